@@ -4,5 +4,6 @@ public enum JobStatus {
     PENDING,
     PROCESSING,
     COMPLETED,
-    FAILED
+    FAILED,
+    QUALITY_FAILED
 }
\ No newline at end of file
@@ -2,5 +2,17 @@ package com.example.backend.constant;
 
 public enum JobType {
     VIDEO_TRANSCODING,
-    RECORDING_MERGE
+    RECORDING_MERGE,
+    FORENSIC_WATERMARK,
+    CAPTION_TRANSLATION,
+    NARRATED_VIDEO,
+    CONTENT_EXPORT,
+    MEDIA_PROCESSING,
+    LIVE_IMPORT,
+    PODCAST_FEED,
+    KEY_ROTATION,
+    CAPTION_BURN_IN,
+    AUDIO_REPLACEMENT,
+    ARTIFACT_REGENERATION,
+    MEDIA_DELETION
 }
\ No newline at end of file
@@ -0,0 +1,4 @@
+-- Correlation ID propagated from the triggering request through the transcode worker
+ALTER TABLE jobs ADD COLUMN correlation_id VARCHAR(64);
+
+CREATE INDEX idx_jobs_correlation_id ON jobs(correlation_id);
@@ -0,0 +1,2 @@
+-- Transcode progress in percent, reported periodically by the worker while ffmpeg runs
+ALTER TABLE jobs ADD COLUMN progress SMALLINT NOT NULL DEFAULT 0;
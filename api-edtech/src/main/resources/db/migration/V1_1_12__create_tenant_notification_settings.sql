@@ -0,0 +1,12 @@
+-- Per-tenant switches for notifications sent by the transcode worker. Tenants
+-- without a row use the worker's defaults.
+CREATE TABLE tenant_notification_settings (
+    tenant_id UUID PRIMARY KEY,
+    video_ready_email BOOLEAN NOT NULL DEFAULT TRUE,
+    reply_to VARCHAR(255),
+    lesson_url_template VARCHAR(500),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+COMMENT ON COLUMN tenant_notification_settings.lesson_url_template IS 'Lesson link with {course_id}, {course_slug}, {lesson_id} and {lesson_slug} placeholders';
@@ -0,0 +1,12 @@
+-- Timeline of what the transcode worker did for a job: status changes, stages,
+-- progress samples, the ffmpeg command and produced renditions
+CREATE TABLE job_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    job_id UUID NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+    event_type VARCHAR(50) NOT NULL,
+    stage VARCHAR(50),
+    data JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_job_events_job_id_created_at ON job_events(job_id, created_at);
@@ -0,0 +1,2 @@
+-- Forced keyframe interval for transcode presets; 0 leaves GOP size to the encoder
+ALTER TABLE presets ADD COLUMN keyframe_seconds INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,16 @@
+-- Bulk re-transcode runs started by the worker's backfill command; jobs point
+-- back at the batch that enqueued them so progress can be counted
+CREATE TABLE backfill_batches (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    filters JSONB NOT NULL DEFAULT '{}',
+    preset VARCHAR(100),
+    status VARCHAR(20) NOT NULL,
+    total INTEGER NOT NULL DEFAULT 0,
+    enqueued INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE jobs ADD COLUMN backfill_batch_id UUID REFERENCES backfill_batches(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_jobs_backfill_batch_id ON jobs(backfill_batch_id);
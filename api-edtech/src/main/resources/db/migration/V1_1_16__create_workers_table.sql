@@ -0,0 +1,21 @@
+-- Transcode workers register here and heartbeat while running; jobs record
+-- which worker claimed them so work on a worker that stopped heartbeating can
+-- be handed to another one
+CREATE TABLE workers (
+    id UUID PRIMARY KEY,
+    hostname VARCHAR(255) NOT NULL,
+    version VARCHAR(100) NOT NULL,
+    mode VARCHAR(20) NOT NULL,
+    capabilities JSONB NOT NULL DEFAULT '{}',
+    concurrency INTEGER NOT NULL DEFAULT 0,
+    in_flight INTEGER NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL,
+    started_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_heartbeat_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_workers_last_heartbeat_at ON workers(last_heartbeat_at);
+
+ALTER TABLE jobs ADD COLUMN worker_id UUID REFERENCES workers(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_jobs_worker_id ON jobs(worker_id);
@@ -0,0 +1,3 @@
+-- SLA class of the course a job belongs to (enterprise, pro, free); the
+-- transcode worker queues and schedules jobs by it. NULL is treated as pro
+ALTER TABLE jobs ADD COLUMN sla_class VARCHAR(20);
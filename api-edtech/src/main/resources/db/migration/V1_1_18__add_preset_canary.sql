@@ -0,0 +1,9 @@
+-- Canary rollout of preset versions: a version with a canary_percent takes
+-- that share of its name's jobs until it is promoted or aborted. Jobs record
+-- the preset version that encoded them so the versions can be compared
+ALTER TABLE presets ADD COLUMN canary_percent INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE jobs ADD COLUMN preset VARCHAR(100);
+ALTER TABLE jobs ADD COLUMN preset_version INTEGER;
+
+CREATE INDEX idx_jobs_preset_version ON jobs(preset, preset_version);
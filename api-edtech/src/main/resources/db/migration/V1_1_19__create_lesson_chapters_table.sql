@@ -0,0 +1,17 @@
+-- Chapters the transcode worker proposes for long lesson recordings, from
+-- their long pauses and scene changes. Every transcode of a lesson replaces them
+CREATE TABLE lesson_chapters (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    lesson_id UUID NOT NULL,
+    job_id UUID NOT NULL,
+    position INTEGER NOT NULL,
+    start_seconds DOUBLE PRECISION NOT NULL,
+    end_seconds DOUBLE PRECISION NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    source VARCHAR(20) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT uk_lesson_chapter_position UNIQUE (lesson_id, position)
+);
+
+COMMENT ON COLUMN lesson_chapters.source IS 'What the chapter starts on: start, pause or scene (a pause at a scene change)';
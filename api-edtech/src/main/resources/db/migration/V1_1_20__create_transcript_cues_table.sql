@@ -0,0 +1,18 @@
+-- Timed transcript text of lesson videos, searched with Postgres full text
+-- search when the transcode worker's search backend is postgres. Every index
+-- of a lesson's transcript replaces its cues
+CREATE TABLE transcript_cues (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    course_id UUID NOT NULL,
+    lesson_id UUID NOT NULL,
+    language VARCHAR(20) NOT NULL,
+    start_seconds DOUBLE PRECISION NOT NULL,
+    end_seconds DOUBLE PRECISION NOT NULL,
+    text TEXT NOT NULL,
+    text_search TSVECTOR GENERATED ALWAYS AS (to_tsvector('simple', text)) STORED,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_transcript_cues_lesson ON transcript_cues(lesson_id);
+CREATE INDEX idx_transcript_cues_course ON transcript_cues(course_id);
+CREATE INDEX idx_transcript_cues_search ON transcript_cues USING GIN(text_search);
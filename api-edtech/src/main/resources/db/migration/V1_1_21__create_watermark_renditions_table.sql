@@ -0,0 +1,25 @@
+-- Short-lived renditions of a lesson watermarked for one student, generated by
+-- the transcode worker at the student's first playback request and deleted
+-- once they expire. code is the identifier burned into the picture, looked up
+-- to trace a leaked copy back to its student
+CREATE TABLE watermark_renditions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    lesson_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    job_id UUID NOT NULL,
+    code VARCHAR(32) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    playlist_key VARCHAR(512),
+    expires_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT uk_watermark_rendition_code UNIQUE (code)
+);
+
+-- One live rendition per student and lesson; after a failed or expired one
+-- the next playback request makes a new row
+CREATE UNIQUE INDEX uk_watermark_rendition_live ON watermark_renditions(lesson_id, user_id) WHERE status IN ('PENDING', 'READY');
+CREATE INDEX idx_watermark_renditions_expires_at ON watermark_renditions(expires_at);
+
+COMMENT ON COLUMN watermark_renditions.status IS 'PENDING while the job runs, READY once playlist_key can be played, FAILED when the job failed, EXPIRED once deleted';
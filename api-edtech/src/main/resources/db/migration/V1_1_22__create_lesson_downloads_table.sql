@@ -0,0 +1,14 @@
+-- Offline renditions of lessons for the mobile app: a single progressive MP4
+-- per lesson, kept apart from the HLS package it streams from. offline_days is
+-- how long the app may keep a downloaded copy before it must be fetched again
+CREATE TABLE lesson_downloads (
+    lesson_id UUID PRIMARY KEY,
+    job_id UUID NOT NULL,
+    object_key VARCHAR(512) NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    height INTEGER NOT NULL,
+    duration_seconds DOUBLE PRECISION NOT NULL,
+    offline_days INTEGER NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,19 @@
+-- Lessons that have captions, one row per language, kept by the transcode
+-- worker whichever search backend indexes the cues
+CREATE TABLE lesson_captions (
+    lesson_id UUID NOT NULL,
+    language VARCHAR(35) NOT NULL,
+    cue_count INTEGER NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (lesson_id, language)
+);
+
+-- Courses whose video lessons are all transcoded and captioned. A row is
+-- made when the course ready event is sent and removed when a lesson goes
+-- back to processing, so the event is sent once per time the course becomes
+-- ready
+CREATE TABLE course_readiness (
+    course_id UUID PRIMARY KEY,
+    ready_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,20 @@
+-- Every package the transcode worker publishes for a lesson, each under its
+-- own prefix lessons/{lesson id}/videos/{job id}/. The ACTIVE version is the
+-- one lessons.video_url points at; a replaced one is RETAINED until
+-- expires_at so the lesson can be rolled back to it, then DELETED
+CREATE TABLE lesson_video_versions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    lesson_id UUID NOT NULL,
+    job_id UUID NOT NULL,
+    playlist_key VARCHAR(512) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    replaced_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT uk_lesson_video_version_job UNIQUE (job_id)
+);
+
+CREATE UNIQUE INDEX uk_lesson_video_version_active ON lesson_video_versions(lesson_id) WHERE status = 'ACTIVE';
+CREATE INDEX idx_lesson_video_versions_lesson_id ON lesson_video_versions(lesson_id);
+CREATE INDEX idx_lesson_video_versions_expires_at ON lesson_video_versions(expires_at) WHERE status = 'RETAINED';
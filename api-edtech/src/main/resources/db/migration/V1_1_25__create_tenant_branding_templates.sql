@@ -0,0 +1,18 @@
+-- Per-tenant branding the transcode worker composites into lesson videos:
+-- a logo in a corner throughout, a title card with the course and lesson
+-- names ahead of the video and a lower third with the lesson name as it
+-- starts. Tenants without a row are published unbranded
+CREATE TABLE tenant_branding_templates (
+    tenant_id UUID PRIMARY KEY,
+    logo_key VARCHAR(512),
+    logo_position VARCHAR(20) NOT NULL DEFAULT 'top-right',
+    primary_color VARCHAR(7) NOT NULL DEFAULT '#1F2937',
+    text_color VARCHAR(7) NOT NULL DEFAULT '#FFFFFF',
+    title_card_seconds INTEGER NOT NULL DEFAULT 0,
+    lower_third_seconds INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+COMMENT ON COLUMN tenant_branding_templates.logo_key IS 'Object key of the logo image in the video bucket';
+COMMENT ON COLUMN tenant_branding_templates.logo_position IS 'top-left, top-right, bottom-left or bottom-right';
@@ -0,0 +1,24 @@
+-- Seconds of the lesson the captions in each language cover, for the caption
+-- coverage of the accessibility report
+ALTER TABLE lesson_captions ADD COLUMN covered_seconds DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+-- What the transcode worker measured of each lesson's video for the
+-- accessibility report. Loudness is null for videos without audio;
+-- legibility_240p is the SSIM of the sampled frame that keeps the least of its
+-- detail scaled down to 240 lines. Caption coverage is read from
+-- lesson_captions, as captions arrive after the video
+CREATE TABLE lesson_accessibility_reports (
+    lesson_id UUID PRIMARY KEY,
+    job_id UUID NOT NULL,
+    duration_seconds DOUBLE PRECISION NOT NULL,
+    audio_description BOOLEAN NOT NULL,
+    integrated_lufs DOUBLE PRECISION,
+    true_peak_dbtp DOUBLE PRECISION,
+    loudness_range_lu DOUBLE PRECISION,
+    loudness_compliant BOOLEAN,
+    legibility_240p DOUBLE PRECISION NOT NULL,
+    least_legible_at DOUBLE PRECISION NOT NULL,
+    legibility_compliant BOOLEAN NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
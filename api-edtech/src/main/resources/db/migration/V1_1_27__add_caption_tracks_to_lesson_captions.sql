@@ -0,0 +1,6 @@
+-- Lesson captions become tracks the player can load: object_key is their
+-- WebVTT file in the bucket. Machine translated tracks are made by the
+-- transcode worker from the captions in source_language
+ALTER TABLE lesson_captions ADD COLUMN object_key VARCHAR(512);
+ALTER TABLE lesson_captions ADD COLUMN machine_translated BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE lesson_captions ADD COLUMN source_language VARCHAR(35);
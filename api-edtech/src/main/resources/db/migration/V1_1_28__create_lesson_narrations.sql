@@ -0,0 +1,19 @@
+-- Lessons narrated from a slide deck and a script rather than recorded. The
+-- transcode worker speaks script[i] over page i+1 of the deck, uploads the
+-- video as the lesson's source at object_path and queues its transcode job
+CREATE TABLE lesson_narrations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    lesson_id UUID NOT NULL,
+    job_id UUID NOT NULL UNIQUE,
+    deck_key VARCHAR(512) NOT NULL,
+    script TEXT[] NOT NULL,
+    titles TEXT[] NOT NULL,
+    voice VARCHAR(100) NOT NULL,
+    language VARCHAR(35) NOT NULL,
+    object_path VARCHAR(512),
+    transcode_job_id UUID,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_lesson_narrations_lesson_id ON lesson_narrations (lesson_id);
@@ -0,0 +1,21 @@
+-- Findings of the transcode worker's quality checks for the content team to
+-- review before a course is published, one row per check of a job. Open and
+-- confirmed flags hold the course back; a new video of the lesson supersedes
+-- the flags of the one it replaces
+CREATE TABLE lesson_qc_flags (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    lesson_id UUID NOT NULL,
+    job_id UUID NOT NULL,
+    qc_check VARCHAR(50) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    matches JSONB NOT NULL,
+    note TEXT,
+    reviewed_by UUID,
+    reviewed_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE (job_id, qc_check)
+);
+
+CREATE INDEX idx_lesson_qc_flags_lesson_id ON lesson_qc_flags (lesson_id);
+CREATE INDEX idx_lesson_qc_flags_open ON lesson_qc_flags (created_at) WHERE status = 'OPEN';
@@ -0,0 +1,13 @@
+-- What the transcode worker measured of each lesson's published video, kept
+-- so the media event the course catalog reads can be sent again when the
+-- lesson's captions change
+CREATE TABLE lesson_media (
+    lesson_id UUID PRIMARY KEY,
+    job_id UUID NOT NULL,
+    duration_seconds DOUBLE PRECISION NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    aspect_ratio VARCHAR(20),
+    audio_languages TEXT[] NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,17 @@
+-- The package the transcode worker last made of each distinct input, so an
+-- identical upload encoded with the same preset version, such as a lesson
+-- of a cloned course, is copied from it instead of encoded again. The key
+-- hashes the source files with everything else that shapes the package
+CREATE TABLE transcode_outputs (
+    output_key VARCHAR(64) PRIMARY KEY,
+    job_id UUID NOT NULL,
+    lesson_id UUID NOT NULL,
+    source_sha256 VARCHAR(64) NOT NULL,
+    preset VARCHAR(100) NOT NULL,
+    preset_version INTEGER NOT NULL,
+    package_path VARCHAR(512) NOT NULL,
+    bytes BIGINT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_transcode_outputs_source_sha256 ON transcode_outputs (source_sha256);
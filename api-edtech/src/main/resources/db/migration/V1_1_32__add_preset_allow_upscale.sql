@@ -0,0 +1,3 @@
+-- Ladders are capped at the source's resolution unless a preset opts out, so
+-- a 720p upload isn't also encoded as an upscaled 1080p rendition.
+ALTER TABLE presets ADD COLUMN allow_upscale BOOLEAN NOT NULL DEFAULT FALSE;
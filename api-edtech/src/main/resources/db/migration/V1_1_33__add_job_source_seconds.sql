@@ -0,0 +1,4 @@
+-- Duration of the source probed when an upload completes. Uploads of at least
+-- the worker's long job threshold are queued on their own lane, and requeues
+-- of the job keep it there.
+ALTER TABLE jobs ADD COLUMN source_seconds DOUBLE PRECISION;
@@ -0,0 +1,29 @@
+-- Third-party delivery for tenants who don't play lesson videos from the
+-- platform's bucket. Once a lesson is transcoded the worker also sends it to
+-- the tenant's target and records where it plays there. Tenants without a
+-- row are delivered from the bucket only
+CREATE TABLE tenant_publish_targets (
+    tenant_id UUID PRIMARY KEY,
+    provider VARCHAR(20) NOT NULL,
+    account_id VARCHAR(255),
+    origin_url VARCHAR(1024),
+    api_token VARCHAR(512) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+COMMENT ON COLUMN tenant_publish_targets.provider IS 'cloudflare, which encodes the source again, or origin, which serves the HLS package as it is';
+COMMENT ON COLUMN tenant_publish_targets.account_id IS 'Cloudflare account the Stream videos belong to';
+COMMENT ON COLUMN tenant_publish_targets.origin_url IS 'Base URL the package files are PUT under';
+
+CREATE TABLE lesson_external_playbacks (
+    lesson_id UUID PRIMARY KEY,
+    job_id UUID NOT NULL,
+    provider VARCHAR(20) NOT NULL,
+    playback_id VARCHAR(255) NOT NULL,
+    playback_url VARCHAR(1024) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+COMMENT ON COLUMN lesson_external_playbacks.playback_id IS 'Cloudflare Stream video uid, or the package path on the origin';
@@ -0,0 +1,35 @@
+-- Zoom cloud recordings taken into lessons. A tenant connects its Zoom
+-- account with a server-to-server OAuth app and links meetings to lessons;
+-- the worker imports each recording of a linked meeting that completes,
+-- from the app's webhook or by polling, and transcodes it
+CREATE TABLE tenant_zoom_connections (
+    tenant_id UUID PRIMARY KEY,
+    account_id VARCHAR(255) NOT NULL,
+    client_id VARCHAR(255) NOT NULL,
+    client_secret VARCHAR(255) NOT NULL,
+    webhook_secret VARCHAR(255) NOT NULL,
+    preset VARCHAR(100) NOT NULL,
+    polled_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+COMMENT ON COLUMN tenant_zoom_connections.webhook_secret IS 'Secret token of the Zoom app, which signs its webhooks';
+COMMENT ON COLUMN tenant_zoom_connections.preset IS 'Preset imported recordings are transcoded with';
+
+CREATE TABLE zoom_meetings (
+    meeting_id BIGINT PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    lesson_id UUID NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_zoom_meetings_tenant_id ON zoom_meetings (tenant_id);
+
+CREATE TABLE zoom_imports (
+    file_id VARCHAR(255) PRIMARY KEY,
+    meeting_uuid VARCHAR(255) NOT NULL,
+    lesson_id UUID NOT NULL,
+    job_id UUID NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
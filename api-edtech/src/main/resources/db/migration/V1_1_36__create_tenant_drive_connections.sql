@@ -0,0 +1,18 @@
+-- Tenants' grants to download from Google Drive or OneDrive, so a transcode
+-- job's source can be a file id there instead of an upload. The worker
+-- copies the file into the bucket before the job is processed
+CREATE TABLE tenant_drive_connections (
+    tenant_id UUID NOT NULL,
+    provider VARCHAR(20) NOT NULL,
+    client_id VARCHAR(255) NOT NULL,
+    client_secret VARCHAR(255) NOT NULL,
+    refresh_token TEXT NOT NULL,
+    directory VARCHAR(255),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tenant_id, provider)
+);
+
+COMMENT ON COLUMN tenant_drive_connections.provider IS 'gdrive or onedrive';
+COMMENT ON COLUMN tenant_drive_connections.refresh_token IS 'OAuth refresh token of the account, replaced when the provider rotates it';
+COMMENT ON COLUMN tenant_drive_connections.directory IS 'Microsoft Entra tenant of a OneDrive account, common when null';
@@ -0,0 +1,18 @@
+-- Lessons packaged by the transcode worker for import into an LMS: a ZIP
+-- with the lesson's video, its captions and a SCORM manifest. The object
+-- key is set once the package is in the bucket
+CREATE TABLE lesson_content_exports (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    lesson_id UUID NOT NULL,
+    job_id UUID NOT NULL UNIQUE,
+    format VARCHAR(20) NOT NULL,
+    object_key VARCHAR(512),
+    size_bytes BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_lesson_content_exports_lesson_id ON lesson_content_exports (lesson_id);
+
+COMMENT ON COLUMN lesson_content_exports.format IS 'scorm12 or scorm2004';
+COMMENT ON COLUMN lesson_content_exports.object_key IS 'Object key of the ZIP in the video bucket, null until it is made';
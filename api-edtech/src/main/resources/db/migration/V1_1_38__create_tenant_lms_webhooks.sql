@@ -0,0 +1,15 @@
+-- Tenants' inbound webhooks from their learning management systems. The
+-- transcode worker turns events its rules match, such as a content item
+-- created with a media URL, into transcode jobs of the lesson they name
+CREATE TABLE tenant_lms_webhooks (
+    tenant_id UUID PRIMARY KEY,
+    secret VARCHAR(255) NOT NULL,
+    allowed_hosts TEXT[] NOT NULL,
+    rules JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+COMMENT ON COLUMN tenant_lms_webhooks.secret IS 'HMAC-SHA256 key the LMS signs its requests with';
+COMMENT ON COLUMN tenant_lms_webhooks.allowed_hosts IS 'Hosts media URLs may point at; a leading dot allows subdomains';
+COMMENT ON COLUMN tenant_lms_webhooks.rules IS 'Events mapped to transcode jobs, each by the JSON paths of its event type, media URL, lesson id and delivery id';
@@ -0,0 +1,9 @@
+-- When the transcode worker last exported each lesson, with its captions,
+-- chapters and video metadata, to the content index platform search reads.
+-- Lessons changed since, or missing here, are exported on its next sweep
+CREATE TABLE lesson_search_exports (
+    lesson_id UUID PRIMARY KEY,
+    exported_at TIMESTAMPTZ NOT NULL
+);
+
+COMMENT ON COLUMN lesson_search_exports.exported_at IS 'When the lesson was read for its last export; changes after it are exported again';
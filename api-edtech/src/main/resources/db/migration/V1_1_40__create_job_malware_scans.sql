@@ -0,0 +1,20 @@
+-- The malware scan each transcode job's upload had before the worker
+-- processed it. An infected upload is moved to quarantine and its job failed,
+-- so the row is what security reviews of a rejected upload start from
+CREATE TABLE job_malware_scans (
+    job_id UUID PRIMARY KEY REFERENCES jobs(id) ON DELETE CASCADE,
+    lesson_id UUID NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    files TEXT[] NOT NULL,
+    signature VARCHAR(255),
+    infected_key TEXT,
+    quarantine_key TEXT,
+    scanned_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_job_malware_scans_status ON job_malware_scans(status);
+
+COMMENT ON COLUMN job_malware_scans.status IS 'CLEAN or INFECTED';
+COMMENT ON COLUMN job_malware_scans.files IS 'Bucket keys of the upload that were scanned, its source and any audio tracks or webcam recording';
+COMMENT ON COLUMN job_malware_scans.signature IS 'What clamd found in the infected file';
+COMMENT ON COLUMN job_malware_scans.quarantine_key IS 'Where the infected file was moved in the bucket';
@@ -0,0 +1,24 @@
+-- Videos of tenants' Vimeo and YouTube libraries imported into lessons when
+-- a tenant moves its hosted videos onto the platform. The worker downloads
+-- each into the bucket and queues its transcode; a video is imported into a
+-- lesson once, unless the import failed
+CREATE TABLE library_imports (
+    provider VARCHAR(20) NOT NULL,
+    video_id VARCHAR(255) NOT NULL,
+    lesson_id UUID NOT NULL,
+    tenant_id UUID NOT NULL,
+    title TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    job_id UUID,
+    error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (provider, video_id, lesson_id)
+);
+
+CREATE INDEX idx_library_imports_tenant ON library_imports(tenant_id, created_at);
+
+COMMENT ON COLUMN library_imports.provider IS 'vimeo or youtube';
+COMMENT ON COLUMN library_imports.video_id IS 'Id of the video at the provider';
+COMMENT ON COLUMN library_imports.status IS 'PENDING while downloading, QUEUED once its transcode job is, or FAILED';
+COMMENT ON COLUMN library_imports.job_id IS 'Transcode job of the imported video';
@@ -0,0 +1,16 @@
+-- Per-tenant overrides of the transcode worker's environment. A tenant's
+-- jobs without a preset of their own use the tenant's, run at most
+-- max_concurrent_jobs at once, and skip or add the stages its features set.
+-- Tenants without a row get the worker's defaults
+CREATE TABLE tenant_configs (
+    tenant_id UUID PRIMARY KEY,
+    preset VARCHAR(100),
+    max_concurrent_jobs INTEGER,
+    features JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+COMMENT ON COLUMN tenant_configs.preset IS 'Preset of the tenant''s jobs that don''t name one; the default ladder when null';
+COMMENT ON COLUMN tenant_configs.max_concurrent_jobs IS 'Jobs of the tenant processed at once across all workers; unlimited when null';
+COMMENT ON COLUMN tenant_configs.features IS 'Processing stages turned on or off by name, such as {"chapters": false, "preview": true}; stages left out follow the worker''s environment';
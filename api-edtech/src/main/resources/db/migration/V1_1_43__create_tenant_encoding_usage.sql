@@ -0,0 +1,22 @@
+-- Encoding minutes quotas. Each completed transcode adds its encoded source
+-- minutes to its tenant's usage for the calendar month, and jobs of a tenant
+-- past its quota are rejected or processed with a warning to billing
+ALTER TABLE tenant_configs ADD COLUMN encoding_minutes_quota INTEGER;
+
+COMMENT ON COLUMN tenant_configs.encoding_minutes_quota IS 'Minutes of video the tenant may have encoded each calendar month; unlimited when null';
+
+CREATE TABLE tenant_encoding_usage (
+    tenant_id UUID NOT NULL,
+    period DATE NOT NULL,
+    seconds DOUBLE PRECISION NOT NULL DEFAULT 0,
+    jobs INTEGER NOT NULL DEFAULT 0,
+    warned_at TIMESTAMPTZ,
+    exceeded_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tenant_id, period)
+);
+
+COMMENT ON COLUMN tenant_encoding_usage.period IS 'First day of the calendar month, in UTC';
+COMMENT ON COLUMN tenant_encoding_usage.seconds IS 'Source seconds encoded, after trimming; packages copied from an identical job are not counted';
+COMMENT ON COLUMN tenant_encoding_usage.warned_at IS 'When billing was told the usage reached QUOTA_WARN_PERCENT of the quota';
+COMMENT ON COLUMN tenant_encoding_usage.exceeded_at IS 'When billing was told the usage reached the quota';
@@ -0,0 +1,19 @@
+-- Messages the transcode worker writes in the same transaction as the change
+-- they announce, such as a job's usage record with its completion, and
+-- relays to RabbitMQ until the broker confirms them. Consumers drop
+-- redeliveries by the AMQP message id, which is the row's id
+CREATE TABLE outbox_messages (
+    id UUID PRIMARY KEY,
+    exchange VARCHAR(255) NOT NULL,
+    routing_key VARCHAR(255) NOT NULL,
+    payload JSONB NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    published_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_outbox_messages_pending ON outbox_messages (created_at) WHERE published_at IS NULL;
+
+COMMENT ON COLUMN outbox_messages.published_at IS 'When the broker confirmed the message; null while it is still to be relayed';
+COMMENT ON COLUMN outbox_messages.last_error IS 'Why the last relay of the message failed';
@@ -0,0 +1,6 @@
+-- Global ffmpeg args a preset's encodes add after the worker's own, so flags
+-- such as a hardware decoder can be tried on one preset version without a
+-- worker release
+ALTER TABLE presets ADD COLUMN ffmpeg_args TEXT[] NOT NULL DEFAULT '{}';
+
+COMMENT ON COLUMN presets.ffmpeg_args IS 'Args that may name {threads}, {loglevel} and {hwaccel_device}, filled in from the worker''s settings';
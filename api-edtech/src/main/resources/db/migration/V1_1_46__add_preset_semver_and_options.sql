@@ -0,0 +1,13 @@
+-- Presets are written as versioned documents: semver is the semantic version
+-- their author gave each save, and options the encoder and packaging settings
+-- that used to be the same for every preset. Versions saved before get their
+-- number as major version
+ALTER TABLE presets ADD COLUMN semver VARCHAR(32);
+UPDATE presets SET semver = version || '.0.0';
+ALTER TABLE presets ALTER COLUMN semver SET NOT NULL;
+
+ALTER TABLE presets ADD COLUMN options JSONB NOT NULL DEFAULT '{}';
+
+ALTER TABLE presets ADD CONSTRAINT uk_presets_name_semver UNIQUE (name, semver);
+
+COMMENT ON COLUMN presets.options IS 'crf, maxrate_factor, bufsize_factor, video_filters and hls_flags; unset ones compile to the worker''s defaults';
@@ -0,0 +1,23 @@
+-- VMAF scores of each rendition the transcode worker encoded, against its
+-- source, so preset versions can be compared by the quality and bitrate
+-- efficiency they give. A retried job replaces its scores
+CREATE TABLE rendition_quality_scores (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    job_id UUID NOT NULL,
+    lesson_id UUID NOT NULL,
+    preset VARCHAR(100) NOT NULL,
+    preset_version INT NOT NULL,
+    width INT NOT NULL,
+    height INT NOT NULL,
+    bitrate VARCHAR(20) NOT NULL,
+    average_kbps DOUBLE PRECISION NOT NULL,
+    vmaf DOUBLE PRECISION NOT NULL,
+    vmaf_min DOUBLE PRECISION NOT NULL,
+    vmaf_harmonic DOUBLE PRECISION NOT NULL,
+    vmaf_per_mbps DOUBLE PRECISION NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE (job_id, height)
+);
+
+CREATE INDEX idx_rendition_quality_scores_preset ON rendition_quality_scores (preset, preset_version);
@@ -0,0 +1,23 @@
+-- The quality gate of each transcode job whose preset sets one. A package
+-- with a rung below the preset's PSNR or SSIM threshold is uploaded but not
+-- published, and its job left QUALITY_FAILED until ops override the gate
+CREATE TABLE job_quality_gates (
+    job_id UUID PRIMARY KEY REFERENCES jobs(id) ON DELETE CASCADE,
+    lesson_id UUID NOT NULL,
+    preset VARCHAR(100) NOT NULL,
+    preset_version INT NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    thresholds JSONB NOT NULL,
+    renditions JSONB NOT NULL,
+    package_path TEXT NOT NULL,
+    overridden_by UUID,
+    note TEXT,
+    overridden_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_job_quality_gates_status ON job_quality_gates(status);
+
+COMMENT ON COLUMN job_quality_gates.status IS 'PASSED, FAILED or OVERRIDDEN';
+COMMENT ON COLUMN job_quality_gates.renditions IS 'Mean PSNR and SSIM of each rung against the source and whether it passed';
+COMMENT ON COLUMN job_quality_gates.package_path IS 'Bucket prefix of the job''s package, published when the gate is overridden';
@@ -0,0 +1,6 @@
+-- What went wrong when a job failed, whichever stage it failed in; it decides whether the worker retries
+ALTER TABLE jobs ADD COLUMN error_kind VARCHAR(50);
+
+CREATE INDEX idx_jobs_error_kind ON jobs(error_kind);
+
+COMMENT ON COLUMN jobs.error_kind IS 'Kind of failure recorded by the worker, e.g. source_corrupt or storage_unavailable';
@@ -0,0 +1,18 @@
+-- Images and documents the transcode worker derives renditions from: resized
+-- course covers and avatars, and thumbnails and preview pages of lesson
+-- attachments. outputs lists the keys written, in order
+CREATE TABLE media_assets (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    job_id UUID NOT NULL UNIQUE,
+    entity_id UUID NOT NULL,
+    entity_type VARCHAR(50) NOT NULL,
+    kind VARCHAR(50) NOT NULL,
+    source_key VARCHAR(512) NOT NULL,
+    widths INTEGER[] NOT NULL DEFAULT '{}',
+    pages INTEGER NOT NULL DEFAULT 0,
+    outputs TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_media_assets_entity_id ON media_assets (entity_id);
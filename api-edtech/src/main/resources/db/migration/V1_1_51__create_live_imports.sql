@@ -0,0 +1,20 @@
+-- Live class recordings pulled from the streaming origin, or an HLS recording
+-- URL, to become a lesson's video. The transcode worker normalizes the
+-- recording, uploads it as the lesson's source at object_path and queues its
+-- transcode job
+CREATE TABLE live_imports (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    lesson_id UUID NOT NULL,
+    job_id UUID NOT NULL UNIQUE,
+    live_session_id UUID,
+    source_url TEXT NOT NULL,
+    preset VARCHAR(100),
+    object_path VARCHAR(512),
+    duration_seconds DOUBLE PRECISION,
+    transcode_job_id UUID,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_live_imports_lesson_id ON live_imports (lesson_id);
+CREATE INDEX idx_live_imports_live_session_id ON live_imports (live_session_id);
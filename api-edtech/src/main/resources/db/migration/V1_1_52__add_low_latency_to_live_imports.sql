@@ -0,0 +1,2 @@
+-- A live import packaged as LL-HLS, for a replay put up as the class ends
+ALTER TABLE live_imports ADD COLUMN low_latency BOOLEAN NOT NULL DEFAULT FALSE;
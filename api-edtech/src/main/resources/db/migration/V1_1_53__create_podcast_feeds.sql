@@ -0,0 +1,30 @@
+-- Courses published as private podcasts by the transcode worker: each build
+-- of a course's feed, and an episode per lecture holding the audio of its
+-- video as an M4A. An episode is remade only when its lesson's video changes
+CREATE TABLE podcast_feeds (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    course_id UUID NOT NULL,
+    job_id UUID NOT NULL UNIQUE,
+    episode_count INTEGER NOT NULL DEFAULT 0,
+    built_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_podcast_feeds_course_id ON podcast_feeds (course_id);
+
+CREATE TABLE podcast_episodes (
+    lesson_id UUID PRIMARY KEY,
+    course_id UUID NOT NULL,
+    source_playlist VARCHAR(512) NOT NULL,
+    object_key VARCHAR(512) NOT NULL,
+    size_bytes BIGINT NOT NULL DEFAULT 0,
+    duration_seconds DOUBLE PRECISION NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_podcast_episodes_course_id ON podcast_episodes (course_id);
+
+COMMENT ON COLUMN podcast_feeds.built_at IS 'When the build finished, null until it has';
+COMMENT ON COLUMN podcast_episodes.source_playlist IS 'Master playlist of the lesson video the episode was made from';
+COMMENT ON COLUMN podcast_episodes.object_key IS 'Object key of the M4A in the video bucket';
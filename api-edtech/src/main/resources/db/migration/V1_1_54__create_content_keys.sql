@@ -0,0 +1,39 @@
+-- AES-128 keys lesson packages are encrypted with by the transcode worker,
+-- each wrapped with one of its master keys, and the jobs rotating them. A
+-- key replaced by a rotation is retired and served until the package it
+-- encrypts expires, so players mid-lesson keep playing
+CREATE TABLE content_keys (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    lesson_id UUID NOT NULL,
+    job_id UUID NOT NULL UNIQUE,
+    wrapped_key BYTEA NOT NULL,
+    master_key_id VARCHAR(100) NOT NULL,
+    iv BYTEA NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    expires_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_content_keys_lesson_id ON content_keys (lesson_id);
+
+CREATE TABLE key_rotations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    lesson_id UUID NOT NULL,
+    job_id UUID NOT NULL UNIQUE,
+    mode VARCHAR(20) NOT NULL,
+    key_id UUID,
+    playlist_key VARCHAR(512),
+    rewrapped INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_key_rotations_lesson_id ON key_rotations (lesson_id);
+
+COMMENT ON COLUMN content_keys.wrapped_key IS 'The key sealed with AES-256-GCM under the master key, nonce first';
+COMMENT ON COLUMN content_keys.master_key_id IS 'Id of the worker master key the key is wrapped with';
+COMMENT ON COLUMN content_keys.status IS 'PENDING, ACTIVE or RETIRED';
+COMMENT ON COLUMN content_keys.expires_at IS 'When a retired key stops being served';
+COMMENT ON COLUMN key_rotations.mode IS 'rotate or rewrap';
+COMMENT ON COLUMN key_rotations.playlist_key IS 'Master playlist of the package a rotation published';
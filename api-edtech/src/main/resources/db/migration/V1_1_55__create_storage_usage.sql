@@ -0,0 +1,30 @@
+-- Storage usage. A package's objects are counted by rendition class when it
+-- is published as a lesson's video version, and taken off again when the
+-- version is deleted, into running totals per course and per tenant
+CREATE TABLE package_storage (
+    job_id UUID NOT NULL,
+    rendition_class VARCHAR(16) NOT NULL,
+    lesson_id UUID NOT NULL,
+    course_id UUID,
+    tenant_id UUID,
+    bytes BIGINT NOT NULL,
+    objects INTEGER NOT NULL,
+    recorded_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    released_at TIMESTAMPTZ,
+    PRIMARY KEY (job_id, rendition_class)
+);
+
+COMMENT ON COLUMN package_storage.rendition_class IS 'sd, hd, fhd or uhd for a rung''s files by height, audio for audio tracks, other for playlists and sidecars';
+COMMENT ON COLUMN package_storage.released_at IS 'When the package was deleted and its bytes taken off the totals';
+
+CREATE TABLE storage_usage (
+    scope VARCHAR(16) NOT NULL,
+    scope_id UUID NOT NULL,
+    rendition_class VARCHAR(16) NOT NULL,
+    bytes BIGINT NOT NULL DEFAULT 0,
+    objects BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (scope, scope_id, rendition_class)
+);
+
+COMMENT ON COLUMN storage_usage.scope IS 'course or tenant; scope_id is the course or tenant id';
@@ -0,0 +1,28 @@
+-- Codec migrations. A migration moves the back catalog onto a new preset
+-- generation: the leader queues re-transcodes of the lessons not yet encoded
+-- with it, most played first, as backfill workers have idle slots, and each
+-- lesson queued is recorded so progress can be counted per course
+CREATE TABLE codec_migrations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    preset VARCHAR(100) NOT NULL,
+    preset_version INTEGER NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    enqueued INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_codec_migrations_preset_open ON codec_migrations(preset) WHERE status IN ('RUNNING', 'PAUSED');
+
+COMMENT ON COLUMN codec_migrations.preset_version IS 'Lessons whose last transcode used this version of the preset or a later one are already migrated';
+
+CREATE TABLE codec_migration_lessons (
+    migration_id UUID NOT NULL REFERENCES codec_migrations(id) ON DELETE CASCADE,
+    lesson_id UUID NOT NULL,
+    course_id UUID NOT NULL,
+    job_id UUID NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (migration_id, lesson_id)
+);
+
+CREATE INDEX idx_codec_migration_lessons_job_id ON codec_migration_lessons(job_id);
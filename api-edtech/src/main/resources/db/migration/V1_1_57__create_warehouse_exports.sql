@@ -0,0 +1,10 @@
+-- Analytics warehouse exports. Each dataset is exported as Parquet a day at a
+-- time; exported_through is where its next export starts, so every row is
+-- written once however often the exporter runs
+CREATE TABLE warehouse_exports (
+    dataset VARCHAR(50) PRIMARY KEY,
+    exported_through TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_jobs_updated_at ON jobs(updated_at);
@@ -0,0 +1,14 @@
+-- Operator annotations on jobs: a free-form note and tags, such as the
+-- escalation a job belongs to or a source known to be bad, kept with the job
+-- so incident context can be found by searching jobs
+CREATE TABLE job_annotations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    job_id UUID NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+    note TEXT NOT NULL DEFAULT '',
+    tags TEXT[] NOT NULL DEFAULT '{}',
+    author_id UUID,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_job_annotations_job_id ON job_annotations(job_id, created_at);
+CREATE INDEX idx_job_annotations_tags ON job_annotations USING GIN (tags);
@@ -0,0 +1,19 @@
+-- Lesson videos with a caption track burned into the picture, made by the
+-- transcode worker for social and preview channels that can't load WebVTT
+-- sidecars. The object key is set once the video is in the bucket
+CREATE TABLE lesson_burned_captions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    lesson_id UUID NOT NULL,
+    job_id UUID NOT NULL UNIQUE,
+    language VARCHAR(35) NOT NULL,
+    object_key VARCHAR(512),
+    size_bytes BIGINT NOT NULL DEFAULT 0,
+    height INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_lesson_burned_captions_lesson_id ON lesson_burned_captions (lesson_id, language);
+
+COMMENT ON COLUMN lesson_burned_captions.language IS 'Language of the caption track burned in';
+COMMENT ON COLUMN lesson_burned_captions.object_key IS 'Object key of the MP4 in the video bucket, null until it is made';
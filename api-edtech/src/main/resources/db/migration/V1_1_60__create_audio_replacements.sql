@@ -0,0 +1,22 @@
+-- Corrected recordings swapped in for the audio of a published lesson video
+-- by the transcode worker. The video renditions are copied as they are into
+-- a new version of the package with the new audio track; the playlist key
+-- is set once that version is published
+CREATE TABLE audio_replacements (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    lesson_id UUID NOT NULL,
+    job_id UUID NOT NULL UNIQUE,
+    object_path VARCHAR(512) NOT NULL,
+    replaced_playlist_key VARCHAR(512),
+    playlist_key VARCHAR(512),
+    remuxed INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_audio_replacements_lesson_id ON audio_replacements (lesson_id);
+
+COMMENT ON COLUMN audio_replacements.object_path IS 'Object key of the uploaded corrected recording';
+COMMENT ON COLUMN audio_replacements.replaced_playlist_key IS 'Master playlist of the package whose audio was replaced';
+COMMENT ON COLUMN audio_replacements.playlist_key IS 'Master playlist of the package the replacement published';
+COMMENT ON COLUMN audio_replacements.remuxed IS 'Progressive renditions remuxed with the new audio';
@@ -0,0 +1,22 @@
+-- Single artifacts of a published lesson video made again by the transcode
+-- worker: its posters, preview, slides, chapters or some of its rungs. What
+-- is part of the package is written into a new version of it with the rest
+-- copied; the playlist key is set once that version is published
+CREATE TABLE artifact_regenerations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    lesson_id UUID NOT NULL,
+    job_id UUID NOT NULL UNIQUE,
+    artifacts TEXT[] NOT NULL,
+    heights INT[] NOT NULL DEFAULT '{}',
+    replaced_playlist_key VARCHAR(512),
+    playlist_key VARCHAR(512),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_artifact_regenerations_lesson_id ON artifact_regenerations (lesson_id);
+
+COMMENT ON COLUMN artifact_regenerations.artifacts IS 'Artifacts made again: posters, preview, slides, chapters or rendition';
+COMMENT ON COLUMN artifact_regenerations.heights IS 'Rungs encoded again, for the rendition artifact';
+COMMENT ON COLUMN artifact_regenerations.replaced_playlist_key IS 'Master playlist of the package the artifacts were made from';
+COMMENT ON COLUMN artifact_regenerations.playlist_key IS 'Master playlist of the package the regeneration published';
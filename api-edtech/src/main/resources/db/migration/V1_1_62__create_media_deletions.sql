@@ -0,0 +1,30 @@
+-- Erasures of a lesson's video or of a user's media run by the transcode
+-- worker. A deletion with a grace window hides the lesson's video straight
+-- away and is queued once purge_after passes, until when it can be
+-- cancelled; the job is set once it is queued. The report is the audit
+-- record of every prefix emptied and every row deleted
+CREATE TABLE media_deletions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    scope VARCHAR(20) NOT NULL CHECK (scope IN ('lesson', 'user')),
+    scope_id UUID NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    job_id UUID UNIQUE,
+    reason TEXT,
+    requested_by UUID,
+    hidden_playlist_key VARCHAR(512),
+    purge_after TIMESTAMPTZ NOT NULL,
+    report JSONB NOT NULL DEFAULT '{}',
+    completed_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_media_deletions_scope ON media_deletions (scope, scope_id);
+CREATE INDEX idx_media_deletions_due ON media_deletions (purge_after) WHERE status = 'SCHEDULED';
+
+COMMENT ON COLUMN media_deletions.scope IS 'What is erased: a lesson''s video or a user''s media';
+COMMENT ON COLUMN media_deletions.status IS 'SCHEDULED, QUEUED, COMPLETED or CANCELLED';
+COMMENT ON COLUMN media_deletions.reason IS 'Why the erasure was requested, such as the ticket of the request';
+COMMENT ON COLUMN media_deletions.hidden_playlist_key IS 'Video the lesson played before the deletion hid it, put back if it is cancelled';
+COMMENT ON COLUMN media_deletions.purge_after IS 'When the grace window ends and the deletion is queued';
+COMMENT ON COLUMN media_deletions.report IS 'Objects deleted by prefix and rows deleted by table';
@@ -0,0 +1,6 @@
+-- Signed CDN access to lesson packages. Enrolled students are granted access
+-- to a lesson's playlists and segments at the CDN edge for the tenant's
+-- playback TTL, or the worker's CDN_SIGNING_TTL
+ALTER TABLE tenant_configs ADD COLUMN playback_ttl INTEGER;
+
+COMMENT ON COLUMN tenant_configs.playback_ttl IS 'Seconds a student''s signed access to a lesson video on the CDN is valid; CDN_SIGNING_TTL when null';
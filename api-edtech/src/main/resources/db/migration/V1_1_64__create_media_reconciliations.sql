@@ -0,0 +1,29 @@
+-- Media reconciliations. Every interval the transcode worker cross-checks
+-- each lesson's video against its active version, the renditions its job
+-- recorded and the objects in the bucket, and records the lessons whose
+-- media is missing or incomplete. Runs are kept for 30 days
+CREATE TABLE media_reconciliations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    lessons INTEGER NOT NULL DEFAULT 0,
+    findings INTEGER NOT NULL DEFAULT 0,
+    started_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMPTZ
+);
+
+CREATE TABLE media_reconciliation_findings (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    reconciliation_id UUID NOT NULL REFERENCES media_reconciliations (id) ON DELETE CASCADE,
+    lesson_id UUID NOT NULL,
+    course_id UUID,
+    problem VARCHAR(50) NOT NULL,
+    detail TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_media_reconciliations_started_at ON media_reconciliations (started_at);
+CREATE INDEX idx_media_reconciliation_findings_run ON media_reconciliation_findings (reconciliation_id);
+
+COMMENT ON COLUMN media_reconciliations.lessons IS 'Lessons with a video the run checked';
+COMMENT ON COLUMN media_reconciliations.finished_at IS 'When the run checked the last lesson; null while it runs or if it was interrupted';
+COMMENT ON COLUMN media_reconciliation_findings.problem IS 'missing_source, missing_package, incomplete_package, missing_rendition, version_mismatch or missing_media';
+COMMENT ON COLUMN media_reconciliation_findings.detail IS 'What was missing, such as the segment or rendition';
@@ -0,0 +1,22 @@
+-- Content fingerprints of lesson uploads. The transcode worker hashes a frame
+-- every few seconds and fingerprints the audio of each upload, and flags
+-- the lesson for review when another lesson's fingerprint shares most of it.
+-- One row per lesson, of its latest upload
+CREATE TABLE lesson_fingerprints (
+    lesson_id UUID PRIMARY KEY,
+    job_id UUID NOT NULL,
+    frames BIGINT[] NOT NULL DEFAULT '{}',
+    audio INTEGER[] NOT NULL DEFAULT '{}',
+    seconds DOUBLE PRECISION NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Lessons sharing any hash with an upload are the candidates it's compared with
+CREATE INDEX idx_lesson_fingerprints_frames ON lesson_fingerprints USING GIN (frames);
+CREATE INDEX idx_lesson_fingerprints_audio ON lesson_fingerprints USING GIN (audio);
+
+ALTER TABLE lesson_qc_flags ADD COLUMN duplicates JSONB NOT NULL DEFAULT '[]';
+
+COMMENT ON COLUMN lesson_fingerprints.frames IS 'Distinct 64-bit difference hashes of the frames sampled';
+COMMENT ON COLUMN lesson_fingerprints.audio IS 'Raw Chromaprint fingerprint of the first audio track, about eight values a second';
+COMMENT ON COLUMN lesson_qc_flags.duplicates IS 'Lessons a DUPLICATE_CONTENT flag''s upload duplicates, with the share of frames and audio they have in common';
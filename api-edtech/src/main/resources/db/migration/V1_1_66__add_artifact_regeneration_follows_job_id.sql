@@ -0,0 +1,7 @@
+-- A transcode can leave its posters, preview, slides, chapters and review
+-- checks to regenerations queued once its video is published, so students
+-- can watch it sooner. Those regenerations point back at the transcode
+ALTER TABLE artifact_regenerations ADD COLUMN follows_job_id UUID;
+
+COMMENT ON COLUMN artifact_regenerations.artifacts IS 'Artifacts made again: posters, preview, slides, chapters, rendition, music or accessibility';
+COMMENT ON COLUMN artifact_regenerations.follows_job_id IS 'The transcode job that left these artifacts to the regeneration; null when it was requested';
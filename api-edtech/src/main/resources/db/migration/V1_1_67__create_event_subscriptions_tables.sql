@@ -0,0 +1,47 @@
+-- Tenants' subscriptions to the transcode worker's events. Each POSTs the
+-- events it names, signed with its secret, to its URL: when a job completes
+-- or fails and when a course is ready to publish. Filters narrow them to
+-- some courses or job types
+CREATE TABLE event_subscriptions (
+    id UUID PRIMARY KEY,
+    tenant_id UUID NOT NULL,
+    url TEXT NOT NULL,
+    events TEXT[] NOT NULL,
+    course_ids UUID[] NOT NULL DEFAULT '{}',
+    job_types TEXT[] NOT NULL DEFAULT '{}',
+    secret VARCHAR(255) NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    cursor_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_event_subscriptions_tenant ON event_subscriptions (tenant_id);
+
+-- Each event sent to a subscription, with its attempts
+CREATE TABLE event_deliveries (
+    id UUID PRIMARY KEY,
+    subscription_id UUID NOT NULL REFERENCES event_subscriptions (id) ON DELETE CASCADE,
+    event_id UUID NOT NULL,
+    event_type VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'PENDING',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    response_code INTEGER,
+    last_error TEXT,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (subscription_id, event_id)
+);
+
+CREATE INDEX idx_event_deliveries_pending ON event_deliveries (next_attempt_at) WHERE status = 'PENDING';
+CREATE INDEX idx_event_deliveries_subscription ON event_deliveries (subscription_id, created_at DESC);
+
+COMMENT ON COLUMN event_subscriptions.events IS 'Events delivered: job.completed, job.failed, course.ready';
+COMMENT ON COLUMN event_subscriptions.course_ids IS 'Courses whose events are delivered; empty for every course of the tenant';
+COMMENT ON COLUMN event_subscriptions.job_types IS 'Job types whose events are delivered; empty for every type';
+COMMENT ON COLUMN event_subscriptions.secret IS 'HMAC-SHA256 key deliveries are signed with';
+COMMENT ON COLUMN event_subscriptions.cursor_at IS 'When the events last turned into deliveries happened; later ones are still to be delivered';
+COMMENT ON COLUMN event_deliveries.event_id IS 'Id of the event, the same in every delivery of it, for the subscriber to drop repeats by';
+COMMENT ON COLUMN event_deliveries.response_code IS 'HTTP status the subscriber last answered with';
@@ -0,0 +1,32 @@
+-- Encoding experiments: while one runs, the transcode worker encodes a share
+-- of the jobs that would use its control preset with its variant preset
+-- instead, and tags every job of either arm, so their quality, size and
+-- encode time can be compared
+CREATE TABLE preset_experiments (
+    id UUID PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    control_preset VARCHAR(100) NOT NULL,
+    variant_preset VARCHAR(100) NOT NULL,
+    percent INTEGER NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'RUNNING',
+    started_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    stopped_at TIMESTAMPTZ
+);
+
+-- One experiment at a time on a preset's jobs
+CREATE UNIQUE INDEX idx_preset_experiments_running ON preset_experiments (control_preset) WHERE status = 'RUNNING';
+
+CREATE TABLE preset_experiment_jobs (
+    job_id UUID PRIMARY KEY,
+    experiment_id UUID NOT NULL REFERENCES preset_experiments (id) ON DELETE CASCADE,
+    arm VARCHAR(20) NOT NULL,
+    preset VARCHAR(100) NOT NULL,
+    preset_version INTEGER NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_preset_experiment_jobs_experiment ON preset_experiment_jobs (experiment_id, arm);
+
+COMMENT ON COLUMN preset_experiments.percent IS 'Share of the control preset''s jobs encoded with the variant, 1 to 99';
+COMMENT ON COLUMN preset_experiment_jobs.arm IS 'control or variant';
+COMMENT ON COLUMN preset_experiment_jobs.preset IS 'Preset the job was encoded with for its arm, with its version';
@@ -0,0 +1,14 @@
+-- Speaker diarization: who speaks when in a lesson's video, so the captions
+-- of panel discussions and Q&A sessions name their speakers
+CREATE TABLE lesson_speakers (
+    lesson_id UUID PRIMARY KEY,
+    job_id UUID NOT NULL,
+    speakers INTEGER NOT NULL,
+    turns JSONB NOT NULL DEFAULT '[]',
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE transcript_cues ADD COLUMN speaker VARCHAR(255);
+
+COMMENT ON COLUMN lesson_speakers.turns IS 'Stretches of the video each speaker talks in: start and end seconds and the speaker''s label, a name from the job or Speaker 1, 2 and so on';
+COMMENT ON COLUMN transcript_cues.speaker IS 'Who speaks the cue; null when the transcript doesn''t say or the video wasn''t diarized';
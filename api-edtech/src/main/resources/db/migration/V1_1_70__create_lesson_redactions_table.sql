@@ -0,0 +1,15 @@
+-- Redaction: profanity and spoken personal details flagged in lesson
+-- transcripts, and the audio replacements that bleeped them
+CREATE TABLE lesson_redactions (
+    lesson_id UUID NOT NULL,
+    language VARCHAR(35) NOT NULL,
+    findings JSONB NOT NULL DEFAULT '[]',
+    audio_replacement_id UUID,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (lesson_id, language)
+);
+
+ALTER TABLE audio_replacements ADD COLUMN bleeps JSONB NOT NULL DEFAULT '[]';
+
+COMMENT ON COLUMN lesson_redactions.findings IS 'Words flagged in the transcript: start and end seconds, kind (PROFANITY, EMAIL or PHONE), the word masked but for its first letter and whether it was bleeped';
+COMMENT ON COLUMN audio_replacements.bleeps IS 'Stretches of the lesson''s own audio a tone is played over, in place of a corrected recording; object_path is empty then';
@@ -0,0 +1,7 @@
+-- Regional worker pools: jobs whose media must stay in a region, for data
+-- residency, are only processed by the workers running there
+ALTER TABLE jobs ADD COLUMN region VARCHAR(50);
+ALTER TABLE tenant_configs ADD COLUMN region VARCHAR(50);
+
+COMMENT ON COLUMN jobs.region IS 'Region the job''s media must be processed in; null leaves it to the tenant''s config';
+COMMENT ON COLUMN tenant_configs.region IS 'Region the tenant''s storage is in, whose workers alone process its jobs; null for any worker';
@@ -0,0 +1,17 @@
+-- Job snapshots: the inputs a transcode job ran with, for replaying it
+CREATE TABLE job_snapshots (
+    job_id UUID PRIMARY KEY REFERENCES jobs(id) ON DELETE CASCADE,
+    message JSONB NOT NULL,
+    preset VARCHAR(100) NOT NULL,
+    preset_version INTEGER NOT NULL,
+    objects JSONB NOT NULL DEFAULT '[]',
+    ffmpeg TEXT NOT NULL,
+    ffmpeg_args TEXT[] NOT NULL DEFAULT '{}',
+    threads INTEGER NOT NULL DEFAULT 0,
+    worker VARCHAR(64) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+COMMENT ON COLUMN job_snapshots.message IS 'The job''s message as it was delivered';
+COMMENT ON COLUMN job_snapshots.objects IS 'Objects the job read, its source first: key, etag, version_id in a versioned bucket, and size';
+COMMENT ON COLUMN job_snapshots.ffmpeg IS 'Version of the ffmpeg build the job ran with';
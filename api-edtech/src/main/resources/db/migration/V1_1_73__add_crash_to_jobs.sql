@@ -0,0 +1,5 @@
+-- Encoder crash post-mortems: what was collected when a job's ffmpeg was
+-- killed by a signal
+ALTER TABLE jobs ADD COLUMN crash JSONB;
+
+COMMENT ON COLUMN jobs.crash IS 'Post-mortem of the job''s last ffmpeg run killed by a signal: exit code, signal, whether the OOM killer killed it and the kernel log and cgroup evidence, peak memory and the cgroup limit, last progress and what it was reading';
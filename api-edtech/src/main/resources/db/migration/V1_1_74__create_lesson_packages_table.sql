@@ -0,0 +1,26 @@
+-- Content-addressed packages: a transcode's package goes under
+-- lessons/{lesson id}/videos/{content hash}-v{preset version}/{job id}/, and
+-- lesson_packages is the manifest naming each lesson's current one
+ALTER TABLE lesson_video_versions ADD COLUMN content_hash VARCHAR(64);
+ALTER TABLE lesson_video_versions ADD COLUMN preset_version INTEGER;
+
+COMMENT ON COLUMN lesson_video_versions.content_hash IS 'Hash of everything the package was made from, as its keys are addressed by; null for packages published before content addressing or derived from another, such as by an audio replacement';
+
+CREATE TABLE lesson_packages (
+    lesson_id UUID PRIMARY KEY,
+    version_id UUID NOT NULL REFERENCES lesson_video_versions(id),
+    job_id UUID NOT NULL,
+    playlist_key VARCHAR(512) NOT NULL,
+    content_hash VARCHAR(64),
+    preset_version INTEGER,
+    current_since TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+COMMENT ON COLUMN lesson_packages.current_since IS 'Creation time of the job that published the package, or the time it was rolled back to; a job created before it does not replace the package when it finishes';
+
+INSERT INTO lesson_packages (lesson_id, version_id, job_id, playlist_key, current_since)
+SELECT v.lesson_id, v.id, v.job_id, v.playlist_key, COALESCE(j.created_at, v.created_at)
+FROM lesson_video_versions v
+LEFT JOIN jobs j ON j.id = v.job_id
+WHERE v.status = 'ACTIVE';
@@ -0,0 +1,7 @@
+-- Job heartbeats: a processing job records it's alive, and what it's doing,
+-- even while ffmpeg reports no progress
+ALTER TABLE jobs ADD COLUMN stage VARCHAR(64);
+ALTER TABLE jobs ADD COLUMN heartbeat_at TIMESTAMPTZ;
+
+COMMENT ON COLUMN jobs.stage IS 'Stage the processing job was in at its last heartbeat, such as download, transcode or upload';
+COMMENT ON COLUMN jobs.heartbeat_at IS 'Time of the processing job''s last heartbeat';
@@ -0,0 +1,5 @@
+-- Job requirements: what a worker must have to run a job, checked before it
+-- claims the job
+ALTER TABLE jobs ADD COLUMN requirements JSONB;
+
+COMMENT ON COLUMN jobs.requirements IS 'What a worker must have to run the job: gpu, scratch_gb of free scratch space, and encoders of its ffmpeg build, such as libsvtav1; a worker without them leaves the job to the rest of the fleet';
@@ -0,0 +1,10 @@
+-- Columns written by the transcode worker so jobs can be searched by tenant and failure class
+ALTER TABLE jobs ADD COLUMN tenant_id UUID;
+ALTER TABLE jobs ADD COLUMN error_class VARCHAR(50);
+ALTER TABLE jobs ADD COLUMN error_message TEXT;
+
+CREATE INDEX idx_jobs_status_created_at ON jobs(status, created_at);
+CREATE INDEX idx_jobs_tenant_id ON jobs(tenant_id);
+CREATE INDEX idx_jobs_error_class ON jobs(error_class);
+
+COMMENT ON COLUMN jobs.error_class IS 'Pipeline stage or error category recorded by the worker when the job failed';
@@ -0,0 +1,20 @@
+-- Encoding presets used by the transcode worker. Every update inserts a new version row.
+CREATE TABLE presets (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(100) NOT NULL,
+    version INTEGER NOT NULL,
+    video_codec VARCHAR(50) NOT NULL,
+    audio_codec VARCHAR(50) NOT NULL,
+    encoder_preset VARCHAR(50) NOT NULL,
+    segment_seconds INTEGER NOT NULL DEFAULT 6,
+    renditions JSONB NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT uk_preset_name_version UNIQUE (name, version)
+);
+
+CREATE INDEX idx_presets_name_active ON presets(name, active);
+
+COMMENT ON COLUMN presets.renditions IS 'Ordered ladder of renditions: [{"width","height","bitrate","audio_rate"}]';
@@ -0,0 +1,2 @@
+-- Priority bumps recorded by the transcode worker; higher runs first
+ALTER TABLE jobs ADD COLUMN priority INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/dto"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/repository"
+	"worker-transcode/service"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+)
+
+func backfill(cfg *config.Config) *cobra.Command {
+	backfillCmd := &cobra.Command{
+		Use:   "backfill",
+		Short: "re-transcode existing lesson videos in tracked batches",
+	}
+	backfillCmd.AddCommand(backfillRun(cfg))
+	backfillCmd.AddCommand(backfillStatus(cfg))
+	return backfillCmd
+}
+
+func backfillRun(cfg *config.Config) *cobra.Command {
+	var (
+		request          dto.BackfillRequest
+		courseId         string
+		transcodedBefore string
+		list             bool
+	)
+
+	runCmd := &cobra.Command{
+		Use:   "run",
+		Short: "queue re-transcode jobs for the videos matching the filters",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if courseId != "" {
+				id, err := uuid.Parse(courseId)
+				if err != nil {
+					return err
+				}
+				request.Filters.CourseId = &id
+			}
+			if transcodedBefore != "" {
+				before, err := time.Parse(time.DateOnly, transcodedBefore)
+				if err != nil {
+					return err
+				}
+				request.Filters.TranscodedBefore = &before
+			}
+
+			ctx, err := cliContext(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			backfillService, err := newBackfillService(ctx, cfg, !list)
+			if err != nil {
+				return err
+			}
+
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if list {
+				candidates, err := backfillService.Candidates(ctx, request)
+				if err != nil {
+					return err
+				}
+				return encoder.Encode(candidates)
+			}
+
+			// A batch that stopped part way is still printed so its id can
+			// be used with backfill status.
+			batch, err := backfillService.Run(ctx, request)
+			if batch != nil {
+				if encodeErr := encoder.Encode(batch); encodeErr != nil {
+					return encodeErr
+				}
+			}
+			return err
+		},
+	}
+
+	runCmd.Flags().StringVar(&courseId, "course-id", "", "only videos in this course")
+	runCmd.Flags().StringVar(&transcodedBefore, "transcoded-before", "", "only videos last transcoded before this day, as YYYY-MM-DD")
+	runCmd.Flags().IntVar(&request.Filters.MissingHeight, "missing-height", 0, "only videos without a rendition of this height")
+	runCmd.Flags().StringVar(&request.Filters.NotCodec, "not-codec", "", "only videos not known to be encoded with this video codec")
+	runCmd.Flags().StringVar(&request.Preset, "preset", "", "preset to re-transcode with (defaults to the default ladder)")
+	runCmd.Flags().IntVar(&request.Limit, "limit", 0, "maximum number of videos in the batch")
+	runCmd.Flags().IntVar(&request.RatePerMinute, "rate", 10, "jobs queued per minute")
+	runCmd.Flags().BoolVar(&list, "list", false, "print the matching videos without queueing anything")
+	return runCmd
+}
+
+func backfillStatus(cfg *config.Config) *cobra.Command {
+	return &cobra.Command{
+		Use:   "status <batch-id>",
+		Short: "show how far a backfill batch has got",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := uuid.Parse(args[0])
+			if err != nil {
+				return err
+			}
+
+			ctx, err := cliContext(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+			backfillService, err := newBackfillService(ctx, cfg, false)
+			if err != nil {
+				return err
+			}
+			progress, err := backfillService.Progress(ctx, id)
+			if err != nil {
+				return err
+			}
+
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(progress)
+		},
+	}
+}
+
+// newBackfillService builds the service, connecting to RabbitMQ only for
+// commands that publish.
+func newBackfillService(ctx context.Context, cfg *config.Config, publish bool) (service.BackfillService, error) {
+	var publisher rabbitmq.Publisher
+	if publish {
+		conn, err := config.NewRabbitMQConn(ctx, cfg.Queue)
+		if err != nil {
+			return nil, err
+		}
+		publisher = rabbitmq.NewPublisher(conn)
+	}
+
+	repo, store, err := openClients(cfg)
+	if err != nil {
+		return nil, err
+	}
+	presetService := service.NewPresetService(repository.NewPresetRepo(repo.GetDB()), cfg)
+	return service.NewBackfillService(repository.NewBackfillRepo(repo.GetDB()), repo, presetService, publisher, store, cfg), nil
+}
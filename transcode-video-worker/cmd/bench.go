@@ -0,0 +1,260 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/entities"
+	"worker-transcode/repository"
+	"worker-transcode/service"
+
+	"github.com/spf13/cobra"
+)
+
+func bench(cfg *config.Config) *cobra.Command {
+	var (
+		presetNames []string
+		input       string
+		duration    time.Duration
+		asJSON      bool
+	)
+
+	benchCmd := &cobra.Command{
+		Use:   "bench",
+		Short: "measure encode speed per rendition of the active presets on this host",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, err := cliContext(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+
+			repo, err := openRepo(cfg)
+			if err != nil {
+				return err
+			}
+			presetService := service.NewPresetService(repository.NewPresetRepo(repo.GetDB()), cfg)
+			var presets []*entities.Preset
+			if len(presetNames) == 0 {
+				if presets, err = activePresets(ctx, presetService); err != nil {
+					return err
+				}
+			}
+			for _, name := range presetNames {
+				preset, err := presetService.Resolve(ctx, name)
+				if err != nil {
+					return err
+				}
+				presets = append(presets, preset)
+			}
+
+			if input == "" {
+				dir, err := os.MkdirTemp("", "worker-bench-")
+				if err != nil {
+					return err
+				}
+				defer os.RemoveAll(dir)
+				if input, err = service.ReferenceClip(ctx, dir, duration); err != nil {
+					return fmt.Errorf("render reference clip: %w", err)
+				}
+			}
+
+			var results []service.BenchResult
+			for _, preset := range presets {
+				presetResults, err := service.Bench(ctx, preset, input)
+				results = append(results, presetResults...)
+				if err != nil {
+					return err
+				}
+			}
+
+			if asJSON {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(results)
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "PRESET\tCODEC\tRENDITION\tBITRATE\tFPS\tSPEED")
+			for _, r := range results {
+				fmt.Fprintf(w, "%s (v%d)\t%s\t%s\t%s\t%.1f\t%.2fx\n", r.Preset, r.PresetVersion, r.VideoCodec, r.Rendition, r.Bitrate, r.FPS, r.Speed)
+			}
+			return w.Flush()
+		},
+	}
+
+	benchCmd.Flags().StringSliceVar(&presetNames, "preset", nil, "presets to benchmark (defaults to every active preset)")
+	benchCmd.Flags().StringVar(&input, "input", "", "clip to encode instead of the generated 1080p reference")
+	benchCmd.Flags().DurationVar(&duration, "duration", 20*time.Second, "length of the generated reference clip")
+	benchCmd.Flags().BoolVar(&asJSON, "json", false, "print results as JSON")
+	benchCmd.AddCommand(benchSuite(cfg))
+	return benchCmd
+}
+
+// benchSuite encodes the fixed fixtures with whole ladders and, given a
+// baseline from an earlier run, fails when speed or memory regressed.
+func benchSuite(cfg *config.Config) *cobra.Command {
+	var (
+		presetNames  []string
+		fixtureNames []string
+		duration     time.Duration
+		fixturesDir  string
+		baselinePath string
+		savePath     string
+		tolerance    float64
+		threads      int
+		asJSON       bool
+	)
+
+	suiteCmd := &cobra.Command{
+		Use:   "suite",
+		Short: "encode the fixed benchmark fixtures through the pipeline and check for regressions against a baseline",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, err := cliContext(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+
+			// The built-in ladder needs no database, so the suite runs the
+			// same anywhere unless stored presets are asked for.
+			presets := []*entities.Preset{service.DefaultPreset()}
+			if len(presetNames) > 0 {
+				repo, err := openRepo(cfg)
+				if err != nil {
+					return err
+				}
+				presetService := service.NewPresetService(repository.NewPresetRepo(repo.GetDB()), cfg)
+				presets = nil
+				for _, name := range presetNames {
+					preset, err := presetService.Resolve(ctx, name)
+					if err != nil {
+						return err
+					}
+					presets = append(presets, preset)
+				}
+			}
+
+			fixtures := service.BenchFixtures
+			if len(fixtureNames) > 0 {
+				fixtures = nil
+				for _, name := range fixtureNames {
+					found := false
+					for _, fixture := range service.BenchFixtures {
+						if fixture.Name == name {
+							fixtures, found = append(fixtures, fixture), true
+						}
+					}
+					if !found {
+						return fmt.Errorf("unknown fixture %q", name)
+					}
+				}
+			}
+
+			if fixturesDir == "" {
+				if fixturesDir, err = os.MkdirTemp("", "worker-fixtures-"); err != nil {
+					return err
+				}
+				defer os.RemoveAll(fixturesDir)
+			} else if err := os.MkdirAll(fixturesDir, os.ModePerm); err != nil {
+				return err
+			}
+
+			var results []service.SuiteResult
+			for _, fixture := range fixtures {
+				input, err := service.RenderFixture(ctx, fixturesDir, fixture, duration)
+				if err != nil {
+					return fmt.Errorf("render fixture %s: %w", fixture.Name, err)
+				}
+				for _, preset := range presets {
+					result, err := service.RunSuite(ctx, preset, fixture.Name, input, threads)
+					if err != nil {
+						return err
+					}
+					results = append(results, *result)
+				}
+			}
+
+			if savePath != "" {
+				raw, err := json.MarshalIndent(results, "", "  ")
+				if err != nil {
+					return err
+				}
+				if err := os.WriteFile(savePath, raw, 0o644); err != nil {
+					return err
+				}
+			}
+			var regressions []service.SuiteRegression
+			if baselinePath != "" {
+				baseline, err := service.LoadSuiteResults(baselinePath)
+				if err != nil {
+					return err
+				}
+				regressions = service.CompareSuite(baseline, results, tolerance)
+			}
+
+			if asJSON {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(map[string]interface{}{"results": results, "regressions": regressions}); err != nil {
+					return err
+				}
+			} else {
+				w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "PRESET\tFIXTURE\tSECONDS\tSPEED\tPEAK MEMORY\tOUTPUT")
+				for _, r := range results {
+					fmt.Fprintf(w, "%s (v%d)\t%s\t%.1f\t%.2fx\t%d MB\t%d MB\n", r.Preset, r.PresetVersion, r.Fixture, r.Seconds, r.Speed, r.PeakMemory>>20, r.OutputBytes>>20)
+				}
+				if err := w.Flush(); err != nil {
+					return err
+				}
+				for _, r := range regressions {
+					fmt.Fprintf(os.Stderr, "regression: %s %s %s %.4g -> %.4g (%+.1f%%)\n", r.Preset, r.Fixture, r.Metric, r.Baseline, r.Current, r.Change*100)
+				}
+			}
+			if len(regressions) > 0 {
+				return fmt.Errorf("%d benchmark regressions beyond %.0f%%", len(regressions), tolerance*100)
+			}
+			return nil
+		},
+	}
+
+	suiteCmd.Flags().StringSliceVar(&presetNames, "preset", nil, "stored presets to run (defaults to the built-in ladder)")
+	suiteCmd.Flags().StringSliceVar(&fixtureNames, "fixture", nil, "fixtures to run: motion, slides, screen (defaults to all)")
+	suiteCmd.Flags().DurationVar(&duration, "duration", 20*time.Second, "length of each fixture")
+	suiteCmd.Flags().StringVar(&fixturesDir, "fixtures-dir", "", "directory rendered fixtures are kept in between runs (defaults to a temp dir)")
+	suiteCmd.Flags().StringVar(&baselinePath, "baseline", "", "results saved from an earlier run to compare against")
+	suiteCmd.Flags().StringVar(&savePath, "save", "", "write the results as JSON, for use as a later baseline")
+	suiteCmd.Flags().Float64Var(&tolerance, "tolerance", 0.1, "fraction speed may drop or memory grow before it counts as a regression")
+	suiteCmd.Flags().IntVar(&threads, "threads", cfg.Server.FFmpegThreads, "ffmpeg threads shared by the renditions, 0 to leave it to ffmpeg")
+	suiteCmd.Flags().BoolVar(&asJSON, "json", false, "print results and regressions as JSON")
+	return suiteCmd
+}
+
+// activePresets returns the active stored presets, plus the built-in default
+// ladder when no preset overrides it.
+func activePresets(ctx context.Context, presetService service.PresetService) ([]*entities.Preset, error) {
+	stored, err := presetService.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var presets []*entities.Preset
+	hasDefault := false
+	for _, preset := range stored {
+		if !preset.Active {
+			continue
+		}
+		hasDefault = hasDefault || preset.Name == service.DefaultPresetName
+		presets = append(presets, preset)
+	}
+	if !hasDefault {
+		preset, err := presetService.Resolve(ctx, service.DefaultPresetName)
+		if err != nil {
+			return nil, err
+		}
+		presets = append([]*entities.Preset{preset}, presets...)
+	}
+	return presets, nil
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/repository"
+	"worker-transcode/service"
+
+	"github.com/spf13/cobra"
+)
+
+func cleanup(cfg *config.Config) *cobra.Command {
+	var (
+		remove     bool
+		minAge     time.Duration
+		reportPath string
+	)
+
+	cleanupCmd := &cobra.Command{
+		Use:   "cleanup",
+		Short: "find bucket objects left by failed jobs and deleted lessons; lists only unless --delete is set",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, err := cliContext(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+
+			repo, store, err := openClients(cfg)
+			if err != nil {
+				return err
+			}
+			cleanupService := service.NewCleanupService(repository.NewCleanupRepo(repo.GetDB()), service.NewStorageService(repository.NewStorageRepo(repo.GetDB()), store, cfg), store, cfg)
+			report, err := cleanupService.Orphans(ctx, minAge)
+			if err != nil {
+				return err
+			}
+
+			var deleteErr error
+			if remove {
+				deleteErr = cleanupService.Delete(ctx, report)
+			}
+
+			var out io.Writer = os.Stdout
+			if reportPath != "" {
+				file, err := os.Create(reportPath)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				out = file
+			}
+			encoder := json.NewEncoder(out)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(report); err != nil {
+				return err
+			}
+			return deleteErr
+		},
+	}
+
+	cleanupCmd.Flags().BoolVar(&remove, "delete", false, "delete the orphaned objects instead of only listing them")
+	cleanupCmd.Flags().DurationVar(&minAge, "min-age", 24*time.Hour, "ignore objects modified more recently than this")
+	cleanupCmd.Flags().StringVar(&reportPath, "report", "", "write the report to this file instead of stdout")
+	return cleanupCmd
+}
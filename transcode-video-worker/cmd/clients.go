@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"worker-transcode/config"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/repository"
+)
+
+// openRepo opens the database one-off commands work with.
+func openRepo(cfg *config.Config) (repository.JobRepository, error) {
+	db, err := config.NewDB(cfg.Database)
+	if err != nil {
+		return nil, err
+	}
+	return repository.NewRepo(db), nil
+}
+
+// openClients opens the database and the bucket for one-off commands whose
+// services read or write objects.
+func openClients(cfg *config.Config) (repository.JobRepository, objectstore.Store, error) {
+	repo, err := openRepo(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	store, err := config.NewStorage(cfg.ObjectStore)
+	if err != nil {
+		return nil, nil, err
+	}
+	return repo, store, nil
+}
@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/pkg/ffmpeg"
+	"worker-transcode/pkg/rabbitmq"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/spf13/cobra"
+)
+
+type checkStatus string
+
+const (
+	checkOK   checkStatus = "OK"
+	checkWarn checkStatus = "WARN"
+	checkFail checkStatus = "FAIL"
+)
+
+type checkResult struct {
+	name   string
+	status checkStatus
+	detail string
+}
+
+func doctor(cfg *config.Config) *cobra.Command {
+	var timeout time.Duration
+
+	doctorCmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "check ffmpeg, codecs, database, storage and broker access",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+			defer cancel()
+
+			var results []checkResult
+			results = append(results, checkBinary("ffmpeg", ffmpeg.Command(ctx, "-hide_banner", "-version")), checkBinary("ffprobe", ffmpeg.Probe(ctx, "-hide_banner", "-version")))
+			results = append(results, checkEncoders(ctx)...)
+			if cfg.Quality.Enabled {
+				results = append(results, checkFilter(ctx, "libvmaf"))
+			}
+			results = append(results, checkDatabase(ctx, cfg), checkStorage(ctx, cfg))
+			results = append(results, checkExchanges(cfg)...)
+
+			failed := 0
+			for _, result := range results {
+				cmd.Printf("[%-4s] %-22s %s\n", result.status, result.name, result.detail)
+				if result.status == checkFail {
+					failed++
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d checks failed", failed, len(results))
+			}
+			return nil
+		},
+	}
+
+	doctorCmd.Flags().DurationVar(&timeout, "timeout", 30*time.Second, "overall time limit for all checks")
+	return doctorCmd
+}
+
+func checkBinary(name string, cmd *exec.Cmd) checkResult {
+	output, err := cmd.Output()
+	if err != nil {
+		return checkResult{name: name, status: checkFail, detail: err.Error()}
+	}
+	version, _, _ := strings.Cut(string(output), "\n")
+	return checkResult{name: name, status: checkOK, detail: version}
+}
+
+// checkEncoders confirms the encoders presets rely on are compiled in.
+// NVENC is optional, so it only warns.
+func checkEncoders(ctx context.Context) []checkResult {
+	output, err := ffmpeg.Command(ctx, "-hide_banner", "-encoders").Output()
+	if err != nil {
+		return []checkResult{{name: "encoders", status: checkFail, detail: err.Error()}}
+	}
+
+	encoders := map[string]bool{}
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 {
+			encoders[fields[1]] = true
+		}
+	}
+
+	required := map[string]checkStatus{"libx264": checkFail, "aac": checkFail, "h264_nvenc": checkWarn}
+	var results []checkResult
+	for _, name := range []string{"libx264", "aac", "h264_nvenc"} {
+		if encoders[name] {
+			results = append(results, checkResult{name: "encoder " + name, status: checkOK, detail: "available"})
+			continue
+		}
+		results = append(results, checkResult{name: "encoder " + name, status: required[name], detail: "not available in this ffmpeg build"})
+	}
+	return results
+}
+
+// checkFilter confirms a filter a setting relies on is compiled in.
+func checkFilter(ctx context.Context, name string) checkResult {
+	output, err := ffmpeg.Command(ctx, "-hide_banner", "-filters").Output()
+	if err != nil {
+		return checkResult{name: "filter " + name, status: checkFail, detail: err.Error()}
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 && fields[1] == name {
+			return checkResult{name: "filter " + name, status: checkOK, detail: "available"}
+		}
+	}
+	return checkResult{name: "filter " + name, status: checkFail, detail: "not available in this ffmpeg build"}
+}
+
+func checkDatabase(ctx context.Context, cfg *config.Config) checkResult {
+	db, err := config.NewDB(cfg.Database)
+	if err != nil {
+		return checkResult{name: "database", status: checkFail, detail: err.Error()}
+	}
+	defer db.Close()
+	if err := db.PingContext(ctx); err != nil {
+		return checkResult{name: "database", status: checkFail, detail: err.Error()}
+	}
+	return checkResult{name: "database", status: checkOK, detail: "ping succeeded"}
+}
+
+// checkStorage writes, reads back and deletes a probe object, which covers
+// every permission the pipeline needs on the bucket.
+func checkStorage(ctx context.Context, cfg *config.Config) checkResult {
+	fail := func(step string, err error) checkResult {
+		return checkResult{name: "storage", status: checkFail, detail: fmt.Sprintf("%s: %v", step, err)}
+	}
+
+	store, err := config.NewStorage(cfg.ObjectStore)
+	if err != nil {
+		return fail("connect", err)
+	}
+	key := fmt.Sprintf(".doctor/%s", uuid.NewString())
+	payload := []byte("worker doctor probe")
+
+	_, err = store.PutObject(ctx, cfg.MinIOBucket, key, bytes.NewReader(payload), int64(len(payload)), minio.PutObjectOptions{ContentType: "text/plain"})
+	if err != nil {
+		return fail("write", err)
+	}
+
+	object, err := store.GetObject(ctx, cfg.MinIOBucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return fail("read", err)
+	}
+	read, err := io.ReadAll(object)
+	object.Close()
+	if err != nil {
+		return fail("read", err)
+	}
+	if !bytes.Equal(read, payload) {
+		return fail("read", fmt.Errorf("probe object content mismatch"))
+	}
+
+	if err := store.RemoveObject(ctx, cfg.MinIOBucket, key, minio.RemoveObjectOptions{}); err != nil {
+		return fail("delete", err)
+	}
+	return checkResult{name: "storage", status: checkOK, detail: fmt.Sprintf("write, read and delete in bucket %s", cfg.MinIOBucket)}
+}
+
+// checkExchanges declares each exchange passively so nothing is created on a
+// broker that is missing them.
+func checkExchanges(cfg *config.Config) []checkResult {
+	conn, err := amqp.DialConfig(cfg.Queue.URL(), amqp.Config{Dial: amqp.DefaultDial(5 * time.Second)})
+	if err != nil {
+		return []checkResult{{name: "rabbitmq", status: checkFail, detail: err.Error()}}
+	}
+	defer conn.Close()
+
+	exchanges := []string{
+		rabbitmq.TranscodeTopology.Exchange,
+		rabbitmq.TranscodeTopology.DLX,
+		"recording_exchange",
+	}
+	if cfg.Analytics.Enabled {
+		exchanges = append(exchanges, cfg.Analytics.Exchange)
+	}
+
+	results := []checkResult{{name: "rabbitmq", status: checkOK, detail: "connected to " + cfg.Queue.Host}}
+	for _, exchange := range exchanges {
+		name := "exchange " + exchange
+		// A failed passive declare closes the channel, so each check gets its own.
+		ch, err := conn.Channel()
+		if err != nil {
+			results = append(results, checkResult{name: name, status: checkFail, detail: err.Error()})
+			continue
+		}
+		err = ch.ExchangeDeclarePassive(exchange, cfg.Queue.Kind, true, false, false, false, nil)
+		if err != nil {
+			results = append(results, checkResult{name: name, status: checkFail, detail: err.Error()})
+		} else {
+			results = append(results, checkResult{name: name, status: checkOK, detail: "exists"})
+			ch.Close()
+		}
+	}
+	return results
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+	"worker-transcode/config"
+	server2 "worker-transcode/server"
+
+	"github.com/spf13/cobra"
+)
+
+const drainPollInterval = 5 * time.Second
+
+func drain(cfg *config.Config) *cobra.Command {
+	var (
+		addr    string
+		timeout time.Duration
+		noWait  bool
+	)
+
+	drainCmd := &cobra.Command{
+		Use:   "drain",
+		Short: "stop a worker taking new jobs and wait for it to finish the ones in flight",
+		Long: "Asks a running worker, through its admin listener, to stop consuming, " +
+			"finish its in-flight jobs and exit. Jobs still running after --timeout are " +
+			"interrupted and redelivered to other workers.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if addr == "" {
+				addr = fmt.Sprintf("http://127.0.0.1:%s", cfg.Admin.Port)
+			}
+			endpoint := addr + "/admin/drain"
+
+			status, err := drainRequest(cmd.Context(), http.MethodPost, endpoint+"?timeout="+url.QueryEscape(timeout.String()))
+			if err != nil {
+				return err
+			}
+			cmd.Printf("draining, in flight: %v\n", status.InFlight)
+			if noWait {
+				return nil
+			}
+
+			// The worker exits when it is done, so a refused connection means
+			// the drain finished. Allow a little past its own timeout.
+			deadline := time.Now().Add(timeout + time.Minute)
+			for time.Now().Before(deadline) {
+				select {
+				case <-cmd.Context().Done():
+					return cmd.Context().Err()
+				case <-time.After(drainPollInterval):
+				}
+
+				status, err = drainRequest(cmd.Context(), http.MethodGet, endpoint)
+				if errors.Is(err, syscall.ECONNREFUSED) {
+					cmd.Println("worker exited")
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				cmd.Printf("in flight: %v\n", status.InFlight)
+			}
+			return fmt.Errorf("worker still running after %s, in flight: %v", timeout, status.InFlight)
+		},
+	}
+
+	drainCmd.Flags().StringVar(&addr, "addr", "", "admin listener of the worker to drain (defaults to the local one)")
+	drainCmd.Flags().DurationVar(&timeout, "timeout", 30*time.Minute, "how long the worker waits for in-flight jobs before exiting anyway")
+	drainCmd.Flags().BoolVar(&noWait, "no-wait", false, "return once the drain has started")
+	return drainCmd
+}
+
+func drainRequest(ctx context.Context, method, endpoint string) (*server2.DrainStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return nil, fmt.Errorf("drain: %s: %s", resp.Status, body)
+	}
+	var status server2.DrainStatus
+	if err := json.Unmarshal(body, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
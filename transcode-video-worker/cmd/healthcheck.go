@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+	"worker-transcode/config"
+
+	"github.com/spf13/cobra"
+)
+
+func healthcheck(cfg *config.Config) *cobra.Command {
+	var (
+		url     string
+		timeout time.Duration
+	)
+
+	healthCmd := &cobra.Command{
+		Use:   "healthcheck",
+		Short: "probe the local readiness endpoint and exit non-zero when unhealthy",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if url == "" {
+				url = fmt.Sprintf("http://127.0.0.1:%s/ready", cfg.Server.HttpPort)
+			}
+
+			ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+			defer cancel()
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return err
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("unhealthy: %s: %s", resp.Status, body)
+			}
+			cmd.Println(string(body))
+			return nil
+		},
+	}
+
+	healthCmd.Flags().StringVar(&url, "url", "", "endpoint to probe (defaults to the local /ready endpoint)")
+	healthCmd.Flags().DurationVar(&timeout, "timeout", 3*time.Second, "give up after this long")
+	return healthCmd
+}
@@ -0,0 +1,188 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"worker-transcode/config"
+	"worker-transcode/dto"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/repository"
+	"worker-transcode/service"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+)
+
+func jobs(config *config.Config) *cobra.Command {
+	jobsCmd := &cobra.Command{
+		Use:   "jobs",
+		Short: "inspect and manage transcode jobs",
+	}
+	jobsCmd.AddCommand(jobsBump(config), jobsAnnotate(config), jobsAnnotations(config), jobsBundle(config))
+	return jobsCmd
+}
+
+func jobsBump(cfg *config.Config) *cobra.Command {
+	var request dto.JobBumpRequest
+
+	bumpCmd := &cobra.Command{
+		Use:   "bump <job-id>",
+		Short: "move a queued job to the priority lane",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := uuid.Parse(args[0])
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := context.WithCancel(cmd.Context())
+			defer cancel()
+
+			jobService, err := newJobService(ctx, cfg, true)
+			if err != nil {
+				return err
+			}
+			job, err := jobService.Bump(ctx, id, request)
+			if err != nil {
+				return err
+			}
+
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(job)
+		},
+	}
+
+	bumpCmd.Flags().IntVar(&request.Priority, "priority", 0, "new priority (defaults to current + 1)")
+	bumpCmd.Flags().StringVar(&request.ObjectPath, "object-path", "", "source object key, if it can't be discovered")
+	return bumpCmd
+}
+
+func jobsAnnotate(cfg *config.Config) *cobra.Command {
+	var request dto.JobAnnotationRequest
+	var authorId string
+
+	annotateCmd := &cobra.Command{
+		Use:   "annotate <job-id>",
+		Short: "attach a note and tags to a job",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := uuid.Parse(args[0])
+			if err != nil {
+				return err
+			}
+			if authorId != "" {
+				author, err := uuid.Parse(authorId)
+				if err != nil {
+					return err
+				}
+				request.AuthorId = &author
+			}
+
+			jobService, err := newJobService(cmd.Context(), cfg, false)
+			if err != nil {
+				return err
+			}
+			annotation, err := jobService.Annotate(cmd.Context(), id, request)
+			if err != nil {
+				return err
+			}
+
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(annotation)
+		},
+	}
+
+	annotateCmd.Flags().StringVar(&request.Note, "note", "", "free-form note, e.g. the escalation the job belongs to")
+	annotateCmd.Flags().StringSliceVar(&request.Tags, "tag", nil, "tag to search the job by, repeatable")
+	annotateCmd.Flags().StringVar(&authorId, "author", "", "user id of the operator annotating")
+	return annotateCmd
+}
+
+func jobsAnnotations(cfg *config.Config) *cobra.Command {
+	return &cobra.Command{
+		Use:   "annotations <job-id>",
+		Short: "list the notes and tags attached to a job",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := uuid.Parse(args[0])
+			if err != nil {
+				return err
+			}
+
+			jobService, err := newJobService(cmd.Context(), cfg, false)
+			if err != nil {
+				return err
+			}
+			annotations, err := jobService.Annotations(cmd.Context(), id)
+			if err != nil {
+				return err
+			}
+
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(annotations)
+		},
+	}
+}
+
+func jobsBundle(cfg *config.Config) *cobra.Command {
+	var output string
+
+	bundleCmd := &cobra.Command{
+		Use:   "bundle <job-id>",
+		Short: "package everything about a job into one file for an escalation",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := uuid.Parse(args[0])
+			if err != nil {
+				return err
+			}
+
+			repo, store, err := openClients(cfg)
+			if err != nil {
+				return err
+			}
+			bundleService := service.NewJobBundleService(repo, repository.NewJobEventRepo(repo.GetDB()), repository.NewJobAnnotationRepo(repo.GetDB()),
+				repository.NewQCRepo(repo.GetDB()), repository.NewPresetRepo(repo.GetDB()), store, cfg)
+			bundle, err := bundleService.Bundle(cmd.Context(), id)
+			if err != nil {
+				return err
+			}
+
+			if output == "" {
+				output = fmt.Sprintf("job-%s.tar.gz", id)
+			}
+			if err := os.WriteFile(output, bundle, 0o644); err != nil {
+				return err
+			}
+			fmt.Printf("bundle of job %s written to %s\n", id, output)
+			return nil
+		},
+	}
+
+	bundleCmd.Flags().StringVar(&output, "output", "", "file the bundle is written to (default job-<job-id>.tar.gz)")
+	return bundleCmd
+}
+
+// newJobService builds the service, connecting to RabbitMQ only for
+// commands that publish.
+func newJobService(ctx context.Context, cfg *config.Config, publish bool) (service.JobService, error) {
+	var publisher rabbitmq.Publisher
+	if publish {
+		conn, err := config.NewRabbitMQConn(ctx, cfg.Queue)
+		if err != nil {
+			return nil, err
+		}
+		publisher = rabbitmq.NewPublisher(conn)
+	}
+
+	repo, store, err := openClients(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return service.NewJobService(repo, repository.NewJobEventRepo(repo.GetDB()), repository.NewJobAnnotationRepo(repo.GetDB()), publisher, store, cfg), nil
+}
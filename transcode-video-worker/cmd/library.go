@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"worker-transcode/config"
+	"worker-transcode/dto"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/repository"
+	"worker-transcode/service"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+)
+
+func library(cfg *config.Config) *cobra.Command {
+	libraryCmd := &cobra.Command{
+		Use:   "library",
+		Short: "import a tenant's videos hosted on vimeo or youtube into lessons",
+	}
+	libraryCmd.AddCommand(libraryVideos(cfg))
+	libraryCmd.AddCommand(libraryImport(cfg))
+	return libraryCmd
+}
+
+func libraryFlags(cmd *cobra.Command, request *dto.LibraryRequest) {
+	cmd.Flags().StringVar(&request.Provider, "provider", "", "vimeo or youtube")
+	cmd.Flags().StringVar(&request.Token, "token", "", "vimeo access token, or youtube oauth access token")
+	cmd.Flags().StringVar(&request.APIKey, "api-key", "", "youtube api key, listing a channel's public uploads")
+	cmd.Flags().StringVar(&request.ChannelId, "channel-id", "", "youtube channel listed with --api-key")
+	cmd.MarkFlagRequired("provider")
+}
+
+func libraryVideos(cfg *config.Config) *cobra.Command {
+	var request dto.LibraryImportRequest
+
+	videosCmd := &cobra.Command{
+		Use:   "videos",
+		Short: "list the library's videos, to map them to lessons",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, err := cliContext(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+			libraryService, err := newLibraryService(ctx, cfg, false)
+			if err != nil {
+				return err
+			}
+			videos, err := libraryService.Videos(ctx, request.LibraryRequest)
+			if err != nil {
+				return err
+			}
+
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(videos)
+		},
+	}
+
+	libraryFlags(videosCmd, &request.LibraryRequest)
+	return videosCmd
+}
+
+func libraryImport(cfg *config.Config) *cobra.Command {
+	var (
+		request  dto.LibraryImportRequest
+		mappings string
+	)
+
+	importCmd := &cobra.Command{
+		Use:   "import <tenant-id>",
+		Short: "download the mapped videos into their lessons and queue their transcodes",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tenantId, err := uuid.Parse(args[0])
+			if err != nil {
+				return err
+			}
+			raw, err := os.ReadFile(mappings)
+			if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(raw, &request.Mappings); err != nil {
+				return err
+			}
+
+			ctx, err := cliContext(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			libraryService, err := newLibraryService(ctx, cfg, true)
+			if err != nil {
+				return err
+			}
+			claimed, err := libraryService.Claim(ctx, tenantId, request)
+			if err != nil {
+				return err
+			}
+			libraryService.Run(ctx, request, claimed)
+			imports, err := libraryService.Imports(ctx, tenantId)
+			if err != nil {
+				return err
+			}
+
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(imports)
+		},
+	}
+
+	libraryFlags(importCmd, &request.LibraryRequest)
+	importCmd.Flags().StringVar(&mappings, "mappings", "", `JSON file of [{"video_id": ..., "lesson_id": ...}]`)
+	importCmd.Flags().StringVar(&request.Preset, "preset", "", "preset to transcode with (defaults to the default ladder)")
+	importCmd.MarkFlagRequired("mappings")
+	return importCmd
+}
+
+// newLibraryService builds the service, connecting to RabbitMQ only for
+// commands that publish.
+func newLibraryService(ctx context.Context, cfg *config.Config, publish bool) (service.LibraryService, error) {
+	var publisher rabbitmq.Publisher
+	if publish {
+		conn, err := config.NewRabbitMQConn(ctx, cfg.Queue)
+		if err != nil {
+			return nil, err
+		}
+		publisher = rabbitmq.NewPublisher(conn)
+	}
+
+	repo, store, err := openClients(cfg)
+	if err != nil {
+		return nil, err
+	}
+	presetService := service.NewPresetService(repository.NewPresetRepo(repo.GetDB()), cfg)
+	return service.NewLibraryService(repository.NewLibraryImportRepo(repo.GetDB()), repo, presetService, publisher, store, cfg), nil
+}
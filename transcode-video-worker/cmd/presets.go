@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"worker-transcode/config"
+	"worker-transcode/entities"
+	"worker-transcode/repository"
+	"worker-transcode/service"
+
+	"github.com/spf13/cobra"
+)
+
+func presets(cfg *config.Config) *cobra.Command {
+	presetsCmd := &cobra.Command{
+		Use:   "presets",
+		Short: "list, validate and compile transcode presets",
+	}
+	presetsCmd.AddCommand(presetsList(cfg))
+	presetsCmd.AddCommand(presetsValidate(cfg))
+	presetsCmd.AddCommand(presetsCompile(cfg))
+	return presetsCmd
+}
+
+func presetsList(cfg *config.Config) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "print the latest version of every stored preset",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, err := cliContext(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+
+			repo, err := openRepo(cfg)
+			if err != nil {
+				return err
+			}
+			list, err := service.NewPresetService(repository.NewPresetRepo(repo.GetDB()), cfg).List(ctx)
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tVERSION\tSEMVER\tACTIVE\tCODECS\tSEGMENT\tKEYFRAME\tRENDITIONS")
+			for _, preset := range list {
+				heights := make([]string, 0, len(preset.Renditions))
+				for _, r := range preset.Renditions {
+					heights = append(heights, fmt.Sprintf("%dp@%s", r.Height, r.Bitrate))
+				}
+				fmt.Fprintf(w, "%s\t%d\t%s\t%t\t%s/%s\t%ds\t%ds\t%s\n",
+					preset.Name, preset.Version, preset.Semver, preset.Active, preset.VideoCodec, preset.AudioCodec,
+					preset.SegmentSeconds, preset.KeyframeSeconds, strings.Join(heights, " "))
+			}
+			return w.Flush()
+		},
+	}
+}
+
+func presetsValidate(cfg *config.Config) *cobra.Command {
+	var skipEncode bool
+
+	validateCmd := &cobra.Command{
+		Use:   "validate <file|name>",
+		Short: "check a preset file, or a stored preset by name, before it reaches production",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, err := cliContext(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+
+			preset, err := loadPreset(ctx, cfg, args[0])
+			if err != nil {
+				return err
+			}
+
+			if skipEncode {
+				err = service.ValidatePresetShape(preset)
+			} else {
+				err = service.ValidatePreset(ctx, preset)
+			}
+			if err != nil {
+				return fmt.Errorf("preset %q is invalid: %w", preset.Name, err)
+			}
+
+			fmt.Fprintf(os.Stdout, "preset %q is valid\n", preset.Name)
+			return nil
+		},
+	}
+
+	validateCmd.Flags().BoolVar(&skipEncode, "skip-encode", false, "only check the ladder, without test encodes")
+	return validateCmd
+}
+
+func presetsCompile(cfg *config.Config) *cobra.Command {
+	var input, output string
+
+	compileCmd := &cobra.Command{
+		Use:   "compile <file|name>",
+		Short: "print the ffmpeg command line a preset encodes a source with",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, err := cliContext(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+
+			preset, err := loadPreset(ctx, cfg, args[0])
+			if err != nil {
+				return err
+			}
+			if err := service.ValidatePresetShape(preset); err != nil {
+				return fmt.Errorf("preset %q is invalid: %w", preset.Name, err)
+			}
+
+			words := service.CompilePreset(preset, input, output, cfg.Server.FFmpegThreads)
+			for i, word := range words {
+				words[i] = shellQuote(word)
+			}
+			fmt.Fprintln(os.Stdout, strings.Join(words, " "))
+			return nil
+		},
+	}
+
+	compileCmd.Flags().StringVar(&input, "input", "input.mp4", "source the command reads")
+	compileCmd.Flags().StringVar(&output, "output", "output", "directory the command writes the package to")
+	return compileCmd
+}
+
+// shellQuote quotes word for a POSIX shell when it needs it.
+func shellQuote(word string) string {
+	if word != "" && !strings.ContainsAny(word, " \t\n'\"\\$`;&|<>()[]{}*?!#~") {
+		return word
+	}
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+// loadPreset reads source as a preset document, in YAML or JSON, when it
+// exists on disk and otherwise looks up the latest stored version with that
+// name.
+func loadPreset(ctx context.Context, cfg *config.Config, source string) (*entities.Preset, error) {
+	raw, err := os.ReadFile(source)
+	if errors.Is(err, os.ErrNotExist) {
+		repo, err := openRepo(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return service.NewPresetService(repository.NewPresetRepo(repo.GetDB()), cfg).Get(ctx, source, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := service.ParsePresetDocument(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", source, err)
+	}
+	return service.PresetFromRequest(request), nil
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"worker-transcode/config"
+	"worker-transcode/service"
+
+	"github.com/spf13/cobra"
+)
+
+func probe(cfg *config.Config) *cobra.Command {
+	return &cobra.Command{
+		Use:   "probe <path|key>",
+		Short: "inspect a source file with the pipeline's checks and print the result as JSON",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, err := cliContext(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+
+			input, cleanup, err := localInput(ctx, cfg, args[0])
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+
+			check, err := service.CheckSource(ctx, input)
+			if err != nil {
+				return err
+			}
+			check.Path = args[0]
+
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(check); err != nil {
+				return err
+			}
+			return check.Err()
+		},
+	}
+}
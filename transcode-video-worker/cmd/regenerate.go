@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"worker-transcode/config"
+	"worker-transcode/dto"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/repository"
+	"worker-transcode/service"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+)
+
+func regenerate(cfg *config.Config) *cobra.Command {
+	var request dto.RegenerationRequest
+
+	regenerateCmd := &cobra.Command{
+		Use:   "regenerate <lesson-id>",
+		Short: "queue the regeneration of some artifacts of a lesson's published video",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			lessonId, err := uuid.Parse(args[0])
+			if err != nil {
+				return err
+			}
+			ctx, err := cliContext(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+
+			conn, err := config.NewRabbitMQConn(ctx, cfg.Queue)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			publisher := rabbitmq.NewPublisher(conn)
+
+			repo, store, err := openClients(cfg)
+			if err != nil {
+				return err
+			}
+			db := repo.GetDB()
+			courseService := service.NewCourseService(repository.NewCourseRepo(db), publisher, cfg)
+			versionService := service.NewVideoVersionService(repository.NewVideoVersionRepo(db), service.NewStorageService(repository.NewStorageRepo(db), store, cfg), store, cfg)
+			regenerationService := service.NewRegenerationService(repository.NewRegenerationRepo(db), repository.NewCourseRepo(db), courseService,
+				service.NewPresetService(repository.NewPresetRepo(db), cfg), service.NewChapterService(repository.NewChapterRepo(db), publisher, cfg),
+				service.NewQCService(repository.NewQCRepo(db), repository.NewFingerprintRepo(db), courseService, cfg),
+				service.NewAccessibilityService(repository.NewAccessibilityRepo(db), cfg),
+				repo, repository.NewJobEventRepo(db), versionService, publisher, store, cfg)
+			regeneration, err := regenerationService.Request(ctx, lessonId, request)
+			if err != nil {
+				return err
+			}
+
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(regeneration)
+		},
+	}
+
+	regenerateCmd.Flags().StringSliceVar(&request.Artifacts, "artifact", nil, "artifact to make again: posters, preview, slides, chapters, rendition, music or accessibility, repeatable")
+	regenerateCmd.Flags().IntSliceVar(&request.Heights, "height", nil, "rung to encode again with the rendition artifact, repeatable")
+	regenerateCmd.MarkFlagRequired("artifact")
+	return regenerateCmd
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"worker-transcode/config"
+	"worker-transcode/dto"
+	"worker-transcode/repository"
+	"worker-transcode/service"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+)
+
+func replay(cfg *config.Config) *cobra.Command {
+	var request dto.ReplayRequest
+
+	replayCmd := &cobra.Command{
+		Use:   "replay <job-id>",
+		Short: "run a transcode job again from its snapshot; output goes under replays/",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := uuid.Parse(args[0])
+			if err != nil {
+				return err
+			}
+
+			ctx, err := cliContext(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			repo, store, err := openClients(cfg)
+			if err != nil {
+				return err
+			}
+			replayService := service.NewReplayService(repository.NewJobSnapshotRepo(repo.GetDB()), repository.NewJobEventRepo(repo.GetDB()),
+				repository.NewPresetRepo(repo.GetDB()), store, cfg)
+			result, err := replayService.Replay(ctx, id, request)
+			if err != nil {
+				return err
+			}
+
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(result)
+		},
+	}
+
+	replayCmd.Flags().BoolVar(&request.AllowDrift, "allow-drift", false, "replay a source changed since the job read it as it is now")
+	replayCmd.Flags().StringVar(&request.LocalDir, "local-dir", "", "directory the replayed package is kept in as well")
+	return replayCmd
+}
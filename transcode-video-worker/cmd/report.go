@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/pkg/mailer"
+	"worker-transcode/repository"
+	"worker-transcode/service"
+
+	"github.com/spf13/cobra"
+)
+
+func report(config *config.Config) *cobra.Command {
+	reportCmd := &cobra.Command{
+		Use:   "report",
+		Short: "build processing reports",
+	}
+	reportCmd.AddCommand(reportDaily(config))
+	return reportCmd
+}
+
+func reportDaily(cfg *config.Config) *cobra.Command {
+	var (
+		date    string
+		publish bool
+	)
+
+	dailyCmd := &cobra.Command{
+		Use:   "daily",
+		Short: "summarise one UTC day of processing",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			day := time.Now().UTC().AddDate(0, 0, -1)
+			if date != "" {
+				parsed, err := time.Parse(time.DateOnly, date)
+				if err != nil {
+					return err
+				}
+				day = parsed
+			}
+
+			repo, store, err := openClients(cfg)
+			if err != nil {
+				return err
+			}
+			reportService := service.NewReportService(repository.NewReportRepo(repo.GetDB()), mailer.New(cfg.SMTP), store, cfg)
+			report, err := reportService.Daily(cmd.Context(), day)
+			if err != nil {
+				return err
+			}
+			if publish {
+				if err := reportService.Publish(cmd.Context(), report); err != nil {
+					return err
+				}
+			}
+
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(report)
+		},
+	}
+
+	dailyCmd.Flags().StringVar(&date, "date", "", "day to summarise as YYYY-MM-DD (defaults to yesterday)")
+	dailyCmd.Flags().BoolVar(&publish, "publish", false, "also write the report to the bucket and email it")
+	return dailyCmd
+}
@@ -1,12 +1,89 @@
 package cmd
 
 import (
+	"fmt"
 	"github.com/spf13/cobra"
+	"os"
 	"worker-transcode/config"
+	"worker-transcode/pkg/ffmpeg"
 )
 
-func Root(config *config.Config) *cobra.Command {
-	rootCmd := &cobra.Command{}
-	rootCmd.AddCommand(server(config))
+// Root builds the command tree. cfg is filled in before any command runs,
+// from --env-file, the environment and the config flags, in rising order of
+// precedence.
+func Root(cfg *config.Config) *cobra.Command {
+	var envFile string
+
+	rootCmd := &cobra.Command{
+		Use:   "worker",
+		Short: "transcode and recording merge worker",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if isCompletion(cmd) || cmd.Name() == "version" {
+				return nil
+			}
+			for _, flag := range config.Flags {
+				if f := cmd.Flags().Lookup(flag.Name); f != nil && f.Changed {
+					if err := os.Setenv(flag.Env, f.Value.String()); err != nil {
+						return err
+					}
+				}
+			}
+
+			loaded, err := config.Load(envFile)
+			if err != nil {
+				return err
+			}
+			*cfg = *loaded
+			return ffmpeg.Configure(cfg.FFmpeg, cfg.Server.FFmpegThreads)
+		},
+	}
+
+	rootCmd.PersistentFlags().StringVar(&envFile, "env-file", ".env", "file to read settings from, if it exists")
+	for _, flag := range config.Flags {
+		rootCmd.PersistentFlags().String(flag.Name, "", fmt.Sprintf("%s ($%s)", flag.Usage, flag.Env))
+		values := flag.Values
+		if flag.Bool {
+			rootCmd.PersistentFlags().Lookup(flag.Name).NoOptDefVal = "true"
+			values = []string{"true", "false"}
+		}
+		if len(values) > 0 {
+			rootCmd.RegisterFlagCompletionFunc(flag.Name, cobra.FixedCompletions(values, cobra.ShellCompDirectiveNoFileComp))
+		}
+	}
+
+	rootCmd.AddCommand(server(cfg))
+	rootCmd.AddCommand(serve(cfg))
+	rootCmd.AddCommand(consume(cfg))
+	rootCmd.AddCommand(jobs(cfg))
+	rootCmd.AddCommand(healthcheck(cfg))
+	rootCmd.AddCommand(doctor(cfg))
+	rootCmd.AddCommand(report(cfg))
+	rootCmd.AddCommand(transcode(cfg))
+	rootCmd.AddCommand(probe(cfg))
+	rootCmd.AddCommand(presets(cfg))
+	rootCmd.AddCommand(cleanup(cfg))
+	rootCmd.AddCommand(storageUsage(cfg))
+	rootCmd.AddCommand(backfill(cfg))
+	rootCmd.AddCommand(library(cfg))
+	rootCmd.AddCommand(bench(cfg))
+	rootCmd.AddCommand(verify(cfg))
+	rootCmd.AddCommand(regenerate(cfg))
+	rootCmd.AddCommand(simulate(cfg))
+	rootCmd.AddCommand(replay(cfg))
+	rootCmd.AddCommand(stats(cfg))
+	rootCmd.AddCommand(drain(cfg))
+	rootCmd.AddCommand(workers(cfg))
+	rootCmd.AddCommand(versionCmd())
 	return rootCmd
 }
+
+// isCompletion reports whether cmd generates or serves shell completions,
+// which, like version, must work without any configuration.
+func isCompletion(cmd *cobra.Command) bool {
+	for c := cmd; c != nil; c = c.Parent() {
+		if c.Name() == "completion" || c.Name() == cobra.ShellCompRequestCmd || c.Name() == cobra.ShellCompNoDescRequestCmd {
+			return true
+		}
+	}
+	return false
+}
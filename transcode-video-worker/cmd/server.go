@@ -9,9 +9,29 @@ import (
 func server(config *config.Config) *cobra.Command {
 	return &cobra.Command{
 		Use:   "server",
-		Short: "start http server",
+		Short: "start http server and queue consumers in one process",
 		Run: func(cmd *cobra.Command, args []string) {
-			server2.RunHttp(config)
+			server2.Run(config, server2.ModeAll)
+		},
+	}
+}
+
+func serve(config *config.Config) *cobra.Command {
+	return &cobra.Command{
+		Use:   "serve",
+		Short: "start the http api without consuming jobs",
+		Run: func(cmd *cobra.Command, args []string) {
+			server2.Run(config, server2.ModeServe)
+		},
+	}
+}
+
+func consume(config *config.Config) *cobra.Command {
+	return &cobra.Command{
+		Use:   "consume",
+		Short: "consume jobs, serving only health, readiness and metrics over http",
+		Run: func(cmd *cobra.Command, args []string) {
+			server2.Run(config, server2.ModeConsume)
 		},
 	}
 }
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"worker-transcode/config"
+	"worker-transcode/dto"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/service"
+
+	"github.com/spf13/cobra"
+)
+
+func simulate(cfg *config.Config) *cobra.Command {
+	var request dto.SimulateRequest
+
+	simulateCmd := &cobra.Command{
+		Use:   "simulate <seed-key>",
+		Short: "publish synthetic transcode jobs for load testing; sources and output go under simulate/",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			request.Seed = args[0]
+
+			ctx, err := cliContext(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			conn, err := config.NewRabbitMQConn(ctx, cfg.Queue)
+			if err != nil {
+				return err
+			}
+
+			repo, store, err := openClients(cfg)
+			if err != nil {
+				return err
+			}
+			simulateService := service.NewSimulateService(repo, rabbitmq.NewPublisher(conn), store, cfg)
+			result, err := simulateService.Run(ctx, request)
+			if result != nil {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				if encodeErr := encoder.Encode(result); encodeErr != nil {
+					return encodeErr
+				}
+			}
+			return err
+		},
+	}
+
+	simulateCmd.Flags().IntVar(&request.Count, "count", 10, "number of jobs to publish")
+	simulateCmd.Flags().Float64Var(&request.RatePerSecond, "rate", 1, "jobs published per second")
+	simulateCmd.Flags().StringVar(&request.Preset, "preset", "", "preset the jobs use (defaults to the default ladder)")
+	simulateCmd.Flags().StringVar(&request.Lane, "lane", "default", "queue to publish to: default, priority, backfill, long, express, enterprise or free")
+	return simulateCmd
+}
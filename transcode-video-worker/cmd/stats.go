@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/dto"
+	"worker-transcode/pkg/mailer"
+	"worker-transcode/repository"
+	"worker-transcode/service"
+
+	"github.com/spf13/cobra"
+)
+
+func stats(cfg *config.Config) *cobra.Command {
+	var (
+		since   time.Duration
+		jsonOut bool
+	)
+
+	statsCmd := &cobra.Command{
+		Use:   "stats",
+		Short: "print throughput, processing times and failures for recent jobs",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if since <= 0 {
+				return errors.New("--since must be positive")
+			}
+			ctx, err := cliContext(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+
+			repo, store, err := openClients(cfg)
+			if err != nil {
+				return err
+			}
+			reportService := service.NewReportService(repository.NewReportRepo(repo.GetDB()), mailer.New(cfg.SMTP), store, cfg)
+			stats, err := reportService.Stats(ctx, since)
+			if err != nil {
+				return err
+			}
+
+			if jsonOut {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(stats)
+			}
+			return printStats(stats)
+		},
+	}
+
+	statsCmd.Flags().DurationVar(&since, "since", 24*time.Hour, "how far back to look")
+	statsCmd.Flags().BoolVar(&jsonOut, "json", false, "print the snapshot as JSON")
+	return statsCmd
+}
+
+func printStats(stats *dto.JobStats) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Jobs finished in the last %s (%s to %s)\n\n", stats.Since,
+		stats.From.Format(time.RFC3339), stats.To.Format(time.RFC3339))
+
+	fmt.Fprintf(w, "completed\t%d\n", stats.Completed)
+	fmt.Fprintf(w, "failed\t%d (%.1f%%)\n", stats.Failed, stats.FailureRate*100)
+	fmt.Fprintf(w, "throughput\t%.2f jobs/hour\n\n", stats.ThroughputPerHour)
+
+	fmt.Fprintln(w, "DURATION\tSAMPLES\tP50\tP95")
+	fmt.Fprintf(w, "turnaround\t%d\t%s\t%s\n", stats.Turnaround.Samples, formatSeconds(stats.Turnaround.P50), formatSeconds(stats.Turnaround.P95))
+	fmt.Fprintf(w, "processing\t%d\t%s\t%s\n\n", stats.Processing.Samples, formatSeconds(stats.Processing.P50), formatSeconds(stats.Processing.P95))
+
+	fmt.Fprintln(w, "STATUS\tJOBS")
+	for _, count := range stats.Jobs {
+		fmt.Fprintf(w, "%s\t%d\n", count.Status, count.Count)
+	}
+
+	if len(stats.ErrorClasses) > 0 {
+		fmt.Fprintln(w, "\nERROR CLASS\tJOBS")
+		for _, count := range stats.ErrorClasses {
+			fmt.Fprintf(w, "%s\t%d\n", count.ErrorClass, count.Count)
+		}
+	}
+	if len(stats.StageFailures) > 0 {
+		fmt.Fprintln(w, "\nSTAGE\tFAILED ATTEMPTS")
+		for _, count := range stats.StageFailures {
+			fmt.Fprintf(w, "%s\t%d\n", count.Stage, count.Count)
+		}
+	}
+	return w.Flush()
+}
+
+func formatSeconds(value float64) string {
+	return time.Duration(value * float64(time.Second)).Round(time.Second).String()
+}
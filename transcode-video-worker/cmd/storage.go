@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"fmt"
+	"worker-transcode/config"
+	"worker-transcode/repository"
+	"worker-transcode/service"
+
+	"github.com/spf13/cobra"
+)
+
+func storageUsage(cfg *config.Config) *cobra.Command {
+	return &cobra.Command{
+		Use:   "storage-usage",
+		Short: "count the storage of video versions published before storage usage was tracked",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, err := cliContext(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+
+			repo, store, err := openClients(cfg)
+			if err != nil {
+				return err
+			}
+			storageService := service.NewStorageService(repository.NewStorageRepo(repo.GetDB()), store, cfg)
+			counted, err := storageService.Backfill(ctx)
+			fmt.Printf("%d versions counted\n", counted)
+			return err
+		},
+	}
+}
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"worker-transcode/config"
+	"worker-transcode/pkg/logging"
+	"worker-transcode/repository"
+	"worker-transcode/service"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+)
+
+func transcode(config *config.Config) *cobra.Command {
+	transcodeCmd := &cobra.Command{
+		Use:   "transcode",
+		Short: "run the encoder outside of the queue",
+	}
+	transcodeCmd.AddCommand(transcodeFile(config))
+	return transcodeCmd
+}
+
+func transcodeFile(cfg *config.Config) *cobra.Command {
+	var (
+		presetName string
+		outputDir  string
+	)
+
+	fileCmd := &cobra.Command{
+		Use:   "file <path|key>",
+		Short: "transcode a local file or bucket object to HLS with a named preset",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, err := cliContext(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+
+			input, cleanup, err := localInput(ctx, cfg, args[0])
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+
+			repo, err := openRepo(cfg)
+			if err != nil {
+				return err
+			}
+			preset, err := service.NewPresetService(repository.NewPresetRepo(repo.GetDB()), cfg).Resolve(ctx, presetName)
+			if err != nil {
+				return err
+			}
+
+			if outputDir == "" {
+				name := filepath.Base(input)
+				outputDir = filepath.Join("out", strings.TrimSuffix(name, filepath.Ext(name)))
+			}
+
+			zerolog.Ctx(ctx).Info().
+				Str("input", input).
+				Str("preset", preset.Name).
+				Int("preset_version", preset.Version).
+				Str("output", outputDir).
+				Msg("transcoding file")
+			if err := service.TranscodeFile(ctx, preset, input, outputDir); err != nil {
+				var ffmpegErr *service.FFmpegError
+				if errors.As(err, &ffmpegErr) {
+					cmd.PrintErrln(ffmpegErr.Output)
+				}
+				return err
+			}
+
+			cmd.Println(filepath.Join(outputDir, "master.m3u8"))
+			return nil
+		},
+	}
+
+	fileCmd.Flags().StringVar(&presetName, "preset", service.DefaultPresetName, "preset to encode with")
+	fileCmd.Flags().StringVar(&outputDir, "output", "", "directory for the HLS output (defaults to out/<name>)")
+	return fileCmd
+}
+
+// cliContext gives one-off commands the same logger the server uses.
+func cliContext(ctx context.Context, cfg *config.Config) (context.Context, error) {
+	logger, err := logging.New(cfg.Log, cfg.App.Environment)
+	if err != nil {
+		return nil, err
+	}
+	return logger.WithContext(ctx), nil
+}
+
+// localInput returns a path on disk for source. A path that doesn't exist
+// locally is treated as a key in the bucket and downloaded to a temp dir.
+func localInput(ctx context.Context, cfg *config.Config, source string) (string, func(), error) {
+	if _, err := os.Stat(source); err == nil {
+		return source, func() {}, nil
+	}
+	store, err := config.NewStorage(cfg.ObjectStore)
+	if err != nil {
+		return "", nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "worker-cli-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	path := filepath.Join(dir, filepath.Base(source))
+	zerolog.Ctx(ctx).Info().Str("key", source).Msg("downloading source from bucket")
+	if err := store.FGetObject(ctx, cfg.MinIOBucket, source, path, minio.GetObjectOptions{}); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return path, cleanup, nil
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"worker-transcode/config"
+	"worker-transcode/repository"
+	"worker-transcode/service"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+)
+
+func verify(cfg *config.Config) *cobra.Command {
+	var expected float64
+
+	verifyCmd := &cobra.Command{
+		Use:   "verify <lesson-id|master-key>",
+		Short: "check that a published HLS package is complete and print the result as JSON",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, err := cliContext(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+
+			repo, store, err := openClients(cfg)
+			if err != nil {
+				return err
+			}
+			masterKey := args[0]
+			if lessonId, err := uuid.Parse(args[0]); err == nil {
+				urls, err := repository.NewCleanupRepo(repo.GetDB()).FindLessonVideoURLs(ctx, []uuid.UUID{lessonId})
+				if err != nil {
+					return err
+				}
+				if masterKey = urls[lessonId]; !strings.HasSuffix(masterKey, ".m3u8") {
+					return fmt.Errorf("lesson %s has no published playlist", lessonId)
+				}
+			}
+
+			report, err := service.VerifyHLS(ctx, store, cfg.MinIOBucket, masterKey, expected)
+			if err != nil {
+				return err
+			}
+
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(report); err != nil {
+				return err
+			}
+			return report.Err()
+		},
+	}
+
+	verifyCmd.Flags().Float64Var(&expected, "duration", 0, "expected duration in seconds (defaults to the longest playlist)")
+	return verifyCmd
+}
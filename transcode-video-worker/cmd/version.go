@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"worker-transcode/pkg/version"
+
+	"github.com/spf13/cobra"
+)
+
+func versionCmd() *cobra.Command {
+	var (
+		asJSON bool
+		deps   bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "version",
+		Short: "print build metadata and the ffmpeg the worker will run",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			info := version.Get()
+			if deps {
+				info.Dependencies = version.Dependencies()
+			}
+
+			if asJSON {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(info)
+			}
+
+			commit := info.Commit
+			if info.Modified {
+				commit += " (modified)"
+			}
+			fmt.Printf("commit:     %s\n", commit)
+			fmt.Printf("built:      %s\n", info.BuildTime)
+			fmt.Printf("go:         %s\n", info.GoVersion)
+			fmt.Printf("ffmpeg:     %s\n", info.FFmpeg)
+			if deps {
+				paths := make([]string, 0, len(info.Dependencies))
+				for path := range info.Dependencies {
+					paths = append(paths, path)
+				}
+				slices.Sort(paths)
+				fmt.Println("dependencies:")
+				for _, path := range paths {
+					fmt.Printf("  %s %s\n", path, info.Dependencies[path])
+				}
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print as JSON")
+	cmd.Flags().BoolVar(&deps, "deps", false, "include the versions of compiled-in modules")
+	return cmd
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/repository"
+	"worker-transcode/service"
+
+	"github.com/spf13/cobra"
+)
+
+func workers(cfg *config.Config) *cobra.Command {
+	var jsonOut bool
+
+	workersCmd := &cobra.Command{
+		Use:   "workers",
+		Short: "list registered workers and their last heartbeat",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, err := cliContext(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+
+			repo, store, err := openClients(cfg)
+			if err != nil {
+				return err
+			}
+			workerService := service.NewWorkerService(repository.NewWorkerRepo(repo.GetDB()), repo, nil, rabbitmq.NewIntake(), store, cfg)
+			list, err := workerService.List(ctx)
+			if err != nil {
+				return err
+			}
+
+			if jsonOut {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(list)
+			}
+
+			if len(list) == 0 {
+				cmd.Println("no workers registered")
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tHOSTNAME\tSTATUS\tMODE\tVERSION\tGPU\tIN FLIGHT\tHEARTBEAT")
+			for _, worker := range list {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t\t%d/%d\t%s ago\n",
+					worker.ID, worker.Hostname, worker.Status, worker.Mode, worker.Version,
+					worker.Capabilities.GPU, worker.InFlight, worker.Concurrency,
+					time.Since(worker.LastHeartbeatAt).Round(time.Second))
+			}
+			return w.Flush()
+		},
+	}
+
+	workersCmd.Flags().BoolVar(&jsonOut, "json", false, "print the workers as JSON")
+	return workersCmd
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const (
+	cgroupRoot = "/sys/fs/cgroup"
+	// coresPerJob is how many cores one transcode is sized to use when the
+	// worker count is derived.
+	coresPerJob = 2
+)
+
+// Limits is what the process may use: its cgroup v2 CPU quota and memory
+// limit, or the host's cores and no memory limit outside a limited cgroup.
+type Limits struct {
+	CPUs float64
+	// MemoryBytes is zero when memory isn't limited.
+	MemoryBytes int64
+}
+
+// DetectLimits reads the limits of the cgroup the process runs in.
+func DetectLimits() Limits {
+	limits := Limits{CPUs: float64(runtime.NumCPU())}
+	dir := cgroupDir()
+
+	if raw, err := os.ReadFile(filepath.Join(dir, "cpu.max")); err == nil {
+		fields := strings.Fields(string(raw))
+		if len(fields) == 2 && fields[0] != "max" {
+			quota, quotaErr := strconv.ParseFloat(fields[0], 64)
+			period, periodErr := strconv.ParseFloat(fields[1], 64)
+			if quotaErr == nil && periodErr == nil && quota > 0 && period > 0 {
+				limits.CPUs = math.Min(limits.CPUs, quota/period)
+			}
+		}
+	}
+
+	if raw, err := os.ReadFile(filepath.Join(dir, "memory.max")); err == nil {
+		if memory, err := strconv.ParseInt(strings.TrimSpace(string(raw)), 10, 64); err == nil && memory > 0 {
+			limits.MemoryBytes = memory
+		}
+	}
+	return limits
+}
+
+// OOMKills is how many processes of the worker's cgroup the kernel's OOM
+// killer has killed, from its memory.events, or -1 where that isn't counted.
+func OOMKills() int64 {
+	raw, err := os.ReadFile(filepath.Join(cgroupDir(), "memory.events"))
+	if err != nil {
+		return -1
+	}
+	for _, line := range strings.Split(string(raw), "\n") {
+		if count, ok := strings.CutPrefix(line, "oom_kill "); ok {
+			if kills, err := strconv.ParseInt(strings.TrimSpace(count), 10, 64); err == nil {
+				return kills
+			}
+		}
+	}
+	return -1
+}
+
+// cgroupDir is the process' cgroup v2 directory, from the "0::" line of
+// /proc/self/cgroup. In a container with its own cgroup namespace it is the
+// root itself.
+func cgroupDir() string {
+	raw, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return cgroupRoot
+	}
+	for _, line := range strings.Split(string(raw), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			dir := filepath.Join(cgroupRoot, path)
+			if _, err := os.Stat(filepath.Join(dir, "cpu.max")); err == nil {
+				return dir
+			}
+		}
+	}
+	return cgroupRoot
+}
+
+// Workers is the number of concurrent transcodes the limits fit: one per
+// coresPerJob cores, and no more than the memory holds at jobMemory MB each.
+func (l Limits) Workers(jobMemory int) int {
+	workers := max(int(l.CPUs/coresPerJob), 1)
+	if l.MemoryBytes > 0 && jobMemory > 0 {
+		workers = min(workers, max(int(l.MemoryBytes/(int64(jobMemory)<<20)), 1))
+	}
+	return workers
+}
+
+// Threads shares the cores between jobs running at once.
+func (l Limits) Threads(jobs int) int {
+	return max(int(math.Round(l.CPUs/float64(max(jobs, 1)))), 1)
+}
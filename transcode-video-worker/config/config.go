@@ -1,25 +1,94 @@
 package config
 
 import (
-	"crypto/tls"
-	"database/sql"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
-	"strconv"
+	"slices"
+	"strings"
 
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
 	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
 )
 
+// knownMediaEngines are the media engines the service implements, which
+// WORKER_MEDIA_ENGINES may list.
+var knownMediaEngines = []string{"ffmpeg"}
+
 type Config struct {
-	MinIOBucket string
-	App         App
-	DB          *sql.DB
-	Queue       *RabbitMQ
-	Storage     *minio.Client
-	Server      Server
+	MinIOBucket   string
+	App           App
+	Database      Database
+	Queue         *RabbitMQ
+	ObjectStore   ObjectStore
+	Cache         Cache
+	Server        Server
+	FFmpeg        FFmpeg
+	Admin         Admin
+	Scaler        Scaler
+	Breaker       Breaker
+	Chaos         Chaos
+	Backpressure  Backpressure
+	SLA           SLA
+	Canary        Canary
+	Tracing       Tracing
+	Sentry        Sentry
+	Log           Log
+	Alerting      Alerting
+	SMTP          SMTP
+	Notify        Notify
+	Analytics     Analytics
+	Chapters      Chapters
+	Slides        Slides
+	Preview       Preview
+	Poster        Poster
+	Webcam        Webcam
+	Dedup         Dedup
+	Artifacts     Artifacts
+	Ingest        Ingest
+	Scan          Scan
+	Quota         Quota
+	Billing       Billing
+	Results       Results
+	Remote        Remote
+	Zoom          Zoom
+	Library       Library
+	LMS           LMS
+	Workflow      Workflow
+	Trim          Trim
+	Downmix       Downmix
+	Search        Search
+	Watermark     Watermark
+	Download      Download
+	Export        Export
+	Media         Media
+	Live          Live
+	Podcast       Podcast
+	Keys          Keys
+	BurnIn        BurnIn
+	Migration     Migration
+	Maintenance   Maintenance
+	Region        Region
+	Warehouse     Warehouse
+	PlaybackProbe PlaybackProbe
+	CDNSigning    CDNSigning
+	Reconcile     Reconcile
+	Subscriptions Subscriptions
+	Course        Course
+	Versions      Versions
+	Deletion      Deletion
+	Branding      Branding
+	Publish       Publish
+	Accessibility Accessibility
+	Quality       Quality
+	Translation   Translation
+	TTS           TTS
+	Music         Music
+	Diarization   Diarization
+	Redaction     Redaction
+	Fingerprint   Fingerprint
+	Report        Report
 }
 
 type App struct {
@@ -30,7 +99,800 @@ type App struct {
 
 type Server struct {
 	HttpPort string
-	Workers  int
+	// Workers defaults to what the cgroup's CPU and memory limits fit. It
+	// bounds the messages of every encoding binding handled at once, however
+	// their concurrencies add up.
+	Workers int
+	// FFmpegThreads caps the threads of each encode; 0 leaves it to ffmpeg.
+	// It defaults to the cores shared out between Workers.
+	FFmpegThreads int
+	// JobMemory is the memory, in MB, one transcode is sized to need when
+	// Workers is derived.
+	JobMemory int
+	// Limits are the CPU and memory limits detected for the process.
+	Limits Limits
+	// PriorityWorkers serve the priority lane used by bumped jobs.
+	PriorityWorkers int
+	// BackfillWorkers serve the lane backfill batches are queued on.
+	BackfillWorkers int
+	// LongWorkers serve the lane uploads of at least LongJobSeconds of
+	// source are queued on; a zero LongJobSeconds keeps every upload on its
+	// SLA lane.
+	LongWorkers    int
+	LongJobSeconds int
+	// ExpressWorkers serve the lane uploads of less than ExpressJobSeconds
+	// of source are queued on; a zero ExpressJobSeconds keeps them on their
+	// SLA lane.
+	ExpressWorkers    int
+	ExpressJobSeconds int
+	// Bindings are the kinds of work a consuming worker takes, each with its
+	// own concurrency, so light jobs aren't stuck behind heavy encodes. They
+	// default to Workers, PriorityWorkers, BackfillWorkers, LongWorkers and
+	// ExpressWorkers.
+	Bindings []Binding
+	// APIToken guards the /api routes with a bearer token when set.
+	APIToken string
+	// UploadDir holds in-progress tus uploads until they are complete.
+	UploadDir string
+	// MaxUploadSize caps a single tus upload, in bytes.
+	MaxUploadSize int64
+	// DryRun plans every job without encoding or uploading anything.
+	DryRun bool
+	// VerifyOutput checks every uploaded package before the job completes.
+	VerifyOutput bool
+	// StreamUpload uploads each finished segment while the encode is still
+	// writing later ones, rather than the whole package after it.
+	StreamUpload bool
+	// StreamCopy remuxes the rung a source already conforms to, in size,
+	// bitrate, codec and keyframes, instead of encoding it again.
+	StreamCopy bool
+	// FollowUps publishes a transcode's package once its renditions pass
+	// verification, and leaves its posters, preview, slides, detected
+	// chapters and review checks to regeneration jobs queued after, which
+	// idle workers run in parallel.
+	FollowUps bool
+	// MediaEngines are the engines encodes may run on, by preference: each
+	// job runs on the first one able to encode its preset.
+	MediaEngines []string
+	// HeartbeatInterval is how often, in seconds, a consuming worker
+	// refreshes its row in the workers table.
+	HeartbeatInterval int
+	// HeartbeatTimeout is how long, in seconds, a worker may go without a
+	// heartbeat before its processing jobs are handed to other workers.
+	HeartbeatTimeout int
+	// JobHeartbeatInterval is how often, in seconds, a processing job records
+	// that it's alive and the stage it's in, however long ffmpeg goes
+	// without reporting progress. A job missing jobHeartbeatMisses of them
+	// is reported stalled.
+	JobHeartbeatInterval int
+	// ShutdownGrace is how long, in seconds, a consuming worker waits for
+	// in-flight jobs after SIGTERM before handing them off. Keep it below the
+	// pod's terminationGracePeriodSeconds.
+	ShutdownGrace int
+	// JobTimeout is the base wall-clock limit of a transcode, in seconds;
+	// JobTimeoutFactor adds that many seconds per second of source media.
+	// A zero JobTimeout disables the limit.
+	JobTimeout       int
+	JobTimeoutFactor float64
+	// ScratchFactor estimates the scratch space a transcode needs: the
+	// source plus this multiple of its size per rendition.
+	ScratchFactor float64
+	// SecureScratchDir is a memory-backed directory, a tmpfs, the jobs of
+	// tenants with secure scratch work in. Empty when the worker has none,
+	// and those jobs fail.
+	SecureScratchDir string
+	// MinFreeMemory is the available memory, in MB, a job needs to start.
+	MinFreeMemory int
+	// PreflightDelay is how long, in seconds, a job the worker can't fit
+	// waits before its message goes back on the queue.
+	PreflightDelay int
+	// WriteBatchInterval is how often, in milliseconds, buffered job events
+	// and progress are written to Postgres; a batch of WriteBatchSize goes
+	// out sooner. A zero interval writes each one as it happens.
+	WriteBatchInterval int
+	WriteBatchSize     int
+}
+
+// Binding is one kind of work a consuming worker takes and how many of it it
+// runs at once; zero leaves that work queued for other workers.
+type Binding struct {
+	Name        string
+	Concurrency int
+}
+
+// FFmpeg picks the ffmpeg and ffprobe binaries and the GlobalArgs every
+// ffmpeg run starts with. GlobalArgs may name {threads}, {loglevel} and
+// {hwaccel_device}, filled in from FFMPEG_THREADS, LogLevel and
+// HWAccelDevice; an arg whose value isn't set is left out with its flag.
+type FFmpeg struct {
+	Path          string
+	ProbePath     string
+	GlobalArgs    []string
+	LogLevel      string
+	HWAccelDevice string
+}
+
+// Admin holds the settings for the internal admin listener which exposes
+// pprof and runtime debug endpoints. It is disabled unless ADMIN_ENABLED is set.
+type Admin struct {
+	Enabled bool
+	Port    string
+}
+
+// Scaler serves the KEDA external scaler gRPC API. Enable it on a process
+// KEDA doesn't scale, such as the serve deployment, so it is up while the
+// workers are scaled to zero.
+type Scaler struct {
+	Enabled bool
+	Port    string
+}
+
+// Breaker sets when the circuit breakers around storage, the database and
+// the broker open: after Threshold consecutive failed calls, staying open for
+// Cooldown seconds before a probe job is let through.
+type Breaker struct {
+	Threshold int
+	Cooldown  int
+}
+
+// Chaos injects failures for staging to see the worker recover from: each
+// rate, 0 to 1, is the share of storage requests answered with a 500, of
+// ffmpeg runs killed within FFmpegKillAfter seconds, and of database
+// statements held up for DatabaseLatency milliseconds. It is refused in
+// production.
+type Chaos struct {
+	Enabled             bool
+	StorageErrorRate    float64
+	FFmpegKillRate      float64
+	FFmpegKillAfter     int
+	DatabaseLatencyRate float64
+	DatabaseLatency     int
+}
+
+// Backpressure sets when a consuming worker stops starting jobs because its
+// node is busy; a zero limit disables that check. Intake resumes once every
+// reading is back under 90% of its limit. CPU is off by default since ffmpeg
+// keeps every core busy on a healthy node.
+type Backpressure struct {
+	// MaxCPU is the CPU use across all cores, in percent.
+	MaxCPU float64
+	// MaxLoadPerCPU is the one minute load average divided by the cores.
+	MaxLoadPerCPU float64
+	// MaxDisk is the use of the scratch filesystem, in percent.
+	MaxDisk float64
+	// MaxFFmpeg caps the ffmpeg processes running in the worker.
+	MaxFFmpeg int
+	// Interval is how often, in seconds, the readings are taken.
+	Interval int
+}
+
+// SLA weighs the queues of each SLA class against each other: the transcode
+// workers take that many enterprise, pro and free jobs per round while all
+// three have work. A class with work is always served, so a sustained free
+// backlog can't starve enterprise uploads, nor the other way around.
+type SLA struct {
+	EnterpriseWeight int
+	ProWeight        int
+	FreeWeight       int
+}
+
+// Canary gates promoting a canary preset version: over the last Window
+// seconds, at least MinJobs of the canary's jobs must have finished and its
+// failure rate may be at most MaxFailureIncrease above the stable version's.
+type Canary struct {
+	Window             int
+	MinJobs            int
+	MaxFailureIncrease float64
+}
+
+// Tracing controls OpenTelemetry export. The OTLP endpoint and headers are
+// taken from the standard OTEL_EXPORTER_OTLP_* variables.
+type Tracing struct {
+	Enabled     bool
+	ServiceName string
+	SampleRatio float64
+}
+
+// Sentry configures error reporting; an empty DSN disables it.
+type Sentry struct {
+	DSN        string
+	SampleRate float64
+}
+
+// Alerting configures webhook alerts. No alerts are sent unless at least one
+// webhook URL is set. Windows are in seconds.
+type Alerting struct {
+	SlackWebhookURL      string
+	DiscordWebhookURL    string
+	FailureRateThreshold float64
+	FailureWindow        int
+	FailureMinSamples    int
+	DLQThreshold         int
+	PriorityThreshold    int
+	DedupWindow          int
+}
+
+// SMTP is the relay used for outgoing email; an empty host disables sending.
+type SMTP struct {
+	Host string
+	Port int
+	User string
+	Pass string
+	From string
+}
+
+// Notify holds the defaults for user-facing notifications. Tenants can
+// override them in tenant_notification_settings.
+type Notify struct {
+	VideoReadyEmail bool
+	// LessonURLTemplate builds the lesson link, with {course_id}, {course_slug},
+	// {lesson_id} and {lesson_slug} placeholders.
+	LessonURLTemplate string
+}
+
+// Analytics controls publishing of media events to a topic exchange owned by
+// the data team.
+type Analytics struct {
+	Enabled  bool
+	Exchange string
+}
+
+// Chapters controls chapter detection on lesson recordings of at least
+// MinDuration seconds. Chapters start at pauses of MinSilence seconds or more,
+// preferably where the scene changes by SceneThreshold (0 to 1), and run for
+// at least MinLength seconds. Detected and instructor chapters alike are
+// announced on Exchange.
+type Chapters struct {
+	Enabled        bool
+	MinDuration    int
+	MinSilence     int
+	SceneThreshold float64
+	MinLength      int
+	Exchange       string
+}
+
+// Slides controls slide extraction from lessons marked as screen recordings.
+// A frame starts a slide when the scene changes by SceneThreshold (0 to 1)
+// and its image hash differs from every slide so far by more than
+// DedupDistance of 64 bits. At most MaxSlides are kept.
+type Slides struct {
+	Enabled        bool
+	SceneThreshold float64
+	DedupDistance  int
+	MaxSlides      int
+}
+
+// Preview controls the looping animated preview course listing cards play
+// on hover: Seconds of the video from a tenth of the way in, at Height lines
+// and FPS frames a second, in Format, webp or gif.
+type Preview struct {
+	Enabled bool
+	Format  string
+	Seconds int
+	Height  int
+	FPS     int
+}
+
+// Poster controls the still a video is shown with before it plays: of
+// Samples frames spread over the video, the Candidates that are neither
+// black nor blurry are kept Width wide and the best one is the poster, until
+// the instructor picks or uploads another. The poster is also kept at each
+// of the narrower Sizes.
+type Poster struct {
+	Enabled    bool
+	Samples    int
+	Candidates int
+	Width      int
+	Sizes      []int
+}
+
+// Webcam sets how a job's webcam recording is composited with its screen
+// capture: an inset Scale of the screen's width, Margin pixels from the
+// edges, or beside the screen at its height.
+type Webcam struct {
+	Scale  float64
+	Margin int
+}
+
+// Dedup controls copying the package of an identical input, encoded with
+// the same preset version, instead of encoding it again.
+type Dedup struct {
+	Enabled bool
+}
+
+// Artifacts controls storing each job's ffmpeg logs, source probe and QC and
+// verification reports as one gzipped tar under artifacts/.
+type Artifacts struct {
+	Enabled bool
+}
+
+// Ingest controls creating transcode jobs from files dropped into the
+// bucket under Prefix, as <preset>/<lesson id>/<file name>. The jobs get
+// SLAClass.
+type Ingest struct {
+	Enabled  bool
+	Prefix   string
+	SLAClass string
+}
+
+// Zoom imports the cloud recordings of tenants' Zoom meetings into the
+// lessons they are linked to, from their apps' webhooks and by listing them
+// every PollInterval minutes, 0 for webhooks only. The jobs get SLAClass;
+// Zoom's transcripts are indexed as captions in CaptionLanguage.
+type Zoom struct {
+	Enabled         bool
+	PollInterval    int
+	SLAClass        string
+	CaptionLanguage string
+}
+
+// Library imports tenants' videos hosted on Vimeo or YouTube into lessons,
+// as transcode jobs of SLAClass.
+type Library struct {
+	SLAClass string
+}
+
+// LMS takes webhooks from tenants' learning management systems, turning the
+// events their rules match into transcode jobs of SLAClass. Each event's
+// media is fetched within DownloadTimeout seconds.
+type LMS struct {
+	Enabled         bool
+	SLAClass        string
+	DownloadTimeout int
+}
+
+// Scan has each upload streamed from the bucket to the clamd at Address
+// before its job is processed, every file within Timeout seconds. An
+// infected file is moved under QuarantinePrefix and its job failed.
+type Scan struct {
+	Enabled          bool
+	Address          string
+	Timeout          int
+	QuarantinePrefix string
+}
+
+// Quota enforces the monthly encoding minutes of tenants whose config sets
+// a quota. With Policy reject a job of a tenant past its quota fails; with
+// warn it is processed anyway. Either way the billing service hears of it on
+// Exchange, as it does when a tenant's usage first reaches WarnPercent of
+// the quota.
+type Quota struct {
+	Policy      string
+	WarnPercent int
+	Exchange    string
+}
+
+// Billing writes a usage record of every completed transcode to the outbox
+// with the job's completion, which the leader relays to Exchange every
+// RelayInterval seconds.
+type Billing struct {
+	Enabled       bool
+	Exchange      string
+	RelayInterval int
+}
+
+// Results writes the result of every completed transcode to the outbox with
+// the job's completion, relayed to Exchange with the billing usage records.
+type Results struct {
+	Enabled  bool
+	Exchange string
+}
+
+// Workflow picks how transcode jobs run. With the queue engine, the
+// default, a consumer runs a job's whole pipeline within its delivery. With
+// temporal, the consumer starts a workflow per job on TaskQueue in Namespace
+// of the Temporal server at Address, and workers run its stages as
+// activities, each retried durably on its own. Its captions stage waits up to
+// CaptionWait hours for the lesson's captions, checking every CaptionPoll
+// minutes.
+type Workflow struct {
+	Engine      string
+	Address     string
+	Namespace   string
+	TaskQueue   string
+	CaptionWait int
+	CaptionPoll int
+}
+
+// Remote sends a transcode to an external provider when this worker can't
+// do it itself: the preset's codec has no encoder here, or the job waited
+// in the queue more than MaxQueueSeconds. Provider is mux, or empty to
+// never fall back; TokenId and TokenSecret are its access token. The
+// provider is polled every PollInterval seconds.
+type Remote struct {
+	Provider        string
+	TokenId         string
+	TokenSecret     string
+	MaxQueueSeconds int
+	PollInterval    int
+}
+
+// Trim controls cutting dead air off lesson uploads: silence of at least
+// MinDeadAir seconds at the start or end, such as waiting for attendees, is
+// cut down to Padding seconds. The untrimmed source is kept so the trim can
+// be undone.
+type Trim struct {
+	Enabled    bool
+	MinDeadAir int
+	Padding    int
+}
+
+// Downmix controls remixing lesson audio that isn't plain mono or stereo,
+// such as 5.1 camera uploads, to stereo before it is encoded, so players
+// that drop the center channel don't lose the dialogue on it. CenterLevel
+// and SurroundLevel are the gains in dB the center and surround channels
+// are mixed in at; the LFE is left out. The mix is brought back to the
+// loudness of the source.
+type Downmix struct {
+	Enabled       bool
+	CenterLevel   float64
+	SurroundLevel float64
+}
+
+// Search picks where lesson transcripts are indexed for searching inside the
+// videos of a course: the postgres backend keeps them in the platform database
+// with its full text search, elasticsearch sends them to Index on
+// ElasticsearchURL. With an ExportInterval, in minutes, lessons whose video,
+// captions or chapters changed are also exported to ContentIndex there, one
+// document per lesson, for searching across the platform.
+type Search struct {
+	Backend          string
+	ElasticsearchURL string
+	User             string
+	Pass             string
+	Index            string
+	ContentIndex     string
+	ExportInterval   int
+}
+
+// Watermark controls the per-student renditions made for forensic
+// watermarking. They are HLS of at most Height lines, deleted TTL seconds
+// after they are ready. Mode visible draws the student's code plainly,
+// invisible draws it faint enough to show only when a copy is examined.
+type Watermark struct {
+	TTL    int
+	Height int
+	Mode   string
+}
+
+// Download controls the offline MP4 made for the mobile app next to each
+// lesson's HLS package, at most Height lines. The app may keep a download for
+// OfflineDays, and the links it downloads from last LinkTTL seconds.
+type Download struct {
+	Enabled     bool
+	Height      int
+	OfflineDays int
+	LinkTTL     int
+}
+
+// Cache mirrors jobs' status and progress in Redis, for the instructor UI's
+// polling to read instead of Postgres. An entry lasts TTL seconds after its
+// last write, which bounds how stale a change made outside the job
+// repository leaves it; an encoding job's progress is mirrored every
+// ProgressInterval milliseconds.
+type Cache struct {
+	// RedisURL is where the job status cache is kept, empty for none.
+	RedisURL         string
+	TTL              int
+	ProgressInterval int
+}
+
+// Export sets how long the links content package exports are downloaded
+// from last, in seconds.
+type Export struct {
+	LinkTTL int
+}
+
+// Media sets what media jobs make of images and documents. An image is
+// resized to each of ImageWidths unless its job asks for its own. A document
+// is rendered to a thumbnail of its first page ThumbnailWidth wide and up to
+// PreviewPages preview pages PreviewWidth wide; office documents are
+// converted to PDF first with the LibreOffice binary at Soffice.
+type Media struct {
+	ImageWidths    []int
+	ThumbnailWidth int
+	PreviewWidth   int
+	PreviewPages   int
+	Soffice        string
+}
+
+// Live sets where live class recordings may be imported from: hosts of the
+// streaming origin, matched exactly or, starting with a dot, as a domain
+// suffix. A recording is pulled within Timeout seconds. A job packaged for
+// low latency, as a replay put up just after a class ends, is cut into
+// partial segments PartSeconds long.
+type Live struct {
+	OriginHosts []string
+	Timeout     int
+	PartSeconds float64
+}
+
+// Podcast turns on publishing courses as private podcasts, whose feeds are
+// served under BaseURL, the worker's public address. A student's feed URL is
+// signed with SigningKey; the episode URLs a feed lists are valid for
+// LinkTTL seconds, through the podcast app's next refresh.
+type Podcast struct {
+	Enabled    bool
+	BaseURL    string
+	SigningKey string
+	LinkTTL    int
+}
+
+// Keys turns on encrypting lesson packages with AES-128 content keys, which
+// players fetch from URL, the API's key endpoint, followed by the key's id.
+// Content keys are stored wrapped with the master key MasterKeyId, one of
+// MasterKeys; the others unwrap keys wrapped before the master key changed.
+type Keys struct {
+	Enabled     bool
+	URL         string
+	MasterKeyId string
+	MasterKeys  map[string][]byte
+}
+
+// BurnIn turns on making copies of lesson videos with a caption track drawn
+// into the picture, at most Height lines tall, for social and preview
+// channels that can't load WebVTT. Their links are valid for LinkTTL
+// seconds.
+type BurnIn struct {
+	Enabled bool
+	Height  int
+	LinkTTL int
+}
+
+// Migration runs codec migrations: every Interval seconds the leader queues
+// re-transcodes of the back catalog on the backfill lane, most played lessons
+// first, as many as the backfill workers have idle slots for and at most
+// MaxPerTick, less outside the Maintenance windows.
+type Migration struct {
+	Enabled    bool
+	Interval   int
+	MaxPerTick int
+}
+
+// Region keeps media processing in the region a tenant's storage is in, for
+// data residency. Jobs tied to a region are only run by the workers of that
+// region, which consume a queue of its own next to each shared one; a
+// worker elsewhere moves such a job to that queue rather than claim it.
+// Known lists the regions tenants may be tied to.
+type Region struct {
+	// Name is the region this worker runs in; empty for a worker that only
+	// runs the jobs tied to no region.
+	Name string
+	// Only leaves the shared queues to other workers, so this one runs the
+	// jobs of its region alone.
+	Only  bool
+	Known []string
+}
+
+// Warehouse exports the pipeline's history for the analytics warehouse: every
+// Interval minutes the leader writes the jobs that finished, their
+// renditions, QC flags and usage since the last export as Parquet files in
+// Bucket, partitioned by dataset and day.
+type Warehouse struct {
+	Enabled  bool
+	Bucket   string
+	Interval int
+}
+
+// PlaybackProbe samples published lesson videos the way students play them:
+// every Interval minutes the leader fetches the master playlists of Samples
+// random lessons, one of their variants and up to Segments of its segments,
+// from BaseURL, the CDN in front of the bucket, or from presigned bucket
+// URLs without one. A fetch that fails or takes longer than MaxLatency
+// milliseconds is alerted on.
+type PlaybackProbe struct {
+	Enabled    bool
+	BaseURL    string
+	Interval   int
+	Samples    int
+	Segments   int
+	MaxLatency int
+}
+
+// Reconcile turns on the media reconciliation: every Interval hours the
+// leader checks each lesson's video against its active version, the
+// renditions its job recorded and the bucket, BatchSize lessons at a time,
+// and alerts on the lessons whose media is missing or incomplete.
+type Reconcile struct {
+	Enabled   bool
+	Interval  int
+	BatchSize int
+}
+
+// Subscriptions turns on tenants' event subscriptions: every Interval
+// seconds the leader turns the jobs that finished and the courses that
+// became ready into deliveries to the subscriptions that want them. Each is
+// POSTed within Timeout seconds, and one that fails is retried, backing off
+// from Interval seconds to an hour, until it has been tried MaxAttempts
+// times.
+type Subscriptions struct {
+	Enabled     bool
+	Interval    int
+	Timeout     int
+	MaxAttempts int
+}
+
+// CDNSigning turns on granting enrolled students access to lesson packages
+// on the CDN at BaseURL, which serves only signed requests: Provider's
+// cookies or URL token, signed with Key, the key KeyId names at the CDN.
+// Grants are valid for TTL seconds, or the student's tenant's playback TTL.
+// Cookies are set for CookieDomain, a parent domain of the CDN's and the
+// site's.
+type CDNSigning struct {
+	Enabled      bool
+	Provider     string
+	BaseURL      string
+	KeyId        string
+	Key          []byte
+	CookieDomain string
+	TTL          int
+}
+
+// Course sets where a course is announced once its videos are all ready to
+// publish, and where the course catalog is told each lesson's media.
+type Course struct {
+	Exchange string
+}
+
+// Versions sets how long the package a lesson's video is replaced by keeps
+// the previous one, in Grace seconds, so the lesson can be rolled back.
+type Versions struct {
+	Grace int
+}
+
+// Deletion sets the grace window, in Grace seconds, a media deletion waits
+// before it runs unless its request sets one, during which it can be
+// cancelled.
+type Deletion struct {
+	Grace int
+}
+
+// Branding turns on compositing tenants' branding templates into their
+// lesson videos.
+type Branding struct {
+	Enabled bool
+}
+
+// Publish turns on sending finished lesson videos to the third party their
+// tenant delivers through, as set in its publish target.
+type Publish struct {
+	Enabled bool
+}
+
+// Translation machine-translates the captions sent for a lesson into each of
+// Languages through Provider, deepl or google, at URL when it isn't the
+// provider's public API.
+type Translation struct {
+	Enabled   bool
+	Provider  string
+	URL       string
+	APIKey    string
+	Languages []string
+}
+
+// TTS is the text-to-speech provider narrated lessons are spoken by, google
+// or openai, at URL when it isn't the provider's public API. Voice and
+// Language are used when a narration doesn't pick its own; Model is the
+// openai model.
+type TTS struct {
+	Provider string
+	URL      string
+	APIKey   string
+	Model    string
+	Voice    string
+	Language string
+}
+
+// Music flags lessons that likely contain copyrighted music for review. A
+// SampleLength second clip every SampleInterval seconds is looked up with
+// Provider, acrcloud or audd, and matches scoring at least MinScore are
+// flagged. APISecret is ACRCloud's access secret; URL is ACRCloud's project
+// host and optional for audd.
+type Music struct {
+	Enabled        bool
+	Provider       string
+	URL            string
+	APIKey         string
+	APISecret      string
+	SampleInterval int
+	SampleLength   int
+	MinScore       float64
+}
+
+// Diarization labels who speaks when in lesson videos, for the captions of
+// panel discussions and Q&A sessions. The audio is posted as 16 kHz mono
+// WAV to URL, a diarization service such as one running pyannote, which
+// tells apart at most MaxSpeakers, or as many as it hears when 0.
+type Diarization struct {
+	Enabled     bool
+	URL         string
+	APIKey      string
+	MaxSpeakers int
+}
+
+// Redaction flags the profanity and spoken personal details, email
+// addresses and phone numbers, in lesson transcripts as they're indexed,
+// for schools that must keep them from students. Words adds to the
+// built-in profanity list. With Bleep, the flagged words are masked in the
+// captions and a tone is played over them in the lesson's audio, Padding
+// seconds either side of where they're estimated to be said.
+type Redaction struct {
+	Enabled bool
+	Words   []string
+	Bleep   bool
+	Padding float64
+}
+
+// Fingerprint flags uploads that duplicate another lesson's video, such as
+// a cloned course or a copied lecture, for the content team to review. A
+// frame every FrameInterval seconds is hashed, and frames within
+// FrameDistance bits of each other are the same picture; the audio is
+// fingerprinted with Chromaprint. Lessons sharing at least MinSimilarity of
+// the shorter video's frames or audio are flagged.
+type Fingerprint struct {
+	Enabled       bool
+	FrameInterval int
+	FrameDistance int
+	MinSimilarity float64
+}
+
+// Accessibility sets how each lesson video is checked for the accessibility
+// report compliance teams audit courses with. Loudness complies within
+// LoudnessTolerance LU of LoudnessTarget LUFS, peaking at most MaxTruePeak
+// dBTP. Captions comply covering MinCaptionCoverage of the video. Text is
+// legible at 240p when frames sampled every SampleInterval seconds keep at
+// least MinLegibility SSIM of their detail scaled down to 240 lines.
+type Accessibility struct {
+	Enabled                 bool
+	SampleInterval          int
+	LoudnessTarget          float64
+	LoudnessTolerance       float64
+	MaxTruePeak             float64
+	MinCaptionCoverage      float64
+	MinLegibility           float64
+	RequireAudioDescription bool
+}
+
+// Quality sets how each encoded rendition is scored with VMAF against its
+// source, so preset changes can be compared by the quality they give.
+// Subsample scores every nth frame only. Model names the VMAF model, the
+// filter's default when empty.
+type Quality struct {
+	Enabled   bool
+	Subsample int
+	Model     string
+}
+
+// Report schedules the daily processing summary. It is written to the bucket
+// under reports/daily/ and emailed to Recipients when any are set.
+type Report struct {
+	Enabled bool
+	// Hour is the UTC hour at which the previous day is summarised.
+	Hour       int
+	Recipients []string
+}
+
+// Log selects the log level and output format; both can be changed at runtime
+// through the admin API. An empty level picks one from the environment.
+type Log struct {
+	Level  string
+	Format string
+}
+
+// Database is the Postgres database jobs and lessons are kept in.
+type Database struct {
+	URL string
+}
+
+// ObjectStore is the bucket endpoint MINIO_URL names and the credentials
+// the worker signs its requests with.
+type ObjectStore struct {
+	Endpoint     string
+	Secure       bool
+	Region       string
+	BucketLookup minio.BucketLookupType
+	AccessKey    string
+	SecretKey    string
 }
 
 type RabbitMQ struct {
@@ -40,75 +902,1579 @@ type RabbitMQ struct {
 	Pass         string
 	ExchangeName string
 	Kind         string
+	// DepthInterval is how often queue depth is sampled for metrics; zero
+	// disables sampling.
+	DepthInterval int
+	// LeaseAfter is how long, in seconds, a message may stay unacked before
+	// it is acked early and its claimed job's heartbeat holds it instead. Keep
+	// it below the broker's consumer_timeout; zero never acks early.
+	LeaseAfter int
+	// Retry is how a failed message is retried, unless RetryPolicies has a
+	// policy for the routing key of its queue.
+	Retry         RetryPolicy
+	RetryPolicies map[string]RetryPolicy
+}
+
+// RetryPolicy is how a kind of message is retried: Tries attempts in all,
+// waiting from InitialInterval seconds, doubling up to MaxInterval, between
+// them. Timeout, in seconds, takes the place of JOB_TIMEOUT as the base time
+// limit of a transcode taken from the queue; zero keeps JOB_TIMEOUT.
+type RetryPolicy struct {
+	Tries           int
+	InitialInterval float64
+	MaxInterval     float64
+	Timeout         int
 }
 
+// Load reads the settings from the environment, after filling it in from the
+// .env file at path when there is one.
 func Load(path string) (*Config, error) {
 	err := godotenv.Load(path)
-	if err != nil {
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		return nil, fmt.Errorf("error loading .env file from %s: %w", path, err)
 	}
 
-	psqlInfo := fmt.Sprintf("postgres://%s:%s@%s:%s/%s",
-		os.Getenv("POSTGRES_USER"),
-		os.Getenv("POSTGRES_PASSWORD"),
-		os.Getenv("DB_HOST"),
-		os.Getenv("DB_PORT"),
-		os.Getenv("POSTGRES_DB"),
-	)
-
-	db, err := sql.Open("postgres", psqlInfo)
+	rabbitmqPort, err := getEnvInt("RABBITMQ_PORT", 5672)
 	if err != nil {
 		return nil, err
 	}
 
-	rabbitmqPort, err := strconv.Atoi(os.Getenv("RABBITMQ_PORT"))
+	depthInterval, err := getEnvInt("RABBITMQ_DEPTH_INTERVAL", 15)
+	if err != nil {
+		return nil, err
+	}
+	leaseAfter, err := getEnvInt("RABBITMQ_LEASE_AFTER", 1200)
+	if err != nil {
+		return nil, err
+	}
+	retryTries, err := getEnvInt("RETRY_TRIES", 5)
+	if err != nil {
+		return nil, err
+	}
+	if retryTries < 1 {
+		return nil, errors.New("RETRY_TRIES must be at least 1")
+	}
+	retryInitialInterval, err := getEnvFloat("RETRY_INITIAL_INTERVAL", 0.5)
+	if err != nil {
+		return nil, err
+	}
+	retryMaxInterval, err := getEnvFloat("RETRY_MAX_INTERVAL", 10)
+	if err != nil {
+		return nil, err
+	}
+	retry := RetryPolicy{Tries: retryTries, InitialInterval: retryInitialInterval, MaxInterval: retryMaxInterval}
+	retryPolicies, err := getEnvRetryPolicies("RETRY_POLICIES", retry)
 	if err != nil {
 		return nil, err
 	}
 	rabbitmq := &RabbitMQ{
-		Host:         os.Getenv("RABBITMQ_HOST"),
-		Port:         rabbitmqPort,
-		User:         os.Getenv("RABBITMQ_USER"),
-		Pass:         os.Getenv("RABBITMQ_PASS"),
-		Kind:         os.Getenv("RABBITMQ_KIND"),
-		ExchangeName: os.Getenv("RABBITMQ_EXCHANGE_NAME"),
+		Host:          os.Getenv("RABBITMQ_HOST"),
+		Port:          rabbitmqPort,
+		User:          os.Getenv("RABBITMQ_USER"),
+		Pass:          os.Getenv("RABBITMQ_PASS"),
+		Kind:          os.Getenv("RABBITMQ_KIND"),
+		ExchangeName:  os.Getenv("RABBITMQ_EXCHANGE_NAME"),
+		DepthInterval: depthInterval,
+		LeaseAfter:    leaseAfter,
+		Retry:         retry,
+		RetryPolicies: retryPolicies,
+	}
+
+	minioSecure, err := getEnvBool("MINIO_USE_SSL", true)
+	if err != nil {
+		return nil, err
+	}
+	minioEndpoint, minioSecure, err := storageEndpoint(getEnv("MINIO_URL", "localhost:9000"), minioSecure)
+	if err != nil {
+		return nil, err
+	}
+	bucketLookup, ok := bucketLookups[getEnv("MINIO_BUCKET_LOOKUP", "auto")]
+	if !ok {
+		return nil, errors.New("MINIO_BUCKET_LOOKUP must be auto, path or virtual-host")
+	}
+
+	cacheTTL, err := getEnvInt("CACHE_TTL", 60)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheProgressInterval, err := getEnvInt("CACHE_PROGRESS_INTERVAL", 500)
+	if err != nil {
+		return nil, err
+	}
+
+	jobMemory, err := getEnvInt("WORKER_JOB_MEMORY_MB", 1536)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without SERVER_WORKERS the worker count and ffmpeg threads are sized
+	// to the container rather than the host it runs on.
+	limits := DetectLimits()
+	workers, err := getEnvInt("SERVER_WORKERS", limits.Workers(jobMemory))
+	if err != nil {
+		return nil, err
+	}
+
+	ffmpegThreads, err := getEnvInt("FFMPEG_THREADS", limits.Threads(workers))
+	if err != nil {
+		return nil, err
+	}
+
+	priorityWorkers, err := getEnvInt("SERVER_PRIORITY_WORKERS", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	backfillWorkers, err := getEnvInt("SERVER_BACKFILL_WORKERS", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	longWorkers, err := getEnvInt("SERVER_LONG_WORKERS", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	longJobSeconds, err := getEnvInt("WORKER_LONG_JOB_SECONDS", 3600)
+	if err != nil {
+		return nil, err
+	}
+
+	expressWorkers, err := getEnvInt("SERVER_EXPRESS_WORKERS", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	expressJobSeconds, err := getEnvInt("WORKER_EXPRESS_JOB_SECONDS", 300)
+	if err != nil {
+		return nil, err
+	}
+	if expressJobSeconds > 0 && longJobSeconds > 0 && expressJobSeconds > longJobSeconds {
+		return nil, errors.New("WORKER_EXPRESS_JOB_SECONDS can't be above WORKER_LONG_JOB_SECONDS")
+	}
+
+	watermarkWorkers, err := getEnvInt("SERVER_WATERMARK_WORKERS", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	translationWorkers, err := getEnvInt("SERVER_TRANSLATION_WORKERS", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	narrationWorkers, err := getEnvInt("SERVER_NARRATION_WORKERS", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	exportWorkers, err := getEnvInt("SERVER_EXPORT_WORKERS", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaWorkers, err := getEnvInt("SERVER_MEDIA_WORKERS", 2)
+	if err != nil {
+		return nil, err
+	}
+
+	liveWorkers, err := getEnvInt("SERVER_LIVE_WORKERS", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	podcastWorkers, err := getEnvInt("SERVER_PODCAST_WORKERS", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	keyWorkers, err := getEnvInt("SERVER_KEY_WORKERS", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	burnInWorkers, err := getEnvInt("SERVER_BURN_IN_WORKERS", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	audioWorkers, err := getEnvInt("SERVER_AUDIO_WORKERS", 1)
+	if err != nil {
+		return nil, err
 	}
 
-	transport, err := minio.DefaultTransport(true)
+	regenerationWorkers, err := getEnvInt("SERVER_REGENERATION_WORKERS", 1)
 	if err != nil {
 		return nil, err
 	}
-	if transport.TLSClientConfig != nil {
-		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+
+	deletionWorkers, err := getEnvInt("SERVER_DELETION_WORKERS", 1)
+	if err != nil {
+		return nil, err
 	}
 
-	minioClient, err := minio.New(os.Getenv("MINIO_URL"), &minio.Options{
-		Creds:     credentials.NewStaticV4(os.Getenv("MINIO_ROOT_USER"), os.Getenv("MINIO_ROOT_PASSWORD"), ""),
-		Secure:    true,
-		Transport: transport,
+	bindings, err := getEnvBindings("QUEUE_BINDINGS", []Binding{
+		{Name: "transcode", Concurrency: workers},
+		{Name: "priority", Concurrency: priorityWorkers},
+		{Name: "backfill", Concurrency: backfillWorkers},
+		{Name: "long", Concurrency: longWorkers},
+		{Name: "express", Concurrency: expressWorkers},
+		{Name: "recording", Concurrency: workers},
+		{Name: "watermark", Concurrency: watermarkWorkers},
+		{Name: "translation", Concurrency: translationWorkers},
+		{Name: "narration", Concurrency: narrationWorkers},
+		{Name: "export", Concurrency: exportWorkers},
+		{Name: "media", Concurrency: mediaWorkers},
+		{Name: "live", Concurrency: liveWorkers},
+		{Name: "podcast", Concurrency: podcastWorkers},
+		{Name: "keys", Concurrency: keyWorkers},
+		{Name: "burnin", Concurrency: burnInWorkers},
+		{Name: "audio", Concurrency: audioWorkers},
+		{Name: "regenerate", Concurrency: regenerationWorkers},
+		{Name: "delete", Concurrency: deletionWorkers},
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	workers, err := strconv.Atoi(os.Getenv("SERVER_WORKERS"))
+	maxUploadSize, err := getEnvInt("UPLOAD_MAX_SIZE", 10<<30)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Config{
-		MinIOBucket: os.Getenv("MINIO_BUCKET"),
-		App: App{
+	adminEnabled, err := getEnvBool("ADMIN_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+
+	scalerEnabled, err := getEnvBool("SCALER_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+
+	breakerThreshold, err := getEnvInt("BREAKER_THRESHOLD", 5)
+	if err != nil {
+		return nil, err
+	}
+
+	breakerCooldown, err := getEnvInt("BREAKER_COOLDOWN", 30)
+	if err != nil {
+		return nil, err
+	}
+
+	chaosEnabled, err := getEnvBool("CHAOS_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+	if chaosEnabled && os.Getenv("APP_ENVIRONMENT") == "production" {
+		return nil, errors.New("CHAOS_ENABLED is refused in production")
+	}
+	chaosRates := map[string]float64{}
+	for _, name := range []string{"CHAOS_STORAGE_ERROR_RATE", "CHAOS_FFMPEG_KILL_RATE", "CHAOS_DB_LATENCY_RATE"} {
+		rate, err := getEnvFloat(name, 0)
+		if err != nil {
+			return nil, err
+		}
+		if rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("%s must be between 0 and 1", name)
+		}
+		chaosRates[name] = rate
+	}
+	chaosKillAfter, err := getEnvInt("CHAOS_FFMPEG_KILL_AFTER", 30)
+	if err != nil {
+		return nil, err
+	}
+	chaosLatency, err := getEnvInt("CHAOS_DB_LATENCY", 2000)
+	if err != nil {
+		return nil, err
+	}
+
+	loadMaxCPU, err := getEnvFloat("LOAD_MAX_CPU", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	loadMaxPerCPU, err := getEnvFloat("LOAD_MAX_PER_CPU", 2)
+	if err != nil {
+		return nil, err
+	}
+
+	loadMaxDisk, err := getEnvFloat("LOAD_MAX_DISK", 90)
+	if err != nil {
+		return nil, err
+	}
+
+	loadMaxFFmpeg, err := getEnvInt("LOAD_MAX_FFMPEG", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	loadInterval, err := getEnvInt("LOAD_INTERVAL", 5)
+	if err != nil {
+		return nil, err
+	}
+
+	enterpriseWeight, err := getEnvInt("SLA_WEIGHT_ENTERPRISE", 6)
+	if err != nil {
+		return nil, err
+	}
+
+	proWeight, err := getEnvInt("SLA_WEIGHT_PRO", 3)
+	if err != nil {
+		return nil, err
+	}
+
+	freeWeight, err := getEnvInt("SLA_WEIGHT_FREE", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	canaryWindow, err := getEnvInt("CANARY_WINDOW", 7*24*3600)
+	if err != nil {
+		return nil, err
+	}
+
+	canaryMinJobs, err := getEnvInt("CANARY_MIN_JOBS", 20)
+	if err != nil {
+		return nil, err
+	}
+
+	canaryMaxFailureIncrease, err := getEnvFloat("CANARY_MAX_FAILURE_INCREASE", 0.02)
+	if err != nil {
+		return nil, err
+	}
+
+	chaptersEnabled, err := getEnvBool("CHAPTERS_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+
+	chaptersMinDuration, err := getEnvInt("CHAPTERS_MIN_DURATION", 1200)
+	if err != nil {
+		return nil, err
+	}
+
+	chaptersMinSilence, err := getEnvInt("CHAPTERS_MIN_SILENCE", 2)
+	if err != nil {
+		return nil, err
+	}
+
+	chaptersSceneThreshold, err := getEnvFloat("CHAPTERS_SCENE_THRESHOLD", 0.3)
+	if err != nil {
+		return nil, err
+	}
+
+	chaptersMinLength, err := getEnvInt("CHAPTERS_MIN_LENGTH", 180)
+	if err != nil {
+		return nil, err
+	}
+
+	slidesEnabled, err := getEnvBool("SLIDES_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+
+	slidesSceneThreshold, err := getEnvFloat("SLIDES_SCENE_THRESHOLD", 0.1)
+	if err != nil {
+		return nil, err
+	}
+
+	slidesDedupDistance, err := getEnvInt("SLIDES_DEDUP_DISTANCE", 6)
+	if err != nil {
+		return nil, err
+	}
+
+	slidesMax, err := getEnvInt("SLIDES_MAX", 300)
+	if err != nil {
+		return nil, err
+	}
+
+	previewEnabled, err := getEnvBool("PREVIEW_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+
+	previewSeconds, err := getEnvInt("PREVIEW_SECONDS", 4)
+	if err != nil {
+		return nil, err
+	}
+
+	previewHeight, err := getEnvInt("PREVIEW_HEIGHT", 180)
+	if err != nil {
+		return nil, err
+	}
+
+	previewFPS, err := getEnvInt("PREVIEW_FPS", 10)
+	if err != nil {
+		return nil, err
+	}
+
+	posterEnabled, err := getEnvBool("POSTER_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+	posterSamples, err := getEnvInt("POSTER_SAMPLES", 12)
+	if err != nil {
+		return nil, err
+	}
+	posterCandidates, err := getEnvInt("POSTER_CANDIDATES", 4)
+	if err != nil {
+		return nil, err
+	}
+	posterWidth, err := getEnvInt("POSTER_WIDTH", 1280)
+	if err != nil {
+		return nil, err
+	}
+	if posterEnabled && (posterCandidates < 1 || posterSamples < posterCandidates || posterWidth < 2) {
+		return nil, errors.New("POSTER_CANDIDATES and POSTER_WIDTH must be positive and POSTER_SAMPLES at least POSTER_CANDIDATES")
+	}
+	posterSizes, err := getEnvInts("POSTER_SIZES", []int{640, 320})
+	if err != nil {
+		return nil, err
+	}
+	for _, size := range posterSizes {
+		if size < 2 || size >= posterWidth {
+			return nil, fmt.Errorf("POSTER_SIZES: %d must be narrower than POSTER_WIDTH %d", size, posterWidth)
+		}
+	}
+
+	webcamScale, err := getEnvFloat("WEBCAM_SCALE", 0.25)
+	if err != nil {
+		return nil, err
+	}
+
+	webcamMargin, err := getEnvInt("WEBCAM_MARGIN", 24)
+	if err != nil {
+		return nil, err
+	}
+
+	dedupEnabled, err := getEnvBool("DEDUP_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+
+	artifactsEnabled, err := getEnvBool("ARTIFACTS_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+
+	ingestEnabled, err := getEnvBool("INGEST_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+
+	zoomEnabled, err := getEnvBool("ZOOM_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+
+	zoomPollInterval, err := getEnvInt("ZOOM_POLL_INTERVAL", 15)
+	if err != nil {
+		return nil, err
+	}
+
+	lmsEnabled, err := getEnvBool("LMS_WEBHOOK_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+
+	lmsDownloadTimeout, err := getEnvInt("LMS_DOWNLOAD_TIMEOUT", 3600)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteMaxQueueSeconds, err := getEnvInt("REMOTE_MAX_QUEUE_SECONDS", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	remotePollInterval, err := getEnvInt("REMOTE_POLL_INTERVAL", 10)
+	if err != nil {
+		return nil, err
+	}
+
+	trimEnabled, err := getEnvBool("TRIM_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+
+	trimMinDeadAir, err := getEnvInt("TRIM_MIN_DEAD_AIR", 60)
+	if err != nil {
+		return nil, err
+	}
+
+	trimPadding, err := getEnvInt("TRIM_PADDING", 2)
+	if err != nil {
+		return nil, err
+	}
+
+	downmixEnabled, err := getEnvBool("DOWNMIX_ENABLED", true)
+	if err != nil {
+		return nil, err
+	}
+
+	downmixCenterLevel, err := getEnvFloat("DOWNMIX_CENTER_LEVEL", -3)
+	if err != nil {
+		return nil, err
+	}
+
+	downmixSurroundLevel, err := getEnvFloat("DOWNMIX_SURROUND_LEVEL", -3)
+	if err != nil {
+		return nil, err
+	}
+	if downmixCenterLevel > 12 || downmixSurroundLevel > 12 {
+		return nil, errors.New("DOWNMIX_CENTER_LEVEL and DOWNMIX_SURROUND_LEVEL must be at most 12 dB")
+	}
+
+	watermarkTTL, err := getEnvInt("WATERMARK_TTL", 86400)
+	if err != nil {
+		return nil, err
+	}
+
+	watermarkHeight, err := getEnvInt("WATERMARK_HEIGHT", 720)
+	if err != nil {
+		return nil, err
+	}
+
+	translationEnabled, err := getEnvBool("TRANSLATION_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+
+	musicEnabled, err := getEnvBool("MUSIC_CHECK_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+
+	musicSampleInterval, err := getEnvInt("MUSIC_SAMPLE_INTERVAL", 60)
+	if err != nil {
+		return nil, err
+	}
+
+	musicSampleLength, err := getEnvInt("MUSIC_SAMPLE_LENGTH", 12)
+	if err != nil {
+		return nil, err
+	}
+
+	musicMinScore, err := getEnvFloat("MUSIC_MIN_SCORE", 70)
+	if err != nil {
+		return nil, err
+	}
+
+	diarizationEnabled, err := getEnvBool("DIARIZATION_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+	diarizationMaxSpeakers, err := getEnvInt("DIARIZATION_MAX_SPEAKERS", 0)
+	if err != nil {
+		return nil, err
+	}
+	if diarizationMaxSpeakers < 0 {
+		return nil, errors.New("DIARIZATION_MAX_SPEAKERS must not be negative")
+	}
+	if diarizationEnabled && os.Getenv("DIARIZATION_URL") == "" {
+		return nil, errors.New("DIARIZATION_URL is required when DIARIZATION_ENABLED is set")
+	}
+
+	redactionEnabled, err := getEnvBool("REDACTION_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+	redactionBleep, err := getEnvBool("REDACTION_BLEEP", false)
+	if err != nil {
+		return nil, err
+	}
+	redactionPadding, err := getEnvFloat("REDACTION_PADDING", 0.25)
+	if err != nil {
+		return nil, err
+	}
+	if redactionPadding < 0 {
+		return nil, errors.New("REDACTION_PADDING must not be negative")
+	}
+
+	fingerprintEnabled, err := getEnvBool("FINGERPRINT_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprintFrameInterval, err := getEnvInt("FINGERPRINT_FRAME_INTERVAL", 2)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprintFrameDistance, err := getEnvInt("FINGERPRINT_FRAME_DISTANCE", 6)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprintMinSimilarity, err := getEnvFloat("FINGERPRINT_MIN_SIMILARITY", 0.8)
+	if err != nil {
+		return nil, err
+	}
+	if fingerprintMinSimilarity <= 0 || fingerprintMinSimilarity > 1 {
+		return nil, errors.New("FINGERPRINT_MIN_SIMILARITY must be above 0 and at most 1")
+	}
+
+	accessibilityEnabled, err := getEnvBool("ACCESSIBILITY_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+
+	accessibilitySampleInterval, err := getEnvInt("ACCESSIBILITY_SAMPLE_INTERVAL", 10)
+	if err != nil {
+		return nil, err
+	}
+
+	loudnessTarget, err := getEnvFloat("ACCESSIBILITY_LOUDNESS_TARGET", -16)
+	if err != nil {
+		return nil, err
+	}
+
+	loudnessTolerance, err := getEnvFloat("ACCESSIBILITY_LOUDNESS_TOLERANCE", 2)
+	if err != nil {
+		return nil, err
+	}
+
+	maxTruePeak, err := getEnvFloat("ACCESSIBILITY_MAX_TRUE_PEAK", -1)
+	if err != nil {
+		return nil, err
+	}
+
+	minCaptionCoverage, err := getEnvFloat("ACCESSIBILITY_MIN_CAPTION_COVERAGE", 0.9)
+	if err != nil {
+		return nil, err
+	}
+
+	minLegibility, err := getEnvFloat("ACCESSIBILITY_MIN_LEGIBILITY", 0.85)
+	if err != nil {
+		return nil, err
+	}
+
+	requireAudioDescription, err := getEnvBool("ACCESSIBILITY_REQUIRE_AUDIO_DESCRIPTION", false)
+	if err != nil {
+		return nil, err
+	}
+
+	qualityEnabled, err := getEnvBool("QUALITY_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+
+	qualitySubsample, err := getEnvInt("QUALITY_SUBSAMPLE", 5)
+	if err != nil {
+		return nil, err
+	}
+
+	brandingEnabled, err := getEnvBool("BRANDING_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+
+	publishEnabled, err := getEnvBool("PUBLISH_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+
+	versionGrace, err := getEnvInt("VIDEO_VERSION_GRACE", 7*24*3600)
+	if err != nil {
+		return nil, err
+	}
+
+	deletionGrace, err := getEnvInt("MEDIA_DELETION_GRACE", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	downloadEnabled, err := getEnvBool("DOWNLOAD_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+
+	downloadHeight, err := getEnvInt("DOWNLOAD_HEIGHT", 480)
+	if err != nil {
+		return nil, err
+	}
+
+	downloadOfflineDays, err := getEnvInt("DOWNLOAD_OFFLINE_DAYS", 30)
+	if err != nil {
+		return nil, err
+	}
+
+	downloadLinkTTL, err := getEnvInt("DOWNLOAD_LINK_TTL", 3600)
+	if err != nil {
+		return nil, err
+	}
+
+	exportLinkTTL, err := getEnvInt("EXPORT_LINK_TTL", 3600)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaImageWidths, err := getEnvInts("MEDIA_IMAGE_WIDTHS", []int{320, 640, 1280})
+	if err != nil {
+		return nil, err
+	}
+
+	mediaThumbnailWidth, err := getEnvInt("MEDIA_THUMBNAIL_WIDTH", 320)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaPreviewWidth, err := getEnvInt("MEDIA_PREVIEW_WIDTH", 1280)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaPreviewPages, err := getEnvInt("MEDIA_PREVIEW_PAGES", 10)
+	if err != nil {
+		return nil, err
+	}
+	if mediaThumbnailWidth <= 0 || mediaPreviewWidth <= 0 || mediaPreviewPages <= 0 {
+		return nil, errors.New("MEDIA_THUMBNAIL_WIDTH, MEDIA_PREVIEW_WIDTH and MEDIA_PREVIEW_PAGES must be positive")
+	}
+
+	liveTimeout, err := getEnvInt("LIVE_IMPORT_TIMEOUT", 14400)
+	if err != nil {
+		return nil, err
+	}
+	livePartSeconds, err := getEnvFloat("LIVE_PART_SECONDS", 1)
+	if err != nil {
+		return nil, err
+	}
+	if livePartSeconds < 0.2 || livePartSeconds > 2 {
+		return nil, errors.New("LIVE_PART_SECONDS must be between 0.2 and 2")
+	}
+
+	podcastEnabled, err := getEnvBool("PODCAST_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+	if podcastEnabled && (os.Getenv("PODCAST_SIGNING_KEY") == "" || os.Getenv("PODCAST_BASE_URL") == "") {
+		return nil, errors.New("PODCAST_ENABLED needs PODCAST_SIGNING_KEY and PODCAST_BASE_URL")
+	}
+	podcastLinkTTL, err := getEnvInt("PODCAST_LINK_TTL", 86400)
+	if err != nil {
+		return nil, err
+	}
+
+	keysEnabled, err := getEnvBool("KEYS_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+	masterKeys, err := getEnvKeys("KEYS_MASTER_KEYS")
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := masterKeys[os.Getenv("KEYS_MASTER_KEY_ID")]; keysEnabled && (!ok || os.Getenv("KEYS_URL") == "") {
+		return nil, errors.New("KEYS_ENABLED needs KEYS_URL and KEYS_MASTER_KEY_ID naming one of KEYS_MASTER_KEYS")
+	}
+
+	burnInEnabled, err := getEnvBool("BURN_IN_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+	burnInHeight, err := getEnvInt("BURN_IN_HEIGHT", 720)
+	if err != nil {
+		return nil, err
+	}
+	if burnInHeight < 144 {
+		return nil, errors.New("BURN_IN_HEIGHT must be at least 144")
+	}
+	burnInLinkTTL, err := getEnvInt("BURN_IN_LINK_TTL", 3600)
+	if err != nil {
+		return nil, err
+	}
+
+	migrationEnabled, err := getEnvBool("MIGRATION_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+	migrationInterval, err := getEnvInt("MIGRATION_INTERVAL", 300)
+	if err != nil {
+		return nil, err
+	}
+	migrationMaxPerTick, err := getEnvInt("MIGRATION_MAX_PER_TICK", 20)
+	if err != nil {
+		return nil, err
+	}
+	if migrationEnabled && (migrationInterval < 1 || migrationMaxPerTick < 1) {
+		return nil, errors.New("MIGRATION_INTERVAL and MIGRATION_MAX_PER_TICK must be positive")
+	}
+
+	maintenanceWindows, err := getEnvWindows("MAINTENANCE_WINDOWS")
+	if err != nil {
+		return nil, err
+	}
+	maintenanceShare, err := getEnvFloat("MAINTENANCE_THROTTLE_SHARE", 0.25)
+	if err != nil {
+		return nil, err
+	}
+	if maintenanceShare <= 0 || maintenanceShare > 1 {
+		return nil, errors.New("MAINTENANCE_THROTTLE_SHARE must be above 0 and at most 1")
+	}
+	regionOnly, err := getEnvBool("WORKER_REGION_ONLY", false)
+	if err != nil {
+		return nil, err
+	}
+	region := Region{Name: os.Getenv("WORKER_REGION"), Only: regionOnly, Known: getEnvList("REGIONS")}
+	if region.Only && region.Name == "" {
+		return nil, errors.New("WORKER_REGION_ONLY needs WORKER_REGION")
+	}
+	if region.Name != "" && !slices.Contains(region.Known, region.Name) {
+		return nil, fmt.Errorf("WORKER_REGION %q is not one of REGIONS", region.Name)
+	}
+
+	maintenance := Maintenance{Region: getEnv("MAINTENANCE_REGION", region.Name), Windows: maintenanceWindows, Share: maintenanceShare}
+	if maintenance.Region != "" && len(maintenanceWindows) > 0 && !slices.ContainsFunc(maintenanceWindows, func(window MaintenanceWindow) bool {
+		return window.Region == maintenance.Region
+	}) {
+		return nil, fmt.Errorf("MAINTENANCE_REGION %q has no MAINTENANCE_WINDOWS", maintenance.Region)
+	}
+
+	warehouseEnabled, err := getEnvBool("WAREHOUSE_EXPORT_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+	warehouseInterval, err := getEnvInt("WAREHOUSE_EXPORT_INTERVAL", 60)
+	if err != nil {
+		return nil, err
+	}
+	if warehouseEnabled && (os.Getenv("WAREHOUSE_BUCKET") == "" || warehouseInterval < 1) {
+		return nil, errors.New("WAREHOUSE_EXPORT_ENABLED needs WAREHOUSE_BUCKET and a positive WAREHOUSE_EXPORT_INTERVAL")
+	}
+
+	playbackProbeEnabled, err := getEnvBool("PLAYBACK_PROBE_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+	playbackProbeInterval, err := getEnvInt("PLAYBACK_PROBE_INTERVAL", 5)
+	if err != nil {
+		return nil, err
+	}
+	playbackProbeSamples, err := getEnvInt("PLAYBACK_PROBE_SAMPLES", 10)
+	if err != nil {
+		return nil, err
+	}
+	playbackProbeSegments, err := getEnvInt("PLAYBACK_PROBE_SEGMENTS", 3)
+	if err != nil {
+		return nil, err
+	}
+	playbackProbeMaxLatency, err := getEnvInt("PLAYBACK_PROBE_MAX_LATENCY", 2000)
+	if err != nil {
+		return nil, err
+	}
+	if playbackProbeEnabled && (playbackProbeInterval < 1 || playbackProbeSamples < 1) {
+		return nil, errors.New("PLAYBACK_PROBE_ENABLED needs a positive PLAYBACK_PROBE_INTERVAL and PLAYBACK_PROBE_SAMPLES")
+	}
+
+	reconcileEnabled, err := getEnvBool("RECONCILE_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+	reconcileInterval, err := getEnvInt("RECONCILE_INTERVAL", 24)
+	if err != nil {
+		return nil, err
+	}
+	reconcileBatchSize, err := getEnvInt("RECONCILE_BATCH_SIZE", 200)
+	if err != nil {
+		return nil, err
+	}
+	if reconcileEnabled && (reconcileInterval < 1 || reconcileBatchSize < 1) {
+		return nil, errors.New("RECONCILE_ENABLED needs a positive RECONCILE_INTERVAL and RECONCILE_BATCH_SIZE")
+	}
+
+	subscriptionsEnabled, err := getEnvBool("SUBSCRIPTIONS_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+	subscriptionsInterval, err := getEnvInt("SUBSCRIPTIONS_INTERVAL", 10)
+	if err != nil {
+		return nil, err
+	}
+	subscriptionsTimeout, err := getEnvInt("SUBSCRIPTIONS_TIMEOUT", 10)
+	if err != nil {
+		return nil, err
+	}
+	subscriptionsMaxAttempts, err := getEnvInt("SUBSCRIPTIONS_MAX_ATTEMPTS", 10)
+	if err != nil {
+		return nil, err
+	}
+	if subscriptionsEnabled && (subscriptionsInterval < 1 || subscriptionsTimeout < 1 || subscriptionsMaxAttempts < 1) {
+		return nil, errors.New("SUBSCRIPTIONS_ENABLED needs a positive SUBSCRIPTIONS_INTERVAL, SUBSCRIPTIONS_TIMEOUT and SUBSCRIPTIONS_MAX_ATTEMPTS")
+	}
+
+	cdnSigningEnabled, err := getEnvBool("CDN_SIGNING_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+	cdnSigningProvider := getEnv("CDN_SIGNING_PROVIDER", "cloudfront")
+	if cdnSigningProvider != "cloudfront" && cdnSigningProvider != "cloudflare" {
+		return nil, errors.New("CDN_SIGNING_PROVIDER must be cloudfront or cloudflare")
+	}
+	cdnSigningTTL, err := getEnvInt("CDN_SIGNING_TTL", 3600)
+	if err != nil {
+		return nil, err
+	}
+	if cdnSigningTTL < 60 {
+		return nil, errors.New("CDN_SIGNING_TTL must be at least 60")
+	}
+	var cdnSigningKey []byte
+	if cdnSigningEnabled {
+		if os.Getenv("PLAYBACK_BASE_URL") == "" || os.Getenv("CDN_SIGNING_KEY_ID") == "" || os.Getenv("CDN_SIGNING_KEY_FILE") == "" {
+			return nil, errors.New("CDN_SIGNING_ENABLED needs PLAYBACK_BASE_URL, CDN_SIGNING_KEY_ID and CDN_SIGNING_KEY_FILE")
+		}
+		if cdnSigningKey, err = os.ReadFile(os.Getenv("CDN_SIGNING_KEY_FILE")); err != nil {
+			return nil, fmt.Errorf("CDN_SIGNING_KEY_FILE: %w", err)
+		}
+	}
+
+	dryRun, err := getEnvBool("WORKER_DRY_RUN", false)
+	if err != nil {
+		return nil, err
+	}
+
+	verifyOutput, err := getEnvBool("WORKER_VERIFY_OUTPUT", false)
+	if err != nil {
+		return nil, err
+	}
+
+	streamUpload, err := getEnvBool("WORKER_STREAM_UPLOAD", true)
+	if err != nil {
+		return nil, err
+	}
+
+	streamCopy, err := getEnvBool("WORKER_STREAM_COPY", true)
+	if err != nil {
+		return nil, err
+	}
+
+	followUps, err := getEnvBool("WORKER_FOLLOW_UPS", false)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaEngines := getEnvList("WORKER_MEDIA_ENGINES")
+	if len(mediaEngines) == 0 {
+		mediaEngines = []string{"ffmpeg"}
+	}
+	for _, engine := range mediaEngines {
+		if !slices.Contains(knownMediaEngines, engine) {
+			return nil, fmt.Errorf("WORKER_MEDIA_ENGINES: unknown media engine %q", engine)
+		}
+	}
+
+	heartbeatInterval, err := getEnvInt("WORKER_HEARTBEAT_INTERVAL", 15)
+	if err != nil {
+		return nil, err
+	}
+
+	heartbeatTimeout, err := getEnvInt("WORKER_HEARTBEAT_TIMEOUT", 120)
+	if err != nil {
+		return nil, err
+	}
+
+	jobHeartbeatInterval, err := getEnvInt("JOB_HEARTBEAT_INTERVAL", 10)
+	if err != nil {
+		return nil, err
+	}
+	if jobHeartbeatInterval < 1 {
+		return nil, errors.New("JOB_HEARTBEAT_INTERVAL must be positive")
+	}
+
+	shutdownGrace, err := getEnvInt("WORKER_SHUTDOWN_GRACE", 25)
+	if err != nil {
+		return nil, err
+	}
+
+	jobTimeout, err := getEnvInt("WORKER_JOB_TIMEOUT", 1800)
+	if err != nil {
+		return nil, err
+	}
+
+	jobTimeoutFactor, err := getEnvFloat("WORKER_JOB_TIMEOUT_FACTOR", 4)
+	if err != nil {
+		return nil, err
+	}
+
+	scratchFactor, err := getEnvFloat("WORKER_SCRATCH_FACTOR", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	minFreeMemory, err := getEnvInt("WORKER_MIN_FREE_MEMORY_MB", 512)
+	if err != nil {
+		return nil, err
+	}
+
+	preflightDelay, err := getEnvInt("WORKER_PREFLIGHT_DELAY", 30)
+	if err != nil {
+		return nil, err
+	}
+
+	writeBatchInterval, err := getEnvInt("WORKER_WRITE_BATCH_INTERVAL", 1000)
+	if err != nil {
+		return nil, err
+	}
+
+	writeBatchSize, err := getEnvInt("WORKER_WRITE_BATCH_SIZE", 200)
+	if err != nil {
+		return nil, err
+	}
+
+	tracingEnabled, err := getEnvBool("TRACING_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+
+	sampleRatio, err := getEnvFloat("TRACING_SAMPLE_RATIO", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	sentrySampleRate, err := getEnvFloat("SENTRY_SAMPLE_RATE", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	failureRate, err := getEnvFloat("ALERT_FAILURE_RATE", 0.5)
+	if err != nil {
+		return nil, err
+	}
+
+	failureWindow, err := getEnvInt("ALERT_FAILURE_WINDOW", 900)
+	if err != nil {
+		return nil, err
+	}
+
+	failureMinSamples, err := getEnvInt("ALERT_FAILURE_MIN_SAMPLES", 10)
+	if err != nil {
+		return nil, err
+	}
+
+	dlqThreshold, err := getEnvInt("ALERT_DLQ_THRESHOLD", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	priorityThreshold, err := getEnvInt("ALERT_PRIORITY_THRESHOLD", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	dedupWindow, err := getEnvInt("ALERT_DEDUP_WINDOW", 1800)
+	if err != nil {
+		return nil, err
+	}
+
+	smtpPort, err := getEnvInt("SMTP_PORT", 587)
+	if err != nil {
+		return nil, err
+	}
+
+	videoReadyEmail, err := getEnvBool("NOTIFY_VIDEO_READY_EMAIL", false)
+	if err != nil {
+		return nil, err
+	}
+
+	analyticsEnabled, err := getEnvBool("ANALYTICS_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+
+	reportEnabled, err := getEnvBool("REPORT_DAILY_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+
+	searchExportInterval, err := getEnvInt("SEARCH_EXPORT_INTERVAL", 0)
+	if err != nil {
+		return nil, err
+	}
+	if searchExportInterval > 0 && os.Getenv("SEARCH_ELASTICSEARCH_URL") == "" {
+		return nil, errors.New("SEARCH_EXPORT_INTERVAL needs SEARCH_ELASTICSEARCH_URL")
+	}
+
+	scanEnabled, err := getEnvBool("SCAN_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+
+	scanTimeout, err := getEnvInt("SCAN_TIMEOUT", 300)
+	if err != nil {
+		return nil, err
+	}
+
+	quotaPolicy := getEnv("QUOTA_POLICY", "reject")
+	if quotaPolicy != "reject" && quotaPolicy != "warn" {
+		return nil, fmt.Errorf("QUOTA_POLICY: unknown policy %q", quotaPolicy)
+	}
+
+	quotaWarnPercent, err := getEnvInt("QUOTA_WARN_PERCENT", 80)
+	if err != nil {
+		return nil, err
+	}
+
+	billingEnabled, err := getEnvBool("BILLING_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+
+	billingRelayInterval, err := getEnvInt("BILLING_RELAY_INTERVAL", 5)
+	if err != nil {
+		return nil, err
+	}
+	if billingRelayInterval <= 0 {
+		return nil, errors.New("BILLING_RELAY_INTERVAL must be positive")
+	}
+
+	resultsEnabled, err := getEnvBool("RESULTS_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+
+	workflowEngine := getEnv("WORKFLOW_ENGINE", "queue")
+	if workflowEngine != "queue" && workflowEngine != "temporal" {
+		return nil, fmt.Errorf("WORKFLOW_ENGINE: unknown engine %q", workflowEngine)
+	}
+
+	workflowCaptionWait, err := getEnvInt("WORKFLOW_CAPTION_WAIT", 48)
+	if err != nil {
+		return nil, err
+	}
+
+	workflowCaptionPoll, err := getEnvInt("WORKFLOW_CAPTION_POLL", 15)
+	if err != nil {
+		return nil, err
+	}
+	if workflowCaptionPoll <= 0 {
+		return nil, errors.New("WORKFLOW_CAPTION_POLL must be positive")
+	}
+
+	reportHour, err := getEnvInt("REPORT_DAILY_HOUR", 6)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Config{
+		MinIOBucket: os.Getenv("MINIO_BUCKET"),
+		App: App{
 			Environment: os.Getenv("APP_ENVIRONMENT"),
 			Host:        os.Getenv("APP_HOST"),
 			Protocol:    os.Getenv("APP_PROTOCOL"),
 		},
+		FFmpeg: FFmpeg{
+			Path:          getEnv("FFMPEG_PATH", "ffmpeg"),
+			ProbePath:     getEnv("FFPROBE_PATH", "ffprobe"),
+			GlobalArgs:    strings.Fields(os.Getenv("FFMPEG_GLOBAL_ARGS")),
+			LogLevel:      os.Getenv("FFMPEG_LOGLEVEL"),
+			HWAccelDevice: os.Getenv("FFMPEG_HWACCEL_DEVICE"),
+		},
 		Server: Server{
-			HttpPort: os.Getenv("WORKER_SERVER_PORT"),
-			Workers:  workers,
+			HttpPort:             os.Getenv("WORKER_SERVER_PORT"),
+			Workers:              workers,
+			FFmpegThreads:        ffmpegThreads,
+			JobMemory:            jobMemory,
+			Limits:               limits,
+			PriorityWorkers:      priorityWorkers,
+			BackfillWorkers:      backfillWorkers,
+			LongWorkers:          longWorkers,
+			LongJobSeconds:       longJobSeconds,
+			ExpressWorkers:       expressWorkers,
+			ExpressJobSeconds:    expressJobSeconds,
+			Bindings:             bindings,
+			APIToken:             os.Getenv("WORKER_API_TOKEN"),
+			UploadDir:            getEnv("UPLOAD_DIR", "uploads"),
+			MaxUploadSize:        int64(maxUploadSize),
+			DryRun:               dryRun,
+			VerifyOutput:         verifyOutput,
+			StreamUpload:         streamUpload,
+			StreamCopy:           streamCopy,
+			FollowUps:            followUps,
+			MediaEngines:         mediaEngines,
+			HeartbeatInterval:    heartbeatInterval,
+			HeartbeatTimeout:     heartbeatTimeout,
+			JobHeartbeatInterval: jobHeartbeatInterval,
+			ShutdownGrace:        shutdownGrace,
+			JobTimeout:           jobTimeout,
+			JobTimeoutFactor:     jobTimeoutFactor,
+			ScratchFactor:        scratchFactor,
+			SecureScratchDir:     getEnv("WORKER_SECURE_SCRATCH_DIR", ""),
+			MinFreeMemory:        minFreeMemory,
+			PreflightDelay:       preflightDelay,
+			WriteBatchInterval:   writeBatchInterval,
+			WriteBatchSize:       writeBatchSize,
+		},
+		Admin: Admin{
+			Enabled: adminEnabled,
+			Port:    getEnv("ADMIN_PORT", "6060"),
+		},
+		Scaler: Scaler{
+			Enabled: scalerEnabled,
+			Port:    getEnv("SCALER_PORT", "9090"),
+		},
+		Breaker: Breaker{
+			Threshold: breakerThreshold,
+			Cooldown:  breakerCooldown,
+		},
+		Chaos: Chaos{
+			Enabled:             chaosEnabled,
+			StorageErrorRate:    chaosRates["CHAOS_STORAGE_ERROR_RATE"],
+			FFmpegKillRate:      chaosRates["CHAOS_FFMPEG_KILL_RATE"],
+			FFmpegKillAfter:     chaosKillAfter,
+			DatabaseLatencyRate: chaosRates["CHAOS_DB_LATENCY_RATE"],
+			DatabaseLatency:     chaosLatency,
+		},
+		Backpressure: Backpressure{
+			MaxCPU:        loadMaxCPU,
+			MaxLoadPerCPU: loadMaxPerCPU,
+			MaxDisk:       loadMaxDisk,
+			MaxFFmpeg:     loadMaxFFmpeg,
+			Interval:      loadInterval,
+		},
+		SLA: SLA{
+			EnterpriseWeight: enterpriseWeight,
+			ProWeight:        proWeight,
+			FreeWeight:       freeWeight,
+		},
+		Canary: Canary{
+			Window:             canaryWindow,
+			MinJobs:            canaryMinJobs,
+			MaxFailureIncrease: canaryMaxFailureIncrease,
+		},
+		Tracing: Tracing{
+			Enabled:     tracingEnabled,
+			ServiceName: getEnv("OTEL_SERVICE_NAME", "transcode-video-worker"),
+			SampleRatio: sampleRatio,
+		},
+		Sentry: Sentry{
+			DSN:        os.Getenv("SENTRY_DSN"),
+			SampleRate: sentrySampleRate,
+		},
+		Log: Log{
+			Level:  os.Getenv("LOG_LEVEL"),
+			Format: getEnv("LOG_FORMAT", "json"),
+		},
+		Alerting: Alerting{
+			SlackWebhookURL:      os.Getenv("ALERT_SLACK_WEBHOOK_URL"),
+			DiscordWebhookURL:    os.Getenv("ALERT_DISCORD_WEBHOOK_URL"),
+			FailureRateThreshold: failureRate,
+			FailureWindow:        failureWindow,
+			FailureMinSamples:    failureMinSamples,
+			DLQThreshold:         dlqThreshold,
+			PriorityThreshold:    priorityThreshold,
+			DedupWindow:          dedupWindow,
+		},
+		SMTP: SMTP{
+			Host: os.Getenv("SMTP_HOST"),
+			Port: smtpPort,
+			User: os.Getenv("SMTP_USER"),
+			Pass: os.Getenv("SMTP_PASS"),
+			From: os.Getenv("SMTP_FROM"),
+		},
+		Notify: Notify{
+			VideoReadyEmail: videoReadyEmail,
+			LessonURLTemplate: getEnv("NOTIFY_LESSON_URL",
+				fmt.Sprintf("%s://%s/courses/{course_slug}/learn/{lesson_id}", getEnv("APP_PROTOCOL", "https"), os.Getenv("APP_HOST"))),
+		},
+		Analytics: Analytics{
+			Enabled:  analyticsEnabled,
+			Exchange: getEnv("ANALYTICS_EXCHANGE", "analytics_exchange"),
+		},
+		Chapters: Chapters{
+			Enabled:        chaptersEnabled,
+			MinDuration:    chaptersMinDuration,
+			MinSilence:     chaptersMinSilence,
+			SceneThreshold: chaptersSceneThreshold,
+			MinLength:      chaptersMinLength,
+			Exchange:       getEnv("CHAPTERS_EXCHANGE", "lesson_events"),
+		},
+		Slides: Slides{
+			Enabled:        slidesEnabled,
+			SceneThreshold: slidesSceneThreshold,
+			DedupDistance:  slidesDedupDistance,
+			MaxSlides:      slidesMax,
+		},
+		Preview: Preview{
+			Enabled: previewEnabled,
+			Format:  getEnv("PREVIEW_FORMAT", "webp"),
+			Seconds: previewSeconds,
+			Height:  previewHeight,
+			FPS:     previewFPS,
+		},
+		Poster: Poster{
+			Enabled:    posterEnabled,
+			Samples:    posterSamples,
+			Candidates: posterCandidates,
+			Width:      posterWidth,
+			Sizes:      posterSizes,
+		},
+		Dedup: Dedup{
+			Enabled: dedupEnabled,
+		},
+		Artifacts: Artifacts{
+			Enabled: artifactsEnabled,
+		},
+		Ingest: Ingest{
+			Enabled:  ingestEnabled,
+			Prefix:   getEnv("INGEST_PREFIX", "ingest/"),
+			SLAClass: getEnv("INGEST_SLA_CLASS", "pro"),
+		},
+		Zoom: Zoom{
+			Enabled:         zoomEnabled,
+			PollInterval:    zoomPollInterval,
+			SLAClass:        getEnv("ZOOM_SLA_CLASS", "pro"),
+			CaptionLanguage: getEnv("ZOOM_CAPTION_LANGUAGE", "en"),
+		},
+		Library: Library{
+			SLAClass: getEnv("LIBRARY_SLA_CLASS", "free"),
+		},
+		LMS: LMS{
+			Enabled:         lmsEnabled,
+			SLAClass:        getEnv("LMS_SLA_CLASS", "pro"),
+			DownloadTimeout: lmsDownloadTimeout,
+		},
+		Scan: Scan{
+			Enabled:          scanEnabled,
+			Address:          getEnv("SCAN_CLAMD_ADDRESS", "localhost:3310"),
+			Timeout:          scanTimeout,
+			QuarantinePrefix: getEnv("SCAN_QUARANTINE_PREFIX", "quarantine"),
+		},
+		Quota: Quota{
+			Policy:      quotaPolicy,
+			WarnPercent: quotaWarnPercent,
+			Exchange:    getEnv("QUOTA_EXCHANGE", "billing_events"),
+		},
+		Billing: Billing{
+			Enabled:       billingEnabled,
+			Exchange:      getEnv("BILLING_EXCHANGE", "billing_events"),
+			RelayInterval: billingRelayInterval,
+		},
+		Results: Results{
+			Enabled:  resultsEnabled,
+			Exchange: getEnv("RESULTS_EXCHANGE", "transcode_results"),
+		},
+		Workflow: Workflow{
+			Engine:      workflowEngine,
+			Address:     getEnv("TEMPORAL_ADDRESS", "localhost:7233"),
+			Namespace:   getEnv("TEMPORAL_NAMESPACE", "default"),
+			TaskQueue:   getEnv("TEMPORAL_TASK_QUEUE", "transcode-pipeline"),
+			CaptionWait: workflowCaptionWait,
+			CaptionPoll: workflowCaptionPoll,
+		},
+		Remote: Remote{
+			Provider:        os.Getenv("REMOTE_PROVIDER"),
+			TokenId:         os.Getenv("REMOTE_TOKEN_ID"),
+			TokenSecret:     os.Getenv("REMOTE_TOKEN_SECRET"),
+			MaxQueueSeconds: remoteMaxQueueSeconds,
+			PollInterval:    remotePollInterval,
+		},
+		Webcam: Webcam{
+			Scale:  webcamScale,
+			Margin: webcamMargin,
+		},
+		Trim: Trim{
+			Enabled:    trimEnabled,
+			MinDeadAir: trimMinDeadAir,
+			Padding:    trimPadding,
+		},
+		Downmix: Downmix{
+			Enabled:       downmixEnabled,
+			CenterLevel:   downmixCenterLevel,
+			SurroundLevel: downmixSurroundLevel,
+		},
+		Search: Search{
+			Backend:          getEnv("SEARCH_BACKEND", "postgres"),
+			ElasticsearchURL: os.Getenv("SEARCH_ELASTICSEARCH_URL"),
+			User:             os.Getenv("SEARCH_ELASTICSEARCH_USER"),
+			Pass:             os.Getenv("SEARCH_ELASTICSEARCH_PASS"),
+			Index:            getEnv("SEARCH_INDEX", "lesson_transcripts"),
+			ContentIndex:     getEnv("SEARCH_CONTENT_INDEX", "lesson_content"),
+			ExportInterval:   searchExportInterval,
+		},
+		Watermark: Watermark{
+			TTL:    watermarkTTL,
+			Height: watermarkHeight,
+			Mode:   getEnv("WATERMARK_MODE", "visible"),
+		},
+		Course: Course{
+			Exchange: getEnv("COURSE_EXCHANGE", "course_events"),
+		},
+		Branding: Branding{
+			Enabled: brandingEnabled,
+		},
+		Publish: Publish{
+			Enabled: publishEnabled,
+		},
+		Translation: Translation{
+			Enabled:   translationEnabled,
+			Provider:  getEnv("TRANSLATION_PROVIDER", "deepl"),
+			URL:       os.Getenv("TRANSLATION_URL"),
+			APIKey:    os.Getenv("TRANSLATION_API_KEY"),
+			Languages: getEnvList("TRANSLATION_LANGUAGES"),
+		},
+		TTS: TTS{
+			Provider: getEnv("TTS_PROVIDER", "google"),
+			URL:      os.Getenv("TTS_URL"),
+			APIKey:   os.Getenv("TTS_API_KEY"),
+			Model:    os.Getenv("TTS_MODEL"),
+			Voice:    os.Getenv("TTS_VOICE"),
+			Language: getEnv("TTS_LANGUAGE", "en-US"),
+		},
+		Music: Music{
+			Enabled:        musicEnabled,
+			Provider:       getEnv("MUSIC_PROVIDER", "acrcloud"),
+			URL:            os.Getenv("MUSIC_URL"),
+			APIKey:         os.Getenv("MUSIC_API_KEY"),
+			APISecret:      os.Getenv("MUSIC_API_SECRET"),
+			SampleInterval: musicSampleInterval,
+			SampleLength:   musicSampleLength,
+			MinScore:       musicMinScore,
+		},
+		Diarization: Diarization{
+			Enabled:     diarizationEnabled,
+			URL:         os.Getenv("DIARIZATION_URL"),
+			APIKey:      os.Getenv("DIARIZATION_API_KEY"),
+			MaxSpeakers: diarizationMaxSpeakers,
+		},
+		Redaction: Redaction{
+			Enabled: redactionEnabled,
+			Words:   getEnvList("REDACTION_WORDS"),
+			Bleep:   redactionBleep,
+			Padding: redactionPadding,
+		},
+		Fingerprint: Fingerprint{
+			Enabled:       fingerprintEnabled,
+			FrameInterval: fingerprintFrameInterval,
+			FrameDistance: fingerprintFrameDistance,
+			MinSimilarity: fingerprintMinSimilarity,
+		},
+		Accessibility: Accessibility{
+			Enabled:                 accessibilityEnabled,
+			SampleInterval:          accessibilitySampleInterval,
+			LoudnessTarget:          loudnessTarget,
+			LoudnessTolerance:       loudnessTolerance,
+			MaxTruePeak:             maxTruePeak,
+			MinCaptionCoverage:      minCaptionCoverage,
+			MinLegibility:           minLegibility,
+			RequireAudioDescription: requireAudioDescription,
+		},
+		Quality: Quality{
+			Enabled:   qualityEnabled,
+			Subsample: qualitySubsample,
+			Model:     os.Getenv("QUALITY_MODEL"),
+		},
+		Versions: Versions{
+			Grace: versionGrace,
+		},
+		Deletion: Deletion{
+			Grace: deletionGrace,
+		},
+		Download: Download{
+			Enabled:     downloadEnabled,
+			Height:      downloadHeight,
+			OfflineDays: downloadOfflineDays,
+			LinkTTL:     downloadLinkTTL,
+		},
+		Export: Export{
+			LinkTTL: exportLinkTTL,
+		},
+		Media: Media{
+			ImageWidths:    mediaImageWidths,
+			ThumbnailWidth: mediaThumbnailWidth,
+			PreviewWidth:   mediaPreviewWidth,
+			PreviewPages:   mediaPreviewPages,
+			Soffice:        getEnv("MEDIA_SOFFICE_PATH", "soffice"),
+		},
+		Live: Live{
+			OriginHosts: getEnvList("LIVE_ORIGIN_HOSTS"),
+			Timeout:     liveTimeout,
+			PartSeconds: livePartSeconds,
+		},
+		Podcast: Podcast{
+			Enabled:    podcastEnabled,
+			BaseURL:    strings.TrimSuffix(os.Getenv("PODCAST_BASE_URL"), "/"),
+			SigningKey: os.Getenv("PODCAST_SIGNING_KEY"),
+			LinkTTL:    podcastLinkTTL,
+		},
+		Keys: Keys{
+			Enabled:     keysEnabled,
+			URL:         strings.TrimSuffix(os.Getenv("KEYS_URL"), "/"),
+			MasterKeyId: os.Getenv("KEYS_MASTER_KEY_ID"),
+			MasterKeys:  masterKeys,
+		},
+		BurnIn: BurnIn{
+			Enabled: burnInEnabled,
+			Height:  burnInHeight,
+			LinkTTL: burnInLinkTTL,
+		},
+		Migration: Migration{
+			Enabled:    migrationEnabled,
+			Interval:   migrationInterval,
+			MaxPerTick: migrationMaxPerTick,
+		},
+		Maintenance: maintenance,
+		Region:      region,
+		Warehouse: Warehouse{
+			Enabled:  warehouseEnabled,
+			Bucket:   os.Getenv("WAREHOUSE_BUCKET"),
+			Interval: warehouseInterval,
+		},
+		PlaybackProbe: PlaybackProbe{
+			Enabled:    playbackProbeEnabled,
+			BaseURL:    os.Getenv("PLAYBACK_BASE_URL"),
+			Interval:   playbackProbeInterval,
+			Samples:    playbackProbeSamples,
+			Segments:   playbackProbeSegments,
+			MaxLatency: playbackProbeMaxLatency,
+		},
+		Reconcile: Reconcile{
+			Enabled:   reconcileEnabled,
+			Interval:  reconcileInterval,
+			BatchSize: reconcileBatchSize,
+		},
+		Subscriptions: Subscriptions{
+			Enabled:     subscriptionsEnabled,
+			Interval:    subscriptionsInterval,
+			Timeout:     subscriptionsTimeout,
+			MaxAttempts: subscriptionsMaxAttempts,
+		},
+		CDNSigning: CDNSigning{
+			Enabled:      cdnSigningEnabled,
+			Provider:     cdnSigningProvider,
+			BaseURL:      os.Getenv("PLAYBACK_BASE_URL"),
+			KeyId:        os.Getenv("CDN_SIGNING_KEY_ID"),
+			Key:          cdnSigningKey,
+			CookieDomain: os.Getenv("CDN_SIGNING_COOKIE_DOMAIN"),
+			TTL:          cdnSigningTTL,
+		},
+		Report: Report{
+			Enabled:    reportEnabled,
+			Hour:       reportHour,
+			Recipients: getEnvList("REPORT_RECIPIENTS"),
+		},
+		Cache: Cache{
+			RedisURL:         os.Getenv("REDIS_URL"),
+			TTL:              cacheTTL,
+			ProgressInterval: cacheProgressInterval,
+		},
+		Database: Database{
+			URL: fmt.Sprintf("postgres://%s:%s@%s:%s/%s",
+				os.Getenv("POSTGRES_USER"),
+				os.Getenv("POSTGRES_PASSWORD"),
+				os.Getenv("DB_HOST"),
+				os.Getenv("DB_PORT"),
+				os.Getenv("POSTGRES_DB"),
+			),
+		},
+		Queue: rabbitmq,
+		ObjectStore: ObjectStore{
+			Endpoint:     minioEndpoint,
+			Secure:       minioSecure,
+			Region:       os.Getenv("MINIO_REGION"),
+			BucketLookup: bucketLookup,
+			AccessKey:    os.Getenv("MINIO_ROOT_USER"),
+			SecretKey:    os.Getenv("MINIO_ROOT_PASSWORD"),
 		},
-		DB:      db,
-		Queue:   rabbitmq,
-		Storage: minioClient,
 	}, nil
 }
@@ -0,0 +1,28 @@
+package config
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+)
+
+// NewDB opens the Postgres database. Connections are made as they're
+// needed, so it doesn't fail when the database is down.
+func NewDB(cfg Database) (*sql.DB, error) {
+	return sql.Open("postgres", cfg.URL)
+}
+
+// NewRedis makes the client of the job status cache, nil when cfg sets no
+// REDIS_URL.
+func NewRedis(cfg Cache) (*redis.Client, error) {
+	if cfg.RedisURL == "" {
+		return nil, nil
+	}
+	options, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("REDIS_URL: %w", err)
+	}
+	return redis.NewClient(options), nil
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) (int, error) {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func getEnvBool(key string, fallback bool) (bool, error) {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+func getEnvFloat(key string, fallback float64) (float64, error) {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback, nil
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// getEnvList splits a comma-separated value, dropping empty entries.
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvInts parses a list of positive integers, e.g. "320,640,1280". Unset,
+// it returns fallback.
+func getEnvInts(key string, fallback []int) ([]int, error) {
+	items := getEnvList(key)
+	if len(items) == 0 {
+		return fallback, nil
+	}
+	values := make([]int, 0, len(items))
+	for _, item := range items {
+		value, err := strconv.Atoi(item)
+		if err != nil || value <= 0 {
+			return nil, fmt.Errorf("%s: %q must be a positive integer", key, item)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// getEnvBindings parses a list of name=concurrency pairs, e.g.
+// "transcode=2,recording=4". Unset, it returns fallback.
+func getEnvBindings(key string, fallback []Binding) ([]Binding, error) {
+	pairs := getEnvList(key)
+	if len(pairs) == 0 {
+		return fallback, nil
+	}
+	bindings := make([]Binding, 0, len(pairs))
+	for _, pair := range pairs {
+		name, raw, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s: %q is not name=concurrency", key, pair)
+		}
+		concurrency, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil || concurrency < 0 {
+			return nil, fmt.Errorf("%s: concurrency of %q must be a non-negative integer", key, pair)
+		}
+		bindings = append(bindings, Binding{Name: strings.TrimSpace(name), Concurrency: concurrency})
+	}
+	return bindings, nil
+}
+
+// getEnvKeys parses a list of id=hex AES-256 keys, e.g. "2024=00ff...".
+func getEnvKeys(key string) (map[string][]byte, error) {
+	keys := map[string][]byte{}
+	for _, pair := range getEnvList(key) {
+		id, raw, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(id) == "" {
+			return nil, fmt.Errorf("%s: a key is not id=hex", key)
+		}
+		value, err := hex.DecodeString(strings.TrimSpace(raw))
+		if err != nil || len(value) != 32 {
+			return nil, fmt.Errorf("%s: key %q must be 32 bytes of hex", key, strings.TrimSpace(id))
+		}
+		keys[strings.TrimSpace(id)] = value
+	}
+	return keys, nil
+}
+
+// getEnvRetryPolicies parses a list of routing-key=tries:initial:max[:timeout]
+// policies, e.g. "video.transcoding.long=2:600:3600:14400". Intervals are in
+// seconds and may be fractions; a policy without a timeout keeps
+// fallback's.
+func getEnvRetryPolicies(key string, fallback RetryPolicy) (map[string]RetryPolicy, error) {
+	policies := map[string]RetryPolicy{}
+	for _, pair := range getEnvList(key) {
+		routingKey, raw, ok := strings.Cut(pair, "=")
+		fields := strings.Split(raw, ":")
+		if !ok || len(fields) < 3 || len(fields) > 4 {
+			return nil, fmt.Errorf("%s: %q is not routing-key=tries:initial:max[:timeout]", key, pair)
+		}
+		policy := fallback
+		var err error
+		if policy.Tries, err = strconv.Atoi(strings.TrimSpace(fields[0])); err != nil || policy.Tries < 1 {
+			return nil, fmt.Errorf("%s: tries of %q must be at least 1", key, pair)
+		}
+		if policy.InitialInterval, err = strconv.ParseFloat(strings.TrimSpace(fields[1]), 64); err != nil || policy.InitialInterval < 0 {
+			return nil, fmt.Errorf("%s: initial interval of %q must be non-negative seconds", key, pair)
+		}
+		if policy.MaxInterval, err = strconv.ParseFloat(strings.TrimSpace(fields[2]), 64); err != nil || policy.MaxInterval < policy.InitialInterval {
+			return nil, fmt.Errorf("%s: max interval of %q must be seconds no less than the initial interval", key, pair)
+		}
+		if len(fields) == 4 {
+			if policy.Timeout, err = strconv.Atoi(strings.TrimSpace(fields[3])); err != nil || policy.Timeout < 0 {
+				return nil, fmt.Errorf("%s: timeout of %q must be non-negative seconds", key, pair)
+			}
+		}
+		policies[strings.TrimSpace(routingKey)] = policy
+	}
+	return policies, nil
+}
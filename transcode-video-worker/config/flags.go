@@ -0,0 +1,332 @@
+package config
+
+// Flag binds a command line flag to the environment variable Load reads, so
+// any setting can be given either way. Flags win over the environment, which
+// wins over the .env file.
+type Flag struct {
+	Name  string
+	Env   string
+	Usage string
+	// Values are offered by shell completion when the setting is an enum.
+	Values []string
+	// Bool settings can be given as a bare --flag.
+	Bool bool
+}
+
+var Flags = []Flag{
+	{Name: "environment", Env: "APP_ENVIRONMENT", Usage: "deployment environment", Values: []string{"production", "staging", "develop"}},
+	{Name: "app-host", Env: "APP_HOST", Usage: "public host of the web app, used in links"},
+	{Name: "app-protocol", Env: "APP_PROTOCOL", Usage: "public protocol of the web app", Values: []string{"https", "http"}},
+
+	{Name: "db-host", Env: "DB_HOST", Usage: "postgres host"},
+	{Name: "db-port", Env: "DB_PORT", Usage: "postgres port"},
+	{Name: "db-name", Env: "POSTGRES_DB", Usage: "postgres database"},
+	{Name: "db-user", Env: "POSTGRES_USER", Usage: "postgres user"},
+	{Name: "db-password", Env: "POSTGRES_PASSWORD", Usage: "postgres password"},
+
+	{Name: "rabbitmq-host", Env: "RABBITMQ_HOST", Usage: "rabbitmq host"},
+	{Name: "rabbitmq-port", Env: "RABBITMQ_PORT", Usage: "rabbitmq port (default 5672)"},
+	{Name: "rabbitmq-user", Env: "RABBITMQ_USER", Usage: "rabbitmq user"},
+	{Name: "rabbitmq-pass", Env: "RABBITMQ_PASS", Usage: "rabbitmq password"},
+	{Name: "rabbitmq-kind", Env: "RABBITMQ_KIND", Usage: "rabbitmq exchange kind"},
+	{Name: "rabbitmq-exchange", Env: "RABBITMQ_EXCHANGE_NAME", Usage: "rabbitmq exchange name"},
+	{Name: "rabbitmq-depth-interval", Env: "RABBITMQ_DEPTH_INTERVAL", Usage: "seconds between queue depth samples, 0 disables (default 15)"},
+	{Name: "rabbitmq-lease-after", Env: "RABBITMQ_LEASE_AFTER", Usage: "seconds before a long job's message is acked early, below the broker's consumer_timeout, 0 disables (default 1200)"},
+	{Name: "retry-tries", Env: "RETRY_TRIES", Usage: "attempts a failed message gets in all (default 5)"},
+	{Name: "retry-initial-interval", Env: "RETRY_INITIAL_INTERVAL", Usage: "seconds before a failed message is first retried (default 0.5)"},
+	{Name: "retry-max-interval", Env: "RETRY_MAX_INTERVAL", Usage: "most seconds between retries of a failed message (default 10)"},
+	{Name: "retry-policies", Env: "RETRY_POLICIES", Usage: "comma-separated routing-key=tries:initial:max[:timeout] retry policies of particular queues"},
+
+	{Name: "minio-url", Env: "MINIO_URL", Usage: "minio or S3 endpoint, host:port or a URL whose scheme picks TLS (default localhost:9000)"},
+	{Name: "minio-region", Env: "MINIO_REGION", Usage: "region of the storage endpoint (default resolved from the endpoint or the bucket)"},
+	{Name: "minio-bucket-lookup", Env: "MINIO_BUCKET_LOOKUP", Usage: "how buckets are addressed (default auto)", Values: []string{"auto", "path", "virtual-host"}},
+	{Name: "minio-user", Env: "MINIO_ROOT_USER", Usage: "minio access key"},
+	{Name: "minio-password", Env: "MINIO_ROOT_PASSWORD", Usage: "minio secret key"},
+	{Name: "minio-bucket", Env: "MINIO_BUCKET", Usage: "bucket holding uploads and outputs"},
+	{Name: "minio-use-ssl", Env: "MINIO_USE_SSL", Usage: "reach minio over https when MINIO_URL has no scheme (default true)", Bool: true},
+	{Name: "redis-url", Env: "REDIS_URL", Usage: "redis the job status cache is kept in, redis://host:port/db (default none)"},
+	{Name: "cache-ttl", Env: "CACHE_TTL", Usage: "seconds a cached job status lasts after its last write (default 60)"},
+	{Name: "cache-progress-interval", Env: "CACHE_PROGRESS_INTERVAL", Usage: "milliseconds between cached progress updates of an encoding job (default 500)"},
+
+	{Name: "port", Env: "WORKER_SERVER_PORT", Usage: "http port"},
+	{Name: "workers", Env: "SERVER_WORKERS", Usage: "concurrent encoding jobs across all bindings (default sized to the container's CPU and memory limits)"},
+	{Name: "ffmpeg-threads", Env: "FFMPEG_THREADS", Usage: "threads per encode, 0 lets ffmpeg decide (default the cores shared between workers)"},
+	{Name: "ffmpeg-path", Env: "FFMPEG_PATH", Usage: "ffmpeg binary to run (default ffmpeg on the PATH)"},
+	{Name: "ffprobe-path", Env: "FFPROBE_PATH", Usage: "ffprobe binary to run (default ffprobe on the PATH)"},
+	{Name: "ffmpeg-global-args", Env: "FFMPEG_GLOBAL_ARGS", Usage: "args every ffmpeg run starts with, may name {threads}, {loglevel} and {hwaccel_device}"},
+	{Name: "ffmpeg-loglevel", Env: "FFMPEG_LOGLEVEL", Usage: "value of {loglevel} in ffmpeg args"},
+	{Name: "ffmpeg-hwaccel-device", Env: "FFMPEG_HWACCEL_DEVICE", Usage: "value of {hwaccel_device} in ffmpeg args, e.g. a GPU index"},
+	{Name: "job-memory", Env: "WORKER_JOB_MEMORY_MB", Usage: "memory in MB one transcode needs, for sizing the default workers (default 1536)"},
+	{Name: "priority-workers", Env: "SERVER_PRIORITY_WORKERS", Usage: "concurrent jobs on the priority lane (default 1)"},
+	{Name: "backfill-workers", Env: "SERVER_BACKFILL_WORKERS", Usage: "concurrent jobs on the backfill lane (default 1)"},
+	{Name: "long-workers", Env: "SERVER_LONG_WORKERS", Usage: "concurrent jobs on the long source lane (default 1)"},
+	{Name: "long-job-seconds", Env: "WORKER_LONG_JOB_SECONDS", Usage: "source seconds from which an upload goes to the long lane, 0 to disable (default 3600)"},
+	{Name: "express-workers", Env: "SERVER_EXPRESS_WORKERS", Usage: "concurrent jobs on the express lane of short sources (default 1)"},
+	{Name: "express-job-seconds", Env: "WORKER_EXPRESS_JOB_SECONDS", Usage: "source seconds under which an upload goes to the express lane, 0 to disable (default 300)"},
+	{Name: "watermark-workers", Env: "SERVER_WATERMARK_WORKERS", Usage: "concurrent watermark jobs (default 1)"},
+	{Name: "translation-workers", Env: "SERVER_TRANSLATION_WORKERS", Usage: "concurrent caption translation jobs (default 1)"},
+	{Name: "narration-workers", Env: "SERVER_NARRATION_WORKERS", Usage: "concurrent narrated video jobs (default 1)"},
+	{Name: "export-workers", Env: "SERVER_EXPORT_WORKERS", Usage: "concurrent content package exports (default 1)"},
+	{Name: "media-workers", Env: "SERVER_MEDIA_WORKERS", Usage: "concurrent image and document jobs (default 2)"},
+	{Name: "live-workers", Env: "SERVER_LIVE_WORKERS", Usage: "concurrent live recording imports (default 1)"},
+	{Name: "podcast-workers", Env: "SERVER_PODCAST_WORKERS", Usage: "concurrent course podcast builds (default 1)"},
+	{Name: "key-workers", Env: "SERVER_KEY_WORKERS", Usage: "concurrent content key rotations (default 1)"},
+	{Name: "burn-in-workers", Env: "SERVER_BURN_IN_WORKERS", Usage: "concurrent burned-in caption renders (default 1)"},
+	{Name: "audio-workers", Env: "SERVER_AUDIO_WORKERS", Usage: "concurrent audio track replacements (default 1)"},
+	{Name: "regeneration-workers", Env: "SERVER_REGENERATION_WORKERS", Usage: "concurrent lesson artifact regenerations (default 1)"},
+	{Name: "deletion-workers", Env: "SERVER_DELETION_WORKERS", Usage: "concurrent media deletions (default 1)"},
+	{Name: "queue-bindings", Env: "QUEUE_BINDINGS", Usage: "work to consume with its concurrency, e.g. transcode=2,recording=4 (default from the worker counts)"},
+	{Name: "api-token", Env: "WORKER_API_TOKEN", Usage: "bearer token required by the /api routes"},
+	{Name: "upload-dir", Env: "UPLOAD_DIR", Usage: "directory for in-progress uploads (default uploads)"},
+	{Name: "upload-max-size", Env: "UPLOAD_MAX_SIZE", Usage: "largest accepted upload in bytes"},
+	{Name: "dry-run", Env: "WORKER_DRY_RUN", Usage: "plan jobs without encoding or uploading", Bool: true},
+	{Name: "verify-output", Env: "WORKER_VERIFY_OUTPUT", Usage: "check uploaded packages before completing jobs", Bool: true},
+	{Name: "media-engines", Env: "WORKER_MEDIA_ENGINES", Usage: "comma-separated media engines encodes run on, by preference (default ffmpeg)"},
+	{Name: "stream-copy", Env: "WORKER_STREAM_COPY", Usage: "remux a rung the source already conforms to instead of encoding it (default true)", Bool: true},
+	{Name: "stream-upload", Env: "WORKER_STREAM_UPLOAD", Usage: "upload finished segments while the encode runs (default true)", Bool: true},
+	{Name: "follow-ups", Env: "WORKER_FOLLOW_UPS", Usage: "publish videos before their posters, previews, slides, chapters and review checks, made by follow-up jobs", Bool: true},
+	{Name: "heartbeat-interval", Env: "WORKER_HEARTBEAT_INTERVAL", Usage: "seconds between worker registry heartbeats (default 15)"},
+	{Name: "heartbeat-timeout", Env: "WORKER_HEARTBEAT_TIMEOUT", Usage: "seconds without a heartbeat before a worker's jobs are reassigned (default 120)"},
+	{Name: "job-heartbeat-interval", Env: "JOB_HEARTBEAT_INTERVAL", Usage: "seconds between a processing job's heartbeats with its current stage (default 10)"},
+	{Name: "shutdown-grace", Env: "WORKER_SHUTDOWN_GRACE", Usage: "seconds to let in-flight jobs finish after SIGTERM, 0 stops at once (default 25)"},
+	{Name: "job-timeout", Env: "WORKER_JOB_TIMEOUT", Usage: "base seconds a transcode may run, 0 disables the limit (default 1800)"},
+	{Name: "job-timeout-factor", Env: "WORKER_JOB_TIMEOUT_FACTOR", Usage: "seconds added to the job timeout per second of source (default 4)"},
+	{Name: "scratch-factor", Env: "WORKER_SCRATCH_FACTOR", Usage: "scratch space needed per rendition, as a multiple of the source size (default 1)"},
+	{Name: "secure-scratch-dir", Env: "WORKER_SECURE_SCRATCH_DIR", Usage: "memory-backed directory the jobs of tenants with secure scratch work in"},
+	{Name: "min-free-memory", Env: "WORKER_MIN_FREE_MEMORY_MB", Usage: "available memory in MB a job needs to start (default 512)"},
+	{Name: "preflight-delay", Env: "WORKER_PREFLIGHT_DELAY", Usage: "seconds a job that doesn't fit waits before it is requeued (default 30)"},
+	{Name: "write-batch-interval", Env: "WORKER_WRITE_BATCH_INTERVAL", Usage: "milliseconds between batched job event and progress writes, 0 to write each at once (default 1000)"},
+	{Name: "write-batch-size", Env: "WORKER_WRITE_BATCH_SIZE", Usage: "buffered job writes that trigger an early flush (default 200)"},
+
+	{Name: "admin-enabled", Env: "ADMIN_ENABLED", Usage: "serve pprof and debug endpoints", Bool: true},
+	{Name: "admin-port", Env: "ADMIN_PORT", Usage: "admin listener port (default 6060)"},
+	{Name: "scaler-enabled", Env: "SCALER_ENABLED", Usage: "serve the KEDA external scaler gRPC API", Bool: true},
+	{Name: "scaler-port", Env: "SCALER_PORT", Usage: "external scaler gRPC port (default 9090)"},
+	{Name: "breaker-threshold", Env: "BREAKER_THRESHOLD", Usage: "consecutive storage, database or broker failures that pause intake (default 5)"},
+	{Name: "breaker-cooldown", Env: "BREAKER_COOLDOWN", Usage: "seconds intake stays paused before a probe job is let through (default 30)"},
+	{Name: "chaos-enabled", Env: "CHAOS_ENABLED", Usage: "inject failures at the chaos rates to exercise retries and recovery; refused in production", Bool: true},
+	{Name: "chaos-storage-error-rate", Env: "CHAOS_STORAGE_ERROR_RATE", Usage: "share of storage requests answered with a 500 (default 0)"},
+	{Name: "chaos-ffmpeg-kill-rate", Env: "CHAOS_FFMPEG_KILL_RATE", Usage: "share of ffmpeg runs killed part way (default 0)"},
+	{Name: "chaos-ffmpeg-kill-after", Env: "CHAOS_FFMPEG_KILL_AFTER", Usage: "seconds within which a doomed ffmpeg run is killed (default 30)"},
+	{Name: "chaos-db-latency-rate", Env: "CHAOS_DB_LATENCY_RATE", Usage: "share of database statements held up (default 0)"},
+	{Name: "chaos-db-latency", Env: "CHAOS_DB_LATENCY", Usage: "milliseconds a held up database statement waits (default 2000)"},
+	{Name: "load-max-cpu", Env: "LOAD_MAX_CPU", Usage: "CPU percent above which intake pauses, 0 disables (default 0)"},
+	{Name: "load-max-per-cpu", Env: "LOAD_MAX_PER_CPU", Usage: "load average per core above which intake pauses, 0 disables (default 2)"},
+	{Name: "load-max-disk", Env: "LOAD_MAX_DISK", Usage: "scratch disk percent used above which intake pauses, 0 disables (default 90)"},
+	{Name: "load-max-ffmpeg", Env: "LOAD_MAX_FFMPEG", Usage: "running ffmpeg processes above which intake pauses, 0 disables (default 0)"},
+	{Name: "load-interval", Env: "LOAD_INTERVAL", Usage: "seconds between load readings (default 5)"},
+	{Name: "sla-weight-enterprise", Env: "SLA_WEIGHT_ENTERPRISE", Usage: "enterprise jobs taken per scheduling round (default 6)"},
+	{Name: "sla-weight-pro", Env: "SLA_WEIGHT_PRO", Usage: "pro jobs taken per scheduling round (default 3)"},
+	{Name: "sla-weight-free", Env: "SLA_WEIGHT_FREE", Usage: "free jobs taken per scheduling round (default 1)"},
+	{Name: "canary-window", Env: "CANARY_WINDOW", Usage: "seconds of finished jobs a canary preset is compared over (default 604800)"},
+	{Name: "canary-min-jobs", Env: "CANARY_MIN_JOBS", Usage: "finished canary jobs needed before it can be promoted (default 20)"},
+	{Name: "canary-max-failure-increase", Env: "CANARY_MAX_FAILURE_INCREASE", Usage: "how much higher the canary's failure rate may be than the stable version's (default 0.02)"},
+
+	{Name: "log-level", Env: "LOG_LEVEL", Usage: "log level", Values: []string{"trace", "debug", "info", "warn", "error"}},
+	{Name: "log-format", Env: "LOG_FORMAT", Usage: "log output format (default json)", Values: []string{"json", "console"}},
+
+	{Name: "tracing-enabled", Env: "TRACING_ENABLED", Usage: "export traces over OTLP", Bool: true},
+	{Name: "tracing-service-name", Env: "OTEL_SERVICE_NAME", Usage: "service name on exported traces"},
+	{Name: "tracing-sample-ratio", Env: "TRACING_SAMPLE_RATIO", Usage: "fraction of traces sampled (default 1)"},
+	{Name: "sentry-dsn", Env: "SENTRY_DSN", Usage: "sentry DSN, empty disables error reporting"},
+	{Name: "sentry-sample-rate", Env: "SENTRY_SAMPLE_RATE", Usage: "fraction of errors reported (default 1)"},
+
+	{Name: "alert-slack-webhook", Env: "ALERT_SLACK_WEBHOOK_URL", Usage: "slack webhook for alerts"},
+	{Name: "alert-discord-webhook", Env: "ALERT_DISCORD_WEBHOOK_URL", Usage: "discord webhook for alerts"},
+	{Name: "alert-failure-rate", Env: "ALERT_FAILURE_RATE", Usage: "failure rate that raises an alert (default 0.5)"},
+	{Name: "alert-failure-window", Env: "ALERT_FAILURE_WINDOW", Usage: "seconds the failure rate is measured over (default 900)"},
+	{Name: "alert-failure-min-samples", Env: "ALERT_FAILURE_MIN_SAMPLES", Usage: "jobs needed in the window before alerting (default 10)"},
+	{Name: "alert-dlq-threshold", Env: "ALERT_DLQ_THRESHOLD", Usage: "dead-lettered messages that raise an alert (default 1)"},
+	{Name: "alert-priority-threshold", Env: "ALERT_PRIORITY_THRESHOLD", Usage: "job priority whose failures always alert (default 1)"},
+	{Name: "alert-dedup-window", Env: "ALERT_DEDUP_WINDOW", Usage: "seconds an identical alert is suppressed (default 1800)"},
+
+	{Name: "smtp-host", Env: "SMTP_HOST", Usage: "smtp host, empty disables email"},
+	{Name: "smtp-port", Env: "SMTP_PORT", Usage: "smtp port (default 587)"},
+	{Name: "smtp-user", Env: "SMTP_USER", Usage: "smtp user"},
+	{Name: "smtp-pass", Env: "SMTP_PASS", Usage: "smtp password"},
+	{Name: "smtp-from", Env: "SMTP_FROM", Usage: "sender address"},
+	{Name: "notify-video-ready", Env: "NOTIFY_VIDEO_READY_EMAIL", Usage: "email instructors when a video is ready", Bool: true},
+	{Name: "notify-lesson-url", Env: "NOTIFY_LESSON_URL", Usage: "lesson link template used in emails"},
+
+	{Name: "analytics-enabled", Env: "ANALYTICS_ENABLED", Usage: "publish media events for analytics", Bool: true},
+	{Name: "analytics-exchange", Env: "ANALYTICS_EXCHANGE", Usage: "exchange media events go to (default analytics_exchange)"},
+	{Name: "chapters-enabled", Env: "CHAPTERS_ENABLED", Usage: "propose chapters for long lesson recordings", Bool: true},
+	{Name: "chapters-min-duration", Env: "CHAPTERS_MIN_DURATION", Usage: "seconds a recording must last to get chapters (default 1200)"},
+	{Name: "chapters-min-silence", Env: "CHAPTERS_MIN_SILENCE", Usage: "seconds of silence that count as a pause (default 2)"},
+	{Name: "chapters-scene-threshold", Env: "CHAPTERS_SCENE_THRESHOLD", Usage: "scene change score, 0 to 1, that marks a new slide or shot (default 0.3)"},
+	{Name: "chapters-min-length", Env: "CHAPTERS_MIN_LENGTH", Usage: "seconds a chapter lasts at least (default 180)"},
+	{Name: "chapters-exchange", Env: "CHAPTERS_EXCHANGE", Usage: "exchange detected chapters are announced on (default lesson_events)"},
+	{Name: "slides-enabled", Env: "SLIDES_ENABLED", Usage: "extract slide decks from screen recordings", Bool: true},
+	{Name: "slides-scene-threshold", Env: "SLIDES_SCENE_THRESHOLD", Usage: "scene change score, 0 to 1, that marks a slide transition (default 0.1)"},
+	{Name: "slides-dedup-distance", Env: "SLIDES_DEDUP_DISTANCE", Usage: "image hash bits within which two slides are the same (default 6)"},
+	{Name: "slides-max", Env: "SLIDES_MAX", Usage: "slides kept per recording (default 300)"},
+	{Name: "preview-enabled", Env: "PREVIEW_ENABLED", Usage: "make an animated preview of each video for course cards", Bool: true},
+	{Name: "preview-format", Env: "PREVIEW_FORMAT", Usage: "animated preview format (default webp)", Values: []string{"webp", "gif"}},
+	{Name: "preview-seconds", Env: "PREVIEW_SECONDS", Usage: "length of the animated preview (default 4)"},
+	{Name: "preview-height", Env: "PREVIEW_HEIGHT", Usage: "lines of the animated preview (default 180)"},
+	{Name: "preview-fps", Env: "PREVIEW_FPS", Usage: "frames a second of the animated preview (default 10)"},
+	{Name: "poster-enabled", Env: "POSTER_ENABLED", Usage: "pick a poster frame for each video", Bool: true},
+	{Name: "poster-samples", Env: "POSTER_SAMPLES", Usage: "frames sampled across the video for its poster (default 12)"},
+	{Name: "poster-candidates", Env: "POSTER_CANDIDATES", Usage: "best poster frames kept for the instructor to pick from (default 4)"},
+	{Name: "poster-width", Env: "POSTER_WIDTH", Usage: "width of poster frames (default 1280)"},
+	{Name: "poster-sizes", Env: "POSTER_SIZES", Usage: "narrower widths each poster is also kept at (default 640,320)"},
+	{Name: "dedup-enabled", Env: "DEDUP_ENABLED", Usage: "copy the package of an identical input instead of encoding it again", Bool: true},
+	{Name: "ingest-enabled", Env: "INGEST_ENABLED", Usage: "create transcode jobs for files dropped under the ingest prefix", Bool: true},
+	{Name: "ingest-prefix", Env: "INGEST_PREFIX", Usage: "bucket prefix watched for <preset>/<lesson id>/<file> drops (default ingest/)"},
+	{Name: "ingest-sla-class", Env: "INGEST_SLA_CLASS", Usage: "SLA class of ingested jobs (default pro)"},
+	{Name: "zoom-enabled", Env: "ZOOM_ENABLED", Usage: "import tenants' zoom cloud recordings into their linked lessons", Bool: true},
+	{Name: "zoom-poll-interval", Env: "ZOOM_POLL_INTERVAL", Usage: "minutes between zoom recording listings, 0 for webhooks only (default 15)"},
+	{Name: "zoom-sla-class", Env: "ZOOM_SLA_CLASS", Usage: "SLA class of zoom recording jobs (default pro)"},
+	{Name: "zoom-caption-language", Env: "ZOOM_CAPTION_LANGUAGE", Usage: "language zoom transcripts are indexed as (default en)"},
+	{Name: "library-sla-class", Env: "LIBRARY_SLA_CLASS", Usage: "SLA class of videos imported from vimeo or youtube (default free)"},
+	{Name: "lms-webhook-enabled", Env: "LMS_WEBHOOK_ENABLED", Usage: "turn tenants' lms webhook events into transcode jobs", Bool: true},
+	{Name: "lms-sla-class", Env: "LMS_SLA_CLASS", Usage: "SLA class of lms webhook jobs (default pro)"},
+	{Name: "lms-download-timeout", Env: "LMS_DOWNLOAD_TIMEOUT", Usage: "seconds an lms event's media may take to fetch (default 3600)"},
+	{Name: "scan-enabled", Env: "SCAN_ENABLED", Usage: "scan uploads for malware with clamd before processing", Bool: true},
+	{Name: "scan-clamd-address", Env: "SCAN_CLAMD_ADDRESS", Usage: "host:port of clamd (default localhost:3310)"},
+	{Name: "scan-timeout", Env: "SCAN_TIMEOUT", Usage: "seconds a file's malware scan may take (default 300)"},
+	{Name: "scan-quarantine-prefix", Env: "SCAN_QUARANTINE_PREFIX", Usage: "bucket prefix infected uploads are moved under (default quarantine)"},
+	{Name: "quota-policy", Env: "QUOTA_POLICY", Usage: "what happens to jobs of tenants past their encoding minutes (default reject)", Values: []string{"reject", "warn"}},
+	{Name: "quota-warn-percent", Env: "QUOTA_WARN_PERCENT", Usage: "percent of a tenant's quota that warns the billing service (default 80)"},
+	{Name: "quota-exchange", Env: "QUOTA_EXCHANGE", Usage: "exchange quota events are published on (default billing_events)"},
+	{Name: "billing-enabled", Env: "BILLING_ENABLED", Usage: "emit a usage record of every completed transcode for billing", Bool: true},
+	{Name: "billing-exchange", Env: "BILLING_EXCHANGE", Usage: "exchange usage records are published on (default billing_events)"},
+	{Name: "billing-relay-interval", Env: "BILLING_RELAY_INTERVAL", Usage: "seconds between relays of the outbox to the broker (default 5)"},
+	{Name: "results-enabled", Env: "RESULTS_ENABLED", Usage: "publish a result of every completed transcode listing its renditions, captions and checks", Bool: true},
+	{Name: "results-exchange", Env: "RESULTS_EXCHANGE", Usage: "exchange transcode results are published on (default transcode_results)"},
+	{Name: "workflow-engine", Env: "WORKFLOW_ENGINE", Usage: "how transcode jobs run (default queue)", Values: []string{"queue", "temporal"}},
+	{Name: "temporal-address", Env: "TEMPORAL_ADDRESS", Usage: "host:port of the temporal server (default localhost:7233)"},
+	{Name: "temporal-namespace", Env: "TEMPORAL_NAMESPACE", Usage: "temporal namespace of the pipeline workflows (default default)"},
+	{Name: "temporal-task-queue", Env: "TEMPORAL_TASK_QUEUE", Usage: "temporal task queue of the pipeline workflows (default transcode-pipeline)"},
+	{Name: "workflow-caption-wait", Env: "WORKFLOW_CAPTION_WAIT", Usage: "hours a pipeline waits for the lesson's captions (default 48)"},
+	{Name: "workflow-caption-poll", Env: "WORKFLOW_CAPTION_POLL", Usage: "minutes between a pipeline's checks for captions (default 15)"},
+	{Name: "remote-provider", Env: "REMOTE_PROVIDER", Usage: "external transcoder to fall back to, empty for none", Values: []string{"mux"}},
+	{Name: "remote-token-id", Env: "REMOTE_TOKEN_ID", Usage: "external transcoder access token id"},
+	{Name: "remote-token-secret", Env: "REMOTE_TOKEN_SECRET", Usage: "external transcoder access token secret"},
+	{Name: "remote-max-queue-seconds", Env: "REMOTE_MAX_QUEUE_SECONDS", Usage: "seconds queued after which a job falls back to the external transcoder, 0 for never (default 0)"},
+	{Name: "remote-poll-interval", Env: "REMOTE_POLL_INTERVAL", Usage: "seconds between external transcoder status checks (default 10)"},
+	{Name: "artifacts-enabled", Env: "ARTIFACTS_ENABLED", Usage: "store each job's ffmpeg logs and reports as one gzipped tar", Bool: true},
+	{Name: "webcam-scale", Env: "WEBCAM_SCALE", Usage: "share of the screen's width an inset webcam takes (default 0.25)"},
+	{Name: "webcam-margin", Env: "WEBCAM_MARGIN", Usage: "pixels between an inset webcam and the screen's edges (default 24)"},
+	{Name: "trim-enabled", Env: "TRIM_ENABLED", Usage: "cut dead air off the start and end of lesson uploads", Bool: true},
+	{Name: "trim-min-dead-air", Env: "TRIM_MIN_DEAD_AIR", Usage: "seconds of leading or trailing silence worth cutting (default 60)"},
+	{Name: "trim-padding", Env: "TRIM_PADDING", Usage: "seconds of silence kept around the content (default 2)"},
+	{Name: "downmix-enabled", Env: "DOWNMIX_ENABLED", Usage: "remix surround and odd channel layouts to stereo before encoding (default true)", Bool: true},
+	{Name: "downmix-center-level", Env: "DOWNMIX_CENTER_LEVEL", Usage: "dB the center channel is mixed into stereo at (default -3)"},
+	{Name: "downmix-surround-level", Env: "DOWNMIX_SURROUND_LEVEL", Usage: "dB the surround channels are mixed into stereo at (default -3)"},
+	{Name: "search-backend", Env: "SEARCH_BACKEND", Usage: "where lesson transcripts are indexed (default postgres)", Values: []string{"postgres", "elasticsearch"}},
+	{Name: "search-elasticsearch-url", Env: "SEARCH_ELASTICSEARCH_URL", Usage: "elasticsearch or opensearch url of the transcript index"},
+	{Name: "search-elasticsearch-user", Env: "SEARCH_ELASTICSEARCH_USER", Usage: "elasticsearch user"},
+	{Name: "search-elasticsearch-pass", Env: "SEARCH_ELASTICSEARCH_PASS", Usage: "elasticsearch password"},
+	{Name: "search-index", Env: "SEARCH_INDEX", Usage: "elasticsearch index of lesson transcripts (default lesson_transcripts)"},
+	{Name: "search-content-index", Env: "SEARCH_CONTENT_INDEX", Usage: "elasticsearch index lessons are exported to for platform search (default lesson_content)"},
+	{Name: "search-export-interval", Env: "SEARCH_EXPORT_INTERVAL", Usage: "minutes between exports of changed lessons to the content index, 0 for none (default 0)"},
+	{Name: "watermark-ttl", Env: "WATERMARK_TTL", Usage: "seconds a watermarked rendition is kept (default 86400)"},
+	{Name: "watermark-height", Env: "WATERMARK_HEIGHT", Usage: "largest height of watermarked renditions (default 720)"},
+	{Name: "branding-enabled", Env: "BRANDING_ENABLED", Usage: "composite tenant branding templates into lesson videos", Bool: true},
+	{Name: "publish-enabled", Env: "PUBLISH_ENABLED", Usage: "send finished lesson videos to their tenant's publish target", Bool: true},
+	{Name: "translation-enabled", Env: "TRANSLATION_ENABLED", Usage: "machine-translate lesson captions", Bool: true},
+	{Name: "translation-provider", Env: "TRANSLATION_PROVIDER", Usage: "machine translation provider (default deepl)", Values: []string{"deepl", "google"}},
+	{Name: "translation-url", Env: "TRANSLATION_URL", Usage: "translation API base url (default the provider's)"},
+	{Name: "translation-api-key", Env: "TRANSLATION_API_KEY", Usage: "translation API key"},
+	{Name: "translation-languages", Env: "TRANSLATION_LANGUAGES", Usage: "comma-separated languages captions are translated into"},
+	{Name: "tts-provider", Env: "TTS_PROVIDER", Usage: "text-to-speech provider of narrated lessons (default google)", Values: []string{"google", "openai"}},
+	{Name: "tts-url", Env: "TTS_URL", Usage: "text-to-speech API base url (default the provider's)"},
+	{Name: "tts-api-key", Env: "TTS_API_KEY", Usage: "text-to-speech API key"},
+	{Name: "tts-model", Env: "TTS_MODEL", Usage: "openai speech model (default tts-1-hd)"},
+	{Name: "tts-voice", Env: "TTS_VOICE", Usage: "voice narrations are spoken in unless they pick one"},
+	{Name: "tts-language", Env: "TTS_LANGUAGE", Usage: "language narrations are spoken in unless they pick one (default en-US)"},
+	{Name: "music-check-enabled", Env: "MUSIC_CHECK_ENABLED", Usage: "flag lessons with likely copyrighted music for review", Bool: true},
+	{Name: "music-provider", Env: "MUSIC_PROVIDER", Usage: "audio fingerprint provider (default acrcloud)", Values: []string{"acrcloud", "audd"}},
+	{Name: "music-url", Env: "MUSIC_URL", Usage: "fingerprint API base url, the project host for acrcloud"},
+	{Name: "music-api-key", Env: "MUSIC_API_KEY", Usage: "fingerprint API key or access key"},
+	{Name: "music-api-secret", Env: "MUSIC_API_SECRET", Usage: "acrcloud access secret"},
+	{Name: "music-sample-interval", Env: "MUSIC_SAMPLE_INTERVAL", Usage: "seconds between audio samples looked up (default 60)"},
+	{Name: "music-sample-length", Env: "MUSIC_SAMPLE_LENGTH", Usage: "seconds of audio in each sample (default 12)"},
+	{Name: "music-min-score", Env: "MUSIC_MIN_SCORE", Usage: "least match score out of 100 that is flagged (default 70)"},
+	{Name: "diarization-enabled", Env: "DIARIZATION_ENABLED", Usage: "label who speaks when in lesson videos' captions", Bool: true},
+	{Name: "diarization-url", Env: "DIARIZATION_URL", Usage: "diarization service lesson audio is posted to"},
+	{Name: "diarization-api-key", Env: "DIARIZATION_API_KEY", Usage: "bearer token of the diarization service"},
+	{Name: "diarization-max-speakers", Env: "DIARIZATION_MAX_SPEAKERS", Usage: "most speakers told apart in a video (default as many as are heard)"},
+	{Name: "redaction-enabled", Env: "REDACTION_ENABLED", Usage: "flag profanity, email addresses and phone numbers in lesson transcripts", Bool: true},
+	{Name: "redaction-words", Env: "REDACTION_WORDS", Usage: "comma-separated words flagged besides the built-in profanity list"},
+	{Name: "redaction-bleep", Env: "REDACTION_BLEEP", Usage: "mask flagged words in captions and bleep them in the audio", Bool: true},
+	{Name: "redaction-padding", Env: "REDACTION_PADDING", Usage: "seconds bleeped either side of a flagged word (default 0.25)"},
+	{Name: "fingerprint-enabled", Env: "FINGERPRINT_ENABLED", Usage: "flag uploads that duplicate another lesson's video for review", Bool: true},
+	{Name: "fingerprint-frame-interval", Env: "FINGERPRINT_FRAME_INTERVAL", Usage: "seconds between the frames hashed (default 2)"},
+	{Name: "fingerprint-frame-distance", Env: "FINGERPRINT_FRAME_DISTANCE", Usage: "most bits two hashes of the same frame differ by (default 6)"},
+	{Name: "fingerprint-min-similarity", Env: "FINGERPRINT_MIN_SIMILARITY", Usage: "least share of frames or audio shared with another lesson that is flagged (default 0.8)"},
+	{Name: "accessibility-enabled", Env: "ACCESSIBILITY_ENABLED", Usage: "check each lesson video for the accessibility report", Bool: true},
+	{Name: "accessibility-sample-interval", Env: "ACCESSIBILITY_SAMPLE_INTERVAL", Usage: "seconds between frames checked for legibility at 240p (default 10)"},
+	{Name: "accessibility-loudness-target", Env: "ACCESSIBILITY_LOUDNESS_TARGET", Usage: "integrated loudness lessons should have, in LUFS (default -16)"},
+	{Name: "accessibility-loudness-tolerance", Env: "ACCESSIBILITY_LOUDNESS_TOLERANCE", Usage: "LU a lesson's loudness may be off its target (default 2)"},
+	{Name: "accessibility-max-true-peak", Env: "ACCESSIBILITY_MAX_TRUE_PEAK", Usage: "highest true peak a lesson may have, in dBTP (default -1)"},
+	{Name: "accessibility-min-caption-coverage", Env: "ACCESSIBILITY_MIN_CAPTION_COVERAGE", Usage: "share of a lesson its captions must cover (default 0.9)"},
+	{Name: "accessibility-min-legibility", Env: "ACCESSIBILITY_MIN_LEGIBILITY", Usage: "least SSIM a frame keeps at 240p for its text to count as legible (default 0.85)"},
+	{Name: "accessibility-require-audio-description", Env: "ACCESSIBILITY_REQUIRE_AUDIO_DESCRIPTION", Usage: "count lessons without an audio description as not compliant", Bool: true},
+	{Name: "quality-enabled", Env: "QUALITY_ENABLED", Usage: "score each encoded rendition with VMAF against its source", Bool: true},
+	{Name: "quality-subsample", Env: "QUALITY_SUBSAMPLE", Usage: "score every nth frame only (default 5)"},
+	{Name: "quality-model", Env: "QUALITY_MODEL", Usage: "VMAF model to score with, e.g. version=vmaf_v0.6.1neg (default the filter's own)"},
+	{Name: "media-deletion-grace", Env: "MEDIA_DELETION_GRACE", Usage: "seconds a media deletion waits, and can be cancelled, before it runs (default 0)"},
+	{Name: "video-version-grace", Env: "VIDEO_VERSION_GRACE", Usage: "seconds a replaced lesson video is kept for rollback (default 604800)"},
+	{Name: "course-exchange", Env: "COURSE_EXCHANGE", Usage: "exchange courses ready to publish are announced on (default course_events)"},
+	{Name: "download-enabled", Env: "DOWNLOAD_ENABLED", Usage: "make an offline mp4 of each lesson for the mobile app", Bool: true},
+	{Name: "download-height", Env: "DOWNLOAD_HEIGHT", Usage: "largest height of offline renditions (default 480)"},
+	{Name: "download-offline-days", Env: "DOWNLOAD_OFFLINE_DAYS", Usage: "days the app may keep a downloaded lesson (default 30)"},
+	{Name: "download-link-ttl", Env: "DOWNLOAD_LINK_TTL", Usage: "seconds a download link is valid (default 3600)"},
+	{Name: "export-link-ttl", Env: "EXPORT_LINK_TTL", Usage: "seconds a content package export link is valid (default 3600)"},
+	{Name: "media-image-widths", Env: "MEDIA_IMAGE_WIDTHS", Usage: "widths images are resized to unless their job picks its own (default 320,640,1280)"},
+	{Name: "media-thumbnail-width", Env: "MEDIA_THUMBNAIL_WIDTH", Usage: "width of a document's first page thumbnail (default 320)"},
+	{Name: "media-preview-width", Env: "MEDIA_PREVIEW_WIDTH", Usage: "width of a document's preview pages (default 1280)"},
+	{Name: "media-preview-pages", Env: "MEDIA_PREVIEW_PAGES", Usage: "most preview pages rendered of a document (default 10)"},
+	{Name: "media-soffice-path", Env: "MEDIA_SOFFICE_PATH", Usage: "LibreOffice binary office documents are converted to PDF with (default soffice)"},
+	{Name: "live-origin-hosts", Env: "LIVE_ORIGIN_HOSTS", Usage: "hosts live recordings may be imported from, a leading dot for a domain (default none)"},
+	{Name: "live-import-timeout", Env: "LIVE_IMPORT_TIMEOUT", Usage: "seconds a live recording import may take (default 14400)"},
+	{Name: "live-part-seconds", Env: "LIVE_PART_SECONDS", Usage: "length of a low-latency package's partial segments (default 1)"},
+	{Name: "podcast-enabled", Env: "PODCAST_ENABLED", Usage: "publish courses as private podcasts", Bool: true},
+	{Name: "podcast-base-url", Env: "PODCAST_BASE_URL", Usage: "public URL of the worker, podcast feeds are served under"},
+	{Name: "podcast-signing-key", Env: "PODCAST_SIGNING_KEY", Usage: "key students' podcast feed URLs are signed with"},
+	{Name: "podcast-link-ttl", Env: "PODCAST_LINK_TTL", Usage: "seconds the episode URLs of a podcast feed are valid (default 86400)"},
+	{Name: "keys-enabled", Env: "KEYS_ENABLED", Usage: "encrypt lesson packages with rotatable content keys", Bool: true},
+	{Name: "keys-url", Env: "KEYS_URL", Usage: "URL players fetch content keys from, followed by the key id"},
+	{Name: "keys-master-key-id", Env: "KEYS_MASTER_KEY_ID", Usage: "id of the master key content keys are wrapped with"},
+	{Name: "keys-master-keys", Env: "KEYS_MASTER_KEYS", Usage: "comma-separated id=hex master keys of 32 bytes, current and previous"},
+	{Name: "burn-in-enabled", Env: "BURN_IN_ENABLED", Usage: "make lesson videos with captions burned in for channels without caption support", Bool: true},
+	{Name: "burn-in-height", Env: "BURN_IN_HEIGHT", Usage: "maximum height of a burned-in caption video (default 720)"},
+	{Name: "burn-in-link-ttl", Env: "BURN_IN_LINK_TTL", Usage: "seconds a burned-in caption video link is valid (default 3600)"},
+	{Name: "migration-enabled", Env: "MIGRATION_ENABLED", Usage: "queue the re-transcodes of running codec migrations", Bool: true},
+	{Name: "migration-interval", Env: "MIGRATION_INTERVAL", Usage: "seconds between codec migration scheduling passes (default 300)"},
+	{Name: "migration-max-per-tick", Env: "MIGRATION_MAX_PER_TICK", Usage: "re-transcodes a codec migration queues per pass at most (default 20)"},
+	{Name: "worker-region", Env: "WORKER_REGION", Usage: "region this worker runs in, whose tenants' jobs it runs besides those of no region"},
+	{Name: "worker-region-only", Env: "WORKER_REGION_ONLY", Usage: "run only the jobs of this worker's region", Bool: true},
+	{Name: "regions", Env: "REGIONS", Usage: "comma-separated regions tenants' media may be kept in"},
+	{Name: "maintenance-region", Env: "MAINTENANCE_REGION", Usage: "region whose maintenance windows throttle this worker's backfill lane (default WORKER_REGION)"},
+	{Name: "maintenance-windows", Env: "MAINTENANCE_WINDOWS", Usage: "comma-separated region=zone HH:MM-HH:MM windows heavy background work runs at full throttle in (default always)"},
+	{Name: "maintenance-throttle-share", Env: "MAINTENANCE_THROTTLE_SHARE", Usage: "share of backfill workers, backfill rate and migration queueing kept outside a maintenance window (default 0.25)"},
+	{Name: "warehouse-export-enabled", Env: "WAREHOUSE_EXPORT_ENABLED", Usage: "export pipeline history as Parquet for the analytics warehouse", Bool: true},
+	{Name: "warehouse-bucket", Env: "WAREHOUSE_BUCKET", Usage: "bucket the analytics warehouse's Parquet files are written to"},
+	{Name: "warehouse-export-interval", Env: "WAREHOUSE_EXPORT_INTERVAL", Usage: "minutes between warehouse exports (default 60)"},
+	{Name: "playback-probe-enabled", Env: "PLAYBACK_PROBE_ENABLED", Usage: "fetch random published lesson videos as students do and alert when they can't be played", Bool: true},
+	{Name: "playback-base-url", Env: "PLAYBACK_BASE_URL", Usage: "CDN URL students play the bucket's objects from (default presigned bucket URLs)"},
+	{Name: "playback-probe-interval", Env: "PLAYBACK_PROBE_INTERVAL", Usage: "minutes between playback probes (default 5)"},
+	{Name: "playback-probe-samples", Env: "PLAYBACK_PROBE_SAMPLES", Usage: "lesson videos fetched by each playback probe (default 10)"},
+	{Name: "playback-probe-segments", Env: "PLAYBACK_PROBE_SEGMENTS", Usage: "segments fetched of each sampled video (default 3)"},
+	{Name: "playback-probe-max-latency", Env: "PLAYBACK_PROBE_MAX_LATENCY", Usage: "milliseconds a playback fetch may take before it's alerted on (default 2000)"},
+	{Name: "reconcile-enabled", Env: "RECONCILE_ENABLED", Usage: "check every lesson's video against its version, renditions and the bucket on a schedule", Bool: true},
+	{Name: "reconcile-interval", Env: "RECONCILE_INTERVAL", Usage: "hours between media reconciliations (default 24)"},
+	{Name: "reconcile-batch-size", Env: "RECONCILE_BATCH_SIZE", Usage: "lessons read at a time by a media reconciliation (default 200)"},
+	{Name: "subscriptions-enabled", Env: "SUBSCRIPTIONS_ENABLED", Usage: "deliver job and course events to the webhooks tenants subscribe", Bool: true},
+	{Name: "subscriptions-interval", Env: "SUBSCRIPTIONS_INTERVAL", Usage: "seconds between event deliveries, and the first retry's backoff (default 10)"},
+	{Name: "subscriptions-timeout", Env: "SUBSCRIPTIONS_TIMEOUT", Usage: "seconds a subscriber has to answer a delivery (default 10)"},
+	{Name: "subscriptions-max-attempts", Env: "SUBSCRIPTIONS_MAX_ATTEMPTS", Usage: "times a delivery is tried before it's given up on (default 10)"},
+	{Name: "cdn-signing-enabled", Env: "CDN_SIGNING_ENABLED", Usage: "sign enrolled students' access to lesson packages on the CDN", Bool: true},
+	{Name: "cdn-signing-provider", Env: "CDN_SIGNING_PROVIDER", Usage: "how CDN access is signed (default cloudfront)", Values: []string{"cloudfront", "cloudflare"}},
+	{Name: "cdn-signing-key-id", Env: "CDN_SIGNING_KEY_ID", Usage: "id of the signing key at the CDN: CloudFront's public key id, or the Worker's secret's"},
+	{Name: "cdn-signing-key-file", Env: "CDN_SIGNING_KEY_FILE", Usage: "file of the signing key: a PEM RSA private key for cloudfront, a secret for cloudflare"},
+	{Name: "cdn-signing-cookie-domain", Env: "CDN_SIGNING_COOKIE_DOMAIN", Usage: "domain CloudFront's signed cookies are set for"},
+	{Name: "cdn-signing-ttl", Env: "CDN_SIGNING_TTL", Usage: "seconds a student's CDN access is valid, unless their tenant sets its own (default 3600)"},
+	{Name: "watermark-mode", Env: "WATERMARK_MODE", Usage: "how the student's code is drawn (default visible)", Values: []string{"visible", "invisible"}},
+	{Name: "report-daily-enabled", Env: "REPORT_DAILY_ENABLED", Usage: "publish a daily processing report", Bool: true},
+	{Name: "report-daily-hour", Env: "REPORT_DAILY_HOUR", Usage: "UTC hour the daily report is sent (default 6)"},
+	{Name: "report-recipients", Env: "REPORT_RECIPIENTS", Usage: "comma-separated addresses the daily report goes to"},
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	// Windows are set in their region's time zone, whether or not the image
+	// ships the zone database.
+	_ "time/tzdata"
+)
+
+// Maintenance sets the windows, per region and in the region's own time
+// zone, during which heavy background work runs at full throttle: the
+// backfill lane, backfill batches and codec migrations. Outside them the
+// fleet is shared with latency-sensitive live processing, so that work is
+// capped to Share of its usual concurrency and rate. A region without
+// windows is never capped.
+type Maintenance struct {
+	// Region is the region this worker runs in. Its windows decide how
+	// much of the backfill lane it takes on at once.
+	Region  string
+	Windows []MaintenanceWindow
+	// Share, 0 to 1, is the part of the backfill workers, backfill rate and
+	// MIGRATION_MAX_PER_TICK kept outside a window, at least 1 of each.
+	Share float64
+}
+
+// MaintenanceWindow is a daily stretch of time in Region, from Start until
+// End after midnight in Location. A window whose End is before its Start
+// runs past midnight.
+type MaintenanceWindow struct {
+	Region   string
+	Location *time.Location
+	Start    time.Duration
+	End      time.Duration
+}
+
+// Contains reports whether t falls in the window.
+func (w MaintenanceWindow) Contains(t time.Time) bool {
+	local := t.In(w.Location)
+	since := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute
+	if w.Start <= w.End {
+		return since >= w.Start && since < w.End
+	}
+	return since >= w.Start || since < w.End
+}
+
+func (w MaintenanceWindow) String() string {
+	clock := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return fmt.Sprintf("%s=%s %s-%s", w.Region, w.Location, clock(w.Start), clock(w.End))
+}
+
+// Open reports whether heavy work runs at full throttle in region at t: when
+// one of its windows contains t, or it has none.
+func (m Maintenance) Open(region string, t time.Time) bool {
+	found := false
+	for _, window := range m.Windows {
+		if window.Region != region {
+			continue
+		}
+		if window.Contains(t) {
+			return true
+		}
+		found = true
+	}
+	return !found
+}
+
+// AnyOpen reports whether some region runs heavy work at full throttle at
+// t, so work queued for the whole fleet has somewhere to go.
+func (m Maintenance) AnyOpen(t time.Time) bool {
+	if len(m.Windows) == 0 {
+		return true
+	}
+	for _, window := range m.Windows {
+		if window.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Throttle is what's kept of n outside a window.
+func (m Maintenance) Throttle(n int) int {
+	return max(int(float64(n)*m.Share), 1)
+}
+
+// getEnvWindows parses a list of region=zone HH:MM-HH:MM windows, e.g.
+// "eu=Europe/Berlin 01:00-06:00,us=America/New_York 22:00-04:00". A region
+// may have several.
+func getEnvWindows(key string) ([]MaintenanceWindow, error) {
+	var windows []MaintenanceWindow
+	for _, item := range getEnvList(key) {
+		region, raw, ok := strings.Cut(item, "=")
+		fields := strings.Fields(raw)
+		if !ok || strings.TrimSpace(region) == "" || len(fields) != 2 {
+			return nil, fmt.Errorf("%s: %q is not region=zone HH:MM-HH:MM", key, item)
+		}
+		location, err := time.LoadLocation(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s: time zone of %q: %w", key, item, err)
+		}
+		from, to, ok := strings.Cut(fields[1], "-")
+		start, startErr := parseClock(from)
+		end, endErr := parseClock(to)
+		if !ok || startErr != nil || endErr != nil || start == end {
+			return nil, fmt.Errorf("%s: %q must run from one HH:MM to another", key, item)
+		}
+		windows = append(windows, MaintenanceWindow{
+			Region:   strings.TrimSpace(region),
+			Location: location,
+			Start:    start,
+			End:      end,
+		})
+	}
+	return windows, nil
+}
+
+// parseClock parses a time of day, HH:MM, as the time since midnight.
+func parseClock(value string) (time.Duration, error) {
+	clock, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute, nil
+}
@@ -9,8 +9,13 @@ import (
 	"time"
 )
 
+// URL is the AMQP address of the broker, credentials included.
+func (cfg *RabbitMQ) URL() string {
+	return fmt.Sprintf("amqp://%s:%s@%s:%d/", cfg.User, cfg.Pass, cfg.Host, cfg.Port)
+}
+
 func NewRabbitMQConn(ctx context.Context, cfg *RabbitMQ) (*amqp.Connection, error) {
-	connAddr := fmt.Sprintf("amqp://%s:%s@%s:%d/", cfg.User, cfg.Pass, cfg.Host, cfg.Port)
+	connAddr := cfg.URL()
 
 	operation := func() (*amqp.Connection, error) {
 		conn, err := amqp.Dial(connAddr)
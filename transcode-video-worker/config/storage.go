@@ -0,0 +1,106 @@
+package config
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"worker-transcode/pkg/breaker"
+	"worker-transcode/pkg/cdn"
+	"worker-transcode/pkg/chaos"
+	"worker-transcode/pkg/objectstore"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/s3utils"
+)
+
+// bucketLookups are the MINIO_BUCKET_LOOKUP styles: auto leaves it to the
+// client, which picks virtual-host style for AWS and path style for
+// everything else.
+var bucketLookups = map[string]minio.BucketLookupType{
+	"auto":         minio.BucketLookupAuto,
+	"path":         minio.BucketLookupPath,
+	"virtual-host": minio.BucketLookupDNS,
+}
+
+// storageEndpoint is the host:port the storage client talks to and whether
+// over TLS. MINIO_URL is either a bare host:port, reached as MINIO_USE_SSL
+// says, or a URL whose scheme says it, for providers documented with one.
+func storageEndpoint(raw string, secure bool) (string, bool, error) {
+	if !strings.Contains(raw, "://") {
+		return raw, secure, nil
+	}
+	endpoint, err := url.Parse(raw)
+	if err != nil {
+		return "", false, fmt.Errorf("MINIO_URL: %w", err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return "", false, fmt.Errorf("MINIO_URL: unsupported scheme %q", endpoint.Scheme)
+	}
+	if strings.Trim(endpoint.Path, "/") != "" {
+		return "", false, errors.New("MINIO_URL can't have a path")
+	}
+	return endpoint.Host, endpoint.Scheme == "https", nil
+}
+
+// storageRegion resolves the region of an endpoint MINIO_REGION doesn't set:
+// AWS S3's from its host, as the client would, and DigitalOcean Spaces' from
+// the host's first label, e.g. nyc3 of nyc3.digitaloceanspaces.com. Other
+// endpoints have none, so the client asks the bucket's location, which
+// MinIO answers with its own region.
+func storageRegion(endpoint url.URL) string {
+	if region := s3utils.GetRegionFromURL(endpoint); region != "" {
+		return region
+	}
+	host := endpoint.Hostname()
+	if region, ok := strings.CutSuffix(host, ".digitaloceanspaces.com"); ok && !strings.Contains(region, ".") {
+		return region
+	}
+	return ""
+}
+
+// NewStorage makes the client of the bucket cfg points at. Its requests go
+// through the storage circuit breaker, and through chaos when that is on.
+func NewStorage(cfg ObjectStore) (objectstore.Store, error) {
+	transport, err := minio.DefaultTransport(true)
+	if err != nil {
+		return nil, err
+	}
+	if transport.TLSClientConfig != nil {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	// Every job talks to the one MinIO host, several objects at a time, so
+	// enough idle connections are kept to reuse one rather than dial and
+	// handshake per request.
+	transport.MaxIdleConnsPerHost = 64
+	transport.IdleConnTimeout = 5 * time.Minute
+
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:              credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure:             cfg.Secure,
+		Region:             cfg.Region,
+		BucketLookup:       cfg.BucketLookup,
+		CustomRegionViaURL: storageRegion,
+		Transport:          breaker.Transport(breaker.Storage, chaos.Transport(transport)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objectstore.NewMinIO(client), nil
+}
+
+// NewCDNSigner makes the signer of students' access to the CDN, nil when
+// cfg isn't enabled.
+func NewCDNSigner(cfg CDNSigning) (cdn.Signer, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	signer, err := cdn.NewSigner(cfg.Provider, cfg.BaseURL, cfg.KeyId, cfg.Key)
+	if err != nil {
+		return nil, fmt.Errorf("CDN_SIGNING_KEY_FILE: %w", err)
+	}
+	return signer, nil
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"github.com/rs/zerolog"
+	"go.temporal.io/sdk/client"
+)
+
+// NewTemporalClient connects to the Temporal server of the workflow engine.
+// The client is closed when ctx is done.
+func NewTemporalClient(ctx context.Context, cfg Workflow) (client.Client, error) {
+	temporal, err := client.DialContext(ctx, client.Options{
+		HostPort:  cfg.Address,
+		Namespace: cfg.Namespace,
+		Logger:    temporalLogger{logger: zerolog.Ctx(ctx)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("connect to temporal at %s: %w", cfg.Address, err)
+	}
+
+	zerolog.Ctx(ctx).Info().Str("namespace", cfg.Namespace).Msg("Successfully connected to Temporal")
+	go func() {
+		<-ctx.Done()
+		temporal.Close()
+		zerolog.Ctx(ctx).Info().Msg("Temporal client closed")
+	}()
+
+	return temporal, nil
+}
+
+// temporalLogger writes the SDK's logs with the worker's own logger.
+type temporalLogger struct {
+	logger *zerolog.Logger
+}
+
+func (l temporalLogger) Debug(msg string, keyvals ...interface{}) {
+	l.logger.Debug().Fields(keyvals).Msg(msg)
+}
+
+func (l temporalLogger) Info(msg string, keyvals ...interface{}) {
+	l.logger.Info().Fields(keyvals).Msg(msg)
+}
+
+func (l temporalLogger) Warn(msg string, keyvals ...interface{}) {
+	l.logger.Warn().Fields(keyvals).Msg(msg)
+}
+
+func (l temporalLogger) Error(msg string, keyvals ...interface{}) {
+	l.logger.Error().Fields(keyvals).Msg(msg)
+}
@@ -7,13 +7,468 @@ const (
 	JobStatusProcessing JobStatus = "PROCESSING"
 	JobStatusFailed     JobStatus = "FAILED"
 	JobStatusCompleted  JobStatus = "COMPLETED"
+	// JobStatusQualityFailed holds a job whose package fell below its
+	// preset's quality gate: it is uploaded but not published until ops
+	// override the gate.
+	JobStatusQualityFailed JobStatus = "QUALITY_FAILED"
 )
 
+// JobType values match the JobType enum the API persists in jobs.job_type.
 type JobType string
 
 const (
-	JobTypeTranscoder     JobType = "transcoder"
-	JobTypeRecordingMerge JobType = "recording_merge"
+	JobTypeTranscoder     JobType = "VIDEO_TRANSCODING"
+	JobTypeRecordingMerge JobType = "RECORDING_MERGE"
+	JobTypeWatermark      JobType = "FORENSIC_WATERMARK"
+	JobTypeTranslation    JobType = "CAPTION_TRANSLATION"
+	JobTypeNarration      JobType = "NARRATED_VIDEO"
+	JobTypeExport         JobType = "CONTENT_EXPORT"
+	JobTypeMedia          JobType = "MEDIA_PROCESSING"
+	JobTypeLiveImport     JobType = "LIVE_IMPORT"
+	JobTypePodcast        JobType = "PODCAST_FEED"
+	JobTypeKeyRotation    JobType = "KEY_ROTATION"
+	JobTypeBurnIn         JobType = "CAPTION_BURN_IN"
+	JobTypeAudioReplace   JobType = "AUDIO_REPLACEMENT"
+	JobTypeRegeneration   JobType = "ARTIFACT_REGENERATION"
+	JobTypeDeletion       JobType = "MEDIA_DELETION"
+)
+
+// EntityType mirrors the API's UploadPurpose enum, which Hibernate stores in
+// jobs.entity_type by ordinal.
+type EntityType string
+
+const (
+	EntityTypeUserAvatar      EntityType = "0"
+	EntityTypeCourseThumbnail EntityType = "1"
+	EntityTypeLessonResource  EntityType = "2"
+	EntityTypeLessonVideo     EntityType = "3"
+	EntityTypeBatchThumbnail  EntityType = "5"
+)
+
+// MediaKind is what a media job makes of its source.
+type MediaKind string
+
+const (
+	// MediaKindImage resizes an image, such as a course cover, to each of
+	// the configured widths.
+	MediaKindImage MediaKind = "image_resize"
+	// MediaKindDocument renders a PDF or office document, such as a lesson
+	// attachment, to a thumbnail and preview pages.
+	MediaKindDocument MediaKind = "document_preview"
+)
+
+// KeyRotationMode is what a key rotation does to a lesson's content key.
+type KeyRotationMode string
+
+const (
+	// KeyRotationModeRotate encrypts the lesson's package with a new content
+	// key, as after the old one leaked.
+	KeyRotationModeRotate KeyRotationMode = "rotate"
+	// KeyRotationModeRewrap wraps the lesson's content keys with the current
+	// master key, as after the master key changed, leaving the package as it
+	// is.
+	KeyRotationModeRewrap KeyRotationMode = "rewrap"
+)
+
+// ContentKeyStatus is where a content key is. A pending key encrypts a
+// package not yet published; a retired one is served until the version it
+// encrypts expires.
+type ContentKeyStatus string
+
+const (
+	ContentKeyStatusPending ContentKeyStatus = "PENDING"
+	ContentKeyStatusActive  ContentKeyStatus = "ACTIVE"
+	ContentKeyStatusRetired ContentKeyStatus = "RETIRED"
+)
+
+// ErrorClass names the pipeline stage a job failed in.
+type ErrorClass string
+
+const (
+	ErrorClassDownload  ErrorClass = "download"
+	ErrorClassWorkspace ErrorClass = "workspace"
+	ErrorClassProbe     ErrorClass = "probe"
+	ErrorClassTranscode ErrorClass = "transcode"
+	ErrorClassPackage   ErrorClass = "package"
+	ErrorClassUpload    ErrorClass = "upload"
+	ErrorClassVerify    ErrorClass = "verify"
+	ErrorClassTimeout   ErrorClass = "timeout"
+	ErrorClassDatabase  ErrorClass = "database"
+	ErrorClassTranslate ErrorClass = "translate"
+	ErrorClassScan      ErrorClass = "scan"
+	ErrorClassQuota     ErrorClass = "quota"
+	ErrorClassQuality   ErrorClass = "quality"
+)
+
+// ErrorKind names what went wrong when a job failed, whichever stage it
+// failed in. It decides whether the job is tried again.
+type ErrorKind string
+
+const (
+	ErrorKindSourceCorrupt       ErrorKind = "source_corrupt"
+	ErrorKindInvalidInput        ErrorKind = "invalid_input"
+	ErrorKindStorageUnavailable  ErrorKind = "storage_unavailable"
+	ErrorKindDatabaseUnavailable ErrorKind = "database_unavailable"
+	ErrorKindEncoderCrash        ErrorKind = "encoder_crash"
+	ErrorKindEncoderOOM          ErrorKind = "encoder_oom"
+	ErrorKindTimeout             ErrorKind = "timeout"
+	ErrorKindQuotaExceeded       ErrorKind = "quota_exceeded"
+	ErrorKindInfected            ErrorKind = "infected"
+	ErrorKindQualityFailed       ErrorKind = "quality_failed"
+	ErrorKindUnknown             ErrorKind = "unknown"
+)
+
+// Retryable reports whether a job failing with the kind is tried again. An
+// unknown failure is, unless the stage that returned it said otherwise.
+func (k ErrorKind) Retryable() bool {
+	switch k {
+	case ErrorKindStorageUnavailable, ErrorKindDatabaseUnavailable, ErrorKindEncoderCrash, ErrorKindEncoderOOM, ErrorKindUnknown:
+		return true
+	}
+	return false
+}
+
+// JobEventType is the kind of entry recorded on a job's timeline.
+type JobEventType string
+
+const (
+	JobEventStatus    JobEventType = "status"
+	JobEventStage     JobEventType = "stage"
+	JobEventProgress  JobEventType = "progress"
+	JobEventCommand   JobEventType = "command"
+	JobEventError     JobEventType = "error"
+	JobEventOutput    JobEventType = "output"
+	JobEventTrim      JobEventType = "trim"
+	JobEventLadder    JobEventType = "ladder"
+	JobEventRemote    JobEventType = "remote"
+	JobEventCrash     JobEventType = "crash"
+	JobEventHeartbeat JobEventType = "heartbeat"
+	JobEventCleanup   JobEventType = "cleanup"
+)
+
+// BackfillStatus is the state of a backfill batch.
+type BackfillStatus string
+
+const (
+	BackfillStatusRunning   BackfillStatus = "RUNNING"
+	BackfillStatusCompleted BackfillStatus = "COMPLETED"
+	BackfillStatusCancelled BackfillStatus = "CANCELLED"
+	BackfillStatusFailed    BackfillStatus = "FAILED"
+)
+
+// MigrationStatus is the state of a codec migration.
+type MigrationStatus string
+
+const (
+	MigrationStatusRunning   MigrationStatus = "RUNNING"
+	MigrationStatusPaused    MigrationStatus = "PAUSED"
+	MigrationStatusCompleted MigrationStatus = "COMPLETED"
+	MigrationStatusCancelled MigrationStatus = "CANCELLED"
+)
+
+// WorkerStatus is what a worker last reported about itself. A worker whose
+// heartbeat lapsed is shown as lost whatever its status says.
+type WorkerStatus string
+
+const (
+	WorkerStatusActive   WorkerStatus = "ACTIVE"
+	WorkerStatusDraining WorkerStatus = "DRAINING"
+	WorkerStatusStopped  WorkerStatus = "STOPPED"
+	WorkerStatusLost     WorkerStatus = "LOST"
+)
+
+// WatermarkStatus is the state of a student's watermarked rendition.
+type WatermarkStatus string
+
+const (
+	WatermarkStatusPending WatermarkStatus = "PENDING"
+	WatermarkStatusReady   WatermarkStatus = "READY"
+	WatermarkStatusFailed  WatermarkStatus = "FAILED"
+	WatermarkStatusExpired WatermarkStatus = "EXPIRED"
+)
+
+// VideoVersionStatus is the state of a package published for a lesson.
+type VideoVersionStatus string
+
+const (
+	VideoVersionStatusActive   VideoVersionStatus = "ACTIVE"
+	VideoVersionStatusRetained VideoVersionStatus = "RETAINED"
+	VideoVersionStatusDeleted  VideoVersionStatus = "DELETED"
+)
+
+// StorageScope is what storage usage is totalled for.
+type StorageScope string
+
+const (
+	StorageScopeCourse StorageScope = "course"
+	StorageScopeTenant StorageScope = "tenant"
+)
+
+// StorageClass is the kind of package file storage usage is split by: a
+// rung's files by its height, its audio tracks, and everything else.
+type StorageClass string
+
+const (
+	StorageClassSD    StorageClass = "sd"
+	StorageClassHD    StorageClass = "hd"
+	StorageClassFHD   StorageClass = "fhd"
+	StorageClassUHD   StorageClass = "uhd"
+	StorageClassAudio StorageClass = "audio"
+	StorageClassOther StorageClass = "other"
+)
+
+// WarehouseDataset is a table of the analytics warehouse, exported as
+// Parquet files under its name.
+type WarehouseDataset string
+
+const (
+	WarehouseDatasetJobs       WarehouseDataset = "jobs"
+	WarehouseDatasetRenditions WarehouseDataset = "renditions"
+	WarehouseDatasetQC         WarehouseDataset = "qc_flags"
+	WarehouseDatasetUsage      WarehouseDataset = "encoding_usage"
+	WarehouseDatasetStorage    WarehouseDataset = "storage_usage"
+)
+
+// QCCheck names a quality check whose findings are flagged for review.
+type QCCheck string
+
+const (
+	QCCheckCopyrightedMusic QCCheck = "COPYRIGHTED_MUSIC"
+	QCCheckDuplicateContent QCCheck = "DUPLICATE_CONTENT"
+)
+
+// RedactionKind is what a word flagged in a transcript is.
+type RedactionKind string
+
+const (
+	RedactionKindProfanity RedactionKind = "PROFANITY"
+	RedactionKindEmail     RedactionKind = "EMAIL"
+	RedactionKindPhone     RedactionKind = "PHONE"
+)
+
+// LibraryImportStatus is where the import of a hosted video into a lesson
+// is. A failed import is claimed again when the video is imported once more.
+type LibraryImportStatus string
+
+const (
+	LibraryImportStatusPending LibraryImportStatus = "PENDING"
+	LibraryImportStatusQueued  LibraryImportStatus = "QUEUED"
+	LibraryImportStatusFailed  LibraryImportStatus = "FAILED"
+)
+
+// TenantFeature is a stage of lesson processing a tenant's config can turn
+// on or off, overriding the worker's setting for it.
+type TenantFeature string
+
+const (
+	TenantFeatureTrim          TenantFeature = "trim"
+	TenantFeatureBranding      TenantFeature = "branding"
+	TenantFeatureChapters      TenantFeature = "chapters"
+	TenantFeatureSlides        TenantFeature = "slides"
+	TenantFeaturePreview       TenantFeature = "preview"
+	TenantFeaturePoster        TenantFeature = "poster"
+	TenantFeatureDownloads     TenantFeature = "downloads"
+	TenantFeatureAccessibility TenantFeature = "accessibility"
+	TenantFeatureQuality       TenantFeature = "quality"
+	TenantFeatureMusic         TenantFeature = "music"
+	TenantFeatureDiarization   TenantFeature = "diarization"
+	TenantFeatureRedaction     TenantFeature = "redaction"
+	TenantFeatureBleep         TenantFeature = "bleep"
+	TenantFeaturePublish       TenantFeature = "publish"
+	// TenantFeatureSecureScratch keeps a tenant's sources and everything made
+	// of them in memory-backed scratch space rather than on disk.
+	TenantFeatureSecureScratch TenantFeature = "secure_scratch"
+)
+
+// TenantFeatures are the features a tenant config may set.
+var TenantFeatures = []TenantFeature{
+	TenantFeatureTrim, TenantFeatureBranding, TenantFeatureChapters, TenantFeatureSlides, TenantFeaturePreview,
+	TenantFeaturePoster, TenantFeatureDownloads, TenantFeatureAccessibility, TenantFeatureQuality, TenantFeatureMusic, TenantFeaturePublish,
+	TenantFeatureSecureScratch, TenantFeatureDiarization, TenantFeatureRedaction, TenantFeatureBleep,
+}
+
+// RegenerationArtifact is a part of a published lesson video that can be
+// made again on its own.
+type RegenerationArtifact string
+
+const (
+	RegenerationPosters  RegenerationArtifact = "posters"
+	RegenerationPreview  RegenerationArtifact = "preview"
+	RegenerationSlides   RegenerationArtifact = "slides"
+	RegenerationChapters RegenerationArtifact = "chapters"
+	// RegenerationRendition encodes the rungs a regeneration names again.
+	RegenerationRendition RegenerationArtifact = "rendition"
+	// RegenerationMusic and RegenerationAccessibility run the copyrighted
+	// music check and the accessibility report again.
+	RegenerationMusic         RegenerationArtifact = "music"
+	RegenerationAccessibility RegenerationArtifact = "accessibility"
+)
+
+// RegenerationArtifacts are the artifacts a regeneration may name.
+var RegenerationArtifacts = []RegenerationArtifact{
+	RegenerationPosters, RegenerationPreview, RegenerationSlides, RegenerationChapters, RegenerationRendition,
+	RegenerationMusic, RegenerationAccessibility,
+}
+
+// DeletionScope is what a media deletion erases everything of.
+type DeletionScope string
+
+const (
+	// DeletionScopeLesson erases a lesson's video with everything made of
+	// it: its versions, renditions, captions, posters and transcripts.
+	DeletionScopeLesson DeletionScope = "lesson"
+	// DeletionScopeUser erases what the worker keeps of a user: their
+	// watermarked renditions and avatar, and their name on jobs.
+	DeletionScopeUser DeletionScope = "user"
+)
+
+// ReconciliationProblem is what the media reconciliation found wrong with a
+// lesson's video.
+type ReconciliationProblem string
+
+const (
+	// ReconciliationMissingSource is a lesson pointing at an upload that
+	// isn't in the bucket.
+	ReconciliationMissingSource ReconciliationProblem = "missing_source"
+	// ReconciliationMissingPackage is a lesson pointing at a master
+	// playlist that isn't in the bucket.
+	ReconciliationMissingPackage ReconciliationProblem = "missing_package"
+	// ReconciliationIncompletePackage is a package with a playlist or
+	// segment missing, empty or unfinished.
+	ReconciliationIncompletePackage ReconciliationProblem = "incomplete_package"
+	// ReconciliationMissingRendition is a rendition the job recorded that
+	// the master playlist doesn't list.
+	ReconciliationMissingRendition ReconciliationProblem = "missing_rendition"
+	// ReconciliationVersionMismatch is a lesson playing another package
+	// than its active video version.
+	ReconciliationVersionMismatch ReconciliationProblem = "version_mismatch"
+	// ReconciliationMissingMedia is a transcoded lesson the course catalog
+	// has no measurements of.
+	ReconciliationMissingMedia ReconciliationProblem = "missing_media"
+)
+
+// DeletionStatus is the state of a media deletion.
+type DeletionStatus string
+
+const (
+	// DeletionStatusScheduled waits out its grace window, during which it
+	// can be cancelled.
+	DeletionStatusScheduled DeletionStatus = "SCHEDULED"
+	DeletionStatusQueued    DeletionStatus = "QUEUED"
+	DeletionStatusCompleted DeletionStatus = "COMPLETED"
+	DeletionStatusCancelled DeletionStatus = "CANCELLED"
+)
+
+// ExperimentStatus is whether an encoding experiment is still taking jobs.
+type ExperimentStatus string
+
+const (
+	ExperimentStatusRunning ExperimentStatus = "RUNNING"
+	ExperimentStatusStopped ExperimentStatus = "STOPPED"
+)
+
+// ExperimentArm is the side of an encoding experiment a job is on.
+type ExperimentArm string
+
+const (
+	ExperimentArmControl ExperimentArm = "control"
+	ExperimentArmVariant ExperimentArm = "variant"
+)
+
+// SubscriptionEvent is an event tenants can subscribe a webhook to.
+type SubscriptionEvent string
+
+const (
+	SubscriptionEventJobCompleted SubscriptionEvent = "job.completed"
+	// SubscriptionEventJobFailed is sent for failed jobs and those held
+	// back by their quality gate.
+	SubscriptionEventJobFailed   SubscriptionEvent = "job.failed"
+	SubscriptionEventCourseReady SubscriptionEvent = "course.ready"
+)
+
+// DeliveryStatus is where an event's delivery to a subscription is.
+type DeliveryStatus string
+
+const (
+	// DeliveryStatusPending is waiting for its next attempt.
+	DeliveryStatusPending   DeliveryStatus = "PENDING"
+	DeliveryStatusDelivered DeliveryStatus = "DELIVERED"
+	// DeliveryStatusFailed was given up on after its last attempt; it is
+	// only tried again when redelivered.
+	DeliveryStatusFailed DeliveryStatus = "FAILED"
+)
+
+// PosterSource is how a video's poster frame was picked.
+type PosterSource string
+
+const (
+	// PosterSourceAuto is the best scored frame of the transcode.
+	PosterSourceAuto PosterSource = "auto"
+	// PosterSourceCandidate is another of the transcode's candidates,
+	// picked by the instructor.
+	PosterSourceCandidate PosterSource = "candidate"
+	// PosterSourceOverride is a frame at a time the instructor picked.
+	PosterSourceOverride PosterSource = "override"
+	// PosterSourceUpload is an image the instructor uploaded.
+	PosterSourceUpload PosterSource = "upload"
+)
+
+// ScanStatus is the verdict of a job's malware scan.
+type ScanStatus string
+
+const (
+	ScanStatusClean    ScanStatus = "CLEAN"
+	ScanStatusInfected ScanStatus = "INFECTED"
+)
+
+// QCFlagStatus is where a flag is in review. Open and confirmed flags hold
+// the lesson's course back from publishing; a flag of a video that was
+// replaced is superseded.
+type QCFlagStatus string
+
+const (
+	QCFlagStatusOpen       QCFlagStatus = "OPEN"
+	QCFlagStatusCleared    QCFlagStatus = "CLEARED"
+	QCFlagStatusConfirmed  QCFlagStatus = "CONFIRMED"
+	QCFlagStatusSuperseded QCFlagStatus = "SUPERSEDED"
+)
+
+// QualityGateStatus is the verdict of a job's quality gate. A failed gate
+// holds the job's package back until ops override it.
+type QualityGateStatus string
+
+const (
+	QualityGateStatusPassed     QualityGateStatus = "PASSED"
+	QualityGateStatusFailed     QualityGateStatus = "FAILED"
+	QualityGateStatusOverridden QualityGateStatus = "OVERRIDDEN"
+)
+
+// SLAClass is the service tier of the course a job belongs to. Each class has
+// its own queue, and workers share their capacity between them by weight.
+type SLAClass string
+
+const (
+	SLAClassEnterprise SLAClass = "enterprise"
+	SLAClassPro        SLAClass = "pro"
+	SLAClassFree       SLAClass = "free"
+)
+
+// ParseSLAClass reads a class from a message or request. Jobs that don't
+// name one, or name an unknown one, are pro.
+func ParseSLAClass(raw string) SLAClass {
+	switch class := SLAClass(raw); class {
+	case SLAClassEnterprise, SLAClassFree:
+		return class
+	default:
+		return SLAClassPro
+	}
+}
+
+type SortOrder string
+
+const (
+	SortOrderAsc  SortOrder = "asc"
+	SortOrderDesc SortOrder = "desc"
 )
 
 type Environment string
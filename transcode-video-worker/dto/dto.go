@@ -1,14 +1,1336 @@
 package dto
 
-import "github.com/google/uuid"
+import (
+	"github.com/google/uuid"
+	"time"
+	"worker-transcode/constant"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/search"
+	"worker-transcode/pkg/version"
+)
 
 type JobMessage struct {
 	JobId      uuid.UUID `json:"jobId"`
 	ObjectPath string    `json:"objectPath"`
 	FileName   string    `json:"fileName"`
+	// Preset names the encoding preset to use; empty selects the default ladder.
+	Preset string `json:"preset,omitempty"`
+	// Region is where the job's media must be processed, when the job's row
+	// doesn't say; empty leaves it to the tenant's config.
+	Region string `json:"region,omitempty"`
+	// Requirements are what a worker must have to run the job, when the
+	// job's row doesn't say.
+	Requirements *entities.JobRequirements `json:"requirements,omitempty"`
+	// SLAClass is the tier of the lesson's course; empty means pro.
+	SLAClass string `json:"slaClass,omitempty"`
+	// Chapters are the instructor's chapter markers, packaged into the output.
+	Chapters []ChapterMarker `json:"chapters,omitempty"`
+	// CuePoints are the lesson's in-video interactions, timed into the
+	// output for the player to pause on.
+	CuePoints []CuePoint `json:"cuePoints,omitempty"`
+	// AudioTracks are dubbed audio files packaged as alternate renditions.
+	AudioTracks []AudioTrack `json:"audioTracks,omitempty"`
+	// ScreenRecording marks a recorded screen, whose slides are extracted
+	// into a deck for download.
+	ScreenRecording bool `json:"screenRecording,omitempty"`
+	// NoTrim publishes the source as it is, without cutting dead air.
+	NoTrim bool `json:"noTrim,omitempty"`
+	// Edit is the instructor's cut of the source, applied in place of the
+	// dead air trim.
+	Edit *Edit `json:"edit,omitempty"`
+	// Webcam is a recording of the instructor made alongside ObjectPath, a
+	// screen capture, and composited onto it before packaging.
+	Webcam *Webcam `json:"webcam,omitempty"`
+	// Source is a file in the tenant's cloud drive, copied to ObjectPath
+	// before the job is processed.
+	Source *DriveSource `json:"source,omitempty"`
+	// Parts are the files of a recording split into several, such as a
+	// long Zoom meeting, in order. They are joined into ObjectPath before
+	// the job is processed.
+	Parts []string `json:"parts,omitempty"`
+	// LowLatency packages the output as LL-HLS, with partial segments and
+	// blocking playlist reload, for a replay that goes up as a live class
+	// ends.
+	LowLatency bool `json:"lowLatency,omitempty"`
+	// Speakers name the people speaking in a panel discussion or Q&A
+	// session, in the order they first speak, for its diarized captions.
+	Speakers []string `json:"speakers,omitempty"`
+}
+
+// PipelineInput starts a job's pipeline workflow. Its waits are fixed when
+// the workflow starts, so a replay waits the same as the original run.
+type PipelineInput struct {
+	Message     JobMessage    `json:"message"`
+	CaptionWait time.Duration `json:"captionWait"`
+	CaptionPoll time.Duration `json:"captionPoll"`
+}
+
+// Edit is an instructor's cut of a recording, in seconds of the source: what
+// runs from In to Out is kept, Out 0 for the end, less each of Cuts, such as
+// pre-roll chatter or a break.
+type Edit struct {
+	In   float64    `json:"in,omitempty"`
+	Out  float64    `json:"out,omitempty"`
+	Cuts []CutRange `json:"cuts,omitempty"`
+}
+
+// CutRange is a span of a recording an edit removes.
+type CutRange struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// DriveSource names a file by its id in a tenant's cloud drive, gdrive or
+// onedrive.
+type DriveSource struct {
+	Provider string `json:"provider"`
+	FileId   string `json:"fileId"`
+}
+
+// ChapterMarker starts a chapter Start seconds into the video. The chapter
+// runs until the next marker or the end.
+type ChapterMarker struct {
+	Start float64 `json:"start"`
+	Title string  `json:"title"`
+}
+
+// CuePoint is an interaction At seconds into the video, such as a quiz or a
+// poll. Id is the interaction's id in the platform, which the player looks
+// its content up by.
+type CuePoint struct {
+	Id   string  `json:"id"`
+	Type string  `json:"type"`
+	At   float64 `json:"at"`
+}
+
+// AudioTrack is a dubbed audio file in the bucket. Language is a BCP 47 tag;
+// Name is what the player's track menu shows and defaults to Language.
+// Description marks an audio description of the video for blind and low
+// vision students, which may be in the source's own language.
+type AudioTrack struct {
+	ObjectPath  string `json:"objectPath"`
+	Language    string `json:"language"`
+	Name        string `json:"name,omitempty"`
+	Description bool   `json:"description,omitempty"`
+}
+
+// Webcam is a webcam recording in the bucket composited with the screen
+// capture of a job. Layout is "corner", the webcam inset over the screen in
+// Corner (bottom_right by default), or "side_by_side". Offset is how many
+// seconds after the screen capture the webcam started recording, negative
+// when it started first. Audio picks the recording whose sound is kept,
+// "screen" by default or "webcam".
+type Webcam struct {
+	ObjectPath string  `json:"objectPath"`
+	Layout     string  `json:"layout,omitempty"`
+	Corner     string  `json:"corner,omitempty"`
+	Offset     float64 `json:"offset,omitempty"`
+	Audio      string  `json:"audio,omitempty"`
 }
 
 type RecordingMergeMessage struct {
 	JobId         uuid.UUID `json:"jobId"`
 	LiveSessionId uuid.UUID `json:"liveSessionId"`
-}
\ No newline at end of file
+}
+
+// WatermarkMessage queues the watermarked rendition of a job. The rendition
+// row holds the lesson and student.
+type WatermarkMessage struct {
+	JobId uuid.UUID `json:"jobId"`
+}
+
+// TranslationMessage queues the machine translation of a job's lesson's
+// captions. They are translated from the captions last sent for it.
+type TranslationMessage struct {
+	JobId uuid.UUID `json:"jobId"`
+}
+
+// NarrationMessage queues the narrated video of a job. The narration row
+// holds the deck and script.
+type NarrationMessage struct {
+	JobId uuid.UUID `json:"jobId"`
+}
+
+// ExportMessage queues a content package export of a job. The export row
+// holds the format.
+type ExportMessage struct {
+	JobId uuid.UUID `json:"jobId"`
+}
+
+// MediaMessage queues a media job. The media asset row holds the source and
+// what to make of it.
+type MediaMessage struct {
+	JobId uuid.UUID `json:"jobId"`
+}
+
+// LiveImportMessage queues the import of a live class recording. The live
+// import row holds the recording's URL.
+type LiveImportMessage struct {
+	JobId uuid.UUID `json:"jobId"`
+}
+
+// PodcastMessage queues a build of a course's podcast. The podcast feed row
+// holds the course.
+type PodcastMessage struct {
+	JobId uuid.UUID `json:"jobId"`
+}
+
+// BurnInMessage queues a burned-in caption render. The burned caption row
+// holds the lesson and the language.
+type BurnInMessage struct {
+	JobId uuid.UUID `json:"jobId"`
+}
+
+// AudioReplacementMessage queues the replacement of a lesson's audio. The
+// audio replacement row holds the lesson and the corrected recording.
+type AudioReplacementMessage struct {
+	JobId uuid.UUID `json:"jobId"`
+}
+
+// RegenerationMessage queues the regeneration of artifacts of a lesson's
+// video. The regeneration row holds the lesson and the artifacts.
+type RegenerationMessage struct {
+	JobId uuid.UUID `json:"jobId"`
+}
+
+// DeletionMessage queues a media deletion whose grace window is over. The
+// deletion row holds its scope.
+type DeletionMessage struct {
+	JobId uuid.UUID `json:"jobId"`
+}
+
+// KeyRotationMessage queues the rotation of a lesson's content key. The key
+// rotation row holds the lesson and the mode.
+type KeyRotationMessage struct {
+	JobId uuid.UUID `json:"jobId"`
+}
+
+// ExportRequest is the body of POST /api/v1/lessons/:id/exports. Format is
+// scorm12 or scorm2004.
+type ExportRequest struct {
+	Format string     `json:"format"`
+	UserId *uuid.UUID `json:"user_id"`
+}
+
+// ExportLink is a content package export with a URL it can be downloaded
+// from until URLExpiresAt, once the package is made.
+type ExportLink struct {
+	*entities.ContentExport
+	URL          string     `json:"url,omitempty"`
+	URLExpiresAt *time.Time `json:"url_expires_at,omitempty"`
+}
+
+// BurnInRequest is the body of POST /api/v1/lessons/:id/burned-captions.
+// Language names one of the lesson's caption tracks.
+type BurnInRequest struct {
+	Language string     `json:"language" binding:"required"`
+	UserId   *uuid.UUID `json:"user_id"`
+}
+
+// AudioReplacementRequest is the body of POST /api/v1/lessons/:id/audio.
+// ObjectPath is the corrected recording in the bucket, audio or a video
+// whose first audio stream is taken, as long as the lesson's video. Without
+// one, the lesson's own audio is kept with a tone played over Bleeps.
+type AudioReplacementRequest struct {
+	ObjectPath string          `json:"object_path"`
+	Bleeps     entities.Bleeps `json:"bleeps"`
+	UserId     *uuid.UUID      `json:"user_id"`
+}
+
+// RegenerationRequest is the body of POST /api/v1/lessons/:id/regenerations.
+// Artifacts are constant.RegenerationArtifact names; Heights are the rungs
+// to encode again, and only taken with the rendition artifact.
+type RegenerationRequest struct {
+	Artifacts []string   `json:"artifacts" binding:"required,min=1"`
+	Heights   []int      `json:"heights"`
+	UserId    *uuid.UUID `json:"user_id"`
+}
+
+// DeletionRequest is the body of POST /api/v1/deletions. Scope is a
+// constant.DeletionScope and ScopeId the lesson or user it erases.
+// GraceSeconds, when set, takes the place of the configured grace window;
+// zero deletes straight away. Reason is kept on the deletion for audit.
+type DeletionRequest struct {
+	Scope        string     `json:"scope" binding:"required"`
+	ScopeId      uuid.UUID  `json:"scope_id" binding:"required"`
+	GraceSeconds *int       `json:"grace_seconds"`
+	Reason       string     `json:"reason"`
+	UserId       *uuid.UUID `json:"user_id"`
+}
+
+// BurnInLink is a burned-in caption video with a URL it can be downloaded
+// from until URLExpiresAt, once the video is made.
+type BurnInLink struct {
+	*entities.BurnedCaption
+	URL          string     `json:"url,omitempty"`
+	URLExpiresAt *time.Time `json:"url_expires_at,omitempty"`
+}
+
+// NarrationRequest is the body of POST /api/v1/lessons/:id/narration. DeckKey
+// is a PDF in the bucket with one page per slide. Voice and Language default
+// to the worker's.
+type NarrationRequest struct {
+	DeckKey  string           `json:"deck_key" binding:"required"`
+	Slides   []NarrationSlide `json:"slides" binding:"required"`
+	Voice    string           `json:"voice"`
+	Language string           `json:"language"`
+	UserId   *uuid.UUID       `json:"user_id"`
+}
+
+// MediaRequest is the body of POST /api/v1/media. Kind is image_resize, for
+// SourceKey an image resized to each of Widths, the worker's when empty; or
+// document_preview, for a PDF or office document rendered to a thumbnail and
+// up to Pages preview pages, the worker's when 0. Purpose is what the media
+// is of, named like the API's upload purposes: course_thumbnail,
+// batch_thumbnail or user_avatar for an image, lesson_resource for a
+// document. EntityId is the course, batch, user or lesson it belongs to.
+type MediaRequest struct {
+	Kind      string     `json:"kind" binding:"required"`
+	Purpose   string     `json:"purpose" binding:"required"`
+	EntityId  uuid.UUID  `json:"entity_id" binding:"required"`
+	SourceKey string     `json:"source_key" binding:"required"`
+	Widths    []int      `json:"widths"`
+	Pages     int        `json:"pages"`
+	UserId    *uuid.UUID `json:"user_id"`
+}
+
+// LiveImportRequest is the body of POST /api/v1/lessons/:id/live-import.
+// SourceURL is the finished recording on the streaming origin, an FLV or MP4
+// over HTTP(S) or RTMP, or an HLS playlist; its host must be one of the
+// worker's live origins. Preset is what the lesson is transcoded with, as
+// for an upload when empty; LowLatency packages it as LL-HLS.
+type LiveImportRequest struct {
+	SourceURL     string     `json:"source_url" binding:"required"`
+	LiveSessionId *uuid.UUID `json:"live_session_id"`
+	Preset        string     `json:"preset"`
+	LowLatency    bool       `json:"low_latency"`
+	UserId        *uuid.UUID `json:"user_id"`
+}
+
+// PodcastRequest is the body of POST /api/v1/courses/:id/podcast.
+type PodcastRequest struct {
+	UserId *uuid.UUID `json:"user_id"`
+}
+
+// PodcastLink is the private feed URL a student subscribes to a course's
+// podcast at. It stays valid as long as they are enrolled.
+type PodcastLink struct {
+	CourseId uuid.UUID `json:"course_id"`
+	UserId   uuid.UUID `json:"user_id"`
+	URL      string    `json:"url"`
+}
+
+// PlaybackGrant lets a student play a lesson's video from the CDN until
+// ExpiresAt. The player loads URL; Cookies, when the CDN checks cookies,
+// are set on the student for Domain before it does. Grants are asked for
+// again before they expire to keep playing.
+type PlaybackGrant struct {
+	LessonId  uuid.UUID        `json:"lesson_id"`
+	URL       string           `json:"url"`
+	Cookies   []PlaybackCookie `json:"cookies"`
+	ExpiresAt time.Time        `json:"expires_at"`
+}
+
+type PlaybackCookie struct {
+	Name    string    `json:"name"`
+	Value   string    `json:"value"`
+	Domain  string    `json:"domain"`
+	Path    string    `json:"path"`
+	Expires time.Time `json:"expires"`
+}
+
+// KeyRotationRequest is the body of POST /api/v1/keys/rotations. Every
+// transcoded lesson of CourseIds is rotated in Mode, rotate or rewrap;
+// rotate when empty.
+type KeyRotationRequest struct {
+	CourseIds []uuid.UUID `json:"course_ids" binding:"required"`
+	Mode      string      `json:"mode"`
+	UserId    *uuid.UUID  `json:"user_id"`
+}
+
+// NarrationSlide is what is said over one page of a narrated deck. A slide
+// with a title starts a chapter.
+type NarrationSlide struct {
+	Script string `json:"script"`
+	Title  string `json:"title"`
+}
+
+// DownloadLink is the offline rendition of a lesson with a URL the app can
+// download it from until URLExpiresAt.
+type DownloadLink struct {
+	*entities.Download
+	URL          string    `json:"url"`
+	URLExpiresAt time.Time `json:"url_expires_at"`
+}
+
+// BrandingRequest is the body of PUT /api/v1/tenants/:id/branding.
+type BrandingRequest struct {
+	LogoKey           *string `json:"logo_key"`
+	LogoPosition      string  `json:"logo_position"`
+	PrimaryColor      string  `json:"primary_color"`
+	TextColor         string  `json:"text_color"`
+	TitleCardSeconds  int     `json:"title_card_seconds"`
+	LowerThirdSeconds int     `json:"lower_third_seconds"`
+}
+
+// PublishTargetRequest is the body of PUT /api/v1/tenants/:id/publishing.
+type PublishTargetRequest struct {
+	Provider  string  `json:"provider"`
+	AccountId *string `json:"account_id"`
+	OriginURL *string `json:"origin_url"`
+	APIToken  string  `json:"api_token"`
+}
+
+// TenantConfigRequest is the body of PUT /api/v1/tenants/:id/config. It
+// replaces the whole config: a null preset, max_concurrent_jobs,
+// encoding_minutes_quota or playback_ttl, and a feature left out, fall back
+// to the worker's environment, which sets no limit or quota.
+type TenantConfigRequest struct {
+	Preset               *string         `json:"preset"`
+	MaxConcurrentJobs    *int            `json:"max_concurrent_jobs"`
+	EncodingMinutesQuota *int            `json:"encoding_minutes_quota"`
+	PlaybackTTL          *int            `json:"playback_ttl"`
+	Region               *string         `json:"region"`
+	Features             map[string]bool `json:"features"`
+}
+
+// QuotaEvent tells the billing service about a tenant's encoding minutes:
+// that they reached the warning share of the quota, or the quota, or that a
+// job past it was rejected or processed anyway. JobId is the job it happened
+// on; UsedMinutes don't count a rejected job.
+type QuotaEvent struct {
+	EventId      uuid.UUID `json:"event_id"`
+	EventType    string    `json:"event_type"`
+	OccurredAt   time.Time `json:"occurred_at"`
+	TenantId     uuid.UUID `json:"tenant_id"`
+	JobId        uuid.UUID `json:"job_id"`
+	Period       string    `json:"period"`
+	QuotaMinutes int       `json:"quota_minutes"`
+	UsedMinutes  float64   `json:"used_minutes"`
+}
+
+// EncodingUsage is a tenant's encoding this calendar month against its
+// quota, which is nil when it has none.
+type EncodingUsage struct {
+	TenantId     uuid.UUID `json:"tenant_id"`
+	Period       string    `json:"period"`
+	UsedMinutes  float64   `json:"used_minutes"`
+	Jobs         int       `json:"jobs"`
+	QuotaMinutes *int      `json:"quota_minutes"`
+	Policy       string    `json:"policy"`
+}
+
+// StorageUsage is what a course's or tenant's published packages take up
+// in the bucket, in all and by rendition class.
+type StorageUsage struct {
+	Scope   string              `json:"scope"`
+	Id      uuid.UUID           `json:"id"`
+	Bytes   int64               `json:"bytes"`
+	Objects int64               `json:"objects"`
+	Classes []StorageClassUsage `json:"classes"`
+}
+
+type StorageClassUsage struct {
+	Class   string `json:"class"`
+	Bytes   int64  `json:"bytes"`
+	Objects int64  `json:"objects"`
+}
+
+// ZoomConnectionRequest is the body of PUT /api/v1/tenants/:id/zoom: a
+// server-to-server OAuth app of the tenant's Zoom account, and the preset
+// its recordings are transcoded with.
+type ZoomConnectionRequest struct {
+	AccountId     string `json:"account_id"`
+	ClientId      string `json:"client_id"`
+	ClientSecret  string `json:"client_secret"`
+	WebhookSecret string `json:"webhook_secret"`
+	Preset        string `json:"preset"`
+}
+
+// ZoomMeetingRequest is the body of PUT /api/v1/tenants/:id/zoom/meetings.
+type ZoomMeetingRequest struct {
+	MeetingId int64     `json:"meeting_id"`
+	LessonId  uuid.UUID `json:"lesson_id"`
+}
+
+// LMSWebhookRequest is the body of PUT /api/v1/tenants/:id/lms-webhook: the
+// secret the tenant's LMS signs its webhooks with, the hosts their media may
+// be fetched from and the rules mapping their events to transcode jobs.
+type LMSWebhookRequest struct {
+	Secret       string                    `json:"secret"`
+	AllowedHosts []string                  `json:"allowed_hosts"`
+	Rules        []entities.LMSWebhookRule `json:"rules"`
+}
+
+// LMSWebhookResult answers an LMS webhook: the job the event queued, or
+// ignored when no rule matched it.
+type LMSWebhookResult struct {
+	Status string     `json:"status"`
+	JobId  *uuid.UUID `json:"job_id,omitempty"`
+}
+
+// SubscriptionRequest is the body of POST /api/v1/tenants/:id/subscriptions
+// and of PUT to one of them: the URL the events are POSTed to, the events,
+// the courses and job types they're narrowed to, if any, and whether the
+// subscription is active. A secret is generated when none is given; an
+// update without one keeps the current secret.
+type SubscriptionRequest struct {
+	URL       string      `json:"url"`
+	Events    []string    `json:"events"`
+	CourseIds []uuid.UUID `json:"course_ids"`
+	JobTypes  []string    `json:"job_types"`
+	Secret    string      `json:"secret"`
+	Active    *bool       `json:"active"`
+}
+
+// SubscriptionSecret answers a subscription being created or its secret
+// changed, the only times the secret is returned.
+type SubscriptionSecret struct {
+	*entities.EventSubscription
+	Secret string `json:"secret"`
+}
+
+// SubscribedEvent is the body of a delivery to an event subscription. Job is
+// set for job events and CourseId for the events of a course, job events
+// too when the job's lesson belongs to one. EventId is the same in every
+// delivery of the event, for the subscriber to drop repeats by.
+type SubscribedEvent struct {
+	EventId    uuid.UUID                  `json:"event_id"`
+	EventType  constant.SubscriptionEvent `json:"event_type"`
+	OccurredAt time.Time                  `json:"occurred_at"`
+	TenantId   uuid.UUID                  `json:"tenant_id"`
+	CourseId   *uuid.UUID                 `json:"course_id,omitempty"`
+	Job        *entities.Job              `json:"job,omitempty"`
+}
+
+// DriveConnectionRequest is the body of PUT
+// /api/v1/tenants/:id/drives/:provider: the OAuth client and a refresh token
+// of the account jobs' drive sources are downloaded from.
+type DriveConnectionRequest struct {
+	ClientId     string  `json:"client_id"`
+	ClientSecret string  `json:"client_secret"`
+	RefreshToken string  `json:"refresh_token"`
+	Directory    *string `json:"directory"`
+}
+
+// LibraryRequest reaches a tenant's videos at a hosting provider, vimeo or
+// youtube. The credentials are used for the request and not kept.
+type LibraryRequest struct {
+	Provider  string `json:"provider"`
+	Token     string `json:"token"`
+	APIKey    string `json:"api_key"`
+	ChannelId string `json:"channel_id"`
+}
+
+// LibraryImportRequest is the body of POST
+// /api/v1/tenants/:id/library-imports: the library's videos to take into
+// the lessons they map to, transcoded with Preset.
+type LibraryImportRequest struct {
+	LibraryRequest
+	Preset   string           `json:"preset"`
+	Mappings []LibraryMapping `json:"mappings"`
+}
+
+// LibraryMapping maps a hosted video to the lesson it becomes the video of.
+type LibraryMapping struct {
+	VideoId  string    `json:"video_id"`
+	LessonId uuid.UUID `json:"lesson_id"`
+}
+
+// WatermarkRequest is the body of POST /api/v1/lessons/:id/watermark.
+type WatermarkRequest struct {
+	UserId uuid.UUID `json:"user_id"`
+}
+
+// QCReviewRequest settles a quality check's flag: CLEARED lets the lesson's
+// course publish, CONFIRMED keeps it held until the video is replaced.
+type QCReviewRequest struct {
+	Status     constant.QCFlagStatus `json:"status" binding:"required"`
+	ReviewerId *uuid.UUID            `json:"reviewer_id"`
+	Note       *string               `json:"note"`
+}
+
+// LessonPoster is the still a lesson's video is shown with before it plays,
+// its narrower sizes, and the candidates picked for it when the video was
+// transcoded, best first.
+type LessonPoster struct {
+	LessonId   uuid.UUID             `json:"lesson_id"`
+	Key        string                `json:"key"`
+	Sizes      []PosterSize          `json:"sizes"`
+	At         float64               `json:"at"`
+	Source     constant.PosterSource `json:"source"`
+	Candidates []PosterCandidate     `json:"candidates"`
+}
+
+// PosterSize is the poster scaled down to Width pixels wide.
+type PosterSize struct {
+	Width int    `json:"width"`
+	Key   string `json:"key"`
+}
+
+// PosterCandidate is a frame At seconds into the video and how it scored;
+// frames too dark, washed out or flat score zero.
+type PosterCandidate struct {
+	Key   string  `json:"key"`
+	At    float64 `json:"at"`
+	Score float64 `json:"score"`
+}
+
+// PosterRequest picks a lesson's poster: Candidate, counted from 1, of its
+// candidates, or the frame At seconds into the video.
+type PosterRequest struct {
+	Candidate *int     `json:"candidate"`
+	At        *float64 `json:"at"`
+}
+
+// JobSearchRequest is bound from the query string of GET /api/v1/jobs.
+type JobSearchRequest struct {
+	Status     string `form:"status"`
+	CourseId   string `form:"course_id"`
+	TenantId   string `form:"tenant_id"`
+	ErrorClass string `form:"error_class"`
+	ErrorKind  string `form:"error_kind"`
+	// Tag lists, comma-separated, tags the job's annotations must carry;
+	// Annotation is text one of its annotations' notes must contain.
+	Tag        string `form:"tag"`
+	Annotation string `form:"annotation"`
+	From       string `form:"from"`
+	To         string `form:"to"`
+	Sort       string `form:"sort"`
+	Order      string `form:"order"`
+	Cursor     string `form:"cursor"`
+	Limit      int    `form:"limit"`
+}
+
+// JobSearchCursor marks the last row of a page; the next page starts strictly after it.
+type JobSearchCursor struct {
+	Value time.Time `json:"v"`
+	Id    uuid.UUID `json:"id"`
+}
+
+// JobSearchQuery is the validated form of JobSearchRequest passed to the repository.
+type JobSearchQuery struct {
+	Statuses   []constant.JobStatus
+	CourseId   *uuid.UUID
+	TenantId   *uuid.UUID
+	ErrorClass *constant.ErrorClass
+	ErrorKind  *constant.ErrorKind
+	Tags       []string
+	Annotation *string
+	From       *time.Time
+	To         *time.Time
+	Sort       string
+	Order      constant.SortOrder
+	After      *JobSearchCursor
+	Limit      int
+}
+
+// JobAnnotationRequest attaches a note, tags or both to a job.
+type JobAnnotationRequest struct {
+	Note     string     `json:"note"`
+	Tags     []string   `json:"tags"`
+	AuthorId *uuid.UUID `json:"author_id"`
+}
+
+type JobPage struct {
+	Data       []*entities.Job `json:"data"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+type PresetRequest struct {
+	Name            string              `json:"name"`
+	VideoCodec      string              `json:"video_codec"`
+	AudioCodec      string              `json:"audio_codec"`
+	EncoderPreset   string              `json:"encoder_preset"`
+	SegmentSeconds  int                 `json:"segment_seconds"`
+	KeyframeSeconds int                 `json:"keyframe_seconds"`
+	Renditions      entities.Renditions `json:"renditions"`
+	AllowUpscale    bool                `json:"allow_upscale"`
+	FFmpegArgs      []string            `json:"ffmpeg_args"`
+	// Semver must be above the name's latest; empty bumps its patch.
+	Semver  string                 `json:"semver"`
+	Options entities.PresetOptions `json:"options"`
+}
+
+// PresetDocument is a preset as written in a YAML or JSON file, grouping its
+// settings by what they apply to. Version is the preset's semantic version.
+type PresetDocument struct {
+	Name         string                    `json:"name" yaml:"name"`
+	Version      string                    `json:"version" yaml:"version"`
+	Video        PresetDocumentVideo       `json:"video" yaml:"video"`
+	Audio        PresetDocumentAudio       `json:"audio" yaml:"audio"`
+	Packaging    PresetDocumentPackaging   `json:"packaging" yaml:"packaging"`
+	Renditions   []PresetDocumentRendition `json:"renditions" yaml:"renditions"`
+	AllowUpscale bool                      `json:"allow_upscale" yaml:"allow_upscale"`
+	FFmpegArgs   []string                  `json:"ffmpeg_args" yaml:"ffmpeg_args"`
+	Quality      *PresetDocumentQuality    `json:"quality" yaml:"quality"`
+}
+
+type PresetDocumentVideo struct {
+	Codec           string   `json:"codec" yaml:"codec"`
+	EncoderPreset   string   `json:"encoder_preset" yaml:"encoder_preset"`
+	KeyframeSeconds int      `json:"keyframe_seconds" yaml:"keyframe_seconds"`
+	CRF             *int     `json:"crf" yaml:"crf"`
+	MaxrateFactor   float64  `json:"maxrate_factor" yaml:"maxrate_factor"`
+	BufsizeFactor   float64  `json:"bufsize_factor" yaml:"bufsize_factor"`
+	Filters         []string `json:"filters" yaml:"filters"`
+}
+
+type PresetDocumentAudio struct {
+	Codec string `json:"codec" yaml:"codec"`
+}
+
+type PresetDocumentPackaging struct {
+	SegmentSeconds int      `json:"segment_seconds" yaml:"segment_seconds"`
+	HLSFlags       []string `json:"hls_flags" yaml:"hls_flags"`
+}
+
+// PresetDocumentQuality is the quality gate every rung must pass to be
+// published.
+type PresetDocumentQuality struct {
+	MinPSNR float64 `json:"min_psnr" yaml:"min_psnr"`
+	MinSSIM float64 `json:"min_ssim" yaml:"min_ssim"`
+}
+
+type PresetDocumentRendition struct {
+	Width     int    `json:"width" yaml:"width"`
+	Height    int    `json:"height" yaml:"height"`
+	Bitrate   string `json:"bitrate" yaml:"bitrate"`
+	AudioRate string `json:"audio_rate" yaml:"audio_rate"`
+	// SegmentFormat and Container are the rung's segments, ts or fmp4, and
+	// its progressive copy, if any.
+	SegmentFormat string `json:"segment_format" yaml:"segment_format"`
+	Container     string `json:"container" yaml:"container"`
+}
+
+// QualityOverrideRequest publishes a package its quality gate held back.
+// Note says why ops accepted it.
+type QualityOverrideRequest struct {
+	ReviewerId *uuid.UUID `json:"reviewer_id"`
+	Note       *string    `json:"note"`
+}
+
+// PresetCanaryRequest rolls a new version of a preset out to Percent of its
+// jobs, 1 to 100, while the active version keeps the rest.
+type PresetCanaryRequest struct {
+	PresetRequest
+	Percent int `json:"percent"`
+}
+
+// PresetVersionStats summarises the finished jobs of one preset version.
+// OutputKbps is the average bitrate of the whole ladder and EncodeSpeed how
+// many times faster than realtime the transcode ran.
+type PresetVersionStats struct {
+	Version     int     `json:"version"`
+	Completed   int64   `json:"completed"`
+	Failed      int64   `json:"failed"`
+	FailureRate float64 `json:"failure_rate"`
+	OutputKbps  float64 `json:"output_kbps"`
+	EncodeSpeed float64 `json:"encode_speed"`
+}
+
+// PresetQualityStats averages the scores of one rung of one preset version
+// over the jobs it encoded.
+type PresetQualityStats struct {
+	Version      int     `json:"version"`
+	Height       int     `json:"height"`
+	Renditions   int64   `json:"renditions"`
+	AverageKbps  float64 `json:"average_kbps"`
+	VMAF         float64 `json:"vmaf"`
+	VMAFMin      float64 `json:"vmaf_min"`
+	VMAFHarmonic float64 `json:"vmaf_harmonic"`
+	VMAFPerMbps  float64 `json:"vmaf_per_mbps"`
+}
+
+// PresetCanaryReport compares a canary version with the stable one. Reason
+// explains why it isn't promotable yet.
+type PresetCanaryReport struct {
+	Name       string              `json:"name"`
+	Percent    int                 `json:"percent"`
+	Since      time.Time           `json:"since"`
+	Stable     *PresetVersionStats `json:"stable"`
+	Canary     *PresetVersionStats `json:"canary"`
+	Promotable bool                `json:"promotable"`
+	Reason     string              `json:"reason,omitempty"`
+}
+
+// PresetExperimentRequest starts an encoding experiment: while it runs,
+// Percent of the jobs of ControlPreset, 1 to 99, are encoded with the
+// active version of VariantPreset instead.
+type PresetExperimentRequest struct {
+	Name          string `json:"name"`
+	ControlPreset string `json:"control_preset"`
+	VariantPreset string `json:"variant_preset"`
+	Percent       int    `json:"percent"`
+}
+
+// ExperimentArmStats summarises the finished jobs of one arm of an
+// experiment. OutputBytes is the average size of a job's package,
+// OutputKbps the average bitrate of its whole ladder, EncodeSeconds the
+// average time its transcode took and EncodeSpeed how many times faster
+// than realtime that was. VMAF and VMAFHarmonic average the Scored
+// renditions' scores.
+type ExperimentArmStats struct {
+	Arm           constant.ExperimentArm `json:"arm"`
+	Preset        string                 `json:"preset"`
+	Completed     int64                  `json:"completed"`
+	Failed        int64                  `json:"failed"`
+	FailureRate   float64                `json:"failure_rate"`
+	OutputBytes   float64                `json:"output_bytes"`
+	OutputKbps    float64                `json:"output_kbps"`
+	EncodeSeconds float64                `json:"encode_seconds"`
+	EncodeSpeed   float64                `json:"encode_speed"`
+	Scored        int64                  `json:"scored"`
+	VMAF          float64                `json:"vmaf"`
+	VMAFHarmonic  float64                `json:"vmaf_harmonic"`
+}
+
+// ExperimentRungStats averages the scores of one arm's renditions of one
+// height.
+type ExperimentRungStats struct {
+	Arm          constant.ExperimentArm `json:"arm"`
+	Height       int                    `json:"height"`
+	Renditions   int64                  `json:"renditions"`
+	AverageKbps  float64                `json:"average_kbps"`
+	VMAF         float64                `json:"vmaf"`
+	VMAFHarmonic float64                `json:"vmaf_harmonic"`
+	VMAFPerMbps  float64                `json:"vmaf_per_mbps"`
+}
+
+// ExperimentDelta is how the variant differs from the control: VMAF and
+// the failure rate in points, sizes and encode time as a share of the
+// control's, negative where the variant's is lower.
+type ExperimentDelta struct {
+	VMAF          float64 `json:"vmaf"`
+	FailureRate   float64 `json:"failure_rate"`
+	OutputBytes   float64 `json:"output_bytes"`
+	OutputKbps    float64 `json:"output_kbps"`
+	EncodeSeconds float64 `json:"encode_seconds"`
+}
+
+// PresetExperimentReport compares the arms of an experiment. Reason explains
+// why it isn't conclusive yet.
+type PresetExperimentReport struct {
+	Experiment *entities.PresetExperiment `json:"experiment"`
+	Control    *ExperimentArmStats        `json:"control"`
+	Variant    *ExperimentArmStats        `json:"variant"`
+	Delta      ExperimentDelta            `json:"delta"`
+	Rungs      []ExperimentRungStats      `json:"rungs"`
+	Conclusive bool                       `json:"conclusive"`
+	Reason     string                     `json:"reason,omitempty"`
+}
+
+type JobBumpRequest struct {
+	// Priority defaults to one above the job's current priority.
+	Priority int `json:"priority"`
+	// ObjectPath overrides source discovery when the upload isn't under the lesson's video prefix.
+	ObjectPath string `json:"object_path"`
+}
+
+// UploadInfo is the state of a tus upload, persisted next to its data file.
+type UploadInfo struct {
+	Id        string            `json:"id"`
+	Length    int64             `json:"length"`
+	Offset    int64             `json:"offset"`
+	Metadata  map[string]string `json:"metadata"`
+	JobId     *uuid.UUID        `json:"job_id,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// ChaptersEvent announces a lesson's chapters, detected or given by the
+// instructor, so the player can list them without polling.
+type ChaptersEvent struct {
+	EventId    uuid.UUID           `json:"event_id"`
+	EventType  string              `json:"event_type"`
+	OccurredAt time.Time           `json:"occurred_at"`
+	LessonId   uuid.UUID           `json:"lesson_id"`
+	JobId      uuid.UUID           `json:"job_id"`
+	Chapters   []*entities.Chapter `json:"chapters"`
+}
+
+// LessonStatus is where a lesson of a course is in processing. JobStatus is
+// that of its newest transcode. A lesson with no video has nothing to process
+// and doesn't hold its course back. Flagged is whether a quality check found
+// something in the video a reviewer hasn't cleared.
+type LessonStatus struct {
+	LessonId   uuid.UUID           `json:"lesson_id"`
+	Title      string              `json:"title"`
+	HasVideo   bool                `json:"has_video"`
+	JobStatus  *constant.JobStatus `json:"job_status"`
+	Transcoded bool                `json:"transcoded"`
+	Captioned  bool                `json:"captioned"`
+	Flagged    bool                `json:"flagged"`
+}
+
+// CourseStatus sums up the lessons of a course for the publishing workflow.
+// The course is ready once every video lesson is transcoded and captioned
+// and none is processing or flagged; ReadyAt is when the course ready event went out.
+type CourseStatus struct {
+	CourseId     uuid.UUID      `json:"course_id"`
+	Ready        bool           `json:"ready"`
+	ReadyAt      *time.Time     `json:"ready_at"`
+	VideoLessons int            `json:"video_lessons"`
+	Transcoded   int            `json:"transcoded"`
+	Captioned    int            `json:"captioned"`
+	Processing   int            `json:"processing"`
+	Failed       int            `json:"failed"`
+	Flagged      int            `json:"flagged"`
+	Lessons      []LessonStatus `json:"lessons"`
+}
+
+// CourseReadyEvent announces that a course's videos are all ready to publish.
+type CourseReadyEvent struct {
+	EventId      uuid.UUID `json:"event_id"`
+	EventType    string    `json:"event_type"`
+	OccurredAt   time.Time `json:"occurred_at"`
+	CourseId     uuid.UUID `json:"course_id"`
+	VideoLessons int       `json:"video_lessons"`
+}
+
+// LessonMediaEvent tells the course catalog what a lesson's published video
+// is, so the duration students see is the video's own. It is sent when a
+// video is published and again when the lesson's captions change; the newest
+// OccurredAt wins.
+type LessonMediaEvent struct {
+	EventId          uuid.UUID `json:"event_id"`
+	EventType        string    `json:"event_type"`
+	OccurredAt       time.Time `json:"occurred_at"`
+	LessonId         uuid.UUID `json:"lesson_id"`
+	CourseId         uuid.UUID `json:"course_id"`
+	JobId            uuid.UUID `json:"job_id"`
+	DurationSeconds  float64   `json:"duration_seconds"`
+	Width            int       `json:"width"`
+	Height           int       `json:"height"`
+	AspectRatio      *string   `json:"aspect_ratio"`
+	HasCaptions      bool      `json:"has_captions"`
+	CaptionLanguages []string  `json:"caption_languages"`
+	AudioLanguages   []string  `json:"audio_languages"`
+}
+
+// LessonCaptions is how many seconds of a lesson its captions in Language
+// cover.
+type LessonCaptions struct {
+	LessonId       uuid.UUID `json:"lesson_id"`
+	Language       string    `json:"language"`
+	CoveredSeconds float64   `json:"covered_seconds"`
+}
+
+// AccessibilityReport is a lesson's video measured against the accessibility
+// checks, with its captions as they are now. CaptionCoverage is the share of
+// the video the best covering language captions. Issues names each check the
+// lesson fails.
+type AccessibilityReport struct {
+	*entities.AccessibilityReport
+	CaptionLanguages  []string `json:"caption_languages"`
+	CaptionCoverage   float64  `json:"caption_coverage"`
+	CaptionsCompliant bool     `json:"captions_compliant"`
+	Compliant         bool     `json:"compliant"`
+	Issues            []string `json:"issues"`
+}
+
+// CourseAccessibility sums up the accessibility reports of a course's lessons
+// for an audit. Lessons whose video hasn't been checked yet aren't counted.
+type CourseAccessibility struct {
+	CourseId  uuid.UUID             `json:"course_id"`
+	Checked   int                   `json:"checked"`
+	Compliant int                   `json:"compliant"`
+	Lessons   []AccessibilityReport `json:"lessons"`
+}
+
+// TranscriptRequest is the JSON body of PUT /api/v1/lessons/:id/transcript.
+type TranscriptRequest struct {
+	Language string       `json:"language"`
+	Cues     []search.Cue `json:"cues"`
+}
+
+// MediaEvent is the analytics record published for every transcode that
+// finishes, successfully or not. Fields are only ever added, never renamed.
+type MediaEvent struct {
+	EventId           uuid.UUID           `json:"event_id"`
+	EventType         string              `json:"event_type"`
+	SchemaVersion     int                 `json:"schema_version"`
+	OccurredAt        time.Time           `json:"occurred_at"`
+	JobId             uuid.UUID           `json:"job_id"`
+	JobType           string              `json:"job_type"`
+	EntityId          uuid.UUID           `json:"entity_id"`
+	TenantId          *uuid.UUID          `json:"tenant_id,omitempty"`
+	CorrelationId     string              `json:"correlation_id,omitempty"`
+	ErrorClass        string              `json:"error_class,omitempty"`
+	ErrorKind         string              `json:"error_kind,omitempty"`
+	Preset            string              `json:"preset,omitempty"`
+	PresetVersion     int                 `json:"preset_version,omitempty"`
+	Canary            bool                `json:"canary,omitempty"`
+	ExperimentId      *uuid.UUID          `json:"experiment_id,omitempty"`
+	ExperimentArm     string              `json:"experiment_arm,omitempty"`
+	VideoCodec        string              `json:"video_codec,omitempty"`
+	AudioCodec        string              `json:"audio_codec,omitempty"`
+	Renditions        entities.Renditions `json:"renditions,omitempty"`
+	SkippedRenditions entities.Renditions `json:"skipped_renditions,omitempty"`
+	SourceBytes       int64               `json:"source_bytes"`
+	SourceSeconds     float64             `json:"source_seconds"`
+	TrimmedSeconds    float64             `json:"trimmed_seconds,omitempty"`
+	OutputBytes       int64               `json:"output_bytes"`
+	EncodeSeconds     float64             `json:"encode_seconds"`
+	ProcessingSeconds float64             `json:"processing_seconds"`
+	RemoteProvider    string              `json:"remote_provider,omitempty"`
+	MediaEngine       string              `json:"media_engine,omitempty"`
+}
+
+// UsageRecord meters one completed transcode for billing. RecordId is the
+// job's id, so a record is billed once however often it is delivered.
+// EncodedMinutes is what was encoded, trimmed and branded, and zero when the
+// package was copied from an identical job's. Compute is gpu, cpu or remote,
+// where an external transcoder did the encode.
+type UsageRecord struct {
+	RecordId       uuid.UUID           `json:"record_id"`
+	SchemaVersion  int                 `json:"schema_version"`
+	OccurredAt     time.Time           `json:"occurred_at"`
+	TenantId       *uuid.UUID          `json:"tenant_id"`
+	JobId          uuid.UUID           `json:"job_id"`
+	JobType        string              `json:"job_type"`
+	LessonId       uuid.UUID           `json:"lesson_id"`
+	Preset         string              `json:"preset"`
+	Renditions     entities.Renditions `json:"renditions"`
+	SourceMinutes  float64             `json:"source_minutes"`
+	EncodedMinutes float64             `json:"encoded_minutes"`
+	StoredBytes    int64               `json:"stored_bytes"`
+	StoredGB       float64             `json:"stored_gb"`
+	Compute        string              `json:"compute"`
+	MediaEngine    string              `json:"media_engine,omitempty"`
+	VideoCodec     string              `json:"video_codec"`
+	Reused         bool                `json:"reused"`
+}
+
+// TranscodeResult is the result of a completed transcode, sent with the
+// job's completion so whatever builds on a lesson's video has everything it
+// needs from one message. Keys are object keys in the media bucket. EventId
+// is the job's id, so a result is handled once however often it is
+// delivered.
+//
+// SchemaVersion only changes when a field is renamed, retyped or removed, and
+// a new version goes out on its own routing key beside the old one for a
+// while. Fields are added without a new version, so consumers must ignore
+// fields they don't know.
+type TranscodeResult struct {
+	EventId        uuid.UUID          `json:"event_id"`
+	SchemaVersion  int                `json:"schema_version"`
+	OccurredAt     time.Time          `json:"occurred_at"`
+	JobId          uuid.UUID          `json:"job_id"`
+	JobType        string             `json:"job_type"`
+	LessonId       uuid.UUID          `json:"lesson_id"`
+	TenantId       *uuid.UUID         `json:"tenant_id,omitempty"`
+	CorrelationId  string             `json:"correlation_id,omitempty"`
+	Preset         string             `json:"preset"`
+	PresetVersion  int                `json:"preset_version"`
+	PlaylistKey    string             `json:"playlist_key"`
+	PackageKey     string             `json:"package_key"`
+	PackageBytes   int64              `json:"package_bytes"`
+	Seconds        float64            `json:"duration_seconds"`
+	Reused         bool               `json:"reused"`
+	Renditions     []ResultRendition  `json:"renditions"`
+	AudioTracks    []ResultAudioTrack `json:"audio_tracks"`
+	Captions       []ResultCaption    `json:"captions"`
+	Thumbnails     []ResultThumbnail  `json:"thumbnails"`
+	QC             ResultQC           `json:"qc"`
+	Timings        ResultTimings      `json:"timings"`
+	RemoteProvider string             `json:"remote_provider,omitempty"`
+	MediaEngine    string             `json:"media_engine,omitempty"`
+}
+
+// ResultRendition is one rung of the ladder as published. Bitrate is the
+// preset's target, e.g. 800k; AverageBitrate, in bits per second, is what the
+// segments average over the video.
+type ResultRendition struct {
+	PlaylistKey    string `json:"playlist_key"`
+	Width          int    `json:"width"`
+	Height         int    `json:"height"`
+	Bitrate        string `json:"bitrate"`
+	AverageBitrate int64  `json:"average_bitrate"`
+	VideoCodec     string `json:"video_codec"`
+	AudioCodec     string `json:"audio_codec"`
+	AudioBitrate   string `json:"audio_bitrate"`
+	Segments       int    `json:"segments"`
+	SizeBytes      int64  `json:"size_bytes"`
+}
+
+// ResultAudioTrack is an audio rendition of the package: the video's own
+// audio, and each dub and audio description.
+type ResultAudioTrack struct {
+	PlaylistKey string `json:"playlist_key"`
+	SizeBytes   int64  `json:"size_bytes"`
+}
+
+// ResultCaption is a caption track the lesson has when its video completes.
+type ResultCaption struct {
+	Language          string  `json:"language"`
+	Key               *string `json:"key"`
+	CueCount          int     `json:"cue_count"`
+	CoveredSeconds    float64 `json:"covered_seconds"`
+	MachineTranslated bool    `json:"machine_translated"`
+}
+
+// ResultThumbnail is an image made of the video: its animated preview, or
+// kind slide for each slide extracted from a screen recording.
+type ResultThumbnail struct {
+	Kind      string `json:"kind"`
+	Key       string `json:"key"`
+	SizeBytes int64  `json:"size_bytes"`
+}
+
+// ResultQC is what the checks of the job found. Verified is whether the
+// uploaded package was read back and checked; Problems are what that
+// check let through.
+type ResultQC struct {
+	SourceWarnings    []string            `json:"source_warnings"`
+	SkippedRenditions entities.Renditions `json:"skipped_renditions"`
+	TrimmedSeconds    float64             `json:"trimmed_seconds"`
+	Verified          bool                `json:"verified"`
+	Problems          []string            `json:"problems"`
+}
+
+// ResultTimings is how long the job took, in seconds, overall and per
+// pipeline stage up to its completion.
+type ResultTimings struct {
+	ProcessingSeconds float64            `json:"processing_seconds"`
+	EncodeSeconds     float64            `json:"encode_seconds"`
+	Stages            map[string]float64 `json:"stages"`
+}
+
+// JobStatus is what the instructor UI polls of a job, served from the status
+// cache when there is one.
+type JobStatus struct {
+	JobId        uuid.UUID            `json:"job_id"`
+	Status       constant.JobStatus   `json:"status"`
+	Progress     int                  `json:"progress"`
+	ErrorClass   *constant.ErrorClass `json:"error_class,omitempty"`
+	ErrorKind    *constant.ErrorKind  `json:"error_kind,omitempty"`
+	ErrorMessage *string              `json:"error_message,omitempty"`
+	// Stage is what a processing job was doing at its last heartbeat, at
+	// HeartbeatAt. Stalled is a processing job whose heartbeats stopped: its
+	// worker is gone or stuck, not just slow.
+	Stage       string     `json:"stage,omitempty"`
+	HeartbeatAt *time.Time `json:"heartbeat_at,omitempty"`
+	Stalled     bool       `json:"stalled"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// JobBundleManifest is the manifest.json of a job's support bundle. Missing
+// says, for each part left out, why.
+type JobBundleManifest struct {
+	JobId       uuid.UUID    `json:"job_id"`
+	GeneratedAt time.Time    `json:"generated_at"`
+	Worker      version.Info `json:"worker"`
+	Files       []string     `json:"files"`
+	Missing     []string     `json:"missing"`
+}
+
+// ReconciliationReport is a media reconciliation with the problems it found,
+// by course and lesson.
+type ReconciliationReport struct {
+	Reconciliation *entities.MediaReconciliation     `json:"reconciliation"`
+	Findings       []*entities.ReconciliationFinding `json:"findings"`
+}
+
+// JobTimeline is everything recorded about one job, ordered by time.
+type JobTimeline struct {
+	Job         *entities.Job        `json:"job"`
+	Transitions []JobTransition      `json:"transitions"`
+	Stages      []JobStageSummary    `json:"stages"`
+	Progress    []JobProgressSample  `json:"progress"`
+	Errors      []*entities.JobEvent `json:"errors"`
+	Commands    []string             `json:"commands"`
+	Renditions  entities.Renditions  `json:"renditions"`
+	Events      []*entities.JobEvent `json:"events"`
+}
+
+// JobTransition is a status change; Seconds is how long the job stayed in the
+// status it left.
+type JobTransition struct {
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	At      time.Time `json:"at"`
+	Seconds float64   `json:"seconds"`
+}
+
+type JobStageSummary struct {
+	Stage      string    `json:"stage"`
+	FinishedAt time.Time `json:"finished_at"`
+	Seconds    float64   `json:"seconds"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type JobProgressSample struct {
+	At      time.Time `json:"at"`
+	Percent float64   `json:"percent"`
+	Speed   float64   `json:"speed"`
+	FPS     float64   `json:"fps"`
+}
+
+// DailyReport summarises one UTC day of processing.
+type DailyReport struct {
+	Date         string            `json:"date"`
+	From         time.Time         `json:"from"`
+	To           time.Time         `json:"to"`
+	Jobs         []StatusCount     `json:"jobs"`
+	Processed    int64             `json:"processed"`
+	Failed       int64             `json:"failed"`
+	FailureRate  float64           `json:"failure_rate"`
+	ErrorClasses []ErrorClassCount `json:"error_classes"`
+	EncodeHours  float64           `json:"encode_hours"`
+	Storage      []TenantStorage   `json:"storage"`
+	GeneratedAt  time.Time         `json:"generated_at"`
+}
+
+type StatusCount struct {
+	Status string `json:"status"`
+	Count  int64  `json:"count"`
+}
+
+type ErrorClassCount struct {
+	ErrorClass string `json:"error_class"`
+	Count      int64  `json:"count"`
+}
+
+// JobStats is a snapshot of recent processing, for incident reviews.
+type JobStats struct {
+	Since             string              `json:"since"`
+	From              time.Time           `json:"from"`
+	To                time.Time           `json:"to"`
+	Jobs              []StatusCount       `json:"jobs"`
+	Completed         int64               `json:"completed"`
+	Failed            int64               `json:"failed"`
+	FailureRate       float64             `json:"failure_rate"`
+	ThroughputPerHour float64             `json:"throughput_per_hour"`
+	Turnaround        DurationPercentiles `json:"turnaround"`
+	Processing        DurationPercentiles `json:"processing"`
+	ErrorClasses      []ErrorClassCount   `json:"error_classes"`
+	StageFailures     []StageFailureCount `json:"stage_failures"`
+}
+
+// DurationPercentiles are in seconds.
+type DurationPercentiles struct {
+	Samples int64   `json:"samples"`
+	P50     float64 `json:"p50_seconds"`
+	P95     float64 `json:"p95_seconds"`
+}
+
+type StageFailureCount struct {
+	Stage string `json:"stage"`
+	Count int64  `json:"count"`
+}
+
+type TenantStorage struct {
+	TenantId *uuid.UUID `json:"tenant_id"`
+	Jobs     int64      `json:"jobs"`
+	Bytes    int64      `json:"bytes"`
+}
+
+// CleanupReport lists the bucket objects no lesson or running job accounts for.
+type CleanupReport struct {
+	GeneratedAt time.Time      `json:"generated_at"`
+	DryRun      bool           `json:"dry_run"`
+	MinAge      string         `json:"min_age"`
+	Scanned     int            `json:"scanned"`
+	Orphans     []OrphanObject `json:"orphans"`
+	OrphanBytes int64          `json:"orphan_bytes"`
+	Deleted     int            `json:"deleted"`
+}
+
+type OrphanObject struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	Reason       string    `json:"reason"`
+}
+
+// BackfillRequest starts a backfill batch. Limit caps the number of videos and
+// RatePerMinute paces how fast their jobs are queued.
+type BackfillRequest struct {
+	Filters       entities.BackfillFilters `json:"filters"`
+	Preset        string                   `json:"preset"`
+	Limit         int                      `json:"limit"`
+	RatePerMinute int                      `json:"rate_per_minute"`
+}
+
+// BackfillCandidate is a published lesson video a backfill would re-transcode.
+type BackfillCandidate struct {
+	LessonId uuid.UUID  `json:"lesson_id"`
+	VideoUrl string     `json:"video_url"`
+	TenantId *uuid.UUID `json:"tenant_id"`
+}
+
+// CodecMigrationRequest starts moving the back catalog onto the active
+// version of Preset.
+type CodecMigrationRequest struct {
+	Preset string `json:"preset" binding:"required"`
+}
+
+// MigrationCandidate is a published lesson video a codec migration would
+// re-transcode, with the times students have started it.
+type MigrationCandidate struct {
+	BackfillCandidate
+	CourseId uuid.UUID `json:"course_id"`
+	Plays    int64     `json:"plays"`
+}
+
+// CourseMigrationProgress counts a course's transcoded lessons, those
+// already encoded with the migration's preset, and the re-transcodes the
+// migration queued and saw fail.
+type CourseMigrationProgress struct {
+	CourseId uuid.UUID `json:"course_id"`
+	Lessons  int64     `json:"lessons"`
+	Migrated int64     `json:"migrated"`
+	Enqueued int64     `json:"enqueued"`
+	Failed   int64     `json:"failed"`
+}
+
+// CodecMigrationProgress is a migration together with how far each course
+// has got.
+type CodecMigrationProgress struct {
+	Migration *entities.CodecMigration  `json:"migration"`
+	Courses   []CourseMigrationProgress `json:"courses"`
+}
+
+// BackfillProgress is a batch together with where its jobs are now.
+type BackfillProgress struct {
+	Batch *entities.BackfillBatch `json:"batch"`
+	Jobs  []StatusCount           `json:"jobs"`
+}
+
+// SimulateRequest describes a synthetic load run. Every job transcodes its own
+// copy of Seed, an object already in the bucket.
+type SimulateRequest struct {
+	Seed          string  `json:"seed"`
+	Count         int     `json:"count"`
+	RatePerSecond float64 `json:"rate_per_second"`
+	Preset        string  `json:"preset"`
+	Lane          string  `json:"lane"`
+}
+
+type SimulateResult struct {
+	RunId     string      `json:"run_id"`
+	Published int         `json:"published"`
+	JobIds    []uuid.UUID `json:"job_ids"`
+}
+
+// ReplayRequest is how a job is replayed. AllowDrift replays an object
+// changed since the job read it, in a bucket that doesn't keep versions, as
+// it is now. LocalDir, when set, keeps the replayed package there as well.
+type ReplayRequest struct {
+	AllowDrift bool   `json:"allow_drift"`
+	LocalDir   string `json:"local_dir"`
+}
+
+// ReplayResult is where a replayed package was uploaded. Drift is how what
+// it ran with differs from what the job did, and Skipped the parts of the
+// job it didn't run.
+type ReplayResult struct {
+	JobId         uuid.UUID `json:"job_id"`
+	Prefix        string    `json:"prefix"`
+	Preset        string    `json:"preset"`
+	PresetVersion int       `json:"preset_version"`
+	Bytes         int64     `json:"bytes"`
+	Seconds       float64   `json:"seconds"`
+	Drift         []string  `json:"drift"`
+	Skipped       []string  `json:"skipped"`
+}
@@ -0,0 +1,216 @@
+//go:build e2e
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"gorm.io/gorm"
+)
+
+// resultWait bounds the wait for the result event once the job completed;
+// the outbox relays it within a second or two.
+const resultWait = time.Minute
+
+// checker runs the checks of a finished job, reporting each as it goes, and
+// fails the run if any did.
+type checker struct {
+	failed []string
+}
+
+func (c *checker) report(name string, err error) {
+	if err != nil {
+		c.failed = append(c.failed, name)
+		fmt.Printf("e2e: FAIL %s: %v\n", name, err)
+		return
+	}
+	fmt.Printf("e2e: ok   %s\n", name)
+}
+
+func (c *checker) err() error {
+	if len(c.failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d checks failed: %s", len(c.failed), strings.Join(c.failed, ", "))
+}
+
+// job checks the job row records what it was encoded with.
+func (c *checker) job(job *entities.Job) {
+	var err error
+	switch {
+	case job.Status != constant.JobStatusCompleted:
+		err = fmt.Errorf("status is %s", job.Status)
+	case job.Preset == nil || job.PresetVersion == nil:
+		err = errors.New("preset not recorded")
+	}
+	c.report("job row", err)
+}
+
+// output checks the job's timeline ends with its output and returns the
+// master playlist it names.
+func (c *checker) output(ctx context.Context, events repository.JobEventRepository, jobId uuid.UUID) string {
+	list, err := events.ListJobEvents(ctx, jobId)
+	if err != nil {
+		c.report("output event", err)
+		return ""
+	}
+	for _, event := range list {
+		if event.EventType != constant.JobEventOutput {
+			continue
+		}
+		playlist, _ := event.Data["playlist"].(string)
+		if playlist == "" {
+			c.report("output event", errors.New("no playlist"))
+			return ""
+		}
+		c.report("output event", nil)
+		return playlist
+	}
+	c.report("output event", fmt.Errorf("none among %d events", len(list)))
+	return ""
+}
+
+// pkg checks every variant the master playlist lists, and every segment of
+// each, is in the bucket. It returns the variants' playlist keys.
+func (c *checker) pkg(ctx context.Context, store objectstore.Store, cfg *config.Config, master string) []string {
+	if master == "" {
+		c.report("renditions", errors.New("no playlist to check"))
+		return nil
+	}
+	variants, err := playlistEntries(ctx, store, cfg, master)
+	if err == nil && len(variants) == 0 {
+		err = errors.New("master playlist lists no variants")
+	}
+	for _, variant := range variants {
+		if err != nil {
+			break
+		}
+		var segments []string
+		segments, err = playlistEntries(ctx, store, cfg, variant)
+		if err == nil && len(segments) == 0 {
+			err = fmt.Errorf("%s lists no segments", variant)
+		}
+		for _, segment := range segments {
+			if _, statErr := store.StatObject(ctx, cfg.MinIOBucket, segment, minio.StatObjectOptions{}); statErr != nil {
+				err = fmt.Errorf("%s: %w", segment, statErr)
+				break
+			}
+		}
+	}
+	c.report(fmt.Sprintf("renditions (%d)", len(variants)), err)
+	return variants
+}
+
+// sourceDeleted checks the job's copy of the sample is gone once it was
+// encoded.
+func (c *checker) sourceDeleted(ctx context.Context, store objectstore.Store, cfg *config.Config, jobId uuid.UUID) {
+	var err error
+	for object := range store.ListObjects(ctx, cfg.MinIOBucket, minio.ListObjectsOptions{Prefix: "simulate/", Recursive: true}) {
+		if object.Err != nil {
+			err = object.Err
+			break
+		}
+		if strings.Contains(object.Key, jobId.String()) && path.Base(object.Key) == path.Base(sampleKey) {
+			err = fmt.Errorf("%s still in the bucket", object.Key)
+			break
+		}
+	}
+	c.report("source deleted", err)
+}
+
+// outbox checks the job's result was written with its completion and relayed.
+func (c *checker) outbox(ctx context.Context, db *gorm.DB, jobId uuid.UUID) {
+	var message entities.OutboxMessage
+	err := db.WithContext(ctx).
+		Where("routing_key LIKE ? AND payload->>'job_id' = ?", "transcode.result.%", jobId.String()).
+		First(&message).Error
+	if err == nil && message.PublishedAt == nil {
+		err = fmt.Errorf("not relayed after %d attempts", message.Attempts)
+	}
+	c.report("outbox row", err)
+}
+
+// result waits for the job's result event and checks it describes the
+// package that was checked in the bucket.
+func (c *checker) result(ctx context.Context, deliveries <-chan amqp.Delivery, jobId uuid.UUID, master string, variants []string) {
+	timeout := time.After(resultWait)
+	for {
+		select {
+		case <-ctx.Done():
+			c.report("result event", ctx.Err())
+			return
+		case <-timeout:
+			c.report("result event", fmt.Errorf("none within %s", resultWait))
+			return
+		case delivery, ok := <-deliveries:
+			if !ok {
+				c.report("result event", errors.New("results queue closed"))
+				return
+			}
+			var result dto.TranscodeResult
+			if err := json.Unmarshal(delivery.Body, &result); err != nil || result.JobId != jobId {
+				continue
+			}
+			c.report("result event", compareResult(result, master, variants))
+			return
+		}
+	}
+}
+
+func compareResult(result dto.TranscodeResult, master string, variants []string) error {
+	if result.SchemaVersion != 1 {
+		return fmt.Errorf("schema version %d", result.SchemaVersion)
+	}
+	if result.PlaylistKey != master {
+		return fmt.Errorf("playlist %s, package has %s", result.PlaylistKey, master)
+	}
+	if len(result.Renditions) != len(variants) {
+		return fmt.Errorf("%d renditions, package has %d", len(result.Renditions), len(variants))
+	}
+	for _, rendition := range result.Renditions {
+		if rendition.Segments == 0 || rendition.SizeBytes == 0 {
+			return fmt.Errorf("rendition %dp is empty", rendition.Height)
+		}
+	}
+	return nil
+}
+
+// playlistEntries reads an HLS playlist from the bucket and returns the keys
+// of the playlists or segments it lists, which are relative to it.
+func playlistEntries(ctx context.Context, store objectstore.Store, cfg *config.Config, key string) ([]string, error) {
+	object, err := store.GetObject(ctx, cfg.MinIOBucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	var entries []string
+	scanner := bufio.NewScanner(object)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, path.Join(path.Dir(key), line))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,163 @@
+//go:build e2e
+
+// Command e2e runs the transcode pipeline end to end against throwaway
+// Postgres, RabbitMQ and MinIO containers. It publishes a job for a sample
+// video to a worker built from this tree, then checks the renditions in the
+// bucket, the rows in the database and the result event the job leaves.
+//
+// It needs Docker, and ffmpeg for both the worker and the sample. Run it with
+// make e2e; it exits non-zero when any check fails.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/dto"
+	"worker-transcode/pkg/ffmpeg"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/repository"
+	"worker-transcode/service"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// sampleKey is where the sample video is uploaded. Each job transcodes its
+// own copy, since the worker deletes a job's source once it is done.
+const sampleKey = "e2e/sample.mp4"
+
+type options struct {
+	migrations string
+	timeout    time.Duration
+	keep       bool
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.migrations, "migrations", "../api-edtech/src/main/resources/db/migration", "directory of the API's Flyway migrations, which create the schema")
+	flag.DurationVar(&opts.timeout, "timeout", 10*time.Minute, "time the whole run may take")
+	flag.BoolVar(&opts.keep, "keep", false, "leave the containers and work dir behind for debugging")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+
+	if err := run(ctx, opts); err != nil {
+		fmt.Fprintln(os.Stderr, "e2e:", err)
+		os.Exit(1)
+	}
+	fmt.Println("e2e: all checks passed")
+}
+
+func run(ctx context.Context, opts options) error {
+	dir, err := os.MkdirTemp("", "worker-e2e-")
+	if err != nil {
+		return err
+	}
+	if !opts.keep {
+		defer os.RemoveAll(dir)
+	}
+
+	env, terminate, err := startServices(ctx)
+	if err != nil {
+		return fmt.Errorf("start containers: %w", err)
+	}
+	if !opts.keep {
+		defer terminate()
+	}
+	// The harness reads the same settings as the worker it starts.
+	for name, value := range env {
+		if err := os.Setenv(name, value); err != nil {
+			return err
+		}
+	}
+	cfg, err := config.Load(filepath.Join(dir, ".env"))
+	if err != nil {
+		return err
+	}
+	if err := ffmpeg.Configure(cfg.FFmpeg, 0); err != nil {
+		return err
+	}
+
+	db, err := config.NewDB(cfg.Database)
+	if err != nil {
+		return err
+	}
+	store, err := config.NewStorage(cfg.ObjectStore)
+	if err != nil {
+		return err
+	}
+
+	step("applying migrations")
+	if err := migrate(ctx, db, opts.migrations); err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+
+	step("uploading sample video")
+	if err := store.MakeBucket(ctx, cfg.MinIOBucket, minio.MakeBucketOptions{}); err != nil {
+		return err
+	}
+	sample, err := makeSample(ctx, dir)
+	if err != nil {
+		return fmt.Errorf("make sample: %w", err)
+	}
+	if _, err := store.FPutObject(ctx, cfg.MinIOBucket, sampleKey, sample, minio.PutObjectOptions{ContentType: "video/mp4"}); err != nil {
+		return err
+	}
+
+	conn, err := config.NewRabbitMQConn(ctx, cfg.Queue)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	results, err := listenResults(conn, cfg.Results.Exchange)
+	if err != nil {
+		return fmt.Errorf("listen for results: %w", err)
+	}
+
+	step("starting worker")
+	stopWorker, err := startWorker(ctx, dir, env)
+	if err != nil {
+		return fmt.Errorf("start worker: %w", err)
+	}
+	defer stopWorker()
+
+	step("publishing job")
+	repo := repository.NewRepo(db)
+	published, err := service.NewSimulateService(repo, rabbitmq.NewPublisher(conn), store, cfg).Run(ctx, dto.SimulateRequest{
+		Seed:          sampleKey,
+		Count:         1,
+		RatePerSecond: 1,
+	})
+	if err != nil {
+		return fmt.Errorf("publish job: %w", err)
+	}
+	jobId := published.JobIds[0]
+
+	step("waiting for job " + jobId.String())
+	job, err := waitForJob(ctx, repo, jobId)
+	if err != nil {
+		return err
+	}
+
+	checks := &checker{}
+	checks.job(job)
+	playlist := checks.output(ctx, repository.NewJobEventRepo(repo.GetDB()), jobId)
+	variants := checks.pkg(ctx, store, cfg, playlist)
+	checks.sourceDeleted(ctx, store, cfg, jobId)
+	checks.outbox(ctx, repo.GetDB(), jobId)
+	checks.result(ctx, results, jobId, playlist, variants)
+	return checks.err()
+}
+
+func step(message string) {
+	fmt.Printf("e2e: %s\n", message)
+}
@@ -0,0 +1,64 @@
+//go:build e2e
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// migrationPattern matches a Flyway versioned migration, V1_1_8__name.sql.
+var migrationPattern = regexp.MustCompile(`^V([0-9_]+)__.+\.sql$`)
+
+// migrate applies the API's Flyway migrations to db in version order, which
+// is the schema the worker runs against.
+func migrate(ctx context.Context, db *sql.DB, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	type migration struct {
+		version []int
+		file    string
+	}
+	var migrations []migration
+	for _, entry := range entries {
+		match := migrationPattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		var version []int
+		for _, part := range strings.Split(match[1], "_") {
+			number, err := strconv.Atoi(part)
+			if err != nil {
+				return fmt.Errorf("%s: %w", entry.Name(), err)
+			}
+			version = append(version, number)
+		}
+		migrations = append(migrations, migration{version: version, file: entry.Name()})
+	}
+	if len(migrations) == 0 {
+		return fmt.Errorf("no migrations in %s", dir)
+	}
+	slices.SortFunc(migrations, func(a, b migration) int {
+		return slices.Compare(a.version, b.version)
+	})
+
+	for _, m := range migrations {
+		script, err := os.ReadFile(filepath.Join(dir, m.file))
+		if err != nil {
+			return err
+		}
+		if _, err := db.ExecContext(ctx, string(script)); err != nil {
+			return fmt.Errorf("%s: %w", m.file, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+//go:build e2e
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/testcontainers/testcontainers-go"
+	tcminio "github.com/testcontainers/testcontainers-go/modules/minio"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	tcrabbitmq "github.com/testcontainers/testcontainers-go/modules/rabbitmq"
+)
+
+const (
+	postgresImage = "postgres:16-alpine"
+	rabbitmqImage = "rabbitmq:3.13-management-alpine"
+	minioImage    = "minio/minio:RELEASE.2024-01-16T16-07-38Z"
+
+	serviceUser     = "e2e"
+	servicePassword = "e2e-password"
+	databaseName    = "edtech"
+	bucketName      = "edtech"
+)
+
+// startServices starts the containers the worker depends on and returns the
+// environment that points it at them, along with a func that removes them.
+func startServices(ctx context.Context) (map[string]string, func(), error) {
+	var containers []testcontainers.Container
+	terminate := func() {
+		for _, container := range containers {
+			if err := testcontainers.TerminateContainer(container); err != nil {
+				fmt.Println("e2e: failed to remove container:", err)
+			}
+		}
+	}
+
+	db, err := postgres.Run(ctx, postgresImage,
+		postgres.WithDatabase(databaseName),
+		postgres.WithUsername(serviceUser),
+		postgres.WithPassword(servicePassword),
+		postgres.BasicWaitStrategies(),
+	)
+	if db != nil {
+		containers = append(containers, db)
+	}
+	if err != nil {
+		terminate()
+		return nil, nil, err
+	}
+
+	broker, err := tcrabbitmq.Run(ctx, rabbitmqImage,
+		tcrabbitmq.WithAdminUsername(serviceUser),
+		tcrabbitmq.WithAdminPassword(servicePassword),
+	)
+	if broker != nil {
+		containers = append(containers, broker)
+	}
+	if err != nil {
+		terminate()
+		return nil, nil, err
+	}
+
+	storage, err := tcminio.Run(ctx, minioImage,
+		tcminio.WithUsername(serviceUser),
+		tcminio.WithPassword(servicePassword),
+	)
+	if storage != nil {
+		containers = append(containers, storage)
+	}
+	if err != nil {
+		terminate()
+		return nil, nil, err
+	}
+
+	dbHost, dbPort, err := endpoint(ctx, db, "5432/tcp")
+	if err != nil {
+		terminate()
+		return nil, nil, err
+	}
+	brokerHost, brokerPort, err := endpoint(ctx, broker, "5672/tcp")
+	if err != nil {
+		terminate()
+		return nil, nil, err
+	}
+	storageURL, err := storage.ConnectionString(ctx)
+	if err != nil {
+		terminate()
+		return nil, nil, err
+	}
+
+	env := map[string]string{
+		"APP_ENVIRONMENT":     "develop",
+		"DB_HOST":             dbHost,
+		"DB_PORT":             dbPort,
+		"POSTGRES_DB":         databaseName,
+		"POSTGRES_USER":       serviceUser,
+		"POSTGRES_PASSWORD":   servicePassword,
+		"PGSSLMODE":           "disable",
+		"RABBITMQ_HOST":       brokerHost,
+		"RABBITMQ_PORT":       brokerPort,
+		"RABBITMQ_USER":       serviceUser,
+		"RABBITMQ_PASS":       servicePassword,
+		"MINIO_URL":           storageURL,
+		"MINIO_USE_SSL":       "false",
+		"MINIO_ROOT_USER":     serviceUser,
+		"MINIO_ROOT_PASSWORD": servicePassword,
+		"MINIO_BUCKET":        bucketName,
+		"WORKER_SERVER_PORT":  workerPort,
+		"RESULTS_ENABLED":     "true",
+		// Results are relayed from the outbox; the check waits on them.
+		"BILLING_RELAY_INTERVAL": "1",
+		"SERVER_WORKERS":         "1",
+	}
+	return env, terminate, nil
+}
+
+// endpoint is the host and port the container's port is reachable on.
+func endpoint(ctx context.Context, container testcontainers.Container, port nat.Port) (string, string, error) {
+	host, err := container.Host(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	mapped, err := container.MappedPort(ctx, port)
+	if err != nil {
+		return "", "", err
+	}
+	return host, mapped.Port(), nil
+}
@@ -0,0 +1,151 @@
+//go:build e2e
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+	"worker-transcode/constant"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/ffmpeg"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// workerPort serves the worker's readiness endpoint.
+	workerPort = "18080"
+	// workerGrace is how long the worker gets to drain once stopped.
+	workerGrace  = 30 * time.Second
+	pollInterval = time.Second
+)
+
+// makeSample renders the sample video: a few seconds of ffmpeg's test
+// pattern with a tone, small enough to transcode in seconds.
+func makeSample(ctx context.Context, dir string) (string, error) {
+	sample := filepath.Join(dir, "sample.mp4")
+	output, err := ffmpeg.Command(ctx, "-hide_banner", "-y",
+		"-f", "lavfi", "-i", "testsrc2=size=640x360:rate=25:duration=6",
+		"-f", "lavfi", "-i", "sine=frequency=440:duration=6",
+		"-c:v", "libx264", "-pix_fmt", "yuv420p", "-c:a", "aac", "-shortest",
+		sample,
+	).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, output)
+	}
+	return sample, nil
+}
+
+// startWorker builds the worker from this tree and runs its consumers in
+// dir, returning once it reports ready. The func it returns stops it.
+func startWorker(ctx context.Context, dir string, env map[string]string) (func(), error) {
+	binary := filepath.Join(dir, "worker")
+	if output, err := exec.CommandContext(ctx, "go", "build", "-o", binary, ".").CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("build: %w: %s", err, output)
+	}
+
+	logPath := filepath.Join(dir, "worker.log")
+	log, err := os.Create(logPath)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(binary, "consume", "--env-file", filepath.Join(dir, ".env"))
+	cmd.Dir = dir
+	cmd.Stdout, cmd.Stderr = log, log
+	cmd.Env = os.Environ()
+	for name, value := range env {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+	if err := cmd.Start(); err != nil {
+		log.Close()
+		return nil, err
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		log.Close()
+		close(exited)
+	}()
+	stop := func() {
+		cmd.Process.Signal(syscall.SIGTERM)
+		select {
+		case <-exited:
+		case <-time.After(workerGrace):
+			cmd.Process.Kill()
+			<-exited
+		}
+	}
+
+	ready := "http://localhost:" + workerPort + "/ready"
+	for {
+		if response, err := http.Get(ready); err == nil {
+			response.Body.Close()
+			if response.StatusCode == http.StatusOK {
+				return stop, nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			stop()
+			return nil, fmt.Errorf("worker not ready, see %s: %w", logPath, ctx.Err())
+		case <-exited:
+			return nil, fmt.Errorf("worker exited, see %s", logPath)
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// listenResults binds a queue of the harness's own to the results exchange,
+// declared as the worker declares it, so no result is missed.
+func listenResults(conn *amqp.Connection, exchange string) (<-chan amqp.Delivery, error) {
+	if err := rabbitmq.DeclareExchange(conn, exchange, "topic"); err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	queue, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := ch.QueueBind(queue.Name, "#", exchange, false, nil); err != nil {
+		return nil, err
+	}
+	return ch.Consume(queue.Name, "", true, true, false, false, nil)
+}
+
+// waitForJob polls the job until it settles, failing unless it completed.
+func waitForJob(ctx context.Context, repo repository.JobRepository, jobId uuid.UUID) (*entities.Job, error) {
+	for {
+		job, err := repo.FindJobById(ctx, jobId)
+		if err != nil {
+			return nil, err
+		}
+		switch job.Status {
+		case constant.JobStatusCompleted:
+			return job, nil
+		case constant.JobStatusFailed, constant.JobStatusQualityFailed:
+			reason := "no error recorded"
+			if job.ErrorMessage != nil {
+				reason = *job.ErrorMessage
+			}
+			return nil, fmt.Errorf("job %s is %s: %s", jobId, job.Status, reason)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, errors.Join(fmt.Errorf("job %s still %s", jobId, job.Status), ctx.Err())
+		case <-time.After(pollInterval):
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// AccessibilityReport is what was measured of a lesson's video for its
+// accessibility report. The loudness fields are nil for a video without
+// audio. Legibility240p is the SSIM the least legible sampled frame keeps
+// scaled down to 240 lines, LeastLegibleAt where that frame is.
+type AccessibilityReport struct {
+	LessonId            uuid.UUID `json:"lesson_id" gorm:"type:uuid;primary_key"`
+	JobId               uuid.UUID `json:"job_id" gorm:"type:uuid;not null"`
+	DurationSeconds     float64   `json:"duration_seconds" gorm:"not null"`
+	AudioDescription    bool      `json:"audio_description" gorm:"not null"`
+	IntegratedLUFS      *float64  `json:"integrated_lufs" gorm:"column:integrated_lufs"`
+	TruePeakDBTP        *float64  `json:"true_peak_dbtp" gorm:"column:true_peak_dbtp"`
+	LoudnessRangeLU     *float64  `json:"loudness_range_lu" gorm:"column:loudness_range_lu"`
+	LoudnessCompliant   *bool     `json:"loudness_compliant"`
+	Legibility240p      float64   `json:"legibility_240p" gorm:"column:legibility_240p;not null"`
+	LeastLegibleAt      float64   `json:"least_legible_at" gorm:"not null"`
+	LegibilityCompliant bool      `json:"legibility_compliant" gorm:"not null"`
+	CreatedAt           time.Time `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt           time.Time `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (AccessibilityReport) TableName() string {
+	return "lesson_accessibility_reports"
+}
@@ -0,0 +1,60 @@
+package entities
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"time"
+)
+
+// AudioReplacement is a corrected recording, ObjectPath, put in place of the
+// audio of a lesson's published video, or else the lesson's own audio with
+// a tone played over the stretches in Bleeps. ReplacedPlaylistKey is the
+// package it was made from and PlaylistKey the version it published, set
+// once it is.
+type AudioReplacement struct {
+	ID                  uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	LessonId            uuid.UUID `json:"lesson_id" gorm:"type:uuid;not null"`
+	JobId               uuid.UUID `json:"job_id" gorm:"type:uuid;not null"`
+	ObjectPath          string    `json:"object_path" gorm:"type:varchar(512);not null"`
+	Bleeps              Bleeps    `json:"bleeps" gorm:"type:jsonb;not null"`
+	ReplacedPlaylistKey *string   `json:"replaced_playlist_key" gorm:"type:varchar(512)"`
+	PlaylistKey         *string   `json:"playlist_key" gorm:"type:varchar(512)"`
+	Remuxed             int       `json:"remuxed" gorm:"not null;default:0"`
+	CreatedAt           time.Time `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt           time.Time `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (AudioReplacement) TableName() string {
+	return "audio_replacements"
+}
+
+// Bleep is a stretch of the audio, in seconds, a tone is played over.
+type Bleep struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// Bleeps is the JSONB list of a replacement's bleeps.
+type Bleeps []Bleep
+
+func (b Bleeps) Value() (driver.Value, error) {
+	if b == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(b)
+}
+
+func (b *Bleeps) Scan(value interface{}) error {
+	var raw []byte
+	switch v := value.(type) {
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		return fmt.Errorf("unsupported bleeps type %T", value)
+	}
+	return json.Unmarshal(raw, b)
+}
@@ -0,0 +1,56 @@
+package entities
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"time"
+	"worker-transcode/constant"
+)
+
+type BackfillBatch struct {
+	ID        uuid.UUID               `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Filters   BackfillFilters         `json:"filters" gorm:"type:jsonb;not null"`
+	Preset    *string                 `json:"preset" gorm:"type:varchar(100)"`
+	Status    constant.BackfillStatus `json:"status" gorm:"type:varchar(20);not null"`
+	Total     int                     `json:"total" gorm:"not null;default:0"`
+	Enqueued  int                     `json:"enqueued" gorm:"not null;default:0"`
+	CreatedAt time.Time               `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time               `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (BackfillBatch) TableName() string {
+	return "backfill_batches"
+}
+
+// BackfillFilters selects the published lesson videos a batch re-transcodes.
+// Zero values don't filter.
+type BackfillFilters struct {
+	CourseId *uuid.UUID `json:"course_id,omitempty"`
+	// TranscodedBefore matches videos whose last completed job finished before
+	// this time, or that have no job on record.
+	TranscodedBefore *time.Time `json:"transcoded_before,omitempty"`
+	// MissingHeight matches videos whose last output has no rendition of this
+	// height.
+	MissingHeight int `json:"missing_height,omitempty"`
+	// NotCodec matches videos not known to be encoded with this video codec.
+	NotCodec string `json:"not_codec,omitempty"`
+}
+
+func (f BackfillFilters) Value() (driver.Value, error) {
+	return json.Marshal(f)
+}
+
+func (f *BackfillFilters) Scan(value interface{}) error {
+	var raw []byte
+	switch v := value.(type) {
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		return fmt.Errorf("unsupported backfill filters type %T", value)
+	}
+	return json.Unmarshal(raw, f)
+}
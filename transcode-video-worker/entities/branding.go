@@ -0,0 +1,25 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// BrandingTemplate is how a tenant's lesson videos are branded. A zero
+// TitleCardSeconds or LowerThirdSeconds leaves that element out, as a nil
+// LogoKey does the logo. Colors are #RRGGBB.
+type BrandingTemplate struct {
+	TenantId          uuid.UUID `json:"tenant_id" gorm:"type:uuid;primary_key"`
+	LogoKey           *string   `json:"logo_key" gorm:"type:varchar(512)"`
+	LogoPosition      string    `json:"logo_position" gorm:"type:varchar(20);not null;default:top-right"`
+	PrimaryColor      string    `json:"primary_color" gorm:"type:varchar(7);not null"`
+	TextColor         string    `json:"text_color" gorm:"type:varchar(7);not null"`
+	TitleCardSeconds  int       `json:"title_card_seconds" gorm:"not null;default:0"`
+	LowerThirdSeconds int       `json:"lower_third_seconds" gorm:"not null;default:0"`
+	CreatedAt         time.Time `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt         time.Time `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (BrandingTemplate) TableName() string {
+	return "tenant_branding_templates"
+}
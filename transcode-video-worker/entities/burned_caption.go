@@ -0,0 +1,25 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// BurnedCaption is a lesson's video with its caption track in Language drawn
+// into the picture, for channels that can't load a sidecar. ObjectKey,
+// SizeBytes and Height are set once the video is made.
+type BurnedCaption struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	LessonId  uuid.UUID `json:"lesson_id" gorm:"type:uuid;not null"`
+	JobId     uuid.UUID `json:"job_id" gorm:"type:uuid;not null"`
+	Language  string    `json:"language" gorm:"type:varchar(35);not null"`
+	ObjectKey *string   `json:"object_key" gorm:"type:varchar(512)"`
+	SizeBytes int64     `json:"size_bytes" gorm:"not null;default:0"`
+	Height    int       `json:"height" gorm:"not null;default:0"`
+	CreatedAt time.Time `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (BurnedCaption) TableName() string {
+	return "lesson_burned_captions"
+}
@@ -0,0 +1,24 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// LessonCaption is a caption track of a lesson, one per language. ObjectKey
+// is its WebVTT file; a machine translated track was made from the track in
+// SourceLanguage.
+type LessonCaption struct {
+	LessonId          uuid.UUID `json:"lesson_id" gorm:"type:uuid;primary_key"`
+	Language          string    `json:"language" gorm:"type:varchar(35);primary_key"`
+	CueCount          int       `json:"cue_count" gorm:"not null"`
+	CoveredSeconds    float64   `json:"covered_seconds" gorm:"not null"`
+	ObjectKey         *string   `json:"object_key" gorm:"type:varchar(512)"`
+	MachineTranslated bool      `json:"machine_translated" gorm:"not null"`
+	SourceLanguage    *string   `json:"source_language" gorm:"type:varchar(35)"`
+	UpdatedAt         time.Time `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (LessonCaption) TableName() string {
+	return "lesson_captions"
+}
@@ -0,0 +1,25 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Chapter is a proposed section of a lesson's recording. Source tells what it
+// starts on: the start of the recording, a long pause, or a pause at a scene
+// change.
+type Chapter struct {
+	ID           uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	LessonId     uuid.UUID `json:"lesson_id" gorm:"type:uuid;not null"`
+	JobId        uuid.UUID `json:"job_id" gorm:"type:uuid;not null"`
+	Position     int       `json:"position" gorm:"not null"`
+	StartSeconds float64   `json:"start_seconds" gorm:"not null"`
+	EndSeconds   float64   `json:"end_seconds" gorm:"not null"`
+	Title        string    `json:"title" gorm:"type:varchar(255);not null"`
+	Source       string    `json:"source" gorm:"type:varchar(20);not null"`
+	CreatedAt    time.Time `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (Chapter) TableName() string {
+	return "lesson_chapters"
+}
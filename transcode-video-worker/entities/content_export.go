@@ -0,0 +1,24 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// ContentExport is a lesson packaged for an LMS: a ZIP holding the video,
+// its captions and a SCORM manifest in Format. ObjectKey and SizeBytes are
+// set once the package is made.
+type ContentExport struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	LessonId  uuid.UUID `json:"lesson_id" gorm:"type:uuid;not null"`
+	JobId     uuid.UUID `json:"job_id" gorm:"type:uuid;not null"`
+	Format    string    `json:"format" gorm:"type:varchar(20);not null"`
+	ObjectKey *string   `json:"object_key" gorm:"type:varchar(512)"`
+	SizeBytes int64     `json:"size_bytes" gorm:"not null;default:0"`
+	CreatedAt time.Time `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (ContentExport) TableName() string {
+	return "lesson_content_exports"
+}
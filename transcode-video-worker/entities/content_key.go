@@ -0,0 +1,46 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+	"worker-transcode/constant"
+)
+
+// ContentKey is the AES-128 key a lesson package is encrypted with, made by
+// the job that encrypted it. The key is stored wrapped with the worker's
+// master key MasterKeyId; IV is what the package's playlists declare.
+type ContentKey struct {
+	ID          uuid.UUID                 `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	LessonId    uuid.UUID                 `json:"lesson_id" gorm:"type:uuid;not null"`
+	JobId       uuid.UUID                 `json:"job_id" gorm:"type:uuid;not null"`
+	WrappedKey  []byte                    `json:"-" gorm:"type:bytea;not null"`
+	MasterKeyId string                    `json:"master_key_id" gorm:"type:varchar(100);not null"`
+	IV          []byte                    `json:"-" gorm:"column:iv;type:bytea;not null"`
+	Status      constant.ContentKeyStatus `json:"status" gorm:"type:varchar(20);not null"`
+	ExpiresAt   *time.Time                `json:"expires_at" gorm:"type:timestamptz"`
+	CreatedAt   time.Time                 `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt   time.Time                 `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (ContentKey) TableName() string {
+	return "content_keys"
+}
+
+// KeyRotation is a rotation of a lesson's content key. A rotation in rotate
+// mode sets KeyId and PlaylistKey once its package is published; one in
+// rewrap mode sets Rewrapped to the keys it wrapped anew.
+type KeyRotation struct {
+	ID          uuid.UUID                `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	LessonId    uuid.UUID                `json:"lesson_id" gorm:"type:uuid;not null"`
+	JobId       uuid.UUID                `json:"job_id" gorm:"type:uuid;not null"`
+	Mode        constant.KeyRotationMode `json:"mode" gorm:"type:varchar(20);not null"`
+	KeyId       *uuid.UUID               `json:"key_id" gorm:"type:uuid"`
+	PlaylistKey *string                  `json:"playlist_key" gorm:"type:varchar(512)"`
+	Rewrapped   int                      `json:"rewrapped" gorm:"not null;default:0"`
+	CreatedAt   time.Time                `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt   time.Time                `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (KeyRotation) TableName() string {
+	return "key_rotations"
+}
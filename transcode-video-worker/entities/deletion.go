@@ -0,0 +1,65 @@
+package entities
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"time"
+	"worker-transcode/constant"
+)
+
+// MediaDeletion is the erasure of a lesson's video, or of a user's media,
+// ScopeId naming the lesson or user. It waits until PurgeAfter, hiding the
+// lesson's video in the meantime, and is queued as JobId once it's due.
+type MediaDeletion struct {
+	ID                uuid.UUID               `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Scope             constant.DeletionScope  `json:"scope" gorm:"type:varchar(20);not null"`
+	ScopeId           uuid.UUID               `json:"scope_id" gorm:"type:uuid;not null"`
+	Status            constant.DeletionStatus `json:"status" gorm:"type:varchar(20);not null"`
+	JobId             *uuid.UUID              `json:"job_id" gorm:"type:uuid"`
+	Reason            *string                 `json:"reason"`
+	RequestedBy       *uuid.UUID              `json:"requested_by" gorm:"type:uuid"`
+	HiddenPlaylistKey *string                 `json:"hidden_playlist_key" gorm:"type:varchar(512)"`
+	PurgeAfter        time.Time               `json:"purge_after" gorm:"type:timestamptz;not null"`
+	Report            DeletionReport          `json:"report" gorm:"type:jsonb;not null"`
+	CompletedAt       *time.Time              `json:"completed_at" gorm:"type:timestamptz"`
+	CreatedAt         time.Time               `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt         time.Time               `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (MediaDeletion) TableName() string {
+	return "media_deletions"
+}
+
+// DeletionReport is the audit record of a deletion: the objects removed
+// under each prefix and the rows removed from, or for a user's jobs cleared
+// in, each table. A deletion run
+// again after a failure adds to what the earlier run removed.
+type DeletionReport struct {
+	Objects map[string]DeletedObjects `json:"objects"`
+	Rows    map[string]int64          `json:"rows"`
+}
+
+// DeletedObjects counts the objects removed under a prefix.
+type DeletedObjects struct {
+	Count int   `json:"count"`
+	Bytes int64 `json:"bytes"`
+}
+
+func (r DeletionReport) Value() (driver.Value, error) {
+	return json.Marshal(r)
+}
+
+func (r *DeletionReport) Scan(value interface{}) error {
+	var raw []byte
+	switch v := value.(type) {
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		return fmt.Errorf("unsupported deletion report type %T", value)
+	}
+	return json.Unmarshal(raw, r)
+}
@@ -0,0 +1,25 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Download is the offline rendition of a lesson: a progressive MP4 for the
+// app to download, never streamed. OfflineDays is how long a downloaded copy
+// may be watched before the app must fetch it again.
+type Download struct {
+	LessonId        uuid.UUID `json:"lesson_id" gorm:"type:uuid;primary_key"`
+	JobId           uuid.UUID `json:"job_id" gorm:"type:uuid;not null"`
+	ObjectKey       string    `json:"object_key" gorm:"type:varchar(512);not null"`
+	SizeBytes       int64     `json:"size_bytes" gorm:"not null"`
+	Height          int       `json:"height" gorm:"not null"`
+	DurationSeconds float64   `json:"duration_seconds" gorm:"not null"`
+	OfflineDays     int       `json:"offline_days" gorm:"not null"`
+	CreatedAt       time.Time `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt       time.Time `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (Download) TableName() string {
+	return "lesson_downloads"
+}
@@ -0,0 +1,25 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// DriveConnection is a tenant's grant to download from its cloud drive,
+// gdrive or onedrive, so jobs can name a file there as their source. The
+// refresh token is replaced when the provider rotates it. Directory is the
+// Microsoft Entra tenant of a OneDrive account.
+type DriveConnection struct {
+	TenantId     uuid.UUID `json:"tenant_id" gorm:"type:uuid;primary_key"`
+	Provider     string    `json:"provider" gorm:"type:varchar(20);primary_key"`
+	ClientId     string    `json:"client_id" gorm:"type:varchar(255);not null"`
+	ClientSecret string    `json:"-" gorm:"type:varchar(255);not null"`
+	RefreshToken string    `json:"-" gorm:"type:text;not null"`
+	Directory    *string   `json:"directory" gorm:"type:varchar(255)"`
+	CreatedAt    time.Time `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt    time.Time `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (DriveConnection) TableName() string {
+	return "tenant_drive_connections"
+}
@@ -0,0 +1,40 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+	"worker-transcode/constant"
+)
+
+// PresetExperiment compares two presets on live traffic: while it runs,
+// Percent of the jobs that would be encoded with ControlPreset are encoded
+// with the active version of VariantPreset instead.
+type PresetExperiment struct {
+	ID            uuid.UUID                 `json:"id" gorm:"type:uuid;primary_key"`
+	Name          string                    `json:"name" gorm:"type:varchar(100);not null"`
+	ControlPreset string                    `json:"control_preset" gorm:"type:varchar(100);not null"`
+	VariantPreset string                    `json:"variant_preset" gorm:"type:varchar(100);not null"`
+	Percent       int                       `json:"percent" gorm:"not null"`
+	Status        constant.ExperimentStatus `json:"status" gorm:"type:varchar(20);not null;default:RUNNING"`
+	StartedAt     time.Time                 `json:"started_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	StoppedAt     *time.Time                `json:"stopped_at" gorm:"type:timestamptz"`
+}
+
+func (PresetExperiment) TableName() string {
+	return "preset_experiments"
+}
+
+// ExperimentJob tags a job with the arm of the experiment it was in and the
+// preset version that arm encoded it with.
+type ExperimentJob struct {
+	JobId         uuid.UUID              `json:"job_id" gorm:"type:uuid;primary_key"`
+	ExperimentId  uuid.UUID              `json:"experiment_id" gorm:"type:uuid;not null"`
+	Arm           constant.ExperimentArm `json:"arm" gorm:"type:varchar(20);not null"`
+	Preset        string                 `json:"preset" gorm:"type:varchar(100);not null"`
+	PresetVersion int                    `json:"preset_version" gorm:"not null"`
+	CreatedAt     time.Time              `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (ExperimentJob) TableName() string {
+	return "preset_experiment_jobs"
+}
@@ -0,0 +1,24 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"time"
+)
+
+// LessonFingerprint is the content fingerprint of a lesson's latest upload.
+// Frames are the distinct difference hashes of frames sampled at an
+// interval; Audio is the raw Chromaprint fingerprint of its audio, each
+// value a 32-bit subfingerprint.
+type LessonFingerprint struct {
+	LessonId  uuid.UUID     `json:"lesson_id" gorm:"type:uuid;primary_key"`
+	JobId     uuid.UUID     `json:"job_id" gorm:"type:uuid;not null"`
+	Frames    pq.Int64Array `json:"-" gorm:"type:bigint[];not null;default:'{}'"`
+	Audio     pq.Int64Array `json:"-" gorm:"type:integer[];not null;default:'{}'"`
+	Seconds   float64       `json:"seconds" gorm:"type:double precision;not null"`
+	CreatedAt time.Time     `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (LessonFingerprint) TableName() string {
+	return "lesson_fingerprints"
+}
@@ -7,13 +7,32 @@ import (
 )
 
 type Job struct {
-	ID         uuid.UUID          `json:"id"`
-	EntityId   uuid.UUID          `json:"entity_id"`
-	EntityType string             `json:"entity_type"`
-	Status     constant.JobStatus `json:"status"`
-	JobType    constant.JobType   `json:"job_type"`
-	CreatedAt  time.Time          `json:"created_at"`
-	UpdatedAt  time.Time          `json:"updated_at"`
+	ID              uuid.UUID            `json:"id"`
+	EntityId        uuid.UUID            `json:"entity_id"`
+	EntityType      string               `json:"entity_type"`
+	Status          constant.JobStatus   `json:"status"`
+	JobType         constant.JobType     `json:"job_type"`
+	Priority        int                  `json:"priority"`
+	Progress        int                  `json:"progress"`
+	TenantId        *uuid.UUID           `json:"tenant_id"`
+	UserId          *uuid.UUID           `json:"user_id"`
+	ErrorClass      *constant.ErrorClass `json:"error_class"`
+	ErrorKind       *constant.ErrorKind  `json:"error_kind"`
+	ErrorMessage    *string              `json:"error_message"`
+	CorrelationId   *string              `json:"correlation_id"`
+	BackfillBatchId *uuid.UUID           `json:"backfill_batch_id"`
+	WorkerId        *uuid.UUID           `json:"worker_id"`
+	SLAClass        *constant.SLAClass   `json:"sla_class"`
+	Preset          *string              `json:"preset"`
+	PresetVersion   *int                 `json:"preset_version"`
+	SourceSeconds   *float64             `json:"source_seconds"`
+	Region          *string              `json:"region"`
+	Requirements    *JobRequirements     `json:"requirements"`
+	Crash           *EncoderCrash        `json:"crash"`
+	Stage           *string              `json:"stage"`
+	HeartbeatAt     *time.Time           `json:"heartbeat_at"`
+	CreatedAt       time.Time            `json:"created_at"`
+	UpdatedAt       time.Time            `json:"updated_at"`
 }
 
 func (Job) TableName() string {
@@ -0,0 +1,22 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"time"
+)
+
+// JobAnnotation is a note and tags an operator attached to a job, e.g. the
+// customer escalation it belongs to or that its source is known to be bad.
+type JobAnnotation struct {
+	ID        uuid.UUID      `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	JobId     uuid.UUID      `json:"job_id" gorm:"type:uuid;not null"`
+	Note      string         `json:"note" gorm:"type:text;not null"`
+	Tags      pq.StringArray `json:"tags" gorm:"type:text[];not null"`
+	AuthorId  *uuid.UUID     `json:"author_id" gorm:"type:uuid"`
+	CreatedAt time.Time      `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (JobAnnotation) TableName() string {
+	return "job_annotations"
+}
@@ -0,0 +1,77 @@
+package entities
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// EncoderCrash is what was collected when a job's ffmpeg exited abnormally,
+// killed by a signal rather than failing on its own, to tell a source that
+// crashes the encoder from a node too small for it.
+type EncoderCrash struct {
+	At       time.Time `json:"at"`
+	ExitCode int       `json:"exit_code"`
+	Signal   string    `json:"signal,omitempty"`
+	// OOMKilled is whether the kernel's OOM killer killed it, going by the
+	// OOMEvidence: the kernel log lines naming it and the count of OOM kills
+	// in the worker's cgroup.
+	OOMKilled   bool     `json:"oom_killed"`
+	OOMEvidence []string `json:"oom_evidence,omitempty"`
+	// PeakMemoryBytes is the most ffmpeg held, and MemoryLimitBytes the
+	// worker's cgroup limit, zero when it has none.
+	PeakMemoryBytes  int64 `json:"peak_memory_bytes"`
+	MemoryLimitBytes int64 `json:"memory_limit_bytes"`
+	// LastProgress is the last progress ffmpeg reported before it died,
+	// nil when it reported none.
+	LastProgress *CrashProgress `json:"last_progress,omitempty"`
+	Input        *CrashInput    `json:"input,omitempty"`
+}
+
+// Cause is oom for a crash the OOM killer caused and signal for any other.
+func (c EncoderCrash) Cause() string {
+	if c.OOMKilled {
+		return "oom"
+	}
+	return "signal"
+}
+
+func (c EncoderCrash) Value() (driver.Value, error) {
+	return json.Marshal(c)
+}
+
+func (c *EncoderCrash) Scan(value interface{}) error {
+	var raw []byte
+	switch v := value.(type) {
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		return fmt.Errorf("unsupported encoder crash type %T", value)
+	}
+	return json.Unmarshal(raw, c)
+}
+
+// CrashProgress is how far the crashed ffmpeg run got.
+type CrashProgress struct {
+	Frame          int64   `json:"frame"`
+	FPS            float64 `json:"fps"`
+	OutTimeSeconds float64 `json:"out_time_seconds"`
+	Speed          float64 `json:"speed"`
+}
+
+// CrashInput is what the crashed ffmpeg run was reading, as ffprobe saw it.
+type CrashInput struct {
+	Container       string  `json:"container"`
+	VideoCodec      string  `json:"video_codec,omitempty"`
+	AudioCodec      string  `json:"audio_codec,omitempty"`
+	Width           int     `json:"width,omitempty"`
+	Height          int     `json:"height,omitempty"`
+	PixFmt          string  `json:"pix_fmt,omitempty"`
+	FrameRate       string  `json:"frame_rate,omitempty"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	SizeBytes       int64   `json:"size_bytes"`
+	BitRate         int64   `json:"bit_rate,omitempty"`
+}
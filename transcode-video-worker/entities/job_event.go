@@ -0,0 +1,46 @@
+package entities
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"time"
+	"worker-transcode/constant"
+)
+
+type JobEvent struct {
+	ID        uuid.UUID             `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	JobId     uuid.UUID             `json:"job_id" gorm:"type:uuid;not null"`
+	EventType constant.JobEventType `json:"event_type" gorm:"type:varchar(50);not null"`
+	Stage     *string               `json:"stage,omitempty" gorm:"type:varchar(50)"`
+	Data      EventData             `json:"data" gorm:"type:jsonb;not null"`
+	CreatedAt time.Time             `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (JobEvent) TableName() string {
+	return "job_events"
+}
+
+// EventData is the free-form JSONB payload of a job event.
+type EventData map[string]interface{}
+
+func (d EventData) Value() (driver.Value, error) {
+	if d == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(d)
+}
+
+func (d *EventData) Scan(value interface{}) error {
+	var raw []byte
+	switch v := value.(type) {
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		return fmt.Errorf("unsupported event data type %T", value)
+	}
+	return json.Unmarshal(raw, d)
+}
@@ -0,0 +1,68 @@
+package entities
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"time"
+)
+
+// JobSnapshot is everything a transcode job's output depends on, recorded
+// once its source is downloaded, so the job can be replayed as it ran. A
+// retry's snapshot replaces the one before it.
+type JobSnapshot struct {
+	JobId uuid.UUID `json:"job_id" gorm:"type:uuid;primary_key"`
+	// Message is the job's message as it was delivered.
+	Message       json.RawMessage `json:"message" gorm:"type:jsonb;not null"`
+	Preset        string          `json:"preset" gorm:"type:varchar(100);not null"`
+	PresetVersion int             `json:"preset_version" gorm:"not null"`
+	// Objects are the source and audio tracks as they were read.
+	Objects SnapshotObjects `json:"objects" gorm:"type:jsonb;not null"`
+	// FFmpeg is the version of the ffmpeg build, and FFmpegArgs and Threads
+	// the global args and threads every run of it was given.
+	FFmpeg     string         `json:"ffmpeg" gorm:"type:text;not null"`
+	FFmpegArgs pq.StringArray `json:"ffmpeg_args" gorm:"type:text[];not null"`
+	Threads    int            `json:"threads" gorm:"not null"`
+	// Worker is the commit of the worker build that ran the job.
+	Worker    string    `json:"worker" gorm:"type:varchar(64);not null"`
+	CreatedAt time.Time `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (JobSnapshot) TableName() string {
+	return "job_snapshots"
+}
+
+// SnapshotObject is an object a job read, with the ETag it had and, in a
+// versioned bucket, its version.
+type SnapshotObject struct {
+	Key       string `json:"key"`
+	ETag      string `json:"etag"`
+	VersionId string `json:"version_id,omitempty"`
+	Size      int64  `json:"size"`
+}
+
+// SnapshotObjects is the JSONB list of the objects a job read, its source
+// first.
+type SnapshotObjects []SnapshotObject
+
+func (o SnapshotObjects) Value() (driver.Value, error) {
+	if o == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(o)
+}
+
+func (o *SnapshotObjects) Scan(value interface{}) error {
+	var raw []byte
+	switch v := value.(type) {
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		return fmt.Errorf("unsupported snapshot objects type %T", value)
+	}
+	return json.Unmarshal(raw, o)
+}
@@ -10,3 +10,13 @@ type Lesson struct {
 func (Lesson) TableName() string {
 	return "lessons"
 }
+
+// LessonPlayback is what granting a user playback of a lesson's video needs:
+// its package, whether the user is enrolled in its course and the playback
+// TTL of the tenant that transcoded it, if the tenant sets one.
+type LessonPlayback struct {
+	LessonId    uuid.UUID
+	VideoUrl    string
+	Enrolled    bool
+	PlaybackTTL *int
+}
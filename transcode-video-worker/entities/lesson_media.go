@@ -0,0 +1,24 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"time"
+)
+
+// LessonMedia is what was measured of a lesson's published video. An audio
+// lesson has no size or aspect ratio.
+type LessonMedia struct {
+	LessonId        uuid.UUID      `json:"lesson_id" gorm:"type:uuid;primary_key"`
+	JobId           uuid.UUID      `json:"job_id" gorm:"type:uuid;not null"`
+	DurationSeconds float64        `json:"duration_seconds" gorm:"not null"`
+	Width           int            `json:"width" gorm:"not null"`
+	Height          int            `json:"height" gorm:"not null"`
+	AspectRatio     *string        `json:"aspect_ratio" gorm:"type:varchar(20)"`
+	AudioLanguages  pq.StringArray `json:"audio_languages" gorm:"type:text[];not null"`
+	UpdatedAt       time.Time      `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (LessonMedia) TableName() string {
+	return "lesson_media"
+}
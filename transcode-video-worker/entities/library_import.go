@@ -0,0 +1,27 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+	"worker-transcode/constant"
+)
+
+// LibraryImport is a video of a tenant's Vimeo or YouTube library taken into
+// a lesson: downloaded into the bucket, then queued as JobId. Error says why
+// a failed import failed.
+type LibraryImport struct {
+	Provider  string                       `json:"provider" gorm:"type:varchar(20);primary_key"`
+	VideoId   string                       `json:"video_id" gorm:"type:varchar(255);primary_key"`
+	LessonId  uuid.UUID                    `json:"lesson_id" gorm:"type:uuid;primary_key"`
+	TenantId  uuid.UUID                    `json:"tenant_id" gorm:"type:uuid;not null"`
+	Title     string                       `json:"title" gorm:"type:text;not null"`
+	Status    constant.LibraryImportStatus `json:"status" gorm:"type:varchar(20);not null"`
+	JobId     *uuid.UUID                   `json:"job_id" gorm:"type:uuid"`
+	Error     *string                      `json:"error" gorm:"type:text"`
+	CreatedAt time.Time                    `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time                    `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (LibraryImport) TableName() string {
+	return "library_imports"
+}
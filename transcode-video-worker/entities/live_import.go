@@ -0,0 +1,31 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// LiveImport is a finished live class recording pulled from the streaming
+// origin, or an HLS recording URL, to become the lesson's video.
+// ObjectPath, DurationSeconds and TranscodeJobId are set once the recording
+// is normalized, stored as the lesson's source and queued for transcoding.
+// LowLatency packages the lesson's video as LL-HLS, for a replay put up as
+// the class ends.
+type LiveImport struct {
+	ID              uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	LessonId        uuid.UUID  `json:"lesson_id" gorm:"type:uuid;not null"`
+	JobId           uuid.UUID  `json:"job_id" gorm:"type:uuid;not null"`
+	LiveSessionId   *uuid.UUID `json:"live_session_id" gorm:"type:uuid"`
+	SourceURL       string     `json:"source_url" gorm:"type:text;not null"`
+	Preset          *string    `json:"preset" gorm:"type:varchar(100)"`
+	LowLatency      bool       `json:"low_latency" gorm:"not null;default:false"`
+	ObjectPath      *string    `json:"object_path" gorm:"type:varchar(512)"`
+	DurationSeconds *float64   `json:"duration_seconds"`
+	TranscodeJobId  *uuid.UUID `json:"transcode_job_id" gorm:"type:uuid"`
+	CreatedAt       time.Time  `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt       time.Time  `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (LiveImport) TableName() string {
+	return "live_imports"
+}
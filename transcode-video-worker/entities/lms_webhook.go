@@ -0,0 +1,60 @@
+package entities
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"time"
+)
+
+// LMSWebhook is a tenant's inbound webhook from its learning management
+// system. Secret signs the LMS's requests; an event becomes a transcode job
+// when a rule matches it, and its media is fetched only from AllowedHosts.
+type LMSWebhook struct {
+	TenantId     uuid.UUID       `json:"tenant_id" gorm:"type:uuid;primary_key"`
+	Secret       string          `json:"-" gorm:"type:varchar(255);not null"`
+	AllowedHosts pq.StringArray  `json:"allowed_hosts" gorm:"type:text[];not null"`
+	Rules        LMSWebhookRules `json:"rules" gorm:"type:jsonb;not null"`
+	CreatedAt    time.Time       `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt    time.Time       `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (LMSWebhook) TableName() string {
+	return "tenant_lms_webhooks"
+}
+
+// LMSWebhookRule maps one kind of LMS event to a transcode job. Fields are
+// dot-separated paths into the event's JSON, array elements by index: the
+// event matches when the value at EventField is Event, and the job
+// transcodes the media at MediaURLField into the lesson at LessonField.
+// DeliveryField, when set, names the event's id, so a redelivered event
+// doesn't queue the job twice.
+type LMSWebhookRule struct {
+	Event         string `json:"event"`
+	EventField    string `json:"event_field"`
+	MediaURLField string `json:"media_url_field"`
+	LessonField   string `json:"lesson_field"`
+	DeliveryField string `json:"delivery_field,omitempty"`
+	Preset        string `json:"preset,omitempty"`
+}
+
+type LMSWebhookRules []LMSWebhookRule
+
+func (r LMSWebhookRules) Value() (driver.Value, error) {
+	return json.Marshal(r)
+}
+
+func (r *LMSWebhookRules) Scan(value interface{}) error {
+	var raw []byte
+	switch v := value.(type) {
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		return fmt.Errorf("unsupported lms webhook rules type %T", value)
+	}
+	return json.Unmarshal(raw, r)
+}
@@ -0,0 +1,26 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"time"
+	"worker-transcode/constant"
+)
+
+// MalwareScan is the verdict of the malware scan a job's upload had before
+// it was processed. Files are the bucket keys scanned; an infected one is
+// InfectedKey, moved to QuarantineKey with the job failed.
+type MalwareScan struct {
+	JobId         uuid.UUID           `json:"job_id" gorm:"type:uuid;primary_key"`
+	LessonId      uuid.UUID           `json:"lesson_id" gorm:"type:uuid;not null"`
+	Status        constant.ScanStatus `json:"status" gorm:"type:varchar(20);not null"`
+	Files         pq.StringArray      `json:"files" gorm:"type:text[];not null"`
+	Signature     *string             `json:"signature" gorm:"type:varchar(255)"`
+	InfectedKey   *string             `json:"infected_key" gorm:"type:text"`
+	QuarantineKey *string             `json:"quarantine_key" gorm:"type:text"`
+	ScannedAt     time.Time           `json:"scanned_at" gorm:"type:timestamptz;not null"`
+}
+
+func (MalwareScan) TableName() string {
+	return "job_malware_scans"
+}
@@ -0,0 +1,29 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"time"
+)
+
+// MediaAsset is an image or document a media job derives renditions from. An
+// image is resized to each of Widths; a document is rendered to a thumbnail
+// of its first page and up to Pages preview pages. Outputs are the keys
+// written, set once the job is done.
+type MediaAsset struct {
+	ID         uuid.UUID      `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	JobId      uuid.UUID      `json:"job_id" gorm:"type:uuid;not null"`
+	EntityId   uuid.UUID      `json:"entity_id" gorm:"type:uuid;not null"`
+	EntityType string         `json:"entity_type" gorm:"type:varchar(50);not null"`
+	Kind       string         `json:"kind" gorm:"type:varchar(50);not null"`
+	SourceKey  string         `json:"source_key" gorm:"type:varchar(512);not null"`
+	Widths     pq.Int64Array  `json:"widths" gorm:"type:integer[];not null;default:'{}'"`
+	Pages      int            `json:"pages" gorm:"not null;default:0"`
+	Outputs    pq.StringArray `json:"outputs" gorm:"type:text[];not null;default:'{}'"`
+	CreatedAt  time.Time      `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt  time.Time      `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (MediaAsset) TableName() string {
+	return "media_assets"
+}
@@ -0,0 +1,37 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+	"worker-transcode/constant"
+)
+
+// CodecMigration moves the back catalog onto PresetVersion of Preset, one
+// re-transcode at a time as capacity frees up.
+type CodecMigration struct {
+	ID            uuid.UUID                `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Preset        string                   `json:"preset" gorm:"type:varchar(100);not null"`
+	PresetVersion int                      `json:"preset_version" gorm:"not null"`
+	Status        constant.MigrationStatus `json:"status" gorm:"type:varchar(20);not null"`
+	Enqueued      int                      `json:"enqueued" gorm:"not null;default:0"`
+	CreatedAt     time.Time                `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt     time.Time                `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (CodecMigration) TableName() string {
+	return "codec_migrations"
+}
+
+// CodecMigrationLesson is a lesson a migration queued, and the job
+// re-transcoding it.
+type CodecMigrationLesson struct {
+	MigrationId uuid.UUID `json:"migration_id" gorm:"type:uuid;primary_key"`
+	LessonId    uuid.UUID `json:"lesson_id" gorm:"type:uuid;primary_key"`
+	CourseId    uuid.UUID `json:"course_id" gorm:"type:uuid;not null"`
+	JobId       uuid.UUID `json:"job_id" gorm:"type:uuid;not null"`
+	CreatedAt   time.Time `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (CodecMigrationLesson) TableName() string {
+	return "codec_migration_lessons"
+}
@@ -0,0 +1,30 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"time"
+)
+
+// Narration is a lesson video made from a slide deck and a script: Script[i]
+// is spoken over page i+1 of the PDF at DeckKey, and Titles[i], when set,
+// starts a chapter there. ObjectPath and TranscodeJobId are set once the
+// video is rendered and queued for transcoding.
+type Narration struct {
+	ID             uuid.UUID      `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	LessonId       uuid.UUID      `json:"lesson_id" gorm:"type:uuid;not null"`
+	JobId          uuid.UUID      `json:"job_id" gorm:"type:uuid;not null"`
+	DeckKey        string         `json:"deck_key" gorm:"type:varchar(512);not null"`
+	Script         pq.StringArray `json:"script" gorm:"type:text[];not null"`
+	Titles         pq.StringArray `json:"titles" gorm:"type:text[];not null"`
+	Voice          string         `json:"voice" gorm:"type:varchar(100);not null"`
+	Language       string         `json:"language" gorm:"type:varchar(35);not null"`
+	ObjectPath     *string        `json:"object_path" gorm:"type:varchar(512)"`
+	TranscodeJobId *uuid.UUID     `json:"transcode_job_id" gorm:"type:uuid"`
+	CreatedAt      time.Time      `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt      time.Time      `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (Narration) TableName() string {
+	return "lesson_narrations"
+}
@@ -0,0 +1,29 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type TenantNotificationSettings struct {
+	TenantId          uuid.UUID `json:"tenant_id" gorm:"type:uuid;primary_key"`
+	VideoReadyEmail   bool      `json:"video_ready_email" gorm:"not null;default:true"`
+	ReplyTo           *string   `json:"reply_to" gorm:"type:varchar(255)"`
+	LessonURLTemplate *string   `json:"lesson_url_template" gorm:"type:varchar(500)"`
+	CreatedAt         time.Time `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt         time.Time `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (TenantNotificationSettings) TableName() string {
+	return "tenant_notification_settings"
+}
+
+// LessonSummary is the lesson and course a notification is about.
+type LessonSummary struct {
+	LessonId    uuid.UUID `json:"lesson_id"`
+	LessonTitle string    `json:"lesson_title"`
+	LessonSlug  string    `json:"lesson_slug"`
+	CourseId    uuid.UUID `json:"course_id"`
+	CourseTitle string    `json:"course_title"`
+	CourseSlug  string    `json:"course_slug"`
+}
@@ -0,0 +1,25 @@
+package entities
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"time"
+)
+
+// OutboxMessage is a message written in the same transaction as the change
+// it announces, then relayed to Exchange until the broker confirms it. Id is
+// sent as the AMQP message id, for consumers to drop redeliveries by.
+type OutboxMessage struct {
+	ID          uuid.UUID       `json:"id" gorm:"type:uuid;primary_key"`
+	Exchange    string          `json:"exchange" gorm:"type:varchar(255);not null"`
+	RoutingKey  string          `json:"routing_key" gorm:"type:varchar(255);not null"`
+	Payload     json.RawMessage `json:"payload" gorm:"type:jsonb;not null"`
+	Attempts    int             `json:"attempts" gorm:"type:integer;not null;default:0"`
+	LastError   *string         `json:"last_error" gorm:"type:text"`
+	CreatedAt   time.Time       `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	PublishedAt *time.Time      `json:"published_at" gorm:"type:timestamptz"`
+}
+
+func (OutboxMessage) TableName() string {
+	return "outbox_messages"
+}
@@ -0,0 +1,56 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// PodcastFeed is a build of a course's podcast: the episodes of its lessons
+// made or brought up to date. EpisodeCount and BuiltAt are set once it is
+// done.
+type PodcastFeed struct {
+	ID           uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	CourseId     uuid.UUID  `json:"course_id" gorm:"type:uuid;not null"`
+	JobId        uuid.UUID  `json:"job_id" gorm:"type:uuid;not null"`
+	EpisodeCount int        `json:"episode_count" gorm:"not null;default:0"`
+	BuiltAt      *time.Time `json:"built_at" gorm:"type:timestamptz"`
+	CreatedAt    time.Time  `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt    time.Time  `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (PodcastFeed) TableName() string {
+	return "podcast_feeds"
+}
+
+// PodcastEpisode is the audio of a lesson's video, an M4A at ObjectKey made
+// from the package at SourcePlaylist.
+type PodcastEpisode struct {
+	LessonId        uuid.UUID `json:"lesson_id" gorm:"type:uuid;primary_key"`
+	CourseId        uuid.UUID `json:"course_id" gorm:"type:uuid;not null"`
+	SourcePlaylist  string    `json:"source_playlist" gorm:"type:varchar(512);not null"`
+	ObjectKey       string    `json:"object_key" gorm:"type:varchar(512);not null"`
+	SizeBytes       int64     `json:"size_bytes" gorm:"not null;default:0"`
+	DurationSeconds float64   `json:"duration_seconds" gorm:"not null;default:0"`
+	UpdatedAt       time.Time `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (PodcastEpisode) TableName() string {
+	return "podcast_episodes"
+}
+
+// PodcastLesson is a transcoded lesson of a course, in course order, as its
+// podcast lists it.
+type PodcastLesson struct {
+	LessonId uuid.UUID
+	Title    string
+	VideoUrl string
+}
+
+// PodcastCourse is what a course's podcast says about the course. Image is
+// the course's cover, an object key or a URL.
+type PodcastCourse struct {
+	Title       string
+	Description string
+	Image       string
+	Language    string
+}
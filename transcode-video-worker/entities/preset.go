@@ -0,0 +1,141 @@
+package entities
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"time"
+)
+
+type Preset struct {
+	ID      uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Name    string    `json:"name" gorm:"type:varchar(100);not null"`
+	Version int       `json:"version" gorm:"not null"`
+	// Semver is the version the preset's author gave it, e.g. "1.2.0";
+	// Version numbers the saves of the name in order.
+	Semver         string `json:"semver" gorm:"type:varchar(32);not null"`
+	VideoCodec     string `json:"video_codec" gorm:"type:varchar(50);not null"`
+	AudioCodec     string `json:"audio_codec" gorm:"type:varchar(50);not null"`
+	EncoderPreset  string `json:"encoder_preset" gorm:"type:varchar(50);not null"`
+	SegmentSeconds int    `json:"segment_seconds" gorm:"not null;default:6"`
+	// KeyframeSeconds forces a keyframe at this interval so every segment
+	// starts on one; 0 leaves the GOP to the encoder.
+	KeyframeSeconds int        `json:"keyframe_seconds" gorm:"not null;default:0"`
+	Renditions      Renditions `json:"renditions" gorm:"type:jsonb;not null"`
+	// AllowUpscale keeps rungs above the source's resolution; by default
+	// the ladder is capped at the source.
+	AllowUpscale bool `json:"allow_upscale" gorm:"not null;default:false"`
+	// FFmpegArgs are global args the preset's encodes add after the
+	// worker's own, e.g. "-hwaccel", "cuda", "-hwaccel_device",
+	// "{hwaccel_device}", with the same placeholders.
+	FFmpegArgs pq.StringArray `json:"ffmpeg_args" gorm:"type:text[];not null;default:'{}'"`
+	Options    PresetOptions  `json:"options" gorm:"type:jsonb;not null;default:'{}'"`
+	Active     bool           `json:"active" gorm:"not null;default:true"`
+	// CanaryPercent is the share of the name's jobs an inactive version takes
+	// while it is rolled out as a canary; 0 when it isn't one.
+	CanaryPercent int       `json:"canary_percent" gorm:"not null;default:0"`
+	CreatedAt     time.Time `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt     time.Time `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (Preset) TableName() string {
+	return "presets"
+}
+
+type Rendition struct {
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Bitrate   string `json:"bitrate"`    // e.g., "800k"
+	AudioRate string `json:"audio_rate"` // e.g., "96k"
+	// SegmentFormat is how the rung's HLS segments are packaged, "ts" or
+	// "fmp4"; unset is "ts".
+	SegmentFormat string `json:"segment_format,omitempty"`
+	// Container is the format of a progressive copy of the rung written next
+	// to its playlist for download and archival, "mp4", "webm" or "mkv";
+	// unset writes none.
+	Container string `json:"container,omitempty"`
+}
+
+// Renditions is stored as a JSONB array on the presets table.
+type Renditions []Rendition
+
+func (r Renditions) Value() (driver.Value, error) {
+	return json.Marshal(r)
+}
+
+func (r *Renditions) Scan(value interface{}) error {
+	var raw []byte
+	switch v := value.(type) {
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		return fmt.Errorf("unsupported renditions type %T", value)
+	}
+	return json.Unmarshal(raw, r)
+}
+
+// PresetOptions tune how the preset's renditions are encoded and packaged.
+// Unset options compile to the settings every preset had before they could
+// be tuned.
+type PresetOptions struct {
+	// CRF is the constant rate factor of every rung, 22 when unset; -1
+	// leaves it out for plain bitrate control.
+	CRF *int `json:"crf,omitempty"`
+	// MaxrateFactor and BufsizeFactor scale a rung's bitrate into its
+	// -maxrate and -bufsize, both 1 when unset.
+	MaxrateFactor float64 `json:"maxrate_factor,omitempty"`
+	BufsizeFactor float64 `json:"bufsize_factor,omitempty"`
+	// VideoFilters run on every rung after it is scaled, e.g. "hqdn3d".
+	VideoFilters []string `json:"video_filters,omitempty"`
+	// HLSFlags are added to every playlist's -hls_flags, e.g.
+	// "independent_segments".
+	HLSFlags []string `json:"hls_flags,omitempty"`
+	// QualityGate is the least quality every rung must score against the
+	// source for the package to be published; none when unset.
+	QualityGate *QualityThresholds `json:"quality_gate,omitempty"`
+}
+
+func (o PresetOptions) Value() (driver.Value, error) {
+	return json.Marshal(o)
+}
+
+func (o *PresetOptions) Scan(value interface{}) error {
+	var raw []byte
+	switch v := value.(type) {
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		return fmt.Errorf("unsupported preset options type %T", value)
+	}
+	return json.Unmarshal(raw, o)
+}
+
+// QualityThresholds are the least PSNR, in dB, and SSIM a rung may score. A
+// zero threshold isn't checked.
+type QualityThresholds struct {
+	MinPSNR float64 `json:"min_psnr,omitempty"`
+	MinSSIM float64 `json:"min_ssim,omitempty"`
+}
+
+func (t QualityThresholds) Value() (driver.Value, error) {
+	return json.Marshal(t)
+}
+
+func (t *QualityThresholds) Scan(value interface{}) error {
+	var raw []byte
+	switch v := value.(type) {
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		return fmt.Errorf("unsupported quality thresholds type %T", value)
+	}
+	return json.Unmarshal(raw, t)
+}
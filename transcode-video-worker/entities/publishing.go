@@ -0,0 +1,40 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// PublishTarget is where a tenant's lesson videos are also sent once
+// transcoded, for tenants who deliver them through a third party. Provider
+// cloudflare uploads the source to Cloudflare Stream under AccountId;
+// origin PUTs every file of the HLS package under OriginURL.
+type PublishTarget struct {
+	TenantId  uuid.UUID `json:"tenant_id" gorm:"type:uuid;primary_key"`
+	Provider  string    `json:"provider" gorm:"type:varchar(20);not null"`
+	AccountId *string   `json:"account_id" gorm:"type:varchar(255)"`
+	OriginURL *string   `json:"origin_url" gorm:"type:varchar(1024)"`
+	APIToken  string    `json:"-" gorm:"type:varchar(512);not null"`
+	CreatedAt time.Time `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (PublishTarget) TableName() string {
+	return "tenant_publish_targets"
+}
+
+// ExternalPlayback is where a lesson's latest video plays at its tenant's
+// publish target. PlaybackId is the provider's id for it.
+type ExternalPlayback struct {
+	LessonId    uuid.UUID `json:"lesson_id" gorm:"type:uuid;primary_key"`
+	JobId       uuid.UUID `json:"job_id" gorm:"type:uuid;not null"`
+	Provider    string    `json:"provider" gorm:"type:varchar(20);not null"`
+	PlaybackId  string    `json:"playback_id" gorm:"type:varchar(255);not null"`
+	PlaybackURL string    `json:"playback_url" gorm:"type:varchar(1024);not null"`
+	CreatedAt   time.Time `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt   time.Time `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (ExternalPlayback) TableName() string {
+	return "lesson_external_playbacks"
+}
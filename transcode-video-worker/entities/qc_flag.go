@@ -0,0 +1,96 @@
+package entities
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"time"
+	"worker-transcode/constant"
+)
+
+// QCFlag is what a quality check found in a job's video, waiting on or
+// settled by a reviewer of the content team.
+type QCFlag struct {
+	ID         uuid.UUID             `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	LessonId   uuid.UUID             `json:"lesson_id" gorm:"type:uuid;not null"`
+	JobId      uuid.UUID             `json:"job_id" gorm:"type:uuid;not null"`
+	Check      constant.QCCheck      `json:"check" gorm:"column:qc_check;type:varchar(50);not null"`
+	Status     constant.QCFlagStatus `json:"status" gorm:"type:varchar(20);not null"`
+	Matches    MusicMatches          `json:"matches" gorm:"type:jsonb;not null"`
+	Duplicates DuplicateMatches      `json:"duplicates" gorm:"type:jsonb;not null"`
+	Note       *string               `json:"note" gorm:"type:text"`
+	ReviewedBy *uuid.UUID            `json:"reviewed_by" gorm:"type:uuid"`
+	ReviewedAt *time.Time            `json:"reviewed_at" gorm:"type:timestamptz"`
+	CreatedAt  time.Time             `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (QCFlag) TableName() string {
+	return "lesson_qc_flags"
+}
+
+// MusicMatch is recorded music heard At seconds into the video, for
+// Duration seconds of consecutive samples.
+type MusicMatch struct {
+	At       float64 `json:"at"`
+	Duration float64 `json:"duration"`
+	Title    string  `json:"title"`
+	Artist   string  `json:"artist"`
+	Score    float64 `json:"score"`
+}
+
+// MusicMatches is the JSONB list of a flag's matches.
+type MusicMatches []MusicMatch
+
+func (m MusicMatches) Value() (driver.Value, error) {
+	if m == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(m)
+}
+
+func (m *MusicMatches) Scan(value interface{}) error {
+	var raw []byte
+	switch v := value.(type) {
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		return fmt.Errorf("unsupported music matches type %T", value)
+	}
+	return json.Unmarshal(raw, m)
+}
+
+// DuplicateMatch is another lesson a video duplicates. Frames and Audio are
+// the share of the shorter of the two videos' frames and audio that they
+// have in common.
+type DuplicateMatch struct {
+	LessonId uuid.UUID `json:"lesson_id"`
+	JobId    uuid.UUID `json:"job_id"`
+	Frames   float64   `json:"frames"`
+	Audio    float64   `json:"audio"`
+}
+
+// DuplicateMatches is the JSONB list of a flag's duplicated lessons.
+type DuplicateMatches []DuplicateMatch
+
+func (m DuplicateMatches) Value() (driver.Value, error) {
+	if m == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(m)
+}
+
+func (m *DuplicateMatches) Scan(value interface{}) error {
+	var raw []byte
+	switch v := value.(type) {
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		return fmt.Errorf("unsupported duplicate matches type %T", value)
+	}
+	return json.Unmarshal(raw, m)
+}
@@ -0,0 +1,90 @@
+package entities
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"time"
+	"worker-transcode/constant"
+)
+
+// RenditionQuality is how one rendition of a job's package scored against its
+// source. The VMAF scores pool the sampled frames' scores; the harmonic mean
+// weighs the worst stretches more than the mean does. AverageKbps is the
+// rendition's measured bitrate and VMAFPerMbps its efficiency: the quality
+// each megabit per second buys.
+type RenditionQuality struct {
+	ID            uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	JobId         uuid.UUID `json:"job_id" gorm:"type:uuid;not null"`
+	LessonId      uuid.UUID `json:"lesson_id" gorm:"type:uuid;not null"`
+	Preset        string    `json:"preset" gorm:"type:varchar(100);not null"`
+	PresetVersion int       `json:"preset_version" gorm:"not null"`
+	Width         int       `json:"width" gorm:"not null"`
+	Height        int       `json:"height" gorm:"not null"`
+	Bitrate       string    `json:"bitrate" gorm:"type:varchar(20);not null"`
+	AverageKbps   float64   `json:"average_kbps" gorm:"not null"`
+	VMAF          float64   `json:"vmaf" gorm:"column:vmaf;not null"`
+	VMAFMin       float64   `json:"vmaf_min" gorm:"column:vmaf_min;not null"`
+	VMAFHarmonic  float64   `json:"vmaf_harmonic" gorm:"column:vmaf_harmonic;not null"`
+	VMAFPerMbps   float64   `json:"vmaf_per_mbps" gorm:"column:vmaf_per_mbps;not null"`
+	CreatedAt     time.Time `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (RenditionQuality) TableName() string {
+	return "rendition_quality_scores"
+}
+
+// QualityGate is the verdict of the quality gate a job's preset set: how each
+// rung scored against the thresholds and whether the package was published.
+// PackagePath is where a held package waits for an override.
+type QualityGate struct {
+	JobId         uuid.UUID                  `json:"job_id" gorm:"type:uuid;primary_key"`
+	LessonId      uuid.UUID                  `json:"lesson_id" gorm:"type:uuid;not null"`
+	Preset        string                     `json:"preset" gorm:"type:varchar(100);not null"`
+	PresetVersion int                        `json:"preset_version" gorm:"not null"`
+	Status        constant.QualityGateStatus `json:"status" gorm:"type:varchar(20);not null"`
+	Thresholds    QualityThresholds          `json:"thresholds" gorm:"type:jsonb;not null"`
+	Renditions    GateRenditions             `json:"renditions" gorm:"type:jsonb;not null"`
+	PackagePath   string                     `json:"package_path" gorm:"type:text;not null"`
+	OverriddenBy  *uuid.UUID                 `json:"overridden_by" gorm:"type:uuid"`
+	Note          *string                    `json:"note" gorm:"type:text"`
+	OverriddenAt  *time.Time                 `json:"overridden_at" gorm:"type:timestamptz"`
+	CreatedAt     time.Time                  `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (QualityGate) TableName() string {
+	return "job_quality_gates"
+}
+
+// GateRendition is how one rung scored: its mean PSNR, in dB, and SSIM over
+// the sampled frames.
+type GateRendition struct {
+	Height int     `json:"height"`
+	PSNR   float64 `json:"psnr"`
+	SSIM   float64 `json:"ssim"`
+	Passed bool    `json:"passed"`
+}
+
+// GateRenditions is the JSONB list of a gate's rungs.
+type GateRenditions []GateRendition
+
+func (g GateRenditions) Value() (driver.Value, error) {
+	if g == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(g)
+}
+
+func (g *GateRenditions) Scan(value interface{}) error {
+	var raw []byte
+	switch v := value.(type) {
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		return fmt.Errorf("unsupported gate renditions type %T", value)
+	}
+	return json.Unmarshal(raw, g)
+}
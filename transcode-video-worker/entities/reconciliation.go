@@ -0,0 +1,51 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+	"worker-transcode/constant"
+)
+
+// MediaReconciliation is one pass of the media reconciliation over every
+// lesson with a video. FinishedAt is nil while it runs, and for a run that
+// was interrupted.
+type MediaReconciliation struct {
+	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Lessons    int        `json:"lessons" gorm:"not null"`
+	Findings   int        `json:"findings" gorm:"not null"`
+	StartedAt  time.Time  `json:"started_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	FinishedAt *time.Time `json:"finished_at" gorm:"type:timestamptz"`
+}
+
+func (MediaReconciliation) TableName() string {
+	return "media_reconciliations"
+}
+
+// ReconciliationFinding is a problem a reconciliation found with a lesson's
+// video.
+type ReconciliationFinding struct {
+	ID               uuid.UUID                      `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ReconciliationId uuid.UUID                      `json:"reconciliation_id" gorm:"type:uuid;not null"`
+	LessonId         uuid.UUID                      `json:"lesson_id" gorm:"type:uuid;not null"`
+	CourseId         *uuid.UUID                     `json:"course_id" gorm:"type:uuid"`
+	Problem          constant.ReconciliationProblem `json:"problem" gorm:"type:varchar(50);not null"`
+	Detail           string                         `json:"detail" gorm:"type:text;not null"`
+	CreatedAt        time.Time                      `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (ReconciliationFinding) TableName() string {
+	return "media_reconciliation_findings"
+}
+
+// ReconciledLesson is what the catalog and the pipeline record of a lesson's
+// video: the key the lesson plays, the playlist of its active version, and
+// the renditions the version's job output, or its last completed
+// transcode's before versions were kept.
+type ReconciledLesson struct {
+	LessonId   uuid.UUID
+	CourseId   *uuid.UUID
+	VideoUrl   string
+	VersionKey *string
+	Renditions Renditions
+	HasMedia   bool
+}
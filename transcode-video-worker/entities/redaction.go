@@ -0,0 +1,60 @@
+package entities
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"time"
+	"worker-transcode/constant"
+)
+
+// LessonRedaction is what was flagged in the lesson's transcript in
+// Language the last time it was indexed. AudioReplacementId is the last
+// replacement that bleeped the lesson's audio for it.
+type LessonRedaction struct {
+	LessonId           uuid.UUID         `json:"lesson_id" gorm:"type:uuid;primary_key"`
+	Language           string            `json:"language" gorm:"type:varchar(35);primary_key"`
+	Findings           RedactionFindings `json:"findings" gorm:"type:jsonb;not null"`
+	AudioReplacementId *uuid.UUID        `json:"audio_replacement_id" gorm:"type:uuid"`
+	UpdatedAt          time.Time         `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (LessonRedaction) TableName() string {
+	return "lesson_redactions"
+}
+
+// RedactionFinding is a word flagged in a cue, masked but for its first
+// letter so the flag doesn't keep the personal details it's about. Start
+// and End are estimated from where in the cue it is, and Bleeped is whether
+// the lesson's audio was bleeped over them.
+type RedactionFinding struct {
+	Start   float64                `json:"start"`
+	End     float64                `json:"end"`
+	Kind    constant.RedactionKind `json:"kind"`
+	Term    string                 `json:"term"`
+	Bleeped bool                   `json:"bleeped"`
+}
+
+// RedactionFindings is the JSONB list of a transcript's findings, in order.
+type RedactionFindings []RedactionFinding
+
+func (f RedactionFindings) Value() (driver.Value, error) {
+	if f == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(f)
+}
+
+func (f *RedactionFindings) Scan(value interface{}) error {
+	var raw []byte
+	switch v := value.(type) {
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		return fmt.Errorf("unsupported redaction findings type %T", value)
+	}
+	return json.Unmarshal(raw, f)
+}
@@ -0,0 +1,29 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"time"
+)
+
+// Regeneration is the making again of some artifacts of a lesson's published
+// video, constant.RegenerationArtifact names, and of the rungs Heights tall.
+// ReplacedPlaylistKey is the package they were made from and PlaylistKey the
+// version they published, set once one is; chapters and the review checks
+// publish none. FollowsJobId is the transcode that left the artifacts to it.
+type Regeneration struct {
+	ID                  uuid.UUID      `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	LessonId            uuid.UUID      `json:"lesson_id" gorm:"type:uuid;not null"`
+	JobId               uuid.UUID      `json:"job_id" gorm:"type:uuid;not null"`
+	Artifacts           pq.StringArray `json:"artifacts" gorm:"type:text[];not null"`
+	Heights             pq.Int64Array  `json:"heights" gorm:"type:integer[];not null;default:'{}'"`
+	ReplacedPlaylistKey *string        `json:"replaced_playlist_key" gorm:"type:varchar(512)"`
+	PlaylistKey         *string        `json:"playlist_key" gorm:"type:varchar(512)"`
+	FollowsJobId        *uuid.UUID     `json:"follows_job_id" gorm:"type:uuid"`
+	CreatedAt           time.Time      `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt           time.Time      `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (Regeneration) TableName() string {
+	return "artifact_regenerations"
+}
@@ -0,0 +1,18 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// SearchExport is when a lesson was last exported to the platform's content
+// index. A lesson whose video, captions or chapters changed since is
+// exported again.
+type SearchExport struct {
+	LessonId   uuid.UUID `json:"lesson_id" gorm:"type:uuid;primary_key"`
+	ExportedAt time.Time `json:"exported_at" gorm:"type:timestamptz;not null"`
+}
+
+func (SearchExport) TableName() string {
+	return "lesson_search_exports"
+}
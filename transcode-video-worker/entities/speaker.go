@@ -0,0 +1,54 @@
+package entities
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"time"
+)
+
+// LessonSpeakers is who speaks when in a lesson's video, as diarized by the
+// job that transcoded it. Cues of transcripts indexed later are labeled with
+// the speaker of the turn they overlap most.
+type LessonSpeakers struct {
+	LessonId  uuid.UUID    `json:"lesson_id" gorm:"type:uuid;primary_key"`
+	JobId     uuid.UUID    `json:"job_id" gorm:"type:uuid;not null"`
+	Speakers  int          `json:"speakers" gorm:"not null"`
+	Turns     SpeakerTurns `json:"turns" gorm:"type:jsonb;not null"`
+	UpdatedAt time.Time    `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (LessonSpeakers) TableName() string {
+	return "lesson_speakers"
+}
+
+// SpeakerTurn is a stretch of the video, in seconds, that Speaker talks in.
+type SpeakerTurn struct {
+	Start   float64 `json:"start"`
+	End     float64 `json:"end"`
+	Speaker string  `json:"speaker"`
+}
+
+// SpeakerTurns is the JSONB list of a lesson's turns, in order.
+type SpeakerTurns []SpeakerTurn
+
+func (t SpeakerTurns) Value() (driver.Value, error) {
+	if t == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(t)
+}
+
+func (t *SpeakerTurns) Scan(value interface{}) error {
+	var raw []byte
+	switch v := value.(type) {
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		return fmt.Errorf("unsupported speaker turns type %T", value)
+	}
+	return json.Unmarshal(raw, t)
+}
@@ -0,0 +1,41 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+	"worker-transcode/constant"
+)
+
+// PackageStorage is what one rendition class of a published package takes
+// up in the bucket. It is released, and taken off the totals, once the
+// package is deleted.
+type PackageStorage struct {
+	JobId          uuid.UUID             `json:"job_id" gorm:"type:uuid;primary_key"`
+	RenditionClass constant.StorageClass `json:"rendition_class" gorm:"type:varchar(16);primary_key"`
+	LessonId       uuid.UUID             `json:"lesson_id" gorm:"type:uuid;not null"`
+	CourseId       *uuid.UUID            `json:"course_id" gorm:"type:uuid"`
+	TenantId       *uuid.UUID            `json:"tenant_id" gorm:"type:uuid"`
+	Bytes          int64                 `json:"bytes" gorm:"type:bigint;not null"`
+	Objects        int                   `json:"objects" gorm:"type:integer;not null"`
+	RecordedAt     time.Time             `json:"recorded_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	ReleasedAt     *time.Time            `json:"released_at" gorm:"type:timestamptz"`
+}
+
+func (PackageStorage) TableName() string {
+	return "package_storage"
+}
+
+// StorageUsage is the running total of one rendition class of the packages
+// a course or tenant has in the bucket.
+type StorageUsage struct {
+	Scope          constant.StorageScope `json:"scope" gorm:"type:varchar(16);primary_key"`
+	ScopeId        uuid.UUID             `json:"scope_id" gorm:"type:uuid;primary_key"`
+	RenditionClass constant.StorageClass `json:"rendition_class" gorm:"type:varchar(16);primary_key"`
+	Bytes          int64                 `json:"bytes" gorm:"type:bigint;not null"`
+	Objects        int64                 `json:"objects" gorm:"type:bigint;not null"`
+	UpdatedAt      time.Time             `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (StorageUsage) TableName() string {
+	return "storage_usage"
+}
@@ -0,0 +1,61 @@
+package entities
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"time"
+	"worker-transcode/constant"
+)
+
+// EventSubscription is a tenant's webhook for the events it names, narrowed
+// to CourseIds and JobTypes when they are set. Deliveries are signed with
+// Secret. CursorAt is when the last event turned into a delivery happened,
+// so a new subscription gets only the events after it was made.
+type EventSubscription struct {
+	ID        uuid.UUID      `json:"id" gorm:"type:uuid;primary_key"`
+	TenantId  uuid.UUID      `json:"tenant_id" gorm:"type:uuid;not null"`
+	URL       string         `json:"url" gorm:"column:url;type:text;not null"`
+	Events    pq.StringArray `json:"events" gorm:"type:text[];not null"`
+	CourseIds pq.StringArray `json:"course_ids" gorm:"type:uuid[];not null"`
+	JobTypes  pq.StringArray `json:"job_types" gorm:"type:text[];not null"`
+	Secret    string         `json:"-" gorm:"type:varchar(255);not null"`
+	Active    bool           `json:"active" gorm:"type:boolean;not null;default:true"`
+	CursorAt  time.Time      `json:"-" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	CreatedAt time.Time      `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time      `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (EventSubscription) TableName() string {
+	return "event_subscriptions"
+}
+
+// EventDelivery is an event sent to a subscription, with how its last
+// attempt went. A pending one is tried again at NextAttemptAt.
+type EventDelivery struct {
+	ID             uuid.UUID                  `json:"id" gorm:"type:uuid;primary_key"`
+	SubscriptionId uuid.UUID                  `json:"subscription_id" gorm:"type:uuid;not null"`
+	EventId        uuid.UUID                  `json:"event_id" gorm:"type:uuid;not null"`
+	EventType      constant.SubscriptionEvent `json:"event_type" gorm:"type:varchar(50);not null"`
+	Payload        json.RawMessage            `json:"payload" gorm:"type:jsonb;not null"`
+	Status         constant.DeliveryStatus    `json:"status" gorm:"type:varchar(20);not null;default:PENDING"`
+	Attempts       int                        `json:"attempts" gorm:"type:integer;not null;default:0"`
+	ResponseCode   *int                       `json:"response_code" gorm:"type:integer"`
+	LastError      *string                    `json:"last_error" gorm:"type:text"`
+	NextAttemptAt  time.Time                  `json:"next_attempt_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	DeliveredAt    *time.Time                 `json:"delivered_at" gorm:"type:timestamptz"`
+	CreatedAt      time.Time                  `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (EventDelivery) TableName() string {
+	return "event_deliveries"
+}
+
+// SubscribedEvent is an event found for a subscription: a job that finished
+// or a course that became ready.
+type SubscribedEvent struct {
+	EventType  constant.SubscriptionEvent
+	JobId      *uuid.UUID
+	CourseId   *uuid.UUID
+	OccurredAt time.Time
+}
@@ -0,0 +1,71 @@
+package entities
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"time"
+)
+
+// TenantConfig overrides, for one tenant's jobs, what the worker's
+// environment sets for every tenant. Preset transcodes the jobs that don't
+// pick their own, at most MaxConcurrentJobs of them at once across the
+// workers. EncodingMinutesQuota caps the minutes of video the tenant has
+// encoded each calendar month. PlaybackTTL is how long its students' signed
+// access to lesson videos on the CDN lasts. Region is where its storage is,
+// whose workers alone process its jobs. Features turns stages on or off; those it
+// leaves out, and the tenants without a row, follow the environment.
+type TenantConfig struct {
+	TenantId          uuid.UUID `json:"tenant_id" gorm:"type:uuid;primary_key"`
+	Preset            *string   `json:"preset" gorm:"type:varchar(100)"`
+	MaxConcurrentJobs *int      `json:"max_concurrent_jobs" gorm:"type:integer"`
+	// EncodingMinutesQuota is nil for tenants without a quota.
+	EncodingMinutesQuota *int           `json:"encoding_minutes_quota" gorm:"type:integer"`
+	PlaybackTTL          *int           `json:"playback_ttl" gorm:"type:integer"`
+	Region               *string        `json:"region" gorm:"type:varchar(50)"`
+	Features             TenantFeatures `json:"features" gorm:"type:jsonb;not null"`
+	CreatedAt            time.Time      `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt            time.Time      `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (TenantConfig) TableName() string {
+	return "tenant_configs"
+}
+
+// EncodingUsage is the video a tenant had encoded in the calendar month
+// starting at Period. The quota events already sent for the month are kept
+// so each is sent once.
+type EncodingUsage struct {
+	TenantId   uuid.UUID  `json:"tenant_id" gorm:"type:uuid;primary_key"`
+	Period     time.Time  `json:"period" gorm:"type:date;primary_key"`
+	Seconds    float64    `json:"seconds" gorm:"type:double precision;not null"`
+	Jobs       int        `json:"jobs" gorm:"type:integer;not null"`
+	WarnedAt   *time.Time `json:"warned_at" gorm:"type:timestamptz"`
+	ExceededAt *time.Time `json:"exceeded_at" gorm:"type:timestamptz"`
+	UpdatedAt  time.Time  `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (EncodingUsage) TableName() string {
+	return "tenant_encoding_usage"
+}
+
+// TenantFeatures map constant.TenantFeature names to whether they run.
+type TenantFeatures map[string]bool
+
+func (f TenantFeatures) Value() (driver.Value, error) {
+	return json.Marshal(f)
+}
+
+func (f *TenantFeatures) Scan(value interface{}) error {
+	var raw []byte
+	switch v := value.(type) {
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		return fmt.Errorf("unsupported tenant features type %T", value)
+	}
+	return json.Unmarshal(raw, f)
+}
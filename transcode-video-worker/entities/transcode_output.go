@@ -0,0 +1,24 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// TranscodeOutput is the package a job made of an input, found again by
+// OutputKey when the same input is encoded with the same preset version.
+type TranscodeOutput struct {
+	OutputKey     string    `json:"output_key" gorm:"type:varchar(64);primary_key"`
+	JobId         uuid.UUID `json:"job_id" gorm:"type:uuid;not null"`
+	LessonId      uuid.UUID `json:"lesson_id" gorm:"type:uuid;not null"`
+	SourceSHA256  string    `json:"source_sha256" gorm:"column:source_sha256;type:varchar(64);not null"`
+	Preset        string    `json:"preset" gorm:"type:varchar(100);not null"`
+	PresetVersion int       `json:"preset_version" gorm:"not null"`
+	PackagePath   string    `json:"package_path" gorm:"type:varchar(512);not null"`
+	Bytes         int64     `json:"bytes" gorm:"not null"`
+	CreatedAt     time.Time `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (TranscodeOutput) TableName() string {
+	return "transcode_outputs"
+}
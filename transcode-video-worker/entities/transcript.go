@@ -0,0 +1,25 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// TranscriptCue is one timed stretch of a lesson's transcript, as indexed by
+// the postgres search backend. Its text_search column is generated by the
+// database and so isn't mapped.
+type TranscriptCue struct {
+	ID           uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	CourseId     uuid.UUID `json:"course_id" gorm:"type:uuid;not null"`
+	LessonId     uuid.UUID `json:"lesson_id" gorm:"type:uuid;not null"`
+	Language     string    `json:"language" gorm:"type:varchar(20);not null"`
+	StartSeconds float64   `json:"start_seconds" gorm:"not null"`
+	EndSeconds   float64   `json:"end_seconds" gorm:"not null"`
+	Text         string    `json:"text" gorm:"type:text;not null"`
+	Speaker      *string   `json:"speaker" gorm:"type:varchar(255)"`
+	CreatedAt    time.Time `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (TranscriptCue) TableName() string {
+	return "transcript_cues"
+}
@@ -0,0 +1,47 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+	"worker-transcode/constant"
+)
+
+// VideoVersion is a package published for a lesson by one job. A replaced
+// version is kept until ExpiresAt so the lesson can be rolled back to it.
+type VideoVersion struct {
+	ID          uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	LessonId    uuid.UUID `json:"lesson_id" gorm:"type:uuid;not null"`
+	JobId       uuid.UUID `json:"job_id" gorm:"type:uuid;not null"`
+	PlaylistKey string    `json:"playlist_key" gorm:"type:varchar(512);not null"`
+	// ContentHash and PresetVersion are what the package's keys are
+	// addressed by, unset for a package derived from another.
+	ContentHash   *string                     `json:"content_hash" gorm:"type:varchar(64)"`
+	PresetVersion *int                        `json:"preset_version"`
+	Status        constant.VideoVersionStatus `json:"status" gorm:"type:varchar(20);not null"`
+	ReplacedAt    *time.Time                  `json:"replaced_at" gorm:"type:timestamptz"`
+	ExpiresAt     *time.Time                  `json:"expires_at" gorm:"type:timestamptz"`
+	CreatedAt     time.Time                   `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (VideoVersion) TableName() string {
+	return "lesson_video_versions"
+}
+
+// LessonPackage is the manifest of a lesson's video: the version it plays.
+// A job replaces it only if the job was created since CurrentSince, so a
+// re-transcode finishing after a later one doesn't undo it, and a rollback
+// isn't undone by a job that was already running.
+type LessonPackage struct {
+	LessonId      uuid.UUID `json:"lesson_id" gorm:"type:uuid;primary_key"`
+	VersionId     uuid.UUID `json:"version_id" gorm:"type:uuid;not null"`
+	JobId         uuid.UUID `json:"job_id" gorm:"type:uuid;not null"`
+	PlaylistKey   string    `json:"playlist_key" gorm:"type:varchar(512);not null"`
+	ContentHash   *string   `json:"content_hash" gorm:"type:varchar(64)"`
+	PresetVersion *int      `json:"preset_version"`
+	CurrentSince  time.Time `json:"current_since" gorm:"type:timestamptz;not null"`
+	UpdatedAt     time.Time `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (LessonPackage) TableName() string {
+	return "lesson_packages"
+}
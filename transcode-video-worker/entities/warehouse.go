@@ -0,0 +1,18 @@
+package entities
+
+import (
+	"time"
+	"worker-transcode/constant"
+)
+
+// WarehouseExport is how far a dataset has been exported to the analytics
+// warehouse: its rows changed before ExportedThrough are in the bucket.
+type WarehouseExport struct {
+	Dataset         constant.WarehouseDataset `json:"dataset" gorm:"type:varchar(50);primary_key"`
+	ExportedThrough time.Time                 `json:"exported_through" gorm:"type:timestamptz;not null"`
+	UpdatedAt       time.Time                 `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (WarehouseExport) TableName() string {
+	return "warehouse_exports"
+}
@@ -0,0 +1,27 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+	"worker-transcode/constant"
+)
+
+// WatermarkRendition is a lesson rendition watermarked for one student. Code
+// is the identifier in the picture; PlaylistKey is set once it can be played
+// and ExpiresAt is when it is deleted.
+type WatermarkRendition struct {
+	ID          uuid.UUID                `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	LessonId    uuid.UUID                `json:"lesson_id" gorm:"type:uuid;not null"`
+	UserId      uuid.UUID                `json:"user_id" gorm:"type:uuid;not null"`
+	JobId       uuid.UUID                `json:"job_id" gorm:"type:uuid;not null"`
+	Code        string                   `json:"code" gorm:"type:varchar(32);not null"`
+	Status      constant.WatermarkStatus `json:"status" gorm:"type:varchar(20);not null"`
+	PlaylistKey *string                  `json:"playlist_key"`
+	ExpiresAt   *time.Time               `json:"expires_at" gorm:"type:timestamptz"`
+	CreatedAt   time.Time                `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt   time.Time                `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (WatermarkRendition) TableName() string {
+	return "watermark_renditions"
+}
@@ -0,0 +1,102 @@
+package entities
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"slices"
+	"time"
+	"worker-transcode/constant"
+)
+
+type Worker struct {
+	ID              uuid.UUID             `json:"id" gorm:"type:uuid;primary_key"`
+	Hostname        string                `json:"hostname" gorm:"type:varchar(255);not null"`
+	Version         string                `json:"version" gorm:"type:varchar(100);not null"`
+	Mode            string                `json:"mode" gorm:"type:varchar(20);not null"`
+	Capabilities    WorkerCapabilities    `json:"capabilities" gorm:"type:jsonb;not null"`
+	Concurrency     int                   `json:"concurrency" gorm:"not null;default:0"`
+	InFlight        int                   `json:"in_flight" gorm:"not null;default:0"`
+	Status          constant.WorkerStatus `json:"status" gorm:"type:varchar(20);not null"`
+	StartedAt       time.Time             `json:"started_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	LastHeartbeatAt time.Time             `json:"last_heartbeat_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (Worker) TableName() string {
+	return "workers"
+}
+
+// WorkerCapabilities describes what a worker can take on.
+type WorkerCapabilities struct {
+	GPU bool `json:"gpu"`
+	// Encoders lists the video encoders found in the worker's ffmpeg build.
+	Encoders []string `json:"encoders,omitempty"`
+	// ScratchBytes is the scratch space the worker had free when it
+	// registered.
+	ScratchBytes uint64 `json:"scratch_bytes,omitempty"`
+	// Lanes maps each queue the worker consumes to its concurrency there.
+	Lanes map[string]int `json:"lanes,omitempty"`
+}
+
+func (c WorkerCapabilities) Value() (driver.Value, error) {
+	return json.Marshal(c)
+}
+
+func (c *WorkerCapabilities) Scan(value interface{}) error {
+	var raw []byte
+	switch v := value.(type) {
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		return fmt.Errorf("unsupported worker capabilities type %T", value)
+	}
+	return json.Unmarshal(raw, c)
+}
+
+// JobRequirements are what a worker must have to run a job, declared by the
+// job. A worker without them leaves the job to the rest of the fleet.
+type JobRequirements struct {
+	GPU bool `json:"gpu,omitempty"`
+	// ScratchGB is the scratch space, in GB, the job needs free.
+	ScratchGB int `json:"scratch_gb,omitempty"`
+	// Encoders are the ffmpeg video encoders the job needs, such as
+	// libsvtav1.
+	Encoders []string `json:"encoders,omitempty"`
+}
+
+// Unmet returns what of the requirements a worker with capabilities lacks.
+func (r JobRequirements) Unmet(capabilities WorkerCapabilities) []string {
+	var unmet []string
+	if r.GPU && !capabilities.GPU {
+		unmet = append(unmet, "gpu")
+	}
+	if need := uint64(r.ScratchGB) << 30; need > capabilities.ScratchBytes {
+		unmet = append(unmet, fmt.Sprintf("%d GB of scratch space, %d GB free", r.ScratchGB, capabilities.ScratchBytes>>30))
+	}
+	for _, encoder := range r.Encoders {
+		if !slices.Contains(capabilities.Encoders, encoder) {
+			unmet = append(unmet, "encoder "+encoder)
+		}
+	}
+	return unmet
+}
+
+func (r JobRequirements) Value() (driver.Value, error) {
+	return json.Marshal(r)
+}
+
+func (r *JobRequirements) Scan(value interface{}) error {
+	var raw []byte
+	switch v := value.(type) {
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		return fmt.Errorf("unsupported job requirements type %T", value)
+	}
+	return json.Unmarshal(raw, r)
+}
@@ -0,0 +1,53 @@
+package entities
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// ZoomConnection is a tenant's Zoom account, reached with a server-to-server
+// OAuth app. WebhookSecret is the app's secret token, which signs its
+// webhooks. PolledAt is when its recordings were last listed.
+type ZoomConnection struct {
+	TenantId      uuid.UUID  `json:"tenant_id" gorm:"type:uuid;primary_key"`
+	AccountId     string     `json:"account_id" gorm:"type:varchar(255);not null"`
+	ClientId      string     `json:"client_id" gorm:"type:varchar(255);not null"`
+	ClientSecret  string     `json:"-" gorm:"type:varchar(255);not null"`
+	WebhookSecret string     `json:"-" gorm:"type:varchar(255);not null"`
+	Preset        string     `json:"preset" gorm:"type:varchar(100);not null"`
+	PolledAt      *time.Time `json:"polled_at" gorm:"type:timestamptz"`
+	CreatedAt     time.Time  `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+	UpdatedAt     time.Time  `json:"updated_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (ZoomConnection) TableName() string {
+	return "tenant_zoom_connections"
+}
+
+// ZoomMeeting links a Zoom meeting to the lesson its recordings become. A
+// recurring meeting keeps its id, so each occurrence's recording replaces
+// the lesson's video.
+type ZoomMeeting struct {
+	MeetingId int64     `json:"meeting_id" gorm:"primary_key;autoIncrement:false"`
+	TenantId  uuid.UUID `json:"tenant_id" gorm:"type:uuid;not null"`
+	LessonId  uuid.UUID `json:"lesson_id" gorm:"type:uuid;not null"`
+	CreatedAt time.Time `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (ZoomMeeting) TableName() string {
+	return "zoom_meetings"
+}
+
+// ZoomImport is a recording file already taken into a lesson, so the
+// webhook and polling don't both import it.
+type ZoomImport struct {
+	FileId      string    `json:"file_id" gorm:"type:varchar(255);primary_key"`
+	MeetingUUID string    `json:"meeting_uuid" gorm:"column:meeting_uuid;type:varchar(255);not null"`
+	LessonId    uuid.UUID `json:"lesson_id" gorm:"type:uuid;not null"`
+	JobId       uuid.UUID `json:"job_id" gorm:"type:uuid;not null"`
+	CreatedAt   time.Time `json:"created_at" gorm:"type:timestamptz;not null;default:CURRENT_TIMESTAMP"`
+}
+
+func (ZoomImport) TableName() string {
+	return "zoom_imports"
+}
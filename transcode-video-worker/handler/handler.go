@@ -5,13 +5,33 @@ import (
 	"encoding/json"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/rs/zerolog"
+	"strconv"
 	"worker-transcode/dto"
 	"worker-transcode/service"
 )
 
+// DryRunHeader asks for a single job to be planned rather than run, without
+// putting the whole worker in dry-run mode.
+const DryRunHeader = "x-dry-run"
+
 type ServiceDependencies struct {
 	TranscodeService      service.Service
 	RecordingMergeService service.RecordingMergeService
+	WatermarkService      service.WatermarkService
+	TranslationService    service.TranslationService
+	NarrationService      service.NarrationService
+	ExportService         service.ContentExportService
+	MediaService          service.MediaService
+	PodcastService        service.PodcastService
+	LiveImportService     service.LiveImportService
+	KeyRotationService    service.KeyRotationService
+	BurnInService         service.BurnInService
+	AudioService          service.AudioReplacementService
+	RegenerationService   service.RegenerationService
+	DeletionService       service.MediaDeletionService
+	// PipelineService starts transcode jobs as workflows; nil with the queue
+	// engine, which runs them here.
+	PipelineService service.PipelineService
 }
 
 func JobHandler(ctx context.Context, msg amqp.Delivery, deps ServiceDependencies) error {
@@ -20,6 +40,15 @@ func JobHandler(ctx context.Context, msg amqp.Delivery, deps ServiceDependencies
 		return err
 	}
 
+	if headerBool(msg.Headers, DryRunHeader) {
+		ctx = service.WithDryRun(ctx)
+	}
+
+	// A dry run only plans the job, so it has no stages to run durably.
+	if deps.PipelineService != nil && !service.IsDryRun(ctx) {
+		return deps.PipelineService.Start(ctx, job)
+	}
+
 	err := deps.TranscodeService.Process(ctx, job)
 	if err != nil {
 		return err
@@ -47,3 +76,134 @@ func RecordingMergeHandler(ctx context.Context, msg amqp.Delivery, deps ServiceD
 
 	return nil
 }
+
+func WatermarkHandler(ctx context.Context, msg amqp.Delivery, deps ServiceDependencies) error {
+	var watermarkMsg dto.WatermarkMessage
+	if err := json.Unmarshal(msg.Body, &watermarkMsg); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to unmarshal watermark message")
+		return err
+	}
+
+	return deps.WatermarkService.Process(ctx, watermarkMsg)
+}
+
+func TranslationHandler(ctx context.Context, msg amqp.Delivery, deps ServiceDependencies) error {
+	var translationMsg dto.TranslationMessage
+	if err := json.Unmarshal(msg.Body, &translationMsg); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to unmarshal translation message")
+		return err
+	}
+
+	return deps.TranslationService.Process(ctx, translationMsg)
+}
+
+func NarrationHandler(ctx context.Context, msg amqp.Delivery, deps ServiceDependencies) error {
+	var narrationMsg dto.NarrationMessage
+	if err := json.Unmarshal(msg.Body, &narrationMsg); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to unmarshal narration message")
+		return err
+	}
+
+	return deps.NarrationService.Process(ctx, narrationMsg)
+}
+
+func ExportHandler(ctx context.Context, msg amqp.Delivery, deps ServiceDependencies) error {
+	var exportMsg dto.ExportMessage
+	if err := json.Unmarshal(msg.Body, &exportMsg); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to unmarshal export message")
+		return err
+	}
+
+	return deps.ExportService.Process(ctx, exportMsg)
+}
+
+func MediaHandler(ctx context.Context, msg amqp.Delivery, deps ServiceDependencies) error {
+	var mediaMsg dto.MediaMessage
+	if err := json.Unmarshal(msg.Body, &mediaMsg); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to unmarshal media message")
+		return err
+	}
+
+	return deps.MediaService.Process(ctx, mediaMsg)
+}
+
+func LiveImportHandler(ctx context.Context, msg amqp.Delivery, deps ServiceDependencies) error {
+	var liveImportMsg dto.LiveImportMessage
+	if err := json.Unmarshal(msg.Body, &liveImportMsg); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to unmarshal live import message")
+		return err
+	}
+
+	return deps.LiveImportService.Process(ctx, liveImportMsg)
+}
+
+func PodcastHandler(ctx context.Context, msg amqp.Delivery, deps ServiceDependencies) error {
+	var podcastMsg dto.PodcastMessage
+	if err := json.Unmarshal(msg.Body, &podcastMsg); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to unmarshal podcast message")
+		return err
+	}
+
+	return deps.PodcastService.Process(ctx, podcastMsg)
+}
+
+func KeyRotationHandler(ctx context.Context, msg amqp.Delivery, deps ServiceDependencies) error {
+	var rotationMsg dto.KeyRotationMessage
+	if err := json.Unmarshal(msg.Body, &rotationMsg); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to unmarshal key rotation message")
+		return err
+	}
+
+	return deps.KeyRotationService.Process(ctx, rotationMsg)
+}
+
+func BurnInHandler(ctx context.Context, msg amqp.Delivery, deps ServiceDependencies) error {
+	var burnInMsg dto.BurnInMessage
+	if err := json.Unmarshal(msg.Body, &burnInMsg); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to unmarshal burn-in message")
+		return err
+	}
+
+	return deps.BurnInService.Process(ctx, burnInMsg)
+}
+
+func AudioReplacementHandler(ctx context.Context, msg amqp.Delivery, deps ServiceDependencies) error {
+	var audioMsg dto.AudioReplacementMessage
+	if err := json.Unmarshal(msg.Body, &audioMsg); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to unmarshal audio replacement message")
+		return err
+	}
+
+	return deps.AudioService.Process(ctx, audioMsg)
+}
+
+func RegenerationHandler(ctx context.Context, msg amqp.Delivery, deps ServiceDependencies) error {
+	var regenerationMsg dto.RegenerationMessage
+	if err := json.Unmarshal(msg.Body, &regenerationMsg); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to unmarshal regeneration message")
+		return err
+	}
+
+	return deps.RegenerationService.Process(ctx, regenerationMsg)
+}
+
+func DeletionHandler(ctx context.Context, msg amqp.Delivery, deps ServiceDependencies) error {
+	var deletionMsg dto.DeletionMessage
+	if err := json.Unmarshal(msg.Body, &deletionMsg); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to unmarshal deletion message")
+		return err
+	}
+
+	return deps.DeletionService.Process(ctx, deletionMsg)
+}
+
+func headerBool(headers amqp.Table, key string) bool {
+	switch v := headers[key].(type) {
+	case bool:
+		return v
+	case string:
+		b, _ := strconv.ParseBool(v)
+		return b
+	}
+	return false
+}
@@ -7,10 +7,8 @@ import (
 )
 
 func main() {
-	cfg, err := config.Load(".env")
-	if err != nil {
-		panic(err)
-	}
+	// The commands fill cfg in once flags are parsed, see cmd.Root.
+	cfg := &config.Config{}
 
 	root := cmd.Root(cfg)
 	if err := root.Execute(); err != nil {
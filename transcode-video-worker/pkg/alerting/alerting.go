@@ -0,0 +1,252 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/pkg/correlation"
+
+	"github.com/rs/zerolog"
+)
+
+// Alert is a single message sent to every configured notifier. Alerts with the
+// same Key are sent at most once per dedup window.
+type Alert struct {
+	Key    string
+	Title  string
+	Text   string
+	Fields map[string]string
+}
+
+// Failure is a job that failed for good.
+type Failure struct {
+	JobId    string
+	JobType  string
+	Stage    string
+	Priority int
+	Message  string
+}
+
+type alerter struct {
+	cfg       config.Alerting
+	notifiers []Notifier
+
+	mu       sync.Mutex
+	sent     map[string]time.Time
+	outcomes []outcome
+	dlq      map[string]int
+}
+
+type outcome struct {
+	at     time.Time
+	failed bool
+}
+
+var active *alerter
+
+// Setup enables alerting for every configured webhook. With none configured
+// the functions below are no-ops.
+func Setup(cfg config.Alerting) {
+	var notifiers []Notifier
+	if cfg.SlackWebhookURL != "" {
+		notifiers = append(notifiers, NewSlackNotifier(cfg.SlackWebhookURL))
+	}
+	if cfg.DiscordWebhookURL != "" {
+		notifiers = append(notifiers, NewDiscordNotifier(cfg.DiscordWebhookURL))
+	}
+	if len(notifiers) == 0 {
+		active = nil
+		return
+	}
+
+	active = &alerter{
+		cfg:       cfg,
+		notifiers: notifiers,
+		sent:      make(map[string]time.Time),
+		dlq:       make(map[string]int),
+	}
+}
+
+// RecordOutcome feeds the failure rate window and alerts once the share of
+// failed jobs in it crosses the configured threshold.
+func RecordOutcome(ctx context.Context, failed bool) {
+	a := active
+	if a == nil {
+		return
+	}
+
+	now := time.Now()
+	window := time.Duration(a.cfg.FailureWindow) * time.Second
+
+	a.mu.Lock()
+	a.outcomes = append(a.outcomes, outcome{at: now, failed: failed})
+	first := 0
+	for first < len(a.outcomes) && now.Sub(a.outcomes[first].at) > window {
+		first++
+	}
+	a.outcomes = a.outcomes[first:]
+
+	total, failures := len(a.outcomes), 0
+	for _, o := range a.outcomes {
+		if o.failed {
+			failures++
+		}
+	}
+	a.mu.Unlock()
+
+	if total < a.cfg.FailureMinSamples {
+		return
+	}
+	rate := float64(failures) / float64(total)
+	if rate < a.cfg.FailureRateThreshold {
+		return
+	}
+
+	a.send(ctx, Alert{
+		Key:   "failure-rate",
+		Title: "Transcode failure rate is high",
+		Text:  fmt.Sprintf("%d of the last %d jobs failed (%.0f%%) within %s.", failures, total, rate*100, window),
+	})
+}
+
+// JobFailed alerts when a job at or above the priority threshold fails.
+func JobFailed(ctx context.Context, failure Failure) {
+	a := active
+	if a == nil || failure.Priority < a.cfg.PriorityThreshold {
+		return
+	}
+
+	fields := map[string]string{
+		"job_id":   failure.JobId,
+		"job_type": failure.JobType,
+		"stage":    failure.Stage,
+		"priority": strconv.Itoa(failure.Priority),
+	}
+	if id := correlation.FromContext(ctx); id != "" {
+		fields[correlation.LogField] = id
+	}
+	a.send(ctx, Alert{
+		Key:    "job-failed:" + failure.JobId,
+		Title:  "High-priority job failed",
+		Text:   failure.Message,
+		Fields: fields,
+	})
+}
+
+// ObserveDLQ alerts when a dead-letter queue grows past the threshold. The
+// first sample after startup only sets the baseline.
+func ObserveDLQ(ctx context.Context, queue string, messages int) {
+	a := active
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	previous, seen := a.dlq[queue]
+	a.dlq[queue] = messages
+	a.mu.Unlock()
+
+	if !seen || messages <= previous || messages < a.cfg.DLQThreshold {
+		return
+	}
+	a.send(ctx, Alert{
+		Key:   "dlq:" + queue,
+		Title: "Dead-letter queue is growing",
+		Text:  fmt.Sprintf("%s grew from %d to %d messages.", queue, previous, messages),
+		Fields: map[string]string{
+			"queue": queue,
+		},
+	})
+}
+
+// PlaybackProblem is a sampled lesson video that couldn't be played, or
+// played too slowly.
+type PlaybackProblem struct {
+	LessonId string
+	Problem  string
+}
+
+// playbackProblemFields bounds how many lessons an alert names.
+const playbackProblemFields = 5
+
+// PlaybackUnhealthy alerts when published lesson videos sampled by the
+// playback probe couldn't be fetched the way students fetch them.
+func PlaybackUnhealthy(ctx context.Context, sampled int, problems []PlaybackProblem) {
+	a := active
+	if a == nil || len(problems) == 0 {
+		return
+	}
+
+	fields := map[string]string{}
+	for _, problem := range problems[:min(len(problems), playbackProblemFields)] {
+		fields["lesson "+problem.LessonId] = problem.Problem
+	}
+	a.send(ctx, Alert{
+		Key:    "playback-unhealthy",
+		Title:  "Published lesson videos can't be played",
+		Text:   fmt.Sprintf("%d of %d sampled lesson videos failed to play through the playback path.", len(problems), sampled),
+		Fields: fields,
+	})
+}
+
+// MediaProblem is a lesson whose video the media reconciliation found
+// missing or incomplete.
+type MediaProblem struct {
+	LessonId string
+	Problem  string
+}
+
+// MediaUnreconciled alerts when the media reconciliation found lessons
+// pointing at missing or incomplete media.
+func MediaUnreconciled(ctx context.Context, checked int, lessons int, problems []MediaProblem) {
+	a := active
+	if a == nil || len(problems) == 0 {
+		return
+	}
+
+	fields := map[string]string{}
+	for _, problem := range problems[:min(len(problems), playbackProblemFields)] {
+		fields["lesson "+problem.LessonId] = problem.Problem
+	}
+	a.send(ctx, Alert{
+		Key:    "media-unreconciled",
+		Title:  "Lessons point at missing or incomplete media",
+		Text:   fmt.Sprintf("%d of %d lessons with a video have missing or incomplete media.", lessons, checked),
+		Fields: fields,
+	})
+}
+
+// send delivers alert in the background unless one with the same key went out
+// within the dedup window.
+func (a *alerter) send(ctx context.Context, alert Alert) {
+	now := time.Now()
+	dedup := time.Duration(a.cfg.DedupWindow) * time.Second
+
+	a.mu.Lock()
+	if last, ok := a.sent[alert.Key]; ok && now.Sub(last) < dedup {
+		a.mu.Unlock()
+		return
+	}
+	a.sent[alert.Key] = now
+	for key, last := range a.sent {
+		if now.Sub(last) >= dedup {
+			delete(a.sent, key)
+		}
+	}
+	a.mu.Unlock()
+
+	logger := zerolog.Ctx(ctx)
+	go func() {
+		// The caller's context may end with the job, so delivery gets its own.
+		notifyCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		for _, notifier := range a.notifiers {
+			if err := notifier.Notify(notifyCtx, alert); err != nil {
+				logger.Warn().Err(err).Str("alert", alert.Key).Msg("failed to send alert")
+			}
+		}
+	}()
+}
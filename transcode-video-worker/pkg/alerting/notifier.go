@@ -0,0 +1,84 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Notifier delivers an alert to one destination.
+type Notifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+type slackNotifier struct {
+	url string
+}
+
+// NewSlackNotifier posts alerts to a Slack incoming webhook.
+func NewSlackNotifier(url string) Notifier {
+	return &slackNotifier{url: url}
+}
+
+func (n *slackNotifier) Notify(ctx context.Context, alert Alert) error {
+	return postJSON(ctx, n.url, map[string]string{"text": fmt.Sprintf("*%s*\n%s", alert.Title, alert.body())})
+}
+
+type discordNotifier struct {
+	url string
+}
+
+// NewDiscordNotifier posts alerts to a Discord channel webhook.
+func NewDiscordNotifier(url string) Notifier {
+	return &discordNotifier{url: url}
+}
+
+func (n *discordNotifier) Notify(ctx context.Context, alert Alert) error {
+	return postJSON(ctx, n.url, map[string]string{"content": fmt.Sprintf("**%s**\n%s", alert.Title, alert.body())})
+}
+
+func (a Alert) body() string {
+	var b strings.Builder
+	b.WriteString(a.Text)
+
+	keys := make([]string, 0, len(a.Fields))
+	for key := range a.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&b, "\n• %s: %s", key, a.Fields[key])
+	}
+	return b.String()
+}
+
+func postJSON(ctx context.Context, url string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
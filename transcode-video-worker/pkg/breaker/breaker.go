@@ -0,0 +1,141 @@
+package breaker
+
+import (
+	"sync"
+	"time"
+	"worker-transcode/pkg/metrics"
+)
+
+type State int
+
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// The breakers around the worker's dependencies. Their clients record every
+// call; the consumers stop taking messages while one of them is open.
+var (
+	Storage  = New("storage")
+	Database = New("database")
+	Broker   = New("broker")
+)
+
+// Configure sets how many consecutive failures open the breakers and how long
+// they stay open before letting a probe through.
+func Configure(threshold int, cooldown time.Duration) {
+	for _, b := range []*Breaker{Storage, Database, Broker} {
+		b.mu.Lock()
+		b.threshold, b.cooldown = max(threshold, 1), cooldown
+		b.mu.Unlock()
+	}
+}
+
+// Breaker counts consecutive failures of one dependency. It doesn't reject
+// calls itself: jobs already running finish or fail on their own, while
+// Allow tells the consumers to stop starting new ones.
+type Breaker struct {
+	name      string
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     State
+	failures  int
+	openedAt  time.Time
+	probeAt   time.Time
+	lastErr   error
+}
+
+func New(name string) *Breaker {
+	b := &Breaker{name: name, threshold: 5, cooldown: 30 * time.Second}
+	metrics.CircuitState.WithLabelValues(name).Set(float64(Closed))
+	return b
+}
+
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// Allow reports whether a new job may start. Once an open breaker has cooled
+// down a single job is let through as a probe; its calls close the breaker
+// or open it again. A probe that never calls the dependency is replaced
+// after another cooldown.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case Open:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(HalfOpen)
+		b.probeAt = time.Now()
+		return true
+	case HalfOpen:
+		if time.Since(b.probeAt) < b.cooldown {
+			return false
+		}
+		b.probeAt = time.Now()
+		return true
+	default:
+		return true
+	}
+}
+
+// Success records a call that reached the dependency.
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	if b.state != Closed {
+		b.setState(Closed)
+	}
+}
+
+// Failure records a call that failed because the dependency is unavailable,
+// as opposed to rejecting the request.
+func (b *Breaker) Failure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.lastErr = err
+	if b.state == HalfOpen || (b.state == Closed && b.failures >= b.threshold) {
+		b.openedAt = time.Now()
+		b.setState(Open)
+	}
+}
+
+// Err returns the failure that opened the breaker while it is open.
+func (b *Breaker) Err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != Open {
+		return nil
+	}
+	return b.lastErr
+}
+
+// State returns the breaker's state and the failure that last opened it.
+func (b *Breaker) State() (State, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state, b.lastErr
+}
+
+func (b *Breaker) setState(state State) {
+	b.state = state
+	metrics.CircuitState.WithLabelValues(b.name).Set(float64(state))
+}
@@ -0,0 +1,35 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+type transport struct {
+	breaker *Breaker
+	next    http.RoundTripper
+}
+
+// Transport records the outcome of every request sent through next on b. An
+// error or a 5xx response is a failure; any other response, 4xx included,
+// means the dependency is up.
+func Transport(b *Breaker, next http.RoundTripper) http.RoundTripper {
+	return &transport{breaker: b, next: next}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	switch {
+	case err != nil:
+		if !errors.Is(err, context.Canceled) {
+			t.breaker.Failure(err)
+		}
+	case resp.StatusCode >= http.StatusInternalServerError:
+		t.breaker.Failure(fmt.Errorf("%s %s: %s", req.Method, req.URL.Host, resp.Status))
+	default:
+		t.breaker.Success()
+	}
+	return resp, err
+}
@@ -0,0 +1,230 @@
+package cdn
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tusChunkSize is how much of a source each upload request carries.
+// Cloudflare wants chunks of at least 5 MiB in multiples of 256 KiB.
+const tusChunkSize = 50 << 20
+
+// Target is a tenant's publish target. AccountId is only used by
+// cloudflare, OriginURL by origin.
+type Target struct {
+	Provider  string
+	AccountId string
+	OriginURL string
+	Token     string
+}
+
+// Playback is where a published video plays. Id is the provider's id for it.
+type Playback struct {
+	Id  string
+	URL string
+}
+
+// File is one file of an HLS package, Name relative to the package.
+type File struct {
+	Name string
+	Size int64
+	Open func(ctx context.Context) (io.ReadCloser, error)
+}
+
+// Video is a lesson video to publish: the transcoded source on disk, which
+// providers that encode again upload, and the files of its package under
+// Prefix, which origins serve as they are.
+type Video struct {
+	Name   string
+	Source string
+	Prefix string
+	Files  []File
+}
+
+// Publisher makes a video play from a third party.
+type Publisher interface {
+	Publish(ctx context.Context, video Video) (*Playback, error)
+}
+
+// New returns the publisher of target's provider.
+func New(target Target) (Publisher, error) {
+	client := &http.Client{Timeout: 10 * time.Minute}
+	switch target.Provider {
+	case "cloudflare":
+		return &cloudflare{target: target, client: client, baseURL: "https://api.cloudflare.com/client/v4"}, nil
+	case "origin":
+		return &origin{target: target, client: client}, nil
+	}
+	return nil, fmt.Errorf("unknown publish provider %q", target.Provider)
+}
+
+type cloudflare struct {
+	target  Target
+	client  *http.Client
+	baseURL string
+}
+
+type cloudflareResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result struct {
+		UID      string `json:"uid"`
+		Playback struct {
+			HLS string `json:"hls"`
+		} `json:"playback"`
+	} `json:"result"`
+}
+
+// Publish uploads the source to Stream with the tus protocol, which takes
+// files of any size, and looks up the video's HLS manifest. Stream encodes
+// the video itself, so it plays once Stream is done.
+func (c *cloudflare) Publish(ctx context.Context, video Video) (*Playback, error) {
+	file, err := os.Open(video.Source)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	streamURL := fmt.Sprintf("%s/accounts/%s/stream", c.baseURL, c.target.AccountId)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, streamURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.target.Token)
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	req.Header.Set("Upload-Length", strconv.FormatInt(info.Size(), 10))
+	req.Header.Set("Upload-Metadata", "name "+base64.StdEncoding.EncodeToString([]byte(video.Name)))
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("create stream upload: cloudflare returned %s", resp.Status)
+	}
+	location, uid := resp.Header.Get("Location"), resp.Header.Get("Stream-Media-Id")
+	if location == "" || uid == "" {
+		return nil, errors.New("create stream upload: cloudflare returned no upload location")
+	}
+
+	buffer := make([]byte, tusChunkSize)
+	for offset := int64(0); offset < info.Size(); {
+		n, err := io.ReadFull(file, buffer)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPatch, location, bytes.NewReader(buffer[:n]))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+c.target.Token)
+		req.Header.Set("Tus-Resumable", "1.0.0")
+		req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		req.Header.Set("Content-Type", "application/offset+octet-stream")
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			return nil, fmt.Errorf("upload to stream at %d: cloudflare returned %s", offset, resp.Status)
+		}
+		offset += int64(n)
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, streamURL+"/"+uid, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.target.Token)
+	resp, err = c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var body cloudflareResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("get stream video: %w", err)
+	}
+	if !body.Success {
+		var messages []string
+		for _, e := range body.Errors {
+			messages = append(messages, fmt.Sprintf("%d %s", e.Code, e.Message))
+		}
+		return nil, fmt.Errorf("get stream video: %s", strings.Join(messages, "; "))
+	}
+	return &Playback{Id: uid, URL: body.Result.Playback.HLS}, nil
+}
+
+type origin struct {
+	target Target
+	client *http.Client
+}
+
+// contentTypes are the HLS types mime doesn't know.
+var contentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+}
+
+// Publish PUTs the package's files under the origin's URL, the same paths
+// they have in the bucket.
+func (o *origin) Publish(ctx context.Context, video Video) (*Playback, error) {
+	base := strings.TrimSuffix(o.target.OriginURL, "/") + "/" + strings.Trim(video.Prefix, "/")
+	for _, file := range video.Files {
+		if err := o.put(ctx, base+"/"+file.Name, file); err != nil {
+			return nil, fmt.Errorf("put %s: %w", file.Name, err)
+		}
+	}
+	return &Playback{Id: video.Prefix, URL: base + "/master.m3u8"}, nil
+}
+
+func (o *origin) put(ctx context.Context, url string, file File) error {
+	body, err := file.Open(ctx)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = file.Size
+	contentType, ok := contentTypes[path.Ext(file.Name)]
+	if !ok {
+		contentType = mime.TypeByExtension(path.Ext(file.Name))
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Authorization", "Bearer "+o.target.Token)
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("origin returned %s: %s", resp.Status, raw)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package cdn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cookie is a cookie a viewer's browser sends the CDN with every request
+// under Path.
+type Cookie struct {
+	Name    string
+	Value   string
+	Path    string
+	Expires time.Time
+}
+
+// Grant lets a viewer fetch every file under a prefix of the CDN until
+// Expires, the playlists and segments of an HLS package alike. URL is where
+// the prefix is fetched from, ending in a slash; the relative URIs of the
+// package's playlists resolve under it, so a token it carries covers them.
+// Cookies, when there are any, are to be set on the viewer for the CDN's
+// domain.
+type Grant struct {
+	URL     string
+	Cookies []Cookie
+	Expires time.Time
+}
+
+// Signer grants access to prefixes of the CDN at baseURL.
+type Signer interface {
+	Sign(prefix string, expires time.Time) (*Grant, error)
+}
+
+// NewSigner returns the signer of provider, signing with the key keyId
+// names:
+//
+//   - cloudfront signs CloudFront cookies with a custom policy over the
+//     prefix. key is the PEM RSA private key of the public key keyId in the
+//     distribution's trusted key group.
+//   - cloudflare puts a keyId.expires.mac token at the front of the path,
+//     the HMAC-SHA256 with key of the prefix and expiry, for a Worker at the
+//     edge to check and strip. keyId tells the Worker which secret to check
+//     it with.
+//
+// Keys are rotated by adding the new key at the CDN, signing with it and
+// removing the old one once the grants it signed expired.
+func NewSigner(provider, baseURL, keyId string, key []byte) (Signer, error) {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	switch provider {
+	case "cloudfront":
+		privateKey, err := parseRSAKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return &cloudfrontSigner{baseURL: baseURL, keyId: keyId, key: privateKey}, nil
+	case "cloudflare":
+		if strings.ContainsAny(keyId, "./") {
+			return nil, fmt.Errorf("cloudflare key id %q can't contain . or /", keyId)
+		}
+		secret := bytes.TrimSpace(key)
+		if len(secret) < 32 {
+			return nil, errors.New("cloudflare signing key must be at least 32 bytes")
+		}
+		return &cloudflareSigner{baseURL: baseURL, keyId: keyId, key: secret}, nil
+	}
+	return nil, fmt.Errorf("unknown signing provider %q", provider)
+}
+
+func parseRSAKey(raw []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("signing key is not PEM")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse signing key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("signing key is not an RSA key")
+	}
+	return key, nil
+}
+
+type cloudfrontSigner struct {
+	baseURL string
+	keyId   string
+	key     *rsa.PrivateKey
+}
+
+type cloudfrontPolicy struct {
+	Statement []cloudfrontStatement `json:"Statement"`
+}
+
+type cloudfrontStatement struct {
+	Resource  string `json:"Resource"`
+	Condition struct {
+		DateLessThan struct {
+			EpochTime int64 `json:"AWS:EpochTime"`
+		} `json:"DateLessThan"`
+	} `json:"Condition"`
+}
+
+func (s *cloudfrontSigner) Sign(prefix string, expires time.Time) (*Grant, error) {
+	prefix = strings.Trim(prefix, "/")
+	statement := cloudfrontStatement{Resource: s.baseURL + "/" + prefix + "/*"}
+	statement.Condition.DateLessThan.EpochTime = expires.Unix()
+	policy, err := json.Marshal(cloudfrontPolicy{Statement: []cloudfrontStatement{statement}})
+	if err != nil {
+		return nil, err
+	}
+	digest := sha1.Sum(policy)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA1, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("sign cloudfront policy: %w", err)
+	}
+
+	path := "/" + prefix + "/"
+	return &Grant{
+		URL: s.baseURL + path,
+		Cookies: []Cookie{
+			{Name: "CloudFront-Policy", Value: cloudfrontBase64(policy), Path: path, Expires: expires},
+			{Name: "CloudFront-Signature", Value: cloudfrontBase64(signature), Path: path, Expires: expires},
+			{Name: "CloudFront-Key-Pair-Id", Value: s.keyId, Path: path, Expires: expires},
+		},
+		Expires: expires,
+	}, nil
+}
+
+// cloudfrontBase64 is base64 with the characters CloudFront can't take in
+// cookies and query strings replaced.
+func cloudfrontBase64(raw []byte) string {
+	return strings.NewReplacer("+", "-", "=", "_", "/", "~").Replace(base64.StdEncoding.EncodeToString(raw))
+}
+
+type cloudflareSigner struct {
+	baseURL string
+	keyId   string
+	key     []byte
+}
+
+func (s *cloudflareSigner) Sign(prefix string, expires time.Time) (*Grant, error) {
+	prefix = strings.Trim(prefix, "/")
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte("/" + prefix + "/\n" + expiry))
+	token := s.keyId + "." + expiry + "." + hex.EncodeToString(mac.Sum(nil))
+	return &Grant{
+		URL:     s.baseURL + "/" + token + "/" + prefix + "/",
+		Expires: expires,
+	}, nil
+}
@@ -0,0 +1,124 @@
+// Package chaos injects failures into the worker's dependencies at
+// configured rates: 500s from storage, ffmpeg killed part way, slow database
+// statements. It lets staging show that retries, the reaper and the dead
+// letter queues recover from them before a production incident relies on
+// them. Nothing is injected until Configure is given a positive rate.
+package chaos
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+	"worker-transcode/pkg/metrics"
+)
+
+// The faults that can be injected, as metrics label them.
+const (
+	FaultStorage  = "storage_error"
+	FaultFFmpeg   = "ffmpeg_kill"
+	FaultDatabase = "database_latency"
+)
+
+// Rates are the share of calls, 0 to 1, each fault is injected into.
+// FFmpegKillAfter bounds how long a doomed ffmpeg runs before it is killed,
+// and DatabaseLatency is the delay a slowed statement gets.
+type Rates struct {
+	StorageError    float64
+	FFmpegKill      float64
+	FFmpegKillAfter time.Duration
+	DatabaseLatency time.Duration
+	DatabaseSlow    float64
+}
+
+var (
+	mu    sync.RWMutex
+	rates Rates
+)
+
+// Configure sets the rates faults are injected at from now on.
+func Configure(r Rates) {
+	mu.Lock()
+	defer mu.Unlock()
+	rates = r
+}
+
+func current() Rates {
+	mu.RLock()
+	defer mu.RUnlock()
+	return rates
+}
+
+// roll reports whether a fault injected at rate strikes this call, counting
+// it when it does.
+func roll(fault string, rate float64) bool {
+	if rate <= 0 || rand.Float64() >= rate {
+		return false
+	}
+	metrics.ChaosFaults.WithLabelValues(fault).Inc()
+	return true
+}
+
+type transport struct {
+	next http.RoundTripper
+}
+
+// Transport answers a share of the requests sent through next with a 500 of
+// its own, as S3 reports an internal error, without sending them.
+func Transport(next http.RoundTripper) http.RoundTripper {
+	return &transport{next: next}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !roll(FaultStorage, current().StorageError) {
+		return t.next.RoundTrip(req)
+	}
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	body := []byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>InternalError</Code><Message>injected by chaos mode</Message></Error>`)
+	return &http.Response{
+		Status:        "500 Internal Server Error",
+		StatusCode:    http.StatusInternalServerError,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/xml"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// KillLater kills a share of the ffmpeg processes it is given, at a random
+// point of their first FFmpegKillAfter. The func it returns is called once
+// the process exited, so one that finished first is left alone.
+func KillLater(process *os.Process) func() {
+	r := current()
+	if r.FFmpegKillAfter <= 0 || !roll(FaultFFmpeg, r.FFmpegKill) {
+		return func() {}
+	}
+	timer := time.AfterFunc(rand.N(r.FFmpegKillAfter), func() {
+		process.Kill()
+	})
+	return func() { timer.Stop() }
+}
+
+// Delay holds up a share of the database statements run with ctx, returning
+// early when ctx is done.
+func Delay(ctx context.Context) {
+	r := current()
+	if r.DatabaseLatency <= 0 || !roll(FaultDatabase, r.DatabaseSlow) {
+		return
+	}
+	timer := time.NewTimer(r.DatabaseLatency)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
@@ -0,0 +1,99 @@
+package clamav
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+	"worker-transcode/config"
+)
+
+// chunkSize is how much of a file each INSTREAM chunk carries. clamd's
+// StreamMaxLength bounds the whole stream, not a chunk.
+const chunkSize = 64 * 1024
+
+// Result is clamd's verdict on a stream. Signature names what was found in
+// an infected one.
+type Result struct {
+	Infected  bool
+	Signature string
+}
+
+// Scanner sends files to a clamd daemon over TCP.
+type Scanner struct {
+	cfg config.Scan
+}
+
+func New(cfg config.Scan) *Scanner {
+	return &Scanner{cfg: cfg}
+}
+
+// Scan streams r to clamd with INSTREAM and returns its verdict. A stream
+// clamd can't finish, such as one over its size limit, is an error rather
+// than clean.
+func (s *Scanner) Scan(ctx context.Context, r io.Reader) (*Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.cfg.Timeout)*time.Second)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("connect to clamd: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return nil, fmt.Errorf("send to clamd: %w", err)
+	}
+	chunk := make([]byte, chunkSize)
+	size := make([]byte, 4)
+	for {
+		n, readErr := r.Read(chunk)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return nil, fmt.Errorf("send to clamd: %w", err)
+			}
+			if _, err := conn.Write(chunk[:n]); err != nil {
+				return nil, fmt.Errorf("send to clamd: %w", err)
+			}
+		}
+		if errors.Is(readErr, io.EOF) {
+			break
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return nil, fmt.Errorf("send to clamd: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("read clamd reply: %w", err)
+	}
+	return parseReply(string(bytes.TrimRight(reply, "\x00\n")))
+}
+
+// parseReply reads clamd's "stream: OK" or "stream: <signature> FOUND".
+func parseReply(reply string) (*Result, error) {
+	verdict, ok := strings.CutPrefix(reply, "stream: ")
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("clamd: %s", reply)
+	case verdict == "OK":
+		return &Result{}, nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return &Result{Infected: true, Signature: strings.TrimSuffix(verdict, " FOUND")}, nil
+	}
+	return nil, fmt.Errorf("clamd: %s", verdict)
+}
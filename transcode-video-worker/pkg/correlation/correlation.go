@@ -0,0 +1,35 @@
+package correlation
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+// Header is the AMQP and HTTP header carrying the correlation ID.
+const Header = "X-Correlation-ID"
+
+// LogField is the zerolog field every line of a job is tagged with.
+const LogField = "correlation_id"
+
+type contextKey struct{}
+
+// New returns a fresh correlation ID.
+func New() string {
+	return uuid.NewString()
+}
+
+// FromContext returns the correlation ID carried by ctx, or "".
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// WithID stores id on ctx and tags the context logger with it, so every
+// zerolog.Ctx(ctx) call downstream logs the ID without threading it by hand.
+func WithID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, contextKey{}, id)
+	logger := zerolog.Ctx(ctx).With().Str(LogField, id).Logger()
+	return logger.WithContext(ctx)
+}
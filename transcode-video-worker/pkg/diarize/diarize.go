@@ -0,0 +1,91 @@
+package diarize
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+	"worker-transcode/config"
+)
+
+// Turn is a stretch of audio one speaker talks in, in seconds. Speaker is
+// the service's label for them, the same in each of their turns.
+type Turn struct {
+	Start   float64 `json:"start"`
+	End     float64 `json:"end"`
+	Speaker string  `json:"speaker"`
+}
+
+// Diarizer tells apart who speaks when in a recording.
+type Diarizer interface {
+	// Diarize returns the turns of the WAV file at audioPath, in the order
+	// the service gave them.
+	Diarize(ctx context.Context, audioPath string) ([]Turn, error)
+}
+
+// New returns a client of the diarization service at cfg.URL.
+func New(cfg config.Diarization) Diarizer {
+	// An hour-long lesson can take the service minutes; ctx bounds it.
+	return &service{cfg: cfg, client: &http.Client{Timeout: 30 * time.Minute}}
+}
+
+type service struct {
+	cfg    config.Diarization
+	client *http.Client
+}
+
+// Diarize posts the audio as the request body, with max_speakers in the
+// query when it's set, and reads the segments the service answers with:
+// {"segments": [{"start": 0.5, "end": 4.2, "speaker": "SPEAKER_00"}]}.
+func (s *service) Diarize(ctx context.Context, audioPath string) ([]Turn, error) {
+	audio, err := os.Open(audioPath)
+	if err != nil {
+		return nil, err
+	}
+	defer audio.Close()
+	info, err := audio.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint, err := url.Parse(s.cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("diarization url: %w", err)
+	}
+	if s.cfg.MaxSpeakers > 0 {
+		query := endpoint.Query()
+		query.Set("max_speakers", strconv.Itoa(s.cfg.MaxSpeakers))
+		endpoint.RawQuery = query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), audio)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "audio/wav")
+	if s.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.APIKey)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("diarization: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("diarization: returned %s: %s", resp.Status, detail)
+	}
+	var result struct {
+		Segments []Turn `json:"segments"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("diarization: %w", err)
+	}
+	return result.Segments, nil
+}
@@ -0,0 +1,212 @@
+package drive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned for a file the credentials can't see.
+var ErrNotFound = errors.New("file not found")
+
+// Credentials are a tenant's grant for one provider, gdrive or onedrive: an
+// OAuth client and a refresh token of the account the files are in.
+// Directory is the Microsoft Entra tenant of a OneDrive account, common when
+// empty.
+type Credentials struct {
+	Provider     string
+	ClientId     string
+	ClientSecret string
+	RefreshToken string
+	Directory    string
+}
+
+// Download is a file being downloaded. RefreshToken is set when the
+// provider replaced the credentials' refresh token, which then has to be
+// kept instead. The caller closes Body.
+type Download struct {
+	Body         io.ReadCloser
+	Name         string
+	Size         int64
+	RefreshToken string
+}
+
+// Drive downloads files from a cloud drive by id.
+type Drive interface {
+	Open(ctx context.Context, fileId string) (*Download, error)
+}
+
+// New returns the drive of the credentials' provider.
+func New(credentials Credentials) (Drive, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	switch credentials.Provider {
+	case "gdrive":
+		return &google{credentials: credentials, client: client}, nil
+	case "onedrive":
+		directory := credentials.Directory
+		if directory == "" {
+			directory = "common"
+		}
+		return &onedrive{credentials: credentials, client: client, tokenURL: fmt.Sprintf("https://login.microsoftonline.com/%s/oauth2/v2.0/token", url.PathEscape(directory))}, nil
+	}
+	return nil, fmt.Errorf("unknown drive provider %q", credentials.Provider)
+}
+
+type google struct {
+	credentials Credentials
+	client      *http.Client
+}
+
+func (g *google) Open(ctx context.Context, fileId string) (*Download, error) {
+	token, err := refresh(ctx, g.client, "https://oauth2.googleapis.com/token", g.credentials, nil)
+	if err != nil {
+		return nil, err
+	}
+	fileURL := "https://www.googleapis.com/drive/v3/files/" + url.PathEscape(fileId)
+	var file struct {
+		Name     string `json:"name"`
+		Size     string `json:"size"`
+		MimeType string `json:"mimeType"`
+	}
+	if err := getJSON(ctx, g.client, fileURL+"?fields=name,size,mimeType&supportsAllDrives=true", token.AccessToken, &file); err != nil {
+		return nil, err
+	}
+	// Google Docs have no bytes of their own to download.
+	if strings.HasPrefix(file.MimeType, "application/vnd.google-apps.") {
+		return nil, fmt.Errorf("%s is a %s, not a video file", file.Name, file.MimeType)
+	}
+	body, err := open(ctx, fileURL+"?alt=media&supportsAllDrives=true", token.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	size, _ := strconv.ParseInt(file.Size, 10, 64)
+	return &Download{Body: body, Name: file.Name, Size: size, RefreshToken: token.RefreshToken}, nil
+}
+
+type onedrive struct {
+	credentials Credentials
+	client      *http.Client
+	tokenURL    string
+}
+
+func (o *onedrive) Open(ctx context.Context, fileId string) (*Download, error) {
+	token, err := refresh(ctx, o.client, o.tokenURL, o.credentials, url.Values{"scope": {"offline_access Files.Read.All"}})
+	if err != nil {
+		return nil, err
+	}
+	itemURL := "https://graph.microsoft.com/v1.0/me/drive/items/" + url.PathEscape(fileId)
+	var item struct {
+		Name string `json:"name"`
+		Size int64  `json:"size"`
+		File *struct {
+			MimeType string `json:"mimeType"`
+		} `json:"file"`
+	}
+	if err := getJSON(ctx, o.client, itemURL, token.AccessToken, &item); err != nil {
+		return nil, err
+	}
+	if item.File == nil {
+		return nil, fmt.Errorf("%s is a folder, not a file", item.Name)
+	}
+	// The content redirects to a preauthenticated download URL.
+	body, err := open(ctx, itemURL+"/content", token.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	return &Download{Body: body, Name: item.Name, Size: item.Size, RefreshToken: token.RefreshToken}, nil
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// refresh trades the refresh token for an access token. The returned
+// refresh token is only set when it differs from the one sent.
+func refresh(ctx context.Context, client *http.Client, tokenURL string, credentials Credentials, extra url.Values) (*tokenResponse, error) {
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {credentials.ClientId},
+		"client_secret": {credentials.ClientSecret},
+		"refresh_token": {credentials.RefreshToken},
+	}
+	for key, values := range extra {
+		form[key] = values
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return nil, fmt.Errorf("refresh %s token: %s: %s", credentials.Provider, resp.Status, raw)
+	}
+	var token tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, err
+	}
+	if token.RefreshToken == credentials.RefreshToken {
+		token.RefreshToken = ""
+	}
+	return &token, nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, url, accessToken string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// open starts a download, which runs as long as the file takes rather than
+// the API timeout.
+func open(ctx context.Context, url, accessToken string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStatus(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func checkStatus(resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case resp.StatusCode != http.StatusOK:
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("drive returned %s: %s", resp.Status, raw)
+	}
+	return nil
+}
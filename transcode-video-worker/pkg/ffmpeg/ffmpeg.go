@@ -0,0 +1,120 @@
+// Package ffmpeg runs the ffmpeg and ffprobe builds the worker is configured
+// with, so a new build can be tried, and its flags tuned, from the
+// environment.
+package ffmpeg
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+	"worker-transcode/config"
+)
+
+var placeholder = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// Placeholders are what templates of args may name.
+var Placeholders = []string{"threads", "loglevel", "hwaccel_device"}
+
+var (
+	mu         sync.RWMutex
+	path       = "ffmpeg"
+	probePath  = "ffprobe"
+	globalArgs []string
+	variables  = map[string]string{}
+)
+
+// Configure sets the binaries and the global args each ffmpeg run starts
+// with. Args are expanded as Expand does; a placeholder without a value
+// drops the arg it is in and the flag before it, so a global arg only
+// applies once its setting is configured.
+func Configure(cfg config.FFmpeg, threads int) error {
+	vars := map[string]string{
+		"loglevel":       cfg.LogLevel,
+		"hwaccel_device": cfg.HWAccelDevice,
+	}
+	if threads > 0 {
+		vars["threads"] = strconv.Itoa(threads)
+	}
+	args, err := expand(cfg.GlobalArgs, vars)
+	if err != nil {
+		return fmt.Errorf("FFMPEG_GLOBAL_ARGS: %w", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	path, probePath, globalArgs, variables = cfg.Path, cfg.ProbePath, args, vars
+	return nil
+}
+
+// Path is the ffmpeg binary, as commands and logs name it.
+func Path() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return path
+}
+
+// Command is an ffmpeg run of args, after the global args.
+func Command(ctx context.Context, args ...string) *exec.Cmd {
+	mu.RLock()
+	defer mu.RUnlock()
+	return exec.CommandContext(ctx, path, append(append([]string{}, globalArgs...), args...)...)
+}
+
+// GlobalArgs are the args each ffmpeg run starts with.
+func GlobalArgs() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return append([]string{}, globalArgs...)
+}
+
+// Probe is an ffprobe run of args.
+func Probe(ctx context.Context, args ...string) *exec.Cmd {
+	mu.RLock()
+	defer mu.RUnlock()
+	return exec.CommandContext(ctx, probePath, args...)
+}
+
+// Expand fills in a template of args, such as a preset's, with the
+// configured {threads}, {loglevel} and {hwaccel_device}.
+func Expand(template []string) ([]string, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return expand(template, variables)
+}
+
+// Check reports an error for a template naming a placeholder that doesn't
+// exist, whatever is configured.
+func Check(template []string) error {
+	_, err := expand(template, nil)
+	return err
+}
+
+func expand(template []string, vars map[string]string) ([]string, error) {
+	args := make([]string, 0, len(template))
+	for _, arg := range template {
+		missing := false
+		for _, match := range placeholder.FindAllStringSubmatch(arg, -1) {
+			if !slices.Contains(Placeholders, match[1]) {
+				return nil, fmt.Errorf("unknown placeholder {%s} in %q", match[1], arg)
+			}
+			if vars[match[1]] == "" {
+				missing = true
+			}
+		}
+		if missing {
+			// The flag the value belongs to goes with it.
+			if n := len(args); n > 0 && len(args[n-1]) > 1 && args[n-1][0] == '-' {
+				args = args[:n-1]
+			}
+			continue
+		}
+		args = append(args, placeholder.ReplaceAllStringFunc(arg, func(match string) string {
+			return vars[match[1:len(match)-1]]
+		}))
+	}
+	return args, nil
+}
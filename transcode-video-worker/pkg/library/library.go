@@ -0,0 +1,290 @@
+package library
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	vimeoURL   = "https://api.vimeo.com"
+	youtubeURL = "https://www.googleapis.com/youtube/v3"
+)
+
+// Providers are the video hosts a library can be imported from.
+var Providers = []string{"vimeo", "youtube"}
+
+// Credentials reach a tenant's library. Vimeo takes a personal access token
+// with the video_files scope. YouTube takes an OAuth access token for the
+// channel it belongs to, or an API key and a ChannelId, which only lists the
+// channel's public uploads.
+type Credentials struct {
+	Token     string
+	APIKey    string
+	ChannelId string
+}
+
+// Video is a video in a hosted library. Providers that don't say leave its
+// duration and size at zero.
+type Video struct {
+	Id              string  `json:"id"`
+	Title           string  `json:"title"`
+	URL             string  `json:"url"`
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+	Width           int     `json:"width,omitempty"`
+	Height          int     `json:"height,omitempty"`
+
+	// download is the file Vimeo offers of the best quality.
+	download string
+}
+
+// Library is a tenant's videos at one provider.
+type Library interface {
+	Videos(ctx context.Context) ([]Video, error)
+	// Fetch downloads the best quality of the video available into dir and
+	// returns the file's path.
+	Fetch(ctx context.Context, video Video, dir string) (string, error)
+}
+
+// New returns the library of the provider the credentials reach.
+func New(provider string, credentials Credentials) (Library, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	switch provider {
+	case "vimeo":
+		if credentials.Token == "" {
+			return nil, errors.New("vimeo needs an access token")
+		}
+		return &vimeo{credentials: credentials, http: client}, nil
+	case "youtube":
+		if credentials.Token == "" && (credentials.APIKey == "" || credentials.ChannelId == "") {
+			return nil, errors.New("youtube needs an access token, or an api key and a channel id")
+		}
+		return &youtube{credentials: credentials, http: client}, nil
+	}
+	return nil, fmt.Errorf("unknown video library provider %q", provider)
+}
+
+type vimeo struct {
+	credentials Credentials
+	http        *http.Client
+}
+
+// vimeoFile is one of the files Vimeo offers a video's owner to download.
+// Quality source is the file as uploaded.
+type vimeoFile struct {
+	Quality string `json:"quality"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	Link    string `json:"link"`
+}
+
+func (v *vimeo) Videos(ctx context.Context) ([]Video, error) {
+	var videos []Video
+	next := "/me/videos?" + url.Values{
+		"per_page": {"100"},
+		"fields":   {"uri,name,link,duration,width,height,download"},
+	}.Encode()
+	for next != "" {
+		var page struct {
+			Paging struct {
+				Next string `json:"next"`
+			} `json:"paging"`
+			Data []struct {
+				URI      string      `json:"uri"`
+				Name     string      `json:"name"`
+				Link     string      `json:"link"`
+				Duration float64     `json:"duration"`
+				Width    int         `json:"width"`
+				Height   int         `json:"height"`
+				Download []vimeoFile `json:"download"`
+			} `json:"data"`
+		}
+		if err := getJSON(ctx, v.http, vimeoURL+next, "bearer "+v.credentials.Token, &page); err != nil {
+			return nil, fmt.Errorf("vimeo: %w", err)
+		}
+		for _, item := range page.Data {
+			video := Video{
+				Id:              path.Base(item.URI),
+				Title:           item.Name,
+				URL:             item.Link,
+				DurationSeconds: item.Duration,
+				Width:           item.Width,
+				Height:          item.Height,
+			}
+			if best := bestVimeoFile(item.Download); best != nil {
+				video.download = best.Link
+			}
+			videos = append(videos, video)
+		}
+		next = page.Paging.Next
+	}
+	return videos, nil
+}
+
+// bestVimeoFile is the uploaded original if Vimeo kept it, the largest
+// rendition otherwise.
+func bestVimeoFile(files []vimeoFile) *vimeoFile {
+	var best *vimeoFile
+	for i := range files {
+		file := &files[i]
+		if file.Quality == "source" {
+			return file
+		}
+		if best == nil || file.Width*file.Height > best.Width*best.Height {
+			best = file
+		}
+	}
+	return best
+}
+
+func (v *vimeo) Fetch(ctx context.Context, video Video, dir string) (string, error) {
+	if video.download == "" {
+		return "", fmt.Errorf("vimeo offers no download of video %s; the token needs the video_files scope", video.Id)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, video.download, nil)
+	if err != nil {
+		return "", err
+	}
+	// Downloads run as long as the file takes, not the API timeout.
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vimeo download returned %s", resp.Status)
+	}
+	file := filepath.Join(dir, video.Id+".mp4")
+	out, err := os.Create(file)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		out.Close()
+		return "", err
+	}
+	return file, out.Close()
+}
+
+type youtube struct {
+	credentials Credentials
+	http        *http.Client
+}
+
+func (y *youtube) Videos(ctx context.Context) ([]Video, error) {
+	channels := url.Values{"part": {"contentDetails"}}
+	if y.credentials.Token != "" {
+		channels.Set("mine", "true")
+	} else {
+		channels.Set("id", y.credentials.ChannelId)
+	}
+	var channel struct {
+		Items []struct {
+			ContentDetails struct {
+				RelatedPlaylists struct {
+					Uploads string `json:"uploads"`
+				} `json:"relatedPlaylists"`
+			} `json:"contentDetails"`
+		} `json:"items"`
+	}
+	if err := y.get(ctx, "/channels", channels, &channel); err != nil {
+		return nil, err
+	}
+	if len(channel.Items) == 0 {
+		return nil, errors.New("youtube: channel not found")
+	}
+
+	var videos []Video
+	token := ""
+	for {
+		query := url.Values{
+			"part":       {"snippet"},
+			"playlistId": {channel.Items[0].ContentDetails.RelatedPlaylists.Uploads},
+			"maxResults": {"50"},
+			"pageToken":  {token},
+		}
+		var page struct {
+			NextPageToken string `json:"nextPageToken"`
+			Items         []struct {
+				Snippet struct {
+					Title      string `json:"title"`
+					ResourceId struct {
+						VideoId string `json:"videoId"`
+					} `json:"resourceId"`
+				} `json:"snippet"`
+			} `json:"items"`
+		}
+		if err := y.get(ctx, "/playlistItems", query, &page); err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			id := item.Snippet.ResourceId.VideoId
+			videos = append(videos, Video{Id: id, Title: item.Snippet.Title, URL: "https://www.youtube.com/watch?v=" + id})
+		}
+		if token = page.NextPageToken; token == "" {
+			return videos, nil
+		}
+	}
+}
+
+// Fetch has yt-dlp download the best video and audio streams and merge them,
+// as the YouTube API doesn't serve video files.
+func (y *youtube) Fetch(ctx context.Context, video Video, dir string) (string, error) {
+	args := []string{
+		"--no-playlist",
+		"--quiet",
+		"-f", "bv*+ba/b",
+		"--remux-video", "mp4",
+		"-o", filepath.Join(dir, video.Id+".%(ext)s"),
+		video.URL,
+	}
+	output, err := exec.CommandContext(ctx, "yt-dlp", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("yt-dlp failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return filepath.Join(dir, video.Id+".mp4"), nil
+}
+
+func (y *youtube) get(ctx context.Context, endpoint string, query url.Values, out interface{}) error {
+	authorization := ""
+	if y.credentials.Token != "" {
+		authorization = "Bearer " + y.credentials.Token
+	} else {
+		query.Set("key", y.credentials.APIKey)
+	}
+	if err := getJSON(ctx, y.http, youtubeURL+endpoint+"?"+query.Encode(), authorization, out); err != nil {
+		return fmt.Errorf("youtube: %w", err)
+	}
+	return nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, endpoint, authorization string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("returned %s: %s", resp.Status, raw)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,86 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
+)
+
+var (
+	jsonOut    io.Writer = os.Stdout
+	consoleOut io.Writer = zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: "15:04:05.000"}
+
+	format atomic.Value
+	output = &switchWriter{}
+)
+
+// switchWriter lets the output format change at runtime without rebuilding the
+// loggers that were already handed out to contexts.
+type switchWriter struct {
+	out atomic.Pointer[io.Writer]
+}
+
+func (w *switchWriter) Write(p []byte) (int, error) {
+	return (*w.out.Load()).Write(p)
+}
+
+// New builds the root logger. An empty level falls back to debug in the
+// develop environment and info everywhere else.
+func New(cfg config.Log, environment string) (zerolog.Logger, error) {
+	level := cfg.Level
+	if level == "" {
+		level = zerolog.LevelInfoValue
+		if environment == constant.EnvironmentDevelop.String() {
+			level = zerolog.LevelDebugValue
+		}
+	}
+	if err := SetLevel(level); err != nil {
+		return zerolog.Nop(), err
+	}
+	if err := SetFormat(cfg.Format); err != nil {
+		return zerolog.Nop(), err
+	}
+	return zerolog.New(output).With().Timestamp().Logger(), nil
+}
+
+func Level() string {
+	return zerolog.GlobalLevel().String()
+}
+
+func SetLevel(level string) error {
+	parsed, err := zerolog.ParseLevel(strings.ToLower(level))
+	if err != nil {
+		return err
+	}
+	zerolog.SetGlobalLevel(parsed)
+	return nil
+}
+
+func Format() string {
+	return format.Load().(string)
+}
+
+func SetFormat(value string) error {
+	switch strings.ToLower(value) {
+	case "", FormatJSON:
+		output.out.Store(&jsonOut)
+		format.Store(FormatJSON)
+	case FormatConsole:
+		output.out.Store(&consoleOut)
+		format.Store(FormatConsole)
+	default:
+		return fmt.Errorf("unsupported log format %q", value)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+	"worker-transcode/config"
+)
+
+type Message struct {
+	To      []string
+	ReplyTo string
+	Subject string
+	Text    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+type smtpMailer struct {
+	cfg config.SMTP
+}
+
+// Send delivers a plain-text message. smtp.SendMail upgrades to STARTTLS when
+// the server offers it, which is what the platform's mail relay expects.
+func (m *smtpMailer) Send(ctx context.Context, message Message) error {
+	if len(message.To) == 0 {
+		return nil
+	}
+
+	var auth smtp.Auth
+	if m.cfg.User != "" {
+		auth = smtp.PlainAuth("", m.cfg.User, m.cfg.Pass, m.cfg.Host)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		addr := net.JoinHostPort(m.cfg.Host, strconv.Itoa(m.cfg.Port))
+		done <- smtp.SendMail(addr, auth, m.cfg.From, message.To, m.render(message))
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *smtpMailer) render(message Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", m.cfg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(message.To, ", "))
+	if message.ReplyTo != "" {
+		fmt.Fprintf(&b, "Reply-To: %s\r\n", message.ReplyTo)
+	}
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(message.Text, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+type noopMailer struct{}
+
+func (noopMailer) Send(ctx context.Context, message Message) error {
+	return nil
+}
+
+// New returns an SMTP mailer, or one that drops every message when no SMTP
+// host is configured.
+func New(cfg config.SMTP) Mailer {
+	if cfg.Host == "" {
+		return noopMailer{}
+	}
+	return &smtpMailer{cfg: cfg}
+}
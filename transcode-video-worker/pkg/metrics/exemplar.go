@@ -0,0 +1,24 @@
+package metrics
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Observe records value on observer and, when ctx carries a sampled span,
+// attaches its trace ID as an exemplar so dashboards can link a slow sample
+// to the trace that produced it. Exemplars are only exposed in the
+// OpenMetrics format.
+func Observe(ctx context.Context, observer prometheus.Observer, value float64) {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if exemplarObserver, ok := observer.(prometheus.ExemplarObserver); ok && spanContext.IsSampled() {
+		exemplarObserver.ObserveWithExemplar(value, prometheus.Labels{
+			"trace_id": spanContext.TraceID().String(),
+			"span_id":  spanContext.SpanID().String(),
+		})
+		return
+	}
+	observer.Observe(value)
+}
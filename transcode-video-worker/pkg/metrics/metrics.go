@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "transcode_worker"
+
+var (
+	JobsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_total",
+		Help:      "Jobs finished by the worker, by job type and final status.",
+	}, []string{"job_type", "status"})
+
+	JobErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "job_errors_total",
+		Help:      "Job failures by job type, error class and error kind.",
+	}, []string{"job_type", "error_class", "error_kind"})
+
+	JobRetriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "job_retries_total",
+		Help:      "Failed job attempts that are tried again, by job type and error kind.",
+	}, []string{"job_type", "error_kind"})
+
+	StageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "stage_duration_seconds",
+		Help:      "Wall-clock time spent in each pipeline stage.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 14), // 0.5s .. ~2.3h
+	}, []string{"stage"})
+
+	SourceSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "source_size_bytes",
+		Help:      "Size of downloaded source files.",
+		Buckets:   prometheus.ExponentialBuckets(1<<20, 2, 15), // 1MiB .. 16GiB
+	})
+
+	SourceDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "source_duration_seconds",
+		Help:      "Media duration of source files as reported by ffprobe.",
+		Buckets:   []float64{30, 60, 300, 600, 1200, 1800, 3600, 7200, 10800, 14400},
+	})
+
+	EncodeSpeed = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "encode_speed_realtime_ratio",
+		Help:      "Seconds of media encoded per second of wall time (1 = realtime).",
+		Buckets:   []float64{0.1, 0.25, 0.5, 0.75, 1, 1.5, 2, 3, 5, 10},
+	})
+
+	UploadThroughput = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upload_throughput_bytes_per_second",
+		Help:      "Throughput of rendition uploads to object storage.",
+		Buckets:   prometheus.ExponentialBuckets(256<<10, 2, 12), // 256KiB/s .. 512MiB/s
+	})
+)
+
+var (
+	QueueMessages = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_messages_ready",
+		Help:      "Messages waiting to be delivered, as reported by a passive queue declare.",
+	}, []string{"queue"})
+
+	QueueConsumers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_consumers",
+		Help:      "Consumers attached to the queue across all worker replicas.",
+	}, []string{"queue"})
+
+	QueueLag = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "queue_lag_seconds",
+		Help:      "Time between a message being published and a worker picking it up.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 4, 10), // 100ms .. ~7h
+	}, []string{"queue"})
+
+	Leader = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "leader",
+		Help:      "1 while this replica holds the lock that runs scheduled maintenance tasks.",
+	})
+
+	DispatchSlotsBusy = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "dispatch_slots_busy",
+		Help:      "Dispatcher slots held by messages being handled.",
+	})
+
+	DispatchWaiting = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "dispatch_waiting",
+		Help:      "Prefetched messages waiting for a dispatcher slot, by queue.",
+	}, []string{"queue"})
+
+	SLAJobsStarted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sla_jobs_started_total",
+		Help:      "Messages the weighted transcode consumer started, by SLA class.",
+	}, []string{"sla_class"})
+
+	CircuitState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "circuit_state",
+		Help:      "State of the circuit breaker around each dependency: 0 closed, 1 open, 2 half-open.",
+	}, []string{"dependency"})
+
+	ChaosFaults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "chaos_faults_total",
+		Help:      "Failures chaos mode injected, by fault.",
+	}, []string{"fault"})
+
+	PlaybackFetchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "playback_fetch_duration_seconds",
+		Help:      "Time the playback probe took to fetch a published file, by kind: master, variant or segment.",
+		Buckets:   prometheus.ExponentialBuckets(0.025, 2, 10), // 25ms .. ~13s
+	}, []string{"kind"})
+
+	PlaybackProbesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "playback_probes_total",
+		Help:      "Published lesson videos the playback probe sampled, by result: ok, slow or unreachable.",
+	}, []string{"result"})
+
+	ReconciliationFindings = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "media_reconciliation_findings",
+		Help:      "Problems the last media reconciliation found with lessons' videos, by problem.",
+	}, []string{"problem"})
+
+	EncoderCrashes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "encoder_crashes_total",
+		Help:      "ffmpeg runs that exited abnormally, by cause, oom or signal, and the video codec of what they read.",
+	}, []string{"cause", "source_codec"})
+
+	CleanupFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "job_cleanup_failures_total",
+		Help:      "Steps of a job's end-of-job cleanup that failed, by step.",
+	}, []string{"step"})
+
+	EventDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "event_delivery_attempts_total",
+		Help:      "Attempts to deliver events to tenants' subscriptions, by result: delivered, retrying or failed.",
+	}, []string{"result"})
+)
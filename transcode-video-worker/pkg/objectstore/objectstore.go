@@ -0,0 +1,49 @@
+// Package objectstore is the bucket storage workers read sources from and
+// write what they make to, behind an interface fakes and other backends can
+// take the place of.
+package objectstore
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/notification"
+)
+
+// Store is the object storage the worker reads sources from and writes
+// packages to. It is the subset of the MinIO client the worker calls, so
+// services can be given a fake or another backend in place of a bucket.
+type Store interface {
+	BucketExists(ctx context.Context, bucket string) (bool, error)
+	MakeBucket(ctx context.Context, bucket string, opts minio.MakeBucketOptions) error
+	StatObject(ctx context.Context, bucket, key string, opts minio.StatObjectOptions) (minio.ObjectInfo, error)
+	ListObjects(ctx context.Context, bucket string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo
+	// GetObject opens key for reading; the reader is closed by the caller.
+	GetObject(ctx context.Context, bucket, key string, opts minio.GetObjectOptions) (io.ReadCloser, error)
+	FGetObject(ctx context.Context, bucket, key, filePath string, opts minio.GetObjectOptions) error
+	PutObject(ctx context.Context, bucket, key string, reader io.Reader, size int64, opts minio.PutObjectOptions) (minio.UploadInfo, error)
+	FPutObject(ctx context.Context, bucket, key, filePath string, opts minio.PutObjectOptions) (minio.UploadInfo, error)
+	CopyObject(ctx context.Context, dst minio.CopyDestOptions, src minio.CopySrcOptions) (minio.UploadInfo, error)
+	ComposeObject(ctx context.Context, dst minio.CopyDestOptions, srcs ...minio.CopySrcOptions) (minio.UploadInfo, error)
+	RemoveObject(ctx context.Context, bucket, key string, opts minio.RemoveObjectOptions) error
+	RemoveObjects(ctx context.Context, bucket string, objects <-chan minio.ObjectInfo, opts minio.RemoveObjectsOptions) <-chan minio.RemoveObjectError
+	PresignedGetObject(ctx context.Context, bucket, key string, expires time.Duration, params url.Values) (*url.URL, error)
+	ListenBucketNotification(ctx context.Context, bucket, prefix, suffix string, events []string) <-chan notification.Info
+}
+
+// minioStore is a Store of a MinIO or S3 compatible bucket.
+type minioStore struct {
+	*minio.Client
+}
+
+func (s minioStore) GetObject(ctx context.Context, bucket, key string, opts minio.GetObjectOptions) (io.ReadCloser, error) {
+	return s.Client.GetObject(ctx, bucket, key, opts)
+}
+
+// NewMinIO is a Store of client's buckets.
+func NewMinIO(client *minio.Client) Store {
+	return minioStore{Client: client}
+}
@@ -0,0 +1,111 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// captionHeight is the strip under each image that holds its caption, in
+// points.
+const captionHeight = 24
+
+// Page is one JPEG image, shown at one point per pixel above its caption.
+type Page struct {
+	JPEG    []byte
+	Width   int
+	Height  int
+	Caption string
+}
+
+// Write renders pages as a PDF document. The JPEGs are embedded as they are,
+// so nothing is re-encoded. Captions are set in Helvetica and limited to
+// ASCII.
+func Write(w io.Writer, pages []Page) error {
+	var (
+		out     bytes.Buffer
+		offsets []int
+	)
+	// Objects are numbered from 1 in the order they are started.
+	begin := func() int {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n", len(offsets))
+		return len(offsets)
+	}
+	end := func() {
+		out.WriteString("endobj\n")
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// The catalog, page tree and font come first so pages can point at them
+	// by number: 1, 2 and 3.
+	begin()
+	out.WriteString("<< /Type /Catalog /Pages 2 0 R >>\n")
+	end()
+
+	kids := make([]string, len(pages))
+	for i := range pages {
+		// Every page takes three objects after the first four: the page,
+		// its content stream and its image.
+		kids[i] = fmt.Sprintf("%d 0 R", 4+i*3)
+	}
+	begin()
+	fmt.Fprintf(&out, "<< /Type /Pages /Kids [%s] /Count %d >>\n", strings.Join(kids, " "), len(pages))
+	end()
+
+	begin()
+	out.WriteString("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>\n")
+	end()
+
+	for _, page := range pages {
+		if page.Width <= 0 || page.Height <= 0 {
+			return fmt.Errorf("page image must have a size, got %dx%d", page.Width, page.Height)
+		}
+		pageId := begin()
+		fmt.Fprintf(&out, "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Contents %d 0 R /Resources << /Font << /F1 3 0 R >> /XObject << /Im0 %d 0 R >> >> >>\n",
+			page.Width, page.Height+captionHeight, pageId+1, pageId+2)
+		end()
+
+		content := fmt.Sprintf("q %d 0 0 %d 0 %d cm /Im0 Do Q\nBT /F1 12 Tf 8 7 Td (%s) Tj ET\n",
+			page.Width, page.Height, captionHeight, escape(page.Caption))
+		begin()
+		fmt.Fprintf(&out, "<< /Length %d >>\nstream\n%sendstream\n", len(content), content)
+		end()
+
+		begin()
+		fmt.Fprintf(&out, "<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /DCTDecode /Length %d >>\nstream\n",
+			page.Width, page.Height, len(page.JPEG))
+		out.Write(page.JPEG)
+		out.WriteString("\nendstream\n")
+		end()
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// escape makes text safe inside a PDF string literal.
+func escape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r > 0x7e:
+			b.WriteByte('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
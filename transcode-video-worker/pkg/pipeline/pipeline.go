@@ -0,0 +1,114 @@
+// Package pipeline runs a job through stages registered at the phases of a
+// worker's processing, so a step is added in a file of its own rather than
+// written into the worker's process function. J is what a stage is given of
+// the job it runs in; E is what the worker keeps of each stage for its own
+// middleware, such as the feature that switches it on.
+package pipeline
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+)
+
+// Phase is the point of a job a registered stage runs at.
+type Phase string
+
+// Stage is one step of a job run at its phase.
+type Stage[J any] interface {
+	Name() string
+	Phase() Phase
+	Run(ctx context.Context, job J) error
+}
+
+// Func is a Stage made of a function, for stages that need no type of their
+// own.
+type Func[J any] struct {
+	StageName  string
+	StagePhase Phase
+	Do         func(ctx context.Context, job J) error
+}
+
+func (f Func[J]) Name() string { return f.StageName }
+func (f Func[J]) Phase() Phase { return f.StagePhase }
+func (f Func[J]) Run(ctx context.Context, job J) error {
+	return f.Do(ctx, job)
+}
+
+// Policy is how a registered stage runs. Applies, when set, is whether the
+// job has anything for the stage to do. An optional stage's failure is
+// logged and the job goes on without it, after Discard removes whatever it
+// left behind.
+type Policy[J any] struct {
+	Applies  func(job J) bool
+	Optional bool
+	Discard  func(job J)
+}
+
+// Registered is a stage as it was registered, with its policy and Extra,
+// what the worker keeps of it.
+type Registered[J, E any] struct {
+	Stage[J]
+	Policy Policy[J]
+	Extra  E
+}
+
+// Runner runs one registered stage on a job.
+type Runner[J any] func(ctx context.Context, job J) error
+
+// Middleware wraps the run of every registered stage, outermost first. It
+// may skip the stage by not calling next.
+type Middleware[J, E any] func(stage *Registered[J, E], next Runner[J]) Runner[J]
+
+// Registry holds the registered stages of each phase, in the order they
+// were registered, and the middleware each is run through.
+type Registry[J, E any] struct {
+	stages     map[Phase][]*Registered[J, E]
+	middleware []Middleware[J, E]
+	tolerant   map[Phase]bool
+}
+
+func New[J, E any](middleware ...Middleware[J, E]) *Registry[J, E] {
+	return &Registry[J, E]{
+		stages:     map[Phase][]*Registered[J, E]{},
+		middleware: middleware,
+		tolerant:   map[Phase]bool{},
+	}
+}
+
+func (r *Registry[J, E]) Register(stage Stage[J], policy Policy[J], extra E) {
+	r.stages[stage.Phase()] = append(r.stages[stage.Phase()], &Registered[J, E]{Stage: stage, Policy: policy, Extra: extra})
+}
+
+// Tolerate makes every stage of phase optional, for a phase run once the
+// job's outcome is settled, which its stages must not fail.
+func (r *Registry[J, E]) Tolerate(phase Phase) {
+	r.tolerant[phase] = true
+}
+
+// Run runs the phase's stages on job in turn, and stops at the first
+// required one that fails.
+func (r *Registry[J, E]) Run(ctx context.Context, phase Phase, job J) error {
+	for _, stage := range r.stages[phase] {
+		if stage.Policy.Applies != nil && !stage.Policy.Applies(job) {
+			continue
+		}
+		run := Runner[J](stage.Run)
+		for i := len(r.middleware) - 1; i >= 0; i-- {
+			run = r.middleware[i](stage, run)
+		}
+		err := run(ctx, job)
+		if err == nil {
+			continue
+		}
+		if !stage.Policy.Optional && !r.tolerant[phase] {
+			zerolog.Ctx(ctx).Error().Err(err).Str("stage", stage.Name()).Msg("stage failed")
+			return err
+		}
+		zerolog.Ctx(ctx).Warn().Err(err).Str("stage", stage.Name()).Msg("stage failed, job goes on without it")
+		if stage.Policy.Discard != nil {
+			stage.Policy.Discard(job)
+		}
+	}
+	return nil
+}
@@ -1,24 +1,281 @@
+// Package rabbitmq consumes and publishes the queues of a worker's jobs: it
+// declares each queue with its dead-letter wiring, spreads a pool of
+// workers over one or several queues by weight, retries a failed message
+// as its Settings say and settles it, and holds back intake while the
+// worker drains or its dependencies are down. The handler is given the
+// delivery and the worker's own dependencies, T.
 package rabbitmq
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/cenkalti/backoff/v5"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/rs/zerolog"
+	"runtime/debug"
+	"strings"
 	"sync"
-	"time"
-	"worker-transcode/config"
+	"worker-transcode/pkg/reporting"
+	"worker-transcode/pkg/tracing"
 )
 
+// Topology names the exchange, queue and dead-letter wiring a consumer declares.
+type Topology struct {
+	Exchange      string
+	Queue         string
+	RoutingKey    string
+	DLX           string
+	DLQ           string
+	DLQRoutingKey string
+	// Region is the region whose workers alone consume the queue, empty
+	// for a queue shared by every worker.
+	Region string
+}
+
+// Regional is the queue, next to topology's shared one, of the workers of
+// region. Its messages are dead-lettered with the shared queue's.
+func Regional(topology Topology, region string) Topology {
+	if topology.Region != "" {
+		topology.Queue = strings.TrimSuffix(topology.Queue, "."+topology.Region)
+		topology.RoutingKey = strings.TrimSuffix(topology.RoutingKey, "."+topology.Region)
+	}
+	topology.Queue += "." + region
+	topology.RoutingKey += "." + region
+	topology.Region = region
+	return topology
+}
+
+var TranscodeTopology = Topology{
+	Exchange:      "transcoding_exchange",
+	Queue:         "transcoding_queue",
+	RoutingKey:    "video.transcoding.request",
+	DLX:           "transcoding_exchange_dlx",
+	DLQ:           "transcoding_queue_dlq",
+	DLQRoutingKey: "dlq.video.transcoding.request",
+}
+
+// PriorityTranscodeTopology is a separate lane for bumped jobs. RabbitMQ can't
+// reorder an existing queue, so bumped jobs are republished here and served by
+// their own workers; the original message is skipped once the job is claimed.
+var PriorityTranscodeTopology = Topology{
+	Exchange:      "transcoding_exchange",
+	Queue:         "transcoding_priority_queue",
+	RoutingKey:    "video.transcoding.priority",
+	DLX:           "transcoding_exchange_dlx",
+	DLQ:           "transcoding_queue_dlq",
+	DLQRoutingKey: "dlq.video.transcoding.request",
+}
+
+// BackfillTranscodeTopology carries re-transcodes of the existing library. Its
+// own small worker pool keeps a backfill from taking capacity from uploads.
+var BackfillTranscodeTopology = Topology{
+	Exchange:      "transcoding_exchange",
+	Queue:         "transcoding_backfill_queue",
+	RoutingKey:    "video.transcoding.backfill",
+	DLX:           "transcoding_exchange_dlx",
+	DLQ:           "transcoding_queue_dlq",
+	DLQRoutingKey: "dlq.video.transcoding.request",
+}
+
+// LongTranscodeTopology carries uploads whose source runs longer than the
+// long job threshold. Its own workers keep a burst of multi-hour recordings
+// from holding every worker while short lessons queue behind them.
+var LongTranscodeTopology = Topology{
+	Exchange:      "transcoding_exchange",
+	Queue:         "transcoding_long_queue",
+	RoutingKey:    "video.transcoding.long",
+	DLX:           "transcoding_exchange_dlx",
+	DLQ:           "transcoding_queue_dlq",
+	DLQRoutingKey: "dlq.video.transcoding.request",
+}
+
+// ExpressTranscodeTopology carries uploads whose source runs shorter than
+// the express threshold. Its own workers publish quick lesson updates within
+// a minute, however long the encodes on the other lanes are.
+var ExpressTranscodeTopology = Topology{
+	Exchange:      "transcoding_exchange",
+	Queue:         "transcoding_express_queue",
+	RoutingKey:    "video.transcoding.express",
+	DLX:           "transcoding_exchange_dlx",
+	DLQ:           "transcoding_queue_dlq",
+	DLQRoutingKey: "dlq.video.transcoding.request",
+}
+
+// EnterpriseTranscodeTopology and FreeTranscodeTopology are the lanes of the
+// enterprise and free SLA classes. Pro uploads, and anything published
+// without a class, stay on TranscodeTopology.
+var EnterpriseTranscodeTopology = Topology{
+	Exchange:      "transcoding_exchange",
+	Queue:         "transcoding_enterprise_queue",
+	RoutingKey:    "video.transcoding.enterprise",
+	DLX:           "transcoding_exchange_dlx",
+	DLQ:           "transcoding_queue_dlq",
+	DLQRoutingKey: "dlq.video.transcoding.request",
+}
+
+var FreeTranscodeTopology = Topology{
+	Exchange:      "transcoding_exchange",
+	Queue:         "transcoding_free_queue",
+	RoutingKey:    "video.transcoding.free",
+	DLX:           "transcoding_exchange_dlx",
+	DLQ:           "transcoding_queue_dlq",
+	DLQRoutingKey: "dlq.video.transcoding.request",
+}
+
+// WatermarkTopology carries on-demand watermark jobs, which a student is
+// waiting on to play, so they don't queue behind lesson encodes.
+var WatermarkTopology = Topology{
+	Exchange:      "transcoding_exchange",
+	Queue:         "watermark_queue",
+	RoutingKey:    "video.watermark.request",
+	DLX:           "transcoding_exchange_dlx",
+	DLQ:           "transcoding_queue_dlq",
+	DLQRoutingKey: "dlq.video.transcoding.request",
+}
+
+// TranslationTopology carries caption translation jobs, which only wait on
+// the translation provider.
+var TranslationTopology = Topology{
+	Exchange:      "transcoding_exchange",
+	Queue:         "caption_translation_queue",
+	RoutingKey:    "video.captions.translate",
+	DLX:           "transcoding_exchange_dlx",
+	DLQ:           "transcoding_queue_dlq",
+	DLQRoutingKey: "dlq.video.transcoding.request",
+}
+
+// ExportTopology carries content package exports, which remux a lesson's
+// package rather than encode it.
+var ExportTopology = Topology{
+	Exchange:      "transcoding_exchange",
+	Queue:         "content_export_queue",
+	RoutingKey:    "video.export.request",
+	DLX:           "transcoding_exchange_dlx",
+	DLQ:           "transcoding_queue_dlq",
+	DLQRoutingKey: "dlq.video.transcoding.request",
+}
+
+// NarrationTopology carries narrated video jobs, which render a lesson's
+// source before it is transcoded.
+var NarrationTopology = Topology{
+	Exchange:      "transcoding_exchange",
+	Queue:         "narration_queue",
+	RoutingKey:    "video.narration.request",
+	DLX:           "transcoding_exchange_dlx",
+	DLQ:           "transcoding_queue_dlq",
+	DLQRoutingKey: "dlq.video.transcoding.request",
+}
+
+// LiveImportTopology carries live recording imports, which re-encode a
+// recording into a lesson's source before it is transcoded.
+var LiveImportTopology = Topology{
+	Exchange:      "transcoding_exchange",
+	Queue:         "live_import_queue",
+	RoutingKey:    "video.live.import",
+	DLX:           "transcoding_exchange_dlx",
+	DLQ:           "transcoding_queue_dlq",
+	DLQRoutingKey: "dlq.video.transcoding.request",
+}
+
+// MediaTopology carries image and document jobs, which render stills rather
+// than encode video, so they don't queue behind lesson encodes.
+var MediaTopology = Topology{
+	Exchange:      "transcoding_exchange",
+	Queue:         "media_queue",
+	RoutingKey:    "media.process.request",
+	DLX:           "transcoding_exchange_dlx",
+	DLQ:           "transcoding_queue_dlq",
+	DLQRoutingKey: "dlq.video.transcoding.request",
+}
+
+// PodcastTopology carries course podcast builds, which remux the audio of a
+// course's lessons rather than encode it.
+var PodcastTopology = Topology{
+	Exchange:      "transcoding_exchange",
+	Queue:         "podcast_queue",
+	RoutingKey:    "course.podcast.build",
+	DLX:           "transcoding_exchange_dlx",
+	DLQ:           "transcoding_queue_dlq",
+	DLQRoutingKey: "dlq.video.transcoding.request",
+}
+
+// KeyRotationTopology carries content key rotations, which re-encrypt a
+// lesson's segments without decoding them.
+var KeyRotationTopology = Topology{
+	Exchange:      "transcoding_exchange",
+	Queue:         "key_rotation_queue",
+	RoutingKey:    "video.keys.rotate",
+	DLX:           "transcoding_exchange_dlx",
+	DLQ:           "transcoding_queue_dlq",
+	DLQRoutingKey: "dlq.video.transcoding.request",
+}
+
+// BurnInTopology carries burned-in caption renders, which re-encode a
+// lesson's published video for export rather than for playback.
+var BurnInTopology = Topology{
+	Exchange:      "transcoding_exchange",
+	Queue:         "burn_in_queue",
+	RoutingKey:    "lesson.captions.burn",
+	DLX:           "transcoding_exchange_dlx",
+	DLQ:           "transcoding_queue_dlq",
+	DLQRoutingKey: "dlq.video.transcoding.request",
+}
+
+// AudioReplacementTopology carries audio track replacements, which encode a
+// corrected recording and copy the video renditions they pair with.
+var AudioReplacementTopology = Topology{
+	Exchange:      "transcoding_exchange",
+	Queue:         "audio_replacement_queue",
+	RoutingKey:    "lesson.audio.replace",
+	DLX:           "transcoding_exchange_dlx",
+	DLQ:           "transcoding_queue_dlq",
+	DLQRoutingKey: "dlq.video.transcoding.request",
+}
+
+// RegenerationTopology carries regenerations of single artifacts of a
+// published lesson video.
+var RegenerationTopology = Topology{
+	Exchange:      "transcoding_exchange",
+	Queue:         "regeneration_queue",
+	RoutingKey:    "lesson.artifacts.regenerate",
+	DLX:           "transcoding_exchange_dlx",
+	DLQ:           "transcoding_queue_dlq",
+	DLQRoutingKey: "dlq.video.transcoding.request",
+}
+
+// DeletionTopology carries media deletions whose grace window is over.
+var DeletionTopology = Topology{
+	Exchange:      "transcoding_exchange",
+	Queue:         "deletion_queue",
+	RoutingKey:    "media.deletion.request",
+	DLX:           "transcoding_exchange_dlx",
+	DLQ:           "transcoding_queue_dlq",
+	DLQRoutingKey: "dlq.video.transcoding.request",
+}
+
+// RecordingMergeTopology is the wiring the recording merge consumer declares.
+var RecordingMergeTopology = Topology{
+	Exchange:      "recording_exchange",
+	Queue:         "recording_merge_queue",
+	RoutingKey:    "recording.merge.request",
+	DLX:           "transcoding_exchange_dlx",
+	DLQ:           "recording_merge_queue_dlq",
+	DLQRoutingKey: "dlq.recording.merge.request",
+}
+
 type Consumer[T any] interface {
 	Consume(ctx context.Context, dependencies T) error
 }
 
 type consumer[T any] struct {
 	conn       *amqp.Connection
-	cfg        *config.RabbitMQ
+	settings   Settings
+	topology   Topology
 	handler    func(ctx context.Context, msg amqp.Delivery, dependencies T) error
 	numWorkers int
+	intake     *Intake
+	dispatch   Dispatch
 }
 
 func (c consumer[T]) Consume(ctx context.Context, dependencies T) error {
@@ -28,20 +285,76 @@ func (c consumer[T]) Consume(ctx context.Context, dependencies T) error {
 	}
 	defer ch.Close()
 
-	exchangeName := "transcoding_exchange"
-	queueName := "transcoding_queue"
-	routingKey := "video.transcoding.request"
-	dlxName := "transcoding_exchange_dlx"
-	dlqName := "transcoding_queue_dlq"
-	dlqRoutingKey := "dlq.video.transcoding.request"
+	queueName := c.topology.Queue
+	if err := declare(ctx, ch, c.settings.Kind, c.topology); err != nil {
+		return err
+	}
+
+	err = ch.Qos(c.numWorkers, 0, false)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Str("queue", queueName).Msg("failed to set QoS")
+		return err
+	}
 
-	err = ch.ExchangeDeclare(exchangeName, c.cfg.Kind, true, false, false, false, nil)
+	deliveries, err := ch.Consume(queueName, queueName, false, false, false, false, nil)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Str("queue", queueName).Msg("failed to consume queue")
+		return err
+	}
+	zerolog.Ctx(ctx).Info().Str("queue", queueName).Int("workers", c.numWorkers).Msg("consumer started")
+
+	jobs := make(chan amqp.Delivery, c.numWorkers)
+	var wg sync.WaitGroup
+	for i := 1; i <= c.numWorkers; i++ {
+		wg.Add(1)
+		go func(workerId int) {
+			defer wg.Done()
+			defer reporting.Recover(ctx)
+			for msg := range jobs {
+				c.handle(ctx, msg, c.topology, dependencies)
+			}
+		}(i)
+	}
+
+	for {
+		select {
+		case delivery, ok := <-deliveries:
+			if !ok {
+				close(jobs)
+				wg.Wait()
+				return nil
+			}
+
+			jobs <- delivery
+		case <-c.intake.Draining():
+			stopIntake(ctx, ch, deliveries, queueName)
+			close(jobs)
+			wg.Wait()
+			return nil
+		case <-ctx.Done():
+			close(jobs)
+			wg.Wait()
+			return ctx.Err()
+		}
+	}
+}
+
+// declare sets up the exchange, queue and dead-letter wiring of topology.
+func declare(ctx context.Context, ch *amqp.Channel, kind string, topology Topology) error {
+	exchangeName := topology.Exchange
+	queueName := topology.Queue
+	routingKey := topology.RoutingKey
+	dlxName := topology.DLX
+	dlqName := topology.DLQ
+	dlqRoutingKey := topology.DLQRoutingKey
+
+	err := ch.ExchangeDeclare(exchangeName, kind, true, false, false, false, nil)
 	if err != nil {
 		zerolog.Ctx(ctx).Error().Str("queue", queueName).Msg("failed to declare exchange")
 		return err
 	}
 
-	err = ch.ExchangeDeclare(dlxName, c.cfg.Kind, true, false, false, false, nil)
+	err = ch.ExchangeDeclare(dlxName, kind, true, false, false, false, nil)
 	if err != nil {
 		zerolog.Ctx(ctx).Error().Msg("failed to declare dlx")
 		return err
@@ -74,74 +387,102 @@ func (c consumer[T]) Consume(ctx context.Context, dependencies T) error {
 		zerolog.Ctx(ctx).Error().Str("queue", queueName).Msg("failed to bind queue")
 		return err
 	}
+	return nil
+}
 
-	err = ch.Qos(c.numWorkers, 0, false)
-	if err != nil {
-		zerolog.Ctx(ctx).Error().Str("queue", queueName).Msg("failed to set QoS")
-		return err
+// handle runs msg through the handler with retries and settles it: acked once
+// handled, requeued when the job was handed back or postponed, and
+// dead-lettered when it is out of retries.
+func (c consumer[T]) handle(ctx context.Context, msg amqp.Delivery, topology Topology, dependencies T) {
+	queueName := topology.Queue
+	if c.intake.IsDraining() || ctx.Err() != nil || !c.intake.admit(ctx, queueName) {
+		requeue(ctx, msg, queueName)
+		return
 	}
-
-	deliveries, err := ch.Consume(queueName, "", false, false, false, false, nil)
-	if err != nil {
-		zerolog.Ctx(ctx).Error().Str("queue", queueName).Msg("failed to consume queue")
-		return err
+	defer c.intake.release(queueName)
+	if !c.dispatch.acquire(ctx, c.intake, queueName) {
+		requeue(ctx, msg, queueName)
+		return
 	}
+	defer c.dispatch.release()
+	c.intake.begin(queueName)
+	defer c.intake.done(queueName)
 
-	jobs := make(chan amqp.Delivery, c.numWorkers)
-	var wg sync.WaitGroup
-	for i := 1; i <= c.numWorkers; i++ {
-		wg.Add(1)
-		go func(workerId int) {
-			defer wg.Done()
-			for msg := range jobs {
-				operation := func() (string, error) {
-					err := c.handler(ctx, msg, dependencies)
-					if err != nil {
-						return "", err
-					}
-					return "", nil
-				}
-
-				bo := backoff.NewExponentialBackOff()
-				bo.MaxInterval = 10 * time.Second
-
-				_, err := backoff.Retry(ctx, operation, backoff.WithBackOff(bo), backoff.WithMaxTries(5))
-				if err != nil {
-					zerolog.Ctx(ctx).Error().Err(err).Msg("failed to handle message after all retries")
-					if nackErr := msg.Nack(false, false); nackErr != nil {
-						zerolog.Ctx(ctx).Error().Err(nackErr).Msg("failed to nack message to send to DLQ")
-					}
-				} else {
-					if ackErr := msg.Ack(false); ackErr != nil {
-						zerolog.Ctx(ctx).Error().Err(ackErr).Msg("failed to acknowledge message")
-					}
-				}
-			}
-		}(i)
+	msgCtx, span := startConsumeSpan(ctx, msg, queueName)
+	observeLag(msgCtx, msg, queueName)
+	msgCtx = withCorrelation(msgCtx, msg)
+	policy := retryPolicy(c.settings, strings.TrimSuffix(topology.RoutingKey, "."+topology.Region))
+	msgCtx = withRetryPolicy(msgCtx, policy)
+	lease := newLease(c.conn, msg)
+	msgCtx = withLease(msgCtx, lease)
+	defer lease.watch(msgCtx, c.settings.LeaseAfter)()
+	operation := func() (string, error) {
+		err := handleSafely(msgCtx, c.handler, msg, dependencies)
+		var requeueErr *RequeueError
+		var forwardErr *ForwardError
+		if errors.As(err, &requeueErr) || errors.As(err, &forwardErr) {
+			return "", backoff.Permanent(err)
+		}
+		if err != nil && lease.held() {
+			// Acked early: the job's row was reset, so a crash during
+			// the backoff would strand it. Try it again through the
+			// queue instead.
+			return "", backoff.Permanent(err)
+		}
+		if err != nil {
+			return "", err
+		}
+		return "", nil
 	}
 
-	for {
-		select {
-		case delivery, ok := <-deliveries:
-			if !ok {
-				close(jobs)
-				wg.Wait()
-				return nil
-			}
+	_, err := backoff.Retry(msgCtx, operation, retryOptions(policy)...)
+	tracing.End(span, err)
+	var requeueErr *RequeueError
+	var forwardErr *ForwardError
+	if err != nil && ctx.Err() != nil {
+		// Shutting down: the handler handed the job back, so the
+		// message goes back on the queue rather than to the DLQ.
+		lease.requeue(msgCtx, queueName)
+	} else if errors.As(err, &requeueErr) {
+		holdBack(msgCtx, c.intake, requeueErr)
+		lease.requeue(msgCtx, queueName)
+	} else if errors.As(err, &forwardErr) {
+		lease.forward(msgCtx, c.settings.Kind, queueName, Regional(topology, forwardErr.Region))
+	} else if err != nil && lease.held() {
+		zerolog.Ctx(msgCtx).Warn().Err(err).Msg("failed to handle message acked early, republishing it")
+		lease.retry(msgCtx, topology)
+	} else if err != nil {
+		zerolog.Ctx(msgCtx).Error().Err(err).Msg("failed to handle message after all retries")
+		reporting.CaptureFailure(msgCtx, err, reporting.Failure{
+			Stage: "consume",
+			Extra: map[string]interface{}{"queue": queueName, "routing_key": msg.RoutingKey},
+		})
+		lease.deadLetter(msgCtx, topology)
+	} else {
+		lease.ack(msgCtx)
+	}
+}
 
-			jobs <- delivery
-		case <-ctx.Done():
-			close(jobs)
-			wg.Wait()
-			return ctx.Err()
+// handleSafely runs handler, turning a panic into a permanent error so the
+// message is dead-lettered without retries and the worker moves on to the
+// next one instead of taking the process, and its prefetched messages, down.
+func handleSafely[T any](ctx context.Context, handler func(ctx context.Context, msg amqp.Delivery, dependencies T) error, msg amqp.Delivery, dependencies T) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			zerolog.Ctx(ctx).Error().Interface("panic", recovered).Str("stack", string(debug.Stack())).Msg("panic while handling message")
+			err = backoff.Permanent(fmt.Errorf("panic while handling message: %v", recovered))
 		}
-	}
+	}()
+	return handler(ctx, msg, dependencies)
 }
 
 func NewConsumer[T any](
 	conn *amqp.Connection,
-	cfg *config.RabbitMQ,
+	settings Settings,
+	topology Topology,
 	numWorkers int,
+	intake *Intake,
+	dispatch Dispatch,
 	handler func(ctx context.Context, msg amqp.Delivery, dependencies T) error,
 ) Consumer[T] {
 	if numWorkers < 1 {
@@ -149,8 +490,11 @@ func NewConsumer[T any](
 	}
 	return &consumer[T]{
 		conn:       conn,
-		cfg:        cfg,
+		settings:   settings,
+		topology:   topology,
 		handler:    handler,
 		numWorkers: numWorkers,
+		intake:     intake,
+		dispatch:   dispatch,
 	}
 }
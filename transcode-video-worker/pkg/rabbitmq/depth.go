@@ -0,0 +1,70 @@
+package rabbitmq
+
+import (
+	"context"
+	"strings"
+	"time"
+	"worker-transcode/pkg/alerting"
+	"worker-transcode/pkg/metrics"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog"
+)
+
+// WatchQueueDepth polls the given queues with a passive declare every interval
+// and publishes their depth and consumer count as gauges, so autoscalers and
+// dashboards can react to backlog growth. It blocks until ctx is done.
+func WatchQueueDepth(ctx context.Context, conn *amqp.Connection, interval time.Duration, queues ...string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, queue := range queues {
+			if err := observeQueue(ctx, conn, queue); err != nil {
+				zerolog.Ctx(ctx).Warn().Err(err).Str("queue", queue).Msg("failed to inspect queue depth")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// InspectQueue reads a queue's ready message and consumer counts with a
+// passive declare. It uses its own channel because a passive declare of a
+// missing queue closes the channel it ran on.
+func InspectQueue(conn *amqp.Connection, queue string) (amqp.Queue, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+	defer ch.Close()
+
+	return ch.QueueDeclarePassive(queue, true, false, false, false, nil)
+}
+
+func observeQueue(ctx context.Context, conn *amqp.Connection, queue string) error {
+	q, err := InspectQueue(conn, queue)
+	if err != nil {
+		return err
+	}
+	metrics.QueueMessages.WithLabelValues(queue).Set(float64(q.Messages))
+	metrics.QueueConsumers.WithLabelValues(queue).Set(float64(q.Consumers))
+	if strings.HasSuffix(queue, "_dlq") {
+		alerting.ObserveDLQ(ctx, queue, q.Messages)
+	}
+	return nil
+}
+
+// observeLag records how long msg sat in queue, when the producer stamped it.
+func observeLag(ctx context.Context, msg amqp.Delivery, queue string) {
+	if msg.Timestamp.IsZero() {
+		return
+	}
+	if lag := time.Since(msg.Timestamp); lag >= 0 {
+		metrics.Observe(ctx, metrics.QueueLag.WithLabelValues(queue), lag.Seconds())
+	}
+}
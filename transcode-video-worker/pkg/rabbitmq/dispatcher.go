@@ -0,0 +1,114 @@
+package rabbitmq
+
+import (
+	"context"
+	"sync"
+	"worker-transcode/pkg/metrics"
+)
+
+// Dispatcher bounds the messages the consumers of a process handle at once.
+// Each binding's workers already cap its own concurrency; the dispatcher
+// caps them together, so a redelivery storm across the queues, or bindings
+// whose concurrency adds up to more than the node fits, can't start more
+// encodes than it has slots. A worker takes a slot before it starts a
+// message and gives it back once the message is settled. A freed slot goes
+// to the waiting message of the highest rank, the earliest first.
+type Dispatcher struct {
+	mu      sync.Mutex
+	slots   int
+	busy    int
+	waiting []*slotWaiter
+}
+
+type slotWaiter struct {
+	rank    int
+	queue   string
+	granted chan struct{}
+}
+
+// Dispatch is how a consumer takes its slots: from Dispatcher at Rank. The
+// zero Dispatch takes none, leaving the binding's own concurrency as its
+// only bound.
+type Dispatch struct {
+	Dispatcher *Dispatcher
+	Rank       int
+}
+
+func NewDispatcher(slots int) *Dispatcher {
+	return &Dispatcher{slots: max(slots, 1)}
+}
+
+// acquire waits for a slot. It reports false, holding none, when the process
+// drains or stops first.
+func (d Dispatch) acquire(ctx context.Context, intake *Intake, queue string) bool {
+	if d.Dispatcher == nil {
+		return true
+	}
+	return d.Dispatcher.acquire(ctx, intake.Draining(), d.Rank, queue)
+}
+
+func (d Dispatch) release() {
+	if d.Dispatcher != nil {
+		d.Dispatcher.release()
+	}
+}
+
+func (d *Dispatcher) acquire(ctx context.Context, draining <-chan struct{}, rank int, queue string) bool {
+	d.mu.Lock()
+	if d.busy < d.slots && len(d.waiting) == 0 {
+		d.busy++
+		metrics.DispatchSlotsBusy.Set(float64(d.busy))
+		d.mu.Unlock()
+		return true
+	}
+	waiter := &slotWaiter{rank: rank, queue: queue, granted: make(chan struct{})}
+	d.waiting = append(d.waiting, waiter)
+	metrics.DispatchWaiting.WithLabelValues(queue).Inc()
+	d.mu.Unlock()
+
+	select {
+	case <-waiter.granted:
+		return true
+	case <-draining:
+	case <-ctx.Done():
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, waiting := range d.waiting {
+		if waiting == waiter {
+			d.waiting = append(d.waiting[:i], d.waiting[i+1:]...)
+			metrics.DispatchWaiting.WithLabelValues(queue).Dec()
+			return false
+		}
+	}
+	// Granted as it gave up: the slot goes to the next waiter.
+	d.busy--
+	d.grant()
+	return false
+}
+
+func (d *Dispatcher) release() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.busy--
+	d.grant()
+}
+
+// grant hands free slots to the waiters by rank. The caller holds mu.
+func (d *Dispatcher) grant() {
+	for d.busy < d.slots && len(d.waiting) > 0 {
+		next := 0
+		for i, waiting := range d.waiting {
+			if waiting.rank > d.waiting[next].rank {
+				next = i
+			}
+		}
+		waiter := d.waiting[next]
+		d.waiting = append(d.waiting[:next], d.waiting[next+1:]...)
+		metrics.DispatchWaiting.WithLabelValues(waiter.queue).Dec()
+		d.busy++
+		close(waiter.granted)
+	}
+	metrics.DispatchSlotsBusy.Set(float64(d.busy))
+}
@@ -0,0 +1,231 @@
+package rabbitmq
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog"
+)
+
+// Intake tracks the messages a process is handling and lets an operator stop
+// it taking new ones. Every consumer in the process shares one Intake; once it
+// is draining they cancel their subscriptions, requeue prefetched messages and
+// finish only what they had already started. While one of its gates is
+// closed they hold off starting the messages they have, and a queue with a
+// limit holds off while as many of its messages as the limit allows are
+// running.
+type Intake struct {
+	mu       sync.Mutex
+	inFlight map[string]int
+	draining chan struct{}
+	started  bool
+	gates    []Gate
+	limits   map[string]func() int
+	admitted map[string]int
+}
+
+// Gate holds intake back, such as a circuit breaker around a dependency that
+// is down or a node that is overloaded.
+type Gate interface {
+	Name() string
+	// Allow reports whether a new message may be started.
+	Allow() bool
+	// Err explains why the gate is holding intake back, or is nil.
+	Err() error
+}
+
+func NewIntake(gates ...Gate) *Intake {
+	return &Intake{
+		inFlight: map[string]int{},
+		draining: make(chan struct{}),
+		gates:    gates,
+		limits:   map[string]func() int{},
+		admitted: map[string]int{},
+	}
+}
+
+// Limit caps the messages of queue handled at once to what limit returns
+// when the next one is admitted, so a lane can be throttled below its
+// binding's concurrency for a while. Messages already running finish.
+func (i *Intake) Limit(queue string, limit func() int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.limits[queue] = limit
+}
+
+// Drain stops intake. It reports false when a drain was already under way.
+func (i *Intake) Drain() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.started {
+		return false
+	}
+	i.started = true
+	close(i.draining)
+	return true
+}
+
+// Draining is closed once Drain is called.
+func (i *Intake) Draining() <-chan struct{} {
+	return i.draining
+}
+
+func (i *Intake) IsDraining() bool {
+	select {
+	case <-i.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// InFlight counts the messages being handled, per queue.
+func (i *Intake) InFlight() map[string]int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return maps.Clone(i.inFlight)
+}
+
+// Wait blocks until nothing is in flight or ctx is done.
+func (i *Intake) Wait(ctx context.Context) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		if i.total() == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Paused explains, per gate, why intake is held back.
+func (i *Intake) Paused() map[string]string {
+	paused := map[string]string{}
+	for _, gate := range i.gates {
+		if err := gate.Err(); err != nil {
+			paused[gate.Name()] = err.Error()
+		}
+	}
+	return paused
+}
+
+// admit waits until no gate holds intake back, so an outage doesn't burn
+// through the queue into the DLQ and a busy node isn't given more work, and
+// until queue is under its limit. It reports false when the process drains
+// or stops first; once it reports true, the message is released when it's
+// done.
+func (i *Intake) admit(ctx context.Context, queue string) bool {
+	paused, throttled := false, false
+	for {
+		closed := i.closed()
+		if closed == nil {
+			if i.take(queue) {
+				if paused || throttled {
+					zerolog.Ctx(ctx).Info().Str("queue", queue).Msg("resuming intake")
+				}
+				return true
+			}
+			if !throttled {
+				zerolog.Ctx(ctx).Info().Str("queue", queue).Msg("throttling intake, queue is at its limit")
+				throttled = true
+			}
+		} else if !paused {
+			zerolog.Ctx(ctx).Warn().Err(closed.Err()).Str("queue", queue).Str("gate", closed.Name()).Msg("pausing intake")
+			paused = true
+		}
+
+		select {
+		case <-i.draining:
+			return false
+		case <-ctx.Done():
+			return false
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// take counts a message of queue as admitted if the queue is under its
+// limit, or has none.
+func (i *Intake) take(queue string) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	limit, ok := i.limits[queue]
+	if !ok {
+		return true
+	}
+	if i.admitted[queue] >= limit() {
+		return false
+	}
+	i.admitted[queue]++
+	return true
+}
+
+// release gives back a message admitted to queue.
+func (i *Intake) release(queue string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if _, ok := i.limits[queue]; ok && i.admitted[queue] > 0 {
+		i.admitted[queue]--
+	}
+}
+
+func (i *Intake) closed() Gate {
+	for _, gate := range i.gates {
+		if !gate.Allow() {
+			return gate
+		}
+	}
+	return nil
+}
+
+func (i *Intake) total() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	total := 0
+	for _, count := range i.inFlight {
+		total += count
+	}
+	return total
+}
+
+func (i *Intake) begin(queue string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.inFlight[queue]++
+}
+
+func (i *Intake) done(queue string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.inFlight[queue]--; i.inFlight[queue] <= 0 {
+		delete(i.inFlight, queue)
+	}
+}
+
+// requeue hands msg back to the broker for another worker.
+func requeue(ctx context.Context, msg amqp.Delivery, queue string) {
+	if err := msg.Nack(false, true); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Str("queue", queue).Msg("failed to requeue message while draining")
+	}
+}
+
+// stopIntake cancels the subscription and requeues whatever the broker had
+// already pushed to it. The deliveries channel is closed once the cancel is
+// confirmed.
+func stopIntake(ctx context.Context, ch *amqp.Channel, deliveries <-chan amqp.Delivery, queue string) {
+	zerolog.Ctx(ctx).Info().Str("queue", queue).Msg("draining, no longer taking messages")
+	if err := ch.Cancel(queue, false); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Str("queue", queue).Msg("failed to cancel consumer")
+		return
+	}
+	for msg := range deliveries {
+		requeue(ctx, msg, queue)
+	}
+}
@@ -0,0 +1,210 @@
+package rabbitmq
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// leaseRetries caps how often a message acked early is republished after
+	// a failure, as the consumer's own retries are capped.
+	leaseRetries        = 5
+	leaseAttemptsHeader = "x-lease-attempts"
+)
+
+// lease lets a long job outlive the broker's consumer timeout. RabbitMQ can't
+// extend an unacked delivery: one held past consumer_timeout gets its channel
+// closed and everything on it redelivered mid-encode. So once the lease is
+// due and the handler has claimed the job, the message is acked early and the
+// job's row holds the lease instead: the worker keeps heartbeating while
+// ffmpeg runs, and the reaper requeues the job if the heartbeats stop. From
+// then on the message is republished where it would have been requeued or
+// dead-lettered.
+type lease struct {
+	conn *amqp.Connection
+	msg  amqp.Delivery
+
+	mu      sync.Mutex
+	claimed bool
+	due     bool
+	acked   bool
+	settled bool
+}
+
+type leaseKey struct{}
+
+func newLease(conn *amqp.Connection, msg amqp.Delivery) *lease {
+	return &lease{conn: conn, msg: msg}
+}
+
+func withLease(ctx context.Context, l *lease) context.Context {
+	return context.WithValue(ctx, leaseKey{}, l)
+}
+
+// Claimed tells the consumer that the job of the message being handled is
+// claimed, so its row now tracks the work and the message may be acked before
+// the job finishes.
+func Claimed(ctx context.Context) {
+	if l, ok := ctx.Value(leaseKey{}).(*lease); ok {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.claimed = true
+		l.ackEarly(ctx)
+	}
+}
+
+// watch makes the lease due after the delay; zero leaves the message unacked
+// until it is settled. The returned func stops the timer.
+func (l *lease) watch(ctx context.Context, after time.Duration) func() {
+	if after <= 0 {
+		return func() {}
+	}
+	timer := time.AfterFunc(after, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.due = true
+		l.ackEarly(ctx)
+	})
+	return func() { timer.Stop() }
+}
+
+// ackEarly acks the message once the lease is due and the job claimed. The
+// caller holds mu.
+func (l *lease) ackEarly(ctx context.Context) {
+	if !l.claimed || !l.due || l.acked || l.settled {
+		return
+	}
+	if err := l.msg.Ack(false); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to acknowledge long-running message early")
+		return
+	}
+	l.acked = true
+	zerolog.Ctx(ctx).Info().Msg("long-running message acked early, the job's heartbeat holds it from here")
+}
+
+// held reports whether the message was acked early.
+func (l *lease) held() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.acked
+}
+
+// settle stops the lease from acking early and reports whether it already
+// did.
+func (l *lease) settle() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.settled = true
+	return l.acked
+}
+
+// ack acks a handled message.
+func (l *lease) ack(ctx context.Context) {
+	if l.settle() {
+		return
+	}
+	if err := l.msg.Ack(false); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to acknowledge message")
+	}
+}
+
+// requeue hands the message back for another worker.
+func (l *lease) requeue(ctx context.Context, queue string) {
+	if !l.settle() {
+		requeue(ctx, l.msg, queue)
+		return
+	}
+	if err := l.republish(ctx, l.msg.Exchange, l.msg.RoutingKey, l.msg.Headers); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Str("queue", queue).Msg("failed to republish message acked early")
+	}
+}
+
+// retry republishes a message acked early whose handler failed, to be tried
+// again like a retry, or dead-letters it once it is out of attempts.
+func (l *lease) retry(ctx context.Context, topology Topology) {
+	l.settle()
+	headers := amqp.Table{}
+	for key, value := range l.msg.Headers {
+		headers[key] = value
+	}
+	attempts, _ := headers[leaseAttemptsHeader].(int32)
+	if attempts+1 >= leaseRetries {
+		l.deadLetter(ctx, topology)
+		return
+	}
+	headers[leaseAttemptsHeader] = attempts + 1
+	if err := l.republish(ctx, l.msg.Exchange, l.msg.RoutingKey, headers); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Str("queue", topology.Queue).Msg("failed to republish message acked early")
+	}
+}
+
+// forward moves the message to topology, declared first so it waits there
+// until a worker of its region consumes it. One that can't be moved goes
+// back on its queue.
+func (l *lease) forward(ctx context.Context, kind, queue string, topology Topology) {
+	acked := l.settle()
+	err := func() error {
+		ch, err := l.conn.Channel()
+		if err != nil {
+			return err
+		}
+		defer ch.Close()
+		if err := declare(ctx, ch, kind, topology); err != nil {
+			return err
+		}
+		return l.republish(ctx, topology.Exchange, topology.RoutingKey, l.msg.Headers)
+	}()
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Str("queue", topology.Queue).Msg("failed to forward message to its region")
+		if !acked {
+			requeue(ctx, l.msg, queue)
+		}
+		return
+	}
+	zerolog.Ctx(ctx).Info().Str("queue", topology.Queue).Msg("message forwarded to its region")
+	if !acked {
+		if err := l.msg.Ack(false); err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to acknowledge forwarded message")
+		}
+	}
+}
+
+// deadLetter sends the message to the DLQ.
+func (l *lease) deadLetter(ctx context.Context, topology Topology) {
+	if !l.settle() {
+		if err := l.msg.Nack(false, false); err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to nack message to send to DLQ")
+		}
+		return
+	}
+	if err := l.republish(ctx, topology.DLX, topology.DLQRoutingKey, l.msg.Headers); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to publish message acked early to DLQ")
+	}
+}
+
+// republish sends a copy of the message. It runs while shutting down too, so
+// it doesn't stop with ctx.
+func (l *lease) republish(ctx context.Context, exchange, routingKey string, headers amqp.Table) (err error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	defer func() { recordBroker(err) }()
+	ch, err := l.conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	return ch.PublishWithContext(ctx, exchange, routingKey, false, false, amqp.Publishing{
+		Headers:       headers,
+		CorrelationId: l.msg.CorrelationId,
+		ContentType:   l.msg.ContentType,
+		DeliveryMode:  amqp.Persistent,
+		Timestamp:     l.msg.Timestamp,
+		Body:          l.msg.Body,
+	})
+}
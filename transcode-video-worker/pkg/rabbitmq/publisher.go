@@ -0,0 +1,127 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"net"
+	"time"
+	"worker-transcode/pkg/breaker"
+	"worker-transcode/pkg/correlation"
+	"worker-transcode/pkg/tracing"
+)
+
+type Publisher interface {
+	Publish(ctx context.Context, exchange, routingKey string, body any) error
+	// PublishConfirmed sends body like Publish, under messageId, and returns
+	// once the broker has taken responsibility for it.
+	PublishConfirmed(ctx context.Context, exchange, routingKey, messageId string, body any) error
+}
+
+type publisher struct {
+	conn *amqp.Connection
+}
+
+// Publish sends body as a persistent JSON message. A channel is opened per call
+// so the publisher is safe to share between worker goroutines.
+func (p *publisher) Publish(ctx context.Context, exchange, routingKey string, body any) error {
+	return p.publish(ctx, exchange, routingKey, "", false, body)
+}
+
+func (p *publisher) PublishConfirmed(ctx context.Context, exchange, routingKey, messageId string, body any) error {
+	return p.publish(ctx, exchange, routingKey, messageId, true, body)
+}
+
+func (p *publisher) publish(ctx context.Context, exchange, routingKey, messageId string, confirm bool, body any) (err error) {
+	ctx, span := tracer.Start(ctx, routingKey+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.destination.name", exchange),
+			attribute.String("messaging.rabbitmq.destination.routing_key", routingKey),
+		),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	defer func() { recordBroker(err) }()
+	ch, err := p.conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	if confirm {
+		if err := ch.Confirm(false); err != nil {
+			return err
+		}
+	}
+
+	headers := amqp.Table{}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(headers))
+	correlationId := correlation.FromContext(ctx)
+	if correlationId != "" {
+		headers[correlation.Header] = correlationId
+	}
+
+	publishing := amqp.Publishing{
+		Headers:       headers,
+		CorrelationId: correlationId,
+		MessageId:     messageId,
+		ContentType:   "application/json",
+		DeliveryMode:  amqp.Persistent,
+		Timestamp:     time.Now(),
+		Body:          payload,
+	}
+	if !confirm {
+		return ch.PublishWithContext(ctx, exchange, routingKey, false, false, publishing)
+	}
+	confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, routingKey, false, false, publishing)
+	if err != nil {
+		return err
+	}
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return errors.New("broker nacked the message")
+	}
+	return nil
+}
+
+// recordBroker reports a publish to the broker's circuit breaker. A closed
+// connection or a network error means the broker is down; a refused publish
+// doesn't.
+func recordBroker(err error) {
+	var netErr net.Error
+	if errors.Is(err, amqp.ErrClosed) || errors.As(err, &netErr) {
+		breaker.Broker.Failure(err)
+		return
+	}
+	breaker.Broker.Success()
+}
+
+func NewPublisher(conn *amqp.Connection) Publisher {
+	return &publisher{
+		conn: conn,
+	}
+}
+
+// DeclareExchange makes sure an exchange that is only ever published to
+// exists, since publishing to a missing exchange closes the channel.
+func DeclareExchange(conn *amqp.Connection, name, kind string) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	return ch.ExchangeDeclare(name, kind, true, false, false, false, nil)
+}
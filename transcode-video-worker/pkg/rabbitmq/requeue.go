@@ -0,0 +1,61 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// RequeueError asks the consumer to put the message back on its queue after
+// After instead of retrying or dead-lettering it. Handlers return it for jobs
+// this worker can't take right now but which another worker, or this one
+// later, can.
+type RequeueError struct {
+	After time.Duration
+	Err   error
+}
+
+// Requeue wraps err so the message is requeued after the delay.
+func Requeue(err error, after time.Duration) error {
+	return &RequeueError{After: after, Err: err}
+}
+
+func (e *RequeueError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RequeueError) Unwrap() error {
+	return e.Err
+}
+
+// ForwardError asks the consumer to move the message to the queue of the
+// workers of Region, since its job may only run there. Handlers return it
+// before claiming the job.
+type ForwardError struct {
+	Region string
+}
+
+// Forward returns the error moving the message to region's queue.
+func Forward(region string) error {
+	return &ForwardError{Region: region}
+}
+
+func (e *ForwardError) Error() string {
+	return fmt.Sprintf("job must run in region %s", e.Region)
+}
+
+// holdBack waits out the delay so the worker doesn't take the message
+// straight back before it is requeued. A drain or shutdown cuts the wait
+// short.
+func holdBack(ctx context.Context, intake *Intake, requeueErr *RequeueError) {
+	zerolog.Ctx(ctx).Warn().Err(requeueErr.Err).Dur("after", requeueErr.After).Msg("message will be requeued")
+	timer := time.NewTimer(requeueErr.After)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-intake.Draining():
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,70 @@
+package rabbitmq
+
+import (
+	"context"
+	"github.com/cenkalti/backoff/v5"
+	"time"
+)
+
+// Settings is how consumers declare their queues and settle the messages
+// they handle. Kind is the kind of exchange declared. A message unacked for
+// LeaseAfter is acked early once its job is claimed; zero never acks early.
+// Retry is how a failed message is retried, unless RetryPolicies has a
+// policy for the routing key of its queue.
+type Settings struct {
+	Kind          string
+	LeaseAfter    time.Duration
+	Retry         RetryPolicy
+	RetryPolicies map[string]RetryPolicy
+}
+
+// RetryPolicy is how a kind of message is retried: Tries attempts in all,
+// waiting from InitialInterval seconds, doubling up to MaxInterval, between
+// them. Timeout, in seconds, is the base time limit handlers give the job,
+// read with JobTimeout; zero leaves it to them.
+type RetryPolicy struct {
+	Tries           int
+	InitialInterval float64
+	MaxInterval     float64
+	Timeout         int
+}
+
+type retryPolicyKey struct{}
+
+// retryPolicy is the policy of the queue bound to routingKey, or the default
+// one when none is set for it.
+func retryPolicy(settings Settings, routingKey string) RetryPolicy {
+	if policy, ok := settings.RetryPolicies[routingKey]; ok {
+		return policy
+	}
+	return settings.Retry
+}
+
+// retryOptions retry an operation as policy says. Attempts are only limited
+// in number: a multi-hour encode that fails once has already run past any
+// limit on the time spent retrying.
+func retryOptions(policy RetryPolicy) []backoff.RetryOption {
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = seconds(policy.InitialInterval)
+	bo.MaxInterval = seconds(policy.MaxInterval)
+	return []backoff.RetryOption{
+		backoff.WithBackOff(bo),
+		backoff.WithMaxTries(uint(max(policy.Tries, 1))),
+		backoff.WithMaxElapsedTime(0),
+	}
+}
+
+func seconds(value float64) time.Duration {
+	return time.Duration(value * float64(time.Second))
+}
+
+func withRetryPolicy(ctx context.Context, policy RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, policy)
+}
+
+// JobTimeout is the base time limit the retry policy of the message being
+// handled sets its job, or zero where the policy keeps the default.
+func JobTimeout(ctx context.Context) time.Duration {
+	policy, _ := ctx.Value(retryPolicyKey{}).(RetryPolicy)
+	return time.Duration(policy.Timeout) * time.Second
+}
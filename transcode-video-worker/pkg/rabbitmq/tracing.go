@@ -0,0 +1,77 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"worker-transcode/pkg/correlation"
+	"worker-transcode/pkg/tracing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = tracing.Tracer("worker-transcode/pkg/rabbitmq")
+
+// headerCarrier adapts AMQP message headers to the OTel propagation API so
+// trace context flows from the producing service through the queue.
+type headerCarrier amqp.Table
+
+var _ propagation.TextMapCarrier = headerCarrier{}
+
+func (c headerCarrier) Get(key string) string {
+	value, ok := c[key]
+	if !ok {
+		return ""
+	}
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (c headerCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// startConsumeSpan continues the producer's trace, if the message carries one.
+func startConsumeSpan(ctx context.Context, msg amqp.Delivery, queue string) (context.Context, trace.Span) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, headerCarrier(msg.Headers))
+	return tracer.Start(ctx, queue+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.destination.name", queue),
+			attribute.String("messaging.rabbitmq.destination.routing_key", msg.RoutingKey),
+			attribute.Bool("messaging.rabbitmq.redelivered", msg.Redelivered),
+		),
+	)
+}
+
+// withCorrelation tags ctx with the message's correlation ID, taking it from
+// the header, then the AMQP property, and generating one as a last resort.
+func withCorrelation(ctx context.Context, msg amqp.Delivery) context.Context {
+	id := headerCarrier(msg.Headers).Get(correlation.Header)
+	if id == "" {
+		id = msg.CorrelationId
+	}
+	if id == "" {
+		id = correlation.New()
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String(correlation.LogField, id))
+	return correlation.WithID(ctx, id)
+}
@@ -0,0 +1,234 @@
+package rabbitmq
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"worker-transcode/pkg/metrics"
+	"worker-transcode/pkg/reporting"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog"
+)
+
+// Lane is one queue of a weighted consumer. Class labels its metrics.
+type Lane struct {
+	Class    string
+	Topology Topology
+	Weight   int
+}
+
+// weightedConsumer serves several queues from one pool of workers. Whenever a
+// worker is free it takes the next message by smooth weighted round robin
+// over the lanes that have one, so every lane with work gets its share and
+// an idle lane's share goes to the others.
+type weightedConsumer[T any] struct {
+	consumer[T]
+	lanes []Lane
+}
+
+func (c weightedConsumer[T]) Consume(ctx context.Context, dependencies T) error {
+	ch, err := c.conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	// The prefetch applies per lane, so a busy lane can't keep an idle one
+	// from having its next message ready.
+	err = ch.Qos(c.numWorkers, 0, false)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Msg("failed to set QoS")
+		return err
+	}
+
+	scheduler := &laneScheduler{lanes: c.lanes}
+	queues := make([]string, 0, len(c.lanes))
+	for _, lane := range c.lanes {
+		queues = append(queues, lane.Topology.Queue)
+		if err := declare(ctx, ch, c.settings.Kind, lane.Topology); err != nil {
+			return err
+		}
+		deliveries, err := ch.Consume(lane.Topology.Queue, lane.Topology.Queue, false, false, false, false, nil)
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Str("queue", lane.Topology.Queue).Msg("failed to consume queue")
+			return err
+		}
+		scheduler.add(deliveries)
+	}
+	zerolog.Ctx(ctx).Info().Strs("queues", queues).Int("workers", c.numWorkers).Msg("weighted consumer started")
+
+	var wg sync.WaitGroup
+	for i := 1; i <= c.numWorkers; i++ {
+		wg.Add(1)
+		go func(workerId int) {
+			defer wg.Done()
+			defer reporting.Recover(ctx)
+			for {
+				msg, lane, ok := scheduler.next(ctx)
+				if !ok {
+					return
+				}
+				c.handle(ctx, msg, lane.Topology, dependencies)
+			}
+		}(i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-c.intake.Draining():
+		// Cancelling closes each lane's deliveries once they are flushed;
+		// the workers requeue what is left and stop.
+		for _, lane := range c.lanes {
+			zerolog.Ctx(ctx).Info().Str("queue", lane.Topology.Queue).Msg("draining, no longer taking messages")
+			if err := ch.Cancel(lane.Topology.Queue, false); err != nil {
+				zerolog.Ctx(ctx).Error().Err(err).Str("queue", lane.Topology.Queue).Msg("failed to cancel consumer")
+			}
+		}
+		<-done
+		return nil
+	case <-ctx.Done():
+		<-done
+		return ctx.Err()
+	}
+}
+
+// laneScheduler hands the workers the next message across the lanes.
+type laneScheduler struct {
+	lanes []Lane
+
+	mu         sync.Mutex
+	deliveries []<-chan amqp.Delivery
+	pending    [][]amqp.Delivery
+	current    []int
+	closed     []bool
+}
+
+func (s *laneScheduler) add(deliveries <-chan amqp.Delivery) {
+	s.deliveries = append(s.deliveries, deliveries)
+	s.pending = append(s.pending, nil)
+	s.current = append(s.current, 0)
+	s.closed = append(s.closed, false)
+}
+
+// next blocks until a lane has a message and returns the one the weights
+// pick. It reports false once every lane is closed or ctx is done.
+func (s *laneScheduler) next(ctx context.Context) (amqp.Delivery, Lane, bool) {
+	for {
+		s.mu.Lock()
+		s.collect()
+		if i := s.pick(); i >= 0 {
+			msg := s.pending[i][0]
+			s.pending[i] = s.pending[i][1:]
+			s.mu.Unlock()
+			metrics.SLAJobsStarted.WithLabelValues(s.lanes[i].Class).Inc()
+			return msg, s.lanes[i], true
+		}
+
+		cases, lanes := s.waitCases(ctx)
+		s.mu.Unlock()
+		if len(lanes) == 0 {
+			return amqp.Delivery{}, Lane{}, false
+		}
+
+		chosen, value, ok := reflect.Select(cases)
+		if chosen == len(lanes) {
+			return amqp.Delivery{}, Lane{}, false
+		}
+		s.mu.Lock()
+		if ok {
+			s.pending[lanes[chosen]] = append(s.pending[lanes[chosen]], value.Interface().(amqp.Delivery))
+		} else {
+			s.closed[lanes[chosen]] = true
+		}
+		s.mu.Unlock()
+	}
+}
+
+// collect moves whatever the lanes have ready into pending without blocking.
+func (s *laneScheduler) collect() {
+	for i, deliveries := range s.deliveries {
+		if s.closed[i] || len(s.pending[i]) > 0 {
+			continue
+		}
+		select {
+		case msg, ok := <-deliveries:
+			if !ok {
+				s.closed[i] = true
+				continue
+			}
+			s.pending[i] = append(s.pending[i], msg)
+		default:
+		}
+	}
+}
+
+// pick runs one step of smooth weighted round robin over the lanes with a
+// pending message, or returns -1 when there is none.
+func (s *laneScheduler) pick() int {
+	best, total := -1, 0
+	for i := range s.lanes {
+		if len(s.pending[i]) == 0 {
+			continue
+		}
+		weight := max(s.lanes[i].Weight, 1)
+		s.current[i] += weight
+		total += weight
+		if best < 0 || s.current[i] > s.current[best] {
+			best = i
+		}
+	}
+	if best >= 0 {
+		s.current[best] -= total
+	}
+	return best
+}
+
+// waitCases selects on every open lane, then on ctx. lanes maps the cases to
+// their lane.
+func (s *laneScheduler) waitCases(ctx context.Context) ([]reflect.SelectCase, []int) {
+	var cases []reflect.SelectCase
+	var lanes []int
+	for i, deliveries := range s.deliveries {
+		if s.closed[i] {
+			continue
+		}
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(deliveries)})
+		lanes = append(lanes, i)
+	}
+	cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())})
+	return cases, lanes
+}
+
+// NewWeightedConsumer consumes lanes with numWorkers workers between them.
+func NewWeightedConsumer[T any](
+	conn *amqp.Connection,
+	settings Settings,
+	lanes []Lane,
+	numWorkers int,
+	intake *Intake,
+	dispatch Dispatch,
+	handler func(ctx context.Context, msg amqp.Delivery, dependencies T) error,
+) Consumer[T] {
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	return &weightedConsumer[T]{
+		consumer: consumer[T]{
+			conn:       conn,
+			settings:   settings,
+			handler:    handler,
+			numWorkers: numWorkers,
+			intake:     intake,
+			dispatch:   dispatch,
+		},
+		lanes: lanes,
+	}
+}
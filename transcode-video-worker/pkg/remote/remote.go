@@ -0,0 +1,219 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"worker-transcode/config"
+
+	"github.com/rs/zerolog"
+)
+
+// Result is what a remote encode left in the output dir: an MP4 per rung
+// height the provider made, keyed by height, and the audio on its own.
+type Result struct {
+	AssetId    string
+	Renditions map[int]string
+	Audio      string
+}
+
+// Transcoder encodes a source the provider fetches from sourceURL into an
+// H.264 MP4 for each of heights it supports, plus the audio, and downloads
+// them into dir. Packaging them as HLS is left to the caller.
+type Transcoder interface {
+	Name() string
+	Transcode(ctx context.Context, sourceURL string, heights []int, dir string) (*Result, error)
+}
+
+// New returns the transcoder of the configured provider.
+func New(cfg config.Remote) (Transcoder, error) {
+	client := &http.Client{Timeout: 5 * time.Minute}
+	switch cfg.Provider {
+	case "mux":
+		return &mux{cfg: cfg, client: client, baseURL: "https://api.mux.com", streamURL: "https://stream.mux.com"}, nil
+	case "mediaconvert":
+		// MediaConvert only writes its outputs to S3, and the packages here
+		// live in MinIO.
+		return nil, errors.New("MediaConvert needs an S3 output bucket, which this deployment doesn't have")
+	}
+	return nil, fmt.Errorf("unknown remote transcoder %q", cfg.Provider)
+}
+
+// muxResolutions are the heights Mux makes static renditions at.
+var muxResolutions = map[int]string{
+	270: "270p", 360: "360p", 480: "480p", 540: "540p",
+	720: "720p", 1080: "1080p", 1440: "1440p", 2160: "2160p",
+}
+
+type mux struct {
+	cfg       config.Remote
+	client    *http.Client
+	baseURL   string
+	streamURL string
+}
+
+func (m *mux) Name() string {
+	return "mux"
+}
+
+type muxAsset struct {
+	Id          string `json:"id"`
+	Status      string `json:"status"`
+	PlaybackIds []struct {
+		Id string `json:"id"`
+	} `json:"playback_ids"`
+	StaticRenditions struct {
+		Status string `json:"status"`
+		Files  []struct {
+			Name   string `json:"name"`
+			Status string `json:"status"`
+		} `json:"files"`
+	} `json:"static_renditions"`
+	Errors struct {
+		Messages []string `json:"messages"`
+	} `json:"errors"`
+}
+
+// Transcode creates an asset with a static rendition per supported height,
+// waits for them, downloads them and deletes the asset, so nothing is left
+// playable at Mux. The asset is public only for as long as that takes.
+func (m *mux) Transcode(ctx context.Context, sourceURL string, heights []int, dir string) (*Result, error) {
+	renditions := []map[string]string{{"resolution": "audio-only"}}
+	for _, height := range heights {
+		if resolution, ok := muxResolutions[height]; ok {
+			renditions = append(renditions, map[string]string{"resolution": resolution})
+		}
+	}
+	if len(renditions) == 1 {
+		return nil, errors.New("mux makes none of the preset's rendition heights")
+	}
+
+	request := map[string]interface{}{
+		"input":             []map[string]string{{"url": sourceURL}},
+		"playback_policy":   []string{"public"},
+		"video_quality":     "plus",
+		"static_renditions": renditions,
+	}
+	var asset muxAsset
+	if err := m.call(ctx, http.MethodPost, "/video/v1/assets", request, &asset); err != nil {
+		return nil, fmt.Errorf("create mux asset: %w", err)
+	}
+	defer func() {
+		if err := m.call(context.WithoutCancel(ctx), http.MethodDelete, "/video/v1/assets/"+asset.Id, nil, nil); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Str("asset", asset.Id).Msg("failed to delete mux asset")
+		}
+	}()
+
+	ticker := time.NewTicker(time.Duration(max(m.cfg.PollInterval, 1)) * time.Second)
+	defer ticker.Stop()
+	for asset.Status != "ready" || asset.StaticRenditions.Status != "ready" {
+		if asset.Status == "errored" || asset.StaticRenditions.Status == "errored" {
+			return nil, fmt.Errorf("mux asset %s errored: %s", asset.Id, strings.Join(asset.Errors.Messages, "; "))
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+		if err := m.call(ctx, http.MethodGet, "/video/v1/assets/"+asset.Id, nil, &asset); err != nil {
+			return nil, fmt.Errorf("get mux asset: %w", err)
+		}
+	}
+	if len(asset.PlaybackIds) == 0 {
+		return nil, fmt.Errorf("mux asset %s has no playback id", asset.Id)
+	}
+
+	result := &Result{AssetId: asset.Id, Renditions: map[int]string{}}
+	for _, file := range asset.StaticRenditions.Files {
+		if file.Status != "ready" {
+			continue
+		}
+		local := filepath.Join(dir, "remote-"+file.Name)
+		if err := m.download(ctx, asset.PlaybackIds[0].Id, file.Name, local); err != nil {
+			return nil, fmt.Errorf("download %s: %w", file.Name, err)
+		}
+		if strings.HasPrefix(file.Name, "audio") {
+			result.Audio = local
+			continue
+		}
+		height, err := strconv.Atoi(strings.TrimSuffix(file.Name, "p.mp4"))
+		if err != nil {
+			continue
+		}
+		result.Renditions[height] = local
+	}
+	if result.Audio == "" || len(result.Renditions) == 0 {
+		return nil, fmt.Errorf("mux asset %s is missing its audio or video renditions", asset.Id)
+	}
+	return result, nil
+}
+
+func (m *mux) call(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, m.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(m.cfg.TokenId, m.cfg.TokenSecret)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("mux returned %s: %s", resp.Status, raw)
+	}
+	if out == nil {
+		return nil
+	}
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return err
+	}
+	return json.Unmarshal(envelope.Data, out)
+}
+
+func (m *mux) download(ctx context.Context, playbackId, name, path string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/%s/%s", m.streamURL, playbackId, name), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("mux stream returned %s", resp.Status)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
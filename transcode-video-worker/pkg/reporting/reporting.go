@@ -0,0 +1,87 @@
+package reporting
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/pkg/correlation"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/rs/zerolog"
+)
+
+// Failure describes where a job failed; it becomes the tags and context of
+// the reported event.
+type Failure struct {
+	JobId   string
+	JobType string
+	Stage   string
+	// Output is the tail of the failing process' output, e.g. ffmpeg stderr.
+	Output string
+	Extra  map[string]interface{}
+}
+
+// Setup initialises the Sentry client. With an empty DSN the SDK stays
+// disabled and every capture below is a no-op.
+func Setup(cfg config.Sentry, environment, release string) (func(), error) {
+	if cfg.DSN == "" {
+		return func() {}, nil
+	}
+
+	err := sentry.Init(sentry.ClientOptions{
+		Dsn:              cfg.DSN,
+		Environment:      environment,
+		Release:          release,
+		SampleRate:       cfg.SampleRate,
+		AttachStacktrace: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return func() { sentry.Flush(2 * time.Second) }, nil
+}
+
+// CaptureFailure reports a job that failed for good (non-retryable or out of retries).
+func CaptureFailure(ctx context.Context, err error, failure Failure) {
+	sentry.WithScope(func(scope *sentry.Scope) {
+		scope.SetTag("job_id", failure.JobId)
+		scope.SetTag("job_type", failure.JobType)
+		scope.SetTag("stage", failure.Stage)
+		if id := correlation.FromContext(ctx); id != "" {
+			scope.SetTag(correlation.LogField, id)
+		}
+		if failure.Output != "" {
+			scope.SetContext("process", sentry.Context{"output": failure.Output})
+		}
+		if len(failure.Extra) > 0 {
+			scope.SetContext("job", failure.Extra)
+		}
+		scope.SetFingerprint([]string{"job-failure", failure.JobType, failure.Stage})
+		sentry.CaptureException(err)
+	})
+}
+
+// Recover reports a panic and re-raises it. It must be deferred directly.
+func Recover(ctx context.Context) {
+	if recovered := recover(); recovered != nil {
+		zerolog.Ctx(ctx).Error().Interface("panic", recovered).Msg("panic in worker")
+		hub := sentry.CurrentHub().Clone()
+		if id := correlation.FromContext(ctx); id != "" {
+			hub.Scope().SetTag(correlation.LogField, id)
+		}
+		hub.RecoverWithContext(ctx, recovered)
+		hub.Flush(2 * time.Second)
+		panic(recovered)
+	}
+}
+
+// Tail returns at most the last n bytes of output, which is where ffmpeg
+// prints the actual error.
+func Tail(output string, n int) string {
+	if len(output) <= n {
+		return output
+	}
+	return fmt.Sprintf("...%s", output[len(output)-n:])
+}
@@ -0,0 +1,113 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+	"worker-transcode/config"
+
+	"github.com/google/uuid"
+)
+
+// contentMapping indexes one document per lesson. Titles are searchable
+// text with an exact keyword beside them for sorting; chapters and
+// transcripts are nested so a hit can tell which chapter or language it
+// matched.
+const contentMapping = `{
+  "mappings": {
+    "properties": {
+      "lesson_id":         {"type": "keyword"},
+      "course_id":         {"type": "keyword"},
+      "lesson_title":      {"type": "text", "fields": {"raw": {"type": "keyword"}}},
+      "course_title":      {"type": "text", "fields": {"raw": {"type": "keyword"}}},
+      "lesson_slug":       {"type": "keyword"},
+      "course_slug":       {"type": "keyword"},
+      "duration_seconds":  {"type": "double"},
+      "width":             {"type": "integer"},
+      "height":            {"type": "integer"},
+      "audio_languages":   {"type": "keyword"},
+      "caption_languages": {"type": "keyword"},
+      "chapters": {
+        "type": "nested",
+        "properties": {
+          "start": {"type": "double"},
+          "title": {"type": "text"}
+        }
+      },
+      "transcripts": {
+        "type": "nested",
+        "properties": {
+          "language": {"type": "keyword"},
+          "text":     {"type": "text"}
+        }
+      },
+      "updated_at":        {"type": "date"}
+    }
+  }
+}`
+
+// ContentChapter is a chapter of a lesson as the content index holds it.
+type ContentChapter struct {
+	Start float64 `json:"start"`
+	Title string  `json:"title"`
+}
+
+// ContentTranscript is the whole text of one of a lesson's caption tracks.
+type ContentTranscript struct {
+	Language string `json:"language"`
+	Text     string `json:"text"`
+}
+
+// ContentDocument is everything platform search knows of a lesson.
+type ContentDocument struct {
+	LessonId         uuid.UUID           `json:"lesson_id"`
+	CourseId         uuid.UUID           `json:"course_id"`
+	LessonTitle      string              `json:"lesson_title"`
+	CourseTitle      string              `json:"course_title"`
+	LessonSlug       string              `json:"lesson_slug,omitempty"`
+	CourseSlug       string              `json:"course_slug,omitempty"`
+	DurationSeconds  float64             `json:"duration_seconds,omitempty"`
+	Width            int                 `json:"width,omitempty"`
+	Height           int                 `json:"height,omitempty"`
+	AudioLanguages   []string            `json:"audio_languages,omitempty"`
+	CaptionLanguages []string            `json:"caption_languages,omitempty"`
+	Chapters         []ContentChapter    `json:"chapters,omitempty"`
+	Transcripts      []ContentTranscript `json:"transcripts,omitempty"`
+	UpdatedAt        time.Time           `json:"updated_at"`
+}
+
+// ContentIndex holds the lessons of the whole platform for searching across
+// courses.
+type ContentIndex interface {
+	// Put replaces the lesson's document.
+	Put(ctx context.Context, document ContentDocument) error
+}
+
+type elasticsearchContent struct {
+	*elasticsearchIndex
+}
+
+func (e *elasticsearchContent) Put(ctx context.Context, document ContentDocument) error {
+	if err := e.ensureIndex(ctx); err != nil {
+		return err
+	}
+	if err := e.do(ctx, http.MethodPut, "/_doc/"+url.PathEscape(document.LessonId.String()), document, nil); err != nil {
+		return fmt.Errorf("index content of lesson %s: %w", document.LessonId, err)
+	}
+	return nil
+}
+
+// NewElasticsearchContent returns the content index on the Elasticsearch or
+// OpenSearch cluster transcripts are searched on.
+func NewElasticsearchContent(cfg config.Search) ContentIndex {
+	cfg.Index = cfg.ContentIndex
+	return &elasticsearchContent{
+		elasticsearchIndex: &elasticsearchIndex{
+			cfg:     cfg,
+			mapping: contentMapping,
+			client:  &http.Client{Timeout: 30 * time.Second},
+		},
+	}
+}
@@ -0,0 +1,224 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"worker-transcode/config"
+
+	"github.com/google/uuid"
+)
+
+// indexMapping keeps the ids exact for filtering and leaves the cue text to
+// the standard analyzer. OpenSearch accepts the same mapping.
+const indexMapping = `{
+  "mappings": {
+    "properties": {
+      "course_id": {"type": "keyword"},
+      "lesson_id": {"type": "keyword"},
+      "language":  {"type": "keyword"},
+      "start":     {"type": "double"},
+      "end":       {"type": "double"},
+      "text":      {"type": "text"},
+      "speaker":   {"type": "keyword"}
+    }
+  }
+}`
+
+type elasticsearchIndex struct {
+	cfg     config.Search
+	mapping string
+	client  *http.Client
+
+	mu    sync.Mutex
+	ready bool
+}
+
+type cueDocument struct {
+	CourseId uuid.UUID `json:"course_id"`
+	LessonId uuid.UUID `json:"lesson_id"`
+	Language string    `json:"language"`
+	Start    float64   `json:"start"`
+	End      float64   `json:"end"`
+	Text     string    `json:"text"`
+	Speaker  string    `json:"speaker,omitempty"`
+}
+
+// Replace deletes the lesson's cues and bulk indexes the new ones, one
+// document per cue so a hit points at a moment in the video.
+func (e *elasticsearchIndex) Replace(ctx context.Context, transcript Transcript) error {
+	if err := e.ensureIndex(ctx); err != nil {
+		return err
+	}
+
+	query := map[string]any{"query": map[string]any{"term": map[string]any{"lesson_id": transcript.LessonId}}}
+	if err := e.do(ctx, http.MethodPost, "/_delete_by_query?refresh=true&conflicts=proceed", query, nil); err != nil {
+		return fmt.Errorf("delete transcript of lesson %s: %w", transcript.LessonId, err)
+	}
+	if len(transcript.Cues) == 0 {
+		return nil
+	}
+
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, cue := range transcript.Cues {
+		if err := encoder.Encode(map[string]any{"index": map[string]any{}}); err != nil {
+			return err
+		}
+		err := encoder.Encode(cueDocument{
+			CourseId: transcript.CourseId,
+			LessonId: transcript.LessonId,
+			Language: transcript.Language,
+			Start:    cue.Start,
+			End:      cue.End,
+			Text:     cue.Text,
+			Speaker:  cue.Speaker,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Error json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if err := e.send(ctx, http.MethodPost, "/_bulk?refresh=true", "application/x-ndjson", &body, &result); err != nil {
+		return fmt.Errorf("index transcript of lesson %s: %w", transcript.LessonId, err)
+	}
+	if result.Errors {
+		for _, item := range result.Items {
+			for _, action := range item {
+				if len(action.Error) > 0 {
+					return fmt.Errorf("index transcript of lesson %s: %s", transcript.LessonId, action.Error)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (e *elasticsearchIndex) Search(ctx context.Context, courseId uuid.UUID, query string, limit int) ([]Hit, error) {
+	if err := e.ensureIndex(ctx); err != nil {
+		return nil, err
+	}
+
+	request := map[string]any{
+		"size": limit,
+		"query": map[string]any{
+			"bool": map[string]any{
+				"must":   map[string]any{"match": map[string]any{"text": map[string]any{"query": query, "operator": "and"}}},
+				"filter": map[string]any{"term": map[string]any{"course_id": courseId}},
+			},
+		},
+		"highlight": map[string]any{
+			"pre_tags":  []string{"<mark>"},
+			"post_tags": []string{"</mark>"},
+			"fields":    map[string]any{"text": map[string]any{"number_of_fragments": 0}},
+		},
+	}
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				Score     float64     `json:"_score"`
+				Source    cueDocument `json:"_source"`
+				Highlight struct {
+					Text []string `json:"text"`
+				} `json:"highlight"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := e.do(ctx, http.MethodPost, "/_search", request, &result); err != nil {
+		return nil, fmt.Errorf("search transcripts of course %s: %w", courseId, err)
+	}
+
+	hits := make([]Hit, 0, len(result.Hits.Hits))
+	for _, hit := range result.Hits.Hits {
+		snippet := hit.Source.Text
+		if len(hit.Highlight.Text) > 0 {
+			snippet = hit.Highlight.Text[0]
+		}
+		hits = append(hits, Hit{
+			LessonId: hit.Source.LessonId,
+			Language: hit.Source.Language,
+			Start:    hit.Source.Start,
+			End:      hit.Source.End,
+			Text:     hit.Source.Text,
+			Speaker:  hit.Source.Speaker,
+			Snippet:  snippet,
+			Score:    hit.Score,
+		})
+	}
+	return hits, nil
+}
+
+// ensureIndex creates the index with its mapping the first time it is used.
+func (e *elasticsearchIndex) ensureIndex(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.ready {
+		return nil
+	}
+
+	err := e.send(ctx, http.MethodPut, "", "application/json", strings.NewReader(e.mapping), nil)
+	if err != nil && !strings.Contains(err.Error(), "resource_already_exists_exception") {
+		return fmt.Errorf("create index %s: %w", e.cfg.Index, err)
+	}
+	e.ready = true
+	return nil
+}
+
+func (e *elasticsearchIndex) do(ctx context.Context, method, path string, body any, out any) error {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return e.send(ctx, method, path, "application/json", bytes.NewReader(raw), out)
+}
+
+// send calls path on the index, decoding the response into out when it is
+// set.
+func (e *elasticsearchIndex) send(ctx context.Context, method, path, contentType string, body io.Reader, out any) error {
+	endpoint := strings.TrimRight(e.cfg.ElasticsearchURL, "/") + "/" + url.PathEscape(e.cfg.Index) + path
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if e.cfg.User != "" {
+		req.SetBasicAuth(e.cfg.User, e.cfg.Pass)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("elasticsearch returned %s: %s", resp.Status, detail)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// NewElasticsearch returns an index on an Elasticsearch or OpenSearch cluster.
+func NewElasticsearch(cfg config.Search) Index {
+	return &elasticsearchIndex{
+		cfg:     cfg,
+		mapping: indexMapping,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
@@ -0,0 +1,151 @@
+package search
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Cue is one timed stretch of a transcript, in seconds. Speaker is who
+// says it, when that's known.
+type Cue struct {
+	Start   float64 `json:"start"`
+	End     float64 `json:"end"`
+	Text    string  `json:"text"`
+	Speaker string  `json:"speaker,omitempty"`
+}
+
+// Transcript is the text of one lesson's video.
+type Transcript struct {
+	CourseId uuid.UUID
+	LessonId uuid.UUID
+	Language string
+	Cues     []Cue
+}
+
+// Hit is a cue matching a search. Snippet is its text with the matched terms
+// wrapped in <mark>.
+type Hit struct {
+	LessonId uuid.UUID `json:"lesson_id"`
+	Language string    `json:"language"`
+	Start    float64   `json:"start"`
+	End      float64   `json:"end"`
+	Text     string    `json:"text"`
+	Speaker  string    `json:"speaker,omitempty"`
+	Snippet  string    `json:"snippet"`
+	Score    float64   `json:"score"`
+}
+
+// Index stores transcripts for searching inside the videos of a course.
+type Index interface {
+	// Replace swaps the lesson's transcript for this one.
+	Replace(ctx context.Context, transcript Transcript) error
+	// Search returns the best matching cues across the course's lessons.
+	Search(ctx context.Context, courseId uuid.UUID, query string, limit int) ([]Hit, error)
+}
+
+var (
+	cueTagPattern = regexp.MustCompile(`<[^>]*>`)
+	// voicePattern matches a voice tag, <v Name> or <v.class Name>, the
+	// speaker's name in its first group.
+	voicePattern  = regexp.MustCompile(`^<v(?:\.[^\s>]*)?\s+([^>]+)>`)
+	timingPattern = regexp.MustCompile(`^((?:\d+:)?\d{2}:\d{2}\.\d{3})\s+-->\s+((?:\d+:)?\d{2}:\d{2}\.\d{3})`)
+)
+
+// ParseWebVTT reads the cues of a WebVTT file. A cue opening with a voice
+// tag is spoken by its speaker; cue settings and other tags are dropped, as
+// are NOTE, STYLE and REGION blocks.
+func ParseWebVTT(r io.Reader) ([]Cue, error) {
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() || !strings.HasPrefix(strings.TrimPrefix(scanner.Text(), "\ufeff"), "WEBVTT") {
+		return nil, fmt.Errorf("not a WebVTT file")
+	}
+
+	var (
+		cues    []Cue
+		current *Cue
+		text    []string
+	)
+	flush := func() {
+		if current != nil && len(text) > 0 {
+			current.Text = strings.Join(text, " ")
+			cues = append(cues, *current)
+		}
+		current, text = nil, nil
+	}
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			flush()
+		case current != nil:
+			if match := voicePattern.FindStringSubmatch(line); match != nil && len(text) == 0 {
+				current.Speaker = strings.TrimSpace(match[1])
+			}
+			if plain := strings.TrimSpace(cueTagPattern.ReplaceAllString(line, "")); plain != "" {
+				text = append(text, plain)
+			}
+		default:
+			// An identifier line may come before the timing; anything
+			// else outside a cue belongs to a NOTE, STYLE or REGION block.
+			match := timingPattern.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+			start, err := vttTimestamp(match[1])
+			if err != nil {
+				return nil, err
+			}
+			end, err := vttTimestamp(match[2])
+			if err != nil {
+				return nil, err
+			}
+			current = &Cue{Start: start, End: end}
+		}
+	}
+	flush()
+	return cues, scanner.Err()
+}
+
+// WriteWebVTT writes cues as a WebVTT file, the speaker of a cue in a voice
+// tag players can show.
+func WriteWebVTT(w io.Writer, cues []Cue) error {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for _, cue := range cues {
+		text := cue.Text
+		if speaker := strings.Trim(strings.NewReplacer(">", "", "\n", " ").Replace(cue.Speaker), " "); speaker != "" {
+			text = "<v " + speaker + ">" + text
+		}
+		fmt.Fprintf(&b, "\n%s --> %s\n%s\n", formatTimestamp(cue.Start), formatTimestamp(cue.End), text)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// formatTimestamp formats seconds as hh:mm:ss.ttt.
+func formatTimestamp(seconds float64) string {
+	millis := int64(math.Round(seconds * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", millis/3600000, millis/60000%60, millis/1000%60, millis%1000)
+}
+
+// vttTimestamp parses [hh:]mm:ss.ttt into seconds.
+func vttTimestamp(raw string) (float64, error) {
+	parts := strings.Split(raw, ":")
+	var seconds float64
+	for _, part := range parts {
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, fmt.Errorf("bad WebVTT timestamp %q", raw)
+		}
+		seconds = seconds*60 + value
+	}
+	return seconds, nil
+}
@@ -0,0 +1,159 @@
+package translate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"worker-transcode/config"
+)
+
+// maxBatch is how many texts go in one request, within what both providers
+// accept.
+const maxBatch = 50
+
+// Translator machine-translates caption text. Texts come back in the order
+// they were given.
+type Translator interface {
+	Translate(ctx context.Context, texts []string, source, target string) ([]string, error)
+}
+
+// New returns the translator of the configured provider.
+func New(cfg config.Translation) (Translator, error) {
+	client := &http.Client{Timeout: 60 * time.Second}
+	switch cfg.Provider {
+	case "deepl":
+		return &deepl{cfg: cfg, client: client}, nil
+	case "google":
+		return &google{cfg: cfg, client: client}, nil
+	}
+	return nil, fmt.Errorf("unknown translation provider %q", cfg.Provider)
+}
+
+// batched translates texts maxBatch at a time.
+func batched(texts []string, translate func(batch []string) ([]string, error)) ([]string, error) {
+	translated := make([]string, 0, len(texts))
+	for start := 0; start < len(texts); start += maxBatch {
+		batch := texts[start:min(start+maxBatch, len(texts))]
+		result, err := translate(batch)
+		if err != nil {
+			return nil, err
+		}
+		if len(result) != len(batch) {
+			return nil, fmt.Errorf("translated %d of %d texts", len(result), len(batch))
+		}
+		translated = append(translated, result...)
+	}
+	return translated, nil
+}
+
+type deepl struct {
+	cfg    config.Translation
+	client *http.Client
+}
+
+// Translate calls the DeepL API, which takes languages in upper case.
+func (d *deepl) Translate(ctx context.Context, texts []string, source, target string) ([]string, error) {
+	endpoint := strings.TrimRight(orDefault(d.cfg.URL, "https://api.deepl.com"), "/") + "/v2/translate"
+	return batched(texts, func(batch []string) ([]string, error) {
+		request := map[string]any{
+			"text":        batch,
+			"source_lang": strings.ToUpper(BaseLanguage(source)),
+			"target_lang": strings.ToUpper(target),
+		}
+		var result struct {
+			Translations []struct {
+				Text string `json:"text"`
+			} `json:"translations"`
+		}
+		header := http.Header{"Authorization": {"DeepL-Auth-Key " + d.cfg.APIKey}}
+		if err := post(ctx, d.client, endpoint, header, request, &result); err != nil {
+			return nil, fmt.Errorf("deepl: %w", err)
+		}
+		translated := make([]string, 0, len(result.Translations))
+		for _, translation := range result.Translations {
+			translated = append(translated, translation.Text)
+		}
+		return translated, nil
+	})
+}
+
+type google struct {
+	cfg    config.Translation
+	client *http.Client
+}
+
+// Translate calls version 2 of the Cloud Translation API.
+func (g *google) Translate(ctx context.Context, texts []string, source, target string) ([]string, error) {
+	endpoint := strings.TrimRight(orDefault(g.cfg.URL, "https://translation.googleapis.com"), "/") +
+		"/language/translate/v2?key=" + url.QueryEscape(g.cfg.APIKey)
+	return batched(texts, func(batch []string) ([]string, error) {
+		request := map[string]any{
+			"q":      batch,
+			"source": BaseLanguage(source),
+			"target": target,
+			"format": "text",
+		}
+		var result struct {
+			Data struct {
+				Translations []struct {
+					TranslatedText string `json:"translatedText"`
+				} `json:"translations"`
+			} `json:"data"`
+		}
+		if err := post(ctx, g.client, endpoint, nil, request, &result); err != nil {
+			return nil, fmt.Errorf("google translate: %w", err)
+		}
+		translated := make([]string, 0, len(result.Data.Translations))
+		for _, translation := range result.Data.Translations {
+			translated = append(translated, translation.TranslatedText)
+		}
+		return translated, nil
+	})
+}
+
+func post(ctx context.Context, client *http.Client, endpoint string, header http.Header, body, out any) error {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("returned %s: %s", resp.Status, detail)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// BaseLanguage drops the region of a caption language, such as en-US, which
+// providers don't take for the source.
+func BaseLanguage(language string) string {
+	base, _, _ := strings.Cut(language, "-")
+	return base
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
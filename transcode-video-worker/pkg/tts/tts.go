@@ -0,0 +1,125 @@
+package tts
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"worker-transcode/config"
+)
+
+// Synthesizer speaks text as MP3 audio.
+type Synthesizer interface {
+	Synthesize(ctx context.Context, text, voice, language string) ([]byte, error)
+}
+
+// New returns the synthesizer of the configured provider.
+func New(cfg config.TTS) (Synthesizer, error) {
+	client := &http.Client{Timeout: 120 * time.Second}
+	switch cfg.Provider {
+	case "google":
+		return &google{cfg: cfg, client: client}, nil
+	case "openai":
+		return &openai{cfg: cfg, client: client}, nil
+	}
+	return nil, fmt.Errorf("unknown tts provider %q", cfg.Provider)
+}
+
+type google struct {
+	cfg    config.TTS
+	client *http.Client
+}
+
+// Synthesize calls the Cloud Text-to-Speech API, which returns the audio
+// base64 encoded.
+func (g *google) Synthesize(ctx context.Context, text, voice, language string) ([]byte, error) {
+	endpoint := strings.TrimRight(orDefault(g.cfg.URL, "https://texttospeech.googleapis.com"), "/") +
+		"/v1/text:synthesize?key=" + url.QueryEscape(g.cfg.APIKey)
+	// Without a name the API picks the language's default voice.
+	selection := map[string]any{"languageCode": language}
+	if voice != "" {
+		selection["name"] = voice
+	}
+	request := map[string]any{
+		"input":       map[string]any{"text": text},
+		"voice":       selection,
+		"audioConfig": map[string]any{"audioEncoding": "MP3", "sampleRateHertz": 48000},
+	}
+	resp, err := post(ctx, g.client, endpoint, nil, request)
+	if err != nil {
+		return nil, fmt.Errorf("google tts: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		AudioContent string `json:"audioContent"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("google tts: %w", err)
+	}
+	return base64.StdEncoding.DecodeString(result.AudioContent)
+}
+
+type openai struct {
+	cfg    config.TTS
+	client *http.Client
+}
+
+// Synthesize calls the OpenAI speech endpoint. Its voices speak whatever
+// language the text is in, so language isn't sent.
+func (o *openai) Synthesize(ctx context.Context, text, voice, language string) ([]byte, error) {
+	endpoint := strings.TrimRight(orDefault(o.cfg.URL, "https://api.openai.com"), "/") + "/v1/audio/speech"
+	request := map[string]any{
+		"model":           orDefault(o.cfg.Model, "tts-1-hd"),
+		"input":           text,
+		"voice":           orDefault(voice, "alloy"),
+		"response_format": "mp3",
+	}
+	header := http.Header{"Authorization": {"Bearer " + o.cfg.APIKey}}
+	resp, err := post(ctx, o.client, endpoint, header, request)
+	if err != nil {
+		return nil, fmt.Errorf("openai tts: %w", err)
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// post sends body as JSON, returning the response when it succeeded.
+func post(ctx context.Context, client *http.Client, endpoint string, header http.Header, body any) (*http.Response, error) {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		defer resp.Body.Close()
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("returned %s: %s", resp.Status, detail)
+	}
+	return resp, nil
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
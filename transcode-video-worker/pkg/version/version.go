@@ -0,0 +1,100 @@
+// Package version describes the running build. Commit and BuildTime are set at
+// link time:
+//
+//	go build -ldflags "-X worker-transcode/pkg/version.Commit=$(git rev-parse HEAD) \
+//	  -X worker-transcode/pkg/version.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// and otherwise fall back to the VCS stamp Go records when building inside a
+// checkout.
+package version
+
+import (
+	"context"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+	"worker-transcode/pkg/ffmpeg"
+)
+
+var (
+	Commit    string
+	BuildTime string
+)
+
+// Info is what `worker version` and the health endpoint report.
+type Info struct {
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+	FFmpeg    string `json:"ffmpeg"`
+	// Dependencies maps module paths to versions; only filled on request.
+	Dependencies map[string]string `json:"dependencies,omitempty"`
+}
+
+var build = sync.OnceValue(func() Info {
+	info := Info{Commit: Commit, BuildTime: BuildTime, GoVersion: runtime.Version()}
+	if buildInfo, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range buildInfo.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.BuildTime == "" {
+					info.BuildTime = setting.Value
+				}
+			case "vcs.modified":
+				info.Modified = setting.Value == "true"
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	return info
+})
+
+// ffmpegVersion is looked up once; the binary doesn't change under a running
+// process and the health endpoint shouldn't fork on every probe.
+var ffmpegVersion = sync.OnceValue(func() string {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	output, err := ffmpeg.Command(ctx, "-hide_banner", "-version").Output()
+	if err != nil {
+		return "unavailable"
+	}
+	line, _, _ := strings.Cut(string(output), "\n")
+	return strings.TrimPrefix(line, "ffmpeg version ")
+})
+
+// Get returns the build metadata and detected ffmpeg version.
+func Get() Info {
+	info := build()
+	info.FFmpeg = ffmpegVersion()
+	return info
+}
+
+// Release names the build for error reports and traces.
+func Release() string {
+	return build().Commit
+}
+
+// Dependencies lists the modules compiled into the binary.
+func Dependencies() map[string]string {
+	deps := map[string]string{}
+	buildInfo, ok := debug.ReadBuildInfo()
+	if !ok {
+		return deps
+	}
+	for _, dep := range buildInfo.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		deps[dep.Path] = dep.Version
+	}
+	return deps
+}
@@ -0,0 +1,111 @@
+package warehouse
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress/snappy"
+)
+
+// Job is a job that finished, one row of the jobs dataset. Its output
+// columns are set for transcodes that produced a package.
+type Job struct {
+	ID                string    `parquet:"id"`
+	JobType           string    `parquet:"job_type,dict"`
+	Status            string    `parquet:"status,dict"`
+	EntityId          string    `parquet:"entity_id"`
+	TenantId          *string   `parquet:"tenant_id"`
+	WorkerId          *string   `parquet:"worker_id"`
+	SLAClass          *string   `parquet:"sla_class,dict"`
+	Priority          int32     `parquet:"priority"`
+	Preset            *string   `parquet:"preset,dict"`
+	PresetVersion     *int32    `parquet:"preset_version"`
+	ErrorClass        *string   `parquet:"error_class,dict"`
+	ErrorKind         *string   `parquet:"error_kind,dict"`
+	SourceSeconds     *float64  `parquet:"source_seconds"`
+	OutputBytes       *int64    `parquet:"output_bytes"`
+	VideoCodec        *string   `parquet:"video_codec,dict"`
+	TurnaroundSeconds float64   `parquet:"turnaround_seconds"`
+	CreatedAt         time.Time `parquet:"created_at,timestamp(microsecond)"`
+	UpdatedAt         time.Time `parquet:"updated_at,timestamp(microsecond)"`
+}
+
+// Rendition is one rung of a package a job produced, with its quality scores
+// when the job's preset scored them.
+type Rendition struct {
+	JobId         string    `parquet:"job_id"`
+	LessonId      string    `parquet:"lesson_id"`
+	TenantId      *string   `parquet:"tenant_id"`
+	Preset        string    `parquet:"preset,dict"`
+	PresetVersion int32     `parquet:"preset_version"`
+	VideoCodec    *string   `parquet:"video_codec,dict"`
+	Width         int32     `parquet:"width"`
+	Height        int32     `parquet:"height"`
+	Bitrate       string    `parquet:"bitrate,dict"`
+	SegmentFormat string    `parquet:"segment_format,dict"`
+	Container     *string   `parquet:"container,dict"`
+	AverageKbps   *float64  `parquet:"average_kbps"`
+	VMAF          *float64  `parquet:"vmaf"`
+	VMAFMin       *float64  `parquet:"vmaf_min"`
+	VMAFHarmonic  *float64  `parquet:"vmaf_harmonic"`
+	CreatedAt     time.Time `parquet:"created_at,timestamp(microsecond)"`
+}
+
+// QCFlag is a quality check finding, written again by the export after it
+// is reviewed; the latest row of a flag is its state.
+type QCFlag struct {
+	ID         string    `parquet:"id"`
+	LessonId   string    `parquet:"lesson_id"`
+	JobId      string    `parquet:"job_id"`
+	Check      string    `parquet:"check,dict"`
+	Status     string    `parquet:"status,dict"`
+	Matches    int32     `parquet:"matches"`
+	ReviewedBy *string   `parquet:"reviewed_by"`
+	CreatedAt  time.Time `parquet:"created_at,timestamp(microsecond)"`
+	ChangedAt  time.Time `parquet:"changed_at,timestamp(microsecond)"`
+}
+
+// EncodingUsage is a tenant's running encoding total for a month, written
+// again by each export after it grows; the latest row of a tenant and period
+// is its total.
+type EncodingUsage struct {
+	TenantId  string    `parquet:"tenant_id"`
+	Period    time.Time `parquet:"period,timestamp(microsecond)"`
+	Seconds   float64   `parquet:"seconds"`
+	Jobs      int32     `parquet:"jobs"`
+	UpdatedAt time.Time `parquet:"updated_at,timestamp(microsecond)"`
+}
+
+// StorageUsage is a course's or tenant's running storage total for a
+// rendition class, written again by each export after it changes.
+type StorageUsage struct {
+	Scope          string    `parquet:"scope,dict"`
+	ScopeId        string    `parquet:"scope_id"`
+	RenditionClass string    `parquet:"rendition_class,dict"`
+	Bytes          int64     `parquet:"bytes"`
+	Objects        int64     `parquet:"objects"`
+	UpdatedAt      time.Time `parquet:"updated_at,timestamp(microsecond)"`
+}
+
+// Encode writes rows as one snappy-compressed Parquet file.
+func Encode[T any](rows []T) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := parquet.NewGenericWriter[T](&buf, parquet.Compression(&snappy.Codec{}))
+	if _, err := writer.Write(rows); err != nil {
+		return nil, fmt.Errorf("write parquet rows: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("close parquet file: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Key is where a dataset's file for the rows of day starting at from goes:
+// Hive-style partitions by day, which warehouse engines prune on, and a name
+// from the export's start so an export run again replaces its file.
+func Key(dataset string, from time.Time) string {
+	from = from.UTC()
+	return fmt.Sprintf("%s/date=%s/part-%d.parquet", dataset, from.Format(time.DateOnly), from.UnixMicro())
+}
@@ -0,0 +1,223 @@
+package zoom
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiURL   = "https://api.zoom.us/v2"
+	oauthURL = "https://zoom.us/oauth/token"
+
+	// maxWebhookAge is how old a webhook's timestamp may be before it is
+	// taken for a replay.
+	maxWebhookAge = 5 * time.Minute
+)
+
+// Credentials are a server-to-server OAuth app of a Zoom account.
+type Credentials struct {
+	AccountId    string
+	ClientId     string
+	ClientSecret string
+}
+
+// File is one file of a cloud recording. FileType is MP4, M4A, TRANSCRIPT,
+// CHAT and so on; RecordingType says what an MP4 shows, such as
+// shared_screen_with_speaker_view. A meeting stopped and started again, or
+// a long one, has a file of each type per part, told apart by when it
+// starts.
+type File struct {
+	Id             string    `json:"id"`
+	FileType       string    `json:"file_type"`
+	FileSize       int64     `json:"file_size"`
+	RecordingType  string    `json:"recording_type"`
+	RecordingStart time.Time `json:"recording_start"`
+	Status         string    `json:"status"`
+	DownloadURL    string    `json:"download_url"`
+}
+
+// Recording is a meeting's cloud recording.
+type Recording struct {
+	UUID           string    `json:"uuid"`
+	Id             int64     `json:"id"`
+	Topic          string    `json:"topic"`
+	StartTime      time.Time `json:"start_time"`
+	RecordingFiles []File    `json:"recording_files"`
+}
+
+// Event is a webhook Zoom sends. Payload is a Recording for
+// recording.completed and a PlainToken for endpoint.url_validation.
+type Event struct {
+	Event   string `json:"event"`
+	Payload struct {
+		AccountId  string    `json:"account_id"`
+		PlainToken string    `json:"plainToken"`
+		Object     Recording `json:"object"`
+	} `json:"payload"`
+	// DownloadToken authorises downloading this event's recording files
+	// without an access token.
+	DownloadToken string `json:"download_token"`
+}
+
+// Client calls the Zoom API of one account.
+type Client struct {
+	credentials Credentials
+	http        *http.Client
+
+	token   string
+	expires time.Time
+}
+
+func NewClient(credentials Credentials) *Client {
+	return &Client{credentials: credentials, http: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// Recordings lists the account's cloud recordings of meetings that started
+// from from on. Zoom looks up at most a month at a time, so a longer span is
+// asked for month by month.
+func (c *Client) Recordings(ctx context.Context, from time.Time) ([]Recording, error) {
+	var recordings []Recording
+	for start := from; start.Before(time.Now()); start = start.AddDate(0, 1, 0) {
+		end := start.AddDate(0, 1, 0)
+		token := ""
+		for {
+			query := url.Values{
+				"from":            {start.Format("2006-01-02")},
+				"to":              {end.Format("2006-01-02")},
+				"page_size":       {"300"},
+				"next_page_token": {token},
+			}
+			var page struct {
+				Meetings      []Recording `json:"meetings"`
+				NextPageToken string      `json:"next_page_token"`
+			}
+			if err := c.get(ctx, "/users/me/recordings?"+query.Encode(), &page); err != nil {
+				return nil, err
+			}
+			recordings = append(recordings, page.Meetings...)
+			if token = page.NextPageToken; token == "" {
+				break
+			}
+		}
+	}
+	return recordings, nil
+}
+
+// Download opens a recording file. A webhook's download token is used when
+// set, the account's access token otherwise. The caller closes the body.
+func (c *Client) Download(ctx context.Context, file File, downloadToken string) (io.ReadCloser, error) {
+	token := downloadToken
+	if token == "" {
+		var err error
+		if token, err = c.accessToken(ctx); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, file.DownloadURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	// Downloads run as long as the file takes, not the API timeout.
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("zoom download returned %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, out interface{}) error {
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("zoom returned %s: %s", resp.Status, raw)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// accessToken returns the account's access token, asking for a new one a
+// minute before the last one expires.
+func (c *Client) accessToken(ctx context.Context) (string, error) {
+	if c.token != "" && time.Now().Before(c.expires) {
+		return c.token, nil
+	}
+	query := url.Values{"grant_type": {"account_credentials"}, "account_id": {c.credentials.AccountId}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, oauthURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(c.credentials.ClientId, c.credentials.ClientSecret)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return "", fmt.Errorf("zoom oauth returned %s: %s", resp.Status, raw)
+	}
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	c.token = body.AccessToken
+	c.expires = time.Now().Add(time.Duration(body.ExpiresIn)*time.Second - time.Minute)
+	return c.token, nil
+}
+
+// VerifyWebhook checks a webhook's x-zm-signature against the app's secret
+// token, and that its x-zm-request-timestamp is recent.
+func VerifyWebhook(secret, timestamp, signature string, body []byte) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid webhook timestamp %q", timestamp)
+	}
+	if age := time.Since(time.Unix(seconds, 0)); age > maxWebhookAge || age < -maxWebhookAge {
+		return fmt.Errorf("webhook timestamp is %s off", age.Round(time.Second))
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(strings.TrimSpace(signature))) {
+		return fmt.Errorf("webhook signature doesn't match")
+	}
+	return nil
+}
+
+// ValidationResponse answers Zoom's endpoint.url_validation challenge.
+func ValidationResponse(secret, plainToken string) map[string]string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(plainToken))
+	return map[string]string{"plainToken": plainToken, "encryptedToken": hex.EncodeToString(mac.Sum(nil))}
+}
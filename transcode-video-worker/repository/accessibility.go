@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+)
+
+type AccessibilityRepository interface {
+	SaveAccessibilityReport(ctx context.Context, report *entities.AccessibilityReport) error
+	FindAccessibilityReport(ctx context.Context, lessonId uuid.UUID) (*entities.AccessibilityReport, error)
+	// ListCourseAccessibilityReports lists the reports of the course's
+	// lessons in course order.
+	ListCourseAccessibilityReports(ctx context.Context, courseId uuid.UUID) ([]*entities.AccessibilityReport, error)
+	ListCaptions(ctx context.Context, lessonIds []uuid.UUID) ([]dto.LessonCaptions, error)
+}
+
+type accessibilityRepo struct {
+	db *gorm.DB
+}
+
+// SaveAccessibilityReport replaces the report the lesson's previous video
+// had.
+func (r *accessibilityRepo) SaveAccessibilityReport(ctx context.Context, report *entities.AccessibilityReport) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(report).Error
+}
+
+func (r *accessibilityRepo) FindAccessibilityReport(ctx context.Context, lessonId uuid.UUID) (*entities.AccessibilityReport, error) {
+	var report entities.AccessibilityReport
+	if err := r.db.WithContext(ctx).Where("lesson_id = ?", lessonId).First(&report).Error; err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+func (r *accessibilityRepo) ListCourseAccessibilityReports(ctx context.Context, courseId uuid.UUID) ([]*entities.AccessibilityReport, error) {
+	var reports []*entities.AccessibilityReport
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT a.* FROM lesson_accessibility_reports a
+		     JOIN lessons l ON l.id = a.lesson_id
+		     LEFT JOIN chapters ch ON ch.id = l.chapter_id
+		     WHERE l.course_id = ?
+		     ORDER BY ch."position" NULLS LAST, l."position" NULLS LAST, l.id`, courseId).
+		Scan(&reports).Error
+	if err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
+func (r *accessibilityRepo) ListCaptions(ctx context.Context, lessonIds []uuid.UUID) ([]dto.LessonCaptions, error) {
+	var captions []dto.LessonCaptions
+	if len(lessonIds) == 0 {
+		return captions, nil
+	}
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT lesson_id, language, covered_seconds FROM lesson_captions
+		     WHERE lesson_id IN ? ORDER BY lesson_id, language`, lessonIds).
+		Scan(&captions).Error
+	if err != nil {
+		return nil, err
+	}
+	return captions, nil
+}
+
+func NewAccessibilityRepo(db *gorm.DB) AccessibilityRepository {
+	return &accessibilityRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+	"worker-transcode/entities"
+)
+
+type AudioReplacementRepository interface {
+	CreateReplacement(ctx context.Context, replacement *entities.AudioReplacement) error
+	FindReplacement(ctx context.Context, id uuid.UUID) (*entities.AudioReplacement, error)
+	FindReplacementByJob(ctx context.Context, jobId uuid.UUID) (*entities.AudioReplacement, error)
+	// MarkReplaced records the version the replacement published.
+	MarkReplaced(ctx context.Context, id uuid.UUID, replacedPlaylistKey, playlistKey string, remuxed int) error
+	// FindLessonVideo returns the master playlist the lesson plays, empty
+	// while it has none.
+	FindLessonVideo(ctx context.Context, lessonId uuid.UUID) (string, error)
+}
+
+type audioReplacementRepo struct {
+	db *gorm.DB
+}
+
+func (r *audioReplacementRepo) CreateReplacement(ctx context.Context, replacement *entities.AudioReplacement) error {
+	return r.db.WithContext(ctx).Create(replacement).Error
+}
+
+func (r *audioReplacementRepo) FindReplacement(ctx context.Context, id uuid.UUID) (*entities.AudioReplacement, error) {
+	replacement := &entities.AudioReplacement{}
+	if err := r.db.WithContext(ctx).Where("id = ?", id).First(replacement).Error; err != nil {
+		return nil, err
+	}
+	return replacement, nil
+}
+
+func (r *audioReplacementRepo) FindReplacementByJob(ctx context.Context, jobId uuid.UUID) (*entities.AudioReplacement, error) {
+	replacement := &entities.AudioReplacement{}
+	if err := r.db.WithContext(ctx).Where("job_id = ?", jobId).First(replacement).Error; err != nil {
+		return nil, err
+	}
+	return replacement, nil
+}
+
+func (r *audioReplacementRepo) MarkReplaced(ctx context.Context, id uuid.UUID, replacedPlaylistKey, playlistKey string, remuxed int) error {
+	return r.db.WithContext(ctx).Model(&entities.AudioReplacement{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"replaced_playlist_key": replacedPlaylistKey,
+			"playlist_key":          playlistKey,
+			"remuxed":               remuxed,
+			"updated_at":            time.Now().UTC(),
+		}).Error
+}
+
+func (r *audioReplacementRepo) FindLessonVideo(ctx context.Context, lessonId uuid.UUID) (string, error) {
+	lesson := &entities.Lesson{}
+	if err := r.db.WithContext(ctx).Select("id", "video_url").Where("id = ?", lessonId).First(lesson).Error; err != nil {
+		return "", err
+	}
+	return lesson.VideoUrl, nil
+}
+
+func NewAudioReplacementRepo(db *gorm.DB) AudioReplacementRepository {
+	return &audioReplacementRepo{
+		db: db,
+	}
+}
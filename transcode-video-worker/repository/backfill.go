@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"context"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type BackfillRepository interface {
+	// FindBackfillCandidates returns published lesson videos matching filters
+	// that have no pending or processing job.
+	FindBackfillCandidates(ctx context.Context, filters entities.BackfillFilters, limit int) ([]dto.BackfillCandidate, error)
+	CreateBackfillBatch(ctx context.Context, batch *entities.BackfillBatch) error
+	UpdateBackfillBatch(ctx context.Context, id uuid.UUID, enqueued int, status constant.BackfillStatus) error
+	FindBackfillBatch(ctx context.Context, id uuid.UUID) (*entities.BackfillBatch, error)
+	CountBackfillJobsByStatus(ctx context.Context, id uuid.UUID) ([]dto.StatusCount, error)
+}
+
+type backfillRepo struct {
+	db *gorm.DB
+}
+
+func (r *backfillRepo) FindBackfillCandidates(ctx context.Context, filters entities.BackfillFilters, limit int) ([]dto.BackfillCandidate, error) {
+	db := r.db.WithContext(ctx).
+		Table("lessons AS l").
+		Select("l.id AS lesson_id, l.video_url, j.tenant_id").
+		Joins(`LEFT JOIN LATERAL (
+			SELECT id, tenant_id, updated_at FROM jobs
+			WHERE entity_id = l.id AND job_type = ? AND status = ?
+			ORDER BY updated_at DESC LIMIT 1
+		) j ON true`, constant.JobTypeTranscoder, constant.JobStatusCompleted).
+		Where("l.video_url IS NOT NULL AND l.video_url <> ''").
+		Where("NOT EXISTS (SELECT 1 FROM jobs a WHERE a.entity_id = l.id AND a.status IN ?)",
+			[]constant.JobStatus{constant.JobStatusPending, constant.JobStatusProcessing})
+
+	if filters.CourseId != nil {
+		db = db.Where("l.course_id = ?", *filters.CourseId)
+	}
+	if filters.TranscodedBefore != nil {
+		db = db.Where("(j.updated_at IS NULL OR j.updated_at < ?)", *filters.TranscodedBefore)
+	}
+	if filters.MissingHeight > 0 {
+		db = db.Where(`NOT EXISTS (
+			SELECT 1 FROM job_events e, jsonb_array_elements(e.data->'renditions') r
+			WHERE e.job_id = j.id AND e.event_type = ? AND (r->>'height')::int = ?
+		)`, constant.JobEventOutput, filters.MissingHeight)
+	}
+	if filters.NotCodec != "" {
+		db = db.Where("NOT EXISTS (SELECT 1 FROM job_events e WHERE e.job_id = j.id AND e.event_type = ? AND e.data->>'video_codec' = ?)",
+			constant.JobEventOutput, filters.NotCodec)
+	}
+	if limit > 0 {
+		db = db.Limit(limit)
+	}
+
+	var candidates []dto.BackfillCandidate
+	if err := db.Order("l.id").Scan(&candidates).Error; err != nil {
+		return nil, err
+	}
+	return candidates, nil
+}
+
+func (r *backfillRepo) CreateBackfillBatch(ctx context.Context, batch *entities.BackfillBatch) error {
+	return r.db.WithContext(ctx).Create(batch).Error
+}
+
+func (r *backfillRepo) UpdateBackfillBatch(ctx context.Context, id uuid.UUID, enqueued int, status constant.BackfillStatus) error {
+	return r.db.WithContext(ctx).
+		Model(&entities.BackfillBatch{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"enqueued":   enqueued,
+			"status":     status,
+			"updated_at": gorm.Expr("CURRENT_TIMESTAMP"),
+		}).Error
+}
+
+func (r *backfillRepo) FindBackfillBatch(ctx context.Context, id uuid.UUID) (*entities.BackfillBatch, error) {
+	batch := &entities.BackfillBatch{}
+	if err := r.db.WithContext(ctx).Where("id = ?", id).First(batch).Error; err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+func (r *backfillRepo) CountBackfillJobsByStatus(ctx context.Context, id uuid.UUID) ([]dto.StatusCount, error) {
+	var counts []dto.StatusCount
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT status, COUNT(*) AS count FROM jobs
+		     WHERE backfill_batch_id = ?
+		     GROUP BY status ORDER BY status`, id).
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+func NewBackfillRepo(db *gorm.DB) BackfillRepository {
+	return &backfillRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"worker-transcode/entities"
+)
+
+type BrandingRepository interface {
+	FindBranding(ctx context.Context, tenantId uuid.UUID) (*entities.BrandingTemplate, error)
+	SaveBranding(ctx context.Context, template *entities.BrandingTemplate) error
+}
+
+type brandingRepo struct {
+	db *gorm.DB
+}
+
+func (r *brandingRepo) FindBranding(ctx context.Context, tenantId uuid.UUID) (*entities.BrandingTemplate, error) {
+	template := &entities.BrandingTemplate{}
+	err := r.db.WithContext(ctx).First(template, "tenant_id = ?", tenantId).Error
+	if err != nil {
+		return nil, err
+	}
+	return template, nil
+}
+
+func (r *brandingRepo) SaveBranding(ctx context.Context, template *entities.BrandingTemplate) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(template).Error
+}
+
+func NewBrandingRepo(db *gorm.DB) BrandingRepository {
+	return &brandingRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net"
+	"strings"
+	"worker-transcode/pkg/breaker"
+
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// recordOutcomes reports every statement run through db to the database
+// circuit breaker. Only errors meaning Postgres is unreachable count against
+// it; a missing row or a constraint violation is a healthy answer.
+func recordOutcomes(db *gorm.DB) {
+	record := func(tx *gorm.DB) {
+		if unavailable(tx.Error) {
+			breaker.Database.Failure(tx.Error)
+			return
+		}
+		breaker.Database.Success()
+	}
+
+	callbacks := db.Callback()
+	_ = callbacks.Create().After("gorm:create").Register("breaker:create", record)
+	_ = callbacks.Query().After("gorm:query").Register("breaker:query", record)
+	_ = callbacks.Update().After("gorm:update").Register("breaker:update", record)
+	_ = callbacks.Delete().After("gorm:delete").Register("breaker:delete", record)
+	_ = callbacks.Row().After("gorm:row").Register("breaker:row", record)
+	_ = callbacks.Raw().After("gorm:raw").Register("breaker:raw", record)
+}
+
+func unavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// Class 08 is connection exceptions, 53 insufficient resources and
+		// 57P0x the server shutting down.
+		code := string(pqErr.Code)
+		return strings.HasPrefix(code, "08") || strings.HasPrefix(code, "53") || strings.HasPrefix(code, "57P0")
+	}
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) || errors.As(err, &netErr)
+}
@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+	"worker-transcode/entities"
+)
+
+type BurnedCaptionRepository interface {
+	CreateBurnedCaption(ctx context.Context, burned *entities.BurnedCaption) error
+	FindBurnedCaption(ctx context.Context, id uuid.UUID) (*entities.BurnedCaption, error)
+	FindBurnedCaptionByJob(ctx context.Context, jobId uuid.UUID) (*entities.BurnedCaption, error)
+	// MarkBurnedCaptionReady records the video made for the burn-in.
+	MarkBurnedCaptionReady(ctx context.Context, id uuid.UUID, objectKey string, sizeBytes int64, height int) error
+	// FindLessonVideo returns the master playlist the lesson plays, empty
+	// while it has none.
+	FindLessonVideo(ctx context.Context, lessonId uuid.UUID) (string, error)
+}
+
+type burnedCaptionRepo struct {
+	db *gorm.DB
+}
+
+func (r *burnedCaptionRepo) CreateBurnedCaption(ctx context.Context, burned *entities.BurnedCaption) error {
+	return r.db.WithContext(ctx).Create(burned).Error
+}
+
+func (r *burnedCaptionRepo) FindBurnedCaption(ctx context.Context, id uuid.UUID) (*entities.BurnedCaption, error) {
+	burned := &entities.BurnedCaption{}
+	if err := r.db.WithContext(ctx).Where("id = ?", id).First(burned).Error; err != nil {
+		return nil, err
+	}
+	return burned, nil
+}
+
+func (r *burnedCaptionRepo) FindBurnedCaptionByJob(ctx context.Context, jobId uuid.UUID) (*entities.BurnedCaption, error) {
+	burned := &entities.BurnedCaption{}
+	if err := r.db.WithContext(ctx).Where("job_id = ?", jobId).First(burned).Error; err != nil {
+		return nil, err
+	}
+	return burned, nil
+}
+
+func (r *burnedCaptionRepo) MarkBurnedCaptionReady(ctx context.Context, id uuid.UUID, objectKey string, sizeBytes int64, height int) error {
+	return r.db.WithContext(ctx).Model(&entities.BurnedCaption{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"object_key": objectKey,
+			"size_bytes": sizeBytes,
+			"height":     height,
+			"updated_at": time.Now().UTC(),
+		}).Error
+}
+
+func (r *burnedCaptionRepo) FindLessonVideo(ctx context.Context, lessonId uuid.UUID) (string, error) {
+	lesson := &entities.Lesson{}
+	if err := r.db.WithContext(ctx).Select("id", "video_url").Where("id = ?", lessonId).First(lesson).Error; err != nil {
+		return "", err
+	}
+	return lesson.VideoUrl, nil
+}
+
+func NewBurnedCaptionRepo(db *gorm.DB) BurnedCaptionRepository {
+	return &burnedCaptionRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,24 @@
+package repository
+
+import (
+	"worker-transcode/pkg/chaos"
+
+	"gorm.io/gorm"
+)
+
+// injectLatency holds up statements run through db as chaos mode sets, before
+// they reach Postgres, so a slow database is seen by the breaker and by the
+// jobs waiting on it alike.
+func injectLatency(db *gorm.DB) {
+	delay := func(tx *gorm.DB) {
+		chaos.Delay(tx.Statement.Context)
+	}
+
+	callbacks := db.Callback()
+	_ = callbacks.Create().Before("gorm:create").Register("chaos:create", delay)
+	_ = callbacks.Query().Before("gorm:query").Register("chaos:query", delay)
+	_ = callbacks.Update().Before("gorm:update").Register("chaos:update", delay)
+	_ = callbacks.Delete().Before("gorm:delete").Register("chaos:delete", delay)
+	_ = callbacks.Row().Before("gorm:row").Register("chaos:row", delay)
+	_ = callbacks.Raw().Before("gorm:raw").Register("chaos:raw", delay)
+}
@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"worker-transcode/entities"
+)
+
+type ChapterRepository interface {
+	ReplaceChapters(ctx context.Context, lessonId uuid.UUID, chapters []*entities.Chapter) error
+	ListChapters(ctx context.Context, lessonId uuid.UUID) ([]*entities.Chapter, error)
+}
+
+type chapterRepo struct {
+	db *gorm.DB
+}
+
+// ReplaceChapters swaps the lesson's chapters for the ones just detected.
+func (r *chapterRepo) ReplaceChapters(ctx context.Context, lessonId uuid.UUID, chapters []*entities.Chapter) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("lesson_id = ?", lessonId).Delete(&entities.Chapter{}).Error; err != nil {
+			return err
+		}
+		if len(chapters) == 0 {
+			return nil
+		}
+		return tx.Create(chapters).Error
+	})
+}
+
+func (r *chapterRepo) ListChapters(ctx context.Context, lessonId uuid.UUID) ([]*entities.Chapter, error) {
+	var chapters []*entities.Chapter
+	err := r.db.WithContext(ctx).Where("lesson_id = ?", lessonId).Order("position ASC").Find(&chapters).Error
+	if err != nil {
+		return nil, err
+	}
+	return chapters, nil
+}
+
+func NewChapterRepo(db *gorm.DB) ChapterRepository {
+	return &chapterRepo{
+		db: db,
+	}
+}
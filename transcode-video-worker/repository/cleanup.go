@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"worker-transcode/constant"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// CleanupRepository answers which lessons still own objects in the bucket.
+type CleanupRepository interface {
+	// FindLessonVideoURLs returns the video_url of every lesson in ids that
+	// still exists; an empty string means no video has been published.
+	FindLessonVideoURLs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]string, error)
+	// FindEntitiesWithActiveJobs returns the ids in ids with a pending or
+	// processing job, whose objects are still in use.
+	FindEntitiesWithActiveJobs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]bool, error)
+}
+
+type cleanupRepo struct {
+	db *gorm.DB
+}
+
+func (r *cleanupRepo) FindLessonVideoURLs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]string, error) {
+	var rows []struct {
+		Id       uuid.UUID
+		VideoUrl *string
+	}
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT id, video_url FROM lessons WHERE id IN ?`, ids).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	urls := make(map[uuid.UUID]string, len(rows))
+	for _, row := range rows {
+		urls[row.Id] = ""
+		if row.VideoUrl != nil {
+			urls[row.Id] = *row.VideoUrl
+		}
+	}
+	return urls, nil
+}
+
+func (r *cleanupRepo) FindEntitiesWithActiveJobs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]bool, error) {
+	var active []uuid.UUID
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT DISTINCT entity_id FROM jobs WHERE entity_id IN ? AND status IN ?`,
+			ids, []constant.JobStatus{constant.JobStatusPending, constant.JobStatusProcessing}).
+		Scan(&active).Error
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[uuid.UUID]bool, len(active))
+	for _, id := range active {
+		found[id] = true
+	}
+	return found, nil
+}
+
+func NewCleanupRepo(db *gorm.DB) CleanupRepository {
+	return &cleanupRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+	"worker-transcode/entities"
+)
+
+type ContentExportRepository interface {
+	CreateExport(ctx context.Context, export *entities.ContentExport) error
+	FindExport(ctx context.Context, id uuid.UUID) (*entities.ContentExport, error)
+	FindExportByJob(ctx context.Context, jobId uuid.UUID) (*entities.ContentExport, error)
+	// MarkExportReady records the package made for the export.
+	MarkExportReady(ctx context.Context, id uuid.UUID, objectKey string, sizeBytes int64) error
+	// FindLessonVideo returns the master playlist the lesson plays, empty
+	// while it has none.
+	FindLessonVideo(ctx context.Context, lessonId uuid.UUID) (string, error)
+}
+
+type contentExportRepo struct {
+	db *gorm.DB
+}
+
+func (r *contentExportRepo) CreateExport(ctx context.Context, export *entities.ContentExport) error {
+	return r.db.WithContext(ctx).Create(export).Error
+}
+
+func (r *contentExportRepo) FindExport(ctx context.Context, id uuid.UUID) (*entities.ContentExport, error) {
+	export := &entities.ContentExport{}
+	if err := r.db.WithContext(ctx).Where("id = ?", id).First(export).Error; err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+func (r *contentExportRepo) FindExportByJob(ctx context.Context, jobId uuid.UUID) (*entities.ContentExport, error) {
+	export := &entities.ContentExport{}
+	if err := r.db.WithContext(ctx).Where("job_id = ?", jobId).First(export).Error; err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+func (r *contentExportRepo) MarkExportReady(ctx context.Context, id uuid.UUID, objectKey string, sizeBytes int64) error {
+	return r.db.WithContext(ctx).Model(&entities.ContentExport{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"object_key": objectKey,
+			"size_bytes": sizeBytes,
+			"updated_at": time.Now().UTC(),
+		}).Error
+}
+
+func (r *contentExportRepo) FindLessonVideo(ctx context.Context, lessonId uuid.UUID) (string, error) {
+	lesson := &entities.Lesson{}
+	if err := r.db.WithContext(ctx).Select("id", "video_url").Where("id = ?", lessonId).First(lesson).Error; err != nil {
+		return "", err
+	}
+	return lesson.VideoUrl, nil
+}
+
+func NewContentExportRepo(db *gorm.DB) ContentExportRepository {
+	return &contentExportRepo{
+		db: db,
+	}
+}
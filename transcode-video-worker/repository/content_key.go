@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+	"worker-transcode/constant"
+	"worker-transcode/entities"
+)
+
+type ContentKeyRepository interface {
+	CreateRotation(ctx context.Context, rotation *entities.KeyRotation) error
+	FindRotation(ctx context.Context, id uuid.UUID) (*entities.KeyRotation, error)
+	FindRotationByJob(ctx context.Context, jobId uuid.UUID) (*entities.KeyRotation, error)
+	MarkRotated(ctx context.Context, id, keyId uuid.UUID, playlistKey string) error
+	MarkRewrapped(ctx context.Context, id uuid.UUID, rewrapped int) error
+	// ListCourseLessons lists the lessons of the courses whose video is
+	// transcoded.
+	ListCourseLessons(ctx context.Context, courseIds []uuid.UUID) ([]uuid.UUID, error)
+	// FindLessonVideo returns the master playlist the lesson plays, empty
+	// while it has none.
+	FindLessonVideo(ctx context.Context, lessonId uuid.UUID) (string, error)
+	// CreateKey registers a job's key, once however often the job is
+	// delivered: the key the job made first is the one it keeps.
+	CreateKey(ctx context.Context, key *entities.ContentKey) error
+	FindKey(ctx context.Context, id uuid.UUID) (*entities.ContentKey, error)
+	FindKeyByJob(ctx context.Context, jobId uuid.UUID) (*entities.ContentKey, error)
+	ActivateKey(ctx context.Context, id uuid.UUID) error
+	// RetireKeys retires the lesson's active keys other than keep, to be
+	// served until expiresAt.
+	RetireKeys(ctx context.Context, lessonId, keep uuid.UUID, expiresAt time.Time) error
+	// ListLiveKeys lists the lesson's keys that are still served or will be.
+	ListLiveKeys(ctx context.Context, lessonId uuid.UUID, now time.Time) ([]*entities.ContentKey, error)
+	UpdateWrappedKey(ctx context.Context, id uuid.UUID, wrapped []byte, masterKeyId string) error
+}
+
+type contentKeyRepo struct {
+	db *gorm.DB
+}
+
+func (r *contentKeyRepo) CreateRotation(ctx context.Context, rotation *entities.KeyRotation) error {
+	return r.db.WithContext(ctx).Create(rotation).Error
+}
+
+func (r *contentKeyRepo) FindRotation(ctx context.Context, id uuid.UUID) (*entities.KeyRotation, error) {
+	rotation := &entities.KeyRotation{}
+	if err := r.db.WithContext(ctx).Where("id = ?", id).First(rotation).Error; err != nil {
+		return nil, err
+	}
+	return rotation, nil
+}
+
+func (r *contentKeyRepo) FindRotationByJob(ctx context.Context, jobId uuid.UUID) (*entities.KeyRotation, error) {
+	rotation := &entities.KeyRotation{}
+	if err := r.db.WithContext(ctx).Where("job_id = ?", jobId).First(rotation).Error; err != nil {
+		return nil, err
+	}
+	return rotation, nil
+}
+
+func (r *contentKeyRepo) MarkRotated(ctx context.Context, id, keyId uuid.UUID, playlistKey string) error {
+	return r.db.WithContext(ctx).Model(&entities.KeyRotation{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"key_id":       keyId,
+			"playlist_key": playlistKey,
+			"updated_at":   time.Now().UTC(),
+		}).Error
+}
+
+func (r *contentKeyRepo) MarkRewrapped(ctx context.Context, id uuid.UUID, rewrapped int) error {
+	return r.db.WithContext(ctx).Model(&entities.KeyRotation{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"rewrapped":  rewrapped,
+			"updated_at": time.Now().UTC(),
+		}).Error
+}
+
+func (r *contentKeyRepo) ListCourseLessons(ctx context.Context, courseIds []uuid.UUID) ([]uuid.UUID, error) {
+	var lessonIds []uuid.UUID
+	err := r.db.WithContext(ctx).Model(&entities.Lesson{}).
+		Where("course_id IN ? AND video_url LIKE '%.m3u8'", courseIds).
+		Order("course_id, id").
+		Pluck("id", &lessonIds).Error
+	if err != nil {
+		return nil, err
+	}
+	return lessonIds, nil
+}
+
+func (r *contentKeyRepo) FindLessonVideo(ctx context.Context, lessonId uuid.UUID) (string, error) {
+	lesson := &entities.Lesson{}
+	if err := r.db.WithContext(ctx).Select("id", "video_url").Where("id = ?", lessonId).First(lesson).Error; err != nil {
+		return "", err
+	}
+	return lesson.VideoUrl, nil
+}
+
+func (r *contentKeyRepo) CreateKey(ctx context.Context, key *entities.ContentKey) error {
+	return r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "job_id"}}, DoNothing: true}).
+		Create(key).Error
+}
+
+func (r *contentKeyRepo) FindKey(ctx context.Context, id uuid.UUID) (*entities.ContentKey, error) {
+	key := &entities.ContentKey{}
+	if err := r.db.WithContext(ctx).Where("id = ?", id).First(key).Error; err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (r *contentKeyRepo) FindKeyByJob(ctx context.Context, jobId uuid.UUID) (*entities.ContentKey, error) {
+	key := &entities.ContentKey{}
+	if err := r.db.WithContext(ctx).Where("job_id = ?", jobId).First(key).Error; err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (r *contentKeyRepo) ActivateKey(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Model(&entities.ContentKey{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":     constant.ContentKeyStatusActive,
+			"expires_at": nil,
+			"updated_at": time.Now().UTC(),
+		}).Error
+}
+
+func (r *contentKeyRepo) RetireKeys(ctx context.Context, lessonId, keep uuid.UUID, expiresAt time.Time) error {
+	return r.db.WithContext(ctx).Model(&entities.ContentKey{}).
+		Where("lesson_id = ? AND status = ? AND id <> ?", lessonId, constant.ContentKeyStatusActive, keep).
+		Updates(map[string]interface{}{
+			"status":     constant.ContentKeyStatusRetired,
+			"expires_at": expiresAt,
+			"updated_at": time.Now().UTC(),
+		}).Error
+}
+
+func (r *contentKeyRepo) ListLiveKeys(ctx context.Context, lessonId uuid.UUID, now time.Time) ([]*entities.ContentKey, error) {
+	var keys []*entities.ContentKey
+	err := r.db.WithContext(ctx).
+		Where("lesson_id = ? AND (expires_at IS NULL OR expires_at > ?)", lessonId, now).
+		Find(&keys).Error
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *contentKeyRepo) UpdateWrappedKey(ctx context.Context, id uuid.UUID, wrapped []byte, masterKeyId string) error {
+	return r.db.WithContext(ctx).Model(&entities.ContentKey{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"wrapped_key":   wrapped,
+			"master_key_id": masterKeyId,
+			"updated_at":    time.Now().UTC(),
+		}).Error
+}
+
+func NewContentKeyRepo(db *gorm.DB) ContentKeyRepository {
+	return &contentKeyRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+)
+
+type CourseRepository interface {
+	FindLessonCourse(ctx context.Context, lessonId uuid.UUID) (uuid.UUID, error)
+	ListLessonStatuses(ctx context.Context, courseId uuid.UUID) ([]dto.LessonStatus, error)
+	SaveCaptions(ctx context.Context, caption *entities.LessonCaption) error
+	// ListCaptionLanguages lists the languages the lesson is captioned in.
+	ListCaptionLanguages(ctx context.Context, lessonId uuid.UUID) ([]string, error)
+	// SaveMedia records the measurements of the lesson's published video in
+	// place of its previous video's.
+	SaveMedia(ctx context.Context, media *entities.LessonMedia) error
+	FindMedia(ctx context.Context, lessonId uuid.UUID) (*entities.LessonMedia, error)
+	// MarkCourseReady records the course as ready and reports whether it
+	// wasn't already.
+	MarkCourseReady(ctx context.Context, courseId uuid.UUID) (bool, error)
+	ClearCourseReady(ctx context.Context, courseId uuid.UUID) error
+	FindCourseReady(ctx context.Context, courseId uuid.UUID) (*time.Time, error)
+}
+
+type courseRepo struct {
+	db *gorm.DB
+}
+
+func (r *courseRepo) FindLessonCourse(ctx context.Context, lessonId uuid.UUID) (uuid.UUID, error) {
+	var courseId uuid.UUID
+	result := r.db.WithContext(ctx).Raw(`SELECT course_id FROM lessons WHERE id = ?`, lessonId).Scan(&courseId)
+	if result.Error != nil {
+		return uuid.Nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return uuid.Nil, gorm.ErrRecordNotFound
+	}
+	return courseId, nil
+}
+
+// ListLessonStatuses lists the course's lessons in course order. A lesson has
+// a video once it was uploaded one, whether or not its job has finished,
+// and is flagged while a quality check's flag of it is open or confirmed, or
+// while the music check its transcode left to a follow-up is still to run.
+func (r *courseRepo) ListLessonStatuses(ctx context.Context, courseId uuid.UUID) ([]dto.LessonStatus, error) {
+	var lessons []dto.LessonStatus
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT l.id AS lesson_id, l.title,
+		            COALESCE(l.video_url, '') <> '' OR j.status IS NOT NULL AS has_video,
+		            j.status AS job_status,
+		            COALESCE(l.video_url LIKE '%.m3u8', false) AS transcoded,
+		            EXISTS (SELECT 1 FROM lesson_captions c WHERE c.lesson_id = l.id) AS captioned,
+		            EXISTS (SELECT 1 FROM lesson_qc_flags f WHERE f.lesson_id = l.id AND f.status IN ?) OR
+		            EXISTS (SELECT 1 FROM artifact_regenerations g JOIN jobs gj ON gj.id = g.job_id
+		                    WHERE g.lesson_id = l.id AND g.follows_job_id IS NOT NULL AND ? = ANY (g.artifacts) AND gj.status IN ?) AS flagged
+		     FROM lessons l
+		     LEFT JOIN chapters ch ON ch.id = l.chapter_id
+		     LEFT JOIN LATERAL (
+		         SELECT status FROM jobs
+		         WHERE entity_id = l.id AND job_type = ?
+		         ORDER BY created_at DESC LIMIT 1
+		     ) j ON true
+		     WHERE l.course_id = ?
+		     ORDER BY ch."position" NULLS LAST, l."position" NULLS LAST, l.id`,
+			[]constant.QCFlagStatus{constant.QCFlagStatusOpen, constant.QCFlagStatusConfirmed},
+			constant.RegenerationMusic, []constant.JobStatus{constant.JobStatusPending, constant.JobStatusProcessing},
+			constant.JobTypeTranscoder, courseId).
+		Scan(&lessons).Error
+	if err != nil {
+		return nil, err
+	}
+	return lessons, nil
+}
+
+// SaveCaptions records the lesson's caption track in its language, replacing
+// the one it had; a track without cues removes it.
+func (r *courseRepo) SaveCaptions(ctx context.Context, caption *entities.LessonCaption) error {
+	if caption.CueCount == 0 {
+		return r.db.WithContext(ctx).
+			Exec(`DELETE FROM lesson_captions WHERE lesson_id = ? AND language = ?`, caption.LessonId, caption.Language).Error
+	}
+	caption.UpdatedAt = time.Now().UTC()
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(caption).Error
+}
+
+func (r *courseRepo) ListCaptionLanguages(ctx context.Context, lessonId uuid.UUID) ([]string, error) {
+	languages := []string{}
+	err := r.db.WithContext(ctx).Model(&entities.LessonCaption{}).
+		Where("lesson_id = ?", lessonId).
+		Order("language").
+		Pluck("language", &languages).Error
+	if err != nil {
+		return nil, err
+	}
+	return languages, nil
+}
+
+func (r *courseRepo) SaveMedia(ctx context.Context, media *entities.LessonMedia) error {
+	media.UpdatedAt = time.Now().UTC()
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(media).Error
+}
+
+func (r *courseRepo) FindMedia(ctx context.Context, lessonId uuid.UUID) (*entities.LessonMedia, error) {
+	media := &entities.LessonMedia{}
+	if err := r.db.WithContext(ctx).Where("lesson_id = ?", lessonId).First(media).Error; err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+func (r *courseRepo) MarkCourseReady(ctx context.Context, courseId uuid.UUID) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Exec(`INSERT INTO course_readiness (course_id) VALUES (?) ON CONFLICT (course_id) DO NOTHING`, courseId)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *courseRepo) ClearCourseReady(ctx context.Context, courseId uuid.UUID) error {
+	return r.db.WithContext(ctx).Exec(`DELETE FROM course_readiness WHERE course_id = ?`, courseId).Error
+}
+
+// FindCourseReady returns when the course was last announced ready, or nil
+// while it isn't.
+func (r *courseRepo) FindCourseReady(ctx context.Context, courseId uuid.UUID) (*time.Time, error) {
+	var readyAt time.Time
+	result := r.db.WithContext(ctx).Raw(`SELECT ready_at FROM course_readiness WHERE course_id = ?`, courseId).Scan(&readyAt)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+	return &readyAt, nil
+}
+
+func NewCourseRepo(db *gorm.DB) CourseRepository {
+	return &courseRepo{
+		db: db,
+	}
+}
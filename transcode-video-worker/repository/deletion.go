@@ -0,0 +1,251 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+	"worker-transcode/constant"
+	"worker-transcode/entities"
+)
+
+// lessonMediaTables are the tables that hold what the worker made of a
+// lesson's video, each keyed by lesson_id. A lesson's jobs, with their
+// malware scans and quality gates, are kept: they hold no media and are the
+// history of what was run.
+var lessonMediaTables = []string{
+	"lesson_chapters", "transcript_cues", "lesson_captions", "lesson_burned_captions", "watermark_renditions",
+	"lesson_downloads", "lesson_narrations", "lesson_content_exports", "lesson_search_exports", "podcast_episodes",
+	"lesson_accessibility_reports", "lesson_qc_flags", "rendition_quality_scores", "lesson_media", "transcode_outputs",
+	"lesson_external_playbacks", "audio_replacements", "artifact_regenerations", "key_rotations", "content_keys",
+	"package_storage", "lesson_fingerprints", "lesson_speakers", "lesson_redactions", "lesson_video_versions",
+}
+
+type DeletionRepository interface {
+	// CreateDeletion saves a scheduled deletion. A lesson's video is hidden
+	// from students at once, the playlist it played kept on the deletion.
+	CreateDeletion(ctx context.Context, deletion *entities.MediaDeletion) error
+	FindDeletion(ctx context.Context, id uuid.UUID) (*entities.MediaDeletion, error)
+	FindDeletionByJob(ctx context.Context, jobId uuid.UUID) (*entities.MediaDeletion, error)
+	// FindOpenDeletion returns the scope's deletion that is scheduled or
+	// queued.
+	FindOpenDeletion(ctx context.Context, scope constant.DeletionScope, scopeId uuid.UUID) (*entities.MediaDeletion, error)
+	// CancelDeletion cancels a scheduled deletion and puts back the video it
+	// hid, unless the lesson has been given another since. It reports false
+	// when the deletion was no longer scheduled.
+	CancelDeletion(ctx context.Context, id uuid.UUID) (bool, error)
+	ListDueDeletions(ctx context.Context, before time.Time) ([]*entities.MediaDeletion, error)
+	// QueueDeletion creates the deletion's job, once however often it's
+	// called. It reports false when the deletion was no longer scheduled.
+	QueueDeletion(ctx context.Context, id uuid.UUID, job *entities.Job) (bool, error)
+	SaveDeletionReport(ctx context.Context, id uuid.UUID, report entities.DeletionReport) error
+	CompleteDeletion(ctx context.Context, id uuid.UUID, report entities.DeletionReport) error
+	// DeleteLessonRecords deletes the rows of lessonMediaTables of the lesson
+	// and clears its video_url, returning how many went from each table.
+	DeleteLessonRecords(ctx context.Context, lessonId uuid.UUID) (map[string]int64, error)
+	ListUserWatermarks(ctx context.Context, userId uuid.UUID) ([]*entities.WatermarkRendition, error)
+	ListUserAvatars(ctx context.Context, userId uuid.UUID) ([]*entities.MediaAsset, error)
+	// DeleteUserRecords deletes the user's watermarked renditions and avatar
+	// media and takes their id off the jobs they requested.
+	DeleteUserRecords(ctx context.Context, userId uuid.UUID) (map[string]int64, error)
+}
+
+type deletionRepo struct {
+	db *gorm.DB
+}
+
+func (r *deletionRepo) CreateDeletion(ctx context.Context, deletion *entities.MediaDeletion) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if deletion.Scope == constant.DeletionScopeLesson {
+			var videoURL *string
+			if err := tx.Raw(`SELECT video_url FROM lessons WHERE id = ?`, deletion.ScopeId).Scan(&videoURL).Error; err != nil {
+				return err
+			}
+			if videoURL != nil && *videoURL != "" {
+				deletion.HiddenPlaylistKey = videoURL
+				if err := tx.Exec(`UPDATE lessons SET video_url = NULL WHERE id = ?`, deletion.ScopeId).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Create(deletion).Error
+	})
+}
+
+func (r *deletionRepo) FindDeletion(ctx context.Context, id uuid.UUID) (*entities.MediaDeletion, error) {
+	deletion := &entities.MediaDeletion{}
+	if err := r.db.WithContext(ctx).Where("id = ?", id).First(deletion).Error; err != nil {
+		return nil, err
+	}
+	return deletion, nil
+}
+
+func (r *deletionRepo) FindDeletionByJob(ctx context.Context, jobId uuid.UUID) (*entities.MediaDeletion, error) {
+	deletion := &entities.MediaDeletion{}
+	if err := r.db.WithContext(ctx).Where("job_id = ?", jobId).First(deletion).Error; err != nil {
+		return nil, err
+	}
+	return deletion, nil
+}
+
+func (r *deletionRepo) FindOpenDeletion(ctx context.Context, scope constant.DeletionScope, scopeId uuid.UUID) (*entities.MediaDeletion, error) {
+	deletion := &entities.MediaDeletion{}
+	err := r.db.WithContext(ctx).
+		Where("scope = ? AND scope_id = ? AND status IN ?", scope, scopeId,
+			[]constant.DeletionStatus{constant.DeletionStatusScheduled, constant.DeletionStatusQueued}).
+		First(deletion).Error
+	if err != nil {
+		return nil, err
+	}
+	return deletion, nil
+}
+
+func (r *deletionRepo) CancelDeletion(ctx context.Context, id uuid.UUID) (bool, error) {
+	cancelled := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		deletion := &entities.MediaDeletion{}
+		result := tx.Model(deletion).
+			Where("id = ? AND status = ?", id, constant.DeletionStatusScheduled).
+			Updates(map[string]interface{}{
+				"status":     constant.DeletionStatusCancelled,
+				"updated_at": time.Now().UTC(),
+			})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		cancelled = true
+		if err := tx.Where("id = ?", id).First(deletion).Error; err != nil {
+			return err
+		}
+		if deletion.Scope != constant.DeletionScopeLesson || deletion.HiddenPlaylistKey == nil {
+			return nil
+		}
+		return tx.Exec(`UPDATE lessons SET video_url = ? WHERE id = ? AND COALESCE(video_url, '') = ''`,
+			*deletion.HiddenPlaylistKey, deletion.ScopeId).Error
+	})
+	return cancelled, err
+}
+
+func (r *deletionRepo) ListDueDeletions(ctx context.Context, before time.Time) ([]*entities.MediaDeletion, error) {
+	var deletions []*entities.MediaDeletion
+	err := r.db.WithContext(ctx).
+		Where("status = ? AND purge_after <= ?", constant.DeletionStatusScheduled, before).
+		Order("purge_after ASC").
+		Find(&deletions).Error
+	if err != nil {
+		return nil, err
+	}
+	return deletions, nil
+}
+
+func (r *deletionRepo) QueueDeletion(ctx context.Context, id uuid.UUID, job *entities.Job) (bool, error) {
+	queued := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&entities.MediaDeletion{}).
+			Where("id = ? AND status = ?", id, constant.DeletionStatusScheduled).
+			Updates(map[string]interface{}{
+				"status":     constant.DeletionStatusQueued,
+				"job_id":     job.ID,
+				"updated_at": time.Now().UTC(),
+			})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		queued = true
+		return tx.Create(job).Error
+	})
+	return queued, err
+}
+
+func (r *deletionRepo) SaveDeletionReport(ctx context.Context, id uuid.UUID, report entities.DeletionReport) error {
+	return r.db.WithContext(ctx).Model(&entities.MediaDeletion{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"report":     report,
+			"updated_at": time.Now().UTC(),
+		}).Error
+}
+
+func (r *deletionRepo) CompleteDeletion(ctx context.Context, id uuid.UUID, report entities.DeletionReport) error {
+	now := time.Now().UTC()
+	return r.db.WithContext(ctx).Model(&entities.MediaDeletion{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":       constant.DeletionStatusCompleted,
+			"report":       report,
+			"completed_at": now,
+			"updated_at":   now,
+		}).Error
+}
+
+func (r *deletionRepo) DeleteLessonRecords(ctx context.Context, lessonId uuid.UUID) (map[string]int64, error) {
+	deleted := map[string]int64{}
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, table := range lessonMediaTables {
+			result := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE lesson_id = ?`, table), lessonId)
+			if result.Error != nil {
+				return fmt.Errorf("delete from %s: %w", table, result.Error)
+			}
+			deleted[table] = result.RowsAffected
+		}
+		return tx.Exec(`UPDATE lessons SET video_url = NULL WHERE id = ?`, lessonId).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+func (r *deletionRepo) ListUserWatermarks(ctx context.Context, userId uuid.UUID) ([]*entities.WatermarkRendition, error) {
+	var renditions []*entities.WatermarkRendition
+	if err := r.db.WithContext(ctx).Where("user_id = ?", userId).Find(&renditions).Error; err != nil {
+		return nil, err
+	}
+	return renditions, nil
+}
+
+func (r *deletionRepo) ListUserAvatars(ctx context.Context, userId uuid.UUID) ([]*entities.MediaAsset, error) {
+	var assets []*entities.MediaAsset
+	err := r.db.WithContext(ctx).
+		Where("entity_type = ? AND entity_id = ?", constant.EntityTypeUserAvatar, userId).
+		Find(&assets).Error
+	if err != nil {
+		return nil, err
+	}
+	return assets, nil
+}
+
+func (r *deletionRepo) DeleteUserRecords(ctx context.Context, userId uuid.UUID) (map[string]int64, error) {
+	deleted := map[string]int64{}
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("user_id = ?", userId).Delete(&entities.WatermarkRendition{})
+		if result.Error != nil {
+			return result.Error
+		}
+		deleted["watermark_renditions"] = result.RowsAffected
+
+		result = tx.Where("entity_type = ? AND entity_id = ?", constant.EntityTypeUserAvatar, userId).Delete(&entities.MediaAsset{})
+		if result.Error != nil {
+			return result.Error
+		}
+		deleted["media_assets"] = result.RowsAffected
+
+		result = tx.Exec(`UPDATE jobs SET user_id = NULL WHERE user_id = ?`, userId)
+		if result.Error != nil {
+			return result.Error
+		}
+		deleted["jobs.user_id"] = result.RowsAffected
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+func NewDeletionRepo(db *gorm.DB) DeletionRepository {
+	return &deletionRepo{
+		db: db,
+	}
+}
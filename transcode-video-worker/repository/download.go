@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"worker-transcode/entities"
+)
+
+type DownloadRepository interface {
+	SaveDownload(ctx context.Context, download *entities.Download) error
+	FindDownload(ctx context.Context, lessonId uuid.UUID) (*entities.Download, error)
+}
+
+type downloadRepo struct {
+	db *gorm.DB
+}
+
+// SaveDownload registers the lesson's offline rendition, replacing the one an
+// earlier upload made.
+func (r *downloadRepo) SaveDownload(ctx context.Context, download *entities.Download) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(download).Error
+}
+
+func (r *downloadRepo) FindDownload(ctx context.Context, lessonId uuid.UUID) (*entities.Download, error) {
+	var download entities.Download
+	if err := r.db.WithContext(ctx).Where("lesson_id = ?", lessonId).First(&download).Error; err != nil {
+		return nil, err
+	}
+	return &download, nil
+}
+
+func NewDownloadRepo(db *gorm.DB) DownloadRepository {
+	return &downloadRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+	"worker-transcode/entities"
+)
+
+type DriveRepository interface {
+	FindDriveConnection(ctx context.Context, tenantId uuid.UUID, provider string) (*entities.DriveConnection, error)
+	SaveDriveConnection(ctx context.Context, connection *entities.DriveConnection) error
+	UpdateDriveRefreshToken(ctx context.Context, tenantId uuid.UUID, provider, refreshToken string) error
+}
+
+type driveRepo struct {
+	db *gorm.DB
+}
+
+func (r *driveRepo) FindDriveConnection(ctx context.Context, tenantId uuid.UUID, provider string) (*entities.DriveConnection, error) {
+	connection := &entities.DriveConnection{}
+	err := r.db.WithContext(ctx).First(connection, "tenant_id = ? AND provider = ?", tenantId, provider).Error
+	if err != nil {
+		return nil, err
+	}
+	return connection, nil
+}
+
+func (r *driveRepo) SaveDriveConnection(ctx context.Context, connection *entities.DriveConnection) error {
+	connection.UpdatedAt = time.Now()
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "tenant_id"}, {Name: "provider"}},
+		DoUpdates: clause.AssignmentColumns([]string{"client_id", "client_secret", "refresh_token", "directory", "updated_at"}),
+	}).Create(connection).Error
+}
+
+func (r *driveRepo) UpdateDriveRefreshToken(ctx context.Context, tenantId uuid.UUID, provider, refreshToken string) error {
+	return r.db.WithContext(ctx).Model(&entities.DriveConnection{}).
+		Where("tenant_id = ? AND provider = ?", tenantId, provider).
+		Updates(map[string]interface{}{"refresh_token": refreshToken, "updated_at": time.Now()}).Error
+}
+
+func NewDriveRepo(db *gorm.DB) DriveRepository {
+	return &driveRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"worker-transcode/entities"
+)
+
+type FingerprintRepository interface {
+	// SaveFingerprint records the fingerprint of the lesson's latest upload,
+	// replacing the one before.
+	SaveFingerprint(ctx context.Context, fingerprint *entities.LessonFingerprint) error
+	// ListCandidates returns up to limit fingerprints of lessons other than
+	// lessonId that share a frame hash or audio value with the given ones.
+	ListCandidates(ctx context.Context, lessonId uuid.UUID, frames, audio pq.Int64Array, limit int) ([]*entities.LessonFingerprint, error)
+}
+
+type fingerprintRepo struct {
+	db *gorm.DB
+}
+
+func (r *fingerprintRepo) SaveFingerprint(ctx context.Context, fingerprint *entities.LessonFingerprint) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "lesson_id"}},
+		UpdateAll: true,
+	}).Create(fingerprint).Error
+}
+
+func (r *fingerprintRepo) ListCandidates(ctx context.Context, lessonId uuid.UUID, frames, audio pq.Int64Array, limit int) ([]*entities.LessonFingerprint, error) {
+	var fingerprints []*entities.LessonFingerprint
+	err := r.db.WithContext(ctx).
+		Where("lesson_id <> ? AND (frames && ?::bigint[] OR audio && ?::integer[])", lessonId, frames, audio).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&fingerprints).Error
+	if err != nil {
+		return nil, err
+	}
+	return fingerprints, nil
+}
+
+func NewFingerprintRepo(db *gorm.DB) FingerprintRepository {
+	return &fingerprintRepo{
+		db: db,
+	}
+}
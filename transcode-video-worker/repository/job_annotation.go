@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"worker-transcode/entities"
+)
+
+type JobAnnotationRepository interface {
+	CreateAnnotation(ctx context.Context, annotation *entities.JobAnnotation) error
+	// ListAnnotations returns the job's annotations, oldest first.
+	ListAnnotations(ctx context.Context, jobId uuid.UUID) ([]*entities.JobAnnotation, error)
+	// DeleteAnnotation removes one of the job's annotations, reporting false
+	// when the job has none with that id.
+	DeleteAnnotation(ctx context.Context, jobId, id uuid.UUID) (bool, error)
+}
+
+type jobAnnotationRepo struct {
+	db *gorm.DB
+}
+
+func (r *jobAnnotationRepo) CreateAnnotation(ctx context.Context, annotation *entities.JobAnnotation) error {
+	return r.db.WithContext(ctx).Create(annotation).Error
+}
+
+func (r *jobAnnotationRepo) ListAnnotations(ctx context.Context, jobId uuid.UUID) ([]*entities.JobAnnotation, error) {
+	var annotations []*entities.JobAnnotation
+	err := r.db.WithContext(ctx).
+		Where("job_id = ?", jobId).
+		Order("created_at ASC").
+		Find(&annotations).Error
+	if err != nil {
+		return nil, err
+	}
+	return annotations, nil
+}
+
+func (r *jobAnnotationRepo) DeleteAnnotation(ctx context.Context, jobId, id uuid.UUID) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Where("id = ? AND job_id = ?", id, jobId).
+		Delete(&entities.JobAnnotation{})
+	return result.RowsAffected > 0, result.Error
+}
+
+func NewJobAnnotationRepo(db *gorm.DB) JobAnnotationRepository {
+	return &jobAnnotationRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+)
+
+// ErrCacheMiss is returned for a job the status cache holds nothing of.
+var ErrCacheMiss = errors.New("job status not cached")
+
+// JobStatusCache mirrors jobs' status and progress in Redis, so polling them
+// doesn't reach Postgres. The job repository it wraps drops a job's entry
+// whenever the job's status changes, for the next read to fill it again.
+type JobStatusCache interface {
+	JobRepository
+	FindJobStatus(ctx context.Context, id uuid.UUID) (*dto.JobStatus, error)
+	SaveJobStatus(ctx context.Context, status *dto.JobStatus) error
+	// SaveJobProgress caches progress the job row may not have yet.
+	SaveJobProgress(ctx context.Context, id uuid.UUID, progress int) error
+}
+
+type jobStatusCache struct {
+	JobRepository
+	client *redis.Client
+	ttl    time.Duration
+}
+
+func (c *jobStatusCache) FindJobStatus(ctx context.Context, id uuid.UUID) (*dto.JobStatus, error) {
+	values, err := c.client.MGet(ctx, statusKey(id), progressKey(id), heartbeatKey(id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	raw, ok := values[0].(string)
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+	status := &dto.JobStatus{}
+	if err := json.Unmarshal([]byte(raw), status); err != nil {
+		return nil, err
+	}
+	if progress, ok := values[1].(string); ok {
+		if percent, err := strconv.Atoi(progress); err == nil && percent > status.Progress {
+			status.Progress = percent
+		}
+	}
+	if raw, ok := values[2].(string); ok {
+		var heartbeat jobHeartbeat
+		if err := json.Unmarshal([]byte(raw), &heartbeat); err == nil && (status.HeartbeatAt == nil || heartbeat.At.After(*status.HeartbeatAt)) {
+			status.Stage, status.HeartbeatAt = heartbeat.Stage, &heartbeat.At
+		}
+	}
+	return status, nil
+}
+
+// jobHeartbeat is a job's last heartbeat as it's cached.
+type jobHeartbeat struct {
+	Stage string    `json:"stage"`
+	At    time.Time `json:"at"`
+}
+
+func (c *jobStatusCache) SaveJobStatus(ctx context.Context, status *dto.JobStatus) error {
+	raw, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	return c.client.Set(ctx, statusKey(status.JobId), raw, c.ttl).Err()
+}
+
+func (c *jobStatusCache) SaveJobProgress(ctx context.Context, id uuid.UUID, progress int) error {
+	return c.client.Set(ctx, progressKey(id), progress, c.ttl).Err()
+}
+
+func (c *jobStatusCache) UpdateStatusJob(ctx context.Context, status constant.JobStatus, id uuid.UUID) error {
+	if err := c.JobRepository.UpdateStatusJob(ctx, status, id); err != nil {
+		return err
+	}
+	c.drop(ctx, id)
+	return nil
+}
+
+func (c *jobStatusCache) CompleteJob(ctx context.Context, id uuid.UUID, outbox ...*entities.OutboxMessage) error {
+	if err := c.JobRepository.CompleteJob(ctx, id, outbox...); err != nil {
+		return err
+	}
+	c.drop(ctx, id)
+	return nil
+}
+
+func (c *jobStatusCache) ClaimJob(ctx context.Context, id uuid.UUID, correlationId string, workerId *uuid.UUID) (bool, error) {
+	claimed, err := c.JobRepository.ClaimJob(ctx, id, correlationId, workerId)
+	if claimed {
+		c.drop(ctx, id)
+	}
+	return claimed, err
+}
+
+func (c *jobStatusCache) FailJob(ctx context.Context, id uuid.UUID, errorClass constant.ErrorClass, errorKind constant.ErrorKind, message string) error {
+	if err := c.JobRepository.FailJob(ctx, id, errorClass, errorKind, message); err != nil {
+		return err
+	}
+	c.drop(ctx, id)
+	return nil
+}
+
+func (c *jobStatusCache) UpdateJobProgress(ctx context.Context, id uuid.UUID, progress int) error {
+	if err := c.JobRepository.UpdateJobProgress(ctx, id, progress); err != nil {
+		return err
+	}
+	return c.SaveJobProgress(ctx, id, progress)
+}
+
+func (c *jobStatusCache) RecordJobHeartbeat(ctx context.Context, id uuid.UUID, stage string) error {
+	if err := c.JobRepository.RecordJobHeartbeat(ctx, id, stage); err != nil {
+		return err
+	}
+	raw, err := json.Marshal(jobHeartbeat{Stage: stage, At: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	return c.client.Set(ctx, heartbeatKey(id), raw, c.ttl).Err()
+}
+
+// drop removes the job's entry. The job row is written already, so a
+// failure only leaves the entry stale until it expires.
+func (c *jobStatusCache) drop(ctx context.Context, id uuid.UUID) {
+	c.client.Del(ctx, statusKey(id), progressKey(id), heartbeatKey(id))
+}
+
+func statusKey(id uuid.UUID) string {
+	return fmt.Sprintf("job:%s:status", id)
+}
+
+func progressKey(id uuid.UUID) string {
+	return fmt.Sprintf("job:%s:progress", id)
+}
+
+// NewJobStatusCache wraps repo with the status cache kept in client. Entries
+// expire ttl after they were last written.
+func NewJobStatusCache(repo JobRepository, client *redis.Client, ttl time.Duration) JobStatusCache {
+	return &jobStatusCache{
+		JobRepository: repo,
+		client:        client,
+		ttl:           ttl,
+	}
+}
+
+func heartbeatKey(id uuid.UUID) string {
+	return fmt.Sprintf("job:%s:heartbeat", id)
+}
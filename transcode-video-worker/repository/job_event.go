@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"worker-transcode/entities"
+)
+
+type JobEventRepository interface {
+	AddJobEvent(ctx context.Context, event *entities.JobEvent) error
+	ListJobEvents(ctx context.Context, jobId uuid.UUID) ([]*entities.JobEvent, error)
+}
+
+type jobEventRepo struct {
+	db *gorm.DB
+}
+
+func (r *jobEventRepo) AddJobEvent(ctx context.Context, event *entities.JobEvent) error {
+	return r.db.WithContext(ctx).Create(event).Error
+}
+
+func (r *jobEventRepo) ListJobEvents(ctx context.Context, jobId uuid.UUID) ([]*entities.JobEvent, error) {
+	var events []*entities.JobEvent
+	err := r.db.WithContext(ctx).Where("job_id = ?", jobId).Order("created_at ASC").Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func NewJobEventRepo(db *gorm.DB) JobEventRepository {
+	return &jobEventRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"strings"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+)
+
+// likeEscaper escapes the wildcards of text matched literally by LIKE.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func (r *repo) FailJob(ctx context.Context, id uuid.UUID, errorClass constant.ErrorClass, errorKind constant.ErrorKind, message string) error {
+	updates := map[string]interface{}{
+		"status":        constant.JobStatusFailed,
+		"error_class":   errorClass,
+		"error_kind":    errorKind,
+		"error_message": message,
+	}
+	return r.GetDB().Model(&entities.Job{}).Where("id = ?", id).Updates(updates).Error
+}
+
+func (r *repo) SearchJobs(ctx context.Context, query dto.JobSearchQuery) ([]*entities.Job, error) {
+	db := r.GetDB().WithContext(ctx).Model(&entities.Job{})
+
+	if len(query.Statuses) > 0 {
+		db = db.Where("status IN ?", query.Statuses)
+	}
+	if query.CourseId != nil {
+		db = db.Where("entity_id IN (?)", r.GetDB().Model(&entities.Lesson{}).Select("id").Where("course_id = ?", *query.CourseId))
+	}
+	if query.TenantId != nil {
+		db = db.Where("tenant_id = ?", *query.TenantId)
+	}
+	if query.ErrorClass != nil {
+		db = db.Where("error_class = ?", *query.ErrorClass)
+	}
+	if query.ErrorKind != nil {
+		db = db.Where("error_kind = ?", *query.ErrorKind)
+	}
+	// A job carries a tag when any of its annotations does.
+	for _, tag := range query.Tags {
+		db = db.Where("EXISTS (SELECT 1 FROM job_annotations a WHERE a.job_id = jobs.id AND a.tags @> ARRAY[?]::text[])", tag)
+	}
+	if query.Annotation != nil {
+		db = db.Where(`EXISTS (SELECT 1 FROM job_annotations a WHERE a.job_id = jobs.id AND a.note ILIKE '%' || ? || '%' ESCAPE '\')`,
+			likeEscaper.Replace(*query.Annotation))
+	}
+	if query.From != nil {
+		db = db.Where(fmt.Sprintf("%s >= ?", query.Sort), *query.From)
+	}
+	if query.To != nil {
+		db = db.Where(fmt.Sprintf("%s < ?", query.Sort), *query.To)
+	}
+
+	// Keyset pagination on (sort column, id) so pages stay stable while new jobs arrive.
+	op := "<"
+	if query.Order == constant.SortOrderAsc {
+		op = ">"
+	}
+	if query.After != nil {
+		db = db.Where(fmt.Sprintf("(%s, id) %s (?, ?)", query.Sort, op), query.After.Value, query.After.Id)
+	}
+
+	var jobs []*entities.Job
+	err := db.Order(fmt.Sprintf("%s %s, id %s", query.Sort, query.Order, query.Order)).
+		Limit(query.Limit).
+		Find(&jobs).Error
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
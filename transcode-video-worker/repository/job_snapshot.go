@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+	"worker-transcode/entities"
+)
+
+type JobSnapshotRepository interface {
+	// SaveSnapshot replaces the job's snapshot.
+	SaveSnapshot(ctx context.Context, snapshot *entities.JobSnapshot) error
+	FindSnapshot(ctx context.Context, jobId uuid.UUID) (*entities.JobSnapshot, error)
+}
+
+type jobSnapshotRepo struct {
+	db *gorm.DB
+}
+
+func (r *jobSnapshotRepo) SaveSnapshot(ctx context.Context, snapshot *entities.JobSnapshot) error {
+	snapshot.CreatedAt = time.Now()
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "job_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"message", "preset", "preset_version", "objects", "ffmpeg", "ffmpeg_args", "threads", "worker", "created_at",
+		}),
+	}).Create(snapshot).Error
+}
+
+func (r *jobSnapshotRepo) FindSnapshot(ctx context.Context, jobId uuid.UUID) (*entities.JobSnapshot, error) {
+	snapshot := &entities.JobSnapshot{}
+	if err := r.db.WithContext(ctx).First(snapshot, "job_id = ?", jobId).Error; err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func NewJobSnapshotRepo(db *gorm.DB) JobSnapshotRepository {
+	return &jobSnapshotRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+	"worker-transcode/constant"
+	"worker-transcode/entities"
+)
+
+// staleLibraryImport is how long a pending import has before it is taken for
+// one a stopped worker left, and can be claimed again.
+const staleLibraryImport = 24 * time.Hour
+
+type LibraryImportRepository interface {
+	// ClaimLibraryImport records the video as being imported into the
+	// lesson, reporting false when it already is or was, unless that import
+	// failed or went stale.
+	ClaimLibraryImport(ctx context.Context, libraryImport *entities.LibraryImport) (bool, error)
+	// FinishLibraryImport records the import as queued as jobId, or failed
+	// with the reason when jobId is nil.
+	FinishLibraryImport(ctx context.Context, libraryImport *entities.LibraryImport, jobId *uuid.UUID, reason string) error
+	// ListLibraryImports lists the tenant's imports, the latest first.
+	ListLibraryImports(ctx context.Context, tenantId uuid.UUID) ([]*entities.LibraryImport, error)
+}
+
+type libraryImportRepo struct {
+	db *gorm.DB
+}
+
+func (r *libraryImportRepo) ClaimLibraryImport(ctx context.Context, libraryImport *entities.LibraryImport) (bool, error) {
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "provider"}, {Name: "video_id"}, {Name: "lesson_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"status":     constant.LibraryImportStatusPending,
+			"title":      libraryImport.Title,
+			"job_id":     nil,
+			"error":      nil,
+			"updated_at": gorm.Expr("CURRENT_TIMESTAMP"),
+		}),
+		Where: clause.Where{Exprs: []clause.Expression{clause.Expr{
+			SQL:  "library_imports.status = ? OR (library_imports.status = ? AND library_imports.updated_at < ?)",
+			Vars: []interface{}{constant.LibraryImportStatusFailed, constant.LibraryImportStatusPending, time.Now().Add(-staleLibraryImport)},
+		}}},
+	}).Create(libraryImport)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *libraryImportRepo) FinishLibraryImport(ctx context.Context, libraryImport *entities.LibraryImport, jobId *uuid.UUID, reason string) error {
+	updates := map[string]interface{}{
+		"status":     constant.LibraryImportStatusQueued,
+		"job_id":     jobId,
+		"updated_at": gorm.Expr("CURRENT_TIMESTAMP"),
+	}
+	if jobId == nil {
+		updates["status"] = constant.LibraryImportStatusFailed
+		updates["error"] = reason
+	}
+	return r.db.WithContext(ctx).Model(&entities.LibraryImport{}).
+		Where("provider = ? AND video_id = ? AND lesson_id = ?", libraryImport.Provider, libraryImport.VideoId, libraryImport.LessonId).
+		Updates(updates).Error
+}
+
+func (r *libraryImportRepo) ListLibraryImports(ctx context.Context, tenantId uuid.UUID) ([]*entities.LibraryImport, error) {
+	var imports []*entities.LibraryImport
+	if err := r.db.WithContext(ctx).Where("tenant_id = ?", tenantId).Order("created_at DESC").Find(&imports).Error; err != nil {
+		return nil, err
+	}
+	return imports, nil
+}
+
+func NewLibraryImportRepo(db *gorm.DB) LibraryImportRepository {
+	return &libraryImportRepo{
+		db: db,
+	}
+}
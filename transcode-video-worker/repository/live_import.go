@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+	"worker-transcode/entities"
+)
+
+type LiveImportRepository interface {
+	CreateLiveImport(ctx context.Context, liveImport *entities.LiveImport) error
+	FindLiveImport(ctx context.Context, id uuid.UUID) (*entities.LiveImport, error)
+	FindLiveImportByJob(ctx context.Context, jobId uuid.UUID) (*entities.LiveImport, error)
+	// MarkLiveImported records the normalized recording and the job
+	// transcoding it.
+	MarkLiveImported(ctx context.Context, id uuid.UUID, objectPath string, duration float64, transcodeJobId uuid.UUID) error
+}
+
+type liveImportRepo struct {
+	db *gorm.DB
+}
+
+func (r *liveImportRepo) CreateLiveImport(ctx context.Context, liveImport *entities.LiveImport) error {
+	return r.db.WithContext(ctx).Create(liveImport).Error
+}
+
+func (r *liveImportRepo) FindLiveImport(ctx context.Context, id uuid.UUID) (*entities.LiveImport, error) {
+	liveImport := &entities.LiveImport{}
+	if err := r.db.WithContext(ctx).Where("id = ?", id).First(liveImport).Error; err != nil {
+		return nil, err
+	}
+	return liveImport, nil
+}
+
+func (r *liveImportRepo) FindLiveImportByJob(ctx context.Context, jobId uuid.UUID) (*entities.LiveImport, error) {
+	liveImport := &entities.LiveImport{}
+	if err := r.db.WithContext(ctx).Where("job_id = ?", jobId).First(liveImport).Error; err != nil {
+		return nil, err
+	}
+	return liveImport, nil
+}
+
+func (r *liveImportRepo) MarkLiveImported(ctx context.Context, id uuid.UUID, objectPath string, duration float64, transcodeJobId uuid.UUID) error {
+	return r.db.WithContext(ctx).Model(&entities.LiveImport{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"object_path":      objectPath,
+			"duration_seconds": duration,
+			"transcode_job_id": transcodeJobId,
+			"updated_at":       time.Now().UTC(),
+		}).Error
+}
+
+func NewLiveImportRepo(db *gorm.DB) LiveImportRepository {
+	return &liveImportRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+	"worker-transcode/entities"
+)
+
+type LMSWebhookRepository interface {
+	FindLMSWebhook(ctx context.Context, tenantId uuid.UUID) (*entities.LMSWebhook, error)
+	SaveLMSWebhook(ctx context.Context, webhook *entities.LMSWebhook) error
+}
+
+type lmsWebhookRepo struct {
+	db *gorm.DB
+}
+
+func (r *lmsWebhookRepo) FindLMSWebhook(ctx context.Context, tenantId uuid.UUID) (*entities.LMSWebhook, error) {
+	webhook := &entities.LMSWebhook{}
+	err := r.db.WithContext(ctx).First(webhook, "tenant_id = ?", tenantId).Error
+	if err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (r *lmsWebhookRepo) SaveLMSWebhook(ctx context.Context, webhook *entities.LMSWebhook) error {
+	webhook.UpdatedAt = time.Now()
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "tenant_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"secret", "allowed_hosts", "rules", "updated_at"}),
+	}).Create(webhook).Error
+}
+
+func NewLMSWebhookRepo(db *gorm.DB) LMSWebhookRepository {
+	return &lmsWebhookRepo{
+		db: db,
+	}
+}
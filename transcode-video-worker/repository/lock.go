@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// LockRepository serializes work on one entity across every worker with
+// Postgres advisory locks.
+type LockRepository interface {
+	// LockEntity blocks until it holds the lock for id or ctx is done. The
+	// returned func releases it. Each held lock pins a pool connection.
+	LockEntity(ctx context.Context, id uuid.UUID) (func(), error)
+	// TryLock takes the lock named key if it is free, returning nil when
+	// another session holds it.
+	TryLock(ctx context.Context, key string) (*SessionLock, error)
+}
+
+// SessionLock is an advisory lock held by one pinned connection. It lasts
+// until Release or until the connection is lost.
+type SessionLock struct {
+	conn *sql.Conn
+	key  string
+}
+
+// Check confirms the connection, and with it the lock, is still alive.
+func (l *SessionLock) Check(ctx context.Context) error {
+	return l.conn.PingContext(ctx)
+}
+
+func (l *SessionLock) Release(ctx context.Context) {
+	releaseSession(ctx, l.conn, l.key)
+}
+
+type lockRepo struct {
+	db *gorm.DB
+}
+
+// LockEntity takes a session lock, so it must stay on one connection: the
+// pool would otherwise hand the unlock to a session that doesn't hold it.
+func (r *lockRepo) LockEntity(ctx context.Context, id uuid.UUID) (func(), error) {
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(hashtextextended($1, 0))", id.String()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return func() {
+		releaseSession(ctx, conn, id.String())
+	}, nil
+}
+
+func (r *lockRepo) TryLock(ctx context.Context, key string) (*SessionLock, error) {
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtextextended($1, 0))", key).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !acquired {
+		conn.Close()
+		return nil, nil
+	}
+	return &SessionLock{conn: conn, key: key}, nil
+}
+
+// releaseSession unlocks and returns conn to the pool. ctx may already be
+// cancelled, so the unlock gets its own deadline.
+func releaseSession(ctx context.Context, conn *sql.Conn, key string) {
+	unlockCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if _, err := conn.ExecContext(unlockCtx, "SELECT pg_advisory_unlock(hashtextextended($1, 0))", key); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("key", key).Msg("failed to release advisory lock")
+		// Discard the session instead of pooling it; Postgres drops the
+		// lock along with it.
+		_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+	}
+	conn.Close()
+}
+
+func NewLockRepo(db *gorm.DB) LockRepository {
+	return &lockRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"worker-transcode/entities"
+)
+
+type ScanRepository interface {
+	// SaveScan records the job's scan, replacing an earlier attempt's.
+	SaveScan(ctx context.Context, scan *entities.MalwareScan) error
+	FindScan(ctx context.Context, jobId uuid.UUID) (*entities.MalwareScan, error)
+}
+
+type scanRepo struct {
+	db *gorm.DB
+}
+
+func (r *scanRepo) SaveScan(ctx context.Context, scan *entities.MalwareScan) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "job_id"}},
+		UpdateAll: true,
+	}).Create(scan).Error
+}
+
+func (r *scanRepo) FindScan(ctx context.Context, jobId uuid.UUID) (*entities.MalwareScan, error) {
+	var scan entities.MalwareScan
+	if err := r.db.WithContext(ctx).Where("job_id = ?", jobId).First(&scan).Error; err != nil {
+		return nil, err
+	}
+	return &scan, nil
+}
+
+func NewScanRepo(db *gorm.DB) ScanRepository {
+	return &scanRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+	"time"
+	"worker-transcode/entities"
+)
+
+type MediaAssetRepository interface {
+	CreateMediaAsset(ctx context.Context, asset *entities.MediaAsset) error
+	FindMediaAsset(ctx context.Context, id uuid.UUID) (*entities.MediaAsset, error)
+	FindMediaAssetByJob(ctx context.Context, jobId uuid.UUID) (*entities.MediaAsset, error)
+	// MarkMediaAssetDone records the keys the asset's job wrote.
+	MarkMediaAssetDone(ctx context.Context, id uuid.UUID, outputs []string) error
+}
+
+type mediaAssetRepo struct {
+	db *gorm.DB
+}
+
+func (r *mediaAssetRepo) CreateMediaAsset(ctx context.Context, asset *entities.MediaAsset) error {
+	return r.db.WithContext(ctx).Create(asset).Error
+}
+
+func (r *mediaAssetRepo) FindMediaAsset(ctx context.Context, id uuid.UUID) (*entities.MediaAsset, error) {
+	asset := &entities.MediaAsset{}
+	if err := r.db.WithContext(ctx).Where("id = ?", id).First(asset).Error; err != nil {
+		return nil, err
+	}
+	return asset, nil
+}
+
+func (r *mediaAssetRepo) FindMediaAssetByJob(ctx context.Context, jobId uuid.UUID) (*entities.MediaAsset, error) {
+	asset := &entities.MediaAsset{}
+	if err := r.db.WithContext(ctx).Where("job_id = ?", jobId).First(asset).Error; err != nil {
+		return nil, err
+	}
+	return asset, nil
+}
+
+func (r *mediaAssetRepo) MarkMediaAssetDone(ctx context.Context, id uuid.UUID, outputs []string) error {
+	return r.db.WithContext(ctx).Model(&entities.MediaAsset{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"outputs":    pq.StringArray(outputs),
+			"updated_at": time.Now().UTC(),
+		}).Error
+}
+
+func NewMediaAssetRepo(db *gorm.DB) MediaAssetRepository {
+	return &mediaAssetRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,183 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+)
+
+type MigrationRepository interface {
+	CreateMigration(ctx context.Context, migration *entities.CodecMigration) error
+	FindMigration(ctx context.Context, id uuid.UUID) (*entities.CodecMigration, error)
+	// FindOpenMigration returns the preset's running or paused migration.
+	FindOpenMigration(ctx context.Context, preset string) (*entities.CodecMigration, error)
+	ListMigrations(ctx context.Context, status constant.MigrationStatus) ([]*entities.CodecMigration, error)
+	// UpdateMigrationStatus moves a migration in one of from to status,
+	// reporting false when it wasn't in any of them.
+	UpdateMigrationStatus(ctx context.Context, id uuid.UUID, from []constant.MigrationStatus, status constant.MigrationStatus) (bool, error)
+	// FindMigrationCandidates returns the transcoded lessons whose last
+	// transcode wasn't with the migration's preset version or a later one,
+	// that it hasn't queued and that have no job pending or processing, the
+	// most played first.
+	FindMigrationCandidates(ctx context.Context, migration *entities.CodecMigration, limit int) ([]dto.MigrationCandidate, error)
+	// AddMigrationLesson records a lesson the migration queued.
+	AddMigrationLesson(ctx context.Context, lesson *entities.CodecMigrationLesson) error
+	// CountMigrationJobs counts the jobs of every migration, or of one when
+	// id isn't nil, in the statuses.
+	CountMigrationJobs(ctx context.Context, id *uuid.UUID, statuses []constant.JobStatus) (int64, error)
+	ListCourseProgress(ctx context.Context, migration *entities.CodecMigration) ([]dto.CourseMigrationProgress, error)
+}
+
+type migrationRepo struct {
+	db *gorm.DB
+}
+
+func (r *migrationRepo) CreateMigration(ctx context.Context, migration *entities.CodecMigration) error {
+	return r.db.WithContext(ctx).Create(migration).Error
+}
+
+func (r *migrationRepo) FindMigration(ctx context.Context, id uuid.UUID) (*entities.CodecMigration, error) {
+	migration := &entities.CodecMigration{}
+	if err := r.db.WithContext(ctx).Where("id = ?", id).First(migration).Error; err != nil {
+		return nil, err
+	}
+	return migration, nil
+}
+
+func (r *migrationRepo) FindOpenMigration(ctx context.Context, preset string) (*entities.CodecMigration, error) {
+	migration := &entities.CodecMigration{}
+	err := r.db.WithContext(ctx).
+		Where("preset = ? AND status IN ?", preset, []constant.MigrationStatus{constant.MigrationStatusRunning, constant.MigrationStatusPaused}).
+		First(migration).Error
+	if err != nil {
+		return nil, err
+	}
+	return migration, nil
+}
+
+func (r *migrationRepo) ListMigrations(ctx context.Context, status constant.MigrationStatus) ([]*entities.CodecMigration, error) {
+	var migrations []*entities.CodecMigration
+	db := r.db.WithContext(ctx)
+	if status != "" {
+		db = db.Where("status = ?", status)
+	}
+	if err := db.Order("created_at DESC").Find(&migrations).Error; err != nil {
+		return nil, err
+	}
+	return migrations, nil
+}
+
+func (r *migrationRepo) UpdateMigrationStatus(ctx context.Context, id uuid.UUID, from []constant.MigrationStatus, status constant.MigrationStatus) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Model(&entities.CodecMigration{}).
+		Where("id = ? AND status IN ?", id, from).
+		Updates(map[string]interface{}{
+			"status":     status,
+			"updated_at": gorm.Expr("CURRENT_TIMESTAMP"),
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *migrationRepo) FindMigrationCandidates(ctx context.Context, migration *entities.CodecMigration, limit int) ([]dto.MigrationCandidate, error) {
+	var candidates []dto.MigrationCandidate
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT l.id AS lesson_id, l.course_id, l.video_url, j.tenant_id,
+		            (SELECT COUNT(*) FROM course_progress p WHERE p.lesson_id = l.id) AS plays
+		     FROM lessons l
+		     LEFT JOIN LATERAL (
+		         SELECT id, tenant_id FROM jobs
+		         WHERE entity_id = l.id AND job_type = ? AND status = ?
+		         ORDER BY updated_at DESC LIMIT 1
+		     ) j ON true
+		     WHERE l.video_url LIKE '%.m3u8'
+		       AND NOT EXISTS (SELECT 1 FROM codec_migration_lessons m WHERE m.migration_id = ? AND m.lesson_id = l.id)
+		       AND NOT EXISTS (SELECT 1 FROM jobs a WHERE a.entity_id = l.id AND a.status IN ?)
+		       AND NOT EXISTS (
+		           SELECT 1 FROM job_events e
+		           WHERE e.job_id = j.id AND e.event_type = ?
+		             AND e.data->>'preset' = ? AND (e.data->>'preset_version')::int >= ?
+		       )
+		     ORDER BY plays DESC, l.id
+		     LIMIT ?`,
+			constant.JobTypeTranscoder, constant.JobStatusCompleted,
+			migration.ID,
+			[]constant.JobStatus{constant.JobStatusPending, constant.JobStatusProcessing},
+			constant.JobEventOutput, migration.Preset, migration.PresetVersion,
+			limit).
+		Scan(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
+	return candidates, nil
+}
+
+func (r *migrationRepo) AddMigrationLesson(ctx context.Context, lesson *entities.CodecMigrationLesson) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(lesson).Error; err != nil {
+			return err
+		}
+		return tx.Model(&entities.CodecMigration{}).
+			Where("id = ?", lesson.MigrationId).
+			Updates(map[string]interface{}{
+				"enqueued":   gorm.Expr("enqueued + 1"),
+				"updated_at": gorm.Expr("CURRENT_TIMESTAMP"),
+			}).Error
+	})
+}
+
+func (r *migrationRepo) CountMigrationJobs(ctx context.Context, id *uuid.UUID, statuses []constant.JobStatus) (int64, error) {
+	var count int64
+	db := r.db.WithContext(ctx).
+		Table("codec_migration_lessons AS m").
+		Joins("JOIN jobs j ON j.id = m.job_id").
+		Where("j.status IN ?", statuses)
+	if id != nil {
+		db = db.Where("m.migration_id = ?", *id)
+	}
+	if err := db.Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// ListCourseProgress counts, per course, the transcoded lessons and the
+// lessons the migration queued, whether or not they are still transcoded.
+func (r *migrationRepo) ListCourseProgress(ctx context.Context, migration *entities.CodecMigration) ([]dto.CourseMigrationProgress, error) {
+	var courses []dto.CourseMigrationProgress
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT l.course_id,
+		            COUNT(*) AS lessons,
+		            COUNT(*) FILTER (WHERE o.data->>'preset' = ? AND (o.data->>'preset_version')::int >= ?) AS migrated,
+		            COUNT(m.job_id) AS enqueued,
+		            COUNT(*) FILTER (WHERE mj.status = ?) AS failed
+		     FROM lessons l
+		     LEFT JOIN codec_migration_lessons m ON m.migration_id = ? AND m.lesson_id = l.id
+		     LEFT JOIN jobs mj ON mj.id = m.job_id
+		     LEFT JOIN LATERAL (
+		         SELECT e.data FROM jobs j
+		         JOIN job_events e ON e.job_id = j.id AND e.event_type = ?
+		         WHERE j.entity_id = l.id AND j.job_type = ? AND j.status = ?
+		         ORDER BY j.updated_at DESC LIMIT 1
+		     ) o ON true
+		     WHERE l.video_url LIKE '%.m3u8' OR m.job_id IS NOT NULL
+		     GROUP BY l.course_id
+		     ORDER BY l.course_id`,
+			migration.Preset, migration.PresetVersion,
+			constant.JobStatusFailed,
+			migration.ID,
+			constant.JobEventOutput, constant.JobTypeTranscoder, constant.JobStatusCompleted).
+		Scan(&courses).Error
+	if err != nil {
+		return nil, err
+	}
+	return courses, nil
+}
+
+func NewMigrationRepo(db *gorm.DB) MigrationRepository {
+	return &migrationRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+	"worker-transcode/entities"
+)
+
+type NarrationRepository interface {
+	CreateNarration(ctx context.Context, narration *entities.Narration) error
+	FindNarration(ctx context.Context, id uuid.UUID) (*entities.Narration, error)
+	FindNarrationByJob(ctx context.Context, jobId uuid.UUID) (*entities.Narration, error)
+	// MarkNarrationRendered records the rendered video and the job
+	// transcoding it.
+	MarkNarrationRendered(ctx context.Context, id uuid.UUID, objectPath string, transcodeJobId uuid.UUID) error
+}
+
+type narrationRepo struct {
+	db *gorm.DB
+}
+
+func (r *narrationRepo) CreateNarration(ctx context.Context, narration *entities.Narration) error {
+	return r.db.WithContext(ctx).Create(narration).Error
+}
+
+func (r *narrationRepo) FindNarration(ctx context.Context, id uuid.UUID) (*entities.Narration, error) {
+	narration := &entities.Narration{}
+	if err := r.db.WithContext(ctx).Where("id = ?", id).First(narration).Error; err != nil {
+		return nil, err
+	}
+	return narration, nil
+}
+
+func (r *narrationRepo) FindNarrationByJob(ctx context.Context, jobId uuid.UUID) (*entities.Narration, error) {
+	narration := &entities.Narration{}
+	if err := r.db.WithContext(ctx).Where("job_id = ?", jobId).First(narration).Error; err != nil {
+		return nil, err
+	}
+	return narration, nil
+}
+
+func (r *narrationRepo) MarkNarrationRendered(ctx context.Context, id uuid.UUID, objectPath string, transcodeJobId uuid.UUID) error {
+	return r.db.WithContext(ctx).Model(&entities.Narration{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"object_path":      objectPath,
+			"transcode_job_id": transcodeJobId,
+			"updated_at":       time.Now().UTC(),
+		}).Error
+}
+
+func NewNarrationRepo(db *gorm.DB) NarrationRepository {
+	return &narrationRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"worker-transcode/entities"
+)
+
+type NotificationRepository interface {
+	FindLessonSummary(ctx context.Context, lessonId uuid.UUID) (*entities.LessonSummary, error)
+	FindCourseInstructorEmails(ctx context.Context, courseId uuid.UUID) ([]string, error)
+	FindUserEmail(ctx context.Context, userId uuid.UUID) (string, error)
+	FindTenantNotificationSettings(ctx context.Context, tenantId uuid.UUID) (*entities.TenantNotificationSettings, error)
+}
+
+type notificationRepo struct {
+	db *gorm.DB
+}
+
+func (r *notificationRepo) FindLessonSummary(ctx context.Context, lessonId uuid.UUID) (*entities.LessonSummary, error) {
+	summary := &entities.LessonSummary{}
+	result := r.db.WithContext(ctx).
+		Raw(`SELECT l.id AS lesson_id, l.title AS lesson_title, COALESCE(l.slug, '') AS lesson_slug,
+		            c.id AS course_id, c.title AS course_title, COALESCE(c.slug, '') AS course_slug
+		     FROM lessons l JOIN courses c ON c.id = l.course_id
+		     WHERE l.id = ?`, lessonId).
+		Scan(summary)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return summary, nil
+}
+
+func (r *notificationRepo) FindCourseInstructorEmails(ctx context.Context, courseId uuid.UUID) ([]string, error) {
+	var emails []string
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT u.email FROM course_instructors ci JOIN users u ON u.id = ci.instructor_id
+		     WHERE ci.course_id = ? AND u.enabled ORDER BY ci.creation`, courseId).
+		Scan(&emails).Error
+	if err != nil {
+		return nil, err
+	}
+	return emails, nil
+}
+
+func (r *notificationRepo) FindUserEmail(ctx context.Context, userId uuid.UUID) (string, error) {
+	var email string
+	result := r.db.WithContext(ctx).Raw(`SELECT email FROM users WHERE id = ?`, userId).Scan(&email)
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		return "", gorm.ErrRecordNotFound
+	}
+	return email, nil
+}
+
+func (r *notificationRepo) FindTenantNotificationSettings(ctx context.Context, tenantId uuid.UUID) (*entities.TenantNotificationSettings, error) {
+	settings := &entities.TenantNotificationSettings{}
+	err := r.db.WithContext(ctx).First(settings, "tenant_id = ?", tenantId).Error
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+func NewNotificationRepo(db *gorm.DB) NotificationRepository {
+	return &notificationRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+	"worker-transcode/entities"
+)
+
+type OutboxRepository interface {
+	// PendingOutbox lists the oldest messages not yet confirmed, at most
+	// limit of them.
+	PendingOutbox(ctx context.Context, limit int) ([]*entities.OutboxMessage, error)
+	MarkOutboxPublished(ctx context.Context, id uuid.UUID) error
+	MarkOutboxFailed(ctx context.Context, id uuid.UUID, reason string) error
+}
+
+type outboxRepo struct {
+	db *gorm.DB
+}
+
+func (r *outboxRepo) PendingOutbox(ctx context.Context, limit int) ([]*entities.OutboxMessage, error) {
+	var messages []*entities.OutboxMessage
+	err := r.db.WithContext(ctx).
+		Where("published_at IS NULL").
+		Order("created_at ASC").
+		Limit(limit).
+		Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func (r *outboxRepo) MarkOutboxPublished(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Model(&entities.OutboxMessage{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"published_at": time.Now(),
+			"attempts":     gorm.Expr("attempts + 1"),
+			"last_error":   nil,
+		}).Error
+}
+
+func (r *outboxRepo) MarkOutboxFailed(ctx context.Context, id uuid.UUID, reason string) error {
+	return r.db.WithContext(ctx).Model(&entities.OutboxMessage{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"attempts":   gorm.Expr("attempts + 1"),
+			"last_error": reason,
+		}).Error
+}
+
+func NewOutboxRepo(db *gorm.DB) OutboxRepository {
+	return &outboxRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+	"worker-transcode/entities"
+)
+
+type OutputRepository interface {
+	FindOutput(ctx context.Context, outputKey string) (*entities.TranscodeOutput, error)
+	// SaveOutput records the job's package as the one to copy for its key,
+	// in place of an older job's.
+	SaveOutput(ctx context.Context, output *entities.TranscodeOutput) error
+}
+
+type outputRepo struct {
+	db *gorm.DB
+}
+
+func (r *outputRepo) FindOutput(ctx context.Context, outputKey string) (*entities.TranscodeOutput, error) {
+	output := &entities.TranscodeOutput{}
+	if err := r.db.WithContext(ctx).Where("output_key = ?", outputKey).First(output).Error; err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+func (r *outputRepo) SaveOutput(ctx context.Context, output *entities.TranscodeOutput) error {
+	output.CreatedAt = time.Now().UTC()
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(output).Error
+}
+
+func NewOutputRepo(db *gorm.DB) OutputRepository {
+	return &outputRepo{
+		db: db,
+	}
+}
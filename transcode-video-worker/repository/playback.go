@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"worker-transcode/constant"
+	"worker-transcode/entities"
+)
+
+type PlaybackRepository interface {
+	// SamplePublishedVideos returns up to limit random lessons with a
+	// published HLS video.
+	SamplePublishedVideos(ctx context.Context, limit int) ([]*entities.Lesson, error)
+	// FindLessonPlayback returns the lesson's playback for the user. Its
+	// tenant is the one of its last completed transcode.
+	FindLessonPlayback(ctx context.Context, lessonId, userId uuid.UUID) (*entities.LessonPlayback, error)
+}
+
+type playbackRepo struct {
+	db *gorm.DB
+}
+
+func (r *playbackRepo) SamplePublishedVideos(ctx context.Context, limit int) ([]*entities.Lesson, error) {
+	var lessons []*entities.Lesson
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT id, video_url FROM lessons WHERE video_url LIKE '%.m3u8' ORDER BY random() LIMIT ?`, limit).
+		Scan(&lessons).Error
+	if err != nil {
+		return nil, err
+	}
+	return lessons, nil
+}
+
+func (r *playbackRepo) FindLessonPlayback(ctx context.Context, lessonId, userId uuid.UUID) (*entities.LessonPlayback, error) {
+	playback := &entities.LessonPlayback{}
+	result := r.db.WithContext(ctx).
+		Raw(`SELECT l.id AS lesson_id, COALESCE(l.video_url, '') AS video_url,
+		            EXISTS (SELECT 1 FROM enrollments e WHERE e.course_id = l.course_id AND e.member_id = ?) AS enrolled,
+		            t.playback_ttl
+		     FROM lessons l
+		     LEFT JOIN LATERAL (
+		         SELECT tenant_id FROM jobs
+		         WHERE entity_id = l.id AND job_type = ? AND status = ?
+		         ORDER BY updated_at DESC LIMIT 1
+		     ) j ON true
+		     LEFT JOIN tenant_configs t ON t.tenant_id = j.tenant_id
+		     WHERE l.id = ?`, userId, constant.JobTypeTranscoder, constant.JobStatusCompleted, lessonId).
+		Scan(playback)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return playback, nil
+}
+
+func NewPlaybackRepo(db *gorm.DB) PlaybackRepository {
+	return &playbackRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+	"worker-transcode/entities"
+)
+
+type PodcastRepository interface {
+	CreateFeed(ctx context.Context, feed *entities.PodcastFeed) error
+	FindFeedByJob(ctx context.Context, jobId uuid.UUID) (*entities.PodcastFeed, error)
+	// FindLatestFeed returns the course's last finished build.
+	FindLatestFeed(ctx context.Context, courseId uuid.UUID) (*entities.PodcastFeed, error)
+	MarkFeedBuilt(ctx context.Context, id uuid.UUID, episodes int) error
+	FindCourse(ctx context.Context, courseId uuid.UUID) (*entities.PodcastCourse, error)
+	// ListLessons lists the course's lessons whose video is transcoded, in
+	// course order.
+	ListLessons(ctx context.Context, courseId uuid.UUID) ([]entities.PodcastLesson, error)
+	ListEpisodes(ctx context.Context, courseId uuid.UUID) ([]*entities.PodcastEpisode, error)
+	// SaveEpisode records the lesson's episode in place of the one it had.
+	SaveEpisode(ctx context.Context, episode *entities.PodcastEpisode) error
+	DeleteEpisode(ctx context.Context, lessonId uuid.UUID) error
+	// IsEnrolled reports whether the user is a member of the course.
+	IsEnrolled(ctx context.Context, courseId, userId uuid.UUID) (bool, error)
+}
+
+type podcastRepo struct {
+	db *gorm.DB
+}
+
+func (r *podcastRepo) CreateFeed(ctx context.Context, feed *entities.PodcastFeed) error {
+	return r.db.WithContext(ctx).Create(feed).Error
+}
+
+func (r *podcastRepo) FindFeedByJob(ctx context.Context, jobId uuid.UUID) (*entities.PodcastFeed, error) {
+	feed := &entities.PodcastFeed{}
+	if err := r.db.WithContext(ctx).Where("job_id = ?", jobId).First(feed).Error; err != nil {
+		return nil, err
+	}
+	return feed, nil
+}
+
+func (r *podcastRepo) FindLatestFeed(ctx context.Context, courseId uuid.UUID) (*entities.PodcastFeed, error) {
+	feed := &entities.PodcastFeed{}
+	err := r.db.WithContext(ctx).
+		Where("course_id = ? AND built_at IS NOT NULL", courseId).
+		Order("built_at DESC").
+		First(feed).Error
+	if err != nil {
+		return nil, err
+	}
+	return feed, nil
+}
+
+func (r *podcastRepo) MarkFeedBuilt(ctx context.Context, id uuid.UUID, episodes int) error {
+	now := time.Now().UTC()
+	return r.db.WithContext(ctx).Model(&entities.PodcastFeed{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"episode_count": episodes,
+			"built_at":      now,
+			"updated_at":    now,
+		}).Error
+}
+
+func (r *podcastRepo) FindCourse(ctx context.Context, courseId uuid.UUID) (*entities.PodcastCourse, error) {
+	course := &entities.PodcastCourse{}
+	result := r.db.WithContext(ctx).
+		Raw(`SELECT title, COALESCE(short_introduction, description, '') AS description,
+		            COALESCE(image, '') AS image, COALESCE(language, '') AS language
+		     FROM courses WHERE id = ?`, courseId).
+		Scan(course)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return course, nil
+}
+
+func (r *podcastRepo) ListLessons(ctx context.Context, courseId uuid.UUID) ([]entities.PodcastLesson, error) {
+	var lessons []entities.PodcastLesson
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT l.id AS lesson_id, l.title, l.video_url
+		     FROM lessons l
+		     LEFT JOIN chapters ch ON ch.id = l.chapter_id
+		     WHERE l.course_id = ? AND l.video_url LIKE '%.m3u8'
+		     ORDER BY ch."position" NULLS LAST, l."position" NULLS LAST, l.id`, courseId).
+		Scan(&lessons).Error
+	if err != nil {
+		return nil, err
+	}
+	return lessons, nil
+}
+
+func (r *podcastRepo) ListEpisodes(ctx context.Context, courseId uuid.UUID) ([]*entities.PodcastEpisode, error) {
+	var episodes []*entities.PodcastEpisode
+	if err := r.db.WithContext(ctx).Where("course_id = ?", courseId).Find(&episodes).Error; err != nil {
+		return nil, err
+	}
+	return episodes, nil
+}
+
+func (r *podcastRepo) SaveEpisode(ctx context.Context, episode *entities.PodcastEpisode) error {
+	episode.UpdatedAt = time.Now().UTC()
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(episode).Error
+}
+
+func (r *podcastRepo) DeleteEpisode(ctx context.Context, lessonId uuid.UUID) error {
+	return r.db.WithContext(ctx).Where("lesson_id = ?", lessonId).Delete(&entities.PodcastEpisode{}).Error
+}
+
+func (r *podcastRepo) IsEnrolled(ctx context.Context, courseId, userId uuid.UUID) (bool, error) {
+	var enrolled bool
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT EXISTS (SELECT 1 FROM enrollments WHERE course_id = ? AND member_id = ?)`, courseId, userId).
+		Scan(&enrolled).Error
+	return enrolled, err
+}
+
+func NewPodcastRepo(db *gorm.DB) PodcastRepository {
+	return &podcastRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"worker-transcode/entities"
+)
+
+type PosterRepository interface {
+	// FindLessonVideo returns the master playlist the lesson plays, empty
+	// while it has none.
+	FindLessonVideo(ctx context.Context, lessonId uuid.UUID) (string, error)
+}
+
+type posterRepo struct {
+	db *gorm.DB
+}
+
+func (r *posterRepo) FindLessonVideo(ctx context.Context, lessonId uuid.UUID) (string, error) {
+	lesson := &entities.Lesson{}
+	if err := r.db.WithContext(ctx).Select("id", "video_url").Where("id = ?", lessonId).First(lesson).Error; err != nil {
+		return "", err
+	}
+	return lesson.VideoUrl, nil
+}
+
+func NewPosterRepo(db *gorm.DB) PosterRepository {
+	return &posterRepo{
+		db: db,
+	}
+}
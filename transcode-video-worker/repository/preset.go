@@ -0,0 +1,346 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+)
+
+type PresetRepository interface {
+	ListLatestPresets(ctx context.Context) ([]*entities.Preset, error)
+	FindLatestPreset(ctx context.Context, name string) (*entities.Preset, error)
+	FindActivePreset(ctx context.Context, name string) (*entities.Preset, error)
+	FindPresetVersion(ctx context.Context, name string, version int) (*entities.Preset, error)
+	CreatePreset(ctx context.Context, preset *entities.Preset) error
+	DeactivatePreset(ctx context.Context, name string) error
+	FindCanaryPreset(ctx context.Context, name string) (*entities.Preset, error)
+	CreateCanary(ctx context.Context, preset *entities.Preset) error
+	PromoteCanary(ctx context.Context, name string) (*entities.Preset, error)
+	AbortCanary(ctx context.Context, name string) error
+	PresetVersionStats(ctx context.Context, name string, since time.Time) ([]dto.PresetVersionStats, error)
+	CreateExperiment(ctx context.Context, experiment *entities.PresetExperiment) error
+	FindExperiment(ctx context.Context, id uuid.UUID) (*entities.PresetExperiment, error)
+	// FindRunningExperiment returns the experiment taking the jobs of the
+	// control preset.
+	FindRunningExperiment(ctx context.Context, controlPreset string) (*entities.PresetExperiment, error)
+	ListExperiments(ctx context.Context) ([]*entities.PresetExperiment, error)
+	// StopExperiment stops the experiment taking jobs, reporting false when
+	// it wasn't running.
+	StopExperiment(ctx context.Context, id uuid.UUID) (bool, error)
+	// SaveExperimentJob tags the job with its arm, replacing the tag an
+	// earlier attempt of the job saved.
+	SaveExperimentJob(ctx context.Context, job *entities.ExperimentJob) error
+	// ExperimentArmStats summarises the finished jobs of each arm that were
+	// encoded with their arm's preset.
+	ExperimentArmStats(ctx context.Context, experimentId uuid.UUID) ([]dto.ExperimentArmStats, error)
+	// ExperimentRungStats averages the scores of each arm's renditions per
+	// rung.
+	ExperimentRungStats(ctx context.Context, experimentId uuid.UUID) ([]dto.ExperimentRungStats, error)
+}
+
+type presetRepo struct {
+	db *gorm.DB
+}
+
+func (r *presetRepo) ListLatestPresets(ctx context.Context) ([]*entities.Preset, error) {
+	var presets []*entities.Preset
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT DISTINCT ON (name) * FROM presets ORDER BY name, version DESC`).
+		Scan(&presets).Error
+	if err != nil {
+		return nil, err
+	}
+	return presets, nil
+}
+
+func (r *presetRepo) FindLatestPreset(ctx context.Context, name string) (*entities.Preset, error) {
+	preset := &entities.Preset{}
+	err := r.db.WithContext(ctx).Where("name = ?", name).Order("version DESC").First(preset).Error
+	if err != nil {
+		return nil, err
+	}
+	return preset, nil
+}
+
+func (r *presetRepo) FindActivePreset(ctx context.Context, name string) (*entities.Preset, error) {
+	preset := &entities.Preset{}
+	err := r.db.WithContext(ctx).Where("name = ? AND active", name).Order("version DESC").First(preset).Error
+	if err != nil {
+		return nil, err
+	}
+	return preset, nil
+}
+
+func (r *presetRepo) FindPresetVersion(ctx context.Context, name string, version int) (*entities.Preset, error) {
+	preset := &entities.Preset{}
+	err := r.db.WithContext(ctx).Where("name = ? AND version = ?", name, version).First(preset).Error
+	if err != nil {
+		return nil, err
+	}
+	return preset, nil
+}
+
+// CreatePreset inserts the preset as the next version of its name. Older
+// versions are kept for auditing but are no longer active, and a canary
+// being rolled out is superseded.
+func (r *presetRepo) CreatePreset(ctx context.Context, preset *entities.Preset) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		latest := &entities.Preset{}
+		err := tx.Where("name = ?", preset.Name).Order("version DESC").First(latest).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			preset.Version = 1
+		case err != nil:
+			return err
+		default:
+			preset.Version = latest.Version + 1
+		}
+
+		if err := tx.Model(&entities.Preset{}).Where("name = ?", preset.Name).
+			Updates(map[string]interface{}{"active": false, "canary_percent": 0}).Error; err != nil {
+			return err
+		}
+
+		preset.Active = true
+		return tx.Create(preset).Error
+	})
+}
+
+func (r *presetRepo) FindCanaryPreset(ctx context.Context, name string) (*entities.Preset, error) {
+	preset := &entities.Preset{}
+	err := r.db.WithContext(ctx).Where("name = ? AND canary_percent > 0", name).Order("version DESC").First(preset).Error
+	if err != nil {
+		return nil, err
+	}
+	return preset, nil
+}
+
+// CreateCanary inserts the preset as the next version of its name without
+// making it active, replacing any canary already being rolled out.
+func (r *presetRepo) CreateCanary(ctx context.Context, preset *entities.Preset) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		latest := &entities.Preset{}
+		err := tx.Where("name = ?", preset.Name).Order("version DESC").First(latest).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			preset.Version = 1
+		case err != nil:
+			return err
+		default:
+			preset.Version = latest.Version + 1
+		}
+
+		if err := tx.Model(&entities.Preset{}).Where("name = ?", preset.Name).Update("canary_percent", 0).Error; err != nil {
+			return err
+		}
+
+		// Active defaults to true in the table, so a false one isn't
+		// inserted and is set afterwards.
+		if err := tx.Create(preset).Error; err != nil {
+			return err
+		}
+		preset.Active = false
+		return tx.Model(preset).Update("active", false).Error
+	})
+}
+
+// PromoteCanary makes the name's canary its active version.
+func (r *presetRepo) PromoteCanary(ctx context.Context, name string) (*entities.Preset, error) {
+	canary := &entities.Preset{}
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("name = ? AND canary_percent > 0", name).Order("version DESC").First(canary).Error
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&entities.Preset{}).Where("name = ?", name).
+			Updates(map[string]interface{}{"active": false, "canary_percent": 0}).Error; err != nil {
+			return err
+		}
+
+		canary.Active, canary.CanaryPercent = true, 0
+		return tx.Model(canary).Updates(map[string]interface{}{"active": true, "canary_percent": 0}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return canary, nil
+}
+
+// AbortCanary stops the name's canary taking jobs. The version is kept for
+// auditing.
+func (r *presetRepo) AbortCanary(ctx context.Context, name string) error {
+	result := r.db.WithContext(ctx).Model(&entities.Preset{}).Where("name = ? AND canary_percent > 0", name).Update("canary_percent", 0)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// PresetVersionStats compares the versions of a preset over the jobs that
+// finished since then: how many completed and failed, the bitrate of the
+// output and how much faster than realtime it was encoded.
+func (r *presetRepo) PresetVersionStats(ctx context.Context, name string, since time.Time) ([]dto.PresetVersionStats, error) {
+	var stats []dto.PresetVersionStats
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT j.preset_version AS version,
+		            COUNT(*) FILTER (WHERE j.status = ?) AS completed,
+		            COUNT(*) FILTER (WHERE j.status = ?) AS failed,
+		            COALESCE(AVG((o.data->>'bytes')::float8 * 8 / 1000 / NULLIF((o.data->>'source_seconds')::float8, 0)), 0) AS output_kbps,
+		            COALESCE(AVG((o.data->>'source_seconds')::float8 / NULLIF(t.seconds, 0)), 0) AS encode_speed
+		     FROM jobs j
+		     LEFT JOIN job_events o ON o.job_id = j.id AND o.event_type = ?
+		     LEFT JOIN LATERAL (
+		         SELECT SUM((e.data->>'seconds')::float8) AS seconds FROM job_events e
+		         WHERE e.job_id = j.id AND e.event_type = ? AND e.stage = 'transcode'
+		     ) t ON TRUE
+		     WHERE j.preset = ? AND j.preset_version IS NOT NULL AND j.status IN (?, ?) AND j.updated_at >= ?
+		     GROUP BY j.preset_version ORDER BY j.preset_version`,
+			constant.JobStatusCompleted, constant.JobStatusFailed, constant.JobEventOutput, constant.JobEventStage,
+			name, constant.JobStatusCompleted, constant.JobStatusFailed, since).
+		Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range stats {
+		if finished := stats[i].Completed + stats[i].Failed; finished > 0 {
+			stats[i].FailureRate = float64(stats[i].Failed) / float64(finished)
+		}
+	}
+	return stats, nil
+}
+
+func (r *presetRepo) DeactivatePreset(ctx context.Context, name string) error {
+	result := r.db.WithContext(ctx).Model(&entities.Preset{}).Where("name = ?", name).Update("active", false)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *presetRepo) CreateExperiment(ctx context.Context, experiment *entities.PresetExperiment) error {
+	return r.db.WithContext(ctx).Create(experiment).Error
+}
+
+func (r *presetRepo) FindExperiment(ctx context.Context, id uuid.UUID) (*entities.PresetExperiment, error) {
+	experiment := &entities.PresetExperiment{}
+	if err := r.db.WithContext(ctx).First(experiment, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return experiment, nil
+}
+
+func (r *presetRepo) FindRunningExperiment(ctx context.Context, controlPreset string) (*entities.PresetExperiment, error) {
+	experiment := &entities.PresetExperiment{}
+	err := r.db.WithContext(ctx).
+		First(experiment, "control_preset = ? AND status = ?", controlPreset, constant.ExperimentStatusRunning).Error
+	if err != nil {
+		return nil, err
+	}
+	return experiment, nil
+}
+
+func (r *presetRepo) ListExperiments(ctx context.Context) ([]*entities.PresetExperiment, error) {
+	var experiments []*entities.PresetExperiment
+	if err := r.db.WithContext(ctx).Order("started_at DESC").Find(&experiments).Error; err != nil {
+		return nil, err
+	}
+	return experiments, nil
+}
+
+func (r *presetRepo) StopExperiment(ctx context.Context, id uuid.UUID) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&entities.PresetExperiment{}).
+		Where("id = ? AND status = ?", id, constant.ExperimentStatusRunning).
+		Updates(map[string]interface{}{"status": constant.ExperimentStatusStopped, "stopped_at": time.Now().UTC()})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *presetRepo) SaveExperimentJob(ctx context.Context, job *entities.ExperimentJob) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "job_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"experiment_id", "arm", "preset", "preset_version"}),
+	}).Create(job).Error
+}
+
+// ExperimentArmStats leaves out jobs whose retry was encoded with another
+// preset than their tag's, after the experiment stopped.
+func (r *presetRepo) ExperimentArmStats(ctx context.Context, experimentId uuid.UUID) ([]dto.ExperimentArmStats, error) {
+	var stats []dto.ExperimentArmStats
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT x.arm, x.preset,
+		            COUNT(*) FILTER (WHERE j.status = ?) AS completed,
+		            COUNT(*) FILTER (WHERE j.status = ?) AS failed,
+		            COALESCE(AVG((o.data->>'bytes')::float8), 0) AS output_bytes,
+		            COALESCE(AVG((o.data->>'bytes')::float8 * 8 / 1000 / NULLIF((o.data->>'source_seconds')::float8, 0)), 0) AS output_kbps,
+		            COALESCE(AVG(t.seconds), 0) AS encode_seconds,
+		            COALESCE(AVG((o.data->>'source_seconds')::float8 / NULLIF(t.seconds, 0)), 0) AS encode_speed,
+		            COALESCE(SUM(q.renditions), 0) AS scored,
+		            COALESCE(SUM(q.vmaf) / NULLIF(SUM(q.renditions), 0), 0) AS vmaf,
+		            COALESCE(SUM(q.vmaf_harmonic) / NULLIF(SUM(q.renditions), 0), 0) AS vmaf_harmonic
+		     FROM preset_experiment_jobs x
+		     JOIN jobs j ON j.id = x.job_id AND j.preset = x.preset
+		     LEFT JOIN job_events o ON o.job_id = j.id AND o.event_type = ?
+		     LEFT JOIN LATERAL (
+		         SELECT SUM((e.data->>'seconds')::float8) AS seconds FROM job_events e
+		         WHERE e.job_id = j.id AND e.event_type = ? AND e.stage = 'transcode'
+		     ) t ON TRUE
+		     LEFT JOIN LATERAL (
+		         SELECT COUNT(*) AS renditions, SUM(vmaf) AS vmaf, SUM(vmaf_harmonic) AS vmaf_harmonic
+		         FROM rendition_quality_scores WHERE job_id = j.id
+		     ) q ON TRUE
+		     WHERE x.experiment_id = ? AND j.status IN (?, ?)
+		     GROUP BY x.arm, x.preset ORDER BY x.arm`,
+			constant.JobStatusCompleted, constant.JobStatusFailed, constant.JobEventOutput, constant.JobEventStage,
+			experimentId, constant.JobStatusCompleted, constant.JobStatusFailed).
+		Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range stats {
+		if finished := stats[i].Completed + stats[i].Failed; finished > 0 {
+			stats[i].FailureRate = float64(stats[i].Failed) / float64(finished)
+		}
+	}
+	return stats, nil
+}
+
+func (r *presetRepo) ExperimentRungStats(ctx context.Context, experimentId uuid.UUID) ([]dto.ExperimentRungStats, error) {
+	var stats []dto.ExperimentRungStats
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT x.arm, q.height,
+		            COUNT(*) AS renditions,
+		            AVG(q.average_kbps) AS average_kbps,
+		            AVG(q.vmaf) AS vmaf,
+		            AVG(q.vmaf_harmonic) AS vmaf_harmonic,
+		            AVG(q.vmaf_per_mbps) AS vmaf_per_mbps
+		     FROM preset_experiment_jobs x
+		     JOIN rendition_quality_scores q ON q.job_id = x.job_id AND q.preset = x.preset
+		     WHERE x.experiment_id = ?
+		     GROUP BY x.arm, q.height ORDER BY q.height, x.arm`, experimentId).
+		Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func NewPresetRepo(db *gorm.DB) PresetRepository {
+	return &presetRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+	"worker-transcode/entities"
+)
+
+type PublishingRepository interface {
+	FindPublishTarget(ctx context.Context, tenantId uuid.UUID) (*entities.PublishTarget, error)
+	SavePublishTarget(ctx context.Context, target *entities.PublishTarget) error
+	FindExternalPlayback(ctx context.Context, lessonId uuid.UUID) (*entities.ExternalPlayback, error)
+	SaveExternalPlayback(ctx context.Context, playback *entities.ExternalPlayback) error
+}
+
+type publishingRepo struct {
+	db *gorm.DB
+}
+
+func (r *publishingRepo) FindPublishTarget(ctx context.Context, tenantId uuid.UUID) (*entities.PublishTarget, error) {
+	target := &entities.PublishTarget{}
+	err := r.db.WithContext(ctx).First(target, "tenant_id = ?", tenantId).Error
+	if err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+func (r *publishingRepo) SavePublishTarget(ctx context.Context, target *entities.PublishTarget) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(target).Error
+}
+
+func (r *publishingRepo) FindExternalPlayback(ctx context.Context, lessonId uuid.UUID) (*entities.ExternalPlayback, error) {
+	playback := &entities.ExternalPlayback{}
+	err := r.db.WithContext(ctx).First(playback, "lesson_id = ?", lessonId).Error
+	if err != nil {
+		return nil, err
+	}
+	return playback, nil
+}
+
+// SaveExternalPlayback replaces the lesson's playback, keeping when it was
+// first published.
+func (r *publishingRepo) SaveExternalPlayback(ctx context.Context, playback *entities.ExternalPlayback) error {
+	playback.UpdatedAt = time.Now()
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "lesson_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"job_id", "provider", "playback_id", "playback_url", "updated_at"}),
+	}).Create(playback).Error
+}
+
+func NewPublishingRepo(db *gorm.DB) PublishingRepository {
+	return &publishingRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+	"worker-transcode/constant"
+	"worker-transcode/entities"
+)
+
+type QCRepository interface {
+	// SaveFlag records a check's findings for a job, superseding the
+	// unsettled flags the lesson's earlier videos had for the check.
+	SaveFlag(ctx context.Context, flag *entities.QCFlag) error
+	// SupersedeFlags supersedes the lesson's open and confirmed flags for
+	// the check from jobs other than jobId.
+	SupersedeFlags(ctx context.Context, lessonId, jobId uuid.UUID, check constant.QCCheck) error
+	FindFlag(ctx context.Context, id uuid.UUID) (*entities.QCFlag, error)
+	ListLessonFlags(ctx context.Context, lessonId uuid.UUID) ([]*entities.QCFlag, error)
+	// ListOpenFlags lists the flags awaiting review, the oldest first, of
+	// every check when check is empty.
+	ListOpenFlags(ctx context.Context, check constant.QCCheck, limit int) ([]*entities.QCFlag, error)
+	// ReviewFlag settles an open flag and reports whether it was open.
+	ReviewFlag(ctx context.Context, id uuid.UUID, status constant.QCFlagStatus, reviewer *uuid.UUID, note *string) (bool, error)
+}
+
+type qcRepo struct {
+	db *gorm.DB
+}
+
+func (r *qcRepo) SaveFlag(ctx context.Context, flag *entities.QCFlag) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		repo := &qcRepo{db: tx}
+		if err := repo.SupersedeFlags(ctx, flag.LessonId, flag.JobId, flag.Check); err != nil {
+			return err
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(flag).Error
+	})
+}
+
+func (r *qcRepo) SupersedeFlags(ctx context.Context, lessonId, jobId uuid.UUID, check constant.QCCheck) error {
+	return r.db.WithContext(ctx).Model(&entities.QCFlag{}).
+		Where("lesson_id = ? AND job_id <> ? AND qc_check = ? AND status IN ?", lessonId, jobId, check,
+			[]constant.QCFlagStatus{constant.QCFlagStatusOpen, constant.QCFlagStatusConfirmed}).
+		Update("status", constant.QCFlagStatusSuperseded).Error
+}
+
+func (r *qcRepo) FindFlag(ctx context.Context, id uuid.UUID) (*entities.QCFlag, error) {
+	flag := &entities.QCFlag{}
+	if err := r.db.WithContext(ctx).Where("id = ?", id).First(flag).Error; err != nil {
+		return nil, err
+	}
+	return flag, nil
+}
+
+func (r *qcRepo) ListLessonFlags(ctx context.Context, lessonId uuid.UUID) ([]*entities.QCFlag, error) {
+	var flags []*entities.QCFlag
+	err := r.db.WithContext(ctx).
+		Where("lesson_id = ?", lessonId).
+		Order("created_at DESC").
+		Find(&flags).Error
+	if err != nil {
+		return nil, err
+	}
+	return flags, nil
+}
+
+func (r *qcRepo) ListOpenFlags(ctx context.Context, check constant.QCCheck, limit int) ([]*entities.QCFlag, error) {
+	var flags []*entities.QCFlag
+	db := r.db.WithContext(ctx).Where("status = ?", constant.QCFlagStatusOpen)
+	if check != "" {
+		db = db.Where("qc_check = ?", check)
+	}
+	err := db.
+		Order("created_at").
+		Limit(limit).
+		Find(&flags).Error
+	if err != nil {
+		return nil, err
+	}
+	return flags, nil
+}
+
+func (r *qcRepo) ReviewFlag(ctx context.Context, id uuid.UUID, status constant.QCFlagStatus, reviewer *uuid.UUID, note *string) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&entities.QCFlag{}).
+		Where("id = ? AND status = ?", id, constant.QCFlagStatusOpen).
+		Updates(map[string]interface{}{
+			"status":      status,
+			"reviewed_by": reviewer,
+			"reviewed_at": time.Now().UTC(),
+			"note":        note,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func NewQCRepo(db *gorm.DB) QCRepository {
+	return &qcRepo{
+		db: db,
+	}
+}
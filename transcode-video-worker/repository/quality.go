@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+)
+
+type QualityRepository interface {
+	// SaveRenditionQuality replaces the scores a job's renditions had, so a
+	// retried job keeps one score per rendition.
+	SaveRenditionQuality(ctx context.Context, jobId uuid.UUID, scores []*entities.RenditionQuality) error
+	ListJobQuality(ctx context.Context, jobId uuid.UUID) ([]*entities.RenditionQuality, error)
+	// PresetQualityStats averages the preset's scores per version and rung.
+	PresetQualityStats(ctx context.Context, name string) ([]dto.PresetQualityStats, error)
+	// SaveGate replaces the verdict a job's gate had.
+	SaveGate(ctx context.Context, gate *entities.QualityGate) error
+	FindGate(ctx context.Context, jobId uuid.UUID) (*entities.QualityGate, error)
+	// OverrideGate settles a failed gate and reports whether it was failed.
+	OverrideGate(ctx context.Context, jobId uuid.UUID, reviewer *uuid.UUID, note *string) (bool, error)
+}
+
+type qualityRepo struct {
+	db *gorm.DB
+}
+
+func (r *qualityRepo) SaveRenditionQuality(ctx context.Context, jobId uuid.UUID, scores []*entities.RenditionQuality) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("job_id = ?", jobId).Delete(&entities.RenditionQuality{}).Error; err != nil {
+			return err
+		}
+		if len(scores) == 0 {
+			return nil
+		}
+		return tx.Create(scores).Error
+	})
+}
+
+func (r *qualityRepo) ListJobQuality(ctx context.Context, jobId uuid.UUID) ([]*entities.RenditionQuality, error) {
+	var scores []*entities.RenditionQuality
+	if err := r.db.WithContext(ctx).Where("job_id = ?", jobId).Order("height").Find(&scores).Error; err != nil {
+		return nil, err
+	}
+	return scores, nil
+}
+
+func (r *qualityRepo) PresetQualityStats(ctx context.Context, name string) ([]dto.PresetQualityStats, error) {
+	var stats []dto.PresetQualityStats
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT preset_version AS version, height,
+		            COUNT(*) AS renditions,
+		            AVG(average_kbps) AS average_kbps,
+		            AVG(vmaf) AS vmaf,
+		            AVG(vmaf_min) AS vmaf_min,
+		            AVG(vmaf_harmonic) AS vmaf_harmonic,
+		            AVG(vmaf_per_mbps) AS vmaf_per_mbps
+		     FROM rendition_quality_scores
+		     WHERE preset = ?
+		     GROUP BY preset_version, height ORDER BY preset_version, height`, name).
+		Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func (r *qualityRepo) SaveGate(ctx context.Context, gate *entities.QualityGate) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "job_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"preset", "preset_version", "status", "thresholds", "renditions", "package_path", "created_at"}),
+	}).Create(gate).Error
+}
+
+func (r *qualityRepo) FindGate(ctx context.Context, jobId uuid.UUID) (*entities.QualityGate, error) {
+	var gate entities.QualityGate
+	if err := r.db.WithContext(ctx).Where("job_id = ?", jobId).First(&gate).Error; err != nil {
+		return nil, err
+	}
+	return &gate, nil
+}
+
+func (r *qualityRepo) OverrideGate(ctx context.Context, jobId uuid.UUID, reviewer *uuid.UUID, note *string) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&entities.QualityGate{}).
+		Where("job_id = ? AND status = ?", jobId, constant.QualityGateStatusFailed).
+		Updates(map[string]interface{}{
+			"status":        constant.QualityGateStatusOverridden,
+			"overridden_by": reviewer,
+			"overridden_at": time.Now().UTC(),
+			"note":          note,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func NewQualityRepo(db *gorm.DB) QualityRepository {
+	return &qualityRepo{
+		db: db,
+	}
+}
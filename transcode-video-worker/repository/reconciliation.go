@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+	"worker-transcode/constant"
+	"worker-transcode/entities"
+)
+
+type ReconciliationRepository interface {
+	CreateReconciliation(ctx context.Context, run *entities.MediaReconciliation) error
+	// FinishReconciliation records the run's totals and that it's done.
+	FinishReconciliation(ctx context.Context, run *entities.MediaReconciliation) error
+	SaveFindings(ctx context.Context, findings []*entities.ReconciliationFinding) error
+	// FindLatestReconciliation returns the last run that finished.
+	FindLatestReconciliation(ctx context.Context) (*entities.MediaReconciliation, error)
+	ListFindings(ctx context.Context, reconciliationId uuid.UUID) ([]*entities.ReconciliationFinding, error)
+	// DeleteReconciliations removes the runs started before, with their
+	// findings.
+	DeleteReconciliations(ctx context.Context, before time.Time) error
+	// ListReconciledLessons returns up to limit lessons with a video, in id
+	// order after the lesson after.
+	ListReconciledLessons(ctx context.Context, after uuid.UUID, limit int) ([]*entities.ReconciledLesson, error)
+}
+
+type reconciliationRepo struct {
+	db *gorm.DB
+}
+
+func (r *reconciliationRepo) CreateReconciliation(ctx context.Context, run *entities.MediaReconciliation) error {
+	return r.db.WithContext(ctx).Create(run).Error
+}
+
+func (r *reconciliationRepo) FinishReconciliation(ctx context.Context, run *entities.MediaReconciliation) error {
+	return r.db.WithContext(ctx).Model(run).Updates(map[string]interface{}{
+		"lessons":     run.Lessons,
+		"findings":    run.Findings,
+		"finished_at": run.FinishedAt,
+	}).Error
+}
+
+func (r *reconciliationRepo) SaveFindings(ctx context.Context, findings []*entities.ReconciliationFinding) error {
+	if len(findings) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).CreateInBatches(findings, 500).Error
+}
+
+func (r *reconciliationRepo) FindLatestReconciliation(ctx context.Context) (*entities.MediaReconciliation, error) {
+	run := &entities.MediaReconciliation{}
+	err := r.db.WithContext(ctx).
+		Where("finished_at IS NOT NULL").
+		Order("started_at DESC").
+		First(run).Error
+	if err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+func (r *reconciliationRepo) ListFindings(ctx context.Context, reconciliationId uuid.UUID) ([]*entities.ReconciliationFinding, error) {
+	var findings []*entities.ReconciliationFinding
+	err := r.db.WithContext(ctx).
+		Where("reconciliation_id = ?", reconciliationId).
+		Order("course_id NULLS LAST, lesson_id, problem").
+		Find(&findings).Error
+	if err != nil {
+		return nil, err
+	}
+	return findings, nil
+}
+
+func (r *reconciliationRepo) DeleteReconciliations(ctx context.Context, before time.Time) error {
+	return r.db.WithContext(ctx).Where("started_at < ?", before).Delete(&entities.MediaReconciliation{}).Error
+}
+
+func (r *reconciliationRepo) ListReconciledLessons(ctx context.Context, after uuid.UUID, limit int) ([]*entities.ReconciledLesson, error) {
+	var lessons []*entities.ReconciledLesson
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT l.id AS lesson_id, l.course_id, l.video_url,
+		            v.playlist_key AS version_key,
+		            COALESCE(e.data->'renditions', '[]') AS renditions,
+		            m.lesson_id IS NOT NULL AS has_media
+		     FROM lessons l
+		     LEFT JOIN LATERAL (
+		         SELECT job_id, playlist_key FROM lesson_video_versions
+		         WHERE lesson_id = l.id AND status = ?
+		         ORDER BY created_at DESC LIMIT 1
+		     ) v ON true
+		     LEFT JOIN LATERAL (
+		         SELECT id FROM jobs
+		         WHERE entity_id = l.id AND job_type = ? AND status = ?
+		         ORDER BY updated_at DESC LIMIT 1
+		     ) j ON true
+		     LEFT JOIN LATERAL (
+		         SELECT data FROM job_events
+		         WHERE job_id = COALESCE(v.job_id, j.id) AND event_type = ?
+		         ORDER BY created_at DESC LIMIT 1
+		     ) e ON true
+		     LEFT JOIN lesson_media m ON m.lesson_id = l.id
+		     WHERE COALESCE(l.video_url, '') <> '' AND l.id > ?
+		     ORDER BY l.id
+		     LIMIT ?`,
+			constant.VideoVersionStatusActive, constant.JobTypeTranscoder, constant.JobStatusCompleted, constant.JobEventOutput, after, limit).
+		Scan(&lessons).Error
+	if err != nil {
+		return nil, err
+	}
+	return lessons, nil
+}
+
+func NewReconciliationRepo(db *gorm.DB) ReconciliationRepository {
+	return &reconciliationRepo{
+		db: db,
+	}
+}
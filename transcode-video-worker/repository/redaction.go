@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+	"worker-transcode/constant"
+	"worker-transcode/entities"
+)
+
+type RedactionRepository interface {
+	// SaveRedaction replaces what was flagged in the lesson's transcript in
+	// the redaction's language.
+	SaveRedaction(ctx context.Context, redaction *entities.LessonRedaction) error
+	ListRedactions(ctx context.Context, lessonId uuid.UUID) ([]*entities.LessonRedaction, error)
+	// FindLessonTenant returns the config of the tenant whose job last
+	// transcoded the lesson.
+	FindLessonTenant(ctx context.Context, lessonId uuid.UUID) (*entities.TenantConfig, error)
+}
+
+type redactionRepo struct {
+	db *gorm.DB
+}
+
+func (r *redactionRepo) SaveRedaction(ctx context.Context, redaction *entities.LessonRedaction) error {
+	redaction.UpdatedAt = time.Now()
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "lesson_id"}, {Name: "language"}},
+		DoUpdates: clause.AssignmentColumns([]string{"findings", "audio_replacement_id", "updated_at"}),
+	}).Create(redaction).Error
+}
+
+func (r *redactionRepo) ListRedactions(ctx context.Context, lessonId uuid.UUID) ([]*entities.LessonRedaction, error) {
+	var redactions []*entities.LessonRedaction
+	if err := r.db.WithContext(ctx).Where("lesson_id = ?", lessonId).Order("language").Find(&redactions).Error; err != nil {
+		return nil, err
+	}
+	return redactions, nil
+}
+
+func (r *redactionRepo) FindLessonTenant(ctx context.Context, lessonId uuid.UUID) (*entities.TenantConfig, error) {
+	tenant := &entities.TenantConfig{}
+	result := r.db.WithContext(ctx).
+		Raw(`SELECT t.* FROM tenant_configs t
+		     WHERE t.tenant_id = (
+		         SELECT tenant_id FROM jobs
+		         WHERE entity_id = ? AND job_type = ? AND status = ?
+		         ORDER BY updated_at DESC LIMIT 1
+		     )`, lessonId, constant.JobTypeTranscoder, constant.JobStatusCompleted).
+		Scan(tenant)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return tenant, nil
+}
+
+func NewRedactionRepo(db *gorm.DB) RedactionRepository {
+	return &redactionRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+	"worker-transcode/entities"
+)
+
+type RegenerationRepository interface {
+	CreateRegeneration(ctx context.Context, regeneration *entities.Regeneration) error
+	FindRegeneration(ctx context.Context, id uuid.UUID) (*entities.Regeneration, error)
+	FindRegenerationByJob(ctx context.Context, jobId uuid.UUID) (*entities.Regeneration, error)
+	// MarkRegenerated records the version the regeneration published.
+	MarkRegenerated(ctx context.Context, id uuid.UUID, replacedPlaylistKey, playlistKey string) error
+	// FindLessonVideo returns the master playlist the lesson plays, empty
+	// while it has none.
+	FindLessonVideo(ctx context.Context, lessonId uuid.UUID) (string, error)
+}
+
+type regenerationRepo struct {
+	db *gorm.DB
+}
+
+func (r *regenerationRepo) CreateRegeneration(ctx context.Context, regeneration *entities.Regeneration) error {
+	return r.db.WithContext(ctx).Create(regeneration).Error
+}
+
+func (r *regenerationRepo) FindRegeneration(ctx context.Context, id uuid.UUID) (*entities.Regeneration, error) {
+	regeneration := &entities.Regeneration{}
+	if err := r.db.WithContext(ctx).Where("id = ?", id).First(regeneration).Error; err != nil {
+		return nil, err
+	}
+	return regeneration, nil
+}
+
+func (r *regenerationRepo) FindRegenerationByJob(ctx context.Context, jobId uuid.UUID) (*entities.Regeneration, error) {
+	regeneration := &entities.Regeneration{}
+	if err := r.db.WithContext(ctx).Where("job_id = ?", jobId).First(regeneration).Error; err != nil {
+		return nil, err
+	}
+	return regeneration, nil
+}
+
+func (r *regenerationRepo) MarkRegenerated(ctx context.Context, id uuid.UUID, replacedPlaylistKey, playlistKey string) error {
+	return r.db.WithContext(ctx).Model(&entities.Regeneration{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"replaced_playlist_key": replacedPlaylistKey,
+			"playlist_key":          playlistKey,
+			"updated_at":            time.Now().UTC(),
+		}).Error
+}
+
+func (r *regenerationRepo) FindLessonVideo(ctx context.Context, lessonId uuid.UUID) (string, error) {
+	lesson := &entities.Lesson{}
+	if err := r.db.WithContext(ctx).Select("id", "video_url").Where("id = ?", lessonId).First(lesson).Error; err != nil {
+		return "", err
+	}
+	return lesson.VideoUrl, nil
+}
+
+func NewRegenerationRepo(db *gorm.DB) RegenerationRepository {
+	return &regenerationRepo{
+		db: db,
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 	"worker-transcode/constant"
+	"worker-transcode/dto"
 	"worker-transcode/entities"
 )
 
@@ -15,7 +16,21 @@ type JobRepository interface {
 	Transaction(ctx context.Context, callback func(ctx context.Context) error, opts ...*sql.TxOptions) error
 	GetDB() *gorm.DB
 	FindJobById(ctx context.Context, id uuid.UUID) (*entities.Job, error)
+	CreateJob(ctx context.Context, job *entities.Job) error
 	UpdateStatusJob(context context.Context, status constant.JobStatus, id uuid.UUID) error
+	CompleteJob(ctx context.Context, id uuid.UUID, outbox ...*entities.OutboxMessage) error
+	ClaimJob(ctx context.Context, id uuid.UUID, correlationId string, workerId *uuid.UUID) (bool, error)
+	UpdateJobPriority(ctx context.Context, id uuid.UUID, priority int) error
+	UpdateJobProgress(ctx context.Context, id uuid.UUID, progress int) error
+	UpdateJobPreset(ctx context.Context, id uuid.UUID, preset string, version int) error
+	FailJob(ctx context.Context, id uuid.UUID, errorClass constant.ErrorClass, errorKind constant.ErrorKind, message string) error
+	// RecordJobCrash keeps the post-mortem of the job's last ffmpeg run that
+	// exited abnormally.
+	RecordJobCrash(ctx context.Context, id uuid.UUID, crash *entities.EncoderCrash) error
+	// RecordJobHeartbeat records that the processing job is alive and in
+	// stage.
+	RecordJobHeartbeat(ctx context.Context, id uuid.UUID, stage string) error
+	SearchJobs(ctx context.Context, query dto.JobSearchQuery) ([]*entities.Job, error)
 	UpdateLessonVideoURL(ctx context.Context, lessonId uuid.UUID, url string) error
 	GetRecordingsByLessonId(ctx context.Context, lessonId uuid.UUID) ([]*entities.Recording, error)
 	GetRecordingChunksByLiveSessionId(ctx context.Context, liveSessionId uuid.UUID) ([]*entities.RecordingChunk, error)
@@ -47,6 +62,10 @@ func (r *repo) FindJobById(ctx context.Context, id uuid.UUID) (*entities.Job, er
 	return job, nil
 }
 
+func (r *repo) CreateJob(ctx context.Context, job *entities.Job) error {
+	return r.GetDB().Create(job).Error
+}
+
 func (r *repo) UpdateStatusJob(context context.Context, status constant.JobStatus, id uuid.UUID) error {
 	job := &entities.Job{}
 	err := r.GetDB().First(job, "id = ?", id).Error
@@ -61,6 +80,71 @@ func (r *repo) UpdateStatusJob(context context.Context, status constant.JobStatu
 	return nil
 }
 
+// CompleteJob marks the job completed and writes the outbox messages about
+// it in the same transaction, so they go out if and only if it completed.
+func (r *repo) CompleteJob(ctx context.Context, id uuid.UUID, outbox ...*entities.OutboxMessage) error {
+	return r.GetDB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&entities.Job{}).Where("id = ?", id).Update("status", constant.JobStatusCompleted).Error
+		if err != nil {
+			return err
+		}
+		if len(outbox) == 0 {
+			return nil
+		}
+		return tx.Create(outbox).Error
+	})
+}
+
+// ClaimJob moves a pending job to processing, reporting false when another
+// delivery of the same job got there first. The correlation ID of the winning
+// delivery is recorded so the row can be matched to its logs, and the worker
+// so the job can be released if that worker stops heartbeating.
+func (r *repo) ClaimJob(ctx context.Context, id uuid.UUID, correlationId string, workerId *uuid.UUID) (bool, error) {
+	updates := map[string]interface{}{
+		"status":         constant.JobStatusProcessing,
+		"correlation_id": gorm.Expr("COALESCE(NULLIF(?, ''), correlation_id)", correlationId),
+		"worker_id":      workerId,
+	}
+	result := r.GetDB().Model(&entities.Job{}).
+		Where("id = ? AND status = ?", id, constant.JobStatusPending).
+		Updates(updates)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *repo) UpdateJobPriority(ctx context.Context, id uuid.UUID, priority int) error {
+	return r.GetDB().Model(&entities.Job{}).Where("id = ?", id).Update("priority", priority).Error
+}
+
+func (r *repo) UpdateJobProgress(ctx context.Context, id uuid.UUID, progress int) error {
+	return r.GetDB().Model(&entities.Job{}).Where("id = ?", id).Update("progress", progress).Error
+}
+
+// UpdateJobPreset records the preset version encoding the job, so canary
+// versions can be compared with the stable one.
+func (r *repo) UpdateJobPreset(ctx context.Context, id uuid.UUID, preset string, version int) error {
+	updates := map[string]interface{}{
+		"preset":         preset,
+		"preset_version": version,
+	}
+	return r.GetDB().Model(&entities.Job{}).Where("id = ?", id).Updates(updates).Error
+}
+
+func (r *repo) RecordJobCrash(ctx context.Context, id uuid.UUID, crash *entities.EncoderCrash) error {
+	return r.GetDB().WithContext(ctx).Model(&entities.Job{}).Where("id = ?", id).Update("crash", crash).Error
+}
+
+func (r *repo) RecordJobHeartbeat(ctx context.Context, id uuid.UUID, stage string) error {
+	return r.GetDB().WithContext(ctx).Model(&entities.Job{}).
+		Where("id = ? AND status = ?", id, constant.JobStatusProcessing).
+		Updates(map[string]interface{}{
+			"stage":        stage,
+			"heartbeat_at": gorm.Expr("CURRENT_TIMESTAMP"),
+		}).Error
+}
+
 func NewRepo(db *sql.DB) JobRepository {
 	gormDB, _ := gorm.Open(postgres.New(postgres.Config{
 		Conn: db}),
@@ -68,6 +152,8 @@ func NewRepo(db *sql.DB) JobRepository {
 			Logger: logger.Default.LogMode(logger.Info),
 		},
 	)
+	recordOutcomes(gormDB)
+	injectLatency(gormDB)
 	return &repo{
 		db: gormDB,
 	}
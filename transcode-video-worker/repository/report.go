@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"time"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+)
+
+// ReportRepository aggregates jobs and job events for periodic reports. All
+// ranges are half-open, [from, to).
+type ReportRepository interface {
+	CountJobsByStatus(ctx context.Context, from, to time.Time) ([]dto.StatusCount, error)
+	TopErrorClasses(ctx context.Context, from, to time.Time, limit int) ([]dto.ErrorClassCount, error)
+	StageSeconds(ctx context.Context, from, to time.Time, stage string) (float64, error)
+	OutputBytesByTenant(ctx context.Context, from, to time.Time) ([]dto.TenantStorage, error)
+	TurnaroundPercentiles(ctx context.Context, from, to time.Time) (dto.DurationPercentiles, error)
+	ProcessingPercentiles(ctx context.Context, from, to time.Time) (dto.DurationPercentiles, error)
+	FailuresByStage(ctx context.Context, from, to time.Time) ([]dto.StageFailureCount, error)
+}
+
+type reportRepo struct {
+	db *gorm.DB
+}
+
+// CountJobsByStatus counts jobs that were last updated in the range, which
+// for finished jobs is when they completed or failed.
+func (r *reportRepo) CountJobsByStatus(ctx context.Context, from, to time.Time) ([]dto.StatusCount, error) {
+	var counts []dto.StatusCount
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT status, COUNT(*) AS count FROM jobs
+		     WHERE updated_at >= ? AND updated_at < ?
+		     GROUP BY status ORDER BY status`, from, to).
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+func (r *reportRepo) TopErrorClasses(ctx context.Context, from, to time.Time, limit int) ([]dto.ErrorClassCount, error) {
+	var counts []dto.ErrorClassCount
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT error_class, COUNT(*) AS count FROM jobs
+		     WHERE status = ? AND error_class IS NOT NULL AND updated_at >= ? AND updated_at < ?
+		     GROUP BY error_class ORDER BY count DESC, error_class LIMIT ?`, constant.JobStatusFailed, from, to, limit).
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+func (r *reportRepo) StageSeconds(ctx context.Context, from, to time.Time, stage string) (float64, error) {
+	var seconds float64
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT COALESCE(SUM((data->>'seconds')::float8), 0) FROM job_events
+		     WHERE event_type = ? AND stage = ? AND created_at >= ? AND created_at < ?`,
+			constant.JobEventStage, stage, from, to).
+		Scan(&seconds).Error
+	if err != nil {
+		return 0, err
+	}
+	return seconds, nil
+}
+
+// OutputBytesByTenant sums the renditions uploaded per tenant. Jobs without a
+// tenant are grouped under a NULL tenant_id.
+func (r *reportRepo) OutputBytesByTenant(ctx context.Context, from, to time.Time) ([]dto.TenantStorage, error) {
+	var storage []dto.TenantStorage
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT j.tenant_id, COUNT(*) AS jobs, COALESCE(SUM((e.data->>'bytes')::bigint), 0) AS bytes
+		     FROM job_events e JOIN jobs j ON j.id = e.job_id
+		     WHERE e.event_type = ? AND e.created_at >= ? AND e.created_at < ?
+		     GROUP BY j.tenant_id ORDER BY bytes DESC`, constant.JobEventOutput, from, to).
+		Scan(&storage).Error
+	if err != nil {
+		return nil, err
+	}
+	return storage, nil
+}
+
+// TurnaroundPercentiles measures completed jobs from creation to completion,
+// queue time included.
+func (r *reportRepo) TurnaroundPercentiles(ctx context.Context, from, to time.Time) (dto.DurationPercentiles, error) {
+	var percentiles dto.DurationPercentiles
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT COUNT(*) AS samples,
+		            COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM updated_at - created_at)), 0) AS p50,
+		            COALESCE(percentile_cont(0.95) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM updated_at - created_at)), 0) AS p95
+		     FROM jobs WHERE status = ? AND updated_at >= ? AND updated_at < ?`,
+			constant.JobStatusCompleted, from, to).
+		Scan(&percentiles).Error
+	return percentiles, err
+}
+
+// ProcessingPercentiles measures completed jobs from their last move to
+// PROCESSING to completion, using the status events on the timeline. Jobs
+// without both events are left out.
+func (r *reportRepo) ProcessingPercentiles(ctx context.Context, from, to time.Time) (dto.DurationPercentiles, error) {
+	var percentiles dto.DurationPercentiles
+	err := r.db.WithContext(ctx).
+		Raw(`WITH spans AS (
+		         SELECT e.job_id,
+		                MAX(e.created_at) FILTER (WHERE e.data->>'to' = ?) AS started,
+		                MAX(e.created_at) FILTER (WHERE e.data->>'to' = ?) AS finished
+		         FROM job_events e JOIN jobs j ON j.id = e.job_id
+		         WHERE e.event_type = ? AND j.status = ? AND j.updated_at >= ? AND j.updated_at < ?
+		         GROUP BY e.job_id
+		     )
+		     SELECT COUNT(*) AS samples,
+		            COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM finished - started)), 0) AS p50,
+		            COALESCE(percentile_cont(0.95) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM finished - started)), 0) AS p95
+		     FROM spans WHERE started IS NOT NULL AND finished > started`,
+			constant.JobStatusProcessing, constant.JobStatusCompleted, constant.JobEventStatus,
+			constant.JobStatusCompleted, from, to).
+		Scan(&percentiles).Error
+	return percentiles, err
+}
+
+// FailuresByStage counts stage events that recorded an error, which includes
+// attempts that were later retried successfully.
+func (r *reportRepo) FailuresByStage(ctx context.Context, from, to time.Time) ([]dto.StageFailureCount, error) {
+	var counts []dto.StageFailureCount
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT stage, COUNT(*) AS count FROM job_events
+		     WHERE event_type = ? AND data->>'error' IS NOT NULL AND created_at >= ? AND created_at < ?
+		     GROUP BY stage ORDER BY count DESC, stage`, constant.JobEventStage, from, to).
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+func NewReportRepo(db *gorm.DB) ReportRepository {
+	return &reportRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+	"worker-transcode/entities"
+)
+
+type SearchExportRepository interface {
+	// ListStaleLessons returns up to limit published lessons never exported,
+	// or whose media, captions or chapters changed since their last export.
+	ListStaleLessons(ctx context.Context, limit int) ([]uuid.UUID, error)
+	MarkExported(ctx context.Context, lessonId uuid.UUID, at time.Time) error
+}
+
+type searchExportRepo struct {
+	db *gorm.DB
+}
+
+func (r *searchExportRepo) ListStaleLessons(ctx context.Context, limit int) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT l.id FROM lessons l
+		     LEFT JOIN lesson_search_exports e ON e.lesson_id = l.id
+		     WHERE COALESCE(l.video_url, '') <> ''
+		       AND (e.exported_at IS NULL
+		            OR EXISTS (SELECT 1 FROM lesson_media m WHERE m.lesson_id = l.id AND m.updated_at > e.exported_at)
+		            OR EXISTS (SELECT 1 FROM lesson_captions c WHERE c.lesson_id = l.id AND c.updated_at > e.exported_at)
+		            OR EXISTS (SELECT 1 FROM lesson_chapters ch WHERE ch.lesson_id = l.id AND ch.created_at > e.exported_at))
+		     ORDER BY e.exported_at NULLS FIRST, l.id
+		     LIMIT ?`, limit).
+		Scan(&ids).Error
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (r *searchExportRepo) MarkExported(ctx context.Context, lessonId uuid.UUID, at time.Time) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "lesson_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"exported_at"}),
+	}).Create(&entities.SearchExport{LessonId: lessonId, ExportedAt: at}).Error
+}
+
+func NewSearchExportRepo(db *gorm.DB) SearchExportRepository {
+	return &searchExportRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"worker-transcode/constant"
+	"worker-transcode/entities"
+)
+
+type StorageRepository interface {
+	// RecordPackage counts a published package's classes into its course's
+	// and tenant's totals, once however often it is published. The course
+	// and tenant are looked up from the lesson and the job.
+	RecordPackage(ctx context.Context, classes []*entities.PackageStorage) error
+	// ReleasePackage takes a deleted package off the totals it was counted
+	// into, once however often it is deleted.
+	ReleasePackage(ctx context.Context, jobId uuid.UUID) error
+	ListUsage(ctx context.Context, scope constant.StorageScope, scopeId uuid.UUID) ([]*entities.StorageUsage, error)
+	// ListUncountedVersions lists the versions still in the bucket that were
+	// published before their storage was counted.
+	ListUncountedVersions(ctx context.Context) ([]*entities.VideoVersion, error)
+}
+
+type storageRepo struct {
+	db *gorm.DB
+}
+
+func (r *storageRepo) RecordPackage(ctx context.Context, classes []*entities.PackageStorage) error {
+	if len(classes) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var owner struct {
+			CourseId *uuid.UUID
+			TenantId *uuid.UUID
+		}
+		err := tx.Raw(`SELECT (SELECT course_id FROM lessons WHERE id = ?) AS course_id,
+		                      (SELECT tenant_id FROM jobs WHERE id = ?) AS tenant_id`,
+			classes[0].LessonId, classes[0].JobId).
+			Scan(&owner).Error
+		if err != nil {
+			return err
+		}
+		for _, class := range classes {
+			class.CourseId, class.TenantId = owner.CourseId, owner.TenantId
+		}
+
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(classes)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		for _, class := range classes {
+			if err := addUsage(tx, class, 1); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *storageRepo) ReleasePackage(ctx context.Context, jobId uuid.UUID) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var classes []*entities.PackageStorage
+		err := tx.Raw(`UPDATE package_storage SET released_at = now()
+		               WHERE job_id = ? AND released_at IS NULL
+		               RETURNING *`, jobId).
+			Scan(&classes).Error
+		if err != nil {
+			return err
+		}
+		for _, class := range classes {
+			if err := addUsage(tx, class, -1); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// addUsage adds a package's class to, for sign -1 takes it off, the totals of
+// its course and tenant.
+func addUsage(tx *gorm.DB, class *entities.PackageStorage, sign int64) error {
+	scopes := map[constant.StorageScope]*uuid.UUID{
+		constant.StorageScopeCourse: class.CourseId,
+		constant.StorageScopeTenant: class.TenantId,
+	}
+	for scope, id := range scopes {
+		if id == nil {
+			continue
+		}
+		err := tx.Exec(`INSERT INTO storage_usage (scope, scope_id, rendition_class, bytes, objects, updated_at)
+		                VALUES (?, ?, ?, ?, ?, now())
+		                ON CONFLICT (scope, scope_id, rendition_class) DO UPDATE
+		                SET bytes = storage_usage.bytes + EXCLUDED.bytes,
+		                    objects = storage_usage.objects + EXCLUDED.objects,
+		                    updated_at = now()`,
+			scope, *id, class.RenditionClass, sign*class.Bytes, sign*int64(class.Objects)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *storageRepo) ListUsage(ctx context.Context, scope constant.StorageScope, scopeId uuid.UUID) ([]*entities.StorageUsage, error) {
+	var usage []*entities.StorageUsage
+	err := r.db.WithContext(ctx).
+		Where("scope = ? AND scope_id = ?", scope, scopeId).
+		Order("rendition_class ASC").
+		Find(&usage).Error
+	if err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+func (r *storageRepo) ListUncountedVersions(ctx context.Context) ([]*entities.VideoVersion, error) {
+	var versions []*entities.VideoVersion
+	err := r.db.WithContext(ctx).
+		Where("status <> ?", constant.VideoVersionStatusDeleted).
+		Where("NOT EXISTS (SELECT 1 FROM package_storage s WHERE s.job_id = lesson_video_versions.job_id)").
+		Order("created_at ASC").
+		Find(&versions).Error
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+func NewStorageRepo(db *gorm.DB) StorageRepository {
+	return &storageRepo{
+		db: db,
+	}
+}
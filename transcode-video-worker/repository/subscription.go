@@ -0,0 +1,219 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"time"
+	"worker-transcode/constant"
+	"worker-transcode/entities"
+)
+
+type SubscriptionRepository interface {
+	CreateSubscription(ctx context.Context, subscription *entities.EventSubscription) error
+	FindSubscription(ctx context.Context, id uuid.UUID) (*entities.EventSubscription, error)
+	ListSubscriptions(ctx context.Context, tenantId uuid.UUID) ([]*entities.EventSubscription, error)
+	ListActiveSubscriptions(ctx context.Context) ([]*entities.EventSubscription, error)
+	UpdateSubscription(ctx context.Context, subscription *entities.EventSubscription) error
+	// DeleteSubscription removes the subscription with its deliveries.
+	DeleteSubscription(ctx context.Context, id uuid.UUID) error
+	// ListSubscribedEvents returns up to limit of the events the
+	// subscription wants that happened from its cursor until until, oldest
+	// first: the tenant's jobs that completed or failed and its courses that
+	// became ready.
+	ListSubscribedEvents(ctx context.Context, subscription *entities.EventSubscription, until time.Time, limit int) ([]*entities.SubscribedEvent, error)
+	// SaveDeliveries creates the deliveries of events not already delivered
+	// to the subscription and moves its cursor to cursor, together.
+	SaveDeliveries(ctx context.Context, subscriptionId uuid.UUID, deliveries []*entities.EventDelivery, cursor time.Time) error
+	// PendingDeliveries lists up to limit deliveries due to be tried, oldest
+	// first. Those of inactive subscriptions wait until they're active.
+	PendingDeliveries(ctx context.Context, limit int) ([]*entities.EventDelivery, error)
+	// UpdateDelivery records how the delivery's last attempt went.
+	UpdateDelivery(ctx context.Context, delivery *entities.EventDelivery) error
+	FindDelivery(ctx context.Context, id uuid.UUID) (*entities.EventDelivery, error)
+	// ListDeliveries returns the subscription's latest deliveries, up to
+	// limit, only those of status when it's set.
+	ListDeliveries(ctx context.Context, subscriptionId uuid.UUID, status *constant.DeliveryStatus, limit int) ([]*entities.EventDelivery, error)
+	// DeleteDeliveries removes the deliveries made before that aren't
+	// pending.
+	DeleteDeliveries(ctx context.Context, before time.Time) error
+}
+
+type subscriptionRepo struct {
+	db *gorm.DB
+}
+
+func (r *subscriptionRepo) CreateSubscription(ctx context.Context, subscription *entities.EventSubscription) error {
+	return r.db.WithContext(ctx).Create(subscription).Error
+}
+
+func (r *subscriptionRepo) FindSubscription(ctx context.Context, id uuid.UUID) (*entities.EventSubscription, error) {
+	subscription := &entities.EventSubscription{}
+	if err := r.db.WithContext(ctx).First(subscription, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+func (r *subscriptionRepo) ListSubscriptions(ctx context.Context, tenantId uuid.UUID) ([]*entities.EventSubscription, error) {
+	var subscriptions []*entities.EventSubscription
+	err := r.db.WithContext(ctx).Where("tenant_id = ?", tenantId).Order("created_at").Find(&subscriptions).Error
+	if err != nil {
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+func (r *subscriptionRepo) ListActiveSubscriptions(ctx context.Context) ([]*entities.EventSubscription, error) {
+	var subscriptions []*entities.EventSubscription
+	if err := r.db.WithContext(ctx).Where("active").Order("created_at").Find(&subscriptions).Error; err != nil {
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+func (r *subscriptionRepo) UpdateSubscription(ctx context.Context, subscription *entities.EventSubscription) error {
+	subscription.UpdatedAt = time.Now()
+	return r.db.WithContext(ctx).Model(subscription).
+		Select("url", "events", "course_ids", "job_types", "secret", "active", "updated_at").
+		Updates(subscription).Error
+}
+
+func (r *subscriptionRepo) DeleteSubscription(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Delete(&entities.EventSubscription{}, "id = ?", id).Error
+}
+
+func (r *subscriptionRepo) ListSubscribedEvents(ctx context.Context, subscription *entities.EventSubscription, until time.Time, limit int) ([]*entities.SubscribedEvent, error) {
+	wants := func(event constant.SubscriptionEvent) bool {
+		for _, e := range subscription.Events {
+			if e == string(event) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var parts []string
+	var args []interface{}
+	var statuses []string
+	if wants(constant.SubscriptionEventJobCompleted) {
+		statuses = append(statuses, string(constant.JobStatusCompleted))
+	}
+	if wants(constant.SubscriptionEventJobFailed) {
+		statuses = append(statuses, string(constant.JobStatusFailed), string(constant.JobStatusQualityFailed))
+	}
+	if len(statuses) > 0 {
+		query := `SELECT CASE WHEN j.status = ? THEN ?::text ELSE ?::text END AS event_type,
+		                 j.id AS job_id, l.course_id, j.updated_at AS occurred_at
+		          FROM jobs j
+		          LEFT JOIN lessons l ON l.id = j.entity_id
+		          WHERE j.tenant_id = ? AND j.status IN ? AND j.updated_at >= ? AND j.updated_at < ?`
+		args = append(args, constant.JobStatusCompleted, constant.SubscriptionEventJobCompleted, constant.SubscriptionEventJobFailed,
+			subscription.TenantId, statuses, subscription.CursorAt, until)
+		if len(subscription.JobTypes) > 0 {
+			query += ` AND j.job_type IN ?`
+			args = append(args, []string(subscription.JobTypes))
+		}
+		if len(subscription.CourseIds) > 0 {
+			query += ` AND l.course_id IN ?`
+			args = append(args, []string(subscription.CourseIds))
+		}
+		parts = append(parts, query)
+	}
+	if wants(constant.SubscriptionEventCourseReady) {
+		// A course is the tenant's when a job of the tenant's transcoded
+		// one of its lessons.
+		query := `SELECT ?::text AS event_type, NULL::uuid AS job_id, r.course_id, r.ready_at AS occurred_at
+		          FROM course_readiness r
+		          WHERE r.ready_at >= ? AND r.ready_at < ?
+		            AND EXISTS (SELECT 1 FROM lessons l JOIN jobs j ON j.entity_id = l.id
+		                        WHERE l.course_id = r.course_id AND j.tenant_id = ?)`
+		args = append(args, constant.SubscriptionEventCourseReady, subscription.CursorAt, until, subscription.TenantId)
+		if len(subscription.CourseIds) > 0 {
+			query += ` AND r.course_id IN ?`
+			args = append(args, []string(subscription.CourseIds))
+		}
+		parts = append(parts, query)
+	}
+	if len(parts) == 0 {
+		return nil, nil
+	}
+
+	var events []*entities.SubscribedEvent
+	err := r.db.WithContext(ctx).
+		Raw(strings.Join(parts, " UNION ALL ")+` ORDER BY occurred_at LIMIT ?`, append(args, limit)...).
+		Scan(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (r *subscriptionRepo) SaveDeliveries(ctx context.Context, subscriptionId uuid.UUID, deliveries []*entities.EventDelivery, cursor time.Time) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if len(deliveries) > 0 {
+			err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "subscription_id"}, {Name: "event_id"}},
+				DoNothing: true,
+			}).Create(deliveries).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Model(&entities.EventSubscription{}).Where("id = ?", subscriptionId).UpdateColumn("cursor_at", cursor).Error
+	})
+}
+
+func (r *subscriptionRepo) PendingDeliveries(ctx context.Context, limit int) ([]*entities.EventDelivery, error) {
+	var deliveries []*entities.EventDelivery
+	err := r.db.WithContext(ctx).
+		Where("status = ? AND next_attempt_at <= ?", constant.DeliveryStatusPending, time.Now()).
+		Where("subscription_id IN (SELECT id FROM event_subscriptions WHERE active)").
+		Order("next_attempt_at").
+		Limit(limit).
+		Find(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func (r *subscriptionRepo) UpdateDelivery(ctx context.Context, delivery *entities.EventDelivery) error {
+	return r.db.WithContext(ctx).Model(delivery).
+		Select("status", "attempts", "response_code", "last_error", "next_attempt_at", "delivered_at").
+		Updates(delivery).Error
+}
+
+func (r *subscriptionRepo) FindDelivery(ctx context.Context, id uuid.UUID) (*entities.EventDelivery, error) {
+	delivery := &entities.EventDelivery{}
+	if err := r.db.WithContext(ctx).First(delivery, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+func (r *subscriptionRepo) ListDeliveries(ctx context.Context, subscriptionId uuid.UUID, status *constant.DeliveryStatus, limit int) ([]*entities.EventDelivery, error) {
+	db := r.db.WithContext(ctx).Where("subscription_id = ?", subscriptionId)
+	if status != nil {
+		db = db.Where("status = ?", *status)
+	}
+	var deliveries []*entities.EventDelivery
+	if err := db.Order("created_at DESC").Limit(limit).Find(&deliveries).Error; err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func (r *subscriptionRepo) DeleteDeliveries(ctx context.Context, before time.Time) error {
+	return r.db.WithContext(ctx).
+		Where("created_at < ? AND status <> ?", before, constant.DeliveryStatusPending).
+		Delete(&entities.EventDelivery{}).Error
+}
+
+func NewSubscriptionRepo(db *gorm.DB) SubscriptionRepository {
+	return &subscriptionRepo{
+		db: db,
+	}
+}
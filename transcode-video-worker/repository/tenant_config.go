@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+	"worker-transcode/constant"
+	"worker-transcode/entities"
+)
+
+type TenantConfigRepository interface {
+	FindTenantConfig(ctx context.Context, tenantId uuid.UUID) (*entities.TenantConfig, error)
+	SaveTenantConfig(ctx context.Context, config *entities.TenantConfig) error
+	// CountProcessingJobs counts the tenant's jobs being processed now, on
+	// any worker.
+	CountProcessingJobs(ctx context.Context, tenantId uuid.UUID) (int64, error)
+	FindEncodingUsage(ctx context.Context, tenantId uuid.UUID, period time.Time) (*entities.EncodingUsage, error)
+	// AddEncodingUsage adds a job's encoded seconds to the tenant's usage in
+	// the period and returns the usage with them.
+	AddEncodingUsage(ctx context.Context, tenantId uuid.UUID, period time.Time, seconds float64) (*entities.EncodingUsage, error)
+	// MarkUsageWarned and MarkUsageExceeded stamp the period's usage with the
+	// quota event being sent, reporting false when it already was.
+	MarkUsageWarned(ctx context.Context, tenantId uuid.UUID, period time.Time) (bool, error)
+	MarkUsageExceeded(ctx context.Context, tenantId uuid.UUID, period time.Time) (bool, error)
+}
+
+type tenantConfigRepo struct {
+	db *gorm.DB
+}
+
+func (r *tenantConfigRepo) FindTenantConfig(ctx context.Context, tenantId uuid.UUID) (*entities.TenantConfig, error) {
+	config := &entities.TenantConfig{}
+	err := r.db.WithContext(ctx).First(config, "tenant_id = ?", tenantId).Error
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (r *tenantConfigRepo) SaveTenantConfig(ctx context.Context, config *entities.TenantConfig) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "tenant_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"preset", "max_concurrent_jobs", "encoding_minutes_quota", "playback_ttl", "region", "features", "updated_at"}),
+	}).Create(config).Error
+}
+
+func (r *tenantConfigRepo) CountProcessingJobs(ctx context.Context, tenantId uuid.UUID) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&entities.Job{}).
+		Where("tenant_id = ? AND status = ?", tenantId, constant.JobStatusProcessing).
+		Count(&count).Error
+	return count, err
+}
+
+func (r *tenantConfigRepo) FindEncodingUsage(ctx context.Context, tenantId uuid.UUID, period time.Time) (*entities.EncodingUsage, error) {
+	usage := &entities.EncodingUsage{}
+	err := r.db.WithContext(ctx).First(usage, "tenant_id = ? AND period = ?", tenantId, period).Error
+	if err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+func (r *tenantConfigRepo) AddEncodingUsage(ctx context.Context, tenantId uuid.UUID, period time.Time, seconds float64) (*entities.EncodingUsage, error) {
+	usage := &entities.EncodingUsage{}
+	err := r.db.WithContext(ctx).
+		Raw(`INSERT INTO tenant_encoding_usage (tenant_id, period, seconds, jobs, updated_at)
+		     VALUES (?, ?, ?, 1, now())
+		     ON CONFLICT (tenant_id, period) DO UPDATE
+		     SET seconds = tenant_encoding_usage.seconds + EXCLUDED.seconds,
+		         jobs = tenant_encoding_usage.jobs + 1,
+		         updated_at = now()
+		     RETURNING *`, tenantId, period, seconds).
+		Scan(usage).Error
+	if err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+func (r *tenantConfigRepo) MarkUsageWarned(ctx context.Context, tenantId uuid.UUID, period time.Time) (bool, error) {
+	return r.markUsage(ctx, tenantId, period, "warned_at")
+}
+
+func (r *tenantConfigRepo) MarkUsageExceeded(ctx context.Context, tenantId uuid.UUID, period time.Time) (bool, error) {
+	return r.markUsage(ctx, tenantId, period, "exceeded_at")
+}
+
+func (r *tenantConfigRepo) markUsage(ctx context.Context, tenantId uuid.UUID, period time.Time, column string) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&entities.EncodingUsage{}).
+		Where("tenant_id = ? AND period = ? AND "+column+" IS NULL", tenantId, period).
+		Update(column, time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+func NewTenantConfigRepo(db *gorm.DB) TenantConfigRepository {
+	return &tenantConfigRepo{
+		db: db,
+	}
+}
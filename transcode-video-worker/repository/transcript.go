@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/search"
+)
+
+// TranscriptRepository is the postgres search backend. The simple text search
+// configuration is used because lessons aren't all in one language.
+type TranscriptRepository interface {
+	search.Index
+	FindLessonCourse(ctx context.Context, lessonId uuid.UUID) (uuid.UUID, error)
+	// ListCaptions lists the lesson's caption tracks, the newest first.
+	ListCaptions(ctx context.Context, lessonId uuid.UUID) ([]*entities.LessonCaption, error)
+	// SaveSpeakers replaces who speaks when in the lesson's video.
+	SaveSpeakers(ctx context.Context, speakers *entities.LessonSpeakers) error
+	FindSpeakers(ctx context.Context, lessonId uuid.UUID) (*entities.LessonSpeakers, error)
+	DeleteSpeakers(ctx context.Context, lessonId uuid.UUID) error
+}
+
+type transcriptRepo struct {
+	db *gorm.DB
+}
+
+func (r *transcriptRepo) Replace(ctx context.Context, transcript search.Transcript) error {
+	cues := make([]*entities.TranscriptCue, 0, len(transcript.Cues))
+	for _, cue := range transcript.Cues {
+		var speaker *string
+		if cue.Speaker != "" {
+			speaker = &cue.Speaker
+		}
+		cues = append(cues, &entities.TranscriptCue{
+			CourseId:     transcript.CourseId,
+			LessonId:     transcript.LessonId,
+			Language:     transcript.Language,
+			StartSeconds: cue.Start,
+			EndSeconds:   cue.End,
+			Text:         cue.Text,
+			Speaker:      speaker,
+		})
+	}
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("lesson_id = ?", transcript.LessonId).Delete(&entities.TranscriptCue{}).Error; err != nil {
+			return err
+		}
+		if len(cues) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(cues, 500).Error
+	})
+}
+
+func (r *transcriptRepo) Search(ctx context.Context, courseId uuid.UUID, query string, limit int) ([]search.Hit, error) {
+	var hits []search.Hit
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT t.lesson_id, t.language, t.start_seconds AS start, t.end_seconds AS "end", t.text, COALESCE(t.speaker, '') AS speaker,
+		            ts_headline('simple', t.text, q, 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true') AS snippet,
+		            ts_rank(t.text_search, q) AS score
+		     FROM transcript_cues t, websearch_to_tsquery('simple', ?) q
+		     WHERE t.course_id = ? AND t.text_search @@ q
+		     ORDER BY score DESC, t.lesson_id, t.start_seconds
+		     LIMIT ?`, query, courseId, limit).
+		Scan(&hits).Error
+	if err != nil {
+		return nil, err
+	}
+	return hits, nil
+}
+
+func (r *transcriptRepo) FindLessonCourse(ctx context.Context, lessonId uuid.UUID) (uuid.UUID, error) {
+	var courseId uuid.UUID
+	result := r.db.WithContext(ctx).Raw(`SELECT course_id FROM lessons WHERE id = ?`, lessonId).Scan(&courseId)
+	if result.Error != nil {
+		return uuid.Nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return uuid.Nil, gorm.ErrRecordNotFound
+	}
+	return courseId, nil
+}
+
+func (r *transcriptRepo) ListCaptions(ctx context.Context, lessonId uuid.UUID) ([]*entities.LessonCaption, error) {
+	var captions []*entities.LessonCaption
+	err := r.db.WithContext(ctx).
+		Where("lesson_id = ?", lessonId).
+		Order("updated_at DESC").
+		Find(&captions).Error
+	if err != nil {
+		return nil, err
+	}
+	return captions, nil
+}
+
+func (r *transcriptRepo) SaveSpeakers(ctx context.Context, speakers *entities.LessonSpeakers) error {
+	speakers.UpdatedAt = time.Now()
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "lesson_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"job_id", "speakers", "turns", "updated_at"}),
+	}).Create(speakers).Error
+}
+
+func (r *transcriptRepo) FindSpeakers(ctx context.Context, lessonId uuid.UUID) (*entities.LessonSpeakers, error) {
+	speakers := &entities.LessonSpeakers{}
+	if err := r.db.WithContext(ctx).First(speakers, "lesson_id = ?", lessonId).Error; err != nil {
+		return nil, err
+	}
+	return speakers, nil
+}
+
+func (r *transcriptRepo) DeleteSpeakers(ctx context.Context, lessonId uuid.UUID) error {
+	return r.db.WithContext(ctx).Delete(&entities.LessonSpeakers{}, "lesson_id = ?", lessonId).Error
+}
+
+func NewTranscriptRepo(db *gorm.DB) TranscriptRepository {
+	return &transcriptRepo{
+		db: db,
+	}
+}
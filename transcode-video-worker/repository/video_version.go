@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+	"worker-transcode/constant"
+	"worker-transcode/entities"
+)
+
+type VideoVersionRepository interface {
+	// SaveVersion registers a job's package, once however often the job is
+	// delivered.
+	SaveVersion(ctx context.Context, version *entities.VideoVersion) error
+	// PublishVersion points the lesson at the version of a job created at
+	// since, retaining the one it replaces until retainUntil, unless the
+	// lesson's package is current since a later time. The version is then
+	// retained itself, and PublishVersion returns false.
+	PublishVersion(ctx context.Context, version *entities.VideoVersion, since, retainUntil time.Time) (bool, error)
+	// ActivateVersion points the lesson at the version whatever it plays,
+	// retaining the one it replaces until retainUntil.
+	ActivateVersion(ctx context.Context, version *entities.VideoVersion, retainUntil time.Time) error
+	FindPackage(ctx context.Context, lessonId uuid.UUID) (*entities.LessonPackage, error)
+	FindJobVersion(ctx context.Context, jobId uuid.UUID) (*entities.VideoVersion, error)
+	FindVersion(ctx context.Context, lessonId, id uuid.UUID) (*entities.VideoVersion, error)
+	ListVersions(ctx context.Context, lessonId uuid.UUID) ([]*entities.VideoVersion, error)
+	ListExpiredVersions(ctx context.Context, before time.Time) ([]*entities.VideoVersion, error)
+	MarkVersionDeleted(ctx context.Context, id uuid.UUID) error
+}
+
+type videoVersionRepo struct {
+	db *gorm.DB
+}
+
+func (r *videoVersionRepo) SaveVersion(ctx context.Context, version *entities.VideoVersion) error {
+	return r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "job_id"}}, DoNothing: true}).
+		Create(version).Error
+}
+
+func (r *videoVersionRepo) PublishVersion(ctx context.Context, version *entities.VideoVersion, since, retainUntil time.Time) (bool, error) {
+	current := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// A redelivered job's version was saved the first time.
+		if err := tx.Where("job_id = ?", version.JobId).First(version).Error; err != nil {
+			return err
+		}
+		// The manifest row is claimed in one statement, so of two jobs
+		// publishing at once the later created wins whichever commits first.
+		result := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "lesson_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"version_id", "job_id", "playlist_key", "content_hash", "preset_version", "current_since", "updated_at"}),
+			Where: clause.Where{Exprs: []clause.Expression{
+				clause.Expr{SQL: "lesson_packages.current_since <= EXCLUDED.current_since"},
+			}},
+		}).Create(lessonPackage(version, since))
+		if result.Error != nil {
+			return result.Error
+		}
+		if current = result.RowsAffected > 0; current {
+			return activate(tx, version, retainUntil)
+		}
+		return tx.Model(&entities.VideoVersion{}).
+			Where("id = ? AND status <> ?", version.ID, constant.VideoVersionStatusActive).
+			Updates(map[string]interface{}{
+				"status":      constant.VideoVersionStatusRetained,
+				"replaced_at": gorm.Expr("CURRENT_TIMESTAMP"),
+				"expires_at":  retainUntil,
+			}).Error
+	})
+	return current, err
+}
+
+func (r *videoVersionRepo) ActivateVersion(ctx context.Context, version *entities.VideoVersion, retainUntil time.Time) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "lesson_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"version_id", "job_id", "playlist_key", "content_hash", "preset_version", "current_since", "updated_at"}),
+		}).Create(lessonPackage(version, time.Now().UTC())).Error
+		if err != nil {
+			return err
+		}
+		return activate(tx, version, retainUntil)
+	})
+}
+
+// activate makes the version the lesson's active one and its video_url.
+func activate(tx *gorm.DB, version *entities.VideoVersion, retainUntil time.Time) error {
+	err := tx.Model(&entities.VideoVersion{}).
+		Where("lesson_id = ? AND status = ? AND job_id <> ?", version.LessonId, constant.VideoVersionStatusActive, version.JobId).
+		Updates(map[string]interface{}{
+			"status":      constant.VideoVersionStatusRetained,
+			"replaced_at": gorm.Expr("CURRENT_TIMESTAMP"),
+			"expires_at":  retainUntil,
+		}).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Model(&entities.VideoVersion{}).
+		Where("job_id = ?", version.JobId).
+		Updates(map[string]interface{}{
+			"status":      constant.VideoVersionStatusActive,
+			"replaced_at": nil,
+			"expires_at":  nil,
+		}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&entities.Lesson{}).Where("id = ?", version.LessonId).Update("video_url", version.PlaylistKey).Error
+}
+
+func lessonPackage(version *entities.VideoVersion, since time.Time) *entities.LessonPackage {
+	return &entities.LessonPackage{
+		LessonId:      version.LessonId,
+		VersionId:     version.ID,
+		JobId:         version.JobId,
+		PlaylistKey:   version.PlaylistKey,
+		ContentHash:   version.ContentHash,
+		PresetVersion: version.PresetVersion,
+		CurrentSince:  since,
+		UpdatedAt:     time.Now().UTC(),
+	}
+}
+
+func (r *videoVersionRepo) FindPackage(ctx context.Context, lessonId uuid.UUID) (*entities.LessonPackage, error) {
+	var current entities.LessonPackage
+	if err := r.db.WithContext(ctx).Where("lesson_id = ?", lessonId).First(&current).Error; err != nil {
+		return nil, err
+	}
+	return &current, nil
+}
+
+func (r *videoVersionRepo) FindJobVersion(ctx context.Context, jobId uuid.UUID) (*entities.VideoVersion, error) {
+	var version entities.VideoVersion
+	if err := r.db.WithContext(ctx).Where("job_id = ?", jobId).First(&version).Error; err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
+
+func (r *videoVersionRepo) FindVersion(ctx context.Context, lessonId, id uuid.UUID) (*entities.VideoVersion, error) {
+	var version entities.VideoVersion
+	if err := r.db.WithContext(ctx).Where("id = ? AND lesson_id = ?", id, lessonId).First(&version).Error; err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
+
+func (r *videoVersionRepo) ListVersions(ctx context.Context, lessonId uuid.UUID) ([]*entities.VideoVersion, error) {
+	var versions []*entities.VideoVersion
+	err := r.db.WithContext(ctx).Where("lesson_id = ?", lessonId).Order("created_at DESC").Find(&versions).Error
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+func (r *videoVersionRepo) ListExpiredVersions(ctx context.Context, before time.Time) ([]*entities.VideoVersion, error) {
+	var versions []*entities.VideoVersion
+	err := r.db.WithContext(ctx).
+		Where("status = ? AND expires_at < ?", constant.VideoVersionStatusRetained, before).
+		Order("expires_at ASC").
+		Find(&versions).Error
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// MarkVersionDeleted records that a retained version's objects are gone. It
+// leaves a version that was rolled back to in the meantime alone.
+func (r *videoVersionRepo) MarkVersionDeleted(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Model(&entities.VideoVersion{}).
+		Where("id = ? AND status = ?", id, constant.VideoVersionStatusRetained).
+		Update("status", constant.VideoVersionStatusDeleted).Error
+}
+
+func NewVideoVersionRepo(db *gorm.DB) VideoVersionRepository {
+	return &videoVersionRepo{
+		db: db,
+	}
+}
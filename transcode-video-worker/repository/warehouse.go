@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+	"worker-transcode/constant"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/warehouse"
+)
+
+// warehouseJobStatuses are the statuses a job is exported in once it
+// reaches them. A failed job that is retried is exported again when it
+// finishes again.
+var warehouseJobStatuses = []constant.JobStatus{constant.JobStatusCompleted, constant.JobStatusFailed}
+
+// WarehouseRepository reads the rows of the analytics warehouse's datasets
+// that changed in [from, to), each dataset by the time its rows last
+// changed.
+type WarehouseRepository interface {
+	FindExport(ctx context.Context, dataset constant.WarehouseDataset) (*entities.WarehouseExport, error)
+	SaveExport(ctx context.Context, export *entities.WarehouseExport) error
+	// FirstChange returns when the dataset's oldest row changed, nil when it
+	// has none, for the first export of a dataset to start from.
+	FirstChange(ctx context.Context, dataset constant.WarehouseDataset) (*time.Time, error)
+	ListJobs(ctx context.Context, from, to time.Time) ([]warehouse.Job, error)
+	ListRenditions(ctx context.Context, from, to time.Time) ([]warehouse.Rendition, error)
+	ListQCFlags(ctx context.Context, from, to time.Time) ([]warehouse.QCFlag, error)
+	ListEncodingUsage(ctx context.Context, from, to time.Time) ([]warehouse.EncodingUsage, error)
+	ListStorageUsage(ctx context.Context, from, to time.Time) ([]warehouse.StorageUsage, error)
+}
+
+type warehouseRepo struct {
+	db *gorm.DB
+}
+
+func (r *warehouseRepo) FindExport(ctx context.Context, dataset constant.WarehouseDataset) (*entities.WarehouseExport, error) {
+	export := &entities.WarehouseExport{}
+	if err := r.db.WithContext(ctx).Where("dataset = ?", dataset).First(export).Error; err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+func (r *warehouseRepo) SaveExport(ctx context.Context, export *entities.WarehouseExport) error {
+	export.UpdatedAt = time.Now()
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "dataset"}},
+		DoUpdates: clause.AssignmentColumns([]string{"exported_through", "updated_at"}),
+	}).Create(export).Error
+}
+
+func (r *warehouseRepo) FirstChange(ctx context.Context, dataset constant.WarehouseDataset) (*time.Time, error) {
+	db := r.db.WithContext(ctx)
+	switch dataset {
+	case constant.WarehouseDatasetJobs:
+		db = db.Raw("SELECT MIN(updated_at) FROM jobs WHERE status IN ?", warehouseJobStatuses)
+	case constant.WarehouseDatasetRenditions:
+		db = db.Raw("SELECT MIN(created_at) FROM job_events WHERE event_type = ?", constant.JobEventOutput)
+	case constant.WarehouseDatasetQC:
+		db = db.Raw("SELECT MIN(created_at) FROM lesson_qc_flags")
+	case constant.WarehouseDatasetUsage:
+		db = db.Raw("SELECT MIN(updated_at) FROM tenant_encoding_usage")
+	case constant.WarehouseDatasetStorage:
+		db = db.Raw("SELECT MIN(updated_at) FROM storage_usage")
+	}
+	var first *time.Time
+	if err := db.Scan(&first).Error; err != nil {
+		return nil, err
+	}
+	return first, nil
+}
+
+func (r *warehouseRepo) ListJobs(ctx context.Context, from, to time.Time) ([]warehouse.Job, error) {
+	var jobs []warehouse.Job
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT j.id, j.job_type, j.status, j.entity_id, j.tenant_id, j.worker_id, j.sla_class, j.priority,
+		            j.preset, j.preset_version, j.error_class, j.error_kind, j.source_seconds,
+		            (o.data->>'bytes')::bigint AS output_bytes, o.data->>'video_codec' AS video_codec,
+		            EXTRACT(EPOCH FROM j.updated_at - j.created_at) AS turnaround_seconds,
+		            j.created_at, j.updated_at
+		     FROM jobs j
+		     LEFT JOIN LATERAL (
+		         SELECT data FROM job_events
+		         WHERE job_id = j.id AND event_type = ?
+		         ORDER BY created_at DESC LIMIT 1
+		     ) o ON true
+		     WHERE j.status IN ? AND j.updated_at >= ? AND j.updated_at < ?
+		     ORDER BY j.updated_at, j.id`,
+			constant.JobEventOutput, warehouseJobStatuses, from, to).
+		Scan(&jobs).Error
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// ListRenditions lists the rungs of the packages output in the window, each
+// with the quality scores of its height when they were taken.
+func (r *warehouseRepo) ListRenditions(ctx context.Context, from, to time.Time) ([]warehouse.Rendition, error) {
+	var renditions []warehouse.Rendition
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT e.job_id, j.entity_id AS lesson_id, j.tenant_id,
+		            e.data->>'preset' AS preset, (e.data->>'preset_version')::int AS preset_version,
+		            e.data->>'video_codec' AS video_codec,
+		            (r->>'width')::int AS width, (r->>'height')::int AS height, r->>'bitrate' AS bitrate,
+		            COALESCE(NULLIF(r->>'segment_format', ''), 'ts') AS segment_format,
+		            NULLIF(r->>'container', '') AS container,
+		            q.average_kbps, q.vmaf, q.vmaf_min, q.vmaf_harmonic,
+		            e.created_at
+		     FROM job_events e
+		     JOIN jobs j ON j.id = e.job_id
+		     CROSS JOIN LATERAL jsonb_array_elements(e.data->'renditions') r
+		     LEFT JOIN LATERAL (
+		         SELECT average_kbps, vmaf, vmaf_min, vmaf_harmonic FROM rendition_quality_scores
+		         WHERE job_id = e.job_id AND height = (r->>'height')::int
+		         ORDER BY created_at DESC LIMIT 1
+		     ) q ON true
+		     WHERE e.event_type = ? AND e.created_at >= ? AND e.created_at < ?
+		     ORDER BY e.created_at, e.job_id, height`,
+			constant.JobEventOutput, from, to).
+		Scan(&renditions).Error
+	if err != nil {
+		return nil, err
+	}
+	return renditions, nil
+}
+
+// ListQCFlags lists the flags raised or reviewed in the window.
+func (r *warehouseRepo) ListQCFlags(ctx context.Context, from, to time.Time) ([]warehouse.QCFlag, error) {
+	var flags []warehouse.QCFlag
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT id, lesson_id, job_id, qc_check AS "check", status,
+		            jsonb_array_length(matches) AS matches, reviewed_by, created_at,
+		            COALESCE(reviewed_at, created_at) AS changed_at
+		     FROM lesson_qc_flags
+		     WHERE COALESCE(reviewed_at, created_at) >= ? AND COALESCE(reviewed_at, created_at) < ?
+		     ORDER BY changed_at, id`, from, to).
+		Scan(&flags).Error
+	if err != nil {
+		return nil, err
+	}
+	return flags, nil
+}
+
+func (r *warehouseRepo) ListEncodingUsage(ctx context.Context, from, to time.Time) ([]warehouse.EncodingUsage, error) {
+	var usage []warehouse.EncodingUsage
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT tenant_id, period, seconds, jobs, updated_at
+		     FROM tenant_encoding_usage
+		     WHERE updated_at >= ? AND updated_at < ?
+		     ORDER BY updated_at, tenant_id`, from, to).
+		Scan(&usage).Error
+	if err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+func (r *warehouseRepo) ListStorageUsage(ctx context.Context, from, to time.Time) ([]warehouse.StorageUsage, error) {
+	var usage []warehouse.StorageUsage
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT scope, scope_id, rendition_class, bytes, objects, updated_at
+		     FROM storage_usage
+		     WHERE updated_at >= ? AND updated_at < ?
+		     ORDER BY updated_at, scope, scope_id, rendition_class`, from, to).
+		Scan(&usage).Error
+	if err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+func NewWarehouseRepo(db *gorm.DB) WarehouseRepository {
+	return &warehouseRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+	"time"
+	"worker-transcode/constant"
+	"worker-transcode/entities"
+)
+
+type WatermarkRepository interface {
+	// FindLiveWatermark returns the student's pending or ready rendition of
+	// the lesson.
+	FindLiveWatermark(ctx context.Context, lessonId, userId uuid.UUID) (*entities.WatermarkRendition, error)
+	FindWatermarkByJob(ctx context.Context, jobId uuid.UUID) (*entities.WatermarkRendition, error)
+	FindWatermarkByCode(ctx context.Context, code string) (*entities.WatermarkRendition, error)
+	CreateWatermark(ctx context.Context, rendition *entities.WatermarkRendition) error
+	MarkWatermarkReady(ctx context.Context, id uuid.UUID, playlistKey string, expiresAt time.Time) error
+	MarkWatermarkFailed(ctx context.Context, id uuid.UUID) error
+	ListExpiredWatermarks(ctx context.Context, before time.Time) ([]*entities.WatermarkRendition, error)
+	// ExpireWatermark marks the rendition expired once its objects are gone;
+	// the row stays so its code can still be traced.
+	ExpireWatermark(ctx context.Context, id uuid.UUID) error
+	// FindLessonVideoURL returns the lesson's published master playlist, or
+	// "" when it has none.
+	FindLessonVideoURL(ctx context.Context, lessonId uuid.UUID) (string, error)
+}
+
+type watermarkRepo struct {
+	db *gorm.DB
+}
+
+func (r *watermarkRepo) FindLiveWatermark(ctx context.Context, lessonId, userId uuid.UUID) (*entities.WatermarkRendition, error) {
+	rendition := &entities.WatermarkRendition{}
+	err := r.db.WithContext(ctx).
+		Where("lesson_id = ? AND user_id = ? AND status IN ?", lessonId, userId,
+			[]constant.WatermarkStatus{constant.WatermarkStatusPending, constant.WatermarkStatusReady}).
+		First(rendition).Error
+	if err != nil {
+		return nil, err
+	}
+	return rendition, nil
+}
+
+func (r *watermarkRepo) FindWatermarkByJob(ctx context.Context, jobId uuid.UUID) (*entities.WatermarkRendition, error) {
+	rendition := &entities.WatermarkRendition{}
+	if err := r.db.WithContext(ctx).Where("job_id = ?", jobId).First(rendition).Error; err != nil {
+		return nil, err
+	}
+	return rendition, nil
+}
+
+func (r *watermarkRepo) FindWatermarkByCode(ctx context.Context, code string) (*entities.WatermarkRendition, error) {
+	rendition := &entities.WatermarkRendition{}
+	if err := r.db.WithContext(ctx).Where("code = ?", code).First(rendition).Error; err != nil {
+		return nil, err
+	}
+	return rendition, nil
+}
+
+// CreateWatermark returns gorm.ErrDuplicatedKey when a concurrent request
+// already created the student's live rendition.
+func (r *watermarkRepo) CreateWatermark(ctx context.Context, rendition *entities.WatermarkRendition) error {
+	err := r.db.WithContext(ctx).Create(rendition).Error
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return gorm.ErrDuplicatedKey
+	}
+	return err
+}
+
+func (r *watermarkRepo) MarkWatermarkReady(ctx context.Context, id uuid.UUID, playlistKey string, expiresAt time.Time) error {
+	return r.db.WithContext(ctx).
+		Model(&entities.WatermarkRendition{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":       constant.WatermarkStatusReady,
+			"playlist_key": playlistKey,
+			"expires_at":   expiresAt,
+			"updated_at":   time.Now().UTC(),
+		}).Error
+}
+
+func (r *watermarkRepo) MarkWatermarkFailed(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).
+		Model(&entities.WatermarkRendition{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":     constant.WatermarkStatusFailed,
+			"updated_at": time.Now().UTC(),
+		}).Error
+}
+
+func (r *watermarkRepo) ListExpiredWatermarks(ctx context.Context, before time.Time) ([]*entities.WatermarkRendition, error) {
+	var renditions []*entities.WatermarkRendition
+	err := r.db.WithContext(ctx).
+		Where("status = ? AND expires_at < ?", constant.WatermarkStatusReady, before).
+		Find(&renditions).Error
+	if err != nil {
+		return nil, err
+	}
+	return renditions, nil
+}
+
+func (r *watermarkRepo) ExpireWatermark(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).
+		Model(&entities.WatermarkRendition{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":       constant.WatermarkStatusExpired,
+			"playlist_key": nil,
+			"updated_at":   time.Now().UTC(),
+		}).Error
+}
+
+func (r *watermarkRepo) FindLessonVideoURL(ctx context.Context, lessonId uuid.UUID) (string, error) {
+	var videoURL *string
+	result := r.db.WithContext(ctx).Raw(`SELECT video_url FROM lessons WHERE id = ?`, lessonId).Scan(&videoURL)
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		return "", gorm.ErrRecordNotFound
+	}
+	if videoURL == nil {
+		return "", nil
+	}
+	return *videoURL, nil
+}
+
+func NewWatermarkRepo(db *gorm.DB) WatermarkRepository {
+	return &watermarkRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"time"
+	"worker-transcode/constant"
+	"worker-transcode/entities"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type WorkerRepository interface {
+	// UpsertWorker registers a worker, replacing any earlier row with its ID.
+	UpsertWorker(ctx context.Context, worker *entities.Worker) error
+	// UpdateWorkerHeartbeat returns gorm.ErrRecordNotFound when the row is gone.
+	UpdateWorkerHeartbeat(ctx context.Context, id uuid.UUID, status constant.WorkerStatus, inFlight int) error
+	ListWorkers(ctx context.Context) ([]*entities.Worker, error)
+	// ReleaseLapsedJobs moves processing jobs claimed by workers that last
+	// heartbeated before lapsedBefore, or that stopped, back to pending and
+	// returns them. Each job is returned to exactly one caller.
+	ReleaseLapsedJobs(ctx context.Context, lapsedBefore time.Time) ([]*entities.Job, error)
+	// DeleteWorkersBefore prunes workers that haven't heartbeated since before.
+	DeleteWorkersBefore(ctx context.Context, before time.Time) error
+	// CountProcessingJobs counts the jobs the fleet is working on.
+	CountProcessingJobs(ctx context.Context) (int64, error)
+}
+
+type workerRepo struct {
+	db *gorm.DB
+}
+
+func (r *workerRepo) UpsertWorker(ctx context.Context, worker *entities.Worker) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(worker).Error
+}
+
+func (r *workerRepo) UpdateWorkerHeartbeat(ctx context.Context, id uuid.UUID, status constant.WorkerStatus, inFlight int) error {
+	result := r.db.WithContext(ctx).Model(&entities.Worker{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":            status,
+		"in_flight":         inFlight,
+		"last_heartbeat_at": gorm.Expr("now()"),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *workerRepo) ListWorkers(ctx context.Context) ([]*entities.Worker, error) {
+	var workers []*entities.Worker
+	err := r.db.WithContext(ctx).Order("hostname, started_at DESC").Find(&workers).Error
+	if err != nil {
+		return nil, err
+	}
+	return workers, nil
+}
+
+func (r *workerRepo) ReleaseLapsedJobs(ctx context.Context, lapsedBefore time.Time) ([]*entities.Job, error) {
+	var jobs []*entities.Job
+	err := r.db.WithContext(ctx).
+		Raw(`UPDATE jobs SET status = ?, worker_id = NULL, progress = 0, updated_at = now()
+		     WHERE status = ? AND worker_id IN (
+		         SELECT id FROM workers WHERE last_heartbeat_at < ? OR status = ?
+		     )
+		     RETURNING *`, constant.JobStatusPending, constant.JobStatusProcessing, lapsedBefore, constant.WorkerStatusStopped).
+		Scan(&jobs).Error
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+func (r *workerRepo) DeleteWorkersBefore(ctx context.Context, before time.Time) error {
+	return r.db.WithContext(ctx).Where("last_heartbeat_at < ?", before).Delete(&entities.Worker{}).Error
+}
+
+func (r *workerRepo) CountProcessingJobs(ctx context.Context) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&entities.Job{}).Where("status = ?", constant.JobStatusProcessing).Count(&count).Error
+	return count, err
+}
+
+func NewWorkerRepo(db *gorm.DB) WorkerRepository {
+	return &workerRepo{
+		db: db,
+	}
+}
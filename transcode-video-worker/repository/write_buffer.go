@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+	"strings"
+	"sync"
+	"time"
+	"worker-transcode/entities"
+)
+
+// maxBufferedEvents bounds how many events a write buffer keeps for another
+// try while Postgres is failing, as a multiple of its batch size. Events are
+// diagnostic, so the oldest go first.
+const maxBufferedEvents = 20
+
+// WriteBuffer is a JobEventRepository that holds job events and progress
+// updates in memory and writes them in batches, every interval or as soon
+// as size of them are waiting. A job's progress is its latest value, so
+// only that one is written. Flush writes what is waiting right away; it is
+// called when a job leaves processing, so its timeline is complete by then.
+type WriteBuffer interface {
+	JobEventRepository
+	UpdateJobProgress(ctx context.Context, id uuid.UUID, progress int) error
+	Flush(ctx context.Context) error
+	Run(ctx context.Context)
+}
+
+type writeBuffer struct {
+	db       *gorm.DB
+	interval time.Duration
+	size     int
+	full     chan struct{}
+
+	mu       sync.Mutex
+	events   []*entities.JobEvent
+	progress map[uuid.UUID]int
+	// flushing serialises flushes so batches land in the order they were
+	// taken.
+	flushing sync.Mutex
+}
+
+func (b *writeBuffer) AddJobEvent(ctx context.Context, event *entities.JobEvent) error {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	b.mu.Lock()
+	b.events = append(b.events, event)
+	waiting := len(b.events) + len(b.progress)
+	b.mu.Unlock()
+	b.signal(waiting)
+	return nil
+}
+
+func (b *writeBuffer) UpdateJobProgress(ctx context.Context, id uuid.UUID, progress int) error {
+	b.mu.Lock()
+	b.progress[id] = progress
+	waiting := len(b.events) + len(b.progress)
+	b.mu.Unlock()
+	b.signal(waiting)
+	return nil
+}
+
+// ListJobEvents flushes first so a job's own worker reads every event it
+// has recorded.
+func (b *writeBuffer) ListJobEvents(ctx context.Context, jobId uuid.UUID) ([]*entities.JobEvent, error) {
+	if err := b.Flush(ctx); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to flush job writes")
+	}
+	var events []*entities.JobEvent
+	err := b.db.WithContext(ctx).Where("job_id = ?", jobId).Order("created_at ASC").Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (b *writeBuffer) signal(waiting int) {
+	if waiting < b.size {
+		return
+	}
+	select {
+	case b.full <- struct{}{}:
+	default:
+	}
+}
+
+// Run flushes every interval, or sooner when a batch fills, until ctx is
+// done, then writes whatever is left.
+func (b *writeBuffer) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := b.Flush(context.WithoutCancel(ctx)); err != nil {
+				zerolog.Ctx(ctx).Error().Err(err).Msg("failed to flush job writes on shutdown")
+			}
+			return
+		case <-ticker.C:
+		case <-b.full:
+		}
+		if err := b.Flush(ctx); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to flush job writes")
+		}
+	}
+}
+
+// Flush writes the waiting events in batches and every waiting progress in
+// one statement. What fails to be written is kept for the next flush.
+func (b *writeBuffer) Flush(ctx context.Context) error {
+	b.flushing.Lock()
+	defer b.flushing.Unlock()
+
+	b.mu.Lock()
+	events, progress := b.events, b.progress
+	b.events, b.progress = nil, map[uuid.UUID]int{}
+	b.mu.Unlock()
+
+	if len(events) > 0 {
+		if err := b.db.WithContext(ctx).CreateInBatches(events, b.size).Error; err != nil {
+			b.restore(events, progress)
+			return err
+		}
+	}
+	if len(progress) > 0 {
+		if err := b.writeProgress(ctx, progress); err != nil {
+			b.restore(nil, progress)
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *writeBuffer) writeProgress(ctx context.Context, progress map[uuid.UUID]int) error {
+	values := make([]string, 0, len(progress))
+	args := make([]interface{}, 0, 2*len(progress))
+	for id, percent := range progress {
+		values = append(values, "(?::uuid, ?::int)")
+		args = append(args, id, percent)
+	}
+	statement := "UPDATE jobs SET progress = v.progress FROM (VALUES " + strings.Join(values, ", ") + ") AS v(id, progress) WHERE jobs.id = v.id"
+	return b.db.WithContext(ctx).Exec(statement, args...).Error
+}
+
+// restore puts back writes that failed, ahead of those made since, unless a
+// newer progress has already replaced them.
+func (b *writeBuffer) restore(events []*entities.JobEvent, progress map[uuid.UUID]int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events = append(events, b.events...)
+	if limit := maxBufferedEvents * b.size; len(b.events) > limit {
+		b.events = b.events[len(b.events)-limit:]
+	}
+	for id, percent := range progress {
+		if _, ok := b.progress[id]; !ok {
+			b.progress[id] = percent
+		}
+	}
+}
+
+func NewWriteBuffer(db *gorm.DB, interval time.Duration, size int) WriteBuffer {
+	if size < 1 {
+		size = 1
+	}
+	return &writeBuffer{
+		db:       db,
+		interval: interval,
+		size:     size,
+		full:     make(chan struct{}, 1),
+		progress: map[uuid.UUID]int{},
+	}
+}
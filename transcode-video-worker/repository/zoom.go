@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+	"worker-transcode/entities"
+)
+
+type ZoomRepository interface {
+	FindZoomConnection(ctx context.Context, tenantId uuid.UUID) (*entities.ZoomConnection, error)
+	ListZoomConnections(ctx context.Context) ([]*entities.ZoomConnection, error)
+	SaveZoomConnection(ctx context.Context, connection *entities.ZoomConnection) error
+	MarkZoomPolled(ctx context.Context, tenantId uuid.UUID, at time.Time) error
+	FindZoomMeeting(ctx context.Context, tenantId uuid.UUID, meetingId int64) (*entities.ZoomMeeting, error)
+	SaveZoomMeeting(ctx context.Context, meeting *entities.ZoomMeeting) error
+	// ClaimZoomImport records the file as imported, reporting false when it
+	// already was.
+	ClaimZoomImport(ctx context.Context, zoomImport *entities.ZoomImport) (bool, error)
+	ReleaseZoomImport(ctx context.Context, fileId string) error
+}
+
+type zoomRepo struct {
+	db *gorm.DB
+}
+
+func (r *zoomRepo) FindZoomConnection(ctx context.Context, tenantId uuid.UUID) (*entities.ZoomConnection, error) {
+	connection := &entities.ZoomConnection{}
+	err := r.db.WithContext(ctx).First(connection, "tenant_id = ?", tenantId).Error
+	if err != nil {
+		return nil, err
+	}
+	return connection, nil
+}
+
+func (r *zoomRepo) ListZoomConnections(ctx context.Context) ([]*entities.ZoomConnection, error) {
+	var connections []*entities.ZoomConnection
+	if err := r.db.WithContext(ctx).Order("tenant_id").Find(&connections).Error; err != nil {
+		return nil, err
+	}
+	return connections, nil
+}
+
+// SaveZoomConnection replaces the tenant's connection, keeping when its
+// recordings were last listed.
+func (r *zoomRepo) SaveZoomConnection(ctx context.Context, connection *entities.ZoomConnection) error {
+	connection.UpdatedAt = time.Now()
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "tenant_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"account_id", "client_id", "client_secret", "webhook_secret", "preset", "updated_at"}),
+	}).Create(connection).Error
+}
+
+func (r *zoomRepo) MarkZoomPolled(ctx context.Context, tenantId uuid.UUID, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&entities.ZoomConnection{}).Where("tenant_id = ?", tenantId).Update("polled_at", at).Error
+}
+
+func (r *zoomRepo) FindZoomMeeting(ctx context.Context, tenantId uuid.UUID, meetingId int64) (*entities.ZoomMeeting, error) {
+	meeting := &entities.ZoomMeeting{}
+	err := r.db.WithContext(ctx).First(meeting, "meeting_id = ? AND tenant_id = ?", meetingId, tenantId).Error
+	if err != nil {
+		return nil, err
+	}
+	return meeting, nil
+}
+
+func (r *zoomRepo) SaveZoomMeeting(ctx context.Context, meeting *entities.ZoomMeeting) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "meeting_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"tenant_id", "lesson_id"}),
+	}).Create(meeting).Error
+}
+
+func (r *zoomRepo) ClaimZoomImport(ctx context.Context, zoomImport *entities.ZoomImport) (bool, error) {
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(zoomImport)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *zoomRepo) ReleaseZoomImport(ctx context.Context, fileId string) error {
+	return r.db.WithContext(ctx).Delete(&entities.ZoomImport{}, "file_id = ?", fileId).Error
+}
+
+func NewZoomRepo(db *gorm.DB) ZoomRepository {
+	return &zoomRepo{
+		db: db,
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net/http"
+	"worker-transcode/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func addAccessibility(r *gin.RouterGroup, accessibilityService service.AccessibilityService) {
+	r.GET("/lessons/:id/accessibility", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		report, err := accessibilityService.Report(c.Request.Context(), id)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": report})
+	})
+
+	r.GET("/courses/:id/accessibility", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		course, err := accessibilityService.Course(c.Request.Context(), id)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": course})
+	})
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	rpprof "runtime/pprof"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/pkg/logging"
+	"worker-transcode/pkg/rabbitmq"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newAdminServer builds the admin listener. It is kept on a separate port from
+// the public health server so profiling endpoints are never exposed by the
+// ingress in front of the worker.
+func newAdminServer(cfg *config.Config, intake *rabbitmq.Intake, drain func(timeout time.Duration)) *http.Server {
+	r := gin.New()
+	r.Use(gin.Recovery())
+	addDebug(r)
+	addLogControl(r)
+	addDrain(r, intake, drain)
+	addMaintenance(r, cfg)
+
+	return &http.Server{
+		Handler:           r,
+		Addr:              fmt.Sprintf(":%s", cfg.Admin.Port),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+func addDebug(r *gin.Engine) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	r.Any("/debug/pprof/*profile", gin.WrapH(mux))
+
+	r.GET("/debug/gc", func(c *gin.Context) {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+
+		var gc debug.GCStats
+		debug.ReadGCStats(&gc)
+
+		c.JSON(http.StatusOK, gin.H{
+			"goroutines":     runtime.NumGoroutine(),
+			"heap_alloc":     mem.HeapAlloc,
+			"heap_inuse":     mem.HeapInuse,
+			"heap_objects":   mem.HeapObjects,
+			"heap_released":  mem.HeapReleased,
+			"sys":            mem.Sys,
+			"next_gc":        mem.NextGC,
+			"num_gc":         gc.NumGC,
+			"last_gc":        gc.LastGC,
+			"pause_total_ns": gc.PauseTotal.Nanoseconds(),
+		})
+	})
+
+	r.POST("/debug/gc", func(c *gin.Context) {
+		runtime.GC()
+		debug.FreeOSMemory()
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	r.GET("/debug/goroutines", func(c *gin.Context) {
+		var buf bytes.Buffer
+		if err := rpprof.Lookup("goroutine").WriteTo(&buf, 2); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", buf.Bytes())
+	})
+}
+
+type logSettings struct {
+	Level  string `json:"level"`
+	Format string `json:"format"`
+}
+
+// addLogControl lets operators raise the log level or switch to console output
+// on a running worker; changes last until the process restarts.
+func addLogControl(r *gin.Engine) {
+	r.GET("/debug/log", func(c *gin.Context) {
+		c.JSON(http.StatusOK, logSettings{Level: logging.Level(), Format: logging.Format()})
+	})
+
+	r.PUT("/debug/log", func(c *gin.Context) {
+		var request logSettings
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if request.Level != "" {
+			if err := logging.SetLevel(request.Level); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if request.Format != "" {
+			if err := logging.SetFormat(request.Format); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		c.JSON(http.StatusOK, logSettings{Level: logging.Level(), Format: logging.Format()})
+	})
+}
+
+// DrainStatus is returned by the admin drain endpoints.
+type DrainStatus struct {
+	Draining bool           `json:"draining"`
+	InFlight map[string]int `json:"in_flight"`
+}
+
+// addDrain lets operators take a node out of service: POST /admin/drain stops
+// intake and exits the process once in-flight jobs finish or the timeout
+// (default 30m) passes. GET reports progress.
+func addDrain(r *gin.Engine, intake *rabbitmq.Intake, drain func(timeout time.Duration)) {
+	status := func(c *gin.Context, code int) {
+		c.JSON(code, DrainStatus{Draining: intake.IsDraining(), InFlight: intake.InFlight()})
+	}
+
+	r.GET("/admin/drain", func(c *gin.Context) {
+		status(c, http.StatusOK)
+	})
+
+	r.POST("/admin/drain", func(c *gin.Context) {
+		timeout := 30 * time.Minute
+		if raw := c.Query("timeout"); raw != "" {
+			parsed, err := time.ParseDuration(raw)
+			if err != nil || parsed <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "timeout must be a positive duration"})
+				return
+			}
+			timeout = parsed
+		}
+		if intake.Drain() {
+			drain(timeout)
+		}
+		status(c, http.StatusAccepted)
+	})
+}
+
+// MaintenanceStatus is returned by GET /admin/maintenance.
+type MaintenanceStatus struct {
+	Region string `json:"region"`
+	// Open is whether the worker's region is in a maintenance window, so
+	// its backfill lane runs at full concurrency.
+	Open bool `json:"open"`
+	// AnyOpen is whether some region is, so backfills and codec migrations
+	// are queued at their full rate.
+	AnyOpen bool              `json:"any_open"`
+	Share   float64           `json:"throttle_share"`
+	Windows []MaintenanceSlot `json:"windows"`
+}
+
+type MaintenanceSlot struct {
+	Window string `json:"window"`
+	Open   bool   `json:"open"`
+}
+
+// addMaintenance shows operators whether heavy background work on the node
+// is throttled right now, and by which windows.
+func addMaintenance(r *gin.Engine, cfg *config.Config) {
+	r.GET("/admin/maintenance", func(c *gin.Context) {
+		now := time.Now()
+		status := MaintenanceStatus{
+			Region:  cfg.Maintenance.Region,
+			Open:    cfg.Maintenance.Open(cfg.Maintenance.Region, now),
+			AnyOpen: cfg.Maintenance.AnyOpen(now),
+			Share:   cfg.Maintenance.Share,
+			Windows: []MaintenanceSlot{},
+		}
+		for _, window := range cfg.Maintenance.Windows {
+			status.Windows = append(status.Windows, MaintenanceSlot{Window: window.String(), Open: window.Contains(now)})
+		}
+		c.JSON(http.StatusOK, status)
+	})
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+	"worker-transcode/dto"
+	"worker-transcode/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func addAudioReplacements(r *gin.RouterGroup, audioService service.AudioReplacementService) {
+	// The lesson plays its current version until the one with the corrected
+	// audio is published.
+	r.POST("/lessons/:id/audio", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var request dto.AudioReplacementRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		replacement, err := audioService.Request(c.Request.Context(), id, request)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"data": replacement})
+	})
+
+	r.GET("/audio-replacements/:id", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		replacement, err := audioService.Find(c.Request.Context(), id)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": replacement})
+	})
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+	"worker-transcode/dto"
+	"worker-transcode/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func addBranding(r *gin.RouterGroup, brandingService service.BrandingService) {
+	r.GET("/tenants/:id/branding", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		template, err := brandingService.Get(c.Request.Context(), id)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": template})
+	})
+
+	// The template applies to lessons transcoded from then on.
+	r.PUT("/tenants/:id/branding", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var request dto.BrandingRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		template, err := brandingService.Save(c.Request.Context(), id, request)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": template})
+	})
+}
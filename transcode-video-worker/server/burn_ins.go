@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+	"worker-transcode/dto"
+	"worker-transcode/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func addBurnIns(r *gin.RouterGroup, burnInService service.BurnInService) {
+	// The video is rendered in the background; the burn-in carries a link
+	// to it once it is ready.
+	r.POST("/lessons/:id/burned-captions", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var request dto.BurnInRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		burned, err := burnInService.Request(c.Request.Context(), id, request)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"data": burned})
+	})
+
+	r.GET("/burned-captions/:id", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		link, err := burnInService.Link(c.Request.Context(), id)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": link})
+	})
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"net/http"
+	"worker-transcode/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func addChapters(r *gin.RouterGroup, chapterService service.ChapterService) {
+	r.GET("/lessons/:id/chapters", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		chapters, err := chapterService.List(c.Request.Context(), id)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": chapters})
+	})
+}
@@ -0,0 +1,264 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	jobHandler "worker-transcode/handler"
+	"worker-transcode/pkg/mailer"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/repository"
+	"worker-transcode/service"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog"
+)
+
+// queueBinding is a kind of work a worker can be bound to with its own
+// concurrency. A binding with several lanes shares its workers between them
+// by weight. Bindings that encode also share the dispatcher's slots, taking
+// a freed one by rank; the rest only wait on other services. Background
+// bindings run at full concurrency only in the maintenance windows of the
+// worker's region.
+type queueBinding struct {
+	lanes      func(cfg *config.Config) []rabbitmq.Lane
+	handler    func(ctx context.Context, msg amqp.Delivery, deps jobHandler.ServiceDependencies) error
+	encodes    bool
+	rank       int
+	background bool
+}
+
+// queueBindings are the names QUEUE_BINDINGS accepts. Bumped jobs, short
+// sources and the watermarks students are waiting on take a freed slot
+// first, backfills last.
+var queueBindings = map[string]queueBinding{
+	"transcode":   {lanes: slaLanes, handler: jobHandler.JobHandler, encodes: true, rank: 2},
+	"priority":    {lanes: singleLane(rabbitmq.PriorityTranscodeTopology), handler: jobHandler.JobHandler, encodes: true, rank: 3},
+	"backfill":    {lanes: singleLane(rabbitmq.BackfillTranscodeTopology), handler: jobHandler.JobHandler, encodes: true, rank: 0, background: true},
+	"long":        {lanes: singleLane(rabbitmq.LongTranscodeTopology), handler: jobHandler.JobHandler, encodes: true, rank: 1},
+	"express":     {lanes: singleLane(rabbitmq.ExpressTranscodeTopology), handler: jobHandler.JobHandler, encodes: true, rank: 3},
+	"recording":   {lanes: singleLane(rabbitmq.RecordingMergeTopology), handler: jobHandler.RecordingMergeHandler, encodes: true, rank: 2},
+	"watermark":   {lanes: singleLane(rabbitmq.WatermarkTopology), handler: jobHandler.WatermarkHandler, encodes: true, rank: 3},
+	"translation": {lanes: singleLane(rabbitmq.TranslationTopology), handler: jobHandler.TranslationHandler},
+	"narration":   {lanes: singleLane(rabbitmq.NarrationTopology), handler: jobHandler.NarrationHandler, encodes: true, rank: 1},
+	"export":      {lanes: singleLane(rabbitmq.ExportTopology), handler: jobHandler.ExportHandler},
+	"media":       {lanes: singleLane(rabbitmq.MediaTopology), handler: jobHandler.MediaHandler},
+	"live":        {lanes: singleLane(rabbitmq.LiveImportTopology), handler: jobHandler.LiveImportHandler, encodes: true, rank: 1},
+	"podcast":     {lanes: singleLane(rabbitmq.PodcastTopology), handler: jobHandler.PodcastHandler},
+	"keys":        {lanes: singleLane(rabbitmq.KeyRotationTopology), handler: jobHandler.KeyRotationHandler},
+	"burnin":      {lanes: singleLane(rabbitmq.BurnInTopology), handler: jobHandler.BurnInHandler, encodes: true, rank: 1},
+	"audio":       {lanes: singleLane(rabbitmq.AudioReplacementTopology), handler: jobHandler.AudioReplacementHandler, encodes: true, rank: 2},
+	"regenerate":  {lanes: singleLane(rabbitmq.RegenerationTopology), handler: jobHandler.RegenerationHandler, encodes: true, rank: 2},
+	"delete":      {lanes: singleLane(rabbitmq.DeletionTopology), handler: jobHandler.DeletionHandler},
+}
+
+func singleLane(topology rabbitmq.Topology) func(cfg *config.Config) []rabbitmq.Lane {
+	return func(cfg *config.Config) []rabbitmq.Lane {
+		return []rabbitmq.Lane{{Topology: topology, Weight: 1}}
+	}
+}
+
+// checkBindings rejects bindings to work the worker doesn't know.
+func checkBindings(cfg *config.Config) error {
+	for _, binding := range cfg.Server.Bindings {
+		if _, ok := queueBindings[binding.Name]; !ok {
+			return fmt.Errorf("unknown queue binding %q", binding.Name)
+		}
+	}
+	return nil
+}
+
+// consumerLanes maps the queues runConsumers consumes to their concurrency,
+// for the worker registry. Lanes sharing a binding's workers are listed
+// under its first lane.
+func consumerLanes(cfg *config.Config) map[string]int {
+	lanes := map[string]int{}
+	for _, binding := range cfg.Server.Bindings {
+		if binding.Concurrency > 0 {
+			lanes[queueBindings[binding.Name].lanes(cfg)[0].Topology.Queue] = binding.Concurrency
+		}
+	}
+	return lanes
+}
+
+// maintenanceLimit is how many messages a background binding of concurrency
+// may handle at once: all of them in a maintenance window of the worker's
+// region, MAINTENANCE_THROTTLE_SHARE of them outside.
+func maintenanceLimit(cfg *config.Config, concurrency int) func() int {
+	return func() int {
+		if cfg.Maintenance.Open(cfg.Maintenance.Region, time.Now()) {
+			return concurrency
+		}
+		return cfg.Maintenance.Throttle(concurrency)
+	}
+}
+
+// regionLanes are the lanes of a binding the worker consumes: the shared
+// queues, and its region's next to them, or alone with WORKER_REGION_ONLY.
+// A region's queue takes its shared one's weight.
+func regionLanes(cfg *config.Config, lanes []rabbitmq.Lane) []rabbitmq.Lane {
+	if cfg.Region.Name == "" {
+		return lanes
+	}
+	var consumed []rabbitmq.Lane
+	if !cfg.Region.Only {
+		consumed = append(consumed, lanes...)
+	}
+	for _, lane := range lanes {
+		lane.Topology = rabbitmq.Regional(lane.Topology, cfg.Region.Name)
+		consumed = append(consumed, lane)
+	}
+	return consumed
+}
+
+// queueSettings are the consumer settings of the RABBITMQ_* environment.
+func queueSettings(cfg *config.RabbitMQ) rabbitmq.Settings {
+	policies := make(map[string]rabbitmq.RetryPolicy, len(cfg.RetryPolicies))
+	for routingKey, policy := range cfg.RetryPolicies {
+		policies[routingKey] = rabbitmq.RetryPolicy(policy)
+	}
+	return rabbitmq.Settings{
+		Kind:          cfg.Kind,
+		LeaseAfter:    time.Duration(cfg.LeaseAfter) * time.Second,
+		Retry:         rabbitmq.RetryPolicy(cfg.Retry),
+		RetryPolicies: policies,
+	}
+}
+
+// slaLanes are the queues of the SLA classes, weighed against each other by
+// the transcode workers.
+func slaLanes(cfg *config.Config) []rabbitmq.Lane {
+	return []rabbitmq.Lane{
+		{Class: string(constant.SLAClassEnterprise), Topology: rabbitmq.EnterpriseTranscodeTopology, Weight: cfg.SLA.EnterpriseWeight},
+		{Class: string(constant.SLAClassPro), Topology: rabbitmq.TranscodeTopology, Weight: cfg.SLA.ProWeight},
+		{Class: string(constant.SLAClassFree), Topology: rabbitmq.FreeTranscodeTopology, Weight: cfg.SLA.FreeWeight},
+	}
+}
+
+// runConsumers starts the queue consumers and queue depth polling. They stop
+// when ctx is cancelled.
+func runConsumers(ctx context.Context, cfg *config.Config, conn *amqp.Connection, repo repository.JobRepository,
+	jobEvents repository.JobEventRepository, presetService service.PresetService, publisher rabbitmq.Publisher, intake *rabbitmq.Intake, store objectstore.Store) {
+	if cfg.Analytics.Enabled {
+		if err := rabbitmq.DeclareExchange(conn, cfg.Analytics.Exchange, "topic"); err != nil {
+			zerolog.Ctx(ctx).Fatal().Err(err).Msg("Failed to declare analytics exchange. Exiting.")
+		}
+	}
+	// Instructor chapters are announced even with detection off.
+	if err := rabbitmq.DeclareExchange(conn, cfg.Chapters.Exchange, "topic"); err != nil {
+		zerolog.Ctx(ctx).Fatal().Err(err).Msg("Failed to declare chapters exchange. Exiting.")
+	}
+	if err := rabbitmq.DeclareExchange(conn, cfg.Quota.Exchange, "topic"); err != nil {
+		zerolog.Ctx(ctx).Fatal().Err(err).Msg("Failed to declare quota exchange. Exiting.")
+	}
+	if cfg.Billing.Enabled {
+		if err := rabbitmq.DeclareExchange(conn, cfg.Billing.Exchange, "topic"); err != nil {
+			zerolog.Ctx(ctx).Fatal().Err(err).Msg("Failed to declare billing exchange. Exiting.")
+		}
+	}
+	if cfg.Results.Enabled {
+		if err := rabbitmq.DeclareExchange(conn, cfg.Results.Exchange, "topic"); err != nil {
+			zerolog.Ctx(ctx).Fatal().Err(err).Msg("Failed to declare results exchange. Exiting.")
+		}
+	}
+
+	mail := mailer.New(cfg.SMTP)
+	notificationService := service.NewNotificationService(repository.NewNotificationRepo(repo.GetDB()), mail, cfg)
+	analyticsService := service.NewAnalyticsService(publisher, cfg)
+	chapterService := service.NewChapterService(repository.NewChapterRepo(repo.GetDB()), publisher, cfg)
+	downloadService := service.NewDownloadService(repository.NewDownloadRepo(repo.GetDB()), store, cfg)
+	courseService := service.NewCourseService(repository.NewCourseRepo(repo.GetDB()), publisher, cfg)
+	versionService := service.NewVideoVersionService(repository.NewVideoVersionRepo(repo.GetDB()), service.NewStorageService(repository.NewStorageRepo(repo.GetDB()), store, cfg), store, cfg)
+	brandingService := service.NewBrandingService(repository.NewBrandingRepo(repo.GetDB()), repository.NewNotificationRepo(repo.GetDB()), store, cfg)
+	accessibilityService := service.NewAccessibilityService(repository.NewAccessibilityRepo(repo.GetDB()), cfg)
+	qcService := service.NewQCService(repository.NewQCRepo(repo.GetDB()), repository.NewFingerprintRepo(repo.GetDB()), courseService, cfg)
+	transcodeService := service.NewService(repo, jobEvents, repository.NewLockRepo(repo.GetDB()), repository.NewOutputRepo(repo.GetDB()), repository.NewRegenerationRepo(repo.GetDB()),
+		repository.NewJobSnapshotRepo(repo.GetDB()), presetService, notificationService, analyticsService, chapterService, downloadService, courseService, versionService, brandingService, accessibilityService,
+		service.NewQualityService(repository.NewQualityRepo(repo.GetDB()), repo, versionService, courseService, cfg),
+		qcService, service.NewPublishingService(repository.NewPublishingRepo(repo.GetDB()), store, cfg),
+		service.NewDriveService(repository.NewDriveRepo(repo.GetDB()), store, cfg), service.NewScanService(repository.NewScanRepo(repo.GetDB()), store, cfg),
+		service.NewTenantService(repository.NewTenantConfigRepo(repo.GetDB()), presetService, cfg),
+		service.NewQuotaService(repository.NewTenantConfigRepo(repo.GetDB()), publisher, cfg), service.NewBillingService(cfg),
+		service.NewResultService(repository.NewTranscriptRepo(repo.GetDB()), store, cfg),
+		service.NewDiarizationService(repository.NewTranscriptRepo(repo.GetDB()), cfg), publisher, store, cfg)
+	recordingMergeService := service.NewRecordingMergeService(repo, jobEvents, store, cfg)
+	watermarkService := service.NewWatermarkService(repository.NewWatermarkRepo(repo.GetDB()), repo, jobEvents, publisher, store, cfg)
+
+	serviceDeps := jobHandler.ServiceDependencies{
+		TranscodeService:      transcodeService,
+		RecordingMergeService: recordingMergeService,
+		WatermarkService:      watermarkService,
+		TranslationService:    service.NewTranslationService(repository.NewTranscriptRepo(repo.GetDB()), repo, jobEvents, courseService, publisher, store, cfg),
+		NarrationService:      service.NewNarrationService(repository.NewNarrationRepo(repo.GetDB()), repo, jobEvents, publisher, store, cfg),
+		ExportService:         service.NewContentExportService(repository.NewContentExportRepo(repo.GetDB()), repository.NewTranscriptRepo(repo.GetDB()), repository.NewNotificationRepo(repo.GetDB()), repo, jobEvents, publisher, store, cfg),
+		MediaService:          service.NewMediaService(repository.NewMediaAssetRepo(repo.GetDB()), repo, jobEvents, publisher, store, cfg),
+		PodcastService:        service.NewPodcastService(repository.NewPodcastRepo(repo.GetDB()), repo, jobEvents, publisher, store, cfg),
+		LiveImportService:     service.NewLiveImportService(repository.NewLiveImportRepo(repo.GetDB()), repo, jobEvents, publisher, store, cfg),
+		KeyRotationService:    service.NewKeyRotationService(repository.NewContentKeyRepo(repo.GetDB()), repo, jobEvents, versionService, publisher, store, cfg),
+		AudioService:          service.NewAudioReplacementService(repository.NewAudioReplacementRepo(repo.GetDB()), presetService, repo, jobEvents, versionService, publisher, store, cfg),
+		RegenerationService:   service.NewRegenerationService(repository.NewRegenerationRepo(repo.GetDB()), repository.NewCourseRepo(repo.GetDB()), courseService, presetService, chapterService, qcService, accessibilityService, repo, jobEvents, versionService, publisher, store, cfg),
+		DeletionService:       service.NewMediaDeletionService(repository.NewDeletionRepo(repo.GetDB()), repository.NewCleanupRepo(repo.GetDB()), repo, jobEvents, publisher, store, cfg),
+		BurnInService:         service.NewBurnInService(repository.NewBurnedCaptionRepo(repo.GetDB()), repository.NewTranscriptRepo(repo.GetDB()), repo, jobEvents, publisher, store, cfg),
+	}
+	if cfg.Workflow.Engine == "temporal" {
+		temporal, err := config.NewTemporalClient(ctx, cfg.Workflow)
+		if err != nil {
+			zerolog.Ctx(ctx).Fatal().Err(err).Msg("Failed to connect to Temporal. Exiting.")
+		}
+		var searchExport service.SearchExportService
+		if cfg.Search.ExportInterval > 0 {
+			db := repo.GetDB()
+			searchExport = service.NewSearchExportService(repository.NewSearchExportRepo(db), repository.NewNotificationRepo(db),
+				repository.NewCourseRepo(db), repository.NewChapterRepo(db), repository.NewTranscriptRepo(db), store, cfg)
+		}
+		pipelineService := service.NewPipelineService(temporal, transcodeService, repo, repository.NewVideoVersionRepo(repo.GetDB()), repository.NewTranscriptRepo(repo.GetDB()), courseService, searchExport, store, cfg)
+		go pipelineService.Run(ctx)
+		serviceDeps.PipelineService = pipelineService
+	}
+
+	// A binding with zero concurrency leaves its work queued for other
+	// workers.
+	dispatcher := rabbitmq.NewDispatcher(cfg.Server.Workers)
+	settings := queueSettings(cfg.Queue)
+	var watched []string
+	for _, binding := range cfg.Server.Bindings {
+		queue := queueBindings[binding.Name]
+		lanes := regionLanes(cfg, queue.lanes(cfg))
+		for _, lane := range lanes {
+			watched = append(watched, lane.Topology.Queue)
+		}
+		if binding.Concurrency == 0 {
+			continue
+		}
+
+		if queue.background {
+			for _, lane := range lanes {
+				intake.Limit(lane.Topology.Queue, maintenanceLimit(cfg, binding.Concurrency))
+			}
+		}
+		var dispatch rabbitmq.Dispatch
+		if queue.encodes {
+			dispatch = rabbitmq.Dispatch{Dispatcher: dispatcher, Rank: queue.rank}
+		}
+		var consumer rabbitmq.Consumer[jobHandler.ServiceDependencies]
+		if len(lanes) == 1 {
+			consumer = rabbitmq.NewConsumer(conn, settings, lanes[0].Topology, binding.Concurrency, intake, dispatch, queue.handler)
+		} else {
+			consumer = rabbitmq.NewWeightedConsumer(conn, settings, lanes, binding.Concurrency, intake, dispatch, queue.handler)
+		}
+		go func(name string) {
+			err := consumer.Consume(ctx, serviceDeps)
+			if err != nil {
+				zerolog.Ctx(ctx).Error().Err(err).Str("binding", name).Msg("Consumer error")
+			}
+		}(binding.Name)
+	}
+
+	if cfg.Queue.DepthInterval > 0 {
+		watched = append(watched, rabbitmq.TranscodeTopology.DLQ, rabbitmq.RecordingMergeTopology.DLQ)
+		go rabbitmq.WatchQueueDepth(ctx, conn, time.Duration(cfg.Queue.DepthInterval)*time.Second, watched...)
+	}
+}
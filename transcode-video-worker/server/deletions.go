@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http"
+	"worker-transcode/dto"
+	"worker-transcode/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func addDeletions(r *gin.RouterGroup, deletionService service.MediaDeletionService) {
+	// A lesson's video is hidden as soon as its deletion is scheduled; the
+	// deletion runs once its grace window is over.
+	r.POST("/deletions", func(c *gin.Context) {
+		var request dto.DeletionRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		deletion, err := deletionService.Request(c.Request.Context(), request)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"data": deletion})
+	})
+
+	// The deletion's report lists what it removed once it has run.
+	r.GET("/deletions/:id", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		deletion, err := deletionService.Find(c.Request.Context(), id)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": deletion})
+	})
+
+	r.POST("/deletions/:id/cancel", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		deletion, err := deletionService.Cancel(c.Request.Context(), id)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": deletion})
+	})
+}
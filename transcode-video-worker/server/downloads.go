@@ -0,0 +1,28 @@
+package server
+
+import (
+	"net/http"
+	"worker-transcode/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func addDownloads(r *gin.RouterGroup, downloadService service.DownloadService) {
+	// The app asks for a fresh link each time it downloads a lesson; the
+	// rendition's metadata tells it how long it may keep the copy.
+	r.GET("/lessons/:id/download", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		link, err := downloadService.Link(c.Request.Context(), id)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": link})
+	})
+}
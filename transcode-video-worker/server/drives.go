@@ -0,0 +1,34 @@
+package server
+
+import (
+	"net/http"
+	"worker-transcode/dto"
+	"worker-transcode/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func addDrives(r *gin.RouterGroup, driveService service.DriveService) {
+	// Jobs of the tenant can name a file in the drive as their source from
+	// then on.
+	r.PUT("/tenants/:id/drives/:provider", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var request dto.DriveConnectionRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		connection, err := driveService.Connect(c.Request.Context(), id, c.Param("provider"), request)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": connection})
+	})
+}
@@ -2,8 +2,10 @@ package server
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -11,58 +13,244 @@ import (
 	"time"
 	"worker-transcode/config"
 	"worker-transcode/constant"
-	jobHandler "worker-transcode/handler"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/alerting"
+	"worker-transcode/pkg/breaker"
+	"worker-transcode/pkg/cdn"
+	"worker-transcode/pkg/chaos"
+	"worker-transcode/pkg/correlation"
+	"worker-transcode/pkg/logging"
+	"worker-transcode/pkg/mailer"
+	"worker-transcode/pkg/objectstore"
 	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/pkg/reporting"
+	"worker-transcode/pkg/tracing"
+	"worker-transcode/pkg/version"
 	"worker-transcode/repository"
 	"worker-transcode/service"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
 )
 
-func RunHttp(cfg *config.Config) {
-	ctx, cancel := signal.NotifyContext(setupLogger(cfg), syscall.SIGINT, syscall.SIGTERM)
-	defer cancel()
+// Mode selects which parts of the worker a process runs. Splitting them lets
+// API replicas and encode capacity scale independently; every mode serves the
+// health, readiness and metrics endpoints.
+type Mode struct {
+	API     bool
+	Consume bool
+}
+
+var (
+	ModeAll     = Mode{API: true, Consume: true}
+	ModeServe   = Mode{API: true}
+	ModeConsume = Mode{Consume: true}
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeServe:
+		return "serve"
+	case ModeConsume:
+		return "consume"
+	default:
+		return "all"
+	}
+}
 
-	zerolog.Ctx(ctx).Info().Str("env", cfg.App.Environment).Bool("isProduction", cfg.App.Environment == constant.EnvironmentProduction.String()).Send()
+func Run(cfg *config.Config, mode Mode) {
+	// stop ends the process: on a second signal, or once a drain finishes.
+	ctx, stop := context.WithCancel(setupLogger(cfg))
+	defer stop()
+
+	zerolog.Ctx(ctx).Info().Str("env", cfg.App.Environment).Str("mode", mode.String()).Bool("isProduction", cfg.App.Environment == constant.EnvironmentProduction.String()).Send()
 	if cfg.App.Environment == constant.EnvironmentProduction.String() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
+	flushReports, err := reporting.Setup(cfg.Sentry, cfg.App.Environment, version.Release())
+	if err != nil {
+		zerolog.Ctx(ctx).Fatal().Err(err).Msg("Failed to set up error reporting. Exiting.")
+	}
+	defer flushReports()
+
+	alerting.Setup(cfg.Alerting)
+
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing)
+	if err != nil {
+		zerolog.Ctx(ctx).Fatal().Err(err).Msg("Failed to set up tracing. Exiting.")
+	}
+	defer func() {
+		// ctx is already cancelled here, so give the exporter its own deadline to flush.
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to flush traces")
+		}
+	}()
+
 	conn, err := config.NewRabbitMQConn(ctx, cfg.Queue)
 	if err != nil {
 		zerolog.Ctx(ctx).Fatal().Err(err).Msg("Failed to connect to RabbitMQ. Exiting.")
 	}
 
-	repo := repository.NewRepo(cfg.DB)
-	transcodeService := service.NewService(repo, cfg)
-	recordingMergeService := service.NewRecordingMergeService(repo, cfg)
+	db, err := config.NewDB(cfg.Database)
+	if err != nil {
+		zerolog.Ctx(ctx).Fatal().Err(err).Msg("Failed to open the database. Exiting.")
+	}
+	store, err := config.NewStorage(cfg.ObjectStore)
+	if err != nil {
+		zerolog.Ctx(ctx).Fatal().Err(err).Msg("Failed to set up object storage. Exiting.")
+	}
+	cache, err := config.NewRedis(cfg.Cache)
+	if err != nil {
+		zerolog.Ctx(ctx).Fatal().Err(err).Msg("Failed to set up the job status cache. Exiting.")
+	}
+	signer, err := config.NewCDNSigner(cfg.CDNSigning)
+	if err != nil {
+		zerolog.Ctx(ctx).Fatal().Err(err).Msg("Failed to set up CDN signing. Exiting.")
+	}
 
-	serviceDeps := jobHandler.ServiceDependencies{
-		TranscodeService:      transcodeService,
-		RecordingMergeService: recordingMergeService,
+	repo := repository.NewRepo(db)
+	if cache != nil {
+		repo = repository.NewJobStatusCache(repo, cache, time.Duration(cfg.Cache.TTL)*time.Second)
+	}
+	jobEvents := repository.NewJobEventRepo(repo.GetDB())
+	if cfg.Server.WriteBatchInterval > 0 {
+		buffer := repository.NewWriteBuffer(repo.GetDB(), time.Duration(cfg.Server.WriteBatchInterval)*time.Millisecond, cfg.Server.WriteBatchSize)
+		go buffer.Run(ctx)
+		jobEvents = buffer
 	}
+	presetService := service.NewPresetService(repository.NewPresetRepo(repo.GetDB()), cfg)
+	publisher := rabbitmq.NewPublisher(conn)
+	// Courses are announced from the API as well as from consumers.
+	if err := rabbitmq.DeclareExchange(conn, cfg.Course.Exchange, "topic"); err != nil {
+		zerolog.Ctx(ctx).Fatal().Err(err).Msg("Failed to declare course exchange. Exiting.")
+	}
+	breaker.Configure(cfg.Breaker.Threshold, time.Duration(cfg.Breaker.Cooldown)*time.Second)
+	if cfg.Chaos.Enabled {
+		chaos.Configure(chaos.Rates{
+			StorageError:    cfg.Chaos.StorageErrorRate,
+			FFmpegKill:      cfg.Chaos.FFmpegKillRate,
+			FFmpegKillAfter: time.Duration(cfg.Chaos.FFmpegKillAfter) * time.Second,
+			DatabaseSlow:    cfg.Chaos.DatabaseLatencyRate,
+			DatabaseLatency: time.Duration(cfg.Chaos.DatabaseLatency) * time.Millisecond,
+		})
+		zerolog.Ctx(ctx).Warn().Interface("chaos", cfg.Chaos).Msg("chaos mode on, failures will be injected")
+	}
+	load := service.NewLoadMonitor(cfg)
+	intake := rabbitmq.NewIntake(breaker.Storage, breaker.Database, breaker.Broker, load)
 
-	// Start transcoding consumer
-	transcodeConsumer := rabbitmq.NewConsumer(conn, cfg.Queue, cfg.Server.Workers, jobHandler.JobHandler)
-	go func() {
-		err := transcodeConsumer.Consume(ctx, serviceDeps)
-		if err != nil {
-			zerolog.Ctx(ctx).Error().Err(err).Msg("Transcode consumer error")
+	workerService := service.NewWorkerService(repository.NewWorkerRepo(repo.GetDB()), repo, publisher, intake, store, cfg)
+	storageService := service.NewStorageService(repository.NewStorageRepo(repo.GetDB()), store, cfg)
+	versionService := service.NewVideoVersionService(repository.NewVideoVersionRepo(repo.GetDB()), storageService, store, cfg)
+	watermarkService := service.NewWatermarkService(repository.NewWatermarkRepo(repo.GetDB()), repo, jobEvents, publisher, store, cfg)
+	var worker *entities.Worker
+	if mode.Consume {
+		if err := checkBindings(cfg); err != nil {
+			zerolog.Ctx(ctx).Fatal().Err(err).Msg("Invalid QUEUE_BINDINGS. Exiting.")
 		}
-	}()
-
-	// Start recording merge consumer
-	recordingConsumer := rabbitmq.NewRecordingConsumer(conn, cfg.Queue, cfg.Server.Workers, jobHandler.RecordingMergeHandler)
-	go func() {
-		err := recordingConsumer.Consume(ctx, serviceDeps)
+		worker, err = workerService.Register(ctx, mode.String(), consumerLanes(cfg))
 		if err != nil {
-			zerolog.Ctx(ctx).Error().Err(err).Msg("Recording merge consumer error")
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to register worker, continuing without heartbeats")
+		} else {
+			go workerService.Heartbeat(ctx, worker)
+			ctx = service.WithWorker(ctx, worker)
 		}
-	}()
+		go load.Run(ctx)
+		service.WarmUp(ctx, store, cfg)
+		runConsumers(ctx, cfg, conn, repo, jobEvents, presetService, publisher, intake, store)
+	}
+
+	courseService := service.NewCourseService(repository.NewCourseRepo(repo.GetDB()), publisher, cfg)
+	translationService := service.NewTranslationService(repository.NewTranscriptRepo(repo.GetDB()), repo, jobEvents, courseService, publisher, store, cfg)
+	audioService := service.NewAudioReplacementService(repository.NewAudioReplacementRepo(repo.GetDB()), presetService, repo, jobEvents, versionService, publisher, store, cfg)
+	redactionService := service.NewRedactionService(repository.NewRedactionRepo(repo.GetDB()), audioService, cfg)
+	transcriptService := service.NewTranscriptService(repository.NewTranscriptRepo(repo.GetDB()), courseService, translationService, redactionService, store, cfg)
+	zoomService := service.NewZoomService(repository.NewZoomRepo(repo.GetDB()), repo, presetService, transcriptService, publisher, store, cfg)
+	deletionService := service.NewMediaDeletionService(repository.NewDeletionRepo(repo.GetDB()), repository.NewCleanupRepo(repo.GetDB()), repo, jobEvents, publisher, store, cfg)
+	migrationService := service.NewMigrationService(repository.NewMigrationRepo(repo.GetDB()), repository.NewWorkerRepo(repo.GetDB()), repo, presetService, publisher, store, cfg)
+
+	go service.RunAsLeader(ctx, repository.NewLockRepo(repo.GetDB()), scheduledTasks(cfg, repo, publisher, store, signer, workerService, watermarkService, versionService, deletionService, zoomService, migrationService)...)
 
 	r := gin.Default()
 	addHealth(r)
+	addReady(r, db, conn, intake)
+	addMetrics(r)
+
+	if mode.API {
+		api := r.Group("/api/v1", withLogger(ctx), requireToken(cfg.Server.APIToken))
+		addJobs(api, service.NewJobService(repo, jobEvents, repository.NewJobAnnotationRepo(repo.GetDB()), publisher, store, cfg))
+		addJobBundles(api, service.NewJobBundleService(repo, jobEvents, repository.NewJobAnnotationRepo(repo.GetDB()), repository.NewQCRepo(repo.GetDB()),
+			repository.NewPresetRepo(repo.GetDB()), store, cfg))
+		addPresets(api, presetService)
+		addChapters(api, service.NewChapterService(repository.NewChapterRepo(repo.GetDB()), publisher, cfg))
+		addPosters(api, service.NewPosterService(repository.NewPosterRepo(repo.GetDB()), store, cfg))
+		addDownloads(api, service.NewDownloadService(repository.NewDownloadRepo(repo.GetDB()), store, cfg))
+		addCourses(api, courseService)
+		addTranscripts(api, transcriptService)
+		addRedactions(api, redactionService)
+		addWatermarks(api, watermarkService)
+		addNarrations(api, service.NewNarrationService(repository.NewNarrationRepo(repo.GetDB()), repo, jobEvents, publisher, store, cfg))
+		addExports(api, service.NewContentExportService(repository.NewContentExportRepo(repo.GetDB()), repository.NewTranscriptRepo(repo.GetDB()), repository.NewNotificationRepo(repo.GetDB()), repo, jobEvents, publisher, store, cfg))
+		addLiveImports(api, service.NewLiveImportService(repository.NewLiveImportRepo(repo.GetDB()), repo, jobEvents, publisher, store, cfg))
+		addMedia(api, service.NewMediaService(repository.NewMediaAssetRepo(repo.GetDB()), repo, jobEvents, publisher, store, cfg))
+		addVersions(api, versionService)
+		addAudioReplacements(api, audioService)
+		accessibilityService := service.NewAccessibilityService(repository.NewAccessibilityRepo(repo.GetDB()), cfg)
+		qcService := service.NewQCService(repository.NewQCRepo(repo.GetDB()), repository.NewFingerprintRepo(repo.GetDB()), courseService, cfg)
+		addRegenerations(api, service.NewRegenerationService(repository.NewRegenerationRepo(repo.GetDB()), repository.NewCourseRepo(repo.GetDB()), courseService, presetService,
+			service.NewChapterService(repository.NewChapterRepo(repo.GetDB()), publisher, cfg), qcService, accessibilityService, repo, jobEvents, versionService, publisher, store, cfg))
+		addDeletions(api, deletionService)
+		addAccessibility(api, accessibilityService)
+		addQuality(api, service.NewQualityService(repository.NewQualityRepo(repo.GetDB()), repo, versionService, courseService, cfg))
+		addQC(api, qcService)
+		addBranding(api, service.NewBrandingService(repository.NewBrandingRepo(repo.GetDB()), repository.NewNotificationRepo(repo.GetDB()), store, cfg))
+		addPublishing(api, service.NewPublishingService(repository.NewPublishingRepo(repo.GetDB()), store, cfg))
+		addDrives(api, service.NewDriveService(repository.NewDriveRepo(repo.GetDB()), store, cfg))
+		addScans(api, service.NewScanService(repository.NewScanRepo(repo.GetDB()), store, cfg))
+		addTenants(api, service.NewTenantService(repository.NewTenantConfigRepo(repo.GetDB()), presetService, cfg), service.NewQuotaService(repository.NewTenantConfigRepo(repo.GetDB()), publisher, cfg))
+		addStorage(api, storageService)
+		addLibrary(api, service.NewLibraryService(repository.NewLibraryImportRepo(repo.GetDB()), repo, presetService, publisher, store, cfg))
+		addUploads(api, service.NewUploadService(repo, publisher, store, cfg), cfg.Server.MaxUploadSize)
+		addWorkers(api, workerService)
+		if cfg.Zoom.Enabled {
+			addZoom(api, zoomService)
+			addZoomWebhook(r.Group("", withLogger(ctx)), zoomService)
+		}
+		if cfg.Podcast.Enabled {
+			podcastService := service.NewPodcastService(repository.NewPodcastRepo(repo.GetDB()), repo, jobEvents, publisher, store, cfg)
+			addPodcasts(api, podcastService)
+			addPodcastFeed(r.Group("", withLogger(ctx)), podcastService)
+		}
+		if cfg.Migration.Enabled {
+			addMigrations(api, migrationService)
+		}
+		if cfg.Reconcile.Enabled {
+			addReconciliations(api, service.NewReconciliationService(repository.NewReconciliationRepo(repo.GetDB()), store, cfg))
+		}
+		if cfg.CDNSigning.Enabled {
+			addPlayback(api, service.NewPlaybackService(repository.NewPlaybackRepo(repo.GetDB()), signer, cfg))
+		}
+		if cfg.Keys.Enabled {
+			addKeys(api, service.NewKeyRotationService(repository.NewContentKeyRepo(repo.GetDB()), repo, jobEvents, versionService, publisher, store, cfg))
+		}
+		if cfg.BurnIn.Enabled {
+			addBurnIns(api, service.NewBurnInService(repository.NewBurnedCaptionRepo(repo.GetDB()), repository.NewTranscriptRepo(repo.GetDB()), repo, jobEvents, publisher, store, cfg))
+		}
+		if cfg.Subscriptions.Enabled {
+			addSubscriptions(api, service.NewSubscriptionService(repository.NewSubscriptionRepo(repo.GetDB()), repo, cfg))
+		}
+		if cfg.LMS.Enabled {
+			lmsService := service.NewLMSWebhookService(repository.NewLMSWebhookRepo(repo.GetDB()), repo, presetService, publisher, store, cfg)
+			addLMSWebhooks(api, lmsService)
+			addLMSWebhookReceiver(r.Group("", withLogger(ctx)), lmsService)
+		}
+	}
 
 	handler := http.Server{
 		Handler:           r,
@@ -77,11 +265,54 @@ func RunHttp(cfg *config.Config) {
 		}
 	}()
 
+	var admin *http.Server
+	if cfg.Admin.Enabled {
+		admin = newAdminServer(cfg, intake, func(timeout time.Duration) {
+			go drain(ctx, intake, timeout, stop)
+		})
+		go func() {
+			zerolog.Ctx(ctx).Info().Str("addr", admin.Addr).Msg("start admin server")
+			if err := admin.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				zerolog.Ctx(ctx).Error().Err(err).Msg("admin server error")
+			}
+		}()
+	}
+
+	var scaler *grpc.Server
+	if cfg.Scaler.Enabled {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%s", cfg.Scaler.Port))
+		if err != nil {
+			zerolog.Ctx(ctx).Fatal().Err(err).Msg("Failed to listen for the external scaler. Exiting.")
+		}
+		scaler = newScalerServer(ctx, conn, repository.NewWorkerRepo(repo.GetDB()), cfg)
+		go func() {
+			zerolog.Ctx(ctx).Info().Str("addr", listener.Addr().String()).Msg("start external scaler server")
+			if err := scaler.Serve(listener); err != nil {
+				zerolog.Ctx(ctx).Error().Err(err).Msg("external scaler server error")
+			}
+		}()
+	}
+
+	go handleSignals(ctx, cfg, mode, intake, stop)
+
 	<-ctx.Done()
 	zerolog.Ctx(ctx).Info().Msg("shutting down server")
 	if err := handler.Shutdown(ctx); err != nil {
 		zerolog.Ctx(ctx).Error().Str("env", cfg.App.Environment).Msg(err.Error())
 	}
+	if admin != nil {
+		if err := admin.Shutdown(ctx); err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to shutdown admin server")
+		}
+	}
+	if scaler != nil {
+		// Stop rather than GracefulStop: KEDA holds StreamIsActive open
+		// indefinitely and reconnects to another replica.
+		scaler.Stop()
+	}
+	if worker != nil {
+		workerService.Stop(ctx, worker)
+	}
 
 	zerolog.Ctx(ctx).Info().Str("env", cfg.App.Environment).Msg("server shutdown")
 }
@@ -89,20 +320,167 @@ func RunHttp(cfg *config.Config) {
 func addHealth(r *gin.Engine) {
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{
-			"status": "ok",
+			"status":  "ok",
+			"version": version.Get(),
 		})
 	})
 }
 
-func setupLogger(cfg *config.Config) context.Context {
-	zerolog.SetGlobalLevel(zerolog.InfoLevel)
-	if cfg.App.Environment == constant.EnvironmentDevelop.String() {
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+// scheduledTasks are the maintenance loops only the leader replica runs.
+func scheduledTasks(cfg *config.Config, repo repository.JobRepository, publisher rabbitmq.Publisher, store objectstore.Store, signer cdn.Signer, workerService service.WorkerService,
+	watermarkService service.WatermarkService, versionService service.VideoVersionService, deletionService service.MediaDeletionService,
+	zoomService service.ZoomService, migrationService service.MigrationService) []func(ctx context.Context) {
+	tasks := []func(ctx context.Context){workerService.Reap, watermarkService.Expire, versionService.Expire, deletionService.Queue}
+	if cfg.Report.Enabled {
+		reportService := service.NewReportService(repository.NewReportRepo(repo.GetDB()), mailer.New(cfg.SMTP), store, cfg)
+		tasks = append(tasks, func(ctx context.Context) {
+			service.RunDailyReports(ctx, reportService, cfg.Report.Hour)
+		})
+	}
+	if cfg.Ingest.Enabled {
+		tasks = append(tasks, service.NewIngestService(repo, publisher, store, cfg).Run)
+	}
+	if cfg.Zoom.Enabled {
+		tasks = append(tasks, zoomService.Run)
+	}
+	if cfg.Migration.Enabled {
+		tasks = append(tasks, migrationService.Run)
+	}
+	if cfg.Billing.Enabled || cfg.Results.Enabled {
+		tasks = append(tasks, service.NewOutboxService(repository.NewOutboxRepo(repo.GetDB()), publisher, cfg).Run)
+	}
+	if cfg.Search.ExportInterval > 0 {
+		db := repo.GetDB()
+		tasks = append(tasks, service.NewSearchExportService(repository.NewSearchExportRepo(db), repository.NewNotificationRepo(db),
+			repository.NewCourseRepo(db), repository.NewChapterRepo(db), repository.NewTranscriptRepo(db), store, cfg).Run)
+	}
+	if cfg.Warehouse.Enabled {
+		tasks = append(tasks, service.NewWarehouseService(repository.NewWarehouseRepo(repo.GetDB()), store, cfg).Run)
+	}
+	if cfg.PlaybackProbe.Enabled {
+		tasks = append(tasks, service.NewPlaybackProbeService(repository.NewPlaybackRepo(repo.GetDB()), store, signer, cfg).Run)
+	}
+	if cfg.Reconcile.Enabled {
+		tasks = append(tasks, service.NewReconciliationService(repository.NewReconciliationRepo(repo.GetDB()), store, cfg).Run)
+	}
+	if cfg.Subscriptions.Enabled {
+		tasks = append(tasks, service.NewSubscriptionService(repository.NewSubscriptionRepo(repo.GetDB()), repo, cfg).Run)
+	}
+	return tasks
+}
+
+// handleSignals turns SIGTERM into a drain bounded by the shutdown grace, so a
+// rolling update lets running jobs finish within the pod's
+// terminationGracePeriodSeconds. Jobs still running when it runs out are
+// handed back to pending and their messages requeued for another worker. A
+// second signal, or a process without consumers, stops straight away.
+func handleSignals(ctx context.Context, cfg *config.Config, mode Mode, intake *rabbitmq.Intake, stop context.CancelFunc) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case <-ctx.Done():
+		return
+	case sig := <-signals:
+		zerolog.Ctx(ctx).Info().Str("signal", sig.String()).Msg("received shutdown signal")
 	}
 
-	// Log to standard output
-	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
-	ctx := logger.WithContext(context.Background())
+	grace := time.Duration(cfg.Server.ShutdownGrace) * time.Second
+	if !mode.Consume || grace <= 0 {
+		stop()
+		return
+	}
+	// An admin drain may already be under way; the grace period still applies.
+	intake.Drain()
+	go drain(ctx, intake, grace, stop)
 
-	return ctx
+	select {
+	case <-ctx.Done():
+	case <-signals:
+		zerolog.Ctx(ctx).Warn().Msg("received second signal, stopping now")
+		stop()
+	}
+}
+
+// drain waits for in-flight messages after intake has stopped, then ends the
+// process. Whatever is still running after timeout is interrupted; its
+// messages were never acknowledged, so the broker redelivers them.
+func drain(ctx context.Context, intake *rabbitmq.Intake, timeout time.Duration, stop context.CancelFunc) {
+	defer stop()
+	zerolog.Ctx(ctx).Info().Interface("in_flight", intake.InFlight()).Dur("timeout", timeout).Msg("draining worker")
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := intake.Wait(waitCtx); err != nil {
+		zerolog.Ctx(ctx).Warn().Interface("in_flight", intake.InFlight()).Msg("drain timed out, interrupting remaining jobs")
+		return
+	}
+	zerolog.Ctx(ctx).Info().Msg("worker drained")
+}
+
+// addReady reports whether the worker can take jobs: the database answers,
+// the AMQP connection is still open and the worker is neither draining nor
+// paused by one of its gates.
+func addReady(r *gin.Engine, db *sql.DB, conn *amqp.Connection, intake *rabbitmq.Intake) {
+	r.GET("/ready", func(c *gin.Context) {
+		checks := gin.H{"database": "ok", "rabbitmq": "ok", "intake": "ok"}
+		status := http.StatusOK
+		if intake.IsDraining() {
+			checks["intake"] = "draining"
+			status = http.StatusServiceUnavailable
+		} else if paused := intake.Paused(); len(paused) > 0 {
+			checks["intake"] = gin.H{"paused": paused}
+			status = http.StatusServiceUnavailable
+		}
+
+		pingCtx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+		defer cancel()
+		if err := db.PingContext(pingCtx); err != nil {
+			checks["database"] = err.Error()
+			status = http.StatusServiceUnavailable
+		}
+		if conn.IsClosed() {
+			checks["rabbitmq"] = "connection closed"
+			status = http.StatusServiceUnavailable
+		}
+
+		c.JSON(status, checks)
+	})
+}
+
+// addMetrics exposes the Prometheus collectors registered by pkg/metrics
+// alongside the Go runtime and process collectors. OpenMetrics is offered so
+// scrapers that ask for it also receive trace exemplars.
+func addMetrics(r *gin.Engine) {
+	handler := promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+	r.GET("/metrics", gin.WrapH(handler))
+}
+
+// withLogger attaches the server logger to each request context so handlers
+// and services log the same way they do when driven by the consumer. The
+// caller's correlation ID is reused, or a new one is issued and echoed back.
+func withLogger(ctx context.Context) gin.HandlerFunc {
+	logger := zerolog.Ctx(ctx)
+	return func(c *gin.Context) {
+		correlationId := c.GetHeader(correlation.Header)
+		if correlationId == "" {
+			correlationId = correlation.New()
+		}
+		c.Header(correlation.Header, correlationId)
+
+		requestCtx := correlation.WithID(logger.WithContext(c.Request.Context()), correlationId)
+		c.Request = c.Request.WithContext(requestCtx)
+		c.Next()
+	}
+}
+
+func setupLogger(cfg *config.Config) context.Context {
+	logger, err := logging.New(cfg.Log, cfg.App.Environment)
+	if err != nil {
+		logger = zerolog.New(os.Stdout).With().Timestamp().Logger()
+		logger.Fatal().Err(err).Msg("invalid log configuration")
+	}
+	return logger.WithContext(context.Background())
 }
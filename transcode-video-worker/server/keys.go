@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/http"
+	"worker-transcode/dto"
+	"worker-transcode/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func addKeys(r *gin.RouterGroup, keyService service.KeyRotationService) {
+	// Each lesson of the courses is rotated by a job of its own; a lesson
+	// plays its current version until its rotation is published.
+	r.POST("/keys/rotations", func(c *gin.Context) {
+		var request dto.KeyRotationRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		rotations, err := keyService.Request(c.Request.Context(), request)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"data": rotations})
+	})
+
+	r.GET("/keys/rotations/:id", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		rotation, err := keyService.Find(c.Request.Context(), id)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": rotation})
+	})
+
+	// The API serves the key to the players it authorizes, from KEYS_URL.
+	r.GET("/keys/:id", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		key, err := keyService.Key(c.Request.Context(), id)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.Header("Cache-Control", "private, no-store")
+		c.Data(http.StatusOK, "application/octet-stream", key)
+	})
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"worker-transcode/dto"
+	"worker-transcode/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func addLibrary(r *gin.RouterGroup, libraryService service.LibraryService) {
+	// Lists the library so the tenant can map its videos to lessons. The
+	// credentials are sent with each request and never stored.
+	r.POST("/tenants/:id/library/videos", func(c *gin.Context) {
+		if _, err := uuid.Parse(c.Param("id")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var request dto.LibraryRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		videos, err := libraryService.Videos(c.Request.Context(), request)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": videos})
+	})
+
+	// Imports run after the response, as downloading a library takes far
+	// longer than a request. The claimed imports are returned to be polled.
+	r.POST("/tenants/:id/library-imports", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var request dto.LibraryImportRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		claimed, err := libraryService.Claim(c.Request.Context(), id, request)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		go libraryService.Run(context.WithoutCancel(c.Request.Context()), request, claimed)
+		c.JSON(http.StatusAccepted, gin.H{"data": claimed})
+	})
+
+	r.GET("/tenants/:id/library-imports", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		imports, err := libraryService.Imports(c.Request.Context(), id)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": imports})
+	})
+}
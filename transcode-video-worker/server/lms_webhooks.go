@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"worker-transcode/dto"
+	"worker-transcode/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxLMSWebhookBody bounds an LMS event's body, which describes a content
+// item and links to its media rather than carrying it.
+const maxLMSWebhookBody = 1 << 20
+
+func addLMSWebhooks(r *gin.RouterGroup, lmsService service.LMSWebhookService) {
+	r.GET("/tenants/:id/lms-webhook", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		webhook, err := lmsService.Get(c.Request.Context(), id)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": webhook})
+	})
+
+	r.PUT("/tenants/:id/lms-webhook", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var request dto.LMSWebhookRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		webhook, err := lmsService.Save(c.Request.Context(), id, request)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": webhook})
+	})
+}
+
+// addLMSWebhookReceiver takes the events of tenants' LMSs. Like Zoom's, they
+// come without the API token, so the route is outside the API group and
+// each event is checked against the tenant's signing secret instead.
+func addLMSWebhookReceiver(r *gin.RouterGroup, lmsService service.LMSWebhookService) {
+	r.POST("/webhooks/lms/:tenant", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("tenant"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxLMSWebhookBody)
+		body, err := c.GetRawData()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		result, err := lmsService.Webhook(c.Request.Context(), id, c.GetHeader("X-Webhook-Timestamp"), c.GetHeader("X-Webhook-Signature"), body)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		status := http.StatusAccepted
+		if result.JobId == nil {
+			status = http.StatusOK
+		}
+		c.JSON(status, gin.H{"data": result})
+	})
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net/http"
+	"worker-transcode/dto"
+	"worker-transcode/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func addMedia(r *gin.RouterGroup, mediaService service.MediaService) {
+	// Images and documents are rendered in the background; the asset lists
+	// the keys written once its job is done.
+	r.POST("/media", func(c *gin.Context) {
+		var request dto.MediaRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		asset, err := mediaService.Request(c.Request.Context(), request)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"data": asset})
+	})
+
+	r.GET("/media/:id", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		asset, err := mediaService.Find(c.Request.Context(), id)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": asset})
+	})
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"net/http"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func addMigrations(r *gin.RouterGroup, migrationService service.MigrationService) {
+	r.POST("/migrations", func(c *gin.Context) {
+		var request dto.CodecMigrationRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		migration, err := migrationService.Start(c.Request.Context(), request)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"data": migration})
+	})
+
+	r.GET("/migrations", func(c *gin.Context) {
+		migrations, err := migrationService.List(c.Request.Context())
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": migrations})
+	})
+
+	// Progress is counted per course.
+	r.GET("/migrations/:id", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		progress, err := migrationService.Progress(c.Request.Context(), id)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": progress})
+	})
+
+	transition := func(status constant.MigrationStatus) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			id, err := uuid.Parse(c.Param("id"))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			migration, err := migrationService.Transition(c.Request.Context(), id, status)
+			if err != nil {
+				respondError(c, err)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"data": migration})
+		}
+	}
+	r.POST("/migrations/:id/pause", transition(constant.MigrationStatusPaused))
+	r.POST("/migrations/:id/resume", transition(constant.MigrationStatusRunning))
+	r.POST("/migrations/:id/cancel", transition(constant.MigrationStatusCancelled))
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"net/http"
+	"worker-transcode/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func addPlayback(r *gin.RouterGroup, playbackService service.PlaybackService) {
+	// The API asks for a grant when a student opens a lesson and sets its
+	// cookies on them before handing the player the URL.
+	r.GET("/lessons/:id/playback/users/:user", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		userId, err := uuid.Parse(c.Param("user"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		grant, err := playbackService.Grant(c.Request.Context(), id, userId)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.Header("Cache-Control", "private, no-store")
+		c.JSON(http.StatusOK, gin.H{"data": grant})
+	})
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"net/http"
+	"worker-transcode/dto"
+	"worker-transcode/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func addPodcasts(r *gin.RouterGroup, podcastService service.PodcastService) {
+	// Episodes are made in the background; students' feeds list them once
+	// the build is done.
+	r.POST("/courses/:id/podcast", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var request dto.PodcastRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		feed, err := podcastService.Request(c.Request.Context(), id, request)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"data": feed})
+	})
+
+	r.GET("/courses/:id/podcast/users/:user", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		userId, err := uuid.Parse(c.Param("user"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		link, err := podcastService.Link(c.Request.Context(), id, userId)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": link})
+	})
+}
+
+// addPodcastFeed serves students' feeds to their podcast apps, which carry
+// no API token: the URL is signed instead.
+func addPodcastFeed(r *gin.RouterGroup, podcastService service.PodcastService) {
+	r.GET("/podcasts/:id/:user/:token/feed.xml", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		userId, err := uuid.Parse(c.Param("user"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+
+		feed, err := podcastService.Feed(c.Request.Context(), id, userId, c.Param("token"))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.Header("Cache-Control", "private, no-store")
+		c.Data(http.StatusOK, "application/rss+xml; charset=utf-8", feed)
+	})
+}
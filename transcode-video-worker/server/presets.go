@@ -0,0 +1,178 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"worker-transcode/dto"
+	"worker-transcode/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func addPresets(r *gin.RouterGroup, presetService service.PresetService) {
+	r.GET("/presets", func(c *gin.Context) {
+		presets, err := presetService.List(c.Request.Context())
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": presets})
+	})
+
+	r.GET("/presets/:name", func(c *gin.Context) {
+		version, _ := strconv.Atoi(c.Query("version"))
+		preset, err := presetService.Get(c.Request.Context(), c.Param("name"), version)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, preset)
+	})
+
+	r.POST("/presets", func(c *gin.Context) {
+		request, err := bindPreset(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		preset, err := presetService.Create(c.Request.Context(), request)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, preset)
+	})
+
+	r.PUT("/presets/:name", func(c *gin.Context) {
+		request, err := bindPreset(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		preset, err := presetService.Update(c.Request.Context(), c.Param("name"), request)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, preset)
+	})
+
+	r.POST("/presets/:name/deactivate", func(c *gin.Context) {
+		if err := presetService.Deactivate(c.Request.Context(), c.Param("name")); err != nil {
+			respondError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	r.POST("/presets/:name/canary", func(c *gin.Context) {
+		var request dto.PresetCanaryRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		preset, err := presetService.StartCanary(c.Request.Context(), c.Param("name"), request)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, preset)
+	})
+
+	r.GET("/presets/:name/canary", func(c *gin.Context) {
+		report, err := presetService.CompareCanary(c.Request.Context(), c.Param("name"))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	})
+
+	r.POST("/presets/:name/canary/promote", func(c *gin.Context) {
+		force, _ := strconv.ParseBool(c.Query("force"))
+		preset, err := presetService.PromoteCanary(c.Request.Context(), c.Param("name"), force)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, preset)
+	})
+
+	r.POST("/presets/:name/canary/abort", func(c *gin.Context) {
+		if err := presetService.AbortCanary(c.Request.Context(), c.Param("name")); err != nil {
+			respondError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	r.GET("/experiments", func(c *gin.Context) {
+		experiments, err := presetService.ListExperiments(c.Request.Context())
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, experiments)
+	})
+
+	r.POST("/experiments", func(c *gin.Context) {
+		var request dto.PresetExperimentRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		experiment, err := presetService.StartExperiment(c.Request.Context(), request)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, experiment)
+	})
+
+	// The report compares the arms' finished jobs so far, running or not.
+	r.GET("/experiments/:id/report", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		report, err := presetService.CompareExperiment(c.Request.Context(), id)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	})
+
+	r.POST("/experiments/:id/stop", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		experiment, err := presetService.StopExperiment(c.Request.Context(), id)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, experiment)
+	})
+}
+
+// bindPreset reads a preset request, or a preset document when the body is
+// YAML.
+func bindPreset(c *gin.Context) (dto.PresetRequest, error) {
+	switch c.ContentType() {
+	case "application/yaml", "application/x-yaml", "text/yaml":
+		raw, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			return dto.PresetRequest{}, err
+		}
+		return service.ParsePresetDocument(raw)
+	}
+	var request dto.PresetRequest
+	err := c.ShouldBindJSON(&request)
+	return request, err
+}
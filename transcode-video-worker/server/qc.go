@@ -0,0 +1,58 @@
+package server
+
+import (
+	"net/http"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func addQC(r *gin.RouterGroup, qcService service.QCService) {
+	r.GET("/lessons/:id/qc", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		flags, err := qcService.Flags(c.Request.Context(), id)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": flags})
+	})
+
+	// The review queue of the content team, of one check with ?check=.
+	r.GET("/qc/flags", func(c *gin.Context) {
+		flags, err := qcService.Open(c.Request.Context(), constant.QCCheck(c.Query("check")))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": flags})
+	})
+
+	r.POST("/qc/flags/:id/review", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var request dto.QCReviewRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		flag, err := qcService.Review(c.Request.Context(), id, request)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": flag})
+	})
+}
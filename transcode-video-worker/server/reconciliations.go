@@ -0,0 +1,19 @@
+package server
+
+import (
+	"net/http"
+	"worker-transcode/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+func addReconciliations(r *gin.RouterGroup, reconciliationService service.ReconciliationService) {
+	r.GET("/reconciliations/latest", func(c *gin.Context) {
+		report, err := reconciliationService.Latest(c.Request.Context())
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": report})
+	})
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/repository"
+
+	"github.com/kedacore/keda/v2/pkg/scalers/externalscaler"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	backlogMetric        = "transcode_backlog"
+	scalerStreamInterval = 10 * time.Second
+)
+
+// defaultScaledQueues are summed when a ScaledObject doesn't list queues.
+var defaultScaledQueues = []string{
+	rabbitmq.TranscodeTopology.Queue,
+	rabbitmq.EnterpriseTranscodeTopology.Queue,
+	rabbitmq.FreeTranscodeTopology.Queue,
+	rabbitmq.PriorityTranscodeTopology.Queue,
+	rabbitmq.LongTranscodeTopology.Queue,
+	rabbitmq.ExpressTranscodeTopology.Queue,
+}
+
+// scaler implements the KEDA external scaler API. Its metric is the transcode
+// backlog: messages ready on the scaled queues plus jobs being processed, so
+// KEDA runs backlog / targetBacklog replicas rather than guessing from CPU.
+//
+// ScaledObject metadata:
+//
+//	queues:        comma-separated queues to count (default the SLA, priority, long and express lanes)
+//	targetBacklog: jobs one replica should hold (default SERVER_WORKERS)
+type scaler struct {
+	externalscaler.UnimplementedExternalScalerServer
+	conn    *amqp.Connection
+	workers repository.WorkerRepository
+	cfg     *config.Config
+}
+
+func newScalerServer(ctx context.Context, conn *amqp.Connection, workers repository.WorkerRepository, cfg *config.Config) *grpc.Server {
+	logger := zerolog.Ctx(ctx)
+	server := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return handler(logger.WithContext(ctx), req)
+	}))
+	externalscaler.RegisterExternalScalerServer(server, &scaler{conn: conn, workers: workers, cfg: cfg})
+	return server
+}
+
+func (s *scaler) IsActive(ctx context.Context, ref *externalscaler.ScaledObjectRef) (*externalscaler.IsActiveResponse, error) {
+	backlog, err := s.backlog(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return &externalscaler.IsActiveResponse{Result: backlog > 0}, nil
+}
+
+// StreamIsActive pushes activity changes so KEDA can wake a deployment scaled
+// to zero without waiting for its polling interval.
+func (s *scaler) StreamIsActive(ref *externalscaler.ScaledObjectRef, stream externalscaler.ExternalScaler_StreamIsActiveServer) error {
+	ticker := time.NewTicker(scalerStreamInterval)
+	defer ticker.Stop()
+
+	var last *bool
+	for {
+		backlog, err := s.backlog(stream.Context(), ref)
+		if err != nil {
+			zerolog.Ctx(stream.Context()).Warn().Err(err).Str("scaled_object", ref.Name).Msg("failed to measure backlog")
+		} else if active := backlog > 0; last == nil || *last != active {
+			if err := stream.Send(&externalscaler.IsActiveResponse{Result: active}); err != nil {
+				return err
+			}
+			last = &active
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *scaler) GetMetricSpec(ctx context.Context, ref *externalscaler.ScaledObjectRef) (*externalscaler.GetMetricSpecResponse, error) {
+	target, err := s.targetBacklog(ref)
+	if err != nil {
+		return nil, err
+	}
+	return &externalscaler.GetMetricSpecResponse{
+		MetricSpecs: []*externalscaler.MetricSpec{{MetricName: backlogMetric, TargetSize: target}},
+	}, nil
+}
+
+func (s *scaler) GetMetrics(ctx context.Context, request *externalscaler.GetMetricsRequest) (*externalscaler.GetMetricsResponse, error) {
+	backlog, err := s.backlog(ctx, request.ScaledObjectRef)
+	if err != nil {
+		return nil, err
+	}
+	return &externalscaler.GetMetricsResponse{
+		MetricValues: []*externalscaler.MetricValue{{MetricName: backlogMetric, MetricValue: backlog}},
+	}, nil
+}
+
+func (s *scaler) backlog(ctx context.Context, ref *externalscaler.ScaledObjectRef) (int64, error) {
+	queues := defaultScaledQueues
+	if raw := ref.GetScalerMetadata()["queues"]; raw != "" {
+		queues = strings.Split(raw, ",")
+	}
+
+	var backlog int64
+	for _, queue := range queues {
+		q, err := rabbitmq.InspectQueue(s.conn, strings.TrimSpace(queue))
+		if err != nil {
+			return 0, status.Errorf(codes.Unavailable, "inspect queue %s: %v", queue, err)
+		}
+		backlog += int64(q.Messages)
+	}
+
+	processing, err := s.workers.CountProcessingJobs(ctx)
+	if err != nil {
+		return 0, status.Errorf(codes.Unavailable, "count processing jobs: %v", err)
+	}
+	return backlog + processing, nil
+}
+
+func (s *scaler) targetBacklog(ref *externalscaler.ScaledObjectRef) (int64, error) {
+	raw := ref.GetScalerMetadata()["targetBacklog"]
+	if raw == "" {
+		return int64(max(s.cfg.Server.Workers, 1)), nil
+	}
+	target, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || target < 1 {
+		return 0, status.Error(codes.InvalidArgument, fmt.Sprintf("targetBacklog must be a positive integer, got %q", raw))
+	}
+	return target, nil
+}
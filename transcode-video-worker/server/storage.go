@@ -0,0 +1,34 @@
+package server
+
+import (
+	"net/http"
+	"worker-transcode/constant"
+	"worker-transcode/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func addStorage(r *gin.RouterGroup, storageService service.StorageService) {
+	usage := func(scope constant.StorageScope) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			id, err := uuid.Parse(c.Param("id"))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			usage, err := storageService.Usage(c.Request.Context(), scope, id)
+			if err != nil {
+				respondError(c, err)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"data": usage})
+		}
+	}
+
+	// What the published packages, including the versions retained for
+	// rollback, take up in the bucket.
+	r.GET("/courses/:id/storage", usage(constant.StorageScopeCourse))
+	r.GET("/tenants/:id/storage", usage(constant.StorageScopeTenant))
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"net/http"
+	"worker-transcode/dto"
+	"worker-transcode/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func addSubscriptions(r *gin.RouterGroup, subscriptionService service.SubscriptionService) {
+	r.GET("/tenants/:id/subscriptions", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		subscriptions, err := subscriptionService.List(c.Request.Context(), id)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": subscriptions})
+	})
+
+	// The secret is returned only here; the subscription gets the events
+	// that happen from now on.
+	r.POST("/tenants/:id/subscriptions", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var request dto.SubscriptionRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		subscription, err := subscriptionService.Create(c.Request.Context(), id, request)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"data": subscription})
+	})
+
+	r.GET("/tenants/:id/subscriptions/:subscription", func(c *gin.Context) {
+		id, subscriptionId, ok := parseSubscriptionParams(c)
+		if !ok {
+			return
+		}
+
+		subscription, err := subscriptionService.Get(c.Request.Context(), id, subscriptionId)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": subscription})
+	})
+
+	r.PUT("/tenants/:id/subscriptions/:subscription", func(c *gin.Context) {
+		id, subscriptionId, ok := parseSubscriptionParams(c)
+		if !ok {
+			return
+		}
+		var request dto.SubscriptionRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		subscription, err := subscriptionService.Update(c.Request.Context(), id, subscriptionId, request)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": subscription})
+	})
+
+	r.DELETE("/tenants/:id/subscriptions/:subscription", func(c *gin.Context) {
+		id, subscriptionId, ok := parseSubscriptionParams(c)
+		if !ok {
+			return
+		}
+
+		if err := subscriptionService.Delete(c.Request.Context(), id, subscriptionId); err != nil {
+			respondError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	// ?status= narrows the history to PENDING, DELIVERED or FAILED
+	// deliveries.
+	r.GET("/tenants/:id/subscriptions/:subscription/deliveries", func(c *gin.Context) {
+		id, subscriptionId, ok := parseSubscriptionParams(c)
+		if !ok {
+			return
+		}
+
+		deliveries, err := subscriptionService.Deliveries(c.Request.Context(), id, subscriptionId, c.Query("status"))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": deliveries})
+	})
+
+	r.POST("/tenants/:id/subscriptions/:subscription/deliveries/:delivery/redeliver", func(c *gin.Context) {
+		id, subscriptionId, ok := parseSubscriptionParams(c)
+		if !ok {
+			return
+		}
+		deliveryId, err := uuid.Parse(c.Param("delivery"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		delivery, err := subscriptionService.Redeliver(c.Request.Context(), id, subscriptionId, deliveryId)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"data": delivery})
+	})
+}
+
+// parseSubscriptionParams reads the tenant and subscription of the route,
+// answering the request itself when either isn't an id.
+func parseSubscriptionParams(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return uuid.Nil, uuid.Nil, false
+	}
+	subscriptionId, err := uuid.Parse(c.Param("subscription"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return id, subscriptionId, true
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"worker-transcode/dto"
+	"worker-transcode/pkg/search"
+	"worker-transcode/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func addTranscripts(r *gin.RouterGroup, transcriptService service.TranscriptService) {
+	// A transcript is sent either as JSON cues or as a WebVTT file with its
+	// language in the query string.
+	r.PUT("/lessons/:id/transcript", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var request dto.TranscriptRequest
+		if strings.HasPrefix(c.ContentType(), "text/vtt") {
+			request.Language = c.Query("language")
+			request.Cues, err = search.ParseWebVTT(c.Request.Body)
+		} else {
+			err = c.ShouldBindJSON(&request)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := transcriptService.Index(c.Request.Context(), id, request.Language, request.Cues); err != nil {
+			respondError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	r.GET("/lessons/:id/captions", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		captions, err := transcriptService.Captions(c.Request.Context(), id)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": captions})
+	})
+
+	r.GET("/courses/:id/search", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		limit, _ := strconv.Atoi(c.Query("limit"))
+
+		hits, err := transcriptService.Search(c.Request.Context(), id, c.Query("q"), limit)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": hits})
+	})
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"worker-transcode/dto"
+	"worker-transcode/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+const tusVersion = "1.0.0"
+
+// addUploads exposes the tus 1.0.0 core protocol plus the creation and
+// termination extensions. Required Upload-Metadata keys are lesson_id and
+// filename; user_id, filetype, preset and sla_class are optional.
+func addUploads(r *gin.RouterGroup, uploadService service.UploadService, maxSize int64) {
+	uploads := r.Group("/uploads", func(c *gin.Context) {
+		c.Header("Tus-Resumable", tusVersion)
+		if c.Request.Method != http.MethodOptions && c.GetHeader("Tus-Resumable") != tusVersion {
+			c.Header("Tus-Version", tusVersion)
+			c.AbortWithStatus(http.StatusPreconditionFailed)
+			return
+		}
+		c.Next()
+	})
+
+	uploads.OPTIONS("", func(c *gin.Context) {
+		c.Header("Tus-Version", tusVersion)
+		c.Header("Tus-Extension", "creation,termination")
+		c.Header("Tus-Max-Size", strconv.FormatInt(maxSize, 10))
+		c.Status(http.StatusNoContent)
+	})
+
+	uploads.POST("", func(c *gin.Context) {
+		length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid Upload-Length"})
+			return
+		}
+		metadata, err := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		info, err := uploadService.Create(c.Request.Context(), length, metadata)
+		if err != nil {
+			respondUploadError(c, err)
+			return
+		}
+
+		c.Header("Location", strings.TrimSuffix(c.Request.URL.Path, "/")+"/"+info.Id)
+		writeUploadHeaders(c, info)
+		c.Status(http.StatusCreated)
+	})
+
+	uploads.HEAD("/:id", func(c *gin.Context) {
+		info, err := uploadService.Info(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			respondUploadError(c, err)
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		writeUploadHeaders(c, info)
+		c.Status(http.StatusOK)
+	})
+
+	uploads.PATCH("/:id", func(c *gin.Context) {
+		if c.ContentType() != "application/offset+octet-stream" {
+			c.AbortWithStatus(http.StatusUnsupportedMediaType)
+			return
+		}
+		offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid Upload-Offset"})
+			return
+		}
+
+		info, err := uploadService.Append(c.Request.Context(), c.Param("id"), offset, c.Request.Body)
+		if err != nil {
+			respondUploadError(c, err)
+			return
+		}
+		writeUploadHeaders(c, info)
+		c.Status(http.StatusNoContent)
+	})
+
+	uploads.DELETE("/:id", func(c *gin.Context) {
+		if err := uploadService.Terminate(c.Request.Context(), c.Param("id")); err != nil {
+			respondUploadError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+}
+
+func writeUploadHeaders(c *gin.Context, info *dto.UploadInfo) {
+	c.Header("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(info.Length, 10))
+	if info.JobId != nil {
+		c.Header("Upload-Job-Id", info.JobId.String())
+	}
+}
+
+func respondUploadError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrOffsetMismatch):
+		c.AbortWithStatus(http.StatusConflict)
+	case errors.Is(err, service.ErrUploadTooLarge):
+		c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+	default:
+		respondError(c, err)
+	}
+}
+
+// parseUploadMetadata decodes "key base64value,key2 base64value2".
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if header == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.New("invalid Upload-Metadata encoding for " + key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func addWatermarks(r *gin.RouterGroup, watermarkService service.WatermarkService) {
+	// The player asks for the student's rendition at playback. Until it is
+	// ready the request is accepted and can be repeated.
+	r.POST("/lessons/:id/watermark", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var request dto.WatermarkRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		rendition, err := watermarkService.Request(c.Request.Context(), id, request.UserId)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		status := http.StatusAccepted
+		if rendition.Status == constant.WatermarkStatusReady {
+			status = http.StatusOK
+		}
+		c.JSON(status, gin.H{"data": rendition})
+	})
+
+	r.GET("/watermarks/:code", func(c *gin.Context) {
+		rendition, err := watermarkService.Trace(c.Request.Context(), c.Param("code"))
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": rendition})
+	})
+}
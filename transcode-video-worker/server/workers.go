@@ -0,0 +1,19 @@
+package server
+
+import (
+	"net/http"
+	"worker-transcode/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+func addWorkers(r *gin.RouterGroup, workerService service.WorkerService) {
+	r.GET("/workers", func(c *gin.Context) {
+		workers, err := workerService.List(c.Request.Context())
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": workers})
+	})
+}
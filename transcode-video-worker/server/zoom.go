@@ -0,0 +1,84 @@
+package server
+
+import (
+	"net/http"
+	"worker-transcode/dto"
+	"worker-transcode/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxWebhookBody bounds a Zoom webhook's body, which lists a recording's
+// files and nothing larger.
+const maxWebhookBody = 1 << 20
+
+func addZoom(r *gin.RouterGroup, zoomService service.ZoomService) {
+	r.PUT("/tenants/:id/zoom", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var request dto.ZoomConnectionRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		connection, err := zoomService.Connect(c.Request.Context(), id, request)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": connection})
+	})
+
+	// Recordings of the meeting completed from then on become the lesson's
+	// video.
+	r.PUT("/tenants/:id/zoom/meetings", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var request dto.ZoomMeetingRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		meeting, err := zoomService.LinkMeeting(c.Request.Context(), id, request)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": meeting})
+	})
+}
+
+// addZoomWebhook takes the events of tenants' Zoom apps. Zoom can't send the
+// API token, so the route is outside the API group and its events are
+// checked against the app's signature instead.
+func addZoomWebhook(r gin.IRoutes, zoomService service.ZoomService) {
+	r.POST("/webhooks/zoom/:tenant", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("tenant"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxWebhookBody)
+		body, err := c.GetRawData()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		response, err := zoomService.Webhook(c.Request.Context(), id, c.GetHeader("x-zm-request-timestamp"), c.GetHeader("x-zm-signature"), body)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, response)
+	})
+}
@@ -0,0 +1,309 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"worker-transcode/config"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/ffmpeg"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// legibilityHeight is the smallest rendition students on poor connections
+// fall back to, where on-screen text has to stay readable.
+const legibilityHeight = 240
+
+var ssimPattern = regexp.MustCompile(`n:(\d+) .*All:([0-9.]+)`)
+
+// AccessibilityService reports how each lesson video does on the checks
+// compliance teams audit courses against: captions, audio description,
+// loudness and whether on-screen text survives the 240p rendition.
+type AccessibilityService interface {
+	// Analyze measures the job's source once it is published and saves the
+	// lesson's report in place of its previous video's.
+	Analyze(ctx context.Context, job *entities.Job, inputFilepath, audioFilepath string, dubs []dubbedAudio, duration float64) error
+	Report(ctx context.Context, lessonId uuid.UUID) (*dto.AccessibilityReport, error)
+	Course(ctx context.Context, courseId uuid.UUID) (*dto.CourseAccessibility, error)
+}
+
+type accessibilityService struct {
+	repo repository.AccessibilityRepository
+	cfg  *config.Config
+}
+
+// loudness is the analysis pass of ffmpeg's loudnorm filter.
+type loudness struct {
+	Integrated string `json:"input_i"`
+	TruePeak   string `json:"input_tp"`
+	Range      string `json:"input_lra"`
+}
+
+func (s *accessibilityService) Analyze(ctx context.Context, job *entities.Job, inputFilepath, audioFilepath string, dubs []dubbedAudio, duration float64) error {
+	dir, err := scratchDir(ctx, s.cfg, filepath.Join(job.ID.String(), "accessibility"))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	report := &entities.AccessibilityReport{
+		LessonId:        job.EntityId,
+		JobId:           job.ID,
+		DurationSeconds: duration,
+		Legibility240p:  1,
+	}
+	for _, dub := range dubs {
+		report.AudioDescription = report.AudioDescription || dub.description
+	}
+
+	info, err := ProbeMedia(ctx, inputFilepath)
+	if err != nil {
+		return err
+	}
+	audioSource := inputFilepath
+	if audioFilepath != "" {
+		audioSource = audioFilepath
+	}
+	hasAudio := audioFilepath != ""
+	for _, stream := range info.Streams {
+		hasAudio = hasAudio || stream.CodecType == "audio"
+	}
+	if hasAudio {
+		measured, err := measureLoudness(ctx, audioSource, "")
+		if err != nil {
+			return err
+		}
+		report.IntegratedLUFS = parseLoudness(measured.Integrated)
+		report.TruePeakDBTP = parseLoudness(measured.TruePeak)
+		report.LoudnessRangeLU = parseLoudness(measured.Range)
+		compliant := report.IntegratedLUFS != nil && report.TruePeakDBTP != nil &&
+			math.Abs(*report.IntegratedLUFS-s.cfg.Accessibility.LoudnessTarget) <= s.cfg.Accessibility.LoudnessTolerance &&
+			*report.TruePeakDBTP <= s.cfg.Accessibility.MaxTruePeak
+		report.LoudnessCompliant = &compliant
+	}
+
+	if video := info.VideoStream(); video != nil && video.Height > legibilityHeight {
+		interval := max(s.cfg.Accessibility.SampleInterval, 1)
+		report.Legibility240p, report.LeastLegibleAt, err = measureLegibility(ctx, inputFilepath, video, dir, interval)
+		if err != nil {
+			return err
+		}
+	}
+	report.LegibilityCompliant = report.Legibility240p >= s.cfg.Accessibility.MinLegibility
+
+	if err := s.repo.SaveAccessibilityReport(ctx, report); err != nil {
+		return err
+	}
+	addJSONArtifact(ctx, "qc/accessibility.json", report)
+
+	event := zerolog.Ctx(ctx).Info().
+		Bool("audio_description", report.AudioDescription).
+		Float64("legibility_240p", report.Legibility240p)
+	if report.IntegratedLUFS != nil {
+		event = event.Float64("integrated_lufs", *report.IntegratedLUFS)
+	}
+	event.Msg("accessibility checked")
+	return nil
+}
+
+func (s *accessibilityService) Report(ctx context.Context, lessonId uuid.UUID) (*dto.AccessibilityReport, error) {
+	report, err := s.repo.FindAccessibilityReport(ctx, lessonId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	captions, err := s.repo.ListCaptions(ctx, []uuid.UUID{lessonId})
+	if err != nil {
+		return nil, err
+	}
+	result := s.assess(report, captions)
+	return &result, nil
+}
+
+func (s *accessibilityService) Course(ctx context.Context, courseId uuid.UUID) (*dto.CourseAccessibility, error) {
+	reports, err := s.repo.ListCourseAccessibilityReports(ctx, courseId)
+	if err != nil {
+		return nil, err
+	}
+	lessonIds := make([]uuid.UUID, 0, len(reports))
+	for _, report := range reports {
+		lessonIds = append(lessonIds, report.LessonId)
+	}
+	captions, err := s.repo.ListCaptions(ctx, lessonIds)
+	if err != nil {
+		return nil, err
+	}
+	byLesson := make(map[uuid.UUID][]dto.LessonCaptions)
+	for _, caption := range captions {
+		byLesson[caption.LessonId] = append(byLesson[caption.LessonId], caption)
+	}
+
+	course := &dto.CourseAccessibility{
+		CourseId: courseId,
+		Checked:  len(reports),
+		Lessons:  make([]dto.AccessibilityReport, 0, len(reports)),
+	}
+	for _, report := range reports {
+		result := s.assess(report, byLesson[report.LessonId])
+		if result.Compliant {
+			course.Compliant++
+		}
+		course.Lessons = append(course.Lessons, result)
+	}
+	return course, nil
+}
+
+// assess checks a lesson's report together with its captions as they are
+// now, since captions are usually added after the video.
+func (s *accessibilityService) assess(report *entities.AccessibilityReport, captions []dto.LessonCaptions) dto.AccessibilityReport {
+	result := dto.AccessibilityReport{
+		AccessibilityReport: report,
+		CaptionLanguages:    make([]string, 0, len(captions)),
+		Issues:              []string{},
+	}
+	for _, caption := range captions {
+		result.CaptionLanguages = append(result.CaptionLanguages, caption.Language)
+		if report.DurationSeconds > 0 {
+			result.CaptionCoverage = max(result.CaptionCoverage, min(caption.CoveredSeconds/report.DurationSeconds, 1))
+		}
+	}
+	result.CaptionsCompliant = len(captions) > 0 && result.CaptionCoverage >= s.cfg.Accessibility.MinCaptionCoverage
+
+	switch {
+	case len(captions) == 0:
+		result.Issues = append(result.Issues, "no captions")
+	case !result.CaptionsCompliant:
+		result.Issues = append(result.Issues, fmt.Sprintf("captions cover %.0f%% of the video", result.CaptionCoverage*100))
+	}
+	if !report.AudioDescription && s.cfg.Accessibility.RequireAudioDescription {
+		result.Issues = append(result.Issues, "no audio description")
+	}
+	if report.LoudnessCompliant != nil && !*report.LoudnessCompliant {
+		result.Issues = append(result.Issues, "loudness out of range")
+	}
+	if !report.LegibilityCompliant {
+		result.Issues = append(result.Issues, fmt.Sprintf("text may be illegible at %dp from %s", legibilityHeight, clockTime(report.LeastLegibleAt)))
+	}
+	result.Compliant = len(result.Issues) == 0
+	return result
+}
+
+// measureLoudness runs the analysis pass of the loudnorm filter over the
+// first audio stream, after filter when it isn't empty.
+func measureLoudness(ctx context.Context, inputFilepath, filter string) (*loudness, error) {
+	graph := "loudnorm=print_format=json"
+	if filter != "" {
+		graph = filter + "," + graph
+	}
+	output, err := ffmpegStderr(ctx, []string{"-hide_banner", "-nostats", "-i", inputFilepath,
+		"-map", "0:a:0",
+		"-af", graph,
+		"-f", "null", "-",
+	})
+	if err != nil {
+		return nil, err
+	}
+	start, end := strings.LastIndex(output, "{"), strings.LastIndex(output, "}")
+	if start < 0 || end < start {
+		return nil, errors.New("loudnorm printed no measurement")
+	}
+	var measured loudness
+	if err := json.Unmarshal([]byte(output[start:end+1]), &measured); err != nil {
+		return nil, fmt.Errorf("parse loudness: %w", err)
+	}
+	return &measured, nil
+}
+
+// parseLoudness returns nil for silence, which loudnorm measures as -inf.
+func parseLoudness(value string) *float64 {
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsInf(parsed, 0) || math.IsNaN(parsed) {
+		return nil
+	}
+	return &parsed
+}
+
+// measureLegibility samples a frame every interval seconds, scales it down to
+// legibilityHeight lines and back up, and compares the two. Small text is the
+// first detail lost, so the worst frame's SSIM stands for the video; it
+// returns it with where that frame is. Frames are compared at no more than
+// 1080 lines to bound the cost.
+func measureLegibility(ctx context.Context, inputFilepath string, video *ProbeStream, dir string, interval int) (float64, float64, error) {
+	height := min(video.Height, 1080) &^ 1
+	width := (video.Width*height/video.Height + 1) &^ 1
+	stats := filepath.Join(dir, "ssim.log")
+	graph := fmt.Sprintf("[0:v:0]fps=1/%d,scale=%d:%d,format=yuv420p,split[ref][low];"+
+		"[low]scale=-2:%d:flags=area,scale=%d:%d:flags=bicubic[up];[ref][up]ssim=stats_file=%s",
+		interval, width, height, legibilityHeight, width, height, stats)
+	_, err := ffmpegStderr(ctx, []string{"-hide_banner", "-nostats", "-i", inputFilepath,
+		"-filter_complex", graph,
+		"-f", "null", "-",
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	file, err := os.Open(stats)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	least, at := 1.0, 0.0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		match := ssimPattern.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		frame, _ := strconv.Atoi(match[1])
+		ssim, err := strconv.ParseFloat(match[2], 64)
+		if err == nil && ssim < least {
+			least, at = ssim, float64((frame-1)*interval)
+		}
+	}
+	return least, at, scanner.Err()
+}
+
+// ffmpegStderr runs an ffmpeg analysis pass, returning the tail of what it
+// wrote to stderr, where filters print their measurements.
+func ffmpegStderr(ctx context.Context, args []string) (string, error) {
+	cmd := ffmpeg.Command(ctx, args...)
+	killProcessGroup(cmd)
+	cmd.WaitDelay = ffmpegWaitDelay
+	zerolog.Ctx(ctx).Info().Str("command", strings.Join(cmd.Args, " ")).Msg("executing FFmpeg command")
+
+	stderr := &tailBuffer{limit: maxFFmpegOutput}
+	cmd.Stderr = stderr
+	done := trackFFmpeg()
+	defer done()
+	if err := cmd.Run(); err != nil {
+		return "", &FFmpegError{Err: err, Output: stderr.String()}
+	}
+	return stderr.String(), nil
+}
+
+func NewAccessibilityService(repo repository.AccessibilityRepository, cfg *config.Config) AccessibilityService {
+	return &accessibilityService{
+		repo: repo,
+		cfg:  cfg,
+	}
+}
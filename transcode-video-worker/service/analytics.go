@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/dto"
+	"worker-transcode/pkg/rabbitmq"
+
+	"github.com/google/uuid"
+)
+
+const (
+	MediaEventProcessed = "video.processed"
+	MediaEventFailed    = "video.failed"
+
+	mediaEventSchemaVersion = 1
+)
+
+// AnalyticsService publishes pipeline events to the analytics exchange so
+// reporting never has to read the operational database.
+type AnalyticsService interface {
+	Publish(ctx context.Context, event dto.MediaEvent) error
+}
+
+type analyticsService struct {
+	publisher rabbitmq.Publisher
+	cfg       *config.Config
+}
+
+// Publish stamps the envelope fields and sends the event with the event type
+// as routing key, e.g. media.video.processed.
+func (s *analyticsService) Publish(ctx context.Context, event dto.MediaEvent) error {
+	if !s.cfg.Analytics.Enabled {
+		return nil
+	}
+	event.EventId = uuid.New()
+	event.SchemaVersion = mediaEventSchemaVersion
+	event.OccurredAt = time.Now().UTC()
+	return s.publisher.Publish(ctx, s.cfg.Analytics.Exchange, "media."+event.EventType, event)
+}
+
+func NewAnalyticsService(publisher rabbitmq.Publisher, cfg *config.Config) AnalyticsService {
+	return &analyticsService{
+		publisher: publisher,
+		cfg:       cfg,
+	}
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/pkg/objectstore"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+)
+
+// artifactsPrefix is where job artifact bundles are stored, one object per
+// job.
+const artifactsPrefix = "artifacts/"
+
+type artifactsKey struct{}
+
+// jobArtifacts collects the diagnostic files of one job: ffmpeg logs, the
+// source probe and the QC and verification reports. They are bundled into
+// a single gzipped tar at the end of the job rather than stored as many
+// tiny objects. They travel in the context like the timeline does.
+type jobArtifacts struct {
+	mu    sync.Mutex
+	files []artifact
+	runs  int
+}
+
+type artifact struct {
+	name string
+	data []byte
+}
+
+func withArtifacts(ctx context.Context) (context.Context, *jobArtifacts) {
+	artifacts := &jobArtifacts{}
+	return context.WithValue(ctx, artifactsKey{}, artifacts), artifacts
+}
+
+// addArtifact adds a file to the job's bundle, if ctx collects one.
+func addArtifact(ctx context.Context, name string, data []byte) {
+	artifacts, ok := ctx.Value(artifactsKey{}).(*jobArtifacts)
+	if !ok {
+		return
+	}
+	artifacts.mu.Lock()
+	defer artifacts.mu.Unlock()
+	artifacts.files = append(artifacts.files, artifact{name: name, data: data})
+}
+
+func addJSONArtifact(ctx context.Context, name string, v interface{}) {
+	if _, ok := ctx.Value(artifactsKey{}).(*jobArtifacts); !ok {
+		return
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("artifact", name).Msg("failed to encode job artifact")
+		return
+	}
+	addArtifact(ctx, name, data)
+}
+
+// addFFmpegLog adds the command and stderr of an ffmpeg run, numbered in
+// the order the runs finished.
+func addFFmpegLog(ctx context.Context, command, stderr string) {
+	artifacts, ok := ctx.Value(artifactsKey{}).(*jobArtifacts)
+	if !ok {
+		return
+	}
+	artifacts.mu.Lock()
+	artifacts.runs++
+	name := fmt.Sprintf("ffmpeg/%03d.log", artifacts.runs)
+	artifacts.mu.Unlock()
+	addArtifact(ctx, name, []byte(command+"\n\n"+stderr))
+}
+
+// artifactsKeyFor is the object a job's bundle is stored under. A retried
+// job replaces the bundle of its earlier attempt.
+func artifactsKeyFor(jobId uuid.UUID) string {
+	return path.Join(artifactsPrefix, jobId.String()+".tar.gz")
+}
+
+// upload stores the collected files as one gzipped tar. Nothing is stored
+// when nothing was collected.
+func (a *jobArtifacts) upload(ctx context.Context, store objectstore.Store, cfg *config.Config, jobId uuid.UUID) error {
+	a.mu.Lock()
+	files := a.files
+	a.mu.Unlock()
+	if len(files) == 0 {
+		return nil
+	}
+
+	var body bytes.Buffer
+	if err := writeTarGz(&body, files); err != nil {
+		return err
+	}
+	_, err := store.PutObject(ctx, cfg.MinIOBucket, artifactsKeyFor(jobId), &body, int64(body.Len()), minio.PutObjectOptions{
+		ContentType: "application/gzip",
+	})
+	return err
+}
+
+// writeTarGz writes files to w as a gzipped tar.
+func writeTarGz(w io.Writer, files []artifact) error {
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+	now := time.Now()
+	for _, file := range files {
+		header := &tar.Header{Name: file.name, Mode: 0o644, Size: int64(len(file.data)), ModTime: now}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := archive.Write(file.data); err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,511 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/correlation"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/pkg/reporting"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// audioDurationTolerance is how far, in seconds, a corrected recording may
+// run from the video's length. A shorter one is padded with silence and a
+// longer one cut; anything further off is a different take, which would
+// drift out of sync.
+const audioDurationTolerance = 1.0
+
+var presetDataPattern = regexp.MustCompile(`DATA-ID="` + regexp.QuoteMeta(presetDataId) + `",VALUE="([^"]+)/(\d+)"`)
+
+// AudioReplacementService puts a corrected recording in place of the audio of
+// a published lesson video, as after a bad microphone. Packages carry their
+// audio as a rendition of its own, so only it is encoded: the video
+// renditions are copied into a new version of the package and the
+// progressive copies muxed again, and the lesson switches to that version
+// like it would to a new transcode.
+type AudioReplacementService interface {
+	// Request queues the replacement of the lesson's audio.
+	Request(ctx context.Context, lessonId uuid.UUID, request dto.AudioReplacementRequest) (*entities.AudioReplacement, error)
+	Find(ctx context.Context, id uuid.UUID) (*entities.AudioReplacement, error)
+	// Process runs an audio replacement job.
+	Process(ctx context.Context, message dto.AudioReplacementMessage) error
+}
+
+type audioReplacementService struct {
+	repo      repository.AudioReplacementRepository
+	presets   PresetService
+	jobs      repository.JobRepository
+	events    repository.JobEventRepository
+	versions  VideoVersionService
+	publisher rabbitmq.Publisher
+	store     objectstore.Store
+	cfg       *config.Config
+}
+
+func (s *audioReplacementService) Request(ctx context.Context, lessonId uuid.UUID, request dto.AudioReplacementRequest) (*entities.AudioReplacement, error) {
+	playlist, err := s.repo.FindLessonVideo(ctx, lessonId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !isHLSSource(playlist) {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("lesson %s has no published video", lessonId))
+	}
+	if (request.ObjectPath == "") == (len(request.Bleeps) == 0) {
+		return nil, errors.Join(ErrInvalidArgument, errors.New("either object_path or bleeps is required"))
+	}
+	for i, bleep := range request.Bleeps {
+		if bleep.Start < 0 || bleep.End <= bleep.Start {
+			return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("bleep %d ends before it starts", i))
+		}
+	}
+	if request.ObjectPath != "" {
+		if _, err := s.store.StatObject(ctx, s.cfg.MinIOBucket, request.ObjectPath, minio.StatObjectOptions{}); err != nil {
+			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+				return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("object_path %s is not in the bucket", request.ObjectPath))
+			}
+			return nil, err
+		}
+	}
+
+	job := &entities.Job{
+		ID:         uuid.New(),
+		EntityId:   lessonId,
+		EntityType: string(constant.EntityTypeLessonVideo),
+		Status:     constant.JobStatusPending,
+		JobType:    constant.JobTypeAudioReplace,
+		UserId:     request.UserId,
+	}
+	if correlationId := correlation.FromContext(ctx); correlationId != "" {
+		job.CorrelationId = &correlationId
+	}
+	replacement := &entities.AudioReplacement{
+		ID:         uuid.New(),
+		LessonId:   lessonId,
+		JobId:      job.ID,
+		ObjectPath: request.ObjectPath,
+		Bleeps:     request.Bleeps,
+	}
+
+	if err := s.jobs.CreateJob(ctx, job); err != nil {
+		return nil, err
+	}
+	if err := s.repo.CreateReplacement(ctx, replacement); err != nil {
+		return nil, err
+	}
+	message := dto.AudioReplacementMessage{JobId: job.ID}
+	if err := s.publisher.Publish(ctx, rabbitmq.AudioReplacementTopology.Exchange, rabbitmq.AudioReplacementTopology.RoutingKey, message); err != nil {
+		return nil, err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("job_id", job.ID.String()).
+		Str("lesson_id", lessonId.String()).
+		Str("object_path", request.ObjectPath).
+		Int("bleeps", len(request.Bleeps)).
+		Msg("audio replacement queued")
+	return replacement, nil
+}
+
+func (s *audioReplacementService) Find(ctx context.Context, id uuid.UUID) (*entities.AudioReplacement, error) {
+	replacement, err := s.repo.FindReplacement(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	return replacement, err
+}
+
+func (s *audioReplacementService) Process(ctx context.Context, message dto.AudioReplacementMessage) (err error) {
+	job, err := s.jobs.FindJobById(ctx, message.JobId)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to find job by id")
+		return err
+	}
+	if job.Status != constant.JobStatusPending {
+		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("job is not pending")
+		return nil
+	}
+	replacement, err := s.repo.FindReplacementByJob(ctx, message.JobId)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to find audio replacement")
+		return err
+	}
+
+	claimed, err := s.jobs.ClaimJob(ctx, message.JobId, correlation.FromContext(ctx), workerFromContext(ctx))
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to update job status")
+		return err
+	}
+	if !claimed {
+		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("job already claimed by another delivery")
+		return nil
+	}
+	rabbitmq.Claimed(ctx)
+	ctx = withTimeline(ctx, s.events, job.ID)
+	recordStatus(ctx, constant.JobStatusPending, constant.JobStatusProcessing)
+
+	stage := constant.ErrorClassWorkspace
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = panicked(ctx, recovered)
+		}
+		if shuttingDown(ctx, err) {
+			err = handOff(ctx, s.jobs, message.JobId, err)
+			return
+		}
+		err = classify(err)
+		recordOutcome(ctx, job, stage, err)
+		recordOutcomeEvents(ctx, stage, err)
+		if err != nil {
+			if errors.Is(err, ErrNonRetryable) {
+				if updateErr := s.jobs.FailJob(ctx, message.JobId, stage, errorKind(err), err.Error()); updateErr != nil {
+					zerolog.Ctx(ctx).Error().Err(updateErr).Msg("failed to update job status")
+				}
+				reporting.CaptureFailure(ctx, err, reporting.Failure{
+					JobId:   message.JobId.String(),
+					JobType: string(job.JobType),
+					Stage:   string(stage),
+					Output:  failureOutput(err),
+					Extra:   map[string]interface{}{"lesson_id": replacement.LessonId.String(), "object_path": replacement.ObjectPath},
+				})
+				err = nil
+			} else {
+				if updateErr := s.jobs.UpdateStatusJob(ctx, constant.JobStatusPending, message.JobId); updateErr != nil {
+					zerolog.Ctx(ctx).Error().Err(updateErr).Msg("failed to update job status")
+				}
+			}
+		}
+	}()
+
+	tempDir := filepath.Join("temp", message.JobId.String())
+	defer os.RemoveAll(tempDir)
+	packageDir := filepath.Join(tempDir, "package")
+	if err = os.MkdirAll(packageDir, os.ModePerm); err != nil {
+		return errors.Join(ErrNonRetryable, err)
+	}
+
+	stage = constant.ErrorClassDatabase
+	playlist, err := s.repo.FindLessonVideo(ctx, replacement.LessonId)
+	if err != nil {
+		return err
+	}
+	if !isHLSSource(playlist) {
+		return errors.Join(ErrNonRetryable, fmt.Errorf("lesson %s has no published video", replacement.LessonId))
+	}
+
+	stage = constant.ErrorClassPackage
+	layout, err := s.readPackage(ctx, playlist)
+	if err != nil {
+		return err
+	}
+
+	stage = constant.ErrorClassDownload
+	input := filepath.Join(tempDir, "recording"+path.Ext(replacement.ObjectPath))
+	if replacement.ObjectPath == "" {
+		// A bleep keeps the lesson's own audio, as the package carries it.
+		input, err = downloadMediaPlaylist(ctx, s.store, s.cfg.MinIOBucket, layout.prefix, path.Base(layout.audio), filepath.Join(tempDir, "current"))
+	} else {
+		err = traceStage(ctx, "download", func(ctx context.Context) error {
+			return s.store.FGetObject(ctx, s.cfg.MinIOBucket, replacement.ObjectPath, input, minio.GetObjectOptions{})
+		})
+	}
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to download audio")
+		return err
+	}
+
+	stage = constant.ErrorClassProbe
+	media, err := ProbeMedia(ctx, input)
+	if err != nil {
+		return errors.Join(ErrNonRetryable, err)
+	}
+	if media.AudioStream() == nil {
+		return errors.Join(ErrNonRetryable, ErrInvalidArgument, fmt.Errorf("%s has no audio", replacement.ObjectPath))
+	}
+	if math.Abs(media.DurationSeconds()-layout.duration) > audioDurationTolerance {
+		return errors.Join(ErrNonRetryable, ErrInvalidArgument,
+			fmt.Errorf("the recording is %.1f seconds long and the video %.1f", media.DurationSeconds(), layout.duration))
+	}
+
+	stage = constant.ErrorClassTranscode
+	if len(replacement.Bleeps) > 0 {
+		bleeped := filepath.Join(tempDir, "bleeped.wav")
+		err = traceStage(ctx, "bleep", func(ctx context.Context) error {
+			return runFFmpeg(ctx, bleepArgs(input, bleeped, replacement.Bleeps, media.AudioStream()), nil)
+		})
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to bleep audio")
+			return errors.Join(ErrNonRetryable, err)
+		}
+		input = bleeped
+	}
+	audioPlaylist := filepath.Join(packageDir, "audio.m3u8")
+	err = traceStage(ctx, "audio", func(ctx context.Context) error {
+		return runFFmpeg(ctx, replacementAudioArgs(layout.preset, input, packageDir, layout.duration), nil)
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to encode corrected audio")
+		return errors.Join(ErrNonRetryable, err)
+	}
+
+	// The progressive copies are muxed from the packaged video and the new
+	// audio, as the transcode muxed them.
+	var remuxed []string
+	for _, r := range layout.preset.Renditions {
+		if r.Container == "" {
+			continue
+		}
+		stage = constant.ErrorClassDownload
+		rung := fmt.Sprintf("%dp.m3u8", r.Height)
+		if _, err = downloadMediaPlaylist(ctx, s.store, s.cfg.MinIOBucket, layout.prefix, rung, packageDir); err != nil {
+			return err
+		}
+		stage = constant.ErrorClassPackage
+		output := filepath.Join(packageDir, progressiveName(r))
+		if err = runFFmpeg(ctx, progressiveArgs(r, filepath.Join(packageDir, rung), audioPlaylist, output, s.cfg.Server.FFmpegThreads), nil); err != nil {
+			return errors.Join(ErrNonRetryable, fmt.Errorf("progressive %s: %w", progressiveName(r), err))
+		}
+		remuxed = append(remuxed, progressiveName(r))
+	}
+
+	stage = constant.ErrorClassUpload
+	replaced := path.Join(packagePrefix(playlist, job.ID), path.Base(playlist))
+	err = traceStage(ctx, "upload", func(ctx context.Context) error {
+		return s.writeVersion(ctx, layout, path.Dir(replaced), audioPlaylist, remuxed)
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to upload package with corrected audio")
+		return err
+	}
+
+	stage = constant.ErrorClassDatabase
+	if err = s.versions.Publish(ctx, job, replaced); err != nil {
+		return err
+	}
+	if err = s.repo.MarkReplaced(ctx, replacement.ID, playlist, replaced, len(remuxed)); err != nil {
+		return err
+	}
+	if err = s.jobs.UpdateStatusJob(ctx, constant.JobStatusCompleted, message.JobId); err != nil {
+		return err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("job_id", message.JobId.String()).
+		Str("lesson_id", replacement.LessonId.String()).
+		Str("playlist", replaced).
+		Int("remuxed", len(remuxed)).
+		Msg("lesson audio replaced")
+	return nil
+}
+
+// replacementPackage is what of a published package the replacement keeps
+// and what it replaces.
+type replacementPackage struct {
+	prefix   string
+	preset   *entities.Preset
+	duration float64
+	// audio is the default audio rendition's playlist and replaced the
+	// objects made again: it, its segments and the progressive copies.
+	audio    string
+	replaced map[string]bool
+}
+
+// readPackage reads the preset a package was encoded with from its master
+// playlist, how long its video runs and which objects carry its audio. A
+// package whose audio is muxed into its variants, or is encrypted, can't
+// have it replaced without encoding the video again, so it is rejected.
+func (s *audioReplacementService) readPackage(ctx context.Context, playlist string) (*replacementPackage, error) {
+	master, err := readObjectLines(ctx, s.store, s.cfg.MinIOBucket, playlist)
+	if err != nil {
+		return nil, fmt.Errorf("read master playlist: %w", err)
+	}
+	layout := &replacementPackage{prefix: path.Dir(playlist), replaced: map[string]bool{}}
+
+	layout.preset, err = masterPreset(ctx, s.presets, master, playlist)
+	if err != nil {
+		return nil, err
+	}
+
+	videoURI, audioURI := highestVariant(master)
+	if videoURI == "" || audioURI != "audio.m3u8" {
+		return nil, errors.Join(ErrNonRetryable, ErrInvalidArgument,
+			fmt.Errorf("%s has no separate audio rendition, transcode the lesson again instead", playlist))
+	}
+	video, err := readObjectLines(ctx, s.store, s.cfg.MinIOBucket, path.Join(layout.prefix, videoURI))
+	if err != nil {
+		return nil, fmt.Errorf("read media playlist: %w", err)
+	}
+	layout.duration = segmentSeconds(video)
+
+	layout.audio = path.Join(layout.prefix, audioURI)
+	audio, err := readObjectLines(ctx, s.store, s.cfg.MinIOBucket, layout.audio)
+	if err != nil {
+		return nil, fmt.Errorf("read audio playlist: %w", err)
+	}
+	layout.replaced[layout.audio] = true
+	for _, line := range audio {
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-KEY:") && !strings.Contains(line, "METHOD=NONE"):
+			return nil, errors.Join(ErrNonRetryable, ErrInvalidArgument,
+				fmt.Errorf("%s is encrypted, transcode the lesson again instead", playlist))
+		case line == "" || strings.HasPrefix(line, "#"):
+		default:
+			layout.replaced[path.Join(path.Dir(layout.audio), line)] = true
+		}
+	}
+	for _, r := range layout.preset.Renditions {
+		if r.Container != "" {
+			layout.replaced[path.Join(layout.prefix, progressiveName(r))] = true
+		}
+	}
+	return layout, nil
+}
+
+// masterPreset returns the preset a package was encoded with, named in its
+// master playlist's session data. One that names none, or a preset since
+// deleted, can't have its renditions made again like the transcode made them.
+func masterPreset(ctx context.Context, presets PresetService, master []string, playlist string) (*entities.Preset, error) {
+	var presetMatch []string
+	for _, line := range master {
+		if match := presetDataPattern.FindStringSubmatch(line); match != nil {
+			presetMatch = match
+		}
+	}
+	if presetMatch == nil {
+		return nil, errors.Join(ErrNonRetryable, fmt.Errorf("%s names no preset, transcode the lesson again instead", playlist))
+	}
+	version, _ := strconv.Atoi(presetMatch[2])
+	preset, err := presets.Get(ctx, presetMatch[1], version)
+	if errors.Is(err, ErrNotFound) {
+		return nil, errors.Join(ErrNonRetryable, err)
+	}
+	return preset, err
+}
+
+// writeVersion writes the new version under prefix: the new audio rendition
+// and progressive copies, and every other object of the package copied as
+// it is.
+func (s *audioReplacementService) writeVersion(ctx context.Context, layout *replacementPackage, prefix, audioPlaylist string, remuxed []string) error {
+	from := layout.prefix + "/"
+	for object := range s.store.ListObjects(ctx, s.cfg.MinIOBucket, minio.ListObjectsOptions{Prefix: from, Recursive: true}) {
+		if object.Err != nil {
+			return fmt.Errorf("list package: %w", object.Err)
+		}
+		if layout.replaced[object.Key] {
+			continue
+		}
+		destination := path.Join(prefix, strings.TrimPrefix(object.Key, from))
+		_, err := s.store.CopyObject(ctx,
+			minio.CopyDestOptions{Bucket: s.cfg.MinIOBucket, Object: destination},
+			minio.CopySrcOptions{Bucket: s.cfg.MinIOBucket, Object: object.Key})
+		if err != nil {
+			return fmt.Errorf("copy %s: %w", object.Key, err)
+		}
+	}
+
+	lines, err := readLines(audioPlaylist)
+	if err != nil {
+		return err
+	}
+	files := []string{path.Base(layout.audio)}
+	for _, line := range lines {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			files = append(files, line)
+		}
+	}
+	files = append(files, remuxed...)
+	for _, name := range files {
+		local := filepath.Join(filepath.Dir(audioPlaylist), filepath.FromSlash(name))
+		destination := path.Join(prefix, name)
+		if _, err := s.store.FPutObject(ctx, s.cfg.MinIOBucket, destination, local, minio.PutObjectOptions{}); err != nil {
+			return fmt.Errorf("upload %s: %w", destination, err)
+		}
+	}
+	return nil
+}
+
+// replacementAudioArgs encode the first audio stream of input as the
+// package's audio rendition into outputDir, as the transcode encoded it,
+// padded or cut to duration so it ends with the video.
+func replacementAudioArgs(preset *entities.Preset, input, outputDir string, duration float64) []string {
+	audioRate := "96k"
+	if len(preset.Renditions) > 0 {
+		audioRate = preset.Renditions[len(preset.Renditions)-1].AudioRate
+	}
+	args := []string{"-i", input,
+		"-map", "0:a:0",
+		"-af", "apad",
+		"-t", strconv.FormatFloat(duration, 'f', 3, 64),
+		"-c:a", preset.AudioCodec,
+		"-b:a", audioRate,
+	}
+	args = append(args, hlsOutputArgs(preset)...)
+	return append(args,
+		"-hls_segment_filename", filepath.Join(outputDir, "audio_%03d.ts"),
+		"-y", filepath.Join(outputDir, "audio.m3u8"))
+}
+
+// bleepArgs write the first audio stream of input to output, a WAV file,
+// silenced over the bleeps with a 1 kHz tone played in their place.
+func bleepArgs(input, output string, bleeps entities.Bleeps, stream *ProbeStream) []string {
+	spans := make([]string, 0, len(bleeps))
+	for _, bleep := range bleeps {
+		spans = append(spans, fmt.Sprintf("between(t,%.3f,%.3f)", bleep.Start, bleep.End))
+	}
+	during := strings.Join(spans, "+")
+
+	layout := "stereo"
+	switch {
+	case stream.ChannelLayout != "":
+		layout = stream.ChannelLayout
+	case stream.Channels == 1:
+		layout = "mono"
+	}
+	format := "aformat=sample_fmts=fltp:sample_rates=48000:channel_layouts=" + layout
+	// amix halves each input, so the sum is doubled back; the tone is only
+	// heard while the audio is silenced.
+	filter := fmt.Sprintf("[0:a:0]%s,volume=0:enable='%s'[muted];"+
+		"sine=frequency=1000:sample_rate=48000,%s,volume=0.25,volume=0:enable='not(%s)'[tone];"+
+		"[muted][tone]amix=inputs=2:duration=first:dropout_transition=0,volume=2[out]",
+		format, during, format, during)
+	return []string{"-hide_banner", "-nostats", "-y",
+		"-i", input,
+		"-filter_complex", filter,
+		"-map", "[out]",
+		"-c:a", "pcm_s16le",
+		output,
+	}
+}
+
+func NewAudioReplacementService(repo repository.AudioReplacementRepository, presets PresetService, jobs repository.JobRepository, events repository.JobEventRepository, versions VideoVersionService, publisher rabbitmq.Publisher, store objectstore.Store, cfg *config.Config) AudioReplacementService {
+	return &audioReplacementService{
+		repo:      repo,
+		presets:   presets,
+		jobs:      jobs,
+		events:    events,
+		versions:  versions,
+		publisher: publisher,
+		store:     store,
+		cfg:       cfg,
+	}
+}
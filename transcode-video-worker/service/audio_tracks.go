@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"worker-transcode/dto"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// describesVideo is the HLS characteristic of an audio description, which
+// players pick when the viewer turned audio descriptions on in their
+// accessibility settings.
+const describesVideo = "public.accessibility.describes-video"
+
+// maxAudioTracks caps the dubbed tracks of a job, each being another audio
+// encode in the same ffmpeg run.
+const maxAudioTracks = 8
+
+// languageTagPattern accepts BCP 47 tags such as "vi", "pt-BR" or "zh-Hant",
+// which is what EXT-X-MEDIA's LANGUAGE attribute takes.
+var languageTagPattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// dubbedAudio is a downloaded dubbed track, encoded as an alternate audio
+// rendition next to the source's own audio.
+type dubbedAudio struct {
+	path        string
+	language    string
+	name        string
+	description bool
+}
+
+// playlist is the media playlist of the rendition under the output dir.
+func (d dubbedAudio) playlist() string {
+	return fmt.Sprintf("audio_%s.m3u8", d.key())
+}
+
+func (d dubbedAudio) segments() string {
+	return fmt.Sprintf("audio_%s_%%03d.ts", d.key())
+}
+
+func (d dubbedAudio) key() string {
+	return audioTrackKey(d.language, d.description)
+}
+
+// audioTrackKey tells tracks apart: one dub and one audio description per
+// language.
+func audioTrackKey(language string, description bool) string {
+	key := strings.ToLower(language)
+	if description {
+		key += "_ad"
+	}
+	return key
+}
+
+func parseAudioTracks(raw string) ([]dto.AudioTrack, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var tracks []dto.AudioTrack
+	if err := json.Unmarshal([]byte(raw), &tracks); err != nil {
+		return nil, err
+	}
+	return tracks, validateAudioTracks(tracks)
+}
+
+// validateAudioTracks checks the dubbed tracks of a job: each an uploaded
+// file with a language tag, and no language twice or the source's own, though
+// an audio description may be in any language.
+func validateAudioTracks(tracks []dto.AudioTrack) error {
+	if len(tracks) > maxAudioTracks {
+		return fmt.Errorf("at most %d audio tracks, got %d", maxAudioTracks, len(tracks))
+	}
+	seen := make(map[string]bool, len(tracks))
+	for i, track := range tracks {
+		if track.ObjectPath == "" {
+			return fmt.Errorf("audio track %d: object path is required", i+1)
+		}
+		if isHLSSource(track.ObjectPath) {
+			return fmt.Errorf("audio track %d: must be an audio file, not a playlist", i+1)
+		}
+		if !languageTagPattern.MatchString(track.Language) {
+			return fmt.Errorf("audio track %d: %q is not a language tag", i+1, track.Language)
+		}
+		key := audioTrackKey(track.Language, track.Description)
+		if key == sourceAudioLanguage {
+			return fmt.Errorf("audio track %d: %s is the language of the source's own audio", i+1, track.Language)
+		}
+		if seen[key] {
+			return fmt.Errorf("audio track %d: language %s is given twice", i+1, track.Language)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// downloadAudioTracks fetches the job's dubbed tracks into dir.
+func (s service) downloadAudioTracks(ctx context.Context, tracks []dto.AudioTrack, dir string) ([]dubbedAudio, error) {
+	dubs := make([]dubbedAudio, 0, len(tracks))
+	for i, track := range tracks {
+		local := filepath.Join(dir, fmt.Sprintf("dub_%d_%s", i, filepath.Base(track.ObjectPath)))
+		if err := s.store.FGetObject(ctx, s.cfg.MinIOBucket, track.ObjectPath, local, minio.GetObjectOptions{}); err != nil {
+			return nil, fmt.Errorf("download audio track %s: %w", track.ObjectPath, err)
+		}
+		dubs = append(dubs, dubbedTrack(track, local))
+	}
+	return dubs, nil
+}
+
+// dubbedTrack is the track, downloaded to local, as it's encoded.
+func dubbedTrack(track dto.AudioTrack, local string) dubbedAudio {
+	name := track.Name
+	switch {
+	case name != "":
+	case track.Description:
+		name = track.Language + " (audio description)"
+	default:
+		name = track.Language
+	}
+	return dubbedAudio{path: local, language: track.Language, name: name, description: track.Description}
+}
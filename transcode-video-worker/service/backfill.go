@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/correlation"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// backfillPriority ranks backfill jobs below uploads, which default to 0.
+const backfillPriority = -1
+
+const defaultBackfillRate = 10
+
+type BackfillService interface {
+	// Candidates lists the videos a request would re-transcode.
+	Candidates(ctx context.Context, request dto.BackfillRequest) ([]dto.BackfillCandidate, error)
+	// Run records a batch and queues a job per candidate on the backfill lane,
+	// at most request.RatePerMinute a minute, or its MAINTENANCE_THROTTLE_SHARE
+	// while no region is in a maintenance window. It returns when every job
+	// is queued or ctx is cancelled.
+	Run(ctx context.Context, request dto.BackfillRequest) (*entities.BackfillBatch, error)
+	Progress(ctx context.Context, id uuid.UUID) (*dto.BackfillProgress, error)
+}
+
+type backfillService struct {
+	repo      repository.BackfillRepository
+	jobs      repository.JobRepository
+	presets   PresetService
+	publisher rabbitmq.Publisher
+	store     objectstore.Store
+	cfg       *config.Config
+}
+
+func (s *backfillService) Candidates(ctx context.Context, request dto.BackfillRequest) ([]dto.BackfillCandidate, error) {
+	return s.repo.FindBackfillCandidates(ctx, request.Filters, request.Limit)
+}
+
+func (s *backfillService) Run(ctx context.Context, request dto.BackfillRequest) (*entities.BackfillBatch, error) {
+	if request.Preset != "" {
+		if _, err := s.presets.Resolve(ctx, request.Preset); err != nil {
+			return nil, err
+		}
+	}
+	rate := request.RatePerMinute
+	if rate <= 0 {
+		rate = defaultBackfillRate
+	}
+
+	candidates, err := s.Candidates(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("find candidates: %w", err)
+	}
+
+	batch := &entities.BackfillBatch{
+		ID:      uuid.New(),
+		Filters: request.Filters,
+		Status:  constant.BackfillStatusRunning,
+		Total:   len(candidates),
+	}
+	if request.Preset != "" {
+		batch.Preset = &request.Preset
+	}
+	if err := s.repo.CreateBackfillBatch(ctx, batch); err != nil {
+		return nil, err
+	}
+	logger := zerolog.Ctx(ctx).With().Str("batch_id", batch.ID.String()).Logger()
+	logger.Info().Int("total", batch.Total).Int("rate_per_minute", rate).Msg("backfill started")
+
+	for i, candidate := range candidates {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(backfillInterval(s.cfg, rate)):
+			}
+		}
+		if ctx.Err() != nil {
+			s.finish(ctx, batch, constant.BackfillStatusCancelled)
+			return batch, ctx.Err()
+		}
+
+		if _, err := queueRetranscode(ctx, s.store, s.cfg, s.jobs, s.publisher, candidate, request.Preset, &batch.ID); err != nil {
+			logger.Error().Err(err).Str("lesson_id", candidate.LessonId.String()).Msg("failed to queue backfill job")
+			s.finish(ctx, batch, constant.BackfillStatusFailed)
+			return batch, err
+		}
+		batch.Enqueued++
+		if err := s.repo.UpdateBackfillBatch(ctx, batch.ID, batch.Enqueued, batch.Status); err != nil {
+			logger.Warn().Err(err).Msg("failed to record backfill progress")
+		}
+	}
+
+	s.finish(ctx, batch, constant.BackfillStatusCompleted)
+	logger.Info().Int("enqueued", batch.Enqueued).Msg("backfill queued")
+	return batch, nil
+}
+
+// backfillInterval is the time between a batch's jobs at rate a minute, or
+// at the throttled rate while no region is in a maintenance window.
+func backfillInterval(cfg *config.Config, rate int) time.Duration {
+	if !cfg.Maintenance.AnyOpen(time.Now()) {
+		rate = cfg.Maintenance.Throttle(rate)
+	}
+	return time.Minute / time.Duration(rate)
+}
+
+// queueRetranscode creates and publishes a job re-transcoding a candidate
+// on the backfill lane, for batchId if it's part of a backfill batch. The
+// newest upload is preferred as the source; once it has been deleted the
+// published master playlist is re-encoded instead.
+func queueRetranscode(ctx context.Context, store objectstore.Store, cfg *config.Config, jobs repository.JobRepository, publisher rabbitmq.Publisher,
+	candidate dto.BackfillCandidate, preset string, batchId *uuid.UUID) (*entities.Job, error) {
+	source, err := latestUpload(ctx, store, cfg, candidate.LessonId)
+	if err != nil {
+		return nil, err
+	}
+	if source == "" {
+		if !strings.HasSuffix(candidate.VideoUrl, ".m3u8") {
+			return nil, fmt.Errorf("lesson video %q is neither an upload nor a playlist", candidate.VideoUrl)
+		}
+		source = candidate.VideoUrl
+	}
+
+	job := &entities.Job{
+		ID:              uuid.New(),
+		EntityId:        candidate.LessonId,
+		EntityType:      string(constant.EntityTypeLessonVideo),
+		Status:          constant.JobStatusPending,
+		JobType:         constant.JobTypeTranscoder,
+		Priority:        backfillPriority,
+		TenantId:        candidate.TenantId,
+		BackfillBatchId: batchId,
+	}
+	if correlationId := correlation.FromContext(ctx); correlationId != "" {
+		job.CorrelationId = &correlationId
+	}
+	if err := jobs.CreateJob(ctx, job); err != nil {
+		return nil, err
+	}
+
+	message := dto.JobMessage{
+		JobId:      job.ID,
+		ObjectPath: source,
+		FileName:   path.Base(source),
+		Preset:     preset,
+	}
+	return job, publisher.Publish(ctx, rabbitmq.BackfillTranscodeTopology.Exchange, rabbitmq.BackfillTranscodeTopology.RoutingKey, message)
+}
+
+// finish records the batch's final state. ctx may already be cancelled, so the
+// update gets its own deadline.
+func (s *backfillService) finish(ctx context.Context, batch *entities.BackfillBatch, status constant.BackfillStatus) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	batch.Status = status
+	if err := s.repo.UpdateBackfillBatch(ctx, batch.ID, batch.Enqueued, status); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("batch_id", batch.ID.String()).Msg("failed to record backfill status")
+	}
+}
+
+func (s *backfillService) Progress(ctx context.Context, id uuid.UUID) (*dto.BackfillProgress, error) {
+	batch, err := s.repo.FindBackfillBatch(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	jobs, err := s.repo.CountBackfillJobsByStatus(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &dto.BackfillProgress{Batch: batch, Jobs: jobs}, nil
+}
+
+func NewBackfillService(repo repository.BackfillRepository, jobs repository.JobRepository, presets PresetService, publisher rabbitmq.Publisher, store objectstore.Store, cfg *config.Config) BackfillService {
+	return &backfillService{
+		repo:      repo,
+		jobs:      jobs,
+		presets:   presets,
+		publisher: publisher,
+		store:     store,
+		cfg:       cfg,
+	}
+}
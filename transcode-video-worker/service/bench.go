@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
+	"worker-transcode/entities"
+)
+
+// BenchResult is how fast this host encodes one rendition of a preset.
+type BenchResult struct {
+	Preset        string  `json:"preset"`
+	PresetVersion int     `json:"preset_version"`
+	VideoCodec    string  `json:"video_codec"`
+	Rendition     string  `json:"rendition"`
+	Bitrate       string  `json:"bitrate"`
+	Frames        int64   `json:"frames"`
+	Seconds       float64 `json:"seconds"`
+	FPS           float64 `json:"fps"`
+	// Speed is media seconds encoded per wall-clock second; 1 is realtime.
+	Speed float64 `json:"speed"`
+}
+
+// ReferenceClip renders a 1080p30 test pattern with a tone into dir. It is
+// generated rather than shipped so the benchmark needs nothing but ffmpeg; the
+// moving pattern and overlaid counter keep it from compressing trivially.
+func ReferenceClip(ctx context.Context, dir string, duration time.Duration) (string, error) {
+	output := filepath.Join(dir, "reference.mp4")
+	seconds := strconv.FormatFloat(duration.Seconds(), 'f', -1, 64)
+	args := []string{
+		"-y",
+		"-f", "lavfi", "-i", "testsrc2=size=1920x1080:rate=30:duration=" + seconds,
+		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + seconds,
+		"-c:v", "libx264", "-preset", "ultrafast", "-crf", "18", "-pix_fmt", "yuv420p",
+		"-c:a", "aac",
+		output,
+	}
+	if err := runFFmpeg(ctx, args, nil); err != nil {
+		return "", err
+	}
+	return output, nil
+}
+
+// Bench encodes input once per rendition of preset, with the preset's video
+// settings and nothing written, and measures each run.
+func Bench(ctx context.Context, preset *entities.Preset, input string) ([]BenchResult, error) {
+	results := make([]BenchResult, 0, len(preset.Renditions))
+	for _, r := range preset.Renditions {
+		args := []string{
+			"-i", input,
+			"-vf", scaleFilter(preset, r),
+			"-an",
+			"-c:v", preset.VideoCodec,
+			"-preset", preset.EncoderPreset,
+		}
+		args = append(args, videoRateArgs(preset, r)...)
+		args = append(args, keyframeArgs(preset)...)
+		args = append(args, "-f", "null", "-")
+
+		var last FFmpegProgress
+		start := time.Now()
+		if err := runFFmpeg(ctx, args, func(p FFmpegProgress) { last = p }); err != nil {
+			return results, fmt.Errorf("rendition %dp: %w", r.Height, err)
+		}
+		elapsed := time.Since(start).Seconds()
+
+		result := BenchResult{
+			Preset:        preset.Name,
+			PresetVersion: preset.Version,
+			VideoCodec:    preset.VideoCodec,
+			Rendition:     fmt.Sprintf("%dp", r.Height),
+			Bitrate:       r.Bitrate,
+			Frames:        last.Frame,
+			Seconds:       elapsed,
+		}
+		if elapsed > 0 {
+			result.FPS = float64(last.Frame) / elapsed
+			result.Speed = last.OutTime.Seconds() / elapsed
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
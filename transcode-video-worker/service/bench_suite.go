@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+	"worker-transcode/entities"
+)
+
+// BenchFixture is one of the generated clips the benchmark suite encodes.
+// They come from ffmpeg's lavfi sources, so every run of the suite, on any
+// host, encodes the same frames.
+type BenchFixture struct {
+	Name   string
+	Source string
+	Width  int
+	Height int
+}
+
+// BenchFixtures cover the content lessons are made of: camera-like motion,
+// a mostly still slide deck and a high resolution screen capture.
+var BenchFixtures = []BenchFixture{
+	{Name: "motion", Source: "testsrc2", Width: 1920, Height: 1080},
+	{Name: "slides", Source: "smptehdbars", Width: 1920, Height: 1080},
+	{Name: "screen", Source: "testsrc", Width: 2560, Height: 1440},
+}
+
+// SuiteResult is one preset's full ladder encoded from one fixture through
+// the pipeline's encode stage.
+type SuiteResult struct {
+	Preset        string  `json:"preset"`
+	PresetVersion int     `json:"preset_version"`
+	Fixture       string  `json:"fixture"`
+	MediaSeconds  float64 `json:"media_seconds"`
+	Seconds       float64 `json:"seconds"`
+	// Speed is media seconds encoded per wall-clock second; 1 is realtime.
+	Speed       float64 `json:"speed"`
+	PeakMemory  int64   `json:"peak_memory_bytes"`
+	OutputBytes int64   `json:"output_bytes"`
+}
+
+// SuiteRegression is a result that got worse than its baseline by more than
+// the tolerance allows.
+type SuiteRegression struct {
+	Preset   string  `json:"preset"`
+	Fixture  string  `json:"fixture"`
+	Metric   string  `json:"metric"`
+	Baseline float64 `json:"baseline"`
+	Current  float64 `json:"current"`
+	// Change is the relative change from the baseline; negative for speed.
+	Change float64 `json:"change"`
+}
+
+// RenderFixture renders fixture into dir, reusing a clip rendered there
+// before with the same duration.
+func RenderFixture(ctx context.Context, dir string, fixture BenchFixture, duration time.Duration) (string, error) {
+	seconds := strconv.FormatFloat(duration.Seconds(), 'f', -1, 64)
+	output := filepath.Join(dir, fmt.Sprintf("%s-%ss.mp4", fixture.Name, seconds))
+	if _, err := os.Stat(output); err == nil {
+		return output, nil
+	}
+	args := []string{
+		"-y",
+		"-f", "lavfi", "-i", fmt.Sprintf("%s=size=%dx%d:rate=30:duration=%s", fixture.Source, fixture.Width, fixture.Height, seconds),
+		"-f", "lavfi", "-i", "sine=frequency=440:duration=" + seconds,
+		// One thread keeps the fixture bit-identical between hosts.
+		"-c:v", "libx264", "-preset", "ultrafast", "-crf", "18", "-pix_fmt", "yuv420p", "-threads", "1",
+		"-c:a", "aac",
+		output,
+	}
+	if err := runFFmpeg(ctx, args, nil); err != nil {
+		return "", err
+	}
+	return output, nil
+}
+
+// RunSuite encodes input with every rung of preset at once, the way a job
+// does, and measures the run. threads is passed on as FFMPEG_THREADS would be.
+func RunSuite(ctx context.Context, preset *entities.Preset, fixture, input string, threads int) (*SuiteResult, error) {
+	media, err := ProbeMedia(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	outputDir, err := os.MkdirTemp("", "worker-suite-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(outputDir)
+
+	ctx, usage := withFFmpegUsage(ctx)
+	start := time.Now()
+	if err := transcodeToHLS(ctx, preset, input, "", nil, outputDir, threads, 0, 0, nil); err != nil {
+		return nil, fmt.Errorf("fixture %s: %w", fixture, err)
+	}
+	elapsed := time.Since(start).Seconds()
+
+	result := &SuiteResult{
+		Preset:        preset.Name,
+		PresetVersion: preset.Version,
+		Fixture:       fixture,
+		MediaSeconds:  media.DurationSeconds(),
+		Seconds:       elapsed,
+		PeakMemory:    usage.peak.Load(),
+	}
+	if elapsed > 0 {
+		result.Speed = result.MediaSeconds / elapsed
+	}
+	err = filepath.Walk(outputDir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			result.OutputBytes += info.Size()
+		}
+		return err
+	})
+	return result, err
+}
+
+// LoadSuiteResults reads results saved from an earlier run.
+func LoadSuiteResults(path string) ([]SuiteResult, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var results []SuiteResult
+	if err := json.Unmarshal(raw, &results); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return results, nil
+}
+
+// CompareSuite returns the results that are slower, or peak higher in
+// memory, than the baseline result of the same preset and fixture by more
+// than tolerance, a fraction. Results without a baseline are not compared.
+func CompareSuite(baseline, current []SuiteResult, tolerance float64) []SuiteRegression {
+	type key struct{ preset, fixture string }
+	previous := make(map[key]SuiteResult, len(baseline))
+	for _, result := range baseline {
+		previous[key{result.Preset, result.Fixture}] = result
+	}
+
+	var regressions []SuiteRegression
+	for _, result := range current {
+		before, ok := previous[key{result.Preset, result.Fixture}]
+		if !ok {
+			continue
+		}
+		if before.Speed > 0 && result.Speed < before.Speed*(1-tolerance) {
+			regressions = append(regressions, SuiteRegression{
+				Preset: result.Preset, Fixture: result.Fixture, Metric: "speed",
+				Baseline: before.Speed, Current: result.Speed, Change: result.Speed/before.Speed - 1,
+			})
+		}
+		if before.PeakMemory > 0 && float64(result.PeakMemory) > float64(before.PeakMemory)*(1+tolerance) {
+			regressions = append(regressions, SuiteRegression{
+				Preset: result.Preset, Fixture: result.Fixture, Metric: "peak_memory_bytes",
+				Baseline: float64(before.PeakMemory), Current: float64(result.PeakMemory),
+				Change: float64(result.PeakMemory)/float64(before.PeakMemory) - 1,
+			})
+		}
+	}
+	return regressions
+}
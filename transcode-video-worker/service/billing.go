@@ -0,0 +1,87 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+
+	"github.com/google/uuid"
+)
+
+const (
+	usageRecordRoutingKey    = "usage.transcode"
+	usageRecordSchemaVersion = 1
+)
+
+// BillingService meters completed transcodes for finance. Records go out
+// through the outbox, written with the job's completion, so each completed
+// job is billed exactly once.
+type BillingService interface {
+	// Usage is the outbox message carrying the job's usage record, nil with
+	// billing off. event is the job's analytics event, as filled in by the
+	// pipeline; reused marks a package copied from an identical job's.
+	Usage(job *entities.Job, event dto.MediaEvent, encodedSeconds float64, reused bool) (*entities.OutboxMessage, error)
+}
+
+type billingService struct {
+	cfg *config.Config
+}
+
+func (s *billingService) Usage(job *entities.Job, event dto.MediaEvent, encodedSeconds float64, reused bool) (*entities.OutboxMessage, error) {
+	if !s.cfg.Billing.Enabled {
+		return nil, nil
+	}
+	if reused {
+		encodedSeconds = 0
+	}
+	record := dto.UsageRecord{
+		RecordId:       job.ID,
+		SchemaVersion:  usageRecordSchemaVersion,
+		OccurredAt:     time.Now().UTC(),
+		TenantId:       job.TenantId,
+		JobId:          job.ID,
+		JobType:        string(job.JobType),
+		LessonId:       job.EntityId,
+		Preset:         event.Preset,
+		Renditions:     event.Renditions,
+		SourceMinutes:  event.SourceSeconds / 60,
+		EncodedMinutes: encodedSeconds / 60,
+		StoredBytes:    event.OutputBytes,
+		StoredGB:       float64(event.OutputBytes) / (1 << 30),
+		Compute:        usageCompute(event),
+		MediaEngine:    event.MediaEngine,
+		VideoCodec:     event.VideoCodec,
+		Reused:         reused,
+	}
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	return &entities.OutboxMessage{
+		ID:         uuid.New(),
+		Exchange:   s.cfg.Billing.Exchange,
+		RoutingKey: usageRecordRoutingKey,
+		Payload:    payload,
+	}, nil
+}
+
+// usageCompute is what the job was encoded on. NVENC encoders run on a GPU,
+// the rest of the encoders this worker uses on the CPU.
+func usageCompute(event dto.MediaEvent) string {
+	switch {
+	case event.RemoteProvider != "":
+		return "remote"
+	case strings.HasSuffix(event.VideoCodec, "_nvenc"):
+		return "gpu"
+	}
+	return "cpu"
+}
+
+func NewBillingService(cfg *config.Config) BillingService {
+	return &billingService{
+		cfg: cfg,
+	}
+}
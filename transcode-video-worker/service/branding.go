@@ -0,0 +1,305 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"worker-transcode/config"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"gorm.io/gorm"
+)
+
+const (
+	maxTitleCardSeconds  = 10
+	maxLowerThirdSeconds = 30
+
+	// lowerThirdStart is when the lower third comes in after the lesson
+	// itself starts.
+	lowerThirdStart = 1
+)
+
+var colorPattern = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+// logoPositions place the logo in a corner, 24 pixels in.
+var logoPositions = map[string]string{
+	"top-left":     "x=24:y=24",
+	"top-right":    "x=W-w-24:y=24",
+	"bottom-left":  "x=24:y=H-h-24",
+	"bottom-right": "x=W-w-24:y=H-h-24",
+}
+
+// branded is a source with its tenant's branding composited in. card is the
+// length of the title card ahead of the source's first frame.
+type branded struct {
+	input string
+	dubs  []dubbedAudio
+	card  float64
+}
+
+// BrandingService composites a tenant's branding template into its lesson
+// videos before they are encoded.
+type BrandingService interface {
+	// Apply brands the job's source when its tenant has a template. It
+	// returns nil for jobs without a tenant or template, or with nothing in
+	// the template to draw.
+	Apply(ctx context.Context, job *entities.Job, inputFilepath, audioFilepath string, dubs []dubbedAudio, source *SourceCheck, dir string) (*branded, error)
+	Get(ctx context.Context, tenantId uuid.UUID) (*entities.BrandingTemplate, error)
+	Save(ctx context.Context, tenantId uuid.UUID, request dto.BrandingRequest) (*entities.BrandingTemplate, error)
+}
+
+type brandingService struct {
+	repo    repository.BrandingRepository
+	lessons repository.NotificationRepository
+	store   objectstore.Store
+	cfg     *config.Config
+}
+
+func (s *brandingService) Apply(ctx context.Context, job *entities.Job, inputFilepath, audioFilepath string, dubs []dubbedAudio, source *SourceCheck, dir string) (*branded, error) {
+	if job.TenantId == nil {
+		return nil, nil
+	}
+	template, err := s.repo.FindBranding(ctx, *job.TenantId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if template.LogoKey == nil && template.TitleCardSeconds <= 0 && template.LowerThirdSeconds <= 0 {
+		return nil, nil
+	}
+	if source.Media == nil || source.Media.VideoStream() == nil {
+		return nil, nil
+	}
+	video := source.Media.VideoStream()
+	summary, err := s.lessons.FindLessonSummary(ctx, job.EntityId)
+	if err != nil {
+		return nil, err
+	}
+
+	brandDir := filepath.Join(dir, "branding")
+	if err := os.MkdirAll(brandDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	var logo string
+	if template.LogoKey != nil {
+		logo = filepath.Join(brandDir, "logo"+path.Ext(*template.LogoKey))
+		if err := s.store.FGetObject(ctx, s.cfg.MinIOBucket, *template.LogoKey, logo, minio.GetObjectOptions{}); err != nil {
+			return nil, fmt.Errorf("download logo: %w", err)
+		}
+	}
+	// Titles are read from files so they need no escaping in the filter.
+	titles := map[string]string{"course": summary.CourseTitle, "lesson": summary.LessonTitle}
+	for name, title := range titles {
+		if err := os.WriteFile(filepath.Join(brandDir, name+".txt"), []byte(title), 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	hasAudio := audioFilepath != ""
+	for _, stream := range source.Media.Streams {
+		hasAudio = hasAudio || stream.CodecType == "audio"
+	}
+	result := &branded{
+		input: filepath.Join(brandDir, "branded.mp4"),
+		dubs:  dubs,
+		card:  float64(min(max(template.TitleCardSeconds, 0), maxTitleCardSeconds)),
+	}
+	args := brandingArgs(template, inputFilepath, audioFilepath, logo, brandDir, result.input, video, hasAudio, s.cfg.Server.FFmpegThreads)
+	if err := runFFmpeg(ctx, args, nil); err != nil {
+		return nil, err
+	}
+
+	// Dubs are delayed by the title card to stay in sync.
+	if result.card > 0 && len(dubs) > 0 {
+		result.dubs = make([]dubbedAudio, len(dubs))
+		for i, dub := range dubs {
+			result.dubs[i] = dub
+			result.dubs[i].path = filepath.Join(brandDir, "delayed_"+filepath.Base(dub.path)+".m4a")
+			err := runFFmpeg(ctx, []string{"-i", dub.path,
+				"-af", fmt.Sprintf("adelay=%d:all=1", int(result.card*1000)),
+				"-c:a", "aac", "-b:a", "192k",
+				"-y", result.dubs[i].path}, nil)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return result, nil
+}
+
+func (s *brandingService) Get(ctx context.Context, tenantId uuid.UUID) (*entities.BrandingTemplate, error) {
+	template, err := s.repo.FindBranding(ctx, tenantId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	return template, err
+}
+
+func (s *brandingService) Save(ctx context.Context, tenantId uuid.UUID, request dto.BrandingRequest) (*entities.BrandingTemplate, error) {
+	template := &entities.BrandingTemplate{
+		TenantId:          tenantId,
+		LogoKey:           request.LogoKey,
+		LogoPosition:      request.LogoPosition,
+		PrimaryColor:      request.PrimaryColor,
+		TextColor:         request.TextColor,
+		TitleCardSeconds:  request.TitleCardSeconds,
+		LowerThirdSeconds: request.LowerThirdSeconds,
+	}
+	if template.LogoPosition == "" {
+		template.LogoPosition = "top-right"
+	}
+	if err := validateBranding(template); err != nil {
+		return nil, errors.Join(ErrInvalidArgument, err)
+	}
+	if template.LogoKey != nil {
+		if _, err := s.store.StatObject(ctx, s.cfg.MinIOBucket, *template.LogoKey, minio.StatObjectOptions{}); err != nil {
+			return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("logo %s: %w", *template.LogoKey, err))
+		}
+	}
+	if err := s.repo.SaveBranding(ctx, template); err != nil {
+		return nil, err
+	}
+	return s.repo.FindBranding(ctx, tenantId)
+}
+
+func validateBranding(template *entities.BrandingTemplate) error {
+	if _, ok := logoPositions[template.LogoPosition]; !ok {
+		return fmt.Errorf("logo_position %q is not a corner", template.LogoPosition)
+	}
+	if !colorPattern.MatchString(template.PrimaryColor) {
+		return fmt.Errorf("primary_color %q is not #RRGGBB", template.PrimaryColor)
+	}
+	if !colorPattern.MatchString(template.TextColor) {
+		return fmt.Errorf("text_color %q is not #RRGGBB", template.TextColor)
+	}
+	if template.TitleCardSeconds < 0 || template.TitleCardSeconds > maxTitleCardSeconds {
+		return fmt.Errorf("title_card_seconds must be between 0 and %d", maxTitleCardSeconds)
+	}
+	if template.LowerThirdSeconds < 0 || template.LowerThirdSeconds > maxLowerThirdSeconds {
+		return fmt.Errorf("lower_third_seconds must be between 0 and %d", maxLowerThirdSeconds)
+	}
+	if template.LogoKey != nil && strings.TrimSpace(*template.LogoKey) == "" {
+		return errors.New("logo_key must not be empty")
+	}
+	return nil
+}
+
+// brandingArgs encodes the branded source at a quality the ladder is encoded
+// from again: the title card in the primary color with the course and lesson
+// names, then the source with the logo over it and the lower third coming in
+// as it starts. The card is silent.
+func brandingArgs(template *entities.BrandingTemplate, inputFilepath, audioFilepath, logo, textDir, output string, video *ProbeStream, hasAudio bool, threads int) []string {
+	args := []string{"-i", inputFilepath}
+	audioInput := "0:a:0"
+	if audioFilepath != "" {
+		args = append(args, "-i", audioFilepath)
+		audioInput = "1:a:0"
+	}
+	if logo != "" {
+		args = append(args, "-i", logo)
+	}
+
+	primary := strings.Replace(template.PrimaryColor, "#", "0x", 1)
+	text := strings.Replace(template.TextColor, "#", "0x", 1)
+	rate := video.AvgFrameRate
+	if rate == "" || strings.HasPrefix(rate, "0/") {
+		rate = "30"
+	}
+	course, lesson := filepath.Join(textDir, "course.txt"), filepath.Join(textDir, "lesson.txt")
+
+	var graph []string
+	chain := "[0:v]setsar=1,format=yuv420p"
+	if logo != "" {
+		logoInput := 1
+		if audioFilepath != "" {
+			logoInput = 2
+		}
+		graph = append(graph, fmt.Sprintf("[%d:v]scale=-1:%d[logo]", logoInput, max(video.Height/10, 16)), chain+"[base]")
+		chain = "[base][logo]overlay=" + logoPositions[template.LogoPosition]
+	}
+	if seconds := min(template.LowerThirdSeconds, maxLowerThirdSeconds); seconds > 0 {
+		enable := fmt.Sprintf("enable='between(t,%d,%d)'", lowerThirdStart, lowerThirdStart+seconds)
+		chain += fmt.Sprintf(",drawbox=x=0:y=ih*0.78:w=iw:h=ih*0.1:color=%s@0.85:t=fill:%s"+
+			",drawtext=textfile=%s:fontcolor=%s:fontsize=h/24:x=w*0.04:y=h*0.78+(h*0.1-th)/2:%s",
+			primary, enable, lesson, text, enable)
+	}
+	graph = append(graph, chain+"[main]")
+
+	card := min(max(template.TitleCardSeconds, 0), maxTitleCardSeconds)
+	videoOut, audioOut := "[main]", "["+audioInput+"]"
+	if hasAudio {
+		graph = append(graph, "["+audioInput+"]aresample=48000,aformat=sample_fmts=fltp:channel_layouts=stereo[main_a]")
+		audioOut = "[main_a]"
+	}
+	if card > 0 {
+		graph = append(graph, fmt.Sprintf("color=c=%s:s=%dx%d:r=%s:d=%d,setsar=1,format=yuv420p,"+
+			"drawtext=textfile=%s:fontcolor=%s:fontsize=h/14:x=(w-tw)/2:y=h*0.38,"+
+			"drawtext=textfile=%s:fontcolor=%s:fontsize=h/20:x=(w-tw)/2:y=h*0.52,"+
+			"fade=t=out:st=%g:d=0.5[card]",
+			primary, video.Width, video.Height, rate, card, course, text, lesson, text, float64(card)-0.5))
+		if hasAudio {
+			graph = append(graph,
+				fmt.Sprintf("anullsrc=r=48000:cl=stereo,atrim=duration=%d[card_a]", card),
+				"[card][card_a][main][main_a]concat=n=2:v=1:a=1[v][a]")
+			videoOut, audioOut = "[v]", "[a]"
+		} else {
+			graph = append(graph, "[card][main]concat=n=2:v=1:a=0[v]")
+			videoOut = "[v]"
+		}
+	}
+
+	args = append(args, "-filter_complex", strings.Join(graph, ";"), "-map", videoOut)
+	if hasAudio {
+		args = append(args, "-map", audioOut, "-c:a", "aac", "-b:a", "192k")
+	}
+	args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-crf", "16")
+	if threads > 0 {
+		args = append(args, "-threads", strconv.Itoa(threads))
+	}
+	return append(args, "-y", output)
+}
+
+// delayMarkers moves instructor chapter markers behind a title card. A
+// chapter starting at the beginning takes the card in.
+func delayMarkers(markers []dto.ChapterMarker, seconds float64) []dto.ChapterMarker {
+	delayed := make([]dto.ChapterMarker, 0, len(markers))
+	for _, marker := range markers {
+		if marker.Start > 0 {
+			marker.Start += seconds
+		}
+		delayed = append(delayed, marker)
+	}
+	return delayed
+}
+
+// delayCuePoints moves cue points behind a title card.
+func delayCuePoints(cues []dto.CuePoint, seconds float64) []dto.CuePoint {
+	delayed := make([]dto.CuePoint, 0, len(cues))
+	for _, cue := range cues {
+		cue.At += seconds
+		delayed = append(delayed, cue)
+	}
+	return delayed
+}
+
+func NewBrandingService(repo repository.BrandingRepository, lessons repository.NotificationRepository, store objectstore.Store, cfg *config.Config) BrandingService {
+	return &brandingService{
+		repo:    repo,
+		lessons: lessons,
+		store:   store,
+		cfg:     cfg,
+	}
+}
@@ -0,0 +1,348 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/correlation"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/pkg/reporting"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// burnInStyle is how burned-in captions are drawn: white on a translucent
+// box, which stays legible over any picture, bottom centred inside the
+// title-safe area, 10% in from each edge, so the platform's own controls and
+// crops don't cover them. libass sizes it against a 384x288 script scaled to
+// the video, so the text is the same share of the picture at any height.
+const burnInStyle = "FontName=DejaVu Sans,FontSize=16,PrimaryColour=&H00FFFFFF,BackColour=&H80000000,OutlineColour=&H80000000," +
+	"BorderStyle=3,Outline=2,Shadow=0,Alignment=2,MarginV=29,MarginL=38,MarginR=38"
+
+// BurnInService makes copies of lesson videos with a caption track drawn into
+// the picture, for the social and preview channels that play a bare MP4 and
+// can't load the WebVTT sidecars the player does.
+type BurnInService interface {
+	// Request queues a burn-in of the lesson's caption track in the language
+	// into its current video.
+	Request(ctx context.Context, lessonId uuid.UUID, request dto.BurnInRequest) (*entities.BurnedCaption, error)
+	// Link returns the burn-in, with a link to its video once it is made.
+	Link(ctx context.Context, id uuid.UUID) (*dto.BurnInLink, error)
+	// Process runs a burn-in job.
+	Process(ctx context.Context, message dto.BurnInMessage) error
+}
+
+type burnInService struct {
+	repo        repository.BurnedCaptionRepository
+	transcripts repository.TranscriptRepository
+	jobs        repository.JobRepository
+	events      repository.JobEventRepository
+	publisher   rabbitmq.Publisher
+	store       objectstore.Store
+	cfg         *config.Config
+}
+
+func (s *burnInService) Request(ctx context.Context, lessonId uuid.UUID, request dto.BurnInRequest) (*entities.BurnedCaption, error) {
+	playlist, err := s.repo.FindLessonVideo(ctx, lessonId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !isHLSSource(playlist) {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("lesson %s has no published video", lessonId))
+	}
+	caption, err := s.caption(ctx, lessonId, request.Language)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &entities.Job{
+		ID:         uuid.New(),
+		EntityId:   lessonId,
+		EntityType: string(constant.EntityTypeLessonVideo),
+		Status:     constant.JobStatusPending,
+		JobType:    constant.JobTypeBurnIn,
+		UserId:     request.UserId,
+	}
+	if correlationId := correlation.FromContext(ctx); correlationId != "" {
+		job.CorrelationId = &correlationId
+	}
+	burned := &entities.BurnedCaption{
+		ID:       uuid.New(),
+		LessonId: lessonId,
+		JobId:    job.ID,
+		Language: caption.Language,
+	}
+
+	if err := s.jobs.CreateJob(ctx, job); err != nil {
+		return nil, err
+	}
+	if err := s.repo.CreateBurnedCaption(ctx, burned); err != nil {
+		return nil, err
+	}
+	message := dto.BurnInMessage{JobId: job.ID}
+	if err := s.publisher.Publish(ctx, rabbitmq.BurnInTopology.Exchange, rabbitmq.BurnInTopology.RoutingKey, message); err != nil {
+		return nil, err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("job_id", job.ID.String()).
+		Str("lesson_id", lessonId.String()).
+		Str("language", burned.Language).
+		Msg("caption burn-in queued")
+	return burned, nil
+}
+
+func (s *burnInService) Link(ctx context.Context, id uuid.UUID) (*dto.BurnInLink, error) {
+	burned, err := s.repo.FindBurnedCaption(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if burned.ObjectKey == nil {
+		return &dto.BurnInLink{BurnedCaption: burned}, nil
+	}
+
+	ttl := time.Duration(s.cfg.BurnIn.LinkTTL) * time.Second
+	params := url.Values{}
+	params.Set("response-content-disposition", fmt.Sprintf("attachment; filename=%q", path.Base(*burned.ObjectKey)))
+	link, err := s.store.PresignedGetObject(ctx, s.cfg.MinIOBucket, *burned.ObjectKey, ttl, params)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Now().UTC().Add(ttl)
+	return &dto.BurnInLink{
+		BurnedCaption: burned,
+		URL:           link.String(),
+		URLExpiresAt:  &expiresAt,
+	}, nil
+}
+
+func (s *burnInService) Process(ctx context.Context, message dto.BurnInMessage) (err error) {
+	job, err := s.jobs.FindJobById(ctx, message.JobId)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to find job by id")
+		return err
+	}
+	if job.Status != constant.JobStatusPending {
+		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("job is not pending")
+		return nil
+	}
+	burned, err := s.repo.FindBurnedCaptionByJob(ctx, message.JobId)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to find burned caption")
+		return err
+	}
+
+	claimed, err := s.jobs.ClaimJob(ctx, message.JobId, correlation.FromContext(ctx), workerFromContext(ctx))
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to update job status")
+		return err
+	}
+	if !claimed {
+		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("job already claimed by another delivery")
+		return nil
+	}
+	rabbitmq.Claimed(ctx)
+	ctx = withTimeline(ctx, s.events, job.ID)
+	recordStatus(ctx, constant.JobStatusPending, constant.JobStatusProcessing)
+
+	stage := constant.ErrorClassWorkspace
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = panicked(ctx, recovered)
+		}
+		if shuttingDown(ctx, err) {
+			err = handOff(ctx, s.jobs, message.JobId, err)
+			return
+		}
+		err = classify(err)
+		recordOutcome(ctx, job, stage, err)
+		recordOutcomeEvents(ctx, stage, err)
+		if err != nil {
+			if errors.Is(err, ErrNonRetryable) {
+				if updateErr := s.jobs.FailJob(ctx, message.JobId, stage, errorKind(err), err.Error()); updateErr != nil {
+					zerolog.Ctx(ctx).Error().Err(updateErr).Msg("failed to update job status")
+				}
+				reporting.CaptureFailure(ctx, err, reporting.Failure{
+					JobId:   message.JobId.String(),
+					JobType: string(job.JobType),
+					Stage:   string(stage),
+					Output:  failureOutput(err),
+					Extra:   map[string]interface{}{"lesson_id": burned.LessonId.String(), "language": burned.Language},
+				})
+				err = nil
+			} else {
+				if updateErr := s.jobs.UpdateStatusJob(ctx, constant.JobStatusPending, message.JobId); updateErr != nil {
+					zerolog.Ctx(ctx).Error().Err(updateErr).Msg("failed to update job status")
+				}
+			}
+		}
+	}()
+
+	tempDir := filepath.Join("temp", message.JobId.String())
+	defer os.RemoveAll(tempDir)
+	sourceDir := filepath.Join(tempDir, "source")
+	if err = os.MkdirAll(sourceDir, os.ModePerm); err != nil {
+		return errors.Join(ErrNonRetryable, err)
+	}
+
+	stage = constant.ErrorClassDatabase
+	playlist, err := s.repo.FindLessonVideo(ctx, burned.LessonId)
+	if err != nil {
+		return err
+	}
+	if !isHLSSource(playlist) {
+		return errors.Join(ErrNonRetryable, fmt.Errorf("lesson %s has no published video", burned.LessonId))
+	}
+	// The track is looked up again, so a burn-in queued before its captions
+	// were corrected draws the corrected ones.
+	caption, err := s.caption(ctx, burned.LessonId, burned.Language)
+	if errors.Is(err, ErrInvalidArgument) {
+		return errors.Join(ErrNonRetryable, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	stage = constant.ErrorClassDownload
+	var videoInput, audioInput string
+	captions := filepath.Join(tempDir, "captions.vtt")
+	err = traceStage(ctx, "download", func(ctx context.Context) error {
+		var downloadErr error
+		videoInput, audioInput, downloadErr = downloadHLSSource(ctx, s.store, s.cfg.MinIOBucket, playlist, sourceDir)
+		if downloadErr != nil {
+			return downloadErr
+		}
+		return s.store.FGetObject(ctx, s.cfg.MinIOBucket, *caption.ObjectKey, captions, minio.GetObjectOptions{})
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to download lesson video")
+		return err
+	}
+
+	stage = constant.ErrorClassTranscode
+	output := filepath.Join(tempDir, "captioned.mp4")
+	err = traceStage(ctx, "burn_in", func(ctx context.Context) error {
+		return runFFmpeg(ctx, burnInArgs(videoInput, audioInput, captions, output, s.cfg.BurnIn.Height, s.cfg.Server.FFmpegThreads), nil)
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to burn in captions")
+		return errors.Join(ErrNonRetryable, err)
+	}
+	info, err := ProbeMedia(ctx, output)
+	if err != nil {
+		return err
+	}
+	var height int
+	if video := info.VideoStream(); video != nil {
+		height = video.Height
+	}
+
+	stage = constant.ErrorClassUpload
+	key := fmt.Sprintf("lessons/%s/burned-captions/%s-%s.mp4", burned.LessonId, burned.JobId, burned.Language)
+	var size int64
+	err = traceStage(ctx, "upload", func(ctx context.Context) error {
+		object, uploadErr := s.store.FPutObject(ctx, s.cfg.MinIOBucket, key, output, minio.PutObjectOptions{
+			ContentType:  "video/mp4",
+			UserMetadata: map[string]string{"download-only": "true"},
+		})
+		size = object.Size
+		return uploadErr
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to upload burned-in caption video")
+		return err
+	}
+
+	stage = constant.ErrorClassDatabase
+	if err = s.repo.MarkBurnedCaptionReady(ctx, burned.ID, key, size, height); err != nil {
+		return err
+	}
+	if err = s.jobs.UpdateStatusJob(ctx, constant.JobStatusCompleted, message.JobId); err != nil {
+		return err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("job_id", message.JobId.String()).
+		Str("lesson_id", burned.LessonId.String()).
+		Str("language", burned.Language).
+		Str("key", key).
+		Int64("bytes", size).
+		Msg("captions burned in")
+	return nil
+}
+
+// caption returns the lesson's caption track in language, which must have
+// cues to draw.
+func (s *burnInService) caption(ctx context.Context, lessonId uuid.UUID, language string) (*entities.LessonCaption, error) {
+	captions, err := s.transcripts.ListCaptions(ctx, lessonId)
+	if err != nil {
+		return nil, err
+	}
+	for _, caption := range captions {
+		if strings.EqualFold(caption.Language, language) && caption.ObjectKey != nil {
+			return caption, nil
+		}
+	}
+	return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("lesson %s has no %q captions", lessonId, language))
+}
+
+// burnInArgs encodes the video at most height tall with the captions drawn
+// over it after scaling, so they are rendered sharp at the output's size.
+func burnInArgs(videoInput, audioInput, captions, output string, height, threads int) []string {
+	args := []string{"-i", videoInput}
+	audioMap := "0:a:0?"
+	if audioInput != "" {
+		args = append(args, "-i", audioInput)
+		audioMap = "1:a:0?"
+	}
+	args = append(args,
+		"-map", "0:v:0",
+		"-map", audioMap,
+		"-vf", fmt.Sprintf("scale=-2:'min(ih,%d)',subtitles=filename=%s:force_style='%s'", height, captions, burnInStyle),
+		"-c:v", "libx264",
+		"-profile:v", "high",
+		"-preset", "medium",
+		"-crf", "21",
+		"-pix_fmt", "yuv420p",
+		"-c:a", "aac",
+		"-b:a", "128k",
+		"-movflags", "+faststart",
+	)
+	if threads > 0 {
+		args = append(args, "-threads", fmt.Sprint(threads))
+	}
+	return append(args, "-y", output)
+}
+
+func NewBurnInService(repo repository.BurnedCaptionRepository, transcripts repository.TranscriptRepository, jobs repository.JobRepository, events repository.JobEventRepository, publisher rabbitmq.Publisher, store objectstore.Store, cfg *config.Config) BurnInService {
+	return &burnInService{
+		repo:        repo,
+		transcripts: transcripts,
+		jobs:        jobs,
+		events:      events,
+		publisher:   publisher,
+		store:       store,
+		cfg:         cfg,
+	}
+}
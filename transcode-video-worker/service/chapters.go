@@ -0,0 +1,422 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/ffmpeg"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+const (
+	ChaptersEventReady = "chapters.ready"
+
+	// silenceNoise is the level under which audio counts as silence.
+	silenceNoise = "-35dB"
+	// sceneWindow is how far a scene change may be from a pause to be taken
+	// as the same break, such as the next slide coming up while the speaker
+	// pauses.
+	sceneWindow = 5.0
+
+	// chapterLanguage tags the titles in the chapters sidecar, matching the
+	// audio track of the master playlist.
+	chapterLanguage = sourceAudioLanguage
+	chaptersSidecar = "chapters.json"
+)
+
+// chapterEpoch is the program date of packaged playlists, which have no wall
+// clock of their own: a chapter t seconds in starts at chapterEpoch + t.
+var chapterEpoch = time.Unix(0, 0).UTC()
+
+var (
+	sceneTimePattern    = regexp.MustCompile(`pts_time:\s*([0-9.]+)`)
+	silenceStartPattern = regexp.MustCompile(`silence_start:\s*(-?[0-9.]+)`)
+	silenceEndPattern   = regexp.MustCompile(`silence_end:\s*([0-9.]+)`)
+)
+
+type ChapterService interface {
+	// Detect proposes chapters for a lesson's recording from its long pauses
+	// and scene changes, replaces the lesson's chapters with them and
+	// announces them. Recordings shorter than MinDuration are left alone.
+	Detect(ctx context.Context, job *entities.Job, inputFilepath, audioFilepath string, duration float64) error
+	// Provide returns the instructor's chapters for the job: its markers,
+	// stored as the lesson's chapters, or when the payload has none, those
+	// an earlier delivery of the job stored. Markers past the end of the
+	// recording are dropped.
+	Provide(ctx context.Context, job *entities.Job, markers []dto.ChapterMarker, duration float64) ([]*entities.Chapter, error)
+	// Announce publishes the lesson's chapters for the player.
+	Announce(ctx context.Context, job *entities.Job, chapters []*entities.Chapter) error
+	List(ctx context.Context, lessonId uuid.UUID) ([]*entities.Chapter, error)
+}
+
+type chapterService struct {
+	repo      repository.ChapterRepository
+	publisher rabbitmq.Publisher
+	cfg       *config.Config
+}
+
+// pause is a stretch of silence in the recording, in seconds.
+type pause struct {
+	start, end float64
+}
+
+// boundary is where a proposed chapter starts.
+type boundary struct {
+	at    float64
+	pause float64
+	scene bool
+}
+
+func (s *chapterService) Detect(ctx context.Context, job *entities.Job, inputFilepath, audioFilepath string, duration float64) error {
+	if !featureEnabled(ctx, constant.TenantFeatureChapters, s.cfg.Chapters.Enabled) || duration < float64(s.cfg.Chapters.MinDuration) {
+		return nil
+	}
+
+	pauses, scenes, err := analyzeLecture(ctx, inputFilepath, audioFilepath, s.cfg.Chapters)
+	if err != nil {
+		return err
+	}
+	boundaries := proposeBoundaries(pauses, scenes, duration, float64(s.cfg.Chapters.MinLength))
+
+	chapters := make([]*entities.Chapter, 0, len(boundaries)+1)
+	start, source := 0.0, "start"
+	for i, next := range append(boundaries, boundary{at: duration}) {
+		chapters = append(chapters, &entities.Chapter{
+			LessonId:     job.EntityId,
+			JobId:        job.ID,
+			Position:     i + 1,
+			StartSeconds: start,
+			EndSeconds:   next.at,
+			Title:        fmt.Sprintf("Chapter %d", i+1),
+			Source:       source,
+		})
+		start, source = next.at, "pause"
+		if next.scene {
+			source = "scene"
+		}
+	}
+
+	if err := s.repo.ReplaceChapters(ctx, job.EntityId, chapters); err != nil {
+		return err
+	}
+	zerolog.Ctx(ctx).Info().Int("chapters", len(chapters)).Int("pauses", len(pauses)).Int("scenes", len(scenes)).Msg("chapters detected")
+	return s.Announce(ctx, job, chapters)
+}
+
+func (s *chapterService) Provide(ctx context.Context, job *entities.Job, markers []dto.ChapterMarker, duration float64) ([]*entities.Chapter, error) {
+	if len(markers) == 0 {
+		stored, err := s.repo.ListChapters(ctx, job.EntityId)
+		if err != nil {
+			return nil, err
+		}
+		return slices.DeleteFunc(stored, func(chapter *entities.Chapter) bool {
+			return chapter.JobId != job.ID || chapter.Source != "instructor"
+		}), nil
+	}
+
+	chapters := make([]*entities.Chapter, 0, len(markers))
+	for i, marker := range markers {
+		if duration > 0 && marker.Start >= duration {
+			zerolog.Ctx(ctx).Warn().Float64("start", marker.Start).Float64("duration", duration).Msg("chapter markers past the end of the video dropped")
+			break
+		}
+		end := duration
+		if i+1 < len(markers) {
+			end = min(markers[i+1].Start, duration)
+		}
+		chapters = append(chapters, &entities.Chapter{
+			LessonId:     job.EntityId,
+			JobId:        job.ID,
+			Position:     i + 1,
+			StartSeconds: marker.Start,
+			EndSeconds:   end,
+			Title:        marker.Title,
+			Source:       "instructor",
+		})
+	}
+	if err := s.repo.ReplaceChapters(ctx, job.EntityId, chapters); err != nil {
+		return nil, err
+	}
+	return chapters, nil
+}
+
+func (s *chapterService) Announce(ctx context.Context, job *entities.Job, chapters []*entities.Chapter) error {
+	event := dto.ChaptersEvent{
+		EventId:    uuid.New(),
+		EventType:  ChaptersEventReady,
+		OccurredAt: time.Now().UTC(),
+		LessonId:   job.EntityId,
+		JobId:      job.ID,
+		Chapters:   chapters,
+	}
+	return s.publisher.Publish(ctx, s.cfg.Chapters.Exchange, "lesson."+ChaptersEventReady, event)
+}
+
+func (s *chapterService) List(ctx context.Context, lessonId uuid.UUID) ([]*entities.Chapter, error) {
+	return s.repo.ListChapters(ctx, lessonId)
+}
+
+// parseMarkers reads the JSON chapter markers of upload metadata; empty
+// metadata has none.
+func parseMarkers(raw string) ([]dto.ChapterMarker, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var markers []dto.ChapterMarker
+	if err := json.Unmarshal([]byte(raw), &markers); err != nil {
+		return nil, err
+	}
+	return markers, validateMarkers(markers)
+}
+
+// validateMarkers checks the chapter markers of a job: titled, not negative,
+// and in ascending order with no two at the same time.
+func validateMarkers(markers []dto.ChapterMarker) error {
+	for i, marker := range markers {
+		if strings.TrimSpace(marker.Title) == "" {
+			return fmt.Errorf("chapter %d: title is required", i+1)
+		}
+		if marker.Start < 0 {
+			return fmt.Errorf("chapter %d: start must not be negative, got %g", i+1, marker.Start)
+		}
+		if i > 0 && marker.Start <= markers[i-1].Start {
+			return fmt.Errorf("chapter %d: chapters must be in ascending order of start", i+1)
+		}
+	}
+	return nil
+}
+
+// embedChapters packages chapters with the HLS output in the ways players
+// read them: a sidecar in the chapters format Apple players take from the
+// master playlist's session data, and an EXT-X-DATERANGE per chapter in every
+// media playlist. The output is HLS only, so there are no MP4 chapter atoms
+// to write.
+func embedChapters(outputDir string, chapters []*entities.Chapter) error {
+	type title struct {
+		Language string `json:"language"`
+		Title    string `json:"title"`
+	}
+	type sidecarChapter struct {
+		Chapter   int     `json:"chapter"`
+		StartTime float64 `json:"start-time"`
+		Duration  float64 `json:"duration"`
+		Titles    []title `json:"titles"`
+	}
+	sidecar := make([]sidecarChapter, 0, len(chapters))
+	dateRanges := make([]string, 0, len(chapters))
+	for _, chapter := range chapters {
+		duration := chapter.EndSeconds - chapter.StartSeconds
+		sidecar = append(sidecar, sidecarChapter{
+			Chapter:   chapter.Position,
+			StartTime: chapter.StartSeconds,
+			Duration:  duration,
+			Titles:    []title{{Language: chapterLanguage, Title: chapter.Title}},
+		})
+		dateRanges = append(dateRanges, fmt.Sprintf("#EXT-X-DATERANGE:ID=\"chapter-%d\",CLASS=\"com.edtech.chapter\",START-DATE=\"%s\",DURATION=%.3f,X-TITLE=\"%s\"",
+			chapter.Position, programDate(chapter.StartSeconds), duration, quotedAttribute(chapter.Title)))
+	}
+
+	raw, err := json.MarshalIndent(sidecar, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(outputDir, chaptersSidecar), raw, 0644); err != nil {
+		return err
+	}
+
+	master := filepath.Join(outputDir, "master.m3u8")
+	content, err := os.ReadFile(master)
+	if err != nil {
+		return err
+	}
+	content = append(content, fmt.Sprintf("#EXT-X-SESSION-DATA:DATA-ID=\"com.apple.hls.chapters\",URI=\"%s\"\n", chaptersSidecar)...)
+	if err := os.WriteFile(master, content, 0644); err != nil {
+		return err
+	}
+	return insertDateRanges(outputDir, dateRanges)
+}
+
+// insertDateRanges adds EXT-X-DATERANGE tags ahead of the first segment of
+// every media playlist, or of its first partial segment in a low-latency
+// one, along with the program date they are timed against when the playlist
+// has none yet.
+func insertDateRanges(outputDir string, dateRanges []string) error {
+	playlists, err := filepath.Glob(filepath.Join(outputDir, "*.m3u8"))
+	if err != nil {
+		return err
+	}
+	for _, playlist := range playlists {
+		if filepath.Base(playlist) == "master.m3u8" {
+			continue
+		}
+		content, err := os.ReadFile(playlist)
+		if err != nil {
+			return err
+		}
+		first := strings.Index(string(content), "#EXTINF")
+		if first < 0 {
+			continue
+		}
+		if part := strings.Index(string(content), "#EXT-X-PART:"); part >= 0 && part < first {
+			first = part
+		}
+		var tags strings.Builder
+		if !strings.Contains(string(content[:first]), "#EXT-X-PROGRAM-DATE-TIME") {
+			fmt.Fprintf(&tags, "#EXT-X-PROGRAM-DATE-TIME:%s\n", programDate(0))
+		}
+		for _, dateRange := range dateRanges {
+			tags.WriteString(dateRange + "\n")
+		}
+		content = []byte(string(content[:first]) + tags.String() + string(content[first:]))
+		if err := os.WriteFile(playlist, content, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// programDate is the date of the moment seconds into a packaged playlist.
+func programDate(seconds float64) string {
+	return chapterEpoch.Add(time.Duration(seconds * float64(time.Second))).Format("2006-01-02T15:04:05.000Z")
+}
+
+// quotedAttribute makes s fit a quoted-string playlist attribute, which can't
+// hold double quotes or line breaks.
+func quotedAttribute(s string) string {
+	return strings.NewReplacer(`"`, "'", "\r", " ", "\n", " ").Replace(s)
+}
+
+// analyzeLecture runs silence and scene detection over the recording in one
+// pass. Frames are thinned and shrunk first, since slide changes don't need
+// full resolution to show. Either stream may be missing.
+func analyzeLecture(ctx context.Context, inputFilepath, audioFilepath string, cfg config.Chapters) ([]pause, []float64, error) {
+	args := []string{"-hide_banner", "-nostats", "-i", inputFilepath}
+	audioStream := "0:a:0?"
+	if audioFilepath != "" {
+		args = append(args, "-i", audioFilepath)
+		audioStream = "1:a:0?"
+	}
+	args = append(args,
+		"-map", "0:v:0?",
+		"-vf", fmt.Sprintf("fps=2,scale=320:-2,select='gt(scene,%g)',showinfo", cfg.SceneThreshold),
+		"-map", audioStream,
+		"-af", fmt.Sprintf("silencedetect=noise=%s:d=%d", silenceNoise, max(cfg.MinSilence, 1)),
+		"-f", "null", "-",
+	)
+
+	cmd := ffmpeg.Command(ctx, args...)
+	killProcessGroup(cmd)
+	cmd.WaitDelay = ffmpegWaitDelay
+	zerolog.Ctx(ctx).Info().Str("command", strings.Join(cmd.Args, " ")).Msg("executing FFmpeg command")
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, &FFmpegError{Err: err}
+	}
+	done := trackFFmpeg()
+	defer done()
+
+	// The output is read as it comes since a long lecture logs more than
+	// is worth keeping; only the tail is kept for errors.
+	var (
+		pauses []pause
+		scenes []float64
+		start  = -1.0
+		tail   = &tailBuffer{limit: maxFFmpegOutput}
+	)
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		line := scanner.Text()
+		tail.Write([]byte(line + "\n"))
+		switch {
+		case strings.Contains(line, "Parsed_showinfo"):
+			if match := sceneTimePattern.FindStringSubmatch(line); match != nil {
+				at, _ := strconv.ParseFloat(match[1], 64)
+				scenes = append(scenes, at)
+			}
+		case strings.Contains(line, "silence_start"):
+			if match := silenceStartPattern.FindStringSubmatch(line); match != nil {
+				start, _ = strconv.ParseFloat(match[1], 64)
+			}
+		case strings.Contains(line, "silence_end"):
+			if match := silenceEndPattern.FindStringSubmatch(line); match != nil && start >= 0 {
+				end, _ := strconv.ParseFloat(match[1], 64)
+				pauses = append(pauses, pause{start: max(start, 0), end: end})
+				start = -1
+			}
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return nil, nil, &FFmpegError{Err: err, Output: tail.String()}
+	}
+	return pauses, scenes, nil
+}
+
+// proposeBoundaries turns pauses into chapter starts. A pause with a scene
+// change next to it starts its chapter at the scene change, otherwise where
+// speech resumes. When two would make a chapter shorter than minLength, the
+// one at a scene change wins, then the longer pause.
+func proposeBoundaries(pauses []pause, scenes []float64, duration, minLength float64) []boundary {
+	candidates := make([]boundary, 0, len(pauses))
+	for _, p := range pauses {
+		candidate := boundary{at: p.end, pause: p.end - p.start}
+		for _, scene := range scenes {
+			if scene >= p.start-sceneWindow && scene <= p.end+sceneWindow {
+				candidate.at, candidate.scene = scene, true
+				break
+			}
+		}
+		candidates = append(candidates, candidate)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].scene != candidates[j].scene {
+			return candidates[i].scene
+		}
+		return candidates[i].pause > candidates[j].pause
+	})
+
+	var accepted []boundary
+	for _, candidate := range candidates {
+		if candidate.at < minLength || duration-candidate.at < minLength {
+			continue
+		}
+		tooClose := slices.ContainsFunc(accepted, func(other boundary) bool {
+			return math.Abs(other.at-candidate.at) < minLength
+		})
+		if !tooClose {
+			accepted = append(accepted, candidate)
+		}
+	}
+	sort.Slice(accepted, func(i, j int) bool { return accepted[i].at < accepted[j].at })
+	return accepted
+}
+
+func NewChapterService(repo repository.ChapterRepository, publisher rabbitmq.Publisher, cfg *config.Config) ChapterService {
+	return &chapterService{
+		repo:      repo,
+		publisher: publisher,
+		cfg:       cfg,
+	}
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/dto"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+)
+
+// lessonPrefix is where the API stores everything that belongs to a lesson:
+// lessons/{id}/videos/ for uploads and their HLS output, lessons/{id}/resources/
+// for attachments, lessons/{id}/captions/ for caption tracks. Each job's output is a version in a directory of its own
+// under videos/, deleted once it is replaced and its grace period is over.
+const lessonPrefix = "lessons/"
+
+// cleanupBatch bounds the number of lesson ids per database lookup.
+const cleanupBatch = 500
+
+const (
+	OrphanLessonDeleted = "lesson deleted"
+	OrphanNoVideo       = "lesson has no published video"
+	OrphanUnreferenced  = "not referenced by the lesson's video_url"
+	OrphanSourceLeft    = "source upload left behind by a finished job"
+)
+
+type CleanupService interface {
+	// Orphans scans the bucket for objects older than minAge that are no
+	// longer needed.
+	Orphans(ctx context.Context, minAge time.Duration) (*dto.CleanupReport, error)
+	// Delete removes the report's orphans and records how many went.
+	Delete(ctx context.Context, report *dto.CleanupReport) error
+}
+
+type cleanupService struct {
+	repo    repository.CleanupRepository
+	storage StorageService
+	store   objectstore.Store
+	cfg     *config.Config
+}
+
+func (s *cleanupService) Orphans(ctx context.Context, minAge time.Duration) (*dto.CleanupReport, error) {
+	report := &dto.CleanupReport{
+		GeneratedAt: time.Now().UTC(),
+		DryRun:      true,
+		MinAge:      minAge.String(),
+		Orphans:     []dto.OrphanObject{},
+	}
+
+	cutoff := time.Now().Add(-minAge)
+	byLesson := map[uuid.UUID][]minio.ObjectInfo{}
+	for object := range s.store.ListObjects(ctx, s.cfg.MinIOBucket, minio.ListObjectsOptions{Prefix: lessonPrefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("list objects: %w", object.Err)
+		}
+		report.Scanned++
+		if object.LastModified.After(cutoff) {
+			continue
+		}
+		id, err := uuid.Parse(strings.SplitN(strings.TrimPrefix(object.Key, lessonPrefix), "/", 2)[0])
+		if err != nil {
+			continue
+		}
+		byLesson[id] = append(byLesson[id], object)
+	}
+
+	ids := make([]uuid.UUID, 0, len(byLesson))
+	for id := range byLesson {
+		ids = append(ids, id)
+	}
+	for start := 0; start < len(ids); start += cleanupBatch {
+		batch := ids[start:min(start+cleanupBatch, len(ids))]
+		urls, err := s.repo.FindLessonVideoURLs(ctx, batch)
+		if err != nil {
+			return nil, fmt.Errorf("find lessons: %w", err)
+		}
+		active, err := s.repo.FindEntitiesWithActiveJobs(ctx, batch)
+		if err != nil {
+			return nil, fmt.Errorf("find active jobs: %w", err)
+		}
+
+		for _, id := range batch {
+			if active[id] {
+				continue
+			}
+			videoURL, exists := urls[id]
+			for _, object := range byLesson[id] {
+				reason := orphanReason(id, object.Key, videoURL, exists)
+				if reason == "" {
+					continue
+				}
+				report.Orphans = append(report.Orphans, dto.OrphanObject{
+					Key:          object.Key,
+					Size:         object.Size,
+					LastModified: object.LastModified,
+					Reason:       reason,
+				})
+				report.OrphanBytes += object.Size
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// orphanReason explains why key is no longer needed, or returns "" to keep it.
+// Only video objects are judged for existing lessons; resources belong to the
+// API, and versions are deleted when they expire. Packages directly in
+// videos/ were published before versioning.
+func orphanReason(lessonId uuid.UUID, key, videoURL string, lessonExists bool) string {
+	if !lessonExists {
+		return OrphanLessonDeleted
+	}
+
+	videoDir := lessonPrefix + lessonId.String() + "/videos"
+	if path.Dir(key) != videoDir {
+		return ""
+	}
+	if videoURL == "" {
+		return OrphanNoVideo
+	}
+	switch {
+	case isPackageFile(key):
+		if !strings.HasSuffix(path.Dir(videoURL), videoDir) {
+			return OrphanUnreferenced
+		}
+		return ""
+	default:
+		// Completed jobs delete their source, so any other file here is a
+		// source whose job failed or was superseded.
+		return OrphanSourceLeft
+	}
+}
+
+// isPackageFile tells the files a transcode writes next to its playlists from
+// the uploads it was made from.
+func isPackageFile(key string) bool {
+	switch path.Base(path.Dir(key)) {
+	case slidesDir, postersDir:
+		return true
+	}
+	switch path.Base(key) {
+	case slidesDeck, slidesSidecar, chaptersSidecar, posterImage, postersSidecar:
+		return true
+	}
+	switch path.Ext(key) {
+	case ".m3u8", ".ts", ".m4s":
+		return true
+	}
+	return progressivePattern.MatchString(path.Base(key))
+}
+
+func (s *cleanupService) Delete(ctx context.Context, report *dto.CleanupReport) error {
+	objects := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objects)
+		for _, orphan := range report.Orphans {
+			objects <- minio.ObjectInfo{Key: orphan.Key}
+		}
+	}()
+
+	report.DryRun = false
+	report.Deleted = len(report.Orphans)
+	var failed int
+	for result := range s.store.RemoveObjects(ctx, s.cfg.MinIOBucket, objects, minio.RemoveObjectsOptions{}) {
+		failed++
+		zerolog.Ctx(ctx).Error().Err(result.Err).Str("key", result.ObjectName).Msg("failed to delete orphaned object")
+	}
+	report.Deleted -= failed
+	if failed > 0 {
+		return fmt.Errorf("%d of %d orphaned objects could not be deleted", failed, len(report.Orphans))
+	}
+
+	// The versions of a deleted lesson are never expired, so their packages
+	// come off the storage totals here.
+	released := map[uuid.UUID]bool{}
+	for _, orphan := range report.Orphans {
+		parts := strings.Split(strings.TrimPrefix(orphan.Key, lessonPrefix), "/")
+		if orphan.Reason != OrphanLessonDeleted || len(parts) < 4 || parts[1] != "videos" {
+			continue
+		}
+		// A package is in its job's directory, lessons/{id}/videos/{job id},
+		// or under its content's, lessons/{id}/videos/{hash}-v{n}/{job id}.
+		jobId, err := uuid.Parse(parts[2])
+		if err != nil && len(parts) >= 5 && packageDirPattern.MatchString(parts[2]) {
+			jobId, err = uuid.Parse(parts[3])
+		}
+		if err != nil || released[jobId] {
+			continue
+		}
+		if err := s.storage.Release(ctx, jobId); err != nil {
+			return fmt.Errorf("release storage of %s: %w", jobId, err)
+		}
+		released[jobId] = true
+	}
+	return nil
+}
+
+func NewCleanupService(repo repository.CleanupRepository, storage StorageService, store objectstore.Store, cfg *config.Config) CleanupService {
+	return &cleanupService{
+		repo:    repo,
+		storage: storage,
+		store:   store,
+		cfg:     cfg,
+	}
+}
@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+)
+
+// maxRecordingParts bounds the files one recording is joined from.
+const maxRecordingParts = 50
+
+// joinableVideoCodecs and joinableAudioCodecs are what parts may be encoded
+// with to be joined into an MP4 without re-encoding.
+var (
+	joinableVideoCodecs = []string{"h264", "hevc"}
+	joinableAudioCodecs = []string{"aac", "mp3"}
+)
+
+// validateParts checks the parts of a job's recording: files, not
+// playlists, joined into an MP4 at the job's object path.
+func validateParts(message dto.JobMessage) error {
+	if len(message.Parts) == 0 {
+		return nil
+	}
+	if len(message.Parts) > maxRecordingParts {
+		return fmt.Errorf("parts: at most %d, got %d", maxRecordingParts, len(message.Parts))
+	}
+	if message.Source != nil {
+		return errors.New("parts: a job joins parts or copies a drive source, not both")
+	}
+	if path.Ext(message.ObjectPath) != ".mp4" {
+		return fmt.Errorf("parts: are joined into an MP4, not %s", message.ObjectPath)
+	}
+	for i, key := range message.Parts {
+		switch {
+		case key == "":
+			return fmt.Errorf("part %d: object path is required", i+1)
+		case isHLSSource(key):
+			return fmt.Errorf("part %d: must be a video file, not a playlist", i+1)
+		case key == message.ObjectPath:
+			return fmt.Errorf("part %d: is the object path the parts are joined into", i+1)
+		}
+	}
+	return nil
+}
+
+// joinParts concatenates the parts of a recording, such as the files Zoom
+// splits a long meeting into, into one video at objectPath, and removes the
+// parts. Parts encoded alike are joined without re-encoding; otherwise each
+// is scaled into the largest part's frame and encoded once, at a quality the
+// ladder's encode can't tell from the source. It returns the joined file,
+// written in dir where the download would put objectPath, or "" when the
+// parts were joined by an earlier attempt.
+func (s service) joinParts(ctx context.Context, parts []string, objectPath, dir string) (string, error) {
+	if _, err := s.store.StatObject(ctx, s.cfg.MinIOBucket, objectPath, minio.StatObjectOptions{}); err == nil {
+		zerolog.Ctx(ctx).Info().Str("object_path", objectPath).Msg("recording parts already joined")
+		return "", nil
+	} else if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		return "", err
+	}
+
+	partDir := filepath.Join(dir, "parts")
+	if err := os.MkdirAll(partDir, os.ModePerm); err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(partDir)
+	inputs := make([]string, len(parts))
+	medias := make([]*MediaInfo, len(parts))
+	for i, key := range parts {
+		inputs[i] = filepath.Join(partDir, fmt.Sprintf("%03d%s", i, path.Ext(key)))
+		if err := s.store.FGetObject(ctx, s.cfg.MinIOBucket, key, inputs[i], minio.GetObjectOptions{}); err != nil {
+			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+				return "", errors.Join(ErrNonRetryable, ErrInvalidArgument, fmt.Errorf("part %d %s: %w", i+1, key, err))
+			}
+			return "", err
+		}
+		media, err := ProbeMedia(ctx, inputs[i])
+		if err != nil {
+			return "", classified(constant.ErrorKindSourceCorrupt, fmt.Errorf("part %d: %w", i+1, err))
+		}
+		if media.VideoStream() == nil {
+			return "", classified(constant.ErrorKindSourceCorrupt, fmt.Errorf("part %d has no video", i+1))
+		}
+		medias[i] = media
+	}
+
+	output := filepath.Join(dir, filepath.Base(objectPath))
+	var args []string
+	if partsAlike(medias) {
+		listPath := filepath.Join(partDir, "parts.txt")
+		if err := writeConcatList(listPath, inputs); err != nil {
+			return "", err
+		}
+		args = []string{
+			"-f", "concat",
+			"-safe", "0",
+			"-i", listPath,
+			"-map", "0:v:0",
+			"-map", "0:a:0?",
+			"-c", "copy",
+			"-movflags", "+faststart",
+			"-y", output,
+		}
+	} else {
+		args = normalizeJoinArgs(inputs, medias, output, s.cfg.Server.FFmpegThreads)
+	}
+	if err := runFFmpeg(ctx, args, nil); err != nil {
+		return "", err
+	}
+
+	if _, err := s.store.FPutObject(ctx, s.cfg.MinIOBucket, objectPath, output, minio.PutObjectOptions{ContentType: "video/mp4"}); err != nil {
+		return "", err
+	}
+	for _, key := range parts {
+		if err := s.store.RemoveObject(ctx, s.cfg.MinIOBucket, key, minio.RemoveObjectOptions{}); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Str("part", key).Msg("failed to remove joined recording part")
+		}
+	}
+	return output, nil
+}
+
+// partsAlike reports whether the parts can be joined as they are: the same
+// codecs, frame and audio format throughout, which an MP4 can carry.
+func partsAlike(medias []*MediaInfo) bool {
+	first, firstAudio := medias[0].VideoStream(), medias[0].AudioStream()
+	if !slices.Contains(joinableVideoCodecs, first.CodecName) {
+		return false
+	}
+	if firstAudio != nil && !slices.Contains(joinableAudioCodecs, firstAudio.CodecName) {
+		return false
+	}
+	for _, media := range medias[1:] {
+		video, audio := media.VideoStream(), media.AudioStream()
+		if video.CodecName != first.CodecName || video.Width != first.Width || video.Height != first.Height ||
+			video.PixFmt != first.PixFmt || video.AvgFrameRate != first.AvgFrameRate {
+			return false
+		}
+		if (audio == nil) != (firstAudio == nil) {
+			return false
+		}
+		if audio != nil && (audio.CodecName != firstAudio.CodecName || audio.SampleRate != firstAudio.SampleRate || audio.Channels != firstAudio.Channels) {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeJoinArgs encode the parts into output in one pass, each fitted
+// into the largest part's frame at the first part's frame rate, with its
+// audio resampled to stereo, or silence for a part without audio.
+func normalizeJoinArgs(inputs []string, medias []*MediaInfo, output string, threads int) []string {
+	var width, height int
+	hasAudio := false
+	for _, media := range medias {
+		if video := media.VideoStream(); video.Height > height {
+			width, height = video.Width, video.Height
+		}
+		hasAudio = hasAudio || media.AudioStream() != nil
+	}
+	width, height = width/2*2, height/2*2
+	frameRate := medias[0].VideoStream().AvgFrameRate
+	if numerator, _, _ := strings.Cut(frameRate, "/"); numerator == "" || numerator == "0" {
+		frameRate = "30"
+	}
+
+	var args []string
+	var filters, concat strings.Builder
+	for i, input := range inputs {
+		args = append(args, "-i", input)
+		fmt.Fprintf(&filters, "[%d:v:0]scale=w=%d:h=%d:force_original_aspect_ratio=decrease,pad=w=%d:h=%d:x=(ow-iw)/2:y=(oh-ih)/2,setsar=1,fps=%s,format=yuv420p[v%d]; ",
+			i, width, height, width, height, frameRate, i)
+		fmt.Fprintf(&concat, "[v%d]", i)
+		if !hasAudio {
+			continue
+		}
+		if medias[i].AudioStream() != nil {
+			fmt.Fprintf(&filters, "[%d:a:0]aresample=48000,aformat=channel_layouts=stereo[a%d]; ", i, i)
+		} else {
+			fmt.Fprintf(&filters, "aevalsrc=0:c=stereo:s=48000:d=%s[a%d]; ", strconv.FormatFloat(medias[i].DurationSeconds(), 'f', 3, 64), i)
+		}
+		fmt.Fprintf(&concat, "[a%d]", i)
+	}
+	audioStreams := 0
+	if hasAudio {
+		audioStreams = 1
+	}
+	fmt.Fprintf(&filters, "%sconcat=n=%d:v=1:a=%d[v]", concat.String(), len(inputs), audioStreams)
+	if hasAudio {
+		filters.WriteString("[a]")
+	}
+
+	args = append(args,
+		"-filter_complex", filters.String(),
+		"-map", "[v]",
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-crf", "18",
+		"-pix_fmt", "yuv420p",
+	)
+	if hasAudio {
+		args = append(args, "-map", "[a]", "-c:a", "aac", "-b:a", "192k")
+	}
+	if threads > 0 {
+		args = append(args, "-threads", strconv.Itoa(threads))
+	}
+	return append(args, "-movflags", "+faststart", "-y", output)
+}
+
+// writeConcatList writes the list the concat demuxer joins files from, in
+// order. The files are named relative to the list, in its directory.
+func writeConcatList(listPath string, files []string) error {
+	var list strings.Builder
+	for _, file := range files {
+		fmt.Fprintf(&list, "file '%s'\n", strings.ReplaceAll(filepath.Base(file), "'", `'\''`))
+	}
+	return os.WriteFile(listPath, []byte(list.String()), 0644)
+}
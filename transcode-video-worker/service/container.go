@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"worker-transcode/entities"
+
+	"github.com/rs/zerolog"
+)
+
+// Segment formats a rung's HLS segments can be packaged in.
+const (
+	segmentFormatTS   = "ts"
+	segmentFormatFMP4 = "fmp4"
+)
+
+// fmp4PlaylistVersion is the version of a master playlist with fMP4 rungs,
+// whose media playlists carry an EXT-X-MAP.
+const fmp4PlaylistVersion = 7
+
+// progressiveContainers are the containers a rung's progressive copy can be
+// written in. WebM can't carry the H.264 and AAC the ladder is encoded with,
+// so a WebM copy is encoded again into VP9 and Opus.
+var progressiveContainers = []string{"mp4", "webm", "mkv"}
+
+// progressivePattern matches the progressive copies and fMP4 init segments
+// written next to a package's playlists.
+var progressivePattern = regexp.MustCompile(`^\d+p(_init\.mp4|\.(mp4|webm|mkv))$`)
+
+// segmentExt is the extension of rung r's segments.
+func segmentExt(r entities.Rendition) string {
+	if r.SegmentFormat == segmentFormatFMP4 {
+		return ".m4s"
+	}
+	return ".ts"
+}
+
+// rungSegmentArgs package rung r's playlist in its segment format, the
+// segments under segmentDir; an fMP4 rung's init segment goes next to its
+// playlist.
+func rungSegmentArgs(r entities.Rendition, segmentDir string) []string {
+	var args []string
+	if r.SegmentFormat == segmentFormatFMP4 {
+		args = append(args, "-hls_segment_type", "fmp4", "-hls_fmp4_init_filename", fmt.Sprintf("%dp_init.mp4", r.Height))
+	}
+	return append(args, "-hls_segment_filename", filepath.Join(segmentDir, fmt.Sprintf("%dp_%%03d%s", r.Height, segmentExt(r))))
+}
+
+// masterPlaylistVersion is the EXT-X-VERSION of preset's master playlist.
+func masterPlaylistVersion(preset *entities.Preset) int {
+	for _, r := range preset.Renditions {
+		if r.SegmentFormat == segmentFormatFMP4 {
+			return fmp4PlaylistVersion
+		}
+	}
+	return 3
+}
+
+// progressiveName is the name of rung r's progressive copy.
+func progressiveName(r entities.Rendition) string {
+	return fmt.Sprintf("%dp.%s", r.Height, r.Container)
+}
+
+// writeProgressive writes a progressive copy of every rung of preset that
+// asks for one, muxed from its packaged playlist and the source's audio
+// track into outputDir, for players that download rather than stream.
+func writeProgressive(ctx context.Context, preset *entities.Preset, outputDir string, threads int) error {
+	for _, r := range preset.Renditions {
+		if r.Container == "" {
+			continue
+		}
+		output := filepath.Join(outputDir, progressiveName(r))
+		video := filepath.Join(outputDir, fmt.Sprintf("%dp.m3u8", r.Height))
+		if err := runFFmpeg(ctx, progressiveArgs(r, video, filepath.Join(outputDir, "audio.m3u8"), output, threads), nil); err != nil {
+			os.Remove(output)
+			return fmt.Errorf("progressive %s: %w", filepath.Base(output), err)
+		}
+		zerolog.Ctx(ctx).Info().Str("file", filepath.Base(output)).Msg("progressive copy written")
+	}
+	return nil
+}
+
+// progressiveArgs mux rung r's playlist, video, and the audio playlist into
+// output, copying the streams into MP4 and MKV and encoding them into WebM.
+func progressiveArgs(r entities.Rendition, video, audio, output string, threads int) []string {
+	args := []string{
+		"-i", video,
+		"-i", audio,
+		"-map", "0:v:0",
+		"-map", "1:a:0?",
+	}
+	switch r.Container {
+	case "webm":
+		args = append(args,
+			"-c:v", "libvpx-vp9",
+			"-b:v", r.Bitrate,
+			"-deadline", "good",
+			"-cpu-used", "4",
+			"-row-mt", "1",
+			"-c:a", "libopus",
+			"-b:a", r.AudioRate,
+		)
+		if threads > 0 {
+			args = append(args, "-threads", strconv.Itoa(threads))
+		}
+	case "mp4":
+		args = append(args, "-c", "copy", "-bsf:a", "aac_adtstoasc", "-movflags", "+faststart")
+	default:
+		args = append(args, "-c", "copy")
+	}
+	return append(args, "-y", output)
+}
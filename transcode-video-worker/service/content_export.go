@@ -0,0 +1,555 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/correlation"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/pkg/reporting"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// Content package formats. SCORM 2004 packages follow its 3rd edition, the
+// one LMSs import most widely.
+const (
+	exportFormatSCORM12   = "scorm12"
+	exportFormatSCORM2004 = "scorm2004"
+)
+
+// ContentExportService packages lessons for institutions to import into their
+// own LMS: a ZIP with a SCORM manifest, a player page that reports the lesson
+// completed once it has been watched to the end, the video as an MP4 and as
+// its HLS renditions, the caption tracks and the lesson's metadata.
+type ContentExportService interface {
+	// Request queues a package of the lesson's current video.
+	Request(ctx context.Context, lessonId uuid.UUID, request dto.ExportRequest) (*entities.ContentExport, error)
+	// Link returns the export, with a link to its package once it is made.
+	Link(ctx context.Context, id uuid.UUID) (*dto.ExportLink, error)
+	// Process runs a content package export job.
+	Process(ctx context.Context, message dto.ExportMessage) error
+}
+
+type contentExportService struct {
+	repo        repository.ContentExportRepository
+	transcripts repository.TranscriptRepository
+	lessons     repository.NotificationRepository
+	jobs        repository.JobRepository
+	events      repository.JobEventRepository
+	publisher   rabbitmq.Publisher
+	store       objectstore.Store
+	cfg         *config.Config
+}
+
+func (s *contentExportService) Request(ctx context.Context, lessonId uuid.UUID, request dto.ExportRequest) (*entities.ContentExport, error) {
+	if request.Format != exportFormatSCORM12 && request.Format != exportFormatSCORM2004 {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("format must be %s or %s, got %q", exportFormatSCORM12, exportFormatSCORM2004, request.Format))
+	}
+	playlist, err := s.repo.FindLessonVideo(ctx, lessonId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !isHLSSource(playlist) {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("lesson %s has no published video", lessonId))
+	}
+
+	job := &entities.Job{
+		ID:         uuid.New(),
+		EntityId:   lessonId,
+		EntityType: string(constant.EntityTypeLessonVideo),
+		Status:     constant.JobStatusPending,
+		JobType:    constant.JobTypeExport,
+		UserId:     request.UserId,
+	}
+	if correlationId := correlation.FromContext(ctx); correlationId != "" {
+		job.CorrelationId = &correlationId
+	}
+	export := &entities.ContentExport{
+		ID:       uuid.New(),
+		LessonId: lessonId,
+		JobId:    job.ID,
+		Format:   request.Format,
+	}
+
+	if err := s.jobs.CreateJob(ctx, job); err != nil {
+		return nil, err
+	}
+	if err := s.repo.CreateExport(ctx, export); err != nil {
+		return nil, err
+	}
+	message := dto.ExportMessage{JobId: job.ID}
+	if err := s.publisher.Publish(ctx, rabbitmq.ExportTopology.Exchange, rabbitmq.ExportTopology.RoutingKey, message); err != nil {
+		return nil, err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("job_id", job.ID.String()).
+		Str("lesson_id", lessonId.String()).
+		Str("format", export.Format).
+		Msg("content package export queued")
+	return export, nil
+}
+
+func (s *contentExportService) Link(ctx context.Context, id uuid.UUID) (*dto.ExportLink, error) {
+	export, err := s.repo.FindExport(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if export.ObjectKey == nil {
+		return &dto.ExportLink{ContentExport: export}, nil
+	}
+
+	ttl := time.Duration(s.cfg.Export.LinkTTL) * time.Second
+	params := url.Values{}
+	params.Set("response-content-disposition", fmt.Sprintf("attachment; filename=%q", path.Base(*export.ObjectKey)))
+	link, err := s.store.PresignedGetObject(ctx, s.cfg.MinIOBucket, *export.ObjectKey, ttl, params)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Now().UTC().Add(ttl)
+	return &dto.ExportLink{
+		ContentExport: export,
+		URL:           link.String(),
+		URLExpiresAt:  &expiresAt,
+	}, nil
+}
+
+func (s *contentExportService) Process(ctx context.Context, message dto.ExportMessage) (err error) {
+	job, err := s.jobs.FindJobById(ctx, message.JobId)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to find job by id")
+		return err
+	}
+	if job.Status != constant.JobStatusPending {
+		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("job is not pending")
+		return nil
+	}
+	export, err := s.repo.FindExportByJob(ctx, message.JobId)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to find content export")
+		return err
+	}
+
+	claimed, err := s.jobs.ClaimJob(ctx, message.JobId, correlation.FromContext(ctx), workerFromContext(ctx))
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to update job status")
+		return err
+	}
+	if !claimed {
+		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("job already claimed by another delivery")
+		return nil
+	}
+	rabbitmq.Claimed(ctx)
+	ctx = withTimeline(ctx, s.events, job.ID)
+	recordStatus(ctx, constant.JobStatusPending, constant.JobStatusProcessing)
+
+	stage := constant.ErrorClassWorkspace
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = panicked(ctx, recovered)
+		}
+		if shuttingDown(ctx, err) {
+			err = handOff(ctx, s.jobs, message.JobId, err)
+			return
+		}
+		err = classify(err)
+		recordOutcome(ctx, job, stage, err)
+		recordOutcomeEvents(ctx, stage, err)
+		if err != nil {
+			if errors.Is(err, ErrNonRetryable) {
+				if updateErr := s.jobs.FailJob(ctx, message.JobId, stage, errorKind(err), err.Error()); updateErr != nil {
+					zerolog.Ctx(ctx).Error().Err(updateErr).Msg("failed to update job status")
+				}
+				reporting.CaptureFailure(ctx, err, reporting.Failure{
+					JobId:   message.JobId.String(),
+					JobType: string(job.JobType),
+					Stage:   string(stage),
+					Output:  failureOutput(err),
+					Extra:   map[string]interface{}{"lesson_id": export.LessonId.String(), "format": export.Format},
+				})
+				err = nil
+			} else {
+				if updateErr := s.jobs.UpdateStatusJob(ctx, constant.JobStatusPending, message.JobId); updateErr != nil {
+					zerolog.Ctx(ctx).Error().Err(updateErr).Msg("failed to update job status")
+				}
+			}
+		}
+	}()
+
+	tempDir := filepath.Join("temp", message.JobId.String())
+	defer os.RemoveAll(tempDir)
+	sourceDir := filepath.Join(tempDir, "source")
+	if err = os.MkdirAll(sourceDir, os.ModePerm); err != nil {
+		return errors.Join(ErrNonRetryable, err)
+	}
+
+	stage = constant.ErrorClassDatabase
+	playlist, err := s.repo.FindLessonVideo(ctx, export.LessonId)
+	if err != nil {
+		return err
+	}
+	if !isHLSSource(playlist) {
+		return errors.Join(ErrNonRetryable, fmt.Errorf("lesson %s has no published video", export.LessonId))
+	}
+	summary, err := s.lessons.FindLessonSummary(ctx, export.LessonId)
+	if err != nil {
+		return err
+	}
+	captions, err := s.transcripts.ListCaptions(ctx, export.LessonId)
+	if err != nil {
+		return err
+	}
+
+	stage = constant.ErrorClassDownload
+	var videoInput, audioInput string
+	err = traceStage(ctx, "download", func(ctx context.Context) error {
+		var downloadErr error
+		videoInput, audioInput, downloadErr = downloadHLSSource(ctx, s.store, s.cfg.MinIOBucket, playlist, sourceDir)
+		return downloadErr
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to download lesson video")
+		return err
+	}
+
+	stage = constant.ErrorClassTranscode
+	video := filepath.Join(tempDir, exportVideoFile)
+	if err = runFFmpeg(ctx, exportVideoArgs(videoInput, audioInput, video), nil); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to remux lesson video")
+		return errors.Join(ErrNonRetryable, err)
+	}
+
+	stage = constant.ErrorClassPackage
+	archive := filepath.Join(tempDir, "package.zip")
+	err = traceStage(ctx, "package", func(ctx context.Context) error {
+		return s.writePackage(ctx, archive, export, summary, playlist, video, captions)
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to write content package")
+		return err
+	}
+
+	stage = constant.ErrorClassUpload
+	key := fmt.Sprintf("lessons/%s/exports/%s-%s.zip", export.LessonId, export.JobId, export.Format)
+	var size int64
+	err = traceStage(ctx, "upload", func(ctx context.Context) error {
+		info, uploadErr := s.store.FPutObject(ctx, s.cfg.MinIOBucket, key, archive, minio.PutObjectOptions{ContentType: "application/zip"})
+		size = info.Size
+		return uploadErr
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to upload content package")
+		return err
+	}
+
+	stage = constant.ErrorClassDatabase
+	if err = s.repo.MarkExportReady(ctx, export.ID, key, size); err != nil {
+		return err
+	}
+	if err = s.jobs.UpdateStatusJob(ctx, constant.JobStatusCompleted, message.JobId); err != nil {
+		return err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("job_id", message.JobId.String()).
+		Str("lesson_id", export.LessonId.String()).
+		Str("key", key).
+		Int64("bytes", size).
+		Msg("content package exported")
+	return nil
+}
+
+const (
+	exportVideoFile = "video.mp4"
+	exportHLSDir    = "hls"
+	exportPlayer    = "index.html"
+)
+
+// exportCaption is a caption track as the package carries it.
+type exportCaption struct {
+	Language string
+	File     string
+}
+
+// writePackage writes the ZIP: the manifest and player at its root, as LMSs
+// expect them, next to the media. Media is stored rather than deflated; it
+// is compressed already.
+func (s *contentExportService) writePackage(ctx context.Context, archive string, export *entities.ContentExport, summary *entities.LessonSummary, playlist, video string, captions []*entities.LessonCaption) error {
+	out, err := os.Create(archive)
+	if err != nil {
+		return errors.Join(ErrNonRetryable, err)
+	}
+	defer out.Close()
+	zw := zip.NewWriter(out)
+
+	files := []string{exportPlayer, exportVideoFile}
+	if err := zipFile(zw, exportVideoFile, video); err != nil {
+		return errors.Join(ErrNonRetryable, err)
+	}
+
+	// Players that play HLS natively, Safari above all, get every rendition
+	// and adapt to the learner's connection; the others play the MP4.
+	prefix := path.Dir(playlist) + "/"
+	for object := range s.store.ListObjects(ctx, s.cfg.MinIOBucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return fmt.Errorf("list package: %w", object.Err)
+		}
+		name := path.Join(exportHLSDir, strings.TrimPrefix(object.Key, prefix))
+		if err := s.zipObject(ctx, zw, name, object.Key); err != nil {
+			return err
+		}
+		files = append(files, name)
+	}
+
+	var tracks []exportCaption
+	for _, caption := range captions {
+		if caption.ObjectKey == nil {
+			continue
+		}
+		name := path.Join("captions", caption.Language+".vtt")
+		if err := s.zipObject(ctx, zw, name, *caption.ObjectKey); err != nil {
+			return err
+		}
+		tracks = append(tracks, exportCaption{Language: caption.Language, File: name})
+		files = append(files, name)
+	}
+
+	metadata, err := json.MarshalIndent(map[string]interface{}{
+		"lesson":      summary,
+		"format":      export.Format,
+		"exported_at": time.Now().UTC(),
+	}, "", "  ")
+	if err != nil {
+		return errors.Join(ErrNonRetryable, err)
+	}
+	if err := zipBytes(zw, "metadata.json", metadata); err != nil {
+		return errors.Join(ErrNonRetryable, err)
+	}
+	files = append(files, "metadata.json")
+
+	player, err := exportPlayerPage(export.Format, summary, path.Join(exportHLSDir, path.Base(playlist)), tracks)
+	if err != nil {
+		return errors.Join(ErrNonRetryable, err)
+	}
+	if err := zipBytes(zw, exportPlayer, player); err != nil {
+		return errors.Join(ErrNonRetryable, err)
+	}
+	if err := zipBytes(zw, "imsmanifest.xml", exportManifest(export.Format, summary, files)); err != nil {
+		return errors.Join(ErrNonRetryable, err)
+	}
+
+	if err := zw.Close(); err != nil {
+		return errors.Join(ErrNonRetryable, err)
+	}
+	return out.Close()
+}
+
+func (s *contentExportService) zipObject(ctx context.Context, zw *zip.Writer, name, key string) error {
+	object, err := s.store.GetObject(ctx, s.cfg.MinIOBucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now().UTC()})
+	if err != nil {
+		return errors.Join(ErrNonRetryable, err)
+	}
+	if _, err := io.Copy(w, object); err != nil {
+		return fmt.Errorf("copy %s: %w", key, err)
+	}
+	return nil
+}
+
+func zipFile(zw *zip.Writer, name, file string) error {
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, in)
+	return err
+}
+
+func zipBytes(zw *zip.Writer, name string, content []byte) error {
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	return err
+}
+
+// exportVideoArgs remuxes the top HLS rendition, and its audio, into an MP4
+// any browser plays, without re-encoding.
+func exportVideoArgs(videoInput, audioInput, output string) []string {
+	args := []string{"-i", videoInput}
+	audioMap := "0:a:0?"
+	if audioInput != "" {
+		args = append(args, "-i", audioInput)
+		audioMap = "1:a:0?"
+	}
+	return append(args,
+		"-map", "0:v:0",
+		"-map", audioMap,
+		"-c", "copy",
+		"-bsf:a", "aac_adtstoasc",
+		"-movflags", "+faststart",
+		"-y", output,
+	)
+}
+
+// exportManifest writes the package's imsmanifest.xml: one organization with
+// the lesson as its only SCO, launched from the player page. The schemas it
+// names aren't bundled; LMSs ship their own.
+func exportManifest(format string, summary *entities.LessonSummary, files []string) []byte {
+	var (
+		namespaces    string
+		schemaVersion string
+		scormType     = "adlcp:scormtype"
+	)
+	if format == exportFormatSCORM2004 {
+		namespaces = `xmlns="http://www.imsglobal.org/xsd/imscp_v1p1" xmlns:adlcp="http://www.adlnet.org/xsd/adlcp_v1p3" xmlns:adlseq="http://www.adlnet.org/xsd/adlseq_v1p3" xmlns:adlnav="http://www.adlnet.org/xsd/adlnav_v1p3" xmlns:imsss="http://www.imsglobal.org/xsd/imsss" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://www.imsglobal.org/xsd/imscp_v1p1 imscp_v1p1.xsd http://www.adlnet.org/xsd/adlcp_v1p3 adlcp_v1p3.xsd http://www.adlnet.org/xsd/adlseq_v1p3 adlseq_v1p3.xsd http://www.adlnet.org/xsd/adlnav_v1p3 adlnav_v1p3.xsd http://www.imsglobal.org/xsd/imsss imsss_v1p0.xsd"`
+		schemaVersion = "2004 3rd Edition"
+		scormType = "adlcp:scormType"
+	} else {
+		namespaces = `xmlns="http://www.imsproject.org/xsd/imscp_rootv1p1p2" xmlns:adlcp="http://www.adlnet.org/xsd/adlcp_rootv1p2" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://www.imsproject.org/xsd/imscp_rootv1p1p2 imscp_rootv1p1p2.xsd http://www.imsglobal.org/xsd/imsmd_rootv1p2p1 imsmd_rootv1p2p1.xsd http://www.adlnet.org/xsd/adlcp_rootv1p2 adlcp_rootv1p2.xsd"`
+		schemaVersion = "1.2"
+	}
+
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	fmt.Fprintf(&b, "<manifest identifier=\"lesson-%s\" version=\"1.0\" %s>\n", summary.LessonId, namespaces)
+	fmt.Fprintf(&b, "  <metadata>\n    <schema>ADL SCORM</schema>\n    <schemaversion>%s</schemaversion>\n  </metadata>\n", schemaVersion)
+	b.WriteString("  <organizations default=\"course\">\n")
+	fmt.Fprintf(&b, "    <organization identifier=\"course\">\n      <title>%s</title>\n", escapeXML(summary.CourseTitle))
+	fmt.Fprintf(&b, "      <item identifier=\"lesson\" identifierref=\"player\">\n        <title>%s</title>\n      </item>\n", escapeXML(summary.LessonTitle))
+	b.WriteString("    </organization>\n  </organizations>\n  <resources>\n")
+	fmt.Fprintf(&b, "    <resource identifier=\"player\" type=\"webcontent\" %s=\"sco\" href=\"%s\">\n", scormType, exportPlayer)
+	for _, file := range files {
+		fmt.Fprintf(&b, "      <file href=\"%s\"/>\n", escapeXML(file))
+	}
+	b.WriteString("    </resource>\n  </resources>\n</manifest>\n")
+	return []byte(b.String())
+}
+
+func escapeXML(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// exportPlayerTemplate plays the lesson and reports it to the LMS through the
+// SCORM run-time API of the package's version, found in a parent frame or the
+// window that opened the player as the specs lay out. It is reported
+// completed when the video ends; outside an LMS it just plays.
+var exportPlayerTemplate = template.Must(template.New("player").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>html,body{margin:0;height:100%;background:#000}video{width:100%;height:100%}</style>
+</head>
+<body>
+<video id="video" controls preload="metadata" crossorigin="anonymous">
+{{- range .Captions}}
+<track kind="captions" srclang="{{.Language}}" label="{{.Language}}" src="{{.File}}">
+{{- end}}
+</video>
+<script>
+(function () {
+  var version = {{.Format}};
+  var names = version === "scorm2004"
+    ? {api: "API_1484_11", init: "Initialize", set: "SetValue", commit: "Commit", finish: "Terminate", status: "cmi.completion_status"}
+    : {api: "API", init: "LMSInitialize", set: "LMSSetValue", commit: "LMSCommit", finish: "LMSFinish", status: "cmi.core.lesson_status"};
+
+  function find(win) {
+    for (var tries = 0; win && tries < 10; tries++) {
+      if (win[names.api]) return win[names.api];
+      if (win.parent === win) break;
+      win = win.parent;
+    }
+    return null;
+  }
+  var api = find(window) || (window.opener && find(window.opener));
+
+  var video = document.getElementById("video");
+  video.src = video.canPlayType("application/vnd.apple.mpegurl") ? {{.Playlist}} : {{.Video}};
+  if (!api) return;
+
+  api[names.init]("");
+  if (version !== "scorm2004") api[names.set]("cmi.core.lesson_status", "incomplete");
+  var finished = false;
+  function finish() {
+    if (finished) return;
+    finished = true;
+    api[names.commit]("");
+    api[names.finish]("");
+  }
+  video.addEventListener("ended", function () {
+    api[names.set](names.status, "completed");
+    api[names.commit]("");
+  });
+  window.addEventListener("pagehide", finish);
+  window.addEventListener("beforeunload", finish);
+})();
+</script>
+</body>
+</html>
+`))
+
+func exportPlayerPage(format string, summary *entities.LessonSummary, playlist string, captions []exportCaption) ([]byte, error) {
+	var b bytes.Buffer
+	err := exportPlayerTemplate.Execute(&b, map[string]interface{}{
+		"Title":    summary.LessonTitle,
+		"Format":   format,
+		"Playlist": playlist,
+		"Video":    exportVideoFile,
+		"Captions": captions,
+	})
+	return b.Bytes(), err
+}
+
+func NewContentExportService(repo repository.ContentExportRepository, transcripts repository.TranscriptRepository, lessons repository.NotificationRepository, jobs repository.JobRepository, events repository.JobEventRepository, publisher rabbitmq.Publisher, store objectstore.Store, cfg *config.Config) ContentExportService {
+	return &contentExportService{
+		repo:        repo,
+		transcripts: transcripts,
+		lessons:     lessons,
+		jobs:        jobs,
+		events:      events,
+		publisher:   publisher,
+		store:       store,
+		cfg:         cfg,
+	}
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+const (
+	CourseEventReady = "course.ready"
+	CourseEventMedia = "lesson.media"
+)
+
+// CourseService follows whether the videos of a course are ready to publish,
+// so the publishing workflow waits for one event rather than polling every
+// lesson.
+type CourseService interface {
+	Status(ctx context.Context, courseId uuid.UUID) (*dto.CourseStatus, error)
+	// Check looks at the course of a lesson whose video or captions just
+	// changed, announcing it the first time it is ready and clearing that
+	// once a lesson goes back to processing.
+	Check(ctx context.Context, lessonId uuid.UUID) error
+	// Captioned records a caption track of the lesson and checks its course.
+	Captioned(ctx context.Context, caption *entities.LessonCaption) error
+	// Media records what the lesson's published video measured and tells
+	// the course catalog.
+	Media(ctx context.Context, media *entities.LessonMedia) error
+}
+
+type courseService struct {
+	repo      repository.CourseRepository
+	publisher rabbitmq.Publisher
+	cfg       *config.Config
+}
+
+func (s *courseService) Status(ctx context.Context, courseId uuid.UUID) (*dto.CourseStatus, error) {
+	lessons, err := s.repo.ListLessonStatuses(ctx, courseId)
+	if err != nil {
+		return nil, err
+	}
+	if len(lessons) == 0 {
+		return nil, errors.Join(ErrNotFound, gorm.ErrRecordNotFound)
+	}
+
+	status := &dto.CourseStatus{CourseId: courseId, Lessons: lessons}
+	for _, lesson := range lessons {
+		if !lesson.HasVideo {
+			continue
+		}
+		status.VideoLessons++
+		if lesson.Transcoded {
+			status.Transcoded++
+		}
+		if lesson.Captioned {
+			status.Captioned++
+		}
+		if lesson.Flagged {
+			status.Flagged++
+		}
+		if lesson.JobStatus == nil {
+			continue
+		}
+		switch *lesson.JobStatus {
+		case constant.JobStatusPending, constant.JobStatusProcessing:
+			status.Processing++
+		case constant.JobStatusFailed, constant.JobStatusQualityFailed:
+			status.Failed++
+		}
+	}
+	status.Ready = status.VideoLessons > 0 &&
+		status.Transcoded == status.VideoLessons &&
+		status.Captioned == status.VideoLessons &&
+		status.Processing == 0 &&
+		status.Flagged == 0
+
+	status.ReadyAt, err = s.repo.FindCourseReady(ctx, courseId)
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+func (s *courseService) Check(ctx context.Context, lessonId uuid.UUID) error {
+	courseId, err := s.repo.FindLessonCourse(ctx, lessonId)
+	if err != nil {
+		return err
+	}
+	status, err := s.Status(ctx, courseId)
+	if err != nil {
+		return err
+	}
+	if !status.Ready {
+		return s.repo.ClearCourseReady(ctx, courseId)
+	}
+
+	announce, err := s.repo.MarkCourseReady(ctx, courseId)
+	if err != nil || !announce {
+		return err
+	}
+	event := dto.CourseReadyEvent{
+		EventId:      uuid.New(),
+		EventType:    CourseEventReady,
+		OccurredAt:   time.Now().UTC(),
+		CourseId:     courseId,
+		VideoLessons: status.VideoLessons,
+	}
+	if err := s.publisher.Publish(ctx, s.cfg.Course.Exchange, CourseEventReady, event); err != nil {
+		// Cleared so the next check sends it again.
+		if clearErr := s.repo.ClearCourseReady(context.WithoutCancel(ctx), courseId); clearErr != nil {
+			zerolog.Ctx(ctx).Error().Err(clearErr).Msg("failed to clear course readiness")
+		}
+		return err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("course_id", courseId.String()).
+		Int("video_lessons", status.VideoLessons).
+		Msg("course ready to publish")
+	return nil
+}
+
+// Captioned also sends the lesson's media event again, as it lists the
+// caption languages; a lesson whose video isn't published yet has none.
+func (s *courseService) Captioned(ctx context.Context, caption *entities.LessonCaption) error {
+	if err := s.repo.SaveCaptions(ctx, caption); err != nil {
+		return err
+	}
+	media, err := s.repo.FindMedia(ctx, caption.LessonId)
+	switch {
+	case err == nil:
+		if err := s.announceMedia(ctx, media); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to publish lesson media event")
+		}
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return err
+	}
+	return s.Check(ctx, caption.LessonId)
+}
+
+func (s *courseService) Media(ctx context.Context, media *entities.LessonMedia) error {
+	if err := s.repo.SaveMedia(ctx, media); err != nil {
+		return err
+	}
+	return s.announceMedia(ctx, media)
+}
+
+func (s *courseService) announceMedia(ctx context.Context, media *entities.LessonMedia) error {
+	courseId, err := s.repo.FindLessonCourse(ctx, media.LessonId)
+	if err != nil {
+		return err
+	}
+	languages, err := s.repo.ListCaptionLanguages(ctx, media.LessonId)
+	if err != nil {
+		return err
+	}
+	event := dto.LessonMediaEvent{
+		EventId:          uuid.New(),
+		EventType:        CourseEventMedia,
+		OccurredAt:       time.Now().UTC(),
+		LessonId:         media.LessonId,
+		CourseId:         courseId,
+		JobId:            media.JobId,
+		DurationSeconds:  media.DurationSeconds,
+		Width:            media.Width,
+		Height:           media.Height,
+		AspectRatio:      media.AspectRatio,
+		HasCaptions:      len(languages) > 0,
+		CaptionLanguages: languages,
+		AudioLanguages:   media.AudioLanguages,
+	}
+	if err := s.publisher.Publish(ctx, s.cfg.Course.Exchange, CourseEventMedia, event); err != nil {
+		return err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("lesson_id", media.LessonId.String()).
+		Float64("duration_seconds", media.DurationSeconds).
+		Strs("caption_languages", languages).
+		Msg("lesson media published")
+	return nil
+}
+
+func NewCourseService(repo repository.CourseRepository, publisher rabbitmq.Publisher, cfg *config.Config) CourseService {
+	return &courseService{
+		repo:      repo,
+		publisher: publisher,
+		cfg:       cfg,
+	}
+}
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/metrics"
+
+	"github.com/rs/zerolog"
+)
+
+// kernelLogTimeout bounds reading the kernel log for a crash's OOM kill.
+const kernelLogTimeout = 2 * time.Second
+
+// postMortem collects what's known of an ffmpeg run that exited abnormally,
+// killed by a signal: the OOM killer's, a crashing decoder's or chaos
+// mode's. oomKills is the worker cgroup's count of OOM kills when the run
+// started and last its last progress. A run that failed on its own, or that
+// the worker killed itself when ctx ended, has none and returns nil.
+func postMortem(ctx context.Context, cmd *exec.Cmd, oomKills int64, last *FFmpegProgress) *entities.EncoderCrash {
+	state := cmd.ProcessState
+	if state == nil || ctx.Err() != nil {
+		return nil
+	}
+	signal, killed := exitSignal(state)
+	if signal == "" {
+		return nil
+	}
+	crash := &entities.EncoderCrash{
+		At:               time.Now().UTC(),
+		ExitCode:         state.ExitCode(),
+		Signal:           signal,
+		PeakMemoryBytes:  peakMemory(state),
+		MemoryLimitBytes: config.DetectLimits().MemoryBytes,
+	}
+	// The OOM killer only sends SIGKILL. The cgroup counts the kills of
+	// every job on the worker; the kernel log names the process.
+	if killed {
+		if after := config.OOMKills(); oomKills >= 0 && after > oomKills {
+			crash.OOMKilled = true
+			crash.OOMEvidence = append(crash.OOMEvidence, fmt.Sprintf("cgroup memory.events oom_kill rose from %d to %d", oomKills, after))
+		}
+		if lines := kernelOOMLog(ctx, state.Pid()); len(lines) > 0 {
+			crash.OOMKilled = true
+			crash.OOMEvidence = append(crash.OOMEvidence, lines...)
+		}
+	}
+	if last != nil {
+		crash.LastProgress = &entities.CrashProgress{
+			Frame:          last.Frame,
+			FPS:            last.FPS,
+			OutTimeSeconds: last.OutTime.Seconds(),
+			Speed:          last.Speed,
+		}
+	}
+	if input := ffmpegInput(cmd.Args); input != "" {
+		crash.Input = crashInput(ctx, input)
+	}
+
+	codec := "unknown"
+	if crash.Input != nil && crash.Input.VideoCodec != "" {
+		codec = crash.Input.VideoCodec
+	}
+	metrics.EncoderCrashes.WithLabelValues(crash.Cause(), codec).Inc()
+	zerolog.Ctx(ctx).Error().
+		Str("signal", crash.Signal).
+		Bool("oom_killed", crash.OOMKilled).
+		Int64("peak_memory_bytes", crash.PeakMemoryBytes).
+		Int64("memory_limit_bytes", crash.MemoryLimitBytes).
+		Msg("ffmpeg crashed")
+	return crash
+}
+
+// kernelOOMLog returns the kernel log's lines about the OOM killer killing
+// pid. Reading the log takes privileges the worker may not have, in which
+// case there are none.
+func kernelOOMLog(ctx context.Context, pid int) []string {
+	ctx, cancel := context.WithTimeout(ctx, kernelLogTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, "dmesg").Output()
+	if err != nil {
+		return nil
+	}
+	killed := fmt.Sprintf("Killed process %d ", pid)
+	task := fmt.Sprintf("pid=%d,", pid)
+	var lines []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.Contains(line, killed) || (strings.Contains(line, "oom-kill") && strings.Contains(line, task)) {
+			lines = append(lines, strings.TrimSpace(line))
+		}
+	}
+	return lines
+}
+
+// ffmpegInput is the first input of an ffmpeg command line.
+func ffmpegInput(args []string) string {
+	for i, arg := range args[:max(len(args)-1, 0)] {
+		if arg == "-i" {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// crashInput describes the input a crashed run read, as far as it can be
+// probed.
+func crashInput(ctx context.Context, input string) *entities.CrashInput {
+	described := &entities.CrashInput{}
+	if info, err := os.Stat(input); err == nil {
+		described.SizeBytes = info.Size()
+	}
+	media, err := ProbeMedia(ctx, input)
+	if err != nil {
+		return described
+	}
+	described.Container = media.Format.FormatName
+	described.DurationSeconds = media.DurationSeconds()
+	described.BitRate, _ = strconv.ParseInt(media.Format.BitRate, 10, 64)
+	if video := media.VideoStream(); video != nil {
+		described.VideoCodec = video.CodecName
+		described.Width, described.Height = video.Width, video.Height
+		described.PixFmt = video.PixFmt
+		described.FrameRate = video.AvgFrameRate
+	}
+	if audio := media.AudioStream(); audio != nil {
+		described.AudioCodec = audio.CodecName
+	}
+	return described
+}
+
+// recordCrash keeps the post-mortem of the ffmpeg run that failed the job's
+// attempt, if it crashed, on the job, its timeline and its artifacts.
+func (s service) recordCrash(ctx context.Context, job *entities.Job, stage constant.ErrorClass, err error) {
+	var ffmpegErr *FFmpegError
+	if !errors.As(err, &ffmpegErr) || ffmpegErr.Crash == nil {
+		return
+	}
+	crash := ffmpegErr.Crash
+	addJSONArtifact(ctx, "crash.json", crash)
+	recordEvent(ctx, constant.JobEventCrash, string(stage), entities.EventData{
+		"signal":             crash.Signal,
+		"oom_killed":         crash.OOMKilled,
+		"peak_memory_bytes":  crash.PeakMemoryBytes,
+		"memory_limit_bytes": crash.MemoryLimitBytes,
+		"last_progress":      crash.LastProgress,
+		"input":              crash.Input,
+	})
+	if recordErr := s.repo.RecordJobCrash(ctx, job.ID, crash); recordErr != nil {
+		zerolog.Ctx(ctx).Warn().Err(recordErr).Msg("failed to record encoder crash")
+	}
+}
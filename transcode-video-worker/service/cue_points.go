@@ -0,0 +1,85 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"worker-transcode/dto"
+)
+
+// maxCuePoints bounds the interactions of one lesson, each of which is a tag
+// in every media playlist.
+const maxCuePoints = 200
+
+// cueTypePattern keeps interaction types to what a player can switch on,
+// such as quiz or poll.
+var cueTypePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+// parseCuePoints reads the JSON cue points of upload metadata; empty metadata
+// has none.
+func parseCuePoints(raw string) ([]dto.CuePoint, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var cues []dto.CuePoint
+	if err := json.Unmarshal([]byte(raw), &cues); err != nil {
+		return nil, err
+	}
+	return cues, validateCuePoints(cues)
+}
+
+// validateCuePoints checks the cue points of a job: each with an id of its
+// own, a type and a time that isn't negative.
+func validateCuePoints(cues []dto.CuePoint) error {
+	if len(cues) > maxCuePoints {
+		return fmt.Errorf("at most %d cue points, got %d", maxCuePoints, len(cues))
+	}
+	seen := make(map[string]bool, len(cues))
+	for i, cue := range cues {
+		if strings.TrimSpace(cue.Id) == "" {
+			return fmt.Errorf("cue point %d: id is required", i+1)
+		}
+		if seen[cue.Id] {
+			return fmt.Errorf("cue point %d: id %q is used twice", i+1, cue.Id)
+		}
+		seen[cue.Id] = true
+		if !cueTypePattern.MatchString(cue.Type) {
+			return fmt.Errorf("cue point %d: type %q is not a lowercase word", i+1, cue.Type)
+		}
+		if cue.At < 0 {
+			return fmt.Errorf("cue point %d: at must not be negative, got %g", i+1, cue.At)
+		}
+	}
+	return nil
+}
+
+// embedCuePoints times the interactions into every media playlist as a
+// zero-length EXT-X-DATERANGE, which players surface as timed metadata when
+// playback reaches it. The output is HLS in MPEG-TS segments, so there are
+// no emsg boxes to write. Cue points past the end of the video are dropped.
+func embedCuePoints(outputDir string, cues []dto.CuePoint, duration float64) error {
+	dateRanges := make([]string, 0, len(cues))
+	for _, cue := range cues {
+		if duration > 0 && cue.At >= duration {
+			continue
+		}
+		dateRanges = append(dateRanges, fmt.Sprintf("#EXT-X-DATERANGE:ID=\"cue-%s\",CLASS=\"com.edtech.interaction\",START-DATE=\"%s\",DURATION=0,X-CUE-ID=\"%s\",X-CUE-TYPE=\"%s\"",
+			quotedAttribute(cue.Id), programDate(cue.At), quotedAttribute(cue.Id), cue.Type))
+	}
+	if len(dateRanges) == 0 {
+		return nil
+	}
+	return insertDateRanges(outputDir, dateRanges)
+}
+
+// shiftCuePoints moves cue points onto the trimmed timeline. Those in the cut
+// start come up as the content begins.
+func shiftCuePoints(cues []dto.CuePoint, window trimWindow) []dto.CuePoint {
+	shifted := make([]dto.CuePoint, 0, len(cues))
+	for _, cue := range cues {
+		cue.At = max(cue.At-window.start, 0)
+		shifted = append(shifted, cue)
+	}
+	return shifted
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/objectstore"
+
+	"github.com/minio/minio-go/v7"
+	"gorm.io/gorm"
+)
+
+// outputInputs is everything that shapes a job's package. Two jobs with the
+// same make the same package, so the second can copy the first's.
+type outputInputs struct {
+	Source        string          `json:"source"`
+	Audio         string          `json:"audio,omitempty"`
+	Dubs          []outputDub     `json:"dubs,omitempty"`
+	Preset        string          `json:"preset"`
+	PresetVersion int             `json:"preset_version"`
+	Chapters      []outputChapter `json:"chapters,omitempty"`
+	CuePoints     []dto.CuePoint  `json:"cue_points,omitempty"`
+	Slides        *config.Slides  `json:"slides,omitempty"`
+	Preview       *config.Preview `json:"preview,omitempty"`
+	LowLatency    bool            `json:"low_latency,omitempty"`
+}
+
+type outputDub struct {
+	SHA256      string `json:"sha256"`
+	Language    string `json:"language"`
+	Name        string `json:"name"`
+	Description bool   `json:"description"`
+}
+
+type outputChapter struct {
+	Start float64 `json:"start"`
+	Title string  `json:"title"`
+}
+
+// outputKey hashes the inputs the job is about to encode, as trimmed,
+// branded and composited, with the settings of every stage writing into the
+// package. It returns the key and the hash of the video input.
+func outputKey(ctx context.Context, preset *entities.Preset, inputFilepath, audioFilepath string, dubs []dubbedAudio, chapters []*entities.Chapter,
+	cuePoints []dto.CuePoint, screenRecording, lowLatency bool, cfg *config.Config) (string, string, error) {
+	source, err := hashFile(inputFilepath)
+	if err != nil {
+		return "", "", err
+	}
+	inputs := outputInputs{
+		Source:        source,
+		Preset:        preset.Name,
+		PresetVersion: preset.Version,
+		CuePoints:     cuePoints,
+		LowLatency:    lowLatency,
+	}
+	if audioFilepath != "" {
+		if inputs.Audio, err = hashFile(audioFilepath); err != nil {
+			return "", "", err
+		}
+	}
+	for _, dub := range dubs {
+		hash, err := hashFile(dub.path)
+		if err != nil {
+			return "", "", err
+		}
+		inputs.Dubs = append(inputs.Dubs, outputDub{SHA256: hash, Language: dub.language, Name: dub.name, Description: dub.description})
+	}
+	for _, chapter := range chapters {
+		inputs.Chapters = append(inputs.Chapters, outputChapter{Start: chapter.StartSeconds, Title: chapter.Title})
+	}
+	if featureEnabled(ctx, constant.TenantFeatureSlides, cfg.Slides.Enabled) && screenRecording {
+		inputs.Slides = &cfg.Slides
+	}
+	if featureEnabled(ctx, constant.TenantFeaturePreview, cfg.Preview.Enabled) {
+		inputs.Preview = &cfg.Preview
+	}
+
+	encoded, err := json.Marshal(inputs)
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:]), source, nil
+}
+
+func hashFile(name string) (string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// reusableOutput finds the package made for key, if it is still in the
+// bucket; expired versions are deleted with their package.
+func (s service) reusableOutput(ctx context.Context, key string) (*entities.TranscodeOutput, error) {
+	output, err := s.outputs.FindOutput(ctx, key)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.store.StatObject(ctx, s.cfg.MinIOBucket, path.Join(output.PackagePath, "master.m3u8"), minio.StatObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// copyPackage copies every object of the package under from to the same
+// place under to, within the bucket, and returns the size of each object
+// copied, by its path under to, including when a later one fails.
+func copyPackage(ctx context.Context, store objectstore.Store, cfg *config.Config, from, to string) (map[string]int64, error) {
+	copied := map[string]int64{}
+	prefix := strings.TrimSuffix(from, "/") + "/"
+	for object := range store.ListObjects(ctx, cfg.MinIOBucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return copied, object.Err
+		}
+		name := strings.TrimPrefix(object.Key, prefix)
+		_, err := store.CopyObject(ctx,
+			minio.CopyDestOptions{Bucket: cfg.MinIOBucket, Object: path.Join(to, name)},
+			minio.CopySrcOptions{Bucket: cfg.MinIOBucket, Object: object.Key})
+		if err != nil {
+			return copied, fmt.Errorf("copy %s: %w", object.Key, err)
+		}
+		copied[name] = object.Size
+	}
+	return copied, nil
+}
@@ -0,0 +1,462 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/correlation"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/pkg/reporting"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// deletionQueueInterval is how often deletions past their grace window are
+// queued.
+const deletionQueueInterval = time.Minute
+
+// MediaDeletionService erases a lesson's video, or a user's media, end to
+// end for erasure requests: every object the worker wrote and every row it
+// keeps of them, recorded in the deletion's report. A deletion waits out its
+// grace window first, with a lesson's video hidden from students, and until
+// then can be cancelled.
+type MediaDeletionService interface {
+	// Request schedules the deletion of the scope's media, or returns the
+	// one already scheduled or queued for it.
+	Request(ctx context.Context, request dto.DeletionRequest) (*entities.MediaDeletion, error)
+	Find(ctx context.Context, id uuid.UUID) (*entities.MediaDeletion, error)
+	// Cancel cancels a deletion still in its grace window and shows the
+	// lesson's video again.
+	Cancel(ctx context.Context, id uuid.UUID) (*entities.MediaDeletion, error)
+	// Queue queues the deletions past their grace window every
+	// deletionQueueInterval until ctx is done. Only the leader runs it.
+	Queue(ctx context.Context)
+	// Process runs a deletion job.
+	Process(ctx context.Context, message dto.DeletionMessage) error
+}
+
+type mediaDeletionService struct {
+	repo      repository.DeletionRepository
+	cleanup   repository.CleanupRepository
+	jobs      repository.JobRepository
+	events    repository.JobEventRepository
+	publisher rabbitmq.Publisher
+	store     objectstore.Store
+	cfg       *config.Config
+}
+
+func (s *mediaDeletionService) Request(ctx context.Context, request dto.DeletionRequest) (*entities.MediaDeletion, error) {
+	scope := constant.DeletionScope(request.Scope)
+	if scope != constant.DeletionScopeLesson && scope != constant.DeletionScopeUser {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("unknown scope %q", request.Scope))
+	}
+	grace := s.cfg.Deletion.Grace
+	if request.GraceSeconds != nil {
+		if *request.GraceSeconds < 0 {
+			return nil, errors.Join(ErrInvalidArgument, errors.New("grace_seconds is negative"))
+		}
+		grace = *request.GraceSeconds
+	}
+
+	open, err := s.repo.FindOpenDeletion(ctx, scope, request.ScopeId)
+	if err == nil {
+		return open, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	// A job running on the lesson would publish what the deletion removed.
+	if scope == constant.DeletionScopeLesson {
+		active, err := s.cleanup.FindEntitiesWithActiveJobs(ctx, []uuid.UUID{request.ScopeId})
+		if err != nil {
+			return nil, err
+		}
+		if active[request.ScopeId] {
+			return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("lesson %s has a job running", request.ScopeId))
+		}
+	}
+
+	deletion := &entities.MediaDeletion{
+		ID:          uuid.New(),
+		Scope:       scope,
+		ScopeId:     request.ScopeId,
+		Status:      constant.DeletionStatusScheduled,
+		RequestedBy: request.UserId,
+		PurgeAfter:  time.Now().UTC().Add(time.Duration(grace) * time.Second),
+		Report:      entities.DeletionReport{Objects: map[string]entities.DeletedObjects{}, Rows: map[string]int64{}},
+	}
+	if request.Reason != "" {
+		deletion.Reason = &request.Reason
+	}
+	if err := s.repo.CreateDeletion(ctx, deletion); err != nil {
+		return nil, err
+	}
+	zerolog.Ctx(ctx).Info().
+		Str("deletion_id", deletion.ID.String()).
+		Str("scope", string(scope)).
+		Str("scope_id", request.ScopeId.String()).
+		Time("purge_after", deletion.PurgeAfter).
+		Msg("media deletion scheduled")
+
+	if grace == 0 {
+		if err := s.queue(ctx, deletion); err != nil {
+			return nil, err
+		}
+		return s.repo.FindDeletion(ctx, deletion.ID)
+	}
+	return deletion, nil
+}
+
+func (s *mediaDeletionService) Find(ctx context.Context, id uuid.UUID) (*entities.MediaDeletion, error) {
+	deletion, err := s.repo.FindDeletion(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	return deletion, err
+}
+
+func (s *mediaDeletionService) Cancel(ctx context.Context, id uuid.UUID) (*entities.MediaDeletion, error) {
+	deletion, err := s.Find(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	cancelled, err := s.repo.CancelDeletion(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !cancelled {
+		deletion, err = s.repo.FindDeletion(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if deletion.Status == constant.DeletionStatusCancelled {
+			return deletion, nil
+		}
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("deletion %s is %s", id, strings.ToLower(string(deletion.Status))))
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("deletion_id", id.String()).
+		Str("scope", string(deletion.Scope)).
+		Str("scope_id", deletion.ScopeId.String()).
+		Msg("media deletion cancelled")
+	return s.repo.FindDeletion(ctx, id)
+}
+
+func (s *mediaDeletionService) Queue(ctx context.Context) {
+	ticker := time.NewTicker(deletionQueueInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.queueDue(ctx); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to queue due media deletions")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *mediaDeletionService) queueDue(ctx context.Context) error {
+	deletions, err := s.repo.ListDueDeletions(ctx, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	for _, deletion := range deletions {
+		if err := s.queue(ctx, deletion); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queue creates the deletion's job and publishes it, unless the deletion was
+// cancelled or queued in the meantime.
+func (s *mediaDeletionService) queue(ctx context.Context, deletion *entities.MediaDeletion) error {
+	entityType := constant.EntityTypeLessonVideo
+	if deletion.Scope == constant.DeletionScopeUser {
+		entityType = constant.EntityTypeUserAvatar
+	}
+	job := &entities.Job{
+		ID:         uuid.New(),
+		EntityId:   deletion.ScopeId,
+		EntityType: string(entityType),
+		Status:     constant.JobStatusPending,
+		JobType:    constant.JobTypeDeletion,
+		UserId:     deletion.RequestedBy,
+	}
+	if correlationId := correlation.FromContext(ctx); correlationId != "" {
+		job.CorrelationId = &correlationId
+	}
+
+	queued, err := s.repo.QueueDeletion(ctx, deletion.ID, job)
+	if err != nil || !queued {
+		return err
+	}
+	message := dto.DeletionMessage{JobId: job.ID}
+	if err := s.publisher.Publish(ctx, rabbitmq.DeletionTopology.Exchange, rabbitmq.DeletionTopology.RoutingKey, message); err != nil {
+		return err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("job_id", job.ID.String()).
+		Str("deletion_id", deletion.ID.String()).
+		Str("scope", string(deletion.Scope)).
+		Str("scope_id", deletion.ScopeId.String()).
+		Msg("media deletion queued")
+	return nil
+}
+
+func (s *mediaDeletionService) Process(ctx context.Context, message dto.DeletionMessage) (err error) {
+	job, err := s.jobs.FindJobById(ctx, message.JobId)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to find job by id")
+		return err
+	}
+	if job.Status != constant.JobStatusPending {
+		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("job is not pending")
+		return nil
+	}
+	deletion, err := s.repo.FindDeletionByJob(ctx, message.JobId)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to find deletion")
+		return err
+	}
+
+	claimed, err := s.jobs.ClaimJob(ctx, message.JobId, correlation.FromContext(ctx), workerFromContext(ctx))
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to update job status")
+		return err
+	}
+	if !claimed {
+		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("job already claimed by another delivery")
+		return nil
+	}
+	rabbitmq.Claimed(ctx)
+	ctx = withTimeline(ctx, s.events, job.ID)
+	recordStatus(ctx, constant.JobStatusPending, constant.JobStatusProcessing)
+
+	stage := constant.ErrorClassDatabase
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = panicked(ctx, recovered)
+		}
+		if shuttingDown(ctx, err) {
+			err = handOff(ctx, s.jobs, message.JobId, err)
+			return
+		}
+		err = classify(err)
+		recordOutcome(ctx, job, stage, err)
+		recordOutcomeEvents(ctx, stage, err)
+		if err != nil {
+			if errors.Is(err, ErrNonRetryable) {
+				if updateErr := s.jobs.FailJob(ctx, message.JobId, stage, errorKind(err), err.Error()); updateErr != nil {
+					zerolog.Ctx(ctx).Error().Err(updateErr).Msg("failed to update job status")
+				}
+				reporting.CaptureFailure(ctx, err, reporting.Failure{
+					JobId:   message.JobId.String(),
+					JobType: string(job.JobType),
+					Stage:   string(stage),
+					Output:  failureOutput(err),
+					Extra:   map[string]interface{}{"deletion_id": deletion.ID.String(), "scope": string(deletion.Scope)},
+				})
+				err = nil
+			} else {
+				if updateErr := s.jobs.UpdateStatusJob(ctx, constant.JobStatusPending, message.JobId); updateErr != nil {
+					zerolog.Ctx(ctx).Error().Err(updateErr).Msg("failed to update job status")
+				}
+			}
+		}
+	}()
+
+	report := deletion.Report
+	if report.Objects == nil {
+		report.Objects = map[string]entities.DeletedObjects{}
+	}
+	if report.Rows == nil {
+		report.Rows = map[string]int64{}
+	}
+
+	// Objects go first: a user's are found through the rows, and a run that
+	// fails part way finds the rest again.
+	var prefixes []objectPrefix
+	switch deletion.Scope {
+	case constant.DeletionScopeLesson:
+		prefixes = lessonPrefixes(deletion.ScopeId)
+	case constant.DeletionScopeUser:
+		prefixes, err = s.userPrefixes(ctx, deletion.ScopeId)
+		if err != nil {
+			return err
+		}
+	default:
+		return errors.Join(ErrNonRetryable, fmt.Errorf("unknown deletion scope %q", deletion.Scope))
+	}
+
+	stage = constant.ErrorClassUpload
+	err = traceStage(ctx, "delete_objects", func(ctx context.Context) error {
+		for _, prefix := range prefixes {
+			deleted, removeErr := s.removeObjects(ctx, prefix)
+			if deleted.Count > 0 {
+				total := report.Objects[prefix.prefix]
+				total.Count += deleted.Count
+				total.Bytes += deleted.Bytes
+				report.Objects[prefix.prefix] = total
+			}
+			if removeErr != nil {
+				return removeErr
+			}
+		}
+		return nil
+	})
+	if saveErr := s.repo.SaveDeletionReport(ctx, deletion.ID, report); saveErr != nil {
+		zerolog.Ctx(ctx).Error().Err(saveErr).Msg("failed to save deletion report")
+	}
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to delete objects")
+		return err
+	}
+
+	stage = constant.ErrorClassDatabase
+	var rows map[string]int64
+	err = traceStage(ctx, "delete_records", func(ctx context.Context) error {
+		var deleteErr error
+		if deletion.Scope == constant.DeletionScopeLesson {
+			rows, deleteErr = s.repo.DeleteLessonRecords(ctx, deletion.ScopeId)
+		} else {
+			rows, deleteErr = s.repo.DeleteUserRecords(ctx, deletion.ScopeId)
+		}
+		return deleteErr
+	})
+	if err != nil {
+		return err
+	}
+	for table, count := range rows {
+		report.Rows[table] += count
+	}
+	if err = s.repo.CompleteDeletion(ctx, deletion.ID, report); err != nil {
+		return err
+	}
+	if err = s.jobs.UpdateStatusJob(ctx, constant.JobStatusCompleted, message.JobId); err != nil {
+		return err
+	}
+
+	objects := 0
+	for _, deleted := range report.Objects {
+		objects += deleted.Count
+	}
+	zerolog.Ctx(ctx).Info().
+		Str("job_id", message.JobId.String()).
+		Str("deletion_id", deletion.ID.String()).
+		Str("scope", string(deletion.Scope)).
+		Str("scope_id", deletion.ScopeId.String()).
+		Int("objects", objects).
+		Msg("media deleted")
+	return nil
+}
+
+// objectPrefix is a prefix of the bucket a deletion empties, but for the
+// keys keep holds on to.
+type objectPrefix struct {
+	prefix string
+	keep   func(key string) bool
+}
+
+// lessonPrefixes are where the worker writes what it makes of a lesson: its
+// versions, renditions, captions, posters and exports under the lesson's
+// prefix, and its students' watermarked renditions. The lesson's attachments
+// in resources/ belong to the API, which deletes them with the lesson.
+func lessonPrefixes(lessonId uuid.UUID) []objectPrefix {
+	resources := lessonPrefix + lessonId.String() + "/resources/"
+	return []objectPrefix{
+		{prefix: lessonPrefix + lessonId.String() + "/", keep: func(key string) bool { return strings.HasPrefix(key, resources) }},
+		{prefix: path.Join(watermarkPrefix, lessonId.String()) + "/"},
+	}
+}
+
+// userPrefixes are the user's watermarked renditions and their avatars, both
+// the upload and the sizes made of it.
+func (s *mediaDeletionService) userPrefixes(ctx context.Context, userId uuid.UUID) ([]objectPrefix, error) {
+	renditions, err := s.repo.ListUserWatermarks(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	avatars, err := s.repo.ListUserAvatars(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	var prefixes []objectPrefix
+	for _, rendition := range renditions {
+		prefixes = append(prefixes, objectPrefix{prefix: path.Join(watermarkPrefix, rendition.LessonId.String(), rendition.ID.String()) + "/"})
+	}
+	for _, avatar := range avatars {
+		source := avatar.SourceKey
+		prefixes = append(prefixes, objectPrefix{prefix: source, keep: func(key string) bool { return key != source }})
+	}
+	prefixes = append(prefixes, objectPrefix{prefix: mediaPrefix + userId.String() + "/"})
+	return prefixes, nil
+}
+
+// removeObjects deletes the objects under prefix, returning how many it
+// deleted before any error.
+func (s *mediaDeletionService) removeObjects(ctx context.Context, prefix objectPrefix) (entities.DeletedObjects, error) {
+	var deleted entities.DeletedObjects
+	var found []minio.ObjectInfo
+	for object := range s.store.ListObjects(ctx, s.cfg.MinIOBucket, minio.ListObjectsOptions{Prefix: prefix.prefix, Recursive: true}) {
+		if object.Err != nil {
+			return deleted, fmt.Errorf("list %s: %w", prefix.prefix, object.Err)
+		}
+		if prefix.keep != nil && prefix.keep(object.Key) {
+			continue
+		}
+		found = append(found, object)
+	}
+	if len(found) == 0 {
+		return deleted, nil
+	}
+
+	objects := make(chan minio.ObjectInfo, len(found))
+	for _, object := range found {
+		objects <- object
+	}
+	close(objects)
+	failed := map[string]bool{}
+	var err error
+	for result := range s.store.RemoveObjects(ctx, s.cfg.MinIOBucket, objects, minio.RemoveObjectsOptions{}) {
+		failed[result.ObjectName] = true
+		err = errors.Join(err, fmt.Errorf("remove %s: %w", result.ObjectName, result.Err))
+	}
+	for _, object := range found {
+		if !failed[object.Key] {
+			deleted.Count++
+			deleted.Bytes += object.Size
+		}
+	}
+	return deleted, err
+}
+
+func NewMediaDeletionService(repo repository.DeletionRepository, cleanup repository.CleanupRepository, jobs repository.JobRepository, events repository.JobEventRepository, publisher rabbitmq.Publisher, store objectstore.Store, cfg *config.Config) MediaDeletionService {
+	return &mediaDeletionService{
+		repo:      repo,
+		cleanup:   cleanup,
+		jobs:      jobs,
+		events:    events,
+		publisher: publisher,
+		store:     store,
+		cfg:       cfg,
+	}
+}
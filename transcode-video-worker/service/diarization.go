@@ -0,0 +1,151 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"worker-transcode/config"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/diarize"
+	"worker-transcode/pkg/search"
+	"worker-transcode/repository"
+
+	"github.com/rs/zerolog"
+)
+
+// turnGap is the longest pause between two turns of a speaker that are
+// kept as one.
+const turnGap = 1.0
+
+// DiarizationService finds who speaks when in lesson videos, so the
+// captions of panel discussions and Q&A sessions say who is talking. The
+// turns are kept with the lesson; transcripts indexed afterwards take their
+// speakers from them.
+type DiarizationService interface {
+	// Diarize finds the speakers of the job's source. They're named after
+	// names in the order they first speak, and Speaker 1, 2 and so on past
+	// them. A video with a single speaker, such as a lecture, keeps none.
+	Diarize(ctx context.Context, job *entities.Job, inputFilepath, audioFilepath string, names []string) error
+}
+
+type diarizationService struct {
+	repo repository.TranscriptRepository
+	cfg  *config.Config
+}
+
+func (s *diarizationService) Diarize(ctx context.Context, job *entities.Job, inputFilepath, audioFilepath string, names []string) error {
+	source := inputFilepath
+	if audioFilepath != "" {
+		source = audioFilepath
+	}
+	media, err := ProbeMedia(ctx, source)
+	if err != nil {
+		return err
+	}
+	if media.AudioStream() == nil {
+		return s.repo.DeleteSpeakers(ctx, job.EntityId)
+	}
+
+	dir, err := scratchDir(ctx, s.cfg, filepath.Join(job.ID.String(), "diarization"))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	audio := filepath.Join(dir, "audio.wav")
+	_, err = ffmpegStderr(ctx, []string{"-hide_banner", "-nostats", "-y",
+		"-i", source,
+		"-map", "0:a:0", "-vn",
+		"-ac", "1", "-ar", "16000", "-c:a", "pcm_s16le",
+		audio,
+	})
+	if err != nil {
+		return fmt.Errorf("extract audio: %w", err)
+	}
+	found, err := diarize.New(s.cfg.Diarization).Diarize(ctx, audio)
+	if err != nil {
+		return err
+	}
+
+	turns, speakers := labelSpeakers(found, names)
+	addJSONArtifact(ctx, "diarization/turns.json", turns)
+	logger := zerolog.Ctx(ctx).With().Int("speakers", speakers).Int("turns", len(turns)).Logger()
+	if speakers < 2 {
+		logger.Info().Msg("a single speaker heard, captions left unlabeled")
+		return s.repo.DeleteSpeakers(ctx, job.EntityId)
+	}
+	err = s.repo.SaveSpeakers(ctx, &entities.LessonSpeakers{
+		LessonId: job.EntityId,
+		JobId:    job.ID,
+		Speakers: speakers,
+		Turns:    turns,
+	})
+	if err == nil {
+		logger.Info().Msg("speakers diarized")
+	}
+	return err
+}
+
+// labelSpeakers orders the turns, joins a speaker's turns with no more than
+// turnGap between them and names the speakers in the order they first
+// speak. It returns the turns with how many speakers they have.
+func labelSpeakers(found []diarize.Turn, names []string) (entities.SpeakerTurns, int) {
+	sorted := slices.Clone(found)
+	slices.SortStableFunc(sorted, func(a, b diarize.Turn) int { return cmp.Compare(a.Start, b.Start) })
+
+	labels := map[string]string{}
+	var turns entities.SpeakerTurns
+	for _, turn := range sorted {
+		if turn.End <= turn.Start {
+			continue
+		}
+		label, ok := labels[turn.Speaker]
+		if !ok {
+			label = fmt.Sprintf("Speaker %d", len(labels)+1)
+			if len(labels) < len(names) && names[len(labels)] != "" {
+				label = names[len(labels)]
+			}
+			labels[turn.Speaker] = label
+		}
+		if n := len(turns); n > 0 && turns[n-1].Speaker == label && turn.Start-turns[n-1].End <= turnGap {
+			turns[n-1].End = max(turns[n-1].End, turn.End)
+			continue
+		}
+		turns = append(turns, entities.SpeakerTurn{Start: turn.Start, End: turn.End, Speaker: label})
+	}
+	return turns, len(labels)
+}
+
+// labelCues gives each cue that names no speaker the speaker of the turn it
+// overlaps most, if any.
+func labelCues(cues []search.Cue, turns entities.SpeakerTurns) []search.Cue {
+	for i, cue := range cues {
+		if cue.Speaker != "" {
+			continue
+		}
+		var most float64
+		for _, turn := range turns {
+			if turn.Start >= cue.End {
+				break
+			}
+			if overlap := min(cue.End, turn.End) - max(cue.Start, turn.Start); overlap > most {
+				most = overlap
+				cues[i].Speaker = turn.Speaker
+			}
+		}
+	}
+	return cues
+}
+
+func NewDiarizationService(repo repository.TranscriptRepository, cfg *config.Config) DiarizationService {
+	return &diarizationService{
+		repo: repo,
+		cfg:  cfg,
+	}
+}
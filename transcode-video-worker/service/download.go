@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// downloadFile is the name of a lesson's offline rendition under
+// lessons/{id}/downloads/, out of the streaming package's videos/.
+const downloadFile = "offline.mp4"
+
+// DownloadService makes the offline renditions the mobile app downloads. They
+// are one progressive MP4 per lesson, registered on their own so no player
+// streams them.
+type DownloadService interface {
+	// Publish encodes the lesson's offline rendition from the job's source,
+	// uploads it and registers it in place of the lesson's previous one.
+	Publish(ctx context.Context, job *entities.Job, inputFilepath, audioFilepath string, duration float64) error
+	// Link returns the lesson's offline rendition with a URL to download it
+	// from, valid for LinkTTL seconds.
+	Link(ctx context.Context, lessonId uuid.UUID) (*dto.DownloadLink, error)
+}
+
+type downloadService struct {
+	repo  repository.DownloadRepository
+	store objectstore.Store
+	cfg   *config.Config
+}
+
+func (s *downloadService) Publish(ctx context.Context, job *entities.Job, inputFilepath, audioFilepath string, duration float64) error {
+	dir, err := scratchDir(ctx, s.cfg, filepath.Join(job.ID.String(), "download"))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	output := filepath.Join(dir, downloadFile)
+	args := downloadArgs(inputFilepath, audioFilepath, output, s.cfg.Download.Height, s.cfg.Server.FFmpegThreads)
+	if err := runFFmpeg(ctx, args, nil); err != nil {
+		return err
+	}
+	info, err := ProbeMedia(ctx, output)
+	if err != nil {
+		return err
+	}
+	var height int
+	if video := info.VideoStream(); video != nil {
+		height = video.Height
+	}
+
+	key := downloadKey(job.EntityId)
+	object, err := s.store.FPutObject(ctx, s.cfg.MinIOBucket, key, output, minio.PutObjectOptions{
+		ContentType:  "video/mp4",
+		UserMetadata: map[string]string{"download-only": "true"},
+	})
+	if err != nil {
+		return fmt.Errorf("upload offline rendition: %w", err)
+	}
+
+	err = s.repo.SaveDownload(ctx, &entities.Download{
+		LessonId:        job.EntityId,
+		JobId:           job.ID,
+		ObjectKey:       key,
+		SizeBytes:       object.Size,
+		Height:          height,
+		DurationSeconds: duration,
+		OfflineDays:     s.cfg.Download.OfflineDays,
+	})
+	if err != nil {
+		return err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("key", key).
+		Int64("bytes", object.Size).
+		Int("height", height).
+		Msg("offline rendition published")
+	return nil
+}
+
+func (s *downloadService) Link(ctx context.Context, lessonId uuid.UUID) (*dto.DownloadLink, error) {
+	download, err := s.repo.FindDownload(ctx, lessonId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := time.Duration(s.cfg.Download.LinkTTL) * time.Second
+	params := url.Values{}
+	params.Set("response-content-disposition", fmt.Sprintf("attachment; filename=%q", lessonId.String()+".mp4"))
+	link, err := s.store.PresignedGetObject(ctx, s.cfg.MinIOBucket, download.ObjectKey, ttl, params)
+	if err != nil {
+		return nil, err
+	}
+	return &dto.DownloadLink{
+		Download:     download,
+		URL:          link.String(),
+		URLExpiresAt: time.Now().UTC().Add(ttl),
+	}, nil
+}
+
+// downloadArgs encodes a single-file MP4 of at most height lines that the
+// app's native player can play from the first byte: H.264 and AAC with the
+// index at the front.
+func downloadArgs(inputFilepath, audioFilepath, output string, height, threads int) []string {
+	args := []string{"-i", inputFilepath}
+	audioMap := "0:a:0?"
+	if audioFilepath != "" {
+		args = append(args, "-i", audioFilepath)
+		audioMap = "1:a:0?"
+	}
+	args = append(args,
+		"-map", "0:v:0",
+		"-map", audioMap,
+		"-vf", fmt.Sprintf("scale=-2:'min(ih,%d)'", height),
+		"-c:v", "libx264",
+		"-profile:v", "main",
+		"-preset", "veryfast",
+		"-crf", "23",
+		"-pix_fmt", "yuv420p",
+		"-c:a", "aac",
+		"-b:a", "128k",
+		"-movflags", "+faststart",
+	)
+	if threads > 0 {
+		args = append(args, "-threads", fmt.Sprint(threads))
+	}
+	return append(args, "-y", output)
+}
+
+func downloadKey(lessonId uuid.UUID) string {
+	return fmt.Sprintf("lessons/%s/downloads/%s", lessonId, downloadFile)
+}
+
+func NewDownloadService(repo repository.DownloadRepository, store objectstore.Store, cfg *config.Config) DownloadService {
+	return &downloadService{
+		repo:  repo,
+		store: store,
+		cfg:   cfg,
+	}
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path/filepath"
+	"worker-transcode/config"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// downmixFile is the remixed audio the downmix stage writes next to the
+	// job's input, FLAC so nothing is lost before the encode.
+	downmixFile = "downmix.flac"
+
+	// downmixCeiling is the peak, in dB, a downmix brought up to the
+	// source's loudness is limited to.
+	downmixCeiling = -1.0
+)
+
+// needsDownmix reports whether audio plays differently from player to
+// player: it has more than two channels, or one or two in a layout other
+// than mono or stereo, such as a dual mono or 1+LFE camera track.
+func needsDownmix(audio *ProbeStream) bool {
+	switch {
+	case audio == nil:
+		return false
+	case audio.Channels > 2:
+		return true
+	case audio.Channels == 1:
+		return audio.ChannelLayout != "" && audio.ChannelLayout != "mono"
+	default:
+		return audio.ChannelLayout != "stereo"
+	}
+}
+
+// remixFilter mixes audio down to stereo, or a single channel to mono, with
+// the center and surround channels at the levels cfg sets. Channels of an
+// unknown layout are laid out as ffmpeg guesses from their count.
+func remixFilter(audio *ProbeStream, cfg config.Downmix) string {
+	layout := "stereo"
+	if audio.Channels == 1 {
+		layout = "mono"
+	}
+	return fmt.Sprintf("aresample=clev=%.4f:slev=%.4f:lfe_mix_level=0,aformat=channel_layouts=%s",
+		dbGain(cfg.CenterLevel), dbGain(cfg.SurroundLevel), layout)
+}
+
+func dbGain(db float64) float64 {
+	return math.Pow(10, db/20)
+}
+
+// downmixAudio remixes the first audio stream of inputFilepath into dir. Mixing
+// channels down changes how loud the audio is, so the mix is measured and
+// brought back to the source's integrated loudness, its peaks limited to
+// downmixCeiling.
+func downmixAudio(ctx context.Context, inputFilepath string, audio *ProbeStream, dir string, cfg config.Downmix) (string, error) {
+	filter := remixFilter(audio, cfg)
+	source, err := measureLoudness(ctx, inputFilepath, "")
+	if err != nil {
+		return "", fmt.Errorf("measure source loudness: %w", err)
+	}
+	mixed, err := measureLoudness(ctx, inputFilepath, filter)
+	if err != nil {
+		return "", fmt.Errorf("measure downmix loudness: %w", err)
+	}
+	// Silence measures as -inf and is left as it is.
+	var gain float64
+	if sourceLUFS, mixedLUFS := parseLoudness(source.Integrated), parseLoudness(mixed.Integrated); sourceLUFS != nil && mixedLUFS != nil {
+		gain = *sourceLUFS - *mixedLUFS
+	}
+	if gain != 0 {
+		filter += fmt.Sprintf(",volume=%.2fdB", gain)
+	}
+	if gain > 0 {
+		filter += fmt.Sprintf(",alimiter=limit=%.4f:level=false", dbGain(downmixCeiling))
+	}
+
+	output := filepath.Join(dir, downmixFile)
+	// The same source always makes the same file, so dedup still matches
+	// jobs of identical uploads.
+	args := []string{
+		"-i", inputFilepath,
+		"-map", "0:a:0",
+		"-af", filter,
+		"-c:a", "flac",
+		"-fflags", "+bitexact",
+		"-flags:a", "+bitexact",
+		"-y", output,
+	}
+	if err := runFFmpeg(ctx, args, nil); err != nil {
+		return "", err
+	}
+	zerolog.Ctx(ctx).Info().
+		Int("channels", audio.Channels).
+		Str("layout", audio.ChannelLayout).
+		Float64("gain_db", gain).
+		Msg("audio downmixed")
+	return output, nil
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"worker-transcode/config"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/drive"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// driveProviders are the cloud drives a job's source can be in.
+var driveProviders = []string{"gdrive", "onedrive"}
+
+// DriveService copies jobs' sources from their tenants' cloud drives into
+// the bucket, where the rest of the job reads them like an upload.
+type DriveService interface {
+	// Fetch copies the drive file to objectPath, unless an earlier attempt
+	// at the job already did.
+	Fetch(ctx context.Context, job *entities.Job, source *dto.DriveSource, objectPath string) error
+	Connect(ctx context.Context, tenantId uuid.UUID, provider string, request dto.DriveConnectionRequest) (*entities.DriveConnection, error)
+}
+
+type driveService struct {
+	repo  repository.DriveRepository
+	store objectstore.Store
+	cfg   *config.Config
+}
+
+func (s *driveService) Fetch(ctx context.Context, job *entities.Job, source *dto.DriveSource, objectPath string) error {
+	if _, err := s.store.StatObject(ctx, s.cfg.MinIOBucket, objectPath, minio.StatObjectOptions{}); err == nil {
+		zerolog.Ctx(ctx).Info().Str("object_path", objectPath).Msg("drive source already copied")
+		return nil
+	} else if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		return err
+	}
+	if job.TenantId == nil {
+		return errors.Join(ErrNonRetryable, ErrInvalidArgument, errors.New("a drive source needs a tenant job"))
+	}
+	connection, err := s.repo.FindDriveConnection(ctx, *job.TenantId, source.Provider)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errors.Join(ErrNonRetryable, ErrInvalidArgument, fmt.Errorf("tenant has no %s connection", source.Provider))
+	}
+	if err != nil {
+		return err
+	}
+
+	credentials := drive.Credentials{
+		Provider:     connection.Provider,
+		ClientId:     connection.ClientId,
+		ClientSecret: connection.ClientSecret,
+		RefreshToken: connection.RefreshToken,
+	}
+	if connection.Directory != nil {
+		credentials.Directory = *connection.Directory
+	}
+	client, err := drive.New(credentials)
+	if err != nil {
+		return errors.Join(ErrNonRetryable, err)
+	}
+	download, err := client.Open(ctx, source.FileId)
+	if errors.Is(err, drive.ErrNotFound) {
+		return errors.Join(ErrNonRetryable, fmt.Errorf("%s file %s: %w", source.Provider, source.FileId, err))
+	}
+	if err != nil {
+		return err
+	}
+	defer download.Body.Close()
+	// The old token stops working once rotated, so the new one is kept
+	// before anything else can fail.
+	if download.RefreshToken != "" {
+		if err := s.repo.UpdateDriveRefreshToken(ctx, *job.TenantId, source.Provider, download.RefreshToken); err != nil {
+			return err
+		}
+	}
+
+	size := download.Size
+	if size <= 0 {
+		size = -1
+	}
+	zerolog.Ctx(ctx).Info().
+		Str("provider", source.Provider).
+		Str("file_name", download.Name).
+		Int64("bytes", download.Size).
+		Msg("copying drive source")
+	_, err = s.store.PutObject(ctx, s.cfg.MinIOBucket, objectPath, download.Body, size, minio.PutObjectOptions{})
+	return err
+}
+
+func (s *driveService) Connect(ctx context.Context, tenantId uuid.UUID, provider string, request dto.DriveConnectionRequest) (*entities.DriveConnection, error) {
+	connection := &entities.DriveConnection{
+		TenantId:     tenantId,
+		Provider:     provider,
+		ClientId:     strings.TrimSpace(request.ClientId),
+		ClientSecret: strings.TrimSpace(request.ClientSecret),
+		RefreshToken: strings.TrimSpace(request.RefreshToken),
+		Directory:    request.Directory,
+	}
+	if err := validateDriveConnection(connection); err != nil {
+		return nil, errors.Join(ErrInvalidArgument, err)
+	}
+	if err := s.repo.SaveDriveConnection(ctx, connection); err != nil {
+		return nil, err
+	}
+	return s.repo.FindDriveConnection(ctx, tenantId, provider)
+}
+
+func validateDriveConnection(connection *entities.DriveConnection) error {
+	if err := validateDriveProvider(connection.Provider); err != nil {
+		return err
+	}
+	for name, value := range map[string]string{
+		"client_id":     connection.ClientId,
+		"client_secret": connection.ClientSecret,
+		"refresh_token": connection.RefreshToken,
+	} {
+		if value == "" {
+			return fmt.Errorf("%s must not be empty", name)
+		}
+	}
+	if connection.Directory != nil && connection.Provider != "onedrive" {
+		return errors.New("directory is only for onedrive")
+	}
+	return nil
+}
+
+func validateDriveSource(source *dto.DriveSource) error {
+	if source == nil {
+		return nil
+	}
+	if err := validateDriveProvider(source.Provider); err != nil {
+		return fmt.Errorf("source: %w", err)
+	}
+	if strings.TrimSpace(source.FileId) == "" {
+		return errors.New("source: file id is required")
+	}
+	return nil
+}
+
+func validateDriveProvider(provider string) error {
+	if slices.Contains(driveProviders, provider) {
+		return nil
+	}
+	return fmt.Errorf("provider %q is neither %s", provider, strings.Join(driveProviders, " nor "))
+}
+
+func NewDriveService(repo repository.DriveRepository, store objectstore.Store, cfg *config.Config) DriveService {
+	return &driveService{
+		repo:  repo,
+		store: store,
+		cfg:   cfg,
+	}
+}
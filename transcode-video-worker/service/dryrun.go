@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"worker-transcode/dto"
+	"worker-transcode/pkg/ffmpeg"
+
+	"github.com/rs/zerolog"
+)
+
+type dryRunKey struct{}
+
+// WithDryRun marks ctx so Process plans the job instead of running it.
+func WithDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+func IsDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunKey{}).(bool)
+	return dryRun
+}
+
+// DryRunPlan is what a job would do: the checked source, the ffmpeg command
+// and the object keys it would write.
+type DryRunPlan struct {
+	JobId         string       `json:"job_id"`
+	ObjectPath    string       `json:"object_path"`
+	Preset        string       `json:"preset"`
+	PresetVersion int          `json:"preset_version"`
+	Source        *SourceCheck `json:"source,omitempty"`
+	Command       string       `json:"command,omitempty"`
+	// Keys are the objects that would be uploaded. Segment keys keep ffmpeg's
+	// %03d pattern; Segments estimates how many each playlist gets.
+	Keys     []string `json:"keys,omitempty"`
+	Segments int      `json:"segments,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// dryRun downloads and probes the source and logs the plan for the job. The
+// job row, the source object and the bucket are left untouched, and failures
+// are reported in the plan rather than returned so the message is not retried.
+func (s service) dryRun(ctx context.Context, message dto.JobMessage) error {
+	plan, err := s.plan(ctx, message)
+	if err != nil {
+		plan.Error = err.Error()
+	}
+	zerolog.Ctx(ctx).Info().Interface("plan", plan).Msg("dry run")
+	return nil
+}
+
+func (s service) plan(ctx context.Context, message dto.JobMessage) (*DryRunPlan, error) {
+	plan := &DryRunPlan{JobId: message.JobId.String(), ObjectPath: message.ObjectPath}
+
+	preset, err := s.presets.Pick(ctx, message.Preset, message.JobId)
+	if err != nil {
+		return plan, fmt.Errorf("resolve preset: %w", err)
+	}
+	plan.Preset, plan.PresetVersion = preset.Name, preset.Version
+
+	tempDir := filepath.Join("temp", "dry-run", message.JobId.String())
+	defer os.RemoveAll(tempDir)
+	if err := os.MkdirAll(tempDir, os.ModePerm); err != nil {
+		return plan, err
+	}
+
+	inputFilepath, audioFilepath, err := s.downloadSource(ctx, message.ObjectPath, tempDir)
+	if err != nil {
+		return plan, fmt.Errorf("download source: %w", err)
+	}
+
+	if err := validateCuePoints(message.CuePoints); err != nil {
+		return plan, err
+	}
+	if err := validateAudioTracks(message.AudioTracks); err != nil {
+		return plan, err
+	}
+	if err := validateEdit(message.Edit); err != nil {
+		return plan, err
+	}
+	dubs, err := s.downloadAudioTracks(ctx, message.AudioTracks, tempDir)
+	if err != nil {
+		return plan, err
+	}
+
+	source, err := CheckSource(ctx, inputFilepath)
+	if err != nil {
+		return plan, fmt.Errorf("inspect source: %w", err)
+	}
+	source.Path = message.ObjectPath
+	plan.Source = source
+	if err := source.Err(); err != nil {
+		return plan, err
+	}
+
+	outputDir := filepath.Join(tempDir, "output")
+	args := append(append([]string{ffmpeg.Path()}, ffmpeg.GlobalArgs()...), hlsArgs(preset, inputFilepath, audioFilepath, dubs, outputDir, s.cfg.Server.FFmpegThreads, 0, 0)...)
+	plan.Command = strings.Join(args, " ")
+
+	// The package's content hash is left out; it's of the inputs as the
+	// job prepares them, which a dry run doesn't.
+	prefix := contentPrefix(message.ObjectPath, message.JobId, packageDir("{content}", preset.Version))
+	plan.Keys = append(plan.Keys, path.Join(prefix, "master.m3u8"))
+	for _, r := range preset.Renditions {
+		plan.Keys = append(plan.Keys,
+			path.Join(prefix, fmt.Sprintf("%dp.m3u8", r.Height)),
+			path.Join(prefix, fmt.Sprintf("%dp_%%03d%s", r.Height, segmentExt(r))))
+		if r.SegmentFormat == segmentFormatFMP4 {
+			plan.Keys = append(plan.Keys, path.Join(prefix, fmt.Sprintf("%dp_init.mp4", r.Height)))
+		}
+		if r.Container != "" {
+			plan.Keys = append(plan.Keys, path.Join(prefix, progressiveName(r)))
+		}
+	}
+	plan.Keys = append(plan.Keys, path.Join(prefix, "audio.m3u8"), path.Join(prefix, "audio_%03d.ts"))
+	for _, dub := range dubs {
+		plan.Keys = append(plan.Keys, path.Join(prefix, dub.playlist()), path.Join(prefix, dub.segments()))
+	}
+	if source.DurationSeconds > 0 {
+		plan.Segments = int(math.Ceil(source.DurationSeconds / float64(preset.SegmentSeconds)))
+	}
+
+	return plan, nil
+}
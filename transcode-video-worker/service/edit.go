@@ -0,0 +1,252 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"worker-transcode/dto"
+)
+
+// minEditedContent is the least an instructor's edit may keep.
+const minEditedContent = 1.0
+
+// parseEdit reads the JSON edit of upload metadata; empty metadata has none.
+func parseEdit(raw string) (*dto.Edit, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var edit dto.Edit
+	if err := json.Unmarshal([]byte(raw), &edit); err != nil {
+		return nil, err
+	}
+	return &edit, validateEdit(&edit)
+}
+
+// validateEdit checks a job's edit: no negative offsets, an out point after
+// the in point, and cuts that are ranges. The source's duration is only
+// known once it is downloaded, which is when editRanges checks against it.
+func validateEdit(edit *dto.Edit) error {
+	if edit == nil {
+		return nil
+	}
+	if edit.In < 0 || edit.Out < 0 {
+		return fmt.Errorf("edit: in and out must not be negative")
+	}
+	if edit.Out > 0 && edit.Out <= edit.In {
+		return fmt.Errorf("edit: out %g must be after in %g", edit.Out, edit.In)
+	}
+	for i, cut := range edit.Cuts {
+		if cut.Start < 0 || cut.End <= cut.Start {
+			return fmt.Errorf("edit: cut %d must end after it starts, got %g to %g", i+1, cut.Start, cut.End)
+		}
+	}
+	return nil
+}
+
+// editRanges are the parts of a recording duration seconds long that edit
+// keeps, in order. Cuts may overlap one another or the in and out points.
+func editRanges(edit dto.Edit, duration float64) ([]trimWindow, error) {
+	end := duration
+	if edit.Out > 0 {
+		end = min(edit.Out, duration)
+	}
+	if edit.In >= end {
+		return nil, fmt.Errorf("edit: in %g is past the end of the %g second recording", edit.In, duration)
+	}
+
+	cuts := slices.Clone(edit.Cuts)
+	slices.SortFunc(cuts, func(a, b dto.CutRange) int { return cmp.Compare(a.Start, b.Start) })
+	var kept []trimWindow
+	at := edit.In
+	for _, cut := range cuts {
+		if cut.Start > at {
+			kept = append(kept, trimWindow{start: at, end: min(cut.Start, end)})
+		}
+		if at = max(at, cut.End); at >= end {
+			break
+		}
+	}
+	if at < end {
+		kept = append(kept, trimWindow{start: at, end: end})
+	}
+	if keptDuration(kept) < minEditedContent {
+		return nil, fmt.Errorf("edit: keeps less than %g second of the recording", minEditedContent)
+	}
+	return kept, nil
+}
+
+func keptDuration(kept []trimWindow) float64 {
+	var total float64
+	for _, r := range kept {
+		total += r.end - r.start
+	}
+	return total
+}
+
+// editedPosition is where the moment at seconds into the source lands in
+// the edited recording. A moment in a cut lands where the cut was.
+func editedPosition(kept []trimWindow, seconds float64) float64 {
+	var position float64
+	for _, r := range kept {
+		if seconds < r.end {
+			return position + max(seconds-r.start, 0)
+		}
+		position += r.end - r.start
+	}
+	return position
+}
+
+// editMarkers moves instructor chapter markers onto the edited timeline.
+// Markers landing on the same moment collapse, the last of them winning.
+func editMarkers(markers []dto.ChapterMarker, kept []trimWindow) []dto.ChapterMarker {
+	edited := make([]dto.ChapterMarker, 0, len(markers))
+	for _, marker := range markers {
+		marker.Start = editedPosition(kept, marker.Start)
+		if len(edited) > 0 && edited[len(edited)-1].Start == marker.Start {
+			edited = edited[:len(edited)-1]
+		}
+		edited = append(edited, marker)
+	}
+	return edited
+}
+
+// editCuePoints moves cue points onto the edited timeline.
+func editCuePoints(cues []dto.CuePoint, kept []trimWindow) []dto.CuePoint {
+	edited := make([]dto.CuePoint, 0, len(cues))
+	for _, cue := range cues {
+		cue.At = editedPosition(kept, cue.At)
+		edited = append(edited, cue)
+	}
+	return edited
+}
+
+// editFile writes the kept ranges of a file into dir. When every range
+// starts on a keyframe, as it always does in a file without video, they are
+// copied and joined without re-encoding; otherwise the file is encoded once,
+// at a quality the ladder's encode can't tell from the source, into an MP4.
+// It reports whether the file was re-encoded.
+func editFile(ctx context.Context, inputFilepath, dir string, kept []trimWindow, threads int) (string, bool, error) {
+	name := filepath.Base(inputFilepath)
+	media, err := ProbeMedia(ctx, inputFilepath)
+	if err != nil {
+		return "", false, err
+	}
+	lossless := media.VideoStream() == nil
+	if !lossless {
+		if lossless, err = startsOnKeyframes(ctx, inputFilepath, kept); err != nil {
+			return "", false, err
+		}
+	}
+	if lossless {
+		output := filepath.Join(dir, "edited_"+name)
+		return output, false, copyRanges(ctx, inputFilepath, dir, output, kept)
+	}
+	output := filepath.Join(dir, "edited_"+strings.TrimSuffix(name, filepath.Ext(name))+".mp4")
+	hasAudio := slices.ContainsFunc(media.Streams, func(stream ProbeStream) bool { return stream.CodecType == "audio" })
+	return output, true, runFFmpeg(ctx, encodeRangesArgs(inputFilepath, output, kept, hasAudio, threads), nil)
+}
+
+// startsOnKeyframes reports whether each kept range starts on a keyframe of
+// the file's video, or at its start.
+func startsOnKeyframes(ctx context.Context, inputFilepath string, kept []trimWindow) (bool, error) {
+	keyframes, err := keyframeTimes(ctx, inputFilepath)
+	if err != nil {
+		return false, err
+	}
+	for _, r := range kept {
+		if r.start <= keyframeTolerance {
+			continue
+		}
+		if !slices.ContainsFunc(keyframes, func(at float64) bool { return math.Abs(at-r.start) <= keyframeTolerance }) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// copyRanges copies each kept range out of the file without re-encoding and
+// joins them into output.
+func copyRanges(ctx context.Context, inputFilepath, dir, output string, kept []trimWindow) error {
+	var pieces []string
+	defer func() {
+		for _, piece := range pieces {
+			os.Remove(piece)
+		}
+	}()
+	for i, r := range kept {
+		piece := filepath.Join(dir, fmt.Sprintf("edit_%d_%s", i, filepath.Base(inputFilepath)))
+		if len(kept) == 1 {
+			piece = output
+		}
+		args := []string{
+			"-ss", strconv.FormatFloat(r.start, 'f', 3, 64),
+			"-i", inputFilepath,
+			"-t", strconv.FormatFloat(r.end-r.start, 'f', 3, 64),
+			"-map", "0",
+			"-c", "copy",
+			"-avoid_negative_ts", "make_zero",
+			"-y", piece,
+		}
+		if err := runFFmpeg(ctx, args, nil); err != nil {
+			return err
+		}
+		if len(kept) == 1 {
+			return nil
+		}
+		pieces = append(pieces, piece)
+	}
+
+	listPath := filepath.Join(dir, "edit_"+filepath.Base(inputFilepath)+".txt")
+	if err := writeConcatList(listPath, pieces); err != nil {
+		return err
+	}
+	pieces = append(pieces, listPath)
+	args := []string{
+		"-f", "concat",
+		"-safe", "0",
+		"-i", listPath,
+		"-map", "0",
+		"-c", "copy",
+		"-y", output,
+	}
+	return runFFmpeg(ctx, args, nil)
+}
+
+// encodeRangesArgs encode the kept ranges of a file into output in one pass,
+// selecting their frames and samples and closing the gaps between them.
+func encodeRangesArgs(inputFilepath, output string, kept []trimWindow, hasAudio bool, threads int) []string {
+	between := make([]string, 0, len(kept))
+	for _, r := range kept {
+		between = append(between, fmt.Sprintf("between(t,%.3f,%.3f)", r.start, r.end))
+	}
+	selected := strings.Join(between, "+")
+
+	args := []string{
+		"-i", inputFilepath,
+		"-map", "0:v:0",
+		"-vf", fmt.Sprintf("select='%s',setpts=N/FRAME_RATE/TB", selected),
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-crf", "18",
+		"-pix_fmt", "yuv420p",
+	}
+	if hasAudio {
+		args = append(args,
+			"-map", "0:a:0",
+			"-af", fmt.Sprintf("aselect='%s',asetpts=N/SR/TB", selected),
+			"-c:a", "aac",
+			"-b:a", "192k",
+		)
+	}
+	if threads > 0 {
+		args = append(args, "-threads", strconv.Itoa(threads))
+	}
+	return append(args, "-movflags", "+faststart", "-y", output)
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"worker-transcode/entities"
+)
+
+// MediaEngine encodes a preset's renditions of a source and packages them
+// into HLS. Engines lay the package out alike, with the playlists and
+// segments createMasterPlaylist and the uploaders expect, so the rest of a
+// job doesn't know which one ran.
+type MediaEngine interface {
+	Name() string
+	// Capabilities says what the engine can do on this worker.
+	Capabilities(ctx context.Context) (EngineCapabilities, error)
+	Encode(ctx context.Context, request EncodeRequest) error
+	// Package writes the master playlist over the encoded renditions.
+	Package(ctx context.Context, preset *entities.Preset, outputDir string, dubs []dubbedAudio) error
+}
+
+// EngineCapabilities is what an engine offers a job: the encoders a preset
+// can name, and whether it can remux a conforming rung, read a separate
+// audio input, report progress and package for low latency.
+type EngineCapabilities struct {
+	VideoCodecs   []string
+	AudioCodecs   []string
+	StreamCopy    bool
+	SeparateAudio bool
+	Progress      bool
+	LowLatency    bool
+}
+
+// supports reports whether the engine can encode preset as the request needs.
+func (c EngineCapabilities) supports(preset *entities.Preset, request EncodeRequest) bool {
+	switch {
+	case !slices.Contains(c.VideoCodecs, preset.VideoCodec), !slices.Contains(c.AudioCodecs, preset.AudioCodec):
+		return false
+	case (request.AudioFilepath != "" || len(request.Dubs) > 0) && !c.SeparateAudio:
+		return false
+	case request.PartSeconds > 0 && !c.LowLatency:
+		return false
+	}
+	return true
+}
+
+// EncodeRequest is one encode of a job. CopyHeight is the rung to remux
+// rather than encode, 0 for none; engines without StreamCopy are only given
+// 0. A positive PartSeconds packages LL-HLS with partial segments that long.
+type EncodeRequest struct {
+	Preset        *entities.Preset
+	InputFilepath string
+	AudioFilepath string
+	Dubs          []dubbedAudio
+	OutputDir     string
+	Threads       int
+	CopyHeight    int
+	PartSeconds   float64
+	OnProgress    func(FFmpegProgress)
+}
+
+// mediaEngines maps the engine names config can list to their engines.
+var mediaEngines = map[string]MediaEngine{
+	"ffmpeg": ffmpegEngine{},
+}
+
+// negotiateEngine returns the first of the named engines able to encode the
+// request, and false with the first engine when none is, leaving it to fail
+// the encode, or the job to go to the external transcoder.
+func negotiateEngine(ctx context.Context, names []string, request EncodeRequest) (MediaEngine, bool, error) {
+	var first MediaEngine
+	for _, name := range names {
+		engine, ok := mediaEngines[name]
+		if !ok {
+			return nil, false, fmt.Errorf("unknown media engine %q", name)
+		}
+		if first == nil {
+			first = engine
+		}
+		capabilities, err := engine.Capabilities(ctx)
+		if err != nil {
+			continue
+		}
+		if capabilities.supports(request.Preset, request) {
+			return engine, true, nil
+		}
+	}
+	if first == nil {
+		return nil, false, fmt.Errorf("no media engine configured")
+	}
+	return first, false, nil
+}
+
+// ffmpegEngine runs one ffmpeg per encode, every rung from a single decode.
+type ffmpegEngine struct{}
+
+func (ffmpegEngine) Name() string {
+	return "ffmpeg"
+}
+
+func (ffmpegEngine) Capabilities(ctx context.Context) (EngineCapabilities, error) {
+	encoders, err := ffmpegEncoders(ctx)
+	if err != nil {
+		return EngineCapabilities{}, err
+	}
+	// ffmpeg lists video and audio encoders together; a preset naming one
+	// of the wrong kind fails its own validation first.
+	return EngineCapabilities{
+		VideoCodecs:   encoders,
+		AudioCodecs:   encoders,
+		StreamCopy:    true,
+		SeparateAudio: true,
+		Progress:      true,
+		LowLatency:    true,
+	}, nil
+}
+
+func (ffmpegEngine) Encode(ctx context.Context, request EncodeRequest) error {
+	if request.PartSeconds > 0 {
+		return transcodeLowLatency(ctx, request)
+	}
+	return transcodeToHLS(ctx, request.Preset, request.InputFilepath, request.AudioFilepath, request.Dubs, request.OutputDir, request.Threads, request.CopyHeight, 0, request.OnProgress)
+}
+
+func (ffmpegEngine) Package(ctx context.Context, preset *entities.Preset, outputDir string, dubs []dubbedAudio) error {
+	return createMasterPlaylist(ctx, preset, outputDir, dubs)
+}
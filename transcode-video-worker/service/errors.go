@@ -0,0 +1,106 @@
+package service
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"worker-transcode/constant"
+
+	"github.com/lib/pq"
+	"github.com/minio/minio-go/v7"
+)
+
+// PipelineError is a job failure classified by kind. The kind is logged,
+// stored on the failed job and counted, and decides whether the job is tried
+// again: errors.Is(err, ErrNonRetryable) holds for a kind that isn't
+// retryable, as it does for any error joined with ErrNonRetryable.
+type PipelineError struct {
+	Kind constant.ErrorKind
+	Err  error
+}
+
+func (e *PipelineError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PipelineError) Unwrap() error {
+	return e.Err
+}
+
+func (e *PipelineError) Is(target error) bool {
+	return target == ErrNonRetryable && !e.Kind.Retryable()
+}
+
+// classified marks err as a failure of kind.
+func classified(kind constant.ErrorKind, err error) error {
+	return &PipelineError{Kind: kind, Err: err}
+}
+
+// classify wraps err in a PipelineError of its kind, if it isn't one already,
+// so the kind decides how the job's pipeline settles it.
+func classify(err error) error {
+	var pipelineErr *PipelineError
+	if err == nil || errors.As(err, &pipelineErr) {
+		return err
+	}
+	return classified(errorKind(err), err)
+}
+
+// errorKind is the kind err was classified with, or else the one its
+// sentinel or type implies.
+func errorKind(err error) constant.ErrorKind {
+	var (
+		pipelineErr *PipelineError
+		ffmpegErr   *FFmpegError
+		storageErr  minio.ErrorResponse
+		pqErr       *pq.Error
+		netErr      net.Error
+	)
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &pipelineErr):
+		return pipelineErr.Kind
+	case errors.Is(err, ErrJobTimeout):
+		return constant.ErrorKindTimeout
+	case errors.Is(err, ErrQuotaExceeded):
+		return constant.ErrorKindQuotaExceeded
+	case errors.Is(err, ErrInfected):
+		return constant.ErrorKindInfected
+	case errors.Is(err, ErrQualityFailed):
+		return constant.ErrorKindQualityFailed
+	case errors.Is(err, ErrInvalidArgument):
+		return constant.ErrorKindInvalidInput
+	case errors.Is(err, ErrIncompletePackage):
+		return constant.ErrorKindStorageUnavailable
+	case errors.As(err, &ffmpegErr) && ffmpegErr.Crash != nil && ffmpegErr.Crash.OOMKilled:
+		return constant.ErrorKindEncoderOOM
+	case errors.As(err, &ffmpegErr):
+		// ffmpeg only runs on sources the probe accepted, so a failed run
+		// is the encoder's, not the source's.
+		return constant.ErrorKindEncoderCrash
+	case errors.As(err, &storageErr) && storageErr.StatusCode >= 500:
+		return constant.ErrorKindStorageUnavailable
+	case errors.As(err, &pqErr) && unavailableClass(pqErr.Code.Class()):
+		return constant.ErrorKindDatabaseUnavailable
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone):
+		return constant.ErrorKindDatabaseUnavailable
+	case errors.As(err, &netErr):
+		// Besides the database, whose driver errors are matched above, the
+		// pipeline's network calls go to object storage.
+		return constant.ErrorKindStorageUnavailable
+	}
+	return constant.ErrorKindUnknown
+}
+
+// unavailableClass reports whether a Postgres error class means the server
+// couldn't take the statement rather than rejected it: connection exceptions,
+// insufficient resources and operator intervention such as a shutdown.
+func unavailableClass(class pq.ErrorClass) bool {
+	switch class {
+	case "08", "53", "57":
+		return true
+	}
+	return false
+}
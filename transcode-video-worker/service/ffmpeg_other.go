@@ -0,0 +1,21 @@
+//go:build !unix
+
+package service
+
+import (
+	"os"
+	"os/exec"
+)
+
+// killProcessGroup leaves exec's default of killing only ffmpeg itself.
+func killProcessGroup(cmd *exec.Cmd) {}
+
+// peakMemory isn't reported on this platform.
+func peakMemory(state *os.ProcessState) int64 {
+	return 0
+}
+
+// exitSignal isn't reported on this platform.
+func exitSignal(state *os.ProcessState) (signal string, killed bool) {
+	return "", false
+}
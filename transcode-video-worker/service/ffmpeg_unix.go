@@ -0,0 +1,43 @@
+//go:build unix
+
+package service
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// killProcessGroup runs cmd in its own process group and makes cancelling its
+// context kill the whole group, so helpers ffmpeg spawns go too.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// peakMemory is the largest resident set, in bytes, the exited process had.
+func peakMemory(state *os.ProcessState) int64 {
+	usage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0
+	}
+	// Linux reports kilobytes, macOS bytes.
+	if runtime.GOOS == "darwin" {
+		return int64(usage.Maxrss)
+	}
+	return int64(usage.Maxrss) * 1024
+}
+
+// exitSignal names the signal that killed the exited process, empty when it
+// exited on its own. killed is whether it was SIGKILL, the OOM killer's.
+func exitSignal(state *os.ProcessState) (signal string, killed bool) {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return "", false
+	}
+	return fmt.Sprintf("%d (%s)", int(status.Signal()), status.Signal()), status.Signal() == syscall.SIGKILL
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"worker-transcode/constant"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/metrics"
+	"worker-transcode/pkg/objectstore"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+)
+
+// ErrIncompletePackage is a package missing objects, or holding ones of
+// another size, of what was uploaded of it. The job is retried, which
+// uploads it again.
+var ErrIncompletePackage = errors.New("uploaded package is incomplete")
+
+// jobCleanup is what a job gives back when it ends, whatever its outcome:
+// its heartbeat, its lesson's lock, its scratch workspace and reservation,
+// and, when it failed for good, what it uploaded of its package. Steps run
+// in the reverse of the order they were added, each whether or not those
+// before it failed, before the outcome is recorded and so before the
+// message is acked.
+type jobCleanup struct {
+	steps []cleanupStep
+}
+
+type cleanupStep struct {
+	name string
+	// failed steps only run for a job that failed for good.
+	failed bool
+	run    func(ctx context.Context) error
+}
+
+// add adds a step run however the job ends.
+func (c *jobCleanup) add(name string, run func(ctx context.Context) error) {
+	c.steps = append(c.steps, cleanupStep{name: name, run: run})
+}
+
+// onFailure adds a step run only when the job fails for good.
+func (c *jobCleanup) onFailure(name string, run func(ctx context.Context) error) {
+	c.steps = append(c.steps, cleanupStep{name: name, failed: true, run: run})
+}
+
+// run runs the steps once. A failed step is logged, counted and recorded
+// on the job's timeline; it doesn't change the job's outcome.
+func (c *jobCleanup) run(ctx context.Context, failed bool) {
+	ctx = context.WithoutCancel(ctx)
+	steps := c.steps
+	c.steps = nil
+	for i := len(steps) - 1; i >= 0; i-- {
+		step := steps[i]
+		if step.failed && !failed {
+			continue
+		}
+		if err := step.run(ctx); err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Str("step", step.name).Msg("job cleanup failed")
+			metrics.CleanupFailures.WithLabelValues(step.name).Inc()
+			recordEvent(ctx, constant.JobEventCleanup, step.name, entities.EventData{"message": err.Error()})
+		}
+	}
+}
+
+// failedForGood reports whether err ends the job without it being retried,
+// handed off or left for ops to override its quality gate.
+func failedForGood(ctx context.Context, err error) bool {
+	switch {
+	case err == nil, errors.Is(err, ErrInsufficientResources), errors.Is(err, ErrQualityFailed):
+		return false
+	case timedOut(ctx):
+		return true
+	}
+	return !shuttingDown(ctx, err) && errors.Is(classify(err), ErrNonRetryable)
+}
+
+// verifyPackage checks every object of manifest, keyed by its path under
+// prefix, is in the bucket with the size it was uploaded with.
+func verifyPackage(ctx context.Context, store objectstore.Store, bucket, prefix string, manifest map[string]int64) error {
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+	found := map[string]int64{}
+	for object := range store.ListObjects(ctx, bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return object.Err
+		}
+		found[strings.TrimPrefix(object.Key, prefix)] = object.Size
+	}
+
+	var missing, mismatched []string
+	for name, size := range manifest {
+		stored, ok := found[name]
+		switch {
+		case !ok:
+			missing = append(missing, name)
+		case stored != size:
+			mismatched = append(mismatched, fmt.Sprintf("%s (%d bytes, uploaded %d)", name, stored, size))
+		}
+	}
+	if len(missing) == 0 && len(mismatched) == 0 {
+		zerolog.Ctx(ctx).Info().Int("objects", len(manifest)).Msg("package verified against its manifest")
+		return nil
+	}
+	sort.Strings(missing)
+	sort.Strings(mismatched)
+	recordEvent(ctx, constant.JobEventError, string(constant.ErrorClassVerify), entities.EventData{
+		"missing":    missing,
+		"mismatched": mismatched,
+	})
+	return fmt.Errorf("%w: %d of %d objects missing, %d of another size, under %s",
+		ErrIncompletePackage, len(missing), len(manifest), len(mismatched), prefix)
+}
+
+// removePackage removes the objects of manifest under prefix.
+func removePackage(ctx context.Context, store objectstore.Store, bucket, prefix string, manifest map[string]int64) error {
+	var errs []error
+	for name := range manifest {
+		if err := store.RemoveObject(ctx, bucket, path.Join(prefix, name), minio.RemoveObjectOptions{}); err != nil {
+			errs = append(errs, fmt.Errorf("remove %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// manifestBytes is the size of the package of manifest.
+func manifestBytes(manifest map[string]int64) int64 {
+	var total int64
+	for _, size := range manifest {
+		total += size
+	}
+	return total
+}
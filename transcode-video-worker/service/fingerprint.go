@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// duplicateCandidates bounds the lessons an upload's fingerprint is
+	// compared with.
+	duplicateCandidates = 20
+	// minFingerprintFrames is the fewest distinct frames a video needs for
+	// its frames to be compared; a static slide shares them with too much.
+	minFingerprintFrames = 10
+	// minFingerprintAudio is the least audio two videos need in common to be
+	// compared, about 30 seconds of Chromaprint values.
+	minFingerprintAudio = 240
+	// maxAudioRepeats skips the audio values, such as silence's, that repeat
+	// too often to say where two videos line up.
+	maxAudioRepeats = 64
+)
+
+// frameFingerprint hashes a frame of the video every interval seconds, as
+// frameHash does a slide, and returns the distinct hashes. Frames too plain
+// to tell apart, such as black ones, are left out.
+func frameFingerprint(ctx context.Context, inputFilepath, dir string, interval int) ([]int64, error) {
+	const cols, rows = 9, 8
+	output := filepath.Join(dir, "frames.gray")
+	_, err := ffmpegStderr(ctx, []string{"-hide_banner", "-nostats", "-y",
+		"-i", inputFilepath,
+		"-map", "0:v:0", "-an",
+		"-vf", fmt.Sprintf("fps=1/%d,scale=%d:%d:flags=area,format=gray", max(interval, 1), cols, rows),
+		"-f", "rawvideo",
+		output,
+	})
+	if err != nil {
+		return nil, err
+	}
+	raw, err := os.ReadFile(output)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[uint64]bool{}
+	var hashes []int64
+	for frame := raw; len(frame) >= cols*rows; frame = frame[cols*rows:] {
+		var hash uint64
+		for y := 0; y < rows; y++ {
+			for x := 0; x < cols-1; x++ {
+				hash <<= 1
+				if frame[y*cols+x] > frame[y*cols+x+1] {
+					hash |= 1
+				}
+			}
+		}
+		if ones := bits.OnesCount64(hash); ones < 4 || ones > 60 || seen[hash] {
+			continue
+		}
+		seen[hash] = true
+		hashes = append(hashes, int64(hash))
+	}
+	return hashes, nil
+}
+
+// audioFingerprint returns the raw Chromaprint fingerprint of the source's
+// first audio track, each value the signed form of a 32-bit subfingerprint.
+func audioFingerprint(ctx context.Context, source, dir string) ([]int64, error) {
+	output := filepath.Join(dir, "audio.raw")
+	_, err := ffmpegStderr(ctx, []string{"-hide_banner", "-nostats", "-y",
+		"-i", source,
+		"-map", "0:a:0", "-vn",
+		"-ac", "1",
+		"-f", "chromaprint", "-fp_format", "raw",
+		output,
+	})
+	if err != nil {
+		return nil, err
+	}
+	raw, err := os.ReadFile(output)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]int64, 0, len(raw)/4)
+	for ; len(raw) >= 4; raw = raw[4:] {
+		values = append(values, int64(int32(binary.LittleEndian.Uint32(raw))))
+	}
+	return values, nil
+}
+
+// frameSimilarity is the share of the shorter video's frames that have a
+// frame within distance bits in the other.
+func frameSimilarity(a, b []int64, distance int) float64 {
+	if len(a) < minFingerprintFrames || len(b) < minFingerprintFrames {
+		return 0
+	}
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	found := 0
+	for _, x := range a {
+		for _, y := range b {
+			if bits.OnesCount64(uint64(x^y)) <= distance {
+				found++
+				break
+			}
+		}
+	}
+	return float64(found) / float64(len(a))
+}
+
+// audioSimilarity lines the two fingerprints up where most of their values
+// agree and scores how alike the audio is there, scaled by how much of the
+// shorter one the overlap covers. Unrelated audio differs in about half its
+// bits and scores 0, the same audio re-encoded close to 1.
+func audioSimilarity(a, b []int64) float64 {
+	positions := map[int64][]int{}
+	for j, v := range b {
+		positions[v] = append(positions[v], j)
+	}
+	votes := map[int]int{}
+	for i, v := range a {
+		if len(positions[v]) > maxAudioRepeats {
+			continue
+		}
+		for _, j := range positions[v] {
+			votes[j-i]++
+		}
+	}
+	offset, best := 0, 0
+	for candidate, count := range votes {
+		if count > best {
+			offset, best = candidate, count
+		}
+	}
+	if best == 0 {
+		return 0
+	}
+
+	start, end := max(0, -offset), min(len(a), len(b)-offset)
+	overlap := end - start
+	if overlap < minFingerprintAudio {
+		return 0
+	}
+	differing := 0
+	for i := start; i < end; i++ {
+		differing += bits.OnesCount32(uint32(a[i]) ^ uint32(b[i+offset]))
+	}
+	errorRate := float64(differing) / float64(32*overlap)
+	return max(0, 1-2*errorRate) * float64(overlap) / float64(min(len(a), len(b)))
+}
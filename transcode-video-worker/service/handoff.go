@@ -0,0 +1,35 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"worker-transcode/constant"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+// ErrHandedOff is returned for a job interrupted by the worker shutting down.
+// The job is back to pending and the consumer requeues its message, so
+// another worker starts it over.
+var ErrHandedOff = errors.New("job handed off at shutdown")
+
+// shuttingDown reports whether err is ctx being cancelled under the job,
+// rather than a failure of the job itself. A panic is the job's own failure
+// even when it happens during shutdown.
+func shuttingDown(ctx context.Context, err error) bool {
+	return err != nil && !errors.Is(err, ErrPanic) && errors.Is(ctx.Err(), context.Canceled)
+}
+
+// handOff releases an interrupted job. ctx is already cancelled, so the
+// writes run without it.
+func handOff(ctx context.Context, repo repository.JobRepository, jobId uuid.UUID, cause error) error {
+	ctx = context.WithoutCancel(ctx)
+	if err := repo.UpdateStatusJob(ctx, constant.JobStatusPending, jobId); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to update job status")
+	}
+	recordStatus(ctx, constant.JobStatusProcessing, constant.JobStatusPending)
+	zerolog.Ctx(ctx).Warn().Err(cause).Str("job_id", jobId.String()).Msg("worker shutting down, job handed off")
+	return ErrHandedOff
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+// jobHeartbeatMisses is how many heartbeats a processing job may miss
+// before it's reported stalled.
+const jobHeartbeatMisses = 3
+
+// stageIdle is the stage of a job between the stages it's traced in.
+const stageIdle = "processing"
+
+type heartbeatKey struct{}
+
+// jobHeartbeat is the stage a job is in and when ffmpeg last reported its
+// progress. It travels in the context, as the timeline does, so traceStage
+// and the progress reporter keep it up to date.
+type jobHeartbeat struct {
+	mu         sync.Mutex
+	stage      string
+	enteredAt  time.Time
+	progressAt time.Time
+}
+
+// withHeartbeat records a heartbeat for the job every JobHeartbeatInterval,
+// with the stage it's in, until the returned stop is called. So the
+// instructor UI can tell a job that's slow from one that's dead, it's
+// recorded whether or not ffmpeg reports progress: during analysis passes,
+// downloads and uploads too. A heartbeat that comes without progress since
+// the last one is recorded on the job's timeline as well.
+func withHeartbeat(ctx context.Context, repo repository.JobRepository, jobId uuid.UUID, cfg *config.Config) (context.Context, func()) {
+	heartbeat := &jobHeartbeat{stage: stageIdle, enteredAt: time.Now()}
+	ctx = context.WithValue(ctx, heartbeatKey{}, heartbeat)
+	interval := time.Duration(max(cfg.Server.JobHeartbeatInterval, 1)) * time.Second
+
+	beatCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		last := time.Now()
+		for {
+			select {
+			case <-beatCtx.Done():
+				return
+			case now := <-ticker.C:
+				heartbeat.beat(beatCtx, repo, jobId, last)
+				last = now
+			}
+		}
+	}()
+	return ctx, func() {
+		cancel()
+		<-done
+	}
+}
+
+func (h *jobHeartbeat) beat(ctx context.Context, repo repository.JobRepository, jobId uuid.UUID, since time.Time) {
+	h.mu.Lock()
+	stage, enteredAt, progressAt := h.stage, h.enteredAt, h.progressAt
+	h.mu.Unlock()
+
+	if err := repo.RecordJobHeartbeat(ctx, jobId, stage); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to record job heartbeat")
+	}
+	if progressAt.After(since) {
+		return
+	}
+	data := entities.EventData{"stage_seconds": time.Since(enteredAt).Seconds()}
+	if !progressAt.IsZero() {
+		data["since_progress_seconds"] = time.Since(progressAt).Seconds()
+	}
+	recordEvent(ctx, constant.JobEventHeartbeat, stage, data)
+}
+
+// enterStage makes name the stage the job's heartbeats report, if ctx has
+// them, and returns the function that goes back to the stage it's in.
+func enterStage(ctx context.Context, name string) func() {
+	h, ok := ctx.Value(heartbeatKey{}).(*jobHeartbeat)
+	if !ok {
+		return func() {}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	outer, outerAt := h.stage, h.enteredAt
+	h.stage, h.enteredAt = name, time.Now()
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.stage, h.enteredAt = outer, outerAt
+	}
+}
+
+// noteProgress records that ffmpeg reported progress.
+func noteProgress(ctx context.Context) {
+	if h, ok := ctx.Value(heartbeatKey{}).(*jobHeartbeat); ok {
+		h.mu.Lock()
+		h.progressAt = time.Now()
+		h.mu.Unlock()
+	}
+}
+
+// stalled reports whether the processing job's heartbeats stopped. A job
+// that hasn't had one yet was claimed by a worker that records none, or
+// only just now.
+func stalled(status *dto.JobStatus, cfg *config.Config) bool {
+	if status.Status != constant.JobStatusProcessing || status.HeartbeatAt == nil {
+		return false
+	}
+	timeout := time.Duration(jobHeartbeatMisses*max(cfg.Server.JobHeartbeatInterval, 1)) * time.Second
+	return time.Since(*status.HeartbeatAt) > timeout
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"worker-transcode/pkg/objectstore"
+
+	"github.com/minio/minio-go/v7"
+)
+
+var (
+	bandwidthPattern = regexp.MustCompile(`BANDWIDTH=(\d+)`)
+	uriPattern       = regexp.MustCompile(`URI="([^"]+)"`)
+)
+
+// isHLSSource reports whether a job's source is a published master playlist
+// rather than an upload. Backfill uses those once the original is gone.
+func isHLSSource(objectPath string) bool {
+	return strings.HasSuffix(objectPath, ".m3u8")
+}
+
+// downloadSource fetches the job's source into dir and returns the local video
+// input and, for HLS sources, the separate audio input.
+func (s service) downloadSource(ctx context.Context, objectPath, dir string) (string, string, error) {
+	if isHLSSource(objectPath) {
+		return downloadHLSSource(ctx, s.store, s.cfg.MinIOBucket, objectPath, dir)
+	}
+	input := filepath.Join(dir, filepath.Base(objectPath))
+	return input, "", s.store.FGetObject(ctx, s.cfg.MinIOBucket, objectPath, input, minio.GetObjectOptions{})
+}
+
+// downloadHLSSource fetches the highest bandwidth variant of a master playlist,
+// and its audio rendition if there is one, with all of their segments. The
+// local playlists are returned so ffmpeg can read them as inputs.
+func downloadHLSSource(ctx context.Context, client objectstore.Store, bucket, masterKey, dir string) (string, string, error) {
+	prefix := path.Dir(masterKey)
+	master, err := readObjectLines(ctx, client, bucket, masterKey)
+	if err != nil {
+		return "", "", fmt.Errorf("read master playlist: %w", err)
+	}
+	videoURI, audioURI := highestVariant(master)
+	if videoURI == "" {
+		return "", "", fmt.Errorf("master playlist %s lists no variants", masterKey)
+	}
+
+	video, err := downloadMediaPlaylist(ctx, client, bucket, prefix, videoURI, dir)
+	if err != nil {
+		return "", "", err
+	}
+	var audio string
+	if audioURI != "" {
+		if audio, err = downloadMediaPlaylist(ctx, client, bucket, prefix, audioURI, dir); err != nil {
+			return "", "", err
+		}
+	}
+	return video, audio, nil
+}
+
+// highestVariant returns the URIs of a master playlist's highest bandwidth
+// variant and of its first audio rendition, empty when it has none.
+func highestVariant(master []string) (string, string) {
+	var (
+		videoURI, audioURI string
+		bestBandwidth      = -1
+	)
+	for i, line := range master {
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MEDIA:") && strings.Contains(line, "TYPE=AUDIO"):
+			if match := uriPattern.FindStringSubmatch(line); match != nil && audioURI == "" {
+				audioURI = match[1]
+			}
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:") && i+1 < len(master):
+			bandwidth := 0
+			if match := bandwidthPattern.FindStringSubmatch(line); match != nil {
+				bandwidth, _ = strconv.Atoi(match[1])
+			}
+			if bandwidth > bestBandwidth {
+				bestBandwidth, videoURI = bandwidth, master[i+1]
+			}
+		}
+	}
+	return videoURI, audioURI
+}
+
+// downloadMediaPlaylist fetches a media playlist and its segments, keeping
+// their paths relative to prefix so the playlist resolves locally.
+func downloadMediaPlaylist(ctx context.Context, client objectstore.Store, bucket, prefix, uri, dir string) (string, error) {
+	local := filepath.Join(dir, filepath.FromSlash(uri))
+	if err := client.FGetObject(ctx, bucket, path.Join(prefix, uri), local, minio.GetObjectOptions{}); err != nil {
+		return "", fmt.Errorf("download playlist %s: %w", uri, err)
+	}
+
+	lines, err := readLines(local)
+	if err != nil {
+		return "", err
+	}
+	segmentPrefix := path.Dir(path.Join(prefix, uri))
+	for _, line := range lines {
+		// An fMP4 playlist's init segment is named by its EXT-X-MAP.
+		if strings.HasPrefix(line, "#EXT-X-MAP:") {
+			if match := uriPattern.FindStringSubmatch(line); match != nil {
+				line = match[1]
+			}
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		segment := filepath.Join(filepath.Dir(local), filepath.FromSlash(line))
+		if err := client.FGetObject(ctx, bucket, path.Join(segmentPrefix, line), segment, minio.GetObjectOptions{}); err != nil {
+			return "", fmt.Errorf("download segment %s: %w", line, err)
+		}
+	}
+	return local, nil
+}
+
+func readObjectLines(ctx context.Context, client objectstore.Store, bucket, key string) ([]string, error) {
+	object, err := client.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+	return scanLines(bufio.NewScanner(object))
+}
+
+func readLines(name string) ([]string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return scanLines(bufio.NewScanner(file))
+}
+
+func scanLines(scanner *bufio.Scanner) ([]string, error) {
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimSpace(scanner.Text()))
+	}
+	return lines, scanner.Err()
+}
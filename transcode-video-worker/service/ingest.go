@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+)
+
+// ingestRetryDelay is how long the listener waits before listening again
+// after MinIO closed the notification stream.
+const ingestRetryDelay = 10 * time.Second
+
+// IngestService creates transcode jobs for files dropped into the ingest
+// prefix, laid out as <prefix><preset>/<lesson id>/<file name>, so a
+// producer only needs to write to the bucket. The preset directory names
+// the preset to encode with.
+type IngestService interface {
+	// Run takes every file already under the prefix, then listens for new
+	// ones until ctx is done. It runs on the leader only, so each file
+	// creates one job.
+	Run(ctx context.Context)
+}
+
+type ingestService struct {
+	repo      repository.JobRepository
+	publisher rabbitmq.Publisher
+	store     objectstore.Store
+	cfg       *config.Config
+}
+
+func (s *ingestService) Run(ctx context.Context) {
+	prefix := s.cfg.Ingest.Prefix
+	// Notifications aren't replayed, so files dropped while nobody was
+	// listening are picked up here.
+	for object := range s.store.ListObjects(ctx, s.cfg.MinIOBucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			zerolog.Ctx(ctx).Warn().Err(object.Err).Msg("failed to list ingest prefix")
+			break
+		}
+		s.ingest(ctx, object.Key)
+	}
+
+	for {
+		notifications := s.store.ListenBucketNotification(ctx, s.cfg.MinIOBucket, prefix, "", []string{"s3:ObjectCreated:*"})
+		for notification := range notifications {
+			if notification.Err != nil {
+				zerolog.Ctx(ctx).Warn().Err(notification.Err).Msg("bucket notification failed")
+				continue
+			}
+			for _, record := range notification.Records {
+				key, err := url.QueryUnescape(record.S3.Object.Key)
+				if err != nil {
+					zerolog.Ctx(ctx).Warn().Err(err).Str("key", record.S3.Object.Key).Msg("invalid ingest key")
+					continue
+				}
+				s.ingest(ctx, key)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(ingestRetryDelay):
+		}
+	}
+}
+
+// ingest moves key into its lesson's videos, where uploads are kept, and
+// queues a transcode of it. A file that isn't laid out as expected is left
+// where it is.
+func (s *ingestService) ingest(ctx context.Context, key string) {
+	preset, lessonId, fileName, err := parseIngestKey(s.cfg.Ingest.Prefix, key)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("key", key).Msg("skipping ingest object")
+		return
+	}
+	ctx = zerolog.Ctx(ctx).With().Str("key", key).Str("lesson_id", lessonId.String()).Logger().WithContext(ctx)
+
+	objectPath := fmt.Sprintf("lessons/%s/videos/%d-%s", lessonId, time.Now().UnixMilli(), fileName)
+	_, err = s.store.ComposeObject(ctx,
+		minio.CopyDestOptions{Bucket: s.cfg.MinIOBucket, Object: objectPath},
+		minio.CopySrcOptions{Bucket: s.cfg.MinIOBucket, Object: key})
+	if err != nil {
+		// Already taken by the listing or an earlier notification.
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return
+		}
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to move ingest object")
+		return
+	}
+	if err := s.store.RemoveObject(ctx, s.cfg.MinIOBucket, key, minio.RemoveObjectOptions{}); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to remove ingest object")
+	}
+
+	job, err := s.queue(ctx, lessonId, objectPath, fileName, preset)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Str("object_path", objectPath).Msg("failed to queue ingested file")
+		return
+	}
+	zerolog.Ctx(ctx).Info().
+		Str("job_id", job.ID.String()).
+		Str("object_path", objectPath).
+		Str("preset", preset).
+		Msg("ingested file queued for transcoding")
+}
+
+func (s *ingestService) queue(ctx context.Context, lessonId uuid.UUID, objectPath, fileName, preset string) (*entities.Job, error) {
+	return queueTranscode(ctx, s.repo, s.publisher, s.store, s.cfg, uuid.New(), lessonId, constant.ParseSLAClass(s.cfg.Ingest.SLAClass), dto.JobMessage{
+		ObjectPath: objectPath,
+		FileName:   fileName,
+		Preset:     preset,
+	})
+}
+
+// queueTranscode creates a transcode job of the lesson video in the bucket
+// at message's ObjectPath and publishes message for it, on the lane its
+// duration belongs to.
+func queueTranscode(ctx context.Context, repo repository.JobRepository, publisher rabbitmq.Publisher, store objectstore.Store, cfg *config.Config, jobId, lessonId uuid.UUID, class constant.SLAClass, message dto.JobMessage) (*entities.Job, error) {
+	job := &entities.Job{
+		ID:         jobId,
+		EntityId:   lessonId,
+		EntityType: string(constant.EntityTypeLessonVideo),
+		Status:     constant.JobStatusPending,
+		JobType:    constant.JobTypeTranscoder,
+		SLAClass:   &class,
+	}
+	// ffprobe reads the object over a presigned URL, so the lane is picked
+	// without downloading it.
+	if source, err := store.PresignedGetObject(ctx, cfg.MinIOBucket, message.ObjectPath, 15*time.Minute, nil); err == nil {
+		if media, err := ProbeMedia(ctx, source.String()); err == nil {
+			seconds := media.DurationSeconds()
+			job.SourceSeconds = &seconds
+		} else {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to probe source duration")
+		}
+	}
+	if err := repo.CreateJob(ctx, job); err != nil {
+		return nil, err
+	}
+
+	message.JobId = job.ID
+	message.SLAClass = string(class)
+	topology := shardTopology(cfg, class, job.SourceSeconds)
+	if err := publisher.Publish(ctx, topology.Exchange, topology.RoutingKey, message); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// parseIngestKey splits <prefix><preset>/<lesson id>/<file name>.
+func parseIngestKey(prefix, key string) (string, uuid.UUID, string, error) {
+	parts := strings.Split(strings.TrimPrefix(key, prefix), "/")
+	if !strings.HasPrefix(key, prefix) || len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+		return "", uuid.Nil, "", errors.New("expected <preset>/<lesson id>/<file name> under the ingest prefix")
+	}
+	lessonId, err := uuid.Parse(parts[1])
+	if err != nil {
+		return "", uuid.Nil, "", fmt.Errorf("invalid lesson id %q: %w", parts[1], err)
+	}
+	fileName := unsafeFileNameChars.ReplaceAllString(path.Base(parts[2]), "_")
+	return parts[0], lessonId, fileName, nil
+}
+
+func NewIngestService(repo repository.JobRepository, publisher rabbitmq.Publisher, store objectstore.Store, cfg *config.Config) IngestService {
+	return &ingestService{
+		repo:      repo,
+		publisher: publisher,
+		store:     store,
+		cfg:       cfg,
+	}
+}
@@ -0,0 +1,526 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultJobPageSize = 50
+	maxJobPageSize     = 200
+)
+
+// Bounds of a job annotation, which holds an operator's context rather than
+// logs.
+const (
+	maxAnnotationNote = 4000
+	maxAnnotationTags = 20
+	maxAnnotationTag  = 64
+)
+
+var jobSortColumns = map[string]bool{
+	"created_at": true,
+	"updated_at": true,
+}
+
+type JobService interface {
+	Search(ctx context.Context, request dto.JobSearchRequest) (*dto.JobPage, error)
+	Bump(ctx context.Context, id uuid.UUID, request dto.JobBumpRequest) (*entities.Job, error)
+	Timeline(ctx context.Context, id uuid.UUID) (*dto.JobTimeline, error)
+	// Status returns the job's status and progress, from the status cache
+	// when there is one.
+	Status(ctx context.Context, id uuid.UUID) (*dto.JobStatus, error)
+	// Untrim undoes the dead air trim of a completed job: a new job publishes
+	// the kept original of the lesson as it was uploaded.
+	Untrim(ctx context.Context, id uuid.UUID) (*entities.Job, error)
+	// Annotate attaches an operator's note and tags to the job; jobs can be
+	// searched by both.
+	Annotate(ctx context.Context, id uuid.UUID, request dto.JobAnnotationRequest) (*entities.JobAnnotation, error)
+	Annotations(ctx context.Context, id uuid.UUID) ([]*entities.JobAnnotation, error)
+	RemoveAnnotation(ctx context.Context, id, annotationId uuid.UUID) error
+}
+
+type jobService struct {
+	repo        repository.JobRepository
+	events      repository.JobEventRepository
+	annotations repository.JobAnnotationRepository
+	publisher   rabbitmq.Publisher
+	store       objectstore.Store
+	cfg         *config.Config
+}
+
+func (s *jobService) Search(ctx context.Context, request dto.JobSearchRequest) (*dto.JobPage, error) {
+	query, err := parseJobSearchRequest(request)
+	if err != nil {
+		return nil, errors.Join(ErrInvalidArgument, err)
+	}
+
+	// Fetch one extra row to know whether another page exists.
+	limit := query.Limit
+	query.Limit = limit + 1
+	jobs, err := s.repo.SearchJobs(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &dto.JobPage{Data: jobs}
+	if len(jobs) > limit {
+		page.Data = jobs[:limit]
+		last := page.Data[limit-1]
+		value := last.CreatedAt
+		if query.Sort == "updated_at" {
+			value = last.UpdatedAt
+		}
+		page.NextCursor, err = encodeJobCursor(dto.JobSearchCursor{Value: value, Id: last.ID})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return page, nil
+}
+
+// Bump republishes a pending job onto the priority lane. Whichever delivery is
+// consumed first claims the job; the other one is acked as a no-op.
+func (s *jobService) Bump(ctx context.Context, id uuid.UUID, request dto.JobBumpRequest) (*entities.Job, error) {
+	job, err := s.repo.FindJobById(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if job.Status != constant.JobStatusPending {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("job is %s, only pending jobs can be bumped", job.Status))
+	}
+	if job.JobType == constant.JobTypeRecordingMerge {
+		return nil, errors.Join(ErrInvalidArgument, errors.New("recording merge jobs can't be bumped"))
+	}
+
+	objectPath := request.ObjectPath
+	if objectPath == "" {
+		objectPath, err = s.findSourceObject(ctx, job)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	priority := request.Priority
+	if priority <= job.Priority {
+		priority = job.Priority + 1
+	}
+
+	message := dto.JobMessage{
+		JobId:      job.ID,
+		ObjectPath: objectPath,
+		FileName:   path.Base(objectPath),
+	}
+	if err := s.publisher.Publish(ctx, rabbitmq.PriorityTranscodeTopology.Exchange, rabbitmq.PriorityTranscodeTopology.RoutingKey, message); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.UpdateJobPriority(ctx, job.ID, priority); err != nil {
+		return nil, err
+	}
+	job.Priority = priority
+
+	zerolog.Ctx(ctx).Info().
+		Str("job_id", job.ID.String()).
+		Str("object_path", objectPath).
+		Int("priority", priority).
+		Msg("job bumped to priority lane")
+
+	return job, nil
+}
+
+func (s *jobService) Timeline(ctx context.Context, id uuid.UUID) (*dto.JobTimeline, error) {
+	job, err := s.repo.FindJobById(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := s.events.ListJobEvents(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return buildTimeline(job, events), nil
+}
+
+func (s *jobService) Status(ctx context.Context, id uuid.UUID) (*dto.JobStatus, error) {
+	cache, cached := s.repo.(repository.JobStatusCache)
+	if cached {
+		status, err := cache.FindJobStatus(ctx, id)
+		if err == nil {
+			status.Stalled = stalled(status, s.cfg)
+			return status, nil
+		}
+		// A cache that is down only costs the read Postgres would have had.
+		if !errors.Is(err, repository.ErrCacheMiss) {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to read job status cache")
+		}
+	}
+
+	job, err := s.repo.FindJobById(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	status := &dto.JobStatus{
+		JobId:        job.ID,
+		Status:       job.Status,
+		Progress:     job.Progress,
+		ErrorClass:   job.ErrorClass,
+		ErrorKind:    job.ErrorKind,
+		ErrorMessage: job.ErrorMessage,
+		HeartbeatAt:  job.HeartbeatAt,
+		UpdatedAt:    job.UpdatedAt,
+	}
+	if job.Stage != nil {
+		status.Stage = *job.Stage
+	}
+	if cached {
+		if err := cache.SaveJobStatus(ctx, status); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to cache job status")
+		}
+	}
+	status.Stalled = stalled(status, s.cfg)
+	return status, nil
+}
+
+func (s *jobService) Untrim(ctx context.Context, id uuid.UUID) (*entities.Job, error) {
+	job, err := s.repo.FindJobById(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != constant.JobStatusCompleted {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("job is %s, only completed jobs can be untrimmed", job.Status))
+	}
+
+	events, err := s.events.ListJobEvents(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var original string
+	for _, event := range events {
+		if event.EventType == constant.JobEventTrim {
+			original = dataString(event.Data, "original")
+		}
+	}
+	if original == "" {
+		return nil, errors.Join(ErrInvalidArgument, errors.New("job was not trimmed"))
+	}
+	if _, err := s.store.StatObject(ctx, s.cfg.MinIOBucket, original, minio.StatObjectOptions{}); err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, errors.Join(ErrNotFound, fmt.Errorf("original %s is gone", original))
+		}
+		return nil, err
+	}
+
+	untrim := &entities.Job{
+		ID:            uuid.New(),
+		EntityId:      job.EntityId,
+		EntityType:    job.EntityType,
+		Status:        constant.JobStatusPending,
+		JobType:       constant.JobTypeTranscoder,
+		TenantId:      job.TenantId,
+		UserId:        job.UserId,
+		SLAClass:      job.SLAClass,
+		SourceSeconds: job.SourceSeconds,
+		CorrelationId: job.CorrelationId,
+	}
+	if err := s.repo.CreateJob(ctx, untrim); err != nil {
+		return nil, err
+	}
+
+	class := jobSLAClass(job.SLAClass)
+	message := dto.JobMessage{
+		JobId:      untrim.ID,
+		ObjectPath: original,
+		FileName:   path.Base(original),
+		SLAClass:   string(class),
+		NoTrim:     true,
+	}
+	topology := shardTopology(s.cfg, class, job.SourceSeconds)
+	if err := s.publisher.Publish(ctx, topology.Exchange, topology.RoutingKey, message); err != nil {
+		return nil, err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("job_id", untrim.ID.String()).
+		Str("trimmed_job_id", job.ID.String()).
+		Str("object_path", original).
+		Msg("untrimmed original queued for transcoding")
+	return untrim, nil
+}
+
+// findSourceObject locates the original upload for a pending lesson job.
+func (s *jobService) findSourceObject(ctx context.Context, job *entities.Job) (string, error) {
+	source, err := latestUpload(ctx, s.store, s.cfg, job.EntityId)
+	if err != nil {
+		return "", err
+	}
+	if source == "" {
+		return "", errors.Join(ErrInvalidArgument, fmt.Errorf("no source object for lesson %s, pass object_path explicitly", job.EntityId))
+	}
+	return source, nil
+}
+
+// latestUpload returns the key of the newest upload for a lesson, or "" if
+// there is none. The API stores uploads under lessons/{id}/videos/ and the
+// worker deletes them after transcoding, so the newest non-HLS object there
+// is the source.
+func latestUpload(ctx context.Context, store objectstore.Store, cfg *config.Config, lessonId uuid.UUID) (string, error) {
+	prefix := fmt.Sprintf("lessons/%s/videos/", lessonId)
+
+	var source *minio.ObjectInfo
+	for object := range store.ListObjects(ctx, cfg.MinIOBucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return "", object.Err
+		}
+		// Versions are packages in directories of their own.
+		if path.Dir(object.Key) != strings.TrimSuffix(prefix, "/") || isPackageFile(object.Key) {
+			continue
+		}
+		if source == nil || object.LastModified.After(source.LastModified) {
+			source = &object
+		}
+	}
+
+	if source == nil {
+		return "", nil
+	}
+	return source.Key, nil
+}
+
+func (s *jobService) Annotate(ctx context.Context, id uuid.UUID, request dto.JobAnnotationRequest) (*entities.JobAnnotation, error) {
+	note := strings.TrimSpace(request.Note)
+	tags, err := normalizeTags(request.Tags)
+	if err != nil {
+		return nil, errors.Join(ErrInvalidArgument, err)
+	}
+	switch {
+	case note == "" && len(tags) == 0:
+		return nil, errors.Join(ErrInvalidArgument, errors.New("an annotation needs a note or tags"))
+	case len(note) > maxAnnotationNote:
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("note: longer than %d characters", maxAnnotationNote))
+	}
+	if _, err := s.findJob(ctx, id); err != nil {
+		return nil, err
+	}
+
+	annotation := &entities.JobAnnotation{
+		ID:       uuid.New(),
+		JobId:    id,
+		Note:     note,
+		Tags:     tags,
+		AuthorId: request.AuthorId,
+	}
+	if err := s.annotations.CreateAnnotation(ctx, annotation); err != nil {
+		return nil, err
+	}
+	zerolog.Ctx(ctx).Info().Str("job_id", id.String()).Strs("tags", tags).Msg("job annotated")
+	return annotation, nil
+}
+
+func (s *jobService) Annotations(ctx context.Context, id uuid.UUID) ([]*entities.JobAnnotation, error) {
+	if _, err := s.findJob(ctx, id); err != nil {
+		return nil, err
+	}
+	return s.annotations.ListAnnotations(ctx, id)
+}
+
+func (s *jobService) RemoveAnnotation(ctx context.Context, id, annotationId uuid.UUID) error {
+	removed, err := s.annotations.DeleteAnnotation(ctx, id, annotationId)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return errors.Join(ErrNotFound, fmt.Errorf("job %s has no annotation %s", id, annotationId))
+	}
+	return nil
+}
+
+func (s *jobService) findJob(ctx context.Context, id uuid.UUID) (*entities.Job, error) {
+	job, err := s.repo.FindJobById(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	return job, err
+}
+
+// normalizeTags lowercases and trims tags so they are searched however they
+// were typed, dropping blanks and repeats.
+func normalizeTags(tags []string) ([]string, error) {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || slices.Contains(normalized, tag) {
+			continue
+		}
+		if len(tag) > maxAnnotationTag {
+			return nil, fmt.Errorf("tag %q: longer than %d characters", tag, maxAnnotationTag)
+		}
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > maxAnnotationTags {
+		return nil, fmt.Errorf("tags: more than %d", maxAnnotationTags)
+	}
+	return normalized, nil
+}
+
+func parseJobSearchRequest(request dto.JobSearchRequest) (dto.JobSearchQuery, error) {
+	query := dto.JobSearchQuery{
+		Sort:  "created_at",
+		Order: constant.SortOrderDesc,
+		Limit: defaultJobPageSize,
+	}
+
+	if request.Status != "" {
+		for _, status := range strings.Split(request.Status, ",") {
+			query.Statuses = append(query.Statuses, constant.JobStatus(strings.ToUpper(strings.TrimSpace(status))))
+		}
+	}
+
+	if request.CourseId != "" {
+		id, err := uuid.Parse(request.CourseId)
+		if err != nil {
+			return query, fmt.Errorf("course_id: %w", err)
+		}
+		query.CourseId = &id
+	}
+
+	if request.TenantId != "" {
+		id, err := uuid.Parse(request.TenantId)
+		if err != nil {
+			return query, fmt.Errorf("tenant_id: %w", err)
+		}
+		query.TenantId = &id
+	}
+
+	if request.ErrorClass != "" {
+		errorClass := constant.ErrorClass(request.ErrorClass)
+		query.ErrorClass = &errorClass
+	}
+	if request.ErrorKind != "" {
+		errorKind := constant.ErrorKind(request.ErrorKind)
+		query.ErrorKind = &errorKind
+	}
+
+	if request.Tag != "" {
+		tags, err := normalizeTags(strings.Split(request.Tag, ","))
+		if err != nil {
+			return query, err
+		}
+		query.Tags = tags
+	}
+	if annotation := strings.TrimSpace(request.Annotation); annotation != "" {
+		query.Annotation = &annotation
+	}
+
+	if request.From != "" {
+		from, err := time.Parse(time.RFC3339, request.From)
+		if err != nil {
+			return query, fmt.Errorf("from: %w", err)
+		}
+		query.From = &from
+	}
+
+	if request.To != "" {
+		to, err := time.Parse(time.RFC3339, request.To)
+		if err != nil {
+			return query, fmt.Errorf("to: %w", err)
+		}
+		query.To = &to
+	}
+
+	if request.Sort != "" {
+		if !jobSortColumns[request.Sort] {
+			return query, fmt.Errorf("sort: unsupported column %q", request.Sort)
+		}
+		query.Sort = request.Sort
+	}
+
+	switch constant.SortOrder(strings.ToLower(request.Order)) {
+	case "":
+	case constant.SortOrderAsc:
+		query.Order = constant.SortOrderAsc
+	case constant.SortOrderDesc:
+		query.Order = constant.SortOrderDesc
+	default:
+		return query, fmt.Errorf("order: must be asc or desc")
+	}
+
+	if request.Limit > 0 {
+		query.Limit = min(request.Limit, maxJobPageSize)
+	}
+
+	if request.Cursor != "" {
+		cursor, err := decodeJobCursor(request.Cursor)
+		if err != nil {
+			return query, fmt.Errorf("cursor: %w", err)
+		}
+		query.After = cursor
+	}
+
+	return query, nil
+}
+
+func encodeJobCursor(cursor dto.JobSearchCursor) (string, error) {
+	raw, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func decodeJobCursor(value string) (*dto.JobSearchCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	cursor := &dto.JobSearchCursor{}
+	if err := json.Unmarshal(raw, cursor); err != nil {
+		return nil, err
+	}
+	return cursor, nil
+}
+
+func NewJobService(repo repository.JobRepository, events repository.JobEventRepository, annotations repository.JobAnnotationRepository,
+	publisher rabbitmq.Publisher, store objectstore.Store, cfg *config.Config) JobService {
+	return &jobService{
+		repo:        repo,
+		events:      events,
+		annotations: annotations,
+		publisher:   publisher,
+		store:       store,
+		cfg:         cfg,
+	}
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/version"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"gorm.io/gorm"
+)
+
+// maxBundledArtifacts bounds how much of a job's artifact bundle is read
+// into its support bundle.
+const maxBundledArtifacts = 256 << 20
+
+// JobBundleService packages everything recorded about a job into one file,
+// for support to attach to an escalation instead of collecting it by hand.
+type JobBundleService interface {
+	// Bundle returns the job's support bundle, a gzipped tar of the job, its
+	// timeline of events, annotations, QC flags, the preset version it ran
+	// with and, under artifacts/, its ffmpeg logs, source probe and QC and
+	// verification reports. manifest.json lists what the bundle holds and
+	// what it couldn't include.
+	Bundle(ctx context.Context, id uuid.UUID) ([]byte, error)
+}
+
+type jobBundleService struct {
+	repo        repository.JobRepository
+	events      repository.JobEventRepository
+	annotations repository.JobAnnotationRepository
+	qc          repository.QCRepository
+	presets     repository.PresetRepository
+	store       objectstore.Store
+	cfg         *config.Config
+}
+
+func (s *jobBundleService) Bundle(ctx context.Context, id uuid.UUID) ([]byte, error) {
+	job, err := s.repo.FindJobById(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := dto.JobBundleManifest{
+		JobId:       id,
+		GeneratedAt: time.Now().UTC(),
+		Worker:      version.Get(),
+		Files:       []string{},
+		Missing:     []string{},
+	}
+	var files []artifact
+	add := func(name string, v interface{}) error {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return fmt.Errorf("encode %s: %w", name, err)
+		}
+		files = append(files, artifact{name: name, data: data})
+		manifest.Files = append(manifest.Files, name)
+		return nil
+	}
+
+	events, err := s.events.ListJobEvents(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	annotations, err := s.annotations.ListAnnotations(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	flags, err := s.jobFlags(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range []struct {
+		name string
+		v    interface{}
+	}{
+		{"job.json", job},
+		{"timeline.json", buildTimeline(job, events)},
+		{"annotations.json", annotations},
+		{"qc/flags.json", flags},
+	} {
+		if err := add(part.name, part.v); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case job.Preset == nil || job.PresetVersion == nil:
+		manifest.Missing = append(manifest.Missing, "preset.json: the job ran without a preset")
+	default:
+		preset, err := s.presets.FindPresetVersion(ctx, *job.Preset, *job.PresetVersion)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			manifest.Missing = append(manifest.Missing, fmt.Sprintf("preset.json: preset %s version %d no longer exists", *job.Preset, *job.PresetVersion))
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := add("preset.json", preset); err != nil {
+			return nil, err
+		}
+	}
+
+	artifacts, err := s.readArtifacts(ctx, id)
+	switch {
+	case minio.ToErrorResponse(err).Code == "NoSuchKey":
+		manifest.Missing = append(manifest.Missing, "artifacts/: the job stored no artifacts")
+	case err != nil:
+		return nil, fmt.Errorf("read job artifacts: %w", err)
+	}
+	for _, file := range artifacts {
+		files = append(files, file)
+		manifest.Files = append(manifest.Files, file.name)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	files = append([]artifact{{name: "manifest.json", data: data}}, files...)
+
+	var body bytes.Buffer
+	if err := writeTarGz(&body, files); err != nil {
+		return nil, err
+	}
+	return body.Bytes(), nil
+}
+
+// jobFlags are the QC flags raised on the job's video.
+func (s *jobBundleService) jobFlags(ctx context.Context, job *entities.Job) ([]*entities.QCFlag, error) {
+	lessonFlags, err := s.qc.ListLessonFlags(ctx, job.EntityId)
+	if err != nil {
+		return nil, err
+	}
+	flags := []*entities.QCFlag{}
+	for _, flag := range lessonFlags {
+		if flag.JobId == job.ID {
+			flags = append(flags, flag)
+		}
+	}
+	return flags, nil
+}
+
+// readArtifacts reads the files of the job's artifact bundle, named under
+// artifacts/.
+func (s *jobBundleService) readArtifacts(ctx context.Context, id uuid.UUID) ([]artifact, error) {
+	object, err := s.store.GetObject(ctx, s.cfg.MinIOBucket, artifactsKeyFor(id), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+	gz, err := gzip.NewReader(io.LimitReader(object, maxBundledArtifacts))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var files []artifact
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(archive)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, artifact{name: path.Join("artifacts", header.Name), data: data})
+	}
+}
+
+func NewJobBundleService(repo repository.JobRepository, events repository.JobEventRepository, annotations repository.JobAnnotationRepository,
+	qc repository.QCRepository, presets repository.PresetRepository, store objectstore.Store, cfg *config.Config) JobBundleService {
+	return &jobBundleService{
+		repo:        repo,
+		events:      events,
+		annotations: annotations,
+		qc:          qc,
+		presets:     presets,
+		store:       store,
+		cfg:         cfg,
+	}
+}
@@ -0,0 +1,628 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/correlation"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/pkg/reporting"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+var (
+	methodPattern = regexp.MustCompile(`METHOD=([A-Z0-9-]+)`)
+	ivPattern     = regexp.MustCompile(`IV=0[xX]([0-9a-fA-F]+)`)
+)
+
+// KeyRotationService rotates the AES-128 content keys lesson packages are
+// encrypted with, as after a key leaked. A rotation re-encrypts the lesson's
+// segments with a new key into a version of its own, so the lesson switches
+// to it in one update and players mid-lesson keep playing the version they
+// loaded, whose key is served until that version expires. A package that
+// was never encrypted is encrypted by its first rotation.
+type KeyRotationService interface {
+	// Request queues a rotation of every transcoded lesson of the courses.
+	Request(ctx context.Context, request dto.KeyRotationRequest) ([]*entities.KeyRotation, error)
+	Find(ctx context.Context, id uuid.UUID) (*entities.KeyRotation, error)
+	// Key returns a content key while it is served, for the API to hand to
+	// players it authorized.
+	Key(ctx context.Context, id uuid.UUID) ([]byte, error)
+	// Process runs a key rotation job.
+	Process(ctx context.Context, message dto.KeyRotationMessage) error
+}
+
+type keyRotationService struct {
+	repo      repository.ContentKeyRepository
+	jobs      repository.JobRepository
+	events    repository.JobEventRepository
+	versions  VideoVersionService
+	publisher rabbitmq.Publisher
+	store     objectstore.Store
+	cfg       *config.Config
+}
+
+func (s *keyRotationService) Request(ctx context.Context, request dto.KeyRotationRequest) ([]*entities.KeyRotation, error) {
+	mode := constant.KeyRotationMode(request.Mode)
+	if mode == "" {
+		mode = constant.KeyRotationModeRotate
+	}
+	if mode != constant.KeyRotationModeRotate && mode != constant.KeyRotationModeRewrap {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("mode %q is not rotate or rewrap", request.Mode))
+	}
+	if len(request.CourseIds) == 0 {
+		return nil, errors.Join(ErrInvalidArgument, errors.New("course_ids must not be empty"))
+	}
+	lessonIds, err := s.repo.ListCourseLessons(ctx, request.CourseIds)
+	if err != nil {
+		return nil, err
+	}
+
+	rotations := make([]*entities.KeyRotation, 0, len(lessonIds))
+	for _, lessonId := range lessonIds {
+		job := &entities.Job{
+			ID:         uuid.New(),
+			EntityId:   lessonId,
+			EntityType: string(constant.EntityTypeLessonVideo),
+			Status:     constant.JobStatusPending,
+			JobType:    constant.JobTypeKeyRotation,
+			UserId:     request.UserId,
+		}
+		if correlationId := correlation.FromContext(ctx); correlationId != "" {
+			job.CorrelationId = &correlationId
+		}
+		rotation := &entities.KeyRotation{
+			ID:       uuid.New(),
+			LessonId: lessonId,
+			JobId:    job.ID,
+			Mode:     mode,
+		}
+
+		if err := s.jobs.CreateJob(ctx, job); err != nil {
+			return nil, err
+		}
+		if err := s.repo.CreateRotation(ctx, rotation); err != nil {
+			return nil, err
+		}
+		message := dto.KeyRotationMessage{JobId: job.ID}
+		if err := s.publisher.Publish(ctx, rabbitmq.KeyRotationTopology.Exchange, rabbitmq.KeyRotationTopology.RoutingKey, message); err != nil {
+			return nil, err
+		}
+		rotations = append(rotations, rotation)
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Int("courses", len(request.CourseIds)).
+		Int("lessons", len(rotations)).
+		Str("mode", string(mode)).
+		Msg("content key rotation queued")
+	return rotations, nil
+}
+
+func (s *keyRotationService) Find(ctx context.Context, id uuid.UUID) (*entities.KeyRotation, error) {
+	rotation, err := s.repo.FindRotation(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	return rotation, err
+}
+
+// Key serves a pending key too: the lesson may be switched to its package
+// before the key is marked active.
+func (s *keyRotationService) Key(ctx context.Context, id uuid.UUID) ([]byte, error) {
+	key, err := s.repo.FindKey(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if key.ExpiresAt != nil && !key.ExpiresAt.After(time.Now()) {
+		return nil, errors.Join(ErrNotFound, fmt.Errorf("content key %s expired", id))
+	}
+	return s.unwrap(key)
+}
+
+func (s *keyRotationService) Process(ctx context.Context, message dto.KeyRotationMessage) (err error) {
+	job, err := s.jobs.FindJobById(ctx, message.JobId)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to find job by id")
+		return err
+	}
+	if job.Status != constant.JobStatusPending {
+		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("job is not pending")
+		return nil
+	}
+	rotation, err := s.repo.FindRotationByJob(ctx, message.JobId)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to find key rotation")
+		return err
+	}
+
+	claimed, err := s.jobs.ClaimJob(ctx, message.JobId, correlation.FromContext(ctx), workerFromContext(ctx))
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to update job status")
+		return err
+	}
+	if !claimed {
+		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("job already claimed by another delivery")
+		return nil
+	}
+	rabbitmq.Claimed(ctx)
+	ctx = withTimeline(ctx, s.events, job.ID)
+	recordStatus(ctx, constant.JobStatusPending, constant.JobStatusProcessing)
+
+	stage := constant.ErrorClassDatabase
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = panicked(ctx, recovered)
+		}
+		if shuttingDown(ctx, err) {
+			err = handOff(ctx, s.jobs, message.JobId, err)
+			return
+		}
+		err = classify(err)
+		recordOutcome(ctx, job, stage, err)
+		recordOutcomeEvents(ctx, stage, err)
+		if err != nil {
+			if errors.Is(err, ErrNonRetryable) {
+				if updateErr := s.jobs.FailJob(ctx, message.JobId, stage, errorKind(err), err.Error()); updateErr != nil {
+					zerolog.Ctx(ctx).Error().Err(updateErr).Msg("failed to update job status")
+				}
+				reporting.CaptureFailure(ctx, err, reporting.Failure{
+					JobId:   message.JobId.String(),
+					JobType: string(job.JobType),
+					Stage:   string(stage),
+					Output:  failureOutput(err),
+					Extra:   map[string]interface{}{"lesson_id": rotation.LessonId.String(), "mode": string(rotation.Mode)},
+				})
+				err = nil
+			} else {
+				if updateErr := s.jobs.UpdateStatusJob(ctx, constant.JobStatusPending, message.JobId); updateErr != nil {
+					zerolog.Ctx(ctx).Error().Err(updateErr).Msg("failed to update job status")
+				}
+			}
+		}
+	}()
+
+	if rotation.Mode == constant.KeyRotationModeRewrap {
+		err = s.rewrap(ctx, rotation)
+	} else {
+		err = s.rotate(ctx, job, rotation, &stage)
+	}
+	if err != nil {
+		return err
+	}
+	stage = constant.ErrorClassDatabase
+	return s.jobs.UpdateStatusJob(ctx, constant.JobStatusCompleted, message.JobId)
+}
+
+// rotate encrypts the lesson's package with the job's key into a version of
+// its own, switches the lesson to it and retires the keys it replaces.
+func (s *keyRotationService) rotate(ctx context.Context, job *entities.Job, rotation *entities.KeyRotation, stage *constant.ErrorClass) error {
+	playlist, err := s.repo.FindLessonVideo(ctx, rotation.LessonId)
+	if err != nil {
+		return err
+	}
+	if !isHLSSource(playlist) {
+		return errors.Join(ErrNonRetryable, ErrInvalidArgument, fmt.Errorf("lesson %s has no published video", rotation.LessonId))
+	}
+	key, raw, err := s.jobKey(ctx, job)
+	if err != nil {
+		return err
+	}
+
+	*stage = constant.ErrorClassPackage
+	rotated := path.Join(packagePrefix(playlist, job.ID), path.Base(playlist))
+	err = traceStage(ctx, "encrypt", func(ctx context.Context) error {
+		return s.encryptPackage(ctx, playlist, path.Dir(rotated), key, raw)
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to encrypt lesson package")
+		return err
+	}
+
+	*stage = constant.ErrorClassDatabase
+	if err := s.versions.Publish(ctx, job, rotated); err != nil {
+		return err
+	}
+	if err := s.repo.ActivateKey(ctx, key.ID); err != nil {
+		return err
+	}
+	retainUntil := time.Now().UTC().Add(time.Duration(s.cfg.Versions.Grace) * time.Second)
+	if err := s.repo.RetireKeys(ctx, rotation.LessonId, key.ID, retainUntil); err != nil {
+		return err
+	}
+	if err := s.repo.MarkRotated(ctx, rotation.ID, key.ID, rotated); err != nil {
+		return err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("job_id", job.ID.String()).
+		Str("lesson_id", rotation.LessonId.String()).
+		Str("key_id", key.ID.String()).
+		Str("playlist", rotated).
+		Msg("lesson content key rotated")
+	return nil
+}
+
+// rewrap wraps the lesson's keys that are still served with the current
+// master key.
+func (s *keyRotationService) rewrap(ctx context.Context, rotation *entities.KeyRotation) error {
+	keys, err := s.repo.ListLiveKeys(ctx, rotation.LessonId, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	rewrapped := 0
+	for _, key := range keys {
+		if key.MasterKeyId == s.cfg.Keys.MasterKeyId {
+			continue
+		}
+		raw, err := s.unwrap(key)
+		if err != nil {
+			return errors.Join(ErrNonRetryable, err)
+		}
+		wrapped, err := wrapKey(s.cfg.Keys.MasterKeys[s.cfg.Keys.MasterKeyId], raw)
+		if err != nil {
+			return err
+		}
+		if err := s.repo.UpdateWrappedKey(ctx, key.ID, wrapped, s.cfg.Keys.MasterKeyId); err != nil {
+			return err
+		}
+		rewrapped++
+	}
+	if err := s.repo.MarkRewrapped(ctx, rotation.ID, rewrapped); err != nil {
+		return err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("lesson_id", rotation.LessonId.String()).
+		Int("rewrapped", rewrapped).
+		Str("master_key_id", s.cfg.Keys.MasterKeyId).
+		Msg("lesson content keys rewrapped")
+	return nil
+}
+
+// jobKey returns the key the job encrypts with, made on its first delivery
+// so a retried job re-encrypts with the same key.
+func (s *keyRotationService) jobKey(ctx context.Context, job *entities.Job) (*entities.ContentKey, []byte, error) {
+	raw := make([]byte, aes.BlockSize)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := wrapKey(s.cfg.Keys.MasterKeys[s.cfg.Keys.MasterKeyId], raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = s.repo.CreateKey(ctx, &entities.ContentKey{
+		ID:          uuid.New(),
+		LessonId:    job.EntityId,
+		JobId:       job.ID,
+		WrappedKey:  wrapped,
+		MasterKeyId: s.cfg.Keys.MasterKeyId,
+		IV:          iv,
+		Status:      constant.ContentKeyStatusPending,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := s.repo.FindKeyByJob(ctx, job.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if raw, err = s.unwrap(key); err != nil {
+		return nil, nil, errors.Join(ErrNonRetryable, err)
+	}
+	return key, raw, nil
+}
+
+func (s *keyRotationService) unwrap(key *entities.ContentKey) ([]byte, error) {
+	master, ok := s.cfg.Keys.MasterKeys[key.MasterKeyId]
+	if !ok {
+		return nil, fmt.Errorf("content key %s is wrapped with unknown master key %q", key.ID, key.MasterKeyId)
+	}
+	return unwrapKey(master, key.WrappedKey)
+}
+
+// hlsSegmentKey is how a segment of the package being rotated is encrypted:
+// with Key and IV, or not at all when Key is nil.
+type hlsSegmentKey struct {
+	Key []byte
+	IV  []byte
+}
+
+// encryptPackage writes the package of playlist under prefix with its
+// segments encrypted by key: decrypted with the key they had, if any, and
+// encrypted anew. Each media playlist of MPEG-TS segments names the new key;
+// subtitle playlists and every other file are copied as they are.
+func (s *keyRotationService) encryptPackage(ctx context.Context, playlist, prefix string, key *entities.ContentKey, raw []byte) error {
+	from := path.Dir(playlist) + "/"
+	var objects []minio.ObjectInfo
+	for object := range s.store.ListObjects(ctx, s.cfg.MinIOBucket, minio.ListObjectsOptions{Prefix: from, Recursive: true}) {
+		if object.Err != nil {
+			return fmt.Errorf("list package: %w", object.Err)
+		}
+		objects = append(objects, object)
+	}
+
+	playlists := map[string][]byte{}
+	segments := map[string]hlsSegmentKey{}
+	keys := map[string][]byte{}
+	for _, object := range objects {
+		if !isHLSSource(object.Key) {
+			continue
+		}
+		lines, err := readObjectLines(ctx, s.store, s.cfg.MinIOBucket, object.Key)
+		if err != nil {
+			return fmt.Errorf("read playlist %s: %w", object.Key, err)
+		}
+		rekeyed, err := s.rekeyPlaylist(ctx, object.Key, lines, key, keys, segments)
+		if err != nil {
+			return err
+		}
+		if rekeyed != nil {
+			playlists[object.Key] = rekeyed
+		}
+	}
+
+	for _, object := range objects {
+		destination := path.Join(prefix, strings.TrimPrefix(object.Key, from))
+		if body, ok := playlists[object.Key]; ok {
+			_, err := s.store.PutObject(ctx, s.cfg.MinIOBucket, destination, bytes.NewReader(body), int64(len(body)),
+				minio.PutObjectOptions{ContentType: "application/vnd.apple.mpegurl"})
+			if err != nil {
+				return fmt.Errorf("upload playlist %s: %w", destination, err)
+			}
+			continue
+		}
+		if old, ok := segments[object.Key]; ok {
+			if err := s.encryptSegment(ctx, object.Key, destination, old, raw, key.IV); err != nil {
+				return err
+			}
+			continue
+		}
+		_, err := s.store.CopyObject(ctx,
+			minio.CopyDestOptions{Bucket: s.cfg.MinIOBucket, Object: destination},
+			minio.CopySrcOptions{Bucket: s.cfg.MinIOBucket, Object: object.Key})
+		if err != nil {
+			return fmt.Errorf("copy %s: %w", object.Key, err)
+		}
+	}
+	return nil
+}
+
+// rekeyPlaylist rewrites a media playlist of MPEG-TS segments to name key,
+// recording in segments how each of its segments is encrypted now. It
+// returns nil for a master or subtitle playlist, which stays as it is. A
+// playlist addressing its segments by byte range, as a low-latency package
+// does, can't be encrypted whole-segment.
+func (s *keyRotationService) rekeyPlaylist(ctx context.Context, playlistKey string, lines []string, key *entities.ContentKey, keys map[string][]byte, segments map[string]hlsSegmentKey) ([]byte, error) {
+	isMedia := slices.ContainsFunc(lines, func(line string) bool {
+		return line != "" && !strings.HasPrefix(line, "#") && strings.HasSuffix(line, ".ts")
+	})
+	if !isMedia {
+		return nil, nil
+	}
+
+	var (
+		b        strings.Builder
+		current  hlsSegmentKey
+		explicit bool
+		sequence int
+		keyed    bool
+	)
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-BYTERANGE"), strings.HasPrefix(line, "#EXT-X-PART"), strings.HasPrefix(line, "#EXT-X-MAP:"):
+			return nil, errors.Join(ErrNonRetryable, ErrInvalidArgument, fmt.Errorf("playlist %s addresses segments by byte range or init section, which can't be encrypted whole-segment", playlistKey))
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			sequence, _ = strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"))
+		case strings.HasPrefix(line, "#EXT-X-KEY:"):
+			var err error
+			if current, explicit, err = s.segmentKey(ctx, line, keys); err != nil {
+				return nil, fmt.Errorf("playlist %s: %w", playlistKey, err)
+			}
+			continue
+		case strings.HasPrefix(line, "#EXTINF:") && !keyed:
+			fmt.Fprintf(&b, "#EXT-X-KEY:METHOD=AES-128,URI=\"%s/%s\",IV=0x%s\n", s.cfg.Keys.URL, key.ID, hex.EncodeToString(key.IV))
+			keyed = true
+		case line != "" && !strings.HasPrefix(line, "#"):
+			segment := current
+			if segment.Key != nil && !explicit {
+				segment.IV = sequenceIV(sequence)
+			}
+			segments[path.Join(path.Dir(playlistKey), line)] = segment
+			sequence++
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	return []byte(b.String()), nil
+}
+
+// segmentKey reads an EXT-X-KEY tag of the package being rotated. It reports
+// whether the tag sets the IV; otherwise each segment's is its sequence
+// number.
+func (s *keyRotationService) segmentKey(ctx context.Context, tag string, keys map[string][]byte) (hlsSegmentKey, bool, error) {
+	method := ""
+	if match := methodPattern.FindStringSubmatch(tag); match != nil {
+		method = match[1]
+	}
+	switch method {
+	case "NONE":
+		return hlsSegmentKey{}, false, nil
+	case "AES-128":
+	default:
+		return hlsSegmentKey{}, false, errors.Join(ErrNonRetryable, ErrInvalidArgument, fmt.Errorf("key method %q can't be rotated", method))
+	}
+
+	match := uriPattern.FindStringSubmatch(tag)
+	if match == nil {
+		return hlsSegmentKey{}, false, errors.Join(ErrNonRetryable, errors.New("AES-128 key has no URI"))
+	}
+	raw, ok := keys[match[1]]
+	if !ok {
+		id, err := uuid.Parse(path.Base(match[1]))
+		if err != nil {
+			return hlsSegmentKey{}, false, errors.Join(ErrNonRetryable, fmt.Errorf("key %s is not a content key", match[1]))
+		}
+		key, err := s.repo.FindKey(ctx, id)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return hlsSegmentKey{}, false, errors.Join(ErrNonRetryable, fmt.Errorf("content key %s: %w", id, err))
+		}
+		if err != nil {
+			return hlsSegmentKey{}, false, err
+		}
+		if raw, err = s.unwrap(key); err != nil {
+			return hlsSegmentKey{}, false, errors.Join(ErrNonRetryable, err)
+		}
+		keys[match[1]] = raw
+	}
+
+	if match := ivPattern.FindStringSubmatch(tag); match != nil {
+		iv, err := hex.DecodeString(strings.Repeat("0", max(2*aes.BlockSize-len(match[1]), 0)) + match[1])
+		if err != nil || len(iv) != aes.BlockSize {
+			return hlsSegmentKey{}, false, errors.Join(ErrNonRetryable, fmt.Errorf("key IV 0x%s is not 16 bytes", match[1]))
+		}
+		return hlsSegmentKey{Key: raw, IV: iv}, true, nil
+	}
+	return hlsSegmentKey{Key: raw}, false, nil
+}
+
+// encryptSegment re-encrypts a segment from source into destination.
+func (s *keyRotationService) encryptSegment(ctx context.Context, source, destination string, old hlsSegmentKey, key, iv []byte) error {
+	object, err := s.store.GetObject(ctx, s.cfg.MinIOBucket, source, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(object)
+	object.Close()
+	if err != nil {
+		return fmt.Errorf("download segment %s: %w", source, err)
+	}
+	if old.Key != nil {
+		if data, err = decryptAES128(old.Key, old.IV, data); err != nil {
+			return errors.Join(ErrNonRetryable, fmt.Errorf("decrypt segment %s: %w", source, err))
+		}
+	}
+	encrypted, err := encryptAES128(key, iv, data)
+	if err != nil {
+		return err
+	}
+	_, err = s.store.PutObject(ctx, s.cfg.MinIOBucket, destination, bytes.NewReader(encrypted), int64(len(encrypted)),
+		minio.PutObjectOptions{ContentType: "video/mp2t"})
+	if err != nil {
+		return fmt.Errorf("upload segment %s: %w", destination, err)
+	}
+	return nil
+}
+
+// sequenceIV is the IV of a segment whose key tag sets none: its media
+// sequence number, big-endian.
+func sequenceIV(sequence int) []byte {
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], uint64(sequence))
+	return iv
+}
+
+// encryptAES128 encrypts a segment as HLS's AES-128 method does: AES-128-CBC
+// with PKCS#7 padding.
+func encryptAES128(key, iv, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	padded := append(slices.Clip(data), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(padded, padded)
+	return padded, nil
+}
+
+func decryptAES128(key, iv, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("ciphertext is not a whole number of blocks")
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > aes.BlockSize || !bytes.Equal(plain[len(plain)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errors.New("bad padding")
+	}
+	return plain[:len(plain)-padding], nil
+}
+
+// wrapKey seals a content key with a master key, AES-256-GCM with the nonce
+// first.
+func wrapKey(master, key []byte) ([]byte, error) {
+	block, err := aes.NewCipher(master)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, key, nil), nil
+}
+
+func unwrapKey(master, wrapped []byte) ([]byte, error) {
+	block, err := aes.NewCipher(master)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, errors.New("wrapped key is too short")
+	}
+	return gcm.Open(nil, wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():], nil)
+}
+
+func NewKeyRotationService(repo repository.ContentKeyRepository, jobs repository.JobRepository, events repository.JobEventRepository, versions VideoVersionService, publisher rabbitmq.Publisher, store objectstore.Store, cfg *config.Config) KeyRotationService {
+	return &keyRotationService{
+		repo:      repo,
+		jobs:      jobs,
+		events:    events,
+		versions:  versions,
+		publisher: publisher,
+		store:     store,
+		cfg:       cfg,
+	}
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"worker-transcode/entities"
+)
+
+// capLadder drops the rungs of preset that would be upscaled from source:
+// those whose box fits the source's frame only by enlarging it. The lowest
+// rung, the first, is always kept so there is something to play. It returns the preset
+// to encode with and the rungs it skipped; preset itself is not changed.
+func capLadder(preset *entities.Preset, source *MediaInfo) (*entities.Preset, entities.Renditions) {
+	if preset.AllowUpscale || len(preset.Renditions) == 0 {
+		return preset, nil
+	}
+	video := source.VideoStream()
+	if video == nil || video.Width <= 0 || video.Height <= 0 {
+		return preset, nil
+	}
+
+	var kept, skipped entities.Renditions
+	for i, r := range preset.Renditions {
+		if i > 0 && upscales(r, video.Width, video.Height) {
+			skipped = append(skipped, r)
+			continue
+		}
+		kept = append(kept, r)
+	}
+	if len(skipped) == 0 {
+		return preset, nil
+	}
+	capped := *preset
+	capped.Renditions = kept
+	return &capped, skipped
+}
+
+// upscales reports whether scaling a width x height frame to fit r, as
+// hlsArgs does, enlarges it: the frame is smaller than r both ways.
+func upscales(r entities.Rendition, width, height int) bool {
+	return r.Width > width && r.Height > height
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+	"worker-transcode/pkg/metrics"
+	"worker-transcode/repository"
+
+	"github.com/rs/zerolog"
+)
+
+// leaderKey names the advisory lock held by the replica that runs scheduled
+// maintenance.
+const leaderKey = "worker-transcode:leader"
+
+const leaderCheckInterval = 15 * time.Second
+
+// RunAsLeader campaigns for leadership until ctx is done. While this replica
+// holds the lock each task runs in its own goroutine with a context that is
+// cancelled when leadership is lost, so with N replicas the tasks still run
+// once. Leadership is lost with the lock's database connection.
+func RunAsLeader(ctx context.Context, locks repository.LockRepository, tasks ...func(ctx context.Context)) {
+	ticker := time.NewTicker(leaderCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		lock, err := locks.TryLock(ctx, leaderKey)
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to campaign for leadership")
+		}
+		if lock != nil {
+			lead(ctx, lock, tasks)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// lead runs tasks until the lock fails its check or ctx is done, then waits
+// for them to return before giving the lock up.
+func lead(ctx context.Context, lock *repository.SessionLock, tasks []func(ctx context.Context)) {
+	zerolog.Ctx(ctx).Info().Msg("elected leader, running scheduled tasks")
+	metrics.Leader.Set(1)
+	defer metrics.Leader.Set(0)
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	for _, task := range tasks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			task(leaderCtx)
+		}()
+	}
+
+	ticker := time.NewTicker(leaderCheckInterval)
+	defer ticker.Stop()
+	for leaderCtx.Err() == nil {
+		select {
+		case <-leaderCtx.Done():
+		case <-ticker.C:
+			if err := lock.Check(leaderCtx); err != nil && leaderCtx.Err() == nil {
+				zerolog.Ctx(ctx).Warn().Err(err).Msg("lost leadership")
+				cancel()
+			}
+		}
+	}
+	cancel()
+	wg.Wait()
+	lock.Release(ctx)
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/library"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+)
+
+// LibraryService moves tenants' videos hosted on Vimeo or YouTube onto the
+// platform. Each video mapped to a lesson is downloaded at the best quality
+// the provider offers into the lesson's prefix, then transcoded like an
+// upload.
+type LibraryService interface {
+	// Videos lists the library, for building an import's mappings.
+	Videos(ctx context.Context, request dto.LibraryRequest) ([]library.Video, error)
+	// Claim checks the import and claims its videos for their lessons,
+	// leaving out those already imported into the lesson or being imported.
+	Claim(ctx context.Context, tenantId uuid.UUID, request dto.LibraryImportRequest) ([]*entities.LibraryImport, error)
+	// Run imports the claimed videos one at a time, returning once each is
+	// queued or failed.
+	Run(ctx context.Context, request dto.LibraryImportRequest, imports []*entities.LibraryImport)
+	Imports(ctx context.Context, tenantId uuid.UUID) ([]*entities.LibraryImport, error)
+}
+
+type libraryService struct {
+	repo      repository.LibraryImportRepository
+	jobs      repository.JobRepository
+	presets   PresetService
+	publisher rabbitmq.Publisher
+	store     objectstore.Store
+	cfg       *config.Config
+}
+
+func (s *libraryService) Videos(ctx context.Context, request dto.LibraryRequest) ([]library.Video, error) {
+	videos, err := s.library(request)
+	if err != nil {
+		return nil, err
+	}
+	return videos.Videos(ctx)
+}
+
+func (s *libraryService) Claim(ctx context.Context, tenantId uuid.UUID, request dto.LibraryImportRequest) ([]*entities.LibraryImport, error) {
+	if len(request.Mappings) == 0 {
+		return nil, errors.Join(ErrInvalidArgument, errors.New("mappings must not be empty"))
+	}
+	for _, mapping := range request.Mappings {
+		if strings.TrimSpace(mapping.VideoId) == "" || mapping.LessonId == uuid.Nil {
+			return nil, errors.Join(ErrInvalidArgument, errors.New("every mapping needs a video_id and a lesson_id"))
+		}
+	}
+	preset := libraryPreset(request)
+	if _, err := s.presets.Resolve(ctx, preset); err != nil {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("preset %s: %w", preset, err))
+	}
+	videos, err := s.Videos(ctx, request.LibraryRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := make([]*entities.LibraryImport, 0, len(request.Mappings))
+	for _, mapping := range request.Mappings {
+		i := slices.IndexFunc(videos, func(video library.Video) bool { return video.Id == mapping.VideoId })
+		if i < 0 {
+			return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("video %s isn't in the %s library", mapping.VideoId, request.Provider))
+		}
+		claims = append(claims, &entities.LibraryImport{
+			Provider: request.Provider,
+			VideoId:  mapping.VideoId,
+			LessonId: mapping.LessonId,
+			TenantId: tenantId,
+			Title:    videos[i].Title,
+			Status:   constant.LibraryImportStatusPending,
+		})
+	}
+
+	var claimed []*entities.LibraryImport
+	for _, claim := range claims {
+		ok, err := s.repo.ClaimLibraryImport(ctx, claim)
+		if err != nil {
+			return claimed, err
+		}
+		if ok {
+			claimed = append(claimed, claim)
+		}
+	}
+	return claimed, nil
+}
+
+func (s *libraryService) Run(ctx context.Context, request dto.LibraryImportRequest, imports []*entities.LibraryImport) {
+	if len(imports) == 0 {
+		return
+	}
+	fail := func(ctx context.Context, claim *entities.LibraryImport, err error) {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to import library video")
+		if finishErr := s.repo.FinishLibraryImport(context.WithoutCancel(ctx), claim, nil, err.Error()); finishErr != nil {
+			zerolog.Ctx(ctx).Error().Err(finishErr).Msg("failed to record library import")
+		}
+	}
+	// The videos are listed again for their download links, which the
+	// provider only keeps valid for a while.
+	videos, err := s.Videos(ctx, request.LibraryRequest)
+	for _, claim := range imports {
+		ctx := zerolog.Ctx(ctx).With().
+			Str("provider", claim.Provider).
+			Str("video_id", claim.VideoId).
+			Str("lesson_id", claim.LessonId.String()).
+			Logger().WithContext(ctx)
+		if err != nil {
+			fail(ctx, claim, err)
+			continue
+		}
+		i := slices.IndexFunc(videos, func(video library.Video) bool { return video.Id == claim.VideoId })
+		if i < 0 {
+			fail(ctx, claim, errors.New("video is no longer in the library"))
+			continue
+		}
+		job, importErr := s.importVideo(ctx, request, claim, videos[i])
+		if importErr != nil {
+			fail(ctx, claim, importErr)
+			continue
+		}
+		if finishErr := s.repo.FinishLibraryImport(ctx, claim, &job.ID, ""); finishErr != nil {
+			zerolog.Ctx(ctx).Error().Err(finishErr).Msg("failed to record library import")
+		}
+		zerolog.Ctx(ctx).Info().Str("job_id", job.ID.String()).Msg("library video queued for transcoding")
+	}
+}
+
+// importVideo downloads the video into the lesson's prefix and queues its
+// transcode.
+func (s *libraryService) importVideo(ctx context.Context, request dto.LibraryImportRequest, claim *entities.LibraryImport, video library.Video) (*entities.Job, error) {
+	videos, err := s.library(request.LibraryRequest)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join("temp", "library", uuid.NewString())
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	file, err := videos.Fetch(ctx, video, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	fileName := fmt.Sprintf("%s-%s.mp4", claim.Provider, claim.VideoId)
+	objectPath := fmt.Sprintf("lessons/%s/videos/%d-%s", claim.LessonId, time.Now().UnixMilli(), fileName)
+	if _, err := s.store.FPutObject(ctx, s.cfg.MinIOBucket, objectPath, file, minio.PutObjectOptions{ContentType: "video/mp4"}); err != nil {
+		return nil, err
+	}
+	return queueTranscode(ctx, s.jobs, s.publisher, s.store, s.cfg, uuid.New(), claim.LessonId, constant.ParseSLAClass(s.cfg.Library.SLAClass), dto.JobMessage{
+		ObjectPath: objectPath,
+		FileName:   fileName,
+		Preset:     libraryPreset(request),
+	})
+}
+
+func libraryPreset(request dto.LibraryImportRequest) string {
+	if request.Preset == "" {
+		return DefaultPresetName
+	}
+	return request.Preset
+}
+
+func (s *libraryService) library(request dto.LibraryRequest) (library.Library, error) {
+	if !slices.Contains(library.Providers, request.Provider) {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("provider must be one of %s", strings.Join(library.Providers, ", ")))
+	}
+	videos, err := library.New(request.Provider, library.Credentials{
+		Token:     strings.TrimSpace(request.Token),
+		APIKey:    strings.TrimSpace(request.APIKey),
+		ChannelId: strings.TrimSpace(request.ChannelId),
+	})
+	if err != nil {
+		return nil, errors.Join(ErrInvalidArgument, err)
+	}
+	return videos, nil
+}
+
+func (s *libraryService) Imports(ctx context.Context, tenantId uuid.UUID) ([]*entities.LibraryImport, error) {
+	return s.repo.ListLibraryImports(ctx, tenantId)
+}
+
+func NewLibraryService(repo repository.LibraryImportRepository, jobs repository.JobRepository, presets PresetService, publisher rabbitmq.Publisher, store objectstore.Store, cfg *config.Config) LibraryService {
+	return &libraryService{
+		repo:      repo,
+		jobs:      jobs,
+		presets:   presets,
+		publisher: publisher,
+		store:     store,
+		cfg:       cfg,
+	}
+}
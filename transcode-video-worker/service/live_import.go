@@ -0,0 +1,321 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/correlation"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/pkg/reporting"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// liveImportSchemes are the protocols a recording is pulled over: the
+// origin's HTTP server for DVR files and HLS, or RTMP.
+var liveImportSchemes = []string{"http", "https", "rtmp", "rtmps"}
+
+// LiveImportService turns finished live classes into on-demand lessons. The
+// recording is pulled from the streaming origin and normalized into an MP4,
+// as live recordings have timestamp gaps and mid-stream changes an encode of
+// a regular upload doesn't expect, then becomes the lesson's source,
+// transcoded like an upload.
+type LiveImportService interface {
+	// Request queues the import of a recording as the lesson's video.
+	Request(ctx context.Context, lessonId uuid.UUID, request dto.LiveImportRequest) (*entities.LiveImport, error)
+	Find(ctx context.Context, id uuid.UUID) (*entities.LiveImport, error)
+	// Process runs a live import job.
+	Process(ctx context.Context, message dto.LiveImportMessage) error
+}
+
+type liveImportService struct {
+	repo      repository.LiveImportRepository
+	jobs      repository.JobRepository
+	events    repository.JobEventRepository
+	publisher rabbitmq.Publisher
+	store     objectstore.Store
+	cfg       *config.Config
+}
+
+func (s *liveImportService) Request(ctx context.Context, lessonId uuid.UUID, request dto.LiveImportRequest) (*entities.LiveImport, error) {
+	if err := validateLiveImport(request, s.cfg.Live.OriginHosts); err != nil {
+		return nil, errors.Join(ErrInvalidArgument, err)
+	}
+
+	job := &entities.Job{
+		ID:         uuid.New(),
+		EntityId:   lessonId,
+		EntityType: string(constant.EntityTypeLessonVideo),
+		Status:     constant.JobStatusPending,
+		JobType:    constant.JobTypeLiveImport,
+		UserId:     request.UserId,
+	}
+	if correlationId := correlation.FromContext(ctx); correlationId != "" {
+		job.CorrelationId = &correlationId
+	}
+	liveImport := &entities.LiveImport{
+		ID:            uuid.New(),
+		LessonId:      lessonId,
+		JobId:         job.ID,
+		LiveSessionId: request.LiveSessionId,
+		SourceURL:     request.SourceURL,
+		LowLatency:    request.LowLatency,
+	}
+	if request.Preset != "" {
+		liveImport.Preset = &request.Preset
+	}
+
+	if err := s.jobs.CreateJob(ctx, job); err != nil {
+		return nil, err
+	}
+	if err := s.repo.CreateLiveImport(ctx, liveImport); err != nil {
+		return nil, err
+	}
+	message := dto.LiveImportMessage{JobId: job.ID}
+	if err := s.publisher.Publish(ctx, rabbitmq.LiveImportTopology.Exchange, rabbitmq.LiveImportTopology.RoutingKey, message); err != nil {
+		return nil, err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("job_id", job.ID.String()).
+		Str("lesson_id", lessonId.String()).
+		Msg("live recording import queued")
+	return liveImport, nil
+}
+
+func (s *liveImportService) Find(ctx context.Context, id uuid.UUID) (*entities.LiveImport, error) {
+	liveImport, err := s.repo.FindLiveImport(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	return liveImport, err
+}
+
+func (s *liveImportService) Process(ctx context.Context, message dto.LiveImportMessage) (err error) {
+	job, err := s.jobs.FindJobById(ctx, message.JobId)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to find job by id")
+		return err
+	}
+	if job.Status != constant.JobStatusPending {
+		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("job is not pending")
+		return nil
+	}
+	liveImport, err := s.repo.FindLiveImportByJob(ctx, message.JobId)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to find live import")
+		return err
+	}
+
+	claimed, err := s.jobs.ClaimJob(ctx, message.JobId, correlation.FromContext(ctx), workerFromContext(ctx))
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to update job status")
+		return err
+	}
+	if !claimed {
+		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("job already claimed by another delivery")
+		return nil
+	}
+	rabbitmq.Claimed(ctx)
+	ctx = withTimeline(ctx, s.events, job.ID)
+	recordStatus(ctx, constant.JobStatusPending, constant.JobStatusProcessing)
+
+	stage := constant.ErrorClassWorkspace
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = panicked(ctx, recovered)
+		}
+		if shuttingDown(ctx, err) {
+			err = handOff(ctx, s.jobs, message.JobId, err)
+			return
+		}
+		err = classify(err)
+		recordOutcome(ctx, job, stage, err)
+		recordOutcomeEvents(ctx, stage, err)
+		if err != nil {
+			if errors.Is(err, ErrNonRetryable) {
+				if updateErr := s.jobs.FailJob(ctx, message.JobId, stage, errorKind(err), err.Error()); updateErr != nil {
+					zerolog.Ctx(ctx).Error().Err(updateErr).Msg("failed to update job status")
+				}
+				reporting.CaptureFailure(ctx, err, reporting.Failure{
+					JobId:   message.JobId.String(),
+					JobType: string(job.JobType),
+					Stage:   string(stage),
+					Output:  failureOutput(err),
+					Extra:   map[string]interface{}{"lesson_id": liveImport.LessonId.String(), "source_url": liveImport.SourceURL},
+				})
+				err = nil
+			} else {
+				if updateErr := s.jobs.UpdateStatusJob(ctx, constant.JobStatusPending, message.JobId); updateErr != nil {
+					zerolog.Ctx(ctx).Error().Err(updateErr).Msg("failed to update job status")
+				}
+			}
+		}
+	}()
+
+	// The origin may have been reconfigured since the import was queued.
+	if err = validateLiveImport(dto.LiveImportRequest{SourceURL: liveImport.SourceURL}, s.cfg.Live.OriginHosts); err != nil {
+		return errors.Join(ErrNonRetryable, ErrInvalidArgument, err)
+	}
+
+	tempDir := filepath.Join("temp", message.JobId.String())
+	defer os.RemoveAll(tempDir)
+	if err = os.MkdirAll(tempDir, os.ModePerm); err != nil {
+		return errors.Join(ErrNonRetryable, err)
+	}
+
+	// A recording the origin can't serve yet, or at all, is tried again
+	// until the job's retries run out.
+	stage = constant.ErrorClassDownload
+	var recording *MediaInfo
+	err = traceStage(ctx, "probe_recording", func(ctx context.Context) error {
+		var probeErr error
+		recording, probeErr = ProbeMedia(ctx, liveImport.SourceURL)
+		return probeErr
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to probe live recording")
+		return err
+	}
+	if recording.VideoStream() == nil {
+		return classified(constant.ErrorKindSourceCorrupt, errors.New("live recording has no video stream"))
+	}
+
+	stage = constant.ErrorClassTranscode
+	output := filepath.Join(tempDir, "live.mp4")
+	err = traceStage(ctx, "normalize", func(ctx context.Context) error {
+		pullCtx, cancel := context.WithTimeout(ctx, time.Duration(s.cfg.Live.Timeout)*time.Second)
+		defer cancel()
+		pullErr := runFFmpeg(pullCtx, liveNormalizeArgs(liveImport.SourceURL, output, s.cfg.Server.FFmpegThreads),
+			progressReporter(ctx, s.jobs, message.JobId, recording.DurationSeconds()))
+		if pullErr != nil && errors.Is(pullCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+			return classified(constant.ErrorKindTimeout, fmt.Errorf("live recording not pulled within %ds: %w", s.cfg.Live.Timeout, pullErr))
+		}
+		return pullErr
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to normalize live recording")
+		return err
+	}
+
+	stage = constant.ErrorClassProbe
+	normalized, err := ProbeMedia(ctx, output)
+	if err != nil {
+		return classified(constant.ErrorKindSourceCorrupt, err)
+	}
+	duration := normalized.DurationSeconds()
+
+	stage = constant.ErrorClassUpload
+	fileName := fmt.Sprintf("live-%s.mp4", liveImport.ID)
+	objectPath := fmt.Sprintf("lessons/%s/videos/%d-%s", liveImport.LessonId, time.Now().UnixMilli(), fileName)
+	err = traceStage(ctx, "upload", func(ctx context.Context) error {
+		_, uploadErr := s.store.FPutObject(ctx, s.cfg.MinIOBucket, objectPath, output, minio.PutObjectOptions{ContentType: "video/mp4"})
+		return uploadErr
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to upload live recording")
+		return err
+	}
+
+	stage = constant.ErrorClassDatabase
+	transcodeMessage := dto.JobMessage{
+		ObjectPath: objectPath,
+		FileName:   fileName,
+		LowLatency: liveImport.LowLatency,
+	}
+	if liveImport.Preset != nil {
+		transcodeMessage.Preset = *liveImport.Preset
+	}
+	transcode, err := queueTranscode(ctx, s.jobs, s.publisher, s.store, s.cfg, uuid.New(), liveImport.LessonId, jobSLAClass(job.SLAClass), transcodeMessage)
+	if err != nil {
+		return err
+	}
+	if err = s.repo.MarkLiveImported(ctx, liveImport.ID, objectPath, duration, transcode.ID); err != nil {
+		return err
+	}
+	if err = s.jobs.UpdateStatusJob(ctx, constant.JobStatusCompleted, message.JobId); err != nil {
+		return err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("job_id", message.JobId.String()).
+		Str("lesson_id", liveImport.LessonId.String()).
+		Str("transcode_job_id", transcode.ID.String()).
+		Float64("duration", duration).
+		Msg("live recording imported and queued for transcoding")
+	return nil
+}
+
+// validateLiveImport checks the recording is on one of the live origins: the
+// worker pulls it from inside the cluster.
+func validateLiveImport(request dto.LiveImportRequest, origins []string) error {
+	source, err := url.Parse(request.SourceURL)
+	if err != nil || request.SourceURL == "" {
+		return fmt.Errorf("source_url %q is not a url", request.SourceURL)
+	}
+	if !slices.Contains(liveImportSchemes, source.Scheme) {
+		return fmt.Errorf("source_url %q is not one of %v", request.SourceURL, liveImportSchemes)
+	}
+	if !hostAllowed(source.Hostname(), origins) {
+		return fmt.Errorf("source_url host %q is not a live origin", source.Hostname())
+	}
+	return nil
+}
+
+// liveNormalizeArgs pull a recording into an MP4 with steady timestamps: the
+// video at a constant frame rate, and the audio resampled over the gaps a
+// dropped connection leaves. It is encoded close to lossless, as it is
+// transcoded again. An HTTP source is reconnected to when the origin drops
+// the connection mid-pull.
+func liveNormalizeArgs(source, output string, threads int) []string {
+	var args []string
+	if parsed, err := url.Parse(source); err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") {
+		args = append(args, "-reconnect", "1", "-reconnect_streamed", "1", "-reconnect_delay_max", "30")
+	}
+	args = append(args,
+		"-fflags", "+genpts+discardcorrupt",
+		"-i", source,
+		"-map", "0:v:0",
+		"-map", "0:a:0?",
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-crf", "18",
+		"-pix_fmt", "yuv420p",
+		"-vsync", "cfr",
+		"-c:a", "aac",
+		"-b:a", "192k",
+		"-ar", "48000",
+		"-af", "aresample=async=1:first_pts=0",
+		"-movflags", "+faststart",
+	)
+	if threads > 0 {
+		args = append(args, "-threads", strconv.Itoa(threads))
+	}
+	return append(args, "-y", output)
+}
+
+func NewLiveImportService(repo repository.LiveImportRepository, jobs repository.JobRepository, events repository.JobEventRepository, publisher rabbitmq.Publisher, store objectstore.Store, cfg *config.Config) LiveImportService {
+	return &liveImportService{
+		repo:      repo,
+		jobs:      jobs,
+		events:    events,
+		publisher: publisher,
+		store:     store,
+		cfg:       cfg,
+	}
+}
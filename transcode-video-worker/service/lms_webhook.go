@@ -0,0 +1,340 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// lmsSignatureTolerance is how far a webhook's timestamp may be from now, so
+// a captured request can't be replayed later.
+const lmsSignatureTolerance = 5 * time.Minute
+
+// LMSWebhookService turns events from tenants' learning management systems
+// into transcode jobs, so partners integrate over HTTP rather than the
+// broker. Each tenant's rules say which events carry a video and where in
+// them its URL and lesson are.
+type LMSWebhookService interface {
+	// Webhook handles an event the tenant's LMS sent, signed with HMAC-SHA256
+	// over "<timestamp>.<body>". The media is fetched and queued in the
+	// background; the job id is returned at once.
+	Webhook(ctx context.Context, tenantId uuid.UUID, timestamp, signature string, body []byte) (*dto.LMSWebhookResult, error)
+	Get(ctx context.Context, tenantId uuid.UUID) (*entities.LMSWebhook, error)
+	Save(ctx context.Context, tenantId uuid.UUID, request dto.LMSWebhookRequest) (*entities.LMSWebhook, error)
+}
+
+type lmsWebhookService struct {
+	repo      repository.LMSWebhookRepository
+	jobs      repository.JobRepository
+	presets   PresetService
+	publisher rabbitmq.Publisher
+	store     objectstore.Store
+	cfg       *config.Config
+}
+
+func (s *lmsWebhookService) Webhook(ctx context.Context, tenantId uuid.UUID, timestamp, signature string, body []byte) (*dto.LMSWebhookResult, error) {
+	webhook, err := s.repo.FindLMSWebhook(ctx, tenantId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyLMSSignature(webhook.Secret, timestamp, signature, body, time.Now()); err != nil {
+		return nil, errors.Join(ErrInvalidArgument, err)
+	}
+
+	var event interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&event); err != nil {
+		return nil, errors.Join(ErrInvalidArgument, err)
+	}
+	rule := matchLMSRule(webhook.Rules, event)
+	if rule == nil {
+		return &dto.LMSWebhookResult{Status: "ignored"}, nil
+	}
+
+	mediaURL, _ := lookupField(event, rule.MediaURLField)
+	source, err := s.allowedMedia(webhook, mediaURL)
+	if err != nil {
+		return nil, errors.Join(ErrInvalidArgument, err)
+	}
+	lesson, _ := lookupField(event, rule.LessonField)
+	lessonId, err := uuid.Parse(lesson)
+	if err != nil {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("%s: lesson id %q: %w", rule.LessonField, lesson, err))
+	}
+
+	// A redelivered event maps to the job its first delivery queued.
+	jobId := uuid.New()
+	if rule.DeliveryField != "" {
+		delivery, ok := lookupField(event, rule.DeliveryField)
+		if !ok || delivery == "" {
+			return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("%s: no delivery id", rule.DeliveryField))
+		}
+		jobId = uuid.NewSHA1(tenantId, []byte(delivery))
+		if _, err := s.jobs.FindJobById(ctx, jobId); err == nil {
+			return &dto.LMSWebhookResult{Status: "duplicate", JobId: &jobId}, nil
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	ctx = zerolog.Ctx(ctx).With().
+		Str("tenant_id", tenantId.String()).
+		Str("lesson_id", lessonId.String()).
+		Str("job_id", jobId.String()).
+		Str("event", rule.Event).
+		Logger().WithContext(context.WithoutCancel(ctx))
+	go s.importMedia(ctx, webhook, source, lessonId, jobId, rule.Preset)
+	return &dto.LMSWebhookResult{Status: "accepted", JobId: &jobId}, nil
+}
+
+// importMedia copies the event's media into the lesson and queues its
+// transcode. A failure is only logged; the LMS sees the job never appear
+// and can send the event again.
+func (s *lmsWebhookService) importMedia(ctx context.Context, webhook *entities.LMSWebhook, source *url.URL, lessonId, jobId uuid.UUID, preset string) {
+	fileName := unsafeFileNameChars.ReplaceAllString(path.Base(source.Path), "_")
+	if fileName == "" || fileName == "_" || fileName == "." {
+		fileName = "lms-media.mp4"
+	}
+	objectPath := fmt.Sprintf("lessons/%s/videos/%d-%s", lessonId, time.Now().UnixMilli(), fileName)
+	if err := s.fetch(ctx, webhook, source, objectPath); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Str("host", source.Host).Msg("failed to fetch lms media")
+		return
+	}
+	_, err := queueTranscode(ctx, s.jobs, s.publisher, s.store, s.cfg, jobId, lessonId, constant.ParseSLAClass(s.cfg.LMS.SLAClass), dto.JobMessage{
+		ObjectPath: objectPath,
+		FileName:   fileName,
+		Preset:     preset,
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Str("object_path", objectPath).Msg("failed to queue lms media")
+		return
+	}
+	zerolog.Ctx(ctx).Info().Str("object_path", objectPath).Msg("lms media queued for transcoding")
+}
+
+func (s *lmsWebhookService) fetch(ctx context.Context, webhook *entities.LMSWebhook, source *url.URL, objectPath string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.cfg.LMS.DownloadTimeout)*time.Second)
+	defer cancel()
+	client := &http.Client{
+		// A redirect leaves the allowed hosts as easily as the URL itself.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			_, err := s.allowedMedia(webhook, req.URL.String())
+			return err
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("media responded %s", resp.Status)
+	}
+	if resp.ContentLength > s.cfg.Server.MaxUploadSize {
+		return fmt.Errorf("media is %d bytes, more than the %d an upload may be", resp.ContentLength, s.cfg.Server.MaxUploadSize)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	_, err = s.store.PutObject(ctx, s.cfg.MinIOBucket, objectPath, resp.Body, resp.ContentLength, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+// allowedMedia parses an event's media URL, which must be HTTPS on one of
+// the tenant's allowed hosts: the worker fetches it from inside the cluster.
+func (s *lmsWebhookService) allowedMedia(webhook *entities.LMSWebhook, raw string) (*url.URL, error) {
+	source, err := url.Parse(raw)
+	if err != nil || raw == "" {
+		return nil, fmt.Errorf("media url %q is not a url", raw)
+	}
+	if source.Scheme != "https" {
+		return nil, fmt.Errorf("media url %q is not https", raw)
+	}
+	if !hostAllowed(source.Hostname(), webhook.AllowedHosts) {
+		return nil, fmt.Errorf("media host %q is not allowed", source.Hostname())
+	}
+	return source, nil
+}
+
+// hostAllowed reports whether host is one of allowed, or under one of them
+// that starts with a dot.
+func hostAllowed(host string, allowed []string) bool {
+	host = strings.ToLower(host)
+	for _, entry := range allowed {
+		entry = strings.ToLower(entry)
+		if host == entry || (strings.HasPrefix(entry, ".") && strings.HasSuffix(host, entry)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *lmsWebhookService) Get(ctx context.Context, tenantId uuid.UUID) (*entities.LMSWebhook, error) {
+	webhook, err := s.repo.FindLMSWebhook(ctx, tenantId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	return webhook, err
+}
+
+func (s *lmsWebhookService) Save(ctx context.Context, tenantId uuid.UUID, request dto.LMSWebhookRequest) (*entities.LMSWebhook, error) {
+	webhook := &entities.LMSWebhook{
+		TenantId: tenantId,
+		Secret:   strings.TrimSpace(request.Secret),
+		Rules:    request.Rules,
+	}
+	for _, host := range request.AllowedHosts {
+		if host = strings.TrimSpace(host); host != "" {
+			webhook.AllowedHosts = append(webhook.AllowedHosts, host)
+		}
+	}
+	if err := s.validate(ctx, webhook); err != nil {
+		return nil, errors.Join(ErrInvalidArgument, err)
+	}
+	if err := s.repo.SaveLMSWebhook(ctx, webhook); err != nil {
+		return nil, err
+	}
+	return s.repo.FindLMSWebhook(ctx, tenantId)
+}
+
+func (s *lmsWebhookService) validate(ctx context.Context, webhook *entities.LMSWebhook) error {
+	if len(webhook.Secret) < 16 {
+		return errors.New("secret must be at least 16 characters")
+	}
+	if len(webhook.AllowedHosts) == 0 {
+		return errors.New("allowed_hosts must name the hosts media is fetched from")
+	}
+	if len(webhook.Rules) == 0 {
+		return errors.New("rules must not be empty")
+	}
+	for i, rule := range webhook.Rules {
+		for name, value := range map[string]string{
+			"event":           rule.Event,
+			"event_field":     rule.EventField,
+			"media_url_field": rule.MediaURLField,
+			"lesson_field":    rule.LessonField,
+		} {
+			if value == "" {
+				return fmt.Errorf("rule %d: %s must not be empty", i+1, name)
+			}
+		}
+		if rule.Preset != "" {
+			if _, err := s.presets.Resolve(ctx, rule.Preset); err != nil {
+				return fmt.Errorf("rule %d: preset %s: %w", i+1, rule.Preset, err)
+			}
+		}
+	}
+	return nil
+}
+
+func verifyLMSSignature(secret, timestamp, signature string, body []byte, now time.Time) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("missing or invalid webhook timestamp")
+	}
+	if skew := now.Sub(time.Unix(seconds, 0)); skew > lmsSignatureTolerance || skew < -lmsSignatureTolerance {
+		return errors.New("webhook timestamp is too far from now")
+	}
+	if !hmac.Equal([]byte(signWebhook(secret, timestamp, body)), []byte(signature)) {
+		return errors.New("webhook signature does not match")
+	}
+	return nil
+}
+
+// signWebhook is the signature of a webhook sent at timestamp, in Unix
+// seconds: "sha256=" and the hex HMAC-SHA256 of "<timestamp>.<body>".
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// matchLMSRule returns the first rule the event matches, nil when none does.
+func matchLMSRule(rules entities.LMSWebhookRules, event interface{}) *entities.LMSWebhookRule {
+	for i := range rules {
+		if value, ok := lookupField(event, rules[i].EventField); ok && value == rules[i].Event {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// lookupField returns the scalar at a dot-separated path into a decoded JSON
+// document as a string, array elements named by their index.
+func lookupField(document interface{}, field string) (string, bool) {
+	value := document
+	for _, key := range strings.Split(field, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			next, ok := v[key]
+			if !ok {
+				return "", false
+			}
+			value = next
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", false
+			}
+			value = v[i]
+		default:
+			return "", false
+		}
+	}
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
+
+func NewLMSWebhookService(repo repository.LMSWebhookRepository, jobs repository.JobRepository, presets PresetService, publisher rabbitmq.Publisher, store objectstore.Store, cfg *config.Config) LMSWebhookService {
+	return &lmsWebhookService{
+		repo:      repo,
+		jobs:      jobs,
+		presets:   presets,
+		publisher: publisher,
+		store:     store,
+		cfg:       cfg,
+	}
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+	"worker-transcode/config"
+
+	"github.com/rs/zerolog"
+)
+
+// resumeRatio is the share of a limit readings must fall back under before
+// intake resumes, so a node hovering at a limit doesn't flap.
+const resumeRatio = 0.9
+
+// LoadMonitor samples the node's CPU, load average, scratch disk and running
+// ffmpeg processes, and holds the consumers back while one is over its limit.
+// It is a gate of the intake.
+type LoadMonitor struct {
+	cfg config.Backpressure
+
+	mu        sync.Mutex
+	over      error
+	lastIdle  uint64
+	lastTotal uint64
+}
+
+func NewLoadMonitor(cfg *config.Config) *LoadMonitor {
+	return &LoadMonitor{cfg: cfg.Backpressure}
+}
+
+func (m *LoadMonitor) Name() string {
+	return "load"
+}
+
+func (m *LoadMonitor) Allow() bool {
+	return m.Err() == nil
+}
+
+// Err returns the reading over its limit while intake is held back.
+func (m *LoadMonitor) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.over
+}
+
+// Run takes readings every Interval until ctx is done.
+func (m *LoadMonitor) Run(ctx context.Context) {
+	if err := os.MkdirAll("temp", os.ModePerm); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to create scratch directory")
+	}
+	ticker := time.NewTicker(time.Duration(max(m.cfg.Interval, 1)) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		m.mu.Lock()
+		wasOver := m.over != nil
+		m.over = m.sample(wasOver)
+		over := m.over
+		m.mu.Unlock()
+
+		switch {
+		case over != nil && !wasOver:
+			zerolog.Ctx(ctx).Warn().Err(over).Msg("node overloaded, holding back intake")
+		case over == nil && wasOver:
+			zerolog.Ctx(ctx).Info().Msg("node load back to normal")
+		}
+	}
+}
+
+// sample returns the first reading over its limit. While intake is already
+// held back the limits are lowered by resumeRatio.
+func (m *LoadMonitor) sample(held bool) error {
+	ratio := 1.0
+	if held {
+		ratio = resumeRatio
+	}
+	over := func(value, limit float64) bool {
+		return limit > 0 && value > limit*ratio
+	}
+
+	idle, total, ok := cpuTimes()
+	if ok && m.lastTotal > 0 && total > m.lastTotal {
+		cpu := 100 * (1 - float64(idle-m.lastIdle)/float64(total-m.lastTotal))
+		m.lastIdle, m.lastTotal = idle, total
+		if over(cpu, m.cfg.MaxCPU) {
+			return fmt.Errorf("cpu at %.0f%%, limit %.0f%%", cpu, m.cfg.MaxCPU)
+		}
+	} else if ok {
+		m.lastIdle, m.lastTotal = idle, total
+	}
+
+	if load, ok := loadAverage(); ok {
+		perCPU := load / float64(runtime.NumCPU())
+		if over(perCPU, m.cfg.MaxLoadPerCPU) {
+			return fmt.Errorf("load average %.2f per core, limit %.2f", perCPU, m.cfg.MaxLoadPerCPU)
+		}
+	}
+
+	if used, ok := diskUsage("temp"); ok && over(used, m.cfg.MaxDisk) {
+		return fmt.Errorf("scratch disk %.0f%% used, limit %.0f%%", used, m.cfg.MaxDisk)
+	}
+
+	// A process count has no room for hysteresis.
+	if running := runningFFmpeg.Load(); m.cfg.MaxFFmpeg > 0 && running >= int64(m.cfg.MaxFFmpeg) {
+		return fmt.Errorf("%d ffmpeg processes running, limit %d", running, m.cfg.MaxFFmpeg)
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// partsDir is where a low-latency encode writes its partial segments,
+// out of the segment uploader's sweep, until packageParts gathers them.
+const partsDir = "parts"
+
+// llPlaylistVersion is the version of a low-latency media playlist, which
+// addresses its parts by byte range.
+const llPlaylistVersion = 6
+
+// partOutputArgs package an output as a VOD playlist cut into parts
+// partSeconds long, whether or not a part starts on a keyframe.
+func partOutputArgs(partSeconds float64) []string {
+	return []string{
+		"-f", "hls",
+		"-hls_time", strconv.FormatFloat(partSeconds, 'f', -1, 64),
+		"-hls_playlist_type", "vod",
+		"-hls_flags", "split_by_time",
+	}
+}
+
+// transcodeLowLatency encodes request as LL-HLS: the renditions are cut into
+// partial segments, which are then gathered into the preset's segments and
+// listed in each media playlist by byte range, so a player at the live edge
+// of a near-live replay can fetch a segment before it is complete. Keyframes
+// are forced on every segment when the preset doesn't force them, so every
+// segment's first part is independent.
+func transcodeLowLatency(ctx context.Context, request EncodeRequest) error {
+	preset := *request.Preset
+	if preset.KeyframeSeconds <= 0 {
+		preset.KeyframeSeconds = preset.SegmentSeconds
+	}
+	if err := os.MkdirAll(filepath.Join(request.OutputDir, partsDir), 0755); err != nil {
+		return err
+	}
+	if err := transcodeToHLS(ctx, &preset, request.InputFilepath, request.AudioFilepath, request.Dubs, request.OutputDir, request.Threads, request.CopyHeight, request.PartSeconds, request.OnProgress); err != nil {
+		return err
+	}
+	return packageParts(ctx, request.OutputDir, float64(preset.SegmentSeconds), request.PartSeconds)
+}
+
+// llPart is a partial segment of a media playlist, and where it ended up in
+// its segment.
+type llPart struct {
+	name     string
+	duration float64
+	offset   int64
+	size     int64
+}
+
+// llSegment is a segment gathered from the parts starting in its span.
+type llSegment struct {
+	name     string
+	duration float64
+	parts    []llPart
+}
+
+// packageParts gathers the parts of every media playlist under outputDir
+// into segments segmentSeconds long and rewrites the playlist for LL-HLS,
+// then removes the parts.
+func packageParts(ctx context.Context, outputDir string, segmentSeconds, partSeconds float64) error {
+	playlists, err := filepath.Glob(filepath.Join(outputDir, "*.m3u8"))
+	if err != nil {
+		return err
+	}
+	for _, playlist := range playlists {
+		if filepath.Base(playlist) == "master.m3u8" {
+			continue
+		}
+		parts, err := readParts(playlist)
+		if err != nil {
+			return err
+		}
+		segments, err := gatherParts(outputDir, strings.TrimSuffix(filepath.Base(playlist), ".m3u8"), parts, segmentSeconds, partSeconds)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(playlist, []byte(llPlaylist(segments, partSeconds)), 0644); err != nil {
+			return err
+		}
+		zerolog.Ctx(ctx).Debug().Str("playlist", filepath.Base(playlist)).Int("segments", len(segments)).Int("parts", len(parts)).Msg("low-latency playlist packaged")
+	}
+	return os.RemoveAll(filepath.Join(outputDir, partsDir))
+}
+
+// readParts lists the parts of a playlist written by partOutputArgs.
+func readParts(playlist string) ([]llPart, error) {
+	file, err := os.Open(playlist)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var parts []llPart
+	var duration float64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			duration, _ = strconv.ParseFloat(value, 64)
+		case line == "", strings.HasPrefix(line, "#"):
+		default:
+			parts = append(parts, llPart{name: filepath.Base(line), duration: duration})
+		}
+	}
+	return parts, scanner.Err()
+}
+
+// gatherParts concatenates parts into segments named like the ones a plain
+// encode writes for the playlist. A part starting within half a part of a
+// segment boundary starts the next segment, which the forced keyframe there
+// makes independent.
+func gatherParts(outputDir, base string, parts []llPart, segmentSeconds, partSeconds float64) ([]llSegment, error) {
+	var segments []llSegment
+	var elapsed, boundary float64
+	var out *os.File
+	for _, part := range parts {
+		if out == nil || elapsed >= boundary-partSeconds/2 {
+			if out != nil {
+				if err := out.Close(); err != nil {
+					return nil, err
+				}
+			}
+			for elapsed >= boundary-partSeconds/2 {
+				boundary += segmentSeconds
+			}
+			segment := llSegment{name: fmt.Sprintf("%s_%03d.ts", base, len(segments))}
+			var err error
+			if out, err = os.Create(filepath.Join(outputDir, segment.name)); err != nil {
+				return nil, err
+			}
+			segments = append(segments, segment)
+		}
+		segment := &segments[len(segments)-1]
+		in, err := os.Open(filepath.Join(outputDir, partsDir, part.name))
+		if err != nil {
+			out.Close()
+			return nil, err
+		}
+		if n := len(segment.parts); n > 0 {
+			part.offset = segment.parts[n-1].offset + segment.parts[n-1].size
+		}
+		part.size, err = io.Copy(out, in)
+		in.Close()
+		if err != nil {
+			out.Close()
+			return nil, err
+		}
+		segment.parts = append(segment.parts, part)
+		segment.duration += part.duration
+		elapsed += part.duration
+	}
+	if out != nil {
+		return segments, out.Close()
+	}
+	return segments, nil
+}
+
+// llPlaylist is a VOD media playlist of segments that also lists each one's
+// parts and lets players block on reloads, as a live LL-HLS playlist would,
+// so a replay published as the class ends plays from its live edge.
+func llPlaylist(segments []llSegment, partSeconds float64) string {
+	target, partTarget := 0.0, partSeconds
+	for _, segment := range segments {
+		target = max(target, segment.duration)
+		for _, part := range segment.parts {
+			partTarget = max(partTarget, part.duration)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	fmt.Fprintf(&b, "#EXT-X-VERSION:%d\n", llPlaylistVersion)
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(target)))
+	fmt.Fprintf(&b, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", partTarget)
+	fmt.Fprintf(&b, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f\n", 3*partTarget)
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	b.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	for _, segment := range segments {
+		for i, part := range segment.parts {
+			fmt.Fprintf(&b, "#EXT-X-PART:DURATION=%.3f,URI=\"%s\",BYTERANGE=\"%d@%d\"", part.duration, segment.name, part.size, part.offset)
+			if i == 0 {
+				b.WriteString(",INDEPENDENT=YES")
+			}
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.6f,\n%s\n", segment.duration, segment.name)
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return b.String()
+}
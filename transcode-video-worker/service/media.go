@@ -0,0 +1,92 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"worker-transcode/entities"
+)
+
+// lessonMedia measures the package the job is publishing. Its duration is
+// what the longest rendition playlist lists, which is what players show
+// rather than what the container of the source claimed; the size and aspect
+// ratio are the source's as encoded.
+func lessonMedia(job *entities.Job, preset *entities.Preset, source *MediaInfo, outputDir string, dubs []dubbedAudio, sourceDuration float64) (*entities.LessonMedia, error) {
+	media := &entities.LessonMedia{
+		LessonId:        job.EntityId,
+		JobId:           job.ID,
+		DurationSeconds: sourceDuration,
+		AudioLanguages:  audioLanguages(dubs),
+	}
+	listed := 0.0
+	for _, rendition := range preset.Renditions {
+		seconds, err := playlistSeconds(filepath.Join(outputDir, fmt.Sprintf("%dp.m3u8", rendition.Height)))
+		if errors.Is(err, os.ErrNotExist) {
+			// A package copied from an identical input isn't on disk.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		listed = max(listed, seconds)
+	}
+	if listed > 0 {
+		media.DurationSeconds = listed
+	}
+	if source == nil {
+		return media, nil
+	}
+	if video := source.VideoStream(); video != nil && video.Width > 0 && video.Height > 0 {
+		media.Width, media.Height = video.Width, video.Height
+		ratio := aspectRatio(video.Width, video.Height)
+		media.AspectRatio = &ratio
+	}
+	return media, nil
+}
+
+// playlistSeconds sums the segment durations of a local media playlist.
+func playlistSeconds(name string) (float64, error) {
+	lines, err := readLines(name)
+	if err != nil {
+		return 0, err
+	}
+	return segmentSeconds(lines), nil
+}
+
+// segmentSeconds sums the segment durations of a media playlist's lines.
+func segmentSeconds(lines []string) float64 {
+	seconds := 0.0
+	for _, line := range lines {
+		if value, found := strings.CutPrefix(line, "#EXTINF:"); found {
+			value, _, _ = strings.Cut(value, ",")
+			duration, _ := strconv.ParseFloat(value, 64)
+			seconds += duration
+		}
+	}
+	return seconds
+}
+
+// aspectRatio reduces a frame size to its ratio, e.g. 1920x1080 to 16:9.
+func aspectRatio(width, height int) string {
+	a, b := width, height
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return fmt.Sprintf("%d:%d", width/a, height/a)
+}
+
+// audioLanguages lists the languages a lesson can be listened to in: its own
+// audio and each dub. Audio descriptions repeat a language and aren't listed.
+func audioLanguages(dubs []dubbedAudio) []string {
+	languages := []string{sourceAudioLanguage}
+	for _, dub := range dubs {
+		if !dub.description && !slices.Contains(languages, dub.language) {
+			languages = append(languages, dub.language)
+		}
+	}
+	return languages
+}
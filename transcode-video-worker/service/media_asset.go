@@ -0,0 +1,427 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/correlation"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/pkg/reporting"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+const (
+	// mediaPrefix is where media jobs write what they make, under the
+	// asset's entity and id.
+	mediaPrefix = "media/"
+
+	// maxImageWidths, minImageWidth and maxImageWidth bound what an image
+	// job may ask for, and maxPreviewPages a document job.
+	maxImageWidths  = 8
+	minImageWidth   = 16
+	maxImageWidth   = 4096
+	maxPreviewPages = 50
+)
+
+// mediaPurposes are the purposes a media job may be for, by kind, as the
+// API's upload purposes it is stored under.
+var mediaPurposes = map[constant.MediaKind]map[string]constant.EntityType{
+	constant.MediaKindImage: {
+		"course_thumbnail": constant.EntityTypeCourseThumbnail,
+		"batch_thumbnail":  constant.EntityTypeBatchThumbnail,
+		"user_avatar":      constant.EntityTypeUserAvatar,
+	},
+	constant.MediaKindDocument: {
+		"lesson_resource": constant.EntityTypeLessonResource,
+	},
+}
+
+// mediaExtensions are the sources each kind of media job takes. Documents
+// other than PDFs are converted with LibreOffice first.
+var mediaExtensions = map[constant.MediaKind][]string{
+	constant.MediaKindImage:    {".jpg", ".jpeg", ".png", ".webp", ".gif", ".bmp"},
+	constant.MediaKindDocument: {".pdf", ".ppt", ".pptx", ".odp", ".doc", ".docx", ".odt"},
+}
+
+// MediaService derives stills from images and documents under the same
+// queues, storage and jobs as lesson videos: resized course covers and
+// avatars, and thumbnails and preview pages of lesson attachments.
+type MediaService interface {
+	// Request queues a media job.
+	Request(ctx context.Context, request dto.MediaRequest) (*entities.MediaAsset, error)
+	Find(ctx context.Context, id uuid.UUID) (*entities.MediaAsset, error)
+	// Process runs a media job.
+	Process(ctx context.Context, message dto.MediaMessage) error
+}
+
+type mediaService struct {
+	repo      repository.MediaAssetRepository
+	jobs      repository.JobRepository
+	events    repository.JobEventRepository
+	publisher rabbitmq.Publisher
+	store     objectstore.Store
+	cfg       *config.Config
+}
+
+func (s *mediaService) Request(ctx context.Context, request dto.MediaRequest) (*entities.MediaAsset, error) {
+	entityType, err := validateMedia(request)
+	if err != nil {
+		return nil, errors.Join(ErrInvalidArgument, err)
+	}
+
+	job := &entities.Job{
+		ID:         uuid.New(),
+		EntityId:   request.EntityId,
+		EntityType: string(entityType),
+		Status:     constant.JobStatusPending,
+		JobType:    constant.JobTypeMedia,
+		UserId:     request.UserId,
+	}
+	if correlationId := correlation.FromContext(ctx); correlationId != "" {
+		job.CorrelationId = &correlationId
+	}
+	asset := &entities.MediaAsset{
+		ID:         uuid.New(),
+		JobId:      job.ID,
+		EntityId:   request.EntityId,
+		EntityType: string(entityType),
+		Kind:       request.Kind,
+		SourceKey:  request.SourceKey,
+		Pages:      request.Pages,
+	}
+	switch constant.MediaKind(request.Kind) {
+	case constant.MediaKindImage:
+		widths := request.Widths
+		if len(widths) == 0 {
+			widths = s.cfg.Media.ImageWidths
+		}
+		for _, width := range widths {
+			asset.Widths = append(asset.Widths, int64(width))
+		}
+	case constant.MediaKindDocument:
+		if asset.Pages == 0 {
+			asset.Pages = s.cfg.Media.PreviewPages
+		}
+	}
+
+	if err := s.jobs.CreateJob(ctx, job); err != nil {
+		return nil, err
+	}
+	if err := s.repo.CreateMediaAsset(ctx, asset); err != nil {
+		return nil, err
+	}
+	message := dto.MediaMessage{JobId: job.ID}
+	if err := s.publisher.Publish(ctx, rabbitmq.MediaTopology.Exchange, rabbitmq.MediaTopology.RoutingKey, message); err != nil {
+		return nil, err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("job_id", job.ID.String()).
+		Str("entity_id", request.EntityId.String()).
+		Str("kind", request.Kind).
+		Msg("media job queued")
+	return asset, nil
+}
+
+func (s *mediaService) Find(ctx context.Context, id uuid.UUID) (*entities.MediaAsset, error) {
+	asset, err := s.repo.FindMediaAsset(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	return asset, err
+}
+
+func (s *mediaService) Process(ctx context.Context, message dto.MediaMessage) (err error) {
+	job, err := s.jobs.FindJobById(ctx, message.JobId)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to find job by id")
+		return err
+	}
+	if job.Status != constant.JobStatusPending {
+		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("job is not pending")
+		return nil
+	}
+	asset, err := s.repo.FindMediaAssetByJob(ctx, message.JobId)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to find media asset")
+		return err
+	}
+
+	claimed, err := s.jobs.ClaimJob(ctx, message.JobId, correlation.FromContext(ctx), workerFromContext(ctx))
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to update job status")
+		return err
+	}
+	if !claimed {
+		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("job already claimed by another delivery")
+		return nil
+	}
+	rabbitmq.Claimed(ctx)
+	ctx = withTimeline(ctx, s.events, job.ID)
+	recordStatus(ctx, constant.JobStatusPending, constant.JobStatusProcessing)
+
+	stage := constant.ErrorClassWorkspace
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = panicked(ctx, recovered)
+		}
+		if shuttingDown(ctx, err) {
+			err = handOff(ctx, s.jobs, message.JobId, err)
+			return
+		}
+		err = classify(err)
+		recordOutcome(ctx, job, stage, err)
+		recordOutcomeEvents(ctx, stage, err)
+		if err != nil {
+			if errors.Is(err, ErrNonRetryable) {
+				if updateErr := s.jobs.FailJob(ctx, message.JobId, stage, errorKind(err), err.Error()); updateErr != nil {
+					zerolog.Ctx(ctx).Error().Err(updateErr).Msg("failed to update job status")
+				}
+				reporting.CaptureFailure(ctx, err, reporting.Failure{
+					JobId:   message.JobId.String(),
+					JobType: string(job.JobType),
+					Stage:   string(stage),
+					Output:  failureOutput(err),
+					Extra:   map[string]interface{}{"kind": asset.Kind, "source_key": asset.SourceKey},
+				})
+				err = nil
+			} else {
+				if updateErr := s.jobs.UpdateStatusJob(ctx, constant.JobStatusPending, message.JobId); updateErr != nil {
+					zerolog.Ctx(ctx).Error().Err(updateErr).Msg("failed to update job status")
+				}
+			}
+		}
+	}()
+
+	tempDir := filepath.Join("temp", message.JobId.String())
+	defer os.RemoveAll(tempDir)
+	outputDir := filepath.Join(tempDir, "output")
+	if err = os.MkdirAll(outputDir, os.ModePerm); err != nil {
+		return errors.Join(ErrNonRetryable, err)
+	}
+
+	stage = constant.ErrorClassDownload
+	source := filepath.Join(tempDir, "source"+strings.ToLower(path.Ext(asset.SourceKey)))
+	err = traceStage(ctx, "download", func(ctx context.Context) error {
+		return s.store.FGetObject(ctx, s.cfg.MinIOBucket, asset.SourceKey, source, minio.GetObjectOptions{})
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to download media source")
+		return err
+	}
+
+	stage = constant.ErrorClassTranscode
+	var outputs []string
+	err = traceStage(ctx, "render_media", func(ctx context.Context) error {
+		var renderErr error
+		switch constant.MediaKind(asset.Kind) {
+		case constant.MediaKindImage:
+			outputs, renderErr = resizeImage(ctx, source, outputDir, asset.Widths)
+		default:
+			outputs, renderErr = s.previewDocument(ctx, source, tempDir, outputDir, asset.Pages)
+		}
+		return renderErr
+	})
+	if err != nil {
+		// The same source fails the same way every time.
+		zerolog.Ctx(ctx).Error().Err(err).Str("kind", asset.Kind).Msg("failed to render media")
+		return classified(constant.ErrorKindSourceCorrupt, err)
+	}
+
+	stage = constant.ErrorClassUpload
+	prefix := fmt.Sprintf("%s%s/%s/", mediaPrefix, asset.EntityId, asset.ID)
+	keys := make([]string, 0, len(outputs))
+	err = traceStage(ctx, "upload", func(ctx context.Context) error {
+		for _, output := range outputs {
+			relative, relErr := filepath.Rel(outputDir, output)
+			if relErr != nil {
+				return relErr
+			}
+			key := prefix + filepath.ToSlash(relative)
+			if _, putErr := s.store.FPutObject(ctx, s.cfg.MinIOBucket, key, output, minio.PutObjectOptions{ContentType: "image/jpeg"}); putErr != nil {
+				return putErr
+			}
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to upload media")
+		return err
+	}
+
+	stage = constant.ErrorClassDatabase
+	if err = s.repo.MarkMediaAssetDone(ctx, asset.ID, keys); err != nil {
+		return err
+	}
+	if err = s.jobs.UpdateStatusJob(ctx, constant.JobStatusCompleted, message.JobId); err != nil {
+		return err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("job_id", message.JobId.String()).
+		Str("kind", asset.Kind).
+		Int("outputs", len(keys)).
+		Msg("media rendered")
+	return nil
+}
+
+func validateMedia(request dto.MediaRequest) (constant.EntityType, error) {
+	kind := constant.MediaKind(request.Kind)
+	purposes, ok := mediaPurposes[kind]
+	if !ok {
+		return "", fmt.Errorf("kind must be %s or %s", constant.MediaKindImage, constant.MediaKindDocument)
+	}
+	entityType, ok := purposes[request.Purpose]
+	if !ok {
+		return "", fmt.Errorf("purpose %q is not one of a %s job", request.Purpose, kind)
+	}
+	if request.EntityId == uuid.Nil {
+		return "", errors.New("entity_id is required")
+	}
+	if !slices.Contains(mediaExtensions[kind], strings.ToLower(path.Ext(request.SourceKey))) {
+		return "", fmt.Errorf("source_key of a %s job must be one of %s", kind, strings.Join(mediaExtensions[kind], ", "))
+	}
+	switch kind {
+	case constant.MediaKindImage:
+		if len(request.Widths) > maxImageWidths {
+			return "", fmt.Errorf("an image is resized to at most %d widths", maxImageWidths)
+		}
+		for _, width := range request.Widths {
+			if width < minImageWidth || width > maxImageWidth {
+				return "", fmt.Errorf("width %d is not between %d and %d", width, minImageWidth, maxImageWidth)
+			}
+		}
+		if request.Pages != 0 {
+			return "", fmt.Errorf("pages is only for a %s job", constant.MediaKindDocument)
+		}
+	case constant.MediaKindDocument:
+		if request.Pages < 0 || request.Pages > maxPreviewPages {
+			return "", fmt.Errorf("a document previews 1 to %d pages", maxPreviewPages)
+		}
+		if len(request.Widths) > 0 {
+			return "", fmt.Errorf("widths are only for a %s job", constant.MediaKindImage)
+		}
+	}
+	return entityType, nil
+}
+
+// resizeImage writes the first frame of an image as a JPEG per width, scaled
+// down to it but never up, returning them in the order of widths.
+func resizeImage(ctx context.Context, source, dir string, widths []int64) ([]string, error) {
+	var outputs []string
+	for _, width := range widths {
+		output := filepath.Join(dir, fmt.Sprintf("w%d.jpg", width))
+		args := []string{"-i", source,
+			"-frames:v", "1",
+			"-vf", fmt.Sprintf("scale='min(iw,%d)':-2", width),
+			"-q:v", "3",
+			"-y", output,
+		}
+		if err := runFFmpeg(ctx, args, nil); err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, output)
+	}
+	return outputs, nil
+}
+
+// previewDocument renders a thumbnail of a document's first page and up to
+// pages preview pages, converting an office document to PDF first. The
+// thumbnail comes first, then the pages in order.
+func (s *mediaService) previewDocument(ctx context.Context, source, workDir, dir string, pages int) ([]string, error) {
+	document := source
+	if !strings.EqualFold(filepath.Ext(source), ".pdf") {
+		var err error
+		if document, err = convertToPDF(ctx, s.cfg.Media.Soffice, source, workDir); err != nil {
+			return nil, err
+		}
+	}
+
+	thumbnail := filepath.Join(dir, "thumbnail")
+	if err := runPdftoppm(ctx, "-jpeg", "-singlefile", "-f", "1", "-l", "1",
+		"-scale-to-x", strconv.Itoa(s.cfg.Media.ThumbnailWidth), "-scale-to-y", "-1",
+		document, thumbnail); err != nil {
+		return nil, err
+	}
+
+	previewDir := filepath.Join(dir, "preview")
+	if err := os.MkdirAll(previewDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	// pdftoppm stops at the document's last page.
+	if err := runPdftoppm(ctx, "-jpeg", "-f", "1", "-l", strconv.Itoa(pages),
+		"-scale-to-x", strconv.Itoa(s.cfg.Media.PreviewWidth), "-scale-to-y", "-1",
+		document, filepath.Join(previewDir, "page")); err != nil {
+		return nil, err
+	}
+	// pdftoppm pads page numbers to the same width, so they sort in order.
+	previews, err := filepath.Glob(filepath.Join(previewDir, "page-*.jpg"))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(previews)
+	return append([]string{thumbnail + ".jpg"}, previews...), nil
+}
+
+func runPdftoppm(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, "pdftoppm", args...)
+	killProcessGroup(cmd)
+	zerolog.Ctx(ctx).Info().Str("command", "pdftoppm "+strings.Join(args, " ")).Msg("executing pdftoppm command")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("pdftoppm: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// convertToPDF converts an office document to a PDF in dir with LibreOffice.
+// Each conversion gets its own profile, as concurrent runs sharing one fail.
+func convertToPDF(ctx context.Context, soffice, document, dir string) (string, error) {
+	profile, err := filepath.Abs(filepath.Join(dir, "soffice"))
+	if err != nil {
+		return "", err
+	}
+	args := []string{"-env:UserInstallation=file://" + filepath.ToSlash(profile),
+		"--headless", "--convert-to", "pdf", "--outdir", dir, document,
+	}
+	cmd := exec.CommandContext(ctx, soffice, args...)
+	killProcessGroup(cmd)
+	zerolog.Ctx(ctx).Info().Str("command", soffice+" "+strings.Join(args, " ")).Msg("executing soffice command")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("soffice: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	converted := filepath.Join(dir, strings.TrimSuffix(filepath.Base(document), filepath.Ext(document))+".pdf")
+	if _, err := os.Stat(converted); err != nil {
+		return "", fmt.Errorf("soffice wrote no PDF: %w", err)
+	}
+	return converted, nil
+}
+
+func NewMediaService(repo repository.MediaAssetRepository, jobs repository.JobRepository, events repository.JobEventRepository, publisher rabbitmq.Publisher, store objectstore.Store, cfg *config.Config) MediaService {
+	return &mediaService{
+		repo:      repo,
+		jobs:      jobs,
+		events:    events,
+		publisher: publisher,
+		store:     store,
+		cfg:       cfg,
+	}
+}
@@ -0,0 +1,259 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// MigrationService moves the back catalog onto a new preset generation, such
+// as an AV1 ladder once it is enabled. A migration re-transcodes every
+// transcoded lesson whose last encode wasn't with the preset's version, the
+// most played lessons first, on the backfill lane and only as fast as the
+// backfill workers have idle slots, so uploads never wait on it.
+type MigrationService interface {
+	// Start opens a migration onto the active version of the request's
+	// preset. A preset has one open migration at a time.
+	Start(ctx context.Context, request dto.CodecMigrationRequest) (*entities.CodecMigration, error)
+	List(ctx context.Context) ([]*entities.CodecMigration, error)
+	Progress(ctx context.Context, id uuid.UUID) (*dto.CodecMigrationProgress, error)
+	// Transition pauses, resumes or cancels a migration. The jobs it queued
+	// run on either way.
+	Transition(ctx context.Context, id uuid.UUID, status constant.MigrationStatus) (*entities.CodecMigration, error)
+	// Run queues the running migrations' re-transcodes every
+	// MIGRATION_INTERVAL until ctx is done. Only the leader runs it.
+	Run(ctx context.Context)
+}
+
+// migrationTransitions are the statuses a migration can be moved to, and
+// from which.
+var migrationTransitions = map[constant.MigrationStatus][]constant.MigrationStatus{
+	constant.MigrationStatusPaused:    {constant.MigrationStatusRunning},
+	constant.MigrationStatusRunning:   {constant.MigrationStatusPaused},
+	constant.MigrationStatusCancelled: {constant.MigrationStatusRunning, constant.MigrationStatusPaused},
+}
+
+type migrationService struct {
+	repo      repository.MigrationRepository
+	workers   repository.WorkerRepository
+	jobs      repository.JobRepository
+	presets   PresetService
+	publisher rabbitmq.Publisher
+	store     objectstore.Store
+	cfg       *config.Config
+}
+
+func (s *migrationService) Start(ctx context.Context, request dto.CodecMigrationRequest) (*entities.CodecMigration, error) {
+	preset, err := s.presets.Resolve(ctx, request.Preset)
+	if err != nil {
+		return nil, err
+	}
+	open, err := s.repo.FindOpenMigration(ctx, preset.Name)
+	switch {
+	case err == nil:
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("preset %q already has migration %s %s", preset.Name, open.ID, open.Status))
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, err
+	}
+
+	migration := &entities.CodecMigration{
+		ID:            uuid.New(),
+		Preset:        preset.Name,
+		PresetVersion: preset.Version,
+		Status:        constant.MigrationStatusRunning,
+	}
+	if err := s.repo.CreateMigration(ctx, migration); err != nil {
+		return nil, err
+	}
+	zerolog.Ctx(ctx).Info().
+		Str("migration_id", migration.ID.String()).
+		Str("preset", preset.Name).
+		Int("version", preset.Version).
+		Msg("codec migration started")
+	return migration, nil
+}
+
+func (s *migrationService) List(ctx context.Context) ([]*entities.CodecMigration, error) {
+	return s.repo.ListMigrations(ctx, "")
+}
+
+func (s *migrationService) Progress(ctx context.Context, id uuid.UUID) (*dto.CodecMigrationProgress, error) {
+	migration, err := s.find(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	courses, err := s.repo.ListCourseProgress(ctx, migration)
+	if err != nil {
+		return nil, err
+	}
+	return &dto.CodecMigrationProgress{Migration: migration, Courses: courses}, nil
+}
+
+func (s *migrationService) Transition(ctx context.Context, id uuid.UUID, status constant.MigrationStatus) (*entities.CodecMigration, error) {
+	from, ok := migrationTransitions[status]
+	if !ok {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("a migration can't be moved to %s", status))
+	}
+	migration, err := s.find(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if migration.Status == status {
+		return migration, nil
+	}
+	moved, err := s.repo.UpdateMigrationStatus(ctx, id, from, status)
+	if err != nil {
+		return nil, err
+	}
+	if !moved {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("migration %s is %s", id, migration.Status))
+	}
+	zerolog.Ctx(ctx).Info().Str("migration_id", id.String()).Str("status", string(status)).Msg("codec migration moved")
+	return s.find(ctx, id)
+}
+
+func (s *migrationService) find(ctx context.Context, id uuid.UUID) (*entities.CodecMigration, error) {
+	migration, err := s.repo.FindMigration(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	return migration, err
+}
+
+func (s *migrationService) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(s.cfg.Migration.Interval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		if err := s.schedule(ctx); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to schedule codec migrations")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// schedule queues as many re-transcodes of the running migrations, oldest
+// migration first, as the backfill workers have idle slots left once the
+// migration jobs already waiting are taken, at most MIGRATION_MAX_PER_TICK,
+// or its MAINTENANCE_THROTTLE_SHARE while no region is in a maintenance
+// window.
+func (s *migrationService) schedule(ctx context.Context) error {
+	migrations, err := s.repo.ListMigrations(ctx, constant.MigrationStatusRunning)
+	if err != nil || len(migrations) == 0 {
+		return err
+	}
+	idle, err := s.idleSlots(ctx)
+	if err != nil {
+		return err
+	}
+	waiting, err := s.repo.CountMigrationJobs(ctx, nil, []constant.JobStatus{constant.JobStatusPending})
+	if err != nil {
+		return err
+	}
+	perTick := s.cfg.Migration.MaxPerTick
+	if !s.cfg.Maintenance.AnyOpen(time.Now()) {
+		perTick = s.cfg.Maintenance.Throttle(perTick)
+	}
+	room := min(idle-int(waiting), perTick)
+
+	slices.Reverse(migrations)
+	for _, migration := range migrations {
+		logger := zerolog.Ctx(ctx).With().Str("migration_id", migration.ID.String()).Logger()
+		candidates, err := s.repo.FindMigrationCandidates(ctx, migration, max(room, 1))
+		if err != nil {
+			return err
+		}
+		if len(candidates) == 0 {
+			if err := s.complete(ctx, migration); err != nil {
+				return err
+			}
+			continue
+		}
+		if room <= 0 {
+			continue
+		}
+		for _, candidate := range candidates {
+			job, err := queueRetranscode(ctx, s.store, s.cfg, s.jobs, s.publisher, candidate.BackfillCandidate, migration.Preset, nil)
+			if err != nil {
+				logger.Error().Err(err).Str("lesson_id", candidate.LessonId.String()).Msg("failed to queue codec migration job")
+				continue
+			}
+			err = s.repo.AddMigrationLesson(ctx, &entities.CodecMigrationLesson{
+				MigrationId: migration.ID,
+				LessonId:    candidate.LessonId,
+				CourseId:    candidate.CourseId,
+				JobId:       job.ID,
+			})
+			if err != nil {
+				return err
+			}
+			room--
+		}
+		logger.Info().Int("queued", len(candidates)).Int("room", room).Msg("codec migration jobs queued")
+	}
+	return nil
+}
+
+// complete closes a migration with nothing left to queue once the jobs it
+// queued have finished. A lesson whose job failed is counted as failed in
+// its course's progress, not queued again.
+func (s *migrationService) complete(ctx context.Context, migration *entities.CodecMigration) error {
+	open, err := s.repo.CountMigrationJobs(ctx, &migration.ID, []constant.JobStatus{constant.JobStatusPending, constant.JobStatusProcessing})
+	if err != nil || open > 0 {
+		return err
+	}
+	moved, err := s.repo.UpdateMigrationStatus(ctx, migration.ID, []constant.MigrationStatus{constant.MigrationStatusRunning}, constant.MigrationStatusCompleted)
+	if moved {
+		zerolog.Ctx(ctx).Info().Str("migration_id", migration.ID.String()).Int("enqueued", migration.Enqueued).Msg("codec migration completed")
+	}
+	return err
+}
+
+// idleSlots is how many more jobs the live workers consuming the backfill
+// lane could take on now.
+func (s *migrationService) idleSlots(ctx context.Context) (int, error) {
+	workers, err := s.workers.ListWorkers(ctx)
+	if err != nil {
+		return 0, err
+	}
+	lapsedBefore := time.Now().Add(-time.Duration(s.cfg.Server.HeartbeatTimeout) * time.Second)
+	var idle int
+	for _, worker := range workers {
+		lane := worker.Capabilities.Lanes[rabbitmq.BackfillTranscodeTopology.Queue]
+		if worker.Status != constant.WorkerStatusActive || worker.LastHeartbeatAt.Before(lapsedBefore) || lane == 0 {
+			continue
+		}
+		idle += max(min(lane, worker.Concurrency-worker.InFlight), 0)
+	}
+	return idle, nil
+}
+
+func NewMigrationService(repo repository.MigrationRepository, workers repository.WorkerRepository, jobs repository.JobRepository,
+	presets PresetService, publisher rabbitmq.Publisher, store objectstore.Store, cfg *config.Config) MigrationService {
+	return &migrationService{
+		repo:      repo,
+		workers:   workers,
+		jobs:      jobs,
+		presets:   presets,
+		publisher: publisher,
+		store:     store,
+		cfg:       cfg,
+	}
+}
@@ -0,0 +1,458 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/correlation"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/pkg/reporting"
+	"worker-transcode/pkg/tts"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+const (
+	narrationWidth     = 1920
+	narrationHeight    = 1080
+	narrationFrameRate = 30
+
+	// maxNarrationSlides and maxSlideScript keep a narration within what the
+	// TTS providers take per request.
+	maxNarrationSlides = 300
+	maxSlideScript     = 4000
+
+	// slidePause is how long a slide stays up after its narration ends, and
+	// silentSlide how long one without a script is shown, in seconds.
+	slidePause  = 0.75
+	silentSlide = 4.0
+)
+
+// NarrationService makes lesson videos from a slide deck and a script, for
+// instructors who publish without recording themselves. Each slide is shown
+// while its script is spoken by the TTS provider, and the video becomes the
+// lesson's source, transcoded like an upload.
+type NarrationService interface {
+	// Request queues the narrated video of the lesson.
+	Request(ctx context.Context, lessonId uuid.UUID, request dto.NarrationRequest) (*entities.Narration, error)
+	Find(ctx context.Context, id uuid.UUID) (*entities.Narration, error)
+	// Process runs a narrated video job.
+	Process(ctx context.Context, message dto.NarrationMessage) error
+}
+
+type narrationService struct {
+	repo      repository.NarrationRepository
+	jobs      repository.JobRepository
+	events    repository.JobEventRepository
+	publisher rabbitmq.Publisher
+	store     objectstore.Store
+	cfg       *config.Config
+}
+
+func (s *narrationService) Request(ctx context.Context, lessonId uuid.UUID, request dto.NarrationRequest) (*entities.Narration, error) {
+	if err := validateNarration(lessonId, request); err != nil {
+		return nil, errors.Join(ErrInvalidArgument, err)
+	}
+
+	job := &entities.Job{
+		ID:         uuid.New(),
+		EntityId:   lessonId,
+		EntityType: string(constant.EntityTypeLessonVideo),
+		Status:     constant.JobStatusPending,
+		JobType:    constant.JobTypeNarration,
+		UserId:     request.UserId,
+	}
+	if correlationId := correlation.FromContext(ctx); correlationId != "" {
+		job.CorrelationId = &correlationId
+	}
+	narration := &entities.Narration{
+		ID:       uuid.New(),
+		LessonId: lessonId,
+		JobId:    job.ID,
+		DeckKey:  request.DeckKey,
+		Voice:    request.Voice,
+		Language: request.Language,
+	}
+	if narration.Voice == "" {
+		narration.Voice = s.cfg.TTS.Voice
+	}
+	if narration.Language == "" {
+		narration.Language = s.cfg.TTS.Language
+	}
+	for _, slide := range request.Slides {
+		narration.Script = append(narration.Script, strings.TrimSpace(slide.Script))
+		narration.Titles = append(narration.Titles, strings.TrimSpace(slide.Title))
+	}
+
+	if err := s.jobs.CreateJob(ctx, job); err != nil {
+		return nil, err
+	}
+	if err := s.repo.CreateNarration(ctx, narration); err != nil {
+		return nil, err
+	}
+	message := dto.NarrationMessage{JobId: job.ID}
+	if err := s.publisher.Publish(ctx, rabbitmq.NarrationTopology.Exchange, rabbitmq.NarrationTopology.RoutingKey, message); err != nil {
+		return nil, err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("job_id", job.ID.String()).
+		Str("lesson_id", lessonId.String()).
+		Int("slides", len(narration.Script)).
+		Msg("narrated video queued")
+	return narration, nil
+}
+
+func (s *narrationService) Find(ctx context.Context, id uuid.UUID) (*entities.Narration, error) {
+	narration, err := s.repo.FindNarration(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	return narration, err
+}
+
+func (s *narrationService) Process(ctx context.Context, message dto.NarrationMessage) (err error) {
+	job, err := s.jobs.FindJobById(ctx, message.JobId)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to find job by id")
+		return err
+	}
+	if job.Status != constant.JobStatusPending {
+		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("job is not pending")
+		return nil
+	}
+	narration, err := s.repo.FindNarrationByJob(ctx, message.JobId)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to find narration")
+		return err
+	}
+
+	claimed, err := s.jobs.ClaimJob(ctx, message.JobId, correlation.FromContext(ctx), workerFromContext(ctx))
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to update job status")
+		return err
+	}
+	if !claimed {
+		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("job already claimed by another delivery")
+		return nil
+	}
+	rabbitmq.Claimed(ctx)
+	ctx = withTimeline(ctx, s.events, job.ID)
+	recordStatus(ctx, constant.JobStatusPending, constant.JobStatusProcessing)
+
+	stage := constant.ErrorClassWorkspace
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = panicked(ctx, recovered)
+		}
+		if errors.Is(err, ErrInsufficientResources) {
+			postpone(ctx, s.jobs, message.JobId, err)
+			return
+		}
+		if shuttingDown(ctx, err) {
+			err = handOff(ctx, s.jobs, message.JobId, err)
+			return
+		}
+		err = classify(err)
+		recordOutcome(ctx, job, stage, err)
+		recordOutcomeEvents(ctx, stage, err)
+		if err != nil {
+			if errors.Is(err, ErrNonRetryable) {
+				if updateErr := s.jobs.FailJob(ctx, message.JobId, stage, errorKind(err), err.Error()); updateErr != nil {
+					zerolog.Ctx(ctx).Error().Err(updateErr).Msg("failed to update job status")
+				}
+				reporting.CaptureFailure(ctx, err, reporting.Failure{
+					JobId:   message.JobId.String(),
+					JobType: string(job.JobType),
+					Stage:   string(stage),
+					Output:  failureOutput(err),
+					Extra:   map[string]interface{}{"lesson_id": narration.LessonId.String()},
+				})
+				err = nil
+			} else {
+				if updateErr := s.jobs.UpdateStatusJob(ctx, constant.JobStatusPending, message.JobId); updateErr != nil {
+					zerolog.Ctx(ctx).Error().Err(updateErr).Msg("failed to update job status")
+				}
+			}
+		}
+	}()
+
+	synthesizer, err := tts.New(s.cfg.TTS)
+	if err != nil {
+		return errors.Join(ErrNonRetryable, err)
+	}
+
+	tempDir := filepath.Join("temp", message.JobId.String())
+	defer os.RemoveAll(tempDir)
+	slidesDir := filepath.Join(tempDir, "slides")
+	segmentsDir := filepath.Join(tempDir, "segments")
+	for _, dir := range []string{slidesDir, segmentsDir} {
+		if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+			return errors.Join(ErrNonRetryable, err)
+		}
+	}
+
+	stage = constant.ErrorClassDownload
+	deck := filepath.Join(tempDir, "deck.pdf")
+	err = traceStage(ctx, "download", func(ctx context.Context) error {
+		return s.store.FGetObject(ctx, s.cfg.MinIOBucket, narration.DeckKey, deck, minio.GetObjectOptions{})
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to download slide deck")
+		return err
+	}
+
+	stage = constant.ErrorClassProbe
+	var pages []string
+	err = traceStage(ctx, "render_slides", func(ctx context.Context) error {
+		var renderErr error
+		pages, renderErr = renderDeck(ctx, deck, slidesDir)
+		return renderErr
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to render slide deck")
+		return errors.Join(ErrNonRetryable, err)
+	}
+	if len(pages) != len(narration.Script) {
+		return errors.Join(ErrNonRetryable, fmt.Errorf("deck has %d pages, script has %d slides", len(pages), len(narration.Script)))
+	}
+
+	var (
+		segments []string
+		markers  []dto.ChapterMarker
+		elapsed  float64
+	)
+	for i, page := range pages {
+		audio := ""
+		if narration.Script[i] != "" {
+			stage = constant.ErrorClassTranscode
+			audio = filepath.Join(segmentsDir, fmt.Sprintf("narration_%03d.mp3", i+1))
+			err = traceStage(ctx, "speak", func(ctx context.Context) error {
+				speech, speakErr := synthesizer.Synthesize(ctx, narration.Script[i], narration.Voice, narration.Language)
+				if speakErr != nil {
+					return speakErr
+				}
+				return os.WriteFile(audio, speech, 0o644)
+			})
+			if err != nil {
+				zerolog.Ctx(ctx).Error().Err(err).Int("slide", i+1).Msg("failed to speak slide script")
+				return err
+			}
+		}
+
+		duration := silentSlide
+		if audio != "" {
+			info, probeErr := ProbeMedia(ctx, audio)
+			if probeErr != nil {
+				stage = constant.ErrorClassProbe
+				return errors.Join(ErrNonRetryable, probeErr)
+			}
+			duration = info.DurationSeconds() + slidePause
+		}
+
+		stage = constant.ErrorClassTranscode
+		segment := filepath.Join(segmentsDir, fmt.Sprintf("segment_%03d.mp4", i+1))
+		if err = runFFmpeg(ctx, slideSegmentArgs(page, audio, segment, duration, s.cfg.Server.FFmpegThreads), nil); err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Int("slide", i+1).Msg("failed to render narrated slide")
+			return errors.Join(ErrNonRetryable, err)
+		}
+		segments = append(segments, segment)
+		if title := narration.Titles[i]; title != "" {
+			markers = append(markers, dto.ChapterMarker{Start: elapsed, Title: title})
+		}
+		elapsed += duration
+	}
+
+	stage = constant.ErrorClassPackage
+	output := filepath.Join(tempDir, "narration.mp4")
+	if err = concatSegments(ctx, segments, segmentsDir, output); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to join narrated slides")
+		return errors.Join(ErrNonRetryable, err)
+	}
+
+	stage = constant.ErrorClassUpload
+	fileName := "narration.mp4"
+	objectPath := fmt.Sprintf("lessons/%s/videos/%d-%s", narration.LessonId, time.Now().UnixMilli(), fileName)
+	err = traceStage(ctx, "upload", func(ctx context.Context) error {
+		_, uploadErr := s.store.FPutObject(ctx, s.cfg.MinIOBucket, objectPath, output, minio.PutObjectOptions{ContentType: "video/mp4"})
+		return uploadErr
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to upload narrated video")
+		return err
+	}
+
+	stage = constant.ErrorClassDatabase
+	transcode := &entities.Job{
+		ID:            uuid.New(),
+		EntityId:      narration.LessonId,
+		EntityType:    string(constant.EntityTypeLessonVideo),
+		Status:        constant.JobStatusPending,
+		JobType:       constant.JobTypeTranscoder,
+		TenantId:      job.TenantId,
+		UserId:        job.UserId,
+		SLAClass:      job.SLAClass,
+		CorrelationId: job.CorrelationId,
+	}
+	if err = s.jobs.CreateJob(ctx, transcode); err != nil {
+		return err
+	}
+	class := jobSLAClass(job.SLAClass)
+	// The narration is spoken from the first frame to the last, so there is
+	// no dead air to trim.
+	transcodeMessage := dto.JobMessage{
+		JobId:      transcode.ID,
+		ObjectPath: objectPath,
+		FileName:   fileName,
+		SLAClass:   string(class),
+		Chapters:   markers,
+		NoTrim:     true,
+	}
+	topology := transcodeTopology(class)
+	if err = s.publisher.Publish(ctx, topology.Exchange, topology.RoutingKey, transcodeMessage); err != nil {
+		return err
+	}
+	if err = s.repo.MarkNarrationRendered(ctx, narration.ID, objectPath, transcode.ID); err != nil {
+		return err
+	}
+	if err = s.jobs.UpdateStatusJob(ctx, constant.JobStatusCompleted, message.JobId); err != nil {
+		return err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("job_id", message.JobId.String()).
+		Str("lesson_id", narration.LessonId.String()).
+		Str("transcode_job_id", transcode.ID.String()).
+		Float64("duration", elapsed).
+		Msg("narrated video rendered and queued for transcoding")
+	return nil
+}
+
+func validateNarration(lessonId uuid.UUID, request dto.NarrationRequest) error {
+	if !strings.HasPrefix(request.DeckKey, lessonPrefix+lessonId.String()+"/") || !strings.EqualFold(filepath.Ext(request.DeckKey), ".pdf") {
+		return fmt.Errorf("deck_key must be a PDF of lesson %s", lessonId)
+	}
+	if len(request.Slides) == 0 || len(request.Slides) > maxNarrationSlides {
+		return fmt.Errorf("a narration has 1 to %d slides, got %d", maxNarrationSlides, len(request.Slides))
+	}
+	if request.Language != "" && !languageTagPattern.MatchString(request.Language) {
+		return fmt.Errorf("language %q is not a language tag", request.Language)
+	}
+	spoken := false
+	for i, slide := range request.Slides {
+		if utf8.RuneCountInString(slide.Script) > maxSlideScript {
+			return fmt.Errorf("slide %d: script is longer than %d characters", i+1, maxSlideScript)
+		}
+		spoken = spoken || strings.TrimSpace(slide.Script) != ""
+	}
+	if !spoken {
+		return errors.New("the script is empty")
+	}
+	return nil
+}
+
+// renderDeck renders each page of a PDF to a PNG no larger than the video,
+// returning them in page order.
+func renderDeck(ctx context.Context, deck, dir string) ([]string, error) {
+	args := []string{"-png", "-r", "150",
+		"-scale-to-x", strconv.Itoa(narrationWidth), "-scale-to-y", "-1",
+		deck, filepath.Join(dir, "page"),
+	}
+	cmd := exec.CommandContext(ctx, "pdftoppm", args...)
+	killProcessGroup(cmd)
+	zerolog.Ctx(ctx).Info().Str("command", "pdftoppm "+strings.Join(args, " ")).Msg("executing pdftoppm command")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("pdftoppm: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	// pdftoppm pads page numbers to the same width, so they sort in order.
+	pages, err := filepath.Glob(filepath.Join(dir, "page-*.png"))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(pages)
+	return pages, nil
+}
+
+// slideSegmentArgs encodes a slide held for duration seconds over its
+// narration, or over silence for a slide without one, letterboxed on white
+// to the video's size. Segments share their encoding so they join without
+// re-encoding.
+func slideSegmentArgs(slide, audio, output string, duration float64, threads int) []string {
+	args := []string{"-loop", "1", "-framerate", strconv.Itoa(narrationFrameRate), "-i", slide}
+	if audio != "" {
+		args = append(args, "-i", audio)
+	} else {
+		args = append(args, "-f", "lavfi", "-i", "anullsrc=r=48000:cl=stereo")
+	}
+	args = append(args,
+		"-map", "0:v:0",
+		"-map", "1:a:0",
+		"-t", strconv.FormatFloat(duration, 'f', 3, 64),
+		"-vf", fmt.Sprintf("scale=%[1]d:%[2]d:force_original_aspect_ratio=decrease,pad=%[1]d:%[2]d:(ow-iw)/2:(oh-ih)/2:white,setsar=1,format=yuv420p",
+			narrationWidth, narrationHeight),
+		"-af", "aresample=48000,apad",
+		"-c:v", "libx264",
+		"-tune", "stillimage",
+		"-preset", "veryfast",
+		"-crf", "18",
+		"-r", strconv.Itoa(narrationFrameRate),
+		"-c:a", "aac",
+		"-b:a", "128k",
+		"-ac", "2",
+	)
+	if threads > 0 {
+		args = append(args, "-threads", fmt.Sprint(threads))
+	}
+	return append(args, "-y", output)
+}
+
+// concatSegments joins the slide segments into one MP4 without re-encoding.
+func concatSegments(ctx context.Context, segments []string, dir, output string) error {
+	var list strings.Builder
+	for _, segment := range segments {
+		absolute, err := filepath.Abs(segment)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&list, "file '%s'\n", strings.ReplaceAll(absolute, "'", `'\''`))
+	}
+	listFile := filepath.Join(dir, "segments.txt")
+	if err := os.WriteFile(listFile, []byte(list.String()), 0o644); err != nil {
+		return err
+	}
+	args := []string{"-f", "concat", "-safe", "0", "-i", listFile,
+		"-c", "copy",
+		"-movflags", "+faststart",
+		"-y", output,
+	}
+	return runFFmpeg(ctx, args, nil)
+}
+
+func NewNarrationService(repo repository.NarrationRepository, jobs repository.JobRepository, events repository.JobEventRepository, publisher rabbitmq.Publisher, store objectstore.Store, cfg *config.Config) NarrationService {
+	return &narrationService{
+		repo:      repo,
+		jobs:      jobs,
+		events:    events,
+		publisher: publisher,
+		store:     store,
+		cfg:       cfg,
+	}
+}
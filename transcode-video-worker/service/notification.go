@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"worker-transcode/config"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/mailer"
+	"worker-transcode/repository"
+
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// NotificationService tells people about finished jobs. Notifications are
+// best effort: callers log errors rather than failing the job.
+type NotificationService interface {
+	VideoReady(ctx context.Context, job *entities.Job, preset *entities.Preset) error
+}
+
+type notificationService struct {
+	repo   repository.NotificationRepository
+	mailer mailer.Mailer
+	cfg    *config.Config
+}
+
+// VideoReady emails the course instructors, and the uploader when they aren't
+// one of them, that a lesson video finished transcoding.
+func (s *notificationService) VideoReady(ctx context.Context, job *entities.Job, preset *entities.Preset) error {
+	enabled, replyTo, urlTemplate, err := s.settings(ctx, job)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return nil
+	}
+
+	lesson, err := s.repo.FindLessonSummary(ctx, job.EntityId)
+	if err != nil {
+		return fmt.Errorf("find lesson: %w", err)
+	}
+
+	recipients, err := s.repo.FindCourseInstructorEmails(ctx, lesson.CourseId)
+	if err != nil {
+		return fmt.Errorf("find instructors: %w", err)
+	}
+	if job.UserId != nil {
+		email, err := s.repo.FindUserEmail(ctx, *job.UserId)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("find uploader: %w", err)
+		}
+		if email != "" && !slices.Contains(recipients, email) {
+			recipients = append(recipients, email)
+		}
+	}
+	if len(recipients) == 0 {
+		zerolog.Ctx(ctx).Info().Str("lesson_id", lesson.LessonId.String()).Msg("no recipients for video ready notification")
+		return nil
+	}
+
+	link := strings.NewReplacer(
+		"{course_id}", lesson.CourseId.String(),
+		"{course_slug}", lesson.CourseSlug,
+		"{lesson_id}", lesson.LessonId.String(),
+		"{lesson_slug}", lesson.LessonSlug,
+	).Replace(urlTemplate)
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "The video for \"%s\" in %s has finished processing and is ready to watch.\n\n", lesson.LessonTitle, lesson.CourseTitle)
+	fmt.Fprintf(&text, "%s\n\n", link)
+	text.WriteString("Available renditions:\n")
+	for _, r := range preset.Renditions {
+		fmt.Fprintf(&text, "  - %dp (%dx%d, %s video / %s audio)\n", r.Height, r.Width, r.Height, r.Bitrate, r.AudioRate)
+	}
+
+	err = s.mailer.Send(ctx, mailer.Message{
+		To:      recipients,
+		ReplyTo: replyTo,
+		Subject: fmt.Sprintf("Your video for \"%s\" is ready", lesson.LessonTitle),
+		Text:    text.String(),
+	})
+	if err != nil {
+		return fmt.Errorf("send email: %w", err)
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("job_id", job.ID.String()).
+		Int("recipients", len(recipients)).
+		Msg("video ready notification sent")
+	return nil
+}
+
+// settings merges the tenant's overrides, if any, over the worker defaults.
+func (s *notificationService) settings(ctx context.Context, job *entities.Job) (bool, string, string, error) {
+	enabled, replyTo, urlTemplate := s.cfg.Notify.VideoReadyEmail, "", s.cfg.Notify.LessonURLTemplate
+	if job.TenantId == nil {
+		return enabled, replyTo, urlTemplate, nil
+	}
+
+	tenant, err := s.repo.FindTenantNotificationSettings(ctx, *job.TenantId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return enabled, replyTo, urlTemplate, nil
+	}
+	if err != nil {
+		return false, "", "", fmt.Errorf("find tenant settings: %w", err)
+	}
+
+	enabled = tenant.VideoReadyEmail
+	if tenant.ReplyTo != nil {
+		replyTo = *tenant.ReplyTo
+	}
+	if tenant.LessonURLTemplate != nil && *tenant.LessonURLTemplate != "" {
+		urlTemplate = *tenant.LessonURLTemplate
+	}
+	return enabled, replyTo, urlTemplate, nil
+}
+
+func NewNotificationService(repo repository.NotificationRepository, mailer mailer.Mailer, cfg *config.Config) NotificationService {
+	return &notificationService{
+		repo:   repo,
+		mailer: mailer,
+		cfg:    cfg,
+	}
+}
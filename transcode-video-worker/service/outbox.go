@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/repository"
+
+	"github.com/rs/zerolog"
+)
+
+const outboxBatch = 100
+
+// OutboxService relays the outbox to the broker. A message is marked sent
+// only once the broker confirms it, so one the relay dies sending goes out
+// again; consumers drop the repeat by its message id.
+type OutboxService interface {
+	// Run relays pending messages every RelayInterval seconds until ctx is
+	// cancelled. It runs on the leader only, keeping messages in order.
+	Run(ctx context.Context)
+}
+
+type outboxService struct {
+	repo      repository.OutboxRepository
+	publisher rabbitmq.Publisher
+	cfg       *config.Config
+}
+
+func (s *outboxService) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(s.cfg.Billing.RelayInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		for s.relay(ctx) == outboxBatch && ctx.Err() == nil {
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// relay sends one batch of pending messages, oldest first, returning how
+// many went out. It stops at the first the broker doesn't take, which is
+// tried again next time with those after it.
+func (s *outboxService) relay(ctx context.Context) int {
+	messages, err := s.repo.PendingOutbox(ctx, outboxBatch)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to list outbox messages")
+		return 0
+	}
+	for i, message := range messages {
+		err := s.publisher.PublishConfirmed(ctx, message.Exchange, message.RoutingKey, message.ID.String(), message.Payload)
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Str("message_id", message.ID.String()).Msg("failed to relay outbox message")
+			if markErr := s.repo.MarkOutboxFailed(ctx, message.ID, err.Error()); markErr != nil {
+				zerolog.Ctx(ctx).Error().Err(markErr).Msg("failed to record outbox failure")
+			}
+			return i
+		}
+		// Unmarked, the message is sent again next time: a duplicate, not
+		// a loss.
+		if err := s.repo.MarkOutboxPublished(ctx, message.ID); err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Str("message_id", message.ID.String()).Msg("failed to mark outbox message sent")
+			return i
+		}
+	}
+	return len(messages)
+}
+
+func NewOutboxService(repo repository.OutboxRepository, publisher rabbitmq.Publisher, cfg *config.Config) OutboxService {
+	return &outboxService{
+		repo:      repo,
+		publisher: publisher,
+		cfg:       cfg,
+	}
+}
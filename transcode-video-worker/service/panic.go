@@ -0,0 +1,21 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/rs/zerolog"
+)
+
+// ErrPanic marks a job whose processing panicked. The same input would most
+// likely panic again, so the job fails instead of being retried.
+var ErrPanic = errors.New("job panicked")
+
+// panicked turns a panic recovered from a job into its failure, logging the
+// stack of the panic while it is still on the goroutine.
+func panicked(ctx context.Context, recovered any) error {
+	zerolog.Ctx(ctx).Error().Interface("panic", recovered).Str("stack", string(debug.Stack())).Msg("job panicked")
+	return errors.Join(ErrNonRetryable, ErrPanic, fmt.Errorf("panic: %v", recovered))
+}
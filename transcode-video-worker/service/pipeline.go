@@ -0,0 +1,312 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/worker"
+	"go.temporal.io/sdk/workflow"
+)
+
+const (
+	// pipelineStageTimeout bounds the stages other than the encode, which
+	// runs within the job's own deadline and only has to keep heartbeating.
+	pipelineStageTimeout     = 10 * time.Minute
+	pipelineEncodeTimeout    = 48 * time.Hour
+	pipelineHeartbeatTimeout = 2 * time.Minute
+	pipelineRetryInterval    = 5 * time.Minute
+	pipelineStageAttempts    = 10
+)
+
+// PipelineService runs transcode jobs as Temporal workflows rather than
+// within a queue delivery: probe, encode, captions, QC and publish are each
+// an activity, retried on their own, and a pipeline waiting days on the
+// lesson's captions survives restarts of every worker.
+type PipelineService interface {
+	// Start starts the job's workflow. A redelivered message finds it
+	// already started and leaves it be.
+	Start(ctx context.Context, message dto.JobMessage) error
+	// Run works the pipelines' workflows and activities until ctx is done.
+	Run(ctx context.Context)
+}
+
+type pipelineService struct {
+	client     client.Client
+	activities *pipelineActivities
+	cfg        *config.Config
+}
+
+func (s *pipelineService) Start(ctx context.Context, message dto.JobMessage) error {
+	options := client.StartWorkflowOptions{
+		ID:                    pipelineWorkflowId(message.JobId),
+		TaskQueue:             s.cfg.Workflow.TaskQueue,
+		WorkflowIDReusePolicy: enumspb.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE_FAILED_ONLY,
+	}
+	input := dto.PipelineInput{
+		Message:     message,
+		CaptionWait: time.Duration(s.cfg.Workflow.CaptionWait) * time.Hour,
+		CaptionPoll: time.Duration(s.cfg.Workflow.CaptionPoll) * time.Minute,
+	}
+	run, err := s.client.ExecuteWorkflow(ctx, options, TranscodePipeline, input)
+	var started *serviceerror.WorkflowExecutionAlreadyStarted
+	if errors.As(err, &started) {
+		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("pipeline already started")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("start pipeline: %w", err)
+	}
+	zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Str("run_id", run.GetRunID()).Msg("pipeline started")
+	return nil
+}
+
+func (s *pipelineService) Run(ctx context.Context) {
+	w := worker.New(s.client, s.cfg.Workflow.TaskQueue, worker.Options{
+		// Activities log and register like the consumers' deliveries.
+		BackgroundActivityContext:          ctx,
+		MaxConcurrentActivityExecutionSize: s.cfg.Server.Workers,
+		WorkerStopTimeout:                  time.Duration(s.cfg.Server.ShutdownGrace) * time.Second,
+	})
+	w.RegisterWorkflow(TranscodePipeline)
+	w.RegisterActivity(s.activities)
+	if err := w.Start(); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to start pipeline worker")
+		return
+	}
+	zerolog.Ctx(ctx).Info().Str("task_queue", s.cfg.Workflow.TaskQueue).Msg("pipeline worker started")
+	<-ctx.Done()
+	w.Stop()
+}
+
+// pipelineWorkflowId is the job's workflow id, which makes a redelivered
+// job message start nothing new.
+func pipelineWorkflowId(jobId uuid.UUID) string {
+	return "transcode-" + jobId.String()
+}
+
+// pipelineOutput is what the encode leaves for the later stages.
+type pipelineOutput struct {
+	JobId    uuid.UUID `json:"jobId"`
+	LessonId uuid.UUID `json:"lessonId"`
+	Playlist string    `json:"playlist"`
+}
+
+// TranscodePipeline is a transcode job's workflow. A job that fails its
+// encode fails the workflow, and so can be started again once requeued;
+// captions that never come only end the wait for them.
+func TranscodePipeline(ctx workflow.Context, input dto.PipelineInput) error {
+	var a *pipelineActivities
+	stage := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: pipelineStageTimeout,
+		RetryPolicy:         &temporal.RetryPolicy{MaximumInterval: pipelineRetryInterval, MaximumAttempts: pipelineStageAttempts},
+	})
+	encode := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: pipelineEncodeTimeout,
+		HeartbeatTimeout:    pipelineHeartbeatTimeout,
+		RetryPolicy:         &temporal.RetryPolicy{MaximumInterval: pipelineRetryInterval},
+	})
+
+	if err := workflow.ExecuteActivity(stage, a.Probe, input.Message).Get(ctx, nil); err != nil {
+		return err
+	}
+	var output pipelineOutput
+	if err := workflow.ExecuteActivity(encode, a.Encode, input.Message).Get(ctx, &output); err != nil {
+		return err
+	}
+
+	until := workflow.Now(ctx).Add(input.CaptionWait)
+	for {
+		var captioned bool
+		if err := workflow.ExecuteActivity(stage, a.Captions, output.LessonId).Get(ctx, &captioned); err != nil {
+			return err
+		}
+		if captioned {
+			break
+		}
+		if !workflow.Now(ctx).Add(input.CaptionPoll).Before(until) {
+			workflow.GetLogger(ctx).Warn("lesson not captioned before the wait ran out", "lesson_id", output.LessonId.String())
+			break
+		}
+		if err := workflow.Sleep(ctx, input.CaptionPoll); err != nil {
+			return err
+		}
+	}
+
+	if err := workflow.ExecuteActivity(stage, a.QC, output).Get(ctx, nil); err != nil {
+		return err
+	}
+	return workflow.ExecuteActivity(stage, a.Publish, output).Get(ctx, nil)
+}
+
+// pipelineActivities are the stages of TranscodePipeline. The encode is the
+// queue engine's whole job, so both engines make the same package.
+type pipelineActivities struct {
+	transcode   Service
+	jobs        repository.JobRepository
+	versions    repository.VideoVersionRepository
+	transcripts repository.TranscriptRepository
+	courses     CourseService
+	// search is nil without a content index to export to.
+	search SearchExportService
+	store  objectstore.Store
+	cfg    *config.Config
+}
+
+// Probe fails the job of a source ffprobe can't read before a worker
+// downloads it. Sources not yet in the bucket, from a drive, and HLS sources
+// are left to the encode's own checks.
+func (a *pipelineActivities) Probe(ctx context.Context, message dto.JobMessage) error {
+	if message.Source != nil || isHLSSource(message.ObjectPath) {
+		return nil
+	}
+	_, err := a.store.StatObject(ctx, a.cfg.MinIOBucket, message.ObjectPath, minio.StatObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return a.reject(ctx, message.JobId, "source not found")
+	}
+	if err != nil {
+		return err
+	}
+	link, err := a.store.PresignedGetObject(ctx, a.cfg.MinIOBucket, message.ObjectPath, pipelineStageTimeout, nil)
+	if err != nil {
+		return err
+	}
+	media, err := ProbeMedia(ctx, link.String())
+	if err != nil {
+		return err
+	}
+	if media.VideoStream() == nil {
+		return a.reject(ctx, message.JobId, "no video stream")
+	}
+	return nil
+}
+
+// reject fails the job with the probe's reason and ends its pipeline.
+func (a *pipelineActivities) reject(ctx context.Context, jobId uuid.UUID, reason string) error {
+	if err := a.jobs.FailJob(ctx, jobId, constant.ErrorClassProbe, constant.ErrorKindSourceCorrupt, reason); err != nil {
+		return err
+	}
+	return temporal.NewNonRetryableApplicationError(reason, "probe", nil)
+}
+
+// Encode runs the job as a consumer would. A job handed back or left to
+// another worker's claim is retried until it completes or fails.
+func (a *pipelineActivities) Encode(ctx context.Context, message dto.JobMessage) (*pipelineOutput, error) {
+	heartbeatCtx, stopHeartbeat := context.WithCancel(ctx)
+	defer stopHeartbeat()
+	go func() {
+		ticker := time.NewTicker(pipelineHeartbeatTimeout / 4)
+		defer ticker.Stop()
+		for {
+			activity.RecordHeartbeat(heartbeatCtx)
+			select {
+			case <-heartbeatCtx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	if err := a.transcode.Process(ctx, message); err != nil {
+		return nil, err
+	}
+	job, err := a.jobs.FindJobById(ctx, message.JobId)
+	if err != nil {
+		return nil, err
+	}
+	switch job.Status {
+	case constant.JobStatusCompleted:
+		// The package's prefix is addressed by its content, so it's read
+		// from the version the job published.
+		version, err := a.versions.FindJobVersion(ctx, job.ID)
+		if err != nil {
+			return nil, err
+		}
+		return &pipelineOutput{
+			JobId:    job.ID,
+			LessonId: job.EntityId,
+			Playlist: version.PlaylistKey,
+		}, nil
+	case constant.JobStatusFailed:
+		reason := "job failed"
+		if job.ErrorMessage != nil {
+			reason = *job.ErrorMessage
+		}
+		return nil, temporal.NewNonRetryableApplicationError(reason, "job", nil)
+	case constant.JobStatusQualityFailed:
+		return nil, temporal.NewNonRetryableApplicationError(ErrQualityFailed.Error(), "job", nil)
+	default:
+		return nil, fmt.Errorf("job is %s", job.Status)
+	}
+}
+
+// Captions reports whether the lesson has a caption track yet.
+func (a *pipelineActivities) Captions(ctx context.Context, lessonId uuid.UUID) (bool, error) {
+	captions, err := a.transcripts.ListCaptions(ctx, lessonId)
+	if err != nil {
+		return false, err
+	}
+	for _, caption := range captions {
+		if caption.ObjectKey != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// QC checks the published package as students will fetch it, its
+// renditions against each other.
+func (a *pipelineActivities) QC(ctx context.Context, output pipelineOutput) error {
+	report, err := VerifyHLS(ctx, a.store, a.cfg.MinIOBucket, output.Playlist, 0)
+	if err != nil {
+		return err
+	}
+	if err := report.Err(); err != nil {
+		zerolog.Ctx(ctx).Error().Interface("report", report).Str("job_id", output.JobId.String()).Msg("published package failed verification")
+		return temporal.NewNonRetryableApplicationError(err.Error(), "qc", nil)
+	}
+	return nil
+}
+
+// Publish announces the lesson again now its captions are in, for a course
+// waiting on them, and exports it to the content index.
+func (a *pipelineActivities) Publish(ctx context.Context, output pipelineOutput) error {
+	if err := a.courses.Check(ctx, output.LessonId); err != nil {
+		return err
+	}
+	if a.search == nil {
+		return nil
+	}
+	return a.search.Export(ctx, output.LessonId)
+}
+
+func NewPipelineService(temporal client.Client, transcode Service, jobs repository.JobRepository, versions repository.VideoVersionRepository, transcripts repository.TranscriptRepository, courses CourseService, search SearchExportService, store objectstore.Store, cfg *config.Config) PipelineService {
+	return &pipelineService{
+		client: temporal,
+		activities: &pipelineActivities{
+			transcode:   transcode,
+			jobs:        jobs,
+			versions:    versions,
+			transcripts: transcripts,
+			courses:     courses,
+			search:      search,
+			store:       store,
+			cfg:         cfg,
+		},
+		cfg: cfg,
+	}
+}
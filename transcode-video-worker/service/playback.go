@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/dto"
+	"worker-transcode/pkg/cdn"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// PlaybackService grants students playback of lesson videos from the CDN,
+// which serves a package's playlists and segments only to the holders of a
+// grant for it.
+type PlaybackService interface {
+	// Grant signs the user's access to the lesson's package, for their
+	// tenant's playback TTL. Only users enrolled in the lesson's course are
+	// granted it.
+	Grant(ctx context.Context, lessonId, userId uuid.UUID) (*dto.PlaybackGrant, error)
+}
+
+type playbackService struct {
+	repo   repository.PlaybackRepository
+	signer cdn.Signer
+	cfg    *config.Config
+}
+
+func (s *playbackService) Grant(ctx context.Context, lessonId, userId uuid.UUID) (*dto.PlaybackGrant, error) {
+	playback, err := s.repo.FindLessonPlayback(ctx, lessonId, userId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, fmt.Errorf("lesson %s not found", lessonId))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !playback.Enrolled {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("user %s is not enrolled in the course of lesson %s", userId, lessonId))
+	}
+	if !strings.HasSuffix(playback.VideoUrl, ".m3u8") || strings.Contains(playback.VideoUrl, "://") {
+		return nil, errors.Join(ErrNotFound, fmt.Errorf("lesson %s has no video on the CDN", lessonId))
+	}
+
+	ttl := s.cfg.CDNSigning.TTL
+	if playback.PlaybackTTL != nil {
+		ttl = *playback.PlaybackTTL
+	}
+	expires := time.Now().UTC().Add(time.Duration(ttl) * time.Second).Truncate(time.Second)
+	grant, err := s.signer.Sign(path.Dir(playback.VideoUrl), expires)
+	if err != nil {
+		return nil, err
+	}
+
+	cookies := make([]dto.PlaybackCookie, 0, len(grant.Cookies))
+	for _, cookie := range grant.Cookies {
+		cookies = append(cookies, dto.PlaybackCookie{
+			Name:    cookie.Name,
+			Value:   cookie.Value,
+			Domain:  s.cfg.CDNSigning.CookieDomain,
+			Path:    cookie.Path,
+			Expires: cookie.Expires,
+		})
+	}
+	return &dto.PlaybackGrant{
+		LessonId:  lessonId,
+		URL:       grant.URL + path.Base(playback.VideoUrl),
+		Cookies:   cookies,
+		ExpiresAt: grant.Expires,
+	}, nil
+}
+
+func NewPlaybackService(repo repository.PlaybackRepository, signer cdn.Signer, cfg *config.Config) PlaybackService {
+	return &playbackService{
+		repo:   repo,
+		signer: signer,
+		cfg:    cfg,
+	}
+}
@@ -0,0 +1,327 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/pkg/alerting"
+	"worker-transcode/pkg/cdn"
+	"worker-transcode/pkg/metrics"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// playbackPlaylistLimit bounds how much of a playlist the probe reads.
+	playbackPlaylistLimit = 4 << 20
+	// playbackSegmentBytes is how much of a segment the probe asks for: enough
+	// to see the CDN serve it without pulling whole renditions every round.
+	playbackSegmentBytes = 256 << 10
+	// playbackURLTTL is how long the presigned URLs of a probe stay valid.
+	playbackURLTTL = 5 * time.Minute
+)
+
+// PlaybackProbeService checks that published lesson videos still play, by
+// fetching a sample of them through the same path students do. Failures of
+// the bucket, the CDN in front of it or a package missing objects are
+// otherwise only reported by students.
+type PlaybackProbeService interface {
+	// Run probes every PLAYBACK_PROBE_INTERVAL minutes until ctx is done and
+	// alerts on the videos that failed. Only the leader runs it.
+	Run(ctx context.Context)
+	// Probe fetches a random sample of published videos.
+	Probe(ctx context.Context) (*PlaybackProbeReport, error)
+}
+
+// PlaybackProbeReport is the result of one round of the playback probe.
+type PlaybackProbeReport struct {
+	ProbedAt time.Time       `json:"probed_at"`
+	Videos   []PlaybackCheck `json:"videos"`
+}
+
+// PlaybackCheck is how one published video fetched. Slow is set when every
+// file was served but some took longer than the probe's latency budget.
+type PlaybackCheck struct {
+	LessonId uuid.UUID       `json:"lesson_id"`
+	Playlist string          `json:"playlist"`
+	Fetches  []PlaybackFetch `json:"fetches"`
+	Problems []string        `json:"problems"`
+	Slow     bool            `json:"slow"`
+}
+
+// PlaybackFetch is one file the probe fetched: the master playlist, the
+// variant it picked or one of the variant's segments.
+type PlaybackFetch struct {
+	Kind    string  `json:"kind"`
+	URI     string  `json:"uri"`
+	Status  int     `json:"status"`
+	Seconds float64 `json:"seconds"`
+}
+
+type playbackProbeService struct {
+	repo   repository.PlaybackRepository
+	client *http.Client
+	store  objectstore.Store
+	signer cdn.Signer
+	cfg    *config.Config
+}
+
+func (s *playbackProbeService) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(s.cfg.PlaybackProbe.Interval) * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		report, err := s.Probe(ctx)
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to sample published videos for playback probe")
+		} else {
+			s.alert(ctx, report)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *playbackProbeService) Probe(ctx context.Context) (*PlaybackProbeReport, error) {
+	lessons, err := s.repo.SamplePublishedVideos(ctx, s.cfg.PlaybackProbe.Samples)
+	if err != nil {
+		return nil, err
+	}
+	report := &PlaybackProbeReport{ProbedAt: time.Now().UTC(), Videos: []PlaybackCheck{}}
+	for _, lesson := range lessons {
+		check := s.probeVideo(ctx, lesson.Id, lesson.VideoUrl)
+		switch {
+		case len(check.Problems) == 0:
+			metrics.PlaybackProbesTotal.WithLabelValues("ok").Inc()
+		case check.Slow:
+			metrics.PlaybackProbesTotal.WithLabelValues("slow").Inc()
+		default:
+			metrics.PlaybackProbesTotal.WithLabelValues("unreachable").Inc()
+		}
+		report.Videos = append(report.Videos, check)
+	}
+	return report, nil
+}
+
+func (s *playbackProbeService) alert(ctx context.Context, report *PlaybackProbeReport) {
+	var problems []alerting.PlaybackProblem
+	for _, check := range report.Videos {
+		if len(check.Problems) == 0 {
+			continue
+		}
+		zerolog.Ctx(ctx).Warn().
+			Str("lesson_id", check.LessonId.String()).
+			Str("playlist", check.Playlist).
+			Strs("problems", check.Problems).
+			Msg("published video failed playback probe")
+		problems = append(problems, alerting.PlaybackProblem{LessonId: check.LessonId.String(), Problem: check.Problems[0]})
+	}
+	zerolog.Ctx(ctx).Info().Int("sampled", len(report.Videos)).Int("failed", len(problems)).Msg("playback probe done")
+	alerting.PlaybackUnhealthy(ctx, len(report.Videos), problems)
+}
+
+// probeVideo fetches the video's master playlist, a random variant of it and
+// the variant's first, last and some random segments between.
+func (s *playbackProbeService) probeVideo(ctx context.Context, lessonId uuid.UUID, masterKey string) (check PlaybackCheck) {
+	check = PlaybackCheck{LessonId: lessonId, Playlist: masterKey, Fetches: []PlaybackFetch{}, Problems: []string{}}
+	unreachable := false
+	fetch := func(kind, key string, limit int64) []byte {
+		body, fetched, err := s.fetch(ctx, kind, key, limit)
+		check.Fetches = append(check.Fetches, fetched)
+		switch {
+		case err != nil:
+			unreachable = true
+			check.Problems = append(check.Problems, fmt.Sprintf("%s %s: %v", kind, fetched.URI, err))
+			return nil
+		case fetched.Seconds*1000 > float64(s.cfg.PlaybackProbe.MaxLatency):
+			check.Problems = append(check.Problems, fmt.Sprintf("%s %s took %.2fs", kind, fetched.URI, fetched.Seconds))
+		}
+		return body
+	}
+	defer func() { check.Slow = len(check.Problems) > 0 && !unreachable }()
+
+	body := fetch("master", masterKey, playbackPlaylistLimit)
+	if body == nil {
+		return check
+	}
+	master, err := scanLines(bufio.NewScanner(bytes.NewReader(body)))
+	if err != nil {
+		unreachable = true
+		check.Problems = append(check.Problems, fmt.Sprintf("master %s: %v", masterKey, err))
+		return check
+	}
+	var variants []string
+	for i, line := range master {
+		if strings.HasPrefix(line, "#EXT-X-STREAM-INF:") && i+1 < len(master) {
+			variants = append(variants, master[i+1])
+		}
+	}
+	if len(variants) == 0 {
+		unreachable = true
+		check.Problems = append(check.Problems, fmt.Sprintf("master %s references no variants", masterKey))
+		return check
+	}
+
+	variantKey := resolvePlaybackURI(masterKey, variants[rand.IntN(len(variants))])
+	body = fetch("variant", variantKey, playbackPlaylistLimit)
+	if body == nil {
+		return check
+	}
+	lines, err := scanLines(bufio.NewScanner(bytes.NewReader(body)))
+	if err != nil {
+		unreachable = true
+		check.Problems = append(check.Problems, fmt.Sprintf("variant %s: %v", variantKey, err))
+		return check
+	}
+	var segments []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "#EXT-X-MAP:") {
+			if match := uriPattern.FindStringSubmatch(line); match != nil {
+				segments = append(segments, match[1])
+			}
+			continue
+		}
+		if line != "" && !strings.HasPrefix(line, "#") {
+			segments = append(segments, line)
+		}
+	}
+	if len(segments) == 0 {
+		unreachable = true
+		check.Problems = append(check.Problems, fmt.Sprintf("variant %s has no segments", variantKey))
+		return check
+	}
+	for _, segment := range sampleSegments(segments, s.cfg.PlaybackProbe.Segments) {
+		fetch("segment", resolvePlaybackURI(variantKey, segment), playbackSegmentBytes)
+	}
+	return check
+}
+
+// fetch GETs key through the playback path, reading up to limit bytes of it,
+// and records how long that took.
+func (s *playbackProbeService) fetch(ctx context.Context, kind, key string, limit int64) ([]byte, PlaybackFetch, error) {
+	fetched := PlaybackFetch{Kind: kind, URI: key}
+	link, cookies, err := s.playbackURL(ctx, key)
+	if err != nil {
+		return nil, fetched, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, fetched, err
+	}
+	for _, cookie := range cookies {
+		req.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+	}
+	if kind == "segment" {
+		req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", limit-1))
+	}
+
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fetched, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit))
+	fetched.Seconds = time.Since(start).Seconds()
+	fetched.Status = resp.StatusCode
+	metrics.Observe(ctx, metrics.PlaybackFetchDuration.WithLabelValues(kind), fetched.Seconds)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return nil, fetched, fmt.Errorf("returned %s", resp.Status)
+	}
+	if err != nil {
+		return nil, fetched, err
+	}
+	if len(body) == 0 {
+		return nil, fetched, errors.New("is empty")
+	}
+	return body, fetched, nil
+}
+
+// playbackURL is where students fetch key from, and the cookies they send
+// with it: under the CDN's URL when one is set, with a grant of the key's
+// directory when the CDN is signed, or a presigned URL of the bucket.
+// Absolute URIs are fetched as they are.
+func (s *playbackProbeService) playbackURL(ctx context.Context, key string) (string, []cdn.Cookie, error) {
+	if strings.Contains(key, "://") {
+		return key, nil, nil
+	}
+	if s.signer != nil {
+		grant, err := s.signer.Sign(path.Dir(key), time.Now().Add(playbackURLTTL))
+		if err != nil {
+			return "", nil, err
+		}
+		return grant.URL + path.Base(key), grant.Cookies, nil
+	}
+	if base := s.cfg.PlaybackProbe.BaseURL; base != "" {
+		return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(key, "/"), nil, nil
+	}
+	link, err := s.store.PresignedGetObject(ctx, s.cfg.MinIOBucket, key, playbackURLTTL, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	return link.String(), nil, nil
+}
+
+// resolvePlaybackURI resolves a URI of the playlist at key.
+func resolvePlaybackURI(key, uri string) string {
+	if strings.Contains(uri, "://") {
+		return uri
+	}
+	return path.Join(path.Dir(key), uri)
+}
+
+// sampleSegments picks up to count of segments: the first, which is the
+// init segment of an fMP4 playlist, the last and random ones between.
+func sampleSegments(segments []string, count int) []string {
+	if count >= len(segments) {
+		return segments
+	}
+	if count <= 0 {
+		return nil
+	}
+	picked := []int{0}
+	if count > 1 {
+		picked = append(picked, len(segments)-1)
+	}
+	for _, i := range rand.Perm(len(segments) - 2) {
+		if len(picked) == count {
+			break
+		}
+		picked = append(picked, i+1)
+	}
+	slices.Sort(picked)
+
+	sample := make([]string, 0, len(picked))
+	for _, i := range picked {
+		sample = append(sample, segments[i])
+	}
+	return sample
+}
+
+// NewPlaybackProbeService probes the CDN with grants of signer, which is nil
+// when the CDN isn't signed.
+func NewPlaybackProbeService(repo repository.PlaybackRepository, store objectstore.Store, signer cdn.Signer, cfg *config.Config) PlaybackProbeService {
+	return &playbackProbeService{
+		repo:   repo,
+		client: &http.Client{Timeout: 30 * time.Second},
+		store:  store,
+		signer: signer,
+		cfg:    cfg,
+	}
+}
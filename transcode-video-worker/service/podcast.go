@@ -0,0 +1,510 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/correlation"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/pkg/reporting"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// PodcastService publishes courses as private podcasts, so students can
+// follow a course in a podcast app: an episode per lecture, holding the
+// audio rendition of its video remuxed into an M4A. Each student subscribes
+// at their own signed feed URL, which stops working once they leave the
+// course.
+type PodcastService interface {
+	// Request queues a build of the course's podcast. Episodes are only made
+	// for lessons whose video changed since the last build.
+	Request(ctx context.Context, courseId uuid.UUID, request dto.PodcastRequest) (*entities.PodcastFeed, error)
+	// Link returns the feed URL of an enrolled student.
+	Link(ctx context.Context, courseId, userId uuid.UUID) (*dto.PodcastLink, error)
+	// Feed renders the course's RSS feed for the student its token was
+	// signed for.
+	Feed(ctx context.Context, courseId, userId uuid.UUID, token string) ([]byte, error)
+	// Process runs a podcast build job.
+	Process(ctx context.Context, message dto.PodcastMessage) error
+}
+
+type podcastService struct {
+	repo      repository.PodcastRepository
+	jobs      repository.JobRepository
+	events    repository.JobEventRepository
+	publisher rabbitmq.Publisher
+	store     objectstore.Store
+	cfg       *config.Config
+}
+
+func (s *podcastService) Request(ctx context.Context, courseId uuid.UUID, request dto.PodcastRequest) (*entities.PodcastFeed, error) {
+	if _, err := s.repo.FindCourse(ctx, courseId); errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	} else if err != nil {
+		return nil, err
+	}
+
+	// A course's jobs are filed under its upload purpose, the only one whose
+	// entity is a course.
+	job := &entities.Job{
+		ID:         uuid.New(),
+		EntityId:   courseId,
+		EntityType: string(constant.EntityTypeCourseThumbnail),
+		Status:     constant.JobStatusPending,
+		JobType:    constant.JobTypePodcast,
+		UserId:     request.UserId,
+	}
+	if correlationId := correlation.FromContext(ctx); correlationId != "" {
+		job.CorrelationId = &correlationId
+	}
+	feed := &entities.PodcastFeed{
+		ID:       uuid.New(),
+		CourseId: courseId,
+		JobId:    job.ID,
+	}
+
+	if err := s.jobs.CreateJob(ctx, job); err != nil {
+		return nil, err
+	}
+	if err := s.repo.CreateFeed(ctx, feed); err != nil {
+		return nil, err
+	}
+	message := dto.PodcastMessage{JobId: job.ID}
+	if err := s.publisher.Publish(ctx, rabbitmq.PodcastTopology.Exchange, rabbitmq.PodcastTopology.RoutingKey, message); err != nil {
+		return nil, err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("job_id", job.ID.String()).
+		Str("course_id", courseId.String()).
+		Msg("course podcast build queued")
+	return feed, nil
+}
+
+func (s *podcastService) Link(ctx context.Context, courseId, userId uuid.UUID) (*dto.PodcastLink, error) {
+	enrolled, err := s.repo.IsEnrolled(ctx, courseId, userId)
+	if err != nil {
+		return nil, err
+	}
+	if !enrolled {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("user %s is not enrolled in course %s", userId, courseId))
+	}
+	return &dto.PodcastLink{
+		CourseId: courseId,
+		UserId:   userId,
+		URL:      fmt.Sprintf("%s/podcasts/%s/%s/%s/feed.xml", s.cfg.Podcast.BaseURL, courseId, userId, podcastToken(s.cfg.Podcast.SigningKey, courseId, userId)),
+	}, nil
+}
+
+// Feed answers a token that doesn't match, a student no longer enrolled and
+// a course without a podcast alike, so a feed URL reveals nothing once it
+// stops working.
+func (s *podcastService) Feed(ctx context.Context, courseId, userId uuid.UUID, token string) ([]byte, error) {
+	expected := podcastToken(s.cfg.Podcast.SigningKey, courseId, userId)
+	if !hmac.Equal([]byte(expected), []byte(token)) {
+		return nil, errors.Join(ErrNotFound, errors.New("podcast feed not found"))
+	}
+	enrolled, err := s.repo.IsEnrolled(ctx, courseId, userId)
+	if err != nil {
+		return nil, err
+	}
+	if !enrolled {
+		return nil, errors.Join(ErrNotFound, errors.New("podcast feed not found"))
+	}
+	feed, err := s.repo.FindLatestFeed(ctx, courseId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, errors.New("podcast feed not found"))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	course, err := s.repo.FindCourse(ctx, courseId)
+	if err != nil {
+		return nil, err
+	}
+	lessons, err := s.repo.ListLessons(ctx, courseId)
+	if err != nil {
+		return nil, err
+	}
+	episodes, err := s.repo.ListEpisodes(ctx, courseId)
+	if err != nil {
+		return nil, err
+	}
+	byLesson := make(map[uuid.UUID]*entities.PodcastEpisode, len(episodes))
+	for _, episode := range episodes {
+		byLesson[episode.LessonId] = episode
+	}
+
+	ttl := time.Duration(s.cfg.Podcast.LinkTTL) * time.Second
+	channel := podcastChannel{
+		Title:       course.Title,
+		Link:        s.cfg.Podcast.BaseURL,
+		Description: course.Description,
+		Language:    course.Language,
+		Block:       "Yes",
+		LastBuild:   feed.BuiltAt.UTC().Format(time.RFC1123Z),
+	}
+	if artwork := s.artworkURL(ctx, course.Image, ttl); artwork != "" {
+		channel.Image = &podcastImage{Href: artwork}
+	}
+	// Episodes whose lesson was replaced since the build wait for the next.
+	for i, lesson := range lessons {
+		episode, ok := byLesson[lesson.LessonId]
+		if !ok || episode.SourcePlaylist != lesson.VideoUrl {
+			continue
+		}
+		link, err := s.store.PresignedGetObject(ctx, s.cfg.MinIOBucket, episode.ObjectKey, ttl, url.Values{})
+		if err != nil {
+			return nil, err
+		}
+		channel.Items = append(channel.Items, podcastItem{
+			Title:     lesson.Title,
+			GUID:      podcastGUID{Value: lesson.LessonId.String(), IsPermaLink: "false"},
+			PubDate:   episode.UpdatedAt.UTC().Format(time.RFC1123Z),
+			Enclosure: podcastEnclosure{URL: link.String(), Length: episode.SizeBytes, Type: "audio/mp4"},
+			Duration:  strconv.Itoa(int(math.Round(episode.DurationSeconds))),
+			Episode:   i + 1,
+		})
+	}
+
+	body, err := xml.MarshalIndent(podcastRSS{
+		Version: "2.0",
+		ITunes:  "http://www.itunes.com/dtds/podcast-1.0.dtd",
+		Channel: channel,
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+// artworkURL is where a podcast app fetches the course's cover: the cover
+// itself when it is a URL, else a link to its object. A course without a
+// cover, or whose cover can't be linked, has no artwork.
+func (s *podcastService) artworkURL(ctx context.Context, image string, ttl time.Duration) string {
+	if image == "" || strings.HasPrefix(image, "http://") || strings.HasPrefix(image, "https://") {
+		return image
+	}
+	link, err := s.store.PresignedGetObject(ctx, s.cfg.MinIOBucket, image, ttl, url.Values{})
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("image", image).Msg("failed to link course cover")
+		return ""
+	}
+	return link.String()
+}
+
+func (s *podcastService) Process(ctx context.Context, message dto.PodcastMessage) (err error) {
+	job, err := s.jobs.FindJobById(ctx, message.JobId)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to find job by id")
+		return err
+	}
+	if job.Status != constant.JobStatusPending {
+		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("job is not pending")
+		return nil
+	}
+	feed, err := s.repo.FindFeedByJob(ctx, message.JobId)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to find podcast feed")
+		return err
+	}
+
+	claimed, err := s.jobs.ClaimJob(ctx, message.JobId, correlation.FromContext(ctx), workerFromContext(ctx))
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to update job status")
+		return err
+	}
+	if !claimed {
+		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("job already claimed by another delivery")
+		return nil
+	}
+	rabbitmq.Claimed(ctx)
+	ctx = withTimeline(ctx, s.events, job.ID)
+	recordStatus(ctx, constant.JobStatusPending, constant.JobStatusProcessing)
+
+	stage := constant.ErrorClassWorkspace
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = panicked(ctx, recovered)
+		}
+		if shuttingDown(ctx, err) {
+			err = handOff(ctx, s.jobs, message.JobId, err)
+			return
+		}
+		err = classify(err)
+		recordOutcome(ctx, job, stage, err)
+		recordOutcomeEvents(ctx, stage, err)
+		if err != nil {
+			if errors.Is(err, ErrNonRetryable) {
+				if updateErr := s.jobs.FailJob(ctx, message.JobId, stage, errorKind(err), err.Error()); updateErr != nil {
+					zerolog.Ctx(ctx).Error().Err(updateErr).Msg("failed to update job status")
+				}
+				reporting.CaptureFailure(ctx, err, reporting.Failure{
+					JobId:   message.JobId.String(),
+					JobType: string(job.JobType),
+					Stage:   string(stage),
+					Output:  failureOutput(err),
+					Extra:   map[string]interface{}{"course_id": feed.CourseId.String()},
+				})
+				err = nil
+			} else {
+				if updateErr := s.jobs.UpdateStatusJob(ctx, constant.JobStatusPending, message.JobId); updateErr != nil {
+					zerolog.Ctx(ctx).Error().Err(updateErr).Msg("failed to update job status")
+				}
+			}
+		}
+	}()
+
+	tempDir := filepath.Join("temp", message.JobId.String())
+	defer os.RemoveAll(tempDir)
+	if err = os.MkdirAll(tempDir, os.ModePerm); err != nil {
+		return errors.Join(ErrNonRetryable, err)
+	}
+
+	stage = constant.ErrorClassDatabase
+	lessons, err := s.repo.ListLessons(ctx, feed.CourseId)
+	if err != nil {
+		return err
+	}
+	episodes, err := s.repo.ListEpisodes(ctx, feed.CourseId)
+	if err != nil {
+		return err
+	}
+	byLesson := make(map[uuid.UUID]*entities.PodcastEpisode, len(episodes))
+	for _, episode := range episodes {
+		byLesson[episode.LessonId] = episode
+	}
+
+	made := 0
+	for _, lesson := range lessons {
+		previous, ok := byLesson[lesson.LessonId]
+		delete(byLesson, lesson.LessonId)
+		if ok && previous.SourcePlaylist == lesson.VideoUrl {
+			continue
+		}
+		var episode *entities.PodcastEpisode
+		err = traceStage(ctx, "episode", func(ctx context.Context) error {
+			var episodeErr error
+			episode, episodeErr = s.makeEpisode(ctx, &stage, feed, lesson, filepath.Join(tempDir, lesson.LessonId.String()))
+			return episodeErr
+		})
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Str("lesson_id", lesson.LessonId.String()).Msg("failed to make podcast episode")
+			return err
+		}
+		stage = constant.ErrorClassDatabase
+		if err = s.repo.SaveEpisode(ctx, episode); err != nil {
+			return err
+		}
+		if ok && previous.ObjectKey != episode.ObjectKey {
+			s.removeEpisodeAudio(ctx, previous.ObjectKey)
+		}
+		made++
+	}
+	// What is left are episodes of lessons that were removed from the course
+	// or lost their video.
+	for _, episode := range byLesson {
+		if err = s.repo.DeleteEpisode(ctx, episode.LessonId); err != nil {
+			return err
+		}
+		s.removeEpisodeAudio(ctx, episode.ObjectKey)
+	}
+
+	if err = s.repo.MarkFeedBuilt(ctx, feed.ID, len(lessons)); err != nil {
+		return err
+	}
+	if err = s.jobs.UpdateStatusJob(ctx, constant.JobStatusCompleted, message.JobId); err != nil {
+		return err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("job_id", message.JobId.String()).
+		Str("course_id", feed.CourseId.String()).
+		Int("episodes", len(lessons)).
+		Int("made", made).
+		Int("removed", len(byLesson)).
+		Msg("course podcast built")
+	return nil
+}
+
+// makeEpisode remuxes the audio rendition of the lesson's package into an
+// M4A and uploads it, setting stage as it goes.
+func (s *podcastService) makeEpisode(ctx context.Context, stage *constant.ErrorClass, feed *entities.PodcastFeed, lesson entities.PodcastLesson, dir string) (*entities.PodcastEpisode, error) {
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, errors.Join(ErrNonRetryable, err)
+	}
+
+	*stage = constant.ErrorClassDownload
+	input, err := downloadHLSAudio(ctx, s.store, s.cfg.MinIOBucket, lesson.VideoUrl, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	*stage = constant.ErrorClassTranscode
+	output := filepath.Join(dir, "episode.m4a")
+	if err := runFFmpeg(ctx, podcastAudioArgs(input, output), nil); err != nil {
+		return nil, errors.Join(ErrNonRetryable, err)
+	}
+	*stage = constant.ErrorClassProbe
+	media, err := ProbeMedia(ctx, output)
+	if err != nil {
+		return nil, classified(constant.ErrorKindSourceCorrupt, err)
+	}
+
+	*stage = constant.ErrorClassUpload
+	key := fmt.Sprintf("lessons/%s/podcast/%s.m4a", lesson.LessonId, feed.JobId)
+	info, err := s.store.FPutObject(ctx, s.cfg.MinIOBucket, key, output, minio.PutObjectOptions{ContentType: "audio/mp4"})
+	if err != nil {
+		return nil, err
+	}
+	return &entities.PodcastEpisode{
+		LessonId:        lesson.LessonId,
+		CourseId:        feed.CourseId,
+		SourcePlaylist:  lesson.VideoUrl,
+		ObjectKey:       key,
+		SizeBytes:       info.Size,
+		DurationSeconds: media.DurationSeconds(),
+	}, nil
+}
+
+func (s *podcastService) removeEpisodeAudio(ctx context.Context, key string) {
+	if err := s.store.RemoveObject(ctx, s.cfg.MinIOBucket, key, minio.RemoveObjectOptions{}); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("key", key).Msg("failed to remove podcast episode audio")
+	}
+}
+
+// downloadHLSAudio fetches the audio rendition of a master playlist with its
+// segments, or, for a package muxing audio into its variants, the lowest
+// bandwidth variant. The local playlist is returned.
+func downloadHLSAudio(ctx context.Context, client objectstore.Store, bucket, masterKey, dir string) (string, error) {
+	master, err := readObjectLines(ctx, client, bucket, masterKey)
+	if err != nil {
+		return "", fmt.Errorf("read master playlist: %w", err)
+	}
+
+	var audioURI, variantURI string
+	lowestBandwidth := math.MaxInt
+	for i, line := range master {
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MEDIA:") && strings.Contains(line, "TYPE=AUDIO"):
+			if match := uriPattern.FindStringSubmatch(line); match != nil && audioURI == "" {
+				audioURI = match[1]
+			}
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:") && i+1 < len(master):
+			bandwidth := 0
+			if match := bandwidthPattern.FindStringSubmatch(line); match != nil {
+				bandwidth, _ = strconv.Atoi(match[1])
+			}
+			if bandwidth < lowestBandwidth {
+				lowestBandwidth, variantURI = bandwidth, master[i+1]
+			}
+		}
+	}
+	if audioURI == "" {
+		audioURI = variantURI
+	}
+	if audioURI == "" {
+		return "", errors.Join(ErrNonRetryable, fmt.Errorf("master playlist %s lists no renditions", masterKey))
+	}
+	return downloadMediaPlaylist(ctx, client, bucket, path.Dir(masterKey), audioURI, dir)
+}
+
+// podcastAudioArgs remux a rendition's audio into an M4A podcast apps can
+// seek in before it is downloaded.
+func podcastAudioArgs(input, output string) []string {
+	return []string{
+		"-i", input,
+		"-map", "0:a:0",
+		"-vn",
+		"-c:a", "copy",
+		"-bsf:a", "aac_adtstoasc",
+		"-movflags", "+faststart",
+		"-y", output,
+	}
+}
+
+// podcastToken signs a student's feed URL for the course.
+func podcastToken(key string, courseId, userId uuid.UUID) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(courseId.String() + ":" + userId.String()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// podcastRSS is an RSS 2.0 feed with the iTunes tags podcast apps read. The
+// feed is blocked from podcast directories; it is a student's own.
+type podcastRSS struct {
+	XMLName xml.Name       `xml:"rss"`
+	Version string         `xml:"version,attr"`
+	ITunes  string         `xml:"xmlns:itunes,attr"`
+	Channel podcastChannel `xml:"channel"`
+}
+
+type podcastChannel struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	Description string        `xml:"description"`
+	Language    string        `xml:"language,omitempty"`
+	LastBuild   string        `xml:"lastBuildDate"`
+	Block       string        `xml:"itunes:block"`
+	Image       *podcastImage `xml:"itunes:image"`
+	Items       []podcastItem `xml:"item"`
+}
+
+type podcastImage struct {
+	Href string `xml:"href,attr"`
+}
+
+type podcastItem struct {
+	Title     string           `xml:"title"`
+	GUID      podcastGUID      `xml:"guid"`
+	PubDate   string           `xml:"pubDate"`
+	Enclosure podcastEnclosure `xml:"enclosure"`
+	Duration  string           `xml:"itunes:duration"`
+	Episode   int              `xml:"itunes:episode"`
+}
+
+type podcastGUID struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink string `xml:"isPermaLink,attr"`
+}
+
+type podcastEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+func NewPodcastService(repo repository.PodcastRepository, jobs repository.JobRepository, events repository.JobEventRepository, publisher rabbitmq.Publisher, store objectstore.Store, cfg *config.Config) PodcastService {
+	return &podcastService{
+		repo:      repo,
+		jobs:      jobs,
+		events:    events,
+		publisher: publisher,
+		store:     store,
+		cfg:       cfg,
+	}
+}
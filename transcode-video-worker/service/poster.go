@@ -0,0 +1,602 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"math"
+	"math/bits"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+const (
+	posterImage    = "poster.jpg"
+	postersDir     = "posters"
+	postersSidecar = "posters.json"
+)
+
+// An uploaded poster must be at least posterMinWidth by posterMinHeight,
+// and at most posterMaxPixels so a small file can't decode to a huge
+// bitmap.
+const (
+	posterMinWidth  = 320
+	posterMinHeight = 180
+	posterMaxPixels = 40_000_000
+)
+
+// errNoPoster is a published video's package without a poster sidecar.
+var errNoPoster = errors.New("no poster")
+
+// A frame whose mean luma is outside [posterMinLuma, posterMaxLuma] is taken
+// as black or washed out, and one whose luma spreads less than
+// posterMinContrast as flat, such as a fade or a blank slate. Candidates
+// within posterDedupDistance bits of each other's frameHash are the same
+// shot, and only the better one is kept.
+const (
+	posterMinLuma       = 0.08
+	posterMaxLuma       = 0.92
+	posterMinContrast   = 0.05
+	posterDedupDistance = 6
+	// posterGrid is how many columns of luma a frame is scored on.
+	posterGrid = 160
+)
+
+// posterSidecar is posters.json, written next to the master playlist: the
+// poster and its narrower sizes, where in the video it was taken and how it
+// was picked, and the candidates it can be replaced with, best first. It's
+// what the package's poster is: a new one is uploaded beside the old and
+// takes its place when the sidecar is written.
+type posterSidecar struct {
+	Poster     string                `json:"poster"`
+	Sizes      []posterSize          `json:"sizes"`
+	At         float64               `json:"at"`
+	Source     constant.PosterSource `json:"source"`
+	Duration   float64               `json:"duration"`
+	Candidates []posterCandidate     `json:"candidates"`
+}
+
+type posterSize struct {
+	Width int    `json:"width"`
+	Image string `json:"image"`
+}
+
+type posterCandidate struct {
+	Image string  `json:"image"`
+	At    float64 `json:"at"`
+	Score float64 `json:"score"`
+}
+
+// PosterService serves and replaces the poster frames picked when lessons
+// are transcoded.
+type PosterService interface {
+	Get(ctx context.Context, lessonId uuid.UUID) (*dto.LessonPoster, error)
+	// Pick replaces the lesson's poster with one of its candidates, or with
+	// the frame at a time the instructor chose, taken from the published
+	// package rather than by transcoding the video again.
+	Pick(ctx context.Context, lessonId uuid.UUID, request dto.PosterRequest) (*dto.LessonPoster, error)
+	// Upload replaces the lesson's poster with an image the instructor
+	// made, a JPEG or PNG at least posterMinWidth by posterMinHeight,
+	// resized to the poster's sizes. A video transcoded without a poster
+	// gets one.
+	Upload(ctx context.Context, lessonId uuid.UUID, image []byte) (*dto.LessonPoster, error)
+}
+
+type posterService struct {
+	repo  repository.PosterRepository
+	store objectstore.Store
+	cfg   *config.Config
+}
+
+func (s *posterService) Get(ctx context.Context, lessonId uuid.UUID) (*dto.LessonPoster, error) {
+	playlist, sidecar, err := s.find(ctx, lessonId)
+	if err != nil {
+		return nil, err
+	}
+	return lessonPoster(lessonId, path.Dir(playlist), sidecar), nil
+}
+
+func (s *posterService) Pick(ctx context.Context, lessonId uuid.UUID, request dto.PosterRequest) (*dto.LessonPoster, error) {
+	if (request.Candidate == nil) == (request.At == nil) {
+		return nil, errors.Join(ErrInvalidArgument, errors.New("a poster is picked by either candidate or at"))
+	}
+	playlist, sidecar, err := s.find(ctx, lessonId)
+	if err != nil {
+		return nil, err
+	}
+	prefix := path.Dir(playlist)
+
+	dir := filepath.Join("temp", "poster-"+uuid.NewString())
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	image := filepath.Join(dir, posterImage)
+
+	if request.Candidate != nil {
+		n := *request.Candidate
+		if n < 1 || n > len(sidecar.Candidates) {
+			return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("candidate: the video has %d", len(sidecar.Candidates)))
+		}
+		candidate := sidecar.Candidates[n-1]
+		if err := s.store.FGetObject(ctx, s.cfg.MinIOBucket, path.Join(prefix, candidate.Image), image, minio.GetObjectOptions{}); err != nil {
+			return nil, fmt.Errorf("download poster candidate: %w", err)
+		}
+		sidecar.At, sidecar.Source = candidate.At, constant.PosterSourceCandidate
+	} else {
+		at := *request.At
+		// A poster uploaded for a video transcoded without one doesn't
+		// know how long it is; a time past its end finds no frame.
+		if at < 0 || (sidecar.Duration > 0 && at >= sidecar.Duration) {
+			return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("at: the video is %.3f seconds long", sidecar.Duration))
+		}
+		if err := s.grab(ctx, playlist, at, image); err != nil {
+			return nil, err
+		}
+		sidecar.At, sidecar.Source = at, constant.PosterSourceOverride
+	}
+
+	if err := s.publish(ctx, prefix, sidecar, image); err != nil {
+		return nil, err
+	}
+	zerolog.Ctx(ctx).Info().
+		Str("lesson_id", lessonId.String()).
+		Str("source", string(sidecar.Source)).
+		Float64("at", sidecar.At).
+		Msg("lesson poster picked")
+	return lessonPoster(lessonId, prefix, sidecar), nil
+}
+
+func (s *posterService) Upload(ctx context.Context, lessonId uuid.UUID, raw []byte) (*dto.LessonPoster, error) {
+	header, format, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("poster must be a JPEG or PNG image: %w", err))
+	}
+	if header.Width < posterMinWidth || header.Height < posterMinHeight {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("poster is %dx%d, at least %dx%d is needed", header.Width, header.Height, posterMinWidth, posterMinHeight))
+	}
+	if header.Width*header.Height > posterMaxPixels {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("poster is %dx%d, more than %d pixels", header.Width, header.Height, posterMaxPixels))
+	}
+	// The header alone doesn't show a truncated or corrupt image.
+	if _, _, err := image.Decode(bytes.NewReader(raw)); err != nil {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("poster can't be read: %w", err))
+	}
+
+	playlist, sidecar, err := s.find(ctx, lessonId)
+	if errors.Is(err, errNoPoster) {
+		sidecar, err = &posterSidecar{Candidates: []posterCandidate{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	prefix := path.Dir(playlist)
+
+	dir := filepath.Join("temp", "poster-"+uuid.NewString())
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	upload := filepath.Join(dir, "upload."+format)
+	if err := os.WriteFile(upload, raw, 0644); err != nil {
+		return nil, err
+	}
+	sidecar.At, sidecar.Source = 0, constant.PosterSourceUpload
+	if err := s.publish(ctx, prefix, sidecar, upload); err != nil {
+		return nil, err
+	}
+	zerolog.Ctx(ctx).Info().
+		Str("lesson_id", lessonId.String()).
+		Int("width", header.Width).
+		Int("height", header.Height).
+		Msg("lesson poster uploaded")
+	return lessonPoster(lessonId, prefix, sidecar), nil
+}
+
+// publish makes the image at local the poster of the package under prefix.
+// It's resized to the poster's width and sizes and uploaded under names of
+// its own, then the sidecar is written to name them, so the poster and all
+// its sizes change at once. poster.jpg, which players may read by name, is
+// overwritten after, and the images of the poster replaced are removed.
+func (s *posterService) publish(ctx context.Context, prefix string, sidecar *posterSidecar, local string) error {
+	dir := filepath.Dir(local)
+	stem := path.Join(postersDir, "poster_"+uuid.NewString()[:8])
+	replaced := append([]posterSize{{Image: sidecar.Poster}}, sidecar.Sizes...)
+
+	if err := os.MkdirAll(filepath.Join(dir, postersDir), os.ModePerm); err != nil {
+		return err
+	}
+	poster := stem + ".jpg"
+	if err := grabFrame(ctx, local, 0, filepath.Join(dir, filepath.FromSlash(poster)), s.cfg.Poster.Width); err != nil {
+		return fmt.Errorf("resize poster: %w", err)
+	}
+	sizes, err := resizePoster(ctx, filepath.Join(dir, filepath.FromSlash(poster)), dir, stem, s.cfg.Poster.Sizes)
+	if err != nil {
+		return err
+	}
+	for _, image := range append([]string{poster}, posterImages(sizes)...) {
+		_, err := s.store.FPutObject(ctx, s.cfg.MinIOBucket, path.Join(prefix, image), filepath.Join(dir, filepath.FromSlash(image)),
+			minio.PutObjectOptions{ContentType: "image/jpeg"})
+		if err != nil {
+			return fmt.Errorf("upload poster: %w", err)
+		}
+	}
+
+	sidecar.Poster, sidecar.Sizes = poster, sizes
+	raw, err := json.MarshalIndent(sidecar, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = s.store.PutObject(ctx, s.cfg.MinIOBucket, path.Join(prefix, postersSidecar), bytes.NewReader(raw), int64(len(raw)),
+		minio.PutObjectOptions{ContentType: "application/json"})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.store.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: s.cfg.MinIOBucket, Object: path.Join(prefix, posterImage)},
+		minio.CopySrcOptions{Bucket: s.cfg.MinIOBucket, Object: path.Join(prefix, poster)})
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to copy poster to poster.jpg")
+	}
+	for _, old := range replaced {
+		candidate := slices.ContainsFunc(sidecar.Candidates, func(c posterCandidate) bool { return c.Image == old.Image })
+		if old.Image == "" || old.Image == posterImage || candidate {
+			continue
+		}
+		if err := s.store.RemoveObject(ctx, s.cfg.MinIOBucket, path.Join(prefix, old.Image), minio.RemoveObjectOptions{}); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Str("image", old.Image).Msg("failed to remove replaced poster")
+		}
+	}
+	return nil
+}
+
+// find returns the master playlist the lesson plays and its package's
+// poster sidecar.
+func (s *posterService) find(ctx context.Context, lessonId uuid.UUID) (string, *posterSidecar, error) {
+	playlist, err := s.repo.FindLessonVideo(ctx, lessonId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil, errors.Join(ErrNotFound, err)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if !isHLSSource(playlist) {
+		return "", nil, errors.Join(ErrNotFound, fmt.Errorf("lesson %s has no published video", lessonId))
+	}
+
+	object, err := s.store.GetObject(ctx, s.cfg.MinIOBucket, path.Join(path.Dir(playlist), postersSidecar), minio.GetObjectOptions{})
+	if err != nil {
+		return "", nil, err
+	}
+	defer object.Close()
+	sidecar := &posterSidecar{}
+	if err := json.NewDecoder(object).Decode(sidecar); err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return playlist, nil, errors.Join(ErrNotFound, errNoPoster, fmt.Errorf("lesson %s's video has no poster", lessonId))
+		}
+		return "", nil, fmt.Errorf("read poster sidecar: %w", err)
+	}
+	return playlist, sidecar, nil
+}
+
+// grab writes the frame at seconds into the package's highest variant to
+// output, next to which the segment holding it, the only one downloaded,
+// is kept.
+func (s *posterService) grab(ctx context.Context, playlist string, at float64, output string) error {
+	input, offset, err := downloadSegmentAt(ctx, s.store, s.cfg.MinIOBucket, playlist, at, filepath.Dir(output))
+	if err != nil {
+		return err
+	}
+	if err := grabFrame(ctx, input, offset, output, s.cfg.Poster.Width); err != nil {
+		return err
+	}
+	if _, err := os.Stat(output); err != nil {
+		return fmt.Errorf("no frame at %.3f seconds: %w", at, err)
+	}
+	return nil
+}
+
+func lessonPoster(lessonId uuid.UUID, prefix string, sidecar *posterSidecar) *dto.LessonPoster {
+	poster := &dto.LessonPoster{
+		LessonId:   lessonId,
+		Key:        path.Join(prefix, sidecar.Poster),
+		Sizes:      make([]dto.PosterSize, 0, len(sidecar.Sizes)),
+		At:         sidecar.At,
+		Source:     sidecar.Source,
+		Candidates: make([]dto.PosterCandidate, 0, len(sidecar.Candidates)),
+	}
+	for _, size := range sidecar.Sizes {
+		poster.Sizes = append(poster.Sizes, dto.PosterSize{Width: size.Width, Key: path.Join(prefix, size.Image)})
+	}
+	for _, candidate := range sidecar.Candidates {
+		poster.Candidates = append(poster.Candidates, dto.PosterCandidate{
+			Key:   path.Join(prefix, candidate.Image),
+			At:    candidate.At,
+			Score: candidate.Score,
+		})
+	}
+	return poster
+}
+
+// pickPosters samples frames spread over the video, keeps the best scored
+// distinct ones as candidates under outputDir/posters and copies the best
+// to poster.jpg, with a posters.json sidecar listing them, so they are
+// uploaded with the package. It returns the number of candidates.
+func pickPosters(ctx context.Context, inputFilepath, outputDir string, duration float64, cfg config.Poster) (int, error) {
+	dir := filepath.Join(outputDir, postersDir)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return 0, err
+	}
+
+	type sample struct {
+		at    float64
+		image string
+		score float64
+		hash  uint64
+	}
+	var samples []sample
+	for i := range max(cfg.Samples, 1) {
+		at := posterSampleTime(i, cfg.Samples, duration)
+		image := filepath.Join(dir, fmt.Sprintf("sample_%03d.jpg", i+1))
+		if err := grabFrame(ctx, inputFilepath, at, image, cfg.Width); err != nil {
+			return 0, err
+		}
+		// A seek into a damaged stretch can come back without a frame.
+		if _, err := os.Stat(image); err != nil {
+			continue
+		}
+		score, err := scoreFrame(image)
+		if err != nil {
+			return 0, err
+		}
+		hash, err := frameHash(image)
+		if err != nil {
+			return 0, err
+		}
+		samples = append(samples, sample{at: at, image: image, score: score, hash: hash})
+	}
+	if len(samples) == 0 {
+		return 0, os.Remove(dir)
+	}
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].score > samples[j].score })
+
+	sidecar := posterSidecar{Poster: posterImage, Source: constant.PosterSourceAuto, Duration: duration}
+	var kept []uint64
+	for _, sample := range samples {
+		duplicate := false
+		for _, hash := range kept {
+			if bits.OnesCount64(hash^sample.hash) <= posterDedupDistance {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate || len(kept) == max(cfg.Candidates, 1) {
+			if err := os.Remove(sample.image); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		name := fmt.Sprintf("%s/candidate_%02d.jpg", postersDir, len(kept)+1)
+		if err := os.Rename(sample.image, filepath.Join(outputDir, filepath.FromSlash(name))); err != nil {
+			return 0, err
+		}
+		kept = append(kept, sample.hash)
+		sidecar.Candidates = append(sidecar.Candidates, posterCandidate{Image: name, At: sample.at, Score: math.Round(sample.score*100) / 100})
+	}
+
+	best := sidecar.Candidates[0]
+	raw, err := os.ReadFile(filepath.Join(outputDir, filepath.FromSlash(best.Image)))
+	if err != nil {
+		return 0, err
+	}
+	if err := os.WriteFile(filepath.Join(outputDir, posterImage), raw, 0644); err != nil {
+		return 0, err
+	}
+	sidecar.At = best.At
+	if sidecar.Sizes, err = resizePoster(ctx, filepath.Join(outputDir, posterImage), outputDir, path.Join(postersDir, "poster"), cfg.Sizes); err != nil {
+		return 0, err
+	}
+
+	raw, err = json.MarshalIndent(sidecar, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	return len(sidecar.Candidates), os.WriteFile(filepath.Join(outputDir, postersSidecar), raw, 0644)
+}
+
+// discardPosters removes whatever a failed pick left in the output, so the
+// package is uploaded without a partial set.
+func discardPosters(outputDir string) {
+	os.RemoveAll(filepath.Join(outputDir, postersDir))
+	os.Remove(filepath.Join(outputDir, posterImage))
+	os.Remove(filepath.Join(outputDir, postersSidecar))
+}
+
+// resizePoster writes the poster at input at each of widths, as stem_<width>.jpg
+// under outputDir, and returns them.
+func resizePoster(ctx context.Context, input, outputDir, stem string, widths []int) ([]posterSize, error) {
+	sizes := make([]posterSize, 0, len(widths))
+	for _, width := range widths {
+		image := fmt.Sprintf("%s_%d.jpg", stem, width)
+		if err := grabFrame(ctx, input, 0, filepath.Join(outputDir, filepath.FromSlash(image)), width); err != nil {
+			return nil, fmt.Errorf("resize poster to %d: %w", width, err)
+		}
+		sizes = append(sizes, posterSize{Width: width, Image: image})
+	}
+	return sizes, nil
+}
+
+func posterImages(sizes []posterSize) []string {
+	images := make([]string, 0, len(sizes))
+	for _, size := range sizes {
+		images = append(images, size.Image)
+	}
+	return images
+}
+
+// posterSampleTime is the time of the i-th of n samples, spread from 5% to
+// 95% of the video, clear of fades in and out.
+func posterSampleTime(i, n int, duration float64) float64 {
+	if n <= 1 {
+		return duration / 2
+	}
+	return duration * (0.05 + 0.9*float64(i)/float64(n-1))
+}
+
+// grabFrame writes the frame at seconds into input as a JPEG at most width
+// wide.
+func grabFrame(ctx context.Context, input string, at float64, output string, width int) error {
+	args := []string{"-hide_banner", "-nostats", "-y",
+		"-ss", strconv.FormatFloat(at, 'f', 3, 64),
+		"-i", input,
+		"-map", "0:v:0",
+		"-frames:v", "1",
+		"-vf", fmt.Sprintf("scale='min(%d,iw)':-2", width),
+		"-q:v", "2",
+		output,
+	}
+	_, err := ffmpegStderr(ctx, args)
+	return err
+}
+
+// scoreFrame rates a frame as a poster: zero when it is black, washed out or
+// flat, otherwise its sharpness, the RMS of the Laplacian of its luma,
+// weighed by its contrast. Scores compare frames of one video.
+func scoreFrame(name string) (float64, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	img, err := jpeg.Decode(file)
+	if err != nil {
+		return 0, fmt.Errorf("decode frame %s: %w", name, err)
+	}
+
+	bounds := img.Bounds()
+	cols := min(posterGrid, bounds.Dx())
+	rows := max(cols*bounds.Dy()/max(bounds.Dx(), 1), 1)
+	luma := make([][]float64, rows)
+	var sum float64
+	for y := range rows {
+		luma[y] = make([]float64, cols)
+		for x := range cols {
+			r, g, b, _ := img.At(bounds.Min.X+x*bounds.Dx()/cols, bounds.Min.Y+y*bounds.Dy()/rows).RGBA()
+			luma[y][x] = (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 0xffff
+			sum += luma[y][x]
+		}
+	}
+	mean := sum / float64(rows*cols)
+	var spread float64
+	for y := range rows {
+		for x := range cols {
+			spread += (luma[y][x] - mean) * (luma[y][x] - mean)
+		}
+	}
+	contrast := math.Sqrt(spread / float64(rows*cols))
+	if mean < posterMinLuma || mean > posterMaxLuma || contrast < posterMinContrast || rows < 3 || cols < 3 {
+		return 0, nil
+	}
+
+	var edges float64
+	for y := 1; y < rows-1; y++ {
+		for x := 1; x < cols-1; x++ {
+			laplacian := 4*luma[y][x] - luma[y-1][x] - luma[y+1][x] - luma[y][x-1] - luma[y][x+1]
+			edges += laplacian * laplacian
+		}
+	}
+	sharpness := math.Sqrt(edges / float64((rows-2)*(cols-2)))
+	return sharpness * contrast * 1000, nil
+}
+
+// downloadSegmentAt fetches the segment of a master playlist's highest
+// variant that holds the frame at seconds, with its init segment, and
+// returns a local playlist of it and how far into it the frame is.
+func downloadSegmentAt(ctx context.Context, client objectstore.Store, bucket, masterKey string, at float64, dir string) (string, float64, error) {
+	master, err := readObjectLines(ctx, client, bucket, masterKey)
+	if err != nil {
+		return "", 0, fmt.Errorf("read master playlist: %w", err)
+	}
+	videoURI, _ := highestVariant(master)
+	if videoURI == "" {
+		return "", 0, fmt.Errorf("master playlist %s lists no variants", masterKey)
+	}
+	mediaKey := path.Join(path.Dir(masterKey), videoURI)
+	lines, err := readObjectLines(ctx, client, bucket, mediaKey)
+	if err != nil {
+		return "", 0, fmt.Errorf("read media playlist: %w", err)
+	}
+	segmentPrefix := path.Dir(mediaKey)
+
+	var (
+		header          []string
+		start, duration float64
+	)
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-KEY:") && !strings.Contains(line, "METHOD=NONE"):
+			return "", 0, errors.Join(ErrInvalidArgument, errors.New("the video is encrypted, pick one of its candidates instead"))
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			match := uriPattern.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+			local := path.Base(match[1])
+			if err := client.FGetObject(ctx, bucket, path.Join(segmentPrefix, match[1]), filepath.Join(dir, local), minio.GetObjectOptions{}); err != nil {
+				return "", 0, fmt.Errorf("download init segment %s: %w", match[1], err)
+			}
+			header = append(header, strings.Replace(line, match[1], local, 1))
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			duration, _ = strconv.ParseFloat(value, 64)
+		case line == "#EXTM3U" || strings.HasPrefix(line, "#EXT-X-VERSION:") || strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			header = append(header, line)
+		case line == "" || strings.HasPrefix(line, "#"):
+		default:
+			if at >= start+duration {
+				start += duration
+				continue
+			}
+			local := path.Base(line)
+			if err := client.FGetObject(ctx, bucket, path.Join(segmentPrefix, line), filepath.Join(dir, local), minio.GetObjectOptions{}); err != nil {
+				return "", 0, fmt.Errorf("download segment %s: %w", line, err)
+			}
+			playlist := append(header, fmt.Sprintf("#EXTINF:%.6f,", duration), local, "#EXT-X-ENDLIST")
+			name := filepath.Join(dir, "segment.m3u8")
+			if err := os.WriteFile(name, []byte(strings.Join(playlist, "\n")+"\n"), 0644); err != nil {
+				return "", 0, err
+			}
+			return name, at - start, nil
+		}
+	}
+	return "", 0, errors.Join(ErrInvalidArgument, fmt.Errorf("the video ends at %.3f seconds", start))
+}
+
+func NewPosterService(repo repository.PosterRepository, store objectstore.Store, cfg *config.Config) PosterService {
+	return &posterService{
+		repo:  repo,
+		store: store,
+		cfg:   cfg,
+	}
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+)
+
+// ErrInsufficientResources is returned for a job this worker lacks the disk or
+// memory to run. The job goes back to pending and its message back on the
+// queue after PreflightDelay, rather than failing halfway with ENOSPC.
+var ErrInsufficientResources = errors.New("insufficient resources")
+
+// scratch is the disk space reserved by the jobs running in this process.
+// Free space only drops as they download and encode, so without it jobs
+// starting together would all count the same free space.
+var scratch scratchReservations
+
+type scratchReservations struct {
+	mu       sync.Mutex
+	reserved uint64
+}
+
+// available returns the free space under dir the jobs running don't have
+// reserved, or false when it isn't measured.
+func (r *scratchReservations) available(dir string) (uint64, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	free, ok := freeDisk(dir)
+	if !ok {
+		return 0, false
+	}
+	return free - min(free, r.reserved), true
+}
+
+// reserve claims need bytes of the free space under dir until the returned
+// func is called.
+func (r *scratchReservations) reserve(dir string, need uint64) (func(), error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if free, ok := freeDisk(dir); ok && need > 0 {
+		available := uint64(0)
+		if free > r.reserved {
+			available = free - r.reserved
+		}
+		if available < need {
+			return nil, fmt.Errorf("%d MB of scratch space available, %d MB needed", available>>20, need>>20)
+		}
+	}
+
+	r.reserved += need
+	return sync.OnceFunc(func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.reserved -= need
+	}), nil
+}
+
+// preflight checks the worker has the memory to start a job and the scratch
+// space under dir it needs, and reserves that space until release is called.
+func preflight(ctx context.Context, cfg *config.Config, dir string, need uint64) (release func(), err error) {
+	if cfg.Server.MinFreeMemory > 0 {
+		minFree := uint64(cfg.Server.MinFreeMemory) << 20
+		if available, ok := availableMemory(); ok && available < minFree {
+			return nil, insufficientResources(cfg, fmt.Errorf("%d MB of memory available, %d MB needed", available>>20, cfg.Server.MinFreeMemory))
+		}
+	}
+
+	release, err = scratch.reserve(dir, need)
+	if err != nil {
+		return nil, insufficientResources(cfg, err)
+	}
+	zerolog.Ctx(ctx).Debug().Uint64("scratch_mb", need>>20).Msg("scratch space reserved")
+	return release, nil
+}
+
+func insufficientResources(cfg *config.Config, err error) error {
+	return rabbitmq.Requeue(errors.Join(ErrInsufficientResources, err), time.Duration(cfg.Server.PreflightDelay)*time.Second)
+}
+
+// transcodeScratch estimates the scratch space of a transcode: the source and,
+// per rendition, ScratchFactor times its size. An HLS source's size isn't
+// known before its segments are listed, so it isn't estimated.
+func transcodeScratch(ctx context.Context, store objectstore.Store, cfg *config.Config, objectPath string, renditions entities.Renditions) uint64 {
+	if isHLSSource(objectPath) {
+		return 0
+	}
+	info, err := store.StatObject(ctx, cfg.MinIOBucket, objectPath, minio.StatObjectOptions{})
+	if err != nil {
+		// The download reports the missing object.
+		zerolog.Ctx(ctx).Warn().Err(err).Str("object_path", objectPath).Msg("failed to stat source for scratch estimate")
+		return 0
+	}
+	size := float64(info.Size)
+	return uint64(size + size*cfg.Server.ScratchFactor*float64(len(renditions)))
+}
+
+// mergeScratch estimates the scratch space of a recording merge: the chunks
+// and the merged file, which is about as large.
+func mergeScratch(chunks []*entities.RecordingChunk) uint64 {
+	var size uint64
+	for _, chunk := range chunks {
+		if chunk.FileSize != nil && *chunk.FileSize > 0 {
+			size += uint64(*chunk.FileSize)
+		}
+	}
+	return 2 * size
+}
+
+// postpone puts a job the worker couldn't fit back to pending; the consumer
+// requeues its message.
+func postpone(ctx context.Context, repo repository.JobRepository, jobId uuid.UUID, cause error) {
+	if err := repo.UpdateStatusJob(ctx, constant.JobStatusPending, jobId); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to update job status")
+	}
+	recordStatus(ctx, constant.JobStatusProcessing, constant.JobStatusPending)
+	zerolog.Ctx(ctx).Warn().Err(cause).Str("job_id", jobId.String()).Msg("not enough resources for job, postponed")
+}
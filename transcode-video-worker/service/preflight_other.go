@@ -0,0 +1,13 @@
+//go:build !unix
+
+package service
+
+// freeDisk and availableMemory aren't measured here, so the preflight checks
+// always pass.
+func freeDisk(dir string) (uint64, bool) {
+	return 0, false
+}
+
+func availableMemory() (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,730 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/ffmpeg"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+const DefaultPresetName = "default"
+
+// defaultPreset is the built-in ladder used when no preset with the requested
+// name is stored in the database.
+var defaultPreset = entities.Preset{
+	Name:            DefaultPresetName,
+	VideoCodec:      "libx264",
+	AudioCodec:      "aac",
+	EncoderPreset:   "veryfast",
+	SegmentSeconds:  6,
+	KeyframeSeconds: 2,
+	Active:          true,
+	Renditions: entities.Renditions{
+		{Width: 256, Height: 144, Bitrate: "200k", AudioRate: "64k"},
+		{Width: 640, Height: 360, Bitrate: "800k", AudioRate: "96k"},
+		{Width: 854, Height: 480, Bitrate: "1500k", AudioRate: "128k"},
+		{Width: 1280, Height: 720, Bitrate: "3000k", AudioRate: "192k"},
+		{Width: 1920, Height: 1080, Bitrate: "5000k", AudioRate: "192k"},
+	},
+}
+
+// videoCodecTags maps the encoders we can package into HLS to the CODECS
+// attribute written to the master playlist.
+var videoCodecTags = map[string]string{
+	"libx264":    "avc1.640028",
+	"h264_nvenc": "avc1.640028",
+}
+
+var audioCodecTags = map[string]string{
+	"aac": "mp4a.40.2",
+}
+
+var bitratePattern = regexp.MustCompile(`^[1-9][0-9]*k$`)
+
+// presetDefaultCRF is the constant rate factor of presets that don't set one.
+const presetDefaultCRF = 22
+
+// presetHLSFlags are the -hls_flags a preset may add; the rest would change
+// what a VOD package looks like to the stages after the encode.
+var presetHLSFlags = []string{"independent_segments", "program_date_time", "round_durations", "split_by_time", "temp_file"}
+
+type PresetService interface {
+	List(ctx context.Context) ([]*entities.Preset, error)
+	Get(ctx context.Context, name string, version int) (*entities.Preset, error)
+	Create(ctx context.Context, request dto.PresetRequest) (*entities.Preset, error)
+	Update(ctx context.Context, name string, request dto.PresetRequest) (*entities.Preset, error)
+	Deactivate(ctx context.Context, name string) error
+	Resolve(ctx context.Context, name string) (*entities.Preset, error)
+	// Pick resolves the preset a job is encoded with: the name's canary for
+	// its share of the jobs, the active version for the rest.
+	Pick(ctx context.Context, name string, jobId uuid.UUID) (*entities.Preset, error)
+	StartCanary(ctx context.Context, name string, request dto.PresetCanaryRequest) (*entities.Preset, error)
+	CompareCanary(ctx context.Context, name string) (*dto.PresetCanaryReport, error)
+	// PromoteCanary makes the canary the active version, refusing while it
+	// isn't promotable unless force is set.
+	PromoteCanary(ctx context.Context, name string, force bool) (*entities.Preset, error)
+	AbortCanary(ctx context.Context, name string) error
+	// Enroll puts the job into the experiment running on the preset it was
+	// picked, tagging it with the arm it lands in, and returns the preset of
+	// that arm. With no experiment running, it returns the preset and no
+	// tag.
+	Enroll(ctx context.Context, preset *entities.Preset, jobId uuid.UUID) (*entities.Preset, *entities.ExperimentJob, error)
+	StartExperiment(ctx context.Context, request dto.PresetExperimentRequest) (*entities.PresetExperiment, error)
+	ListExperiments(ctx context.Context) ([]*entities.PresetExperiment, error)
+	StopExperiment(ctx context.Context, id uuid.UUID) (*entities.PresetExperiment, error)
+	CompareExperiment(ctx context.Context, id uuid.UUID) (*dto.PresetExperimentReport, error)
+}
+
+type presetService struct {
+	repo repository.PresetRepository
+	cfg  *config.Config
+}
+
+func (s *presetService) List(ctx context.Context) ([]*entities.Preset, error) {
+	return s.repo.ListLatestPresets(ctx)
+}
+
+func (s *presetService) Get(ctx context.Context, name string, version int) (*entities.Preset, error) {
+	var (
+		preset *entities.Preset
+		err    error
+	)
+	if version > 0 {
+		preset, err = s.repo.FindPresetVersion(ctx, name, version)
+	} else {
+		preset, err = s.repo.FindLatestPreset(ctx, name)
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	return preset, err
+}
+
+func (s *presetService) Create(ctx context.Context, request dto.PresetRequest) (*entities.Preset, error) {
+	_, err := s.repo.FindLatestPreset(ctx, request.Name)
+	if err == nil {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("preset %q already exists", request.Name))
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	return s.save(ctx, request)
+}
+
+func (s *presetService) Update(ctx context.Context, name string, request dto.PresetRequest) (*entities.Preset, error) {
+	if _, err := s.Get(ctx, name, 0); err != nil {
+		return nil, err
+	}
+	request.Name = name
+	return s.save(ctx, request)
+}
+
+func (s *presetService) save(ctx context.Context, request dto.PresetRequest) (*entities.Preset, error) {
+	preset := PresetFromRequest(request)
+	if err := s.assignSemver(ctx, preset, request.Semver); err != nil {
+		return nil, err
+	}
+	if err := ValidatePreset(ctx, preset); err != nil {
+		return nil, errors.Join(ErrInvalidArgument, err)
+	}
+	if err := s.repo.CreatePreset(ctx, preset); err != nil {
+		return nil, err
+	}
+	return preset, nil
+}
+
+func (s *presetService) Deactivate(ctx context.Context, name string) error {
+	err := s.repo.DeactivatePreset(ctx, name)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errors.Join(ErrNotFound, err)
+	}
+	return err
+}
+
+// Resolve returns the active version of the named preset, falling back to the
+// built-in ladder for the default name so the worker runs on an empty table.
+func (s *presetService) Resolve(ctx context.Context, name string) (*entities.Preset, error) {
+	if name == "" {
+		name = DefaultPresetName
+	}
+
+	preset, err := s.repo.FindActivePreset(ctx, name)
+	if err == nil {
+		return preset, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if name == DefaultPresetName {
+		builtin := defaultPreset
+		return &builtin, nil
+	}
+	return nil, errors.Join(ErrNotFound, fmt.Errorf("no active preset named %q", name))
+}
+
+// assignSemver gives the preset about to be saved its semantic version,
+// against the latest stored version of its name.
+func (s *presetService) assignSemver(ctx context.Context, preset *entities.Preset, requested string) error {
+	var latest string
+	stored, err := s.repo.FindLatestPreset(ctx, preset.Name)
+	switch {
+	case err == nil:
+		latest = stored.Semver
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return err
+	}
+	version, err := nextPresetSemver(latest, requested)
+	if err != nil {
+		return errors.Join(ErrInvalidArgument, err)
+	}
+	preset.Semver = version
+	return nil
+}
+
+// DefaultPreset returns a copy of the built-in ladder.
+func DefaultPreset() *entities.Preset {
+	builtin := defaultPreset
+	return &builtin
+}
+
+func (s *presetService) Pick(ctx context.Context, name string, jobId uuid.UUID) (*entities.Preset, error) {
+	stable, err := s.Resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	canary, err := s.repo.FindCanaryPreset(ctx, stable.Name)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return stable, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if canaryBucket(jobId) < canary.CanaryPercent {
+		return canary, nil
+	}
+	return stable, nil
+}
+
+// canaryBucket places a job in one of 100 buckets by its ID, so every
+// delivery and retry of a job is encoded with the same version.
+func canaryBucket(jobId uuid.UUID) int {
+	return int(crc32.ChecksumIEEE(jobId[:]) % 100)
+}
+
+// StartCanary saves the request as the next version of the preset, taking
+// Percent of its jobs while the active version keeps the rest.
+func (s *presetService) StartCanary(ctx context.Context, name string, request dto.PresetCanaryRequest) (*entities.Preset, error) {
+	if request.Percent < 1 || request.Percent > 100 {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("percent must be between 1 and 100, got %d", request.Percent))
+	}
+	// The canary is compared with the active version, so there must be one.
+	if _, err := s.Resolve(ctx, name); err != nil {
+		return nil, err
+	}
+
+	request.Name = name
+	preset := PresetFromRequest(request.PresetRequest)
+	preset.CanaryPercent = request.Percent
+	if err := s.assignSemver(ctx, preset, request.Semver); err != nil {
+		return nil, err
+	}
+	if err := ValidatePreset(ctx, preset); err != nil {
+		return nil, errors.Join(ErrInvalidArgument, err)
+	}
+	if err := s.repo.CreateCanary(ctx, preset); err != nil {
+		return nil, err
+	}
+	zerolog.Ctx(ctx).Info().Str("preset", name).Int("version", preset.Version).Int("percent", preset.CanaryPercent).Msg("canary preset started")
+	return preset, nil
+}
+
+func (s *presetService) CompareCanary(ctx context.Context, name string) (*dto.PresetCanaryReport, error) {
+	canary, err := s.repo.FindCanaryPreset(ctx, name)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, fmt.Errorf("no canary for preset %q", name))
+	}
+	if err != nil {
+		return nil, err
+	}
+	stable, err := s.Resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &dto.PresetCanaryReport{
+		Name:    name,
+		Percent: canary.CanaryPercent,
+		Since:   time.Now().UTC().Add(-time.Duration(s.cfg.Canary.Window) * time.Second),
+		Stable:  &dto.PresetVersionStats{Version: stable.Version},
+		Canary:  &dto.PresetVersionStats{Version: canary.Version},
+	}
+	stats, err := s.repo.PresetVersionStats(ctx, name, report.Since)
+	if err != nil {
+		return nil, err
+	}
+	for _, versionStats := range stats {
+		switch versionStats.Version {
+		case stable.Version:
+			*report.Stable = versionStats
+		case canary.Version:
+			*report.Canary = versionStats
+		}
+	}
+
+	finished := report.Canary.Completed + report.Canary.Failed
+	increase := report.Canary.FailureRate - report.Stable.FailureRate
+	switch {
+	case finished < int64(s.cfg.Canary.MinJobs):
+		report.Reason = fmt.Sprintf("canary has finished %d jobs, %d needed", finished, s.cfg.Canary.MinJobs)
+	case increase > s.cfg.Canary.MaxFailureIncrease:
+		report.Reason = fmt.Sprintf("canary fails %.1f%% of jobs against %.1f%% for the stable version",
+			report.Canary.FailureRate*100, report.Stable.FailureRate*100)
+	default:
+		report.Promotable = true
+	}
+	return report, nil
+}
+
+func (s *presetService) PromoteCanary(ctx context.Context, name string, force bool) (*entities.Preset, error) {
+	report, err := s.CompareCanary(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if !report.Promotable && !force {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("canary of preset %q is not promotable: %s", name, report.Reason))
+	}
+
+	preset, err := s.repo.PromoteCanary(ctx, name)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	zerolog.Ctx(ctx).Info().Str("preset", name).Int("version", preset.Version).Bool("forced", !report.Promotable).Msg("canary preset promoted")
+	return preset, nil
+}
+
+func (s *presetService) AbortCanary(ctx context.Context, name string) error {
+	err := s.repo.AbortCanary(ctx, name)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errors.Join(ErrNotFound, fmt.Errorf("no canary for preset %q", name))
+	}
+	if err == nil {
+		zerolog.Ctx(ctx).Info().Str("preset", name).Msg("canary preset aborted")
+	}
+	return err
+}
+
+func (s *presetService) Enroll(ctx context.Context, preset *entities.Preset, jobId uuid.UUID) (*entities.Preset, *entities.ExperimentJob, error) {
+	experiment, err := s.repo.FindRunningExperiment(ctx, preset.Name)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return preset, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	arm := constant.ExperimentArmControl
+	if experimentBucket(experiment.ID, jobId) < experiment.Percent {
+		variant, err := s.Resolve(ctx, experiment.VariantPreset)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			// The variant was deactivated mid-experiment; the job isn't
+			// failed for it.
+			zerolog.Ctx(ctx).Warn().Err(err).Str("experiment_id", experiment.ID.String()).Msg("experiment variant preset is gone, encoding with the control")
+		case err != nil:
+			return nil, nil, err
+		default:
+			arm, preset = constant.ExperimentArmVariant, variant
+		}
+	}
+	tag := &entities.ExperimentJob{
+		JobId:         jobId,
+		ExperimentId:  experiment.ID,
+		Arm:           arm,
+		Preset:        preset.Name,
+		PresetVersion: preset.Version,
+	}
+	if err := s.repo.SaveExperimentJob(ctx, tag); err != nil {
+		return nil, nil, err
+	}
+	return preset, tag, nil
+}
+
+// experimentBucket places a job in one of 100 buckets by its ID and the
+// experiment's, so every attempt of a job lands in the same arm, and which
+// jobs do doesn't follow which took a canary.
+func experimentBucket(experimentId, jobId uuid.UUID) int {
+	return int(crc32.ChecksumIEEE(append(experimentId[:], jobId[:]...)) % 100)
+}
+
+// StartExperiment checks both presets resolve, so the variant's jobs don't
+// fail for want of it.
+func (s *presetService) StartExperiment(ctx context.Context, request dto.PresetExperimentRequest) (*entities.PresetExperiment, error) {
+	switch {
+	case request.Name == "":
+		return nil, errors.Join(ErrInvalidArgument, errors.New("name is required"))
+	case request.Percent < 1 || request.Percent > 99:
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("percent must be between 1 and 99, got %d", request.Percent))
+	case request.ControlPreset == "" || request.VariantPreset == "":
+		return nil, errors.Join(ErrInvalidArgument, errors.New("control_preset and variant_preset are required"))
+	case request.ControlPreset == request.VariantPreset:
+		return nil, errors.Join(ErrInvalidArgument, errors.New("the variant must be another preset than the control"))
+	}
+	for _, name := range []string{request.ControlPreset, request.VariantPreset} {
+		if _, err := s.Resolve(ctx, name); err != nil {
+			return nil, err
+		}
+	}
+	_, err := s.repo.FindRunningExperiment(ctx, request.ControlPreset)
+	if err == nil {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("an experiment is already running on preset %q", request.ControlPreset))
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	experiment := &entities.PresetExperiment{
+		ID:            uuid.New(),
+		Name:          request.Name,
+		ControlPreset: request.ControlPreset,
+		VariantPreset: request.VariantPreset,
+		Percent:       request.Percent,
+		Status:        constant.ExperimentStatusRunning,
+		StartedAt:     time.Now().UTC(),
+	}
+	if err := s.repo.CreateExperiment(ctx, experiment); err != nil {
+		return nil, err
+	}
+	zerolog.Ctx(ctx).Info().
+		Str("experiment_id", experiment.ID.String()).
+		Str("control", experiment.ControlPreset).
+		Str("variant", experiment.VariantPreset).
+		Int("percent", experiment.Percent).
+		Msg("preset experiment started")
+	return experiment, nil
+}
+
+func (s *presetService) ListExperiments(ctx context.Context) ([]*entities.PresetExperiment, error) {
+	experiments, err := s.repo.ListExperiments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if experiments == nil {
+		experiments = []*entities.PresetExperiment{}
+	}
+	return experiments, nil
+}
+
+// StopExperiment stops the experiment taking jobs; the jobs it tagged still
+// make up its report.
+func (s *presetService) StopExperiment(ctx context.Context, id uuid.UUID) (*entities.PresetExperiment, error) {
+	experiment, err := s.findExperiment(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	stopped, err := s.repo.StopExperiment(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !stopped {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("experiment %s is not running", id))
+	}
+	zerolog.Ctx(ctx).Info().Str("experiment_id", id.String()).Msg("preset experiment stopped")
+	return s.findExperiment(ctx, experiment.ID)
+}
+
+// CompareExperiment is conclusive once each arm has finished
+// CANARY_MIN_JOBS jobs, and had any of them scored.
+func (s *presetService) CompareExperiment(ctx context.Context, id uuid.UUID) (*dto.PresetExperimentReport, error) {
+	experiment, err := s.findExperiment(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	report := &dto.PresetExperimentReport{
+		Experiment: experiment,
+		Control:    &dto.ExperimentArmStats{Arm: constant.ExperimentArmControl, Preset: experiment.ControlPreset},
+		Variant:    &dto.ExperimentArmStats{Arm: constant.ExperimentArmVariant, Preset: experiment.VariantPreset},
+	}
+	arms, err := s.repo.ExperimentArmStats(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, arm := range arms {
+		switch arm.Arm {
+		case constant.ExperimentArmControl:
+			*report.Control = arm
+		case constant.ExperimentArmVariant:
+			*report.Variant = arm
+		}
+	}
+	report.Rungs, err = s.repo.ExperimentRungStats(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if report.Rungs == nil {
+		report.Rungs = []dto.ExperimentRungStats{}
+	}
+
+	control, variant := report.Control, report.Variant
+	report.Delta = dto.ExperimentDelta{
+		VMAF:          variant.VMAF - control.VMAF,
+		FailureRate:   variant.FailureRate - control.FailureRate,
+		OutputBytes:   relativeChange(control.OutputBytes, variant.OutputBytes),
+		OutputKbps:    relativeChange(control.OutputKbps, variant.OutputKbps),
+		EncodeSeconds: relativeChange(control.EncodeSeconds, variant.EncodeSeconds),
+	}
+	switch {
+	case control.Completed+control.Failed < int64(s.cfg.Canary.MinJobs):
+		report.Reason = fmt.Sprintf("control has finished %d jobs, %d needed", control.Completed+control.Failed, s.cfg.Canary.MinJobs)
+	case variant.Completed+variant.Failed < int64(s.cfg.Canary.MinJobs):
+		report.Reason = fmt.Sprintf("variant has finished %d jobs, %d needed", variant.Completed+variant.Failed, s.cfg.Canary.MinJobs)
+	case control.Scored == 0 || variant.Scored == 0:
+		report.Reason = "both arms need renditions scored with VMAF"
+	default:
+		report.Conclusive = true
+	}
+	return report, nil
+}
+
+func (s *presetService) findExperiment(ctx context.Context, id uuid.UUID) (*entities.PresetExperiment, error) {
+	experiment, err := s.repo.FindExperiment(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, fmt.Errorf("experiment %s not found", id))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return experiment, nil
+}
+
+// relativeChange is how much to differs from from, as a share of from.
+func relativeChange(from, to float64) float64 {
+	if from == 0 {
+		return 0
+	}
+	return (to - from) / from
+}
+
+// PresetFromRequest is the preset a request saves, before it is versioned.
+func PresetFromRequest(request dto.PresetRequest) *entities.Preset {
+	return &entities.Preset{
+		Name:            request.Name,
+		Semver:          request.Semver,
+		VideoCodec:      request.VideoCodec,
+		AudioCodec:      request.AudioCodec,
+		EncoderPreset:   request.EncoderPreset,
+		SegmentSeconds:  request.SegmentSeconds,
+		KeyframeSeconds: request.KeyframeSeconds,
+		Renditions:      request.Renditions,
+		AllowUpscale:    request.AllowUpscale,
+		FFmpegArgs:      pq.StringArray(request.FFmpegArgs),
+		Options:         request.Options,
+	}
+}
+
+// ValidatePreset checks the ladder is sane and then runs a tiny test encode of
+// every rung so a preset can never be saved with settings ffmpeg rejects.
+func ValidatePreset(ctx context.Context, preset *entities.Preset) error {
+	if err := ValidatePresetShape(preset); err != nil {
+		return err
+	}
+
+	for _, r := range preset.Renditions {
+		args := append(presetArgs(preset), []string{
+			"-hide_banner", "-loglevel", "error",
+			"-f", "lavfi", "-i", fmt.Sprintf("testsrc2=size=%dx%d:rate=30:duration=0.5", r.Width, r.Height),
+			"-f", "lavfi", "-i", "sine=frequency=440:duration=0.5",
+			"-vf", scaleFilter(preset, r),
+			"-c:v", preset.VideoCodec,
+			"-preset", preset.EncoderPreset,
+		}...)
+		args = append(args, videoRateArgs(preset, r)...)
+		args = append(args, keyframeArgs(preset)...)
+		args = append(args,
+			"-c:a", preset.AudioCodec,
+			"-b:a", r.AudioRate,
+			"-f", "null", "-",
+		)
+		output, err := ffmpeg.Command(ctx, args...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("rendition %dp is not encodable: %w: %s", r.Height, err, string(output))
+		}
+	}
+
+	return nil
+}
+
+// ValidatePresetShape checks the ladder without encoding anything: supported
+// codecs, segment and keyframe intervals that line up, renditions in
+// ascending height and bitrate, and options ffmpeg can be given.
+func ValidatePresetShape(preset *entities.Preset) error {
+	if preset.Name == "" {
+		return errors.New("name is required")
+	}
+	if _, ok := videoCodecTags[preset.VideoCodec]; !ok {
+		return fmt.Errorf("unsupported video codec %q", preset.VideoCodec)
+	}
+	if _, ok := audioCodecTags[preset.AudioCodec]; !ok {
+		return fmt.Errorf("unsupported audio codec %q", preset.AudioCodec)
+	}
+	if preset.EncoderPreset == "" {
+		return errors.New("encoder_preset is required")
+	}
+	if err := ffmpeg.Check(preset.FFmpegArgs); err != nil {
+		return fmt.Errorf("ffmpeg_args: %w", err)
+	}
+	if preset.SegmentSeconds < 1 || preset.SegmentSeconds > 30 {
+		return fmt.Errorf("segment_seconds must be between 1 and 30, got %d", preset.SegmentSeconds)
+	}
+	if preset.KeyframeSeconds < 0 {
+		return fmt.Errorf("keyframe_seconds must not be negative, got %d", preset.KeyframeSeconds)
+	}
+	if preset.KeyframeSeconds > 0 && preset.SegmentSeconds%preset.KeyframeSeconds != 0 {
+		return fmt.Errorf("segment_seconds (%d) must be a multiple of keyframe_seconds (%d) so segments start on a keyframe",
+			preset.SegmentSeconds, preset.KeyframeSeconds)
+	}
+	if len(preset.Renditions) == 0 {
+		return errors.New("at least one rendition is required")
+	}
+
+	previousHeight, previousBitrate := 0, 0
+	for i, r := range preset.Renditions {
+		if r.Width <= 0 || r.Height <= 0 || r.Width%2 != 0 || r.Height%2 != 0 {
+			return fmt.Errorf("rendition %d: width and height must be positive even numbers", i)
+		}
+		if !bitratePattern.MatchString(r.Bitrate) || !bitratePattern.MatchString(r.AudioRate) {
+			return fmt.Errorf("rendition %d: bitrates must look like \"800k\"", i)
+		}
+		bitrate, _ := strconv.Atoi(r.Bitrate[:len(r.Bitrate)-1])
+		if r.Height <= previousHeight || bitrate <= previousBitrate {
+			return fmt.Errorf("rendition %d: renditions must be ordered by ascending height and bitrate", i)
+		}
+		previousHeight, previousBitrate = r.Height, bitrate
+		if r.SegmentFormat != "" && r.SegmentFormat != segmentFormatTS && r.SegmentFormat != segmentFormatFMP4 {
+			return fmt.Errorf("rendition %d: segment_format must be %s or %s, got %q", i, segmentFormatTS, segmentFormatFMP4, r.SegmentFormat)
+		}
+		if r.Container != "" && !slices.Contains(progressiveContainers, r.Container) {
+			return fmt.Errorf("rendition %d: container %q is not one of %s", i, r.Container, strings.Join(progressiveContainers, ", "))
+		}
+	}
+
+	return validatePresetOptions(preset.Options)
+}
+
+func validatePresetOptions(options entities.PresetOptions) error {
+	if options.CRF != nil && (*options.CRF < -1 || *options.CRF > 51) {
+		return fmt.Errorf("crf must be between 0 and 51, or -1 for none, got %d", *options.CRF)
+	}
+	if options.MaxrateFactor < 0 || options.MaxrateFactor > 10 || options.BufsizeFactor < 0 || options.BufsizeFactor > 10 {
+		return errors.New("maxrate_factor and bufsize_factor must be between 0 and 10")
+	}
+	for _, filter := range options.VideoFilters {
+		// A filter is appended to each rung's chain; labels or a second
+		// chain would escape it.
+		if strings.TrimSpace(filter) == "" || strings.ContainsAny(filter, ";[]") {
+			return fmt.Errorf("video filter %q must be a single filter, without labels", filter)
+		}
+	}
+	for _, flag := range options.HLSFlags {
+		if !slices.Contains(presetHLSFlags, flag) {
+			return fmt.Errorf("hls flag %q is not one of %s", flag, strings.Join(presetHLSFlags, ", "))
+		}
+	}
+	if gate := options.QualityGate; gate != nil {
+		if gate.MinPSNR < 0 || gate.MinPSNR > 100 {
+			return fmt.Errorf("quality gate min_psnr must be between 0 and 100 dB, got %g", gate.MinPSNR)
+		}
+		if gate.MinSSIM < 0 || gate.MinSSIM > 1 {
+			return fmt.Errorf("quality gate min_ssim must be between 0 and 1, got %g", gate.MinSSIM)
+		}
+		if gate.MinPSNR == 0 && gate.MinSSIM == 0 {
+			return errors.New("quality gate must set min_psnr, min_ssim or both")
+		}
+	}
+	return nil
+}
+
+// keyframeArgs forces keyframes on the preset's interval, if it sets one.
+func keyframeArgs(preset *entities.Preset) []string {
+	if preset.KeyframeSeconds <= 0 {
+		return nil
+	}
+	return []string{"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", preset.KeyframeSeconds)}
+}
+
+// videoRateArgs are the rate control args of rung r: a bitrate, capped by the
+// preset's maxrate and bufsize, and its constant rate factor.
+func videoRateArgs(preset *entities.Preset, r entities.Rendition) []string {
+	var args []string
+	crf := presetDefaultCRF
+	if preset.Options.CRF != nil {
+		crf = *preset.Options.CRF
+	}
+	if crf >= 0 {
+		args = append(args, "-crf", strconv.Itoa(crf))
+	}
+	return append(args,
+		"-b:v", r.Bitrate,
+		"-maxrate", scaleBitrate(r.Bitrate, preset.Options.MaxrateFactor),
+		"-bufsize", scaleBitrate(r.Bitrate, preset.Options.BufsizeFactor),
+	)
+}
+
+// scaleBitrate multiplies a bitrate like "800k" by factor, 0 leaving it as
+// it is.
+func scaleBitrate(bitrate string, factor float64) string {
+	kbps, err := strconv.Atoi(strings.TrimSuffix(bitrate, "k"))
+	if factor == 0 || err != nil {
+		return bitrate
+	}
+	return fmt.Sprintf("%dk", max(int(math.Round(float64(kbps)*factor)), 1))
+}
+
+// scaleFilter fits a frame into rung r, letterboxed, then runs the preset's
+// own filters.
+func scaleFilter(preset *entities.Preset, r entities.Rendition) string {
+	filters := append([]string{fmt.Sprintf("scale=w=%d:h=%d:force_original_aspect_ratio=decrease,pad=w=%d:h=%d:x=(ow-iw)/2:y=(oh-ih)/2",
+		r.Width, r.Height, r.Width, r.Height)}, preset.Options.VideoFilters...)
+	return strings.Join(filters, ",")
+}
+
+// hlsOutputArgs package an output as a VOD playlist cut into the preset's
+// segments.
+func hlsOutputArgs(preset *entities.Preset) []string {
+	args := []string{
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(preset.SegmentSeconds),
+		"-hls_playlist_type", "vod",
+	}
+	if len(preset.Options.HLSFlags) > 0 {
+		args = append(args, "-hls_flags", strings.Join(preset.Options.HLSFlags, "+"))
+	}
+	return args
+}
+
+func NewPresetService(repo repository.PresetRepository, cfg *config.Config) PresetService {
+	return &presetService{
+		repo: repo,
+		cfg:  cfg,
+	}
+}
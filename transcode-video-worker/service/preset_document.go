@@ -0,0 +1,119 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/ffmpeg"
+
+	"golang.org/x/mod/semver"
+	"gopkg.in/yaml.v3"
+)
+
+// ParsePresetDocument reads a preset document, in YAML or JSON, into the
+// request that saves it. Fields the document format doesn't know are
+// rejected, so a misspelt setting can't silently fall back to its default.
+func ParsePresetDocument(raw []byte) (dto.PresetRequest, error) {
+	var document dto.PresetDocument
+	decoder := yaml.NewDecoder(bytes.NewReader(raw))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&document); err != nil {
+		if errors.Is(err, io.EOF) {
+			return dto.PresetRequest{}, errors.New("preset document is empty")
+		}
+		return dto.PresetRequest{}, fmt.Errorf("preset document: %w", err)
+	}
+	if document.Version != "" {
+		if err := validPresetSemver(document.Version); err != nil {
+			return dto.PresetRequest{}, err
+		}
+	}
+
+	var gate *entities.QualityThresholds
+	if document.Quality != nil {
+		gate = &entities.QualityThresholds{MinPSNR: document.Quality.MinPSNR, MinSSIM: document.Quality.MinSSIM}
+	}
+	renditions := make(entities.Renditions, 0, len(document.Renditions))
+	for _, r := range document.Renditions {
+		renditions = append(renditions, entities.Rendition{
+			Width:         r.Width,
+			Height:        r.Height,
+			Bitrate:       r.Bitrate,
+			AudioRate:     r.AudioRate,
+			SegmentFormat: r.SegmentFormat,
+			Container:     r.Container,
+		})
+	}
+	return dto.PresetRequest{
+		Name:            document.Name,
+		VideoCodec:      document.Video.Codec,
+		AudioCodec:      document.Audio.Codec,
+		EncoderPreset:   document.Video.EncoderPreset,
+		SegmentSeconds:  document.Packaging.SegmentSeconds,
+		KeyframeSeconds: document.Video.KeyframeSeconds,
+		Renditions:      renditions,
+		AllowUpscale:    document.AllowUpscale,
+		FFmpegArgs:      document.FFmpegArgs,
+		Semver:          document.Version,
+		Options: entities.PresetOptions{
+			CRF:           document.Video.CRF,
+			MaxrateFactor: document.Video.MaxrateFactor,
+			BufsizeFactor: document.Video.BufsizeFactor,
+			VideoFilters:  document.Video.Filters,
+			HLSFlags:      document.Packaging.HLSFlags,
+			QualityGate:   gate,
+		},
+	}, nil
+}
+
+// CompilePreset is the ffmpeg command line a source with every rung of the
+// preset is encoded with, reading input and writing the package to
+// outputDir.
+func CompilePreset(preset *entities.Preset, input, outputDir string, threads int) []string {
+	args := append([]string{ffmpeg.Path()}, ffmpeg.GlobalArgs()...)
+	return append(args, hlsArgs(preset, input, "", nil, outputDir, threads, 0, 0)...)
+}
+
+// validPresetSemver checks version is a full semantic version such as 1.2.0
+// or 2.0.0-rc.1. Build metadata isn't allowed, since it doesn't order one
+// version above another.
+func validPresetSemver(version string) error {
+	if semver.Canonical("v"+version) != "v"+version {
+		return fmt.Errorf("version %q is not a semantic version like 1.2.0", version)
+	}
+	return nil
+}
+
+// nextPresetSemver is the version a save of a preset whose latest version is
+// latest is stored under: requested, which must be above latest, or else
+// latest with its patch bumped. A name's first version is 1.0.0 unless one
+// is requested.
+func nextPresetSemver(latest, requested string) (string, error) {
+	if requested != "" {
+		if err := validPresetSemver(requested); err != nil {
+			return "", err
+		}
+		if latest != "" && semver.Compare("v"+requested, "v"+latest) <= 0 {
+			return "", fmt.Errorf("version %s must be above the latest version, %s", requested, latest)
+		}
+		return requested, nil
+	}
+	if latest == "" {
+		return "1.0.0", nil
+	}
+	// The release a pre-release leads up to comes next.
+	if prerelease := semver.Prerelease("v" + latest); prerelease != "" {
+		return strings.TrimSuffix(latest, prerelease), nil
+	}
+	if err := validPresetSemver(latest); err != nil {
+		return "", err
+	}
+	parts := strings.Split(latest, ".")
+	patch, _ := strconv.Atoi(parts[2])
+	return fmt.Sprintf("%s.%s.%d", parts[0], parts[1], patch+1), nil
+}
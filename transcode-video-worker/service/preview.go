@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"worker-transcode/config"
+)
+
+// renderPreview writes a short looping preview of the video next to the
+// master playlist, so it is uploaded with the package for course listing
+// cards. It starts a tenth of the way in, past title cards and intros, and
+// is kept small enough to autoplay in a grid of cards. It returns the file
+// name.
+func renderPreview(ctx context.Context, inputFilepath, outputDir string, duration float64, cfg config.Preview) (string, error) {
+	length := min(float64(max(cfg.Seconds, 1)), duration)
+	start := min(duration/10, duration-length)
+	height := max(cfg.Height, 2) &^ 1
+	fps := max(cfg.FPS, 1)
+	scale := fmt.Sprintf("fps=%d,scale=-2:%d:flags=lanczos", fps, height)
+
+	args := []string{"-hide_banner", "-nostats", "-y",
+		"-ss", strconv.FormatFloat(start, 'f', 3, 64),
+		"-t", strconv.FormatFloat(length, 'f', 3, 64),
+		"-i", inputFilepath,
+		"-map", "0:v:0", "-an",
+	}
+	var name string
+	switch cfg.Format {
+	case "webp":
+		name = "preview.webp"
+		args = append(args, "-vf", scale, "-c:v", "libwebp", "-quality", "60", "-compression_level", "6", "-loop", "0")
+	case "gif":
+		// A palette made from the clip itself keeps gradients from banding
+		// in 256 colours.
+		name = "preview.gif"
+		args = append(args, "-filter_complex", scale+",split[a][b];[a]palettegen=stats_mode=diff[p];[b][p]paletteuse=dither=bayer:bayer_scale=4", "-loop", "0")
+	default:
+		return "", fmt.Errorf("unknown preview format %q", cfg.Format)
+	}
+	output := filepath.Join(outputDir, name)
+	if _, err := ffmpegStderr(ctx, append(args, output)); err != nil {
+		os.Remove(output)
+		return "", err
+	}
+	return name, nil
+}
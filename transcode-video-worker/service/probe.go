@@ -0,0 +1,170 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"worker-transcode/pkg/ffmpeg"
+)
+
+// MediaInfo is the subset of ffprobe output the pipeline relies on.
+type MediaInfo struct {
+	Format  ProbeFormat   `json:"format"`
+	Streams []ProbeStream `json:"streams"`
+}
+
+type ProbeFormat struct {
+	FormatName string `json:"format_name"`
+	Duration   string `json:"duration"`
+	Size       string `json:"size"`
+	BitRate    string `json:"bit_rate"`
+}
+
+type ProbeStream struct {
+	Index         int    `json:"index"`
+	CodecType     string `json:"codec_type"`
+	CodecName     string `json:"codec_name"`
+	Profile       string `json:"profile,omitempty"`
+	Level         int    `json:"level,omitempty"`
+	Width         int    `json:"width,omitempty"`
+	Height        int    `json:"height,omitempty"`
+	PixFmt        string `json:"pix_fmt,omitempty"`
+	AvgFrameRate  string `json:"avg_frame_rate,omitempty"`
+	SampleRate    string `json:"sample_rate,omitempty"`
+	Channels      int    `json:"channels,omitempty"`
+	ChannelLayout string `json:"channel_layout,omitempty"`
+	BitRate       string `json:"bit_rate,omitempty"`
+}
+
+// DurationSeconds returns the container duration, or 0 when unknown.
+func (m *MediaInfo) DurationSeconds() float64 {
+	duration, _ := strconv.ParseFloat(m.Format.Duration, 64)
+	return duration
+}
+
+// VideoStream returns the first video stream, or nil for audio-only sources.
+func (m *MediaInfo) VideoStream() *ProbeStream {
+	for i := range m.Streams {
+		if m.Streams[i].CodecType == "video" {
+			return &m.Streams[i]
+		}
+	}
+	return nil
+}
+
+// AudioStream returns the first audio stream, or nil for silent sources.
+func (m *MediaInfo) AudioStream() *ProbeStream {
+	for i := range m.Streams {
+		if m.Streams[i].CodecType == "audio" {
+			return &m.Streams[i]
+		}
+	}
+	return nil
+}
+
+// ProbeMedia runs ffprobe on the file at path. A file probed before and not
+// changed since isn't probed again.
+func ProbeMedia(ctx context.Context, path string) (*MediaInfo, error) {
+	media, key, ok := cachedProbe(path)
+	if ok {
+		return media, nil
+	}
+	args := []string{
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		path,
+	}
+	output, err := ffmpeg.Probe(ctx, args...).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("ffprobe failed: %w: %s", err, string(exitErr.Stderr))
+		}
+		return nil, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	info := &MediaInfo{}
+	if err := json.Unmarshal(output, info); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+	storeProbe(key, info)
+	return info, nil
+}
+
+// SourceCheck is the verdict on a source file: what ffprobe saw and anything
+// that would make the pipeline fail (Errors) or degrade (Warnings).
+type SourceCheck struct {
+	Path            string     `json:"path"`
+	SizeBytes       int64      `json:"size_bytes"`
+	DurationSeconds float64    `json:"duration_seconds"`
+	Media           *MediaInfo `json:"media,omitempty"`
+	Errors          []string   `json:"errors"`
+	Warnings        []string   `json:"warnings"`
+}
+
+// Err returns the check's errors as one error, or nil when the file can be
+// transcoded.
+func (c *SourceCheck) Err() error {
+	if len(c.Errors) == 0 {
+		return nil
+	}
+	return fmt.Errorf("source file rejected: %s", strings.Join(c.Errors, "; "))
+}
+
+// CheckSource probes path and applies the checks the pipeline runs before
+// encoding. Only a file that can't be stat'ed is returned as an error; a
+// missing ffprobe binary is a warning so the encode can still be attempted.
+func CheckSource(ctx context.Context, path string) (*SourceCheck, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	check := &SourceCheck{Path: path, SizeBytes: info.Size(), Errors: []string{}, Warnings: []string{}}
+	if check.SizeBytes == 0 {
+		check.Errors = append(check.Errors, "file is empty")
+		return check, nil
+	}
+
+	media, err := ProbeMedia(ctx, path)
+	if errors.Is(err, exec.ErrNotFound) {
+		check.Warnings = append(check.Warnings, "ffprobe is not installed, file was not inspected")
+		return check, nil
+	}
+	if err != nil {
+		check.Errors = append(check.Errors, fmt.Sprintf("not a readable media file: %v", err))
+		return check, nil
+	}
+	check.Media = media
+	check.DurationSeconds = media.DurationSeconds()
+
+	video := media.VideoStream()
+	switch {
+	case video == nil:
+		check.Errors = append(check.Errors, "no video stream")
+	case video.Width <= 0 || video.Height <= 0:
+		check.Errors = append(check.Errors, "video stream has no dimensions")
+	}
+
+	hasAudio := false
+	for _, stream := range media.Streams {
+		if stream.CodecType == "audio" {
+			hasAudio = true
+			break
+		}
+	}
+	if !hasAudio {
+		check.Warnings = append(check.Warnings, "no audio stream, output will be silent")
+	}
+	if check.DurationSeconds <= 0 {
+		check.Warnings = append(check.Warnings, "duration is unknown, progress can't be reported")
+	}
+
+	return check, nil
+}
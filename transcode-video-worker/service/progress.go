@@ -0,0 +1,258 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/chaos"
+	"worker-transcode/pkg/ffmpeg"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+const (
+	progressLogInterval = 10 * time.Second
+	maxFFmpegOutput     = 64 << 10
+	// ffmpegWaitDelay bounds how long Wait blocks on output pipes after a
+	// kill.
+	ffmpegWaitDelay = 5 * time.Second
+)
+
+// FFmpegProgress is one block of ffmpeg's -progress output.
+type FFmpegProgress struct {
+	Frame   int64
+	FPS     float64
+	Bitrate string
+	OutTime time.Duration
+	Speed   float64
+	Done    bool
+}
+
+// runFFmpeg runs ffmpeg with machine-readable progress on stdout. Each
+// completed progress block is passed to onProgress; stderr is kept (up to
+// maxFFmpegOutput) for the FFmpegError returned on failure.
+func runFFmpeg(ctx context.Context, args []string, onProgress func(FFmpegProgress)) error {
+	args = append([]string{"-hide_banner", "-nostats", "-progress", "pipe:1"}, args...)
+	// ffmpeg is killed when ctx is cancelled, so a shutting down worker can
+	// hand the job off and a job past its time limit stops, instead of
+	// waiting for the encode.
+	cmd := ffmpeg.Command(ctx, args...)
+	killProcessGroup(cmd)
+	cmd.WaitDelay = ffmpegWaitDelay
+	command := strings.Join(cmd.Args, " ")
+	zerolog.Ctx(ctx).Info().Str("command", command).Msg("executing FFmpeg command")
+	recordEvent(ctx, constant.JobEventCommand, "", entities.EventData{"command": command})
+
+	stderr := &tailBuffer{limit: maxFFmpegOutput}
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	oomKills := config.OOMKills()
+	if err := cmd.Start(); err != nil {
+		return &FFmpegError{Err: err}
+	}
+
+	done := trackFFmpeg()
+	spare := chaos.KillLater(cmd.Process)
+	var last *FFmpegProgress
+	parseProgress(stdout, func(progress FFmpegProgress) {
+		last = &progress
+		if onProgress != nil {
+			onProgress(progress)
+		}
+	})
+
+	err = cmd.Wait()
+	spare()
+	done()
+	addFFmpegLog(ctx, command, stderr.String())
+	if usage, ok := ctx.Value(ffmpegUsageKey{}).(*ffmpegUsage); ok && cmd.ProcessState != nil {
+		usage.observe(peakMemory(cmd.ProcessState))
+	}
+	if err != nil {
+		output := stderr.String()
+		zerolog.Ctx(ctx).Error().Str("ffmpeg_output", output).Msg("FFmpeg failed")
+		return &FFmpegError{Err: err, Output: output, Crash: postMortem(ctx, cmd, oomKills, last)}
+	}
+	return nil
+}
+
+type ffmpegUsageKey struct{}
+
+// ffmpegUsage is the peak memory of the ffmpeg runs made with a context
+// from withFFmpegUsage.
+type ffmpegUsage struct {
+	peak atomic.Int64
+}
+
+func (u *ffmpegUsage) observe(bytes int64) {
+	for {
+		current := u.peak.Load()
+		if bytes <= current || u.peak.CompareAndSwap(current, bytes) {
+			return
+		}
+	}
+}
+
+func withFFmpegUsage(ctx context.Context) (context.Context, *ffmpegUsage) {
+	usage := &ffmpegUsage{}
+	return context.WithValue(ctx, ffmpegUsageKey{}, usage), usage
+}
+
+// runningFFmpeg counts the ffmpeg processes the worker has running, which
+// the load monitor limits.
+var runningFFmpeg atomic.Int64
+
+// trackFFmpeg counts a started ffmpeg until the returned func is called.
+func trackFFmpeg() func() {
+	runningFFmpeg.Add(1)
+	return func() { runningFFmpeg.Add(-1) }
+}
+
+// parseProgress reads key=value lines until EOF. ffmpeg terminates every block
+// with a progress=continue or progress=end line.
+func parseProgress(r io.Reader, onProgress func(FFmpegProgress)) {
+	var current FFmpegProgress
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "frame":
+			current.Frame, _ = strconv.ParseInt(value, 10, 64)
+		case "fps":
+			current.FPS, _ = strconv.ParseFloat(value, 64)
+		case "bitrate":
+			current.Bitrate = value
+		case "out_time_us":
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil {
+				current.OutTime = time.Duration(us) * time.Microsecond
+			}
+		case "speed":
+			current.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+		case "progress":
+			current.Done = value == "end"
+			if onProgress != nil {
+				onProgress(current)
+			}
+		}
+	}
+}
+
+// progressStore is where a job's progress percentage is written.
+type progressStore interface {
+	UpdateJobProgress(ctx context.Context, id uuid.UUID, progress int) error
+}
+
+// cachedProgress mirrors progress into the status cache at most once per
+// interval, far more often than the job row is written.
+type cachedProgress struct {
+	progressStore
+	cache    repository.JobStatusCache
+	interval time.Duration
+	last     time.Time
+	percent  int
+}
+
+func (p *cachedProgress) liveProgress(ctx context.Context, id uuid.UUID, percent int) {
+	if percent == p.percent || time.Since(p.last) < p.interval {
+		return
+	}
+	p.last, p.percent = time.Now(), percent
+	if err := p.cache.SaveJobProgress(ctx, id, percent); err != nil {
+		zerolog.Ctx(ctx).Debug().Err(err).Msg("failed to cache job progress")
+	}
+}
+
+// progressReporter logs ffmpeg progress as structured fields and stores the
+// percentage on the job, at most once per progressLogInterval. total is the
+// source duration in seconds; when it is unknown, or repo is nil, only logs
+// are written.
+func progressReporter(ctx context.Context, repo progressStore, jobId uuid.UUID, total float64) func(FFmpegProgress) {
+	var last time.Time
+	lastPercent := -1
+	live, _ := repo.(*cachedProgress)
+	return func(p FFmpegProgress) {
+		noteProgress(ctx)
+		if live != nil && total > 0 && !p.Done {
+			live.liveProgress(ctx, jobId, min(max(int(p.OutTime.Seconds()/total*100), 0), 100))
+		}
+		if !p.Done && time.Since(last) < progressLogInterval {
+			return
+		}
+		last = time.Now()
+
+		event := zerolog.Ctx(ctx).Info().
+			Int64("frame", p.Frame).
+			Float64("fps", p.FPS).
+			Float64("speed", p.Speed).
+			Str("bitrate", p.Bitrate).
+			Dur("out_time", p.OutTime)
+
+		sample := entities.EventData{"speed": p.Speed, "fps": p.FPS, "out_time_seconds": p.OutTime.Seconds()}
+		if total <= 0 {
+			event.Msg("ffmpeg progress")
+			recordEvent(ctx, constant.JobEventProgress, "transcode", sample)
+			return
+		}
+
+		percent := int(p.OutTime.Seconds() / total * 100)
+		if p.Done {
+			percent = 100
+		}
+		percent = min(max(percent, 0), 100)
+		event.Int("percent", percent).Msg("ffmpeg progress")
+
+		if percent == lastPercent {
+			return
+		}
+		lastPercent = percent
+		sample["percent"] = percent
+		recordEvent(ctx, constant.JobEventProgress, "transcode", sample)
+		if repo == nil {
+			return
+		}
+		if err := repo.UpdateJobProgress(ctx, jobId, percent); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to update job progress")
+		}
+	}
+}
+
+// tailBuffer keeps the last limit bytes written to it, which is where ffmpeg
+// puts the error that made it exit.
+type tailBuffer struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	limit int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Write(p)
+	if overflow := b.buf.Len() - b.limit; overflow > 0 {
+		b.buf.Next(overflow)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/cdn"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"gorm.io/gorm"
+)
+
+// PublishingService sends finished lesson videos to the third party their
+// tenant delivers through, if any. Publishing is best effort: the package in
+// the bucket still plays when it fails.
+type PublishingService interface {
+	// Publish sends the job's video to its tenant's publish target and
+	// records where it plays there. It does nothing for jobs without a
+	// tenant or target.
+	Publish(ctx context.Context, job *entities.Job, inputFilepath, audioFilepath, packagePath string) error
+	Get(ctx context.Context, tenantId uuid.UUID) (*entities.PublishTarget, error)
+	Save(ctx context.Context, tenantId uuid.UUID, request dto.PublishTargetRequest) (*entities.PublishTarget, error)
+	Playback(ctx context.Context, lessonId uuid.UUID) (*entities.ExternalPlayback, error)
+}
+
+type publishingService struct {
+	repo  repository.PublishingRepository
+	store objectstore.Store
+	cfg   *config.Config
+}
+
+func (s *publishingService) Publish(ctx context.Context, job *entities.Job, inputFilepath, audioFilepath, packagePath string) error {
+	if !featureEnabled(ctx, constant.TenantFeaturePublish, s.cfg.Publish.Enabled) || job.TenantId == nil {
+		return nil
+	}
+	target, err := s.repo.FindPublishTarget(ctx, *job.TenantId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	publisher, err := cdn.New(cdnTarget(target))
+	if err != nil {
+		return err
+	}
+
+	video := cdn.Video{Name: job.EntityId.String(), Source: inputFilepath, Prefix: packagePath}
+	switch target.Provider {
+	case "cloudflare":
+		// Stream takes one file with the audio in it.
+		if audioFilepath != "" || filepath.Ext(inputFilepath) == ".m3u8" {
+			return errors.New("cloudflare stream needs a single file source, not a playlist or separate audio")
+		}
+	case "origin":
+		if video.Files, err = s.packageFiles(ctx, packagePath); err != nil {
+			return err
+		}
+	}
+	playback, err := publisher.Publish(ctx, video)
+	if err != nil {
+		return fmt.Errorf("publish to %s: %w", target.Provider, err)
+	}
+	return s.repo.SaveExternalPlayback(ctx, &entities.ExternalPlayback{
+		LessonId:    job.EntityId,
+		JobId:       job.ID,
+		Provider:    target.Provider,
+		PlaybackId:  playback.Id,
+		PlaybackURL: playback.URL,
+	})
+}
+
+// packageFiles lists the package in the bucket, which is complete even when
+// it was copied from an identical job's rather than encoded.
+func (s *publishingService) packageFiles(ctx context.Context, packagePath string) ([]cdn.File, error) {
+	prefix := strings.TrimSuffix(packagePath, "/") + "/"
+	var files []cdn.File
+	for object := range s.store.ListObjects(ctx, s.cfg.MinIOBucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		key := object.Key
+		files = append(files, cdn.File{
+			Name: strings.TrimPrefix(key, prefix),
+			Size: object.Size,
+			Open: func(ctx context.Context) (io.ReadCloser, error) {
+				return s.store.GetObject(ctx, s.cfg.MinIOBucket, key, minio.GetObjectOptions{})
+			},
+		})
+	}
+	return files, nil
+}
+
+func (s *publishingService) Get(ctx context.Context, tenantId uuid.UUID) (*entities.PublishTarget, error) {
+	target, err := s.repo.FindPublishTarget(ctx, tenantId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	return target, err
+}
+
+func (s *publishingService) Save(ctx context.Context, tenantId uuid.UUID, request dto.PublishTargetRequest) (*entities.PublishTarget, error) {
+	target := &entities.PublishTarget{
+		TenantId:  tenantId,
+		Provider:  request.Provider,
+		AccountId: request.AccountId,
+		OriginURL: request.OriginURL,
+		APIToken:  request.APIToken,
+	}
+	if err := validatePublishTarget(target); err != nil {
+		return nil, errors.Join(ErrInvalidArgument, err)
+	}
+	if err := s.repo.SavePublishTarget(ctx, target); err != nil {
+		return nil, err
+	}
+	return s.repo.FindPublishTarget(ctx, tenantId)
+}
+
+func (s *publishingService) Playback(ctx context.Context, lessonId uuid.UUID) (*entities.ExternalPlayback, error) {
+	playback, err := s.repo.FindExternalPlayback(ctx, lessonId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	return playback, err
+}
+
+func validatePublishTarget(target *entities.PublishTarget) error {
+	if strings.TrimSpace(target.APIToken) == "" {
+		return errors.New("api_token must not be empty")
+	}
+	switch target.Provider {
+	case "cloudflare":
+		if target.AccountId == nil || strings.TrimSpace(*target.AccountId) == "" {
+			return errors.New("cloudflare needs an account_id")
+		}
+	case "origin":
+		if target.OriginURL == nil {
+			return errors.New("origin needs an origin_url")
+		}
+		if parsed, err := url.Parse(*target.OriginURL); err != nil || parsed.Host == "" || (parsed.Scheme != "https" && parsed.Scheme != "http") {
+			return fmt.Errorf("origin_url %q is not an http(s) URL", *target.OriginURL)
+		}
+	default:
+		return fmt.Errorf("provider %q is neither cloudflare nor origin", target.Provider)
+	}
+	return nil
+}
+
+func cdnTarget(target *entities.PublishTarget) cdn.Target {
+	result := cdn.Target{Provider: target.Provider, Token: target.APIToken}
+	if target.AccountId != nil {
+		result.AccountId = *target.AccountId
+	}
+	if target.OriginURL != nil {
+		result.OriginURL = *target.OriginURL
+	}
+	return result
+}
+
+func NewPublishingService(repo repository.PublishingRepository, store objectstore.Store, cfg *config.Config) PublishingService {
+	return &publishingService{
+		repo:  repo,
+		store: store,
+		cfg:   cfg,
+	}
+}
@@ -0,0 +1,287 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/fingerprint"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// openFlagsLimit bounds the review queue returned at once.
+const openFlagsLimit = 200
+
+// QCService runs the quality checks whose findings need a person to look at
+// them, and keeps the flags they raise until the content team reviews them.
+// A lesson with an open or confirmed flag holds its course back from
+// publishing.
+type QCService interface {
+	// CheckMusic samples the job's audio for recorded music and flags the
+	// lesson when any is recognised.
+	CheckMusic(ctx context.Context, job *entities.Job, inputFilepath, audioFilepath string, duration float64) error
+	// CheckDuplicates fingerprints the job's upload and flags the lesson when
+	// it duplicates another lesson's video. The fingerprint is kept for the
+	// uploads after it to be compared with.
+	CheckDuplicates(ctx context.Context, job *entities.Job, inputFilepath, audioFilepath string, media *MediaInfo, duration float64) error
+	Flags(ctx context.Context, lessonId uuid.UUID) ([]*entities.QCFlag, error)
+	// Open lists the flags of check awaiting review, the oldest first, or of
+	// every check when check is empty.
+	Open(ctx context.Context, check constant.QCCheck) ([]*entities.QCFlag, error)
+	Review(ctx context.Context, id uuid.UUID, request dto.QCReviewRequest) (*entities.QCFlag, error)
+}
+
+type qcService struct {
+	repo         repository.QCRepository
+	fingerprints repository.FingerprintRepository
+	courses      CourseService
+	cfg          *config.Config
+}
+
+func (s *qcService) CheckMusic(ctx context.Context, job *entities.Job, inputFilepath, audioFilepath string, duration float64) error {
+	identifier, err := fingerprint.New(s.cfg.Music)
+	if err != nil {
+		return err
+	}
+	source := inputFilepath
+	if audioFilepath != "" {
+		source = audioFilepath
+	} else {
+		info, err := ProbeMedia(ctx, inputFilepath)
+		if err != nil {
+			return err
+		}
+		hasAudio := false
+		for _, stream := range info.Streams {
+			hasAudio = hasAudio || stream.CodecType == "audio"
+		}
+		if !hasAudio {
+			return s.repo.SupersedeFlags(ctx, job.EntityId, job.ID, constant.QCCheckCopyrightedMusic)
+		}
+	}
+
+	dir, err := scratchDir(ctx, s.cfg, filepath.Join(job.ID.String(), "music"))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	interval := float64(max(s.cfg.Music.SampleInterval, 1))
+	length := float64(max(s.cfg.Music.SampleLength, 1))
+	var matches entities.MusicMatches
+	for at := 0.0; at < duration; at += interval {
+		sample, err := musicSample(ctx, source, dir, at, min(length, duration-at))
+		if err != nil {
+			return err
+		}
+		match, err := identifier.Identify(ctx, sample)
+		if err != nil {
+			return fmt.Errorf("identify music at %s: %w", clockTime(at), err)
+		}
+		if match == nil || match.Score < s.cfg.Music.MinScore {
+			continue
+		}
+		matches = mergeMusicMatch(matches, entities.MusicMatch{
+			At:       at,
+			Duration: min(length, duration-at),
+			Title:    match.Title,
+			Artist:   match.Artist,
+			Score:    match.Score,
+		}, interval)
+	}
+
+	addJSONArtifact(ctx, "qc/music.json", matches)
+	if len(matches) == 0 {
+		zerolog.Ctx(ctx).Info().Msg("no copyrighted music recognised")
+		return s.repo.SupersedeFlags(ctx, job.EntityId, job.ID, constant.QCCheckCopyrightedMusic)
+	}
+	flag := &entities.QCFlag{
+		LessonId: job.EntityId,
+		JobId:    job.ID,
+		Check:    constant.QCCheckCopyrightedMusic,
+		Status:   constant.QCFlagStatusOpen,
+		Matches:  matches,
+	}
+	if err := s.repo.SaveFlag(ctx, flag); err != nil {
+		return err
+	}
+	if err := s.courses.Check(ctx, job.EntityId); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to check course readiness")
+	}
+	zerolog.Ctx(ctx).Warn().
+		Str("lesson_id", job.EntityId.String()).
+		Int("matches", len(matches)).
+		Str("title", matches[0].Title).
+		Msg("copyrighted music flagged for review")
+	return nil
+}
+
+func (s *qcService) CheckDuplicates(ctx context.Context, job *entities.Job, inputFilepath, audioFilepath string, media *MediaInfo, duration float64) error {
+	dir, err := scratchDir(ctx, s.cfg, filepath.Join(job.ID.String(), "fingerprint"))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	fingerprint := &entities.LessonFingerprint{LessonId: job.EntityId, JobId: job.ID, Seconds: duration}
+	if media.VideoStream() != nil {
+		if fingerprint.Frames, err = frameFingerprint(ctx, inputFilepath, dir, s.cfg.Fingerprint.FrameInterval); err != nil {
+			return fmt.Errorf("fingerprint frames: %w", err)
+		}
+	}
+	if audioFilepath != "" || media.AudioStream() != nil {
+		source := inputFilepath
+		if audioFilepath != "" {
+			source = audioFilepath
+		}
+		if fingerprint.Audio, err = audioFingerprint(ctx, source, dir); err != nil {
+			return fmt.Errorf("fingerprint audio: %w", err)
+		}
+	}
+
+	candidates, err := s.fingerprints.ListCandidates(ctx, job.EntityId, fingerprint.Frames, fingerprint.Audio, duplicateCandidates)
+	if err != nil {
+		return err
+	}
+	var duplicates entities.DuplicateMatches
+	for _, candidate := range candidates {
+		frames := frameSimilarity(fingerprint.Frames, candidate.Frames, s.cfg.Fingerprint.FrameDistance)
+		audio := audioSimilarity(fingerprint.Audio, candidate.Audio)
+		if max(frames, audio) < s.cfg.Fingerprint.MinSimilarity {
+			continue
+		}
+		duplicates = append(duplicates, entities.DuplicateMatch{
+			LessonId: candidate.LessonId,
+			JobId:    candidate.JobId,
+			Frames:   math.Round(frames*100) / 100,
+			Audio:    math.Round(audio*100) / 100,
+		})
+	}
+	if err := s.fingerprints.SaveFingerprint(ctx, fingerprint); err != nil {
+		return err
+	}
+
+	addJSONArtifact(ctx, "qc/duplicates.json", duplicates)
+	if len(duplicates) == 0 {
+		zerolog.Ctx(ctx).Info().Int("candidates", len(candidates)).Msg("upload duplicates no other lesson")
+		return s.repo.SupersedeFlags(ctx, job.EntityId, job.ID, constant.QCCheckDuplicateContent)
+	}
+	flag := &entities.QCFlag{
+		LessonId:   job.EntityId,
+		JobId:      job.ID,
+		Check:      constant.QCCheckDuplicateContent,
+		Status:     constant.QCFlagStatusOpen,
+		Duplicates: duplicates,
+	}
+	if err := s.repo.SaveFlag(ctx, flag); err != nil {
+		return err
+	}
+	if err := s.courses.Check(ctx, job.EntityId); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to check course readiness")
+	}
+	zerolog.Ctx(ctx).Warn().
+		Str("lesson_id", job.EntityId.String()).
+		Int("duplicates", len(duplicates)).
+		Str("duplicate_of", duplicates[0].LessonId.String()).
+		Msg("duplicate content flagged for review")
+	return nil
+}
+
+func (s *qcService) Flags(ctx context.Context, lessonId uuid.UUID) ([]*entities.QCFlag, error) {
+	return s.repo.ListLessonFlags(ctx, lessonId)
+}
+
+func (s *qcService) Open(ctx context.Context, check constant.QCCheck) ([]*entities.QCFlag, error) {
+	return s.repo.ListOpenFlags(ctx, check, openFlagsLimit)
+}
+
+func (s *qcService) Review(ctx context.Context, id uuid.UUID, request dto.QCReviewRequest) (*entities.QCFlag, error) {
+	if request.Status != constant.QCFlagStatusCleared && request.Status != constant.QCFlagStatusConfirmed {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("a flag is reviewed as %s or %s, not %q",
+			constant.QCFlagStatusCleared, constant.QCFlagStatusConfirmed, request.Status))
+	}
+	flag, err := s.repo.FindFlag(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	reviewed, err := s.repo.ReviewFlag(ctx, id, request.Status, request.ReviewerId, request.Note)
+	if err != nil {
+		return nil, err
+	}
+	if !reviewed {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("flag %s was already %s", id, flag.Status))
+	}
+	if err := s.courses.Check(ctx, flag.LessonId); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to check course readiness")
+	}
+	zerolog.Ctx(ctx).Info().
+		Str("flag_id", id.String()).
+		Str("lesson_id", flag.LessonId.String()).
+		Str("status", string(request.Status)).
+		Msg("qc flag reviewed")
+	return s.repo.FindFlag(ctx, id)
+}
+
+// musicSample cuts length seconds of audio at at into a mono MP3, the input
+// fingerprinting services expect.
+func musicSample(ctx context.Context, source, dir string, at, length float64) ([]byte, error) {
+	output := filepath.Join(dir, "sample.mp3")
+	_, err := ffmpegStderr(ctx, []string{"-hide_banner", "-nostats", "-y",
+		"-ss", strconv.FormatFloat(at, 'f', 3, 64),
+		"-t", strconv.FormatFloat(length, 'f', 3, 64),
+		"-i", source,
+		"-map", "0:a:0", "-vn",
+		"-ac", "1", "-ar", "44100", "-b:a", "128k",
+		output,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(output)
+}
+
+// mergeMusicMatch extends the last match over the next sample when both
+// heard the same recording, so a song playing under a whole lesson is one
+// match rather than one per sample.
+func mergeMusicMatch(matches entities.MusicMatches, match entities.MusicMatch, interval float64) entities.MusicMatches {
+	if n := len(matches); n > 0 {
+		last := &matches[n-1]
+		if strings.EqualFold(last.Title, match.Title) && strings.EqualFold(last.Artist, match.Artist) &&
+			match.At-last.At-last.Duration <= interval {
+			last.Duration = match.At + match.Duration - last.At
+			last.Score = max(last.Score, match.Score)
+			return matches
+		}
+	}
+	return append(matches, match)
+}
+
+func NewQCService(repo repository.QCRepository, fingerprints repository.FingerprintRepository, courses CourseService, cfg *config.Config) QCService {
+	return &qcService{
+		repo:         repo,
+		fingerprints: fingerprints,
+		courses:      courses,
+		cfg:          cfg,
+	}
+}
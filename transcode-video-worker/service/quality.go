@@ -0,0 +1,349 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// qualityHeight caps the lines renditions are scored at, bounding the cost
+// of scoring a 4K source.
+const qualityHeight = 1080
+
+// ErrQualityFailed is returned for a job whose package fell below its
+// preset's quality gate.
+var ErrQualityFailed = errors.New("package is below its preset's quality gate")
+
+// maxPSNR stands for the infinite PSNR of a rung identical to its source.
+const maxPSNR = 100
+
+var (
+	psnrSummaryPattern = regexp.MustCompile(`PSNR .*average:(inf|[0-9.]+)`)
+	ssimSummaryPattern = regexp.MustCompile(`SSIM .*All:([0-9.]+)`)
+)
+
+// QualityService scores each rendition a preset encodes with VMAF against its
+// source, so a preset change can be judged by the quality and bitrate
+// efficiency its versions give rather than by eye.
+type QualityService interface {
+	// Score rates every rendition the job encoded into outputDir and saves
+	// the scores in place of any an earlier attempt saved.
+	Score(ctx context.Context, job *entities.Job, preset *entities.Preset, inputFilepath, outputDir string, duration float64) error
+	Job(ctx context.Context, jobId uuid.UUID) ([]*entities.RenditionQuality, error)
+	Preset(ctx context.Context, name string) ([]dto.PresetQualityStats, error)
+	// Gate checks every rendition the job encoded into outputDir against the
+	// thresholds of its preset's quality gate and saves the verdict. A nil
+	// gate means there was no video to check.
+	Gate(ctx context.Context, job *entities.Job, preset *entities.Preset, inputFilepath, outputDir, packagePath string) (*entities.QualityGate, error)
+	FindGate(ctx context.Context, jobId uuid.UUID) (*entities.QualityGate, error)
+	// Override publishes the package a failed gate held back and completes
+	// its job.
+	Override(ctx context.Context, jobId uuid.UUID, request dto.QualityOverrideRequest) (*entities.QualityGate, error)
+}
+
+type qualityService struct {
+	repo     repository.QualityRepository
+	jobs     repository.JobRepository
+	versions VideoVersionService
+	courses  CourseService
+	cfg      *config.Config
+}
+
+// vmafLog is the part of libvmaf's JSON log the scores are read from.
+type vmafLog struct {
+	PooledMetrics struct {
+		VMAF struct {
+			Min          float64 `json:"min"`
+			Mean         float64 `json:"mean"`
+			HarmonicMean float64 `json:"harmonic_mean"`
+		} `json:"vmaf"`
+	} `json:"pooled_metrics"`
+}
+
+func (s *qualityService) Score(ctx context.Context, job *entities.Job, preset *entities.Preset, inputFilepath, outputDir string, duration float64) error {
+	info, err := ProbeMedia(ctx, inputFilepath)
+	if err != nil {
+		return err
+	}
+	video := info.VideoStream()
+	if video == nil || video.Width <= 0 || video.Height <= 0 {
+		return nil
+	}
+	dir, err := scratchDir(ctx, s.cfg, filepath.Join(job.ID.String(), "quality"))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	var scores []*entities.RenditionQuality
+	for _, r := range preset.Renditions {
+		playlist := filepath.Join(outputDir, fmt.Sprintf("%dp.m3u8", r.Height))
+		if _, err := os.Stat(playlist); errors.Is(err, os.ErrNotExist) {
+			// A rung above the source isn't encoded.
+			continue
+		}
+		measured, err := s.measureVMAF(ctx, inputFilepath, playlist, video, r, dir)
+		if err != nil {
+			return fmt.Errorf("rendition %dp: %w", r.Height, err)
+		}
+		segments, err := filepath.Glob(filepath.Join(outputDir, fmt.Sprintf("%dp_*", r.Height)))
+		if err != nil {
+			return err
+		}
+		var size int64
+		for _, segment := range segments {
+			if stat, err := os.Stat(segment); err == nil {
+				size += stat.Size()
+			}
+		}
+
+		score := &entities.RenditionQuality{
+			JobId:         job.ID,
+			LessonId:      job.EntityId,
+			Preset:        preset.Name,
+			PresetVersion: preset.Version,
+			Width:         r.Width,
+			Height:        r.Height,
+			Bitrate:       r.Bitrate,
+			VMAF:          measured.PooledMetrics.VMAF.Mean,
+			VMAFMin:       measured.PooledMetrics.VMAF.Min,
+			VMAFHarmonic:  measured.PooledMetrics.VMAF.HarmonicMean,
+		}
+		if duration > 0 {
+			score.AverageKbps = float64(size*8) / 1000 / duration
+		}
+		if score.AverageKbps > 0 {
+			score.VMAFPerMbps = score.VMAF / (score.AverageKbps / 1000)
+		}
+		scores = append(scores, score)
+	}
+
+	if err := s.repo.SaveRenditionQuality(ctx, job.ID, scores); err != nil {
+		return err
+	}
+	addJSONArtifact(ctx, "qc/quality.json", scores)
+
+	event := zerolog.Ctx(ctx).Info().Int("renditions", len(scores))
+	if len(scores) > 0 {
+		event = event.Float64("top_vmaf", scores[len(scores)-1].VMAF)
+	}
+	event.Msg("rendition quality scored")
+	return nil
+}
+
+// measureVMAF compares a rendition with its source. Both are brought to the
+// rung's shape at the source's lines, capped at qualityHeight: the rendition
+// scaled up as a player would, the source letterboxed as the encode was, so
+// the score counts what the ladder lost to scaling as well as to
+// compression.
+func (s *qualityService) measureVMAF(ctx context.Context, inputFilepath, playlist string, video *ProbeStream, r entities.Rendition, dir string) (*vmafLog, error) {
+	log := filepath.Join(dir, fmt.Sprintf("%dp.json", r.Height))
+
+	options := fmt.Sprintf("log_fmt=json:log_path=%s:n_subsample=%d", log, max(s.cfg.Quality.Subsample, 1))
+	if s.cfg.Server.FFmpegThreads > 0 {
+		options += fmt.Sprintf(":n_threads=%d", s.cfg.Server.FFmpegThreads)
+	}
+	if s.cfg.Quality.Model != "" {
+		options += ":model=" + s.cfg.Quality.Model
+	}
+	graph := comparedInputs(video, r) + ";[dist][ref]libvmaf=" + options
+	_, err := ffmpegStderr(ctx, []string{"-hide_banner", "-nostats",
+		"-i", playlist,
+		"-i", inputFilepath,
+		"-filter_complex", graph,
+		"-f", "null", "-",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := os.ReadFile(log)
+	if err != nil {
+		return nil, err
+	}
+	var measured vmafLog
+	if err := json.Unmarshal(raw, &measured); err != nil {
+		return nil, fmt.Errorf("parse vmaf log: %w", err)
+	}
+	return &measured, nil
+}
+
+// comparedInputs is the filter graph that brings a rung, input 0, and its
+// source, input 1, to the rung's shape at the source's lines, capped at
+// qualityHeight, as [dist] and [ref].
+func comparedInputs(video *ProbeStream, r entities.Rendition) string {
+	height := min(video.Height, qualityHeight) &^ 1
+	width := (r.Width*height/r.Height + 1) &^ 1
+	return fmt.Sprintf("[0:v:0]scale=%d:%d:flags=bicubic,setpts=PTS-STARTPTS[dist];"+
+		"[1:v:0]scale=w=%d:h=%d:force_original_aspect_ratio=decrease,pad=w=%d:h=%d:x=(ow-iw)/2:y=(oh-ih)/2,setpts=PTS-STARTPTS[ref]",
+		width, height, width, height, width, height)
+}
+
+func (s *qualityService) Gate(ctx context.Context, job *entities.Job, preset *entities.Preset, inputFilepath, outputDir, packagePath string) (*entities.QualityGate, error) {
+	thresholds := preset.Options.QualityGate
+	if thresholds == nil {
+		return nil, nil
+	}
+	info, err := ProbeMedia(ctx, inputFilepath)
+	if err != nil {
+		return nil, err
+	}
+	video := info.VideoStream()
+	if video == nil || video.Width <= 0 || video.Height <= 0 {
+		return nil, nil
+	}
+
+	gate := &entities.QualityGate{
+		JobId:         job.ID,
+		LessonId:      job.EntityId,
+		Preset:        preset.Name,
+		PresetVersion: preset.Version,
+		Status:        constant.QualityGateStatusPassed,
+		Thresholds:    *thresholds,
+		Renditions:    entities.GateRenditions{},
+		PackagePath:   packagePath,
+		CreatedAt:     time.Now().UTC(),
+	}
+	for _, r := range preset.Renditions {
+		playlist := filepath.Join(outputDir, fmt.Sprintf("%dp.m3u8", r.Height))
+		if _, err := os.Stat(playlist); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		psnr, ssim, err := s.measureFidelity(ctx, inputFilepath, playlist, video, r)
+		if err != nil {
+			return nil, fmt.Errorf("rendition %dp: %w", r.Height, err)
+		}
+		passed := (thresholds.MinPSNR == 0 || psnr >= thresholds.MinPSNR) && (thresholds.MinSSIM == 0 || ssim >= thresholds.MinSSIM)
+		if !passed {
+			gate.Status = constant.QualityGateStatusFailed
+		}
+		gate.Renditions = append(gate.Renditions, entities.GateRendition{Height: r.Height, PSNR: psnr, SSIM: ssim, Passed: passed})
+	}
+
+	if err := s.repo.SaveGate(ctx, gate); err != nil {
+		return nil, err
+	}
+	addJSONArtifact(ctx, "qc/gate.json", gate)
+	zerolog.Ctx(ctx).Info().
+		Str("status", string(gate.Status)).
+		Int("renditions", len(gate.Renditions)).
+		Msg("quality gate checked")
+	return gate, nil
+}
+
+// measureFidelity is the mean PSNR and SSIM of a rung against its source,
+// over every nth frame as the VMAF score samples them.
+func (s *qualityService) measureFidelity(ctx context.Context, inputFilepath, playlist string, video *ProbeStream, r entities.Rendition) (float64, float64, error) {
+	step := max(s.cfg.Quality.Subsample, 1)
+	graph := comparedInputs(video, r) + fmt.Sprintf(";[dist]framestep=%d,split[dist1][dist2];[ref]framestep=%d,split[ref1][ref2];"+
+		"[dist1][ref1]psnr;[dist2][ref2]ssim", step, step)
+	output, err := ffmpegStderr(ctx, []string{"-hide_banner", "-nostats",
+		"-i", playlist,
+		"-i", inputFilepath,
+		"-filter_complex", graph,
+		"-f", "null", "-",
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	psnrMatch := psnrSummaryPattern.FindStringSubmatch(output)
+	ssimMatch := ssimSummaryPattern.FindStringSubmatch(output)
+	if psnrMatch == nil || ssimMatch == nil {
+		return 0, 0, errors.New("ffmpeg reported no psnr or ssim")
+	}
+	psnr := float64(maxPSNR)
+	if psnrMatch[1] != "inf" {
+		if psnr, err = strconv.ParseFloat(psnrMatch[1], 64); err != nil {
+			return 0, 0, err
+		}
+		psnr = min(psnr, maxPSNR)
+	}
+	ssim, err := strconv.ParseFloat(ssimMatch[1], 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return psnr, ssim, nil
+}
+
+func (s *qualityService) FindGate(ctx context.Context, jobId uuid.UUID) (*entities.QualityGate, error) {
+	gate, err := s.repo.FindGate(ctx, jobId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	return gate, err
+}
+
+func (s *qualityService) Override(ctx context.Context, jobId uuid.UUID, request dto.QualityOverrideRequest) (*entities.QualityGate, error) {
+	gate, err := s.FindGate(ctx, jobId)
+	if err != nil {
+		return nil, err
+	}
+	if gate.Status != constant.QualityGateStatusFailed {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("quality gate of job %s is %s, not %s", jobId, gate.Status, constant.QualityGateStatusFailed))
+	}
+	job, err := s.jobs.FindJobById(ctx, jobId)
+	if err != nil {
+		return nil, err
+	}
+
+	// The package is published before the gate is settled, so an override
+	// that fails part way can be made again.
+	if err := s.versions.Publish(ctx, job, filepath.Join(gate.PackagePath, "master.m3u8")); err != nil {
+		return nil, err
+	}
+	if err := s.jobs.CompleteJob(ctx, jobId); err != nil {
+		return nil, err
+	}
+	overridden, err := s.repo.OverrideGate(ctx, jobId, request.ReviewerId, request.Note)
+	if err != nil {
+		return nil, err
+	}
+	if !overridden {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("quality gate of job %s was already overridden", jobId))
+	}
+	if err := s.courses.Check(ctx, job.EntityId); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to check course readiness")
+	}
+	zerolog.Ctx(ctx).Info().
+		Str("job_id", jobId.String()).
+		Str("lesson_id", job.EntityId.String()).
+		Msg("quality gate overridden")
+	return s.repo.FindGate(ctx, jobId)
+}
+
+func (s *qualityService) Job(ctx context.Context, jobId uuid.UUID) ([]*entities.RenditionQuality, error) {
+	return s.repo.ListJobQuality(ctx, jobId)
+}
+
+func (s *qualityService) Preset(ctx context.Context, name string) ([]dto.PresetQualityStats, error) {
+	return s.repo.PresetQualityStats(ctx, name)
+}
+
+func NewQualityService(repo repository.QualityRepository, jobs repository.JobRepository, versions VideoVersionService, courses CourseService, cfg *config.Config) QualityService {
+	return &qualityService{
+		repo:     repo,
+		jobs:     jobs,
+		versions: versions,
+		courses:  courses,
+		cfg:      cfg,
+	}
+}
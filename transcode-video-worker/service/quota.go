@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+const (
+	QuotaEventWarning  = "quota.warning"
+	QuotaEventExceeded = "quota.exceeded"
+	QuotaEventRejected = "quota.rejected"
+	QuotaEventOverage  = "quota.overage"
+)
+
+var ErrQuotaExceeded = errors.New("encoding minutes quota exceeded")
+
+// QuotaService meters the minutes of video each tenant has encoded per
+// calendar month and holds the tenants with a quota to it, telling the
+// billing service as they near or pass it.
+type QuotaService interface {
+	// Check holds the job in ctx, admitted for its tenant, to the tenant's
+	// quota. Past it, the job fails non-retryably under QUOTA_POLICY reject
+	// and is let through under warn.
+	Check(ctx context.Context, job *entities.Job) error
+	// Record adds the seconds the job encoded to its tenant's month.
+	Record(ctx context.Context, job *entities.Job, seconds float64) error
+	Usage(ctx context.Context, tenantId uuid.UUID) (*dto.EncodingUsage, error)
+}
+
+type quotaService struct {
+	repo      repository.TenantConfigRepository
+	publisher rabbitmq.Publisher
+	cfg       *config.Config
+}
+
+func (s *quotaService) Check(ctx context.Context, job *entities.Job) error {
+	tenant := tenantFromContext(ctx)
+	if tenant == nil || tenant.EncodingMinutesQuota == nil {
+		return nil
+	}
+	period := billingPeriod(time.Now())
+	used, err := s.usedSeconds(ctx, tenant.TenantId, period)
+	if err != nil {
+		return err
+	}
+	quota := *tenant.EncodingMinutesQuota
+	if used < float64(quota)*60 {
+		return nil
+	}
+
+	eventType := QuotaEventRejected
+	if s.cfg.Quota.Policy == "warn" {
+		eventType = QuotaEventOverage
+	}
+	if publishErr := s.publish(ctx, eventType, tenant.TenantId, job.ID, period, quota, used); publishErr != nil {
+		zerolog.Ctx(ctx).Warn().Err(publishErr).Msg("failed to publish quota event")
+	}
+	if eventType == QuotaEventOverage {
+		zerolog.Ctx(ctx).Warn().
+			Str("tenant_id", tenant.TenantId.String()).
+			Float64("used_minutes", used/60).
+			Int("quota_minutes", quota).
+			Msg("tenant past its encoding quota, job processed anyway")
+		return nil
+	}
+	return errors.Join(ErrNonRetryable, ErrQuotaExceeded, fmt.Errorf("%.0f of %d minutes encoded in %s", used/60, quota, period.Format("2006-01")))
+}
+
+func (s *quotaService) Record(ctx context.Context, job *entities.Job, seconds float64) error {
+	if job.TenantId == nil || seconds <= 0 {
+		return nil
+	}
+	period := billingPeriod(time.Now())
+	usage, err := s.repo.AddEncodingUsage(ctx, *job.TenantId, period, seconds)
+	if err != nil {
+		return err
+	}
+	tenant := tenantFromContext(ctx)
+	if tenant == nil || tenant.EncodingMinutesQuota == nil {
+		return nil
+	}
+
+	// Each event is sent once a month, by whichever job crosses its line.
+	quota := *tenant.EncodingMinutesQuota
+	crossed := []struct {
+		eventType string
+		at        float64
+		mark      func(ctx context.Context, tenantId uuid.UUID, period time.Time) (bool, error)
+	}{
+		{QuotaEventExceeded, float64(quota) * 60, s.repo.MarkUsageExceeded},
+		{QuotaEventWarning, float64(quota) * 60 * float64(s.cfg.Quota.WarnPercent) / 100, s.repo.MarkUsageWarned},
+	}
+	for _, line := range crossed {
+		if usage.Seconds < line.at || (line.eventType == QuotaEventWarning && s.cfg.Quota.WarnPercent <= 0) {
+			continue
+		}
+		first, err := line.mark(ctx, *job.TenantId, period)
+		if err != nil {
+			return err
+		}
+		if !first {
+			continue
+		}
+		if err := s.publish(ctx, line.eventType, *job.TenantId, job.ID, period, quota, usage.Seconds); err != nil {
+			return err
+		}
+		zerolog.Ctx(ctx).Info().
+			Str("tenant_id", job.TenantId.String()).
+			Float64("used_minutes", usage.Seconds/60).
+			Int("quota_minutes", quota).
+			Str("event_type", line.eventType).
+			Msg("quota event sent")
+	}
+	return nil
+}
+
+func (s *quotaService) Usage(ctx context.Context, tenantId uuid.UUID) (*dto.EncodingUsage, error) {
+	period := billingPeriod(time.Now())
+	result := &dto.EncodingUsage{TenantId: tenantId, Period: period.Format("2006-01"), Policy: s.cfg.Quota.Policy}
+	usage, err := s.repo.FindEncodingUsage(ctx, tenantId, period)
+	switch {
+	case err == nil:
+		result.UsedMinutes, result.Jobs = usage.Seconds/60, usage.Jobs
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, err
+	}
+	tenant, err := s.repo.FindTenantConfig(ctx, tenantId)
+	switch {
+	case err == nil:
+		result.QuotaMinutes = tenant.EncodingMinutesQuota
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *quotaService) usedSeconds(ctx context.Context, tenantId uuid.UUID, period time.Time) (float64, error) {
+	usage, err := s.repo.FindEncodingUsage(ctx, tenantId, period)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return usage.Seconds, nil
+}
+
+// publish sends the event with its type as routing key, e.g. quota.warning.
+func (s *quotaService) publish(ctx context.Context, eventType string, tenantId, jobId uuid.UUID, period time.Time, quota int, usedSeconds float64) error {
+	return s.publisher.Publish(ctx, s.cfg.Quota.Exchange, eventType, dto.QuotaEvent{
+		EventId:      uuid.New(),
+		EventType:    eventType,
+		OccurredAt:   time.Now().UTC(),
+		TenantId:     tenantId,
+		JobId:        jobId,
+		Period:       period.Format("2006-01"),
+		QuotaMinutes: quota,
+		UsedMinutes:  usedSeconds / 60,
+	})
+}
+
+// billingPeriod is the first day of the calendar month of t, in UTC.
+func billingPeriod(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func NewQuotaService(repo repository.TenantConfigRepository, publisher rabbitmq.Publisher, cfg *config.Config) QuotaService {
+	return &quotaService{
+		repo:      repo,
+		publisher: publisher,
+		cfg:       cfg,
+	}
+}
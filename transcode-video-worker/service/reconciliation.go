@@ -0,0 +1,325 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/alerting"
+	"worker-transcode/pkg/metrics"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// reconciliationRetention is how long reconciliation runs are kept.
+const reconciliationRetention = 30 * 24 * time.Hour
+
+// ReconciliationService finds lessons whose video is broken before students
+// do, by cross-checking what the catalog says each lesson plays against its
+// active video version, the renditions its job recorded and the objects in
+// the bucket.
+type ReconciliationService interface {
+	// Run reconciles every RECONCILE_INTERVAL hours until ctx is done and
+	// alerts on the lessons with problems. Only the leader runs it.
+	Run(ctx context.Context)
+	// Reconcile checks every lesson with a video and records what it found.
+	Reconcile(ctx context.Context) (*dto.ReconciliationReport, error)
+	// Latest returns the report of the last reconciliation that finished.
+	Latest(ctx context.Context) (*dto.ReconciliationReport, error)
+}
+
+type reconciliationService struct {
+	repo  repository.ReconciliationRepository
+	store objectstore.Store
+	cfg   *config.Config
+}
+
+func (s *reconciliationService) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(s.cfg.Reconcile.Interval) * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		report, err := s.Reconcile(ctx)
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to reconcile lesson media")
+		} else {
+			s.alert(ctx, report)
+		}
+		if err := s.repo.DeleteReconciliations(ctx, time.Now().Add(-reconciliationRetention)); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to delete old media reconciliations")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reconcile saves the findings of each batch of lessons as it goes, so a run
+// cut short by a restart still records what it got through.
+func (s *reconciliationService) Reconcile(ctx context.Context) (*dto.ReconciliationReport, error) {
+	run := &entities.MediaReconciliation{StartedAt: time.Now().UTC()}
+	if err := s.repo.CreateReconciliation(ctx, run); err != nil {
+		return nil, err
+	}
+	report := &dto.ReconciliationReport{Reconciliation: run, Findings: []*entities.ReconciliationFinding{}}
+
+	after := uuid.Nil
+	for {
+		lessons, err := s.repo.ListReconciledLessons(ctx, after, s.cfg.Reconcile.BatchSize)
+		if err != nil {
+			return nil, err
+		}
+		var findings []*entities.ReconciliationFinding
+		for _, lesson := range lessons {
+			problems, err := s.check(ctx, lesson)
+			if err != nil {
+				return nil, fmt.Errorf("lesson %s: %w", lesson.LessonId, err)
+			}
+			for _, problem := range problems {
+				problem.ReconciliationId = run.ID
+				problem.LessonId = lesson.LessonId
+				problem.CourseId = lesson.CourseId
+				findings = append(findings, problem)
+			}
+		}
+		if err := s.repo.SaveFindings(ctx, findings); err != nil {
+			return nil, err
+		}
+		run.Lessons += len(lessons)
+		run.Findings += len(findings)
+		report.Findings = append(report.Findings, findings...)
+		if len(lessons) < s.cfg.Reconcile.BatchSize {
+			break
+		}
+		after = lessons[len(lessons)-1].LessonId
+	}
+
+	finished := time.Now().UTC()
+	run.FinishedAt = &finished
+	if err := s.repo.FinishReconciliation(ctx, run); err != nil {
+		return nil, err
+	}
+
+	byProblem := map[constant.ReconciliationProblem]int{}
+	for _, finding := range report.Findings {
+		byProblem[finding.Problem]++
+	}
+	metrics.ReconciliationFindings.Reset()
+	for problem, count := range byProblem {
+		metrics.ReconciliationFindings.WithLabelValues(string(problem)).Set(float64(count))
+	}
+	zerolog.Ctx(ctx).Info().
+		Int("lessons", run.Lessons).
+		Int("findings", run.Findings).
+		Dur("took", finished.Sub(run.StartedAt)).
+		Msg("lesson media reconciled")
+	return report, nil
+}
+
+func (s *reconciliationService) Latest(ctx context.Context) (*dto.ReconciliationReport, error) {
+	run, err := s.repo.FindLatestReconciliation(ctx)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, errors.New("no media reconciliation has finished yet"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	findings, err := s.repo.ListFindings(ctx, run.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &dto.ReconciliationReport{Reconciliation: run, Findings: findings}, nil
+}
+
+func (s *reconciliationService) alert(ctx context.Context, report *dto.ReconciliationReport) {
+	var problems []alerting.MediaProblem
+	seen := map[uuid.UUID]bool{}
+	for _, finding := range report.Findings {
+		if seen[finding.LessonId] {
+			continue
+		}
+		seen[finding.LessonId] = true
+		problems = append(problems, alerting.MediaProblem{
+			LessonId: finding.LessonId.String(),
+			Problem:  fmt.Sprintf("%s: %s", finding.Problem, finding.Detail),
+		})
+	}
+	alerting.MediaUnreconciled(ctx, report.Reconciliation.Lessons, len(problems), problems)
+}
+
+// check finds what's wrong with the lesson's video. Videos served from
+// elsewhere than the bucket aren't checked.
+func (s *reconciliationService) check(ctx context.Context, lesson *entities.ReconciledLesson) ([]*entities.ReconciliationFinding, error) {
+	var findings []*entities.ReconciliationFinding
+	found := func(problem constant.ReconciliationProblem, format string, args ...interface{}) {
+		findings = append(findings, &entities.ReconciliationFinding{Problem: problem, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	key := lesson.VideoUrl
+	switch {
+	case strings.Contains(key, "://"):
+		return nil, nil
+	case !strings.HasSuffix(key, ".m3u8"):
+		_, err := s.store.StatObject(ctx, s.cfg.MinIOBucket, key, minio.StatObjectOptions{})
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			found(constant.ReconciliationMissingSource, "upload %s is not in the bucket", key)
+			return findings, nil
+		}
+		return nil, err
+	}
+
+	if lesson.VersionKey != nil && *lesson.VersionKey != key {
+		found(constant.ReconciliationVersionMismatch, "lesson plays %s, its active version is %s", key, *lesson.VersionKey)
+	}
+	if !lesson.HasMedia {
+		found(constant.ReconciliationMissingMedia, "the catalog has no measurements of %s", key)
+	}
+
+	objects := map[string]int64{}
+	prefix := path.Dir(key) + "/"
+	for object := range s.store.ListObjects(ctx, s.cfg.MinIOBucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("list package: %w", object.Err)
+		}
+		objects[object.Key] = object.Size
+	}
+	if _, ok := objects[key]; !ok {
+		found(constant.ReconciliationMissingPackage, "master playlist %s is not in the bucket", key)
+		return findings, nil
+	}
+
+	master, err := readObjectLines(ctx, s.store, s.cfg.MinIOBucket, key)
+	if err != nil {
+		return nil, fmt.Errorf("read master playlist: %w", err)
+	}
+	var uris []string
+	for i, line := range master {
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MEDIA:"):
+			if match := uriPattern.FindStringSubmatch(line); match != nil {
+				uris = append(uris, match[1])
+			}
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:") && i+1 < len(master):
+			uris = append(uris, master[i+1])
+		}
+	}
+	if len(uris) == 0 {
+		found(constant.ReconciliationIncompletePackage, "master playlist %s references no playlists", key)
+		return findings, nil
+	}
+
+	heights := map[int]bool{}
+	for _, uri := range uris {
+		if match := rungFilePattern.FindStringSubmatch(path.Base(uri)); match != nil {
+			height, _ := strconv.Atoi(match[1])
+			heights[height] = true
+		}
+		if strings.Contains(uri, "://") {
+			continue
+		}
+		playlistKey := path.Join(path.Dir(key), uri)
+		if _, ok := objects[playlistKey]; !ok {
+			found(constant.ReconciliationIncompletePackage, "playlist %s is not in the bucket", uri)
+			continue
+		}
+		problem, err := s.checkPlaylist(ctx, playlistKey, objects)
+		if err != nil {
+			return nil, err
+		}
+		if problem != "" {
+			found(constant.ReconciliationIncompletePackage, "playlist %s %s", uri, problem)
+		}
+	}
+	// Packages whose playlists aren't named for their rung can't be matched
+	// to the renditions recorded.
+	if len(heights) > 0 {
+		for _, rendition := range lesson.Renditions {
+			if rendition.Height > 0 && !heights[rendition.Height] {
+				found(constant.ReconciliationMissingRendition, "%dp was output by the job but the master playlist doesn't list it", rendition.Height)
+			}
+		}
+	}
+	return findings, nil
+}
+
+// checkPlaylist describes what's wrong with the media playlist at key, given
+// the objects of its package, or is empty when nothing is.
+func (s *reconciliationService) checkPlaylist(ctx context.Context, key string, objects map[string]int64) (string, error) {
+	lines, err := readObjectLines(ctx, s.store, s.cfg.MinIOBucket, key)
+	if err != nil {
+		return "", fmt.Errorf("read playlist %s: %w", key, err)
+	}
+
+	var segments, missing, empty int
+	var first string
+	ended := false
+	for _, line := range lines {
+		uri := line
+		switch {
+		case line == "#EXT-X-ENDLIST":
+			ended = true
+			continue
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			match := uriPattern.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+			uri = match[1]
+		case line == "" || strings.HasPrefix(line, "#") || strings.Contains(line, "://"):
+			continue
+		}
+		segments++
+		size, ok := objects[path.Join(path.Dir(key), uri)]
+		switch {
+		case !ok:
+			missing++
+		case size == 0:
+			empty++
+		default:
+			continue
+		}
+		if first == "" {
+			first = uri
+		}
+	}
+
+	var problems []string
+	if missing > 0 {
+		problems = append(problems, fmt.Sprintf("is missing %d of %d segments", missing, segments))
+	}
+	if empty > 0 {
+		problems = append(problems, fmt.Sprintf("has %d empty segments", empty))
+	}
+	if first != "" {
+		problems = append(problems, "the first being "+first)
+	}
+	if segments == 0 {
+		problems = append(problems, "has no segments")
+	}
+	if !ended {
+		problems = append(problems, "has no EXT-X-ENDLIST")
+	}
+	return strings.Join(problems, ", "), nil
+}
+
+func NewReconciliationService(repo repository.ReconciliationRepository, store objectstore.Store, cfg *config.Config) ReconciliationService {
+	return &reconciliationService{
+		repo:  repo,
+		store: store,
+		cfg:   cfg,
+	}
+}
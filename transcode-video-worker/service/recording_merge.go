@@ -7,12 +7,17 @@ import (
 	"github.com/minio/minio-go/v7"
 	"github.com/rs/zerolog"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 	"worker-transcode/config"
 	"worker-transcode/constant"
 	"worker-transcode/dto"
+	"worker-transcode/pkg/correlation"
+	"worker-transcode/pkg/ffmpeg"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/pkg/reporting"
 	"worker-transcode/entities"
 	"worker-transcode/repository"
 )
@@ -22,8 +27,10 @@ type RecordingMergeService interface {
 }
 
 type recordingMergeService struct {
-	repo repository.JobRepository
-	cfg  *config.Config
+	repo   repository.JobRepository
+	events repository.JobEventRepository
+	store  objectstore.Store
+	cfg    *config.Config
 }
 
 func (s *recordingMergeService) ProcessRecordingMerge(ctx context.Context, message dto.RecordingMergeMessage) (err error) {
@@ -43,17 +50,47 @@ func (s *recordingMergeService) ProcessRecordingMerge(ctx context.Context, messa
 		return nil
 	}
 
-	if err := s.repo.UpdateStatusJob(ctx, constant.JobStatusProcessing, message.JobId); err != nil {
+	claimed, err := s.repo.ClaimJob(ctx, message.JobId, correlation.FromContext(ctx), workerFromContext(ctx))
+	if err != nil {
 		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to update job status")
 		return err
 	}
+	if !claimed {
+		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("job already claimed by another delivery")
+		return nil
+	}
+	rabbitmq.Claimed(ctx)
+	ctx = withTimeline(ctx, s.events, job.ID)
+	recordStatus(ctx, constant.JobStatusPending, constant.JobStatusProcessing)
 
+	stage := constant.ErrorClassDatabase
 	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = panicked(ctx, recovered)
+		}
+		if errors.Is(err, ErrInsufficientResources) {
+			postpone(ctx, s.repo, message.JobId, err)
+			return
+		}
+		if shuttingDown(ctx, err) {
+			err = handOff(ctx, s.repo, message.JobId, err)
+			return
+		}
+		err = classify(err)
+		recordOutcome(ctx, job, stage, err)
+		recordOutcomeEvents(ctx, stage, err)
 		if err != nil {
 			if errors.Is(err, ErrNonRetryable) {
-				if updateErr := s.repo.UpdateStatusJob(ctx, constant.JobStatusFailed, message.JobId); updateErr != nil {
+				if updateErr := s.repo.FailJob(ctx, message.JobId, stage, errorKind(err), err.Error()); updateErr != nil {
 					zerolog.Ctx(ctx).Error().Err(updateErr).Msg("failed to update job status")
 				}
+				reporting.CaptureFailure(ctx, err, reporting.Failure{
+					JobId:   message.JobId.String(),
+					JobType: string(job.JobType),
+					Stage:   string(stage),
+					Output:  failureOutput(err),
+					Extra:   map[string]interface{}{"live_session_id": message.LiveSessionId.String()},
+				})
 				err = nil
 			} else {
 				if updateErr := s.repo.UpdateStatusJob(ctx, constant.JobStatusPending, message.JobId); updateErr != nil {
@@ -102,6 +139,7 @@ func (s *recordingMergeService) ProcessRecordingMerge(ctx context.Context, messa
 	}
 
 	// Create temporary directories
+	stage = constant.ErrorClassWorkspace
 	tempDir := filepath.Join("temp", message.JobId.String())
 	defer os.RemoveAll(tempDir)
 
@@ -117,9 +155,20 @@ func (s *recordingMergeService) ProcessRecordingMerge(ctx context.Context, messa
 		return errors.Join(ErrNonRetryable, err)
 	}
 
+	release, err := preflight(ctx, s.cfg, tempDir, mergeScratch(chunks))
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	// Download all chunks from MinIO using object_name from database
+	stage = constant.ErrorClassDownload
 	zerolog.Ctx(ctx).Info().Int("total_chunks", len(chunks)).Msg("starting to download chunks from MinIO")
-	chunkPaths, err := s.downloadChunks(ctx, chunks, chunksDir)
+	var chunkPaths []string
+	err = traceStage(ctx, "download", func(ctx context.Context) error {
+		chunkPaths, err = s.downloadChunks(ctx, chunks, chunksDir)
+		return err
+	})
 	if err != nil {
 		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to download chunks")
 		return err
@@ -137,6 +186,7 @@ func (s *recordingMergeService) ProcessRecordingMerge(ctx context.Context, messa
 		Msg("all chunks downloaded successfully")
 
 	// Merge chunks using FFmpeg
+	stage = constant.ErrorClassTranscode
 	outputFileName := "final.mp4"
 	outputFilePath := filepath.Join(outputDir, outputFileName)
 
@@ -146,7 +196,10 @@ func (s *recordingMergeService) ProcessRecordingMerge(ctx context.Context, messa
 		Str("output_file", outputFilePath).
 		Msg("starting to merge chunks with FFmpeg")
 	
-	if err = mergeWebMChunks(ctx, chunkPaths, outputFilePath); err != nil {
+	err = traceStage(ctx, "merge", func(ctx context.Context) error {
+		return mergeWebMChunks(ctx, chunkPaths, outputFilePath)
+	})
+	if err != nil {
 		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to merge chunks")
 		// Update chunks status to FAILED
 		for _, chunk := range chunks {
@@ -167,14 +220,22 @@ func (s *recordingMergeService) ProcessRecordingMerge(ctx context.Context, messa
 	outputKey := filepath.Join(sessionFolder, "final", "recording.mp4")
 	outputKey = strings.ReplaceAll(outputKey, "\\", "/")
 
+	stage = constant.ErrorClassUpload
 	zerolog.Ctx(ctx).Info().Str("output_key", outputKey).Msg("uploading final video to MinIO")
-	_, err = s.cfg.Storage.FPutObject(ctx, s.cfg.MinIOBucket, outputKey, outputFilePath, minio.PutObjectOptions{
-		ContentType: "video/mp4",
+	uploadStart := time.Now()
+	var uploaded int64
+	err = traceStage(ctx, "upload", func(ctx context.Context) error {
+		object, err := s.store.FPutObject(ctx, s.cfg.MinIOBucket, outputKey, outputFilePath, minio.PutObjectOptions{
+			ContentType: "video/mp4",
+		})
+		uploaded = object.Size
+		return err
 	})
 	if err != nil {
 		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to upload final video")
 		return err
 	}
+	observeThroughput(ctx, uploaded, time.Since(uploadStart))
 
 	// Update chunks status to COMPLETED
 	for _, chunk := range chunks {
@@ -209,6 +270,7 @@ func (s *recordingMergeService) ProcessRecordingMerge(ctx context.Context, messa
 	}
 
 	// Update job status to completed
+	stage = constant.ErrorClassDatabase
 	if err = s.repo.UpdateStatusJob(ctx, constant.JobStatusCompleted, message.JobId); err != nil {
 		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to update job status")
 		return err
@@ -249,7 +311,7 @@ func (s *recordingMergeService) downloadChunks(ctx context.Context, chunks []*en
 			Interface("file_size", chunk.FileSize).
 			Msg("downloading chunk from MinIO")
 
-		err := s.cfg.Storage.FGetObject(ctx, s.cfg.MinIOBucket, objectName, localPath, minio.GetObjectOptions{})
+		err := s.store.FGetObject(ctx, s.cfg.MinIOBucket, objectName, localPath, minio.GetObjectOptions{})
 		if err != nil {
 			zerolog.Ctx(ctx).Error().
 				Err(err).
@@ -323,8 +385,10 @@ func mergeWebMChunks(ctx context.Context, chunkPaths []string, outputPath string
 			mp4Path,
 		}
 
-		cmd := exec.Command("ffmpeg", convertArgs...)
+		cmd := ffmpeg.Command(ctx, convertArgs...)
+		done := trackFFmpeg()
 		output, err := cmd.CombinedOutput()
+		done()
 		
 		if err != nil {
 			zerolog.Ctx(ctx).Error().
@@ -339,7 +403,7 @@ func mergeWebMChunks(ctx context.Context, chunkPaths []string, outputPath string
 				os.Remove(mp4Path)
 			}
 			
-			return fmt.Errorf("failed to convert chunk %d: %w\nOutput: %s", i, err, string(output))
+			return fmt.Errorf("failed to convert chunk %d: %w", i, &FFmpegError{Err: err, Output: string(output)})
 		}
 
 		// Get converted file size
@@ -416,8 +480,10 @@ func mergeWebMChunks(ctx context.Context, chunkPaths []string, outputPath string
 		Strs("ffmpeg_args", ffmpegArgs).
 		Msg("executing FFmpeg merge command for MP4 files")
 
-	cmd := exec.Command("ffmpeg", ffmpegArgs...)
+	cmd := ffmpeg.Command(ctx, ffmpegArgs...)
+	done := trackFFmpeg()
 	output, err := cmd.CombinedOutput()
+	done()
 	
 	zerolog.Ctx(ctx).Info().
 		Str("ffmpeg_output", string(output)).
@@ -434,7 +500,7 @@ func mergeWebMChunks(ctx context.Context, chunkPaths []string, outputPath string
 			os.Remove(mp4Path)
 		}
 		
-		return fmt.Errorf("ffmpeg merge failed: %w\nOutput: %s", err, string(output))
+		return fmt.Errorf("ffmpeg merge failed: %w", &FFmpegError{Err: err, Output: string(output)})
 	}
 
 	// Step 4: Cleanup temp MP4 files
@@ -463,10 +529,12 @@ func mergeWebMChunks(ctx context.Context, chunkPaths []string, outputPath string
 	return nil
 }
 
-func NewRecordingMergeService(repo repository.JobRepository, cfg *config.Config) RecordingMergeService {
+func NewRecordingMergeService(repo repository.JobRepository, events repository.JobEventRepository, store objectstore.Store, cfg *config.Config) RecordingMergeService {
 	return &recordingMergeService{
-		repo: repo,
-		cfg:  cfg,
+		repo:   repo,
+		events: events,
+		store:  store,
+		cfg:    cfg,
 	}
 }
 
@@ -0,0 +1,263 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/search"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// profanity is the built-in list of words flagged, besides REDACTION_WORDS.
+// Each is flagged with its usual English endings too.
+var profanity = []string{
+	"arse", "arsehole", "ass", "asshole", "bastard", "bitch", "bollocks", "bullshit", "cock", "crap",
+	"cunt", "damn", "dick", "dickhead", "fag", "faggot", "fuck", "goddamn", "motherfuck", "nigga",
+	"nigger", "piss", "prick", "pussy", "retard", "shit", "slut", "twat", "wanker", "whore",
+}
+
+var (
+	emailPattern = regexp.MustCompile(`(?i)\b[a-z0-9._%+-]+@[a-z0-9-]+(?:\.[a-z0-9-]+)*\.[a-z]{2,}\b`)
+	// spokenEmailPattern is an address as a transcript spells it out,
+	// "jane dot doe at example dot com".
+	spokenEmailPattern = regexp.MustCompile(`(?i)\b[a-z0-9]+(?: dot [a-z0-9]+)* at [a-z0-9-]+(?: dot [a-z0-9-]+)+\b`)
+	phonePattern       = regexp.MustCompile(`(?:\+\d{1,3}[\s.-]?)?(?:\(\d{2,4}\)\s?|\b\d{2,4}[\s.-])\d{3,4}[\s.-]?\d{3,4}\b`)
+	yearPattern        = regexp.MustCompile(`^(?:19|20)\d\d$`)
+)
+
+// RedactionService flags the profanity and spoken personal details in
+// lesson transcripts as they're indexed, with when in the video they're
+// said, for schools that must keep them from students. A lesson's tenant
+// turns the check and bleeping on or off with the redaction and bleep
+// features, as tenant configs do for the stages of lesson processing.
+type RedactionService interface {
+	// Check flags what's in the transcript's cues and returns them as they
+	// should be indexed: masked where the words were bleeped.
+	Check(ctx context.Context, lessonId uuid.UUID, language string, cues []search.Cue) ([]search.Cue, error)
+	// List returns what was flagged in each of the lesson's transcripts.
+	List(ctx context.Context, lessonId uuid.UUID) ([]*entities.LessonRedaction, error)
+}
+
+type redactionService struct {
+	repo      repository.RedactionRepository
+	audio     AudioReplacementService
+	profanity *regexp.Regexp
+	cfg       *config.Config
+}
+
+func (s *redactionService) Check(ctx context.Context, lessonId uuid.UUID, language string, cues []search.Cue) ([]search.Cue, error) {
+	// Transcripts come through the API as well as from jobs, so the tenant
+	// is the one whose job transcoded the lesson.
+	if tenantFromContext(ctx) == nil {
+		tenant, err := s.repo.FindLessonTenant(ctx, lessonId)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if tenant != nil {
+			ctx = context.WithValue(ctx, tenantKey{}, tenant)
+		}
+	}
+	if !featureEnabled(ctx, constant.TenantFeatureRedaction, s.cfg.Redaction.Enabled) {
+		return cues, nil
+	}
+
+	findings, masked := s.detect(cues)
+	redaction := &entities.LessonRedaction{
+		LessonId: lessonId,
+		Language: language,
+		Findings: findings,
+	}
+	bleep := featureEnabled(ctx, constant.TenantFeatureBleep, s.cfg.Redaction.Bleep)
+	if bleep && len(findings) > 0 {
+		if err := s.bleep(ctx, redaction); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.repo.SaveRedaction(ctx, redaction); err != nil {
+		return nil, err
+	}
+
+	if len(findings) > 0 {
+		zerolog.Ctx(ctx).Info().
+			Str("lesson_id", lessonId.String()).
+			Str("language", language).
+			Int("findings", len(findings)).
+			Bool("bleep", bleep).
+			Msg("transcript redaction flagged")
+	}
+	if bleep {
+		return masked, nil
+	}
+	return cues, nil
+}
+
+// bleep queues an audio replacement bleeping the redaction's findings that
+// aren't bleeped yet. Those a replacement of an earlier transcript of the
+// lesson covers, in any language, aren't bleeped again. A lesson without a
+// published video yet is bleeped when its transcript is indexed again.
+func (s *redactionService) bleep(ctx context.Context, redaction *entities.LessonRedaction) error {
+	earlier, err := s.repo.ListRedactions(ctx, redaction.LessonId)
+	if err != nil {
+		return err
+	}
+	var bleeped entities.Bleeps
+	for _, r := range earlier {
+		for _, finding := range r.Findings {
+			if finding.Bleeped {
+				bleeped = append(bleeped, entities.Bleep{Start: finding.Start, End: finding.End})
+			}
+		}
+		if r.Language == redaction.Language {
+			redaction.AudioReplacementId = r.AudioReplacementId
+		}
+	}
+
+	var bleeps entities.Bleeps
+	var fresh []int
+	for i, finding := range redaction.Findings {
+		covered := slices.ContainsFunc(bleeped, func(b entities.Bleep) bool {
+			return b.Start <= finding.Start && finding.End <= b.End
+		})
+		if covered {
+			redaction.Findings[i].Bleeped = true
+			continue
+		}
+		bleeps = append(bleeps, entities.Bleep{Start: finding.Start, End: finding.End})
+		fresh = append(fresh, i)
+	}
+	if len(bleeps) == 0 {
+		return nil
+	}
+
+	replacement, err := s.audio.Request(ctx, redaction.LessonId, dto.AudioReplacementRequest{Bleeps: bleeps})
+	if errors.Is(err, ErrInvalidArgument) {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("lesson_id", redaction.LessonId.String()).Msg("lesson audio can't be bleeped yet")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, i := range fresh {
+		redaction.Findings[i].Bleeped = true
+	}
+	redaction.AudioReplacementId = &replacement.ID
+	return nil
+}
+
+func (s *redactionService) List(ctx context.Context, lessonId uuid.UUID) ([]*entities.LessonRedaction, error) {
+	return s.repo.ListRedactions(ctx, lessonId)
+}
+
+// redactionMatch is where in a cue's text a flagged word is.
+type redactionMatch struct {
+	from, to int
+	kind     constant.RedactionKind
+}
+
+// detect finds the flagged words in the cues and returns them, with the
+// cues where they're masked. A word's time is estimated from where in its
+// cue it is, as if the cue were spoken evenly, and padded either side.
+func (s *redactionService) detect(cues []search.Cue) (entities.RedactionFindings, []search.Cue) {
+	var findings entities.RedactionFindings
+	masked := slices.Clone(cues)
+	for c, cue := range cues {
+		var matches []redactionMatch
+		add := func(pattern *regexp.Regexp, kind constant.RedactionKind, keep func(string) bool) {
+			for _, at := range pattern.FindAllStringIndex(cue.Text, -1) {
+				if keep == nil || keep(cue.Text[at[0]:at[1]]) {
+					matches = append(matches, redactionMatch{from: at[0], to: at[1], kind: kind})
+				}
+			}
+		}
+		add(emailPattern, constant.RedactionKindEmail, nil)
+		add(spokenEmailPattern, constant.RedactionKindEmail, nil)
+		add(phonePattern, constant.RedactionKindPhone, isPhoneNumber)
+		add(s.profanity, constant.RedactionKindProfanity, nil)
+		if len(matches) == 0 {
+			continue
+		}
+		slices.SortFunc(matches, func(a, b redactionMatch) int {
+			return cmp.Or(cmp.Compare(a.from, b.from), cmp.Compare(b.to, a.to))
+		})
+
+		var text strings.Builder
+		last := 0
+		length := float64(len(cue.Text))
+		for _, m := range matches {
+			// A word inside one already flagged, like an address's name, is
+			// part of it.
+			if m.from < last {
+				continue
+			}
+			term := maskTerm(cue.Text[m.from:m.to])
+			findings = append(findings, entities.RedactionFinding{
+				Start: max(cue.Start+(cue.End-cue.Start)*float64(m.from)/length-s.cfg.Redaction.Padding, 0),
+				End:   cue.Start + (cue.End-cue.Start)*float64(m.to)/length + s.cfg.Redaction.Padding,
+				Kind:  m.kind,
+				Term:  term,
+			})
+			text.WriteString(cue.Text[last:m.from])
+			text.WriteString(term)
+			last = m.to
+		}
+		text.WriteString(cue.Text[last:])
+		masked[c].Text = text.String()
+	}
+	slices.SortStableFunc(findings, func(a, b entities.RedactionFinding) int { return cmp.Compare(a.Start, b.Start) })
+	return findings, masked
+}
+
+// isPhoneNumber rules out runs of numbers that aren't one, such as a list
+// of years, by their count of digits.
+func isPhoneNumber(match string) bool {
+	digits := 0
+	years := 0
+	groups := strings.FieldsFunc(match, func(r rune) bool { return r < '0' || r > '9' })
+	for _, group := range groups {
+		digits += len(group)
+		if yearPattern.MatchString(group) {
+			years++
+		}
+	}
+	return digits >= 9 && digits <= 15 && years < len(groups)
+}
+
+// maskTerm keeps a flagged word's first letter and stars the rest, so what
+// is kept of it doesn't say the word or personal details again.
+func maskTerm(term string) string {
+	first, size := utf8.DecodeRuneInString(term)
+	return string(first) + strings.Repeat("*", utf8.RuneCountInString(term[size:]))
+}
+
+// profanityPattern matches the words, in any case, with the endings they
+// take, such as "-s", "-ed" and "-ing".
+func profanityPattern(words []string) *regexp.Regexp {
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		if word = strings.TrimSpace(word); word != "" {
+			quoted = append(quoted, regexp.QuoteMeta(strings.ToLower(word)))
+		}
+	}
+	return regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)(?:s|es|ed|er|ers|ing|in|y)?\b`)
+}
+
+func NewRedactionService(repo repository.RedactionRepository, audio AudioReplacementService, cfg *config.Config) RedactionService {
+	return &redactionService{
+		repo:      repo,
+		audio:     audio,
+		profanity: profanityPattern(append(slices.Clone(profanity), cfg.Redaction.Words...)),
+		cfg:       cfg,
+	}
+}
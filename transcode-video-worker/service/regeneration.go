@@ -0,0 +1,545 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/correlation"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/pkg/reporting"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// RegenerationService makes single artifacts of a published lesson video
+// again, as after a fix to how posters are scored or a rung that came out
+// wrong, rather than transcoding the whole lesson. They're made from the
+// package's highest rung, with the duration and size measured when it was
+// transcoded rather than probed again. Artifacts of the package go into a
+// new version of it with everything else copied, and the lesson switches to
+// that version like it would to a new transcode. With follow-ups on, a
+// transcode leaves its artifacts to regenerations queued once it publishes.
+type RegenerationService interface {
+	// Request queues the regeneration of the lesson's artifacts.
+	Request(ctx context.Context, lessonId uuid.UUID, request dto.RegenerationRequest) (*entities.Regeneration, error)
+	Find(ctx context.Context, id uuid.UUID) (*entities.Regeneration, error)
+	// Process runs a regeneration job.
+	Process(ctx context.Context, message dto.RegenerationMessage) error
+}
+
+type regenerationService struct {
+	repo          repository.RegenerationRepository
+	courses       repository.CourseRepository
+	courseService CourseService
+	presets       PresetService
+	chapters      ChapterService
+	qc            QCService
+	accessibility AccessibilityService
+	jobs          repository.JobRepository
+	events        repository.JobEventRepository
+	versions      VideoVersionService
+	publisher     rabbitmq.Publisher
+	store         objectstore.Store
+	cfg           *config.Config
+}
+
+func (s *regenerationService) Request(ctx context.Context, lessonId uuid.UUID, request dto.RegenerationRequest) (*entities.Regeneration, error) {
+	var artifacts []string
+	for _, name := range request.Artifacts {
+		if !slices.Contains(constant.RegenerationArtifacts, constant.RegenerationArtifact(name)) {
+			return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("unknown artifact %q", name))
+		}
+		if !slices.Contains(artifacts, name) {
+			artifacts = append(artifacts, name)
+		}
+	}
+	rendition := slices.Contains(artifacts, string(constant.RegenerationRendition))
+	if rendition != (len(request.Heights) > 0) {
+		return nil, errors.Join(ErrInvalidArgument, errors.New("heights are given with the rendition artifact, and only with it"))
+	}
+
+	playlist, err := s.repo.FindLessonVideo(ctx, lessonId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !isHLSSource(playlist) {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("lesson %s has no published video", lessonId))
+	}
+	if _, err := s.courses.FindMedia(ctx, lessonId); errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("lesson %s has no measurements of its video, transcode it again instead", lessonId))
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Detected chapters would take the place of the instructor's.
+	if slices.Contains(artifacts, string(constant.RegenerationChapters)) {
+		chapters, err := s.chapters.List(ctx, lessonId)
+		if err != nil {
+			return nil, err
+		}
+		for _, chapter := range chapters {
+			if chapter.Source == "instructor" {
+				return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("lesson %s has instructor chapters", lessonId))
+			}
+		}
+	}
+
+	heights := make(pq.Int64Array, 0, len(request.Heights))
+	if rendition {
+		master, err := readObjectLines(ctx, s.store, s.cfg.MinIOBucket, playlist)
+		if err != nil {
+			return nil, fmt.Errorf("read master playlist: %w", err)
+		}
+		preset, err := masterPreset(ctx, s.presets, master, playlist)
+		if errors.Is(err, ErrNonRetryable) {
+			return nil, errors.Join(ErrInvalidArgument, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, height := range request.Heights {
+			if !slices.ContainsFunc(preset.Renditions, func(r entities.Rendition) bool { return r.Height == height }) {
+				return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("the lesson's package has no %dp rung", height))
+			}
+			if !slices.Contains(heights, int64(height)) {
+				heights = append(heights, int64(height))
+			}
+		}
+	}
+
+	regeneration := &entities.Regeneration{
+		LessonId:  lessonId,
+		Artifacts: artifacts,
+		Heights:   heights,
+	}
+	if err := queueRegeneration(ctx, s.jobs, s.repo, s.publisher, regeneration, request.UserId); err != nil {
+		return nil, err
+	}
+	return regeneration, nil
+}
+
+// queueRegeneration creates the job of the regeneration, saves it and
+// publishes the job's message.
+func queueRegeneration(ctx context.Context, jobs repository.JobRepository, repo repository.RegenerationRepository, publisher rabbitmq.Publisher,
+	regeneration *entities.Regeneration, userId *uuid.UUID) error {
+	job := &entities.Job{
+		ID:         uuid.New(),
+		EntityId:   regeneration.LessonId,
+		EntityType: string(constant.EntityTypeLessonVideo),
+		Status:     constant.JobStatusPending,
+		JobType:    constant.JobTypeRegeneration,
+		UserId:     userId,
+	}
+	if correlationId := correlation.FromContext(ctx); correlationId != "" {
+		job.CorrelationId = &correlationId
+	}
+	regeneration.ID, regeneration.JobId = uuid.New(), job.ID
+
+	if err := jobs.CreateJob(ctx, job); err != nil {
+		return err
+	}
+	if err := repo.CreateRegeneration(ctx, regeneration); err != nil {
+		return err
+	}
+	message := dto.RegenerationMessage{JobId: job.ID}
+	if err := publisher.Publish(ctx, rabbitmq.RegenerationTopology.Exchange, rabbitmq.RegenerationTopology.RoutingKey, message); err != nil {
+		return err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("job_id", job.ID.String()).
+		Str("lesson_id", regeneration.LessonId.String()).
+		Strs("artifacts", regeneration.Artifacts).
+		Msg("regeneration queued")
+	return nil
+}
+
+// followUp queues the artifacts the job's stages left to follow-up jobs.
+// Those of the package go in one regeneration, as each publishes a version
+// of it that the others' would replace, and every other artifact in one of
+// its own, so idle workers make them in parallel. One that can't be queued
+// is left for a regeneration by hand.
+func followUp(ctx context.Context, jobs repository.JobRepository, repo repository.RegenerationRepository, publisher rabbitmq.Publisher,
+	job *entities.Job, artifacts []constant.RegenerationArtifact) {
+	var packaged pq.StringArray
+	var groups []pq.StringArray
+	for _, artifact := range artifacts {
+		switch artifact {
+		case constant.RegenerationPosters, constant.RegenerationPreview, constant.RegenerationSlides:
+			packaged = append(packaged, string(artifact))
+		default:
+			groups = append(groups, pq.StringArray{string(artifact)})
+		}
+	}
+	if len(packaged) > 0 {
+		groups = append([]pq.StringArray{packaged}, groups...)
+	}
+	for _, group := range groups {
+		regeneration := &entities.Regeneration{
+			LessonId:     job.EntityId,
+			Artifacts:    group,
+			Heights:      pq.Int64Array{},
+			FollowsJobId: &job.ID,
+		}
+		if err := queueRegeneration(ctx, jobs, repo, publisher, regeneration, job.UserId); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Strs("artifacts", group).Msg("failed to queue follow-up")
+		}
+	}
+}
+
+func (s *regenerationService) Find(ctx context.Context, id uuid.UUID) (*entities.Regeneration, error) {
+	regeneration, err := s.repo.FindRegeneration(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	return regeneration, err
+}
+
+func (s *regenerationService) Process(ctx context.Context, message dto.RegenerationMessage) (err error) {
+	job, err := s.jobs.FindJobById(ctx, message.JobId)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to find job by id")
+		return err
+	}
+	if job.Status != constant.JobStatusPending {
+		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("job is not pending")
+		return nil
+	}
+	regeneration, err := s.repo.FindRegenerationByJob(ctx, message.JobId)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to find regeneration")
+		return err
+	}
+
+	claimed, err := s.jobs.ClaimJob(ctx, message.JobId, correlation.FromContext(ctx), workerFromContext(ctx))
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to update job status")
+		return err
+	}
+	if !claimed {
+		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("job already claimed by another delivery")
+		return nil
+	}
+	rabbitmq.Claimed(ctx)
+	ctx = withTimeline(ctx, s.events, job.ID)
+	recordStatus(ctx, constant.JobStatusPending, constant.JobStatusProcessing)
+
+	stage := constant.ErrorClassWorkspace
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = panicked(ctx, recovered)
+		}
+		if shuttingDown(ctx, err) {
+			err = handOff(ctx, s.jobs, message.JobId, err)
+			return
+		}
+		err = classify(err)
+		recordOutcome(ctx, job, stage, err)
+		recordOutcomeEvents(ctx, stage, err)
+		if err != nil {
+			if errors.Is(err, ErrNonRetryable) {
+				if updateErr := s.jobs.FailJob(ctx, message.JobId, stage, errorKind(err), err.Error()); updateErr != nil {
+					zerolog.Ctx(ctx).Error().Err(updateErr).Msg("failed to update job status")
+				}
+				reporting.CaptureFailure(ctx, err, reporting.Failure{
+					JobId:   message.JobId.String(),
+					JobType: string(job.JobType),
+					Stage:   string(stage),
+					Output:  failureOutput(err),
+					Extra:   map[string]interface{}{"lesson_id": regeneration.LessonId.String(), "artifacts": []string(regeneration.Artifacts)},
+				})
+				err = nil
+			} else {
+				if updateErr := s.jobs.UpdateStatusJob(ctx, constant.JobStatusPending, message.JobId); updateErr != nil {
+					zerolog.Ctx(ctx).Error().Err(updateErr).Msg("failed to update job status")
+				}
+			}
+		}
+	}()
+
+	tempDir := filepath.Join("temp", message.JobId.String())
+	defer os.RemoveAll(tempDir)
+	sourceDir := filepath.Join(tempDir, "source")
+	packageDir := filepath.Join(tempDir, "package")
+	for _, dir := range []string{sourceDir, packageDir} {
+		if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+			return errors.Join(ErrNonRetryable, err)
+		}
+	}
+
+	stage = constant.ErrorClassDatabase
+	playlist, err := s.repo.FindLessonVideo(ctx, regeneration.LessonId)
+	if err != nil {
+		return err
+	}
+	if !isHLSSource(playlist) {
+		return errors.Join(ErrNonRetryable, fmt.Errorf("lesson %s has no published video", regeneration.LessonId))
+	}
+	media, err := s.courses.FindMedia(ctx, regeneration.LessonId)
+	if err != nil {
+		return err
+	}
+
+	stage = constant.ErrorClassDownload
+	var video, audio string
+	err = traceStage(ctx, "download", func(ctx context.Context) error {
+		var downloadErr error
+		video, audio, downloadErr = downloadHLSSource(ctx, s.store, s.cfg.MinIOBucket, playlist, sourceDir)
+		return downloadErr
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to download published package")
+		return err
+	}
+	lines, err := readLines(video)
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(lines, func(line string) bool {
+		return strings.HasPrefix(line, "#EXT-X-KEY:") && !strings.Contains(line, "METHOD=NONE")
+	}) {
+		return errors.Join(ErrNonRetryable, ErrInvalidArgument, fmt.Errorf("%s is encrypted, transcode the lesson again instead", playlist))
+	}
+
+	// regenerated holds the objects of the package, relative to its master
+	// playlist, that the new version doesn't copy.
+	var regenerated []func(name string) bool
+	hasVideo := media.Width > 0 && media.DurationSeconds > 0
+	for _, artifact := range regeneration.Artifacts {
+		switch constant.RegenerationArtifact(artifact) {
+		case constant.RegenerationPosters:
+			if !hasVideo {
+				return errors.Join(ErrNonRetryable, ErrInvalidArgument, errors.New("an audio lesson has no poster"))
+			}
+			stage = constant.ErrorClassTranscode
+			err = traceStage(ctx, "poster", func(ctx context.Context) error {
+				_, posterErr := pickPosters(ctx, video, packageDir, media.DurationSeconds, s.cfg.Poster)
+				return posterErr
+			})
+			if err != nil {
+				return errors.Join(ErrNonRetryable, err)
+			}
+			regenerated = append(regenerated, func(name string) bool {
+				return name == posterImage || name == postersSidecar || strings.HasPrefix(name, postersDir+"/")
+			})
+		case constant.RegenerationPreview:
+			if !hasVideo {
+				return errors.Join(ErrNonRetryable, ErrInvalidArgument, errors.New("an audio lesson has no preview"))
+			}
+			stage = constant.ErrorClassTranscode
+			err = traceStage(ctx, "preview", func(ctx context.Context) error {
+				_, previewErr := renderPreview(ctx, video, packageDir, media.DurationSeconds, s.cfg.Preview)
+				return previewErr
+			})
+			if err != nil {
+				return errors.Join(ErrNonRetryable, err)
+			}
+			regenerated = append(regenerated, func(name string) bool {
+				return strings.TrimSuffix(name, path.Ext(name)) == "preview"
+			})
+		case constant.RegenerationSlides:
+			stage = constant.ErrorClassTranscode
+			err = traceStage(ctx, "slides", func(ctx context.Context) error {
+				_, slidesErr := extractSlides(ctx, video, packageDir, s.cfg.Slides)
+				return slidesErr
+			})
+			if err != nil {
+				return errors.Join(ErrNonRetryable, err)
+			}
+			regenerated = append(regenerated, func(name string) bool {
+				return name == slidesDeck || name == slidesSidecar || strings.HasPrefix(name, slidesDir+"/")
+			})
+		case constant.RegenerationChapters:
+			stage = constant.ErrorClassTranscode
+			err = traceStage(ctx, "chapters", func(ctx context.Context) error {
+				return s.chapters.Detect(ctx, job, video, audio, media.DurationSeconds)
+			})
+			if err != nil {
+				return err
+			}
+		case constant.RegenerationMusic:
+			stage = constant.ErrorClassTranscode
+			err = traceStage(ctx, "music_check", func(ctx context.Context) error {
+				return s.qc.CheckMusic(ctx, job, video, audio, media.DurationSeconds)
+			})
+			if err != nil {
+				return err
+			}
+		case constant.RegenerationAccessibility:
+			stage = constant.ErrorClassTranscode
+			err = traceStage(ctx, "accessibility", func(ctx context.Context) error {
+				return s.accessibility.Analyze(ctx, job, video, audio, nil, media.DurationSeconds)
+			})
+			if err != nil {
+				return err
+			}
+		case constant.RegenerationRendition:
+			stage = constant.ErrorClassPackage
+			master, readErr := readObjectLines(ctx, s.store, s.cfg.MinIOBucket, playlist)
+			if readErr != nil {
+				return fmt.Errorf("read master playlist: %w", readErr)
+			}
+			preset, presetErr := masterPreset(ctx, s.presets, master, playlist)
+			if presetErr != nil {
+				return presetErr
+			}
+			stage = constant.ErrorClassTranscode
+			for _, height := range regeneration.Heights {
+				index := slices.IndexFunc(preset.Renditions, func(r entities.Rendition) bool { return int64(r.Height) == height })
+				if index < 0 {
+					return errors.Join(ErrNonRetryable, ErrInvalidArgument, fmt.Errorf("the lesson's package has no %dp rung", height))
+				}
+				r := preset.Renditions[index]
+				if err = s.encodeRung(ctx, preset, r, video, audio, packageDir); err != nil {
+					return err
+				}
+				rung := fmt.Sprintf("%dp", r.Height)
+				regenerated = append(regenerated, func(name string) bool {
+					return name == rung+".m3u8" || strings.HasPrefix(name, rung+"_") || (r.Container != "" && name == progressiveName(r))
+				})
+			}
+		}
+	}
+
+	if len(regenerated) > 0 {
+		stage = constant.ErrorClassUpload
+		republished := path.Join(packagePrefix(playlist, job.ID), path.Base(playlist))
+		err = traceStage(ctx, "upload", func(ctx context.Context) error {
+			return s.writeVersion(ctx, path.Dir(playlist), path.Dir(republished), packageDir, regenerated)
+		})
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to upload package with regenerated artifacts")
+			return err
+		}
+
+		stage = constant.ErrorClassDatabase
+		if err = s.versions.Publish(ctx, job, republished); err != nil {
+			return err
+		}
+		if err = s.repo.MarkRegenerated(ctx, regeneration.ID, playlist, republished); err != nil {
+			return err
+		}
+	}
+	stage = constant.ErrorClassDatabase
+	if err = s.jobs.UpdateStatusJob(ctx, constant.JobStatusCompleted, message.JobId); err != nil {
+		return err
+	}
+	// The course waited on the music check while it was to run.
+	if slices.Contains(regeneration.Artifacts, string(constant.RegenerationMusic)) {
+		if courseErr := s.courseService.Check(ctx, regeneration.LessonId); courseErr != nil {
+			zerolog.Ctx(ctx).Warn().Err(courseErr).Msg("failed to check course readiness")
+		}
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("job_id", message.JobId.String()).
+		Str("lesson_id", regeneration.LessonId.String()).
+		Strs("artifacts", regeneration.Artifacts).
+		Msg("lesson artifacts regenerated")
+	return nil
+}
+
+// encodeRung encodes rung r of preset again from video into packageDir, with
+// its progressive copy, if it has one, muxed with the package's audio.
+func (s *regenerationService) encodeRung(ctx context.Context, preset *entities.Preset, r entities.Rendition, video, audio, packageDir string) error {
+	rung := filepath.Join(packageDir, fmt.Sprintf("%dp.m3u8", r.Height))
+	err := traceStage(ctx, "transcode", func(ctx context.Context) error {
+		return runFFmpeg(ctx, rungArgs(preset, r, video, packageDir, s.cfg.Server.FFmpegThreads), nil)
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Int("height", r.Height).Msg("failed to encode rung")
+		return errors.Join(ErrNonRetryable, err)
+	}
+	if r.Container == "" {
+		return nil
+	}
+	if audio == "" {
+		return errors.Join(ErrNonRetryable, fmt.Errorf("the package has no audio rendition to mux %s with", progressiveName(r)))
+	}
+	output := filepath.Join(packageDir, progressiveName(r))
+	if err := runFFmpeg(ctx, progressiveArgs(r, rung, audio, output, s.cfg.Server.FFmpegThreads), nil); err != nil {
+		return errors.Join(ErrNonRetryable, fmt.Errorf("progressive %s: %w", progressiveName(r), err))
+	}
+	return nil
+}
+
+// writeVersion writes the new version under prefix: the objects of the
+// package under from that no regenerated func claims, copied as they are,
+// and the files under packageDir.
+func (s *regenerationService) writeVersion(ctx context.Context, from, prefix, packageDir string, regenerated []func(name string) bool) error {
+	for object := range s.store.ListObjects(ctx, s.cfg.MinIOBucket, minio.ListObjectsOptions{Prefix: from + "/", Recursive: true}) {
+		if object.Err != nil {
+			return fmt.Errorf("list package: %w", object.Err)
+		}
+		name := strings.TrimPrefix(object.Key, from+"/")
+		if slices.ContainsFunc(regenerated, func(claims func(string) bool) bool { return claims(name) }) {
+			continue
+		}
+		_, err := s.store.CopyObject(ctx,
+			minio.CopyDestOptions{Bucket: s.cfg.MinIOBucket, Object: path.Join(prefix, name)},
+			minio.CopySrcOptions{Bucket: s.cfg.MinIOBucket, Object: object.Key})
+		if err != nil {
+			return fmt.Errorf("copy %s: %w", object.Key, err)
+		}
+	}
+	_, err := uploadDirectory(ctx, s.store, s.cfg.MinIOBucket, packageDir, prefix)
+	return err
+}
+
+// rungArgs encode the video of input as rung r of preset into outputDir, as
+// the transcode encoded it, without audio.
+func rungArgs(preset *entities.Preset, r entities.Rendition, input, outputDir string, threads int) []string {
+	args := append(presetArgs(preset), "-i", input,
+		"-map", "0:v:0",
+		"-vf", scaleFilter(preset, r),
+		"-c:v", preset.VideoCodec,
+		"-preset", preset.EncoderPreset,
+	)
+	args = append(args, videoRateArgs(preset, r)...)
+	if threads > 0 {
+		args = append(args, "-threads", strconv.Itoa(threads))
+	}
+	args = append(args, keyframeArgs(preset)...)
+	args = append(args, "-an")
+	args = append(args, hlsOutputArgs(preset)...)
+	args = append(args, rungSegmentArgs(r, outputDir)...)
+	return append(args, "-y", filepath.Join(outputDir, fmt.Sprintf("%dp.m3u8", r.Height)))
+}
+
+func NewRegenerationService(repo repository.RegenerationRepository, courses repository.CourseRepository, courseService CourseService, presets PresetService, chapters ChapterService, qc QCService, accessibility AccessibilityService,
+	jobs repository.JobRepository, events repository.JobEventRepository, versions VideoVersionService, publisher rabbitmq.Publisher, store objectstore.Store, cfg *config.Config) RegenerationService {
+	return &regenerationService{
+		repo:          repo,
+		courses:       courses,
+		courseService: courseService,
+		presets:       presets,
+		chapters:      chapters,
+		qc:            qc,
+		accessibility: accessibility,
+		jobs:          jobs,
+		events:        events,
+		versions:      versions,
+		publisher:     publisher,
+		store:         store,
+		cfg:           cfg,
+	}
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/remote"
+
+	"github.com/rs/zerolog"
+)
+
+// remoteSourceTTL is how long the provider's link to the source stays valid.
+// It only fetches it once, at the start of the encode.
+const remoteSourceTTL = 6 * time.Hour
+
+// remoteReason says why a job should go to the external transcoder, or is
+// empty when this worker should encode it. capable is whether one of the
+// worker's media engines can encode the job; queued is how long the job
+// waited before it was picked up, and a long wait means the workers are
+// saturated.
+func remoteReason(cfg *config.Config, capable bool, queued time.Duration) string {
+	if cfg.Remote.Provider == "" {
+		return ""
+	}
+	if !capable {
+		return "codec"
+	}
+	if limit := cfg.Remote.MaxQueueSeconds; limit > 0 && queued > time.Duration(limit)*time.Second {
+		return "queue"
+	}
+	return ""
+}
+
+// transcodeRemote has the external transcoder encode the source at
+// objectPath, then packages what it made into HLS under outputDir, the way
+// transcodeToHLS lays it out. The provider's files are downloaded into
+// workDir, away from the package. Rungs the provider can't make are left
+// out, so it returns preset narrowed to the rungs packaged, as H.264 and AAC.
+func transcodeRemote(ctx context.Context, store objectstore.Store, cfg *config.Config, objectPath string, preset *entities.Preset, outputDir, workDir string) (*entities.Preset, error) {
+	transcoder, err := remote.New(cfg.Remote)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(workDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	source, err := store.PresignedGetObject(ctx, cfg.MinIOBucket, objectPath, remoteSourceTTL, nil)
+	if err != nil {
+		return nil, err
+	}
+	heights := make([]int, 0, len(preset.Renditions))
+	for _, r := range preset.Renditions {
+		heights = append(heights, r.Height)
+	}
+
+	zerolog.Ctx(ctx).Info().Str("provider", transcoder.Name()).Ints("heights", heights).Msg("transcoding with external provider")
+	result, err := transcoder.Transcode(ctx, source.String(), heights, workDir)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", transcoder.Name(), err)
+	}
+
+	made := *preset
+	made.VideoCodec, made.AudioCodec = "libx264", "aac"
+	made.Renditions = nil
+	for _, r := range preset.Renditions {
+		rendition, ok := result.Renditions[r.Height]
+		if !ok {
+			continue
+		}
+		args := append([]string{"-i", rendition, "-map", "0:v:0", "-c:v", "copy"}, hlsOutputArgs(preset)...)
+		args = append(args, rungSegmentArgs(r, outputDir)...)
+		err := runFFmpeg(ctx, append(args, filepath.Join(outputDir, fmt.Sprintf("%dp.m3u8", r.Height))), nil)
+		if err != nil {
+			return nil, err
+		}
+		made.Renditions = append(made.Renditions, r)
+	}
+	if len(made.Renditions) == 0 {
+		return nil, fmt.Errorf("%s made none of the preset's rungs", transcoder.Name())
+	}
+
+	args := append([]string{
+		"-i", result.Audio,
+		"-map", "0:a:0", "-c:a", "aac",
+		"-b:a", made.Renditions[len(made.Renditions)-1].AudioRate,
+	}, hlsOutputArgs(preset)...)
+	err = runFFmpeg(ctx, append(args,
+		"-hls_segment_filename", filepath.Join(outputDir, "audio_%03d.ts"),
+		filepath.Join(outputDir, "audio.m3u8"),
+	), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &made, nil
+}
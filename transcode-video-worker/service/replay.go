@@ -0,0 +1,333 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/ffmpeg"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/version"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// replayPrefix keeps replayed packages apart from lesson data, one prefix
+// per replay under the job's.
+const replayPrefix = "replays/"
+
+// snapshot records what the job runs with: its message, preset version, the
+// objects it read and the ffmpeg build and settings. It's taken once the
+// source is downloaded, before anything is done to it, and goes in the
+// job's artifacts too.
+func (s service) snapshot(ctx context.Context, message dto.JobMessage, preset *entities.Preset) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	keys := []string{message.ObjectPath}
+	for _, track := range message.AudioTracks {
+		keys = append(keys, track.ObjectPath)
+	}
+	objects := make(entities.SnapshotObjects, 0, len(keys))
+	for _, key := range keys {
+		info, err := s.store.StatObject(ctx, s.cfg.MinIOBucket, key, minio.StatObjectOptions{})
+		if err != nil {
+			return fmt.Errorf("stat %s: %w", key, err)
+		}
+		objects = append(objects, entities.SnapshotObject{Key: key, ETag: info.ETag, VersionId: info.VersionID, Size: info.Size})
+	}
+
+	build := version.Get()
+	snapshot := &entities.JobSnapshot{
+		JobId:         message.JobId,
+		Message:       payload,
+		Preset:        preset.Name,
+		PresetVersion: preset.Version,
+		Objects:       objects,
+		FFmpeg:        build.FFmpeg,
+		FFmpegArgs:    ffmpeg.GlobalArgs(),
+		Threads:       s.cfg.Server.FFmpegThreads,
+		Worker:        build.Commit,
+	}
+	if err := s.snapshots.SaveSnapshot(ctx, snapshot); err != nil {
+		return err
+	}
+	addJSONArtifact(ctx, "snapshot.json", snapshot)
+	return nil
+}
+
+// ReplayService runs a transcode job again from its snapshot, to reproduce
+// what it made on another machine or after the worker has changed.
+type ReplayService interface {
+	// Replay downloads the job's source and audio tracks at the versions it
+	// read them, applies its edit and the dead air trim its events record,
+	// and encodes and packages them with the same preset version, ffmpeg
+	// threads and cue points. The package is uploaded under
+	// replays/<job-id>/<time of the replay>/; the job, its lesson and its
+	// published package are left untouched. Branding, webcam compositing,
+	// chapters and the registered stages aren't replayed.
+	Replay(ctx context.Context, jobId uuid.UUID, request dto.ReplayRequest) (*dto.ReplayResult, error)
+}
+
+type replayService struct {
+	snapshots repository.JobSnapshotRepository
+	events    repository.JobEventRepository
+	presets   repository.PresetRepository
+	store     objectstore.Store
+	cfg       *config.Config
+}
+
+func (s *replayService) Replay(ctx context.Context, jobId uuid.UUID, request dto.ReplayRequest) (*dto.ReplayResult, error) {
+	snapshot, err := s.snapshots.FindSnapshot(ctx, jobId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, fmt.Errorf("job %s has no snapshot", jobId))
+	}
+	if err != nil {
+		return nil, err
+	}
+	var message dto.JobMessage
+	if err := json.Unmarshal(snapshot.Message, &message); err != nil {
+		return nil, fmt.Errorf("decode snapshot message: %w", err)
+	}
+	preset, err := s.presets.FindPresetVersion(ctx, snapshot.Preset, snapshot.PresetVersion)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, fmt.Errorf("preset %s version %d no longer exists", snapshot.Preset, snapshot.PresetVersion))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	started := time.Now()
+	result := &dto.ReplayResult{
+		JobId:         jobId,
+		Prefix:        path.Join(replayPrefix, jobId.String(), started.UTC().Format("20060102T150405Z")),
+		Preset:        preset.Name,
+		PresetVersion: preset.Version,
+		Drift:         []string{},
+		Skipped:       []string{},
+	}
+	build := version.Get()
+	if build.FFmpeg != snapshot.FFmpeg {
+		result.Drift = append(result.Drift, fmt.Sprintf("ffmpeg is %q, the job ran %q", build.FFmpeg, snapshot.FFmpeg))
+	}
+	if args := ffmpeg.GlobalArgs(); !slices.Equal(args, snapshot.FFmpegArgs) {
+		result.Drift = append(result.Drift, fmt.Sprintf("ffmpeg global args are %q, the job ran with %q", args, []string(snapshot.FFmpegArgs)))
+	}
+	if build.Commit != snapshot.Worker {
+		result.Drift = append(result.Drift, fmt.Sprintf("worker is %s, the job ran on %s", build.Commit, snapshot.Worker))
+	}
+	if message.Webcam != nil {
+		result.Skipped = append(result.Skipped, "webcam")
+	}
+	if len(message.Chapters) > 0 {
+		result.Skipped = append(result.Skipped, "chapters")
+	}
+
+	tempDir := filepath.Join("temp", "replay", jobId.String())
+	defer os.RemoveAll(tempDir)
+	inputDir := filepath.Join(tempDir, "input")
+	outputDir := filepath.Join(tempDir, "output")
+	if request.LocalDir != "" {
+		outputDir = request.LocalDir
+	}
+	for _, dir := range []string{inputDir, outputDir} {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+
+	inputFilepath, audioFilepath, dubs, err := s.download(ctx, snapshot, message, inputDir, request, result)
+	if err != nil {
+		return nil, err
+	}
+	source, err := CheckSource(ctx, inputFilepath)
+	if err != nil {
+		return nil, fmt.Errorf("inspect source: %w", err)
+	}
+	if err := source.Err(); err != nil {
+		return nil, err
+	}
+	duration := source.DurationSeconds
+
+	if message.Edit != nil && !isHLSSource(message.ObjectPath) {
+		kept, err := editRanges(*message.Edit, duration)
+		if err != nil {
+			return nil, errors.Join(ErrInvalidArgument, err)
+		}
+		if inputFilepath, _, err = editFile(ctx, inputFilepath, inputDir, kept, snapshot.Threads); err != nil {
+			return nil, fmt.Errorf("apply edit: %w", err)
+		}
+		for i := range dubs {
+			if dubs[i].path, _, err = editFile(ctx, dubs[i].path, inputDir, kept, snapshot.Threads); err != nil {
+				return nil, fmt.Errorf("apply edit: %w", err)
+			}
+		}
+		message.CuePoints = editCuePoints(message.CuePoints, kept)
+		duration = keptDuration(kept)
+	} else if window, ok, err := s.trimmed(ctx, jobId); err != nil {
+		return nil, err
+	} else if ok {
+		if inputFilepath, err = trimFile(ctx, inputFilepath, inputDir, window); err != nil {
+			return nil, fmt.Errorf("trim dead air: %w", err)
+		}
+		for i := range dubs {
+			if dubs[i].path, err = trimFile(ctx, dubs[i].path, inputDir, window); err != nil {
+				return nil, fmt.Errorf("trim dead air: %w", err)
+			}
+		}
+		message.CuePoints = shiftCuePoints(message.CuePoints, window)
+		duration = window.end - window.start
+	}
+	if capped, skipped := capLadder(preset, source.Media); len(skipped) > 0 {
+		preset = capped
+	}
+
+	encode := EncodeRequest{
+		Preset:        preset,
+		InputFilepath: inputFilepath,
+		AudioFilepath: audioFilepath,
+		Dubs:          dubs,
+		OutputDir:     outputDir,
+		Threads:       snapshot.Threads,
+		OnProgress:    progressReporter(ctx, nil, uuid.Nil, duration),
+	}
+	if message.LowLatency {
+		encode.PartSeconds = s.cfg.Live.PartSeconds
+	}
+	engine, _, err := negotiateEngine(ctx, s.cfg.Server.MediaEngines, encode)
+	if err != nil {
+		return nil, err
+	}
+	if capabilities, _ := engine.Capabilities(ctx); s.cfg.Server.StreamCopy && capabilities.StreamCopy {
+		if encode.CopyHeight, err = copyableRung(ctx, preset, inputFilepath, source.Media); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to check source keyframes, encoding every rung")
+		}
+	}
+	if err := engine.Encode(ctx, encode); err != nil {
+		return nil, fmt.Errorf("transcode: %w", err)
+	}
+	if err := engine.Package(ctx, preset, outputDir, dubs); err != nil {
+		return nil, fmt.Errorf("package: %w", err)
+	}
+	if err := writeProgressive(ctx, preset, outputDir, snapshot.Threads); err != nil {
+		return nil, fmt.Errorf("package: %w", err)
+	}
+	if err := embedCuePoints(outputDir, message.CuePoints, duration); err != nil {
+		return nil, fmt.Errorf("package: %w", err)
+	}
+
+	if result.Bytes, err = uploadDirectory(ctx, s.store, s.cfg.MinIOBucket, outputDir, result.Prefix); err != nil {
+		return nil, fmt.Errorf("upload replay: %w", err)
+	}
+	result.Seconds = time.Since(started).Seconds()
+	zerolog.Ctx(ctx).Info().
+		Str("job_id", jobId.String()).
+		Str("prefix", result.Prefix).
+		Int("drift", len(result.Drift)).
+		Msg("job replayed")
+	return result, nil
+}
+
+// download fetches the snapshot's objects into dir at the versions the job
+// read them. An object changed since, in a bucket that doesn't keep its
+// versions, is only replayed as it is now with request.AllowDrift.
+func (s *replayService) download(ctx context.Context, snapshot *entities.JobSnapshot, message dto.JobMessage, dir string, request dto.ReplayRequest, result *dto.ReplayResult) (string, string, []dubbedAudio, error) {
+	if len(snapshot.Objects) != 1+len(message.AudioTracks) {
+		return "", "", nil, fmt.Errorf("snapshot lists %d objects for a source and %d audio tracks", len(snapshot.Objects), len(message.AudioTracks))
+	}
+	locals := make([]string, len(snapshot.Objects))
+	for i, object := range snapshot.Objects {
+		info, err := s.store.StatObject(ctx, s.cfg.MinIOBucket, object.Key, minio.StatObjectOptions{VersionID: object.VersionId})
+		if err != nil {
+			return "", "", nil, fmt.Errorf("stat %s: %w", object.Key, err)
+		}
+		if info.ETag != object.ETag {
+			drift := fmt.Sprintf("%s has changed since the job read it: etag %s, was %s", object.Key, info.ETag, object.ETag)
+			if !request.AllowDrift {
+				return "", "", nil, errors.Join(ErrInvalidArgument, errors.New(drift))
+			}
+			result.Drift = append(result.Drift, drift)
+		}
+
+		if i == 0 && isHLSSource(object.Key) {
+			// A playlist source is a package of this service's, whose
+			// segments are never rewritten.
+			video, audio, err := downloadHLSSource(ctx, s.store, s.cfg.MinIOBucket, object.Key, dir)
+			if err != nil {
+				return "", "", nil, err
+			}
+			locals[i] = video
+			if audio != "" {
+				locals = append(locals, audio)
+			}
+			continue
+		}
+		name := filepath.Base(object.Key)
+		if i > 0 {
+			name = fmt.Sprintf("dub_%d_%s", i-1, name)
+		}
+		locals[i] = filepath.Join(dir, name)
+		if err := s.store.FGetObject(ctx, s.cfg.MinIOBucket, object.Key, locals[i], minio.GetObjectOptions{VersionID: object.VersionId}); err != nil {
+			return "", "", nil, fmt.Errorf("download %s: %w", object.Key, err)
+		}
+	}
+
+	dubs := make([]dubbedAudio, 0, len(message.AudioTracks))
+	for i, track := range message.AudioTracks {
+		dubs = append(dubs, dubbedTrack(track, locals[1+i]))
+	}
+	var audio string
+	if len(locals) > len(snapshot.Objects) {
+		audio = locals[len(snapshot.Objects)]
+	}
+	return locals[0], audio, dubs, nil
+}
+
+// trimmed returns the dead air trim the job's last run recorded, if it
+// trimmed any.
+func (s *replayService) trimmed(ctx context.Context, jobId uuid.UUID) (trimWindow, bool, error) {
+	events, err := s.events.ListJobEvents(ctx, jobId)
+	if err != nil {
+		return trimWindow{}, false, err
+	}
+	for _, event := range slices.Backward(events) {
+		if event.EventType == constant.JobEventStatus && event.Data["to"] == string(constant.JobStatusProcessing) {
+			break
+		}
+		if event.EventType != constant.JobEventTrim || event.Stage == nil || *event.Stage != "trim" {
+			continue
+		}
+		start, startOk := event.Data["start"].(float64)
+		end, endOk := event.Data["end"].(float64)
+		if !startOk || !endOk {
+			return trimWindow{}, false, fmt.Errorf("trim event of job %s has no window", jobId)
+		}
+		return trimWindow{start: start, end: end}, true, nil
+	}
+	return trimWindow{}, false, nil
+}
+
+func NewReplayService(snapshots repository.JobSnapshotRepository, events repository.JobEventRepository, presets repository.PresetRepository,
+	store objectstore.Store, cfg *config.Config) ReplayService {
+	return &replayService{
+		snapshots: snapshots,
+		events:    events,
+		presets:   presets,
+		store:     store,
+		cfg:       cfg,
+	}
+}
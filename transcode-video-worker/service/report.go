@@ -0,0 +1,265 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/pkg/mailer"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/repository"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+)
+
+const topErrorClasses = 5
+
+// encodeStages are the stages whose time counts as encoding.
+var encodeStages = []string{"transcode", "merge"}
+
+type ReportService interface {
+	Daily(ctx context.Context, day time.Time) (*dto.DailyReport, error)
+	// Stats summarises the jobs that finished in the last since.
+	Stats(ctx context.Context, since time.Duration) (*dto.JobStats, error)
+	Publish(ctx context.Context, report *dto.DailyReport) error
+	// Published reports whether the report for day was already written, so
+	// replicas don't send duplicates.
+	Published(ctx context.Context, day time.Time) (bool, error)
+}
+
+type reportService struct {
+	repo   repository.ReportRepository
+	mailer mailer.Mailer
+	store  objectstore.Store
+	cfg    *config.Config
+}
+
+// Daily builds the summary of the UTC day containing day.
+func (s *reportService) Daily(ctx context.Context, day time.Time) (*dto.DailyReport, error) {
+	from := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 1)
+
+	report := &dto.DailyReport{
+		Date:        from.Format(time.DateOnly),
+		From:        from,
+		To:          to,
+		GeneratedAt: time.Now().UTC(),
+	}
+
+	var err error
+	if report.Jobs, err = s.repo.CountJobsByStatus(ctx, from, to); err != nil {
+		return nil, fmt.Errorf("count jobs: %w", err)
+	}
+	for _, count := range report.Jobs {
+		switch constant.JobStatus(count.Status) {
+		case constant.JobStatusCompleted:
+			report.Processed += count.Count
+		case constant.JobStatusFailed:
+			report.Failed += count.Count
+		}
+	}
+	if finished := report.Processed + report.Failed; finished > 0 {
+		report.FailureRate = float64(report.Failed) / float64(finished)
+	}
+
+	if report.ErrorClasses, err = s.repo.TopErrorClasses(ctx, from, to, topErrorClasses); err != nil {
+		return nil, fmt.Errorf("top error classes: %w", err)
+	}
+
+	for _, stage := range encodeStages {
+		seconds, err := s.repo.StageSeconds(ctx, from, to, stage)
+		if err != nil {
+			return nil, fmt.Errorf("stage seconds: %w", err)
+		}
+		report.EncodeHours += seconds / 3600
+	}
+
+	if report.Storage, err = s.repo.OutputBytesByTenant(ctx, from, to); err != nil {
+		return nil, fmt.Errorf("storage by tenant: %w", err)
+	}
+
+	return report, nil
+}
+
+func (s *reportService) Stats(ctx context.Context, since time.Duration) (*dto.JobStats, error) {
+	to := time.Now().UTC()
+	from := to.Add(-since)
+
+	stats := &dto.JobStats{
+		Since: since.String(),
+		From:  from,
+		To:    to,
+	}
+
+	var err error
+	if stats.Jobs, err = s.repo.CountJobsByStatus(ctx, from, to); err != nil {
+		return nil, fmt.Errorf("count jobs: %w", err)
+	}
+	for _, count := range stats.Jobs {
+		switch constant.JobStatus(count.Status) {
+		case constant.JobStatusCompleted:
+			stats.Completed += count.Count
+		case constant.JobStatusFailed:
+			stats.Failed += count.Count
+		}
+	}
+	if finished := stats.Completed + stats.Failed; finished > 0 {
+		stats.FailureRate = float64(stats.Failed) / float64(finished)
+	}
+	if hours := since.Hours(); hours > 0 {
+		stats.ThroughputPerHour = float64(stats.Completed) / hours
+	}
+
+	if stats.Turnaround, err = s.repo.TurnaroundPercentiles(ctx, from, to); err != nil {
+		return nil, fmt.Errorf("turnaround percentiles: %w", err)
+	}
+	if stats.Processing, err = s.repo.ProcessingPercentiles(ctx, from, to); err != nil {
+		return nil, fmt.Errorf("processing percentiles: %w", err)
+	}
+	if stats.ErrorClasses, err = s.repo.TopErrorClasses(ctx, from, to, math.MaxInt32); err != nil {
+		return nil, fmt.Errorf("error classes: %w", err)
+	}
+	if stats.StageFailures, err = s.repo.FailuresByStage(ctx, from, to); err != nil {
+		return nil, fmt.Errorf("stage failures: %w", err)
+	}
+
+	return stats, nil
+}
+
+// Publish writes the report to the bucket and emails it to the configured
+// recipients.
+func (s *reportService) Publish(ctx context.Context, report *dto.DailyReport) error {
+	raw, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.store.PutObject(ctx, s.cfg.MinIOBucket, dailyReportKey(report.From), bytes.NewReader(raw), int64(len(raw)), minio.PutObjectOptions{
+		ContentType: "application/json",
+	})
+	if err != nil {
+		return fmt.Errorf("write report: %w", err)
+	}
+
+	if len(s.cfg.Report.Recipients) > 0 {
+		err = s.mailer.Send(ctx, mailer.Message{
+			To:      s.cfg.Report.Recipients,
+			Subject: fmt.Sprintf("Transcode summary for %s", report.Date),
+			Text:    renderDailyReport(report),
+		})
+		if err != nil {
+			return fmt.Errorf("email report: %w", err)
+		}
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("date", report.Date).
+		Int64("processed", report.Processed).
+		Int64("failed", report.Failed).
+		Float64("encode_hours", report.EncodeHours).
+		Msg("daily report published")
+	return nil
+}
+
+func (s *reportService) Published(ctx context.Context, day time.Time) (bool, error) {
+	_, err := s.store.StatObject(ctx, s.cfg.MinIOBucket, dailyReportKey(day), minio.StatObjectOptions{})
+	if err == nil {
+		return true, nil
+	}
+	var response minio.ErrorResponse
+	if errors.As(err, &response) && response.Code == "NoSuchKey" {
+		return false, nil
+	}
+	return false, err
+}
+
+func dailyReportKey(day time.Time) string {
+	return fmt.Sprintf("reports/daily/%s.json", day.UTC().Format(time.DateOnly))
+}
+
+func renderDailyReport(report *dto.DailyReport) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Processing summary for %s (UTC)\n\n", report.Date)
+	fmt.Fprintf(&b, "Completed:    %d\n", report.Processed)
+	fmt.Fprintf(&b, "Failed:       %d\n", report.Failed)
+	fmt.Fprintf(&b, "Failure rate: %.1f%%\n", report.FailureRate*100)
+	fmt.Fprintf(&b, "Encode hours: %.2f\n", report.EncodeHours)
+
+	if len(report.ErrorClasses) > 0 {
+		b.WriteString("\nTop error classes:\n")
+		for _, class := range report.ErrorClasses {
+			fmt.Fprintf(&b, "  - %s: %d\n", class.ErrorClass, class.Count)
+		}
+	}
+
+	if len(report.Storage) > 0 {
+		b.WriteString("\nStorage produced per tenant:\n")
+		for _, tenant := range report.Storage {
+			name := "(no tenant)"
+			if tenant.TenantId != nil {
+				name = tenant.TenantId.String()
+			}
+			fmt.Fprintf(&b, "  - %s: %.2f GiB across %d jobs\n", name, float64(tenant.Bytes)/(1<<30), tenant.Jobs)
+		}
+	}
+	return b.String()
+}
+
+// RunDailyReports publishes the previous day's report every day at the
+// configured UTC hour until ctx is done.
+func RunDailyReports(ctx context.Context, reports ReportService, hour int) {
+	for {
+		now := time.Now().UTC()
+		next := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, time.UTC)
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		day := next.AddDate(0, 0, -1)
+		if err := publishDailyReport(ctx, reports, day); err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Str("date", day.Format(time.DateOnly)).Msg("failed to publish daily report")
+		}
+	}
+}
+
+func publishDailyReport(ctx context.Context, reports ReportService, day time.Time) error {
+	published, err := reports.Published(ctx, day)
+	if err != nil {
+		return err
+	}
+	if published {
+		zerolog.Ctx(ctx).Info().Str("date", day.Format(time.DateOnly)).Msg("daily report already published")
+		return nil
+	}
+
+	report, err := reports.Daily(ctx, day)
+	if err != nil {
+		return err
+	}
+	return reports.Publish(ctx, report)
+}
+
+func NewReportService(repo repository.ReportRepository, mailer mailer.Mailer, store objectstore.Store, cfg *config.Config) ReportService {
+	return &reportService{
+		repo:   repo,
+		mailer: mailer,
+		store:  store,
+		cfg:    cfg,
+	}
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/rabbitmq"
+)
+
+// ErrUnmetRequirements is returned for a job needing what this worker lacks,
+// such as a GPU or an AV1 encoder. It's returned before the job is claimed
+// and its message is requeued after PreflightDelay, for a worker that has
+// it, rather than failing the job halfway on the wrong machine.
+var ErrUnmetRequirements = errors.New("worker can't meet the job's requirements")
+
+// jobRequirements are the job's requirements: its row's, or else its
+// message's.
+func jobRequirements(job *entities.Job, message dto.JobMessage) *entities.JobRequirements {
+	if job.Requirements != nil {
+		return job.Requirements
+	}
+	return message.Requirements
+}
+
+// checkRequirements returns the error requeueing a job whose requirements
+// this worker doesn't meet. Scratch space is measured as it's free now, of
+// what the jobs running haven't reserved; the rest is what the worker
+// advertised when it registered. A worker that didn't register runs any
+// job, as it did before requirements.
+func checkRequirements(ctx context.Context, cfg *config.Config, requirements *entities.JobRequirements) error {
+	if requirements == nil {
+		return nil
+	}
+	capabilities, ok := capabilitiesFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	wanted := *requirements
+	root, err := scratchDir(ctx, cfg, "")
+	free, measured := scratch.available(root)
+	if err != nil || !measured {
+		wanted.ScratchGB = 0
+	}
+	capabilities.ScratchBytes = free
+
+	unmet := wanted.Unmet(capabilities)
+	if len(unmet) == 0 {
+		return nil
+	}
+	err = fmt.Errorf("worker lacks %s", strings.Join(unmet, ", "))
+	return rabbitmq.Requeue(errors.Join(ErrUnmetRequirements, err), time.Duration(cfg.Server.PreflightDelay)*time.Second)
+}
+
+// requiredScratch is the scratch space a job reserves: the estimate, or
+// what it declared it needs when that's more.
+func requiredScratch(requirements *entities.JobRequirements, estimate uint64) uint64 {
+	if requirements == nil {
+		return estimate
+	}
+	return max(estimate, uint64(requirements.ScratchGB)<<30)
+}
@@ -0,0 +1,230 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	resultSchemaVersion = 1
+	resultRoutingKey    = "transcode.result.v1"
+)
+
+// ResultService describes completed transcodes to whatever builds on a
+// lesson's video. Results go out through the outbox, written with the job's
+// completion, so none is lost to a broker outage.
+type ResultService interface {
+	// Result is the outbox message carrying the job's result, nil with
+	// results off. It reads the package back from storage, so it describes a
+	// package copied from an identical job's as well as an encoded one. event
+	// is the job's analytics event, as filled in by the pipeline.
+	Result(ctx context.Context, job *entities.Job, preset *entities.Preset, event dto.MediaEvent, packagePath string, seconds float64, qc dto.ResultQC, reused bool) (*entities.OutboxMessage, error)
+}
+
+type resultService struct {
+	captions repository.TranscriptRepository
+	store    objectstore.Store
+	cfg      *config.Config
+}
+
+func (s *resultService) Result(ctx context.Context, job *entities.Job, preset *entities.Preset, event dto.MediaEvent, packagePath string, seconds float64, qc dto.ResultQC, reused bool) (*entities.OutboxMessage, error) {
+	if !s.cfg.Results.Enabled {
+		return nil, nil
+	}
+	result := dto.TranscodeResult{
+		EventId:        job.ID,
+		SchemaVersion:  resultSchemaVersion,
+		OccurredAt:     time.Now().UTC(),
+		JobId:          job.ID,
+		JobType:        string(job.JobType),
+		LessonId:       job.EntityId,
+		TenantId:       job.TenantId,
+		CorrelationId:  event.CorrelationId,
+		Preset:         preset.Name,
+		PresetVersion:  preset.Version,
+		PlaylistKey:    path.Join(packagePath, "master.m3u8"),
+		PackageKey:     packagePath,
+		Seconds:        seconds,
+		Reused:         reused,
+		Renditions:     []dto.ResultRendition{},
+		AudioTracks:    []dto.ResultAudioTrack{},
+		Captions:       []dto.ResultCaption{},
+		Thumbnails:     []dto.ResultThumbnail{},
+		QC:             qc,
+		Timings:        stageTimingsFromContext(ctx),
+		RemoteProvider: event.RemoteProvider,
+		MediaEngine:    event.MediaEngine,
+	}
+	result.Timings.EncodeSeconds = event.EncodeSeconds
+
+	// Objects are summed by the playlist they belong to.
+	playlists := map[string]int64{}
+	segments := map[string]int{}
+	prefix := strings.TrimSuffix(packagePath, "/") + "/"
+	for object := range s.store.ListObjects(ctx, s.cfg.MinIOBucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		result.PackageBytes += object.Size
+		name := strings.TrimPrefix(object.Key, prefix)
+		switch {
+		case strings.HasSuffix(name, ".m3u8"):
+			playlists[strings.TrimSuffix(name, ".m3u8")] += object.Size
+		case segmentPattern.MatchString(name):
+			stem := segmentPattern.FindStringSubmatch(name)[1]
+			playlists[stem] += object.Size
+			segments[stem]++
+		case strings.HasPrefix(name, "preview."):
+			result.Thumbnails = append(result.Thumbnails, dto.ResultThumbnail{Kind: "preview", Key: object.Key, SizeBytes: object.Size})
+		case path.Dir(name) == slidesDir:
+			result.Thumbnails = append(result.Thumbnails, dto.ResultThumbnail{Kind: "slide", Key: object.Key, SizeBytes: object.Size})
+		case name == posterImage:
+			result.Thumbnails = append(result.Thumbnails, dto.ResultThumbnail{Kind: "poster", Key: object.Key, SizeBytes: object.Size})
+		case path.Dir(name) == postersDir && strings.HasPrefix(path.Base(name), "poster_"):
+			result.Thumbnails = append(result.Thumbnails, dto.ResultThumbnail{Kind: "poster", Key: object.Key, SizeBytes: object.Size})
+		case path.Dir(name) == postersDir:
+			result.Thumbnails = append(result.Thumbnails, dto.ResultThumbnail{Kind: "poster_candidate", Key: object.Key, SizeBytes: object.Size})
+		}
+	}
+
+	for _, rendition := range preset.Renditions {
+		stem := fmt.Sprintf("%dp", rendition.Height)
+		size := playlists[stem]
+		var bitrate int64
+		if seconds > 0 {
+			bitrate = int64(float64(size*8) / seconds)
+		}
+		result.Renditions = append(result.Renditions, dto.ResultRendition{
+			PlaylistKey:    path.Join(packagePath, stem+".m3u8"),
+			Width:          rendition.Width,
+			Height:         rendition.Height,
+			Bitrate:        rendition.Bitrate,
+			AverageBitrate: bitrate,
+			VideoCodec:     preset.VideoCodec,
+			AudioCodec:     preset.AudioCodec,
+			AudioBitrate:   rendition.AudioRate,
+			Segments:       segments[stem],
+			SizeBytes:      size,
+		})
+	}
+	audio := make([]string, 0, len(playlists))
+	for stem := range playlists {
+		if stem == "audio" || strings.HasPrefix(stem, "audio_") {
+			audio = append(audio, stem)
+		}
+	}
+	slices.Sort(audio)
+	for _, stem := range audio {
+		result.AudioTracks = append(result.AudioTracks, dto.ResultAudioTrack{PlaylistKey: path.Join(packagePath, stem+".m3u8"), SizeBytes: playlists[stem]})
+	}
+
+	captions, err := s.captions.ListCaptions(ctx, job.EntityId)
+	if err != nil {
+		return nil, err
+	}
+	for _, caption := range captions {
+		result.Captions = append(result.Captions, dto.ResultCaption{
+			Language:          caption.Language,
+			Key:               caption.ObjectKey,
+			CueCount:          caption.CueCount,
+			CoveredSeconds:    caption.CoveredSeconds,
+			MachineTranslated: caption.MachineTranslated,
+		})
+	}
+
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	return &entities.OutboxMessage{
+		ID:         uuid.New(),
+		Exchange:   s.cfg.Results.Exchange,
+		RoutingKey: resultRoutingKey,
+		Payload:    payload,
+	}, nil
+}
+
+// resultQC collects what the checks of a job found for its result.
+func resultQC(source *SourceCheck, event dto.MediaEvent, verified *VerifyReport) dto.ResultQC {
+	qc := dto.ResultQC{
+		SourceWarnings:    source.Warnings,
+		SkippedRenditions: event.SkippedRenditions,
+		TrimmedSeconds:    event.TrimmedSeconds,
+		Problems:          []string{},
+	}
+	if qc.SkippedRenditions == nil {
+		qc.SkippedRenditions = entities.Renditions{}
+	}
+	if verified != nil {
+		qc.Verified = true
+		qc.Problems = append(qc.Problems, verified.Problems...)
+		for _, playlist := range verified.Playlists {
+			for _, problem := range playlist.Problems {
+				qc.Problems = append(qc.Problems, playlist.URI+": "+problem)
+			}
+		}
+	}
+	return qc
+}
+
+type stageTimingsKey struct{}
+
+// stageTimings adds up how long each stage of a job takes, for its result.
+type stageTimings struct {
+	mu      sync.Mutex
+	started time.Time
+	stages  map[string]float64
+}
+
+func withStageTimings(ctx context.Context) context.Context {
+	return context.WithValue(ctx, stageTimingsKey{}, &stageTimings{started: time.Now(), stages: map[string]float64{}})
+}
+
+// addStageTiming adds seconds to the stage of the job in ctx, if its
+// timings are kept.
+func addStageTiming(ctx context.Context, name string, seconds float64) {
+	timings, ok := ctx.Value(stageTimingsKey{}).(*stageTimings)
+	if !ok {
+		return
+	}
+	timings.mu.Lock()
+	defer timings.mu.Unlock()
+	timings.stages[name] += seconds
+}
+
+func stageTimingsFromContext(ctx context.Context) dto.ResultTimings {
+	result := dto.ResultTimings{Stages: map[string]float64{}}
+	timings, ok := ctx.Value(stageTimingsKey{}).(*stageTimings)
+	if !ok {
+		return result
+	}
+	timings.mu.Lock()
+	defer timings.mu.Unlock()
+	result.ProcessingSeconds = time.Since(timings.started).Seconds()
+	for name, seconds := range timings.stages {
+		result.Stages[name] = seconds
+	}
+	return result
+}
+
+func NewResultService(captions repository.TranscriptRepository, store objectstore.Store, cfg *config.Config) ResultService {
+	return &resultService{
+		captions: captions,
+		store:    store,
+		cfg:      cfg,
+	}
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/clamav"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+var ErrInfected = errors.New("upload is infected")
+
+// ScanService scans jobs' uploads for malware before anything else reads
+// them, and keeps each job's verdict for security reviews.
+type ScanService interface {
+	// Scan streams the job's files from the bucket to clamd and records the
+	// verdict. An infected file is quarantined and the error non-retryable;
+	// a retried job that was found infected fails the same way.
+	Scan(ctx context.Context, job *entities.Job, keys []string) error
+	Find(ctx context.Context, jobId uuid.UUID) (*entities.MalwareScan, error)
+}
+
+type scanService struct {
+	repo    repository.ScanRepository
+	scanner *clamav.Scanner
+	store   objectstore.Store
+	cfg     *config.Config
+}
+
+func (s *scanService) Scan(ctx context.Context, job *entities.Job, keys []string) error {
+	previous, err := s.repo.FindScan(ctx, job.ID)
+	switch {
+	case err == nil && previous.Status == constant.ScanStatusInfected:
+		return errors.Join(ErrNonRetryable, ErrInfected, fmt.Errorf("%s: %s found", *previous.InfectedKey, *previous.Signature))
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+		return err
+	}
+
+	scan := &entities.MalwareScan{
+		JobId:    job.ID,
+		LessonId: job.EntityId,
+		Status:   constant.ScanStatusClean,
+		Files:    keys,
+	}
+	for _, key := range keys {
+		result, err := s.scanObject(ctx, key)
+		if err != nil {
+			return fmt.Errorf("scan %s: %w", key, err)
+		}
+		if !result.Infected {
+			continue
+		}
+		quarantine := path.Join(s.cfg.Scan.QuarantinePrefix, job.ID.String(), key)
+		if err := s.quarantine(ctx, key, quarantine); err != nil {
+			return fmt.Errorf("quarantine %s: %w", key, err)
+		}
+		scan.Status = constant.ScanStatusInfected
+		scan.Signature, scan.InfectedKey, scan.QuarantineKey = &result.Signature, &key, &quarantine
+		zerolog.Ctx(ctx).Warn().Str("object_path", key).Str("signature", result.Signature).Str("quarantine", quarantine).Msg("infected upload quarantined")
+		break
+	}
+	scan.ScannedAt = time.Now()
+	if err := s.repo.SaveScan(ctx, scan); err != nil {
+		return err
+	}
+	if scan.Status == constant.ScanStatusInfected {
+		return errors.Join(ErrNonRetryable, ErrInfected, fmt.Errorf("%s: %s found", *scan.InfectedKey, *scan.Signature))
+	}
+	return nil
+}
+
+func (s *scanService) scanObject(ctx context.Context, key string) (*clamav.Result, error) {
+	object, err := s.store.GetObject(ctx, s.cfg.MinIOBucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+	return s.scanner.Scan(ctx, object)
+}
+
+// quarantine moves the object out of the lesson's prefix, where nothing
+// serves or processes it. Uploads can be past a single copy's 5 GiB.
+func (s *scanService) quarantine(ctx context.Context, key, quarantine string) error {
+	_, err := s.store.ComposeObject(ctx,
+		minio.CopyDestOptions{Bucket: s.cfg.MinIOBucket, Object: quarantine},
+		minio.CopySrcOptions{Bucket: s.cfg.MinIOBucket, Object: key})
+	if err != nil {
+		return err
+	}
+	return s.store.RemoveObject(ctx, s.cfg.MinIOBucket, key, minio.RemoveObjectOptions{})
+}
+
+// uploadKeys are the bucket keys of the files uploaded for the job.
+func uploadKeys(message dto.JobMessage) []string {
+	keys := []string{message.ObjectPath}
+	for _, track := range message.AudioTracks {
+		keys = append(keys, track.ObjectPath)
+	}
+	if message.Webcam != nil {
+		keys = append(keys, message.Webcam.ObjectPath)
+	}
+	return keys
+}
+
+func (s *scanService) Find(ctx context.Context, jobId uuid.UUID) (*entities.MalwareScan, error) {
+	scan, err := s.repo.FindScan(ctx, jobId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	return scan, err
+}
+
+func NewScanService(repo repository.ScanRepository, store objectstore.Store, cfg *config.Config) ScanService {
+	return &scanService{
+		repo:    repo,
+		scanner: clamav.New(cfg.Scan),
+		store:   store,
+		cfg:     cfg,
+	}
+}
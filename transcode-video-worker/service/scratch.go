@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+)
+
+// ErrInsecureScratch is returned for the job of a tenant with secure scratch
+// on a worker without memory-backed scratch space to run it in, or whose
+// memory can be swapped to a disk in the clear.
+var ErrInsecureScratch = errors.New("no secure scratch space")
+
+// scratchDir is the directory under which the job works. A tenant with
+// secure scratch has its sources, and everything made of them, kept in the
+// worker's memory-backed SecureScratchDir, so they never reach its disk; it
+// goes away with the job, or with the machine.
+func scratchDir(ctx context.Context, cfg *config.Config, name string) (string, error) {
+	if !featureEnabled(ctx, constant.TenantFeatureSecureScratch, false) {
+		return filepath.Join("temp", name), nil
+	}
+	if err := checkSecureScratch(cfg.Server.SecureScratchDir); err != nil {
+		return "", errors.Join(ErrNonRetryable, ErrInsecureScratch, err)
+	}
+	return filepath.Join(cfg.Server.SecureScratchDir, name), nil
+}
+
+// checkSecureScratch checks dir is memory-backed and that no swap its pages
+// could be written to is unencrypted.
+func checkSecureScratch(dir string) error {
+	if dir == "" {
+		return errors.New("WORKER_SECURE_SCRATCH_DIR isn't set")
+	}
+	inMemory, err := memoryBacked(dir)
+	if err != nil {
+		return err
+	}
+	if !inMemory {
+		return fmt.Errorf("%s isn't a memory-backed filesystem", dir)
+	}
+	swap, err := plainSwap()
+	if err != nil {
+		return err
+	}
+	if swap != "" {
+		return fmt.Errorf("swap %s isn't encrypted", swap)
+	}
+	return nil
+}
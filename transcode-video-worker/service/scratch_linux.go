@@ -0,0 +1,65 @@
+//go:build linux
+
+package service
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// The statfs magic numbers of tmpfs and ramfs.
+const (
+	tmpfsMagic = 0x01021994
+	ramfsMagic = 0x858458f6
+)
+
+// memoryBacked reports whether dir is on a tmpfs or ramfs.
+func memoryBacked(dir string) (bool, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return false, err
+	}
+	return stat.Type == tmpfsMagic || stat.Type == ramfsMagic, nil
+}
+
+// plainSwap returns the first active swap that isn't encrypted, or "" when
+// there is none. zram swaps to memory, and a dm-crypt device to disk
+// encrypted with a key the kernel holds.
+func plainSwap() (string, error) {
+	file, err := os.Open("/proc/swaps")
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // the header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		device := fields[0]
+		if resolved, err := filepath.EvalSymlinks(device); err == nil {
+			device = resolved
+		}
+		name := filepath.Base(device)
+		if strings.HasPrefix(name, "zram") {
+			continue
+		}
+		if strings.HasPrefix(name, "dm-") {
+			uuid, err := os.ReadFile(filepath.Join("/sys/block", name, "dm", "uuid"))
+			if err == nil && strings.HasPrefix(string(uuid), "CRYPT-") {
+				continue
+			}
+		}
+		return fields[0], nil
+	}
+	return "", scanner.Err()
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package service
+
+import "errors"
+
+// Whether a directory is memory-backed is only known on Linux, so secure
+// scratch isn't offered elsewhere.
+func memoryBacked(dir string) (bool, error) {
+	return false, errors.New("memory-backed scratch is only checked on linux")
+}
+
+func plainSwap() (string, error) {
+	return "", nil
+}
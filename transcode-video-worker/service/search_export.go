@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/search"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// searchExportBatch is how many lessons one sweep exports. A sweep that
+// fills it runs again straight away rather than waiting for the next tick.
+const searchExportBatch = 100
+
+// SearchExportService keeps the platform's content index in step with the
+// lessons: each published lesson's titles, video metadata, chapters and the
+// text of its caption tracks go into one document, for searching across
+// every course.
+type SearchExportService interface {
+	// Run exports the lessons changed since their last export every
+	// ExportInterval minutes until ctx is done. Only the leader runs it.
+	Run(ctx context.Context)
+	// Export replaces the lesson's document in the content index.
+	Export(ctx context.Context, lessonId uuid.UUID) error
+}
+
+type searchExportService struct {
+	repo        repository.SearchExportRepository
+	lessons     repository.NotificationRepository
+	courses     repository.CourseRepository
+	chapters    repository.ChapterRepository
+	transcripts repository.TranscriptRepository
+	index       search.ContentIndex
+	store       objectstore.Store
+	cfg         *config.Config
+}
+
+func (s *searchExportService) Run(ctx context.Context) {
+	if s.cfg.Search.ExportInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(s.cfg.Search.ExportInterval) * time.Minute)
+	defer ticker.Stop()
+	for {
+		for s.sweep(ctx) == searchExportBatch && ctx.Err() == nil {
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweep exports one batch of stale lessons, returning how many were listed.
+// A lesson that fails stays stale and is tried again next sweep.
+func (s *searchExportService) sweep(ctx context.Context) int {
+	lessons, err := s.repo.ListStaleLessons(ctx, searchExportBatch)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to list lessons to export")
+		return 0
+	}
+	exported := 0
+	for _, lessonId := range lessons {
+		if err := s.Export(ctx, lessonId); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Str("lesson_id", lessonId.String()).Msg("failed to export lesson to content index")
+			continue
+		}
+		exported++
+	}
+	if len(lessons) > 0 {
+		zerolog.Ctx(ctx).Info().Int("listed", len(lessons)).Int("exported", exported).Msg("lessons exported to content index")
+	}
+	// A batch of nothing but failures would list the same lessons again.
+	if exported == 0 {
+		return 0
+	}
+	return len(lessons)
+}
+
+func (s *searchExportService) Export(ctx context.Context, lessonId uuid.UUID) error {
+	// Changes made while the lesson is read are after this, so the next
+	// sweep picks them up.
+	read := time.Now()
+	summary, err := s.lessons.FindLessonSummary(ctx, lessonId)
+	if err != nil {
+		return err
+	}
+	document := search.ContentDocument{
+		LessonId:    summary.LessonId,
+		CourseId:    summary.CourseId,
+		LessonTitle: summary.LessonTitle,
+		CourseTitle: summary.CourseTitle,
+		LessonSlug:  summary.LessonSlug,
+		CourseSlug:  summary.CourseSlug,
+		UpdatedAt:   read.UTC(),
+	}
+
+	media, err := s.courses.FindMedia(ctx, lessonId)
+	switch {
+	case err == nil:
+		document.DurationSeconds = media.DurationSeconds
+		document.Width, document.Height = media.Width, media.Height
+		document.AudioLanguages = media.AudioLanguages
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return err
+	}
+
+	chapters, err := s.chapters.ListChapters(ctx, lessonId)
+	if err != nil {
+		return err
+	}
+	for _, chapter := range chapters {
+		document.Chapters = append(document.Chapters, search.ContentChapter{Start: chapter.StartSeconds, Title: chapter.Title})
+	}
+
+	captions, err := s.transcripts.ListCaptions(ctx, lessonId)
+	if err != nil {
+		return err
+	}
+	for _, caption := range captions {
+		if caption.ObjectKey == nil {
+			continue
+		}
+		text, err := s.captionText(ctx, *caption.ObjectKey)
+		if err != nil {
+			return err
+		}
+		document.CaptionLanguages = append(document.CaptionLanguages, caption.Language)
+		document.Transcripts = append(document.Transcripts, search.ContentTranscript{Language: caption.Language, Text: text})
+	}
+
+	if err := s.index.Put(ctx, document); err != nil {
+		return err
+	}
+	return s.repo.MarkExported(ctx, lessonId, read)
+}
+
+// captionText is the text of a WebVTT caption track, its cues joined in
+// order.
+func (s *searchExportService) captionText(ctx context.Context, key string) (string, error) {
+	object, err := s.store.GetObject(ctx, s.cfg.MinIOBucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return "", err
+	}
+	defer object.Close()
+	cues, err := search.ParseWebVTT(object)
+	if err != nil {
+		return "", err
+	}
+	text := make([]string, 0, len(cues))
+	for _, cue := range cues {
+		text = append(text, cue.Text)
+	}
+	return strings.Join(text, " "), nil
+}
+
+func NewSearchExportService(repo repository.SearchExportRepository, lessons repository.NotificationRepository, courses repository.CourseRepository, chapters repository.ChapterRepository, transcripts repository.TranscriptRepository, store objectstore.Store, cfg *config.Config) SearchExportService {
+	return &searchExportService{
+		repo:        repo,
+		lessons:     lessons,
+		courses:     courses,
+		chapters:    chapters,
+		transcripts: transcripts,
+		index:       search.NewElasticsearchContent(cfg.Search),
+		store:       store,
+		cfg:         cfg,
+	}
+}
@@ -5,31 +5,81 @@ import (
 	"errors"
 	"github.com/minio/minio-go/v7"
 	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 	"worker-transcode/config"
 	"worker-transcode/constant"
 	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/alerting"
+	"worker-transcode/pkg/correlation"
+	"worker-transcode/pkg/metrics"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/pkg/reporting"
+	"worker-transcode/pkg/tracing"
 	"worker-transcode/repository"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var ErrNonRetryable = errors.New("non-retryable error")
+var tracer = tracing.Tracer("worker-transcode/service")
+
+var (
+	ErrNonRetryable    = errors.New("non-retryable error")
+	ErrInvalidArgument = errors.New("invalid argument")
+	ErrNotFound        = errors.New("not found")
+)
 
 type Service interface {
 	Process(ctx context.Context, message dto.JobMessage) error
 }
 
 type service struct {
-	repo repository.JobRepository
-	cfg  *config.Config
+	repo          repository.JobRepository
+	presets       PresetService
+	notifications NotificationService
+	analytics     AnalyticsService
+	chapters      ChapterService
+	downloads     DownloadService
+	courses       CourseService
+	versions      VideoVersionService
+	branding      BrandingService
+	accessibility AccessibilityService
+	quality       QualityService
+	qc            QCService
+	publishing    PublishingService
+	drives        DriveService
+	scans         ScanService
+	tenants       TenantService
+	quotas        QuotaService
+	billing       BillingService
+	results       ResultService
+	diarization   DiarizationService
+	events        repository.JobEventRepository
+	locks         repository.LockRepository
+	outputs       repository.OutputRepository
+	regenerations repository.RegenerationRepository
+	snapshots     repository.JobSnapshotRepository
+	publisher     rabbitmq.Publisher
+	stages        *stageRegistry
+	store         objectstore.Store
+	cfg           *config.Config
 }
 
 func (s service) Process(ctx context.Context, message dto.JobMessage) (err error) {
 	zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("processing job")
-	path := filepath.Dir(message.ObjectPath)
-	fileName := filepath.Base(message.ObjectPath)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("job.id", message.JobId.String()))
+	if s.cfg.Server.DryRun || IsDryRun(ctx) {
+		return s.dryRun(ctx, message)
+	}
+	// The package's prefix is addressed by its content once the inputs are
+	// ready; until then it's the job's.
+	path := packagePrefix(message.ObjectPath, message.JobId)
 	job, err := s.repo.FindJobById(ctx, message.JobId)
 	if err != nil {
 		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to find job by id")
@@ -40,29 +90,176 @@ func (s service) Process(ctx context.Context, message dto.JobMessage) (err error
 		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("job is not pending")
 		return nil
 	}
+	// The tenant's config is read before claiming, so a job over its
+	// tenant's limit isn't counted against it while it waits.
+	ctx, err = s.tenants.Admit(ctx, job)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("job not admitted for its tenant")
+		return err
+	}
+	// A job whose media must stay in another region is left to the workers
+	// there.
+	if region := jobRegion(ctx, job, message); region != "" && region != s.cfg.Region.Name {
+		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Str("region", region).Msg("job belongs to another region")
+		return rabbitmq.Forward(region)
+	}
+	// A job needing what this worker lacks, such as a GPU, is left to the
+	// workers that have it.
+	requirements := jobRequirements(job, message)
+	if err = checkRequirements(ctx, s.cfg, requirements); err != nil {
+		zerolog.Ctx(ctx).Info().Err(err).Str("job_id", message.JobId.String()).Msg("job left to a worker meeting its requirements")
+		return err
+	}
 
-	if err := s.repo.UpdateStatusJob(ctx, constant.JobStatusProcessing, message.JobId); err != nil {
-		log.Error().Err(err).Msg("failed to update job status")
+	claimed, err := s.repo.ClaimJob(ctx, message.JobId, correlation.FromContext(ctx), workerFromContext(ctx))
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to update job status")
 		return err
 	}
+	if !claimed {
+		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("job already claimed by another delivery")
+		return nil
+	}
+	rabbitmq.Claimed(ctx)
+	queued := time.Since(job.CreatedAt)
+	ctx = withTimeline(ctx, s.events, job.ID)
+	ctx = withStageTimings(ctx)
+	ctx, stopHeartbeat := withHeartbeat(ctx, s.repo, job.ID, s.cfg)
+	cleanup := &jobCleanup{}
+	cleanup.add("heartbeat", func(context.Context) error {
+		stopHeartbeat()
+		return nil
+	})
+	recordStatus(ctx, constant.JobStatusPending, constant.JobStatusProcessing)
+	// A course already announced goes back to waiting on this lesson.
+	if courseErr := s.courses.Check(ctx, job.EntityId); courseErr != nil {
+		zerolog.Ctx(ctx).Warn().Err(courseErr).Msg("failed to check course readiness")
+	}
+
+	started := time.Now()
+	event := dto.MediaEvent{
+		JobId:         job.ID,
+		JobType:       string(job.JobType),
+		EntityId:      job.EntityId,
+		TenantId:      job.TenantId,
+		CorrelationId: correlation.FromContext(ctx),
+	}
+
+	ctx, deadline, cancelDeadline := withJobDeadline(ctx, s.cfg)
+	defer cancelDeadline()
+
+	// Deferred first so it runs last, after the outcome is recorded.
+	if s.cfg.Artifacts.Enabled {
+		var artifacts *jobArtifacts
+		ctx, artifacts = withArtifacts(ctx)
+		defer func() {
+			if uploadErr := artifacts.upload(context.WithoutCancel(ctx), s.store, s.cfg, job.ID); uploadErr != nil {
+				zerolog.Ctx(ctx).Warn().Err(uploadErr).Msg("failed to upload job artifacts")
+			}
+		}()
+	}
 
+	stage := constant.ErrorClassWorkspace
 	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = panicked(ctx, recovered)
+		}
+		// Whatever the outcome, the job lets go of what it holds before the
+		// outcome is recorded and its message acked.
+		cleanup.run(ctx, failedForGood(ctx, err))
+		if errors.Is(err, ErrInsufficientResources) {
+			postpone(ctx, s.repo, message.JobId, err)
+			return
+		}
+		if err != nil && timedOut(ctx) {
+			// The ffmpeg process group is already killed; what remains of
+			// the job runs on a context that isn't cancelled.
+			ctx = context.WithoutCancel(ctx)
+			stage = constant.ErrorClassTimeout
+			err = errors.Join(ErrNonRetryable, ErrJobTimeout, err)
+		}
+		if shuttingDown(ctx, err) {
+			err = handOff(ctx, s.repo, message.JobId, err)
+			return
+		}
+		err = classify(err)
+		s.recordCrash(ctx, job, stage, err)
+		recordOutcome(ctx, job, stage, err)
+		if err == nil || errors.Is(err, ErrNonRetryable) {
+			event.EventType = MediaEventProcessed
+			if err != nil {
+				event.EventType = MediaEventFailed
+				event.ErrorClass = string(stage)
+				event.ErrorKind = string(errorKind(err))
+			}
+			event.ProcessingSeconds = time.Since(started).Seconds()
+			if publishErr := s.analytics.Publish(ctx, event); publishErr != nil {
+				zerolog.Ctx(ctx).Warn().Err(publishErr).Msg("failed to publish analytics event")
+			}
+		}
+		recordOutcomeEvents(ctx, stage, err)
 		if err != nil {
-			if errors.Is(err, ErrNonRetryable) {
-				if updateErr := s.repo.UpdateStatusJob(ctx, constant.JobStatusFailed, message.JobId); updateErr != nil {
-					log.Error().Err(updateErr).Msg("failed to update job status")
+			if errors.Is(err, ErrQualityFailed) {
+				// The package waits for ops to override its gate.
+				if updateErr := s.repo.UpdateStatusJob(ctx, constant.JobStatusQualityFailed, message.JobId); updateErr != nil {
+					zerolog.Ctx(ctx).Error().Err(updateErr).Msg("failed to update job status")
 				}
 				err = nil
+			} else if errors.Is(err, ErrNonRetryable) {
+				if updateErr := s.repo.FailJob(ctx, message.JobId, stage, errorKind(err), err.Error()); updateErr != nil {
+					zerolog.Ctx(ctx).Error().Err(updateErr).Msg("failed to update job status")
+				}
+				reporting.CaptureFailure(ctx, err, reporting.Failure{
+					JobId:   message.JobId.String(),
+					JobType: string(job.JobType),
+					Stage:   string(stage),
+					Output:  failureOutput(err),
+					Extra: map[string]interface{}{
+						"object_path": message.ObjectPath,
+						"preset":      message.Preset,
+					},
+				})
+				err = nil
 			} else {
 				if updateErr := s.repo.UpdateStatusJob(ctx, constant.JobStatusPending, message.JobId); updateErr != nil {
-					log.Error().Err(updateErr).Msg("failed to update job status")
+					zerolog.Ctx(ctx).Error().Err(updateErr).Msg("failed to update job status")
 				}
 			}
 		}
 	}()
 
-	tempDir := filepath.Join("temp", message.JobId.String())
-	defer os.RemoveAll(tempDir)
+	stage = constant.ErrorClassQuota
+	if err = s.quotas.Check(ctx, job); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("job rejected for its tenant's quota")
+		return err
+	}
+	stage = constant.ErrorClassWorkspace
+
+	// A re-upload must not start while an earlier transcode of the same
+	// lesson is still writing to the same keys, so jobs per lesson run one at
+	// a time.
+	var unlock func()
+	err = traceStage(ctx, "lock", func(ctx context.Context) error {
+		var lockErr error
+		unlock, lockErr = s.locks.LockEntity(ctx, job.EntityId)
+		return lockErr
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to lock lesson")
+		return err
+	}
+	cleanup.add("lock", func(context.Context) error {
+		unlock()
+		return nil
+	})
+	tempDir, err := scratchDir(ctx, s.cfg, message.JobId.String())
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to pick scratch space")
+		return err
+	}
+	cleanup.add("workspace", func(context.Context) error {
+		return os.RemoveAll(tempDir)
+	})
 
 	inputDir := filepath.Join(tempDir, "input")
 	outputDir := filepath.Join(tempDir, "output")
@@ -76,56 +273,775 @@ func (s service) Process(ctx context.Context, message dto.JobMessage) (err error
 		return errors.Join(ErrNonRetryable, err)
 	}
 
-	inputFilepath := filepath.Join(inputDir, fileName)
-	zerolog.Ctx(ctx).Info().Str("input_file", inputFilepath).Msg("downloading input file")
-	err = s.cfg.Storage.FGetObject(ctx, s.cfg.MinIOBucket, message.ObjectPath, inputFilepath, minio.GetObjectOptions{})
+	if err = validateMarkers(message.Chapters); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("invalid chapter markers")
+		return errors.Join(ErrNonRetryable, ErrInvalidArgument, err)
+	}
+	if err = validateCuePoints(message.CuePoints); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("invalid cue points")
+		return errors.Join(ErrNonRetryable, ErrInvalidArgument, err)
+	}
+	if err = validateAudioTracks(message.AudioTracks); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("invalid audio tracks")
+		return errors.Join(ErrNonRetryable, ErrInvalidArgument, err)
+	}
+	if err = validateWebcam(message.Webcam); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("invalid webcam recording")
+		return errors.Join(ErrNonRetryable, ErrInvalidArgument, err)
+	}
+	if err = validateEdit(message.Edit); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("invalid edit")
+		return errors.Join(ErrNonRetryable, ErrInvalidArgument, err)
+	}
+	if err = validateParts(message); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("invalid recording parts")
+		return errors.Join(ErrNonRetryable, ErrInvalidArgument, err)
+	}
+	if err = validateDriveSource(message.Source); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("invalid drive source")
+		return errors.Join(ErrNonRetryable, ErrInvalidArgument, err)
+	}
+	if message.Source != nil && isHLSSource(message.ObjectPath) {
+		err = errors.New("a drive source is copied to a video file, not a playlist")
+		zerolog.Ctx(ctx).Error().Err(err).Msg("invalid drive source")
+		return errors.Join(ErrNonRetryable, ErrInvalidArgument, err)
+	}
+	if message.Webcam != nil && isHLSSource(message.ObjectPath) {
+		err = errors.New("a webcam recording needs a screen capture file, not a playlist")
+		zerolog.Ctx(ctx).Error().Err(err).Msg("invalid webcam recording")
+		return errors.Join(ErrNonRetryable, ErrInvalidArgument, err)
+	}
+
+	preset, err := s.presets.Pick(ctx, tenantPreset(ctx, message.Preset), message.JobId)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Str("preset", message.Preset).Msg("failed to resolve preset")
+		if errors.Is(err, ErrNotFound) {
+			return errors.Join(ErrNonRetryable, err)
+		}
+		return err
+	}
+	enrolled, experiment, err := s.presets.Enroll(ctx, preset, message.JobId)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Str("preset", preset.Name).Msg("failed to enroll job in preset experiment")
+		return err
+	}
+	preset = enrolled
+	if err = s.repo.UpdateJobPreset(ctx, message.JobId, preset.Name, preset.Version); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to record job preset")
+		return err
+	}
+	event.Preset = preset.Name
+	event.PresetVersion = preset.Version
+	event.Canary = preset.CanaryPercent > 0
+	if experiment != nil {
+		event.ExperimentId = &experiment.ExperimentId
+		event.ExperimentArm = string(experiment.Arm)
+	}
+	event.VideoCodec = preset.VideoCodec
+	event.AudioCodec = preset.AudioCodec
+	event.Renditions = preset.Renditions
+
+	release, err := preflight(ctx, s.cfg, tempDir, requiredScratch(requirements, transcodeScratch(ctx, s.store, s.cfg, message.ObjectPath, preset.Renditions)))
+	if err != nil {
+		return err
+	}
+	cleanup.add("scratch", func(context.Context) error {
+		release()
+		return nil
+	})
+
+	stage = constant.ErrorClassDownload
+	if message.Source != nil {
+		err = traceStage(ctx, "drive", func(ctx context.Context) error {
+			return s.drives.Fetch(ctx, job, message.Source, message.ObjectPath)
+		})
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Str("provider", message.Source.Provider).Msg("failed to copy drive source")
+			return err
+		}
+	}
+	var joined string
+	if len(message.Parts) > 0 {
+		stage = constant.ErrorClassTranscode
+		err = traceStage(ctx, "join_parts", func(ctx context.Context) error {
+			var joinErr error
+			joined, joinErr = s.joinParts(ctx, message.Parts, message.ObjectPath, inputDir)
+			return joinErr
+		})
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Int("parts", len(message.Parts)).Msg("failed to join recording parts")
+			return err
+		}
+		zerolog.Ctx(ctx).Info().Int("parts", len(message.Parts)).Msg("recording parts joined")
+		stage = constant.ErrorClassDownload
+	}
+	// An infected upload is quarantined before anything reads it. A
+	// playlist source is this service's own output, not an upload.
+	if s.cfg.Scan.Enabled && !isHLSSource(message.ObjectPath) {
+		stage = constant.ErrorClassScan
+		err = traceStage(ctx, "scan", func(ctx context.Context) error {
+			return s.scans.Scan(ctx, job, uploadKeys(message))
+		})
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to scan upload")
+			return err
+		}
+		stage = constant.ErrorClassDownload
+	}
+	var (
+		inputFilepath, audioFilepath string
+		dubs                         []dubbedAudio
+	)
+	zerolog.Ctx(ctx).Info().Str("object_path", message.ObjectPath).Int("audio_tracks", len(message.AudioTracks)).Msg("downloading input file")
+	err = traceStage(ctx, "download", func(ctx context.Context) error {
+		var downloadErr error
+		// The joined parts are already here.
+		if joined != "" {
+			inputFilepath = joined
+		} else if inputFilepath, audioFilepath, downloadErr = s.downloadSource(ctx, message.ObjectPath, inputDir); downloadErr != nil {
+			return downloadErr
+		}
+		dubs, downloadErr = s.downloadAudioTracks(ctx, message.AudioTracks, inputDir)
+		return downloadErr
+	})
 	if err != nil {
 		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to download file")
 		return err
 	}
+	downloaded := inputFilepath
+	// A job that can't be snapshotted still runs; it just can't be replayed.
+	if snapshotErr := s.snapshot(ctx, message, preset); snapshotErr != nil {
+		zerolog.Ctx(ctx).Warn().Err(snapshotErr).Msg("failed to record job snapshot")
+	}
 
-	zerolog.Ctx(ctx).Info().Msg("transcode file")
-	if err = transcodeToHLS(inputFilepath, outputDir); err != nil {
-		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to transcode file")
-		return errors.Join(ErrNonRetryable, err)
+	stage = constant.ErrorClassProbe
+	source, err := CheckSource(ctx, inputFilepath)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to inspect source file")
+		return classified(constant.ErrorKindSourceCorrupt, err)
+	}
+	for _, warning := range source.Warnings {
+		zerolog.Ctx(ctx).Warn().Str("input_file", inputFilepath).Msg(warning)
+	}
+	if err = source.Err(); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("source file failed validation")
+		return classified(constant.ErrorKindSourceCorrupt, err)
+	}
+	observeSource(source)
+	addJSONArtifact(ctx, "probe.json", source)
+	event.SourceBytes, event.SourceSeconds = source.SizeBytes, source.DurationSeconds
+	sourceDuration := source.DurationSeconds
+	if limit := deadline.scale(sourceDuration); limit > 0 {
+		zerolog.Ctx(ctx).Info().Dur("limit", limit).Msg("job time limit set")
 	}
 
-	if err = createMasterPlaylist(outputDir); err != nil {
-		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to create master playlist")
-		return errors.Join(ErrNonRetryable, err)
+	var (
+		chapters  []*entities.Chapter
+		reused    *entities.TranscodeOutput
+		verified  *VerifyReport
+		followUps []constant.RegenerationArtifact
+	)
+	// stageJob is the job as the registered stages of the phase about to run
+	// see it.
+	stageJob := func() *StageJob {
+		return &StageJob{
+			Job:           job,
+			Message:       message,
+			Preset:        preset,
+			Source:        source,
+			InputFilepath: inputFilepath,
+			AudioFilepath: audioFilepath,
+			Dubs:          dubs,
+			Chapters:      chapters,
+			SourceSeconds: sourceDuration,
+			OutputDir:     outputDir,
+			PackagePath:   path,
+			Reused:        reused != nil,
+			Verified:      verified,
+			Experiment:    experiment,
+		}
+	}
+	if err = s.stages.run(ctx, PhaseProbe, stageJob()); err != nil {
+		return err
+	}
+
+	// The composite takes the screen capture's place for every later stage.
+	if message.Webcam != nil {
+		stage = constant.ErrorClassTranscode
+		err = traceStage(ctx, "webcam", func(ctx context.Context) error {
+			composited, compositeErr := s.compositeWebcam(ctx, inputFilepath, source.Media, message.Webcam, inputDir)
+			if compositeErr != nil {
+				return compositeErr
+			}
+			media, compositeErr := ProbeMedia(ctx, composited)
+			if compositeErr != nil {
+				return compositeErr
+			}
+			os.Remove(inputFilepath)
+			inputFilepath, source.Media = composited, media
+			return nil
+		})
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to composite webcam")
+			return err
+		}
+		zerolog.Ctx(ctx).Info().Str("layout", message.Webcam.Layout).Msg("webcam composited")
+	}
+
+	if capped, skipped := capLadder(preset, source.Media); len(skipped) > 0 {
+		preset = capped
+		event.Renditions, event.SkippedRenditions = preset.Renditions, skipped
+		recordEvent(ctx, constant.JobEventLadder, "probe", entities.EventData{"skipped": skipped})
+		zerolog.Ctx(ctx).Info().Int("renditions", len(preset.Renditions)).Int("skipped", len(skipped)).Msg("ladder capped at source resolution")
 	}
 
-	zerolog.Ctx(ctx).Info().Msg("upload transcode file")
-	err = uploadDirectory(ctx, s.cfg.Storage, s.cfg.MinIOBucket, outputDir, path)
+	// The instructor's edit takes the place of the dead air trim. One that
+	// can't be applied fails the job rather than publish what was cut.
+	var edit []trimWindow
+	if message.Edit != nil && !isHLSSource(message.ObjectPath) {
+		stage = constant.ErrorClassTranscode
+		var reencoded bool
+		err = traceStage(ctx, "edit", func(ctx context.Context) error {
+			kept, editErr := editRanges(*message.Edit, sourceDuration)
+			if editErr != nil {
+				return errors.Join(ErrInvalidArgument, editErr)
+			}
+			edited, editReencoded, editErr := editFile(ctx, inputFilepath, inputDir, kept, s.cfg.Server.FFmpegThreads)
+			if editErr != nil {
+				return editErr
+			}
+			editedDubs := slices.Clone(dubs)
+			for i := range editedDubs {
+				if editedDubs[i].path, _, editErr = editFile(ctx, editedDubs[i].path, inputDir, kept, s.cfg.Server.FFmpegThreads); editErr != nil {
+					return editErr
+				}
+			}
+			inputFilepath, dubs, edit, reencoded = edited, editedDubs, kept, editReencoded
+			return nil
+		})
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to apply edit")
+			return err
+		}
+		kept := make([][2]float64, 0, len(edit))
+		for _, r := range edit {
+			kept = append(kept, [2]float64{r.start, r.end})
+		}
+		event.TrimmedSeconds = sourceDuration - keptDuration(edit)
+		sourceDuration = keptDuration(edit)
+		message.Chapters = editMarkers(message.Chapters, edit)
+		message.CuePoints = editCuePoints(message.CuePoints, edit)
+		recordEvent(ctx, constant.JobEventTrim, "edit", entities.EventData{
+			"kept":     kept,
+			"lossless": !reencoded,
+			"original": originalKey(message.ObjectPath),
+		})
+		zerolog.Ctx(ctx).Info().
+			Int("ranges", len(edit)).
+			Bool("lossless", !reencoded).
+			Float64("cut_seconds", event.TrimmedSeconds).
+			Msg("edit applied")
+	}
+
+	// A recording that can't be trimmed is published untrimmed.
+	var trim *trimWindow
+	if featureEnabled(ctx, constant.TenantFeatureTrim, s.cfg.Trim.Enabled) && !message.NoTrim && edit == nil && !isHLSSource(message.ObjectPath) {
+		stage = constant.ErrorClassTranscode
+		err = traceStage(ctx, "trim", func(ctx context.Context) error {
+			window, found, trimErr := detectDeadAir(ctx, inputFilepath, sourceDuration, s.cfg.Trim)
+			if trimErr != nil || !found {
+				return trimErr
+			}
+			trimmed, trimErr := trimFile(ctx, inputFilepath, inputDir, window)
+			if trimErr != nil {
+				return trimErr
+			}
+			trimmedDubs := slices.Clone(dubs)
+			for i := range trimmedDubs {
+				if trimmedDubs[i].path, trimErr = trimFile(ctx, trimmedDubs[i].path, inputDir, window); trimErr != nil {
+					return trimErr
+				}
+			}
+			inputFilepath, dubs, trim = trimmed, trimmedDubs, &window
+			return nil
+		})
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to trim dead air")
+		}
+	}
+	if trim != nil {
+		event.TrimmedSeconds = trim.cut(sourceDuration)
+		sourceDuration = trim.end - trim.start
+		message.Chapters = shiftMarkers(message.Chapters, *trim)
+		message.CuePoints = shiftCuePoints(message.CuePoints, *trim)
+		recordEvent(ctx, constant.JobEventTrim, "trim", entities.EventData{
+			"start":    trim.start,
+			"end":      trim.end,
+			"original": originalKey(message.ObjectPath),
+		})
+		zerolog.Ctx(ctx).Info().
+			Float64("start", trim.start).
+			Float64("end", trim.end).
+			Float64("cut_seconds", event.TrimmedSeconds).
+			Msg("dead air trimmed")
+	}
+
+	// A lesson that can't be branded is published unbranded.
+	if featureEnabled(ctx, constant.TenantFeatureBranding, s.cfg.Branding.Enabled) {
+		stage = constant.ErrorClassTranscode
+		var brand *branded
+		err = traceStage(ctx, "branding", func(ctx context.Context) error {
+			var brandErr error
+			brand, brandErr = s.branding.Apply(ctx, job, inputFilepath, audioFilepath, dubs, source, inputDir)
+			return brandErr
+		})
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to apply branding")
+		} else if brand != nil {
+			inputFilepath, audioFilepath, dubs = brand.input, "", brand.dubs
+			sourceDuration += brand.card
+			message.Chapters = delayMarkers(message.Chapters, brand.card)
+			message.CuePoints = delayCuePoints(message.CuePoints, brand.card)
+			zerolog.Ctx(ctx).Info().Float64("title_card_seconds", brand.card).Msg("branding applied")
+		}
+	}
+
+	stage = constant.ErrorClassTranscode
+	preprocessed := stageJob()
+	if err = s.stages.run(ctx, PhasePreprocess, preprocessed); err != nil {
+		return err
+	}
+	inputFilepath, audioFilepath, dubs = preprocessed.InputFilepath, preprocessed.AudioFilepath, preprocessed.Dubs
+
+	stage = constant.ErrorClassDatabase
+	chapters, err = s.chapters.Provide(ctx, job, message.Chapters, sourceDuration)
 	if err != nil {
-		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to upload directory")
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to store chapters")
 		return err
 	}
 
-	zerolog.Ctx(ctx).Info().Msg("deleting original file")
-	err = s.cfg.Storage.RemoveObject(ctx, s.cfg.MinIOBucket, message.ObjectPath, minio.RemoveObjectOptions{})
+	// The package is addressed by a hash of everything it's made from and
+	// the preset's version, under a directory of the job's own, so a
+	// re-transcode never writes over the package the lesson plays.
+	var reuseKey, sourceHash string
+	err = traceStage(ctx, "content_hash", func(ctx context.Context) error {
+		var hashErr error
+		reuseKey, sourceHash, hashErr = outputKey(ctx, preset, inputFilepath, audioFilepath, dubs, chapters, message.CuePoints, message.ScreenRecording, message.LowLatency, s.cfg)
+		return hashErr
+	})
 	if err != nil {
-		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to delete original file")
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to hash the job's inputs, packaging under the job's prefix")
+		reuseKey = ""
+	} else {
+		path = contentPrefix(message.ObjectPath, message.JobId, packageDir(reuseKey, preset.Version))
+	}
+	// An identical input already encoded with the same preset version, as
+	// when a course is cloned, is copied from that job's package instead of
+	// encoded again.
+	if reuseKey != "" && s.cfg.Dedup.Enabled {
+		err = traceStage(ctx, "dedup", func(ctx context.Context) error {
+			var dedupErr error
+			reused, dedupErr = s.reusableOutput(ctx, reuseKey)
+			return dedupErr
+		})
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to look up an identical output")
+			reused = nil
+		}
+	}
+
+	// manifest is the size of each object put in the package, by its path
+	// under the package's prefix. The package is checked against it before
+	// the job completes, and what it names is removed if the job fails.
+	var manifest map[string]int64
+	var uploaded int64
+	if reused != nil {
+		stage = constant.ErrorClassUpload
+		cleanup.onFailure("package", func(ctx context.Context) error {
+			return removePackage(ctx, s.store, s.cfg.MinIOBucket, path, manifest)
+		})
+		err = traceStage(ctx, "copy_package", func(ctx context.Context) error {
+			var copyErr error
+			manifest, copyErr = copyPackage(ctx, s.store, s.cfg, reused.PackagePath, path)
+			return copyErr
+		})
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to copy identical output")
+			return err
+		}
+		uploaded = manifestBytes(manifest)
+		event.OutputBytes = uploaded
+		zerolog.Ctx(ctx).Info().
+			Str("reused_job_id", reused.JobId.String()).
+			Str("package", reused.PackagePath).
+			Msg("identical output copied instead of encoded")
+	} else {
+		stage = constant.ErrorClassTranscode
+		request := EncodeRequest{
+			Preset:        preset,
+			InputFilepath: inputFilepath,
+			AudioFilepath: audioFilepath,
+			Dubs:          dubs,
+			OutputDir:     outputDir,
+			Threads:       s.cfg.Server.FFmpegThreads,
+			OnProgress:    progressReporter(ctx, s.progressStore(), message.JobId, sourceDuration),
+		}
+		if message.LowLatency {
+			request.PartSeconds = s.cfg.Live.PartSeconds
+		}
+		engine, capable, engineErr := negotiateEngine(ctx, s.cfg.Server.MediaEngines, request)
+		if engineErr != nil {
+			return errors.Join(ErrNonRetryable, engineErr)
+		}
+		capabilities, _ := engine.Capabilities(ctx)
+		// A rung the source already conforms to is remuxed, not encoded.
+		var copyHeight int
+		if s.cfg.Server.StreamCopy && capabilities.StreamCopy {
+			if media, probeErr := ProbeMedia(ctx, inputFilepath); probeErr == nil {
+				copyHeight, probeErr = copyableRung(ctx, preset, inputFilepath, media)
+				if probeErr != nil {
+					zerolog.Ctx(ctx).Warn().Err(probeErr).Msg("failed to check source keyframes, encoding every rung")
+				}
+			}
+			if copyHeight > 0 {
+				zerolog.Ctx(ctx).Info().Int("height", copyHeight).Msg("source conforms to a rung, stream copying it")
+			}
+		}
+		// The external transcoder fetches the source itself, so it only
+		// takes one nothing has been done to locally, and doesn't package
+		// for low latency.
+		var remoteFallback string
+		if inputFilepath == downloaded && audioFilepath == "" && len(dubs) == 0 && !isHLSSource(message.ObjectPath) && !message.LowLatency {
+			remoteFallback = remoteReason(s.cfg, capable, queued)
+		}
+		zerolog.Ctx(ctx).Info().Msg("transcode file")
+		encodeStart := time.Now()
+		segments := newSegmentUploader(s.store, s.cfg.MinIOBucket, outputDir, path)
+		cleanup.onFailure("package", segments.discard)
+		err = traceStage(ctx, "transcode", func(ctx context.Context) error {
+			if s.cfg.Server.StreamUpload {
+				streamCtx, stopStreaming := context.WithCancel(ctx)
+				streamed := make(chan struct{})
+				go func() {
+					defer close(streamed)
+					segments.run(streamCtx)
+				}()
+				defer func() {
+					stopStreaming()
+					<-streamed
+				}()
+			}
+			if remoteFallback != "" {
+				made, remoteErr := transcodeRemote(ctx, s.store, s.cfg, message.ObjectPath, preset, outputDir, filepath.Join(tempDir, "remote"))
+				if remoteErr == nil {
+					for _, r := range preset.Renditions {
+						if !slices.Contains(made.Renditions, r) {
+							event.SkippedRenditions = append(event.SkippedRenditions, r)
+						}
+					}
+					preset = made
+					event.Renditions, event.VideoCodec, event.AudioCodec = made.Renditions, made.VideoCodec, made.AudioCodec
+					event.RemoteProvider = s.cfg.Remote.Provider
+					recordEvent(ctx, constant.JobEventRemote, "transcode", entities.EventData{
+						"provider": s.cfg.Remote.Provider,
+						"reason":   remoteFallback,
+					})
+					return nil
+				}
+				zerolog.Ctx(ctx).Warn().Err(remoteErr).Str("reason", remoteFallback).Msg("external transcoder failed, encoding locally")
+			}
+			request.CopyHeight = copyHeight
+			event.MediaEngine = engine.Name()
+			return engine.Encode(ctx, request)
+		})
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to transcode file")
+			return errors.Join(ErrNonRetryable, err)
+		}
+		event.EncodeSeconds = time.Since(encodeStart).Seconds()
+		if sourceDuration > 0 && event.EncodeSeconds > 0 {
+			metrics.Observe(ctx, metrics.EncodeSpeed, sourceDuration/event.EncodeSeconds)
+		}
+		if err = s.stages.run(ctx, PhaseEncode, stageJob()); err != nil {
+			return errors.Join(ErrNonRetryable, err)
+		}
+
+		stage = constant.ErrorClassPackage
+		err = traceStage(ctx, "package", func(ctx context.Context) error {
+			if err := engine.Package(ctx, preset, outputDir, dubs); err != nil {
+				return err
+			}
+			if err := writeProgressive(ctx, preset, outputDir, s.cfg.Server.FFmpegThreads); err != nil {
+				return err
+			}
+			if err := embedCuePoints(outputDir, message.CuePoints, sourceDuration); err != nil {
+				return err
+			}
+			if len(chapters) == 0 {
+				return nil
+			}
+			return embedChapters(outputDir, chapters)
+		})
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to create master playlist")
+			return errors.Join(ErrNonRetryable, err)
+		}
+
+		packaged := stageJob()
+		if err = s.stages.run(ctx, PhasePackage, packaged); err != nil {
+			return errors.Join(ErrNonRetryable, err)
+		}
+		followUps = packaged.FollowUps
+
+		stage = constant.ErrorClassUpload
+		zerolog.Ctx(ctx).Info().Msg("upload transcode file")
+		uploadStart := time.Now()
+		var remaining int64
+		err = traceStage(ctx, "upload", func(ctx context.Context) error {
+			var uploadErr error
+			uploaded, remaining, uploadErr = segments.finish(ctx)
+			return uploadErr
+		})
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to upload directory")
+			return err
+		}
+		manifest = segments.manifest()
+		observeThroughput(ctx, remaining, time.Since(uploadStart))
+		zerolog.Ctx(ctx).Info().Int64("bytes", uploaded).Int64("streamed_bytes", uploaded-remaining).Msg("package uploaded")
+		event.OutputBytes = uploaded
+		if err = s.stages.run(ctx, PhaseUpload, stageJob()); err != nil {
+			return err
+		}
+	}
+
+	stage = constant.ErrorClassVerify
+	checked := stageJob()
+	if err = s.stages.run(ctx, PhaseQC, checked); err != nil {
 		return err
 	}
+	verified = checked.Verified
 
-	if err = s.repo.UpdateStatusJob(ctx, constant.JobStatusCompleted, message.JobId); err != nil {
+	// A package below its preset's quality gate stays uploaded for ops to
+	// override, but isn't published or offered for reuse. A package copied
+	// from an identical job's passed the same gate with that job.
+	if reused == nil && preset.Options.QualityGate != nil {
+		stage = constant.ErrorClassQuality
+		var gate *entities.QualityGate
+		err = traceStage(ctx, "quality_gate", func(ctx context.Context) error {
+			var gateErr error
+			gate, gateErr = s.quality.Gate(ctx, job, preset, inputFilepath, outputDir, path)
+			return gateErr
+		})
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to check quality gate")
+			return err
+		}
+		if gate != nil && gate.Status == constant.QualityGateStatusFailed {
+			zerolog.Ctx(ctx).Warn().Interface("renditions", gate.Renditions).Msg("package failed its quality gate")
+			return errors.Join(ErrNonRetryable, ErrQualityFailed)
+		}
+	}
+
+	// Last before the job completes and its source is let go of, everything
+	// put in the package is checked to be there.
+	stage = constant.ErrorClassVerify
+	err = traceStage(ctx, "verify_manifest", func(ctx context.Context) error {
+		return verifyPackage(ctx, s.store, s.cfg.MinIOBucket, path, manifest)
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("uploaded package doesn't match its manifest")
+		return err
+	}
+
+	// The newest package of an input is the one kept longest.
+	if reuseKey != "" && s.cfg.Dedup.Enabled {
+		output := &entities.TranscodeOutput{
+			OutputKey:     reuseKey,
+			JobId:         job.ID,
+			LessonId:      job.EntityId,
+			SourceSHA256:  sourceHash,
+			Preset:        preset.Name,
+			PresetVersion: preset.Version,
+			PackagePath:   path,
+			Bytes:         uploaded,
+		}
+		if saveErr := s.outputs.SaveOutput(ctx, output); saveErr != nil {
+			zerolog.Ctx(ctx).Warn().Err(saveErr).Msg("failed to record output for reuse")
+		}
+	}
+
+	// An HLS source is the playlist of the version just replaced, which is
+	// retained with it.
+	// The source of a trimmed or edited job is kept as its original.
+	if trim != nil || edit != nil {
+		err = traceStage(ctx, "keep_original", func(ctx context.Context) error {
+			original, keepErr := keepOriginal(ctx, s.store, s.cfg, message.ObjectPath)
+			if keepErr == nil {
+				zerolog.Ctx(ctx).Info().Str("original", original).Msg("untrimmed original kept")
+			}
+			return keepErr
+		})
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to keep untrimmed original")
+			return err
+		}
+	} else if !isHLSSource(message.ObjectPath) {
+		zerolog.Ctx(ctx).Info().Msg("deleting original file")
+		err = traceStage(ctx, "delete_source", func(ctx context.Context) error {
+			return s.store.RemoveObject(ctx, s.cfg.MinIOBucket, message.ObjectPath, minio.RemoveObjectOptions{})
+		})
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to delete original file")
+			return err
+		}
+	}
+
+	stage = constant.ErrorClassDatabase
+	var outbox []*entities.OutboxMessage
+	usage, err := s.billing.Usage(job, event, sourceDuration, reused != nil)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to make usage record")
+		return err
+	}
+	if usage != nil {
+		outbox = append(outbox, usage)
+	}
+	result, err := s.results.Result(ctx, job, preset, event, path, sourceDuration, resultQC(source, event, verified), reused != nil)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to make transcode result")
+		return err
+	}
+	if result != nil {
+		outbox = append(outbox, result)
+	}
+	if err = s.repo.CompleteJob(ctx, message.JobId, outbox...); err != nil {
 		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to update job status")
 		return err
 	}
 
-	if err = s.repo.UpdateLessonVideoURL(ctx, job.EntityId, filepath.Join(path, "master.m3u8")); err != nil {
+	if err = s.versions.Publish(ctx, job, filepath.Join(path, "master.m3u8")); err != nil {
 		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to update lesson video url")
 		return err
 	}
 
 	zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("job completed")
+	recordEvent(ctx, constant.JobEventOutput, "", entities.EventData{
+		"playlist":       filepath.Join(path, "master.m3u8"),
+		"renditions":     preset.Renditions,
+		"bytes":          uploaded,
+		"preset":         preset.Name,
+		"preset_version": preset.Version,
+		"canary":         preset.CanaryPercent > 0,
+		"video_codec":    preset.VideoCodec,
+		"source_seconds": sourceDuration,
+	})
+
+	// A package copied from an identical job's wasn't encoded again.
+	if reused == nil {
+		if quotaErr := s.quotas.Record(ctx, job, sourceDuration); quotaErr != nil {
+			zerolog.Ctx(ctx).Warn().Err(quotaErr).Msg("failed to record encoding usage")
+		}
+	}
+
+	// The stages of a completed job are extras, logged when they fail.
+	published := stageJob()
+	_ = s.stages.run(ctx, PhasePublish, published)
+	if followUps = append(followUps, published.FollowUps...); len(followUps) > 0 {
+		followUp(ctx, s.repo, s.regenerations, s.publisher, job, followUps)
+	}
+
+	if courseErr := s.courses.Check(ctx, job.EntityId); courseErr != nil {
+		zerolog.Ctx(ctx).Warn().Err(courseErr).Msg("failed to check course readiness")
+	}
+
+	if notifyErr := s.notifications.VideoReady(ctx, job, preset); notifyErr != nil {
+		zerolog.Ctx(ctx).Warn().Err(notifyErr).Msg("failed to send video ready notification")
+	}
 
 	return nil
 }
 
-func uploadDirectory(ctx context.Context, client *minio.Client, bucket, localPath, remotePrefix string) error {
-	return filepath.Walk(localPath, func(path string, info os.FileInfo, err error) error {
+// failureOutput returns the tail of ffmpeg's output when err came from ffmpeg.
+func failureOutput(err error) string {
+	var ffmpegErr *FFmpegError
+	if errors.As(err, &ffmpegErr) {
+		return reporting.Tail(ffmpegErr.Output, 4096)
+	}
+	return ""
+}
+
+// recordOutcomeEvents closes the job's timeline with the error, if any, and
+// the status the job is about to move to.
+func recordOutcomeEvents(ctx context.Context, stage constant.ErrorClass, err error) {
+	switch {
+	case err == nil:
+		recordStatus(ctx, constant.JobStatusProcessing, constant.JobStatusCompleted)
+	case errors.Is(err, ErrQualityFailed):
+		recordEvent(ctx, constant.JobEventError, string(stage), entities.EventData{"message": err.Error()})
+		recordStatus(ctx, constant.JobStatusProcessing, constant.JobStatusQualityFailed)
+	case errors.Is(err, ErrNonRetryable):
+		recordEvent(ctx, constant.JobEventError, string(stage), entities.EventData{"message": err.Error(), "kind": errorKind(err), "output": failureOutput(err)})
+		recordStatus(ctx, constant.JobStatusProcessing, constant.JobStatusFailed)
+	default:
+		recordEvent(ctx, constant.JobEventError, string(stage), entities.EventData{"message": err.Error(), "kind": errorKind(err), "retryable": true})
+		recordStatus(ctx, constant.JobStatusProcessing, constant.JobStatusPending)
+	}
+}
+
+// recordOutcome counts a finished attempt, logs a failed one with its kind
+// and feeds the failure alerts. Retryable errors are counted as retries so
+// job_errors_total only reflects jobs that actually failed.
+func recordOutcome(ctx context.Context, job *entities.Job, stage constant.ErrorClass, err error) {
+	jobType := string(job.JobType)
+	kind := errorKind(err)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).
+			Str("stage", string(stage)).
+			Str("error_kind", string(kind)).
+			Bool("retryable", !errors.Is(err, ErrNonRetryable)).
+			Msg("job attempt failed")
+	}
+	switch {
+	case err == nil:
+		metrics.JobsTotal.WithLabelValues(jobType, string(constant.JobStatusCompleted)).Inc()
+		alerting.RecordOutcome(ctx, false)
+	case errors.Is(err, ErrNonRetryable):
+		metrics.JobsTotal.WithLabelValues(jobType, string(constant.JobStatusFailed)).Inc()
+		metrics.JobErrorsTotal.WithLabelValues(jobType, string(stage), string(kind)).Inc()
+		alerting.RecordOutcome(ctx, true)
+		alerting.JobFailed(ctx, alerting.Failure{
+			JobId:    job.ID.String(),
+			JobType:  jobType,
+			Stage:    string(stage),
+			Priority: job.Priority,
+			Message:  err.Error(),
+		})
+	default:
+		metrics.JobsTotal.WithLabelValues(jobType, "RETRY").Inc()
+		metrics.JobRetriesTotal.WithLabelValues(jobType, string(kind)).Inc()
+	}
+}
+
+// observeSource records the size and media duration of a checked source.
+func observeSource(source *SourceCheck) {
+	metrics.SourceSize.Observe(float64(source.SizeBytes))
+	if source.DurationSeconds > 0 {
+		metrics.SourceDuration.Observe(source.DurationSeconds)
+	}
+}
+
+func observeThroughput(ctx context.Context, bytes int64, elapsed time.Duration) {
+	if bytes > 0 && elapsed > 0 {
+		metrics.Observe(ctx, metrics.UploadThroughput, float64(bytes)/elapsed.Seconds())
+	}
+}
+
+// uploadDirectory uploads every file under localPath and returns the number
+// of bytes written.
+func uploadDirectory(ctx context.Context, client objectstore.Store, bucket, localPath, remotePrefix string) (int64, error) {
+	var uploaded int64
+	err := filepath.Walk(localPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -143,14 +1059,62 @@ func uploadDirectory(ctx context.Context, client *minio.Client, bucket, localPat
 
 		objectName = strings.ReplaceAll(objectName, "\\", "/")
 
-		_, uploadErr := client.FPutObject(ctx, bucket, objectName, path, minio.PutObjectOptions{})
+		object, uploadErr := client.FPutObject(ctx, bucket, objectName, path, minio.PutObjectOptions{})
+		uploaded += object.Size
 		return uploadErr
 	})
+	return uploaded, err
+}
+
+// progressStore is the events buffer when it batches writes, so progress
+// goes out with them, and the job repository otherwise. With a status cache,
+// progress is also mirrored there as it happens.
+func (s *service) progressStore() progressStore {
+	var store progressStore = s.repo
+	if buffer, ok := s.events.(repository.WriteBuffer); ok {
+		store = buffer
+	}
+	if cache, ok := s.repo.(repository.JobStatusCache); ok {
+		return &cachedProgress{
+			progressStore: store,
+			cache:         cache,
+			interval:      time.Duration(s.cfg.Cache.ProgressInterval) * time.Millisecond,
+		}
+	}
+	return store
 }
 
-func NewService(repo repository.JobRepository, cfg *config.Config) Service {
-	return &service{
-		repo: repo,
-		cfg:  cfg,
+func NewService(repo repository.JobRepository, events repository.JobEventRepository, locks repository.LockRepository, outputs repository.OutputRepository, regenerations repository.RegenerationRepository, snapshots repository.JobSnapshotRepository, presets PresetService, notifications NotificationService, analytics AnalyticsService, chapters ChapterService, downloads DownloadService, courses CourseService, versions VideoVersionService, branding BrandingService, accessibility AccessibilityService, quality QualityService, qc QCService, publishing PublishingService, drives DriveService, scans ScanService, tenants TenantService, quotas QuotaService, billing BillingService, results ResultService, diarization DiarizationService, publisher rabbitmq.Publisher, store objectstore.Store, cfg *config.Config) Service {
+	s := &service{
+		repo:          repo,
+		events:        events,
+		locks:         locks,
+		outputs:       outputs,
+		regenerations: regenerations,
+		snapshots:     snapshots,
+		publisher:     publisher,
+		presets:       presets,
+		notifications: notifications,
+		analytics:     analytics,
+		chapters:      chapters,
+		downloads:     downloads,
+		courses:       courses,
+		versions:      versions,
+		branding:      branding,
+		accessibility: accessibility,
+		quality:       quality,
+		publishing:    publishing,
+		drives:        drives,
+		scans:         scans,
+		tenants:       tenants,
+		quotas:        quotas,
+		billing:       billing,
+		results:       results,
+		diarization:   diarization,
+		qc:            qc,
+		store:         store,
+		cfg:           cfg,
 	}
+	s.stages = s.builtinStages()
+	return s
 }
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/correlation"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+)
+
+// simulatePrefix keeps synthetic sources and their output apart from lesson
+// data, so cleanup never mistakes one for the other.
+const simulatePrefix = "simulate/"
+
+var simulateLanes = map[string]rabbitmq.Topology{
+	"default":  rabbitmq.TranscodeTopology,
+	"priority": rabbitmq.PriorityTranscodeTopology,
+	"backfill": rabbitmq.BackfillTranscodeTopology,
+	"long":     rabbitmq.LongTranscodeTopology,
+	"express":  rabbitmq.ExpressTranscodeTopology,
+	// The SLA lanes, for checking one class isn't starved by another.
+	"enterprise": rabbitmq.EnterpriseTranscodeTopology,
+	"free":       rabbitmq.FreeTranscodeTopology,
+}
+
+type SimulateService interface {
+	// Run creates request.Count jobs and publishes them at the requested
+	// rate, stopping early when ctx is cancelled.
+	Run(ctx context.Context, request dto.SimulateRequest) (*dto.SimulateResult, error)
+}
+
+type simulateService struct {
+	repo      repository.JobRepository
+	publisher rabbitmq.Publisher
+	store     objectstore.Store
+	cfg       *config.Config
+}
+
+func (s *simulateService) Run(ctx context.Context, request dto.SimulateRequest) (*dto.SimulateResult, error) {
+	if request.Lane == "" {
+		request.Lane = "default"
+	}
+	topology, ok := simulateLanes[request.Lane]
+	if !ok {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("unknown lane %q", request.Lane))
+	}
+	if request.Count <= 0 || request.RatePerSecond <= 0 {
+		return nil, errors.Join(ErrInvalidArgument, errors.New("count and rate must be positive"))
+	}
+	if _, err := s.store.StatObject(ctx, s.cfg.MinIOBucket, request.Seed, minio.StatObjectOptions{}); err != nil {
+		return nil, fmt.Errorf("seed object %s: %w", request.Seed, err)
+	}
+
+	result := &dto.SimulateResult{RunId: time.Now().UTC().Format("20060102T150405"), JobIds: []uuid.UUID{}}
+	// Tagging every job with the run lets its rows be found and removed later.
+	ctx = correlation.WithID(ctx, "simulate-"+result.RunId)
+	zerolog.Ctx(ctx).Info().Int("count", request.Count).Float64("rate_per_second", request.RatePerSecond).Str("lane", request.Lane).Msg("simulation started")
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / request.RatePerSecond))
+	defer ticker.Stop()
+
+	for i := 0; i < request.Count; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return result, ctx.Err()
+			case <-ticker.C:
+			}
+		}
+
+		jobId, err := s.publish(ctx, result.RunId, request, topology)
+		if err != nil {
+			return result, err
+		}
+		result.Published++
+		result.JobIds = append(result.JobIds, jobId)
+	}
+
+	zerolog.Ctx(ctx).Info().Int("published", result.Published).Msg("simulation finished publishing")
+	return result, nil
+}
+
+// publish copies the seed for one job, since the worker deletes a job's
+// source once it is done, then creates and queues the job.
+func (s *simulateService) publish(ctx context.Context, runId string, request dto.SimulateRequest, topology rabbitmq.Topology) (uuid.UUID, error) {
+	correlationId := correlation.FromContext(ctx)
+	job := &entities.Job{
+		ID:            uuid.New(),
+		EntityId:      uuid.New(),
+		EntityType:    string(constant.EntityTypeLessonVideo),
+		Status:        constant.JobStatusPending,
+		JobType:       constant.JobTypeTranscoder,
+		CorrelationId: &correlationId,
+	}
+
+	source := path.Join(simulatePrefix, runId, job.ID.String(), path.Base(request.Seed))
+	_, err := s.store.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: s.cfg.MinIOBucket, Object: source},
+		minio.CopySrcOptions{Bucket: s.cfg.MinIOBucket, Object: request.Seed})
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("copy seed: %w", err)
+	}
+
+	if err := s.repo.CreateJob(ctx, job); err != nil {
+		return uuid.Nil, err
+	}
+	message := dto.JobMessage{
+		JobId:      job.ID,
+		ObjectPath: source,
+		FileName:   path.Base(source),
+		Preset:     request.Preset,
+	}
+	if err := s.publisher.Publish(ctx, topology.Exchange, topology.RoutingKey, message); err != nil {
+		return uuid.Nil, err
+	}
+	return job.ID, nil
+}
+
+func NewSimulateService(repo repository.JobRepository, publisher rabbitmq.Publisher, store objectstore.Store, cfg *config.Config) SimulateService {
+	return &simulateService{
+		repo:      repo,
+		publisher: publisher,
+		store:     store,
+		cfg:       cfg,
+	}
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/pkg/rabbitmq"
+)
+
+// transcodeTopology is the lane transcodes of an SLA class are queued on.
+func transcodeTopology(class constant.SLAClass) rabbitmq.Topology {
+	switch class {
+	case constant.SLAClassEnterprise:
+		return rabbitmq.EnterpriseTranscodeTopology
+	case constant.SLAClassFree:
+		return rabbitmq.FreeTranscodeTopology
+	default:
+		return rabbitmq.TranscodeTopology
+	}
+}
+
+// shardTopology queues a source of at least LongJobSeconds on the long lane,
+// one shorter than ExpressJobSeconds on the express lane, whatever its
+// class, and anything between, or never probed, on its class's lane.
+func shardTopology(cfg *config.Config, class constant.SLAClass, seconds *float64) rabbitmq.Topology {
+	switch {
+	case seconds == nil:
+	case cfg.Server.LongJobSeconds > 0 && *seconds >= float64(cfg.Server.LongJobSeconds):
+		return rabbitmq.LongTranscodeTopology
+	case cfg.Server.ExpressJobSeconds > 0 && *seconds < float64(cfg.Server.ExpressJobSeconds):
+		return rabbitmq.ExpressTranscodeTopology
+	}
+	return transcodeTopology(class)
+}
+
+// jobSLAClass is the class a job was created with; older jobs are pro.
+func jobSLAClass(class *constant.SLAClass) constant.SLAClass {
+	if class == nil {
+		return constant.SLAClassPro
+	}
+	return *class
+}
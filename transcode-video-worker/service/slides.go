@@ -0,0 +1,266 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image/jpeg"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"worker-transcode/config"
+	"worker-transcode/pkg/ffmpeg"
+	"worker-transcode/pkg/pdf"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	slidesDir     = "slides"
+	slidesDeck    = "slides.pdf"
+	slidesSidecar = "slides.json"
+)
+
+// slide is a distinct slide of a screen recording and where it first shows.
+type slide struct {
+	start float64
+	image string
+	hash  uint64
+}
+
+// extractSlides finds the slide transitions of a screen recording and writes
+// one image per distinct slide under outputDir/slides, a PDF deck of them
+// captioned with their timestamps, and a slides.json sidecar listing them, so
+// they are uploaded with the package. A frame close to the slide before it,
+// such as the next bullet coming in, replaces that slide's image so the deck
+// keeps the finished slide; one close to an earlier slide is the lecture going
+// back to it and is dropped. It returns the number of slides.
+func extractSlides(ctx context.Context, inputFilepath, outputDir string, cfg config.Slides) (int, error) {
+	framesDir := filepath.Join(outputDir, slidesDir)
+	if err := os.MkdirAll(framesDir, os.ModePerm); err != nil {
+		return 0, err
+	}
+	starts, err := captureTransitions(ctx, inputFilepath, framesDir, cfg)
+	if err != nil {
+		return 0, err
+	}
+
+	var slides []*slide
+	for i, start := range starts {
+		frame := filepath.Join(framesDir, fmt.Sprintf("frame_%04d.jpg", i+1))
+		hash, err := frameHash(frame)
+		if err != nil {
+			return 0, err
+		}
+
+		duplicate := -1
+		for j, kept := range slides {
+			if bits.OnesCount64(kept.hash^hash) <= cfg.DedupDistance {
+				duplicate = j
+				break
+			}
+		}
+		switch {
+		case duplicate < 0 && len(slides) < max(cfg.MaxSlides, 1):
+			slides = append(slides, &slide{start: start, image: frame, hash: hash})
+			continue
+		case duplicate >= 0 && duplicate == len(slides)-1:
+			previous := slides[duplicate]
+			if err := os.Rename(frame, previous.image); err != nil {
+				return 0, err
+			}
+			previous.hash = hash
+			continue
+		}
+		if err := os.Remove(frame); err != nil {
+			return 0, err
+		}
+	}
+
+	// Kept frames are renamed in order so the keys read as the deck does.
+	for i, kept := range slides {
+		name := filepath.Join(framesDir, fmt.Sprintf("slide_%03d.jpg", i+1))
+		if err := os.Rename(kept.image, name); err != nil {
+			return 0, err
+		}
+		kept.image = name
+	}
+	if len(slides) == 0 {
+		return 0, os.Remove(framesDir)
+	}
+
+	if err := writeSlideDeck(filepath.Join(outputDir, slidesDeck), slides); err != nil {
+		return 0, err
+	}
+	return len(slides), writeSlidesSidecar(outputDir, slides)
+}
+
+// captureTransitions writes the first frame and every frame the scene changes
+// on as frame_NNNN.jpg under dir, and returns their times in order.
+func captureTransitions(ctx context.Context, inputFilepath, dir string, cfg config.Slides) ([]float64, error) {
+	args := []string{"-hide_banner", "-nostats", "-i", inputFilepath,
+		"-map", "0:v:0",
+		"-vf", fmt.Sprintf("select='eq(n\\,0)+gt(scene\\,%g)',showinfo", cfg.SceneThreshold),
+		"-vsync", "vfr",
+		"-q:v", "3",
+		filepath.Join(dir, "frame_%04d.jpg"),
+	}
+
+	cmd := ffmpeg.Command(ctx, args...)
+	killProcessGroup(cmd)
+	cmd.WaitDelay = ffmpegWaitDelay
+	zerolog.Ctx(ctx).Info().Str("command", strings.Join(cmd.Args, " ")).Msg("executing FFmpeg command")
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, &FFmpegError{Err: err}
+	}
+	done := trackFFmpeg()
+	defer done()
+
+	var (
+		starts []float64
+		tail   = &tailBuffer{limit: maxFFmpegOutput}
+	)
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		line := scanner.Text()
+		tail.Write([]byte(line + "\n"))
+		if !strings.Contains(line, "Parsed_showinfo") {
+			continue
+		}
+		if match := sceneTimePattern.FindStringSubmatch(line); match != nil {
+			at, _ := strconv.ParseFloat(match[1], 64)
+			starts = append(starts, at)
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, &FFmpegError{Err: err, Output: tail.String()}
+	}
+	return starts, nil
+}
+
+// frameHash is the difference hash of a frame: 64 bits telling whether each
+// cell of a 9x8 grid is brighter than the one to its right. Cursor moves and
+// compression noise barely change it, a new slide changes many bits.
+func frameHash(name string) (uint64, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	img, err := jpeg.Decode(file)
+	if err != nil {
+		return 0, fmt.Errorf("decode frame %s: %w", name, err)
+	}
+
+	const cols, rows, samples = 9, 8, 6
+	bounds := img.Bounds()
+	var grid [rows][cols]float64
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols; x++ {
+			var sum float64
+			for sy := 0; sy < samples; sy++ {
+				for sx := 0; sx < samples; sx++ {
+					px := bounds.Min.X + (x*samples+sx)*bounds.Dx()/(cols*samples)
+					py := bounds.Min.Y + (y*samples+sy)*bounds.Dy()/(rows*samples)
+					r, g, b, _ := img.At(px, py).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+				}
+			}
+			grid[y][x] = sum
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols-1; x++ {
+			hash <<= 1
+			if grid[y][x] > grid[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash, nil
+}
+
+func writeSlideDeck(name string, slides []*slide) error {
+	pages := make([]pdf.Page, 0, len(slides))
+	for i, kept := range slides {
+		raw, err := os.ReadFile(kept.image)
+		if err != nil {
+			return err
+		}
+		size, err := jpeg.DecodeConfig(bytes.NewReader(raw))
+		if err != nil {
+			return fmt.Errorf("decode slide %s: %w", kept.image, err)
+		}
+		pages = append(pages, pdf.Page{
+			JPEG:    raw,
+			Width:   size.Width,
+			Height:  size.Height,
+			Caption: fmt.Sprintf("Slide %d - %s", i+1, clockTime(kept.start)),
+		})
+	}
+
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := pdf.Write(file, pages); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// writeSlidesSidecar lists the slides with where each starts, so the player
+// can jump from a slide to its place in the video.
+func writeSlidesSidecar(outputDir string, slides []*slide) error {
+	type sidecarSlide struct {
+		Slide int     `json:"slide"`
+		Start float64 `json:"start"`
+		Image string  `json:"image"`
+	}
+	sidecar := struct {
+		Deck   string         `json:"deck"`
+		Slides []sidecarSlide `json:"slides"`
+	}{Deck: slidesDeck}
+	for i, kept := range slides {
+		sidecar.Slides = append(sidecar.Slides, sidecarSlide{
+			Slide: i + 1,
+			Start: kept.start,
+			Image: slidesDir + "/" + filepath.Base(kept.image),
+		})
+	}
+
+	raw, err := json.MarshalIndent(sidecar, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(outputDir, slidesSidecar), raw, 0644)
+}
+
+// discardSlides removes whatever a failed extraction left in the output, so
+// the package is uploaded without a partial deck.
+func discardSlides(outputDir string) {
+	os.RemoveAll(filepath.Join(outputDir, slidesDir))
+	os.Remove(filepath.Join(outputDir, slidesDeck))
+	os.Remove(filepath.Join(outputDir, slidesSidecar))
+}
+
+// clockTime formats seconds as h:mm:ss, or m:ss under an hour.
+func clockTime(seconds float64) string {
+	total := int(seconds)
+	if total >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", total/3600, total/60%60, total%60)
+	}
+	return fmt.Sprintf("%d:%02d", total/60, total%60)
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"context"
+	"time"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/metrics"
+	"worker-transcode/pkg/pipeline"
+	"worker-transcode/pkg/tracing"
+)
+
+// StagePhase is the point of a transcode job a registered stage runs at.
+type StagePhase = pipeline.Phase
+
+const (
+	// PhaseProbe runs once the source is downloaded and checked.
+	PhaseProbe StagePhase = "probe"
+	// PhasePreprocess runs once the source is composited, edited, trimmed
+	// and branded, before its chapters are stored. Its stages may replace
+	// the input and audio files.
+	PhasePreprocess StagePhase = "preprocess"
+	// PhaseEncode runs once the renditions are encoded.
+	PhaseEncode StagePhase = "encode"
+	// PhasePackage runs once the master playlist is written, before the
+	// package is uploaded.
+	PhasePackage StagePhase = "package"
+	// PhaseUpload runs once the package is uploaded.
+	PhaseUpload StagePhase = "upload"
+	// PhaseQC runs on the uploaded package, before its quality gate.
+	PhaseQC StagePhase = "qc"
+	// PhasePublish runs once the job is completed and its version published,
+	// so its stages never fail the job.
+	PhasePublish StagePhase = "publish"
+)
+
+// Stage is one step of a transcode job run at its phase. Stages register
+// with the service rather than being written into Process, so one like DRM
+// or translation is added in a file of its own.
+type Stage = pipeline.Stage[*StageJob]
+
+// StageJob is what a stage is given of the job it runs in. The encode and
+// package phases only run for a job that was encoded; a job whose package
+// was copied from an identical one's is Reused and has no OutputDir.
+type StageJob struct {
+	Job           *entities.Job
+	Message       dto.JobMessage
+	Preset        *entities.Preset
+	Source        *SourceCheck
+	InputFilepath string
+	AudioFilepath string
+	Dubs          []dubbedAudio
+	Chapters      []*entities.Chapter
+	SourceSeconds float64
+	OutputDir     string
+	PackagePath   string
+	Reused        bool
+	// Verified is the report of the package's verification, set by the
+	// verify stage.
+	Verified *VerifyReport
+	// FollowUps are the artifacts the phase's stages left to follow-up jobs.
+	FollowUps []constant.RegenerationArtifact
+	// Experiment tags the job with the arm of the preset experiment it's
+	// in, if any.
+	Experiment *entities.ExperimentJob
+}
+
+// hasVideo reports whether the job's source has a video stream and a length.
+func (j *StageJob) hasVideo() bool {
+	return j.Source != nil && j.Source.Media != nil && j.Source.Media.VideoStream() != nil && j.SourceSeconds > 0
+}
+
+// stagePolicy is how a registered stage runs. enabled is whether it runs
+// for tenants that don't set its feature, or for every tenant when it has
+// none; applies, when set, is whether the job has anything for it to do.
+// An optional stage's failure is logged and the job goes on without it,
+// after discard removes whatever it left behind. followUp, when set, is the
+// regeneration artifact that makes what the stage does from the published
+// package, which the stage is left to when follow-ups are on.
+type stagePolicy struct {
+	feature  constant.TenantFeature
+	enabled  bool
+	applies  func(job *StageJob) bool
+	optional bool
+	discard  func(job *StageJob)
+	followUp constant.RegenerationArtifact
+}
+
+type registeredStage = pipeline.Registered[*StageJob, stagePolicy]
+
+// stageFunc is a Stage made of a function, for stages that need no type of
+// their own.
+type stageFunc struct {
+	name  string
+	phase StagePhase
+	run   func(ctx context.Context, job *StageJob) error
+}
+
+func (s stageFunc) Name() string      { return s.name }
+func (s stageFunc) Phase() StagePhase { return s.phase }
+func (s stageFunc) Run(ctx context.Context, job *StageJob) error {
+	return s.run(ctx, job)
+}
+
+// stageRunner runs one registered stage on a job.
+type stageRunner = pipeline.Runner[*StageJob]
+
+// stageMiddleware wraps the run of every registered stage, outermost first.
+type stageMiddleware = pipeline.Middleware[*StageJob, stagePolicy]
+
+// stageRegistry holds the registered stages of each phase, in the order
+// they were registered, and the middleware each is run through. Publish
+// stages run once the job's outcome is settled, so none fails it.
+type stageRegistry struct {
+	*pipeline.Registry[*StageJob, stagePolicy]
+}
+
+func newStageRegistry(middleware ...stageMiddleware) *stageRegistry {
+	registry := pipeline.New(middleware...)
+	registry.Tolerate(PhasePublish)
+	return &stageRegistry{Registry: registry}
+}
+
+func (r *stageRegistry) register(stage Stage, policy stagePolicy) {
+	r.Register(stage, pipeline.Policy[*StageJob]{Applies: policy.applies, Optional: policy.optional, Discard: policy.discard}, policy)
+}
+
+// run runs the phase's stages on job in turn, and stops at the first
+// required one that fails.
+func (r *stageRegistry) run(ctx context.Context, phase StagePhase, job *StageJob) error {
+	return r.Run(ctx, phase, job)
+}
+
+// tenantPolicyMiddleware skips a stage its job's tenant has switched off,
+// or that is off and the tenant hasn't switched on.
+func tenantPolicyMiddleware(stage *registeredStage, next stageRunner) stageRunner {
+	return func(ctx context.Context, job *StageJob) error {
+		if stage.Extra.feature == "" && !stage.Extra.enabled {
+			return nil
+		}
+		if stage.Extra.feature != "" && !featureEnabled(ctx, stage.Extra.feature, stage.Extra.enabled) {
+			return nil
+		}
+		return next(ctx, job)
+	}
+}
+
+// followUpMiddleware leaves a stage with a follow-up to it, when follow-ups
+// are on, rather than running it.
+func followUpMiddleware(enabled bool) stageMiddleware {
+	return func(stage *registeredStage, next stageRunner) stageRunner {
+		return func(ctx context.Context, job *StageJob) error {
+			if !enabled || stage.Extra.followUp == "" {
+				return next(ctx, job)
+			}
+			job.FollowUps = append(job.FollowUps, stage.Extra.followUp)
+			return nil
+		}
+	}
+}
+
+// tracingMiddleware runs a stage inside its own span.
+func tracingMiddleware(stage *registeredStage, next stageRunner) stageRunner {
+	return func(ctx context.Context, job *StageJob) error {
+		return traceSpan(ctx, stage.Name(), func(ctx context.Context) error {
+			return next(ctx, job)
+		})
+	}
+}
+
+// timingMiddleware records how long a stage took.
+func timingMiddleware(stage *registeredStage, next stageRunner) stageRunner {
+	return func(ctx context.Context, job *StageJob) error {
+		return timeStage(ctx, stage.Name(), func(ctx context.Context) error {
+			return next(ctx, job)
+		})
+	}
+}
+
+// traceStage runs one pipeline stage inside its own span and records how long
+// it took.
+func traceStage(ctx context.Context, name string, stage func(ctx context.Context) error) error {
+	return traceSpan(ctx, name, func(ctx context.Context) error {
+		return timeStage(ctx, name, stage)
+	})
+}
+
+func traceSpan(ctx context.Context, name string, stage func(ctx context.Context) error) error {
+	ctx, span := tracer.Start(ctx, name)
+	err := stage(ctx)
+	tracing.End(span, err)
+	return err
+}
+
+// timeStage records the stage's duration in its metric, the job's timings
+// and the job's timeline, and reports it in the job's heartbeats while it
+// runs.
+func timeStage(ctx context.Context, name string, stage func(ctx context.Context) error) error {
+	defer enterStage(ctx, name)()
+	start := time.Now()
+	err := stage(ctx)
+	elapsed := time.Since(start).Seconds()
+	metrics.Observe(ctx, metrics.StageDuration.WithLabelValues(name), elapsed)
+	addStageTiming(ctx, name, elapsed)
+
+	data := entities.EventData{"seconds": elapsed}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	recordEvent(ctx, constant.JobEventStage, name, data)
+	return err
+}
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"worker-transcode/constant"
+
+	"github.com/rs/zerolog"
+)
+
+// builtinStages registers the stages every worker runs. Stages of a phase
+// run in the order they're registered here.
+func (s *service) builtinStages() *stageRegistry {
+	stages := newStageRegistry(tenantPolicyMiddleware, followUpMiddleware(s.cfg.Server.FollowUps), tracingMiddleware, timingMiddleware)
+
+	// Fingerprinting goes on the upload as it came, before branding puts the
+	// same intro on every lesson of the tenant. A failure leaves the lesson
+	// unflagged rather than holding its video back.
+	stages.register(stageFunc{name: "fingerprint", phase: PhaseProbe, run: func(ctx context.Context, job *StageJob) error {
+		return s.qc.CheckDuplicates(ctx, job.Job, job.InputFilepath, job.AudioFilepath, job.Source.Media, job.SourceSeconds)
+	}}, stagePolicy{
+		enabled:  s.cfg.Fingerprint.Enabled,
+		applies:  func(job *StageJob) bool { return !isHLSSource(job.Message.ObjectPath) && job.Source.Media != nil },
+		optional: true,
+	})
+
+	// Audio that can't be remixed is encoded with the layout it has.
+	stages.register(stageFunc{name: "downmix", phase: PhasePreprocess, run: func(ctx context.Context, job *StageJob) error {
+		source := job.InputFilepath
+		if job.AudioFilepath != "" {
+			source = job.AudioFilepath
+		}
+		media, err := ProbeMedia(ctx, source)
+		if err != nil {
+			return err
+		}
+		if audio := media.AudioStream(); needsDownmix(audio) {
+			remixed, err := downmixAudio(ctx, source, audio, filepath.Dir(job.InputFilepath), s.cfg.Downmix)
+			if err != nil {
+				return err
+			}
+			job.AudioFilepath = remixed
+		}
+		return nil
+	}}, stagePolicy{
+		enabled:  s.cfg.Downmix.Enabled,
+		optional: true,
+		discard:  func(job *StageJob) { os.Remove(filepath.Join(filepath.Dir(job.InputFilepath), downmixFile)) },
+	})
+
+	// Students still get the video when the deck can't be made.
+	stages.register(stageFunc{name: "slides", phase: PhasePackage, run: func(ctx context.Context, job *StageJob) error {
+		count, err := extractSlides(ctx, job.InputFilepath, job.OutputDir, s.cfg.Slides)
+		if err == nil {
+			zerolog.Ctx(ctx).Info().Int("slides", count).Msg("slides extracted")
+		}
+		return err
+	}}, stagePolicy{
+		feature:  constant.TenantFeatureSlides,
+		enabled:  s.cfg.Slides.Enabled,
+		applies:  func(job *StageJob) bool { return job.Message.ScreenRecording },
+		optional: true,
+		discard:  func(job *StageJob) { discardSlides(job.OutputDir) },
+		followUp: constant.RegenerationSlides,
+	})
+
+	// A card without a preview shows its still instead.
+	stages.register(stageFunc{name: "preview", phase: PhasePackage, run: func(ctx context.Context, job *StageJob) error {
+		name, err := renderPreview(ctx, job.InputFilepath, job.OutputDir, job.SourceSeconds, s.cfg.Preview)
+		if err == nil {
+			zerolog.Ctx(ctx).Info().Str("preview", name).Msg("animated preview made")
+		}
+		return err
+	}}, stagePolicy{
+		feature:  constant.TenantFeaturePreview,
+		enabled:  s.cfg.Preview.Enabled,
+		applies:  (*StageJob).hasVideo,
+		optional: true,
+		followUp: constant.RegenerationPreview,
+	})
+
+	// A lesson without a poster shows the player's first frame.
+	stages.register(stageFunc{name: "poster", phase: PhasePackage, run: func(ctx context.Context, job *StageJob) error {
+		count, err := pickPosters(ctx, job.InputFilepath, job.OutputDir, job.SourceSeconds, s.cfg.Poster)
+		if err == nil {
+			zerolog.Ctx(ctx).Info().Int("candidates", count).Msg("poster picked")
+		}
+		return err
+	}}, stagePolicy{
+		feature:  constant.TenantFeaturePoster,
+		enabled:  s.cfg.Poster.Enabled,
+		applies:  (*StageJob).hasVideo,
+		optional: true,
+		discard:  func(job *StageJob) { discardPosters(job.OutputDir) },
+		followUp: constant.RegenerationPosters,
+	})
+
+	// A failed check is retried: the whole package is uploaded again.
+	stages.register(stageFunc{name: "verify", phase: PhaseQC, run: func(ctx context.Context, job *StageJob) error {
+		report, err := VerifyHLS(ctx, s.store, s.cfg.MinIOBucket, filepath.ToSlash(filepath.Join(job.PackagePath, "master.m3u8")), job.SourceSeconds)
+		if err != nil {
+			return err
+		}
+		job.Verified = report
+		addJSONArtifact(ctx, "verify.json", report)
+		if err = report.Err(); err != nil {
+			zerolog.Ctx(ctx).Error().Interface("report", report).Msg("uploaded package failed verification")
+		}
+		return err
+	}}, stagePolicy{enabled: s.cfg.Server.VerifyOutput})
+
+	// The catalog keeps the duration it had until the event goes out.
+	stages.register(stageFunc{name: "media_metadata", phase: PhasePublish, run: func(ctx context.Context, job *StageJob) error {
+		media, err := lessonMedia(job.Job, job.Preset, job.Source.Media, job.OutputDir, job.Dubs, job.SourceSeconds)
+		if err != nil {
+			return err
+		}
+		return s.courses.Media(ctx, media)
+	}}, stagePolicy{enabled: true})
+
+	// Chapters are an extra: a failed detection or announcement leaves the
+	// player without them rather than failing a job whose video is already
+	// published. Instructor chapters take the place of detected ones.
+	stages.register(stageFunc{name: "chapters", phase: PhasePublish, run: func(ctx context.Context, job *StageJob) error {
+		return s.chapters.Announce(ctx, job.Job, job.Chapters)
+	}}, stagePolicy{
+		enabled: true,
+		applies: func(job *StageJob) bool { return len(job.Chapters) > 0 },
+	})
+	stages.register(stageFunc{name: "chapters", phase: PhasePublish, run: func(ctx context.Context, job *StageJob) error {
+		return s.chapters.Detect(ctx, job.Job, job.InputFilepath, job.AudioFilepath, job.SourceSeconds)
+	}}, stagePolicy{
+		enabled:  true,
+		applies:  func(job *StageJob) bool { return len(job.Chapters) == 0 },
+		followUp: constant.RegenerationChapters,
+	})
+
+	// Without the offline rendition the app streams the lesson instead.
+	stages.register(stageFunc{name: "download_rendition", phase: PhasePublish, run: func(ctx context.Context, job *StageJob) error {
+		return s.downloads.Publish(ctx, job.Job, job.InputFilepath, job.AudioFilepath, job.SourceSeconds)
+	}}, stagePolicy{feature: constant.TenantFeatureDownloads, enabled: s.cfg.Download.Enabled})
+
+	// The lesson keeps the report of its previous video until one is saved.
+	stages.register(stageFunc{name: "accessibility", phase: PhasePublish, run: func(ctx context.Context, job *StageJob) error {
+		return s.accessibility.Analyze(ctx, job.Job, job.InputFilepath, job.AudioFilepath, job.Dubs, job.SourceSeconds)
+	}}, stagePolicy{
+		feature:  constant.TenantFeatureAccessibility,
+		enabled:  s.cfg.Accessibility.Enabled,
+		followUp: constant.RegenerationAccessibility,
+	})
+
+	// A package copied from an identical job's was scored with that job.
+	stages.register(stageFunc{name: "quality", phase: PhasePublish, run: func(ctx context.Context, job *StageJob) error {
+		return s.quality.Score(ctx, job.Job, job.Preset, job.InputFilepath, job.OutputDir, job.SourceSeconds)
+	}}, stagePolicy{
+		feature: constant.TenantFeatureQuality,
+		enabled: s.cfg.Quality.Enabled,
+		applies: func(job *StageJob) bool { return !job.Reused },
+	})
+
+	// An experiment's jobs are scored whether or not their tenant's are, for
+	// its arms to be compared.
+	stages.register(stageFunc{name: "experiment_quality", phase: PhasePublish, run: func(ctx context.Context, job *StageJob) error {
+		if featureEnabled(ctx, constant.TenantFeatureQuality, s.cfg.Quality.Enabled) {
+			return nil
+		}
+		return s.quality.Score(ctx, job.Job, job.Preset, job.InputFilepath, job.OutputDir, job.SourceSeconds)
+	}}, stagePolicy{
+		enabled:  true,
+		applies:  func(job *StageJob) bool { return job.Experiment != nil && !job.Reused },
+		optional: true,
+	})
+
+	// A check that fails leaves the lesson unflagged rather than failing a
+	// video that already published.
+	stages.register(stageFunc{name: "music_check", phase: PhasePublish, run: func(ctx context.Context, job *StageJob) error {
+		return s.qc.CheckMusic(ctx, job.Job, job.InputFilepath, job.AudioFilepath, job.SourceSeconds)
+	}}, stagePolicy{
+		feature:  constant.TenantFeatureMusic,
+		enabled:  s.cfg.Music.Enabled,
+		followUp: constant.RegenerationMusic,
+	})
+
+	// Captions go unlabeled when the speakers can't be told apart.
+	stages.register(stageFunc{name: "diarization", phase: PhasePublish, run: func(ctx context.Context, job *StageJob) error {
+		return s.diarization.Diarize(ctx, job.Job, job.InputFilepath, job.AudioFilepath, job.Message.Speakers)
+	}}, stagePolicy{
+		feature: constant.TenantFeatureDiarization,
+		enabled: s.cfg.Diarization.Enabled,
+	})
+
+	stages.register(stageFunc{name: "publish", phase: PhasePublish, run: func(ctx context.Context, job *StageJob) error {
+		return s.publishing.Publish(ctx, job.Job, job.InputFilepath, job.AudioFilepath, job.PackagePath)
+	}}, stagePolicy{enabled: true})
+
+	return stages
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+)
+
+// rungFilePattern matches the files of a rung, named for its height: its
+// playlist, segments, init segment and progressive copy.
+var rungFilePattern = regexp.MustCompile(`^(\d+)p[._]`)
+
+// StorageService keeps what each course's and tenant's packages take up in
+// the bucket. A package is counted when it is published as a lesson's video
+// version and taken off when the version is deleted.
+type StorageService interface {
+	// Record counts the package the job published at playlistKey.
+	Record(ctx context.Context, lessonId, jobId uuid.UUID, playlistKey string) error
+	// Release takes the job's package off the totals once it is deleted.
+	Release(ctx context.Context, jobId uuid.UUID) error
+	Usage(ctx context.Context, scope constant.StorageScope, id uuid.UUID) (*dto.StorageUsage, error)
+	// Backfill counts the versions published before storage was counted,
+	// and returns how many it counted.
+	Backfill(ctx context.Context) (int, error)
+}
+
+type storageService struct {
+	repo  repository.StorageRepository
+	store objectstore.Store
+	cfg   *config.Config
+}
+
+func (s *storageService) Record(ctx context.Context, lessonId, jobId uuid.UUID, playlistKey string) error {
+	byClass := map[constant.StorageClass]*entities.PackageStorage{}
+	prefix := path.Dir(playlistKey) + "/"
+	for object := range s.store.ListObjects(ctx, s.cfg.MinIOBucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return fmt.Errorf("list package: %w", object.Err)
+		}
+		class := storageClass(object.Key)
+		if byClass[class] == nil {
+			byClass[class] = &entities.PackageStorage{JobId: jobId, RenditionClass: class, LessonId: lessonId}
+		}
+		byClass[class].Bytes += object.Size
+		byClass[class].Objects++
+	}
+
+	classes := make([]*entities.PackageStorage, 0, len(byClass))
+	for _, class := range byClass {
+		classes = append(classes, class)
+	}
+	return s.repo.RecordPackage(ctx, classes)
+}
+
+func (s *storageService) Release(ctx context.Context, jobId uuid.UUID) error {
+	return s.repo.ReleasePackage(ctx, jobId)
+}
+
+func (s *storageService) Usage(ctx context.Context, scope constant.StorageScope, id uuid.UUID) (*dto.StorageUsage, error) {
+	rows, err := s.repo.ListUsage(ctx, scope, id)
+	if err != nil {
+		return nil, err
+	}
+	usage := &dto.StorageUsage{Scope: string(scope), Id: id, Classes: []dto.StorageClassUsage{}}
+	for _, row := range rows {
+		if row.Objects == 0 {
+			continue
+		}
+		usage.Bytes += row.Bytes
+		usage.Objects += row.Objects
+		usage.Classes = append(usage.Classes, dto.StorageClassUsage{Class: string(row.RenditionClass), Bytes: row.Bytes, Objects: row.Objects})
+	}
+	return usage, nil
+}
+
+func (s *storageService) Backfill(ctx context.Context) (int, error) {
+	versions, err := s.repo.ListUncountedVersions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for i, version := range versions {
+		if err := s.Record(ctx, version.LessonId, version.JobId, version.PlaylistKey); err != nil {
+			return i, fmt.Errorf("version %s: %w", version.ID, err)
+		}
+		zerolog.Ctx(ctx).Debug().Str("version_id", version.ID.String()).Msg("video version storage counted")
+	}
+	return len(versions), nil
+}
+
+// storageClass is the class a package file is counted in: a rung's files by
+// its height, the audio tracks, and the master playlist, slides, previews
+// and sidecars as other.
+func storageClass(key string) constant.StorageClass {
+	name := path.Base(key)
+	if match := rungFilePattern.FindStringSubmatch(name); match != nil {
+		height, _ := strconv.Atoi(match[1])
+		switch {
+		case height >= 2160:
+			return constant.StorageClassUHD
+		case height >= 1080:
+			return constant.StorageClassFHD
+		case height >= 720:
+			return constant.StorageClassHD
+		default:
+			return constant.StorageClassSD
+		}
+	}
+	if strings.HasPrefix(name, "audio") {
+		return constant.StorageClassAudio
+	}
+	return constant.StorageClassOther
+}
+
+func NewStorageService(repo repository.StorageRepository, store objectstore.Store, cfg *config.Config) StorageService {
+	return &storageService{
+		repo:  repo,
+		store: store,
+		cfg:   cfg,
+	}
+}
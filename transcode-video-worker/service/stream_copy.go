@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/ffmpeg"
+)
+
+// copyCodecs maps the encoders a preset can name to the codec a source must
+// already be in for a rung to be copied rather than encoded.
+var copyCodecs = map[string]string{
+	"libx264":    "h264",
+	"h264_nvenc": "h264",
+}
+
+// copyProfiles are the H.264 profiles the master playlist's CODECS, High at
+// level 4.0, covers. maxCopyLevel is that level as ffprobe reports it.
+var copyProfiles = map[string]bool{
+	"Constrained Baseline": true,
+	"Baseline":             true,
+	"Main":                 true,
+	"High":                 true,
+}
+
+const maxCopyLevel = 40
+
+// keyframeTolerance is how far, in seconds, a source keyframe may sit from
+// the preset's keyframe grid and still count as on it.
+const keyframeTolerance = 0.05
+
+// copyableRung returns the height of the rung of preset the source at
+// inputFilepath already conforms to, so hlsArgs can remux it with -c copy,
+// or 0 when every rung has to be encoded. A rung conforms when the source
+// has its exact size, no more than its bitrate, a codec, profile and level
+// the master playlist declares, and keyframes on the grid the encoded rungs
+// are cut on, so all the renditions' segments line up.
+func copyableRung(ctx context.Context, preset *entities.Preset, inputFilepath string, source *MediaInfo) (int, error) {
+	video := source.VideoStream()
+	if video == nil || video.CodecName != copyCodecs[preset.VideoCodec] {
+		return 0, nil
+	}
+	if !copyProfiles[video.Profile] || video.Level <= 0 || video.Level > maxCopyLevel || video.PixFmt != "yuv420p" {
+		return 0, nil
+	}
+	sourceBitrate, _ := strconv.Atoi(video.BitRate)
+	if sourceBitrate <= 0 {
+		return 0, nil
+	}
+
+	var rung *entities.Rendition
+	for i, r := range preset.Renditions {
+		if r.Width == video.Width && r.Height == video.Height {
+			rung = &preset.Renditions[i]
+			break
+		}
+	}
+	if rung == nil {
+		return 0, nil
+	}
+	var rungBitrate int
+	fmt.Sscanf(rung.Bitrate, "%dk", &rungBitrate)
+	if sourceBitrate > rungBitrate*1000 {
+		return 0, nil
+	}
+
+	interval := float64(preset.KeyframeSeconds)
+	if interval <= 0 {
+		interval = float64(preset.SegmentSeconds)
+	}
+	aligned, err := keyframesAligned(ctx, inputFilepath, interval, source.DurationSeconds())
+	if err != nil || !aligned {
+		return 0, err
+	}
+	return rung.Height, nil
+}
+
+// keyframesAligned reports whether the video has a keyframe on every
+// multiple of interval up to duration, as forced keyframes would put them.
+// Keyframes in between, at scene cuts, don't move where segments are cut.
+func keyframesAligned(ctx context.Context, inputFilepath string, interval, duration float64) (bool, error) {
+	keyframes, err := keyframeTimes(ctx, inputFilepath)
+	if err != nil {
+		return false, err
+	}
+
+	next := 0.0
+	for _, at := range keyframes {
+		switch {
+		case math.Abs(at-next) <= keyframeTolerance:
+			next += interval
+		case at > next:
+			return false, nil
+		}
+	}
+	return next > 0 && next >= duration-keyframeTolerance, nil
+}
+
+// keyframeTimes lists when the keyframes of the file's video fall, in
+// seconds, none when it has no video.
+func keyframeTimes(ctx context.Context, inputFilepath string) ([]float64, error) {
+	args := []string{
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-skip_frame", "nokey",
+		"-show_entries", "frame=pts_time",
+		"-of", "csv=p=0",
+		inputFilepath,
+	}
+	output, err := ffmpeg.Probe(ctx, args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe keyframes failed: %w", err)
+	}
+
+	var keyframes []float64
+	for _, line := range strings.Fields(string(output)) {
+		at, err := strconv.ParseFloat(strings.TrimSuffix(line, ","), 64)
+		if err != nil {
+			continue
+		}
+		keyframes = append(keyframes, at)
+	}
+	return keyframes, nil
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"worker-transcode/pkg/objectstore"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+)
+
+// streamUploadInterval is how often the output dir is swept for finished
+// segments while the encode runs.
+const streamUploadInterval = 2 * time.Second
+
+var segmentPattern = regexp.MustCompile(`^(.+)_(\d+)\.(ts|m4s)$`)
+
+// segmentUploader uploads a package's segments while the encode is still
+// writing later ones, so the upload stage only has what was written last.
+// A segment is finished once the encoder has started the next one of its
+// playlist. Playlists are only uploaded by finish, so players never see a
+// package that is partly there.
+type segmentUploader struct {
+	client    objectstore.Store
+	bucket    string
+	localPath string
+	prefix    string
+
+	mu       sync.Mutex
+	uploaded map[string]int64
+	streamed int64
+}
+
+func newSegmentUploader(client objectstore.Store, bucket, localPath, prefix string) *segmentUploader {
+	return &segmentUploader{
+		client:    client,
+		bucket:    bucket,
+		localPath: localPath,
+		prefix:    prefix,
+		uploaded:  map[string]int64{},
+	}
+}
+
+// run sweeps until ctx is done. A segment that fails to upload is left for
+// finish to try again.
+func (u *segmentUploader) run(ctx context.Context) {
+	ticker := time.NewTicker(streamUploadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := u.sweep(ctx); err != nil && ctx.Err() == nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to upload finished segments")
+		}
+	}
+}
+
+func (u *segmentUploader) sweep(ctx context.Context) error {
+	entries, err := os.ReadDir(u.localPath)
+	if err != nil {
+		return err
+	}
+	type segment struct {
+		name  string
+		index int
+	}
+	playlists := map[string][]segment{}
+	for _, entry := range entries {
+		match := segmentPattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		index, _ := strconv.Atoi(match[2])
+		playlists[match[1]] = append(playlists[match[1]], segment{name: entry.Name(), index: index})
+	}
+	for _, segments := range playlists {
+		sort.Slice(segments, func(i, j int) bool { return segments[i].index < segments[j].index })
+		for _, segment := range segments[:len(segments)-1] {
+			if err := u.upload(ctx, segment.name, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// finish uploads everything the sweeps haven't, and returns the bytes of
+// the whole package and of what it uploaded itself.
+func (u *segmentUploader) finish(ctx context.Context) (int64, int64, error) {
+	err := filepath.Walk(u.localPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relativePath, err := filepath.Rel(u.localPath, path)
+		if err != nil {
+			return err
+		}
+		return u.upload(ctx, relativePath, false)
+	})
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var total int64
+	for _, size := range u.uploaded {
+		total += size
+	}
+	return total, total - u.streamed, err
+}
+
+// manifest returns the size of each object uploaded of the package, by its
+// path under the package's prefix.
+func (u *segmentUploader) manifest() map[string]int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	manifest := make(map[string]int64, len(u.uploaded))
+	for name, size := range u.uploaded {
+		manifest[filepath.ToSlash(name)] = size
+	}
+	return manifest
+}
+
+// discard removes what was uploaded of a package that won't be completed.
+func (u *segmentUploader) discard(ctx context.Context) error {
+	manifest := u.manifest()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.uploaded = map[string]int64{}
+	return removePackage(ctx, u.client, u.bucket, u.prefix, manifest)
+}
+
+func (u *segmentUploader) upload(ctx context.Context, relativePath string, streaming bool) error {
+	u.mu.Lock()
+	_, done := u.uploaded[relativePath]
+	u.mu.Unlock()
+	if done {
+		return nil
+	}
+	objectName := filepath.ToSlash(filepath.Join(u.prefix, relativePath))
+	object, err := u.client.FPutObject(ctx, u.bucket, objectName, filepath.Join(u.localPath, relativePath), minio.PutObjectOptions{})
+	if err != nil {
+		return err
+	}
+	u.mu.Lock()
+	u.uploaded[relativePath] = object.Size
+	if streaming {
+		u.streamed += object.Size
+	}
+	u.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,498 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/metrics"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+const (
+	subscriptionBatch = 100
+	// subscriptionSettle holds back the events of the last moments, so a
+	// job timestamped before its transaction commits isn't passed over.
+	subscriptionSettle = 30 * time.Second
+	// deliveryConcurrency bounds the deliveries POSTed at once, so one slow
+	// subscriber doesn't hold up the others.
+	deliveryConcurrency = 8
+	maxDeliveryBackoff  = time.Hour
+	// deliveryRetention is how long deliveries are kept once they're done.
+	deliveryRetention = 30 * 24 * time.Hour
+	// deliveryHistory bounds the deliveries listed of a subscription.
+	deliveryHistory = 200
+	// minSubscriptionSecret is the shortest secret a tenant may choose.
+	minSubscriptionSecret = 16
+)
+
+// SubscriptionService lets tenants subscribe webhooks to the pipeline's
+// events, each signed with its subscription's secret like the webhooks the
+// worker takes from LMSs: "X-Webhook-Signature: sha256=<hex>" of
+// "<X-Webhook-Timestamp>.<body>".
+type SubscriptionService interface {
+	// Run turns new events into deliveries and delivers those due every
+	// SUBSCRIPTIONS_INTERVAL seconds until ctx is done. Only the leader runs
+	// it.
+	Run(ctx context.Context)
+	List(ctx context.Context, tenantId uuid.UUID) ([]*entities.EventSubscription, error)
+	Get(ctx context.Context, tenantId, id uuid.UUID) (*entities.EventSubscription, error)
+	// Create subscribes the tenant, returning the secret its deliveries are
+	// signed with.
+	Create(ctx context.Context, tenantId uuid.UUID, request dto.SubscriptionRequest) (*dto.SubscriptionSecret, error)
+	Update(ctx context.Context, tenantId, id uuid.UUID, request dto.SubscriptionRequest) (*entities.EventSubscription, error)
+	Delete(ctx context.Context, tenantId, id uuid.UUID) error
+	// Deliveries lists the subscription's latest deliveries, newest first,
+	// only those of status when it's set.
+	Deliveries(ctx context.Context, tenantId, id uuid.UUID, status string) ([]*entities.EventDelivery, error)
+	// Redeliver sends the delivery again at once, as if it hadn't been
+	// tried.
+	Redeliver(ctx context.Context, tenantId, id, deliveryId uuid.UUID) (*entities.EventDelivery, error)
+}
+
+type subscriptionService struct {
+	repo   repository.SubscriptionRepository
+	jobs   repository.JobRepository
+	client *http.Client
+	cfg    *config.Config
+}
+
+func (s *subscriptionService) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(s.cfg.Subscriptions.Interval) * time.Second)
+	defer ticker.Stop()
+
+	var pruned time.Time
+	for {
+		s.enqueue(ctx)
+		for s.deliver(ctx) == subscriptionBatch && ctx.Err() == nil {
+		}
+		if time.Since(pruned) > time.Hour {
+			if err := s.repo.DeleteDeliveries(ctx, time.Now().Add(-deliveryRetention)); err != nil {
+				zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to delete old event deliveries")
+			}
+			pruned = time.Now()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// enqueue turns the events since each active subscription's cursor into
+// its deliveries.
+func (s *subscriptionService) enqueue(ctx context.Context) {
+	subscriptions, err := s.repo.ListActiveSubscriptions(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to list event subscriptions")
+		return
+	}
+	until := time.Now().Add(-subscriptionSettle)
+	for _, subscription := range subscriptions {
+		if err := s.enqueueFor(ctx, subscription, until); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Str("subscription_id", subscription.ID.String()).Msg("failed to queue event deliveries")
+		}
+	}
+}
+
+// enqueueFor moves the subscription's cursor up to until a batch of events
+// at a time. The events at the cursor are listed again by the next batch;
+// the delivery already made of them is kept.
+func (s *subscriptionService) enqueueFor(ctx context.Context, subscription *entities.EventSubscription, until time.Time) error {
+	for {
+		events, err := s.repo.ListSubscribedEvents(ctx, subscription, until, subscriptionBatch)
+		if err != nil {
+			return err
+		}
+		deliveries := make([]*entities.EventDelivery, 0, len(events))
+		for _, event := range events {
+			delivery, err := s.newDelivery(ctx, subscription, event)
+			if err != nil {
+				return err
+			}
+			if delivery != nil {
+				deliveries = append(deliveries, delivery)
+			}
+		}
+
+		cursor := until
+		if len(events) == subscriptionBatch {
+			cursor = events[len(events)-1].OccurredAt
+		}
+		if err := s.repo.SaveDeliveries(ctx, subscription.ID, deliveries, cursor); err != nil {
+			return err
+		}
+		if len(events) < subscriptionBatch || !cursor.After(subscription.CursorAt) {
+			return nil
+		}
+		subscription.CursorAt = cursor
+	}
+}
+
+// newDelivery builds the delivery of the event to the subscription. An
+// event's id is derived from what happened, so it's the same in every
+// subscription's delivery of it. A job deleted since isn't delivered.
+func (s *subscriptionService) newDelivery(ctx context.Context, subscription *entities.EventSubscription, event *entities.SubscribedEvent) (*entities.EventDelivery, error) {
+	payload := dto.SubscribedEvent{
+		EventType:  event.EventType,
+		OccurredAt: event.OccurredAt.UTC(),
+		TenantId:   subscription.TenantId,
+		CourseId:   event.CourseId,
+	}
+	var subject string
+	switch {
+	case event.JobId != nil:
+		job, err := s.jobs.FindJobById(ctx, *event.JobId)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("find job %s: %w", event.JobId, err)
+		}
+		payload.Job = job
+		subject = event.JobId.String()
+	case event.CourseId != nil:
+		// A course can be ready again after going back to processing.
+		subject = event.CourseId.String() + "/" + payload.OccurredAt.Format(time.RFC3339Nano)
+	}
+	payload.EventId = uuid.NewSHA1(uuid.NameSpaceURL, []byte("event:"+string(event.EventType)+"/"+subject))
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &entities.EventDelivery{
+		ID:             uuid.New(),
+		SubscriptionId: subscription.ID,
+		EventId:        payload.EventId,
+		EventType:      event.EventType,
+		Payload:        body,
+		Status:         constant.DeliveryStatusPending,
+		NextAttemptAt:  time.Now().UTC(),
+	}, nil
+}
+
+// deliver tries a batch of the deliveries due, returning how many it tried.
+func (s *subscriptionService) deliver(ctx context.Context) int {
+	deliveries, err := s.repo.PendingDeliveries(ctx, subscriptionBatch)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to list pending event deliveries")
+		return 0
+	}
+
+	subscriptions := map[uuid.UUID]*entities.EventSubscription{}
+	slots := make(chan struct{}, deliveryConcurrency)
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		subscription, ok := subscriptions[delivery.SubscriptionId]
+		if !ok {
+			subscription, err = s.repo.FindSubscription(ctx, delivery.SubscriptionId)
+			if err != nil {
+				zerolog.Ctx(ctx).Error().Err(err).Str("subscription_id", delivery.SubscriptionId.String()).Msg("failed to find event subscription")
+				continue
+			}
+			subscriptions[delivery.SubscriptionId] = subscription
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(delivery *entities.EventDelivery) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			s.attempt(ctx, subscription, delivery)
+		}(delivery)
+	}
+	wg.Wait()
+	return len(deliveries)
+}
+
+// attempt POSTs the delivery and records how it went. One the subscriber
+// doesn't take is tried again after a backoff doubling from
+// SUBSCRIPTIONS_INTERVAL, until it has been tried
+// SUBSCRIPTIONS_MAX_ATTEMPTS times.
+func (s *subscriptionService) attempt(ctx context.Context, subscription *entities.EventSubscription, delivery *entities.EventDelivery) {
+	code, err := s.post(ctx, subscription, delivery)
+	delivery.Attempts++
+	delivery.ResponseCode = nil
+	if code != 0 {
+		delivery.ResponseCode = &code
+	}
+
+	result := "delivered"
+	switch {
+	case err == nil:
+		now := time.Now().UTC()
+		delivery.Status = constant.DeliveryStatusDelivered
+		delivery.DeliveredAt = &now
+		delivery.LastError = nil
+	case delivery.Attempts >= s.cfg.Subscriptions.MaxAttempts:
+		result = "failed"
+		reason := err.Error()
+		delivery.Status = constant.DeliveryStatusFailed
+		delivery.LastError = &reason
+	default:
+		result = "retrying"
+		reason := err.Error()
+		delivery.LastError = &reason
+		delivery.NextAttemptAt = time.Now().UTC().Add(s.backoff(delivery.Attempts))
+	}
+	metrics.EventDeliveries.WithLabelValues(result).Inc()
+
+	logger := zerolog.Ctx(ctx).With().
+		Str("delivery_id", delivery.ID.String()).
+		Str("subscription_id", subscription.ID.String()).
+		Str("event_type", string(delivery.EventType)).
+		Int("attempts", delivery.Attempts).
+		Logger()
+	if err != nil {
+		logger.Warn().Err(err).Str("result", result).Msg("failed to deliver event")
+	}
+	if err := s.repo.UpdateDelivery(context.WithoutCancel(ctx), delivery); err != nil {
+		logger.Error().Err(err).Msg("failed to record event delivery")
+	}
+}
+
+// post sends the delivery, returning the status the subscriber answered
+// with. Only a 2xx answer delivers it; redirects aren't followed.
+func (s *subscriptionService) post(ctx context.Context, subscription *entities.EventSubscription, delivery *entities.EventDelivery) (int, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Webhook-Event", string(delivery.EventType))
+	request.Header.Set("X-Webhook-Id", delivery.EventId.String())
+	request.Header.Set("X-Webhook-Delivery", delivery.ID.String())
+	request.Header.Set("X-Webhook-Timestamp", timestamp)
+	request.Header.Set("X-Webhook-Signature", signWebhook(subscription.Secret, timestamp, delivery.Payload))
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		excerpt, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return response.StatusCode, fmt.Errorf("subscriber answered %s: %s", response.Status, strings.TrimSpace(string(excerpt)))
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+	return response.StatusCode, nil
+}
+
+func (s *subscriptionService) backoff(attempts int) time.Duration {
+	backoff := time.Duration(s.cfg.Subscriptions.Interval) * time.Second
+	for i := 1; i < attempts && backoff < maxDeliveryBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxDeliveryBackoff)
+}
+
+func (s *subscriptionService) List(ctx context.Context, tenantId uuid.UUID) ([]*entities.EventSubscription, error) {
+	subscriptions, err := s.repo.ListSubscriptions(ctx, tenantId)
+	if err != nil {
+		return nil, err
+	}
+	if subscriptions == nil {
+		subscriptions = []*entities.EventSubscription{}
+	}
+	return subscriptions, nil
+}
+
+func (s *subscriptionService) Get(ctx context.Context, tenantId, id uuid.UUID) (*entities.EventSubscription, error) {
+	subscription, err := s.repo.FindSubscription(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && subscription.TenantId != tenantId) {
+		return nil, errors.Join(ErrNotFound, fmt.Errorf("subscription %s not found", id))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+func (s *subscriptionService) Create(ctx context.Context, tenantId uuid.UUID, request dto.SubscriptionRequest) (*dto.SubscriptionSecret, error) {
+	subscription := &entities.EventSubscription{
+		ID:       uuid.New(),
+		TenantId: tenantId,
+		Active:   true,
+		Secret:   request.Secret,
+	}
+	if err := applySubscription(subscription, request); err != nil {
+		return nil, errors.Join(ErrInvalidArgument, err)
+	}
+	if subscription.Secret == "" {
+		secret, err := newSubscriptionSecret()
+		if err != nil {
+			return nil, err
+		}
+		subscription.Secret = secret
+	}
+	if err := s.repo.CreateSubscription(ctx, subscription); err != nil {
+		return nil, err
+	}
+	// Read back for the cursor and timestamps the database set.
+	created, err := s.repo.FindSubscription(ctx, subscription.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &dto.SubscriptionSecret{EventSubscription: created, Secret: created.Secret}, nil
+}
+
+func (s *subscriptionService) Update(ctx context.Context, tenantId, id uuid.UUID, request dto.SubscriptionRequest) (*entities.EventSubscription, error) {
+	subscription, err := s.Get(ctx, tenantId, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := applySubscription(subscription, request); err != nil {
+		return nil, errors.Join(ErrInvalidArgument, err)
+	}
+	if request.Secret != "" {
+		subscription.Secret = request.Secret
+	}
+	if err := s.repo.UpdateSubscription(ctx, subscription); err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+func (s *subscriptionService) Delete(ctx context.Context, tenantId, id uuid.UUID) error {
+	if _, err := s.Get(ctx, tenantId, id); err != nil {
+		return err
+	}
+	return s.repo.DeleteSubscription(ctx, id)
+}
+
+func (s *subscriptionService) Deliveries(ctx context.Context, tenantId, id uuid.UUID, status string) ([]*entities.EventDelivery, error) {
+	if _, err := s.Get(ctx, tenantId, id); err != nil {
+		return nil, err
+	}
+	var filter *constant.DeliveryStatus
+	if status != "" {
+		switch value := constant.DeliveryStatus(strings.ToUpper(status)); value {
+		case constant.DeliveryStatusPending, constant.DeliveryStatusDelivered, constant.DeliveryStatusFailed:
+			filter = &value
+		default:
+			return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("unknown delivery status %q", status))
+		}
+	}
+	deliveries, err := s.repo.ListDeliveries(ctx, id, filter, deliveryHistory)
+	if err != nil {
+		return nil, err
+	}
+	if deliveries == nil {
+		deliveries = []*entities.EventDelivery{}
+	}
+	return deliveries, nil
+}
+
+func (s *subscriptionService) Redeliver(ctx context.Context, tenantId, id, deliveryId uuid.UUID) (*entities.EventDelivery, error) {
+	if _, err := s.Get(ctx, tenantId, id); err != nil {
+		return nil, err
+	}
+	delivery, err := s.repo.FindDelivery(ctx, deliveryId)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && delivery.SubscriptionId != id) {
+		return nil, errors.Join(ErrNotFound, fmt.Errorf("delivery %s not found", deliveryId))
+	}
+	if err != nil {
+		return nil, err
+	}
+	delivery.Status = constant.DeliveryStatusPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = time.Now().UTC()
+	delivery.DeliveredAt = nil
+	if err := s.repo.UpdateDelivery(ctx, delivery); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// applySubscription checks the request and sets the subscription's URL,
+// events, filters and, when given, whether it's active.
+func applySubscription(subscription *entities.EventSubscription, request dto.SubscriptionRequest) error {
+	target, err := url.Parse(request.URL)
+	if err != nil || (target.Scheme != "https" && target.Scheme != "http") || target.Host == "" {
+		return fmt.Errorf("url %q must be an absolute http or https URL", request.URL)
+	}
+	if request.Secret != "" && len(request.Secret) < minSubscriptionSecret {
+		return fmt.Errorf("secret must be at least %d characters", minSubscriptionSecret)
+	}
+	if len(request.Events) == 0 {
+		return errors.New("events must name at least one event")
+	}
+	seen := map[string]bool{}
+	events := make([]string, 0, len(request.Events))
+	for _, event := range request.Events {
+		switch constant.SubscriptionEvent(event) {
+		case constant.SubscriptionEventJobCompleted, constant.SubscriptionEventJobFailed, constant.SubscriptionEventCourseReady:
+		default:
+			return fmt.Errorf("unknown event %q: must be %s, %s or %s", event,
+				constant.SubscriptionEventJobCompleted, constant.SubscriptionEventJobFailed, constant.SubscriptionEventCourseReady)
+		}
+		if !seen[event] {
+			seen[event] = true
+			events = append(events, event)
+		}
+	}
+	courseIds := make([]string, 0, len(request.CourseIds))
+	for _, id := range request.CourseIds {
+		courseIds = append(courseIds, id.String())
+	}
+	jobTypes := make([]string, 0, len(request.JobTypes))
+	for _, jobType := range request.JobTypes {
+		if jobType == "" {
+			return errors.New("job_types must not be empty strings")
+		}
+		jobTypes = append(jobTypes, jobType)
+	}
+
+	subscription.URL = request.URL
+	subscription.Events = events
+	subscription.CourseIds = courseIds
+	subscription.JobTypes = jobTypes
+	if request.Active != nil {
+		subscription.Active = *request.Active
+	}
+	return nil
+}
+
+func newSubscriptionSecret() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+func NewSubscriptionService(repo repository.SubscriptionRepository, jobs repository.JobRepository, cfg *config.Config) SubscriptionService {
+	return &subscriptionService{
+		repo: repo,
+		jobs: jobs,
+		client: &http.Client{
+			Timeout: time.Duration(cfg.Subscriptions.Timeout) * time.Second,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		cfg: cfg,
+	}
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrTenantBusy is returned for a job whose tenant already has its
+// MaxConcurrentJobs being processed; its message goes back on the queue.
+var ErrTenantBusy = errors.New("tenant is at its concurrent job limit")
+
+type tenantKey struct{}
+
+// TenantService resolves the per-tenant config jobs run with, in place of
+// the environment's settings for every tenant.
+type TenantService interface {
+	// Admit returns ctx carrying the job's tenant config, which the stages
+	// of the job read their overrides from. A job of a tenant at its
+	// concurrent job limit is requeued instead. Jobs without a tenant, or of
+	// a tenant without a config, run as the environment sets.
+	Admit(ctx context.Context, job *entities.Job) (context.Context, error)
+	Get(ctx context.Context, tenantId uuid.UUID) (*entities.TenantConfig, error)
+	Save(ctx context.Context, tenantId uuid.UUID, request dto.TenantConfigRequest) (*entities.TenantConfig, error)
+}
+
+type tenantService struct {
+	repo    repository.TenantConfigRepository
+	presets PresetService
+	cfg     *config.Config
+}
+
+func (s *tenantService) Admit(ctx context.Context, job *entities.Job) (context.Context, error) {
+	if job.TenantId == nil {
+		return ctx, nil
+	}
+	tenant, err := s.repo.FindTenantConfig(ctx, *job.TenantId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ctx, nil
+	}
+	if err != nil {
+		return ctx, err
+	}
+
+	// Workers claiming at the same moment can each see room for one more,
+	// so the limit can be passed by the few jobs claimed together.
+	if tenant.MaxConcurrentJobs != nil {
+		processing, err := s.repo.CountProcessingJobs(ctx, *job.TenantId)
+		if err != nil {
+			return ctx, err
+		}
+		if processing >= int64(*tenant.MaxConcurrentJobs) {
+			err := fmt.Errorf("%d of %d jobs processing", processing, *tenant.MaxConcurrentJobs)
+			return ctx, rabbitmq.Requeue(errors.Join(ErrTenantBusy, err), time.Duration(s.cfg.Server.PreflightDelay)*time.Second)
+		}
+	}
+	return context.WithValue(ctx, tenantKey{}, tenant), nil
+}
+
+func (s *tenantService) Get(ctx context.Context, tenantId uuid.UUID) (*entities.TenantConfig, error) {
+	tenant, err := s.repo.FindTenantConfig(ctx, tenantId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	return tenant, err
+}
+
+func (s *tenantService) Save(ctx context.Context, tenantId uuid.UUID, request dto.TenantConfigRequest) (*entities.TenantConfig, error) {
+	tenant := &entities.TenantConfig{
+		TenantId:             tenantId,
+		MaxConcurrentJobs:    request.MaxConcurrentJobs,
+		EncodingMinutesQuota: request.EncodingMinutesQuota,
+		PlaybackTTL:          request.PlaybackTTL,
+		Features:             entities.TenantFeatures{},
+		UpdatedAt:            time.Now(),
+	}
+	if request.Preset != nil && strings.TrimSpace(*request.Preset) != "" {
+		preset := strings.TrimSpace(*request.Preset)
+		if _, err := s.presets.Resolve(ctx, preset); err != nil {
+			return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("preset %s: %w", preset, err))
+		}
+		tenant.Preset = &preset
+	}
+	if tenant.MaxConcurrentJobs != nil && *tenant.MaxConcurrentJobs <= 0 {
+		return nil, errors.Join(ErrInvalidArgument, errors.New("max_concurrent_jobs must be positive, or null for no limit"))
+	}
+	if tenant.EncodingMinutesQuota != nil && *tenant.EncodingMinutesQuota < 0 {
+		return nil, errors.Join(ErrInvalidArgument, errors.New("encoding_minutes_quota must not be negative, or null for no quota"))
+	}
+	if tenant.PlaybackTTL != nil && *tenant.PlaybackTTL < 60 {
+		return nil, errors.Join(ErrInvalidArgument, errors.New("playback_ttl must be at least 60 seconds, or null for the worker's"))
+	}
+	if request.Region != nil && strings.TrimSpace(*request.Region) != "" {
+		region := strings.TrimSpace(*request.Region)
+		if !slices.Contains(s.cfg.Region.Known, region) {
+			return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("region %q is not one of REGIONS", region))
+		}
+		tenant.Region = &region
+	}
+	for name, enabled := range request.Features {
+		if !slices.Contains(constant.TenantFeatures, constant.TenantFeature(name)) {
+			return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("unknown feature %q", name))
+		}
+		tenant.Features[name] = enabled
+	}
+	if err := s.repo.SaveTenantConfig(ctx, tenant); err != nil {
+		return nil, err
+	}
+	return s.repo.FindTenantConfig(ctx, tenantId)
+}
+
+// jobRegion is the region the job's media must be processed in: its own,
+// or else its message's, or else its tenant's; empty when it may run
+// anywhere.
+func jobRegion(ctx context.Context, job *entities.Job, message dto.JobMessage) string {
+	if job.Region != nil {
+		return *job.Region
+	}
+	if message.Region != "" {
+		return message.Region
+	}
+	if tenant := tenantFromContext(ctx); tenant != nil && tenant.Region != nil {
+		return *tenant.Region
+	}
+	return ""
+}
+
+func tenantFromContext(ctx context.Context) *entities.TenantConfig {
+	tenant, _ := ctx.Value(tenantKey{}).(*entities.TenantConfig)
+	return tenant
+}
+
+// featureEnabled is whether the job in ctx runs the feature: as its tenant's
+// config sets it, or else as enabled, the environment's setting.
+func featureEnabled(ctx context.Context, feature constant.TenantFeature, enabled bool) bool {
+	if tenant := tenantFromContext(ctx); tenant != nil {
+		if override, ok := tenant.Features[string(feature)]; ok {
+			return override
+		}
+	}
+	return enabled
+}
+
+// tenantPreset is the preset a job naming name is transcoded with: its own,
+// or else its tenant's, or else the default ladder.
+func tenantPreset(ctx context.Context, name string) string {
+	if name != "" {
+		return name
+	}
+	if tenant := tenantFromContext(ctx); tenant != nil && tenant.Preset != nil {
+		return *tenant.Preset
+	}
+	return name
+}
+
+func NewTenantService(repo repository.TenantConfigRepository, presets PresetService, cfg *config.Config) TenantService {
+	return &tenantService{
+		repo:    repo,
+		presets: presets,
+		cfg:     cfg,
+	}
+}
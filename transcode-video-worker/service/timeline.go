@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+type timelineKey struct{}
+
+// jobTimeline records events for one job. It travels in the context so the
+// stage helpers and ffmpeg runner can add to it without extra parameters.
+type jobTimeline struct {
+	repo  repository.JobEventRepository
+	jobId uuid.UUID
+}
+
+func withTimeline(ctx context.Context, repo repository.JobEventRepository, jobId uuid.UUID) context.Context {
+	return context.WithValue(ctx, timelineKey{}, &jobTimeline{repo: repo, jobId: jobId})
+}
+
+// recordEvent appends an event to the job's timeline, if ctx has one. The
+// timeline is diagnostic, so a failed write is logged and otherwise ignored.
+func recordEvent(ctx context.Context, eventType constant.JobEventType, stage string, data entities.EventData) {
+	timeline, ok := ctx.Value(timelineKey{}).(*jobTimeline)
+	if !ok {
+		return
+	}
+
+	event := &entities.JobEvent{
+		JobId:     timeline.jobId,
+		EventType: eventType,
+		Data:      data,
+	}
+	if stage != "" {
+		event.Stage = &stage
+	}
+	if err := timeline.repo.AddJobEvent(ctx, event); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("event_type", string(eventType)).Msg("failed to record job event")
+	}
+}
+
+// recordStatus records a transition. Once a job leaves processing its
+// buffered writes are flushed, so the timeline and progress are complete
+// when anyone looks at the outcome.
+func recordStatus(ctx context.Context, from, to constant.JobStatus) {
+	recordEvent(ctx, constant.JobEventStatus, "", entities.EventData{"from": from, "to": to})
+	if to == constant.JobStatusProcessing {
+		return
+	}
+	timeline, ok := ctx.Value(timelineKey{}).(*jobTimeline)
+	if !ok {
+		return
+	}
+	if buffer, ok := timeline.repo.(repository.WriteBuffer); ok {
+		if err := buffer.Flush(ctx); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to flush job writes")
+		}
+	}
+}
+
+// buildTimeline folds raw job events into the sections a debugging UI shows.
+func buildTimeline(job *entities.Job, events []*entities.JobEvent) *dto.JobTimeline {
+	timeline := &dto.JobTimeline{
+		Job:         job,
+		Transitions: []dto.JobTransition{},
+		Stages:      []dto.JobStageSummary{},
+		Progress:    []dto.JobProgressSample{},
+		Errors:      []*entities.JobEvent{},
+		Commands:    []string{},
+		Events:      events,
+	}
+
+	since := job.CreatedAt
+	for _, event := range events {
+		switch event.EventType {
+		case constant.JobEventStatus:
+			timeline.Transitions = append(timeline.Transitions, dto.JobTransition{
+				From:    dataString(event.Data, "from"),
+				To:      dataString(event.Data, "to"),
+				At:      event.CreatedAt,
+				Seconds: event.CreatedAt.Sub(since).Seconds(),
+			})
+			since = event.CreatedAt
+		case constant.JobEventStage:
+			summary := dto.JobStageSummary{
+				FinishedAt: event.CreatedAt,
+				Seconds:    dataFloat(event.Data, "seconds"),
+				Error:      dataString(event.Data, "error"),
+			}
+			if event.Stage != nil {
+				summary.Stage = *event.Stage
+			}
+			timeline.Stages = append(timeline.Stages, summary)
+		case constant.JobEventProgress:
+			timeline.Progress = append(timeline.Progress, dto.JobProgressSample{
+				At:      event.CreatedAt,
+				Percent: dataFloat(event.Data, "percent"),
+				Speed:   dataFloat(event.Data, "speed"),
+				FPS:     dataFloat(event.Data, "fps"),
+			})
+		case constant.JobEventCommand:
+			timeline.Commands = append(timeline.Commands, dataString(event.Data, "command"))
+		case constant.JobEventError:
+			timeline.Errors = append(timeline.Errors, event)
+		case constant.JobEventOutput:
+			if renditions, ok := event.Data["renditions"].([]interface{}); ok {
+				for _, raw := range renditions {
+					if r, ok := raw.(map[string]interface{}); ok {
+						timeline.Renditions = append(timeline.Renditions, entities.Rendition{
+							Width:     int(dataFloat(r, "width")),
+							Height:    int(dataFloat(r, "height")),
+							Bitrate:   dataString(r, "bitrate"),
+							AudioRate: dataString(r, "audio_rate"),
+						})
+					}
+				}
+			}
+		}
+	}
+
+	return timeline
+}
+
+func dataString(data map[string]interface{}, key string) string {
+	value, _ := data[key].(string)
+	return value
+}
+
+func dataFloat(data map[string]interface{}, key string) float64 {
+	value, _ := data[key].(float64)
+	return value
+}
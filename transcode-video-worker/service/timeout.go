@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/pkg/rabbitmq"
+)
+
+// ErrJobTimeout is the cause of a job's context being cancelled because the
+// job ran past its limit.
+var ErrJobTimeout = errors.New("job exceeded its time limit")
+
+// jobDeadline bounds a job's wall-clock time. The source duration isn't known
+// until the probe, so the limit starts at the base and scale stretches it.
+type jobDeadline struct {
+	timer   *time.Timer
+	started time.Time
+	base    time.Duration
+	factor  float64
+}
+
+// withJobDeadline returns a context cancelled with ErrJobTimeout once the
+// limit passes. The base is the retry policy's timeout of the queue the job
+// came from, else cfg's; the limit is off when neither sets one.
+func withJobDeadline(ctx context.Context, cfg *config.Config) (context.Context, *jobDeadline, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	deadline := &jobDeadline{
+		started: time.Now(),
+		base:    time.Duration(cfg.Server.JobTimeout) * time.Second,
+		factor:  cfg.Server.JobTimeoutFactor,
+	}
+	if timeout := rabbitmq.JobTimeout(ctx); timeout > 0 {
+		deadline.base = timeout
+	}
+	if deadline.base > 0 {
+		deadline.timer = time.AfterFunc(deadline.base, func() { cancel(ErrJobTimeout) })
+	}
+	return ctx, deadline, func() {
+		if deadline.timer != nil {
+			deadline.timer.Stop()
+		}
+		cancel(nil)
+	}
+}
+
+// scale moves the limit to base + factor × the source duration, counted from
+// the start of the job, and returns it.
+func (d *jobDeadline) scale(sourceSeconds float64) time.Duration {
+	if d.timer == nil {
+		return 0
+	}
+	limit := d.base + time.Duration(d.factor*sourceSeconds*float64(time.Second))
+	d.timer.Reset(max(limit-time.Since(d.started), 0))
+	return limit
+}
+
+func timedOut(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrJobTimeout)
+}
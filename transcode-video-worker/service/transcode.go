@@ -1,64 +1,154 @@
 package service
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"github.com/rs/zerolog"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/ffmpeg"
+
+	"github.com/google/uuid"
 )
 
-type Resolution struct {
-	Width     int
-	Height    int
-	Bitrate   string // e.g., "800k"
-	AudioRate string // e.g., "96k"
+// presetDataId names the master playlist's session data carrying the preset
+// name and version, as "name/version".
+const presetDataId = "com.edtech.preset"
+
+// sourceAudioLanguage is the language of the source's own audio, the default
+// rendition of every package.
+const sourceAudioLanguage = "en"
+
+// FFmpegError keeps the stderr output of a failed ffmpeg run so it can be
+// attached to error reports, and the post-mortem of one that crashed.
+type FFmpegError struct {
+	Err    error
+	Output string
+	Crash  *entities.EncoderCrash
 }
 
-// Define the target resolutions for HLS.
-var resolutions = []Resolution{
-	{Width: 256, Height: 144, Bitrate: "200k", AudioRate: "64k"},
-	{Width: 640, Height: 360, Bitrate: "800k", AudioRate: "96k"},
-	{Width: 854, Height: 480, Bitrate: "1500k", AudioRate: "128k"},
-	{Width: 1280, Height: 720, Bitrate: "3000k", AudioRate: "192k"},
-	{Width: 1920, Height: 1080, Bitrate: "5000k", AudioRate: "192k"},
+func (e *FFmpegError) Error() string {
+	return fmt.Sprintf("ffmpeg execution failed: %v", e.Err)
 }
 
-func transcodeToHLS(inputFilepath, outputDir string) error {
-	var filterComplexBuilder strings.Builder
-	for _, r := range resolutions {
-		filterComplexBuilder.WriteString(
-			fmt.Sprintf("[0:v]scale=w=%d:h=%d:force_original_aspect_ratio=decrease,pad=w=%d:h=%d:x=(ow-iw)/2:y=(oh-ih)/2[v%d]; ",
-				r.Width, r.Height, r.Width, r.Height, r.Height))
+func (e *FFmpegError) Unwrap() error {
+	return e.Err
+}
+
+// TranscodeFile runs the encode and packaging stages on a local file, outside
+// of any job. It backs the one-off CLI commands.
+func TranscodeFile(ctx context.Context, preset *entities.Preset, inputFilepath, outputDir string) error {
+	if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
+		return err
 	}
 
-	ffmpegArgs := []string{
-		"-i", inputFilepath,
-		"-filter_complex", strings.TrimSuffix(filterComplexBuilder.String(), "; "),
+	var duration float64
+	if media, err := ProbeMedia(ctx, inputFilepath); err == nil {
+		duration = media.DurationSeconds()
+	}
+	if err := transcodeToHLS(ctx, preset, inputFilepath, "", nil, outputDir, 0, 0, 0, progressReporter(ctx, nil, uuid.Nil, duration)); err != nil {
+		return err
 	}
+	if err := createMasterPlaylist(ctx, preset, outputDir, nil); err != nil {
+		return err
+	}
+	return writeProgressive(ctx, preset, outputDir, 0)
+}
 
+func transcodeToHLS(ctx context.Context, preset *entities.Preset, inputFilepath, audioFilepath string, dubs []dubbedAudio, outputDir string, threads, copyHeight int, partSeconds float64, onProgress func(FFmpegProgress)) error {
+	return runFFmpeg(ctx, hlsArgs(preset, inputFilepath, audioFilepath, dubs, outputDir, threads, copyHeight, partSeconds), onProgress)
+}
+
+// presetArgs are the preset's own global args, filled in. They are checked
+// when the preset is saved, so one that no longer expands is dropped.
+func presetArgs(preset *entities.Preset) []string {
+	args, err := ffmpeg.Expand(preset.FFmpegArgs)
+	if err != nil {
+		return []string{}
+	}
+	return args
+}
+
+// hlsArgs builds the single ffmpeg run that encodes every rendition of
+// preset, plus a shared audio track, into HLS playlists under outputDir. Audio comes
+// from audioFilepath when set and from the video input otherwise; each dubbed
+// track is encoded the same way into a playlist of its own. A positive
+// threads is shared between the rendition encoders. The rung copyHeight tall,
+// if any, is remuxed from the source's video with -c copy instead. A positive
+// partSeconds cuts every playlist into parts that long under partsDir, for
+// packageParts to gather into segments.
+func hlsArgs(preset *entities.Preset, inputFilepath, audioFilepath string, dubs []dubbedAudio, outputDir string, threads, copyHeight int, partSeconds float64) []string {
+	resolutions := preset.Renditions
+	var encoded entities.Renditions
 	for _, r := range resolutions {
+		if r.Height != copyHeight {
+			encoded = append(encoded, r)
+		}
+	}
 
-		playlistName := fmt.Sprintf("%dp.m3u8", r.Height)
-		segmentName := fmt.Sprintf("%dp_%%03d.ts", r.Height)
+	// The source is decoded once and split between the rungs, rather than
+	// each rung's scaler pulling its own copy of the decoded frames.
+	var filterComplexBuilder strings.Builder
+	filterComplexBuilder.WriteString(fmt.Sprintf("[0:v]split=%d", len(encoded)))
+	for i := range encoded {
+		filterComplexBuilder.WriteString(fmt.Sprintf("[s%d]", i))
+	}
+	filterComplexBuilder.WriteString("; ")
+	for i, r := range encoded {
+		filterComplexBuilder.WriteString(fmt.Sprintf("[s%d]%s[v%d]; ", i, scaleFilter(preset, r), r.Height))
+	}
 
-		ffmpegArgs = append(ffmpegArgs,
-			"-map", fmt.Sprintf("[v%d]", r.Height),
+	ffmpegArgs := append(presetArgs(preset), "-i", inputFilepath)
+	audioMap := "0:a:0?"
+	if audioFilepath != "" {
+		ffmpegArgs = append(ffmpegArgs, "-i", audioFilepath)
+		audioMap = "1:a:0?"
+	}
+	firstDub := 1
+	if audioFilepath != "" {
+		firstDub = 2
+	}
+	for _, dub := range dubs {
+		ffmpegArgs = append(ffmpegArgs, "-i", dub.path)
+	}
+	if len(encoded) > 0 {
+		ffmpegArgs = append(ffmpegArgs, "-filter_complex", strings.TrimSuffix(filterComplexBuilder.String(), "; "))
+	}
 
-			"-c:v", "libx264",
-			"-preset", "veryfast",
-			"-crf", "22", // Constant Rate Factor for quality
-			"-b:v", r.Bitrate,
-			"-maxrate", r.Bitrate,
-			"-bufsize", r.Bitrate,
+	segmentDir, outputArgs := outputDir, hlsOutputArgs(preset)
+	if partSeconds > 0 {
+		segmentDir, outputArgs = filepath.Join(outputDir, partsDir), partOutputArgs(partSeconds)
+	}
+
+	for _, r := range resolutions {
 
-			"-f", "hls",
-			"-hls_time", "6",
-			"-hls_playlist_type", "vod",
-			"-hls_segment_filename", filepath.Join(outputDir, segmentName),
-			filepath.Join(outputDir, playlistName),
-		)
+		playlistName := fmt.Sprintf("%dp.m3u8", r.Height)
+		// Parts are gathered into TS segments, whatever the rung's format.
+		if partSeconds > 0 {
+			r.SegmentFormat = segmentFormatTS
+		}
+
+		if r.Height == copyHeight {
+			ffmpegArgs = append(ffmpegArgs, "-map", "0:v:0", "-c:v", "copy")
+		} else {
+			ffmpegArgs = append(ffmpegArgs,
+				"-map", fmt.Sprintf("[v%d]", r.Height),
+
+				"-c:v", preset.VideoCodec,
+				"-preset", preset.EncoderPreset,
+			)
+			ffmpegArgs = append(ffmpegArgs, videoRateArgs(preset, r)...)
+			if threads > 0 {
+				ffmpegArgs = append(ffmpegArgs, "-threads", strconv.Itoa(max(threads/len(encoded), 1)))
+			}
+			ffmpegArgs = append(ffmpegArgs, keyframeArgs(preset)...)
+		}
+		ffmpegArgs = append(ffmpegArgs, outputArgs...)
+		ffmpegArgs = append(ffmpegArgs, rungSegmentArgs(r, segmentDir)...)
+		ffmpegArgs = append(ffmpegArgs, filepath.Join(outputDir, playlistName))
 	}
 
 	highestAudioRate := "96k" // Default
@@ -66,38 +156,61 @@ func transcodeToHLS(inputFilepath, outputDir string) error {
 		highestAudioRate = resolutions[len(resolutions)-1].AudioRate
 	}
 	ffmpegArgs = append(ffmpegArgs,
-		"-map", "0:a:0?",
-		"-c:a", "aac",
-		"-b:a", highestAudioRate,
-		"-f", "hls",
-		"-hls_time", "6",
-		"-hls_playlist_type", "vod",
-		"-hls_segment_filename", filepath.Join(outputDir, "audio_%03d.ts"),
+		"-map", audioMap,
+		"-c:a", preset.AudioCodec,
+		"-b:a", highestAudioRate)
+	ffmpegArgs = append(ffmpegArgs, outputArgs...)
+	ffmpegArgs = append(ffmpegArgs,
+		"-hls_segment_filename", filepath.Join(segmentDir, "audio_%03d.ts"),
 		filepath.Join(outputDir, "audio.m3u8"))
 
-	cmd := exec.Command("ffmpeg", ffmpegArgs...)
-	log.Printf("Executing FFmpeg command: ffmpeg %s", strings.Join(ffmpegArgs, " "))
-
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		log.Printf("FFmpeg output:\n%s\n", string(output))
-		return fmt.Errorf("ffmpeg execution failed: %w", err)
+	for i, dub := range dubs {
+		ffmpegArgs = append(ffmpegArgs,
+			"-map", fmt.Sprintf("%d:a:0", firstDub+i),
+			"-c:a", preset.AudioCodec,
+			"-b:a", highestAudioRate,
+			"-metadata:s:a:0", "language="+dub.language)
+		if dub.description {
+			ffmpegArgs = append(ffmpegArgs, "-disposition:a:0", "visual_impaired+descriptions")
+		}
+		ffmpegArgs = append(ffmpegArgs, outputArgs...)
+		ffmpegArgs = append(ffmpegArgs,
+			"-hls_segment_filename", filepath.Join(segmentDir, dub.segments()),
+			filepath.Join(outputDir, dub.playlist()))
 	}
 
-	return nil
+	return ffmpegArgs
 }
 
-func createMasterPlaylist(outputDir string) error {
+// createMasterPlaylist writes the master playlist, with the source's audio as
+// the default rendition and each dubbed track as an alternate one players
+// offer in their audio menu. Audio descriptions carry the describes-video
+// characteristic; they stay in the one audio group every variant references,
+// as the HLS authoring spec lays them out, so any variant can play them. The
+// output is HLS only, so there's no DASH manifest to list them in.
+func createMasterPlaylist(ctx context.Context, preset *entities.Preset, outputDir string, dubs []dubbedAudio) error {
 	masterPlaylistPath := filepath.Join(outputDir, "master.m3u8")
 	var contentBuilder strings.Builder
 	contentBuilder.WriteString("#EXTM3U\n")
-	contentBuilder.WriteString("#EXT-X-VERSION:3\n\n")
-
-	contentBuilder.WriteString(`#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio",NAME="English",DEFAULT=YES,AUTOSELECT=YES,URI="audio.m3u8"` + "\n\n")
+	contentBuilder.WriteString(fmt.Sprintf("#EXT-X-VERSION:%d\n", masterPlaylistVersion(preset)))
+	// Players ignore it; it tells which preset version encoded the package.
+	contentBuilder.WriteString(fmt.Sprintf("#EXT-X-SESSION-DATA:DATA-ID=\"%s\",VALUE=\"%s/%d\"\n\n", presetDataId, preset.Name, preset.Version))
+
+	contentBuilder.WriteString(fmt.Sprintf("#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",LANGUAGE=\"%s\",NAME=\"English\",DEFAULT=YES,AUTOSELECT=YES,URI=\"audio.m3u8\"\n", sourceAudioLanguage))
+	for _, dub := range dubs {
+		var characteristics string
+		if dub.description {
+			characteristics = fmt.Sprintf(",CHARACTERISTICS=\"%s\"", describesVideo)
+		}
+		contentBuilder.WriteString(fmt.Sprintf("#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",LANGUAGE=\"%s\",NAME=\"%s\",DEFAULT=NO,AUTOSELECT=YES%s,URI=\"%s\"\n",
+			dub.language, strings.ReplaceAll(dub.name, `"`, "'"), characteristics, dub.playlist()))
+	}
+	contentBuilder.WriteString("\n")
 
-	log.Println("Creating master playlist...")
+	zerolog.Ctx(ctx).Info().Msg("creating master playlist")
 
-	for _, r := range resolutions {
+	codecs := fmt.Sprintf("%s,%s", videoCodecTags[preset.VideoCodec], audioCodecTags[preset.AudioCodec])
+	for _, r := range preset.Renditions {
 		var videoBitrateBPS int
 		fmt.Sscanf(r.Bitrate, "%dk", &videoBitrateBPS)
 
@@ -107,7 +220,7 @@ func createMasterPlaylist(outputDir string) error {
 		totalBandwidth := (videoBitrateBPS + audioBitrateBPS) * 1000
 
 		playlistName := fmt.Sprintf("%dp.m3u8", r.Height)
-		contentBuilder.WriteString(fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,CODECS=\"avc1.640028,mp4a.40.2\",AUDIO=\"audio\"\n", totalBandwidth, r.Width, r.Height))
+		contentBuilder.WriteString(fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,CODECS=\"%s\",AUDIO=\"audio\"\n", totalBandwidth, r.Width, r.Height, codecs))
 		contentBuilder.WriteString(playlistName + "\n")
 	}
 
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"worker-transcode/config"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/search"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// TranscriptService indexes the timed text of lesson videos so students can
+// search inside the videos of a course and jump to the moment a term is said.
+// Transcripts are pushed through the API until the worker generates captions
+// itself; a caption stage would call Index with the cues it produced. Each
+// becomes the lesson's caption track in its language, its cues labeled
+// with their speakers when the video was diarized and checked for words
+// to redact.
+type TranscriptService interface {
+	// Index replaces the lesson's transcript in the search index.
+	Index(ctx context.Context, lessonId uuid.UUID, language string, cues []search.Cue) error
+	// Search returns the cues of the course's lessons that best match query.
+	Search(ctx context.Context, courseId uuid.UUID, query string, limit int) ([]search.Hit, error)
+	// Captions lists the lesson's caption tracks for the player.
+	Captions(ctx context.Context, lessonId uuid.UUID) ([]*entities.LessonCaption, error)
+}
+
+type transcriptService struct {
+	repo         repository.TranscriptRepository
+	index        search.Index
+	courses      CourseService
+	translations TranslationService
+	redactions   RedactionService
+	store        objectstore.Store
+	cfg          *config.Config
+}
+
+func (s *transcriptService) Index(ctx context.Context, lessonId uuid.UUID, language string, cues []search.Cue) error {
+	if language == "" {
+		return errors.Join(ErrInvalidArgument, errors.New("language is required"))
+	}
+	if !languageTagPattern.MatchString(language) {
+		return errors.Join(ErrInvalidArgument, fmt.Errorf("language %q is not a language tag", language))
+	}
+	kept := make([]search.Cue, 0, len(cues))
+	for i, cue := range cues {
+		cue.Text = strings.TrimSpace(cue.Text)
+		if cue.Start < 0 || cue.End < cue.Start {
+			return errors.Join(ErrInvalidArgument, fmt.Errorf("cue %d ends before it starts", i))
+		}
+		if cue.Text != "" {
+			kept = append(kept, cue)
+		}
+	}
+
+	courseId, err := s.repo.FindLessonCourse(ctx, lessonId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errors.Join(ErrNotFound, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Cues the transcript doesn't name a speaker for take the one the
+	// lesson's video was diarized with.
+	speakers, err := s.repo.FindSpeakers(ctx, lessonId)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if speakers != nil {
+		kept = labelCues(kept, speakers.Turns)
+	}
+	kept, err = s.redactions.Check(ctx, lessonId, language, kept)
+	if err != nil {
+		return err
+	}
+
+	err = s.index.Replace(ctx, search.Transcript{
+		CourseId: courseId,
+		LessonId: lessonId,
+		Language: language,
+		Cues:     kept,
+	})
+	if err != nil {
+		return err
+	}
+
+	caption := &entities.LessonCaption{
+		LessonId:       lessonId,
+		Language:       language,
+		CueCount:       len(kept),
+		CoveredSeconds: coveredSeconds(kept),
+	}
+	if len(kept) > 0 {
+		key, err := uploadCaptions(ctx, s.store, s.cfg, lessonId, language, kept)
+		if err != nil {
+			return err
+		}
+		caption.ObjectKey = &key
+	}
+	if err := s.courses.Captioned(ctx, caption); err != nil {
+		return err
+	}
+	if s.cfg.Translation.Enabled && len(kept) > 0 {
+		if err := s.translations.Queue(ctx, lessonId); err != nil {
+			return err
+		}
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("lesson_id", lessonId.String()).
+		Str("language", language).
+		Int("cues", len(kept)).
+		Str("backend", s.cfg.Search.Backend).
+		Msg("transcript indexed")
+	return nil
+}
+
+func (s *transcriptService) Search(ctx context.Context, courseId uuid.UUID, query string, limit int) ([]search.Hit, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, errors.Join(ErrInvalidArgument, errors.New("q is required"))
+	}
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	return s.index.Search(ctx, courseId, query, min(limit, maxSearchLimit))
+}
+
+func (s *transcriptService) Captions(ctx context.Context, lessonId uuid.UUID) ([]*entities.LessonCaption, error) {
+	return s.repo.ListCaptions(ctx, lessonId)
+}
+
+// coveredSeconds is how much of the video the cues cover, counting the
+// stretches where they overlap once.
+func coveredSeconds(cues []search.Cue) float64 {
+	sorted := slices.Clone(cues)
+	slices.SortFunc(sorted, func(a, b search.Cue) int { return cmp.Compare(a.Start, b.Start) })
+
+	var covered, end float64
+	for _, cue := range sorted {
+		start := max(cue.Start, end)
+		if cue.End > start {
+			covered += cue.End - start
+			end = cue.End
+		}
+	}
+	return covered
+}
+
+// NewTranscriptService indexes into Elasticsearch when it is the configured
+// backend, and into the platform database otherwise.
+func NewTranscriptService(repo repository.TranscriptRepository, courses CourseService, translations TranslationService, redactions RedactionService, store objectstore.Store, cfg *config.Config) TranscriptService {
+	var index search.Index = repo
+	if cfg.Search.Backend == "elasticsearch" {
+		index = search.NewElasticsearch(cfg.Search)
+	}
+	return &transcriptService{
+		repo:         repo,
+		index:        index,
+		courses:      courses,
+		translations: translations,
+		redactions:   redactions,
+		store:        store,
+		cfg:          cfg,
+	}
+}
@@ -0,0 +1,268 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/correlation"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/pkg/reporting"
+	"worker-transcode/pkg/search"
+	"worker-transcode/pkg/translate"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+)
+
+// TranslationService machine-translates the captions sent for a lesson into
+// the configured languages, so students can follow a lesson in a language it
+// wasn't captioned in. Captions sent for a language replace its machine
+// translation, which is never made again while they are there.
+type TranslationService interface {
+	// Queue queues a job translating the lesson's captions.
+	Queue(ctx context.Context, lessonId uuid.UUID) error
+	// Process runs a translation job.
+	Process(ctx context.Context, message dto.TranslationMessage) error
+}
+
+type translationService struct {
+	transcripts repository.TranscriptRepository
+	jobs        repository.JobRepository
+	events      repository.JobEventRepository
+	courses     CourseService
+	publisher   rabbitmq.Publisher
+	store       objectstore.Store
+	cfg         *config.Config
+}
+
+func (s *translationService) Queue(ctx context.Context, lessonId uuid.UUID) error {
+	job := &entities.Job{
+		ID:         uuid.New(),
+		EntityId:   lessonId,
+		EntityType: string(constant.EntityTypeLessonVideo),
+		Status:     constant.JobStatusPending,
+		JobType:    constant.JobTypeTranslation,
+	}
+	if correlationId := correlation.FromContext(ctx); correlationId != "" {
+		job.CorrelationId = &correlationId
+	}
+	if err := s.jobs.CreateJob(ctx, job); err != nil {
+		return err
+	}
+	message := dto.TranslationMessage{JobId: job.ID}
+	if err := s.publisher.Publish(ctx, rabbitmq.TranslationTopology.Exchange, rabbitmq.TranslationTopology.RoutingKey, message); err != nil {
+		return err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("job_id", job.ID.String()).
+		Str("lesson_id", lessonId.String()).
+		Strs("languages", s.cfg.Translation.Languages).
+		Msg("caption translation queued")
+	return nil
+}
+
+func (s *translationService) Process(ctx context.Context, message dto.TranslationMessage) (err error) {
+	job, err := s.jobs.FindJobById(ctx, message.JobId)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to find job by id")
+		return err
+	}
+	if job.Status != constant.JobStatusPending {
+		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("job is not pending")
+		return nil
+	}
+
+	claimed, err := s.jobs.ClaimJob(ctx, message.JobId, correlation.FromContext(ctx), workerFromContext(ctx))
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to update job status")
+		return err
+	}
+	if !claimed {
+		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("job already claimed by another delivery")
+		return nil
+	}
+	rabbitmq.Claimed(ctx)
+	ctx = withTimeline(ctx, s.events, job.ID)
+	recordStatus(ctx, constant.JobStatusPending, constant.JobStatusProcessing)
+
+	stage := constant.ErrorClassDatabase
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = panicked(ctx, recovered)
+		}
+		if shuttingDown(ctx, err) {
+			err = handOff(ctx, s.jobs, message.JobId, err)
+			return
+		}
+		err = classify(err)
+		recordOutcome(ctx, job, stage, err)
+		recordOutcomeEvents(ctx, stage, err)
+		if err != nil {
+			if errors.Is(err, ErrNonRetryable) {
+				if updateErr := s.jobs.FailJob(ctx, message.JobId, stage, errorKind(err), err.Error()); updateErr != nil {
+					zerolog.Ctx(ctx).Error().Err(updateErr).Msg("failed to update job status")
+				}
+				reporting.CaptureFailure(ctx, err, reporting.Failure{
+					JobId:   message.JobId.String(),
+					JobType: string(job.JobType),
+					Stage:   string(stage),
+					Output:  failureOutput(err),
+					Extra:   map[string]interface{}{"lesson_id": job.EntityId.String()},
+				})
+				err = nil
+			} else {
+				if updateErr := s.jobs.UpdateStatusJob(ctx, constant.JobStatusPending, message.JobId); updateErr != nil {
+					zerolog.Ctx(ctx).Error().Err(updateErr).Msg("failed to update job status")
+				}
+			}
+		}
+	}()
+
+	translator, err := translate.New(s.cfg.Translation)
+	if err != nil {
+		return errors.Join(ErrNonRetryable, err)
+	}
+	captions, err := s.transcripts.ListCaptions(ctx, job.EntityId)
+	if err != nil {
+		return err
+	}
+	source := sourceCaption(captions)
+	if source == nil || source.ObjectKey == nil {
+		return errors.Join(ErrNonRetryable, fmt.Errorf("lesson %s has no captions to translate", job.EntityId))
+	}
+
+	stage = constant.ErrorClassDownload
+	object, err := s.store.GetObject(ctx, s.cfg.MinIOBucket, *source.ObjectKey, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	cues, err := search.ParseWebVTT(object)
+	object.Close()
+	if err != nil {
+		return fmt.Errorf("read captions %s: %w", *source.ObjectKey, err)
+	}
+	texts := make([]string, 0, len(cues))
+	for _, cue := range cues {
+		texts = append(texts, cue.Text)
+	}
+
+	var translated []string
+	for _, target := range s.cfg.Translation.Languages {
+		if !translationWanted(captions, source, target) {
+			continue
+		}
+
+		stage = constant.ErrorClassTranslate
+		err = traceStage(ctx, "translate_"+target, func(ctx context.Context) error {
+			var translateErr error
+			translated, translateErr = translator.Translate(ctx, texts, source.Language, target)
+			return translateErr
+		})
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Str("language", target).Msg("failed to translate captions")
+			return err
+		}
+		track := slices.Clone(cues)
+		for i := range track {
+			track[i].Text = strings.TrimSpace(translated[i])
+		}
+
+		stage = constant.ErrorClassUpload
+		key, err := uploadCaptions(ctx, s.store, s.cfg, job.EntityId, target, track)
+		if err != nil {
+			return err
+		}
+
+		stage = constant.ErrorClassDatabase
+		err = s.courses.Captioned(ctx, &entities.LessonCaption{
+			LessonId:          job.EntityId,
+			Language:          target,
+			CueCount:          len(track),
+			CoveredSeconds:    source.CoveredSeconds,
+			ObjectKey:         &key,
+			MachineTranslated: true,
+			SourceLanguage:    &source.Language,
+		})
+		if err != nil {
+			return err
+		}
+		zerolog.Ctx(ctx).Info().
+			Str("lesson_id", job.EntityId.String()).
+			Str("source_language", source.Language).
+			Str("language", target).
+			Int("cues", len(track)).
+			Msg("captions translated")
+	}
+
+	stage = constant.ErrorClassDatabase
+	return s.jobs.UpdateStatusJob(ctx, constant.JobStatusCompleted, message.JobId)
+}
+
+// sourceCaption is the lesson's track to translate from: the captions last
+// sent for it.
+func sourceCaption(captions []*entities.LessonCaption) *entities.LessonCaption {
+	for _, caption := range captions {
+		if !caption.MachineTranslated {
+			return caption
+		}
+	}
+	return nil
+}
+
+// translationWanted reports whether target needs a machine translation of
+// source: it isn't source's own language and wasn't captioned by hand.
+func translationWanted(captions []*entities.LessonCaption, source *entities.LessonCaption, target string) bool {
+	if strings.EqualFold(translate.BaseLanguage(target), translate.BaseLanguage(source.Language)) {
+		return false
+	}
+	for _, caption := range captions {
+		if strings.EqualFold(caption.Language, target) && !caption.MachineTranslated {
+			return false
+		}
+	}
+	return true
+}
+
+// uploadCaptions writes a lesson's caption track in language to the bucket
+// as WebVTT, replacing the one it had, and returns its key.
+func uploadCaptions(ctx context.Context, store objectstore.Store, cfg *config.Config, lessonId uuid.UUID, language string, cues []search.Cue) (string, error) {
+	var body bytes.Buffer
+	if err := search.WriteWebVTT(&body, cues); err != nil {
+		return "", err
+	}
+	key := captionKey(lessonId, language)
+	_, err := store.PutObject(ctx, cfg.MinIOBucket, key, &body, int64(body.Len()), minio.PutObjectOptions{
+		ContentType: "text/vtt",
+	})
+	if err != nil {
+		return "", fmt.Errorf("upload captions %s: %w", key, err)
+	}
+	return key, nil
+}
+
+func captionKey(lessonId uuid.UUID, language string) string {
+	return fmt.Sprintf("%s%s/captions/%s.vtt", lessonPrefix, lessonId, language)
+}
+
+func NewTranslationService(transcripts repository.TranscriptRepository, jobs repository.JobRepository, events repository.JobEventRepository, courses CourseService, publisher rabbitmq.Publisher, store objectstore.Store, cfg *config.Config) TranslationService {
+	return &translationService{
+		transcripts: transcripts,
+		jobs:        jobs,
+		events:      events,
+		courses:     courses,
+		publisher:   publisher,
+		store:       store,
+		cfg:         cfg,
+	}
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"worker-transcode/config"
+	"worker-transcode/dto"
+	"worker-transcode/pkg/ffmpeg"
+	"worker-transcode/pkg/objectstore"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// originalsDir sits next to a lesson's videos/ and keeps the untrimmed
+	// sources of trimmed lessons, out of reach of the orphan cleanup and of
+	// the newest upload lookup.
+	originalsDir = "originals"
+
+	// minTrimmedContent is the least a trim leaves, so a recording that is
+	// silent throughout is published as it is.
+	minTrimmedContent = 10.0
+)
+
+// trimWindow is the part of a recording kept after cutting dead air, in
+// seconds of the source.
+type trimWindow struct {
+	start, end float64
+}
+
+func (w trimWindow) cut(duration float64) float64 {
+	return w.start + duration - w.end
+}
+
+// detectDeadAir finds the silence the recording starts and ends with, such as
+// an instructor waiting for attendees, when it lasts at least MinDeadAir
+// seconds. Padding seconds of it are kept on either side of the content. It
+// reports false when there is nothing worth cutting or no audio.
+func detectDeadAir(ctx context.Context, inputFilepath string, duration float64, cfg config.Trim) (trimWindow, bool, error) {
+	window := trimWindow{end: duration}
+	if duration <= 0 {
+		return window, false, nil
+	}
+
+	args := []string{"-hide_banner", "-nostats", "-i", inputFilepath,
+		"-map", "0:a:0?",
+		"-af", fmt.Sprintf("silencedetect=noise=%s:d=%d", silenceNoise, max(cfg.MinDeadAir, 1)),
+		"-f", "null", "-",
+	}
+	cmd := ffmpeg.Command(ctx, args...)
+	killProcessGroup(cmd)
+	cmd.WaitDelay = ffmpegWaitDelay
+	zerolog.Ctx(ctx).Info().Str("command", strings.Join(cmd.Args, " ")).Msg("executing FFmpeg command")
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return window, false, err
+	}
+	if err := cmd.Start(); err != nil {
+		return window, false, &FFmpegError{Err: err}
+	}
+	done := trackFFmpeg()
+	defer done()
+
+	var (
+		pauses []pause
+		start  = -1.0
+		tail   = &tailBuffer{limit: maxFFmpegOutput}
+	)
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		line := scanner.Text()
+		tail.Write([]byte(line + "\n"))
+		switch {
+		case strings.Contains(line, "silence_start"):
+			if match := silenceStartPattern.FindStringSubmatch(line); match != nil {
+				start, _ = strconv.ParseFloat(match[1], 64)
+			}
+		case strings.Contains(line, "silence_end"):
+			if match := silenceEndPattern.FindStringSubmatch(line); match != nil && start >= 0 {
+				end, _ := strconv.ParseFloat(match[1], 64)
+				pauses = append(pauses, pause{start: start, end: end})
+				start = -1
+			}
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return window, false, &FFmpegError{Err: err, Output: tail.String()}
+	}
+	// Silence running into the end of the file may not be closed.
+	if start >= 0 {
+		pauses = append(pauses, pause{start: start, end: duration})
+	}
+	if len(pauses) == 0 {
+		return window, false, nil
+	}
+
+	padding := float64(max(cfg.Padding, 0))
+	if first := pauses[0]; first.start <= 0.5 {
+		window.start = max(first.end-padding, 0)
+	}
+	if last := pauses[len(pauses)-1]; last.end >= duration-0.5 && last.start > window.start {
+		window.end = min(last.start+padding, duration)
+	}
+	if window.start == 0 && window.end == duration {
+		return window, false, nil
+	}
+	if window.end-window.start < minTrimmedContent {
+		return trimWindow{end: duration}, false, nil
+	}
+	return window, true, nil
+}
+
+// trimFile copies the window of a file into dir without re-encoding. Cuts
+// land on the keyframe before the window's start, which the padding absorbs.
+func trimFile(ctx context.Context, inputFilepath, dir string, window trimWindow) (string, error) {
+	output := filepath.Join(dir, "trimmed_"+filepath.Base(inputFilepath))
+	args := []string{
+		"-ss", strconv.FormatFloat(window.start, 'f', 3, 64),
+		"-i", inputFilepath,
+		"-t", strconv.FormatFloat(window.end-window.start, 'f', 3, 64),
+		"-map", "0",
+		"-c", "copy",
+		"-avoid_negative_ts", "make_zero",
+		"-y", output,
+	}
+	if err := runFFmpeg(ctx, args, nil); err != nil {
+		return "", err
+	}
+	return output, nil
+}
+
+// shiftMarkers moves instructor chapter markers onto the trimmed timeline.
+// Markers inside the cut start collapse onto the start, where the last of
+// them wins.
+func shiftMarkers(markers []dto.ChapterMarker, window trimWindow) []dto.ChapterMarker {
+	shifted := make([]dto.ChapterMarker, 0, len(markers))
+	for _, marker := range markers {
+		marker.Start = max(marker.Start-window.start, 0)
+		if len(shifted) > 0 && shifted[len(shifted)-1].Start == marker.Start {
+			shifted = shifted[:len(shifted)-1]
+		}
+		shifted = append(shifted, marker)
+	}
+	return shifted
+}
+
+// originalKey is where the untrimmed source of a lesson upload is kept:
+// lessons/{id}/videos/{name} goes to lessons/{id}/originals/{name}.
+func originalKey(objectPath string) string {
+	return path.Join(path.Dir(path.Dir(objectPath)), originalsDir, path.Base(objectPath))
+}
+
+// keepOriginal moves the source of a trimmed job to its originals key, so the
+// trim can be undone.
+func keepOriginal(ctx context.Context, store objectstore.Store, cfg *config.Config, objectPath string) (string, error) {
+	key := originalKey(objectPath)
+	_, err := store.ComposeObject(ctx,
+		minio.CopyDestOptions{Bucket: cfg.MinIOBucket, Object: key},
+		minio.CopySrcOptions{Bucket: cfg.MinIOBucket, Object: objectPath})
+	if err != nil {
+		return "", fmt.Errorf("copy original: %w", err)
+	}
+	return key, store.RemoveObject(ctx, cfg.MinIOBucket, objectPath, minio.RemoveObjectOptions{})
+}
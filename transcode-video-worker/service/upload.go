@@ -0,0 +1,296 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/correlation"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+)
+
+var (
+	ErrOffsetMismatch = errors.New("upload offset mismatch")
+	ErrUploadTooLarge = errors.New("upload exceeds maximum size")
+)
+
+var unsafeFileNameChars = regexp.MustCompile(`[^a-zA-Z0-9.\-]`)
+
+// UploadService implements the storage side of the tus resumable upload
+// protocol. Partial uploads live on local disk; once the last byte arrives the
+// file is moved to MinIO and a transcode job is created and queued.
+type UploadService interface {
+	Create(ctx context.Context, length int64, metadata map[string]string) (*dto.UploadInfo, error)
+	Info(ctx context.Context, id string) (*dto.UploadInfo, error)
+	Append(ctx context.Context, id string, offset int64, body io.Reader) (*dto.UploadInfo, error)
+	Terminate(ctx context.Context, id string) error
+}
+
+type uploadService struct {
+	repo      repository.JobRepository
+	publisher rabbitmq.Publisher
+	store     objectstore.Store
+	cfg       *config.Config
+	locks     sync.Map
+}
+
+func (s *uploadService) Create(ctx context.Context, length int64, metadata map[string]string) (*dto.UploadInfo, error) {
+	if length < 0 {
+		return nil, errors.Join(ErrInvalidArgument, errors.New("Upload-Length is required"))
+	}
+	if length > s.cfg.Server.MaxUploadSize {
+		return nil, ErrUploadTooLarge
+	}
+	if _, err := uuid.Parse(metadata["lesson_id"]); err != nil {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("metadata lesson_id: %w", err))
+	}
+	if metadata["filename"] == "" {
+		return nil, errors.Join(ErrInvalidArgument, errors.New("metadata filename is required"))
+	}
+	if _, err := parseMarkers(metadata["chapters"]); err != nil {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("metadata chapters: %w", err))
+	}
+	if _, err := parseCuePoints(metadata["cue_points"]); err != nil {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("metadata cue_points: %w", err))
+	}
+	if _, err := parseAudioTracks(metadata["audio_tracks"]); err != nil {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("metadata audio_tracks: %w", err))
+	}
+	if _, err := parseWebcam(metadata["webcam"]); err != nil {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("metadata webcam: %w", err))
+	}
+	if _, err := parseEdit(metadata["edit"]); err != nil {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("metadata edit: %w", err))
+	}
+
+	if err := os.MkdirAll(s.cfg.Server.UploadDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	info := &dto.UploadInfo{
+		Id:        uuid.NewString(),
+		Length:    length,
+		Metadata:  metadata,
+		CreatedAt: time.Now(),
+	}
+	data, err := os.Create(s.dataPath(info.Id))
+	if err != nil {
+		return nil, err
+	}
+	if err := data.Close(); err != nil {
+		return nil, err
+	}
+	if err := s.writeInfo(info); err != nil {
+		return nil, err
+	}
+
+	zerolog.Ctx(ctx).Info().Str("upload_id", info.Id).Int64("length", length).Msg("tus upload created")
+
+	if length == 0 {
+		return s.complete(ctx, info)
+	}
+	return info, nil
+}
+
+func (s *uploadService) Info(ctx context.Context, id string) (*dto.UploadInfo, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+	raw, err := os.ReadFile(s.infoPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info := &dto.UploadInfo{}
+	if err := json.Unmarshal(raw, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (s *uploadService) Append(ctx context.Context, id string, offset int64, body io.Reader) (*dto.UploadInfo, error) {
+	unlock := s.lock(id)
+	defer unlock()
+
+	info, err := s.Info(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if info.JobId != nil {
+		return info, nil
+	}
+	if info.Offset != offset {
+		return nil, ErrOffsetMismatch
+	}
+
+	data, err := os.OpenFile(s.dataPath(id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	// Whatever was written before the client disconnected still counts, so the
+	// offset is persisted even when the copy fails part way.
+	written, copyErr := io.Copy(data, io.LimitReader(body, info.Length-info.Offset))
+	closeErr := data.Close()
+	info.Offset += written
+	if err := s.writeInfo(info); err != nil {
+		return nil, err
+	}
+	if copyErr != nil {
+		return info, copyErr
+	}
+	if closeErr != nil {
+		return info, closeErr
+	}
+
+	if info.Offset == info.Length {
+		return s.complete(ctx, info)
+	}
+	return info, nil
+}
+
+func (s *uploadService) Terminate(ctx context.Context, id string) error {
+	unlock := s.lock(id)
+	defer unlock()
+
+	if _, err := s.Info(ctx, id); err != nil {
+		return err
+	}
+	if err := os.Remove(s.dataPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.Remove(s.infoPath(id))
+}
+
+// complete stores the finished upload in MinIO under the same layout the API
+// uses for lesson videos, then creates and queues the transcode job.
+func (s *uploadService) complete(ctx context.Context, info *dto.UploadInfo) (*dto.UploadInfo, error) {
+	lessonId := uuid.MustParse(info.Metadata["lesson_id"])
+	fileName := unsafeFileNameChars.ReplaceAllString(info.Metadata["filename"], "_")
+	objectPath := fmt.Sprintf("lessons/%s/videos/%d-%s", lessonId, time.Now().UnixMilli(), fileName)
+
+	_, err := s.store.FPutObject(ctx, s.cfg.MinIOBucket, objectPath, s.dataPath(info.Id), minio.PutObjectOptions{
+		ContentType: info.Metadata["filetype"],
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	job := &entities.Job{
+		ID:         uuid.New(),
+		EntityId:   lessonId,
+		EntityType: string(constant.EntityTypeLessonVideo),
+		Status:     constant.JobStatusPending,
+		JobType:    constant.JobTypeTranscoder,
+	}
+	if correlationId := correlation.FromContext(ctx); correlationId != "" {
+		job.CorrelationId = &correlationId
+	}
+	if userId, err := uuid.Parse(info.Metadata["user_id"]); err == nil {
+		job.UserId = &userId
+	}
+	class := constant.ParseSLAClass(info.Metadata["sla_class"])
+	job.SLAClass = &class
+	// The duration picks the lane; a file ffprobe can't read is queued on
+	// its class's lane and rejected by the worker.
+	if media, err := ProbeMedia(ctx, s.dataPath(info.Id)); err == nil {
+		seconds := media.DurationSeconds()
+		job.SourceSeconds = &seconds
+	} else {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("upload_id", info.Id).Msg("failed to probe upload duration")
+	}
+	if err := s.repo.CreateJob(ctx, job); err != nil {
+		return nil, err
+	}
+
+	message := dto.JobMessage{
+		JobId:      job.ID,
+		ObjectPath: objectPath,
+		FileName:   fileName,
+		Preset:     info.Metadata["preset"],
+		SLAClass:   string(class),
+	}
+	// Checked when the upload was created.
+	message.Chapters, _ = parseMarkers(info.Metadata["chapters"])
+	message.CuePoints, _ = parseCuePoints(info.Metadata["cue_points"])
+	message.AudioTracks, _ = parseAudioTracks(info.Metadata["audio_tracks"])
+	message.Webcam, _ = parseWebcam(info.Metadata["webcam"])
+	message.Edit, _ = parseEdit(info.Metadata["edit"])
+	message.ScreenRecording = info.Metadata["screen_recording"] == "true"
+	topology := shardTopology(s.cfg, class, job.SourceSeconds)
+	if err := s.publisher.Publish(ctx, topology.Exchange, topology.RoutingKey, message); err != nil {
+		return nil, err
+	}
+
+	info.JobId = &job.ID
+	if err := os.Remove(s.dataPath(info.Id)); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("upload_id", info.Id).Msg("failed to remove upload data")
+	}
+	// The info file is kept so a client retrying a HEAD after completion still
+	// sees the final offset and the job it created.
+	if err := s.writeInfo(info); err != nil {
+		return nil, err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("upload_id", info.Id).
+		Str("job_id", job.ID.String()).
+		Str("object_path", objectPath).
+		Msg("tus upload completed and queued for transcoding")
+
+	return info, nil
+}
+
+func (s *uploadService) lock(id string) func() {
+	mu, _ := s.locks.LoadOrStore(id, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+func (s *uploadService) writeInfo(info *dto.UploadInfo) error {
+	raw, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	tmp := s.infoPath(info.Id) + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.infoPath(info.Id))
+}
+
+func (s *uploadService) dataPath(id string) string {
+	return filepath.Join(s.cfg.Server.UploadDir, id+".bin")
+}
+
+func (s *uploadService) infoPath(id string) string {
+	return filepath.Join(s.cfg.Server.UploadDir, id+".info")
+}
+
+func NewUploadService(repo repository.JobRepository, publisher rabbitmq.Publisher, store objectstore.Store, cfg *config.Config) UploadService {
+	return &uploadService{
+		repo:      repo,
+		publisher: publisher,
+		store:     store,
+		cfg:       cfg,
+	}
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"worker-transcode/pkg/objectstore"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// segmentDurationSlack is how far past EXT-X-TARGETDURATION a segment may
+	// run, since encoders round the target.
+	segmentDurationSlack = 0.5
+	// durationTolerance is how far playlist durations may drift from each
+	// other and from the source, as a fraction.
+	durationTolerance = 0.02
+)
+
+// VerifyReport is the result of checking a published HLS package.
+type VerifyReport struct {
+	Playlist  string          `json:"playlist"`
+	Playlists []PlaylistCheck `json:"playlists"`
+	Problems  []string        `json:"problems"`
+}
+
+// PlaylistCheck summarises one media playlist of a package.
+type PlaylistCheck struct {
+	URI            string   `json:"uri"`
+	Segments       int      `json:"segments"`
+	Bytes          int64    `json:"bytes"`
+	Seconds        float64  `json:"seconds"`
+	TargetDuration float64  `json:"target_duration"`
+	Problems       []string `json:"problems"`
+}
+
+// Err summarises the problems found, or returns nil for a sound package.
+func (r *VerifyReport) Err() error {
+	problems := len(r.Problems)
+	for _, playlist := range r.Playlists {
+		problems += len(playlist.Problems)
+	}
+	if problems == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s: %d problems found", r.Playlist, problems)
+}
+
+// VerifyHLS checks that every playlist a master references exists, is a
+// finished VOD playlist, and that each of its segments is in the bucket with a
+// non-zero size and a duration within the target. When expectedSeconds is
+// known, every playlist must also match it.
+func VerifyHLS(ctx context.Context, client objectstore.Store, bucket, masterKey string, expectedSeconds float64) (*VerifyReport, error) {
+	report := &VerifyReport{Playlist: masterKey, Playlists: []PlaylistCheck{}, Problems: []string{}}
+
+	master, err := readObjectLines(ctx, client, bucket, masterKey)
+	if err != nil {
+		return nil, fmt.Errorf("read master playlist: %w", err)
+	}
+
+	var uris []string
+	for i, line := range master {
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MEDIA:"):
+			if match := uriPattern.FindStringSubmatch(line); match != nil {
+				uris = append(uris, match[1])
+			}
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:") && i+1 < len(master):
+			uris = append(uris, master[i+1])
+		}
+	}
+	if len(uris) == 0 {
+		report.Problems = append(report.Problems, "master playlist references no playlists")
+		return report, nil
+	}
+
+	prefix := path.Dir(masterKey)
+	longest := 0.0
+	for _, uri := range uris {
+		check := verifyMediaPlaylist(ctx, client, bucket, path.Join(prefix, uri))
+		check.URI = uri
+		longest = math.Max(longest, check.Seconds)
+		report.Playlists = append(report.Playlists, check)
+	}
+
+	reference := longest
+	if expectedSeconds > 0 {
+		reference = expectedSeconds
+	}
+	for i := range report.Playlists {
+		check := &report.Playlists[i]
+		if check.Segments > 0 && math.Abs(check.Seconds-reference) > math.Max(1, reference*durationTolerance) {
+			check.Problems = append(check.Problems, fmt.Sprintf("runs %.1fs, expected about %.1fs", check.Seconds, reference))
+		}
+	}
+
+	return report, nil
+}
+
+func verifyMediaPlaylist(ctx context.Context, client objectstore.Store, bucket, key string) PlaylistCheck {
+	check := PlaylistCheck{Problems: []string{}}
+	lines, err := readObjectLines(ctx, client, bucket, key)
+	if err != nil {
+		check.Problems = append(check.Problems, fmt.Sprintf("playlist can't be read: %v", err))
+		return check
+	}
+
+	ended := false
+	duration := 0.0
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			check.TargetDuration, _ = strconv.ParseFloat(strings.TrimPrefix(line, "#EXT-X-TARGETDURATION:"), 64)
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			duration, _ = strconv.ParseFloat(value, 64)
+		case line == "#EXT-X-ENDLIST":
+			ended = true
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			match := uriPattern.FindStringSubmatch(line)
+			if match == nil {
+				check.Problems = append(check.Problems, "EXT-X-MAP has no URI")
+				continue
+			}
+			info, err := client.StatObject(ctx, bucket, path.Join(path.Dir(key), match[1]), minio.StatObjectOptions{})
+			switch {
+			case err != nil:
+				check.Problems = append(check.Problems, fmt.Sprintf("init segment %s is missing: %v", match[1], err))
+			case info.Size == 0:
+				check.Problems = append(check.Problems, fmt.Sprintf("init segment %s is empty", match[1]))
+			default:
+				check.Bytes += info.Size
+			}
+		case line != "" && !strings.HasPrefix(line, "#"):
+			check.Segments++
+			check.Seconds += duration
+			if duration <= 0 {
+				check.Problems = append(check.Problems, fmt.Sprintf("%s has no duration", line))
+			} else if check.TargetDuration > 0 && duration > check.TargetDuration+segmentDurationSlack {
+				check.Problems = append(check.Problems, fmt.Sprintf("%s runs %.2fs, over the %.0fs target", line, duration, check.TargetDuration))
+			}
+			duration = 0
+
+			info, err := client.StatObject(ctx, bucket, path.Join(path.Dir(key), line), minio.StatObjectOptions{})
+			switch {
+			case err != nil:
+				check.Problems = append(check.Problems, fmt.Sprintf("%s is missing: %v", line, err))
+			case info.Size == 0:
+				check.Problems = append(check.Problems, fmt.Sprintf("%s is empty", line))
+			default:
+				check.Bytes += info.Size
+			}
+		}
+	}
+
+	if check.Segments == 0 {
+		check.Problems = append(check.Problems, "playlist has no segments")
+	}
+	if !ended {
+		check.Problems = append(check.Problems, "playlist has no EXT-X-ENDLIST, the upload may be incomplete")
+	}
+	return check
+}
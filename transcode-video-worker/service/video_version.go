@@ -0,0 +1,226 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// versionExpiryInterval is how often retained versions past their grace
+// period are deleted.
+const versionExpiryInterval = time.Hour
+
+// VideoVersionService keeps the packages a lesson's video has had. Each job
+// publishes under a prefix of its own, addressed by what the package was
+// made from, so replacing a video leaves the previous package playable for
+// the grace period and a rollback is a switch of the lesson's manifest row
+// and video_url.
+type VideoVersionService interface {
+	// Publish makes the job's package the lesson's video, retaining the one
+	// it replaces, and counts the storage it takes up. A package of a job
+	// created before the lesson's current one is retained instead.
+	Publish(ctx context.Context, job *entities.Job, playlistKey string) error
+	List(ctx context.Context, lessonId uuid.UUID) ([]*entities.VideoVersion, error)
+	// Current returns the lesson's manifest row, the package it plays.
+	Current(ctx context.Context, lessonId uuid.UUID) (*entities.LessonPackage, error)
+	// Rollback makes a retained version the lesson's video again.
+	Rollback(ctx context.Context, lessonId, versionId uuid.UUID) (*entities.VideoVersion, error)
+	// Expire deletes retained versions past their grace period every
+	// versionExpiryInterval until ctx is done. Only the leader runs it.
+	Expire(ctx context.Context)
+}
+
+type videoVersionService struct {
+	repo    repository.VideoVersionRepository
+	storage StorageService
+	store   objectstore.Store
+	cfg     *config.Config
+}
+
+func (s *videoVersionService) Publish(ctx context.Context, job *entities.Job, playlistKey string) error {
+	playlistKey = filepath.ToSlash(playlistKey)
+	// A version is saved retained, without an expiry, until it's activated.
+	version := &entities.VideoVersion{
+		ID:          uuid.New(),
+		LessonId:    job.EntityId,
+		JobId:       job.ID,
+		PlaylistKey: playlistKey,
+		Status:      constant.VideoVersionStatusRetained,
+	}
+	if hash, presetVersion, ok := packageContent(playlistKey); ok {
+		version.ContentHash, version.PresetVersion = &hash, &presetVersion
+	}
+	if err := s.repo.SaveVersion(ctx, version); err != nil {
+		return err
+	}
+	current, err := s.repo.PublishVersion(ctx, version, job.CreatedAt, s.retainUntil())
+	if err != nil {
+		return err
+	}
+	if !current {
+		zerolog.Ctx(ctx).Warn().
+			Str("lesson_id", job.EntityId.String()).
+			Str("job_id", job.ID.String()).
+			Msg("lesson's video was replaced by a later job, package retained")
+	}
+	return s.storage.Record(ctx, job.EntityId, job.ID, playlistKey)
+}
+
+func (s *videoVersionService) List(ctx context.Context, lessonId uuid.UUID) ([]*entities.VideoVersion, error) {
+	return s.repo.ListVersions(ctx, lessonId)
+}
+
+func (s *videoVersionService) Current(ctx context.Context, lessonId uuid.UUID) (*entities.LessonPackage, error) {
+	current, err := s.repo.FindPackage(ctx, lessonId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, fmt.Errorf("lesson %s has no published video", lessonId))
+	}
+	return current, err
+}
+
+func (s *videoVersionService) Rollback(ctx context.Context, lessonId, versionId uuid.UUID) (*entities.VideoVersion, error) {
+	version, err := s.repo.FindVersion(ctx, lessonId, versionId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case version.Status == constant.VideoVersionStatusActive:
+		return version, nil
+	case version.Status != constant.VideoVersionStatusRetained:
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("version %s is %s", versionId, strings.ToLower(string(version.Status))))
+	// Past its expiry the version may be deleted at any moment.
+	case version.ExpiresAt != nil && !version.ExpiresAt.After(time.Now()):
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("version %s is past its grace period", versionId))
+	}
+
+	if err := s.repo.ActivateVersion(ctx, version, s.retainUntil()); err != nil {
+		return nil, err
+	}
+	zerolog.Ctx(ctx).Info().
+		Str("lesson_id", lessonId.String()).
+		Str("version_id", versionId.String()).
+		Msg("lesson video rolled back")
+	return s.repo.FindVersion(ctx, lessonId, versionId)
+}
+
+func (s *videoVersionService) Expire(ctx context.Context) {
+	ticker := time.NewTicker(versionExpiryInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.expire(ctx); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to delete expired video versions")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *videoVersionService) expire(ctx context.Context) error {
+	versions, err := s.repo.ListExpiredVersions(ctx, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		prefix := path.Dir(version.PlaylistKey) + "/"
+		objects := s.store.ListObjects(ctx, s.cfg.MinIOBucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true})
+		for result := range s.store.RemoveObjects(ctx, s.cfg.MinIOBucket, objects, minio.RemoveObjectsOptions{}) {
+			err = errors.Join(err, fmt.Errorf("remove %s: %w", result.ObjectName, result.Err))
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.storage.Release(ctx, version.JobId); err != nil {
+			return err
+		}
+		if err := s.repo.MarkVersionDeleted(ctx, version.ID); err != nil {
+			return err
+		}
+		zerolog.Ctx(ctx).Info().
+			Str("lesson_id", version.LessonId.String()).
+			Str("version_id", version.ID.String()).
+			Msg("expired video version deleted")
+	}
+	return nil
+}
+
+func (s *videoVersionService) retainUntil() time.Time {
+	return time.Now().UTC().Add(time.Duration(s.cfg.Versions.Grace) * time.Second)
+}
+
+// packagePrefix is where a job's package goes: a version of its own,
+// lessons/{id}/videos/{job id}, whether the source is an upload, a kept
+// original or the playlist of an earlier version.
+func packagePrefix(objectPath string, jobId uuid.UUID) string {
+	parts := strings.SplitN(filepath.ToSlash(objectPath), "/", 3)
+	if len(parts) == 3 && parts[0]+"/" == lessonPrefix {
+		return path.Join(lessonPrefix, parts[1], "videos", jobId.String())
+	}
+	return path.Join(path.Dir(filepath.ToSlash(objectPath)), jobId.String())
+}
+
+// contentPrefix is where a transcode's package goes: next to where
+// packagePrefix puts it, in a directory named by packageDir, then the job's
+// own, lessons/{id}/videos/{hash}-v{preset version}/{job id}. Jobs making
+// the same package share the first, but never write each other's objects.
+func contentPrefix(objectPath string, jobId uuid.UUID, dir string) string {
+	prefix := packagePrefix(objectPath, jobId)
+	return path.Join(path.Dir(prefix), dir, jobId.String())
+}
+
+// contentHashLength is how much of a package's output key its directory is
+// named by.
+const contentHashLength = 16
+
+// packageDir names the directory of the packages made from what hashes to
+// outputKey with the preset's version.
+func packageDir(outputKey string, presetVersion int) string {
+	return fmt.Sprintf("%s-v%d", outputKey[:min(len(outputKey), contentHashLength)], presetVersion)
+}
+
+var packageDirPattern = regexp.MustCompile(`^([0-9a-f]{16})-v([0-9]+)$`)
+
+// packageContent returns what the package of playlistKey is addressed by,
+// or false for one published under a job's prefix alone.
+func packageContent(playlistKey string) (string, int, bool) {
+	match := packageDirPattern.FindStringSubmatch(path.Base(path.Dir(path.Dir(playlistKey))))
+	if match == nil {
+		return "", 0, false
+	}
+	presetVersion, err := strconv.Atoi(match[2])
+	if err != nil {
+		return "", 0, false
+	}
+	return match[1], presetVersion, true
+}
+
+func NewVideoVersionService(repo repository.VideoVersionRepository, storage StorageService, store objectstore.Store, cfg *config.Config) VideoVersionService {
+	return &videoVersionService{
+		repo:    repo,
+		storage: storage,
+		store:   store,
+		cfg:     cfg,
+	}
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/warehouse"
+	"worker-transcode/repository"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// warehouseSettle is how long before an export a row must have changed to be
+// exported by it, so rows written by transactions still open when it reads
+// aren't skipped past.
+const warehouseSettle = 5 * time.Minute
+
+// WarehouseService exports the pipeline's history to the analytics
+// warehouse as Parquet files, so the data team can query it without reading
+// the operational database. Each dataset is exported from where its last
+// export stopped, a day at a time, into a file under the day's partition.
+type WarehouseService interface {
+	// Run exports every WAREHOUSE_EXPORT_INTERVAL minutes until ctx is done.
+	// Only the leader runs it.
+	Run(ctx context.Context)
+	// Export writes the rows of every dataset changed since its last export.
+	Export(ctx context.Context) error
+}
+
+// warehouseDataset is a dataset and how its rows changed in [from, to) are
+// encoded, nil with no rows.
+type warehouseDataset struct {
+	name   constant.WarehouseDataset
+	encode func(ctx context.Context, from, to time.Time) (int, []byte, error)
+}
+
+type warehouseService struct {
+	repo     repository.WarehouseRepository
+	datasets []warehouseDataset
+	store    objectstore.Store
+	cfg      *config.Config
+}
+
+func (s *warehouseService) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(s.cfg.Warehouse.Interval) * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Export(ctx); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to export to analytics warehouse")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Export exports each dataset on its own, so one that fails doesn't hold the
+// others back; it is picked up where it stopped next time.
+func (s *warehouseService) Export(ctx context.Context) error {
+	through := time.Now().Add(-warehouseSettle)
+	var errs []error
+	for _, dataset := range s.datasets {
+		if err := s.exportDataset(ctx, dataset, through); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", dataset.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *warehouseService) exportDataset(ctx context.Context, dataset warehouseDataset, through time.Time) error {
+	var from time.Time
+	export, err := s.repo.FindExport(ctx, dataset.name)
+	switch {
+	case err == nil:
+		from = export.ExportedThrough
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		first, err := s.repo.FirstChange(ctx, dataset.name)
+		if err != nil || first == nil {
+			return err
+		}
+		from = *first
+	default:
+		return err
+	}
+
+	for from.Before(through) && ctx.Err() == nil {
+		to := nextDay(from)
+		if to.After(through) {
+			to = through
+		}
+		rows, file, err := dataset.encode(ctx, from, to)
+		if err != nil {
+			return err
+		}
+		if rows > 0 {
+			key := warehouse.Key(string(dataset.name), from)
+			_, err := s.store.PutObject(ctx, s.cfg.Warehouse.Bucket, key, bytes.NewReader(file), int64(len(file)), minio.PutObjectOptions{
+				ContentType: "application/vnd.apache.parquet",
+			})
+			if err != nil {
+				return fmt.Errorf("upload %s: %w", key, err)
+			}
+			zerolog.Ctx(ctx).Info().Str("dataset", string(dataset.name)).Str("key", key).Int("rows", rows).Msg("warehouse file exported")
+		}
+		if err := s.repo.SaveExport(ctx, &entities.WarehouseExport{Dataset: dataset.name, ExportedThrough: to}); err != nil {
+			return err
+		}
+		from = to
+	}
+	return nil
+}
+
+// nextDay is the UTC midnight after t, where an export's window ends so its
+// file holds one day's partition.
+func nextDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+}
+
+// encodeRows encodes the rows list returns as one Parquet file.
+func encodeRows[T any](list func(ctx context.Context, from, to time.Time) ([]T, error)) func(ctx context.Context, from, to time.Time) (int, []byte, error) {
+	return func(ctx context.Context, from, to time.Time) (int, []byte, error) {
+		rows, err := list(ctx, from, to)
+		if err != nil || len(rows) == 0 {
+			return 0, nil, err
+		}
+		file, err := warehouse.Encode(rows)
+		return len(rows), file, err
+	}
+}
+
+func NewWarehouseService(repo repository.WarehouseRepository, store objectstore.Store, cfg *config.Config) WarehouseService {
+	return &warehouseService{
+		repo: repo,
+		datasets: []warehouseDataset{
+			{name: constant.WarehouseDatasetJobs, encode: encodeRows(repo.ListJobs)},
+			{name: constant.WarehouseDatasetRenditions, encode: encodeRows(repo.ListRenditions)},
+			{name: constant.WarehouseDatasetQC, encode: encodeRows(repo.ListQCFlags)},
+			{name: constant.WarehouseDatasetUsage, encode: encodeRows(repo.ListEncodingUsage)},
+			{name: constant.WarehouseDatasetStorage, encode: encodeRows(repo.ListStorageUsage)},
+		},
+		store: store,
+		cfg:   cfg,
+	}
+}
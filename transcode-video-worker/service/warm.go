@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/pkg/ffmpeg"
+	"worker-transcode/pkg/objectstore"
+
+	"github.com/rs/zerolog"
+)
+
+// maxCachedProbes bounds the probe cache. A job probes a handful of files,
+// so this covers every job a worker runs at once.
+const maxCachedProbes = 256
+
+// encoderCache holds the encoders this ffmpeg build has. The binary doesn't
+// change under a running worker, so a successful listing is kept for good.
+var encoderCache struct {
+	mu       sync.Mutex
+	encoders []string
+}
+
+// ffmpegEncoders lists the encoders of this ffmpeg build, running ffmpeg
+// only until a listing succeeds.
+func ffmpegEncoders(ctx context.Context) ([]string, error) {
+	encoderCache.mu.Lock()
+	defer encoderCache.mu.Unlock()
+	if encoderCache.encoders != nil {
+		return encoderCache.encoders, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	output, err := ffmpeg.Command(ctx, "-hide_banner", "-encoders").Output()
+	if err != nil {
+		return nil, err
+	}
+	encoders := []string{}
+	for _, line := range strings.Split(string(output), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 {
+			encoders = append(encoders, fields[1])
+		}
+	}
+	encoderCache.encoders = encoders
+	return encoders, nil
+}
+
+// probeKey identifies a file's contents well enough for the probe cache: a
+// file rewritten in place gets a new size or modification time.
+type probeKey struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+var probeCache struct {
+	mu    sync.Mutex
+	media map[probeKey]*MediaInfo
+}
+
+// cachedProbe returns a copy of what ProbeMedia found for the file at path,
+// if it hasn't changed since.
+func cachedProbe(path string) (*MediaInfo, probeKey, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, probeKey{}, false
+	}
+	key := probeKey{path: path, size: info.Size(), modTime: info.ModTime()}
+	probeCache.mu.Lock()
+	defer probeCache.mu.Unlock()
+	media, ok := probeCache.media[key]
+	if !ok {
+		return nil, key, false
+	}
+	return media.clone(), key, true
+}
+
+func storeProbe(key probeKey, media *MediaInfo) {
+	if key.path == "" {
+		return
+	}
+	probeCache.mu.Lock()
+	defer probeCache.mu.Unlock()
+	if probeCache.media == nil || len(probeCache.media) >= maxCachedProbes {
+		probeCache.media = map[probeKey]*MediaInfo{}
+	}
+	probeCache.media[key] = media.clone()
+}
+
+func (m *MediaInfo) clone() *MediaInfo {
+	clone := *m
+	clone.Streams = slices.Clone(m.Streams)
+	return &clone
+}
+
+// WarmUp does the work every job would otherwise start with once, before
+// the consumers take any: the scratch root, the encoder listing and a
+// pooled connection to the bucket. Failures are left for the jobs to hit.
+func WarmUp(ctx context.Context, store objectstore.Store, cfg *config.Config) {
+	if err := os.MkdirAll("temp", os.ModePerm); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to create scratch directory")
+	}
+	if _, err := ffmpegEncoders(ctx); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to list ffmpeg encoders")
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if _, err := store.BucketExists(ctx, cfg.MinIOBucket); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to reach bucket")
+	}
+}
@@ -0,0 +1,395 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/correlation"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/pkg/reporting"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+const (
+	// watermarkPrefix keeps watermarked renditions apart from lesson videos,
+	// under watermarks/{lesson id}/{rendition id}/.
+	watermarkPrefix   = "watermarks"
+	watermarkPlaylist = "index.m3u8"
+
+	// watermarkExpiryInterval is how often expired renditions are deleted.
+	watermarkExpiryInterval = time.Hour
+)
+
+// watermarkAlpha is how opaque the identifier is drawn. An invisible mark
+// can't be seen in playback but comes out when a leaked copy's contrast is
+// raised.
+var watermarkAlpha = map[string]float64{
+	"visible":   0.35,
+	"invisible": 0.04,
+}
+
+// WatermarkService makes short-lived renditions of a lesson carrying an
+// identifier of the student watching it, for content where piracy matters. A
+// rendition is made at the student's first playback request and served from
+// the cache until it expires; its code traces a leaked copy back to them.
+type WatermarkService interface {
+	// Request returns the student's rendition of the lesson, queueing a job
+	// for it when there is none yet. Until the job is done it is pending.
+	Request(ctx context.Context, lessonId, userId uuid.UUID) (*entities.WatermarkRendition, error)
+	// Trace returns the rendition an identifier seen in a leaked copy
+	// belongs to.
+	Trace(ctx context.Context, code string) (*entities.WatermarkRendition, error)
+	// Process runs a watermark job.
+	Process(ctx context.Context, message dto.WatermarkMessage) error
+	// Expire deletes renditions past their expiry every
+	// watermarkExpiryInterval until ctx is done. Only the leader runs it.
+	Expire(ctx context.Context)
+}
+
+type watermarkService struct {
+	repo      repository.WatermarkRepository
+	jobs      repository.JobRepository
+	events    repository.JobEventRepository
+	publisher rabbitmq.Publisher
+	store     objectstore.Store
+	cfg       *config.Config
+}
+
+func (s *watermarkService) Request(ctx context.Context, lessonId, userId uuid.UUID) (*entities.WatermarkRendition, error) {
+	if userId == uuid.Nil {
+		return nil, errors.Join(ErrInvalidArgument, errors.New("user_id is required"))
+	}
+	rendition, err := s.repo.FindLiveWatermark(ctx, lessonId, userId)
+	if err == nil {
+		return rendition, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	videoURL, err := s.repo.FindLessonVideoURL(ctx, lessonId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if videoURL == "" {
+		return nil, errors.Join(ErrNotFound, fmt.Errorf("lesson %s has no published video", lessonId))
+	}
+
+	code, err := watermarkCode()
+	if err != nil {
+		return nil, err
+	}
+	job := &entities.Job{
+		ID:         uuid.New(),
+		EntityId:   lessonId,
+		EntityType: string(constant.EntityTypeLessonVideo),
+		Status:     constant.JobStatusPending,
+		JobType:    constant.JobTypeWatermark,
+		UserId:     &userId,
+	}
+	if correlationId := correlation.FromContext(ctx); correlationId != "" {
+		job.CorrelationId = &correlationId
+	}
+	rendition = &entities.WatermarkRendition{
+		ID:       uuid.New(),
+		LessonId: lessonId,
+		UserId:   userId,
+		JobId:    job.ID,
+		Code:     code,
+		Status:   constant.WatermarkStatusPending,
+	}
+	// The rendition goes first: a concurrent request for the same student
+	// loses on its unique index and returns the winner's.
+	if err := s.repo.CreateWatermark(ctx, rendition); err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return s.repo.FindLiveWatermark(ctx, lessonId, userId)
+		}
+		return nil, err
+	}
+	if err := s.jobs.CreateJob(ctx, job); err != nil {
+		s.fail(ctx, rendition.ID)
+		return nil, err
+	}
+	message := dto.WatermarkMessage{JobId: job.ID}
+	if err := s.publisher.Publish(ctx, rabbitmq.WatermarkTopology.Exchange, rabbitmq.WatermarkTopology.RoutingKey, message); err != nil {
+		s.fail(ctx, rendition.ID)
+		return nil, err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("job_id", job.ID.String()).
+		Str("lesson_id", lessonId.String()).
+		Str("user_id", userId.String()).
+		Msg("watermark rendition queued")
+	return rendition, nil
+}
+
+func (s *watermarkService) Trace(ctx context.Context, code string) (*entities.WatermarkRendition, error) {
+	rendition, err := s.repo.FindWatermarkByCode(ctx, code)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	return rendition, err
+}
+
+func (s *watermarkService) Process(ctx context.Context, message dto.WatermarkMessage) (err error) {
+	job, err := s.jobs.FindJobById(ctx, message.JobId)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to find job by id")
+		return err
+	}
+	if job.Status != constant.JobStatusPending {
+		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("job is not pending")
+		return nil
+	}
+	rendition, err := s.repo.FindWatermarkByJob(ctx, message.JobId)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to find watermark rendition")
+		return err
+	}
+
+	claimed, err := s.jobs.ClaimJob(ctx, message.JobId, correlation.FromContext(ctx), workerFromContext(ctx))
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to update job status")
+		return err
+	}
+	if !claimed {
+		zerolog.Ctx(ctx).Info().Str("job_id", message.JobId.String()).Msg("job already claimed by another delivery")
+		return nil
+	}
+	rabbitmq.Claimed(ctx)
+	ctx = withTimeline(ctx, s.events, job.ID)
+	recordStatus(ctx, constant.JobStatusPending, constant.JobStatusProcessing)
+
+	stage := constant.ErrorClassWorkspace
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = panicked(ctx, recovered)
+		}
+		if errors.Is(err, ErrInsufficientResources) {
+			postpone(ctx, s.jobs, message.JobId, err)
+			return
+		}
+		if shuttingDown(ctx, err) {
+			err = handOff(ctx, s.jobs, message.JobId, err)
+			return
+		}
+		err = classify(err)
+		recordOutcome(ctx, job, stage, err)
+		recordOutcomeEvents(ctx, stage, err)
+		if err != nil {
+			if errors.Is(err, ErrNonRetryable) {
+				if updateErr := s.jobs.FailJob(ctx, message.JobId, stage, errorKind(err), err.Error()); updateErr != nil {
+					zerolog.Ctx(ctx).Error().Err(updateErr).Msg("failed to update job status")
+				}
+				s.fail(ctx, rendition.ID)
+				reporting.CaptureFailure(ctx, err, reporting.Failure{
+					JobId:   message.JobId.String(),
+					JobType: string(job.JobType),
+					Stage:   string(stage),
+					Output:  failureOutput(err),
+					Extra:   map[string]interface{}{"lesson_id": rendition.LessonId.String()},
+				})
+				err = nil
+			} else {
+				if updateErr := s.jobs.UpdateStatusJob(ctx, constant.JobStatusPending, message.JobId); updateErr != nil {
+					zerolog.Ctx(ctx).Error().Err(updateErr).Msg("failed to update job status")
+				}
+			}
+		}
+	}()
+
+	alpha, ok := watermarkAlpha[s.cfg.Watermark.Mode]
+	if !ok {
+		return errors.Join(ErrNonRetryable, fmt.Errorf("unknown watermark mode %q", s.cfg.Watermark.Mode))
+	}
+
+	tempDir := filepath.Join("temp", message.JobId.String())
+	defer os.RemoveAll(tempDir)
+	inputDir := filepath.Join(tempDir, "input")
+	outputDir := filepath.Join(tempDir, "output")
+	for _, dir := range []string{inputDir, outputDir} {
+		if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+			return errors.Join(ErrNonRetryable, err)
+		}
+	}
+
+	stage = constant.ErrorClassDatabase
+	videoURL, err := s.repo.FindLessonVideoURL(ctx, rendition.LessonId)
+	if err != nil {
+		return err
+	}
+	if videoURL == "" {
+		return errors.Join(ErrNonRetryable, fmt.Errorf("lesson %s has no published video", rendition.LessonId))
+	}
+
+	stage = constant.ErrorClassDownload
+	var inputFilepath, audioFilepath string
+	err = traceStage(ctx, "download", func(ctx context.Context) error {
+		var downloadErr error
+		inputFilepath, audioFilepath, downloadErr = downloadHLSSource(ctx, s.store, s.cfg.MinIOBucket, videoURL, inputDir)
+		return downloadErr
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to download published video")
+		return err
+	}
+
+	stage = constant.ErrorClassTranscode
+	err = traceStage(ctx, "transcode", func(ctx context.Context) error {
+		args := watermarkArgs(inputFilepath, audioFilepath, outputDir, rendition.Code, alpha, s.cfg.Watermark.Height, s.cfg.Server.FFmpegThreads)
+		return runFFmpeg(ctx, args, nil)
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to watermark video")
+		return errors.Join(ErrNonRetryable, err)
+	}
+
+	stage = constant.ErrorClassUpload
+	prefix := path.Join(watermarkPrefix, rendition.LessonId.String(), rendition.ID.String())
+	err = traceStage(ctx, "upload", func(ctx context.Context) error {
+		_, uploadErr := uploadDirectory(ctx, s.store, s.cfg.MinIOBucket, outputDir, prefix)
+		return uploadErr
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to upload watermarked rendition")
+		return err
+	}
+
+	stage = constant.ErrorClassDatabase
+	expiresAt := time.Now().UTC().Add(time.Duration(s.cfg.Watermark.TTL) * time.Second)
+	if err = s.repo.MarkWatermarkReady(ctx, rendition.ID, path.Join(prefix, watermarkPlaylist), expiresAt); err != nil {
+		return err
+	}
+	if err = s.jobs.UpdateStatusJob(ctx, constant.JobStatusCompleted, message.JobId); err != nil {
+		return err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("job_id", message.JobId.String()).
+		Str("lesson_id", rendition.LessonId.String()).
+		Time("expires_at", expiresAt).
+		Msg("watermark rendition ready")
+	return nil
+}
+
+func (s *watermarkService) Expire(ctx context.Context) {
+	ticker := time.NewTicker(watermarkExpiryInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.expire(ctx); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to delete expired watermark renditions")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *watermarkService) expire(ctx context.Context) error {
+	renditions, err := s.repo.ListExpiredWatermarks(ctx, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	for _, rendition := range renditions {
+		prefix := path.Join(watermarkPrefix, rendition.LessonId.String(), rendition.ID.String()) + "/"
+		objects := s.store.ListObjects(ctx, s.cfg.MinIOBucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true})
+		for result := range s.store.RemoveObjects(ctx, s.cfg.MinIOBucket, objects, minio.RemoveObjectsOptions{}) {
+			err = errors.Join(err, fmt.Errorf("remove %s: %w", result.ObjectName, result.Err))
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.repo.ExpireWatermark(ctx, rendition.ID); err != nil {
+			return err
+		}
+		zerolog.Ctx(ctx).Info().Str("rendition_id", rendition.ID.String()).Msg("expired watermark rendition deleted")
+	}
+	return nil
+}
+
+func (s *watermarkService) fail(ctx context.Context, id uuid.UUID) {
+	if err := s.repo.MarkWatermarkFailed(context.WithoutCancel(ctx), id); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to mark watermark rendition failed")
+	}
+}
+
+// watermarkArgs encodes one rendition of at most height lines with code drawn
+// over it. The mark drifts across the picture so cropping a corner doesn't
+// remove it, and the code is also written into the container metadata.
+func watermarkArgs(inputFilepath, audioFilepath, outputDir, code string, alpha float64, height, threads int) []string {
+	args := []string{"-i", inputFilepath}
+	audioMap := "0:a:0?"
+	if audioFilepath != "" {
+		args = append(args, "-i", audioFilepath)
+		audioMap = "1:a:0?"
+	}
+	filter := fmt.Sprintf("scale=-2:'min(ih,%d)',"+
+		"drawtext=text='%s':fontsize=h/18:fontcolor=white@%g:borderw=1:bordercolor=black@%g:"+
+		"x='mod(t*37,w-tw)':y='h*0.1+mod(t*23,h*0.8-th)'",
+		height, code, alpha, alpha)
+	args = append(args,
+		"-map", "0:v:0",
+		"-map", audioMap,
+		"-vf", filter,
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-crf", "23",
+		"-c:a", "aac",
+		"-b:a", "128k",
+		"-metadata", "comment=wm:"+code,
+	)
+	if threads > 0 {
+		args = append(args, "-threads", fmt.Sprint(threads))
+	}
+	return append(args,
+		"-f", "hls",
+		"-hls_time", "6",
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(outputDir, "wm_%03d.ts"),
+		filepath.Join(outputDir, watermarkPlaylist),
+	)
+}
+
+// watermarkCode is a random identifier, so a code says nothing about its
+// student without the table.
+func watermarkCode() (string, error) {
+	raw := make([]byte, 6)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+func NewWatermarkService(repo repository.WatermarkRepository, jobs repository.JobRepository, events repository.JobEventRepository, publisher rabbitmq.Publisher, store objectstore.Store, cfg *config.Config) WatermarkService {
+	return &watermarkService{
+		repo:      repo,
+		jobs:      jobs,
+		events:    events,
+		publisher: publisher,
+		store:     store,
+		cfg:       cfg,
+	}
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"worker-transcode/config"
+	"worker-transcode/dto"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	webcamLayoutCorner     = "corner"
+	webcamLayoutSideBySide = "side_by_side"
+
+	webcamAudioScreen = "screen"
+	webcamAudioWebcam = "webcam"
+)
+
+// webcamCorners places an inset webcam, as overlay coordinates around a
+// margin of m pixels.
+var webcamCorners = map[string]func(m int) (string, string){
+	"bottom_right": func(m int) (string, string) {
+		return fmt.Sprintf("main_w-overlay_w-%d", m), fmt.Sprintf("main_h-overlay_h-%d", m)
+	},
+	"bottom_left": func(m int) (string, string) { return strconv.Itoa(m), fmt.Sprintf("main_h-overlay_h-%d", m) },
+	"top_right":   func(m int) (string, string) { return fmt.Sprintf("main_w-overlay_w-%d", m), strconv.Itoa(m) },
+	"top_left":    func(m int) (string, string) { return strconv.Itoa(m), strconv.Itoa(m) },
+}
+
+func parseWebcam(raw string) (*dto.Webcam, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var webcam dto.Webcam
+	if err := json.Unmarshal([]byte(raw), &webcam); err != nil {
+		return nil, err
+	}
+	return &webcam, validateWebcam(&webcam)
+}
+
+// validateWebcam checks a job's webcam recording, filling in the default
+// layout, corner and audio.
+func validateWebcam(webcam *dto.Webcam) error {
+	if webcam == nil {
+		return nil
+	}
+	if webcam.ObjectPath == "" {
+		return fmt.Errorf("webcam: object path is required")
+	}
+	if isHLSSource(webcam.ObjectPath) {
+		return fmt.Errorf("webcam: must be a video file, not a playlist")
+	}
+	if webcam.Layout == "" {
+		webcam.Layout = webcamLayoutCorner
+	}
+	if webcam.Layout != webcamLayoutCorner && webcam.Layout != webcamLayoutSideBySide {
+		return fmt.Errorf("webcam: unknown layout %q", webcam.Layout)
+	}
+	if webcam.Corner == "" {
+		webcam.Corner = "bottom_right"
+	}
+	if _, ok := webcamCorners[webcam.Corner]; !ok {
+		return fmt.Errorf("webcam: unknown corner %q", webcam.Corner)
+	}
+	if webcam.Audio == "" {
+		webcam.Audio = webcamAudioScreen
+	}
+	if webcam.Audio != webcamAudioScreen && webcam.Audio != webcamAudioWebcam {
+		return fmt.Errorf("webcam: audio is %s or %s, not %q", webcamAudioScreen, webcamAudioWebcam, webcam.Audio)
+	}
+	return nil
+}
+
+// compositeWebcam downloads the job's webcam recording and composites it
+// with the screen capture into one file under dir, which it returns. The
+// output runs as long as the screen capture; the screen shows alone where
+// the webcam recording doesn't cover it. The kept audio falls back to the
+// other recording's when its own has none.
+func (s service) compositeWebcam(ctx context.Context, screenPath string, screen *MediaInfo, webcam *dto.Webcam, dir string) (string, error) {
+	video := screen.VideoStream()
+	if video == nil {
+		return "", errors.Join(ErrNonRetryable, errors.New("screen capture has no video to composite the webcam onto"))
+	}
+	webcamPath := filepath.Join(dir, "webcam_"+filepath.Base(webcam.ObjectPath))
+	if err := s.store.FGetObject(ctx, s.cfg.MinIOBucket, webcam.ObjectPath, webcamPath, minio.GetObjectOptions{}); err != nil {
+		return "", fmt.Errorf("download webcam %s: %w", webcam.ObjectPath, err)
+	}
+	camera, err := ProbeMedia(ctx, webcamPath)
+	if err != nil {
+		return "", err
+	}
+	cameraVideo := camera.VideoStream()
+	if cameraVideo == nil || cameraVideo.Height == 0 {
+		return "", errors.Join(ErrNonRetryable, fmt.Errorf("webcam recording %s has no video", webcam.ObjectPath))
+	}
+
+	audio := map[string]bool{webcamAudioScreen: hasAudioStream(screen), webcamAudioWebcam: hasAudioStream(camera)}
+	audioInput := -1
+	switch {
+	case audio[webcam.Audio]:
+		audioInput = map[string]int{webcamAudioScreen: 0, webcamAudioWebcam: 1}[webcam.Audio]
+	case audio[webcamAudioScreen]:
+		audioInput = 0
+	case audio[webcamAudioWebcam]:
+		audioInput = 1
+	}
+
+	output := filepath.Join(dir, "composited.mp4")
+	args := webcamArgs(s.cfg.Webcam, screenPath, webcamPath, video, cameraVideo, webcam, screen.DurationSeconds(), audioInput, s.cfg.Server.FFmpegThreads, output)
+	if err := runFFmpeg(ctx, args, nil); err != nil {
+		return "", err
+	}
+	os.Remove(webcamPath)
+	return output, nil
+}
+
+// webcamArgs builds the composite, cut to the screen capture's duration. The
+// webcam input is shifted by its offset so both recordings share the screen
+// capture's timeline.
+func webcamArgs(cfg config.Webcam, screenPath, webcamPath string, screen, camera *ProbeStream, webcam *dto.Webcam, duration float64, audioInput, threads int, output string) []string {
+	args := []string{"-i", screenPath}
+	switch {
+	case webcam.Offset > 0:
+		args = append(args, "-itsoffset", strconv.FormatFloat(webcam.Offset, 'f', 3, 64))
+	case webcam.Offset < 0:
+		args = append(args, "-ss", strconv.FormatFloat(-webcam.Offset, 'f', 3, 64))
+	}
+	args = append(args, "-i", webcamPath)
+
+	height := screen.Height &^ 1
+	var graph string
+	if webcam.Layout == webcamLayoutSideBySide {
+		screenWidth := (screen.Width*height/screen.Height + 1) &^ 1
+		cameraWidth := (camera.Width*height/camera.Height + 1) &^ 1
+		graph = fmt.Sprintf("[0:v]scale=%d:%d,setsar=1,pad=%d:%d:0:0[bg];[1:v]scale=%d:%d,setsar=1[cam];"+
+			"[bg][cam]overlay=%d:0:eof_action=pass,format=yuv420p[v]",
+			screenWidth, height, screenWidth+cameraWidth, height, cameraWidth, height, screenWidth)
+	} else {
+		scale := min(max(cfg.Scale, 0.05), 1)
+		cameraWidth := int(float64(screen.Width)*scale) &^ 1
+		x, y := webcamCorners[webcam.Corner](max(cfg.Margin, 0))
+		graph = fmt.Sprintf("[1:v]scale=%d:-2,setsar=1[cam];[0:v]setsar=1[bg];[bg][cam]overlay=%s:%s:eof_action=pass,format=yuv420p[v]",
+			cameraWidth, x, y)
+	}
+	args = append(args, "-filter_complex", graph, "-map", "[v]")
+	if audioInput >= 0 {
+		args = append(args, "-map", fmt.Sprintf("%d:a:0", audioInput), "-c:a", "aac", "-b:a", "192k")
+	}
+	if duration > 0 {
+		args = append(args, "-t", strconv.FormatFloat(duration, 'f', 3, 64))
+	}
+	args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-crf", "16")
+	if threads > 0 {
+		args = append(args, "-threads", strconv.Itoa(threads))
+	}
+	return append(args, "-y", output)
+}
+
+func hasAudioStream(media *MediaInfo) bool {
+	for _, stream := range media.Streams {
+		if stream.CodecType == "audio" {
+			return true
+		}
+	}
+	return false
+}
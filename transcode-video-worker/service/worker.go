@@ -0,0 +1,344 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/pkg/version"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// workerRetention is how long rows of workers that are gone stay listed.
+const workerRetention = 7 * 24 * time.Hour
+
+// videoEncoders are the encoders worth advertising; NVENC ones mark a GPU.
+var videoEncoders = []string{"libx264", "libx265", "libsvtav1", "libvpx-vp9", "h264_nvenc", "hevc_nvenc", "av1_nvenc"}
+
+type workerKey struct{}
+
+// WithWorker marks ctx as running on the registered worker, so jobs claimed
+// under it record which worker holds them and are checked against its
+// capabilities.
+func WithWorker(ctx context.Context, worker *entities.Worker) context.Context {
+	return context.WithValue(ctx, workerKey{}, worker)
+}
+
+func workerFromContext(ctx context.Context) *uuid.UUID {
+	worker, ok := ctx.Value(workerKey{}).(*entities.Worker)
+	if !ok {
+		return nil
+	}
+	return &worker.ID
+}
+
+// capabilitiesFromContext returns what the registered worker advertised, or
+// false when it didn't register.
+func capabilitiesFromContext(ctx context.Context) (entities.WorkerCapabilities, bool) {
+	worker, ok := ctx.Value(workerKey{}).(*entities.Worker)
+	if !ok {
+		return entities.WorkerCapabilities{}, false
+	}
+	return worker.Capabilities, true
+}
+
+// WorkerService keeps this process's row in the worker registry and hands the
+// jobs of workers that stopped heartbeating to the rest of the fleet.
+type WorkerService interface {
+	// Register records the worker and returns it; lanes maps each consumed
+	// queue to its concurrency.
+	Register(ctx context.Context, mode string, lanes map[string]int) (*entities.Worker, error)
+	// Heartbeat refreshes the worker's row every HeartbeatInterval until ctx
+	// is done.
+	Heartbeat(ctx context.Context, worker *entities.Worker)
+	// Reap releases and requeues the jobs of lapsed workers every
+	// HeartbeatInterval until ctx is done. Only the leader runs it.
+	Reap(ctx context.Context)
+	// Stop marks the worker stopped, so its jobs are released right away.
+	Stop(ctx context.Context, worker *entities.Worker)
+	// List returns the fleet, with lapsed workers reported as lost.
+	List(ctx context.Context) ([]*entities.Worker, error)
+}
+
+type workerService struct {
+	repo      repository.WorkerRepository
+	jobs      repository.JobRepository
+	publisher rabbitmq.Publisher
+	intake    *rabbitmq.Intake
+	store     objectstore.Store
+	cfg       *config.Config
+}
+
+func (s *workerService) Register(ctx context.Context, mode string, lanes map[string]int) (*entities.Worker, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	worker := &entities.Worker{
+		ID:              uuid.New(),
+		Hostname:        hostname,
+		Version:         version.Release(),
+		Mode:            mode,
+		Capabilities:    detectCapabilities(ctx, lanes),
+		Status:          constant.WorkerStatusActive,
+		StartedAt:       time.Now().UTC(),
+		LastHeartbeatAt: time.Now().UTC(),
+	}
+	for _, concurrency := range lanes {
+		worker.Concurrency += concurrency
+	}
+	if err := s.repo.UpsertWorker(ctx, worker); err != nil {
+		return nil, err
+	}
+
+	zerolog.Ctx(ctx).Info().
+		Str("worker_id", worker.ID.String()).
+		Str("hostname", hostname).
+		Bool("gpu", worker.Capabilities.GPU).
+		Strs("encoders", worker.Capabilities.Encoders).
+		Uint64("scratch_mb", worker.Capabilities.ScratchBytes>>20).
+		Int("concurrency", worker.Concurrency).
+		Float64("cpu_limit", s.cfg.Server.Limits.CPUs).
+		Int64("memory_limit", s.cfg.Server.Limits.MemoryBytes).
+		Int("ffmpeg_threads", s.cfg.Server.FFmpegThreads).
+		Msg("worker registered")
+	return worker, nil
+}
+
+func (s *workerService) Heartbeat(ctx context.Context, worker *entities.Worker) {
+	ticker := time.NewTicker(time.Duration(max(s.cfg.Server.HeartbeatInterval, 1)) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := s.beat(ctx, worker); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to record worker heartbeat")
+		}
+	}
+}
+
+func (s *workerService) Reap(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(max(s.cfg.Server.HeartbeatInterval, 1)) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		if err := s.releaseLapsed(ctx); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to release jobs of lapsed workers")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// beat refreshes the row, re-registering when it was pruned while the worker
+// couldn't reach the database.
+func (s *workerService) beat(ctx context.Context, worker *entities.Worker) error {
+	worker.Status = constant.WorkerStatusActive
+	if s.intake.IsDraining() {
+		worker.Status = constant.WorkerStatusDraining
+	}
+	worker.InFlight = 0
+	for _, count := range s.intake.InFlight() {
+		worker.InFlight += count
+	}
+
+	err := s.repo.UpdateWorkerHeartbeat(ctx, worker.ID, worker.Status, worker.InFlight)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		worker.LastHeartbeatAt = time.Now().UTC()
+		return s.repo.UpsertWorker(ctx, worker)
+	}
+	return err
+}
+
+// releaseLapsed moves the jobs of lapsed workers back to pending and queues
+// them again. The messages those workers held may be redelivered as well;
+// whichever delivery claims the job first runs it.
+func (s *workerService) releaseLapsed(ctx context.Context) error {
+	lapsedBefore := time.Now().Add(-time.Duration(s.cfg.Server.HeartbeatTimeout) * time.Second)
+	if err := s.repo.DeleteWorkersBefore(ctx, time.Now().Add(-workerRetention)); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to prune worker registry")
+	}
+
+	jobs, err := s.repo.ReleaseLapsedJobs(ctx, lapsedBefore)
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		logger := zerolog.Ctx(ctx).With().Str("job_id", job.ID.String()).Logger()
+		if err := s.requeue(ctx, job); err != nil {
+			logger.Error().Err(err).Msg("failed to requeue job released from lapsed worker")
+			if failErr := s.jobs.FailJob(ctx, job.ID, constant.ErrorClassDownload, errorKind(err), err.Error()); failErr != nil {
+				logger.Error().Err(failErr).Msg("failed to update job status")
+			}
+			continue
+		}
+		logger.Warn().Msg("job released from lapsed worker and requeued")
+	}
+	return nil
+}
+
+// requeue publishes a released job on the lane it came from. Jobs don't keep
+// their original message, so a transcode restarts from the lesson's newest
+// upload with the default preset.
+func (s *workerService) requeue(ctx context.Context, job *entities.Job) error {
+	if job.JobType == constant.JobTypeRecordingMerge {
+		message := dto.RecordingMergeMessage{JobId: job.ID, LiveSessionId: job.EntityId}
+		return s.publisher.Publish(ctx, rabbitmq.RecordingMergeTopology.Exchange, rabbitmq.RecordingMergeTopology.RoutingKey, message)
+	}
+	if job.JobType == constant.JobTypeWatermark {
+		message := dto.WatermarkMessage{JobId: job.ID}
+		return s.publisher.Publish(ctx, rabbitmq.WatermarkTopology.Exchange, rabbitmq.WatermarkTopology.RoutingKey, message)
+	}
+	if job.JobType == constant.JobTypeTranslation {
+		message := dto.TranslationMessage{JobId: job.ID}
+		return s.publisher.Publish(ctx, rabbitmq.TranslationTopology.Exchange, rabbitmq.TranslationTopology.RoutingKey, message)
+	}
+	if job.JobType == constant.JobTypeExport {
+		message := dto.ExportMessage{JobId: job.ID}
+		return s.publisher.Publish(ctx, rabbitmq.ExportTopology.Exchange, rabbitmq.ExportTopology.RoutingKey, message)
+	}
+	if job.JobType == constant.JobTypeNarration {
+		message := dto.NarrationMessage{JobId: job.ID}
+		return s.publisher.Publish(ctx, rabbitmq.NarrationTopology.Exchange, rabbitmq.NarrationTopology.RoutingKey, message)
+	}
+	if job.JobType == constant.JobTypeLiveImport {
+		message := dto.LiveImportMessage{JobId: job.ID}
+		return s.publisher.Publish(ctx, rabbitmq.LiveImportTopology.Exchange, rabbitmq.LiveImportTopology.RoutingKey, message)
+	}
+	if job.JobType == constant.JobTypePodcast {
+		message := dto.PodcastMessage{JobId: job.ID}
+		return s.publisher.Publish(ctx, rabbitmq.PodcastTopology.Exchange, rabbitmq.PodcastTopology.RoutingKey, message)
+	}
+	if job.JobType == constant.JobTypeAudioReplace {
+		message := dto.AudioReplacementMessage{JobId: job.ID}
+		return s.publisher.Publish(ctx, rabbitmq.AudioReplacementTopology.Exchange, rabbitmq.AudioReplacementTopology.RoutingKey, message)
+	}
+	if job.JobType == constant.JobTypeRegeneration {
+		message := dto.RegenerationMessage{JobId: job.ID}
+		return s.publisher.Publish(ctx, rabbitmq.RegenerationTopology.Exchange, rabbitmq.RegenerationTopology.RoutingKey, message)
+	}
+	if job.JobType == constant.JobTypeDeletion {
+		message := dto.DeletionMessage{JobId: job.ID}
+		return s.publisher.Publish(ctx, rabbitmq.DeletionTopology.Exchange, rabbitmq.DeletionTopology.RoutingKey, message)
+	}
+	if job.JobType == constant.JobTypeBurnIn {
+		message := dto.BurnInMessage{JobId: job.ID}
+		return s.publisher.Publish(ctx, rabbitmq.BurnInTopology.Exchange, rabbitmq.BurnInTopology.RoutingKey, message)
+	}
+	if job.JobType == constant.JobTypeKeyRotation {
+		message := dto.KeyRotationMessage{JobId: job.ID}
+		return s.publisher.Publish(ctx, rabbitmq.KeyRotationTopology.Exchange, rabbitmq.KeyRotationTopology.RoutingKey, message)
+	}
+	if job.JobType == constant.JobTypeMedia {
+		message := dto.MediaMessage{JobId: job.ID}
+		return s.publisher.Publish(ctx, rabbitmq.MediaTopology.Exchange, rabbitmq.MediaTopology.RoutingKey, message)
+	}
+
+	source, err := latestUpload(ctx, s.store, s.cfg, job.EntityId)
+	if err != nil {
+		return err
+	}
+	if source == "" {
+		return fmt.Errorf("no source object left for lesson %s", job.EntityId)
+	}
+
+	topology := shardTopology(s.cfg, jobSLAClass(job.SLAClass), job.SourceSeconds)
+	switch {
+	case job.BackfillBatchId != nil:
+		topology = rabbitmq.BackfillTranscodeTopology
+	case job.Priority > 0:
+		topology = rabbitmq.PriorityTranscodeTopology
+	}
+	message := dto.JobMessage{
+		JobId:      job.ID,
+		ObjectPath: source,
+		FileName:   path.Base(source),
+		SLAClass:   string(jobSLAClass(job.SLAClass)),
+	}
+	return s.publisher.Publish(ctx, topology.Exchange, topology.RoutingKey, message)
+}
+
+func (s *workerService) Stop(ctx context.Context, worker *entities.Worker) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := s.repo.UpdateWorkerHeartbeat(ctx, worker.ID, constant.WorkerStatusStopped, 0); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to record worker stop")
+	}
+}
+
+func (s *workerService) List(ctx context.Context) ([]*entities.Worker, error) {
+	workers, err := s.repo.ListWorkers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lapsedBefore := time.Now().Add(-time.Duration(s.cfg.Server.HeartbeatTimeout) * time.Second)
+	for _, worker := range workers {
+		if worker.Status != constant.WorkerStatusStopped && worker.LastHeartbeatAt.Before(lapsedBefore) {
+			worker.Status = constant.WorkerStatusLost
+		}
+	}
+	return workers, nil
+}
+
+// detectCapabilities lists the video encoders this ffmpeg build has. A
+// missing ffmpeg leaves the list empty rather than failing registration.
+func detectCapabilities(ctx context.Context, lanes map[string]int) entities.WorkerCapabilities {
+	capabilities := entities.WorkerCapabilities{Lanes: lanes}
+	// Jobs work under temp, which the first one would otherwise make.
+	if err := os.MkdirAll("temp", os.ModePerm); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to make scratch directory")
+	}
+	if free, ok := scratch.available("temp"); ok {
+		capabilities.ScratchBytes = free
+	}
+
+	encoders, err := ffmpegEncoders(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to list ffmpeg encoders")
+		return capabilities
+	}
+	for _, encoder := range encoders {
+		if !slices.Contains(videoEncoders, encoder) {
+			continue
+		}
+		capabilities.Encoders = append(capabilities.Encoders, encoder)
+		if strings.HasSuffix(encoder, "_nvenc") {
+			capabilities.GPU = true
+		}
+	}
+	return capabilities
+}
+
+func NewWorkerService(repo repository.WorkerRepository, jobs repository.JobRepository, publisher rabbitmq.Publisher, intake *rabbitmq.Intake, store objectstore.Store, cfg *config.Config) WorkerService {
+	return &workerService{
+		repo:      repo,
+		jobs:      jobs,
+		publisher: publisher,
+		intake:    intake,
+		store:     store,
+		cfg:       cfg,
+	}
+}
@@ -0,0 +1,349 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"worker-transcode/config"
+	"worker-transcode/constant"
+	"worker-transcode/dto"
+	"worker-transcode/entities"
+	"worker-transcode/pkg/objectstore"
+	"worker-transcode/pkg/rabbitmq"
+	"worker-transcode/pkg/search"
+	"worker-transcode/pkg/zoom"
+	"worker-transcode/repository"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// zoomLookback is how far back a connection's first poll lists recordings,
+// and how far before its last poll the next one starts: a recording is
+// listed by when its meeting started, and completes some time after.
+const zoomLookback = 24 * time.Hour
+
+// zoomViews are the recording types an MP4 is picked by, best first: the
+// shared screen with the speaker in it makes the most of a lesson.
+var zoomViews = []string{
+	"shared_screen_with_speaker_view",
+	"shared_screen_with_speaker_view(CC)",
+	"shared_screen_with_gallery_view",
+	"shared_screen",
+	"speaker_view",
+	"active_speaker",
+	"gallery_view",
+}
+
+// ZoomService imports the cloud recordings of tenants' Zoom meetings into
+// the lessons the meetings are linked to: the video is transcoded and Zoom's
+// audio transcript, if the account makes one, becomes the lesson's captions.
+type ZoomService interface {
+	// Run lists the recordings of every connected account each poll
+	// interval until ctx is done. It runs on the leader only.
+	Run(ctx context.Context)
+	// Webhook handles an event the tenant's Zoom app sent, returning the
+	// body to answer with. Recordings completed are imported in the
+	// background, as Zoom wants an answer within seconds.
+	Webhook(ctx context.Context, tenantId uuid.UUID, timestamp, signature string, body []byte) (interface{}, error)
+	Connect(ctx context.Context, tenantId uuid.UUID, request dto.ZoomConnectionRequest) (*entities.ZoomConnection, error)
+	LinkMeeting(ctx context.Context, tenantId uuid.UUID, request dto.ZoomMeetingRequest) (*entities.ZoomMeeting, error)
+}
+
+type zoomService struct {
+	repo        repository.ZoomRepository
+	jobs        repository.JobRepository
+	presets     PresetService
+	transcripts TranscriptService
+	publisher   rabbitmq.Publisher
+	store       objectstore.Store
+	cfg         *config.Config
+}
+
+func (s *zoomService) Run(ctx context.Context) {
+	if s.cfg.Zoom.PollInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(s.cfg.Zoom.PollInterval) * time.Minute)
+	defer ticker.Stop()
+	for {
+		s.poll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *zoomService) poll(ctx context.Context) {
+	connections, err := s.repo.ListZoomConnections(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to list zoom connections")
+		return
+	}
+	for _, connection := range connections {
+		ctx := zerolog.Ctx(ctx).With().Str("tenant_id", connection.TenantId.String()).Logger().WithContext(ctx)
+		polled := time.Now()
+		from := polled.Add(-zoomLookback)
+		if connection.PolledAt != nil {
+			from = connection.PolledAt.Add(-zoomLookback)
+		}
+		client := zoomClient(connection)
+		recordings, err := client.Recordings(ctx, from)
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to list zoom recordings")
+			continue
+		}
+		for _, recording := range recordings {
+			s.importRecording(ctx, connection, client, recording, "")
+		}
+		if err := s.repo.MarkZoomPolled(ctx, connection.TenantId, polled); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to record zoom poll")
+		}
+	}
+}
+
+func (s *zoomService) Webhook(ctx context.Context, tenantId uuid.UUID, timestamp, signature string, body []byte) (interface{}, error) {
+	connection, err := s.repo.FindZoomConnection(ctx, tenantId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.Join(ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := zoom.VerifyWebhook(connection.WebhookSecret, timestamp, signature, body); err != nil {
+		return nil, errors.Join(ErrInvalidArgument, err)
+	}
+	var event zoom.Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, errors.Join(ErrInvalidArgument, err)
+	}
+
+	switch event.Event {
+	case "endpoint.url_validation":
+		return zoom.ValidationResponse(connection.WebhookSecret, event.Payload.PlainToken), nil
+	case "recording.completed":
+		ctx := zerolog.Ctx(ctx).With().Str("tenant_id", tenantId.String()).Logger().WithContext(context.WithoutCancel(ctx))
+		go s.importRecording(ctx, connection, zoomClient(connection), event.Payload.Object, event.DownloadToken)
+	}
+	return map[string]string{"status": "ok"}, nil
+}
+
+// importRecording moves the recording's video into its meeting's lesson and
+// queues its transcode, then indexes its transcript. Meetings not linked to
+// a lesson, and files imported before, are left alone.
+func (s *zoomService) importRecording(ctx context.Context, connection *entities.ZoomConnection, client *zoom.Client, recording zoom.Recording, downloadToken string) {
+	ctx = zerolog.Ctx(ctx).With().Int64("meeting_id", recording.Id).Str("meeting_uuid", recording.UUID).Logger().WithContext(ctx)
+	meeting, err := s.repo.FindZoomMeeting(ctx, connection.TenantId, recording.Id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		zerolog.Ctx(ctx).Debug().Msg("zoom meeting isn't linked to a lesson")
+		return
+	}
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to find zoom meeting")
+		return
+	}
+	videos := pickZoomVideos(recording.RecordingFiles)
+	if len(videos) == 0 {
+		zerolog.Ctx(ctx).Debug().Msg("zoom recording has no completed video")
+		return
+	}
+	video := videos[0]
+
+	claim := &entities.ZoomImport{FileId: video.Id, MeetingUUID: recording.UUID, LessonId: meeting.LessonId, JobId: uuid.New()}
+	claimed, err := s.repo.ClaimZoomImport(ctx, claim)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to claim zoom recording")
+		return
+	}
+	if !claimed {
+		return
+	}
+	ctx = zerolog.Ctx(ctx).With().Str("lesson_id", meeting.LessonId.String()).Str("job_id", claim.JobId.String()).Logger().WithContext(ctx)
+
+	fileName := fmt.Sprintf("zoom-%d-%s.mp4", recording.Id, recording.StartTime.UTC().Format("20060102T150405Z"))
+	objectPath := fmt.Sprintf("lessons/%s/videos/%d-%s", meeting.LessonId, time.Now().UnixMilli(), fileName)
+	// A recording in parts is stored part by part, for its transcode to
+	// join into objectPath.
+	var parts []string
+	if len(videos) > 1 {
+		for i := range videos {
+			parts = append(parts, fmt.Sprintf("lessons/%s/parts/%s/%03d.mp4", meeting.LessonId, claim.JobId, i))
+		}
+	}
+	for i, file := range videos {
+		key := objectPath
+		if parts != nil {
+			key = parts[i]
+		}
+		if err := s.save(ctx, client, file, downloadToken, key); err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to store zoom recording")
+			// Another poll or a redelivered webhook tries again.
+			if releaseErr := s.repo.ReleaseZoomImport(ctx, video.Id); releaseErr != nil {
+				zerolog.Ctx(ctx).Error().Err(releaseErr).Msg("failed to release zoom recording")
+			}
+			return
+		}
+	}
+	_, err = queueTranscode(ctx, s.jobs, s.publisher, s.store, s.cfg, claim.JobId, meeting.LessonId, constant.ParseSLAClass(s.cfg.Zoom.SLAClass), dto.JobMessage{
+		ObjectPath:      objectPath,
+		FileName:        fileName,
+		Preset:          connection.Preset,
+		ScreenRecording: strings.HasPrefix(video.RecordingType, "shared_screen"),
+		Parts:           parts,
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Str("object_path", objectPath).Msg("failed to queue zoom recording")
+		return
+	}
+	zerolog.Ctx(ctx).Info().Str("object_path", objectPath).Str("recording_type", video.RecordingType).Int("parts", len(videos)).Msg("zoom recording queued for transcoding")
+
+	// The lesson still gets its video without Zoom's transcript.
+	for _, file := range recording.RecordingFiles {
+		if file.FileType != "TRANSCRIPT" || file.Status != "completed" {
+			continue
+		}
+		if err := s.indexTranscript(ctx, client, file, downloadToken, meeting.LessonId); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to index zoom transcript")
+		}
+		break
+	}
+}
+
+func (s *zoomService) save(ctx context.Context, client *zoom.Client, file zoom.File, downloadToken, objectPath string) error {
+	body, err := client.Download(ctx, file, downloadToken)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	size := file.FileSize
+	if size <= 0 {
+		size = -1
+	}
+	_, err = s.store.PutObject(ctx, s.cfg.MinIOBucket, objectPath, body, size, minio.PutObjectOptions{ContentType: "video/mp4"})
+	return err
+}
+
+func (s *zoomService) indexTranscript(ctx context.Context, client *zoom.Client, file zoom.File, downloadToken string, lessonId uuid.UUID) error {
+	body, err := client.Download(ctx, file, downloadToken)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	cues, err := search.ParseWebVTT(body)
+	if err != nil {
+		return err
+	}
+	return s.transcripts.Index(ctx, lessonId, s.cfg.Zoom.CaptionLanguage, cues)
+}
+
+// pickZoomVideos returns the completed MP4s of the best view, one per part
+// of the recording in the order they were recorded, none when there is no
+// video.
+func pickZoomVideos(files []zoom.File) []zoom.File {
+	var best *zoom.File
+	rank := func(file *zoom.File) int {
+		if i := slices.Index(zoomViews, file.RecordingType); i >= 0 {
+			return i
+		}
+		return len(zoomViews)
+	}
+	for i := range files {
+		file := &files[i]
+		if file.FileType != "MP4" || file.Status != "completed" {
+			continue
+		}
+		if best == nil || rank(file) < rank(best) {
+			best = file
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	var videos []zoom.File
+	for _, file := range files {
+		if file.FileType == "MP4" && file.Status == "completed" && file.RecordingType == best.RecordingType {
+			videos = append(videos, file)
+		}
+	}
+	slices.SortStableFunc(videos, func(a, b zoom.File) int { return a.RecordingStart.Compare(b.RecordingStart) })
+	return videos
+}
+
+func (s *zoomService) Connect(ctx context.Context, tenantId uuid.UUID, request dto.ZoomConnectionRequest) (*entities.ZoomConnection, error) {
+	connection := &entities.ZoomConnection{
+		TenantId:      tenantId,
+		AccountId:     strings.TrimSpace(request.AccountId),
+		ClientId:      strings.TrimSpace(request.ClientId),
+		ClientSecret:  strings.TrimSpace(request.ClientSecret),
+		WebhookSecret: strings.TrimSpace(request.WebhookSecret),
+		Preset:        request.Preset,
+	}
+	for name, value := range map[string]string{
+		"account_id":     connection.AccountId,
+		"client_id":      connection.ClientId,
+		"client_secret":  connection.ClientSecret,
+		"webhook_secret": connection.WebhookSecret,
+	} {
+		if value == "" {
+			return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("%s must not be empty", name))
+		}
+	}
+	if connection.Preset == "" {
+		connection.Preset = DefaultPresetName
+	}
+	if _, err := s.presets.Resolve(ctx, connection.Preset); err != nil {
+		return nil, errors.Join(ErrInvalidArgument, fmt.Errorf("preset %s: %w", connection.Preset, err))
+	}
+	if err := s.repo.SaveZoomConnection(ctx, connection); err != nil {
+		return nil, err
+	}
+	return s.repo.FindZoomConnection(ctx, tenantId)
+}
+
+func (s *zoomService) LinkMeeting(ctx context.Context, tenantId uuid.UUID, request dto.ZoomMeetingRequest) (*entities.ZoomMeeting, error) {
+	if request.MeetingId <= 0 {
+		return nil, errors.Join(ErrInvalidArgument, errors.New("meeting_id must be a zoom meeting id"))
+	}
+	if request.LessonId == uuid.Nil {
+		return nil, errors.Join(ErrInvalidArgument, errors.New("lesson_id is required"))
+	}
+	if _, err := s.repo.FindZoomConnection(ctx, tenantId); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.Join(ErrNotFound, errors.New("tenant has no zoom connection"))
+		}
+		return nil, err
+	}
+	meeting := &entities.ZoomMeeting{MeetingId: request.MeetingId, TenantId: tenantId, LessonId: request.LessonId}
+	if err := s.repo.SaveZoomMeeting(ctx, meeting); err != nil {
+		return nil, err
+	}
+	return s.repo.FindZoomMeeting(ctx, tenantId, request.MeetingId)
+}
+
+func zoomClient(connection *entities.ZoomConnection) *zoom.Client {
+	return zoom.NewClient(zoom.Credentials{
+		AccountId:    connection.AccountId,
+		ClientId:     connection.ClientId,
+		ClientSecret: connection.ClientSecret,
+	})
+}
+
+func NewZoomService(repo repository.ZoomRepository, jobs repository.JobRepository, presets PresetService, transcripts TranscriptService, publisher rabbitmq.Publisher, store objectstore.Store, cfg *config.Config) ZoomService {
+	return &zoomService{
+		repo:        repo,
+		jobs:        jobs,
+		presets:     presets,
+		transcripts: transcripts,
+		publisher:   publisher,
+		store:       store,
+		cfg:         cfg,
+	}
+}